@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/spf13/cobra"
+)
+
+var (
+	accessUntil    string
+	accessAdminURL string
+)
+
+var accessCmd = &cobra.Command{
+	Use:   "access",
+	Short: "Answer access requests from the block page",
+	Long: `List, approve and deny the access requests made from the block page of a
+running KProxy (admin.enabled must be set). Approving a request adds a
+runtime rule allowing the requested host for the device's profile until it
+expires; see "kproxy rule". Unanswered requests are dropped after a day.`,
+}
+
+var accessListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List access requests waiting for an answer",
+	Args:    cobra.NoArgs,
+	RunE:    runAccessList,
+}
+
+var accessApproveCmd = &cobra.Command{
+	Use:   "approve <id>",
+	Short: "Allow the requested host for a while",
+	Example: `  kproxy access approve access-3
+  kproxy access approve access-3 --until 21:00`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAccessApprove,
+}
+
+var accessDenyCmd = &cobra.Command{
+	Use:     "deny <id>",
+	Short:   "Deny an access request",
+	Example: `  kproxy access deny access-3`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runAccessDeny,
+}
+
+func init() {
+	accessApproveCmd.Flags().StringVar(&accessUntil, "until", "", "When access ends: a time of day (21:00), a duration (2h) or an RFC 3339 time (default: 1h)")
+	accessCmd.PersistentFlags().StringVar(&accessAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	accessCmd.AddCommand(accessListCmd)
+	accessCmd.AddCommand(accessApproveCmd)
+	accessCmd.AddCommand(accessDenyCmd)
+	rootCmd.AddCommand(accessCmd)
+}
+
+func runAccessList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(accessAdminURL)
+	if err != nil {
+		return err
+	}
+
+	list, err := api.AccessRequests(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-12s %-16s %-12s %-30s %-17s %s\n", "ID", "CLIENT", "PROFILE", "HOST", "REQUESTED", "REASON")
+	for _, req := range list {
+		fmt.Printf("%-12s %-16s %-12s %-30s %-17s %s\n", req.ID, req.Client, req.Profile, req.Host, req.RequestedAt.Local().Format("2006-01-02 15:04"), req.Reason)
+	}
+	if len(list) == 0 {
+		fmt.Println("(no access requests waiting)")
+	}
+	return nil
+}
+
+func runAccessApprove(cmd *cobra.Command, args []string) error {
+	var req admin.AccessApproveRequest
+	if accessUntil != "" {
+		until, err := parseUntil(accessUntil, time.Now())
+		if err != nil {
+			return err
+		}
+		req.Until = &until
+	}
+
+	api, err := newAdminClient(accessAdminURL)
+	if err != nil {
+		return err
+	}
+
+	rule, err := api.ApproveAccessRequest(cmd.Context(), args[0], req)
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Approved ")
+	fmt.Printf("%s: %s %s for profile %s", args[0], rule.Action, rule.Domain, rule.Profile)
+	if rule.Until != nil {
+		fmt.Printf(" until %s", rule.Until.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Printf(" (%s)\n", rule.ID)
+	return nil
+}
+
+func runAccessDeny(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(accessAdminURL)
+	if err != nil {
+		return err
+	}
+
+	if err := api.DenyAccessRequest(cmd.Context(), args[0]); err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Denied ")
+	fmt.Println(args[0])
+	return nil
+}
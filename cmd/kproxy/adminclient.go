@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/pkg/client"
+)
+
+// newAdminClient creates a client for the admin API configured in the
+// config file, or at baseURL if given
+func newAdminClient(baseURL string) (*client.Client, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.Admin.TLS {
+		tlsConfig = adminTLSConfig(cfg)
+	}
+	if baseURL == "" {
+		if !cfg.Admin.Enabled {
+			return nil, fmt.Errorf("the admin API is not enabled (admin.enabled)")
+		}
+		// A wildcard bind address is reachable on loopback
+		host := cfg.Server.BindAddress
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+		scheme := "http"
+		if tlsConfig != nil {
+			// The certificate is for server.name, not the listen address
+			scheme = "https"
+			tlsConfig.ServerName = cfg.Server.Name
+		}
+		baseURL = scheme + "://" + net.JoinHostPort(host, strconv.Itoa(cfg.Admin.Port))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return client.New(baseURL, cfg.Admin.Token, &http.Client{Timeout: client.DefaultTimeout, Transport: transport}), nil
+}
+
+// adminTLSConfig trusts the system roots, for a Let's Encrypt certificate,
+// and the KProxy root CA, which signs the admin certificate otherwise
+func adminTLSConfig(cfg *config.Config) *tls.Config {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if rootPEM, err := os.ReadFile(cfg.TLS.CACert); err == nil {
+		roots.AppendCertsFromPEM(rootPEM)
+	}
+	return &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+}
+
+// printHeld reports a change the admin API holds until a second admin
+// account approves it, returning whether err was one
+func printHeld(err error) bool {
+	var held *client.HeldError
+	if !errors.As(err, &held) {
+		return false
+	}
+	_, _ = color.New(color.FgYellow, color.Bold).Print("Pending ")
+	fmt.Printf("%s (kproxy approval approve %s)\n", held, held.Change.ID)
+	return true
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/desired"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyFile   string
+	applyDryRun bool
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply devices, users, profiles, rule sets, subnets and bypass domains from YAML",
+	Long: `Apply a declarative configuration kept in YAML files (for example in git)
+to config.rego in the policy directory. The files define any of devices,
+users, profiles, rule_sets, subnet_profiles and bypass_domains, in the same
+shape as config.rego; each section that appears is made to match exactly,
+adding, updating and deleting entries, and sections that don't appear are
+left alone.
+
+kproxy apply prints the plan before writing. With --dry-run it stops there.
+Reload KProxy afterwards (systemctl reload kproxy, or SIGHUP) to use the
+new configuration.`,
+	Example: `  kproxy apply -f /etc/kproxy/desired/ --dry-run
+  kproxy apply -f /etc/kproxy/desired/`,
+	Args: cobra.NoArgs,
+	RunE: runApply,
+}
+
+func init() {
+	applyCmd.Flags().StringVarP(&applyFile, "file", "f", "", "YAML file, or directory of .yaml/.yml files")
+	applyCmd.Flags().BoolVar(&applyDryRun, "dry-run", false, "Show the plan without writing config.rego")
+	_ = applyCmd.MarkFlagRequired("file")
+
+	rootCmd.AddCommand(applyCmd)
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if !policyDirSource(cfg) {
+		return fmt.Errorf("kproxy apply writes config.rego in the policy directory; policy.opa_policy_source is %s", cfg.Policy.OPAPolicySource)
+	}
+
+	configRego := filepath.Join(cfg.Policy.OPAPolicyDir, "config.rego")
+	src, err := os.ReadFile(configRego)
+	if err != nil {
+		return err
+	}
+	current, err := desired.Parse(configRego, src)
+	if err != nil {
+		return err
+	}
+
+	want, err := desired.Load(applyFile)
+	if err != nil {
+		return err
+	}
+	merged := desired.Merge(current, want)
+	if err := desired.Validate(merged); err != nil {
+		return err
+	}
+
+	changes := desired.Diff(current, merged)
+	printPlan(changes)
+	if len(changes) == 0 || applyDryRun {
+		return nil
+	}
+
+	out, err := desired.Render(merged, applyFile)
+	if err != nil {
+		return fmt.Errorf("failed to render config.rego: %w", err)
+	}
+	if err := checkPolicies(cfg.Policy.OPAPolicyDir, out); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(configRego, out); err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Applied ")
+	fmt.Printf("%d change(s) to %s\n", len(changes), configRego)
+	fmt.Println("Reload KProxy to use them: systemctl reload kproxy (or send SIGHUP)")
+	return nil
+}
+
+// printPlan shows the changes by section, like a diff
+func printPlan(changes []desired.Change) {
+	if len(changes) == 0 {
+		fmt.Println("No changes; config.rego matches.")
+		return
+	}
+
+	green := color.New(color.FgGreen)
+	yellow := color.New(color.FgYellow)
+	red := color.New(color.FgRed)
+	cyan := color.New(color.FgCyan, color.Bold)
+
+	counts := make(map[string]int)
+	section := ""
+	for _, change := range changes {
+		if change.Section != section {
+			section = change.Section
+			_, _ = cyan.Printf("%s:\n", section)
+		}
+		switch change.Op {
+		case desired.OpAdd:
+			_, _ = green.Printf("  + %s\n", change.Key)
+		case desired.OpUpdate:
+			_, _ = yellow.Printf("  ~ %s\n", change.Key)
+		case desired.OpDelete:
+			_, _ = red.Printf("  - %s\n", change.Key)
+		}
+		counts[change.Op]++
+	}
+	fmt.Printf("\nPlan: %d to add, %d to change, %d to delete.\n", counts[desired.OpAdd], counts[desired.OpUpdate], counts[desired.OpDelete])
+}
+
+// checkPolicies compiles the policies with a new config.rego in a scratch
+// directory, so a bad apply never reaches the policy directory
+func checkPolicies(policyDir string, configRego []byte) error {
+	dir, err := os.MkdirTemp("", "kproxy-apply-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	files, err := filepath.Glob(filepath.Join(policyDir, "*.rego"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if filepath.Base(file) == "config.rego" || strings.HasSuffix(file, "_test.rego") {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(file)), content, 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.rego"), configRego, 0644); err != nil {
+		return err
+	}
+
+	_, err = opa.NewEngine(opa.Config{Source: "filesystem", PolicyDir: dir, HTTPTimeout: 30 * time.Second}, zerolog.Nop())
+	if err != nil {
+		return fmt.Errorf("policies don't compile with the new config.rego: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file, keeping its permissions
+func writeFileAtomic(path string, content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config.rego-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	approvalToken    string
+	approvalAdminURL string
+)
+
+var approvalCmd = &cobra.Command{
+	Use:   "approval",
+	Short: "Approve or reject changes held for a second admin",
+	Long: `List, approve and reject destructive changes on a running KProxy that
+wait for a second admin account (admin.approval.enabled must be set).
+Removing a runtime device or runtime rule is held until an account other
+than the one that asked for it approves; unapproved changes expire after
+admin.approval.window.
+
+Commands authenticate with admin.token from the config file; pass the token
+of one of admin.accounts with --token to act as that account.`,
+}
+
+var approvalListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List changes waiting for approval",
+	Args:    cobra.NoArgs,
+	RunE:    runApprovalList,
+}
+
+var approvalApproveCmd = &cobra.Command{
+	Use:     "approve <id>",
+	Short:   "Approve and apply a change",
+	Example: `  kproxy approval approve 3f9c2a1b7d4e6f80 --token "$PARENT_TOKEN"`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runApprovalApprove,
+}
+
+var approvalRejectCmd = &cobra.Command{
+	Use:     "reject <id>",
+	Short:   "Reject a change without applying it",
+	Example: `  kproxy approval reject 3f9c2a1b7d4e6f80`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runApprovalReject,
+}
+
+func init() {
+	approvalCmd.PersistentFlags().StringVar(&approvalToken, "token", "", "Bearer token of the admin account to act as (default: admin.token)")
+	approvalCmd.PersistentFlags().StringVar(&approvalAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	approvalCmd.AddCommand(approvalListCmd)
+	approvalCmd.AddCommand(approvalApproveCmd)
+	approvalCmd.AddCommand(approvalRejectCmd)
+	rootCmd.AddCommand(approvalCmd)
+}
+
+// newApprovalClient creates an admin client acting as the --token account
+func newApprovalClient() (*client.Client, error) {
+	api, err := newAdminClient(approvalAdminURL)
+	if err != nil {
+		return nil, err
+	}
+	if approvalToken != "" {
+		api = api.WithToken(approvalToken)
+	}
+	return api, nil
+}
+
+func runApprovalList(cmd *cobra.Command, args []string) error {
+	api, err := newApprovalClient()
+	if err != nil {
+		return err
+	}
+
+	list, err := api.Approvals(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-18s %-16s %-20s %-12s %s\n", "ID", "ACTION", "TARGET", "REQUESTED BY", "EXPIRES")
+	for _, change := range list {
+		fmt.Printf("%-18s %-16s %-20s %-12s %s\n", change.ID, change.Action, change.Target, change.RequestedBy, change.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	}
+	if len(list) == 0 {
+		fmt.Println("(no changes waiting for approval)")
+	}
+	return nil
+}
+
+func runApprovalApprove(cmd *cobra.Command, args []string) error {
+	api, err := newApprovalClient()
+	if err != nil {
+		return err
+	}
+
+	if err := api.Approve(cmd.Context(), args[0]); err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Approved ")
+	fmt.Println(args[0])
+	return nil
+}
+
+func runApprovalReject(cmd *cobra.Command, args []string) error {
+	api, err := newApprovalClient()
+	if err != nil {
+		return err
+	}
+
+	if err := api.Reject(cmd.Context(), args[0]); err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Rejected ")
+	fmt.Println(args[0])
+	return nil
+}
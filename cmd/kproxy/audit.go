@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditAdminURL string
+	auditAccount  string
+	auditPath     string
+	auditSince    string
+	auditLimit    int
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Show changes made through the admin API",
+	Long: `List the changes made through the admin API of a running KProxy
+(admin.enabled and admin.audit.enabled must be set), newest first: when,
+which account, and the request. Failed requests and reads aren't recorded;
+entries are kept for admin.audit.retention.
+
+Use "kproxy audit show" with an entry ID to see the changed fields, for
+example to find out who changed a rule when something stopped working.`,
+	Example: `  kproxy audit --since 24h
+  kproxy audit --path /api/rules/kids-tiktok
+  kproxy audit show 3f9c2a1b7d4e6f80`,
+	Args: cobra.NoArgs,
+	RunE: runAuditList,
+}
+
+var auditShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show one change with the fields it changed",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runAuditShow,
+}
+
+func init() {
+	auditCmd.Flags().StringVar(&auditAccount, "account", "", "Only changes by this admin account")
+	auditCmd.Flags().StringVar(&auditPath, "path", "", "Only changes to API paths containing this")
+	auditCmd.Flags().StringVar(&auditSince, "since", "", "Only changes within this long, e.g. 24h")
+	auditCmd.Flags().IntVar(&auditLimit, "limit", 50, "Maximum number of changes to list")
+	auditCmd.PersistentFlags().StringVar(&auditAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	auditCmd.AddCommand(auditShowCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+func runAuditList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(auditAdminURL)
+	if err != nil {
+		return err
+	}
+
+	query := client.AuditQuery{Account: auditAccount, Path: auditPath, Limit: auditLimit}
+	if auditSince != "" {
+		since, err := time.ParseDuration(auditSince)
+		if err != nil || since <= 0 {
+			return fmt.Errorf("invalid since: %s", auditSince)
+		}
+		query.Since = since
+	}
+
+	entries, err := api.AuditLog(cmd.Context(), query)
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-18s %-17s %-12s %-7s %-40s %s\n", "ID", "TIME", "ACCOUNT", "METHOD", "PATH", "CHANGES")
+	for _, entry := range entries {
+		fmt.Printf("%-18s %-17s %-12s %-7s %-40s %d\n", entry.ID, entry.Time.Local().Format("2006-01-02 15:04"), entry.Account, entry.Method, entry.Path, len(entry.Changes))
+	}
+	if len(entries) == 0 {
+		fmt.Println("(no changes recorded)")
+	}
+	return nil
+}
+
+func runAuditShow(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(auditAdminURL)
+	if err != nil {
+		return err
+	}
+
+	entry, err := api.AuditEntry(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%s %s\n", entry.Method, entry.Path)
+	fmt.Printf("  Time:    %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Account: %s\n", entry.Account)
+	if entry.Client != "" {
+		fmt.Printf("  Client:  %s\n", entry.Client)
+	}
+	fmt.Printf("  Status:  %d\n", entry.Status)
+	if len(entry.Request) > 0 {
+		fmt.Printf("  Request: %s\n", entry.Request)
+	}
+
+	if len(entry.Changes) == 0 {
+		fmt.Println("\n(no field changes recorded)")
+		return nil
+	}
+	_, _ = cyan.Println("\nChanges:")
+	red := color.New(color.FgRed)
+	green := color.New(color.FgGreen)
+	for _, change := range entry.Changes {
+		fmt.Printf("  %s\n", change.Field)
+		if len(change.Before) > 0 {
+			_, _ = red.Printf("    - %s\n", change.Before)
+		}
+		if len(change.After) > 0 {
+			_, _ = green.Printf("    + %s\n", change.After)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+var (
+	backupAdminURL string
+	backupOutput   string
+	backupCA       bool
+	backupDryRun   bool
+	backupYes      bool
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up storage, policies and optionally the CA",
+	Long: `Download a backup of a running KProxy through its admin API (admin.enabled
+must be set) as one archive: the storage records that make up configuration
+(device rules, rule sets, pauses, API tokens, DHCP leases and reservations,
+block pages, today's usage) and the policy files. With --ca the CA
+certificates and keys are included too; keep such a backup private.
+Restore it with kproxy restore.`,
+	Example: `  kproxy backup
+  kproxy backup --ca -o /mnt/usb/kproxy.tar.gz
+  kproxy backup -o - | ssh nas 'cat > kproxy.tar.gz'`,
+	Args: cobra.NoArgs,
+	RunE: runBackup,
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Restore a backup",
+	Long: `Restore a backup written by kproxy backup or a scheduled backup. The policy
+files become those of the backup (compiled before anything is changed), and
+the kinds of storage record it covers are replaced by those in it. The
+CA certificates and keys are only restored with --ca, and are used after
+KProxy restarts. Backups written by older versions of KProxy are migrated.
+
+The changes are shown and confirmed before they are made.`,
+	Example: `  kproxy restore kproxy-backup-20261015-030000.tar.gz --dry-run
+  kproxy restore kproxy-backup-20261015-030000.tar.gz --ca --yes`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRestore,
+}
+
+func init() {
+	backupCmd.Flags().StringVarP(&backupOutput, "output", "o", "", "File to write, - for stdout (default: kproxy-backup-<time>.tar.gz)")
+	backupCmd.Flags().BoolVar(&backupCA, "ca", false, "Include the CA certificates and keys")
+	backupCmd.Flags().StringVar(&backupAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+	restoreCmd.Flags().BoolVar(&backupCA, "ca", false, "Restore the CA certificates and keys too")
+	restoreCmd.Flags().BoolVar(&backupDryRun, "dry-run", false, "Show what would change without changing it")
+	restoreCmd.Flags().BoolVarP(&backupYes, "yes", "y", false, "Restore without asking for confirmation")
+	restoreCmd.Flags().StringVar(&backupAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func runBackup(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(backupAdminURL)
+	if err != nil {
+		return err
+	}
+
+	archive, err := api.Backup(cmd.Context(), backupCA)
+	if err != nil {
+		return err
+	}
+
+	if backupOutput == "-" {
+		_, err = os.Stdout.Write(archive)
+		return err
+	}
+	output := backupOutput
+	if output == "" {
+		output = "kproxy-backup-" + time.Now().Format("20060102-150405") + ".tar.gz"
+	}
+	if err := os.WriteFile(output, archive, 0600); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Saved ")
+	fmt.Printf("backup to %s (%d bytes)\n", output, len(archive))
+	return nil
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", args[0], err)
+	}
+
+	api, err := newAdminClient(backupAdminURL)
+	if err != nil {
+		return err
+	}
+
+	// Always check first, so the changes can be shown
+	plan, err := api.Restore(cmd.Context(), bytes.NewReader(data), backupCA, true)
+	if err != nil {
+		return err
+	}
+	printRestore(plan)
+	if backupDryRun {
+		return nil
+	}
+	if !backupYes && !confirm(bufio.NewReader(os.Stdin), "Restore? [y/N] ", false) {
+		return fmt.Errorf("%s not restored", args[0])
+	}
+
+	result, err := api.Restore(cmd.Context(), bytes.NewReader(data), backupCA, false)
+	if printHeld(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Restored ")
+	fmt.Printf("%s\n", args[0])
+	if len(result.CA) > 0 {
+		_, _ = color.New(color.FgYellow).Println("Restart KProxy to use the restored CA.")
+	}
+	return nil
+}
+
+// printRestore describes what a restore changes
+func printRestore(result backup.Result) {
+	cyan := color.New(color.FgCyan, color.Bold)
+	field := func(name, value string) {
+		_, _ = cyan.Printf("%-9s ", name+":")
+		fmt.Println(value)
+	}
+
+	created := result.Manifest.Created.Local().Format("2006-01-02 15:04")
+	if result.Manifest.KProxy != "" {
+		created += " by KProxy " + result.Manifest.KProxy
+	}
+	field("Backup", created)
+	field("Records", fmt.Sprintf("%d storage records replace the current ones", result.Records))
+
+	policies := "unchanged"
+	if len(result.Policies) > 0 {
+		var names []string
+		for _, p := range result.Policies {
+			switch {
+			case !slices.Contains(result.Manifest.Policies, p.Name):
+				names = append(names, "-"+p.Name)
+			case p.Created:
+				names = append(names, "+"+p.Name)
+			default:
+				names = append(names, "~"+p.Name)
+			}
+		}
+		policies = strings.Join(names, " ")
+	}
+	field("Policies", policies)
+
+	ca := "not restored"
+	if len(result.CA) > 0 {
+		ca = strings.Join(result.CA, ", ") + " (used after a restart)"
+	}
+	field("CA", ca)
+}
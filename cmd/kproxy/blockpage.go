@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var (
+	blockPageFile     string
+	blockPageAdminURL string
+)
+
+var blockPageCmd = &cobra.Command{
+	Use:   "blockpage",
+	Short: "Manage custom block pages",
+	Long: `Upload, list and remove custom block page templates on a running KProxy
+through its admin API (admin.enabled must be set). Templates are Go
+html/template pages that can show {{.Reason}}, {{.Host}}, {{.URL}},
+{{.Device}}, {{.Profile}}, {{.Category}}, {{.TimeRemaining}} and
+{{.BlockedAt}}. Profiles choose them per category in config.rego:
+
+  "block_templates": {"gaming": "game-over", "default": "family"}
+
+Templates are kept in storage (storage.type), so with Redis they survive
+restarts.`,
+}
+
+var blockPageListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List custom block pages",
+	Args:    cobra.NoArgs,
+	RunE:    runBlockPageList,
+}
+
+var blockPageShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Print a block page template",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runBlockPageShow,
+}
+
+var blockPageSetCmd = &cobra.Command{
+	Use:     "set <name>",
+	Short:   "Create or replace a block page from a file",
+	Example: `  kproxy blockpage set game-over --file game-over.html`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runBlockPageSet,
+}
+
+var blockPageRmCmd = &cobra.Command{
+	Use:     "rm <name>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a block page",
+	Args:    cobra.ExactArgs(1),
+	RunE:    runBlockPageRm,
+}
+
+func init() {
+	blockPageSetCmd.Flags().StringVar(&blockPageFile, "file", "", "HTML template file")
+	_ = blockPageSetCmd.MarkFlagRequired("file")
+	blockPageCmd.PersistentFlags().StringVar(&blockPageAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	blockPageCmd.AddCommand(blockPageListCmd)
+	blockPageCmd.AddCommand(blockPageShowCmd)
+	blockPageCmd.AddCommand(blockPageSetCmd)
+	blockPageCmd.AddCommand(blockPageRmCmd)
+	rootCmd.AddCommand(blockPageCmd)
+}
+
+func runBlockPageList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(blockPageAdminURL)
+	if err != nil {
+		return err
+	}
+
+	list, err := api.BlockPages(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-24s %-8s %s\n", "NAME", "SIZE", "UPDATED")
+	for _, page := range list {
+		fmt.Printf("%-24s %-8d %s\n", page.Name, len(page.Template), page.UpdatedAt.Local().Format("2006-01-02 15:04"))
+	}
+	if len(list) == 0 {
+		fmt.Println("(no custom block pages)")
+	}
+	return nil
+}
+
+func runBlockPageShow(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(blockPageAdminURL)
+	if err != nil {
+		return err
+	}
+
+	page, err := api.BlockPage(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Print(page.Template)
+	return nil
+}
+
+func runBlockPageSet(cmd *cobra.Command, args []string) error {
+	template, err := os.ReadFile(blockPageFile)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+
+	api, err := newAdminClient(blockPageAdminURL)
+	if err != nil {
+		return err
+	}
+
+	page, err := api.PutBlockPage(cmd.Context(), args[0], string(template))
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Saved ")
+	fmt.Println(page.Name)
+	return nil
+}
+
+func runBlockPageRm(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(blockPageAdminURL)
+	if err != nil {
+		return err
+	}
+
+	if err := api.RemoveBlockPage(cmd.Context(), args[0]); err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Removed ")
+	fmt.Println(args[0])
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/ca"
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	caInitForce   bool
+	caInitPermit  []string
+	caRotateRoot  bool
+	caRotateGrace string
+	caAdminURL    string
+)
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the interception certificate authority",
+}
+
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate the root and intermediate CA",
+	Long: `Generate a root CA and an intermediate CA signed by it, and write them to
+the paths in the tls section of the config file (tls.ca_cert, tls.ca_key,
+tls.intermediate_cert and tls.intermediate_key). Keys are written with
+mode 0600. The root uses ECDSA P-384 and may only sign CAs one level below
+it; the intermediate uses the faster P-256 and may only sign leaf
+certificates.
+
+--permit-domain limits the root to names in the given domains (X.509 name
+constraints). Only non-public suffixes such as lan or home.arpa are
+accepted. Devices then reject KProxy's certificates for every other site,
+so HTTPS to the internet can't be intercepted; use it when KProxy only
+needs to serve local names over TLS.
+
+Existing files are not overwritten unless --force is given. KProxy also
+generates missing CA files when it starts; ca init makes the step explicit
+and prints how to install the root on devices.`,
+	Example: `  sudo kproxy ca init
+  sudo kproxy ca init --permit-domain lan --permit-domain home.arpa`,
+	Args: cobra.NoArgs,
+	RunE: runCAInit,
+}
+
+var caRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the CA's signing certificates",
+	Long: `Generate a new intermediate CA on a running KProxy (admin.enabled must be
+set) and re-sign intercepted hosts with it from their next connection. No
+restart is needed, and clients trust the new intermediate through the
+existing root.
+
+With --root a new root is generated too, and clients must install it from
+the certificate download page. For --grace, the new certificates are also
+cross-signed by the old root, so clients that still trust only the old root
+keep working until it ends.
+
+The previous files are kept beside the new ones with a timestamp suffix.
+A CA using an externally managed root can't be rotated this way.`,
+	Example: `  kproxy ca rotate
+  kproxy ca rotate --root --grace 336h`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if caRotateGrace != "" {
+			if d, err := time.ParseDuration(caRotateGrace); err != nil || d < 0 {
+				return fmt.Errorf("invalid grace: %s", caRotateGrace)
+			}
+		}
+
+		api, err := newAdminClient(caAdminURL)
+		if err != nil {
+			return err
+		}
+
+		rotation, err := api.RotateCA(cmd.Context(), admin.CARotateRequest{
+			Root:  caRotateRoot,
+			Grace: caRotateGrace,
+		})
+		if err != nil {
+			return err
+		}
+
+		printRotation(rotation)
+		return nil
+	},
+}
+
+func init() {
+	caInitCmd.Flags().BoolVar(&caInitForce, "force", false, "Replace existing CA files")
+	caInitCmd.Flags().StringArrayVar(&caInitPermit, "permit-domain", nil, "Only allow the root to sign for names in this domain (repeatable)")
+	caRotateCmd.Flags().BoolVar(&caRotateRoot, "root", false, "Also generate a new root CA")
+	caRotateCmd.Flags().StringVar(&caRotateGrace, "grace", "", "How long the old root stays trusted after a root rotation, e.g. 336h (default 168h)")
+	caCmd.PersistentFlags().StringVar(&caAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	caCmd.AddCommand(caInitCmd)
+	caCmd.AddCommand(caRotateCmd)
+	rootCmd.AddCommand(caCmd)
+}
+
+func runCAInit(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	root, intermediate, err := ca.Init(ca.Config{
+		RootCertPath:   cfg.TLS.CACert,
+		RootKeyPath:    cfg.TLS.CAKey,
+		IntermCertPath: cfg.TLS.IntermediateCert,
+		IntermKeyPath:  cfg.TLS.IntermediateKey,
+	}, ca.InitOptions{
+		Force:            caInitForce,
+		PermittedDomains: caInitPermit,
+	}, zerolog.Nop())
+	if errors.Is(err, ca.ErrExists) {
+		return fmt.Errorf("%w (use --force to replace them)", err)
+	} else if err != nil {
+		return err
+	}
+
+	printCAInit(cfg, root, intermediate)
+	return nil
+}
+
+func printCAInit(cfg *config.Config, root, intermediate *x509.Certificate) {
+	cyan := color.New(color.FgCyan, color.Bold)
+	green := color.New(color.FgGreen, color.Bold)
+	yellow := color.New(color.FgYellow, color.Bold)
+
+	_, _ = green.Println("Certificate authority created")
+	fmt.Println()
+	fingerprint := sha256.Sum256(root.Raw)
+	fmt.Printf("Root:         %s (valid until %s)\n", cfg.TLS.CACert, root.NotAfter.Format("2006-01-02"))
+	fmt.Printf("Root key:     %s\n", cfg.TLS.CAKey)
+	if intermediate != nil {
+		fmt.Printf("Intermediate: %s (valid until %s)\n", cfg.TLS.IntermediateCert, intermediate.NotAfter.Format("2006-01-02"))
+		fmt.Printf("Int. key:     %s\n", cfg.TLS.IntermediateKey)
+	}
+	fmt.Printf("SHA-256:      %s\n", strings.ToUpper(fmt.Sprintf("% x", fingerprint[:])))
+	if len(root.PermittedDNSDomains) > 0 {
+		fmt.Printf("Signs for:    %s only\n", strings.Join(root.PermittedDNSDomains, ", "))
+	}
+	fmt.Println()
+
+	_, _ = cyan.Println("Install the root certificate on each device:")
+	if cfg.Server.Name != "" {
+		fmt.Printf("  Any device:  browse to https://%s/ and follow the instructions\n", cfg.Server.Name)
+	}
+	fmt.Printf("  Windows:     certutil -addstore -user Root %s\n", cfg.TLS.CACert)
+	fmt.Printf("  macOS:       sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %s\n", cfg.TLS.CACert)
+	fmt.Printf("  Linux:       sudo cp %s /usr/local/share/ca-certificates/kproxy-root-ca.crt && sudo update-ca-certificates\n", cfg.TLS.CACert)
+	fmt.Println("  iOS/Android: see docs/ca-installation.md")
+	fmt.Println()
+	fmt.Println("Check the SHA-256 fingerprint shown by the device matches the one above.")
+	_, _ = yellow.Printf("Keep %s private: anyone holding it can impersonate any site to these devices.\n", cfg.TLS.CAKey)
+}
+
+func printRotation(rotation ca.Rotation) {
+	cyan := color.New(color.FgCyan, color.Bold)
+	green := color.New(color.FgGreen, color.Bold)
+	yellow := color.New(color.FgYellow, color.Bold)
+
+	_, _ = cyan.Print("Rotated:      ")
+	if rotation.Root {
+		_, _ = yellow.Println("root and intermediate")
+	} else {
+		_, _ = green.Println("intermediate")
+	}
+	fmt.Printf("Root:         %s (SHA-256 %s)\n", rotation.RootSubject, rotation.RootFingerprint)
+	if rotation.Intermediate != "" {
+		fmt.Printf("Intermediate: %s (serial %s)\n", rotation.Intermediate, rotation.IntermediateSerial)
+	}
+	fmt.Printf("Re-signing:   %d cached certificates dropped\n", rotation.InvalidatedLeaves)
+	fmt.Printf("Backup:       previous files suffixed .%s\n", rotation.Backup)
+	if rotation.Root {
+		if rotation.GraceUntil != nil {
+			fmt.Printf("Grace:        old root trusted until %s\n", rotation.GraceUntil.Local().Format("2006-01-02 15:04:05"))
+		}
+		_, _ = yellow.Println("Install the new root CA on every device.")
+	}
+}
@@ -110,13 +110,7 @@ func runCheckDNS(cmd *cobra.Command, args []string) error {
 	logger := zerolog.New(os.Stderr).Level(zerolog.ErrorLevel).With().Timestamp().Logger()
 
 	// Initialize OPA engine directly (DNS doesn't need storage/usage data)
-	opaConfig := opa.Config{
-		Source:      cfg.Policy.OPAPolicySource,
-		PolicyDir:   cfg.Policy.OPAPolicyDir,
-		PolicyURLs:  cfg.Policy.OPAPolicyURLs,
-		HTTPTimeout: parseDuration(cfg.Policy.OPAHTTPTimeout, 30*time.Second),
-		HTTPRetries: cfg.Policy.OPAHTTPRetries,
-	}
+	opaConfig := newOPAConfig(cfg)
 
 	opaEngine, err := opa.NewEngine(opaConfig, logger)
 	if err != nil {
@@ -222,13 +216,7 @@ func runCheckHTTP(cmd *cobra.Command, args []string) error {
 	logger := zerolog.New(os.Stderr).Level(zerolog.ErrorLevel).With().Timestamp().Logger()
 
 	// Initialize OPA engine directly (no storage needed if we're providing usage data)
-	opaConfig := opa.Config{
-		Source:      cfg.Policy.OPAPolicySource,
-		PolicyDir:   cfg.Policy.OPAPolicyDir,
-		PolicyURLs:  cfg.Policy.OPAPolicyURLs,
-		HTTPTimeout: parseDuration(cfg.Policy.OPAHTTPTimeout, 30*time.Second),
-		HTTPRetries: cfg.Policy.OPAHTTPRetries,
-	}
+	opaConfig := newOPAConfig(cfg)
 
 	opaEngine, err := opa.NewEngine(opaConfig, logger)
 	if err != nil {
@@ -300,11 +288,20 @@ func runCheckHTTP(cmd *cobra.Command, args []string) error {
 		Action:        policy.Action(opaDecision.Action),
 		Reason:        opaDecision.Reason,
 		BlockPage:     opaDecision.BlockPage,
+		BlockTemplate: opaDecision.BlockTemplate,
 		MatchedRuleID: opaDecision.MatchedRuleID,
 		Category:      opaDecision.Category,
 		InjectTimer:   opaDecision.InjectTimer,
 		TimeRemaining: time.Duration(opaDecision.TimeRemainingMinutes) * time.Minute,
 		UsageLimitID:  opaDecision.UsageLimitID,
+		ThrottleDelay: time.Duration(opaDecision.ThrottleDelayMS) * time.Millisecond,
+		ThrottleKbps:  opaDecision.ThrottleKbps,
+	}
+	for _, limit := range opaDecision.BandwidthLimits {
+		decision.BandwidthLimits = append(decision.BandwidthLimits, policy.BandwidthLimit{ID: limit.ID, Kbps: limit.Kbps, Bucket: limit.Bucket})
+	}
+	if n := opaDecision.Notice; n != nil {
+		decision.Notice = &policy.Notice{Kind: n.Kind, Category: n.Category, Remaining: time.Duration(n.MinutesRemaining) * time.Minute, Threshold: n.Threshold}
 	}
 
 	// Display result with colors
@@ -477,6 +474,9 @@ func printHTTPResult(parsedURL *url.URL, clientIP net.IP, clientMAC net.Hardware
 	case policy.ActionBlock:
 		_, _ = red.Println("BLOCK")
 		fmt.Println("            → Request will be blocked")
+	case policy.ActionWarn:
+		_, _ = yellow.Println("WARN")
+		fmt.Println("            → Request will be allowed (warn mode, would be blocked)")
 	default:
 		fmt.Printf("%s\n", decision.Action)
 	}
@@ -501,9 +501,32 @@ func printHTTPResult(parsedURL *url.URL, clientIP net.IP, clientMAC net.Hardware
 		_, _ = yellow.Printf("Timer:      Yes (Time Remaining: %d minutes)\n", int(decision.TimeRemaining.Minutes()))
 	}
 
+	if decision.ThrottleDelay > 0 || decision.ThrottleKbps > 0 {
+		_, _ = yellow.Printf("Throttle:   %s delay", decision.ThrottleDelay)
+		if decision.ThrottleKbps > 0 {
+			_, _ = yellow.Printf(", %d kbit/s", decision.ThrottleKbps)
+		}
+		fmt.Println()
+	}
+
+	for _, limit := range decision.BandwidthLimits {
+		_, _ = yellow.Printf("Bandwidth:  %d kbit/s (%s, shared as %s)\n", limit.Kbps, limit.ID, limit.Bucket)
+	}
+
+	if n := decision.Notice; n != nil {
+		what := n.Kind
+		if n.Category != "" {
+			what += " for " + n.Category
+		}
+		_, _ = yellow.Printf("Notice:     %s in %d minutes (%d minute warning)\n", what, int(n.Remaining.Minutes()), n.Threshold)
+	}
+
 	if decision.BlockPage != "" {
 		fmt.Printf("Block Page: %s\n", decision.BlockPage)
 	}
+	if decision.BlockTemplate != "" {
+		fmt.Printf("Template:   %s\n", decision.BlockTemplate)
+	}
 
 	fmt.Println()
 	_, _ = cyan.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var checkReplayCmd = &cobra.Command{
+	Use:   "replay [flags] DECISION-ID",
+	Short: "Evaluate a recorded decision again against current policies",
+	Long: `Evaluate a decision recorded by the decision log (policy.decision_log) again,
+with the same input, against the policies currently configured, and show how
+the result differs from the recorded one. Decision IDs are logged with each
+DNS query and proxy request as decision_id.
+
+Decisions are read from storage, so replaying needs the Redis storage the
+server records to; they are kept for policy.decision_log.retention.`,
+	Example: `  kproxy check replay 5f2c9a81d3e04b17
+  kproxy check replay --show-facts 5f2c9a81d3e04b17`,
+	Args: cobra.ExactArgs(1),
+	RunE: runCheckReplay,
+}
+
+func init() {
+	checkReplayCmd.Flags().BoolVar(&checkShowFacts, "show-facts", false, "Show the recorded facts/input sent to OPA")
+	checkCmd.AddCommand(checkReplayCmd)
+}
+
+func runCheckReplay(cmd *cobra.Command, args []string) error {
+	id := args[0]
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if cfg.Storage.Type == "memory" && cfg.Storage.Postgres.DSN == "" {
+		return fmt.Errorf("decisions in memory storage can't be read outside the server; storage.type must be redis, or storage.postgres.dsn set")
+	}
+
+	store, err := openStorage(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	decision, err := store.Decisions().Get(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("decision %s not found: it wasn't sampled, was too large to record, or has expired", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read decision: %w", err)
+	}
+
+	// Create a quiet logger for check mode
+	logger := zerolog.New(os.Stderr).Level(zerolog.ErrorLevel).With().Timestamp().Logger()
+	opaEngine, err := opa.NewEngine(newOPAConfig(cfg), logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize OPA engine: %w", err)
+	}
+
+	replayed, err := policy.Replay(ctx, opaEngine, decision)
+	if err != nil {
+		return fmt.Errorf("OPA evaluation failed: %w", err)
+	}
+
+	if checkShowFacts {
+		var facts map[string]interface{}
+		if err := json.Unmarshal(decision.Input, &facts); err != nil {
+			return fmt.Errorf("failed to decode decision input: %w", err)
+		}
+		printFacts(facts)
+	}
+
+	return printReplay(decision, replayed)
+}
+
+// printReplay shows a recorded decision's result and how the current
+// policies' result differs from it
+func printReplay(decision *storage.Decision, replayed json.RawMessage) error {
+	var input map[string]interface{}
+	if err := json.Unmarshal(decision.Input, &input); err != nil {
+		return fmt.Errorf("failed to decode decision input: %w", err)
+	}
+	var recordedResult, replayedResult struct {
+		Action        string `json:"action"`
+		Reason        string `json:"reason"`
+		MatchedRuleID string `json:"matched_rule_id"`
+	}
+	_ = json.Unmarshal(decision.Result, &recordedResult)
+	_ = json.Unmarshal(replayed, &replayedResult)
+
+	bold := color.New(color.Bold)
+	cyan := color.New(color.FgCyan, color.Bold)
+	green := color.New(color.FgGreen, color.Bold)
+	yellow := color.New(color.FgYellow, color.Bold)
+
+	_, _ = cyan.Printf("Decision %s\n", decision.ID)
+	fmt.Printf("  Kind:     %s\n", decision.Kind)
+	fmt.Printf("  Recorded: %s\n", decision.Time.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("  Client:   %v\n", input["client_ip"])
+	if decision.Kind == "dns" {
+		fmt.Printf("  Domain:   %v\n", input["domain"])
+	} else {
+		fmt.Printf("  Request:  %v %v%v\n", input["method"], input["host"], input["path"])
+	}
+	fmt.Println()
+
+	describe := func(action, reason, rule string) string {
+		s := action
+		if rule != "" {
+			s += " [" + rule + "]"
+		}
+		if reason != "" {
+			s += " - " + reason
+		}
+		return s
+	}
+	_, _ = bold.Print("Recorded: ")
+	fmt.Println(describe(recordedResult.Action, recordedResult.Reason, recordedResult.MatchedRuleID))
+	_, _ = bold.Print("Current:  ")
+	fmt.Println(describe(replayedResult.Action, replayedResult.Reason, replayedResult.MatchedRuleID))
+	fmt.Println()
+
+	before, err := indentJSON(decision.Result)
+	if err != nil {
+		return err
+	}
+	after, err := indentJSON(replayed)
+	if err != nil {
+		return err
+	}
+	if before == after {
+		_, _ = green.Println("✓ The current policies decide the same")
+		return nil
+	}
+
+	_, _ = yellow.Println("⚠ The current policies decide differently")
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(before),
+		B:        difflib.SplitLines(after),
+		FromFile: "recorded",
+		ToFile:   "current",
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	printDiff(diff)
+	return nil
+}
+
+// indentJSON formats a result for diffing, one field per line
+func indentJSON(data json.RawMessage) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return "", fmt.Errorf("failed to format decision result: %w", err)
+	}
+	return strings.TrimRight(buf.String(), "\n") + "\n", nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	decisionsAdminURL string
+	decisionsKind     string
+	decisionsClient   string
+	decisionsHost     string
+	decisionsSince    string
+	decisionsUntil    string
+	decisionsLimit    int
+)
+
+var decisionsCmd = &cobra.Command{
+	Use:   "decisions",
+	Short: "Search recorded policy decisions",
+	Long: `List the DNS and proxy decisions a running KProxy recorded
+(admin.enabled and policy.decision_log.enabled must be set), newest first:
+when, which client, the host and the action. Searching needs the decision
+log in PostgreSQL (storage.postgres.dsn), or bolt or memory storage.
+
+Use "kproxy check replay" with a decision ID to evaluate it again against
+the current policies.`,
+	Example: `  kproxy decisions --client 192.168.1.50 --since 24h
+  kproxy decisions --host www.roblox.com --since 2026-09-01 --until 2026-10-01
+  kproxy decisions --kind dns --limit 20`,
+	Args: cobra.NoArgs,
+	RunE: runDecisions,
+}
+
+func init() {
+	decisionsCmd.Flags().StringVar(&decisionsKind, "kind", "", "Only dns or proxy decisions")
+	decisionsCmd.Flags().StringVar(&decisionsClient, "client", "", "Only decisions for this client IP address")
+	decisionsCmd.Flags().StringVar(&decisionsHost, "host", "", "Only decisions for this host or DNS domain")
+	decisionsCmd.Flags().StringVar(&decisionsSince, "since", "", "Only decisions within this long (24h), or from a date or RFC 3339 time")
+	decisionsCmd.Flags().StringVar(&decisionsUntil, "until", "", "Only decisions before a date or RFC 3339 time")
+	decisionsCmd.Flags().IntVar(&decisionsLimit, "limit", 50, "Maximum number of decisions to list")
+	decisionsCmd.Flags().StringVar(&decisionsAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	rootCmd.AddCommand(decisionsCmd)
+}
+
+func runDecisions(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(decisionsAdminURL)
+	if err != nil {
+		return err
+	}
+
+	query := client.DecisionQuery{Kind: decisionsKind, Client: decisionsClient, Host: decisionsHost, Limit: decisionsLimit}
+	if decisionsSince != "" {
+		if query.Since, err = parseSearchTime(decisionsSince, true); err != nil {
+			return fmt.Errorf("invalid --since: %s", decisionsSince)
+		}
+	}
+	if decisionsUntil != "" {
+		if query.Until, err = parseSearchTime(decisionsUntil, false); err != nil {
+			return fmt.Errorf("invalid --until: %s", decisionsUntil)
+		}
+	}
+
+	decisions, err := api.Decisions(cmd.Context(), query)
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-17s %-20s %-6s %-16s %-40s %s\n", "ID", "TIME", "KIND", "CLIENT", "HOST", "ACTION")
+	for _, d := range decisions {
+		var input struct {
+			ClientIP string `json:"client_ip"`
+			Host     string `json:"host"`
+			Domain   string `json:"domain"`
+		}
+		var result struct {
+			Action string `json:"action"`
+		}
+		_ = json.Unmarshal(d.Input, &input)
+		_ = json.Unmarshal(d.Result, &result)
+		host := input.Host
+		if host == "" {
+			host = input.Domain
+		}
+		fmt.Printf("%-17s %-20s %-6s %-16s %-40s %s\n", d.ID, d.Time.Local().Format("2006-01-02 15:04:05"), d.Kind, input.ClientIP, host, result.Action)
+	}
+	if len(decisions) == 0 {
+		fmt.Println("(no decisions recorded)")
+	}
+	return nil
+}
+
+// parseSearchTime turns --since or --until into a time: a date, an RFC 3339
+// time, or (with ago) a duration before now
+func parseSearchTime(value string, ago bool) (time.Time, error) {
+	if ago {
+		if d, err := time.ParseDuration(value); err == nil {
+			if d <= 0 {
+				return time.Time{}, fmt.Errorf("duration must be positive")
+			}
+			return time.Now().Add(-d), nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
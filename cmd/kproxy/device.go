@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/spf13/cobra"
+)
+
+var (
+	deviceID          string
+	deviceName        string
+	deviceIdentifiers []string
+	deviceProfile     string
+	deviceAdminURL    string
+	devicePending     bool
+)
+
+var deviceCmd = &cobra.Command{
+	Use:   "device",
+	Short: "Manage devices",
+	Long: `List, add and assign profiles to devices on a running KProxy through its
+admin API (admin.enabled must be set), and identify unknown clients.
+Devices added and profiles assigned here are kept in memory until KProxy
+restarts; permanent devices belong in config.rego.`,
+}
+
+var deviceListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List devices and their profiles",
+	Long: `List devices and their profiles. With admin.discovery enabled, unknown
+clients are added as pending devices in the quarantine profile as they
+appear; classify one by assigning it a profile with kproxy device assign.`,
+	Example: `  kproxy device list
+  kproxy device list --pending`,
+	Args: cobra.NoArgs,
+	RunE: runDeviceList,
+}
+
+var deviceAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a device",
+	Example: `  kproxy device add --id kids-switch --name "Nintendo Switch" --identifier 98:b6:e9:12:34:56 --profile kids
+  kproxy device add --id guest-wifi --identifier 192.168.50.0/24 --profile guest`,
+	Args: cobra.NoArgs,
+	RunE: runDeviceAdd,
+}
+
+var deviceAssignCmd = &cobra.Command{
+	Use:     "assign <id>",
+	Short:   "Assign a profile to a device",
+	Example: `  kproxy device assign kids-ipad --profile adults`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runDeviceAssign,
+}
+
+var deviceIdentifyCmd = &cobra.Command{
+	Use:   "identify <ip>",
+	Short: "Describe the client at an IP address",
+	Long: `Describe the client at an IP address using the server's ARP table, its
+DHCP lease, multicast DNS and recent traffic, and show which device (if
+any) policy identifies it as.`,
+	Example: `  kproxy device identify 192.168.1.57`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runDeviceIdentify,
+}
+
+func init() {
+	deviceAddCmd.Flags().StringVar(&deviceID, "id", "", "Device ID (lowercase letters, digits, - and _)")
+	deviceAddCmd.Flags().StringVar(&deviceName, "name", "", "Display name (default: the ID)")
+	deviceAddCmd.Flags().StringArrayVar(&deviceIdentifiers, "identifier", nil, "MAC address, IP address or CIDR range (repeatable)")
+	deviceAddCmd.Flags().StringVar(&deviceProfile, "profile", "", "Profile for the device")
+	_ = deviceAddCmd.MarkFlagRequired("id")
+	_ = deviceAddCmd.MarkFlagRequired("identifier")
+	_ = deviceAddCmd.MarkFlagRequired("profile")
+	deviceListCmd.Flags().BoolVar(&devicePending, "pending", false, "Only list new devices waiting to be classified")
+	deviceAssignCmd.Flags().StringVar(&deviceProfile, "profile", "", "Profile to assign")
+	_ = deviceAssignCmd.MarkFlagRequired("profile")
+	deviceCmd.PersistentFlags().StringVar(&deviceAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	deviceCmd.AddCommand(deviceListCmd)
+	deviceCmd.AddCommand(deviceAddCmd)
+	deviceCmd.AddCommand(deviceAssignCmd)
+	deviceCmd.AddCommand(deviceIdentifyCmd)
+	rootCmd.AddCommand(deviceCmd)
+}
+
+func runDeviceList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(deviceAdminURL)
+	if err != nil {
+		return err
+	}
+
+	list, err := api.Devices(cmd.Context(), devicePending)
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-20s %-24s %-12s %-8s %s\n", "ID", "NAME", "PROFILE", "SOURCE", "IDENTIFIERS")
+
+	assigned, pending := false, false
+	for _, d := range list {
+		profile := d.Profile
+		if d.Assigned {
+			profile += "*"
+			assigned = true
+		}
+		source := d.Source
+		if d.Pending {
+			source = "pending"
+			pending = true
+		}
+		fmt.Printf("%-20s %-24s %-12s %-8s %s\n", d.ID, d.Name, profile, source, strings.Join(d.Identifiers, ", "))
+	}
+	if len(list) == 0 {
+		fmt.Println("(no devices)")
+	}
+	if assigned || pending {
+		fmt.Println()
+	}
+	if assigned {
+		fmt.Println("* profile assigned at runtime")
+	}
+	if pending {
+		fmt.Println("Classify pending devices with: kproxy device assign <id> --profile <profile>")
+	}
+	return nil
+}
+
+func runDeviceAdd(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(deviceAdminURL)
+	if err != nil {
+		return err
+	}
+
+	req := admin.DeviceRequest{
+		ID:          deviceID,
+		Name:        deviceName,
+		Identifiers: deviceIdentifiers,
+		Profile:     deviceProfile,
+	}
+	device, err := api.AddDevice(cmd.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Added ")
+	fmt.Printf("%s (%s) with profile %s: %s\n", device.ID, device.Name, device.Profile, strings.Join(device.Identifiers, ", "))
+	return nil
+}
+
+func runDeviceAssign(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(deviceAdminURL)
+	if err != nil {
+		return err
+	}
+
+	device, err := api.AssignProfile(cmd.Context(), args[0], deviceProfile)
+	if printHeld(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Assigned ")
+	fmt.Printf("profile %s to %s\n", device.Profile, device.ID)
+	return nil
+}
+
+func runDeviceIdentify(cmd *cobra.Command, args []string) error {
+	ip := net.ParseIP(args[0])
+	if ip == nil {
+		return fmt.Errorf("invalid IP address: %s", args[0])
+	}
+
+	api, err := newAdminClient(deviceAdminURL)
+	if err != nil {
+		return err
+	}
+
+	id, err := api.IdentifyDevice(cmd.Context(), ip.String())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	field := func(name, value string) {
+		if value == "" {
+			value = "-"
+		}
+		_, _ = cyan.Printf("%-16s", name+":")
+		fmt.Println(value)
+	}
+
+	field("IP", id.IP)
+	mac := id.MAC
+	if mac != "" {
+		mac += " (" + id.MACSource + ")"
+	}
+	field("MAC", mac)
+	if id.Lease != nil {
+		lease := id.Lease.Hostname
+		if lease == "" {
+			lease = "(no hostname)"
+		}
+		lease += ", expires " + id.Lease.ExpiresAt.Local().Format("2006-01-02 15:04")
+		field("DHCP lease", lease)
+	} else {
+		field("DHCP lease", "")
+	}
+	field("mDNS name", id.MDNSName)
+	if id.Fingerprint != nil {
+		field("Device type", id.Fingerprint.DeviceType)
+		field("DHCP vendor", id.Fingerprint.VendorClass)
+		if len(id.Fingerprint.UserAgents) > 0 {
+			field("User-Agent", id.Fingerprint.UserAgents[0])
+		}
+	}
+
+	switch {
+	case id.Device != nil && id.Device.DeviceType != "":
+		_, _ = cyan.Printf("%-16s", "Device:")
+		_, _ = color.New(color.FgYellow).Printf("unknown (default profile of %s devices applies)\n", id.Device.DeviceType)
+		field("Profile", id.Device.Profile)
+	case id.Device != nil && id.Device.Subnet != "":
+		_, _ = cyan.Printf("%-16s", "Device:")
+		_, _ = color.New(color.FgYellow).Printf("unknown (default profile of %s applies)\n", id.Device.Subnet)
+		field("Profile", id.Device.Profile)
+	case id.Device != nil:
+		field("Device", fmt.Sprintf("%s (%s)", id.DeviceID, id.Device.Name))
+		field("Profile", id.Device.Profile)
+	default:
+		_, _ = cyan.Printf("%-16s", "Device:")
+		_, _ = color.New(color.FgYellow).Println("unknown (default profile applies)")
+	}
+
+	if id.Activity != nil {
+		field("Queries/min", fmt.Sprintf("%d", id.Activity.QueriesLastMinute))
+		if len(id.Activity.RecentBlocks) > 0 {
+			_, _ = cyan.Println("Recent blocks:")
+			for _, b := range id.Activity.RecentBlocks {
+				fmt.Printf("  %s  %s  %s\n", b.Time.Local().Format("15:04:05"), b.Host, b.Reason)
+			}
+		}
+	}
+	return nil
+}
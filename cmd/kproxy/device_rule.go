@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/rules"
+	"github.com/spf13/cobra"
+)
+
+var (
+	deviceRuleDomain string
+	deviceRuleAction string
+	deviceRuleUntil  string
+)
+
+var deviceRuleCmd = &cobra.Command{
+	Use:   "rule",
+	Short: "Manage rules for a single device",
+	Long: `Add, list and remove rules for one device, so it can get an exception
+without a profile of its own. Device rules take precedence over the rules
+of the device's profile, including runtime rules added with "kproxy rule".
+They are kept in storage and survive restarts until they expire or are
+removed.
+
+Device rules apply to devices matched by their identifiers; clients
+identified only by user or by a subnet default profile have none.`,
+}
+
+var deviceRuleAddCmd = &cobra.Command{
+	Use:   "add <device>",
+	Short: "Add a rule for a device",
+	Example: `  kproxy device rule add kids-ipad --domain .roblox.com --action allow --until 21:00
+  kproxy device rule add kids-laptop --domain .youtube.com --action block`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDeviceRuleAdd,
+}
+
+var deviceRuleListCmd = &cobra.Command{
+	Use:     "list <device>",
+	Aliases: []string{"ls"},
+	Short:   "List a device's rules",
+	Args:    cobra.ExactArgs(1),
+	RunE:    runDeviceRuleList,
+}
+
+var deviceRuleRmCmd = &cobra.Command{
+	Use:     "rm <device> <id>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a device rule",
+	Example: `  kproxy device rule rm kids-ipad device-rule-3`,
+	Args:    cobra.ExactArgs(2),
+	RunE:    runDeviceRuleRm,
+}
+
+func init() {
+	deviceRuleAddCmd.Flags().StringVar(&deviceRuleDomain, "domain", "", "Domain pattern, as in config.rego (e.g. .roblox.com)")
+	deviceRuleAddCmd.Flags().StringVar(&deviceRuleAction, "action", "", "Rule action: allow, block or bypass")
+	deviceRuleAddCmd.Flags().StringVar(&deviceRuleUntil, "until", "", "When the rule ends: a time of day (21:00), a duration (2h) or an RFC 3339 time (default: until removed)")
+	_ = deviceRuleAddCmd.MarkFlagRequired("domain")
+	_ = deviceRuleAddCmd.MarkFlagRequired("action")
+
+	deviceRuleCmd.AddCommand(deviceRuleAddCmd)
+	deviceRuleCmd.AddCommand(deviceRuleListCmd)
+	deviceRuleCmd.AddCommand(deviceRuleRmCmd)
+	deviceCmd.AddCommand(deviceRuleCmd)
+}
+
+func runDeviceRuleAdd(cmd *cobra.Command, args []string) error {
+	req := admin.DeviceRuleRequest{
+		Domain: deviceRuleDomain,
+		Action: strings.ToLower(deviceRuleAction),
+	}
+	switch req.Action {
+	case rules.ActionAllow, rules.ActionBlock, rules.ActionBypass:
+	default:
+		return fmt.Errorf("invalid action: %s (must be allow, block or bypass)", deviceRuleAction)
+	}
+	if deviceRuleUntil != "" {
+		until, err := parseUntil(deviceRuleUntil, time.Now())
+		if err != nil {
+			return err
+		}
+		req.Until = &until
+	}
+
+	api, err := newAdminClient(deviceAdminURL)
+	if err != nil {
+		return err
+	}
+
+	rule, err := api.AddDeviceRule(cmd.Context(), args[0], req)
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Added ")
+	fmt.Printf("%s: %s %s for device %s", rule.ID, rule.Action, rule.Domain, rule.Device)
+	if rule.Until != nil {
+		fmt.Printf(" until %s", rule.Until.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Println()
+	return nil
+}
+
+func runDeviceRuleList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(deviceAdminURL)
+	if err != nil {
+		return err
+	}
+
+	list, err := api.DeviceRules(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-16s %-8s %-32s %s\n", "ID", "ACTION", "DOMAIN", "UNTIL")
+	for _, rule := range list {
+		until := "-"
+		if rule.Until != nil {
+			until = rule.Until.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-16s %-8s %-32s %s\n", rule.ID, rule.Action, rule.Domain, until)
+	}
+	if len(list) == 0 {
+		fmt.Println("(no device rules)")
+	}
+	return nil
+}
+
+func runDeviceRuleRm(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(deviceAdminURL)
+	if err != nil {
+		return err
+	}
+
+	err = api.RemoveDeviceRule(cmd.Context(), args[0], args[1])
+	if printHeld(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Removed ")
+	fmt.Println(args[1])
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var dhcpAdminURL string
+
+var dhcpCmd = &cobra.Command{
+	Use:   "dhcp",
+	Short: "Manage DHCP leases and reservations",
+	Long: `List and release the leases of KProxy's DHCP server, and reserve a
+client's address so it always gets the same one, through the admin API of a
+running KProxy (admin.enabled and dhcp.enabled must be set).
+
+Releasing a lease frees its address for other clients; the client is offered
+an address afresh when it next renews. Reservations are kept in storage
+(storage.type), so with Redis they survive restarts, and may be outside the
+dhcp.range_start to dhcp.range_end pool.`,
+}
+
+var dhcpLeasesCmd = &cobra.Command{
+	Use:     "leases",
+	Aliases: []string{"ls"},
+	Short:   "List active leases",
+	Args:    cobra.NoArgs,
+	RunE:    runDHCPLeases,
+}
+
+var dhcpReleaseCmd = &cobra.Command{
+	Use:   "release <mac>",
+	Short: "Expire a lease now",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDHCPRelease,
+}
+
+var dhcpReserveCmd = &cobra.Command{
+	Use:     "reserve <mac>",
+	Short:   "Reserve the address of a client's lease",
+	Example: `  kproxy dhcp reserve aa:bb:cc:dd:ee:ff`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runDHCPReserve,
+}
+
+var dhcpReservationsCmd = &cobra.Command{
+	Use:   "reservations",
+	Short: "List reservations",
+	Args:  cobra.NoArgs,
+	RunE:  runDHCPReservations,
+}
+
+var dhcpUnreserveCmd = &cobra.Command{
+	Use:   "unreserve <mac>",
+	Short: "Remove a reservation",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDHCPUnreserve,
+}
+
+func init() {
+	dhcpCmd.PersistentFlags().StringVar(&dhcpAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	dhcpCmd.AddCommand(dhcpLeasesCmd)
+	dhcpCmd.AddCommand(dhcpReleaseCmd)
+	dhcpCmd.AddCommand(dhcpReserveCmd)
+	dhcpCmd.AddCommand(dhcpReservationsCmd)
+	dhcpCmd.AddCommand(dhcpUnreserveCmd)
+	rootCmd.AddCommand(dhcpCmd)
+}
+
+func runDHCPLeases(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(dhcpAdminURL)
+	if err != nil {
+		return err
+	}
+
+	leases, err := api.Leases(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-16s %-18s %-24s %-16s %s\n", "IP", "MAC", "HOSTNAME", "EXPIRES", "RESERVED")
+	for _, lease := range leases {
+		reserved := ""
+		if lease.Reserved {
+			reserved = "yes"
+		}
+		fmt.Printf("%-16s %-18s %-24s %-16s %s\n", lease.IP, lease.MAC, lease.Hostname, lease.ExpiresAt.Local().Format("2006-01-02 15:04"), reserved)
+	}
+	if len(leases) == 0 {
+		fmt.Println("(no active leases)")
+	}
+	return nil
+}
+
+func runDHCPRelease(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(dhcpAdminURL)
+	if err != nil {
+		return err
+	}
+
+	mac, err := api.ReleaseLease(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Released ")
+	fmt.Println(mac)
+	return nil
+}
+
+func runDHCPReserve(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(dhcpAdminURL)
+	if err != nil {
+		return err
+	}
+
+	reservation, err := api.ReserveLease(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Reserved ")
+	fmt.Printf("%s for %s\n", reservation.IP, reservation.MAC)
+	return nil
+}
+
+func runDHCPReservations(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(dhcpAdminURL)
+	if err != nil {
+		return err
+	}
+
+	reservations, err := api.Reservations(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-16s %-18s %-24s %s\n", "IP", "MAC", "HOSTNAME", "CREATED")
+	for _, r := range reservations {
+		fmt.Printf("%-16s %-18s %-24s %s\n", r.IP, r.MAC, r.Hostname, r.CreatedAt.Local().Format("2006-01-02 15:04"))
+	}
+	if len(reservations) == 0 {
+		fmt.Println("(no reservations)")
+	}
+	return nil
+}
+
+func runDHCPUnreserve(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(dhcpAdminURL)
+	if err != nil {
+		return err
+	}
+
+	mac, err := api.RemoveReservation(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Removed reservation for ")
+	fmt.Println(mac)
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/maintenance"
+	"github.com/goodtune/kproxy/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	maintenanceMessage  string
+	maintenanceDuration string
+	maintenanceAdminURL string
+)
+
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Control network maintenance mode",
+	Long: `Switch network maintenance mode on a running KProxy through its admin API
+(admin.enabled must be set). While it is on, all web traffic gets a maintenance
+page and DNS follows maintenance.dns_action. Maintenance ends automatically
+after its duration.`,
+}
+
+var maintenanceOnCmd = &cobra.Command{
+	Use:   "on",
+	Short: "Start (or extend) a maintenance window",
+	Example: `  kproxy maintenance on
+  kproxy maintenance on --duration 30m --message "Upgrading the router, back by 9pm"`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if maintenanceDuration != "" {
+			if _, err := time.ParseDuration(maintenanceDuration); err != nil {
+				return fmt.Errorf("invalid duration: %s", maintenanceDuration)
+			}
+		}
+		req := admin.MaintenanceRequest{
+			Message:  maintenanceMessage,
+			Duration: maintenanceDuration,
+		}
+		return runMaintenance(func(api *client.Client) (maintenance.Status, error) {
+			return api.StartMaintenance(cmd.Context(), req)
+		})
+	},
+}
+
+var maintenanceOffCmd = &cobra.Command{
+	Use:   "off",
+	Short: "End the maintenance window",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMaintenance(func(api *client.Client) (maintenance.Status, error) {
+			return api.EndMaintenance(cmd.Context())
+		})
+	},
+}
+
+var maintenanceStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether maintenance mode is on",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMaintenance(func(api *client.Client) (maintenance.Status, error) {
+			return api.Maintenance(cmd.Context())
+		})
+	},
+}
+
+func init() {
+	maintenanceOnCmd.Flags().StringVar(&maintenanceMessage, "message", "", "Message for the maintenance page (default: maintenance.message)")
+	maintenanceOnCmd.Flags().StringVar(&maintenanceDuration, "duration", "", "How long maintenance lasts, e.g. 30m (default: maintenance.duration)")
+	maintenanceCmd.PersistentFlags().StringVar(&maintenanceAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	maintenanceCmd.AddCommand(maintenanceOnCmd)
+	maintenanceCmd.AddCommand(maintenanceOffCmd)
+	maintenanceCmd.AddCommand(maintenanceStatusCmd)
+	rootCmd.AddCommand(maintenanceCmd)
+}
+
+// runMaintenance calls the maintenance endpoint of the admin API through
+// call and prints the result
+func runMaintenance(call func(api *client.Client) (maintenance.Status, error)) error {
+	api, err := newAdminClient(maintenanceAdminURL)
+	if err != nil {
+		return err
+	}
+
+	status, err := call(api)
+	if err != nil {
+		return err
+	}
+
+	printMaintenanceStatus(status)
+	return nil
+}
+
+func printMaintenanceStatus(status maintenance.Status) {
+	cyan := color.New(color.FgCyan, color.Bold)
+	green := color.New(color.FgGreen, color.Bold)
+	yellow := color.New(color.FgYellow, color.Bold)
+
+	_, _ = cyan.Print("Maintenance: ")
+	if !status.Active {
+		_, _ = green.Println("OFF")
+		return
+	}
+
+	_, _ = yellow.Println("ON")
+	if status.Until != nil {
+		fmt.Printf("Until:       %s (in %s)\n", status.Until.Local().Format("2006-01-02 15:04:05"), time.Until(*status.Until).Round(time.Second))
+	}
+	fmt.Printf("Message:     %s\n", status.Message)
+}
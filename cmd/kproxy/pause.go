@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pauseProfile  string
+	pauseUntil    string
+	pauseAdminURL string
+)
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause [device]",
+	Short: "Pause the internet for a device or profile",
+	Long: `Pause the internet for a device, or with --profile for every device with a
+profile, through the admin API of a running KProxy (admin.enabled must be
+set). DNS and the proxy block everything for it from the next query on,
+apart from KProxy's own server name, which shows a block page saying the
+internet is paused; no policy edit or reload is involved. Answers devices
+looked up before the pause stay in their DNS caches until they expire.
+
+Pauses last until "kproxy resume", or until --until. They are kept in
+storage and survive restarts. Without arguments, pause lists what is paused.`,
+	Example: `  kproxy pause kids-ipad
+  kproxy pause kids-ipad --until 30m
+  kproxy pause --profile child --until 19:00
+  kproxy pause`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runPause,
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume [device]",
+	Short: "End the pause of a device or profile",
+	Example: `  kproxy resume kids-ipad
+  kproxy resume --profile child`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runResume,
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{pauseCmd, resumeCmd} {
+		cmd.Flags().StringVar(&pauseProfile, "profile", "", "Profile to pause or resume, instead of a device")
+		cmd.Flags().StringVar(&pauseAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+		rootCmd.AddCommand(cmd)
+	}
+	pauseCmd.Flags().StringVar(&pauseUntil, "until", "", "When the pause ends: a time of day (21:00), a duration (2h) or an RFC 3339 time (default: until resumed)")
+}
+
+// pauseTarget returns the kind and target of the pause of the device in
+// args or of --profile
+func pauseTarget(args []string) (string, string, error) {
+	switch {
+	case len(args) == 1 && pauseProfile != "":
+		return "", "", fmt.Errorf("give a device or --profile, not both")
+	case len(args) == 1:
+		return storage.PauseDevice, args[0], nil
+	case pauseProfile != "":
+		return storage.PauseProfile, pauseProfile, nil
+	}
+	return "", "", fmt.Errorf("give a device or --profile")
+}
+
+func runPause(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 && pauseProfile == "" {
+		return runPauseList(cmd)
+	}
+	kind, target, err := pauseTarget(args)
+	if err != nil {
+		return err
+	}
+
+	var req admin.PauseRequest
+	if pauseUntil != "" {
+		until, err := parseUntil(pauseUntil, time.Now())
+		if err != nil {
+			return err
+		}
+		req.Until = &until
+	}
+
+	api, err := newAdminClient(pauseAdminURL)
+	if err != nil {
+		return err
+	}
+
+	p, err := api.Pause(cmd.Context(), kind, target, req)
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgYellow, color.Bold).Print("Paused ")
+	fmt.Printf("%s %s", p.Kind, p.Target)
+	if p.Until != nil {
+		fmt.Printf(" until %s", p.Until.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Println()
+	return nil
+}
+
+func runResume(cmd *cobra.Command, args []string) error {
+	kind, target, err := pauseTarget(args)
+	if err != nil {
+		return err
+	}
+
+	api, err := newAdminClient(pauseAdminURL)
+	if err != nil {
+		return err
+	}
+	resumed, err := api.Resume(cmd.Context(), kind, target)
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Resumed ")
+	fmt.Println(resumed)
+	return nil
+}
+
+func runPauseList(cmd *cobra.Command) error {
+	api, err := newAdminClient(pauseAdminURL)
+	if err != nil {
+		return err
+	}
+
+	list, err := api.Pauses(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-8s %-24s %-18s %s\n", "KIND", "TARGET", "SINCE", "UNTIL")
+	for _, p := range list {
+		until := "resumed"
+		if p.Until != nil {
+			until = p.Until.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-8s %-24s %-18s %s\n", p.Kind, p.Target, p.Created.Local().Format("2006-01-02 15:04"), until)
+	}
+	if len(list) == 0 {
+		fmt.Println("(nothing paused)")
+	}
+	return nil
+}
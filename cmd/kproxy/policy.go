@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/goodtune/kproxy/policies"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	policyInitForce bool
+	policyInitTests bool
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Manage the Rego policy directory",
+}
+
+var policyInitCmd = &cobra.Command{
+	Use:   "init [dir]",
+	Short: "Write the starter policies to a policy directory",
+	Long: `Write the policies shipped with this release to a policy directory, so
+KProxy starts from a working set instead of an empty directory. The
+directory defaults to policy.opa_policy_dir from the config file
+(/etc/kproxy/policies).
+
+device.rego, dns.rego, proxy.rego, schedule.rego and helpers.rego decide
+from the facts KProxy gathers. config.rego holds your devices, profiles and
+bypass domains; it starts with a profile that blocks everything, and its
+comments show device matching, subnet defaults, time restrictions and
+usage limits. Edit config.rego and leave the other files as they are, so
+they can be replaced on upgrade.
+
+Existing files are not overwritten unless --force is given.`,
+	Example: `  sudo kproxy policy init
+  kproxy policy init ./policies --tests`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runPolicyInit,
+}
+
+func init() {
+	policyInitCmd.Flags().BoolVar(&policyInitForce, "force", false, "Overwrite existing policy files")
+	policyInitCmd.Flags().BoolVar(&policyInitTests, "tests", false, "Also write the policy tests (*_test.rego, for opa test)")
+
+	policyCmd.AddCommand(policyInitCmd)
+	rootCmd.AddCommand(policyCmd)
+}
+
+func runPolicyInit(cmd *cobra.Command, args []string) error {
+	dir := ""
+	if len(args) > 0 {
+		dir = args[0]
+	} else {
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to load configuration (or give the directory): %w", err)
+		}
+		dir = cfg.Policy.OPAPolicyDir
+	}
+
+	files, err := policies.Files(policyInitTests)
+	if err != nil {
+		return err
+	}
+
+	if !policyInitForce {
+		var existing []string
+		for _, name := range files {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				existing = append(existing, name)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("%s already has %s; use --force to overwrite", dir, strings.Join(existing, ", "))
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range files {
+		content, err := policies.FS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return err
+		}
+	}
+
+	if _, err := opa.NewEngine(opa.Config{Source: "filesystem", PolicyDir: dir, HTTPTimeout: 30 * time.Second}, zerolog.Nop()); err != nil {
+		return fmt.Errorf("written policies don't compile: %w", err)
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Wrote ")
+	fmt.Printf("%d policy files to %s\n", len(files), dir)
+	fmt.Printf("Next: add your devices and profiles to %s (see docs/policy-tutorial.md),\n", filepath.Join(dir, "config.rego"))
+	fmt.Println("then try them with kproxy check and start or reload KProxy.")
+	return nil
+}
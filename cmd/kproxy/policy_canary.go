@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var policyCanaryCmd = &cobra.Command{
+	Use:   "canary",
+	Short: "Show how candidate policies differ from the active ones",
+	Long: `Show how the candidate policies of a running KProxy (policy.opa_candidate_policy_dir,
+opa_candidate_policy_urls with the remote source, or opa_candidate_bundle_url
+with the bundle source) differ from the active
+ones on live traffic. Every DNS and proxy decision is evaluated again by
+the candidate, which is never enforced; the decisions that differ are
+counted by action and rule (the matched rule ID for proxy decisions, the
+reason for DNS decisions), with the last domain or host seen.
+
+Counts start afresh whenever the policies are reloaded. Once the
+candidate only differs where intended, copy it over the active policies.`,
+	Example: `  kproxy policy canary`,
+	Args:    cobra.NoArgs,
+	RunE:    runPolicyCanary,
+}
+
+func init() {
+	policyCanaryCmd.Flags().StringVar(&policyAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+	policyCmd.AddCommand(policyCanaryCmd)
+}
+
+func runPolicyCanary(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(policyAdminURL)
+	if err != nil {
+		return err
+	}
+
+	report, err := api.Canary(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	red := color.New(color.FgRed)
+	green := color.New(color.FgGreen)
+
+	fmt.Printf("Candidate: %s\n", report.Candidate)
+	fmt.Printf("Since:     %s (%s)\n", report.Since.Local().Format("2006-01-02 15:04:05"), time.Since(report.Since).Round(time.Second))
+	fmt.Printf("Decisions: %d evaluated, ", report.Evaluated)
+	if report.Diverged == 0 {
+		_, _ = green.Print("none differ")
+	} else {
+		_, _ = red.Printf("%d differ", report.Diverged)
+	}
+	fmt.Println()
+	if report.Skipped > 0 || report.Failed > 0 {
+		fmt.Printf("           %d skipped (queue full), %d failed to evaluate\n", report.Skipped, report.Failed)
+	}
+	if len(report.Divergences) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	_, _ = cyan.Printf("%-6s %-8s %-30s %-8s %-30s %-8s %s\n", "KIND", "ACTIVE", "RULE", "CANARY", "RULE", "COUNT", "LAST")
+	for _, d := range report.Divergences {
+		fmt.Printf("%-6s %-8s %-30s %-8s %-30s %-8d %s\n", d.Kind,
+			d.ActiveAction, orNone(d.ActiveRule), d.CandidateAction, orNone(d.CandidateRule), d.Count, d.Example)
+	}
+	return nil
+}
+
+// orNone shows an empty rule as "-"
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
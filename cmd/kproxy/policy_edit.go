@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/policyedit"
+	"github.com/spf13/cobra"
+)
+
+var (
+	policyAdminURL string
+	policyEditFile string
+	policyDryRun   bool
+	policyYes      bool
+)
+
+var policyListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the policy files of a running KProxy",
+	Args:    cobra.NoArgs,
+	RunE:    runPolicyList,
+}
+
+var policyShowCmd = &cobra.Command{
+	Use:     "show <file>",
+	Short:   "Print a policy file of a running KProxy",
+	Example: `  kproxy policy show config.rego`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runPolicyShow,
+}
+
+var policyEditCmd = &cobra.Command{
+	Use:   "edit <file>",
+	Short: "Edit a policy file of a running KProxy",
+	Long: `Edit a policy file of a running KProxy through its admin API (admin.enabled
+must be set, with the filesystem policy source). The file opens in $VISUAL
+or $EDITOR (vi by default); when the editor exits, the change is compiled
+with the rest of the policies on the server and its diff is shown before
+you confirm. Saving reloads the policies. A change that doesn't compile is
+never saved.
+
+--file sends a local file instead of opening an editor, for scripts.
+Naming a file that doesn't exist creates it.`,
+	Example: `  kproxy policy edit config.rego
+  kproxy policy edit config.rego --file ./config.rego --dry-run
+  kproxy policy edit config.rego --file ./config.rego --yes`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPolicyEdit,
+}
+
+func init() {
+	policyEditCmd.Flags().StringVar(&policyEditFile, "file", "", "Send this local file instead of opening an editor")
+	policyEditCmd.Flags().BoolVar(&policyDryRun, "dry-run", false, "Only compile the change and show its diff")
+	policyEditCmd.Flags().BoolVarP(&policyYes, "yes", "y", false, "Save without asking for confirmation")
+	for _, cmd := range []*cobra.Command{policyListCmd, policyShowCmd, policyEditCmd} {
+		cmd.Flags().StringVar(&policyAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+		policyCmd.AddCommand(cmd)
+	}
+}
+
+func runPolicyList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(policyAdminURL)
+	if err != nil {
+		return err
+	}
+
+	files, err := api.Policies(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-24s %8s  %s\n", "FILE", "SIZE", "MODIFIED")
+	for _, f := range files {
+		fmt.Printf("%-24s %8d  %s\n", f.Name, f.Size, f.Modified.Local().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+func runPolicyShow(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(policyAdminURL)
+	if err != nil {
+		return err
+	}
+
+	file, err := api.Policy(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Print(file.Content)
+	return nil
+}
+
+func runPolicyEdit(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	api, err := newAdminClient(policyAdminURL)
+	if err != nil {
+		return err
+	}
+
+	// Start from the server's copy, or an empty file for a new one
+	files, err := api.Policies(cmd.Context())
+	if err != nil {
+		return err
+	}
+	var current policyedit.File
+	for _, f := range files {
+		if f.Name == name {
+			if current, err = api.Policy(cmd.Context(), name); err != nil {
+				return err
+			}
+		}
+	}
+
+	content := current.Content
+	var editing string
+	if policyEditFile != "" {
+		data, err := os.ReadFile(policyEditFile)
+		if err != nil {
+			return err
+		}
+		content = string(data)
+	} else {
+		tmp, err := os.CreateTemp("", "kproxy-*-"+filepath.Base(name))
+		if err != nil {
+			return err
+		}
+		editing = tmp.Name()
+		_ = tmp.Close()
+		if err := os.WriteFile(editing, []byte(content), 0600); err != nil {
+			return err
+		}
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	var result policyedit.Result
+	for {
+		if editing != "" {
+			if err := runEditor(editing); err != nil {
+				return err
+			}
+			data, err := os.ReadFile(editing)
+			if err != nil {
+				return err
+			}
+			content = string(data)
+		}
+
+		var err error
+		result, err = api.PutPolicy(cmd.Context(), name, admin.PolicyRequest{Content: content, DryRun: true})
+		if err == nil {
+			break
+		}
+		_, _ = color.New(color.FgRed).Println(err)
+		if editing == "" || !confirm(stdin, "Edit again? [Y/n] ", true) {
+			if editing != "" {
+				fmt.Printf("Your changes are in %s\n", editing)
+			}
+			return fmt.Errorf("%s not saved", name)
+		}
+	}
+	if editing != "" {
+		defer func() { _ = os.Remove(editing) }()
+	}
+
+	if result.Diff == "" {
+		fmt.Println("No changes")
+		return nil
+	}
+	printDiff(result.Diff)
+	if policyDryRun {
+		_, _ = color.New(color.FgGreen, color.Bold).Print("Compiles ")
+		fmt.Println("(dry run, not saved)")
+		return nil
+	}
+	if !policyYes && !confirm(stdin, "Save and reload policies? [y/N] ", false) {
+		return fmt.Errorf("%s not saved", name)
+	}
+
+	_, err = api.PutPolicy(cmd.Context(), name, admin.PolicyRequest{Content: content})
+	if printHeld(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Saved ")
+	fmt.Printf("%s and reloaded policies\n", name)
+	return nil
+}
+
+// runEditor opens path in $VISUAL or $EDITOR
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// The editor may carry arguments, e.g. "code --wait"
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %w", editor, err)
+	}
+	return nil
+}
+
+// confirm asks a yes/no question, returning def for an empty answer
+func confirm(in *bufio.Reader, prompt string, def bool) bool {
+	fmt.Print(prompt)
+	answer, err := in.ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "":
+		return def
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// printDiff prints a unified diff with added lines in green and removed
+// lines in red
+func printDiff(diff string) {
+	green := color.New(color.FgGreen)
+	red := color.New(color.FgRed)
+	cyan := color.New(color.FgCyan)
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			fmt.Print(line)
+		case strings.HasPrefix(line, "@@"):
+			_, _ = cyan.Print(line)
+		case strings.HasPrefix(line, "+"):
+			_, _ = green.Print(line)
+		case strings.HasPrefix(line, "-"):
+			_, _ = red.Print(line)
+		default:
+			fmt.Print(line)
+		}
+	}
+}
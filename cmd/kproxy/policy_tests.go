@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/open-policy-agent/opa/v1/tester"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	policyTestFormat  string
+	policyTestRun     string
+	policyTestVerbose bool
+	policyTestOutput  string
+)
+
+var policyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Run the policy tests against the configured policy source",
+	Long: `Run the policy tests (test_ rules, as in the *_test.rego files shipped with
+KProxy) among the policies loaded from the configured source, as opa test
+does: the policy directory, the remote URLs or the signed bundle. Run it
+before reloading, from CI or after editing config.rego, so that a change
+which breaks the policies is caught before it breaks the network.
+
+Tests must be loaded with the policies: in the policy directory, listed in
+policy.opa_policy_urls, or packed in the bundle. The command fails when a
+test fails, when the policies don't compile, or when no tests are found.
+
+The pretty format is for the terminal; json matches opa test --format json,
+and junit is for CI test reports.`,
+	Example: `  kproxy policy test
+  kproxy policy test --run 'kproxy.proxy_test' -v
+  kproxy policy test --format junit -o policy-tests.xml`,
+	Args: cobra.NoArgs,
+	RunE: runPolicyTest,
+}
+
+func init() {
+	policyTestCmd.Flags().StringVar(&policyTestFormat, "format", "pretty", "Output format: pretty, json or junit")
+	policyTestCmd.Flags().StringVar(&policyTestRun, "run", "", "Only run tests whose package and name match this regular expression")
+	policyTestCmd.Flags().BoolVarP(&policyTestVerbose, "verbose", "v", false, "Show every test and its print output (pretty format)")
+	policyTestCmd.Flags().StringVarP(&policyTestOutput, "output", "o", "", "Write the results to this file instead of stdout")
+	policyCmd.AddCommand(policyTestCmd)
+}
+
+func runPolicyTest(cmd *cobra.Command, args []string) error {
+	if policyTestFormat != "pretty" && policyTestFormat != "json" && policyTestFormat != "junit" {
+		return fmt.Errorf("invalid --format: %s (use pretty, json or junit)", policyTestFormat)
+	}
+	// Failing tests aren't a usage error
+	cmd.SilenceUsage = true
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Create a quiet logger for test mode
+	logger := zerolog.New(os.Stderr).Level(zerolog.ErrorLevel).With().Timestamp().Logger()
+	results, err := opa.RunTests(context.Background(), newOPAConfig(cfg), policyTestRun, logger)
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no policy tests found (tests are test_ rules loaded with the policies)")
+	}
+
+	out := io.Writer(os.Stdout)
+	if policyTestOutput != "" {
+		f, err := os.Create(policyTestOutput)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		out = f
+	}
+
+	ch := make(chan *tester.Result, len(results))
+	for _, result := range results {
+		ch <- result
+	}
+	close(ch)
+
+	switch policyTestFormat {
+	case "json":
+		err = tester.JSONReporter{Output: out}.Report(ch)
+	case "junit":
+		err = writeJUnit(out, results)
+	default:
+		err = tester.PrettyReporter{Output: out, Verbose: policyTestVerbose, FailureLine: true}.Report(ch)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if !result.Pass() && !result.Skip {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d policy tests failed", failed, len(results))
+	}
+	return nil
+}
+
+// junitTestSuites is the root of a JUnit XML report, one suite per package
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes test results as a JUnit XML report
+func writeJUnit(w io.Writer, results []*tester.Result) error {
+	var report junitTestSuites
+	suites := map[string]*junitTestSuite{}
+	var order []string
+
+	for _, result := range results {
+		suite, ok := suites[result.Package]
+		if !ok {
+			suite = &junitTestSuite{Name: result.Package}
+			suites[result.Package] = suite
+			order = append(order, result.Package)
+		}
+
+		tc := junitTestCase{
+			Name:      result.Name,
+			ClassName: result.Package,
+			Time:      result.Duration.Seconds(),
+			SystemOut: string(result.Output),
+		}
+		if result.Location != nil {
+			tc.File = result.Location.File
+		}
+		switch {
+		case result.Skip:
+			tc.Skipped = &junitMessage{}
+			suite.Skipped++
+		case result.Error != nil:
+			tc.Error = &junitMessage{Message: result.Error.Error()}
+			suite.Errors++
+		case result.Fail:
+			failure := &junitMessage{Message: "test failed"}
+			if result.Location != nil {
+				failure.Text = result.Location.String()
+			}
+			if result.FailedAt != nil {
+				failure.Message = "failed at " + result.FailedAt.String()
+			}
+			tc.Failure = failure
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.Time += tc.Time
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	sort.Strings(order)
+	for _, name := range order {
+		suite := suites[name]
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Time += suite.Time
+		report.Suites = append(report.Suites, *suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var (
+	probesAdminURL string
+	probesSince    time.Duration
+	probesResults  bool
+)
+
+var probesCmd = &cobra.Command{
+	Use:   "probes",
+	Short: "Show internet connectivity history",
+	Long: `Show the connectivity probe history of a running KProxy (probes.enabled
+and admin.enabled must be set): uptime and latency per probe target, and
+the outages seen, with the phase they failed in ("dns" when the upstream
+DNS servers didn't answer, "http" when the target couldn't be reached).
+
+An outage recorded by the probes means the internet was down; a site that
+failed while the probes succeeded was blocked or down itself.`,
+	Example: `  kproxy probes
+  kproxy probes --since 6h --results`,
+	Args: cobra.NoArgs,
+	RunE: runProbes,
+}
+
+func init() {
+	probesCmd.Flags().StringVar(&probesAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+	probesCmd.Flags().DurationVar(&probesSince, "since", 0, "Only show this recent period, e.g. 6h (default: all kept history)")
+	probesCmd.Flags().BoolVar(&probesResults, "results", false, "List every probe result")
+
+	rootCmd.AddCommand(probesCmd)
+}
+
+func runProbes(cmd *cobra.Command, args []string) error {
+	if probesSince < 0 {
+		return fmt.Errorf("invalid --since: %s", probesSince)
+	}
+
+	api, err := newAdminClient(probesAdminURL)
+	if err != nil {
+		return err
+	}
+
+	h, err := api.Probes(cmd.Context(), probesSince)
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	red := color.New(color.FgRed)
+	green := color.New(color.FgGreen)
+
+	for _, s := range h.Summaries {
+		_, _ = cyan.Println(s.Target)
+		if s.Probes == 0 {
+			fmt.Println("  (no probes yet)")
+			continue
+		}
+		fmt.Printf("  Uptime:  %.1f%% (%d of %d probes failed)\n", s.Uptime, s.Failures, s.Probes)
+		fmt.Printf("  Latency: %d ms average\n", s.AvgLatencyMS)
+		if s.Last != nil {
+			fmt.Print("  Last:    ")
+			if s.Last.OK {
+				_, _ = green.Print("OK")
+			} else {
+				_, _ = red.Print("FAILED")
+			}
+			fmt.Printf(" at %s\n", s.Last.Time.Local().Format("2006-01-02 15:04:05"))
+		}
+		for _, o := range s.Outages {
+			end := "ongoing"
+			if o.End != nil {
+				end = o.End.Local().Format("15:04:05") + " (" + o.End.Sub(o.Start).Round(time.Second).String() + ")"
+			}
+			_, _ = red.Printf("  Outage:  %s - %s, %s: %s\n", o.Start.Local().Format("2006-01-02 15:04:05"), end, o.Phase, o.Error)
+		}
+	}
+
+	if probesResults {
+		fmt.Println()
+		_, _ = cyan.Printf("%-19s %-6s %-6s %-8s %-10s %s\n", "TIME", "OK", "DNS", "LATENCY", "KBIT/S", "TARGET")
+		for _, r := range h.Results {
+			ok := "yes"
+			if !r.OK {
+				ok = "no"
+			}
+			fmt.Printf("%-19s %-6s %-6s %-8s %-10.0f %s", r.Time.Local().Format("2006-01-02 15:04:05"), ok,
+				fmt.Sprintf("%dms", r.DNSMS), fmt.Sprintf("%dms", r.LatencyMS), r.KbitPerSec, r.Target)
+			if r.Error != "" {
+				fmt.Printf(" (%s: %s)", r.Phase, r.Error)
+			}
+			fmt.Println()
+		}
+	}
+	return nil
+}
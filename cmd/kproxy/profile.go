@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/spf13/cobra"
+)
+
+var profileAdminURL string
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Inspect profiles",
+	Long: `List and show the profiles of a running KProxy through its admin API
+(admin.enabled must be set): the devices with each profile, the rule sets
+attached to it, its runtime rules and whether it is paused. Profiles are
+defined in config.rego; assign them to devices with kproxy device assign.`,
+}
+
+var profileListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List profiles with their devices",
+	Example: `  kproxy profile list`,
+	Args:    cobra.NoArgs,
+	RunE:    runProfileList,
+}
+
+var profileShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show a profile and its weekly schedule",
+	Long: `Show a profile's devices, rule sets, runtime rules and pause, and its
+weekly schedule per category: one row per day, one column per hour.`,
+	Example: `  kproxy profile show child`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runProfileShow,
+}
+
+func init() {
+	profileCmd.PersistentFlags().StringVar(&profileAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	profileCmd.AddCommand(profileListCmd)
+	profileCmd.AddCommand(profileShowCmd)
+	rootCmd.AddCommand(profileCmd)
+}
+
+func runProfileList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(profileAdminURL)
+	if err != nil {
+		return err
+	}
+
+	list, err := api.Profiles(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	yellow := color.New(color.FgYellow)
+	_, _ = cyan.Printf("%-16s %-8s %-6s %-24s %s\n", "PROFILE", "DEVICES", "RULES", "RULE SETS", "STATUS")
+	for _, p := range list {
+		ruleSets := strings.Join(p.RuleSets, ",")
+		if ruleSets == "" {
+			ruleSets = "-"
+		}
+		fmt.Printf("%-16s %-8d %-6d %-24s ", p.ID, len(p.Devices), p.Rules, ruleSets)
+		if p.Paused != nil {
+			_, _ = yellow.Println("paused")
+		} else {
+			fmt.Println("active")
+		}
+	}
+	if len(list) == 0 {
+		fmt.Println("(no profiles)")
+	}
+	return nil
+}
+
+func runProfileShow(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(profileAdminURL)
+	if err != nil {
+		return err
+	}
+
+	p, err := api.Profile(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	field := func(name, value string) {
+		if value == "" {
+			value = "-"
+		}
+		_, _ = cyan.Printf("%-10s ", name+":")
+		fmt.Println(value)
+	}
+	field("Profile", p.ID)
+	field("Devices", strings.Join(p.Devices, ", "))
+	field("Rule sets", strings.Join(p.RuleSets, ", "))
+	status := "active"
+	if p.Paused != nil {
+		status = "paused"
+		if p.Paused.Until != nil {
+			status += " until " + p.Paused.Until.Local().Format("2006-01-02 15:04")
+		}
+	}
+	field("Status", status)
+
+	if len(p.RuntimeRules) > 0 {
+		fmt.Println()
+		_, _ = cyan.Printf("%-12s %-8s %-32s %s\n", "RULE", "ACTION", "DOMAIN", "UNTIL")
+		for _, rule := range p.RuntimeRules {
+			until := "-"
+			if rule.Until != nil {
+				until = rule.Until.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%-12s %-8s %-32s %s\n", rule.ID, rule.Action, rule.Domain, until)
+		}
+	}
+
+	if p.Schedule != nil {
+		printSchedule(p.Schedule)
+	}
+	return nil
+}
+
+// scheduleCells draws the cell values of a schedule projection
+var scheduleCells = map[string]struct {
+	mark  string
+	color *color.Color
+}{
+	"ALLOW":   {"#", color.New(color.FgGreen)},
+	"LIMITED": {"L", color.New(color.FgCyan)},
+	"PARTIAL": {"~", color.New(color.FgYellow)},
+	"BLOCK":   {".", color.New(color.FgRed)},
+}
+
+// printSchedule draws a profile's weekly schedule, one grid per category
+func printSchedule(schedule *opa.Schedule) {
+	cyan := color.New(color.FgCyan, color.Bold)
+	for _, category := range schedule.Categories {
+		fmt.Println()
+		_, _ = cyan.Printf("%-10s ", category)
+		for hour := 0; hour < 24; hour += 6 {
+			fmt.Printf("%-6d", hour)
+		}
+		fmt.Println()
+		for day, hours := range schedule.Schedule[category] {
+			fmt.Printf("%-10s ", time.Weekday(day).String()[:3])
+			for _, action := range hours {
+				cell, ok := scheduleCells[action]
+				if !ok {
+					fmt.Print("?")
+					continue
+				}
+				_, _ = cell.color.Print(cell.mark)
+			}
+			fmt.Println()
+		}
+	}
+	fmt.Println()
+	fmt.Println("# allowed  L allowed up to a daily limit  ~ part of the hour  . blocked")
+}
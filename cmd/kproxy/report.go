@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/report"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportAdminURL string
+	reportProfile  string
+	reportEnd      string
+	reportFormat   string
+	reportOutput   string
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Show usage reports",
+}
+
+var reportWeeklyCmd = &cobra.Command{
+	Use:   "weekly",
+	Short: "Show a profile's weekly report",
+	Long: `Show a profile's report for the seven days ending on --end (default today)
+from a running KProxy (admin.enabled must be set): usage by category, top
+sites, blocks, and how the profile's time rules were kept. Traffic through
+the proxy is counted per profile and day and kept for five weeks; devices
+not identified by policy are not counted.
+
+The text format is a summary for the terminal. The html format is a
+standalone page for emailing or printing to PDF from a browser; json is
+for other tools.`,
+	Example: `  kproxy report weekly --profile child
+  kproxy report weekly --profile child --end 2026-03-01 --format html -o child.html`,
+	Args: cobra.NoArgs,
+	RunE: runReportWeekly,
+}
+
+func init() {
+	reportWeeklyCmd.Flags().StringVar(&reportProfile, "profile", "", "Profile to report on")
+	reportWeeklyCmd.Flags().StringVar(&reportEnd, "end", "", "Last day of the week, YYYY-MM-DD (default: today)")
+	reportWeeklyCmd.Flags().StringVar(&reportFormat, "format", "text", "Output format: text, html or json")
+	reportWeeklyCmd.Flags().StringVarP(&reportOutput, "output", "o", "", "Write the report to this file instead of stdout")
+	_ = reportWeeklyCmd.MarkFlagRequired("profile")
+	reportCmd.PersistentFlags().StringVar(&reportAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	reportCmd.AddCommand(reportWeeklyCmd)
+	rootCmd.AddCommand(reportCmd)
+}
+
+func runReportWeekly(cmd *cobra.Command, args []string) error {
+	if reportFormat != "text" && reportFormat != "html" && reportFormat != "json" {
+		return fmt.Errorf("invalid --format: %s (use text, html or json)", reportFormat)
+	}
+
+	api, err := newAdminClient(reportAdminURL)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	switch reportFormat {
+	case "html", "json":
+		out, err = api.WeeklyReportFile(cmd.Context(), reportProfile, reportEnd, reportFormat)
+		if err != nil {
+			return err
+		}
+	default:
+		weekly, err := api.WeeklyReport(cmd.Context(), reportProfile, reportEnd)
+		if err != nil {
+			return err
+		}
+		if reportOutput == "" {
+			printWeekly(&weekly)
+			return nil
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Weekly report: %s (%s to %s)\n", weekly.Profile, weekly.Start, weekly.End)
+		writeWeekly(&b, &weekly)
+		out = []byte(b.String())
+	}
+
+	if reportOutput == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	if err := os.WriteFile(reportOutput, out, 0644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Saved ")
+	fmt.Printf("%s report for %s to %s\n", reportFormat, reportProfile, reportOutput)
+	return nil
+}
+
+// printWeekly prints a report summary with colored headings
+func printWeekly(w *report.Weekly) {
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("Weekly report: %s (%s to %s)\n", w.Profile, w.Start, w.End)
+	var b strings.Builder
+	writeWeekly(&b, w)
+	fmt.Print(b.String())
+}
+
+// writeWeekly writes a plain text report summary
+func writeWeekly(b *strings.Builder, w *report.Weekly) {
+	if w.Empty {
+		b.WriteString("No traffic was recorded for this profile during the week.\n")
+		return
+	}
+
+	fmt.Fprintf(b, "\n%d allowed, %d blocked, %d minutes of use\n", w.Requests, w.Blocked, w.Minutes)
+	fmt.Fprintf(b, "Within allowed hours on %d of %d days; %d attempts outside hours, %d usage limit blocks, %d stream limit blocks\n",
+		w.Compliance.DaysWithinHours, len(w.Days), w.Compliance.OutsideHoursAttempts, w.Compliance.UsageLimitBlocks, w.Compliance.StreamLimitBlocks)
+
+	fmt.Fprintf(b, "\n%-14s %8s %8s %8s %8s\n", "DAY", "ALLOWED", "BLOCKED", "MINUTES", "OUTSIDE")
+	for _, d := range w.Days {
+		fmt.Fprintf(b, "%-3.3s %-10s %8d %8d %8d %8d\n", d.Weekday, d.Date, d.Requests, d.Blocked, d.Minutes, d.OutsideHours)
+	}
+
+	fmt.Fprintf(b, "\n%-20s %8s %8s %8s\n", "CATEGORY", "MINUTES", "ALLOWED", "BLOCKED")
+	for _, c := range w.Categories {
+		fmt.Fprintf(b, "%-20s %8d %8d %8d\n", c.Category, c.Minutes, c.Requests, c.Blocked)
+	}
+
+	writeCounts(b, "TOP SITES", w.TopSites)
+	writeCounts(b, "TOP BLOCKED", w.TopBlocked)
+	writeCounts(b, "BLOCKED BY", w.BlockKinds)
+}
+
+// writeCounts writes a list of hosts or block pages with their counts
+func writeCounts(b *strings.Builder, heading string, counts []report.Count) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n%-40s %8s\n", heading, "COUNT")
+	for _, c := range counts {
+		fmt.Fprintf(b, "%-40s %8d\n", c.Name, c.Count)
+	}
+}
@@ -29,6 +29,7 @@ server for home network parental controls. It uses fact-based Open Policy Agent
 func init() {
 	// Global flags
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "/etc/kproxy/config.yaml", "Path to configuration file")
+	_ = rootCmd.MarkPersistentFlagFilename("config", "yaml", "yml")
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/rules"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ruleProfile  string
+	ruleDomain   string
+	ruleAction   string
+	ruleUntil    string
+	ruleAdminURL string
+	ruleFormat   string
+	ruleDryRun   bool
+)
+
+var ruleCmd = &cobra.Command{
+	Use:     "rule",
+	Aliases: []string{"rules"},
+	Short:   "Manage runtime rules",
+	Long: `Add, list and remove rules on a running KProxy through its admin API
+(admin.enabled must be set). Runtime rules apply to one profile and take
+precedence over its rules in config.rego. They are kept in memory, so they
+end at their expiry, when removed, or when KProxy restarts; permanent rules
+belong in config.rego.`,
+}
+
+var ruleAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a rule for a profile",
+	Example: `  kproxy rule add --profile kids --domain .roblox.com --action allow --until 21:00
+  kproxy rule add --profile kids --domain tiktok.com --action block --until 2h
+  kproxy rule add --profile adults --domain .bank.example.com --action bypass`,
+	Args: cobra.NoArgs,
+	RunE: runRuleAdd,
+}
+
+var ruleListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List runtime rules",
+	Args:    cobra.NoArgs,
+	RunE:    runRuleList,
+}
+
+var ruleRmCmd = &cobra.Command{
+	Use:     "rm <id>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a runtime rule",
+	Example: `  kproxy rule rm runtime-3`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    runRuleRm,
+}
+
+var ruleExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the configuration and runtime state",
+	Long: `Write the data in config.rego (devices, users, profiles with their rules,
+time restrictions and usage limits, rule sets, subnet profiles and bypass
+domains) and the runtime rules, device rules, devices, assignments and rule
+sets to stdout, to keep as a backup or import into another KProxy.`,
+	Example: `  kproxy rules export > rules.yaml
+  kproxy rules export --format json > rules.json`,
+	Args: cobra.NoArgs,
+	RunE: runRuleExport,
+}
+
+var ruleImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import an export",
+	Long: `Import a file written by kproxy rules export (YAML or JSON). Each
+config.rego section in it replaces that section, and its runtime rules,
+devices, assignments and rule sets are added or replaced by ID; nothing
+else is removed. Expired rules are skipped.`,
+	Example: `  kproxy rules import rules.yaml --dry-run
+  kproxy rules import rules.yaml`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRuleImport,
+}
+
+func init() {
+	ruleAddCmd.Flags().StringVar(&ruleProfile, "profile", "", "Profile the rule applies to")
+	ruleAddCmd.Flags().StringVar(&ruleDomain, "domain", "", "Domain pattern, as in config.rego (e.g. .roblox.com)")
+	ruleAddCmd.Flags().StringVar(&ruleAction, "action", "", "Rule action: allow, block or bypass")
+	ruleAddCmd.Flags().StringVar(&ruleUntil, "until", "", "When the rule ends: a time of day (21:00), a duration (2h) or an RFC 3339 time (default: until removed)")
+	_ = ruleAddCmd.MarkFlagRequired("profile")
+	_ = ruleAddCmd.MarkFlagRequired("domain")
+	_ = ruleAddCmd.MarkFlagRequired("action")
+	ruleListCmd.Flags().StringVar(&ruleProfile, "profile", "", "Only show rules for this profile")
+	ruleExportCmd.Flags().StringVar(&ruleFormat, "format", "yaml", "Output format: yaml or json")
+	ruleImportCmd.Flags().BoolVar(&ruleDryRun, "dry-run", false, "Check the file and show the changes without making them")
+	ruleCmd.PersistentFlags().StringVar(&ruleAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	ruleCmd.AddCommand(ruleAddCmd)
+	ruleCmd.AddCommand(ruleListCmd)
+	ruleCmd.AddCommand(ruleRmCmd)
+	ruleCmd.AddCommand(ruleExportCmd)
+	ruleCmd.AddCommand(ruleImportCmd)
+	rootCmd.AddCommand(ruleCmd)
+}
+
+func runRuleAdd(cmd *cobra.Command, args []string) error {
+	req := admin.RuleRequest{
+		Profile: ruleProfile,
+		Domain:  ruleDomain,
+		Action:  strings.ToLower(ruleAction),
+	}
+	switch req.Action {
+	case rules.ActionAllow, rules.ActionBlock, rules.ActionBypass:
+	default:
+		return fmt.Errorf("invalid action: %s (must be allow, block or bypass)", ruleAction)
+	}
+	if ruleUntil != "" {
+		until, err := parseUntil(ruleUntil, time.Now())
+		if err != nil {
+			return err
+		}
+		req.Until = &until
+	}
+
+	api, err := newAdminClient(ruleAdminURL)
+	if err != nil {
+		return err
+	}
+
+	rule, err := api.AddRule(cmd.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Added ")
+	fmt.Printf("%s: %s %s for profile %s", rule.ID, rule.Action, rule.Domain, rule.Profile)
+	if rule.Until != nil {
+		fmt.Printf(" until %s", rule.Until.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Println()
+	return nil
+}
+
+func runRuleList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(ruleAdminURL)
+	if err != nil {
+		return err
+	}
+
+	list, err := api.Rules(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-12s %-12s %-8s %-32s %s\n", "ID", "PROFILE", "ACTION", "DOMAIN", "UNTIL")
+
+	shown := 0
+	for _, rule := range list {
+		if ruleProfile != "" && rule.Profile != ruleProfile {
+			continue
+		}
+		until := "-"
+		if rule.Until != nil {
+			until = rule.Until.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-12s %-12s %-8s %-32s %s\n", rule.ID, rule.Profile, rule.Action, rule.Domain, until)
+		shown++
+	}
+	if shown == 0 {
+		fmt.Println("(no runtime rules)")
+	}
+	return nil
+}
+
+func runRuleRm(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(ruleAdminURL)
+	if err != nil {
+		return err
+	}
+
+	err = api.RemoveRule(cmd.Context(), args[0])
+	if printHeld(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Removed ")
+	fmt.Println(args[0])
+	return nil
+}
+
+func runRuleExport(cmd *cobra.Command, args []string) error {
+	if ruleFormat != "yaml" && ruleFormat != "json" {
+		return fmt.Errorf("invalid --format: %s (must be yaml or json)", ruleFormat)
+	}
+
+	api, err := newAdminClient(ruleAdminURL)
+	if err != nil {
+		return err
+	}
+
+	out, err := api.ExportFile(cmd.Context(), ruleFormat)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func runRuleImport(cmd *cobra.Command, args []string) error {
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", args[0], err)
+	}
+	export, err := admin.ParseExport(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	api, err := newAdminClient(ruleAdminURL)
+	if err != nil {
+		return err
+	}
+
+	result, err := api.Import(cmd.Context(), export, ruleDryRun)
+	if printHeld(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(export.Policy) > 0 {
+		printPlan(result.Policy)
+	}
+	verb := "Imported"
+	if result.DryRun {
+		verb = "Would import"
+	}
+	_, _ = color.New(color.FgGreen, color.Bold).Printf("%s ", verb)
+	fmt.Printf("%d rules, %d device rules, %d devices, %d assignments, %d rule sets\n",
+		result.Rules, result.DeviceRules, result.Devices, result.Assignments, result.RuleSets)
+	return nil
+}
+
+// parseUntil turns --until into a time: a time of day is the next time the
+// clock shows it, a duration is counted from now
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("15:04", value, now.Location()); err == nil {
+		until := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !until.After(now) {
+			until = until.AddDate(0, 0, 1)
+		}
+		return until, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("invalid --until: %s", value)
+		}
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --until: %s (use a time of day like 21:00, a duration like 2h, or an RFC 3339 time)", value)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ruleSetFile     string
+	ruleSetName     string
+	ruleSetProfiles []string
+	ruleSetAdminURL string
+)
+
+var ruleSetCmd = &cobra.Command{
+	Use:   "ruleset",
+	Short: "Manage rule sets shared by several profiles",
+	Long: `Save, list, attach and remove rule sets on a running KProxy through its
+admin API (admin.enabled must be set). A rule set is a named collection of
+rules, such as "Social Media" or "Gaming", that applies to every profile it
+is attached to, after the profile's own rules. Rule sets are kept in
+storage (storage.type), so with Redis they survive restarts.
+
+The file given to "kproxy ruleset set" is YAML (or JSON) with the rules in
+the same shape as a profile's rules in config.rego:
+
+  name: Social Media
+  profiles: [child, teen]
+  rules:
+    - id: block-tiktok
+      domains: [.tiktok.com]
+      action: block
+      category: social
+
+Rule sets can also be written in config.rego (rule_sets); a rule set saved
+here replaces the configured one with the same ID.`,
+}
+
+var ruleSetListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List rule sets",
+	Args:    cobra.NoArgs,
+	RunE:    runRuleSetList,
+}
+
+var ruleSetShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Print a rule set as YAML",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runRuleSetShow,
+}
+
+var ruleSetSetCmd = &cobra.Command{
+	Use:   "set <id>",
+	Short: "Create or replace a rule set from a file",
+	Example: `  kproxy ruleset set social-media --file social-media.yaml
+  kproxy ruleset set gaming --file gaming.yaml --name Gaming --profile child --profile teen`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRuleSetSet,
+}
+
+var ruleSetAssignCmd = &cobra.Command{
+	Use:   "assign <id> [profile...]",
+	Short: "Attach a rule set to exactly these profiles",
+	Long: `Attach a rule set to the listed profiles, replacing the profiles it was
+attached to. With no profiles the rule set is detached from all of them
+but kept.`,
+	Example: `  kproxy ruleset assign social-media child teen`,
+	Args:    cobra.MinimumNArgs(1),
+	RunE:    runRuleSetAssign,
+}
+
+var ruleSetRmCmd = &cobra.Command{
+	Use:     "rm <id>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a rule set",
+	Args:    cobra.ExactArgs(1),
+	RunE:    runRuleSetRm,
+}
+
+func init() {
+	ruleSetSetCmd.Flags().StringVar(&ruleSetFile, "file", "", "YAML or JSON rule set file")
+	ruleSetSetCmd.Flags().StringVar(&ruleSetName, "name", "", "Display name (overrides the file)")
+	ruleSetSetCmd.Flags().StringSliceVar(&ruleSetProfiles, "profile", nil, "Profile to attach the rule set to (repeatable, overrides the file)")
+	_ = ruleSetSetCmd.MarkFlagRequired("file")
+	ruleSetCmd.PersistentFlags().StringVar(&ruleSetAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	ruleSetCmd.AddCommand(ruleSetListCmd)
+	ruleSetCmd.AddCommand(ruleSetShowCmd)
+	ruleSetCmd.AddCommand(ruleSetSetCmd)
+	ruleSetCmd.AddCommand(ruleSetAssignCmd)
+	ruleSetCmd.AddCommand(ruleSetRmCmd)
+	rootCmd.AddCommand(ruleSetCmd)
+}
+
+func runRuleSetList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(ruleSetAdminURL)
+	if err != nil {
+		return err
+	}
+
+	list, err := api.RuleSets(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-20s %-24s %-6s %s\n", "ID", "NAME", "RULES", "PROFILES")
+	for _, set := range list {
+		profiles := strings.Join(set.Profiles, ",")
+		if profiles == "" {
+			profiles = "-"
+		}
+		fmt.Printf("%-20s %-24s %-6d %s\n", set.ID, set.Name, len(set.Rules), profiles)
+	}
+	if len(list) == 0 {
+		fmt.Println("(no rule sets)")
+	}
+	return nil
+}
+
+func runRuleSetShow(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(ruleSetAdminURL)
+	if err != nil {
+		return err
+	}
+
+	set, err := api.RuleSet(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	// Same shape as the file taken by "kproxy ruleset set"
+	out, err := yaml.Marshal(ruleSetFileFormat{
+		Name:        set.Name,
+		Description: set.Description,
+		Profiles:    set.Profiles,
+		Rules:       set.Rules,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(out))
+	return nil
+}
+
+// ruleSetFileFormat is the YAML file taken by "kproxy ruleset set"
+type ruleSetFileFormat struct {
+	Name        string                `yaml:"name"`
+	Description string                `yaml:"description,omitempty"`
+	Profiles    []string              `yaml:"profiles"`
+	Rules       []storage.RuleSetRule `yaml:"rules"`
+}
+
+func runRuleSetSet(cmd *cobra.Command, args []string) error {
+	data, err := os.ReadFile(ruleSetFile)
+	if err != nil {
+		return fmt.Errorf("failed to read rule set: %w", err)
+	}
+	var file ruleSetFileFormat
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s: %w", ruleSetFile, err)
+	}
+	set := storage.RuleSet{Name: file.Name, Description: file.Description, Profiles: file.Profiles, Rules: file.Rules}
+	if ruleSetName != "" {
+		set.Name = ruleSetName
+	}
+	if cmd.Flags().Changed("profile") {
+		set.Profiles = ruleSetProfiles
+	}
+
+	api, err := newAdminClient(ruleSetAdminURL)
+	if err != nil {
+		return err
+	}
+	saved, err := api.PutRuleSet(cmd.Context(), args[0], set)
+	if printHeld(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Saved ")
+	fmt.Printf("%s: %d rules", saved.ID, len(saved.Rules))
+	if len(saved.Profiles) > 0 {
+		fmt.Printf(" for %s", strings.Join(saved.Profiles, ", "))
+	}
+	fmt.Println()
+	return nil
+}
+
+func runRuleSetAssign(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(ruleSetAdminURL)
+	if err != nil {
+		return err
+	}
+
+	set, err := api.AttachRuleSet(cmd.Context(), args[0], args[1:])
+	if printHeld(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Assigned ")
+	if len(set.Profiles) == 0 {
+		fmt.Printf("%s to no profiles\n", set.ID)
+		return nil
+	}
+	fmt.Printf("%s to %s\n", set.ID, strings.Join(set.Profiles, ", "))
+	return nil
+}
+
+func runRuleSetRm(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(ruleSetAdminURL)
+	if err != nil {
+		return err
+	}
+
+	err = api.RemoveRuleSet(cmd.Context(), args[0])
+	if printHeld(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = color.New(color.FgGreen, color.Bold).Print("Removed ")
+	fmt.Println(args[0])
+	return nil
+}
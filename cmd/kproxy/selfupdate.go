@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/update"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	selfUpdateCheck     bool
+	selfUpdateForce     bool
+	selfUpdateAuto      bool
+	selfUpdateNoRestart bool
+)
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Install the latest signed release",
+	Long: `Check the configured release channel (update.url, update.channel) for a new
+release and install it. The channel manifest must be signed with the key in
+update.public_key and the downloaded binary must match the digest in the
+manifest; the running binary is then replaced atomically and
+update.systemd_unit is restarted.
+
+With --auto (used by kproxy-update.timer) the release is only installed when
+update.auto_update is enabled.`,
+	Example: `  kproxy self-update --check
+  kproxy self-update
+  kproxy self-update --force --no-restart`,
+	Args: cobra.NoArgs,
+	RunE: runSelfUpdate,
+}
+
+func init() {
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateCheck, "check", false, "Only report the current and available versions")
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateForce, "force", false, "Install the channel's release even if it is not newer")
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateAuto, "auto", false, "Only install when update.auto_update is enabled")
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateNoRestart, "no-restart", false, "Don't restart the systemd unit after installing")
+
+	rootCmd.AddCommand(selfUpdateCmd)
+}
+
+func runSelfUpdate(cmd *cobra.Command, args []string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	updater, err := update.New(update.Config{
+		Current:   version,
+		URL:       cfg.Update.URL,
+		Channel:   cfg.Update.Channel,
+		PublicKey: cfg.Update.PublicKey,
+	}, zerolog.Nop())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	rel, err := updater.Check(ctx)
+	if err != nil {
+		return fmt.Errorf("update check failed: %w", err)
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	green := color.New(color.FgGreen, color.Bold)
+	yellow := color.New(color.FgYellow, color.Bold)
+
+	newer := update.Newer(rel.Version, version)
+	_, _ = cyan.Print("Current:   ")
+	fmt.Println(version)
+	_, _ = cyan.Print("Available: ")
+	fmt.Printf("%s (%s channel)\n", rel.Version, cfg.Update.Channel)
+
+	if selfUpdateCheck {
+		if newer {
+			_, _ = yellow.Println("An update is available")
+		} else {
+			_, _ = green.Println("Up to date")
+		}
+		return nil
+	}
+
+	if !newer && !selfUpdateForce {
+		_, _ = green.Println("Up to date")
+		return nil
+	}
+	if selfUpdateAuto && !cfg.Update.AutoUpdate {
+		_, _ = yellow.Println("Automatic updates are disabled (update.auto_update); not installing")
+		return nil
+	}
+
+	path, err := update.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the kproxy binary: %w", err)
+	}
+
+	if err := updater.Apply(ctx, rel, path); err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	_, _ = green.Printf("Installed %s to %s\n", rel.Version, path)
+
+	if selfUpdateNoRestart || cfg.Update.SystemdUnit == "" {
+		return nil
+	}
+	if err := update.Restart(cfg.Update.SystemdUnit); err != nil {
+		return fmt.Errorf("installed %s but the restart failed: %w", rel.Version, err)
+	}
+	_, _ = green.Printf("Restarted %s\n", cfg.Update.SystemdUnit)
+
+	return nil
+}
@@ -1,46 +1,93 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/goodtune/kproxy/internal/acme"
+	"github.com/goodtune/kproxy/internal/access"
+	"github.com/goodtune/kproxy/internal/activity"
+	"github.com/goodtune/kproxy/internal/analytics"
+	"github.com/goodtune/kproxy/internal/apitoken"
+	"github.com/goodtune/kproxy/internal/approval"
+	"github.com/goodtune/kproxy/internal/backup"
+	"github.com/goodtune/kproxy/internal/arp"
+	"github.com/goodtune/kproxy/internal/blockpage"
+	"github.com/goodtune/kproxy/internal/clientcert"
+	"github.com/goodtune/kproxy/internal/admin"
 	"github.com/goodtune/kproxy/internal/ca"
+	"github.com/goodtune/kproxy/internal/clientnames"
 	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/devices"
 	"github.com/goodtune/kproxy/internal/dhcp"
 	"github.com/goodtune/kproxy/internal/dns"
+	"github.com/goodtune/kproxy/internal/extauthz"
+	"github.com/goodtune/kproxy/internal/fingerprint"
+	"github.com/goodtune/kproxy/internal/identity"
+	"github.com/goodtune/kproxy/internal/features"
+	"github.com/goodtune/kproxy/internal/maintenance"
 	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/mirror"
+	"github.com/goodtune/kproxy/internal/netboot"
+	"github.com/goodtune/kproxy/internal/pause"
 	"github.com/goodtune/kproxy/internal/policy"
 	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/goodtune/kproxy/internal/policyedit"
+	"github.com/goodtune/kproxy/internal/probe"
 	"github.com/goodtune/kproxy/internal/proxy"
+	"github.com/goodtune/kproxy/internal/reload"
+	"github.com/goodtune/kproxy/internal/report"
+	"github.com/goodtune/kproxy/internal/rules"
+	"github.com/goodtune/kproxy/internal/script"
+	"github.com/goodtune/kproxy/internal/searchwatch"
+	"github.com/goodtune/kproxy/internal/spool"
 	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/storage/bolt"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/goodtune/kproxy/internal/storage/postgres"
 	"github.com/goodtune/kproxy/internal/storage/redis"
 	"github.com/goodtune/kproxy/internal/systemd"
+	"github.com/goodtune/kproxy/internal/update"
 	"github.com/goodtune/kproxy/internal/usage"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+var (
+	serverDNSOnly bool
+)
+
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Start KProxy server",
-	Long:  `Start the KProxy server with DNS, DHCP (optional), HTTP/HTTPS proxy, and metrics endpoints.`,
+	Long: `Start the KProxy server with DNS, DHCP (optional), HTTP/HTTPS proxy, and metrics endpoints.
+
+With --dns-only (or server.mode: dns-only), KProxy runs purely as a filtering
+resolver: no HTTP/HTTPS proxy, no certificate authority and no usage tracking.`,
 	RunE:  runServer,
 }
 
 func init() {
+	serverCmd.Flags().BoolVar(&serverDNSOnly, "dns-only", false, "Run as a DNS-only filtering resolver (overrides server.mode)")
 	rootCmd.AddCommand(serverCmd)
 }
 
 func runServer(cmd *cobra.Command, args []string) error {
+	startTime := time.Now()
+
 	// Load configuration
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return fmt.Errorf("%s not found; run \"kproxy setup\" to create it", configPath)
+	}
 	cfg, err := config.Load(configPath)
 	if err != nil {
 		return fmt.Errorf("failed to load configuration: %w", err)
@@ -55,6 +102,15 @@ func runServer(cmd *cobra.Command, args []string) error {
 		Str("config", configPath).
 		Msg("Starting KProxy")
 
+	// DNS-only mode runs KProxy purely as a filtering resolver (no proxy, no usage tracking)
+	if serverDNSOnly {
+		cfg.Server.Mode = config.ModeDNSOnly
+	}
+	dnsOnly := cfg.Server.Mode == config.ModeDNSOnly
+	if dnsOnly {
+		logger.Info().Msg("Running in DNS-only mode: proxy, TLS and usage tracking are disabled")
+	}
+
 	// Check for systemd socket activation
 	sdListeners, err := systemd.GetListeners()
 	if err != nil {
@@ -75,97 +131,68 @@ func runServer(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
-	logger.Info().
-		Str("type", cfg.Storage.Type).
-		Str("redis_host", cfg.Storage.Redis.Host).
-		Int("redis_port", cfg.Storage.Redis.Port).
-		Msg("Storage initialized")
-
-	// Initialize Certificate Authority
-	caConfig := ca.Config{
-		RootCertPath:   cfg.TLS.CACert,
-		RootKeyPath:    cfg.TLS.CAKey,
-		IntermCertPath: cfg.TLS.IntermediateCert,
-		IntermKeyPath:  cfg.TLS.IntermediateKey,
-		CertCacheSize:  cfg.TLS.CertCacheSize,
-		CertCacheTTL:   parseDuration(cfg.TLS.CertCacheTTL, 24*time.Hour),
-		CertValidity:   parseDuration(cfg.TLS.CertValidity, 24*time.Hour),
-	}
-
-	certificateAuthority, err := ca.NewCA(caConfig, logger)
-	if err != nil {
-		return fmt.Errorf("failed to initialize Certificate Authority: %w", err)
+	storageLog := logger.Info().Str("type", cfg.Storage.Type)
+	primaryStore := store
+	if combined, ok := store.(*postgres.Combined); ok {
+		primaryStore = combined.Store
+		storageLog = storageLog.Bool("postgres", true)
+		if version, err := combined.Postgres.SchemaVersion(context.Background()); err == nil {
+			storageLog = storageLog.Int("postgres_schema_version", version)
+		}
 	}
-
-	logger.Info().Msg("Certificate Authority initialized")
-
-	// Obtain and load Let's Encrypt certificate if configured
-	var letsEncryptCert *tls.Certificate
-	if cfg.TLS.UseLetsEncrypt {
-		// Check if we need to obtain a new certificate
-		needsRenewal, reason := checkCertificateRenewal(cfg.TLS.LegoCertPath, cfg.TLS.LegoKeyPath, logger)
-
-		if needsRenewal {
-			logger.Info().
-				Str("domain", cfg.Server.Name).
-				Str("dns_provider", cfg.TLS.LegoDNSProvider).
-				Str("reason", reason).
-				Msg("Let's Encrypt certificate renewal needed, obtaining via ACME DNS-01 challenge")
-
-			acmeClient := acme.NewClient(acme.Config{
-				Email:       cfg.TLS.LegoEmail,
-				DNSProvider: cfg.TLS.LegoDNSProvider,
-				CertPath:    cfg.TLS.LegoCertPath,
-				KeyPath:     cfg.TLS.LegoKeyPath,
-				CADirURL:    cfg.TLS.LegoCADirURL,
-				Domain:      cfg.Server.Name,
-			}, logger)
-
-			if err := acmeClient.ObtainCertificate(); err != nil {
-				logger.Error().
-					Err(err).
-					Str("domain", cfg.Server.Name).
-					Msg("Failed to obtain Let's Encrypt certificate - continuing with self-signed CA")
-			} else {
-				logger.Info().
-					Str("domain", cfg.Server.Name).
-					Str("cert_path", cfg.TLS.LegoCertPath).
-					Str("key_path", cfg.TLS.LegoKeyPath).
-					Msg("Let's Encrypt certificate obtained successfully")
-			}
+	if cfg.Storage.Type == "redis" {
+		storageLog = storageLog.Str("redis_mode", cfg.Storage.Redis.Mode)
+		if cfg.Storage.Redis.Mode == config.RedisStandalone {
+			storageLog = storageLog.
+				Str("redis_host", cfg.Storage.Redis.Host).
+				Int("redis_port", cfg.Storage.Redis.Port)
 		} else {
-			logger.Info().
-				Str("cert_path", cfg.TLS.LegoCertPath).
-				Msg("Existing Let's Encrypt certificate is still valid, skipping renewal")
+			storageLog = storageLog.Strs("redis_addrs", cfg.Storage.Redis.Addrs)
+		}
+		storageLog = storageLog.Bool("redis_tls", cfg.Storage.Redis.TLS.Enabled)
+		if rs, ok := primaryStore.(*redis.Store); ok {
+			if version, err := rs.SchemaVersion(context.Background()); err == nil {
+				storageLog = storageLog.Int("schema_version", version)
+			}
 		}
 	}
-
-	// Load Let's Encrypt certificate if it exists
-	if cfg.TLS.UseLetsEncrypt {
-		cert, err := tls.LoadX509KeyPair(cfg.TLS.LegoCertPath, cfg.TLS.LegoKeyPath)
+	if bs, ok := primaryStore.(*bolt.Store); ok {
+		storageLog = storageLog.Str("bolt_path", cfg.Storage.Bolt.Path)
+		if version, err := bs.SchemaVersion(context.Background()); err == nil {
+			storageLog = storageLog.Int("schema_version", version)
+		}
+	}
+	storageLog.Msg("Storage initialized")
+
+	// Health checks of the backend; while they fail, usage limits are
+	// enforced from memory
+	storageMonitor := storage.NewMonitor(store, storage.MonitorConfig{
+		Interval: parseDuration(cfg.Storage.Health.Interval, 10*time.Second),
+		Timeout:  parseDuration(cfg.Storage.Health.Timeout, 2*time.Second),
+	}, logger)
+	storageMonitor.Start()
+	defer storageMonitor.Stop()
+
+	// Initialize TLS (CA and Let's Encrypt) - needed by the proxy and DNS-over-TLS
+	var certificateAuthority *ca.CA
+	var letsEncrypt *acme.Renewer
+	if !dnsOnly || cfg.Server.DNSEnableDoT {
+		certificateAuthority, letsEncrypt, err = initTLS(cfg, logger)
 		if err != nil {
-			logger.Warn().
-				Err(err).
-				Str("cert_path", cfg.TLS.LegoCertPath).
-				Str("key_path", cfg.TLS.LegoKeyPath).
-				Msg("Failed to load Let's Encrypt certificate - will use self-signed CA for server name")
-		} else {
-			letsEncryptCert = &cert
-			logger.Info().
-				Str("domain", cfg.Server.Name).
-				Msg("Let's Encrypt certificate loaded successfully")
+			return err
+		}
+		defer certificateAuthority.Close()
+		letsEncrypt.Start()
+		defer letsEncrypt.Stop()
+		// Audit log of the certificates the CA mints
+		if cfg.TLS.IssuanceLog {
+			certificateAuthority.SetIssuanceLog(store.IssuedCerts(), parseDuration(cfg.TLS.IssuanceLogRetention, 30*24*time.Hour))
 		}
 	}
 
 	// Initialize Policy Engine (fact-based, no config loading)
 	// Build OPA configuration
-	opaConfig := opa.Config{
-		Source:      cfg.Policy.OPAPolicySource,
-		PolicyDir:   cfg.Policy.OPAPolicyDir,
-		PolicyURLs:  cfg.Policy.OPAPolicyURLs,
-		HTTPTimeout: parseDuration(cfg.Policy.OPAHTTPTimeout, 30*time.Second),
-		HTTPRetries: cfg.Policy.OPAHTTPRetries,
-	}
+	opaConfig := newOPAConfig(cfg)
 
 	policyEngine, err := policy.NewEngine(
 		store.Usage(), // Only need usage store for facts
@@ -181,68 +208,270 @@ func runServer(cmd *cobra.Command, args []string) error {
 		Str("opa_source", opaConfig.Source).
 		Msg("Fact-based Policy Engine initialized (configuration in OPA policies)")
 
-	// Initialize Usage Tracker
-	usageTracker := usage.NewTracker(
-		store.Usage(),
-		usage.Config{
-			InactivityTimeout:  parseDuration(cfg.Usage.InactivityTimeout, 2*time.Minute),
-			MinSessionDuration: parseDuration(cfg.Usage.MinSessionDuration, 10*time.Second),
-		},
-		logger,
-	)
+	// Candidate policies evaluated in shadow (kproxy policy canary)
+	canary := newCanary(cfg, opaConfig, logger)
+	if canary != nil {
+		policyEngine.SetCanary(canary)
+		canary.Start()
+	}
+
+	// Sampled decisions recorded for replay (kproxy check replay)
+	var decisionLog *policy.DecisionLog
+	if cfg.Policy.DecisionLog.Enabled {
+		decisionLog = policy.NewDecisionLog(store.Decisions(), policy.DecisionLogConfig{
+			SampleRate: cfg.Policy.DecisionLog.SampleRate,
+			MaxSize:    cfg.Policy.DecisionLog.MaxSize,
+			Retention:  parseDuration(cfg.Policy.DecisionLog.Retention, 24*time.Hour),
+		}, logger)
+		policyEngine.SetDecisionLog(decisionLog)
+		decisionLog.Start()
+	}
+
+	// Recent activity and live events for dashboards ("kproxy top",
+	// /api/events)
+	recorder := activity.NewRecorder()
+
+	// Usage tracking only applies to proxied requests
+	var resetScheduler *usage.ResetScheduler
+	var usageReporter admin.UsageReporter
+	if !dnsOnly {
+		// Initialize Usage Tracker
+		usageTracker := usage.NewTracker(
+			store.Usage(),
+			usage.Config{
+				InactivityTimeout:  parseDuration(cfg.Usage.InactivityTimeout, 2*time.Minute),
+				MinSessionDuration: parseDuration(cfg.Usage.MinSessionDuration, 10*time.Second),
+			},
+			logger,
+		)
+		usageTracker.SetActivity(recorder)
+		usageTracker.SetStorageHealth(storageMonitor.Healthy)
+
+		logger.Info().Msg("Usage Tracker initialized")
+
+		// Connect usage tracker to policy engine
+		policyEngine.SetUsageTracker(usageTracker)
+		usageReporter = usageTracker
+
+		// Initialize Reset Scheduler
+		resetScheduler, err = usage.NewResetScheduler(
+			store.Usage(),
+			cfg.Usage.DailyResetTime,
+			logger,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to initialize Reset Scheduler: %w", err)
+		}
 
-	logger.Info().Msg("Usage Tracker initialized")
+		resetScheduler.Start()
+		logger.Info().Msg("Reset Scheduler initialized")
+	}
 
-	// Connect usage tracker to policy engine
-	policyEngine.SetUsageTracker(usageTracker)
+	// Initialize user identification for shared devices
+	var radiusServer *identity.RadiusServer
+	var agentServer *identity.AgentServer
+	var userRegistry *identity.Registry
+	if cfg.Identity.Enabled {
+		userRegistry = identity.NewRegistry(parseDuration(cfg.Identity.TTL, 10*time.Minute))
+		policyEngine.SetUserResolver(userRegistry)
+
+		if cfg.Identity.Radius.Enabled {
+			radiusAddr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Identity.Radius.Port)
+			radiusServer = identity.NewRadiusServer(radiusAddr, cfg.Identity.Radius.Secret, userRegistry, logger)
+			if err := radiusServer.Start(); err != nil {
+				return fmt.Errorf("failed to start RADIUS accounting listener: %w", err)
+			}
+		}
 
-	// Initialize Reset Scheduler
-	resetScheduler, err := usage.NewResetScheduler(
-		store.Usage(),
-		cfg.Usage.DailyResetTime,
-		logger,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to initialize Reset Scheduler: %w", err)
+		if cfg.Identity.Agent.Enabled {
+			agentAddr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Identity.Agent.Port)
+			agentServer = identity.NewAgentServer(agentAddr, cfg.Identity.Agent.Token, userRegistry, logger)
+			if err := agentServer.Start(); err != nil {
+				return fmt.Errorf("failed to start identity agent server: %w", err)
+			}
+		}
+
+		logger.Info().
+			Bool("radius", cfg.Identity.Radius.Enabled).
+			Bool("agent", cfg.Identity.Agent.Enabled).
+			Msg("User identification initialized")
 	}
 
-	resetScheduler.Start()
-	logger.Info().Msg("Reset Scheduler initialized")
+	// Custom block pages, uploaded through the admin API (kproxy blockpage)
+	blockPages := blockpage.New(store.BlockPages(), logger)
+
+	// Access requests from the block page, answered through the admin API
+	// (kproxy access); without it there is no one to ask
+	var accessRequests *access.Queue
+	if cfg.Admin.Enabled {
+		accessRequests = access.New(logger)
+	}
+
+	// Hosts that ask for a client certificate, tunnelled instead of
+	// intercepted and listed through the admin API
+	var clientCerts *clientcert.Cache
+	if cfg.TLS.ClientCertBypass {
+		clientCerts = clientcert.New(parseDuration(cfg.TLS.ClientCertBypassTTL, 24*time.Hour), logger)
+	}
+
+	// Network maintenance switch, toggled through the admin API
+	maint := maintenance.New(cfg.Maintenance.Message, parseDuration(cfg.Maintenance.Duration, time.Hour), logger)
+	if cfg.Maintenance.Enabled {
+		maint.Enable("", 0)
+	}
+
+	// Device type guesses from DHCP requests and User-Agents, for policy
+	// (device_type_profiles) and "kproxy device identify"
+	fingerprints := fingerprint.NewCollector(logger)
+	policyEngine.SetDeviceTypeSource(fingerprints)
+
+	// Store-and-forward spools of remote sinks, stopped after the sinks
+	var spools []*spool.Spool
+
+	// Hourly decision counts, exported nightly to a warehouse if enabled
+	var decisionCounts *analytics.Collector
+	var analyticsExporter *analytics.Exporter
+	if cfg.Analytics.Enabled {
+		decisionCounts = analytics.NewCollector()
+		analyticsExporter, err = analytics.NewExporter(decisionCounts, analyticsTarget(cfg.Analytics), cfg.Analytics.ExportTime, logger)
+		if err != nil {
+			return fmt.Errorf("failed to initialize decision analytics export: %w", err)
+		}
+		if s := openSpool(cfg.Spool, "analytics", analyticsExporter.Resend, logger); s != nil {
+			analyticsExporter.SetSpool(s)
+			spools = append(spools, s)
+		}
+		analyticsExporter.Start()
+	}
+
+	// Daily traffic counts per profile for weekly reports (proxy only; DNS
+	// answers say too little about use)
+	var reports *report.Recorder
+	if !dnsOnly {
+		reports = report.New(store.Reports(), logger)
+		reports.Start()
+	}
+
+	// Client MAC addresses from the kernel's neighbour table, so devices
+	// identified by MAC are recognised
+	var arpCache *arp.Cache
+	if cfg.Policy.UseMACAddress {
+		arpCache = arp.NewCache(parseDuration(cfg.Policy.ARPCacheTTL, 5*time.Minute), logger)
+	}
+
+	// Hostnames clients announce for themselves, so logs and policy can name
+	// them
+	var clientNames *clientnames.Resolver
+	if cfg.Hostnames.Enabled {
+		clientNames = clientnames.NewResolver(clientnames.Config{
+			TTL:     parseDuration(cfg.Hostnames.TTL, 24*time.Hour),
+			MDNS:    cfg.Hostnames.MDNS,
+			NetBIOS: cfg.Hostnames.NetBIOS,
+		}, store.Hostnames(), logger)
+		if err := clientNames.Start(); err != nil {
+			return fmt.Errorf("failed to start hostname resolver: %w", err)
+		}
+		policyEngine.SetHostnameSource(clientNames)
+	}
+
+	// Devices added and profiles assigned at runtime (kproxy device), with
+	// unknown clients quarantined as they appear
+	var runtimeDevices *devices.Registry
+	var discovery *devices.Discovery
+	if cfg.Admin.Enabled {
+		runtimeDevices = devices.New(logger)
+		runtimeDevices.SetActivity(recorder)
+		policyEngine.SetDeviceSource(runtimeDevices)
+		if cfg.Admin.Discovery.Enabled {
+			discovery = devices.NewDiscovery(runtimeDevices, policyEngine, cfg.Admin.Discovery.Profile, logger)
+			discovery.Start()
+		}
+	}
 
 	// Initialize DNS Server
-	// ProxyIP - if not configured, auto-detect the server's primary IP
+	// ProxyIP - if not configured, auto-detect the server's primary IP (unused in DNS-only mode)
 	proxyIP := cfg.Server.ProxyIP
-	if proxyIP == "" {
+	if proxyIP == "" && !dnsOnly {
 		detectedIP, err := detectServerIP()
 		if err != nil {
 			return fmt.Errorf("failed to auto-detect server IP. Please set server.proxy_ip in config: %w", err)
 		}
 		proxyIP = detectedIP
 		logger.Info().Str("proxy_ip", proxyIP).Msg("Auto-detected server IP for DNS intercept responses")
-	} else {
+	} else if proxyIP != "" {
 		logger.Info().Str("proxy_ip", proxyIP).Msg("Using configured proxy IP for DNS intercept responses")
 	}
+	if cfg.Server.ProxyIPv6 != "" && !dnsOnly {
+		logger.Info().Str("proxy_ipv6", cfg.Server.ProxyIPv6).Msg("Using configured proxy IPv6 address for AAAA intercept responses")
+	}
 
 	dnsConfig := dns.Config{
 		ListenAddr:   fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.DNSPort),
 		ProxyIP:      proxyIP,
+		ProxyIPv6:    cfg.Server.ProxyIPv6,
 		UpstreamDNS:  cfg.DNS.UpstreamServers,
+		ForwardZones: forwardZones(cfg.DNS.ForwardZones),
 		InterceptTTL: cfg.DNS.InterceptTTL,
 		BypassTTLCap: cfg.DNS.BypassTTLCap,
 		BlockTTL:     cfg.DNS.BlockTTL,
 		EnableTCP:    cfg.Server.DNSEnableTCP,
 		EnableUDP:    cfg.Server.DNSEnableUDP,
 		Timeout:      parseDuration(cfg.DNS.UpstreamTimeout, 5*time.Second),
+		DNSOnly:      dnsOnly,
+
+		CNAMEInspection: cfg.DNS.CNAMEInspection,
+		RateLimitQPS:    cfg.DNS.RateLimitQPS,
+		RateLimitBurst:  cfg.DNS.RateLimitBurst,
+		EDNSPassthrough: cfg.DNS.EDNSPassthrough,
+		DNSSEC:          cfg.DNS.DNSSEC,
+		StatusName:      cfg.DNS.StatusName,
+
+		StripHTTPSRecords: cfg.Server.QUICMode == "dns",
+
+		Maintenance:          maint,
+		MaintenanceDNSAction: cfg.Maintenance.DNSAction,
+	}
+
+	if cfg.DNS.CacheEnabled {
+		// The in-memory cache is always used; Redis additionally shares entries
+		// between instances and keeps them warm across restarts
+		var sharedCache storage.DNSCacheStore
+		if cfg.DNS.CacheBackend == "redis" {
+			sharedCache = store.DNSCache()
+		}
+		dnsCache, err := dns.NewCache(cfg.DNS.CacheSize, sharedCache, cfg.DNS.BypassTTLCap, logger)
+		if err != nil {
+			return fmt.Errorf("failed to initialize DNS cache: %w", err)
+		}
+		dnsConfig.Cache = dnsCache
+
+		logger.Info().
+			Int("size", cfg.DNS.CacheSize).
+			Str("backend", cfg.DNS.CacheBackend).
+			Msg("DNS cache initialized")
+	}
+
+	if cfg.Server.DNSEnableDoT {
+		dnsConfig.DoTAddr = fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.DoTPort)
+		dnsConfig.GetCertificate = serverNameCertificate(certificateAuthority, letsEncrypt, cfg.Server.Name)
 	}
 
 	dnsServer, err := dns.NewServer(dnsConfig, policyEngine, logger)
 	if err != nil {
 		return fmt.Errorf("failed to initialize DNS Server: %w", err)
 	}
+	dnsServer.SetActivity(recorder)
+	dnsServer.SetAnalytics(decisionCounts)
+	dnsServer.SetARP(arpCache)
+	dnsServer.SetDiscovery(discovery)
+	dnsServer.SetClientNames(clientNames)
 
 	// Use systemd socket-activated listeners if available
 	if sdListeners.Activated {
 		dnsServer.SetListeners(sdListeners.DNSUdp, sdListeners.DNSTcp)
+		if sdListeners.DNSTLS != nil {
+			dnsServer.SetDoTListener(sdListeners.DNSTLS)
+		}
 	}
 
 	if err := dnsServer.Start(); err != nil {
@@ -251,8 +480,33 @@ func runServer(cmd *cobra.Command, args []string) error {
 
 	logger.Info().
 		Str("addr", dnsConfig.ListenAddr).
+		Str("dot_addr", dnsConfig.DoTAddr).
 		Msg("DNS Server started")
 
+	// Initialize TFTP Server (if enabled), for network boot files and the
+	// iPXE menu, whether or not KProxy hands out addresses itself
+	var tftpServer *netboot.TFTPServer
+	if cfg.DHCP.TFTP.Enabled {
+		tftpServer, err = netboot.NewTFTPServer(netboot.TFTPConfig{
+			BindAddress: cfg.DHCP.TFTP.BindAddress,
+			Port:        cfg.DHCP.TFTP.Port,
+			Root:        cfg.DHCP.TFTP.Root,
+		}, logger)
+		if err != nil {
+			return fmt.Errorf("failed to initialize TFTP Server: %w", err)
+		}
+		if cfg.DHCP.IPXE.Enabled {
+			tftpServer.SetFile(netboot.MenuScriptName, netboot.Menu(
+				cfg.DHCP.IPXE.Title,
+				ipxeEntries(cfg.DHCP.IPXE.Entries),
+				parseDuration(cfg.DHCP.IPXE.Timeout, 10*time.Second),
+			))
+		}
+		if err := tftpServer.Start(); err != nil {
+			return fmt.Errorf("failed to start TFTP Server: %w", err)
+		}
+	}
+
 	// Initialize DHCP Server (if enabled)
 	var dhcpServer *dhcp.Server
 	if cfg.DHCP.Enabled {
@@ -284,6 +538,16 @@ func runServer(cmd *cobra.Command, args []string) error {
 			}
 		}
 
+		// Boot files come from the embedded TFTP server unless another is set
+		tftpIP := cfg.DHCP.TFTPIP
+		if tftpIP == "" && tftpServer != nil {
+			tftpIP = dhcpServerIP
+		}
+		var ipxeScript string
+		if cfg.DHCP.IPXE.Enabled {
+			ipxeScript = netboot.MenuScriptName
+		}
+
 		dhcpConfig := dhcp.Config{
 			Enabled:        cfg.DHCP.Enabled,
 			Port:           cfg.DHCP.Port,
@@ -297,14 +561,29 @@ func runServer(cmd *cobra.Command, args []string) error {
 			RangeEnd:       cfg.DHCP.RangeEnd,
 			BootFileName:   cfg.DHCP.BootFileName,
 			BootServerName: cfg.DHCP.BootServerName,
-			TFTPIP:         cfg.DHCP.TFTPIP,
+			TFTPIP:         tftpIP,
 			BootURI:        cfg.DHCP.BootURI,
+			Scopes:         dhcpScopes(cfg.DHCP.Scopes),
+			Options:        dhcpOptions(cfg.DHCP.Options),
+			VendorOptions:  dhcpVendorOptions(cfg.DHCP.VendorOptions),
+			ConflictHold:   parseDuration(cfg.DHCP.ConflictHold, time.Hour),
+
+			UEFIBootFileName:  cfg.DHCP.UEFIBootFileName,
+			ARM64BootFileName: cfg.DHCP.ARM64BootFileName,
+			IPXEScript:        ipxeScript,
 		}
 
 		dhcpServer, err = dhcp.NewServer(dhcpConfig, policyEngine, store.DHCPLeases(), logger)
 		if err != nil {
 			return fmt.Errorf("failed to initialize DHCP Server: %w", err)
 		}
+		dhcpServer.SetDiscovery(discovery)
+		dhcpServer.SetClientNames(clientNames)
+		dhcpServer.SetFingerprints(fingerprints)
+		dhcpServer.SetReservations(store.DHCPReservations())
+		if cfg.DHCP.ConflictDetection {
+			dhcpServer.SetProber(dhcp.NewPingProber(parseDuration(cfg.DHCP.ProbeTimeout, 500*time.Millisecond), logger))
+		}
 
 		if err := dhcpServer.Start(); err != nil {
 			return fmt.Errorf("failed to start DHCP Server: %w", err)
@@ -316,47 +595,204 @@ func runServer(cmd *cobra.Command, args []string) error {
 			Str("subnet", dhcpSubnetMask).
 			Str("gateway", dhcpGateway).
 			Str("range", fmt.Sprintf("%s-%s", cfg.DHCP.RangeStart, cfg.DHCP.RangeEnd)).
+			Int("scopes", len(cfg.DHCP.Scopes)).
 			Msg("DHCP Server started")
 	}
 
-	// Initialize Proxy Server
-	proxyConfig := proxy.Config{
-		HTTPAddr:    fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.HTTPPort),
-		HTTPSAddr:   fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.HTTPSPort),
-		AdminDomain: cfg.Server.AdminDomain,
-		ServerName:  cfg.Server.Name,
-		HTTPSPort:   cfg.Server.HTTPSPort,
-	}
+	// Initialize Proxy Server (skipped in DNS-only mode)
+	var proxyServer *proxy.Server
+	var scriptRuntime *script.Runtime
+	var requestMirror *mirror.Mirror
+	if !dnsOnly {
+		proxyConfig := proxy.Config{
+			HTTPAddr:    fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.HTTPPort),
+			HTTPSAddr:   fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.HTTPSPort),
+			AdminDomain: cfg.Server.AdminDomain,
+			ServerName:  cfg.Server.Name,
+			HTTPSPort:   cfg.Server.HTTPSPort,
 
-	proxyServer := proxy.NewServer(
-		proxyConfig,
-		policyEngine,
-		certificateAuthority,
-		logger,
-	)
+			ReverseLookup: cfg.Server.DirectIPReverseLookup,
 
-	// Configure Let's Encrypt certificate if available
-	if letsEncryptCert != nil {
-		proxyServer.SetLetsEncryptCert(letsEncryptCert)
-	}
+			ShutdownTimeout: parseDuration(cfg.Server.ShutdownTimeout, 5*time.Second),
+			HTTP2:           cfg.Server.HTTP2,
 
-	// Use systemd socket-activated listeners if available
-	if sdListeners.Activated {
-		proxyServer.SetListeners(sdListeners.HTTP, sdListeners.HTTPS)
-	}
+			SlowPolicyThreshold: parseDuration(cfg.Policy.SlowEvalThreshold, 100*time.Millisecond),
+			QuotaCheckInterval:  parseDuration(cfg.Usage.EnforceInterval, 30*time.Second),
 
-	if err := proxyServer.Start(); err != nil {
-		return fmt.Errorf("failed to start Proxy Server: %w", err)
-	}
+			RejectQUIC: cfg.Server.QUICMode == "reject",
+			StripHTTP3: cfg.Server.QUICMode != "allow",
 
-	logger.Info().
-		Str("http", proxyConfig.HTTPAddr).
-		Str("https", proxyConfig.HTTPSAddr).
-		Msg("Proxy Server started")
+			PolicyHeaders: cfg.Policy.RequestHeaders,
+		}
+		for _, port := range cfg.Server.TLSPorts {
+			proxyConfig.TLSAddrs = append(proxyConfig.TLSAddrs, fmt.Sprintf("%s:%d", cfg.Server.BindAddress, port))
+		}
+		if cfg.Response.Enabled {
+			proxyConfig.TimerInjection = &proxy.TimerInjectionConfig{
+				DisabledHosts: cfg.Response.DisabledHosts,
+				ContentTypes:  cfg.Response.AllowedContentTypes,
+			}
+		}
+		if cfg.Server.Transparent.Enabled {
+			proxyConfig.Transparent = &proxy.TransparentConfig{
+				Mode:      cfg.Server.Transparent.Mode,
+				HTTPAddr:  fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.Transparent.HTTPPort),
+				HTTPSAddr: fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.Transparent.HTTPSPort),
+			}
+		}
+		if cfg.Server.ForwardProxy.Enabled {
+			proxyConfig.ForwardProxy = &proxy.ForwardProxyConfig{
+				Addr:         fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.ForwardProxy.Port),
+				PACProxy:     net.JoinHostPort(proxyIP, strconv.Itoa(cfg.Server.ForwardProxy.Port)),
+				Users:        cfg.Server.ForwardProxy.Users,
+				ConnectPorts: cfg.Server.ForwardProxy.ConnectPorts,
+			}
+		}
+
+		proxyServer = proxy.NewServer(
+			proxyConfig,
+			policyEngine,
+			certificateAuthority,
+			logger,
+		)
+
+		// Configure Let's Encrypt certificate if enabled
+		if letsEncrypt != nil {
+			proxyServer.SetLetsEncryptCert(letsEncrypt.CertificateFor)
+		}
+
+		// Egress routes chosen by policy for upstream connections
+		if len(cfg.Egress.Routes) > 0 {
+			routes := make([]proxy.Egress, 0, len(cfg.Egress.Routes))
+			for _, route := range cfg.Egress.Routes {
+				routes = append(routes, proxy.Egress{
+					Name:      route.Name,
+					Interface: route.Interface,
+					SourceIP:  route.SourceIP,
+					Proxy:     route.Proxy,
+				})
+			}
+			if err := proxyServer.SetEgress(routes, cfg.Egress.Default); err != nil {
+				return fmt.Errorf("failed to configure egress: %w", err)
+			}
+		}
+
+		// Forward proxy logins identify users like RADIUS and the agent
+		if userRegistry != nil {
+			proxyServer.SetUserRegistry(userRegistry)
+		}
+
+		// Initialize request mutation scripts if enabled
+		if cfg.Scripting.Enabled {
+			scriptRuntime, err = script.NewRuntime(script.Config{
+				Dir:             cfg.Scripting.Dir,
+				Timeout:         parseDuration(cfg.Scripting.Timeout, 50*time.Millisecond),
+				MaxCallStack:    cfg.Scripting.MaxCallStack,
+				MaxRegistrySize: cfg.Scripting.MaxRegistrySize,
+				MaxMemory:       cfg.Scripting.MaxMemoryMB << 20,
+			}, logger)
+			if err != nil {
+				return fmt.Errorf("failed to initialize script runtime: %w", err)
+			}
+			proxyServer.SetScriptRuntime(scriptRuntime)
+		}
+
+		proxyServer.SetMaintenance(maint)
+		proxyServer.SetBlockPages(blockPages)
+		proxyServer.SetAccessRequests(accessRequests)
+		proxyServer.SetActivity(recorder)
+		proxyServer.SetAnalytics(decisionCounts)
+		proxyServer.SetARP(arpCache)
+		proxyServer.SetDiscovery(discovery)
+		proxyServer.SetClientNames(clientNames)
+		proxyServer.SetFingerprints(fingerprints)
+		proxyServer.SetReports(reports)
+		proxyServer.SetClientCerts(clientCerts)
+
+		// Track media streams for profiles with a stream limit
+		streams := proxy.NewStreamTracker()
+		proxyServer.SetStreams(streams)
+		policyEngine.SetStreamSource(streams)
+
+		// Share bandwidth between profiles if enabled
+		if cfg.Bandwidth.Enabled {
+			proxyServer.SetShaper(proxy.NewShaper(cfg.Bandwidth.TotalKbps))
+			logger.Info().Int("total_kbps", cfg.Bandwidth.TotalKbps).Msg("Bandwidth sharing enabled")
+		}
+
+		// Pass search queries to policy for logging and concern alerts if enabled
+		if cfg.Search.Enabled {
+			monitor := searchwatch.New(searchwatch.Config{
+				AlertWebhook:      cfg.Search.AlertWebhook,
+				AlertIncludeQuery: cfg.Search.AlertIncludeQuery,
+				AlertTimeout:      parseDuration(cfg.Search.AlertTimeout, 10*time.Second),
+			}, logger)
+			if cfg.Search.AlertWebhook != "" {
+				if s := openSpool(cfg.Spool, "search-alerts", monitor.Resend, logger); s != nil {
+					monitor.SetSpool(s)
+					spools = append(spools, s)
+				}
+			}
+			proxyServer.SetSearchMonitor(monitor)
+			logger.Info().Msg("Search monitoring enabled for profiles that opt in")
+		}
+
+		// Mirror allowed requests to an analysis sink if enabled
+		if cfg.Mirror.Enabled {
+			requestMirror, err = mirror.New(mirror.Config{
+				URL:             cfg.Mirror.URL,
+				SampleRate:      cfg.Mirror.SampleRate,
+				FullHosts:       cfg.Mirror.FullHosts,
+				MaxBodyBytes:    cfg.Mirror.MaxBodyBytes,
+				RedactClientIP:  cfg.Mirror.RedactClientIP,
+				RedactQuery:     cfg.Mirror.RedactQuery,
+				RedactUserAgent: cfg.Mirror.RedactUserAgent,
+				RedactHeaders:   cfg.Mirror.RedactHeaders,
+				QueueSize:       cfg.Mirror.QueueSize,
+				Timeout:         parseDuration(cfg.Mirror.Timeout, 5*time.Second),
+			}, logger)
+			if err != nil {
+				return fmt.Errorf("failed to initialize request mirroring: %w", err)
+			}
+			if s := openSpool(cfg.Spool, "mirror", requestMirror.Resend, logger); s != nil {
+				requestMirror.SetSpool(s)
+				spools = append(spools, s)
+			}
+			requestMirror.Start()
+			proxyServer.SetMirror(requestMirror)
+		}
+
+		// Use systemd socket-activated listeners if available
+		if sdListeners.Activated {
+			proxyServer.SetListeners(sdListeners.HTTP, sdListeners.HTTPS)
+		}
+
+		if err := proxyServer.Start(); err != nil {
+			return fmt.Errorf("failed to start Proxy Server: %w", err)
+		}
+
+		logger.Info().
+			Str("http", proxyConfig.HTTPAddr).
+			Str("https", proxyConfig.HTTPSAddr).
+			Msg("Proxy Server started")
+	}
 
 	// Initialize Metrics Server
 	metricsAddr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.MetricsPort)
 	metricsServer := metrics.NewServer(metricsAddr, logger)
+	metricsServer.SetHealthStatus(func() string {
+		var status []string
+		if maintenance := maint.Status(); maintenance.Active {
+			status = append(status, fmt.Sprintf("MAINTENANCE until %s", maintenance.Until.Format(time.RFC3339)))
+		}
+		if health := storageMonitor.Health(); !health.Healthy {
+			status = append(status, fmt.Sprintf("DEGRADED: storage unreachable since %s", health.Since.Format(time.RFC3339)))
+		}
+		if len(status) == 0 {
+			return "OK"
+		}
+		return strings.Join(status, "; ")
+	})
 
 	// Use systemd socket-activated listener if available
 	if sdListeners.Activated && sdListeners.Metrics != nil {
@@ -371,12 +807,275 @@ func runServer(cmd *cobra.Command, args []string) error {
 		Str("addr", metricsAddr).
 		Msg("Metrics Server started")
 
+	// Release update checks (reported on the admin API)
+	updater, err := update.New(update.Config{
+		Current:       version,
+		URL:           cfg.Update.URL,
+		Channel:       cfg.Update.Channel,
+		PublicKey:     cfg.Update.PublicKey,
+		CheckInterval: parseDuration(cfg.Update.CheckInterval, 24*time.Hour),
+	}, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize updater: %w", err)
+	}
+	updater.Start()
+
+	// Connectivity probes through the upstream DNS servers (reported on the
+	// admin API and metrics)
+	var prober *probe.Prober
+	if cfg.Probes.Enabled {
+		var resolvers []probe.Resolver
+		for _, spec := range cfg.DNS.UpstreamServers {
+			upstream, err := dns.NewUpstream(spec, parseDuration(cfg.DNS.UpstreamTimeout, 5*time.Second))
+			if err != nil {
+				return fmt.Errorf("failed to initialize probes: %w", err)
+			}
+			resolvers = append(resolvers, upstream)
+		}
+		prober, err = probe.New(probe.Config{
+			Targets:   cfg.Probes.Targets,
+			Resolvers: resolvers,
+			Interval:  parseDuration(cfg.Probes.Interval, time.Minute),
+			Timeout:   parseDuration(cfg.Probes.Timeout, 10*time.Second),
+			Retention: parseDuration(cfg.Probes.Retention, 24*time.Hour),
+		}, logger)
+		if err != nil {
+			return fmt.Errorf("failed to initialize probes: %w", err)
+		}
+		prober.Start()
+	}
+
+	// Experimental feature flags (switchable through the admin API)
+	featureFlags, err := features.New(map[string]bool{
+		features.H3Listener:      cfg.Features.H3Listener,
+		features.ContentScanning: cfg.Features.ContentScanning,
+	}, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize feature flags: %w", err)
+	}
+	for _, flag := range featureFlags.List() {
+		if flag.Enabled {
+			logger.Warn().Str("flag", flag.Name).Msg("Experimental feature enabled")
+		}
+	}
+
+	// Rules for single devices, kept in storage (kproxy device rule)
+	deviceRules := rules.NewDeviceSet(store.DeviceRules(), logger)
+	if err := deviceRules.Load(context.Background()); err != nil {
+		return fmt.Errorf("failed to load device rules: %w", err)
+	}
+	policyEngine.SetDeviceRuleSource(deviceRules)
+
+	// Paused devices and profiles, kept in storage (kproxy device pause)
+	pauses := pause.New(store.Pauses(), logger)
+	if err := pauses.Load(context.Background()); err != nil {
+		return fmt.Errorf("failed to load pauses: %w", err)
+	}
+	policyEngine.SetPauseSource(pauses)
+
+	// Rule sets attached to profiles, kept in storage (kproxy ruleset)
+	ruleSets := rules.NewRuleSets(store.RuleSets(), logger)
+	if err := ruleSets.Load(context.Background()); err != nil {
+		return fmt.Errorf("failed to load rule sets: %w", err)
+	}
+	policyEngine.SetRuleSetSource(ruleSets)
+
+	// API tokens for scripts and integrations, kept in storage (kproxy token)
+	apiTokens := apitoken.New(store.APITokens(), logger)
+	if err := apiTokens.Load(context.Background()); err != nil {
+		return fmt.Errorf("failed to load API tokens: %w", err)
+	}
+
+	// Reload policies and scripts on SIGHUP, and when watched policies change
+	reloadPolicies := func() {
+		if err := policyEngine.Reload(); err != nil {
+			logger.Error().Err(err).Msg("Failed to reload policies")
+		} else {
+			logger.Info().Msg("Policies reloaded successfully")
+		}
+		if scriptRuntime != nil {
+			if err := scriptRuntime.Reload(); err != nil {
+				logger.Error().Err(err).Msg("Failed to reload scripts")
+			}
+		}
+	}
+
+	// Reload the runtime state cached from storage
+	reloadState := func() {
+		ctx := context.Background()
+		if err := deviceRules.Load(ctx); err != nil {
+			logger.Error().Err(err).Msg("Failed to reload device rules")
+		}
+		if err := pauses.Load(ctx); err != nil {
+			logger.Error().Err(err).Msg("Failed to reload pauses")
+		}
+		if err := ruleSets.Load(ctx); err != nil {
+			logger.Error().Err(err).Msg("Failed to reload rule sets")
+		}
+		if err := apiTokens.Load(ctx); err != nil {
+			logger.Error().Err(err).Msg("Failed to reload API tokens")
+		}
+	}
+
+	// Changes made through the admin API of another instance sharing
+	// storage: reload policies and the state cached from storage
+	broadcaster := reload.New(store.Reloads(), func() {
+		reloadPolicies()
+		reloadState()
+	}, logger)
+	broadcaster.Start()
+
+	// Policy files edited through the admin API (kproxy policy edit) and
+	// restored from backups
+	var policyEditor *policyedit.Editor
+	if policyDirSource(cfg) {
+		policyEditor = policyedit.New(cfg.Policy.OPAPolicyDir, policyEngine, logger)
+	}
+
+	// Backups of storage, policies and the CA (kproxy backup)
+	backupConfig := backup.Config{
+		Store: store,
+		CAFiles: map[string]string{
+			backup.CACert:           cfg.TLS.CACert,
+			backup.CAKey:            cfg.TLS.CAKey,
+			backup.IntermediateCert: cfg.TLS.IntermediateCert,
+			backup.IntermediateKey:  cfg.TLS.IntermediateKey,
+		},
+		Version: version,
+		Reload:  reloadState,
+	}
+	if policyEditor != nil {
+		backupConfig.Policies = policyEditor
+	}
+	backups := backup.New(backupConfig, logger)
+	var backupScheduler *backup.Scheduler
+	if cfg.Backup.Enabled {
+		backupScheduler, err = backup.NewScheduler(backups, cfg.Backup.Dir, cfg.Backup.Time, cfg.Backup.Keep, cfg.Backup.IncludeCA, logger)
+		if err != nil {
+			return fmt.Errorf("failed to initialize scheduled backups: %w", err)
+		}
+		backupScheduler.Start()
+	}
+
+	// Initialize Admin API Server
+	var adminServer *admin.Server
+	if cfg.Admin.Enabled {
+		// Rules added at runtime through the admin API (kproxy rule)
+		runtimeRules := rules.New(logger)
+		policyEngine.SetRuleSource(runtimeRules)
+
+		adminAddr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Admin.Port)
+		adminServer = admin.NewServer(adminAddr, cfg.Admin.Token, policyEngine, maint, logger)
+		if cfg.Admin.TLS {
+			adminServer.SetTLS(serverNameCertificate(certificateAuthority, letsEncrypt, cfg.Server.Name))
+		}
+		adminServer.SetVersionReporter(updater)
+		adminServer.SetFeatures(featureFlags)
+		adminServer.SetActivity(recorder, usageReporter)
+		adminServer.SetRules(runtimeRules)
+		adminServer.SetDevices(runtimeDevices, store.DHCPLeases())
+		adminServer.SetFingerprints(fingerprints)
+		if cfg.DHCP.Enabled {
+			adminServer.SetDHCP(store.DHCPLeases(), store.DHCPReservations())
+		}
+		adminServer.SetDeviceRules(deviceRules)
+		adminServer.SetPauses(pauses)
+		adminServer.SetAPITokens(apiTokens)
+		adminServer.SetRuleSets(ruleSets)
+		adminServer.SetBlockPages(blockPages)
+		adminServer.SetAccessRequests(accessRequests)
+		adminServer.SetClientCerts(clientCerts)
+		if certificateAuthority != nil {
+			adminServer.SetCA(certificateAuthority)
+			if cfg.TLS.IssuanceLog {
+				adminServer.SetIssuedCerts(store.IssuedCerts())
+			}
+		}
+		adminServer.SetReports(reports)
+		if prober != nil {
+			adminServer.SetProbes(prober)
+		}
+		if canary != nil {
+			adminServer.SetCanary(canary)
+		}
+		if policyEditor != nil {
+			adminServer.SetPolicies(policyEditor)
+		}
+		adminServer.SetBackups(backups)
+		adminServer.SetAccounts(cfg.Admin.Accounts)
+		// Who changed what through the API (kproxy audit)
+		if cfg.Admin.Audit.Enabled {
+			adminServer.SetAuditLog(store.AuditLog(), parseDuration(cfg.Admin.Audit.Retention, 90*24*time.Hour))
+		}
+		// Recorded decisions (kproxy decisions)
+		if cfg.Policy.DecisionLog.Enabled {
+			adminServer.SetDecisions(store.Decisions())
+		}
+		if cfg.Admin.Approval.Enabled {
+			// Destructive changes wait for a second account (kproxy approval)
+			adminServer.SetApprovals(approval.New(store.PendingChanges(), parseDuration(cfg.Admin.Approval.Window, time.Hour), logger))
+		}
+		adminServer.SetChangeNotifier(broadcaster)
+		adminServer.SetSystemInfo(admin.SystemInfo{
+			Version: version,
+			Channel: cfg.Update.Channel,
+			Mode:    cfg.Server.Mode,
+			Started: startTime,
+		})
+		if err := adminServer.Start(); err != nil {
+			return fmt.Errorf("failed to start Admin API Server: %w", err)
+		}
+
+		logger.Info().
+			Str("addr", adminAddr).
+			Msg("Admin API Server started")
+	}
+
+	// Answer ext_authz checks from other gateways (Envoy) with proxy decisions
+	var extAuthzServer *extauthz.Server
+	if cfg.ExtAuthz.Enabled {
+		extAuthzAddr := fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.ExtAuthz.Port)
+		extAuthzServer = extauthz.NewServer(extAuthzAddr, cfg.ExtAuthz.Token, policyEngine, cfg.Policy.RequestHeaders, logger)
+		if err := extAuthzServer.Start(); err != nil {
+			return fmt.Errorf("failed to start external authorization server: %w", err)
+		}
+	}
+
 	// Log startup complete
 	logger.Info().Msg("KProxy startup complete")
 	logger.Info().Msgf("DNS Server: %s:%d", cfg.Server.BindAddress, cfg.Server.DNSPort)
-	logger.Info().Msgf("HTTP Proxy: %s:%d", cfg.Server.BindAddress, cfg.Server.HTTPPort)
-	logger.Info().Msgf("HTTPS Proxy: %s:%d", cfg.Server.BindAddress, cfg.Server.HTTPSPort)
+	if cfg.Server.DNSEnableDoT {
+		logger.Info().Msgf("DNS-over-TLS: %s:%d", cfg.Server.BindAddress, cfg.Server.DoTPort)
+	}
+	if !dnsOnly {
+		logger.Info().Msgf("HTTP Proxy: %s:%d", cfg.Server.BindAddress, cfg.Server.HTTPPort)
+		logger.Info().Msgf("HTTPS Proxy: %s:%d", cfg.Server.BindAddress, cfg.Server.HTTPSPort)
+	}
 	logger.Info().Msgf("Metrics: http://%s:%d/metrics", cfg.Server.BindAddress, cfg.Server.MetricsPort)
+	if adminServer != nil {
+		logger.Info().Msgf("Admin API: http://%s:%d/api/", cfg.Server.BindAddress, cfg.Admin.Port)
+	}
+	if extAuthzServer != nil {
+		logger.Info().Msgf("External authorization (gRPC): %s:%d", cfg.Server.BindAddress, cfg.ExtAuthz.Port)
+	}
+
+	var policyWatcher, candidateWatcher *opa.Watcher
+	if cfg.Policy.OPAPolicyWatch && policyDirSource(cfg) {
+		interval := parseDuration(cfg.Policy.OPAPolicyWatchInterval, 10*time.Second)
+		policyWatcher = opa.NewWatcher(cfg.Policy.OPAPolicyDir, interval, reloadPolicies, logger)
+		if err := policyWatcher.Start(); err != nil {
+			return fmt.Errorf("failed to watch policies: %w", err)
+		}
+		if canary != nil {
+			candidateWatcher = opa.NewWatcher(cfg.Policy.OPACandidatePolicyDir, interval, reloadPolicies, logger)
+			if err := candidateWatcher.Start(); err != nil {
+				return fmt.Errorf("failed to watch candidate policies: %w", err)
+			}
+		}
+	}
+
+	// Ready for traffic (/readyz) once every server has started
+	metricsServer.SetReady(true)
 
 	// Notify systemd that we're ready to serve requests
 	if err := systemd.NotifyReady(); err != nil {
@@ -396,11 +1095,7 @@ func runServer(cmd *cobra.Command, args []string) error {
 		switch sig {
 		case syscall.SIGHUP:
 			logger.Info().Msg("SIGHUP received, reloading policies...")
-			if err := policyEngine.Reload(); err != nil {
-				logger.Error().Err(err).Msg("Failed to reload policies")
-			} else {
-				logger.Info().Msg("Policies reloaded successfully")
-			}
+			reloadPolicies()
 			// Continue running
 			continue
 
@@ -418,8 +1113,29 @@ func runServer(cmd *cobra.Command, args []string) error {
 		logger.Warn().Err(err).Msg("Failed to send systemd stopping notification")
 	}
 
+	// Report not ready and keep serving while load balancers drain us
+	metricsServer.SetReady(false)
+	if delay := parseDuration(cfg.Server.ShutdownDelay, 0); delay > 0 {
+		logger.Info().Dur("delay", delay).Msg("Waiting before stopping servers")
+		time.Sleep(delay)
+	}
+
+	broadcaster.Stop()
+	if policyWatcher != nil {
+		policyWatcher.Stop()
+	}
+	if candidateWatcher != nil {
+		candidateWatcher.Stop()
+	}
+
 	// Stop servers
-	resetScheduler.Stop()
+	if resetScheduler != nil {
+		resetScheduler.Stop()
+	}
+	updater.Stop()
+	if prober != nil {
+		prober.Stop()
+	}
 
 	if err := dnsServer.Stop(); err != nil {
 		logger.Error().Err(err).Msg("Error stopping DNS Server")
@@ -431,8 +1147,84 @@ func runServer(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	if err := proxyServer.Stop(); err != nil {
-		logger.Error().Err(err).Msg("Error stopping Proxy Server")
+	if tftpServer != nil {
+		if err := tftpServer.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error stopping TFTP Server")
+		}
+	}
+
+	if proxyServer != nil {
+		if err := proxyServer.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error stopping Proxy Server")
+		}
+	}
+
+	if extAuthzServer != nil {
+		if err := extAuthzServer.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error stopping external authorization server")
+		}
+	}
+
+	// After the proxy, so records of the last requests are sent
+	if requestMirror != nil {
+		requestMirror.Stop()
+	}
+
+	// After the DNS server and proxy, comparing the last decisions
+	if canary != nil {
+		canary.Stop()
+	}
+
+	// After the DNS, DHCP and proxy servers, which look up and learn names
+	if clientNames != nil {
+		clientNames.Stop()
+	}
+
+	// After the DNS, DHCP and proxy servers, quarantining the last new clients
+	if discovery != nil {
+		discovery.Stop()
+	}
+
+	// After the DNS server, proxy and ext_authz, recording the last decisions
+	if decisionLog != nil {
+		decisionLog.Stop()
+	}
+
+	// After the DNS server and proxy, exporting the completed hours
+	if analyticsExporter != nil {
+		analyticsExporter.Stop()
+	}
+
+	if backupScheduler != nil {
+		backupScheduler.Stop()
+	}
+
+	// After the sinks, whose last failed sends are now on disk
+	for _, s := range spools {
+		s.Stop()
+	}
+
+	// After the proxy, storing the last report counts
+	if reports != nil {
+		reports.Stop()
+	}
+
+	if radiusServer != nil {
+		if err := radiusServer.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error stopping RADIUS accounting listener")
+		}
+	}
+
+	if agentServer != nil {
+		if err := agentServer.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error stopping identity agent server")
+		}
+	}
+
+	if adminServer != nil {
+		if err := adminServer.Stop(); err != nil {
+			logger.Error().Err(err).Msg("Error stopping Admin API Server")
+		}
 	}
 
 	if err := metricsServer.Stop(); err != nil {
@@ -444,18 +1236,117 @@ func runServer(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// initTLS initializes the Certificate Authority and obtains/loads the Let's Encrypt certificate if configured.
+// The returned renewer is nil without Let's Encrypt.
+func initTLS(cfg *config.Config, logger zerolog.Logger) (*ca.CA, *acme.Renewer, error) {
+	// Initialize Certificate Authority
+	caConfig := ca.Config{
+		RootCertPath:   cfg.TLS.CACert,
+		RootKeyPath:    cfg.TLS.CAKey,
+		IntermCertPath: cfg.TLS.IntermediateCert,
+		IntermKeyPath:  cfg.TLS.IntermediateKey,
+		CertCacheSize:  cfg.TLS.CertCacheSize,
+		CertCacheTTL:   parseDuration(cfg.TLS.CertCacheTTL, 24*time.Hour),
+		CertValidity:   parseDuration(cfg.TLS.CertValidity, 24*time.Hour),
+		KeyPoolSize:    cfg.TLS.KeyPoolSize,
+	}
+
+	certificateAuthority, err := ca.NewCA(caConfig, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize Certificate Authority: %w", err)
+	}
+
+	logger.Info().Msg("Certificate Authority initialized")
+
+	// Obtain or renew the Let's Encrypt certificate if configured; the
+	// renewer keeps it current in the background once started
+	var letsEncrypt *acme.Renewer
+	if cfg.TLS.UseLetsEncrypt {
+		acmeClient := acme.NewClient(acme.Config{
+			Email:       cfg.TLS.LegoEmail,
+			DNSProvider: cfg.TLS.LegoDNSProvider,
+			CertPath:    cfg.TLS.LegoCertPath,
+			KeyPath:     cfg.TLS.LegoKeyPath,
+			CADirURL:    cfg.TLS.LegoCADirURL,
+			Domain:      cfg.Server.Name,
+			Domains:     cfg.TLS.LegoDomains,
+		}, logger)
+		letsEncrypt = acme.NewRenewer(acmeClient, parseDuration(cfg.TLS.LegoRenewBefore, acme.DefaultRenewBefore), logger)
+
+		if err := letsEncrypt.Check(); err != nil {
+			logger.Error().
+				Err(err).
+				Str("domain", cfg.Server.Name).
+				Msg("Failed to obtain Let's Encrypt certificate")
+		}
+		if letsEncrypt.Certificate() == nil {
+			logger.Warn().
+				Str("cert_path", cfg.TLS.LegoCertPath).
+				Msg("No Let's Encrypt certificate yet - using self-signed CA for server name until renewal succeeds")
+		} else {
+			logger.Info().
+				Str("domain", cfg.Server.Name).
+				Msg("Let's Encrypt certificate loaded successfully")
+		}
+	}
+
+	return certificateAuthority, letsEncrypt, nil
+}
+
+// serverNameCertificate returns a certificate selector for DNS-over-TLS. Clients such as
+// Android Private DNS connect using server.name, so the Let's Encrypt certificate is
+// preferred for the names it covers; anything else gets a certificate from the KProxy
+// CA. The renewer is read on every handshake, so a renewed certificate is used straight
+// away. The admin API uses the same selection when served over TLS.
+func serverNameCertificate(certificateAuthority *ca.CA, letsEncrypt *acme.Renewer, serverName string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		// Clients without SNI get a certificate for the server name
+		if hello.ServerName == "" {
+			hello.ServerName = serverName
+		}
+		if letsEncryptCert := letsEncrypt.CertificateFor(hello.ServerName); letsEncryptCert != nil {
+			return letsEncryptCert, nil
+		}
+		return certificateAuthority.GetCertificate(hello)
+	}
+}
+
 func openStorage(cfg config.StorageConfig) (storage.Store, error) {
 	storageType := cfg.Type
 	if storageType == "" {
 		storageType = "redis"
 	}
 
+	var store storage.Store
 	switch storageType {
 	case "redis":
-		return redis.Open(cfg.Redis)
+		redisStore, err := redis.Open(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		store = redisStore
+	case "bolt":
+		boltStore, err := bolt.Open(cfg.Bolt)
+		if err != nil {
+			return nil, err
+		}
+		store = boltStore
+	case "memory":
+		store = memory.Open()
 	default:
-		return nil, fmt.Errorf("unsupported storage type: %s (only 'redis' is supported)", storageType)
+		return nil, fmt.Errorf("unsupported storage type: %s (supported: redis, bolt, memory)", storageType)
+	}
+
+	// Usage and decisions go to PostgreSQL when it is configured
+	if cfg.Postgres.DSN == "" {
+		return store, nil
 	}
+	pg, err := postgres.Open(cfg.Postgres)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return postgres.Combine(store, pg), nil
 }
 
 // setupLogger configures the logger based on configuration
@@ -484,6 +1375,168 @@ func setupLogger(cfg config.LoggingConfig) zerolog.Logger {
 	return zerolog.New(os.Stdout).With().Timestamp().Logger()
 }
 
+// forwardZones converts configured forward zones for the DNS server
+func forwardZones(zones []config.ForwardZoneConfig) []dns.ForwardZone {
+	out := make([]dns.ForwardZone, 0, len(zones))
+	for _, z := range zones {
+		out = append(out, dns.ForwardZone{Zone: z.Zone, Servers: z.Servers})
+	}
+	return out
+}
+
+// dhcpScopes converts DHCP scope configuration for the DHCP server
+func dhcpScopes(scopes []config.DHCPScopeConfig) []dhcp.Scope {
+	out := make([]dhcp.Scope, 0, len(scopes))
+	for _, sc := range scopes {
+		out = append(out, dhcp.Scope{
+			Name:       sc.Name,
+			Interface:  sc.Interface,
+			Subnet:     sc.Subnet,
+			ServerIP:   sc.ServerIP,
+			Gateway:    sc.Gateway,
+			DNSServers: sc.DNSServers,
+			LeaseTime:  parseDuration(sc.LeaseTime, 0),
+			RangeStart: sc.RangeStart,
+			RangeEnd:   sc.RangeEnd,
+			Options:    dhcpOptions(sc.Options),
+		})
+	}
+	return out
+}
+
+// ipxeEntries converts iPXE menu configuration for the menu generator
+func ipxeEntries(entries []config.DHCPIPXEEntryConfig) []netboot.MenuEntry {
+	out := make([]netboot.MenuEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, netboot.MenuEntry{
+			Name:   e.Name,
+			Arch:   e.Arch,
+			Chain:  e.Chain,
+			Kernel: e.Kernel,
+			Initrd: e.Initrd,
+			Args:   e.Args,
+		})
+	}
+	return out
+}
+
+// dhcpVendorOptions converts DHCP vendor class options for the DHCP server
+func dhcpVendorOptions(vendors []config.DHCPVendorOptionsConfig) []dhcp.VendorOptions {
+	out := make([]dhcp.VendorOptions, 0, len(vendors))
+	for _, v := range vendors {
+		out = append(out, dhcp.VendorOptions{VendorClass: v.VendorClass, Options: dhcpOptions(v.Options)})
+	}
+	return out
+}
+
+// dhcpOptions converts extra DHCP options to codes. The codes were checked
+// by config validation.
+func dhcpOptions(options map[string]string) map[uint8]string {
+	out := make(map[uint8]string, len(options))
+	for code, value := range options {
+		n, _ := strconv.Atoi(code)
+		out[uint8(n)] = value
+	}
+	return out
+}
+
+// newOPAConfig is the OPA engine configuration of the active policies
+func newOPAConfig(cfg *config.Config) opa.Config {
+	return opa.Config{
+		Source:      cfg.Policy.OPAPolicySource,
+		PolicyDir:   cfg.Policy.OPAPolicyDir,
+		PolicyURLs:  cfg.Policy.OPAPolicyURLs,
+		HTTPTimeout: parseDuration(cfg.Policy.OPAHTTPTimeout, 30*time.Second),
+		HTTPRetries: cfg.Policy.OPAHTTPRetries,
+
+		BundleURL:       cfg.Policy.OPABundleURL,
+		BundleKeyFile:   cfg.Policy.OPABundleKeyFile,
+		BundleKeyID:     cfg.Policy.OPABundleKeyID,
+		BundleAlgorithm: cfg.Policy.OPABundleAlgorithm,
+
+		Target: cfg.Policy.OPATarget,
+	}
+}
+
+// policyDirSource reports whether the active policies are read from
+// policy.opa_policy_dir, where they can be edited and watched
+func policyDirSource(cfg *config.Config) bool {
+	switch strings.ToLower(cfg.Policy.OPAPolicySource) {
+	case "remote", "bundle":
+		return false
+	}
+	return true
+}
+
+// newCanary loads the candidate policies, from the same source as the
+// active ones, for shadow evaluation. It returns nil when none are
+// configured or they fail to load (logged), since the candidate must
+// never keep KProxy from starting.
+func newCanary(cfg *config.Config, opaConfig opa.Config, logger zerolog.Logger) *policy.Canary {
+	candidateConfig := opaConfig
+	candidateConfig.PolicyDir = cfg.Policy.OPACandidatePolicyDir
+	candidateConfig.PolicyURLs = cfg.Policy.OPACandidatePolicyURLs
+	candidateConfig.BundleURL = cfg.Policy.OPACandidateBundleURL
+
+	source := candidateConfig.PolicyDir
+	switch strings.ToLower(opaConfig.Source) {
+	case "remote":
+		source = strings.Join(candidateConfig.PolicyURLs, ", ")
+	case "bundle":
+		source = candidateConfig.BundleURL
+	}
+	if source == "" {
+		return nil
+	}
+
+	engine, err := opa.NewEngine(candidateConfig, logger.With().Str("policies", "candidate").Logger())
+	if err != nil {
+		logger.Error().Err(err).Str("candidate", source).Msg("Failed to load candidate policies, not shadow evaluating")
+		return nil
+	}
+	return policy.NewCanary(engine, source, logger)
+}
+
+// openSpool opens and starts the store-and-forward spool of a sink. It
+// returns nil when the spool is disabled or can't be opened (logged), and
+// the sink then works without it.
+func openSpool(cfg config.SpoolConfig, name string, send spool.Send, logger zerolog.Logger) *spool.Spool {
+	if !cfg.Enabled {
+		return nil
+	}
+	s, err := spool.Open(cfg.Dir, name, int64(cfg.MaxSizeMB)<<20, parseDuration(cfg.RetryInterval, 30*time.Second), send, logger)
+	if err != nil {
+		logger.Warn().Err(err).Str("sink", name).Msg("Store-and-forward unavailable, failed sends will be lost")
+		return nil
+	}
+	s.Start()
+	return s
+}
+
+// analyticsTarget creates the configured decision analytics export target
+func analyticsTarget(cfg config.AnalyticsConfig) analytics.Target {
+	switch cfg.Target {
+	case "s3":
+		return &analytics.S3Target{
+			Endpoint:        cfg.S3.Endpoint,
+			Bucket:          cfg.S3.Bucket,
+			Region:          cfg.S3.Region,
+			Prefix:          cfg.S3.Prefix,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+		}
+	case "influxdb":
+		return &analytics.InfluxDBTarget{
+			URL:    cfg.InfluxDB.URL,
+			Org:    cfg.InfluxDB.Org,
+			Bucket: cfg.InfluxDB.Bucket,
+			Token:  cfg.InfluxDB.Token,
+		}
+	default:
+		return &analytics.CSVTarget{Dir: cfg.CSV.Dir}
+	}
+}
+
 // parseDuration parses a duration string with a fallback
 func parseDuration(s string, fallback time.Duration) time.Duration {
 	d, err := time.ParseDuration(s)
@@ -555,83 +1608,3 @@ func detectNetworkConfig() (ip, subnet, gateway string, err error) {
 
 	return "", "", "", fmt.Errorf("no suitable network configuration found")
 }
-
-// checkCertificateRenewal checks if a Let's Encrypt certificate needs renewal
-// Returns (needsRenewal, reason)
-func checkCertificateRenewal(certPath, keyPath string, logger zerolog.Logger) (bool, string) {
-	// Check if files exist
-	if _, err := os.Stat(certPath); os.IsNotExist(err) {
-		return true, "certificate file does not exist"
-	}
-	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
-		return true, "private key file does not exist"
-	}
-
-	// Load the certificate
-	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
-	if err != nil {
-		logger.Warn().
-			Err(err).
-			Str("cert_path", certPath).
-			Str("key_path", keyPath).
-			Msg("Failed to load existing certificate for renewal check")
-		return true, "failed to load certificate"
-	}
-
-	// Parse the certificate to check expiry
-	if len(cert.Certificate) == 0 {
-		return true, "certificate chain is empty"
-	}
-
-	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
-	if err != nil {
-		logger.Warn().
-			Err(err).
-			Msg("Failed to parse certificate for renewal check")
-		return true, "failed to parse certificate"
-	}
-
-	// Check if certificate is expired
-	now := time.Now()
-	if now.After(x509Cert.NotAfter) {
-		logger.Info().
-			Time("expired_at", x509Cert.NotAfter).
-			Msg("Certificate has expired")
-		return true, "certificate has expired"
-	}
-
-	// Check if certificate is not yet valid
-	if now.Before(x509Cert.NotBefore) {
-		logger.Warn().
-			Time("not_before", x509Cert.NotBefore).
-			Msg("Certificate is not yet valid")
-		return true, "certificate is not yet valid"
-	}
-
-	// Calculate remaining validity period
-	totalValidity := x509Cert.NotAfter.Sub(x509Cert.NotBefore)
-	remainingValidity := x509Cert.NotAfter.Sub(now)
-	halfLife := totalValidity / 2
-
-	// Renew if less than half the validity period remains
-	if remainingValidity < halfLife {
-		logger.Info().
-			Dur("total_validity", totalValidity).
-			Dur("remaining_validity", remainingValidity).
-			Dur("renewal_threshold", halfLife).
-			Time("expires_at", x509Cert.NotAfter).
-			Msg("Certificate has less than half its validity remaining")
-		return true, fmt.Sprintf("less than half validity remains (%s remaining, threshold %s)",
-			remainingValidity.Round(time.Hour), halfLife.Round(time.Hour))
-	}
-
-	// Certificate is still valid
-	logger.Info().
-		Time("not_before", x509Cert.NotBefore).
-		Time("not_after", x509Cert.NotAfter).
-		Dur("remaining_validity", remainingValidity).
-		Dur("total_validity", totalValidity).
-		Msg("Certificate is valid and does not need renewal yet")
-
-	return false, ""
-}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/acme"
+	"github.com/goodtune/kproxy/internal/ca"
+	"github.com/goodtune/kproxy/internal/dns"
+	miekg "github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+func TestDNSOverTLS(t *testing.T) {
+	dir := t.TempDir()
+	authority, err := ca.NewCA(ca.Config{
+		RootCertPath:  filepath.Join(dir, "root-ca.crt"),
+		RootKeyPath:   filepath.Join(dir, "root-ca.key"),
+		CertCacheSize: 10,
+		CertValidity:  time.Hour,
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A Let's Encrypt certificate for the server name, as the renewer finds
+	// it on disk
+	leCert := writeCertificate(t, dir, "kproxy.example.com")
+	letsEncrypt := acme.NewRenewer(acme.NewClient(acme.Config{
+		Domain:   "kproxy.example.com",
+		CertPath: filepath.Join(dir, "le.crt"),
+		KeyPath:  filepath.Join(dir, "le.key"),
+	}, zerolog.Nop()), 0, zerolog.Nop())
+	if err := letsEncrypt.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := dns.NewServer(dns.Config{
+		DoTAddr:        ln.Addr().String(),
+		GetCertificate: serverNameCertificate(authority, letsEncrypt, "kproxy.example.com"),
+		StatusName:     "status.kproxy.lan",
+	}, nil, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetDoTListener(ln)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Stop() }()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.RootCertificate())
+	roots.AddCert(leCert)
+
+	tests := []struct {
+		name       string
+		sni        string
+		wantLE     bool
+		verifyName string
+	}{
+		{"server name gets the Let's Encrypt certificate", "kproxy.example.com", true, "kproxy.example.com"},
+		{"other names get a CA certificate", "dns.kproxy.lan", false, "dns.kproxy.lan"},
+		{"no SNI is treated as the server name", "", true, "kproxy.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &miekg.Client{
+				Net: "tcp-tls",
+				TLSConfig: &tls.Config{
+					ServerName: tt.sni,
+					RootCAs:    roots,
+					// Without a server name the client can't verify; the
+					// certificate is checked below instead
+					InsecureSkipVerify: tt.sni == "",
+				},
+				Timeout: 5 * time.Second,
+			}
+			conn, err := client.Dial(ln.Addr().String())
+			if err != nil {
+				t.Fatalf("handshake failed: %v", err)
+			}
+			defer func() { _ = conn.Close() }()
+
+			state := conn.Conn.(*tls.Conn).ConnectionState()
+			leaf := state.PeerCertificates[0]
+			if leaf.Equal(leCert) != tt.wantLE {
+				t.Errorf("certificate issued by %q, want Let's Encrypt = %v", leaf.Issuer.CommonName, tt.wantLE)
+			}
+			if err := leaf.VerifyHostname(tt.verifyName); err != nil {
+				t.Errorf("certificate not valid for %s: %v", tt.verifyName, err)
+			}
+
+			query := new(miekg.Msg)
+			query.SetQuestion("status.kproxy.lan.", miekg.TypeA)
+			resp, _, err := client.ExchangeWithConn(query, conn)
+			if err != nil {
+				t.Fatalf("query failed: %v", err)
+			}
+			if resp.Id != query.Id || resp.Rcode != miekg.RcodeSuccess || !resp.Authoritative {
+				t.Errorf("response = %v, want an authoritative NOERROR answer to the query", resp)
+			}
+		})
+	}
+}
+
+// writeCertificate writes a self-signed certificate for name to le.crt and
+// le.key in dir and returns it
+func writeCertificate(t *testing.T, dir, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, "le.crt"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "le.key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
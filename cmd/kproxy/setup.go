@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/setup"
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+)
+
+var (
+	setupForce bool
+	setupWeb   string
+)
+
+var setupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Set up KProxy for the first time",
+	Long: `Set up KProxy in one guided step. Setup asks which network to serve and
+whether to hand out addresses with DHCP, then:
+
+  - writes the starter policies next to the config file (policies/), with
+    example child, teen and adult profiles and a profile for devices on the
+    network that aren't added yet
+  - generates the certificate authority (ca/), whose root certificate the
+    devices install
+  - writes a config file with the admin API enabled and a generated token
+    for the admin account, shown once at the end
+
+With --web, the questions are a web form instead, for a first boot without
+a terminal at hand. Submitting the form takes the one-time code printed
+here, and setup exits once it is done.
+
+An existing config file or policy files are only replaced with --force.`,
+	Example: `  sudo kproxy setup
+  sudo kproxy setup --web :8080
+  kproxy setup -c ./kproxy/config.yaml`,
+	Args: cobra.NoArgs,
+	RunE: runSetup,
+}
+
+func init() {
+	setupCmd.Flags().BoolVar(&setupForce, "force", false, "Replace an existing config file and policy files")
+	setupCmd.Flags().StringVar(&setupWeb, "web", "", "Ask the questions as a web form served on this address (e.g. :8080)")
+	rootCmd.AddCommand(setupCmd)
+}
+
+func runSetup(cmd *cobra.Command, args []string) error {
+	if _, err := os.Stat(configPath); err == nil && !setupForce {
+		return fmt.Errorf("%s already exists; use --force to set up again", configPath)
+	}
+
+	networks, err := setup.DetectNetworks()
+	if err != nil {
+		return fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+	if len(networks) == 0 {
+		return fmt.Errorf("no IPv4 networks found")
+	}
+
+	if setupWeb != "" {
+		return runSetupWeb(networks)
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	stdin := bufio.NewReader(os.Stdin)
+
+	_, _ = cyan.Println("Network")
+	for i, network := range networks {
+		fmt.Printf("  %d) %s\n", i+1, network)
+	}
+	choice := 1
+	if len(networks) > 1 {
+		choice, err = strconv.Atoi(ask(stdin, "Network to serve", "1"))
+		if err != nil || choice < 1 || choice > len(networks) {
+			return fmt.Errorf("choose a network from 1 to %d", len(networks))
+		}
+	}
+	o := setup.DefaultOptions(configPath, networks[choice-1])
+	if setup.RedisListening() {
+		o.Storage = "redis"
+	}
+	o.ServerName = ask(stdin, "Server name", o.ServerName)
+
+	fmt.Println()
+	_, _ = cyan.Println("DHCP")
+	fmt.Println("  KProxy can hand out addresses, with itself as DNS server. Turn off")
+	fmt.Println("  DHCP on your router first; otherwise point the router's DNS at KProxy.")
+	o.DHCP = confirm(stdin, "Enable DHCP? [y/N] ", false)
+	if o.DHCP {
+		o.RangeStart = net.ParseIP(ask(stdin, "Range start", ipOrEmpty(o.RangeStart)))
+		o.RangeEnd = net.ParseIP(ask(stdin, "Range end", ipOrEmpty(o.RangeEnd)))
+		o.Gateway = net.ParseIP(ask(stdin, "Gateway", ipOrEmpty(o.Gateway)))
+	}
+
+	fmt.Println()
+	_, _ = cyan.Println("Profiles")
+	o.ExampleProfiles = confirm(stdin, "Add example profiles (child, teen, adult)? [Y/n] ", true)
+	if o.ExampleProfiles {
+		fmt.Println("  Devices on the network that aren't added yet can get a profile;")
+		fmt.Println(`  "none" blocks them as unknown devices.`)
+		o.NetworkProfile = ask(stdin, "Profile for devices not yet added", o.NetworkProfile)
+	} else {
+		o.NetworkProfile = ""
+	}
+	if o.NetworkProfile == "none" {
+		o.NetworkProfile = ""
+	}
+
+	fmt.Println()
+	_, _ = cyan.Println("Storage")
+	o.Storage = ask(stdin, "Storage (redis, bolt for a file, or memory, which loses usage on restart)", o.Storage)
+
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	fmt.Println()
+	if !confirm(stdin, fmt.Sprintf("Write %s? [Y/n] ", configPath), true) {
+		return fmt.Errorf("setup cancelled")
+	}
+
+	result, err := setup.Run(o, setupForce, zerolog.Nop())
+	if err != nil {
+		return err
+	}
+	printSetupResult(result, o.ServerName)
+	return nil
+}
+
+// runSetupWeb serves the setup form until it has been submitted
+func runSetupWeb(networks []setup.Network) error {
+	wizard, err := setup.NewWizard(configPath, networks, setupForce, zerolog.Nop())
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", setupWeb)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: wizard, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = srv.Serve(ln) }()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	fmt.Println("Open the setup form at:")
+	for _, network := range networks {
+		fmt.Printf("  http://%s/\n", net.JoinHostPort(network.Address.String(), strconv.Itoa(port)))
+	}
+	fmt.Print("Setup code: ")
+	_, _ = color.New(color.Bold).Println(wizard.Code())
+
+	<-wizard.Done()
+
+	// Let the result page finish
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	fmt.Println()
+	printSetupResult(wizard.Result(), "")
+	return nil
+}
+
+// printSetupResult shows what setup wrote and what to do next
+func printSetupResult(result *setup.Result, serverName string) {
+	_, _ = color.New(color.FgGreen, color.Bold).Println("KProxy is set up")
+	fmt.Printf("Config:           %s\n", result.ConfigPath)
+	fmt.Printf("Policies:         %s\n", result.PolicyDir)
+	fmt.Printf("Root certificate: %s\n", result.RootCert)
+	fmt.Print("Admin token:      ")
+	_, _ = color.New(color.Bold).Println(result.AdminToken)
+	fmt.Println("Keep the token safe; it is only shown once (it is also in the config file).")
+	fmt.Println()
+	fmt.Println("Next:")
+	fmt.Println("  1. Start KProxy: sudo systemctl start kproxy (or kproxy server)")
+	fmt.Println("  2. Point your network's DNS at KProxy, unless it hands out addresses")
+	if serverName != "" {
+		fmt.Printf("  3. Install the root certificate on each device from https://%s/\n", serverName)
+	} else {
+		fmt.Println("  3. Install the root certificate on each device from the setup page (server.name)")
+	}
+	fmt.Printf("  4. Add your devices to %s/config.rego\n", result.PolicyDir)
+}
+
+// ask prompts for a value, returning def when the answer is empty
+func ask(in *bufio.Reader, prompt, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", prompt, def)
+	} else {
+		fmt.Printf("%s: ", prompt)
+	}
+	answer, _ := in.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// ipOrEmpty formats an optional address
+func ipOrEmpty(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenAdminURL string
+	tokenName     string
+	tokenScopes   []string
+	tokenExpires  string
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens for scripts and integrations",
+	Long: `Manage long-lived API tokens through the admin API of a running KProxy
+(admin.enabled must be set). Give Home Assistant or a script its own token
+instead of an admin account's, limited to what it needs:
+
+  *                 everything an admin account can do
+  read              read anything
+  <resource>        anything under /api/<resource>, e.g. maintenance
+  <resource>:read   read /api/<resource>, e.g. devices:read
+
+Tokens can't manage tokens or approve held changes whatever their scopes.
+Only a hash of each token is stored, so the token is shown once, when
+created. Changes made with a token appear in "kproxy audit" as
+token:<name>.`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create an API token",
+	Example: `  kproxy token create --name home-assistant --scope read --scope maintenance
+  kproxy token create --name backup --scope rules:read --expires 720h`,
+	Args: cobra.NoArgs,
+	RunE: runTokenCreate,
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens",
+	Args:  cobra.NoArgs,
+	RunE:  runTokenList,
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an API token",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTokenRevoke,
+}
+
+func init() {
+	tokenCreateCmd.Flags().StringVar(&tokenName, "name", "", "Name of the token, e.g. home-assistant (required)")
+	tokenCreateCmd.Flags().StringArrayVar(&tokenScopes, "scope", nil, "Scope granted to the token (repeatable, required)")
+	tokenCreateCmd.Flags().StringVar(&tokenExpires, "expires", "", "Lifetime of the token, e.g. 720h (default: until revoked)")
+	_ = tokenCreateCmd.MarkFlagRequired("name")
+	_ = tokenCreateCmd.MarkFlagRequired("scope")
+	tokenCmd.PersistentFlags().StringVar(&tokenAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenRevokeCmd)
+	rootCmd.AddCommand(tokenCmd)
+}
+
+func runTokenCreate(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(tokenAdminURL)
+	if err != nil {
+		return err
+	}
+
+	req := admin.APITokenRequest{Name: tokenName, Scopes: tokenScopes, Expires: tokenExpires}
+	created, err := api.CreateAPIToken(cmd.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created API token %s (%s)\n", created.Name, created.ID)
+	fmt.Printf("  Scopes:  %s\n", strings.Join(created.Scopes, ", "))
+	if created.ExpiresAt != nil {
+		fmt.Printf("  Expires: %s\n", created.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	}
+	_, _ = color.New(color.FgYellow).Println("\nCopy the token now; it can't be shown again:")
+	fmt.Println(created.Token)
+	return nil
+}
+
+func runTokenList(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(tokenAdminURL)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := api.APITokens(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	_, _ = cyan.Printf("%-18s %-20s %-30s %-12s %-17s %s\n", "ID", "NAME", "SCOPES", "CREATED BY", "CREATED", "EXPIRES")
+	for _, token := range tokens {
+		expires := "never"
+		if token.ExpiresAt != nil {
+			expires = token.ExpiresAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-18s %-20s %-30s %-12s %-17s %s\n", token.ID, token.Name, strings.Join(token.Scopes, ","), token.CreatedBy, token.Created.Local().Format("2006-01-02 15:04"), expires)
+	}
+	if len(tokens) == 0 {
+		fmt.Println("(no API tokens)")
+	}
+	return nil
+}
+
+func runTokenRevoke(cmd *cobra.Command, args []string) error {
+	api, err := newAdminClient(tokenAdminURL)
+	if err != nil {
+		return err
+	}
+	if err := api.RevokeAPIToken(cmd.Context(), args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked API token %s\n", args[0])
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/goodtune/kproxy/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	topAdminURL string
+	topInterval time.Duration
+	topClients  int
+	topOnce     bool
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Live terminal dashboard",
+	Long: `Show a live dashboard of a running KProxy in the terminal: DNS query rate,
+the busiest clients, recent blocks and today's usage meters. It polls the
+admin API (admin.enabled must be set), so it works over SSH on headless
+boxes. Press Ctrl-C to quit.`,
+	Example: `  kproxy top
+  kproxy top --interval 5s --clients 20
+  kproxy top --once`,
+	Args: cobra.NoArgs,
+	RunE: runTop,
+}
+
+func init() {
+	topCmd.Flags().StringVar(&topAdminURL, "admin-url", "", "Admin API base URL (default: derived from the config file)")
+	topCmd.Flags().DurationVar(&topInterval, "interval", 2*time.Second, "Refresh interval")
+	topCmd.Flags().IntVar(&topClients, "clients", 10, "Number of top clients to show")
+	topCmd.Flags().BoolVar(&topOnce, "once", false, "Print one snapshot and exit")
+
+	rootCmd.AddCommand(topCmd)
+}
+
+func runTop(cmd *cobra.Command, args []string) error {
+	if topInterval < 100*time.Millisecond {
+		return fmt.Errorf("invalid interval: %s", topInterval)
+	}
+	if topClients < 1 {
+		return fmt.Errorf("invalid number of clients: %d", topClients)
+	}
+
+	api, err := newAdminClient(topAdminURL)
+	if err != nil {
+		return err
+	}
+
+	if topOnce {
+		return renderTop(cmd.Context(), api, false)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	ticker := time.NewTicker(topInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := renderTop(cmd.Context(), api, true); err != nil {
+			// Keep polling; the server may be restarting
+			fmt.Printf("\n%s\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-sigChan:
+			fmt.Println()
+			return nil
+		}
+	}
+}
+
+// renderTop fetches the current activity and draws one screen
+func renderTop(ctx context.Context, api *client.Client, clear bool) error {
+	info, err := api.SystemInfo(ctx)
+	if err != nil {
+		return err
+	}
+	act, err := api.Activity(ctx, topClients)
+	if err != nil {
+		return err
+	}
+
+	cyan := color.New(color.FgCyan, color.Bold)
+	red := color.New(color.FgRed)
+	green := color.New(color.FgGreen)
+	bold := color.New(color.Bold)
+
+	if clear {
+		// Cursor home and clear screen
+		fmt.Print("\033[H\033[2J")
+	}
+
+	_, _ = bold.Printf("KProxy %s (%s)", info.Version, info.Mode)
+	fmt.Printf("  up %s  %s\n\n", info.Uptime, time.Now().Format("15:04:05"))
+
+	_, _ = cyan.Print("DNS  ")
+	fmt.Printf("%.1f queries/s, %d in the last minute\n", act.QPS, act.QueriesLastMinute)
+
+	_, _ = cyan.Println("\nTOP CLIENTS (last minute)")
+	if len(act.TopClients) == 0 {
+		fmt.Println("  (no queries)")
+	}
+	maxQueries := 1
+	for _, c := range act.TopClients {
+		maxQueries = max(maxQueries, c.Queries)
+	}
+	for _, c := range act.TopClients {
+		fmt.Printf("  %-39s %6d  %s\n", c.Client, c.Queries, strings.Repeat("█", (c.Queries*30+maxQueries-1)/maxQueries))
+	}
+
+	_, _ = cyan.Println("\nRECENT BLOCKS")
+	if len(act.RecentBlocks) == 0 {
+		fmt.Println("  (none)")
+	}
+	for i, b := range act.RecentBlocks {
+		if i == 10 {
+			break
+		}
+		fmt.Printf("  %s  %-5s  %-15s  ", b.Time.Local().Format("15:04:05"), b.Source, b.Client)
+		_, _ = red.Printf("%-40s", b.Host)
+		fmt.Printf("  %s\n", b.Reason)
+	}
+
+	_, _ = cyan.Println("\nUSAGE TODAY")
+	if len(act.Usage) == 0 {
+		fmt.Println("  (none)")
+	}
+	for _, m := range act.Usage {
+		fmt.Printf("  %-24s %-16s %8s", m.DeviceID, m.LimitID, (time.Duration(m.Seconds) * time.Second).String())
+		if m.Active {
+			_, _ = green.Print("  ● active")
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
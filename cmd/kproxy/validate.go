@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/fatih/color"
@@ -85,9 +86,12 @@ func getDefaultConfig() *config.Config {
 // setDefaultsForDump sets default configuration values (copied from config package)
 func setDefaultsForDump(v *viper.Viper) {
 	// Server defaults
+	v.SetDefault("server.mode", "full")
 	v.SetDefault("server.dns_port", 53)
 	v.SetDefault("server.dns_enable_udp", true)
 	v.SetDefault("server.dns_enable_tcp", true)
+	v.SetDefault("server.dns_enable_dot", false)
+	v.SetDefault("server.dot_port", 853)
 	v.SetDefault("server.http_port", 80)
 	v.SetDefault("server.https_port", 443)
 	v.SetDefault("server.admin_domain", "kproxy.home.local")
@@ -95,6 +99,20 @@ func setDefaultsForDump(v *viper.Viper) {
 	v.SetDefault("server.metrics_port", 9090)
 	v.SetDefault("server.bind_address", "0.0.0.0")
 	v.SetDefault("server.proxy_ip", "")
+	v.SetDefault("server.proxy_ipv6", "")
+	v.SetDefault("server.direct_ip_reverse_lookup", true)
+	v.SetDefault("server.http2", true)
+	v.SetDefault("server.quic_mode", "allow")
+	v.SetDefault("server.transparent.enabled", false)
+	v.SetDefault("server.transparent.mode", "tproxy")
+	v.SetDefault("server.transparent.http_port", 3129)
+	v.SetDefault("server.transparent.https_port", 3130)
+	v.SetDefault("server.forward_proxy.enabled", false)
+	v.SetDefault("server.forward_proxy.port", 3128)
+	v.SetDefault("server.forward_proxy.connect_ports", []int{})
+	v.SetDefault("server.tls_ports", []int{})
+	v.SetDefault("server.shutdown_delay", "0s")
+	v.SetDefault("server.shutdown_timeout", "5s")
 
 	// DNS defaults
 	v.SetDefault("dns.upstream_servers", []string{"8.8.8.8:53", "1.1.1.1:53"})
@@ -109,6 +127,15 @@ func setDefaultsForDump(v *viper.Viper) {
 		"time.*.com",
 		"time.*.gov",
 	})
+	v.SetDefault("dns.cache_enabled", true)
+	v.SetDefault("dns.cache_size", 10000)
+	v.SetDefault("dns.cache_backend", "memory")
+	v.SetDefault("dns.cname_inspection", true)
+	v.SetDefault("dns.rate_limit_qps", 0)
+	v.SetDefault("dns.rate_limit_burst", 100)
+	v.SetDefault("dns.edns_passthrough", false)
+	v.SetDefault("dns.dnssec", false)
+	v.SetDefault("dns.status_name", "")
 
 	// DHCP defaults
 	v.SetDefault("dhcp.enabled", false)
@@ -116,6 +143,16 @@ func setDefaultsForDump(v *viper.Viper) {
 	v.SetDefault("dhcp.bind_address", "0.0.0.0")
 	v.SetDefault("dhcp.lease_time", "24h")
 	v.SetDefault("dhcp.dns_servers", []string{})
+	v.SetDefault("dhcp.conflict_detection", false)
+	v.SetDefault("dhcp.probe_timeout", "500ms")
+	v.SetDefault("dhcp.conflict_hold", "1h")
+	v.SetDefault("dhcp.tftp.enabled", false)
+	v.SetDefault("dhcp.tftp.bind_address", "0.0.0.0")
+	v.SetDefault("dhcp.tftp.port", 69)
+	v.SetDefault("dhcp.tftp.root", "/var/lib/kproxy/tftp")
+	v.SetDefault("dhcp.ipxe.enabled", false)
+	v.SetDefault("dhcp.ipxe.title", "KProxy network boot")
+	v.SetDefault("dhcp.ipxe.timeout", "10s")
 
 	// TLS defaults
 	v.SetDefault("tls.ca_cert", "/etc/kproxy/ca/root-ca.crt")
@@ -125,24 +162,46 @@ func setDefaultsForDump(v *viper.Viper) {
 	v.SetDefault("tls.cert_cache_size", 1000)
 	v.SetDefault("tls.cert_cache_ttl", "24h")
 	v.SetDefault("tls.cert_validity", "24h")
+	v.SetDefault("tls.key_pool_size", 16)
 	v.SetDefault("tls.use_letsencrypt", false)
 	v.SetDefault("tls.lego_email", "")
 	v.SetDefault("tls.lego_dns_provider", "")
 	v.SetDefault("tls.lego_cert_path", "/etc/kproxy/certs/letsencrypt.crt")
 	v.SetDefault("tls.lego_key_path", "/etc/kproxy/certs/letsencrypt.key")
 	v.SetDefault("tls.lego_ca_dir_url", "https://acme-v02.api.letsencrypt.org/directory")
+	v.SetDefault("tls.lego_renew_before", "720h")
+	v.SetDefault("tls.lego_domains", []string{})
+	v.SetDefault("tls.client_cert_bypass", true)
+	v.SetDefault("tls.client_cert_bypass_ttl", "24h")
+	v.SetDefault("tls.issuance_log", true)
+	v.SetDefault("tls.issuance_log_retention", "720h")
 
 	// Storage defaults
 	v.SetDefault("storage.type", "redis")
+	v.SetDefault("storage.redis.mode", config.RedisStandalone)
 	v.SetDefault("storage.redis.host", "localhost")
 	v.SetDefault("storage.redis.port", 6379)
+	v.SetDefault("storage.redis.addrs", []string{})
+	v.SetDefault("storage.redis.master_name", "")
+	v.SetDefault("storage.redis.username", "")
 	v.SetDefault("storage.redis.password", "")
 	v.SetDefault("storage.redis.db", 0)
+	v.SetDefault("storage.redis.sentinel_username", "")
+	v.SetDefault("storage.redis.sentinel_password", "")
+	v.SetDefault("storage.redis.tls.enabled", false)
+	v.SetDefault("storage.redis.tls.ca_file", "")
+	v.SetDefault("storage.redis.tls.cert_file", "")
+	v.SetDefault("storage.redis.tls.key_file", "")
+	v.SetDefault("storage.redis.tls.server_name", "")
+	v.SetDefault("storage.redis.tls.insecure_skip_verify", false)
 	v.SetDefault("storage.redis.pool_size", 10)
 	v.SetDefault("storage.redis.min_idle_conns", 5)
 	v.SetDefault("storage.redis.dial_timeout", "5s")
 	v.SetDefault("storage.redis.read_timeout", "3s")
 	v.SetDefault("storage.redis.write_timeout", "3s")
+	v.SetDefault("storage.bolt.path", "/var/lib/kproxy/kproxy.db")
+	v.SetDefault("storage.health.interval", "10s")
+	v.SetDefault("storage.health.timeout", "2s")
 
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
@@ -158,16 +217,145 @@ func setDefaultsForDump(v *viper.Viper) {
 	v.SetDefault("policy.opa_policy_urls", []string{})
 	v.SetDefault("policy.opa_http_timeout", "30s")
 	v.SetDefault("policy.opa_http_retries", 3)
+	v.SetDefault("policy.opa_bundle_url", "")
+	v.SetDefault("policy.opa_bundle_key_file", "")
+	v.SetDefault("policy.opa_bundle_key_id", "default")
+	v.SetDefault("policy.opa_bundle_signing_algorithm", "RS256")
+	v.SetDefault("policy.opa_target", "rego")
+	v.SetDefault("policy.opa_policy_watch", false)
+	v.SetDefault("policy.opa_policy_watch_interval", "10s")
+	v.SetDefault("policy.opa_candidate_policy_dir", "")
+	v.SetDefault("policy.opa_candidate_policy_urls", []string{})
+	v.SetDefault("policy.opa_candidate_bundle_url", "")
+	v.SetDefault("policy.slow_eval_threshold", "100ms")
+	v.SetDefault("policy.decision_log.enabled", false)
+	v.SetDefault("policy.decision_log.sample_rate", 0.1)
+	v.SetDefault("policy.decision_log.max_size", 65536)
+	v.SetDefault("policy.decision_log.retention", "24h")
+	v.SetDefault("policy.request_headers", []string{"Referer", "Origin"})
 
 	// Usage tracking defaults
 	v.SetDefault("usage_tracking.inactivity_timeout", "2m")
 	v.SetDefault("usage_tracking.min_session_duration", "10s")
 	v.SetDefault("usage_tracking.daily_reset_time", "00:00")
+	v.SetDefault("usage_tracking.enforce_interval", "30s")
 
 	// Response modification defaults
 	v.SetDefault("response_modification.enabled", true)
 	v.SetDefault("response_modification.disabled_hosts", []string{"*.bank.com", "secure.*"})
 	v.SetDefault("response_modification.allowed_content_types", []string{"text/html"})
+
+	// Scripting defaults
+	v.SetDefault("scripting.enabled", false)
+	v.SetDefault("scripting.dir", "/etc/kproxy/scripts")
+	v.SetDefault("scripting.timeout", "50ms")
+	v.SetDefault("scripting.max_call_stack", 64)
+	v.SetDefault("scripting.max_registry_size", 16384)
+	v.SetDefault("scripting.max_memory_mb", 32)
+
+	// Identity defaults
+	v.SetDefault("identity.enabled", false)
+	v.SetDefault("identity.ttl", "10m")
+	v.SetDefault("identity.radius.enabled", false)
+	v.SetDefault("identity.radius.port", 1813)
+	v.SetDefault("identity.radius.secret", "")
+	v.SetDefault("identity.agent.enabled", false)
+	v.SetDefault("identity.agent.port", 9091)
+	v.SetDefault("identity.agent.token", "")
+	v.SetDefault("hostnames.enabled", false)
+	v.SetDefault("hostnames.ttl", "24h")
+	v.SetDefault("hostnames.mdns", true)
+	v.SetDefault("hostnames.netbios", true)
+
+	// External authorization defaults
+	v.SetDefault("ext_authz.enabled", false)
+	v.SetDefault("ext_authz.port", 9191)
+	v.SetDefault("ext_authz.token", "")
+
+	// Admin API defaults
+	v.SetDefault("admin.enabled", false)
+	v.SetDefault("admin.port", 9092)
+	v.SetDefault("admin.token", "")
+	v.SetDefault("admin.tls", false)
+	v.SetDefault("admin.approval.enabled", false)
+	v.SetDefault("admin.approval.window", "1h")
+	v.SetDefault("admin.discovery.enabled", false)
+	v.SetDefault("admin.discovery.profile", "quarantine")
+	v.SetDefault("admin.audit.enabled", true)
+	v.SetDefault("admin.audit.retention", "2160h")
+
+	// Bandwidth sharing defaults
+	v.SetDefault("bandwidth.enabled", false)
+	v.SetDefault("bandwidth.total_kbps", 0)
+
+	// Maintenance defaults
+	v.SetDefault("maintenance.enabled", false)
+	v.SetDefault("maintenance.message", "The network is undergoing maintenance and will be back shortly.")
+	v.SetDefault("maintenance.duration", "1h")
+	v.SetDefault("maintenance.dns_action", "intercept")
+
+	// Self-update defaults
+	v.SetDefault("update.url", "")
+	v.SetDefault("update.channel", "stable")
+	v.SetDefault("update.public_key", "")
+	v.SetDefault("update.check_interval", "24h")
+	v.SetDefault("update.auto_update", false)
+	v.SetDefault("update.systemd_unit", "kproxy.service")
+
+	// Request mirroring defaults
+	v.SetDefault("mirror.enabled", false)
+	v.SetDefault("mirror.url", "")
+	v.SetDefault("mirror.sample_rate", 1.0)
+	v.SetDefault("mirror.full_hosts", []string{})
+	v.SetDefault("mirror.max_body_bytes", 65536)
+	v.SetDefault("mirror.redact_client_ip", true)
+	v.SetDefault("mirror.redact_query", true)
+	v.SetDefault("mirror.redact_user_agent", false)
+	v.SetDefault("mirror.redact_headers", []string{})
+	v.SetDefault("mirror.queue_size", 1000)
+	v.SetDefault("mirror.timeout", "5s")
+
+	// Decision analytics defaults
+	v.SetDefault("analytics.enabled", false)
+	v.SetDefault("analytics.export_time", "02:00")
+	v.SetDefault("analytics.target", "csv")
+	v.SetDefault("analytics.csv.dir", "/var/lib/kproxy/analytics")
+	v.SetDefault("analytics.s3.endpoint", "")
+	v.SetDefault("analytics.s3.bucket", "")
+	v.SetDefault("analytics.s3.region", "us-east-1")
+	v.SetDefault("analytics.s3.prefix", "kproxy/")
+	v.SetDefault("analytics.s3.access_key_id", "")
+	v.SetDefault("analytics.s3.secret_access_key", "")
+	v.SetDefault("analytics.influxdb.url", "")
+	v.SetDefault("analytics.influxdb.org", "")
+	v.SetDefault("analytics.influxdb.bucket", "")
+	v.SetDefault("analytics.influxdb.token", "")
+
+	// Search monitoring defaults
+	v.SetDefault("search_monitoring.enabled", false)
+	v.SetDefault("search_monitoring.alert_webhook", "")
+	v.SetDefault("search_monitoring.alert_include_query", false)
+	v.SetDefault("search_monitoring.alert_timeout", "10s")
+
+	// Store-and-forward defaults
+	v.SetDefault("spool.enabled", true)
+	v.SetDefault("spool.dir", "/var/lib/kproxy/spool")
+	v.SetDefault("spool.max_size_mb", 100)
+	v.SetDefault("spool.retry_interval", "30s")
+
+	// Connectivity probe defaults
+	v.SetDefault("probes.enabled", false)
+	v.SetDefault("probes.targets", []string{"http://connectivitycheck.gstatic.com/generate_204", "https://www.cloudflare.com/cdn-cgi/trace"})
+	v.SetDefault("probes.interval", "1m")
+	v.SetDefault("probes.timeout", "10s")
+	v.SetDefault("probes.retention", "24h")
+
+	// Experimental feature defaults
+	v.SetDefault("features.h3_listener", false)
+	v.SetDefault("features.content_scanning", false)
+
+	// Egress route defaults
+	v.SetDefault("egress.default", "")
 }
 
 // findUnknownKeys loads the config file and checks for unknown keys
@@ -262,9 +450,12 @@ func dumpConfig(cfg, defaultCfg *config.Config, unknownKeys []string) {
 
 	// Server
 	_, _ = cyan.Println("\n[server]")
+	dumpField("  mode", cfg.Server.Mode, defaultCfg.Server.Mode, yellow, green)
 	dumpField("  dns_port", cfg.Server.DNSPort, defaultCfg.Server.DNSPort, yellow, green)
 	dumpField("  dns_enable_udp", cfg.Server.DNSEnableUDP, defaultCfg.Server.DNSEnableUDP, yellow, green)
 	dumpField("  dns_enable_tcp", cfg.Server.DNSEnableTCP, defaultCfg.Server.DNSEnableTCP, yellow, green)
+	dumpField("  dns_enable_dot", cfg.Server.DNSEnableDoT, defaultCfg.Server.DNSEnableDoT, yellow, green)
+	dumpField("  dot_port", cfg.Server.DoTPort, defaultCfg.Server.DoTPort, yellow, green)
 	dumpField("  http_port", cfg.Server.HTTPPort, defaultCfg.Server.HTTPPort, yellow, green)
 	dumpField("  https_port", cfg.Server.HTTPSPort, defaultCfg.Server.HTTPSPort, yellow, green)
 	dumpField("  admin_domain", cfg.Server.AdminDomain, defaultCfg.Server.AdminDomain, yellow, green)
@@ -272,15 +463,42 @@ func dumpConfig(cfg, defaultCfg *config.Config, unknownKeys []string) {
 	dumpField("  metrics_port", cfg.Server.MetricsPort, defaultCfg.Server.MetricsPort, yellow, green)
 	dumpField("  bind_address", cfg.Server.BindAddress, defaultCfg.Server.BindAddress, yellow, green)
 	dumpField("  proxy_ip", cfg.Server.ProxyIP, defaultCfg.Server.ProxyIP, yellow, green)
+	dumpField("  proxy_ipv6", cfg.Server.ProxyIPv6, defaultCfg.Server.ProxyIPv6, yellow, green)
+	dumpField("  direct_ip_reverse_lookup", cfg.Server.DirectIPReverseLookup, defaultCfg.Server.DirectIPReverseLookup, yellow, green)
+	dumpField("  http2", cfg.Server.HTTP2, defaultCfg.Server.HTTP2, yellow, green)
+	dumpField("  quic_mode", cfg.Server.QUICMode, defaultCfg.Server.QUICMode, yellow, green)
+	dumpField("  tls_ports", cfg.Server.TLSPorts, defaultCfg.Server.TLSPorts, yellow, green)
+	dumpField("  shutdown_delay", cfg.Server.ShutdownDelay, defaultCfg.Server.ShutdownDelay, yellow, green)
+	dumpField("  shutdown_timeout", cfg.Server.ShutdownTimeout, defaultCfg.Server.ShutdownTimeout, yellow, green)
+	_, _ = cyan.Println("  [server.transparent]")
+	dumpField("    enabled", cfg.Server.Transparent.Enabled, defaultCfg.Server.Transparent.Enabled, yellow, green)
+	dumpField("    mode", cfg.Server.Transparent.Mode, defaultCfg.Server.Transparent.Mode, yellow, green)
+	dumpField("    http_port", cfg.Server.Transparent.HTTPPort, defaultCfg.Server.Transparent.HTTPPort, yellow, green)
+	dumpField("    https_port", cfg.Server.Transparent.HTTPSPort, defaultCfg.Server.Transparent.HTTPSPort, yellow, green)
+	_, _ = cyan.Println("  [server.forward_proxy]")
+	dumpField("    enabled", cfg.Server.ForwardProxy.Enabled, defaultCfg.Server.ForwardProxy.Enabled, yellow, green)
+	dumpField("    port", cfg.Server.ForwardProxy.Port, defaultCfg.Server.ForwardProxy.Port, yellow, green)
+	dumpField("    users", accountNames(cfg.Server.ForwardProxy.Users), accountNames(defaultCfg.Server.ForwardProxy.Users), yellow, green)
+	dumpField("    connect_ports", cfg.Server.ForwardProxy.ConnectPorts, defaultCfg.Server.ForwardProxy.ConnectPorts, yellow, green)
 
 	// DNS
 	_, _ = cyan.Println("\n[dns]")
 	dumpField("  upstream_servers", cfg.DNS.UpstreamServers, defaultCfg.DNS.UpstreamServers, yellow, green)
+	dumpField("  forward_zones", cfg.DNS.ForwardZones, defaultCfg.DNS.ForwardZones, yellow, green)
 	dumpField("  intercept_ttl", cfg.DNS.InterceptTTL, defaultCfg.DNS.InterceptTTL, yellow, green)
 	dumpField("  bypass_ttl_cap", cfg.DNS.BypassTTLCap, defaultCfg.DNS.BypassTTLCap, yellow, green)
 	dumpField("  block_ttl", cfg.DNS.BlockTTL, defaultCfg.DNS.BlockTTL, yellow, green)
 	dumpField("  upstream_timeout", cfg.DNS.UpstreamTimeout, defaultCfg.DNS.UpstreamTimeout, yellow, green)
 	dumpField("  global_bypass", cfg.DNS.GlobalBypass, defaultCfg.DNS.GlobalBypass, yellow, green)
+	dumpField("  cache_enabled", cfg.DNS.CacheEnabled, defaultCfg.DNS.CacheEnabled, yellow, green)
+	dumpField("  cache_size", cfg.DNS.CacheSize, defaultCfg.DNS.CacheSize, yellow, green)
+	dumpField("  cache_backend", cfg.DNS.CacheBackend, defaultCfg.DNS.CacheBackend, yellow, green)
+	dumpField("  cname_inspection", cfg.DNS.CNAMEInspection, defaultCfg.DNS.CNAMEInspection, yellow, green)
+	dumpField("  rate_limit_qps", cfg.DNS.RateLimitQPS, defaultCfg.DNS.RateLimitQPS, yellow, green)
+	dumpField("  rate_limit_burst", cfg.DNS.RateLimitBurst, defaultCfg.DNS.RateLimitBurst, yellow, green)
+	dumpField("  edns_passthrough", cfg.DNS.EDNSPassthrough, defaultCfg.DNS.EDNSPassthrough, yellow, green)
+	dumpField("  dnssec", cfg.DNS.DNSSEC, defaultCfg.DNS.DNSSEC, yellow, green)
+	dumpField("  status_name", cfg.DNS.StatusName, defaultCfg.DNS.StatusName, yellow, green)
 
 	// DHCP
 	_, _ = cyan.Println("\n[dhcp]")
@@ -298,6 +516,24 @@ func dumpConfig(cfg, defaultCfg *config.Config, unknownKeys []string) {
 	dumpField("  boot_server_name", cfg.DHCP.BootServerName, defaultCfg.DHCP.BootServerName, yellow, green)
 	dumpField("  tftp_ip", cfg.DHCP.TFTPIP, defaultCfg.DHCP.TFTPIP, yellow, green)
 	dumpField("  boot_uri", cfg.DHCP.BootURI, defaultCfg.DHCP.BootURI, yellow, green)
+	dumpField("  scopes", cfg.DHCP.Scopes, defaultCfg.DHCP.Scopes, yellow, green)
+	dumpField("  options", cfg.DHCP.Options, defaultCfg.DHCP.Options, yellow, green)
+	dumpField("  vendor_options", cfg.DHCP.VendorOptions, defaultCfg.DHCP.VendorOptions, yellow, green)
+	dumpField("  conflict_detection", cfg.DHCP.ConflictDetection, defaultCfg.DHCP.ConflictDetection, yellow, green)
+	dumpField("  probe_timeout", cfg.DHCP.ProbeTimeout, defaultCfg.DHCP.ProbeTimeout, yellow, green)
+	dumpField("  conflict_hold", cfg.DHCP.ConflictHold, defaultCfg.DHCP.ConflictHold, yellow, green)
+	dumpField("  uefi_boot_filename", cfg.DHCP.UEFIBootFileName, defaultCfg.DHCP.UEFIBootFileName, yellow, green)
+	dumpField("  arm64_boot_filename", cfg.DHCP.ARM64BootFileName, defaultCfg.DHCP.ARM64BootFileName, yellow, green)
+	_, _ = cyan.Println("  [dhcp.tftp]")
+	dumpField("    enabled", cfg.DHCP.TFTP.Enabled, defaultCfg.DHCP.TFTP.Enabled, yellow, green)
+	dumpField("    bind_address", cfg.DHCP.TFTP.BindAddress, defaultCfg.DHCP.TFTP.BindAddress, yellow, green)
+	dumpField("    port", cfg.DHCP.TFTP.Port, defaultCfg.DHCP.TFTP.Port, yellow, green)
+	dumpField("    root", cfg.DHCP.TFTP.Root, defaultCfg.DHCP.TFTP.Root, yellow, green)
+	_, _ = cyan.Println("  [dhcp.ipxe]")
+	dumpField("    enabled", cfg.DHCP.IPXE.Enabled, defaultCfg.DHCP.IPXE.Enabled, yellow, green)
+	dumpField("    title", cfg.DHCP.IPXE.Title, defaultCfg.DHCP.IPXE.Title, yellow, green)
+	dumpField("    timeout", cfg.DHCP.IPXE.Timeout, defaultCfg.DHCP.IPXE.Timeout, yellow, green)
+	dumpField("    entries", cfg.DHCP.IPXE.Entries, defaultCfg.DHCP.IPXE.Entries, yellow, green)
 
 	// TLS
 	_, _ = cyan.Println("\n[tls]")
@@ -308,26 +544,50 @@ func dumpConfig(cfg, defaultCfg *config.Config, unknownKeys []string) {
 	dumpField("  cert_cache_size", cfg.TLS.CertCacheSize, defaultCfg.TLS.CertCacheSize, yellow, green)
 	dumpField("  cert_cache_ttl", cfg.TLS.CertCacheTTL, defaultCfg.TLS.CertCacheTTL, yellow, green)
 	dumpField("  cert_validity", cfg.TLS.CertValidity, defaultCfg.TLS.CertValidity, yellow, green)
+	dumpField("  key_pool_size", cfg.TLS.KeyPoolSize, defaultCfg.TLS.KeyPoolSize, yellow, green)
 	dumpField("  use_letsencrypt", cfg.TLS.UseLetsEncrypt, defaultCfg.TLS.UseLetsEncrypt, yellow, green)
 	dumpField("  lego_email", cfg.TLS.LegoEmail, defaultCfg.TLS.LegoEmail, yellow, green)
 	dumpField("  lego_dns_provider", cfg.TLS.LegoDNSProvider, defaultCfg.TLS.LegoDNSProvider, yellow, green)
 	dumpField("  lego_cert_path", cfg.TLS.LegoCertPath, defaultCfg.TLS.LegoCertPath, yellow, green)
 	dumpField("  lego_key_path", cfg.TLS.LegoKeyPath, defaultCfg.TLS.LegoKeyPath, yellow, green)
 	dumpField("  lego_ca_dir_url", cfg.TLS.LegoCADirURL, defaultCfg.TLS.LegoCADirURL, yellow, green)
+	dumpField("  lego_renew_before", cfg.TLS.LegoRenewBefore, defaultCfg.TLS.LegoRenewBefore, yellow, green)
+	dumpField("  lego_domains", cfg.TLS.LegoDomains, defaultCfg.TLS.LegoDomains, yellow, green)
+	dumpField("  client_cert_bypass", cfg.TLS.ClientCertBypass, defaultCfg.TLS.ClientCertBypass, yellow, green)
+	dumpField("  client_cert_bypass_ttl", cfg.TLS.ClientCertBypassTTL, defaultCfg.TLS.ClientCertBypassTTL, yellow, green)
+	dumpField("  issuance_log", cfg.TLS.IssuanceLog, defaultCfg.TLS.IssuanceLog, yellow, green)
+	dumpField("  issuance_log_retention", cfg.TLS.IssuanceLogRetention, defaultCfg.TLS.IssuanceLogRetention, yellow, green)
 
 	// Storage
 	_, _ = cyan.Println("\n[storage]")
 	dumpField("  type", cfg.Storage.Type, defaultCfg.Storage.Type, yellow, green)
 	_, _ = cyan.Println("  [storage.redis]")
+	dumpField("    mode", cfg.Storage.Redis.Mode, defaultCfg.Storage.Redis.Mode, yellow, green)
 	dumpField("    host", cfg.Storage.Redis.Host, defaultCfg.Storage.Redis.Host, yellow, green)
 	dumpField("    port", cfg.Storage.Redis.Port, defaultCfg.Storage.Redis.Port, yellow, green)
+	dumpField("    addrs", cfg.Storage.Redis.Addrs, defaultCfg.Storage.Redis.Addrs, yellow, green)
+	dumpField("    master_name", cfg.Storage.Redis.MasterName, defaultCfg.Storage.Redis.MasterName, yellow, green)
+	dumpField("    username", cfg.Storage.Redis.Username, defaultCfg.Storage.Redis.Username, yellow, green)
 	dumpField("    password", redactPassword(cfg.Storage.Redis.Password), redactPassword(defaultCfg.Storage.Redis.Password), yellow, green)
 	dumpField("    db", cfg.Storage.Redis.DB, defaultCfg.Storage.Redis.DB, yellow, green)
+	dumpField("    sentinel_username", cfg.Storage.Redis.SentinelUsername, defaultCfg.Storage.Redis.SentinelUsername, yellow, green)
+	dumpField("    sentinel_password", redactPassword(cfg.Storage.Redis.SentinelPassword), redactPassword(defaultCfg.Storage.Redis.SentinelPassword), yellow, green)
+	dumpField("    tls.enabled", cfg.Storage.Redis.TLS.Enabled, defaultCfg.Storage.Redis.TLS.Enabled, yellow, green)
+	dumpField("    tls.ca_file", cfg.Storage.Redis.TLS.CAFile, defaultCfg.Storage.Redis.TLS.CAFile, yellow, green)
+	dumpField("    tls.cert_file", cfg.Storage.Redis.TLS.CertFile, defaultCfg.Storage.Redis.TLS.CertFile, yellow, green)
+	dumpField("    tls.key_file", cfg.Storage.Redis.TLS.KeyFile, defaultCfg.Storage.Redis.TLS.KeyFile, yellow, green)
+	dumpField("    tls.server_name", cfg.Storage.Redis.TLS.ServerName, defaultCfg.Storage.Redis.TLS.ServerName, yellow, green)
+	dumpField("    tls.insecure_skip_verify", cfg.Storage.Redis.TLS.InsecureSkipVerify, defaultCfg.Storage.Redis.TLS.InsecureSkipVerify, yellow, green)
 	dumpField("    pool_size", cfg.Storage.Redis.PoolSize, defaultCfg.Storage.Redis.PoolSize, yellow, green)
 	dumpField("    min_idle_conns", cfg.Storage.Redis.MinIdleConns, defaultCfg.Storage.Redis.MinIdleConns, yellow, green)
 	dumpField("    dial_timeout", cfg.Storage.Redis.DialTimeout, defaultCfg.Storage.Redis.DialTimeout, yellow, green)
 	dumpField("    read_timeout", cfg.Storage.Redis.ReadTimeout, defaultCfg.Storage.Redis.ReadTimeout, yellow, green)
 	dumpField("    write_timeout", cfg.Storage.Redis.WriteTimeout, defaultCfg.Storage.Redis.WriteTimeout, yellow, green)
+	_, _ = cyan.Println("  [storage.bolt]")
+	dumpField("    path", cfg.Storage.Bolt.Path, defaultCfg.Storage.Bolt.Path, yellow, green)
+	_, _ = cyan.Println("  [storage.health]")
+	dumpField("    interval", cfg.Storage.Health.Interval, defaultCfg.Storage.Health.Interval, yellow, green)
+	dumpField("    timeout", cfg.Storage.Health.Timeout, defaultCfg.Storage.Health.Timeout, yellow, green)
 
 	// Logging
 	_, _ = cyan.Println("\n[logging]")
@@ -345,12 +605,30 @@ func dumpConfig(cfg, defaultCfg *config.Config, unknownKeys []string) {
 	dumpField("  opa_policy_urls", cfg.Policy.OPAPolicyURLs, defaultCfg.Policy.OPAPolicyURLs, yellow, green)
 	dumpField("  opa_http_timeout", cfg.Policy.OPAHTTPTimeout, defaultCfg.Policy.OPAHTTPTimeout, yellow, green)
 	dumpField("  opa_http_retries", cfg.Policy.OPAHTTPRetries, defaultCfg.Policy.OPAHTTPRetries, yellow, green)
+	dumpField("  opa_bundle_url", cfg.Policy.OPABundleURL, defaultCfg.Policy.OPABundleURL, yellow, green)
+	dumpField("  opa_bundle_key_file", cfg.Policy.OPABundleKeyFile, defaultCfg.Policy.OPABundleKeyFile, yellow, green)
+	dumpField("  opa_bundle_key_id", cfg.Policy.OPABundleKeyID, defaultCfg.Policy.OPABundleKeyID, yellow, green)
+	dumpField("  opa_bundle_signing_algorithm", cfg.Policy.OPABundleAlgorithm, defaultCfg.Policy.OPABundleAlgorithm, yellow, green)
+	dumpField("  opa_target", cfg.Policy.OPATarget, defaultCfg.Policy.OPATarget, yellow, green)
+	dumpField("  opa_policy_watch", cfg.Policy.OPAPolicyWatch, defaultCfg.Policy.OPAPolicyWatch, yellow, green)
+	dumpField("  opa_policy_watch_interval", cfg.Policy.OPAPolicyWatchInterval, defaultCfg.Policy.OPAPolicyWatchInterval, yellow, green)
+	dumpField("  opa_candidate_policy_dir", cfg.Policy.OPACandidatePolicyDir, defaultCfg.Policy.OPACandidatePolicyDir, yellow, green)
+	dumpField("  opa_candidate_policy_urls", cfg.Policy.OPACandidatePolicyURLs, defaultCfg.Policy.OPACandidatePolicyURLs, yellow, green)
+	dumpField("  opa_candidate_bundle_url", cfg.Policy.OPACandidateBundleURL, defaultCfg.Policy.OPACandidateBundleURL, yellow, green)
+	dumpField("  slow_eval_threshold", cfg.Policy.SlowEvalThreshold, defaultCfg.Policy.SlowEvalThreshold, yellow, green)
+	dumpField("  request_headers", cfg.Policy.RequestHeaders, defaultCfg.Policy.RequestHeaders, yellow, green)
+	_, _ = cyan.Println("  [policy.decision_log]")
+	dumpField("    enabled", cfg.Policy.DecisionLog.Enabled, defaultCfg.Policy.DecisionLog.Enabled, yellow, green)
+	dumpField("    sample_rate", cfg.Policy.DecisionLog.SampleRate, defaultCfg.Policy.DecisionLog.SampleRate, yellow, green)
+	dumpField("    max_size", cfg.Policy.DecisionLog.MaxSize, defaultCfg.Policy.DecisionLog.MaxSize, yellow, green)
+	dumpField("    retention", cfg.Policy.DecisionLog.Retention, defaultCfg.Policy.DecisionLog.Retention, yellow, green)
 
 	// Usage
 	_, _ = cyan.Println("\n[usage_tracking]")
 	dumpField("  inactivity_timeout", cfg.Usage.InactivityTimeout, defaultCfg.Usage.InactivityTimeout, yellow, green)
 	dumpField("  min_session_duration", cfg.Usage.MinSessionDuration, defaultCfg.Usage.MinSessionDuration, yellow, green)
 	dumpField("  daily_reset_time", cfg.Usage.DailyResetTime, defaultCfg.Usage.DailyResetTime, yellow, green)
+	dumpField("  enforce_interval", cfg.Usage.EnforceInterval, defaultCfg.Usage.EnforceInterval, yellow, green)
 
 	// Response modification
 	_, _ = cyan.Println("\n[response_modification]")
@@ -358,6 +636,145 @@ func dumpConfig(cfg, defaultCfg *config.Config, unknownKeys []string) {
 	dumpField("  disabled_hosts", cfg.Response.DisabledHosts, defaultCfg.Response.DisabledHosts, yellow, green)
 	dumpField("  allowed_content_types", cfg.Response.AllowedContentTypes, defaultCfg.Response.AllowedContentTypes, yellow, green)
 
+	// Scripting
+	_, _ = cyan.Println("\n[scripting]")
+	dumpField("  enabled", cfg.Scripting.Enabled, defaultCfg.Scripting.Enabled, yellow, green)
+	dumpField("  dir", cfg.Scripting.Dir, defaultCfg.Scripting.Dir, yellow, green)
+	dumpField("  timeout", cfg.Scripting.Timeout, defaultCfg.Scripting.Timeout, yellow, green)
+	dumpField("  max_call_stack", cfg.Scripting.MaxCallStack, defaultCfg.Scripting.MaxCallStack, yellow, green)
+	dumpField("  max_registry_size", cfg.Scripting.MaxRegistrySize, defaultCfg.Scripting.MaxRegistrySize, yellow, green)
+	dumpField("  max_memory_mb", cfg.Scripting.MaxMemoryMB, defaultCfg.Scripting.MaxMemoryMB, yellow, green)
+
+	// Identity
+	_, _ = cyan.Println("\n[identity]")
+	dumpField("  enabled", cfg.Identity.Enabled, defaultCfg.Identity.Enabled, yellow, green)
+	dumpField("  ttl", cfg.Identity.TTL, defaultCfg.Identity.TTL, yellow, green)
+	_, _ = cyan.Println("  [identity.radius]")
+	dumpField("    enabled", cfg.Identity.Radius.Enabled, defaultCfg.Identity.Radius.Enabled, yellow, green)
+	dumpField("    port", cfg.Identity.Radius.Port, defaultCfg.Identity.Radius.Port, yellow, green)
+	dumpField("    secret", redactPassword(cfg.Identity.Radius.Secret), redactPassword(defaultCfg.Identity.Radius.Secret), yellow, green)
+	_, _ = cyan.Println("  [identity.agent]")
+	dumpField("    enabled", cfg.Identity.Agent.Enabled, defaultCfg.Identity.Agent.Enabled, yellow, green)
+	dumpField("    port", cfg.Identity.Agent.Port, defaultCfg.Identity.Agent.Port, yellow, green)
+	dumpField("    token", redactPassword(cfg.Identity.Agent.Token), redactPassword(defaultCfg.Identity.Agent.Token), yellow, green)
+
+	// Hostnames
+	_, _ = cyan.Println("\n[hostnames]")
+	dumpField("  enabled", cfg.Hostnames.Enabled, defaultCfg.Hostnames.Enabled, yellow, green)
+	dumpField("  ttl", cfg.Hostnames.TTL, defaultCfg.Hostnames.TTL, yellow, green)
+	dumpField("  mdns", cfg.Hostnames.MDNS, defaultCfg.Hostnames.MDNS, yellow, green)
+	dumpField("  netbios", cfg.Hostnames.NetBIOS, defaultCfg.Hostnames.NetBIOS, yellow, green)
+
+	// External authorization
+	_, _ = cyan.Println("\n[ext_authz]")
+	dumpField("  enabled", cfg.ExtAuthz.Enabled, defaultCfg.ExtAuthz.Enabled, yellow, green)
+	dumpField("  port", cfg.ExtAuthz.Port, defaultCfg.ExtAuthz.Port, yellow, green)
+	dumpField("  token", redactPassword(cfg.ExtAuthz.Token), redactPassword(defaultCfg.ExtAuthz.Token), yellow, green)
+
+	// Admin API
+	_, _ = cyan.Println("\n[admin]")
+	dumpField("  enabled", cfg.Admin.Enabled, defaultCfg.Admin.Enabled, yellow, green)
+	dumpField("  port", cfg.Admin.Port, defaultCfg.Admin.Port, yellow, green)
+	dumpField("  token", redactPassword(cfg.Admin.Token), redactPassword(defaultCfg.Admin.Token), yellow, green)
+	dumpField("  tls", cfg.Admin.TLS, defaultCfg.Admin.TLS, yellow, green)
+	dumpField("  accounts", accountNames(cfg.Admin.Accounts), accountNames(defaultCfg.Admin.Accounts), yellow, green)
+	_, _ = cyan.Println("  [admin.approval]")
+	dumpField("    enabled", cfg.Admin.Approval.Enabled, defaultCfg.Admin.Approval.Enabled, yellow, green)
+	dumpField("    window", cfg.Admin.Approval.Window, defaultCfg.Admin.Approval.Window, yellow, green)
+	_, _ = cyan.Println("  [admin.discovery]")
+	dumpField("    enabled", cfg.Admin.Discovery.Enabled, defaultCfg.Admin.Discovery.Enabled, yellow, green)
+	dumpField("    profile", cfg.Admin.Discovery.Profile, defaultCfg.Admin.Discovery.Profile, yellow, green)
+	_, _ = cyan.Println("  [admin.audit]")
+	dumpField("    enabled", cfg.Admin.Audit.Enabled, defaultCfg.Admin.Audit.Enabled, yellow, green)
+	dumpField("    retention", cfg.Admin.Audit.Retention, defaultCfg.Admin.Audit.Retention, yellow, green)
+
+	// Bandwidth sharing
+	_, _ = cyan.Println("\n[bandwidth]")
+	dumpField("  enabled", cfg.Bandwidth.Enabled, defaultCfg.Bandwidth.Enabled, yellow, green)
+	dumpField("  total_kbps", cfg.Bandwidth.TotalKbps, defaultCfg.Bandwidth.TotalKbps, yellow, green)
+
+	// Maintenance
+	_, _ = cyan.Println("\n[maintenance]")
+	dumpField("  enabled", cfg.Maintenance.Enabled, defaultCfg.Maintenance.Enabled, yellow, green)
+	dumpField("  message", cfg.Maintenance.Message, defaultCfg.Maintenance.Message, yellow, green)
+	dumpField("  duration", cfg.Maintenance.Duration, defaultCfg.Maintenance.Duration, yellow, green)
+	dumpField("  dns_action", cfg.Maintenance.DNSAction, defaultCfg.Maintenance.DNSAction, yellow, green)
+
+	// Self-update
+	_, _ = cyan.Println("\n[update]")
+	dumpField("  url", cfg.Update.URL, defaultCfg.Update.URL, yellow, green)
+	dumpField("  channel", cfg.Update.Channel, defaultCfg.Update.Channel, yellow, green)
+	dumpField("  public_key", cfg.Update.PublicKey, defaultCfg.Update.PublicKey, yellow, green)
+	dumpField("  check_interval", cfg.Update.CheckInterval, defaultCfg.Update.CheckInterval, yellow, green)
+	dumpField("  auto_update", cfg.Update.AutoUpdate, defaultCfg.Update.AutoUpdate, yellow, green)
+	dumpField("  systemd_unit", cfg.Update.SystemdUnit, defaultCfg.Update.SystemdUnit, yellow, green)
+
+	// Request mirroring
+	_, _ = cyan.Println("\n[mirror]")
+	dumpField("  enabled", cfg.Mirror.Enabled, defaultCfg.Mirror.Enabled, yellow, green)
+	dumpField("  url", cfg.Mirror.URL, defaultCfg.Mirror.URL, yellow, green)
+	dumpField("  sample_rate", cfg.Mirror.SampleRate, defaultCfg.Mirror.SampleRate, yellow, green)
+	dumpField("  full_hosts", cfg.Mirror.FullHosts, defaultCfg.Mirror.FullHosts, yellow, green)
+	dumpField("  max_body_bytes", cfg.Mirror.MaxBodyBytes, defaultCfg.Mirror.MaxBodyBytes, yellow, green)
+	dumpField("  redact_client_ip", cfg.Mirror.RedactClientIP, defaultCfg.Mirror.RedactClientIP, yellow, green)
+	dumpField("  redact_query", cfg.Mirror.RedactQuery, defaultCfg.Mirror.RedactQuery, yellow, green)
+	dumpField("  redact_user_agent", cfg.Mirror.RedactUserAgent, defaultCfg.Mirror.RedactUserAgent, yellow, green)
+	dumpField("  redact_headers", cfg.Mirror.RedactHeaders, defaultCfg.Mirror.RedactHeaders, yellow, green)
+	dumpField("  queue_size", cfg.Mirror.QueueSize, defaultCfg.Mirror.QueueSize, yellow, green)
+	dumpField("  timeout", cfg.Mirror.Timeout, defaultCfg.Mirror.Timeout, yellow, green)
+
+	// Decision analytics
+	_, _ = cyan.Println("\n[analytics]")
+	dumpField("  enabled", cfg.Analytics.Enabled, defaultCfg.Analytics.Enabled, yellow, green)
+	dumpField("  export_time", cfg.Analytics.ExportTime, defaultCfg.Analytics.ExportTime, yellow, green)
+	dumpField("  target", cfg.Analytics.Target, defaultCfg.Analytics.Target, yellow, green)
+	_, _ = cyan.Println("  [analytics.csv]")
+	dumpField("    dir", cfg.Analytics.CSV.Dir, defaultCfg.Analytics.CSV.Dir, yellow, green)
+	_, _ = cyan.Println("  [analytics.s3]")
+	dumpField("    endpoint", cfg.Analytics.S3.Endpoint, defaultCfg.Analytics.S3.Endpoint, yellow, green)
+	dumpField("    bucket", cfg.Analytics.S3.Bucket, defaultCfg.Analytics.S3.Bucket, yellow, green)
+	dumpField("    region", cfg.Analytics.S3.Region, defaultCfg.Analytics.S3.Region, yellow, green)
+	dumpField("    prefix", cfg.Analytics.S3.Prefix, defaultCfg.Analytics.S3.Prefix, yellow, green)
+	dumpField("    access_key_id", cfg.Analytics.S3.AccessKeyID, defaultCfg.Analytics.S3.AccessKeyID, yellow, green)
+	dumpField("    secret_access_key", redactPassword(cfg.Analytics.S3.SecretAccessKey), redactPassword(defaultCfg.Analytics.S3.SecretAccessKey), yellow, green)
+	_, _ = cyan.Println("  [analytics.influxdb]")
+	dumpField("    url", cfg.Analytics.InfluxDB.URL, defaultCfg.Analytics.InfluxDB.URL, yellow, green)
+	dumpField("    org", cfg.Analytics.InfluxDB.Org, defaultCfg.Analytics.InfluxDB.Org, yellow, green)
+	dumpField("    bucket", cfg.Analytics.InfluxDB.Bucket, defaultCfg.Analytics.InfluxDB.Bucket, yellow, green)
+	dumpField("    token", redactPassword(cfg.Analytics.InfluxDB.Token), redactPassword(defaultCfg.Analytics.InfluxDB.Token), yellow, green)
+
+	// Search monitoring
+	_, _ = cyan.Println("\n[search_monitoring]")
+	dumpField("  enabled", cfg.Search.Enabled, defaultCfg.Search.Enabled, yellow, green)
+	dumpField("  alert_webhook", cfg.Search.AlertWebhook, defaultCfg.Search.AlertWebhook, yellow, green)
+	dumpField("  alert_include_query", cfg.Search.AlertIncludeQuery, defaultCfg.Search.AlertIncludeQuery, yellow, green)
+	dumpField("  alert_timeout", cfg.Search.AlertTimeout, defaultCfg.Search.AlertTimeout, yellow, green)
+
+	// Store-and-forward
+	_, _ = cyan.Println("\n[spool]")
+	dumpField("  enabled", cfg.Spool.Enabled, defaultCfg.Spool.Enabled, yellow, green)
+	dumpField("  dir", cfg.Spool.Dir, defaultCfg.Spool.Dir, yellow, green)
+	dumpField("  max_size_mb", cfg.Spool.MaxSizeMB, defaultCfg.Spool.MaxSizeMB, yellow, green)
+	dumpField("  retry_interval", cfg.Spool.RetryInterval, defaultCfg.Spool.RetryInterval, yellow, green)
+
+	// Connectivity probes
+	_, _ = cyan.Println("\n[probes]")
+	dumpField("  enabled", cfg.Probes.Enabled, defaultCfg.Probes.Enabled, yellow, green)
+	dumpField("  targets", cfg.Probes.Targets, defaultCfg.Probes.Targets, yellow, green)
+	dumpField("  interval", cfg.Probes.Interval, defaultCfg.Probes.Interval, yellow, green)
+	dumpField("  timeout", cfg.Probes.Timeout, defaultCfg.Probes.Timeout, yellow, green)
+	dumpField("  retention", cfg.Probes.Retention, defaultCfg.Probes.Retention, yellow, green)
+
+	// Experimental features
+	_, _ = cyan.Println("\n[features]")
+	dumpField("  h3_listener", cfg.Features.H3Listener, defaultCfg.Features.H3Listener, yellow, green)
+	dumpField("  content_scanning", cfg.Features.ContentScanning, defaultCfg.Features.ContentScanning, yellow, green)
+
+	// Egress routes (proxy URLs may hold passwords)
+	_, _ = cyan.Println("\n[egress]")
+	dumpField("  default", cfg.Egress.Default, defaultCfg.Egress.Default, yellow, green)
+	dumpField("  routes", routeNames(cfg.Egress.Routes), routeNames(defaultCfg.Egress.Routes), yellow, green)
+
 	_, _ = fmt.Fprintln(os.Stdout, "\n"+strings.Repeat("=", 80))
 	// Display unknown keys if any
 	if len(unknownKeys) > 0 {
@@ -394,3 +811,22 @@ func redactPassword(password string) string {
 	}
 	return "***REDACTED***"
 }
+
+// accountNames lists admin account names without their tokens
+func accountNames(accounts map[string]string) string {
+	names := make([]string, 0, len(accounts))
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// routeNames lists egress route names without their settings
+func routeNames(routes []config.EgressRouteConfig) string {
+	names := make([]string, 0, len(routes))
+	for _, route := range routes {
+		names = append(names, route.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,163 @@
+// Package access holds requests for access to blocked sites, made from the
+// block page, until a parent approves (with a temporary allow rule) or
+// denies them through the admin API.
+package access
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// maxAge is how long a request waits for an answer before it is dropped
+const maxAge = 24 * time.Hour
+
+// maxPending caps the requests waiting at once, so clients can't fill
+// memory by requesting access to many hosts
+const maxPending = 100
+
+// maxPendingPerClient caps the requests one client has waiting, so a single
+// client can't take every place in the queue and lock out the others
+const maxPendingPerClient = 10
+
+var (
+	// ErrNotFound is returned for a request that doesn't exist (or has expired)
+	ErrNotFound = errors.New("access request not found")
+
+	// ErrTooMany is returned when too many requests are already waiting
+	ErrTooMany = errors.New("too many access requests waiting")
+)
+
+// Request is a request from a blocked client for access to a host
+type Request struct {
+	ID          string    `json:"id"`
+	Client      string    `json:"client"`             // Client IP address
+	Host        string    `json:"host"`               // Blocked host
+	Path        string    `json:"path,omitempty"`     // Blocked path
+	Profile     string    `json:"profile"`            // Profile the block applied to
+	Category    string    `json:"category,omitempty"` // Category of the blocking rule
+	BlockReason string    `json:"block_reason"`       // Why policy blocked the request
+	Reason      string    `json:"reason,omitempty"`   // Why the client wants access
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// Queue holds access requests in memory until they are answered. Requests
+// from the same client for the same host and profile are merged.
+type Queue struct {
+	logger zerolog.Logger
+
+	mu       sync.Mutex
+	requests []Request
+	next     int
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates an empty access request queue
+func New(logger zerolog.Logger) *Queue {
+	return &Queue{
+		logger: logger.With().Str("component", "access").Logger(),
+		next:   1,
+		now:    time.Now,
+	}
+}
+
+// Submit queues a request. A repeated request from the same client for the
+// same host and profile replaces the earlier one's path and reason and keeps
+// its ID; it reports whether the request is new.
+func (q *Queue) Submit(req Request) (Request, bool, error) {
+	q.mu.Lock()
+	q.pruneLocked()
+
+	req.RequestedAt = q.now()
+	fromClient := 0
+	for i, r := range q.requests {
+		if r.Client != req.Client {
+			continue
+		}
+		if r.Host == req.Host && r.Profile == req.Profile {
+			req.ID = r.ID
+			q.requests[i] = req
+			q.mu.Unlock()
+			return req, false, nil
+		}
+		fromClient++
+	}
+	if len(q.requests) >= maxPending || fromClient >= maxPendingPerClient {
+		q.mu.Unlock()
+		return Request{}, false, ErrTooMany
+	}
+
+	req.ID = "access-" + strconv.Itoa(q.next)
+	q.next++
+	q.requests = append(q.requests, req)
+	q.mu.Unlock()
+
+	q.logger.Info().
+		Str("id", req.ID).
+		Str("client", req.Client).
+		Str("host", req.Host).
+		Str("profile", req.Profile).
+		Str("reason", req.Reason).
+		Msg("Access requested")
+	return req, true, nil
+}
+
+// List returns the waiting requests, oldest first
+func (q *Queue) List() []Request {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pruneLocked()
+	list := make([]Request, len(q.requests))
+	copy(list, q.requests)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].RequestedAt.Before(list[j].RequestedAt) })
+	return list
+}
+
+// Get returns a waiting request
+func (q *Queue) Get(id string) (Request, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pruneLocked()
+	for _, r := range q.requests {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return Request{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// Remove takes a request off the queue once it has been answered
+func (q *Queue) Remove(id string) (Request, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pruneLocked()
+	for i, r := range q.requests {
+		if r.ID == id {
+			q.requests = append(q.requests[:i], q.requests[i+1:]...)
+			return r, nil
+		}
+	}
+	return Request{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// pruneLocked drops requests that have waited too long
+func (q *Queue) pruneLocked() {
+	cutoff := q.now().Add(-maxAge)
+	kept := q.requests[:0]
+	for _, r := range q.requests {
+		if r.RequestedAt.After(cutoff) {
+			kept = append(kept, r)
+		}
+	}
+	q.requests = kept
+}
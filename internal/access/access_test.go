@@ -0,0 +1,82 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestQueue(t *testing.T) {
+	q := New(zerolog.Nop())
+	now := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+
+	first, created, err := q.Submit(Request{Client: "192.168.1.20", Host: "games.example", Profile: "kids", Reason: "homework"})
+	if err != nil || !created || first.ID != "access-1" {
+		t.Fatalf("Submit() = %+v, %v, %v, want new access-1", first, created, err)
+	}
+
+	// Asking again updates the same request
+	now = now.Add(time.Minute)
+	again, created, err := q.Submit(Request{Client: "192.168.1.20", Host: "games.example", Profile: "kids", Reason: "please"})
+	if err != nil || created || again.ID != first.ID {
+		t.Errorf("Submit() again = %+v, %v, %v, want access-1 updated", again, created, err)
+	}
+	if _, _, err := q.Submit(Request{Client: "192.168.1.21", Host: "games.example", Profile: "kids"}); err != nil {
+		t.Fatal(err)
+	}
+
+	list := q.List()
+	if len(list) != 2 || list[0].Reason != "please" {
+		t.Errorf("List() = %+v, want two requests with the updated reason", list)
+	}
+
+	if _, err := q.Remove("access-1"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := q.Get("access-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Remove() error = %v, want ErrNotFound", err)
+	}
+
+	// Unanswered requests are dropped after a day
+	now = now.Add(maxAge)
+	if list := q.List(); len(list) != 0 {
+		t.Errorf("List() after a day = %+v, want none", list)
+	}
+}
+
+func TestQueueLimit(t *testing.T) {
+	q := New(zerolog.Nop())
+	for i := 0; i < maxPending; i++ {
+		client := fmt.Sprintf("192.168.1.%d", 20+i/maxPendingPerClient)
+		if _, _, err := q.Submit(Request{Client: client, Host: fmt.Sprintf("host-%d.example", i), Profile: "kids"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := q.Submit(Request{Client: "192.168.1.200", Host: "one-more.example", Profile: "kids"}); !errors.Is(err, ErrTooMany) {
+		t.Errorf("Submit() over the limit error = %v, want ErrTooMany", err)
+	}
+}
+
+func TestQueueClientLimit(t *testing.T) {
+	q := New(zerolog.Nop())
+	for i := 0; i < maxPendingPerClient; i++ {
+		if _, _, err := q.Submit(Request{Client: "192.168.1.20", Host: fmt.Sprintf("host-%d.example", i), Profile: "kids"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := q.Submit(Request{Client: "192.168.1.20", Host: "one-more.example", Profile: "kids"}); !errors.Is(err, ErrTooMany) {
+		t.Errorf("Submit() over the client limit error = %v, want ErrTooMany", err)
+	}
+
+	// Repeats are still merged, and other clients still get in
+	if _, created, err := q.Submit(Request{Client: "192.168.1.20", Host: "host-0.example", Profile: "kids", Reason: "homework"}); err != nil || created {
+		t.Errorf("Submit() repeat = created %v, %v, want merged", created, err)
+	}
+	if _, _, err := q.Submit(Request{Client: "192.168.1.21", Host: "one-more.example", Profile: "kids"}); err != nil {
+		t.Errorf("Submit() from another client error = %v", err)
+	}
+}
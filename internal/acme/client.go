@@ -10,6 +10,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
@@ -22,12 +24,13 @@ import (
 
 // Config holds ACME client configuration
 type Config struct {
-	Email       string // Email for Let's Encrypt account
-	DNSProvider string // DNS provider name (e.g., "cloudflare", "route53")
-	CertPath    string // Path to store certificate
-	KeyPath     string // Path to store private key
-	CADirURL    string // ACME directory URL
-	Domain      string // Domain to obtain certificate for
+	Email       string   // Email for Let's Encrypt account
+	DNSProvider string   // DNS provider name (e.g., "cloudflare", "route53")
+	CertPath    string   // Path to store certificate
+	KeyPath     string   // Path to store private key
+	CADirURL    string   // ACME directory URL
+	Domain      string   // Domain to obtain certificate for
+	Domains     []string // Further names on the same certificate (SANs)
 }
 
 // User implements the ACME user interface
@@ -71,7 +74,7 @@ func (c *Client) ObtainCertificate() error {
 	log.SetFlags(log.LstdFlags)
 
 	c.logger.Info().
-		Str("domain", c.config.Domain).
+		Strs("domains", c.config.names()).
 		Str("dns_provider", c.config.DNSProvider).
 		Str("ca_url", c.config.CADirURL).
 		Msg("Starting ACME certificate acquisition")
@@ -137,11 +140,11 @@ func (c *Client) ObtainCertificate() error {
 
 	// Request certificate
 	c.logger.Info().
-		Str("domain", c.config.Domain).
+		Strs("domains", c.config.names()).
 		Msg("Requesting certificate from Let's Encrypt")
 
 	request := certificate.ObtainRequest{
-		Domains: []string{c.config.Domain},
+		Domains: c.config.names(),
 		Bundle:  true,
 	}
 
@@ -168,6 +171,18 @@ func (c *Client) ObtainCertificate() error {
 	return nil
 }
 
+// names returns every name the certificate is for, Domain first (it
+// becomes the subject)
+func (c Config) names() []string {
+	names := []string{c.Domain}
+	for _, name := range c.Domains {
+		if !slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(n, name) }) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // getDNSProvider creates a DNS provider from environment variables
 func (c *Client) getDNSProvider() (challenge.Provider, error) {
 	c.logger.Info().
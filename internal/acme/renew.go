@@ -0,0 +1,197 @@
+package acme
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultRenewBefore is how long before expiry a certificate is renewed
+// when no window is given
+const DefaultRenewBefore = 30 * 24 * time.Hour
+
+// renewCheckInterval is how often the background loop checks expiry
+const renewCheckInterval = 24 * time.Hour
+
+// Renewer keeps the certificate at the client's paths current. It checks
+// expiry daily, obtains a new certificate when little validity remains and
+// swaps it in: servers read Certificate on every handshake, so a renewal
+// takes effect without a restart.
+type Renewer struct {
+	certPath    string
+	keyPath     string
+	names       []string // Names the certificate must cover
+	renewBefore time.Duration
+	logger      zerolog.Logger
+	stopChan    chan struct{}
+
+	cert atomic.Pointer[tls.Certificate]
+
+	// Replaced in tests
+	obtain   func() error
+	now      func() time.Time
+	interval time.Duration
+}
+
+// NewRenewer creates a renewer for the certificate client obtains, renewing
+// it when less than renewBefore (DefaultRenewBefore if 0) remains
+func NewRenewer(client *Client, renewBefore time.Duration, logger zerolog.Logger) *Renewer {
+	if renewBefore <= 0 {
+		renewBefore = DefaultRenewBefore
+	}
+	return &Renewer{
+		certPath:    client.config.CertPath,
+		keyPath:     client.config.KeyPath,
+		names:       client.config.names(),
+		renewBefore: renewBefore,
+		logger:      logger.With().Str("component", "acme").Logger(),
+		stopChan:    make(chan struct{}),
+		obtain:      client.ObtainCertificate,
+		now:         time.Now,
+		interval:    renewCheckInterval,
+	}
+}
+
+// Certificate returns the current certificate, nil if none could be loaded
+// or obtained
+func (r *Renewer) Certificate() *tls.Certificate {
+	if r == nil {
+		return nil
+	}
+	return r.cert.Load()
+}
+
+// CertificateFor returns the current certificate if it is valid for
+// serverName, nil otherwise, so servers can choose it by SNI
+func (r *Renewer) CertificateFor(serverName string) *tls.Certificate {
+	cert := r.Certificate()
+	if cert == nil || cert.Leaf == nil || cert.Leaf.VerifyHostname(serverName) != nil {
+		return nil
+	}
+	return cert
+}
+
+// Start begins the daily background checks
+func (r *Renewer) Start() {
+	if r == nil {
+		return
+	}
+	go r.run()
+	r.logger.Info().
+		Dur("interval", r.interval).
+		Dur("renew_before", r.renewBefore).
+		Msg("Let's Encrypt certificate renewal checks started")
+}
+
+// Stop stops background checks
+func (r *Renewer) Stop() {
+	if r == nil {
+		return
+	}
+	close(r.stopChan)
+}
+
+// run is the background check loop. The first check is made by the caller
+// at startup, so the loop waits an interval before its own.
+func (r *Renewer) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stopChan:
+			return
+		}
+		if err := r.Check(); err != nil {
+			r.logger.Error().Err(err).Msg("Let's Encrypt certificate renewal failed, retrying at the next check")
+		}
+	}
+}
+
+// Check loads the certificate from disk and, if it is missing, invalid or
+// due for renewal, obtains a new one. The certificate on disk stays in use
+// when renewal fails.
+func (r *Renewer) Check() error {
+	reason := ""
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		reason = fmt.Sprintf("no usable certificate (%v)", err)
+	} else {
+		r.cert.Store(&cert)
+		reason = r.renewalReason(&cert)
+	}
+
+	if reason == "" {
+		r.logger.Debug().
+			Str("cert_path", r.certPath).
+			Time("not_after", cert.Leaf.NotAfter).
+			Msg("Let's Encrypt certificate does not need renewal yet")
+		return nil
+	}
+
+	r.logger.Info().
+		Str("cert_path", r.certPath).
+		Str("reason", reason).
+		Msg("Let's Encrypt certificate renewal needed, obtaining via ACME DNS-01 challenge")
+
+	if err := r.obtain(); err != nil {
+		return err
+	}
+	renewed, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load renewed certificate: %w", err)
+	}
+	r.cert.Store(&renewed)
+
+	r.logger.Info().
+		Str("cert_path", r.certPath).
+		Time("not_after", renewed.Leaf.NotAfter).
+		Msg("Let's Encrypt certificate renewed")
+	return nil
+}
+
+// renewalReason says why cert needs renewing, or returns "" when it
+// doesn't. A certificate is renewed when less than renewBefore remains, or
+// less than half its lifetime for short-lived certificates, and when a
+// configured name was added since it was issued.
+func (r *Renewer) renewalReason(cert *tls.Certificate) string {
+	leaf := cert.Leaf
+	for _, name := range r.names {
+		if name != "" && !covers(leaf, name) {
+			return fmt.Sprintf("certificate does not cover %s", name)
+		}
+	}
+
+	now := r.now()
+	if now.After(leaf.NotAfter) {
+		return "certificate has expired"
+	}
+	if now.Before(leaf.NotBefore) {
+		return "certificate is not yet valid"
+	}
+
+	threshold := r.renewBefore
+	if half := leaf.NotAfter.Sub(leaf.NotBefore) / 2; half < threshold {
+		threshold = half
+	}
+	if remaining := leaf.NotAfter.Sub(now); remaining < threshold {
+		return fmt.Sprintf("%s remaining, threshold %s", remaining.Round(time.Hour), threshold.Round(time.Hour))
+	}
+	return ""
+}
+
+// covers reports whether leaf is valid for name. A wildcard name is
+// covered only by the same wildcard, not by a certificate for one host.
+func covers(leaf *x509.Certificate, name string) bool {
+	if strings.HasPrefix(name, "*.") {
+		return slices.ContainsFunc(leaf.DNSNames, func(n string) bool { return strings.EqualFold(n, name) })
+	}
+	return leaf.VerifyHostname(name) == nil
+}
@@ -0,0 +1,174 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRenewerCheck(t *testing.T) {
+	dir := t.TempDir()
+	client := NewClient(Config{
+		CertPath: filepath.Join(dir, "le.crt"),
+		KeyPath:  filepath.Join(dir, "le.key"),
+	}, zerolog.Nop())
+	r := NewRenewer(client, 0, zerolog.Nop())
+
+	now := time.Now()
+	var obtained int
+	var failure error
+	r.obtain = func() error {
+		obtained++
+		if failure != nil {
+			return failure
+		}
+		writeCertificate(t, client.config.CertPath, client.config.KeyPath, now, now.Add(90*24*time.Hour))
+		return nil
+	}
+
+	// Nothing on disk: obtained straight away
+	if err := r.Check(); err != nil {
+		t.Fatal(err)
+	}
+	first := r.Certificate()
+	if obtained != 1 || first == nil {
+		t.Fatalf("obtained %d times, certificate %v; want one certificate", obtained, first)
+	}
+
+	// 60 days left: kept
+	r.now = func() time.Time { return now.Add(30 * 24 * time.Hour) }
+	if err := r.Check(); err != nil || obtained != 1 {
+		t.Fatalf("Check() = %v after %d obtains, want no renewal", err, obtained)
+	}
+
+	// 20 days left: renewed and swapped in
+	r.now = func() time.Time { return now.Add(70 * 24 * time.Hour) }
+	now = now.Add(time.Hour)
+	if err := r.Check(); err != nil || obtained != 2 {
+		t.Fatalf("Check() = %v after %d obtains, want a renewal", err, obtained)
+	}
+	if r.Certificate().Leaf.Equal(first.Leaf) {
+		t.Error("renewed certificate wasn't swapped in")
+	}
+
+	// A failed renewal keeps the current certificate
+	current := r.Certificate()
+	failure = errors.New("dns provider unavailable")
+	if err := r.Check(); !errors.Is(err, failure) {
+		t.Fatalf("Check() = %v, want %v", err, failure)
+	}
+	if !r.Certificate().Leaf.Equal(current.Leaf) {
+		t.Error("failed renewal replaced the certificate")
+	}
+
+	// Short-lived certificates are renewed at half their lifetime
+	failure = nil
+	writeCertificate(t, client.config.CertPath, client.config.KeyPath, now, now.Add(6*24*time.Hour))
+	obtained = 0
+	r.now = func() time.Time { return now.Add(2 * 24 * time.Hour) }
+	if err := r.Check(); err != nil || obtained != 0 {
+		t.Fatalf("Check() = %v after %d obtains, want no renewal with 4 of 6 days left", err, obtained)
+	}
+	r.now = func() time.Time { return now.Add(4 * 24 * time.Hour) }
+	if err := r.Check(); err != nil || obtained != 1 {
+		t.Fatalf("Check() = %v after %d obtains, want a renewal with 2 of 6 days left", err, obtained)
+	}
+}
+
+func TestRenewerNames(t *testing.T) {
+	dir := t.TempDir()
+	client := NewClient(Config{
+		CertPath: filepath.Join(dir, "le.crt"),
+		KeyPath:  filepath.Join(dir, "le.key"),
+		Domain:   "kproxy.example.com",
+		Domains:  []string{"admin.example.com", "*.block.example.com", "KPROXY.example.com"},
+	}, zerolog.Nop())
+	if names := client.config.names(); len(names) != 3 || names[0] != "kproxy.example.com" {
+		t.Errorf("names() = %v, want server name first without duplicates", names)
+	}
+	r := NewRenewer(client, 0, zerolog.Nop())
+
+	now := time.Now()
+	var obtained int
+	r.obtain = func() error {
+		obtained++
+		writeCertificate(t, client.config.CertPath, client.config.KeyPath, now, now.Add(90*24*time.Hour), "admin.example.com", "*.block.example.com")
+		return nil
+	}
+
+	// A valid certificate missing a configured name is replaced
+	writeCertificate(t, client.config.CertPath, client.config.KeyPath, now, now.Add(90*24*time.Hour), "admin.example.com")
+	if err := r.Check(); err != nil || obtained != 1 {
+		t.Fatalf("Check() = %v after %d obtains, want a renewal for the added name", err, obtained)
+	}
+	if err := r.Check(); err != nil || obtained != 1 {
+		t.Fatalf("Check() = %v after %d obtains, want no renewal once every name is covered", err, obtained)
+	}
+
+	// Chosen by SNI
+	for sni, want := range map[string]bool{
+		"kproxy.example.com":       true,
+		"Admin.Example.com":        true,
+		"denied.block.example.com": true,
+		"block.example.com":        false,
+		"www.example.com":          false,
+	} {
+		if got := r.CertificateFor(sni) != nil; got != want {
+			t.Errorf("CertificateFor(%q) = %v, want %v", sni, got, want)
+		}
+	}
+}
+
+func TestRenewerNil(t *testing.T) {
+	var r *Renewer
+	if r.Certificate() != nil || r.CertificateFor("kproxy.example.com") != nil {
+		t.Error("nil renewer returned a certificate")
+	}
+	r.Start()
+	r.Stop()
+}
+
+// writeCertificate writes a self-signed certificate and key valid between
+// notBefore and notAfter, for kproxy.example.com and any further names
+func writeCertificate(t *testing.T, certPath, keyPath string, notBefore, notAfter time.Time, names ...string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "kproxy.example.com"},
+		DNSNames:     append([]string{"kproxy.example.com"}, names...),
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "kproxy.example.com"}, SerialNumber: serial}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,194 @@
+package activity
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// window is how far back query rates and top clients look
+	window = 60 * time.Second
+
+	// qpsWindow is the span the current query rate is averaged over
+	qpsWindow = 10 * time.Second
+
+	// maxRecentBlocks is how many blocked requests are kept
+	maxRecentBlocks = 50
+)
+
+// Block is a blocked DNS query or web request
+type Block struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"` // "dns" or "proxy"
+	Client string    `json:"client"`
+	Host   string    `json:"host"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// ClientCount is the number of DNS queries from one client
+type ClientCount struct {
+	Client  string `json:"client"`
+	Queries int    `json:"queries"`
+}
+
+// Snapshot is the live view served to dashboards
+type Snapshot struct {
+	QPS               float64       `json:"qps"` // DNS queries per second over the last 10 seconds
+	QueriesLastMinute int           `json:"queries_last_minute"`
+	TopClients        []ClientCount `json:"top_clients"`   // Busiest clients over the last minute
+	RecentBlocks      []Block       `json:"recent_blocks"` // Newest first
+}
+
+// ClientActivity is the recent activity of one client
+type ClientActivity struct {
+	QueriesLastMinute int     `json:"queries_last_minute"`
+	RecentBlocks      []Block `json:"recent_blocks"` // Newest first
+}
+
+// second holds the query counts of one second
+type second struct {
+	unix    int64
+	total   int
+	clients map[string]int
+}
+
+// Recorder keeps a short in-memory history of DNS queries and blocks for
+// live dashboards, and streams events to subscribers as they happen. It is
+// not a log: only the last minute of query counts and the most recent
+// blocks are kept.
+type Recorder struct {
+	mu      sync.Mutex
+	seconds [60]second
+	blocks  []Block // Ring buffer
+	next    int     // Next ring position
+
+	events events
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewRecorder creates an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{
+		blocks: make([]Block, 0, maxRecentBlocks),
+		now:    time.Now,
+	}
+}
+
+// RecordQuery counts a DNS query from client
+func (r *Recorder) RecordQuery(client string) {
+	if r == nil {
+		return
+	}
+
+	now := r.now().Unix()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	slot := &r.seconds[now%int64(len(r.seconds))]
+	if slot.unix != now {
+		*slot = second{unix: now, clients: make(map[string]int)}
+	}
+	slot.total++
+	slot.clients[client]++
+}
+
+// RecordBlock remembers a blocked query or request and publishes it
+func (r *Recorder) RecordBlock(source, client, host, reason string) {
+	if r == nil {
+		return
+	}
+
+	block := Block{Time: r.now(), Source: source, Client: client, Host: host, Reason: reason}
+
+	r.mu.Lock()
+	if len(r.blocks) < maxRecentBlocks {
+		r.blocks = append(r.blocks, block)
+	} else {
+		r.blocks[r.next] = block
+	}
+	r.next = (r.next + 1) % maxRecentBlocks
+	r.mu.Unlock()
+
+	r.Publish(Event{Time: block.Time, Type: EventBlock, Source: source, Client: client, Host: host, Reason: reason})
+}
+
+// Snapshot returns current query rates, the busiest clients (at most top)
+// and recent blocks
+func (r *Recorder) Snapshot(top int) Snapshot {
+	now := r.now().Unix()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snap := Snapshot{TopClients: []ClientCount{}, RecentBlocks: []Block{}}
+
+	// The current second is still filling up, so rates use complete seconds
+	recent := 0
+	clients := make(map[string]int)
+	for _, s := range r.seconds {
+		age := now - s.unix
+		if s.clients == nil || age < 0 || age >= int64(window/time.Second) {
+			continue
+		}
+		snap.QueriesLastMinute += s.total
+		if age >= 1 && age <= int64(qpsWindow/time.Second) {
+			recent += s.total
+		}
+		for client, n := range s.clients {
+			clients[client] += n
+		}
+	}
+	snap.QPS = float64(recent) / qpsWindow.Seconds()
+
+	for client, n := range clients {
+		snap.TopClients = append(snap.TopClients, ClientCount{Client: client, Queries: n})
+	}
+	sort.Slice(snap.TopClients, func(i, j int) bool {
+		if snap.TopClients[i].Queries != snap.TopClients[j].Queries {
+			return snap.TopClients[i].Queries > snap.TopClients[j].Queries
+		}
+		return snap.TopClients[i].Client < snap.TopClients[j].Client
+	})
+	if len(snap.TopClients) > top {
+		snap.TopClients = snap.TopClients[:top]
+	}
+
+	// Walk the ring from newest to oldest
+	for i := 1; i <= len(r.blocks); i++ {
+		idx := (r.next - i + maxRecentBlocks) % maxRecentBlocks
+		snap.RecentBlocks = append(snap.RecentBlocks, r.blocks[idx])
+	}
+
+	return snap
+}
+
+// Client returns the queries of one client over the last minute and its
+// blocks among the recent ones
+func (r *Recorder) Client(client string) ClientActivity {
+	now := r.now().Unix()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	act := ClientActivity{RecentBlocks: []Block{}}
+	for _, s := range r.seconds {
+		age := now - s.unix
+		if s.clients == nil || age < 0 || age >= int64(window/time.Second) {
+			continue
+		}
+		act.QueriesLastMinute += s.clients[client]
+	}
+
+	for i := 1; i <= len(r.blocks); i++ {
+		block := r.blocks[(r.next-i+maxRecentBlocks)%maxRecentBlocks]
+		if block.Client == client {
+			act.RecentBlocks = append(act.RecentBlocks, block)
+		}
+	}
+
+	return act
+}
@@ -0,0 +1,128 @@
+package activity
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSnapshot_Queries(t *testing.T) {
+	r := NewRecorder()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	// 90 seconds ago: outside the window
+	now = now.Add(-90 * time.Second)
+	r.RecordQuery("192.168.1.9")
+
+	// 30 seconds ago: counted for the minute but not the current rate
+	now = now.Add(60 * time.Second)
+	for i := 0; i < 5; i++ {
+		r.RecordQuery("192.168.1.20")
+	}
+
+	// Last 10 seconds
+	now = now.Add(25 * time.Second)
+	for i := 0; i < 20; i++ {
+		r.RecordQuery("192.168.1.10")
+	}
+	r.RecordQuery("192.168.1.20")
+
+	now = now.Add(5 * time.Second)
+	snap := r.Snapshot(1)
+
+	if snap.QueriesLastMinute != 26 {
+		t.Errorf("queries last minute = %d, want 26", snap.QueriesLastMinute)
+	}
+	if snap.QPS != 2.1 {
+		t.Errorf("qps = %v, want 2.1", snap.QPS)
+	}
+	if len(snap.TopClients) != 1 || snap.TopClients[0] != (ClientCount{Client: "192.168.1.10", Queries: 20}) {
+		t.Errorf("top clients = %+v, want 192.168.1.10 only", snap.TopClients)
+	}
+}
+
+func TestSnapshot_RecentBlocks(t *testing.T) {
+	r := NewRecorder()
+
+	for i := 0; i < maxRecentBlocks+5; i++ {
+		r.RecordBlock("dns", "192.168.1.10", fmt.Sprintf("site%d.example.com", i), "")
+	}
+
+	blocks := r.Snapshot(10).RecentBlocks
+	if len(blocks) != maxRecentBlocks {
+		t.Fatalf("got %d blocks, want %d", len(blocks), maxRecentBlocks)
+	}
+	if want := fmt.Sprintf("site%d.example.com", maxRecentBlocks+4); blocks[0].Host != want {
+		t.Errorf("newest block = %s, want %s", blocks[0].Host, want)
+	}
+	if blocks[len(blocks)-1].Host != "site5.example.com" {
+		t.Errorf("oldest block = %s, want site5.example.com", blocks[len(blocks)-1].Host)
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	r.RecordQuery("192.168.1.10")
+	r.RecordBlock("proxy", "192.168.1.10", "example.com", "blocked")
+}
+
+func TestClient(t *testing.T) {
+	r := NewRecorder()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	r.RecordQuery("192.168.5.37")
+	r.RecordQuery("192.168.5.37")
+	r.RecordQuery("192.168.1.10")
+	r.RecordBlock("dns", "192.168.5.37", "ads.example.com", "BLOCK")
+	r.RecordBlock("proxy", "192.168.1.10", "games.example.com", "outside allowed hours")
+	r.RecordBlock("proxy", "192.168.5.37", "tiktok.com", "matched block rule")
+
+	act := r.Client("192.168.5.37")
+	if act.QueriesLastMinute != 2 {
+		t.Errorf("queries = %d, want 2", act.QueriesLastMinute)
+	}
+	if len(act.RecentBlocks) != 2 || act.RecentBlocks[0].Host != "tiktok.com" {
+		t.Errorf("blocks = %+v, want tiktok.com then ads.example.com", act.RecentBlocks)
+	}
+
+	if act := r.Client("192.168.9.9"); act.QueriesLastMinute != 0 || len(act.RecentBlocks) != 0 {
+		t.Errorf("unknown client = %+v, want nothing", act)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	r := NewRecorder()
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	// Without subscribers events go nowhere
+	r.Publish(Event{Type: EventDNS, Host: "example.com"})
+
+	events, cancel := r.Subscribe()
+	r.Publish(Event{Type: EventDNS, Client: "192.168.1.10", Host: "example.com", Action: "ALLOW"})
+	r.RecordBlock("proxy", "192.168.1.10", "ads.example.com", "blocked by rule")
+
+	if got := <-events; got.Type != EventDNS || got.Host != "example.com" || !got.Time.Equal(now) {
+		t.Errorf("first event = %+v, want the DNS query stamped now", got)
+	}
+	if got := <-events; got.Type != EventBlock || got.Source != "proxy" || got.Reason != "blocked by rule" {
+		t.Errorf("second event = %+v, want the block", got)
+	}
+
+	// A subscriber that falls behind misses events rather than holding up
+	// the publisher
+	for i := 0; i < subscriberBuffer+10; i++ {
+		r.Publish(Event{Type: EventDNS})
+	}
+	if len(events) != subscriberBuffer {
+		t.Errorf("buffered %d events, want %d", len(events), subscriberBuffer)
+	}
+
+	cancel()
+	cancel()
+	for range events {
+	}
+	r.Publish(Event{Type: EventDNS})
+}
@@ -0,0 +1,90 @@
+package activity
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types streamed to live dashboards
+const (
+	EventDNS          = "dns"           // A DNS query was answered
+	EventDecision     = "decision"      // The proxy decided on a request
+	EventBlock        = "block"         // A query or request was blocked
+	EventDevice       = "device"        // A new device was discovered
+	EventSessionStart = "session_start" // A usage session started
+	EventSessionStop  = "session_stop"  // A usage session ended
+)
+
+// EventTypes lists every event type
+var EventTypes = []string{EventDNS, EventDecision, EventBlock, EventDevice, EventSessionStart, EventSessionStop}
+
+// subscriberBuffer is how many events a subscriber may fall behind by
+// before further events are dropped for it
+const subscriberBuffer = 256
+
+// Event is something that just happened, streamed to live dashboards
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Source    string    `json:"source,omitempty"` // "dns", "proxy" or, for new devices, "dhcp"
+	Client    string    `json:"client,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	QueryType string    `json:"query_type,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Category  string    `json:"category,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	Device    string    `json:"device,omitempty"`
+	Limit     string    `json:"limit,omitempty"`   // Usage limit of a session
+	Seconds   int64     `json:"seconds,omitempty"` // Length of an ended session
+}
+
+// events fans published events out to subscribers
+type events struct {
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+}
+
+// Publish sends an event to every subscriber without waiting: a subscriber
+// that has fallen behind misses it. Without subscribers it does nothing.
+func (r *Recorder) Publish(event Event) {
+	if r == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = r.now()
+	}
+
+	r.events.mu.RLock()
+	defer r.events.mu.RUnlock()
+
+	for ch := range r.events.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving events as they are published, and a
+// function ending the subscription, which closes the channel
+func (r *Recorder) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	r.events.mu.Lock()
+	if r.events.subscribers == nil {
+		r.events.subscribers = make(map[chan Event]struct{})
+	}
+	r.events.subscribers[ch] = struct{}{}
+	r.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			r.events.mu.Lock()
+			delete(r.events.subscribers, ch)
+			r.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// maxAuditBody bounds each request, resource and response body kept in an
+// audit entry; larger ones are left out
+const maxAuditBody = 64 << 10
+
+// secretBodies are the paths whose request bodies are left out of audit
+// entries: a backup holds API token hashes and may hold the CA key
+var secretBodies = map[string]bool{
+	"/api/system/restore": true,
+}
+
+// SetAuditLog records every change made through the API in store for
+// retention, searched through GET /api/audit
+func (s *Server) SetAuditLog(store storage.AuditLogStore, retention time.Duration) {
+	s.audit = store
+	s.auditRetention = retention
+}
+
+// auditChanges records each successful change routed by mux: the account,
+// the request body, and the resource at the request path before and after,
+// read through its GET route where it has one. POST creates or acts on
+// something other than the collection at its path, so for POST, and
+// without a GET route, the response stands in for the resource after the
+// change.
+func (s *Server) auditChanges(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.audit == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// Keep the start of the body, passing all of it on to the handler
+		var body []byte
+		if r.Body != nil && !secretBodies[r.URL.Path] {
+			body, _ = io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		}
+
+		var before json.RawMessage
+		readable := false
+		if r.Method != http.MethodPost {
+			before, readable = s.snapshot(mux, r)
+		}
+		rec := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(rec, r)
+		if rec.status >= http.StatusMultipleChoices {
+			return
+		}
+		after := redactSecret(auditJSON(rec.body.Bytes()))
+		if readable {
+			after, _ = s.snapshot(mux, r)
+		}
+
+		id, err := newAuditID()
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Failed to record change in audit log")
+			return
+		}
+		now := time.Now()
+		client, _, _ := net.SplitHostPort(r.RemoteAddr)
+		entry := storage.AuditEntry{
+			ID:        id,
+			Time:      now,
+			Account:   account(r),
+			Client:    client,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    rec.status,
+			Request:   auditJSON(body),
+			Before:    before,
+			After:     after,
+			Changes:   diffJSON(before, after),
+			ExpiresAt: now.Add(s.auditRetention),
+		}
+		if err := s.audit.Add(r.Context(), entry); err != nil {
+			s.logger.Error().Err(err).Str("path", r.URL.Path).Msg("Failed to record change in audit log")
+		}
+	})
+}
+
+// snapshot reads the resource at the request path through its GET route.
+// It reports false when there is no such route, and returns nil when the
+// resource doesn't exist.
+func (s *Server) snapshot(mux *http.ServeMux, r *http.Request) (json.RawMessage, bool) {
+	get := r.Clone(r.Context())
+	get.Method = http.MethodGet
+	get.URL.RawQuery = ""
+	get.Body = http.NoBody
+	get.ContentLength = 0
+	if _, pattern := mux.Handler(get); !strings.HasPrefix(pattern, http.MethodGet+" ") {
+		return nil, false
+	}
+
+	rec := &auditRecorder{ResponseWriter: discardWriter{}, status: http.StatusOK}
+	mux.ServeHTTP(rec, get)
+	if rec.status != http.StatusOK {
+		return nil, true
+	}
+	return auditJSON(rec.body.Bytes()), true
+}
+
+// auditJSON returns body for an audit entry: as is when it is JSON, as a
+// JSON string otherwise, and nil when empty or too large
+func auditJSON(body []byte) json.RawMessage {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || len(body) > maxAuditBody {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(bytes.Clone(body))
+	}
+	data, err := json.Marshal(string(body))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// redactSecret blanks a top-level "token" field, the secret returned once
+// when an API token is created, so it never reaches the audit log
+func redactSecret(data json.RawMessage) json.RawMessage {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil || object["token"] == nil {
+		return data
+	}
+	object["token"] = json.RawMessage(`"[redacted]"`)
+	redacted, err := json.Marshal(object)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// diffJSON lists the fields that differ between two JSON documents,
+// flattening objects into dotted paths; arrays are compared whole
+func diffJSON(before, after json.RawMessage) []storage.AuditChange {
+	old := map[string]json.RawMessage{}
+	flattenJSON("", before, old)
+	updated := map[string]json.RawMessage{}
+	flattenJSON("", after, updated)
+
+	var changes []storage.AuditChange
+	for field, value := range old {
+		if newValue, ok := updated[field]; !ok || !bytes.Equal(value, newValue) {
+			changes = append(changes, storage.AuditChange{Field: field, Before: value, After: newValue})
+		}
+	}
+	for field, value := range updated {
+		if _, ok := old[field]; !ok {
+			changes = append(changes, storage.AuditChange{Field: field, After: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// flattenJSON adds the leaves of data to fields, in compact form, keyed by
+// their path under prefix. A document that isn't an object is the leaf ".".
+func flattenJSON(prefix string, data json.RawMessage, fields map[string]json.RawMessage) {
+	if len(data) == 0 {
+		return
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil || object == nil {
+		if prefix == "" {
+			prefix = "."
+		}
+		var b bytes.Buffer
+		if err := json.Compact(&b, data); err != nil {
+			return
+		}
+		fields[prefix] = b.Bytes()
+		return
+	}
+	for key, value := range object {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		flattenJSON(key, value, fields)
+	}
+}
+
+// newAuditID returns a random audit entry ID
+func newAuditID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// auditRecorder records the status and the start of the body of a response
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *auditRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *auditRecorder) Write(b []byte) (int, error) {
+	if r.body.Len() <= maxAuditBody {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// discardWriter is a ResponseWriter for internal reads of a resource
+type discardWriter struct{}
+
+func (discardWriter) Header() http.Header         { return http.Header{} }
+func (discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardWriter) WriteHeader(int)             {}
+
+// handleAuditLog searches the audit log, newest first. Query parameters:
+// account, path (substring), since (a duration, e.g. "24h") and limit
+// (default 100).
+func (s *Server) handleAuditLog(w http.ResponseWriter, r *http.Request) {
+	if s.audit == nil {
+		writeError(w, http.StatusNotFound, "audit log not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	who := query.Get("account")
+	path := query.Get("path")
+	var since time.Time
+	if v := query.Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid since: "+v)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	entries, err := s.audit.List(r.Context())
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list audit log")
+		writeError(w, http.StatusInternalServerError, "failed to list audit log")
+		return
+	}
+	matches := []storage.AuditEntry{}
+	for _, entry := range entries {
+		if len(matches) == limit || entry.Time.Before(since) {
+			break
+		}
+		if who != "" && entry.Account != who {
+			continue
+		}
+		if path != "" && !strings.Contains(entry.Path, path) {
+			continue
+		}
+		matches = append(matches, entry)
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+// handleAuditEntry returns one audit log entry
+func (s *Server) handleAuditEntry(w http.ResponseWriter, r *http.Request) {
+	if s.audit == nil {
+		writeError(w, http.StatusNotFound, "audit log not configured")
+		return
+	}
+
+	entries, err := s.audit.List(r.Context())
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list audit log")
+		writeError(w, http.StatusInternalServerError, "failed to list audit log")
+		return
+	}
+	for _, entry := range entries {
+		if entry.ID == r.PathValue("id") {
+			writeJSON(w, http.StatusOK, entry)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "audit entry not found")
+}
@@ -0,0 +1,131 @@
+package admin
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/goodtune/kproxy/internal/backup"
+	"github.com/goodtune/kproxy/internal/policyedit"
+)
+
+// maxRestoreSize bounds a backup uploaded to /api/system/restore
+const maxRestoreSize = 256 << 20
+
+// pendingRestore is a restore held for approval
+type pendingRestore struct {
+	Archive []byte `json:"archive"`
+	CA      bool   `json:"ca"`
+}
+
+// SetBackups sets the backups taken through /api/system/backup and
+// restored through /api/system/restore
+func (s *Server) SetBackups(m *backup.Manager) {
+	s.backups = m
+}
+
+// handleBackup returns a backup archive, with the CA certificates and keys
+// when ?ca=true
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if s.backups == nil {
+		writeError(w, http.StatusNotFound, "backups not configured")
+		return
+	}
+	withCA, ok := queryBool(w, r, "ca")
+	if !ok {
+		return
+	}
+
+	// Written in full first, so a failure can still be answered with an error
+	var buf bytes.Buffer
+	manifest, err := s.backups.Write(r.Context(), &buf, withCA)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Backup failed")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.logger.Info().Str("account", account(r)).Bool("ca", withCA).Msg("Backup taken")
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="kproxy-backup-`+manifest.Created.Format("20060102-150405")+`.tar.gz"`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to write backup")
+	}
+}
+
+// handleRestore restores a backup archive. The CA files in it are only
+// restored with ?ca=true; ?dry_run=true checks the archive and reports what
+// would change. With two-person approval, the archive is checked and held
+// for another admin to approve.
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if s.backups == nil {
+		writeError(w, http.StatusNotFound, "backups not configured")
+		return
+	}
+	withCA, ok := queryBool(w, r, "ca")
+	if !ok {
+		return
+	}
+	dryRun, ok := queryBool(w, r, "dry_run")
+	if !ok {
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hold := !dryRun && s.approvals != nil
+	result, ok := s.restore(w, r, data, withCA, dryRun || hold)
+	if !ok {
+		return
+	}
+	if hold && s.held(w, r, ActionRestore, "", pendingRestore{Archive: data, CA: withCA}) {
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// restore restores a backup archive, or with dryRun checks it and reports
+// what would change. Errors are answered here, returning false.
+func (s *Server) restore(w http.ResponseWriter, r *http.Request, data []byte, withCA, dryRun bool) (*backup.Result, bool) {
+	archive, err := backup.Read(bytes.NewReader(data))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	result, err := s.backups.Restore(r.Context(), archive, withCA, dryRun)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, backup.ErrUnrestorable) || errors.Is(err, policyedit.ErrCompile) {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, err.Error())
+		return nil, false
+	}
+
+	if !dryRun {
+		s.logger.Info().Str("account", account(r)).Time("created", archive.Manifest.Created).Msg("Backup restored")
+	}
+	return &result, true
+}
+
+// queryBool reads an optional boolean query parameter, answering 400 when
+// it is invalid
+func queryBool(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid "+name)
+		return false, false
+	}
+	return b, true
+}
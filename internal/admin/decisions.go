@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// maxDecisions bounds the decisions returned by one search
+const maxDecisions = 1000
+
+// SetDecisions sets the decision log read through /api/decisions. Searching
+// it needs storage that implements storage.DecisionHistory.
+func (s *Server) SetDecisions(store storage.DecisionLogStore) {
+	s.decisions = store
+}
+
+// handleDecisions searches the recorded decisions, newest first. Query
+// parameters: kind (dns or proxy), client (IP address), host, since and until
+// (a duration, e.g. "24h", a date or an RFC 3339 time) and limit (default
+// 100).
+func (s *Server) handleDecisions(w http.ResponseWriter, r *http.Request) {
+	if s.decisions == nil {
+		writeError(w, http.StatusNotFound, "decision log not configured")
+		return
+	}
+	history, ok := s.decisions.(storage.DecisionHistory)
+	if !ok {
+		writeError(w, http.StatusNotFound, "decision search needs storage.postgres, or bolt or memory storage")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.DecisionFilter{
+		Kind:   query.Get("kind"),
+		Client: query.Get("client"),
+		Host:   query.Get("host"),
+		Limit:  100,
+	}
+	if filter.Kind != "" && filter.Kind != "dns" && filter.Kind != "proxy" {
+		writeError(w, http.StatusBadRequest, "invalid kind: "+filter.Kind)
+		return
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := parseSince(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid "+name+": "+v)
+			return
+		}
+		*t = parsed
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDecisions {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		filter.Limit = n
+	}
+
+	decisions, err := history.List(r.Context(), filter)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to search decisions")
+		writeError(w, http.StatusInternalServerError, "failed to search decisions")
+		return
+	}
+	writeJSON(w, http.StatusOK, decisions)
+}
+
+// handleDecision returns one recorded decision
+func (s *Server) handleDecision(w http.ResponseWriter, r *http.Request) {
+	if s.decisions == nil {
+		writeError(w, http.StatusNotFound, "decision log not configured")
+		return
+	}
+
+	decision, err := s.decisions.Get(r.Context(), r.PathValue("id"))
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "decision not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to read decision")
+		writeError(w, http.StatusInternalServerError, "failed to read decision")
+		return
+	}
+	writeJSON(w, http.StatusOK, decision)
+}
+
+// parseSince reads a time given as a duration before now (24h), an RFC 3339
+// time or a date
+func parseSince(v string) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		if d <= 0 {
+			return time.Time{}, errors.New("duration must be positive")
+		}
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/activity"
+)
+
+// eventKeepAlive is how often an idle event stream sends a comment, so
+// clients and proxies in between don't time it out
+const eventKeepAlive = 15 * time.Second
+
+// handleEvents streams events as they happen as Server-Sent Events: DNS
+// queries, proxy decisions, blocks, new devices and usage session starts
+// and stops. Each is sent as an "event:" line with the type and a "data:"
+// line with the activity.Event as JSON. The types query parameter
+// (comma-separated) limits the stream to some types. Events are not
+// buffered for slow readers: a client that falls behind misses some.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if s.activity == nil {
+		writeError(w, http.StatusNotFound, "activity reporting not configured")
+		return
+	}
+
+	var types []string
+	if v := r.URL.Query().Get("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if !slices.Contains(activity.EventTypes, t) {
+				writeError(w, http.StatusBadRequest, "invalid event type: "+t)
+				return
+			}
+			types = append(types, t)
+		}
+	}
+
+	// The stream lasts as long as the client stays connected
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	events, cancel := s.activity.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		s.logger.Error().Err(err).Msg("Event stream not supported by connection")
+		return
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if types != nil && !slices.Contains(types, event.Type) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
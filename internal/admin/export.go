@@ -0,0 +1,403 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/desired"
+	"github.com/goodtune/kproxy/internal/devices"
+	"github.com/goodtune/kproxy/internal/policyedit"
+	"github.com/goodtune/kproxy/internal/rules"
+	"github.com/goodtune/kproxy/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportVersion is the version of the export document
+const ExportVersion = 1
+
+// configRego is the policy file holding the data exported as policy
+const configRego = "config.rego"
+
+// maxImportSize bounds an export document sent to /api/import
+const maxImportSize = 32 << 20
+
+// Export is the document served by /api/export and taken by /api/import:
+// the sections of config.rego managed by kproxy apply (devices, users,
+// profiles with their rules, time restrictions and usage limits, rule
+// sets, subnet profiles and bypass domains) and the runtime state added
+// through the admin API
+type Export struct {
+	Version  int           `json:"version"`
+	Exported time.Time     `json:"exported,omitempty"`
+	Policy   desired.State `json:"policy,omitempty"` // Omitted without policy editing
+
+	Rules       []rules.Rule         `json:"rules,omitempty"`
+	DeviceRules []storage.DeviceRule `json:"device_rules,omitempty"`
+	Devices     []devices.Device     `json:"devices,omitempty"`
+	Assignments map[string]string    `json:"assignments,omitempty"` // Device ID -> profile assigned at runtime
+	RuleSets    []storage.RuleSet    `json:"rule_sets,omitempty"`
+}
+
+// ImportResult is the outcome of an import
+type ImportResult struct {
+	DryRun      bool             `json:"dry_run"`
+	Policy      []desired.Change `json:"policy"` // Changes to config.rego
+	Rules       int              `json:"rules"`
+	DeviceRules int              `json:"device_rules"`
+	Devices     int              `json:"devices"`
+	Assignments int              `json:"assignments"`
+	RuleSets    int              `json:"rule_sets"`
+}
+
+// ParseExport reads an export document in JSON or YAML
+func ParseExport(data []byte) (Export, error) {
+	// YAML is a superset of JSON; go through JSON so the json tags apply
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Export{}, err
+	}
+	content, err := json.Marshal(doc)
+	if err != nil {
+		return Export{}, err
+	}
+	var export Export
+	if err := json.Unmarshal(content, &export); err != nil {
+		return Export{}, err
+	}
+	if export.Version != ExportVersion {
+		return Export{}, fmt.Errorf("unsupported export version %d (want %d)", export.Version, ExportVersion)
+	}
+	return export, nil
+}
+
+// MarshalExportYAML writes an export document as YAML, with the same field
+// names as JSON
+func MarshalExportYAML(export Export) ([]byte, error) {
+	content, err := json.Marshal(export)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// handleExport exports config.rego's data and the runtime state, as JSON
+// or with ?format=yaml as YAML
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "yaml" {
+		writeError(w, http.StatusBadRequest, "invalid format (use json or yaml)")
+		return
+	}
+
+	export := Export{Version: ExportVersion, Exported: time.Now().UTC()}
+	if s.policies != nil {
+		current, err := s.currentPolicy()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		export.Policy = desired.State{}
+		for _, section := range desired.Sections {
+			if value, ok := current[section]; ok {
+				export.Policy[section] = value
+			}
+		}
+	}
+	if s.rules != nil {
+		export.Rules = s.rules.List()
+	}
+	if s.deviceRules != nil {
+		export.DeviceRules = s.deviceRules.List("")
+	}
+	if s.devices != nil {
+		for _, device := range s.devices.Devices() {
+			// Quarantined devices are found again when next seen
+			if !device.Pending {
+				export.Devices = append(export.Devices, device)
+			}
+		}
+		for id, profile := range s.devices.PolicyProfiles() {
+			if export.Assignments == nil {
+				export.Assignments = make(map[string]string)
+			}
+			export.Assignments[id] = profile.(string)
+		}
+	}
+	if s.ruleSets != nil {
+		export.RuleSets = s.ruleSets.List()
+	}
+
+	if format != "yaml" {
+		writeJSON(w, http.StatusOK, export)
+		return
+	}
+	content, err := MarshalExportYAML(export)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(content); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to write export")
+	}
+}
+
+// handleImport imports an export document in JSON or YAML. Each config.rego
+// section in its policy replaces that section, and its runtime rules,
+// devices, assignments and rule sets are added or replaced by ID; nothing
+// else is removed. ?dry_run=true checks the document and reports the
+// changes without making them. With two-person approval, the document is
+// checked and held for another admin to approve.
+func (s *Server) handleImport(w http.ResponseWriter, r *http.Request) {
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid dry_run")
+			return
+		}
+		dryRun = b
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "export too large")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read body")
+		return
+	}
+	export, err := ParseExport(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid export: "+err.Error())
+		return
+	}
+
+	hold := !dryRun && s.approvals != nil
+	result, ok := s.importExport(w, r, export, dryRun || hold)
+	if !ok {
+		return
+	}
+	if hold && s.held(w, r, ActionImport, "", export) {
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// importExport imports an export document, or with dryRun checks it and
+// reports the changes. Errors are answered here, returning false.
+func (s *Server) importExport(w http.ResponseWriter, r *http.Request, export Export, dryRun bool) (*ImportResult, bool) {
+	result := &ImportResult{DryRun: dryRun, Policy: []desired.Change{}}
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return nil, false
+	}
+	profiles := lookup.Profiles
+	configured := make(map[string]bool)
+	for id := range lookup.Devices {
+		if s.devices != nil {
+			if _, runtime := s.devices.Get(id); runtime {
+				continue
+			}
+		}
+		configured[id] = true
+	}
+
+	// config.rego first, so the runtime state can use its profiles
+	var policy []byte
+	if len(export.Policy) > 0 {
+		if s.policies == nil {
+			writeError(w, http.StatusNotFound, "policy editing not configured")
+			return nil, false
+		}
+		current, err := s.currentPolicy()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return nil, false
+		}
+		imported := desired.State{}
+		for _, section := range desired.Sections {
+			if value, ok := export.Policy[section]; ok {
+				imported[section] = value
+			}
+		}
+		merged := desired.Merge(current, imported)
+		if err := desired.Validate(merged); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return nil, false
+		}
+		result.Policy = desired.Diff(current, merged)
+		if len(result.Policy) > 0 {
+			if policy, err = desired.RenderImport(merged); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return nil, false
+			}
+		}
+
+		profiles = profiles[:0:0]
+		mergedProfiles, _ := merged["profiles"].(map[string]interface{})
+		for id := range mergedProfiles {
+			profiles = append(profiles, id)
+		}
+		configured = make(map[string]bool)
+		mergedDevices, _ := merged["devices"].(map[string]interface{})
+		for id := range mergedDevices {
+			configured[id] = true
+		}
+	}
+
+	if err := s.checkImport(export, profiles, configured); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	if policy != nil {
+		if _, err := s.policies.Put(configRego, string(policy), dryRun); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, policyedit.ErrCompile) {
+				status = http.StatusBadRequest
+			}
+			writeError(w, status, err.Error())
+			return nil, false
+		}
+	}
+	if !dryRun {
+		if err := s.applyImport(r, export, result); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return nil, false
+		}
+		s.logger.Info().Str("account", account(r)).Int("policy_changes", len(result.Policy)).
+			Int("rules", result.Rules).Int("devices", result.Devices).Int("rule_sets", result.RuleSets).
+			Msg("Configuration imported")
+	} else {
+		result.Rules, result.DeviceRules, result.Devices = len(export.Rules), len(export.DeviceRules), len(export.Devices)
+		result.Assignments, result.RuleSets = len(export.Assignments), len(export.RuleSets)
+	}
+
+	return result, true
+}
+
+// checkImport checks that the runtime state of an export can be imported:
+// its parts are configured here and its profiles exist
+func (s *Server) checkImport(export Export, profiles []string, configured map[string]bool) error {
+	known := func(what, id, profile string) error {
+		if !slices.Contains(profiles, profile) {
+			return fmt.Errorf("%s %q: profile %q not found", what, id, profile)
+		}
+		return nil
+	}
+
+	if len(export.Rules) > 0 && s.rules == nil {
+		return errors.New("runtime rules not configured")
+	}
+	for _, rule := range export.Rules {
+		if err := known("rule", rule.ID, rule.Profile); err != nil {
+			return err
+		}
+	}
+	if (len(export.Devices) > 0 || len(export.Assignments) > 0) && s.devices == nil {
+		return errors.New("runtime devices not configured")
+	}
+	for _, device := range export.Devices {
+		if configured[device.ID] {
+			return fmt.Errorf("device %q is defined in config.rego", device.ID)
+		}
+		if err := known("device", device.ID, device.Profile); err != nil {
+			return err
+		}
+	}
+	for id, profile := range export.Assignments {
+		if err := known("assignment", id, profile); err != nil {
+			return err
+		}
+	}
+	if len(export.DeviceRules) > 0 && s.deviceRules == nil {
+		return errors.New("device rules not configured")
+	}
+	if len(export.RuleSets) > 0 && s.ruleSets == nil {
+		return errors.New("rule sets not configured")
+	}
+	return nil
+}
+
+// applyImport adds or replaces the runtime state of an export. Expired
+// rules are skipped, and device rules already in place aren't added again.
+func (s *Server) applyImport(r *http.Request, export Export, result *ImportResult) error {
+	now := time.Now()
+
+	for _, set := range export.RuleSets {
+		if _, _, err := s.ruleSets.Put(r.Context(), set.ID, set); err != nil {
+			return fmt.Errorf("rule set %q: %w", set.ID, err)
+		}
+		result.RuleSets++
+	}
+	for _, device := range export.Devices {
+		if _, _, err := s.devices.Put(device.ID, device.Name, device.Identifiers, device.Profile); err != nil {
+			return fmt.Errorf("device %q: %w", device.ID, err)
+		}
+		result.Devices++
+	}
+	for id, profile := range export.Assignments {
+		s.devices.Assign(id, profile)
+		result.Assignments++
+	}
+	for _, rule := range export.Rules {
+		var until time.Time
+		if rule.Until != nil {
+			if !rule.Until.After(now) {
+				continue
+			}
+			until = *rule.Until
+		}
+		if _, _, err := s.rules.Put(rule.ID, rule.Profile, rule.Domain, rule.Action, until); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.ID, err)
+		}
+		result.Rules++
+	}
+	for _, rule := range export.DeviceRules {
+		var until time.Time
+		if rule.Until != nil {
+			if !rule.Until.After(now) {
+				continue
+			}
+			until = *rule.Until
+		}
+		if slices.ContainsFunc(s.deviceRules.List(rule.Device), func(have storage.DeviceRule) bool {
+			return have.Domain == rule.Domain && have.Action == rule.Action
+		}) {
+			continue
+		}
+		if _, err := s.deviceRules.Add(r.Context(), rule.Device, rule.Domain, rule.Action, until); err != nil {
+			return fmt.Errorf("device rule %q: %w", rule.ID, err)
+		}
+		result.DeviceRules++
+	}
+	return nil
+}
+
+// currentPolicy reads the data in config.rego
+func (s *Server) currentPolicy() (desired.State, error) {
+	file, err := s.policies.Get(configRego)
+	if errors.Is(err, policyedit.ErrNotFound) {
+		return desired.State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return desired.Parse(configRego, []byte(file.Content))
+}
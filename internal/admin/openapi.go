@@ -0,0 +1,51 @@
+package admin
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:generate go run openapi_gen.go
+
+// openAPISpec is the OpenAPI description of the API, generated from the
+// routes and handlers by openapi_gen.go
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves the OpenAPI description of the API
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(openAPISpec); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to write OpenAPI description")
+	}
+}
+
+// apiDocsPage shows the OpenAPI description in Swagger UI, loaded from a CDN
+const apiDocsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<title>KProxy Admin API</title>
+	<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+	<div id="swagger-ui"></div>
+	<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+	<script>
+		SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#swagger-ui", persistAuthorization: true});
+	</script>
+</body>
+</html>
+`
+
+// handleAPIDocs serves Swagger UI for the API. Use its Authorize button to
+// try requests with a token.
+func (s *Server) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(apiDocsPage)); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to write API docs page")
+	}
+}
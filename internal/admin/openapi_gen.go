@@ -0,0 +1,372 @@
+//go:build ignore
+
+// openapi_gen writes openapi.json, the OpenAPI description of the admin API,
+// from the routes NewServer registers and the handlers' doc comments.
+// Run it with "go generate ./internal/admin" after changing a route, a
+// handler's doc comment or a request type.
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// pathParam matches the wildcards of a route pattern
+var pathParam = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// generator collects what the package declares
+type generator struct {
+	funcs   map[string]*ast.FuncDecl // Server methods by name
+	structs map[string]*ast.StructType
+	schemas map[string]interface{} // Request types referenced so far
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("openapi_gen: ")
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go") && info.Name() != "openapi_gen.go"
+	}, parser.ParseComments)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := &generator{
+		funcs:   map[string]*ast.FuncDecl{},
+		structs: map[string]*ast.StructType{},
+		schemas: map[string]interface{}{},
+	}
+	var routes [][2]string // Pattern, handler
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				switch d := decl.(type) {
+				case *ast.FuncDecl:
+					if d.Recv != nil {
+						g.funcs[d.Name.Name] = d
+					}
+				case *ast.GenDecl:
+					for _, spec := range d.Specs {
+						if ts, ok := spec.(*ast.TypeSpec); ok {
+							if st, ok := ts.Type.(*ast.StructType); ok {
+								g.structs[ts.Name.Name] = st
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	newServer, ok := findFunc(pkgs, "NewServer")
+	if !ok {
+		log.Fatal("NewServer not found")
+	}
+	ast.Inspect(newServer.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || !isSelector(call.Fun, "mux", "HandleFunc") || len(call.Args) != 2 {
+			return true
+		}
+		pattern, err := strconv.Unquote(call.Args[0].(*ast.BasicLit).Value)
+		if err != nil {
+			log.Fatal(err)
+		}
+		handler := call.Args[1].(*ast.SelectorExpr).Sel.Name
+		routes = append(routes, [2]string{pattern, handler})
+		return true
+	})
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route[0], " ")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(method)] = g.operation(method, path, route[1])
+	}
+
+	g.schemas["Error"] = map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+	}
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "KProxy Admin API",
+			"version":     "1",
+			"description": "Generated from internal/admin by openapi_gen.go. Authenticate with the admin token, an admin account's token or an API token.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"bearer": []string{}}},
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("openapi.json", append(data, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// operation describes one route from its handler
+func (g *generator) operation(method, path, handler string) map[string]interface{} {
+	fn, ok := g.funcs[handler]
+	if !ok {
+		log.Fatalf("handler %s not found", handler)
+	}
+
+	op := map[string]interface{}{
+		"operationId": strings.TrimPrefix(handler, "handle"),
+		"tags":        []string{strings.SplitN(strings.TrimPrefix(path, "/api/"), "/", 2)[0]},
+	}
+	if fn.Doc != nil {
+		text := strings.Join(strings.Fields(strings.TrimPrefix(fn.Doc.Text(), handler+" ")), " ")
+		text = capitalize(text)
+		summary, _, _ := strings.Cut(text, ". ")
+		op["summary"] = strings.TrimSuffix(summary, ".")
+		if summary != text {
+			op["description"] = text
+		}
+	}
+
+	var params []interface{}
+	for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+		params = append(params, map[string]interface{}{
+			"name": m[1], "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+
+	facts := &handlerFacts{query: map[string]bool{}}
+	g.inspect(fn, facts, map[string]bool{})
+	query := make([]string, 0, len(facts.query))
+	for name := range facts.query {
+		query = append(query, name)
+	}
+	sort.Strings(query)
+	for _, name := range query {
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "query",
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	if params != nil {
+		op["parameters"] = params
+	}
+
+	if facts.request != "" && method != "GET" {
+		op["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.ref(facts.request)},
+			},
+		}
+	}
+
+	status := "200"
+	if facts.created {
+		status = "201"
+	}
+	content := "application/json"
+	if facts.stream {
+		content = "text/event-stream"
+	}
+	op["responses"] = map[string]interface{}{
+		status: map[string]interface{}{
+			"description": "Success",
+			"content":     map[string]interface{}{content: map[string]interface{}{}},
+		},
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"}},
+			},
+		},
+	}
+	return op
+}
+
+// handlerFacts is what a handler's body shows about its request and response
+type handlerFacts struct {
+	request string          // Request body type
+	query   map[string]bool // Query parameters read
+	created bool            // Answers 201
+	stream  bool            // Streams Server-Sent Events
+}
+
+// inspect collects facts from fn and the Server methods it calls
+func (g *generator) inspect(fn *ast.FuncDecl, facts *handlerFacts, seen map[string]bool) {
+	if seen[fn.Name.Name] {
+		return
+	}
+	seen[fn.Name.Name] = true
+
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			if ident, ok := n.Type.(*ast.Ident); ok && strings.HasSuffix(ident.Name, "Request") && g.structs[ident.Name] != nil && facts.request == "" {
+				facts.request = ident.Name
+			}
+		case *ast.SelectorExpr:
+			if n.Sel.Name == "StatusCreated" {
+				facts.created = true
+			}
+		case *ast.BasicLit:
+			if n.Value == `"text/event-stream"` {
+				facts.stream = true
+			}
+		case *ast.CallExpr:
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if sel.Sel.Name == "Get" && len(n.Args) == 1 && readsQuery(sel.X) {
+				if lit, ok := n.Args[0].(*ast.BasicLit); ok {
+					name, _ := strconv.Unquote(lit.Value)
+					facts.query[name] = true
+				}
+			}
+			if recv, ok := sel.X.(*ast.Ident); ok && recv.Name == "s" {
+				if callee, ok := g.funcs[sel.Sel.Name]; ok {
+					g.inspect(callee, facts, seen)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// readsQuery reports whether x is the request's query: r.URL.Query() or a
+// variable named query holding it
+func readsQuery(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Name == "query"
+	case *ast.CallExpr:
+		return isSelectorName(x.Fun, "Query")
+	}
+	return false
+}
+
+// ref returns a reference to the schema of a package struct, adding it
+func (g *generator) ref(name string) map[string]interface{} {
+	if _, ok := g.schemas[name]; !ok {
+		g.schemas[name] = nil // Break cycles
+		g.schemas[name] = g.structSchema(g.structs[name])
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// structSchema describes a struct by its JSON fields
+func (g *generator) structSchema(st *ast.StructType) map[string]interface{} {
+	props := map[string]interface{}{}
+	for _, field := range st.Fields.List {
+		name := ""
+		if field.Tag != nil {
+			tag, _ := strconv.Unquote(field.Tag.Value)
+			name, _, _ = strings.Cut(reflect.StructTag(tag).Get("json"), ",")
+		}
+		if name == "-" || (name == "" && len(field.Names) == 0) {
+			continue
+		}
+		for _, ident := range field.Names {
+			if name == "" {
+				name = ident.Name
+			}
+		}
+		schema := g.typeSchema(field.Type)
+		if field.Comment != nil {
+			schema["description"] = strings.TrimSpace(field.Comment.Text())
+		}
+		props[name] = schema
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// typeSchema describes a field type
+func (g *generator) typeSchema(expr ast.Expr) map[string]interface{} {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return g.typeSchema(t.X)
+	case *ast.ArrayType:
+		return map[string]interface{}{"type": "array", "items": g.typeSchema(t.Elt)}
+	case *ast.MapType:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.typeSchema(t.Value)}
+	case *ast.SelectorExpr:
+		if isSelector(t, "time", "Time") {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return map[string]interface{}{"type": "string"}
+		case "bool":
+			return map[string]interface{}{"type": "boolean"}
+		case "int", "int64", "int32", "uint", "uint64", "uint32":
+			return map[string]interface{}{"type": "integer"}
+		case "float64", "float32":
+			return map[string]interface{}{"type": "number"}
+		}
+		if g.structs[t.Name] != nil {
+			return g.ref(t.Name)
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// findFunc finds a package-level function
+func findFunc(pkgs map[string]*ast.Package, name string) (*ast.FuncDecl, bool) {
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == name {
+					return fn, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// isSelector reports whether expr is x.sel
+func isSelector(expr ast.Expr, x, sel string) bool {
+	s, ok := expr.(*ast.SelectorExpr)
+	if !ok || s.Sel.Name != sel {
+		return false
+	}
+	ident, ok := s.X.(*ast.Ident)
+	return ok && ident.Name == x
+}
+
+// isSelectorName reports whether expr selects sel from anything
+func isSelectorName(expr ast.Expr, sel string) bool {
+	s, ok := expr.(*ast.SelectorExpr)
+	return ok && s.Sel.Name == sel
+}
+
+// capitalize upper-cases the first letter of s
+func capitalize(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
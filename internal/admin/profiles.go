@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/goodtune/kproxy/internal/rules"
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// ProfileInfo is a profile as listed on /api/profiles
+type ProfileInfo struct {
+	ID       string         `json:"id"`
+	Devices  []string       `json:"devices"`          // IDs of the devices with the profile
+	RuleSets []string       `json:"rule_sets"`        // IDs of the rule sets attached to it
+	Rules    int            `json:"rules"`            // Runtime rules for it
+	Paused   *storage.Pause `json:"paused,omitempty"` // nil unless the profile is paused
+}
+
+// ProfileDetail is a profile with its runtime rules and weekly schedule,
+// as returned by /api/profiles/{id}
+type ProfileDetail struct {
+	ProfileInfo
+	RuntimeRules []rules.Rule  `json:"runtime_rules"`
+	Schedule     *opa.Schedule `json:"schedule,omitempty"` // nil if the projection failed
+}
+
+// handleProfiles lists the profiles defined by policy with their devices,
+// rule sets, runtime rules and pauses
+func (s *Server) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return
+	}
+
+	list := make([]ProfileInfo, 0, len(lookup.Profiles))
+	for _, id := range lookup.Profiles {
+		list = append(list, s.profileInfo(id, lookup))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleProfile returns a profile with its runtime rules and weekly
+// schedule
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return
+	}
+	if !slices.Contains(lookup.Profiles, id) {
+		writeError(w, http.StatusNotFound, "profile not found")
+		return
+	}
+
+	detail := ProfileDetail{ProfileInfo: s.profileInfo(id, lookup), RuntimeRules: []rules.Rule{}}
+	if s.rules != nil {
+		for _, rule := range s.rules.List() {
+			if rule.Profile == id {
+				detail.RuntimeRules = append(detail.RuntimeRules, rule)
+			}
+		}
+	}
+
+	schedule, err := s.policy.ProfileSchedule(id)
+	if err != nil && !errors.Is(err, opa.ErrProfileNotFound) {
+		s.logger.Error().Err(err).Str("profile", id).Msg("Schedule projection failed")
+	}
+	detail.Schedule = schedule
+
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// profileInfo summarizes a profile from the devices policy knows and the
+// runtime state
+func (s *Server) profileInfo(id string, lookup *opa.DeviceLookup) ProfileInfo {
+	info := ProfileInfo{ID: id, Devices: []string{}, RuleSets: []string{}}
+	for deviceID, d := range lookup.Devices {
+		if d.Profile == id {
+			info.Devices = append(info.Devices, deviceID)
+		}
+	}
+	sort.Strings(info.Devices)
+
+	if s.ruleSets != nil {
+		for _, set := range s.ruleSets.List() {
+			if slices.Contains(set.Profiles, id) {
+				info.RuleSets = append(info.RuleSets, set.ID)
+			}
+		}
+	}
+	if s.rules != nil {
+		for _, rule := range s.rules.List() {
+			if rule.Profile == id {
+				info.Rules++
+			}
+		}
+	}
+	if s.pauses != nil {
+		if p, err := s.pauses.Get(storage.PauseProfile, id); err == nil {
+			info.Paused = &p
+		}
+	}
+	return info
+}
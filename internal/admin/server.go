@@ -0,0 +1,2521 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/access"
+	"github.com/goodtune/kproxy/internal/activity"
+	"github.com/goodtune/kproxy/internal/apitoken"
+	"github.com/goodtune/kproxy/internal/approval"
+	"github.com/goodtune/kproxy/internal/backup"
+	"github.com/goodtune/kproxy/internal/blockpage"
+	"github.com/goodtune/kproxy/internal/ca"
+	"github.com/goodtune/kproxy/internal/clientcert"
+	"github.com/goodtune/kproxy/internal/devices"
+	"github.com/goodtune/kproxy/internal/features"
+	"github.com/goodtune/kproxy/internal/fingerprint"
+	"github.com/goodtune/kproxy/internal/maintenance"
+	"github.com/goodtune/kproxy/internal/pause"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/goodtune/kproxy/internal/policyedit"
+	"github.com/goodtune/kproxy/internal/probe"
+	"github.com/goodtune/kproxy/internal/report"
+	"github.com/goodtune/kproxy/internal/rules"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/update"
+	"github.com/goodtune/kproxy/internal/usage"
+	"github.com/rs/zerolog"
+)
+
+// PolicyEngine is the subset of the policy engine used by the admin API
+type PolicyEngine interface {
+	ProfileSchedule(profileID string) (*opa.Schedule, error)
+	LookupDevices(clientIP net.IP, clientMAC net.HardwareAddr) (*opa.DeviceLookup, error)
+}
+
+// LeaseLookup finds the DHCP lease of an address
+type LeaseLookup interface {
+	GetByIP(ctx context.Context, ip string) (*storage.DHCPLease, error)
+}
+
+// UsageReporter reports today's usage meters
+type UsageReporter interface {
+	ListTodayUsage() ([]usage.Meter, error)
+}
+
+// VersionReporter reports the running and available release versions
+type VersionReporter interface {
+	Status() update.Status
+}
+
+// ProbeReporter reports the connectivity probe history
+type ProbeReporter interface {
+	History(since time.Time) probe.History
+}
+
+// CanaryReporter reports how candidate policies evaluated in shadow
+// differ from the active ones
+type CanaryReporter interface {
+	Report() policy.CanaryReport
+}
+
+// CARotator replaces the certificate authority's signing chain
+type CARotator interface {
+	Rotate(newRoot bool, grace time.Duration) (*ca.Rotation, error)
+}
+
+// ChangeNotifier announces changes made through the admin API to the other
+// KProxy instances sharing storage
+type ChangeNotifier interface {
+	Notify()
+}
+
+// Server is the admin HTTP API server. It exposes read-only views computed
+// from the loaded policies for use by management UIs, the maintenance
+// mode switch, device and profile pauses, runtime rules, rule sets and
+// devices, custom block pages, access requests, connectivity probes, weekly
+// reports, candidate policy divergences, feature flags, release version
+// information and a live event stream. With an approval queue set, destructive changes wait for a
+// second admin account to approve them. Besides admin accounts, scoped API
+// tokens can authenticate when a token set is attached.
+type Server struct {
+	server      *http.Server
+	policy      PolicyEngine
+	maintenance *maintenance.Mode
+	pauses      *pause.Set
+	versions    VersionReporter
+	features    *features.Set
+	rules       *rules.Set
+	deviceRules *rules.DeviceSet
+	ruleSets    *rules.RuleSets
+	devices     *devices.Registry
+	leases      LeaseLookup
+	fingerprint *fingerprint.Collector
+	dhcp        storage.DHCPLeaseStore
+	reserved    storage.DHCPReservationStore
+	info        SystemInfo
+	activity    *activity.Recorder
+	usage       UsageReporter
+	approvals   *approval.Queue
+	blockPages  *blockpage.Pages
+	access      *access.Queue
+	clientCerts *clientcert.Cache
+	issued      storage.IssuedCertStore
+	audit       storage.AuditLogStore
+	decisions   storage.DecisionLogStore
+	apiTokens   *apitoken.Set
+	rotator     CARotator
+	probes      ProbeReporter
+	policies    *policyedit.Editor
+	backups     *backup.Manager
+	reports     *report.Recorder
+	canary      CanaryReporter
+	notifier    ChangeNotifier
+	token       string
+	accounts    map[string]string // Account name -> token
+	logger      zerolog.Logger
+
+	// How long audit log entries are kept
+	auditRetention time.Duration
+}
+
+// Destructive changes held for approval when an approval queue is set
+const (
+	ActionDeviceRemove     = "device.remove"
+	ActionDevicePut        = "device.put"
+	ActionDeviceAssign     = "device.assign"
+	ActionDeviceRuleRemove = "device.rule.remove"
+	ActionRulePut          = "rule.put"
+	ActionRuleRemove       = "rule.remove"
+	ActionRuleSetPut       = "ruleset.put"
+	ActionRuleSetProfiles  = "ruleset.profiles"
+	ActionRuleSetRemove    = "ruleset.remove"
+	ActionPolicyWrite      = "policy.write"
+	ActionImport           = "import"
+	ActionRestore          = "restore"
+)
+
+// secretParams are the actions whose params are never answered: a held
+// restore carries the backup archive, with API token hashes and maybe the
+// CA key
+var secretParams = map[string]bool{
+	ActionRestore: true,
+}
+
+// DefaultAccount is the account name of the admin token
+const DefaultAccount = "admin"
+
+// TokenAccountPrefix starts the account name of requests made with an API
+// token, followed by the token's name
+const TokenAccountPrefix = "token:"
+
+// accountKey is the request context key of the authenticated account name
+type accountKey struct{}
+
+// creatorKey is the request context key of the admin account that created
+// the API token a request was made with
+type creatorKey struct{}
+
+// SystemInfo describes the running deployment for support
+type SystemInfo struct {
+	Version   string          `json:"version"`
+	Channel   string          `json:"channel,omitempty"` // Release channel
+	Mode      string          `json:"mode"`
+	Started   time.Time       `json:"started"`
+	Uptime    string          `json:"uptime"`
+	GoVersion string          `json:"go_version"`
+	Platform  string          `json:"platform"`
+	Features  []features.Flag `json:"features"`
+}
+
+// Activity is the live view served on /api/activity
+type Activity struct {
+	activity.Snapshot
+	Usage []usage.Meter `json:"usage"` // Today's usage per device and limit
+}
+
+// FeatureRequest is the JSON body for switching a feature flag
+type FeatureRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// RuleRequest is the JSON body for adding a runtime rule
+type RuleRequest struct {
+	Profile string     `json:"profile"`
+	Domain  string     `json:"domain"`
+	Action  string     `json:"action"`          // allow, block or bypass
+	Until   *time.Time `json:"until,omitempty"` // Omit to keep until removed
+}
+
+// DeviceRuleRequest is the JSON body for adding a rule for one device
+type DeviceRuleRequest struct {
+	Domain string     `json:"domain"`
+	Action string     `json:"action"`          // allow, block or bypass
+	Until  *time.Time `json:"until,omitempty"` // Omit to keep until removed
+}
+
+// RuleSetProfilesRequest is the JSON body for attaching a rule set to
+// profiles. It replaces the profiles the rule set is attached to.
+type RuleSetProfilesRequest struct {
+	Profiles []string `json:"profiles"`
+}
+
+// DeviceInfo is a device as listed on /api/devices
+type DeviceInfo struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Identifiers []string `json:"identifiers"`
+	Profile     string   `json:"profile"`
+	Source      string   `json:"source"`   // "config" or "runtime"
+	Assigned    bool     `json:"assigned"` // Profile assigned at runtime
+
+	// Discovered devices in quarantine, waiting to be classified
+	Pending      bool   `json:"pending,omitempty"`
+	DiscoveredBy string `json:"discovered_by,omitempty"` // "dhcp", "dns" or "proxy"
+
+	// Device type guessed from its DHCP requests and User-Agents
+	DeviceType string `json:"device_type,omitempty"`
+}
+
+// LeaseInfo is a DHCP lease and whether its address is reserved
+type LeaseInfo struct {
+	storage.DHCPLease
+	Reserved bool `json:"reserved"`
+}
+
+// DeviceRequest is the JSON body for adding a device
+type DeviceRequest struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name,omitempty"`
+	Identifiers []string `json:"identifiers"`
+	Profile     string   `json:"profile"`
+}
+
+// BlockPageRequest is the JSON body for saving a block page template
+type BlockPageRequest struct {
+	Template string `json:"template"` // Go html/template, e.g. "<p>{{.Reason}}</p>"
+}
+
+// PolicyRequest is the JSON body for saving a policy file
+type PolicyRequest struct {
+	Content string `json:"content"`
+	DryRun  bool   `json:"dry_run,omitempty"` // Only compile and diff the change
+}
+
+// AccessApproveRequest is the JSON body for approving an access request.
+// The allow rule lasts an hour unless Until or Duration is given.
+type AccessApproveRequest struct {
+	Until    *time.Time `json:"until,omitempty"`
+	Duration string     `json:"duration,omitempty"` // e.g. "30m"
+}
+
+// defaultAccessDuration is how long an approved access request's allow
+// rule lasts by default
+const defaultAccessDuration = time.Hour
+
+// AssignRequest is the JSON body for assigning a profile to a device
+type AssignRequest struct {
+	Profile string `json:"profile"`
+}
+
+// Identification is what KProxy knows about a client address
+type Identification struct {
+	IP          string                   `json:"ip"`
+	MAC         string                   `json:"mac,omitempty"`
+	MACSource   string                   `json:"mac_source,omitempty"` // "arp" or "dhcp"
+	Lease       *storage.DHCPLease       `json:"lease,omitempty"`
+	MDNSName    string                   `json:"mdns_name,omitempty"`
+	Fingerprint *fingerprint.Fingerprint `json:"fingerprint,omitempty"`
+	DeviceID    string                   `json:"device_id,omitempty"`
+	Device      *opa.Device              `json:"device,omitempty"` // Device policy identifies (nil = unknown)
+	Activity    *activity.ClientActivity `json:"activity,omitempty"`
+}
+
+// PauseRequest is the JSON body for pausing a device or profile. With
+// neither field set the pause lasts until resumed.
+type PauseRequest struct {
+	Until    *time.Time `json:"until,omitempty"`
+	Duration string     `json:"duration,omitempty"` // e.g. "30m"
+}
+
+// MaintenanceRequest is the JSON body for enabling maintenance mode. Both
+// fields are optional and default to the configured values.
+type MaintenanceRequest struct {
+	Message  string `json:"message,omitempty"`
+	Duration string `json:"duration,omitempty"` // e.g. "30m"
+}
+
+// CARotateRequest is the JSON body for rotating the CA. Grace is how long
+// the old root stays trusted after a root rotation (default 168h, "0s" to
+// drop it at once).
+type CARotateRequest struct {
+	Root  bool   `json:"root,omitempty"` // Also generate a new root
+	Grace string `json:"grace,omitempty"`
+}
+
+// NewServer creates a new admin API server. Requests must carry
+// "Authorization: Bearer <token>".
+func NewServer(addr, token string, policy PolicyEngine, maint *maintenance.Mode, logger zerolog.Logger) *Server {
+	s := &Server{
+		policy:      policy,
+		maintenance: maint,
+		token:       token,
+		logger:      logger.With().Str("component", "admin").Logger(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/profiles", s.handleProfiles)
+	mux.HandleFunc("GET /api/profiles/{id}", s.handleProfile)
+	mux.HandleFunc("GET /api/profiles/{id}/schedule", s.handleProfileSchedule)
+	mux.HandleFunc("GET /api/maintenance", s.handleMaintenanceStatus)
+	mux.HandleFunc("PUT /api/maintenance", s.handleMaintenanceEnable)
+	mux.HandleFunc("DELETE /api/maintenance", s.handleMaintenanceDisable)
+	mux.HandleFunc("GET /api/pauses", s.handlePauses)
+	mux.HandleFunc("PUT /api/devices/{id}/pause", s.handleDevicePause)
+	mux.HandleFunc("DELETE /api/devices/{id}/pause", s.handleDeviceResume)
+	mux.HandleFunc("PUT /api/profiles/{id}/pause", s.handleProfilePause)
+	mux.HandleFunc("DELETE /api/profiles/{id}/pause", s.handleProfileResume)
+	mux.HandleFunc("GET /api/version", s.handleVersion)
+	mux.HandleFunc("GET /api/system/info", s.handleSystemInfo)
+	mux.HandleFunc("GET /api/system/backup", s.handleBackup)
+	mux.HandleFunc("POST /api/system/restore", s.handleRestore)
+	mux.HandleFunc("GET /api/activity", s.handleActivity)
+	mux.HandleFunc("GET /api/events", s.handleEvents)
+	mux.HandleFunc("GET /api/reports/weekly", s.handleWeeklyReport)
+	mux.HandleFunc("GET /api/features", s.handleFeatures)
+	mux.HandleFunc("PUT /api/features/{name}", s.handleFeatureSet)
+	mux.HandleFunc("DELETE /api/features/{name}", s.handleFeatureReset)
+	mux.HandleFunc("GET /api/rules", s.handleRules)
+	mux.HandleFunc("POST /api/rules", s.handleRuleAdd)
+	mux.HandleFunc("GET /api/rules/{id}", s.handleRule)
+	mux.HandleFunc("PUT /api/rules/{id}", s.handleRulePut)
+	mux.HandleFunc("DELETE /api/rules/{id}", s.handleRuleRemove)
+	mux.HandleFunc("GET /api/rulesets", s.handleRuleSets)
+	mux.HandleFunc("GET /api/rulesets/{id}", s.handleRuleSet)
+	mux.HandleFunc("PUT /api/rulesets/{id}", s.handleRuleSetPut)
+	mux.HandleFunc("DELETE /api/rulesets/{id}", s.handleRuleSetRemove)
+	mux.HandleFunc("PUT /api/rulesets/{id}/profiles", s.handleRuleSetProfiles)
+	mux.HandleFunc("GET /api/devices", s.handleDevices)
+	mux.HandleFunc("POST /api/devices", s.handleDeviceAdd)
+	mux.HandleFunc("GET /api/devices/{id}", s.handleDevice)
+	mux.HandleFunc("PUT /api/devices/{id}", s.handleDevicePut)
+	mux.HandleFunc("DELETE /api/devices/{id}", s.handleDeviceRemove)
+	mux.HandleFunc("PUT /api/devices/{id}/profile", s.handleDeviceAssign)
+	mux.HandleFunc("GET /api/devices/{id}/rules", s.handleDeviceRules)
+	mux.HandleFunc("POST /api/devices/{id}/rules", s.handleDeviceRuleAdd)
+	mux.HandleFunc("DELETE /api/devices/{id}/rules/{rule}", s.handleDeviceRuleRemove)
+	mux.HandleFunc("GET /api/devices/identify", s.handleDeviceIdentify)
+	mux.HandleFunc("GET /api/dhcp/leases", s.handleLeases)
+	mux.HandleFunc("GET /api/dhcp/leases/{mac}", s.handleLease)
+	mux.HandleFunc("DELETE /api/dhcp/leases/{mac}", s.handleLeaseRelease)
+	mux.HandleFunc("POST /api/dhcp/leases/{mac}/reservation", s.handleLeaseReserve)
+	mux.HandleFunc("GET /api/dhcp/reservations", s.handleReservations)
+	mux.HandleFunc("DELETE /api/dhcp/reservations/{mac}", s.handleReservationRemove)
+	mux.HandleFunc("GET /api/blockpages", s.handleBlockPages)
+	mux.HandleFunc("GET /api/blockpages/{name}", s.handleBlockPage)
+	mux.HandleFunc("PUT /api/blockpages/{name}", s.handleBlockPagePut)
+	mux.HandleFunc("DELETE /api/blockpages/{name}", s.handleBlockPageRemove)
+	mux.HandleFunc("GET /api/approvals", s.handleApprovals)
+	mux.HandleFunc("POST /api/approvals/{id}/approve", s.handleApprovalApprove)
+	mux.HandleFunc("DELETE /api/approvals/{id}", s.handleApprovalReject)
+	mux.HandleFunc("GET /api/probes", s.handleProbes)
+	mux.HandleFunc("GET /api/policies", s.handlePolicies)
+	mux.HandleFunc("GET /api/policies/{name}", s.handlePolicy)
+	mux.HandleFunc("PUT /api/policies/{name}", s.handlePolicyPut)
+	mux.HandleFunc("GET /api/export", s.handleExport)
+	mux.HandleFunc("POST /api/import", s.handleImport)
+	mux.HandleFunc("GET /api/canary", s.handleCanary)
+	mux.HandleFunc("GET /api/access-requests", s.handleAccessRequests)
+	mux.HandleFunc("POST /api/access-requests/{id}/approve", s.handleAccessApprove)
+	mux.HandleFunc("DELETE /api/access-requests/{id}", s.handleAccessDeny)
+	mux.HandleFunc("GET /api/client-certs", s.handleClientCerts)
+	mux.HandleFunc("GET /api/ca/issued", s.handleIssuedCerts)
+	mux.HandleFunc("POST /api/ca/rotate", s.handleCARotate)
+	mux.HandleFunc("DELETE /api/client-certs/{host}", s.handleClientCertRemove)
+	mux.HandleFunc("GET /api/audit", s.handleAuditLog)
+	mux.HandleFunc("GET /api/audit/{id}", s.handleAuditEntry)
+	mux.HandleFunc("GET /api/decisions", s.handleDecisions)
+	mux.HandleFunc("GET /api/decisions/{id}", s.handleDecision)
+	mux.HandleFunc("GET /api/tokens", s.handleAPITokens)
+	mux.HandleFunc("POST /api/tokens", s.handleAPITokenCreate)
+	mux.HandleFunc("DELETE /api/tokens/{id}", s.handleAPITokenRevoke)
+
+	// The API description is public, so browsers can open the docs
+	public := http.NewServeMux()
+	public.HandleFunc("GET /api/openapi.json", s.handleOpenAPI)
+	public.HandleFunc("GET /api/docs", s.handleAPIDocs)
+	public.Handle("/", s.requireToken(s.announceChanges(s.auditChanges(mux))))
+
+	s.server = &http.Server{
+		Addr:    addr,
+		Handler: public,
+	}
+	return s
+}
+
+// SetVersionReporter sets the source for GET /api/version
+func (s *Server) SetVersionReporter(v VersionReporter) {
+	s.versions = v
+}
+
+// SetFeatures sets the feature flags switched through /api/features
+func (s *Server) SetFeatures(f *features.Set) {
+	s.features = f
+}
+
+// SetRules sets the runtime rules managed through /api/rules
+func (s *Server) SetRules(r *rules.Set) {
+	s.rules = r
+}
+
+// SetDevices sets the runtime devices managed through /api/devices and the
+// DHCP leases used to identify clients (optional)
+func (s *Server) SetDevices(r *devices.Registry, leases LeaseLookup) {
+	s.devices = r
+	s.leases = leases
+}
+
+// SetDHCP sets the DHCP leases and reservations managed through
+// /api/dhcp
+func (s *Server) SetDHCP(leases storage.DHCPLeaseStore, reservations storage.DHCPReservationStore) {
+	s.dhcp = leases
+	s.reserved = reservations
+}
+
+// SetFingerprints sets the collector whose device type guesses are shown
+// with devices (optional)
+func (s *Server) SetFingerprints(c *fingerprint.Collector) {
+	s.fingerprint = c
+}
+
+// SetDeviceRules sets the device rules managed through
+// /api/devices/{id}/rules
+func (s *Server) SetDeviceRules(r *rules.DeviceSet) {
+	s.deviceRules = r
+}
+
+// SetPauses sets the pauses managed through /api/devices/{id}/pause and
+// /api/profiles/{id}/pause
+func (s *Server) SetPauses(p *pause.Set) {
+	s.pauses = p
+}
+
+// SetRuleSets sets the rule sets managed through /api/rulesets
+func (s *Server) SetRuleSets(r *rules.RuleSets) {
+	s.ruleSets = r
+}
+
+// SetSystemInfo sets the deployment details reported on /api/system/info.
+// Uptime, runtime details and feature flags are filled in per request.
+func (s *Server) SetSystemInfo(info SystemInfo) {
+	s.info = info
+}
+
+// SetActivity sets the sources for /api/activity and the live event stream
+// on /api/events. The usage reporter is
+// optional (there are no usage meters in DNS-only mode).
+func (s *Server) SetActivity(r *activity.Recorder, usage UsageReporter) {
+	s.activity = r
+	s.usage = usage
+}
+
+// SetBlockPages sets the custom block page templates managed through
+// /api/blockpages
+func (s *Server) SetBlockPages(p *blockpage.Pages) {
+	s.blockPages = p
+}
+
+// SetAccounts sets named admin accounts (name -> token) accepted alongside
+// the admin token, which authenticates as the "admin" account
+func (s *Server) SetAccounts(accounts map[string]string) {
+	s.accounts = accounts
+}
+
+// SetApprovals sets the queue holding destructive changes until a second
+// admin account approves them (optional)
+func (s *Server) SetApprovals(q *approval.Queue) {
+	s.approvals = q
+}
+
+// SetAccessRequests sets the queue of access requests made from the block
+// page, answered through /api/access-requests
+func (s *Server) SetAccessRequests(q *access.Queue) {
+	s.access = q
+}
+
+// SetClientCerts sets the hosts found to want a client certificate, listed
+// and cleared through /api/client-certs
+func (s *Server) SetClientCerts(c *clientcert.Cache) {
+	s.clientCerts = c
+}
+
+// SetIssuedCerts sets the issuance log of certificates the CA minted,
+// searched through GET /api/ca/issued
+func (s *Server) SetIssuedCerts(store storage.IssuedCertStore) {
+	s.issued = store
+}
+
+// SetCA sets the certificate authority rotated through POST /api/ca/rotate
+func (s *Server) SetCA(r CARotator) {
+	s.rotator = r
+}
+
+// SetTLS serves the admin API over HTTPS, choosing the certificate for
+// each connection by SNI with getCertificate
+func (s *Server) SetTLS(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) {
+	s.server.TLSConfig = &tls.Config{
+		GetCertificate: getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// SetProbes sets the source for GET /api/probes
+func (s *Server) SetProbes(p ProbeReporter) {
+	s.probes = p
+}
+
+// SetPolicies sets the policy files edited through /api/policies
+// (filesystem policy source only)
+func (s *Server) SetPolicies(e *policyedit.Editor) {
+	s.policies = e
+}
+
+// SetReports sets the recorder behind GET /api/reports/weekly
+func (s *Server) SetReports(r *report.Recorder) {
+	s.reports = r
+}
+
+// SetCanary sets the shadow evaluation behind GET /api/canary
+func (s *Server) SetCanary(c CanaryReporter) {
+	s.canary = c
+}
+
+// SetChangeNotifier sets where successful changes are announced, so other
+// instances sharing storage reload
+func (s *Server) SetChangeNotifier(n ChangeNotifier) {
+	s.notifier = n
+}
+
+// Start starts the admin API server
+func (s *Server) Start() error {
+	s.logger.Info().
+		Str("addr", s.server.Addr).
+		Bool("tls", s.server.TLSConfig != nil).
+		Msg("Starting admin API server")
+	go func() {
+		var err error
+		if s.server.TLSConfig != nil {
+			err = s.server.ListenAndServeTLS("", "")
+		} else {
+			err = s.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			s.logger.Error().Err(err).Msg("Admin API server error")
+		}
+	}()
+	return nil
+}
+
+// Stop stops the admin API server
+func (s *Server) Stop() error {
+	s.logger.Info().Msg("Stopping admin API server")
+	return s.server.Close()
+}
+
+// requireToken rejects requests without the bearer token of an admin
+// account or an API token, and records the account in the request context.
+// API tokens authenticate as "token:<name>" and are refused requests
+// outside their scopes.
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		account, ok := s.authenticate(token)
+		if !ok {
+			apiToken, found := s.apiTokens.Authenticate(token)
+			if !found {
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			if !apitoken.Allows(apiToken.Scopes, r.Method, r.URL.Path) {
+				writeError(w, http.StatusForbidden, "token scopes do not allow this request")
+				return
+			}
+			account = TokenAccountPrefix + apiToken.Name
+			r = r.WithContext(context.WithValue(r.Context(), creatorKey{}, apiToken.CreatedBy))
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accountKey{}, account)))
+	})
+}
+
+// announceChanges announces requests that may have changed something
+// (anything but GET and HEAD) once they succeed
+func (s *Server) announceChanges(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.notifier == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status < http.StatusMultipleChoices {
+			s.notifier.Notify()
+		}
+	})
+}
+
+// statusRecorder records the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// authenticate returns the account a bearer token belongs to
+func (s *Server) authenticate(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+		return DefaultAccount, true
+	}
+	for name, t := range s.accounts {
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// account returns the authenticated account of a request
+func account(r *http.Request) string {
+	name, _ := r.Context().Value(accountKey{}).(string)
+	return name
+}
+
+// requester returns the admin answerable for a request: its account, or
+// for an API token the admin who created it, who mustn't be able to
+// approve changes they made through the token
+func requester(r *http.Request) string {
+	if creator, ok := r.Context().Value(creatorKey{}).(string); ok {
+		return creator
+	}
+	return account(r)
+}
+
+// held queues a destructive change for approval, answering 202 with the
+// pending change. Params (optional) is handed back on approval. It reports
+// false when no approval queue is set and the caller should apply the
+// change itself.
+func (s *Server) held(w http.ResponseWriter, r *http.Request, action, target string, params interface{}) bool {
+	if s.approvals == nil {
+		return false
+	}
+
+	change, err := s.approvals.Submit(r.Context(), action, target, params, requester(r))
+	if err != nil {
+		s.logger.Error().Err(err).Str("action", action).Msg("Failed to queue change for approval")
+		writeError(w, http.StatusInternalServerError, "failed to queue change for approval")
+		return true
+	}
+	writeJSON(w, http.StatusAccepted, shownChange(*change))
+	return true
+}
+
+// shownChange returns a pending change as answered by the API
+func shownChange(change storage.PendingChange) storage.PendingChange {
+	if secretParams[change.Action] {
+		change.Params = nil
+	}
+	return change
+}
+
+// handleProfileSchedule returns the weekly 7x24 schedule of effective actions per category
+func (s *Server) handleProfileSchedule(w http.ResponseWriter, r *http.Request) {
+	profileID := r.PathValue("id")
+
+	schedule, err := s.policy.ProfileSchedule(profileID)
+	if errors.Is(err, opa.ErrProfileNotFound) {
+		writeError(w, http.StatusNotFound, "profile not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("profile", profileID).Msg("Schedule projection failed")
+		writeError(w, http.StatusInternalServerError, "schedule projection failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, schedule)
+}
+
+// handleMaintenanceStatus returns the current maintenance window
+func (s *Server) handleMaintenanceStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.maintenance.Status())
+}
+
+// handleMaintenanceEnable starts or extends a maintenance window
+func (s *Server) handleMaintenanceEnable(w http.ResponseWriter, r *http.Request) {
+	var req MaintenanceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid duration")
+			return
+		}
+		duration = d
+	}
+
+	writeJSON(w, http.StatusOK, s.maintenance.Enable(req.Message, duration))
+}
+
+// handleMaintenanceDisable ends the maintenance window
+func (s *Server) handleMaintenanceDisable(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.maintenance.Disable())
+}
+
+// handlePauses lists the devices and profiles paused now
+func (s *Server) handlePauses(w http.ResponseWriter, r *http.Request) {
+	if s.pauses == nil {
+		writeError(w, http.StatusNotFound, "pauses not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.pauses.List())
+}
+
+// handleDevicePause pauses a configured or runtime device
+func (s *Server) handleDevicePause(w http.ResponseWriter, r *http.Request) {
+	s.pauseTarget(w, r, storage.PauseDevice, func(lookup *opa.DeviceLookup, id string) bool {
+		_, ok := lookup.Devices[id]
+		return ok
+	})
+}
+
+// handleProfilePause pauses every device with a profile
+func (s *Server) handleProfilePause(w http.ResponseWriter, r *http.Request) {
+	s.pauseTarget(w, r, storage.PauseProfile, func(lookup *opa.DeviceLookup, id string) bool {
+		return slices.Contains(lookup.Profiles, id)
+	})
+}
+
+// pauseTarget pauses the device or profile in the path if policy knows it
+func (s *Server) pauseTarget(w http.ResponseWriter, r *http.Request, kind string, known func(*opa.DeviceLookup, string) bool) {
+	if s.pauses == nil {
+		writeError(w, http.StatusNotFound, "pauses not configured")
+		return
+	}
+
+	var req PauseRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	var until time.Time
+	switch {
+	case req.Until != nil && req.Duration != "":
+		writeError(w, http.StatusBadRequest, "give until or duration, not both")
+		return
+	case req.Until != nil:
+		until = *req.Until
+	case req.Duration != "":
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid duration: "+req.Duration)
+			return
+		}
+		until = time.Now().Add(d)
+	}
+
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return
+	}
+	id := r.PathValue("id")
+	if !known(lookup, id) {
+		writeError(w, http.StatusNotFound, kind+" not found")
+		return
+	}
+
+	p, err := s.pauses.Pause(r.Context(), kind, id, until)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// handleDeviceResume ends the pause of a device
+func (s *Server) handleDeviceResume(w http.ResponseWriter, r *http.Request) {
+	s.resumeTarget(w, r, storage.PauseDevice)
+}
+
+// handleProfileResume ends the pause of a profile
+func (s *Server) handleProfileResume(w http.ResponseWriter, r *http.Request) {
+	s.resumeTarget(w, r, storage.PauseProfile)
+}
+
+// resumeTarget ends the pause of the device or profile in the path
+func (s *Server) resumeTarget(w http.ResponseWriter, r *http.Request, kind string) {
+	if s.pauses == nil {
+		writeError(w, http.StatusNotFound, "pauses not configured")
+		return
+	}
+
+	id := r.PathValue("id")
+	err := s.pauses.Resume(r.Context(), kind, id)
+	if errors.Is(err, pause.ErrNotFound) {
+		writeError(w, http.StatusNotFound, kind+" not paused")
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("kind", kind).Str("target", id).Msg("Resume failed")
+		writeError(w, http.StatusInternalServerError, "resume failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"resumed": id})
+}
+
+// handleVersion returns the running version and the latest release on the
+// configured channel
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if s.versions == nil {
+		writeError(w, http.StatusNotFound, "version reporting not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.versions.Status())
+}
+
+// handleSystemInfo returns the deployment details and enabled features
+func (s *Server) handleSystemInfo(w http.ResponseWriter, r *http.Request) {
+	info := s.info
+	if !info.Started.IsZero() {
+		info.Uptime = time.Since(info.Started).Round(time.Second).String()
+	}
+	info.GoVersion = runtime.Version()
+	info.Platform = runtime.GOOS + "/" + runtime.GOARCH
+	info.Features = []features.Flag{}
+	if s.features != nil {
+		info.Features = s.features.List()
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleActivity returns query rates, the busiest clients (?top=N, default
+// 10), recent blocks and today's usage meters
+func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
+	if s.activity == nil {
+		writeError(w, http.StatusNotFound, "activity reporting not configured")
+		return
+	}
+
+	top := 10
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid top")
+			return
+		}
+		top = n
+	}
+
+	resp := Activity{Snapshot: s.activity.Snapshot(top), Usage: []usage.Meter{}}
+	if s.usage != nil {
+		meters, err := s.usage.ListTodayUsage()
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Failed to list usage")
+			writeError(w, http.StatusInternalServerError, "failed to list usage")
+			return
+		}
+		resp.Usage = meters
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleWeeklyReport renders a profile's report for the week ending on the
+// end date (default today) as HTML, or as JSON with format=json. There is
+// no PDF renderer; print the HTML instead.
+func (s *Server) handleWeeklyReport(w http.ResponseWriter, r *http.Request) {
+	if s.reports == nil {
+		writeError(w, http.StatusNotFound, "reports not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	profile := query.Get("profile")
+	if profile == "" {
+		writeError(w, http.StatusBadRequest, "profile is required")
+		return
+	}
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return
+	}
+	if !slices.Contains(lookup.Profiles, profile) {
+		writeError(w, http.StatusNotFound, "profile not found")
+		return
+	}
+
+	end := time.Now()
+	if v := query.Get("end"); v != "" {
+		end, err = time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid end date (use YYYY-MM-DD)")
+			return
+		}
+	}
+
+	format := query.Get("format")
+	switch format {
+	case "", "html", "json":
+	case "pdf":
+		writeError(w, http.StatusBadRequest, "PDF reports are not supported; print the HTML report to PDF")
+		return
+	default:
+		writeError(w, http.StatusBadRequest, "invalid format (use html or json)")
+		return
+	}
+
+	weekly, err := s.reports.Weekly(r.Context(), profile, end)
+	if err != nil {
+		s.logger.Error().Err(err).Str("profile", profile).Msg("Weekly report failed")
+		writeError(w, http.StatusInternalServerError, "weekly report failed")
+		return
+	}
+	if format == "json" {
+		writeJSON(w, http.StatusOK, weekly)
+		return
+	}
+
+	page, err := weekly.HTML()
+	if err != nil {
+		s.logger.Error().Err(err).Str("profile", profile).Msg("Weekly report rendering failed")
+		writeError(w, http.StatusInternalServerError, "weekly report rendering failed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(page)
+}
+
+// handleFeatures returns all feature flags
+func (s *Server) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	if s.features == nil {
+		writeError(w, http.StatusNotFound, "feature flags not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.features.List())
+}
+
+// handleFeatureSet switches a feature flag at runtime
+func (s *Server) handleFeatureSet(w http.ResponseWriter, r *http.Request) {
+	if s.features == nil {
+		writeError(w, http.StatusNotFound, "feature flags not configured")
+		return
+	}
+
+	var req FeatureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	flag, err := s.features.Set(r.PathValue("name"), req.Enabled)
+	if errors.Is(err, features.ErrUnknownFlag) {
+		writeError(w, http.StatusNotFound, "unknown feature flag")
+		return
+	}
+	writeJSON(w, http.StatusOK, flag)
+}
+
+// handleFeatureReset returns a feature flag to its configured value
+func (s *Server) handleFeatureReset(w http.ResponseWriter, r *http.Request) {
+	if s.features == nil {
+		writeError(w, http.StatusNotFound, "feature flags not configured")
+		return
+	}
+
+	flag, err := s.features.Reset(r.PathValue("name"))
+	if errors.Is(err, features.ErrUnknownFlag) {
+		writeError(w, http.StatusNotFound, "unknown feature flag")
+		return
+	}
+	writeJSON(w, http.StatusOK, flag)
+}
+
+// handleRules returns the runtime rules in effect
+func (s *Server) handleRules(w http.ResponseWriter, r *http.Request) {
+	if s.rules == nil {
+		writeError(w, http.StatusNotFound, "runtime rules not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.rules.List())
+}
+
+// handleRuleAdd adds a runtime rule
+func (s *Server) handleRuleAdd(w http.ResponseWriter, r *http.Request) {
+	if s.rules == nil {
+		writeError(w, http.StatusNotFound, "runtime rules not configured")
+		return
+	}
+
+	var req RuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	var until time.Time
+	if req.Until != nil {
+		until = *req.Until
+	}
+	rule, err := s.rules.Add(req.Profile, req.Domain, req.Action, until)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// handleRule returns one runtime rule
+func (s *Server) handleRule(w http.ResponseWriter, r *http.Request) {
+	if s.rules == nil {
+		writeError(w, http.StatusNotFound, "runtime rules not configured")
+		return
+	}
+
+	rule, err := s.rules.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "rule not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// handleRulePut creates or replaces the runtime rule with the ID in the
+// path. Replacing a rule is held for approval.
+func (s *Server) handleRulePut(w http.ResponseWriter, r *http.Request) {
+	if s.rules == nil {
+		writeError(w, http.StatusNotFound, "runtime rules not configured")
+		return
+	}
+
+	var req RuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	id := r.PathValue("id")
+	_, err := s.rules.Get(id)
+	hold := err == nil && s.approvals != nil
+	if s.putRule(w, id, req, hold) && hold {
+		s.held(w, r, ActionRulePut, id, req)
+	}
+}
+
+// putRule creates or replaces a runtime rule and writes it, or only checks
+// the request when check is set. It answers errors itself, reporting false
+// once it has.
+func (s *Server) putRule(w http.ResponseWriter, id string, req RuleRequest, check bool) bool {
+	var until time.Time
+	if req.Until != nil {
+		until = *req.Until
+	}
+	if check {
+		if err := s.rules.Check(id, req.Profile, req.Domain, req.Action, until); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+		return true
+	}
+
+	rule, created, err := s.rules.Put(id, req.Profile, req.Domain, req.Action, until)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	writeJSON(w, putStatus(created), rule)
+	return true
+}
+
+// handleRuleRemove removes a runtime rule
+func (s *Server) handleRuleRemove(w http.ResponseWriter, r *http.Request) {
+	if s.rules == nil {
+		writeError(w, http.StatusNotFound, "runtime rules not configured")
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := s.rules.Get(id); errors.Is(err, rules.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "rule not found")
+		return
+	}
+	if s.held(w, r, ActionRuleRemove, id, nil) {
+		return
+	}
+	s.removeRule(w, id)
+}
+
+// removeRule removes a runtime rule and writes the result
+func (s *Server) removeRule(w http.ResponseWriter, id string) {
+	if err := s.rules.Remove(id); errors.Is(err, rules.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "rule not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"removed": id})
+}
+
+// handleDevices lists configured and runtime devices with their current
+// profiles; ?pending=true lists only discovered devices awaiting
+// classification
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	pending := false
+	if v := r.URL.Query().Get("pending"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pending")
+			return
+		}
+		pending = b
+	}
+
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return
+	}
+
+	list := make([]DeviceInfo, 0, len(lookup.Devices))
+	for id, d := range lookup.Devices {
+		info := s.deviceInfo(id, d)
+		if pending && !info.Pending {
+			continue
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleDeviceAdd adds a device at runtime
+func (s *Server) handleDeviceAdd(w http.ResponseWriter, r *http.Request) {
+	if s.devices == nil {
+		writeError(w, http.StatusNotFound, "runtime devices not configured")
+		return
+	}
+
+	var req DeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return
+	}
+	if _, ok := lookup.Devices[req.ID]; ok {
+		writeError(w, http.StatusConflict, "device already exists")
+		return
+	}
+	if !slices.Contains(lookup.Profiles, req.Profile) {
+		writeError(w, http.StatusBadRequest, "profile not found")
+		return
+	}
+
+	device, err := s.devices.Add(req.ID, req.Name, req.Identifiers, req.Profile)
+	if errors.Is(err, devices.ErrExists) {
+		writeError(w, http.StatusConflict, "device already exists")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, device)
+}
+
+// handleDeviceAssign assigns a profile to a configured or runtime device,
+// classifying a pending one. The assignment is held for approval.
+func (s *Server) handleDeviceAssign(w http.ResponseWriter, r *http.Request) {
+	if s.devices == nil {
+		writeError(w, http.StatusNotFound, "runtime devices not configured")
+		return
+	}
+
+	var req AssignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	id := r.PathValue("id")
+	hold := s.approvals != nil
+	if s.assignDevice(w, id, req.Profile, hold) && hold {
+		s.held(w, r, ActionDeviceAssign, id, req.Profile)
+	}
+}
+
+// assignDevice assigns a profile to a device and writes the device, or only
+// checks the assignment when check is set. It answers errors itself,
+// reporting false once it has.
+func (s *Server) assignDevice(w http.ResponseWriter, id, profile string, check bool) bool {
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return false
+	}
+	device, ok := lookup.Devices[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return false
+	}
+	if !slices.Contains(lookup.Profiles, profile) {
+		writeError(w, http.StatusBadRequest, "profile not found")
+		return false
+	}
+	if check {
+		return true
+	}
+
+	s.devices.Assign(id, profile)
+	device.Profile = profile
+	writeJSON(w, http.StatusOK, s.deviceInfo(id, device))
+	return true
+}
+
+// handleDevice returns one configured or runtime device
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return
+	}
+
+	id := r.PathValue("id")
+	device, ok := lookup.Devices[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.deviceInfo(id, device))
+}
+
+// handleDevicePut creates or replaces the runtime device with the ID in the
+// path. Devices from config.rego can't be replaced, and replacing a runtime
+// device is held for approval.
+func (s *Server) handleDevicePut(w http.ResponseWriter, r *http.Request) {
+	if s.devices == nil {
+		writeError(w, http.StatusNotFound, "runtime devices not configured")
+		return
+	}
+
+	var req DeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	id := r.PathValue("id")
+	if req.ID != "" && req.ID != id {
+		writeError(w, http.StatusBadRequest, "device ID in body doesn't match the path")
+		return
+	}
+
+	_, exists := s.devices.Get(id)
+	hold := exists && s.approvals != nil
+	if s.putDevice(w, id, req, hold) && hold {
+		s.held(w, r, ActionDevicePut, id, req)
+	}
+}
+
+// putDevice creates or replaces a runtime device and writes it, or only
+// checks the request when check is set. It answers errors itself, reporting
+// false once it has.
+func (s *Server) putDevice(w http.ResponseWriter, id string, req DeviceRequest, check bool) bool {
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return false
+	}
+	if _, configured := lookup.Devices[id]; configured {
+		if _, runtime := s.devices.Get(id); !runtime {
+			writeError(w, http.StatusConflict, "device is defined in config.rego")
+			return false
+		}
+	}
+	if !slices.Contains(lookup.Profiles, req.Profile) {
+		writeError(w, http.StatusBadRequest, "profile not found")
+		return false
+	}
+	if check {
+		if err := devices.Check(id, req.Name, req.Identifiers, req.Profile); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return false
+		}
+		return true
+	}
+
+	device, created, err := s.devices.Put(id, req.Name, req.Identifiers, req.Profile)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	writeJSON(w, putStatus(created), device)
+	return true
+}
+
+// handleDeviceRemove removes a runtime device, or clears the profile
+// assigned at runtime to a configured one
+func (s *Server) handleDeviceRemove(w http.ResponseWriter, r *http.Request) {
+	if s.devices == nil {
+		writeError(w, http.StatusNotFound, "runtime devices not configured")
+		return
+	}
+
+	id := r.PathValue("id")
+	_, runtime := s.devices.Get(id)
+	_, assigned := s.devices.Assigned(id)
+	if !runtime && !assigned {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	if s.held(w, r, ActionDeviceRemove, id, nil) {
+		return
+	}
+	s.removeDevice(w, id)
+}
+
+// removeDevice removes a runtime device or clears the runtime profile
+// assignment of a configured one, and writes the result
+func (s *Server) removeDevice(w http.ResponseWriter, id string) {
+	if err := s.devices.Remove(id); err == nil {
+		writeJSON(w, http.StatusOK, map[string]string{"removed": id})
+		return
+	}
+	if s.devices.Unassign(id) {
+		writeJSON(w, http.StatusOK, map[string]string{"unassigned": id})
+		return
+	}
+	writeError(w, http.StatusNotFound, "device not found")
+}
+
+// handleDeviceRules returns the rules in effect for a device
+func (s *Server) handleDeviceRules(w http.ResponseWriter, r *http.Request) {
+	if s.deviceRules == nil {
+		writeError(w, http.StatusNotFound, "device rules not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.deviceRules.List(r.PathValue("id")))
+}
+
+// handleDeviceRuleAdd adds a rule for a configured or runtime device
+func (s *Server) handleDeviceRuleAdd(w http.ResponseWriter, r *http.Request) {
+	if s.deviceRules == nil {
+		writeError(w, http.StatusNotFound, "device rules not configured")
+		return
+	}
+
+	var req DeviceRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	lookup, err := s.policy.LookupDevices(nil, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return
+	}
+	id := r.PathValue("id")
+	if _, ok := lookup.Devices[id]; !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+
+	var until time.Time
+	if req.Until != nil {
+		until = *req.Until
+	}
+	rule, err := s.deviceRules.Add(r.Context(), id, req.Domain, req.Action, until)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// handleDeviceRuleRemove removes a rule of a device
+func (s *Server) handleDeviceRuleRemove(w http.ResponseWriter, r *http.Request) {
+	if s.deviceRules == nil {
+		writeError(w, http.StatusNotFound, "device rules not configured")
+		return
+	}
+
+	id := r.PathValue("rule")
+	if rule, err := s.deviceRules.Get(id); err != nil || rule.Device != r.PathValue("id") {
+		writeError(w, http.StatusNotFound, "rule not found")
+		return
+	}
+	if s.held(w, r, ActionDeviceRuleRemove, id, nil) {
+		return
+	}
+	s.removeDeviceRule(w, r, id)
+}
+
+// removeDeviceRule removes a device rule and writes the result
+func (s *Server) removeDeviceRule(w http.ResponseWriter, r *http.Request, id string) {
+	rule, err := s.deviceRules.Get(id)
+	if err == nil {
+		err = s.deviceRules.Remove(r.Context(), rule.Device, id)
+	}
+	if errors.Is(err, rules.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "rule not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("id", id).Msg("Device rule removal failed")
+		writeError(w, http.StatusInternalServerError, "device rule removal failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"removed": id})
+}
+
+// handleRuleSets lists the rule sets saved at runtime
+func (s *Server) handleRuleSets(w http.ResponseWriter, r *http.Request) {
+	if s.ruleSets == nil {
+		writeError(w, http.StatusNotFound, "rule sets not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.ruleSets.List())
+}
+
+// handleRuleSet returns a rule set
+func (s *Server) handleRuleSet(w http.ResponseWriter, r *http.Request) {
+	if s.ruleSets == nil {
+		writeError(w, http.StatusNotFound, "rule sets not configured")
+		return
+	}
+
+	set, err := s.ruleSets.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "rule set not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, set)
+}
+
+// handleRuleSetPut creates or replaces a rule set. The body is a rule set;
+// its ID comes from the path. Replacing a rule set so that it drops or
+// changes a rule or detaches a profile is held for approval.
+func (s *Server) handleRuleSetPut(w http.ResponseWriter, r *http.Request) {
+	if s.ruleSets == nil {
+		writeError(w, http.StatusNotFound, "rule sets not configured")
+		return
+	}
+
+	var req storage.RuleSet
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	id := r.PathValue("id")
+	if old, err := s.ruleSets.Get(id); err == nil && s.approvals != nil {
+		set, err := s.ruleSets.Check(id, req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if loosens(old, set) && s.held(w, r, ActionRuleSetPut, id, set) {
+			return
+		}
+	}
+	s.putRuleSet(w, r, id, req)
+}
+
+// putRuleSet creates or replaces a rule set and writes the result
+func (s *Server) putRuleSet(w http.ResponseWriter, r *http.Request, id string, req storage.RuleSet) {
+	set, created, err := s.ruleSets.Put(r.Context(), id, req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, putStatus(created), set)
+}
+
+// loosens reports whether saving updated over old drops or changes one of
+// old's rules or detaches it from one of its profiles
+func loosens(old, updated storage.RuleSet) bool {
+	if detaches(old.Profiles, updated.Profiles) {
+		return true
+	}
+	kept := make(map[string]storage.RuleSetRule, len(updated.Rules))
+	for _, rule := range updated.Rules {
+		kept[rule.ID] = rule
+	}
+	for _, rule := range old.Rules {
+		k, ok := kept[rule.ID]
+		if !ok || rule.Action != k.Action || rule.Category != k.Category ||
+			!slices.Equal(rule.Domains, k.Domains) || !slices.Equal(rule.Paths, k.Paths) {
+			return true
+		}
+	}
+	return false
+}
+
+// detaches reports whether a profile of old is missing from profiles
+func detaches(old, profiles []string) bool {
+	for _, profile := range old {
+		if !slices.ContainsFunc(profiles, func(p string) bool { return strings.TrimSpace(p) == profile }) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRuleSetProfiles attaches a rule set to a list of profiles at once.
+// Detaching it from a profile is held for approval.
+func (s *Server) handleRuleSetProfiles(w http.ResponseWriter, r *http.Request) {
+	if s.ruleSets == nil {
+		writeError(w, http.StatusNotFound, "rule sets not configured")
+		return
+	}
+
+	var req RuleSetProfilesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	id := r.PathValue("id")
+	old, err := s.ruleSets.Get(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "rule set not found")
+		return
+	}
+	if detaches(old.Profiles, req.Profiles) && s.held(w, r, ActionRuleSetProfiles, id, req.Profiles) {
+		return
+	}
+	s.setRuleSetProfiles(w, r, id, req.Profiles)
+}
+
+// setRuleSetProfiles replaces the profiles a rule set is attached to and
+// writes the result
+func (s *Server) setRuleSetProfiles(w http.ResponseWriter, r *http.Request, id string, profiles []string) {
+	set, err := s.ruleSets.SetProfiles(r.Context(), id, profiles)
+	if errors.Is(err, rules.ErrRuleSetNotFound) {
+		writeError(w, http.StatusNotFound, "rule set not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to save rule set")
+		writeError(w, http.StatusInternalServerError, "failed to save rule set")
+		return
+	}
+	writeJSON(w, http.StatusOK, set)
+}
+
+// handleRuleSetRemove deletes a rule set
+func (s *Server) handleRuleSetRemove(w http.ResponseWriter, r *http.Request) {
+	if s.ruleSets == nil {
+		writeError(w, http.StatusNotFound, "rule sets not configured")
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := s.ruleSets.Get(id); err != nil {
+		writeError(w, http.StatusNotFound, "rule set not found")
+		return
+	}
+	if s.held(w, r, ActionRuleSetRemove, id, nil) {
+		return
+	}
+	s.removeRuleSet(w, r, id)
+}
+
+// removeRuleSet deletes a rule set and writes the result
+func (s *Server) removeRuleSet(w http.ResponseWriter, r *http.Request, id string) {
+	err := s.ruleSets.Delete(r.Context(), id)
+	if errors.Is(err, rules.ErrRuleSetNotFound) {
+		writeError(w, http.StatusNotFound, "rule set not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("id", id).Msg("Rule set removal failed")
+		writeError(w, http.StatusInternalServerError, "rule set removal failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"removed": id})
+}
+
+// deviceInfo describes a device from a policy lookup
+func (s *Server) deviceInfo(id string, d opa.Device) DeviceInfo {
+	info := DeviceInfo{ID: id, Name: d.Name, Identifiers: d.Identifiers, Profile: d.Profile, Source: "config"}
+	if s.devices != nil {
+		if device, ok := s.devices.Get(id); ok {
+			info.Source = "runtime"
+			info.Pending, info.DiscoveredBy = device.Pending, device.DiscoveredBy
+		}
+		_, info.Assigned = s.devices.Assigned(id)
+	}
+	info.DeviceType = s.deviceType(d.Identifiers)
+	return info
+}
+
+// deviceType is the device type guessed for the first of a device's
+// identifiers a client was fingerprinted by
+func (s *Server) deviceType(identifiers []string) string {
+	for _, identifier := range identifiers {
+		var deviceType string
+		if mac, err := net.ParseMAC(identifier); err == nil {
+			deviceType = s.fingerprint.DeviceType(nil, mac)
+		} else if ip := net.ParseIP(identifier); ip != nil {
+			deviceType = s.fingerprint.DeviceType(ip, nil)
+		}
+		if deviceType != "" {
+			return deviceType
+		}
+	}
+	return ""
+}
+
+// handleDeviceIdentify describes the client at ?ip= from the ARP table, its
+// DHCP lease, mDNS, its fingerprint, policy identification and recent
+// activity
+func (s *Server) handleDeviceIdentify(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(r.URL.Query().Get("ip"))
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, "invalid ip")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1500*time.Millisecond)
+	defer cancel()
+
+	id := Identification{IP: ip.String()}
+
+	if mac, err := devices.ARPLookup(ip); err != nil {
+		s.logger.Warn().Err(err).Msg("ARP lookup failed")
+	} else if mac != "" {
+		id.MAC, id.MACSource = mac, "arp"
+	}
+
+	if s.leases != nil {
+		lease, err := s.leases.GetByIP(ctx, ip.String())
+		if err == nil && !lease.IsExpired() {
+			id.Lease = lease
+			if id.MAC == "" {
+				id.MAC, id.MACSource = lease.MAC, "dhcp"
+			}
+		} else if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			s.logger.Warn().Err(err).Msg("DHCP lease lookup failed")
+		}
+	}
+
+	if name, err := devices.MDNSLookup(ctx, ip); err != nil {
+		s.logger.Debug().Err(err).Msg("mDNS lookup failed")
+	} else {
+		id.MDNSName = name
+	}
+
+	mac, _ := net.ParseMAC(id.MAC)
+	if f, ok := s.fingerprint.Lookup(ip, mac); ok {
+		id.Fingerprint = &f
+	}
+	lookup, err := s.policy.LookupDevices(ip, mac)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Device lookup failed")
+		writeError(w, http.StatusInternalServerError, "device lookup failed")
+		return
+	}
+	id.DeviceID = lookup.DeviceID
+	id.Device = lookup.Device
+
+	if s.activity != nil {
+		act := s.activity.Client(ip.String())
+		id.Activity = &act
+	}
+
+	writeJSON(w, http.StatusOK, id)
+}
+
+// pathMAC parses the {mac} path value into the form leases are stored by
+func pathMAC(w http.ResponseWriter, r *http.Request) (string, bool) {
+	mac, err := net.ParseMAC(r.PathValue("mac"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid MAC address")
+		return "", false
+	}
+	return mac.String(), true
+}
+
+// activeLease reads the unexpired lease of mac, writing the error if there
+// is none
+func (s *Server) activeLease(w http.ResponseWriter, r *http.Request, mac string) (*storage.DHCPLease, bool) {
+	lease, err := s.dhcp.GetByMAC(r.Context(), mac)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && lease.IsExpired()) {
+		writeError(w, http.StatusNotFound, "lease not found")
+		return nil, false
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to read DHCP lease")
+		writeError(w, http.StatusInternalServerError, "failed to read lease")
+		return nil, false
+	}
+	return lease, true
+}
+
+// isReserved reports whether mac has a reservation
+func (s *Server) isReserved(r *http.Request, mac string) bool {
+	if s.reserved == nil {
+		return false
+	}
+	_, err := s.reserved.Get(r.Context(), mac)
+	return err == nil
+}
+
+// handleLeases lists the unexpired DHCP leases, sorted by IP address
+func (s *Server) handleLeases(w http.ResponseWriter, r *http.Request) {
+	if s.dhcp == nil {
+		writeError(w, http.StatusNotFound, "DHCP not configured")
+		return
+	}
+
+	leases, err := s.dhcp.List(r.Context())
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list DHCP leases")
+		writeError(w, http.StatusInternalServerError, "failed to list leases")
+		return
+	}
+	list := make([]LeaseInfo, 0, len(leases))
+	for _, lease := range leases {
+		if !lease.IsExpired() {
+			list = append(list, LeaseInfo{DHCPLease: lease, Reserved: s.isReserved(r, lease.MAC)})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(list[i].IP).To16(), net.ParseIP(list[j].IP).To16()) < 0
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleLease reads the DHCP lease of a MAC address
+func (s *Server) handleLease(w http.ResponseWriter, r *http.Request) {
+	if s.dhcp == nil {
+		writeError(w, http.StatusNotFound, "DHCP not configured")
+		return
+	}
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	lease, ok := s.activeLease(w, r, mac)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, LeaseInfo{DHCPLease: *lease, Reserved: s.isReserved(r, mac)})
+}
+
+// handleLeaseRelease expires a DHCP lease now, freeing its address for
+// other clients. The client keeps using the address until it next renews,
+// when it is offered an address afresh.
+func (s *Server) handleLeaseRelease(w http.ResponseWriter, r *http.Request) {
+	if s.dhcp == nil {
+		writeError(w, http.StatusNotFound, "DHCP not configured")
+		return
+	}
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	lease, ok := s.activeLease(w, r, mac)
+	if !ok {
+		return
+	}
+	if err := s.dhcp.Delete(r.Context(), mac); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to release DHCP lease")
+		writeError(w, http.StatusInternalServerError, "failed to release lease")
+		return
+	}
+	s.logger.Info().Str("mac", mac).Str("ip", lease.IP).Str("account", account(r)).Msg("DHCP lease released")
+	writeJSON(w, http.StatusOK, map[string]string{"released": mac})
+}
+
+// handleLeaseReserve reserves the address of a DHCP lease for its client
+func (s *Server) handleLeaseReserve(w http.ResponseWriter, r *http.Request) {
+	if s.dhcp == nil || s.reserved == nil {
+		writeError(w, http.StatusNotFound, "DHCP not configured")
+		return
+	}
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	lease, ok := s.activeLease(w, r, mac)
+	if !ok {
+		return
+	}
+	reservation := storage.DHCPReservation{
+		MAC:       mac,
+		IP:        lease.IP,
+		Hostname:  lease.Hostname,
+		CreatedAt: time.Now(),
+	}
+	if err := s.reserved.Put(r.Context(), reservation); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to save DHCP reservation")
+		writeError(w, http.StatusInternalServerError, "failed to save reservation")
+		return
+	}
+	s.logger.Info().Str("mac", mac).Str("ip", lease.IP).Str("account", account(r)).Msg("DHCP lease reserved")
+	writeJSON(w, http.StatusOK, reservation)
+}
+
+// handleReservations lists the DHCP reservations
+func (s *Server) handleReservations(w http.ResponseWriter, r *http.Request) {
+	if s.reserved == nil {
+		writeError(w, http.StatusNotFound, "DHCP not configured")
+		return
+	}
+
+	reservations, err := s.reserved.List(r.Context())
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list DHCP reservations")
+		writeError(w, http.StatusInternalServerError, "failed to list reservations")
+		return
+	}
+	writeJSON(w, http.StatusOK, reservations)
+}
+
+// handleReservationRemove removes a DHCP reservation. The client keeps its
+// lease until it expires.
+func (s *Server) handleReservationRemove(w http.ResponseWriter, r *http.Request) {
+	if s.reserved == nil {
+		writeError(w, http.StatusNotFound, "DHCP not configured")
+		return
+	}
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.reserved.Delete(r.Context(), mac); errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "reservation not found")
+		return
+	} else if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to delete DHCP reservation")
+		writeError(w, http.StatusInternalServerError, "failed to delete reservation")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"removed": mac})
+}
+
+// handleBlockPages lists the custom block page templates
+func (s *Server) handleBlockPages(w http.ResponseWriter, r *http.Request) {
+	if s.blockPages == nil {
+		writeError(w, http.StatusNotFound, "block pages not configured")
+		return
+	}
+
+	pages, err := s.blockPages.List(r.Context())
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list block pages")
+		writeError(w, http.StatusInternalServerError, "failed to list block pages")
+		return
+	}
+	if pages == nil {
+		pages = []storage.BlockPage{}
+	}
+	writeJSON(w, http.StatusOK, pages)
+}
+
+// handleBlockPage returns a custom block page template
+func (s *Server) handleBlockPage(w http.ResponseWriter, r *http.Request) {
+	if s.blockPages == nil {
+		writeError(w, http.StatusNotFound, "block pages not configured")
+		return
+	}
+
+	page, err := s.blockPages.Get(r.Context(), r.PathValue("name"))
+	if errors.Is(err, blockpage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "block page not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to read block page")
+		writeError(w, http.StatusInternalServerError, "failed to read block page")
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleBlockPagePut creates or replaces a custom block page template.
+// Templates that don't parse or render are rejected.
+func (s *Server) handleBlockPagePut(w http.ResponseWriter, r *http.Request) {
+	if s.blockPages == nil {
+		writeError(w, http.StatusNotFound, "block pages not configured")
+		return
+	}
+
+	var req BlockPageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	page, created, err := s.blockPages.Put(r.Context(), r.PathValue("name"), req.Template)
+	if errors.Is(err, blockpage.ErrInvalidName) || errors.Is(err, blockpage.ErrInvalidTemplate) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to save block page")
+		writeError(w, http.StatusInternalServerError, "failed to save block page")
+		return
+	}
+	writeJSON(w, putStatus(created), page)
+}
+
+// handleBlockPageRemove deletes a custom block page template
+func (s *Server) handleBlockPageRemove(w http.ResponseWriter, r *http.Request) {
+	if s.blockPages == nil {
+		writeError(w, http.StatusNotFound, "block pages not configured")
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := s.blockPages.Delete(r.Context(), name); errors.Is(err, blockpage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "block page not found")
+		return
+	} else if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to delete block page")
+		writeError(w, http.StatusInternalServerError, "failed to delete block page")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"removed": name})
+}
+
+// handleApprovals lists the changes waiting for approval
+func (s *Server) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	if s.approvals == nil {
+		writeError(w, http.StatusNotFound, "approvals not configured")
+		return
+	}
+
+	changes, err := s.approvals.List(r.Context())
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list pending changes")
+		writeError(w, http.StatusInternalServerError, "failed to list pending changes")
+		return
+	}
+	if changes == nil {
+		changes = []storage.PendingChange{}
+	}
+	for i := range changes {
+		changes[i] = shownChange(changes[i])
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// handleApprovalApprove approves a pending change and applies it. The
+// approving account must differ from the requesting one.
+func (s *Server) handleApprovalApprove(w http.ResponseWriter, r *http.Request) {
+	if s.approvals == nil {
+		writeError(w, http.StatusNotFound, "approvals not configured")
+		return
+	}
+
+	change, err := s.approvals.Approve(r.Context(), r.PathValue("id"), account(r))
+	switch {
+	case errors.Is(err, approval.ErrNotFound):
+		writeError(w, http.StatusNotFound, "pending change not found")
+		return
+	case errors.Is(err, approval.ErrSelfApproval):
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	case err != nil:
+		s.logger.Error().Err(err).Msg("Failed to approve change")
+		writeError(w, http.StatusInternalServerError, "failed to approve change")
+		return
+	}
+
+	switch change.Action {
+	case ActionDeviceRemove:
+		if s.devices == nil {
+			writeError(w, http.StatusNotFound, "runtime devices not configured")
+			return
+		}
+		s.removeDevice(w, change.Target)
+	case ActionDevicePut:
+		if s.devices == nil {
+			writeError(w, http.StatusNotFound, "runtime devices not configured")
+			return
+		}
+		var req DeviceRequest
+		if err := json.Unmarshal(change.Params, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pending device change")
+			return
+		}
+		// Checked again, against the devices as they are now
+		s.putDevice(w, change.Target, req, false)
+	case ActionDeviceAssign:
+		if s.devices == nil {
+			writeError(w, http.StatusNotFound, "runtime devices not configured")
+			return
+		}
+		var profile string
+		if err := json.Unmarshal(change.Params, &profile); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pending profile assignment")
+			return
+		}
+		s.assignDevice(w, change.Target, profile, false)
+	case ActionDeviceRuleRemove:
+		if s.deviceRules == nil {
+			writeError(w, http.StatusNotFound, "device rules not configured")
+			return
+		}
+		s.removeDeviceRule(w, r, change.Target)
+	case ActionRuleRemove:
+		if s.rules == nil {
+			writeError(w, http.StatusNotFound, "runtime rules not configured")
+			return
+		}
+		s.removeRule(w, change.Target)
+	case ActionRulePut:
+		if s.rules == nil {
+			writeError(w, http.StatusNotFound, "runtime rules not configured")
+			return
+		}
+		var req RuleRequest
+		if err := json.Unmarshal(change.Params, &req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pending rule change")
+			return
+		}
+		s.putRule(w, change.Target, req, false)
+	case ActionRuleSetPut:
+		if s.ruleSets == nil {
+			writeError(w, http.StatusNotFound, "rule sets not configured")
+			return
+		}
+		var set storage.RuleSet
+		if err := json.Unmarshal(change.Params, &set); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pending rule set change")
+			return
+		}
+		s.putRuleSet(w, r, change.Target, set)
+	case ActionRuleSetProfiles:
+		if s.ruleSets == nil {
+			writeError(w, http.StatusNotFound, "rule sets not configured")
+			return
+		}
+		var profiles []string
+		if err := json.Unmarshal(change.Params, &profiles); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pending rule set change")
+			return
+		}
+		s.setRuleSetProfiles(w, r, change.Target, profiles)
+	case ActionRuleSetRemove:
+		if s.ruleSets == nil {
+			writeError(w, http.StatusNotFound, "rule sets not configured")
+			return
+		}
+		s.removeRuleSet(w, r, change.Target)
+	case ActionPolicyWrite:
+		if s.policies == nil {
+			writeError(w, http.StatusNotFound, "policy editing not configured")
+			return
+		}
+		var content string
+		if err := json.Unmarshal(change.Params, &content); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pending policy change")
+			return
+		}
+		// Compiled again, against the policies as they are now
+		if result, ok := s.putPolicy(w, r, change.Target, content, false); ok {
+			writeJSON(w, putStatus(result.Created && result.Saved), result)
+		}
+	case ActionImport:
+		var export Export
+		if err := json.Unmarshal(change.Params, &export); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pending import")
+			return
+		}
+		// Checked again, against the configuration as it is now
+		if result, ok := s.importExport(w, r, export, false); ok {
+			writeJSON(w, http.StatusOK, result)
+		}
+	case ActionRestore:
+		if s.backups == nil {
+			writeError(w, http.StatusNotFound, "backups not configured")
+			return
+		}
+		var pending pendingRestore
+		if err := json.Unmarshal(change.Params, &pending); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid pending restore")
+			return
+		}
+		if result, ok := s.restore(w, r, pending.Archive, pending.CA, false); ok {
+			writeJSON(w, http.StatusOK, result)
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unknown action: "+change.Action)
+	}
+}
+
+// handleApprovalReject drops a pending change without applying it
+func (s *Server) handleApprovalReject(w http.ResponseWriter, r *http.Request) {
+	if s.approvals == nil {
+		writeError(w, http.StatusNotFound, "approvals not configured")
+		return
+	}
+
+	id := r.PathValue("id")
+	if _, err := s.approvals.Reject(r.Context(), id, account(r)); errors.Is(err, approval.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "pending change not found")
+		return
+	} else if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to reject change")
+		writeError(w, http.StatusInternalServerError, "failed to reject change")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"rejected": id})
+}
+
+// handleProbes returns the connectivity probe history. "since" limits it to
+// a recent period (e.g. "6h"); the default is everything kept.
+func (s *Server) handleProbes(w http.ResponseWriter, r *http.Request) {
+	if s.probes == nil {
+		writeError(w, http.StatusNotFound, "probes not configured")
+		return
+	}
+
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid since: "+v)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	writeJSON(w, http.StatusOK, s.probes.History(since))
+}
+
+// handleCanary reports the decisions on which the candidate policies
+// differ from the active ones
+func (s *Server) handleCanary(w http.ResponseWriter, r *http.Request) {
+	if s.canary == nil {
+		writeError(w, http.StatusNotFound, "candidate policies not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.canary.Report())
+}
+
+// handleAccessRequests lists the access requests waiting for an answer
+func (s *Server) handleAccessRequests(w http.ResponseWriter, r *http.Request) {
+	if s.access == nil {
+		writeError(w, http.StatusNotFound, "access requests not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.access.List())
+}
+
+// handleAccessApprove approves an access request with a temporary runtime
+// rule allowing the host for the request's profile
+func (s *Server) handleAccessApprove(w http.ResponseWriter, r *http.Request) {
+	if s.access == nil {
+		writeError(w, http.StatusNotFound, "access requests not configured")
+		return
+	}
+	if s.rules == nil {
+		writeError(w, http.StatusNotFound, "runtime rules not configured")
+		return
+	}
+
+	var body AccessApproveRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	until := time.Now().Add(defaultAccessDuration)
+	switch {
+	case body.Until != nil && body.Duration != "":
+		writeError(w, http.StatusBadRequest, "give until or duration, not both")
+		return
+	case body.Until != nil:
+		until = *body.Until
+	case body.Duration != "":
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid duration: "+body.Duration)
+			return
+		}
+		until = time.Now().Add(d)
+	}
+
+	id := r.PathValue("id")
+	req, err := s.access.Get(id)
+	if errors.Is(err, access.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "access request not found")
+		return
+	}
+
+	rule, err := s.rules.Add(req.Profile, req.Host, rules.ActionAllow, until)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := s.access.Remove(id); err != nil {
+		// Answered concurrently; the rule stands either way
+		s.logger.Debug().Err(err).Str("id", id).Msg("Access request already answered")
+	}
+
+	s.logger.Info().
+		Str("id", id).
+		Str("host", req.Host).
+		Str("profile", req.Profile).
+		Str("account", account(r)).
+		Time("until", until).
+		Msg("Access request approved")
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// handleAccessDeny drops an access request without allowing anything
+func (s *Server) handleAccessDeny(w http.ResponseWriter, r *http.Request) {
+	if s.access == nil {
+		writeError(w, http.StatusNotFound, "access requests not configured")
+		return
+	}
+
+	id := r.PathValue("id")
+	req, err := s.access.Remove(id)
+	if errors.Is(err, access.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "access request not found")
+		return
+	}
+
+	s.logger.Info().
+		Str("id", id).
+		Str("host", req.Host).
+		Str("profile", req.Profile).
+		Str("account", account(r)).
+		Msg("Access request denied")
+	writeJSON(w, http.StatusOK, map[string]string{"denied": id})
+}
+
+// handleIssuedCerts searches the certificates the CA minted, newest first:
+// host (substring), client (address), serial (or SHA-256 fingerprint),
+// since (a duration back from now) and limit (default 100)
+func (s *Server) handleIssuedCerts(w http.ResponseWriter, r *http.Request) {
+	if s.issued == nil {
+		writeError(w, http.StatusNotFound, "issuance log not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	host := strings.ToLower(query.Get("host"))
+	client := query.Get("client")
+	serial := strings.ToLower(strings.ReplaceAll(query.Get("serial"), ":", ""))
+	var since time.Time
+	if v := query.Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid since: "+v)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	limit := 100
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	certs, err := s.issued.List(r.Context())
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list issued certificates")
+		writeError(w, http.StatusInternalServerError, "failed to list issued certificates")
+		return
+	}
+	matches := []storage.IssuedCert{}
+	for _, cert := range certs {
+		if len(matches) == limit || cert.Issued.Before(since) {
+			break
+		}
+		if host != "" && !strings.Contains(cert.Host, host) {
+			continue
+		}
+		if client != "" && cert.Client != client {
+			continue
+		}
+		if serial != "" && cert.Serial != serial && cert.Fingerprint != serial {
+			continue
+		}
+		matches = append(matches, cert)
+	}
+	writeJSON(w, http.StatusOK, matches)
+}
+
+// handleCARotate generates a new intermediate (and with root, a new root)
+// and re-signs leaf certificates with it from the next handshake
+func (s *Server) handleCARotate(w http.ResponseWriter, r *http.Request) {
+	if s.rotator == nil {
+		writeError(w, http.StatusNotFound, "certificate authority not configured")
+		return
+	}
+
+	var req CARotateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+	grace := ca.DefaultGrace
+	if req.Grace != "" {
+		d, err := time.ParseDuration(req.Grace)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "invalid grace")
+			return
+		}
+		grace = d
+	}
+
+	rotation, err := s.rotator.Rotate(req.Root, grace)
+	switch {
+	case errors.Is(err, ca.ErrExternalPKI), errors.Is(err, ca.ErrNoIntermediate):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		s.logger.Error().Err(err).Msg("Failed to rotate certificate authority")
+		writeError(w, http.StatusInternalServerError, "failed to rotate certificate authority")
+		return
+	}
+
+	s.logger.Info().
+		Str("account", account(r)).
+		Bool("root", rotation.Root).
+		Str("backup", rotation.Backup).
+		Msg("Certificate authority rotated")
+	writeJSON(w, http.StatusOK, rotation)
+}
+
+// handleClientCerts lists the hosts tunnelled because they asked for a
+// client certificate
+func (s *Server) handleClientCerts(w http.ResponseWriter, r *http.Request) {
+	if s.clientCerts == nil {
+		writeError(w, http.StatusNotFound, "client certificate bypass not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.clientCerts.List())
+}
+
+// handleClientCertRemove has a host intercepted again
+func (s *Server) handleClientCertRemove(w http.ResponseWriter, r *http.Request) {
+	if s.clientCerts == nil {
+		writeError(w, http.StatusNotFound, "client certificate bypass not configured")
+		return
+	}
+
+	host := r.PathValue("host")
+	if err := s.clientCerts.Remove(host); errors.Is(err, clientcert.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "host not bypassed")
+		return
+	}
+	s.logger.Info().Str("host", host).Str("account", account(r)).Msg("Client certificate bypass removed")
+	writeJSON(w, http.StatusOK, map[string]string{"removed": host})
+}
+
+// putStatus is the status of a PUT that created (201) or replaced (200) a resource
+func putStatus(created bool) int {
+	if created {
+		return http.StatusCreated
+	}
+	return http.StatusOK
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// handlePolicies lists the policy files
+func (s *Server) handlePolicies(w http.ResponseWriter, r *http.Request) {
+	if s.policies == nil {
+		writeError(w, http.StatusNotFound, "policy editing not configured")
+		return
+	}
+
+	files, err := s.policies.List()
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to list policy files")
+		writeError(w, http.StatusInternalServerError, "failed to list policy files")
+		return
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// handlePolicy returns one policy file with its content
+func (s *Server) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	if s.policies == nil {
+		writeError(w, http.StatusNotFound, "policy editing not configured")
+		return
+	}
+
+	file, err := s.policies.Get(r.PathValue("name"))
+	if errors.Is(err, policyedit.ErrInvalidName) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, policyedit.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "policy file not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to read policy file")
+		writeError(w, http.StatusInternalServerError, "failed to read policy file")
+		return
+	}
+	writeJSON(w, http.StatusOK, file)
+}
+
+// handlePolicyPut compiles a policy file with the rest of the policies and,
+// unless it's a dry run, saves it and reloads the policies. A change that
+// compiles is held for approval when an approval queue is set, since it
+// can change every decision.
+func (s *Server) handlePolicyPut(w http.ResponseWriter, r *http.Request) {
+	if s.policies == nil {
+		writeError(w, http.StatusNotFound, "policy editing not configured")
+		return
+	}
+
+	var req PolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	name := r.PathValue("name")
+	hold := !req.DryRun && s.approvals != nil
+	result, ok := s.putPolicy(w, r, name, req.Content, req.DryRun || hold)
+	if !ok {
+		return
+	}
+	if hold && result.Diff != "" && s.held(w, r, ActionPolicyWrite, name, req.Content) {
+		return
+	}
+	writeJSON(w, putStatus(result.Created && result.Saved), result)
+}
+
+// putPolicy saves a policy file, or only compiles it for a dry run. It
+// answers errors itself, reporting false once it has.
+func (s *Server) putPolicy(w http.ResponseWriter, r *http.Request, name, content string, dryRun bool) (*policyedit.Result, bool) {
+	result, err := s.policies.Put(name, content, dryRun)
+	if errors.Is(err, policyedit.ErrInvalidName) || errors.Is(err, policyedit.ErrCompile) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to save policy file")
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if result.Saved {
+		s.logger.Info().Str("name", result.Name).Str("account", account(r)).Msg("Policy file edited")
+	}
+	return result, true
+}
@@ -0,0 +1,1953 @@
+package admin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/access"
+	"github.com/goodtune/kproxy/internal/activity"
+	"github.com/goodtune/kproxy/internal/apitoken"
+	"github.com/goodtune/kproxy/internal/approval"
+	"github.com/goodtune/kproxy/internal/backup"
+	"github.com/goodtune/kproxy/internal/blockpage"
+	"github.com/goodtune/kproxy/internal/ca"
+	"github.com/goodtune/kproxy/internal/clientcert"
+	"github.com/goodtune/kproxy/internal/devices"
+	"github.com/goodtune/kproxy/internal/features"
+	"github.com/goodtune/kproxy/internal/fingerprint"
+	"github.com/goodtune/kproxy/internal/maintenance"
+	"github.com/goodtune/kproxy/internal/pause"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/goodtune/kproxy/internal/policyedit"
+	"github.com/goodtune/kproxy/internal/probe"
+	"github.com/goodtune/kproxy/internal/report"
+	"github.com/goodtune/kproxy/internal/rules"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/goodtune/kproxy/internal/update"
+	"github.com/goodtune/kproxy/internal/usage"
+	"github.com/goodtune/kproxy/policies"
+	"github.com/rs/zerolog"
+)
+
+type fakePolicy struct{}
+
+func (fakePolicy) ProfileSchedule(profileID string) (*opa.Schedule, error) {
+	if profileID != "child" {
+		return nil, opa.ErrProfileNotFound
+	}
+	return &opa.Schedule{
+		Profile:    "child",
+		Categories: []string{"default"},
+		Schedule:   map[string][][]string{"default": {{"BLOCK"}}},
+	}, nil
+}
+
+func (fakePolicy) LookupDevices(clientIP net.IP, clientMAC net.HardwareAddr) (*opa.DeviceLookup, error) {
+	return &opa.DeviceLookup{
+		Devices: map[string]opa.Device{
+			"tablet": {Name: "Tablet", Identifiers: []string{"aa:bb:cc:dd:ee:ff"}, Profile: "child"},
+		},
+		Profiles: []string{"adult", "child"},
+	}, nil
+}
+
+func TestProfileSchedule(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	tests := []struct {
+		name       string
+		path       string
+		auth       string
+		wantStatus int
+	}{
+		{"no token", "/api/profiles/child/schedule", "", http.StatusUnauthorized},
+		{"wrong token", "/api/profiles/child/schedule", "Bearer nope", http.StatusUnauthorized},
+		{"unknown profile", "/api/profiles/missing/schedule", "Bearer secret", http.StatusNotFound},
+		{"known profile", "/api/profiles/child/schedule", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var schedule opa.Schedule
+			if err := json.NewDecoder(rec.Body).Decode(&schedule); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if schedule.Profile != "child" || schedule.Schedule["default"][0][0] != "BLOCK" {
+				t.Errorf("unexpected schedule: %+v", schedule)
+			}
+		})
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	maint := maintenance.New("Back soon", time.Hour, zerolog.Nop())
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maint, zerolog.Nop())
+
+	do := func(method, body string) (int, maintenance.Status) {
+		req := httptest.NewRequest(method, "/api/maintenance", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+
+		var status maintenance.Status
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+		}
+		return rec.Code, status
+	}
+
+	if code, status := do(http.MethodGet, ""); code != http.StatusOK || status.Active {
+		t.Fatalf("GET = %d %+v, want inactive", code, status)
+	}
+
+	if code, _ := do(http.MethodPut, `{"duration": "soon"}`); code != http.StatusBadRequest {
+		t.Errorf("PUT with bad duration = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	code, status := do(http.MethodPut, `{"message": "Upgrading", "duration": "30m"}`)
+	if code != http.StatusOK || !status.Active || status.Message != "Upgrading" {
+		t.Fatalf("PUT = %d %+v, want active with message", code, status)
+	}
+	if remaining := time.Until(*status.Until); remaining <= 29*time.Minute || remaining > 30*time.Minute {
+		t.Errorf("window ends in %v, want 30m", remaining)
+	}
+	if !maint.Active() {
+		t.Error("maintenance not enabled")
+	}
+
+	// An empty body uses the defaults
+	if code, status := do(http.MethodPut, ""); code != http.StatusOK || status.Message != "Back soon" {
+		t.Errorf("PUT without body = %d %+v, want default message", code, status)
+	}
+
+	if code, status := do(http.MethodDelete, ""); code != http.StatusOK || status.Active {
+		t.Errorf("DELETE = %d %+v, want inactive", code, status)
+	}
+}
+
+type fakeVersions struct{}
+
+func (fakeVersions) Status() update.Status {
+	return update.Status{Current: "v1.1.0", Available: "v1.2.0", UpdateAvailable: true, Channel: "stable"}
+}
+
+// countingNotifier counts change notices
+type countingNotifier struct {
+	notices int
+}
+
+func (n *countingNotifier) Notify() {
+	n.notices++
+}
+
+func TestChangeNotifier(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	notifier := &countingNotifier{}
+	s.SetChangeNotifier(notifier)
+
+	do := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	do(http.MethodGet, "/api/maintenance", "")
+	if notifier.notices != 0 {
+		t.Errorf("GET announced %d changes, want none", notifier.notices)
+	}
+	if code := do(http.MethodPut, "/api/maintenance", `{"duration": "soon"}`); code != http.StatusBadRequest || notifier.notices != 0 {
+		t.Errorf("failed PUT = %d and announced %d changes, want none", code, notifier.notices)
+	}
+	if code := do(http.MethodPut, "/api/maintenance", ""); code != http.StatusOK || notifier.notices != 1 {
+		t.Errorf("PUT = %d and announced %d changes, want one", code, notifier.notices)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/version", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get(); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without reporter = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	s.SetVersionReporter(fakeVersions{})
+	rec := get()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var status update.Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if status.Current != "v1.1.0" || status.Available != "v1.2.0" || !status.UpdateAvailable {
+		t.Errorf("GET = %+v", status)
+	}
+}
+
+func TestFeaturesAndSystemInfo(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	flags, err := features.New(map[string]bool{features.H3Listener: true}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetFeatures(flags)
+	s.SetSystemInfo(SystemInfo{Version: "v1.2.0", Channel: "beta", Mode: "full", Started: time.Now().Add(-time.Hour)})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPut, "/api/features/content_scanning", `{"enabled": true}`)
+	var flag features.Flag
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&flag); err != nil || !flag.Enabled || !flag.Overridden {
+		t.Errorf("PUT = %+v (%v), want enabled and overridden", flag, err)
+	}
+	if !flags.Enabled(features.ContentScanning) {
+		t.Error("content_scanning not enabled")
+	}
+
+	if rec := do(http.MethodPut, "/api/features/warp_drive", `{"enabled": true}`); rec.Code != http.StatusNotFound {
+		t.Errorf("PUT unknown flag = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if rec := do(http.MethodDelete, "/api/features/content_scanning", ""); rec.Code != http.StatusOK || flags.Enabled(features.ContentScanning) {
+		t.Errorf("DELETE = %d, want the flag back to its configured value", rec.Code)
+	}
+
+	rec = do(http.MethodGet, "/api/system/info", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET system info = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var info SystemInfo
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if info.Version != "v1.2.0" || info.Channel != "beta" || info.Uptime != "1h0m0s" || len(info.Features) != 2 {
+		t.Errorf("system info = %+v", info)
+	}
+}
+
+type fakeUsage struct{}
+
+func (fakeUsage) ListTodayUsage() ([]usage.Meter, error) {
+	return []usage.Meter{{DeviceID: "kids-ipad", LimitID: "gaming", Seconds: 1800, Active: true}}, nil
+}
+
+func TestActivity(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	recorder := activity.NewRecorder()
+	recorder.RecordQuery("192.168.1.10")
+	recorder.RecordBlock("dns", "192.168.1.10", "ads.example.com", "BLOCK")
+	s.SetActivity(recorder, fakeUsage{})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/activity?top=0"); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET ?top=0 = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := get("/api/activity?top=5")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got Activity
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if got.QueriesLastMinute != 1 || len(got.TopClients) != 1 || got.TopClients[0].Client != "192.168.1.10" {
+		t.Errorf("queries = %d %+v, want one from 192.168.1.10", got.QueriesLastMinute, got.TopClients)
+	}
+	if len(got.RecentBlocks) != 1 || got.RecentBlocks[0].Host != "ads.example.com" {
+		t.Errorf("recent blocks = %+v", got.RecentBlocks)
+	}
+	if len(got.Usage) != 1 || got.Usage[0].Seconds != 1800 {
+		t.Errorf("usage = %+v", got.Usage)
+	}
+}
+
+func TestWeeklyReport(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/reports/weekly?profile=child"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without reports = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	recorder := report.New(memory.Open().Reports(), zerolog.Nop())
+	recorder.Record("child", "youtube.com", "video", "ALLOW", "")
+	s.SetReports(recorder)
+
+	for path, want := range map[string]int{
+		"/api/reports/weekly":                              http.StatusBadRequest,
+		"/api/reports/weekly?profile=missing":              http.StatusNotFound,
+		"/api/reports/weekly?profile=child&end=yesterday":  http.StatusBadRequest,
+		"/api/reports/weekly?profile=child&format=pdf":     http.StatusBadRequest,
+		"/api/reports/weekly?profile=child&end=2026-03-01": http.StatusOK,
+		"/api/reports/weekly?profile=adult&format=json":    http.StatusOK,
+	} {
+		if rec := get(path); rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+
+	rec := get("/api/reports/weekly?profile=child")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") || !strings.Contains(rec.Body.String(), "youtube.com") {
+		t.Errorf("GET = %s %q, want an HTML report listing youtube.com", ct, rec.Body.String())
+	}
+
+	rec = get("/api/reports/weekly?profile=child&format=json")
+	var weekly report.Weekly
+	if err := json.NewDecoder(rec.Body).Decode(&weekly); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if weekly.Profile != "child" || weekly.Requests != 1 || len(weekly.Days) != 7 {
+		t.Errorf("JSON report = %+v, want one request for child over seven days", weekly)
+	}
+}
+
+func TestRules(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	set := rules.New(zerolog.Nop())
+	s.SetRules(set)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	until := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	rec := do(http.MethodPost, "/api/rules", `{"profile": "kids", "domain": ".roblox.com", "action": "allow", "until": "`+until+`"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var rule rules.Rule
+	if err := json.NewDecoder(rec.Body).Decode(&rule); err != nil || rule.ID == "" || rule.Until == nil {
+		t.Fatalf("POST = %+v (%v), want a rule with an expiry", rule, err)
+	}
+
+	if rec := do(http.MethodPost, "/api/rules", `{"profile": "kids", "domain": ".roblox.com", "action": "maybe"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST invalid action = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = do(http.MethodGet, "/api/rules", "")
+	var list []rules.Rule
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 1 || list[0].Domain != ".roblox.com" {
+		t.Errorf("GET = %+v (%v), want the added rule", list, err)
+	}
+
+	if rec := do(http.MethodDelete, "/api/rules/"+rule.ID, ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodDelete, "/api/rules/"+rule.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE again = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if len(set.List()) != 0 {
+		t.Errorf("rules left after DELETE: %+v", set.List())
+	}
+
+	// PUT is idempotent: created once, then replaced in place
+	body := `{"profile": "kids", "domain": "tiktok.com", "action": "block"}`
+	for _, want := range []int{http.StatusCreated, http.StatusOK} {
+		if rec := do(http.MethodPut, "/api/rules/kids-tiktok", body); rec.Code != want {
+			t.Errorf("PUT = %d, want %d: %s", rec.Code, want, rec.Body)
+		}
+	}
+	rec = do(http.MethodGet, "/api/rules/kids-tiktok", "")
+	if err := json.NewDecoder(rec.Body).Decode(&rule); err != nil || rule.ID != "kids-tiktok" || rule.Action != "block" {
+		t.Errorf("GET = %+v (%v), want kids-tiktok", rule, err)
+	}
+	if len(set.List()) != 1 {
+		t.Errorf("rules after PUT twice: %+v, want one", set.List())
+	}
+	if rec := do(http.MethodGet, "/api/rules/missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET missing = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	reg := devices.New(zerolog.Nop())
+	s.SetDevices(reg, nil)
+	fingerprints := fingerprint.NewCollector(zerolog.Nop())
+	tabletMAC, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	fingerprints.ObserveUserAgent(net.ParseIP("192.168.1.20"), tabletMAC, "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)")
+	s.SetFingerprints(fingerprints)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"add", http.MethodPost, "/api/devices", `{"id": "laptop", "identifiers": ["11:22:33:44:55:66"], "profile": "adult"}`, http.StatusOK},
+		{"add again", http.MethodPost, "/api/devices", `{"id": "laptop", "identifiers": ["192.168.1.9"], "profile": "adult"}`, http.StatusConflict},
+		{"add configured", http.MethodPost, "/api/devices", `{"id": "tablet", "identifiers": ["192.168.1.9"], "profile": "adult"}`, http.StatusConflict},
+		{"add unknown profile", http.MethodPost, "/api/devices", `{"id": "phone", "identifiers": ["192.168.1.9"], "profile": "guest"}`, http.StatusBadRequest},
+		{"add invalid identifier", http.MethodPost, "/api/devices", `{"id": "phone", "identifiers": ["kitchen"], "profile": "adult"}`, http.StatusBadRequest},
+		{"assign", http.MethodPut, "/api/devices/tablet/profile", `{"profile": "adult"}`, http.StatusOK},
+		{"assign unknown device", http.MethodPut, "/api/devices/phone/profile", `{"profile": "adult"}`, http.StatusNotFound},
+		{"assign unknown profile", http.MethodPut, "/api/devices/tablet/profile", `{"profile": "guest"}`, http.StatusBadRequest},
+		{"identify invalid ip", http.MethodGet, "/api/devices/identify?ip=kitchen", "", http.StatusBadRequest},
+		{"put", http.MethodPut, "/api/devices/tv", `{"identifiers": ["192.168.1.40"], "profile": "child"}`, http.StatusCreated},
+		{"put again", http.MethodPut, "/api/devices/tv", `{"identifiers": ["192.168.1.40"], "profile": "child"}`, http.StatusOK},
+		{"put configured", http.MethodPut, "/api/devices/tablet", `{"identifiers": ["192.168.1.41"], "profile": "child"}`, http.StatusConflict},
+		{"put mismatched id", http.MethodPut, "/api/devices/tv", `{"id": "radio", "identifiers": ["192.168.1.40"], "profile": "child"}`, http.StatusBadRequest},
+		{"get", http.MethodGet, "/api/devices/tablet", "", http.StatusOK},
+		{"get unknown", http.MethodGet, "/api/devices/phone", "", http.StatusNotFound},
+		{"delete", http.MethodDelete, "/api/devices/tv", "", http.StatusOK},
+		{"delete again", http.MethodDelete, "/api/devices/tv", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(tt.method, tt.path, tt.body); rec.Code != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	if profile, ok := reg.Assigned("tablet"); !ok || profile != "adult" {
+		t.Errorf("Assigned(tablet) = %q, %v, want adult", profile, ok)
+	}
+	if list := reg.Devices(); len(list) != 1 || list[0].ID != "laptop" {
+		t.Errorf("Devices() = %+v, want laptop", list)
+	}
+
+	rec := do(http.MethodGet, "/api/devices", "")
+	var list []DeviceInfo
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 1 || !list[0].Assigned {
+		t.Errorf("GET = %+v (%v), want tablet with an assigned profile", list, err)
+	}
+	if len(list) == 1 && list[0].DeviceType != "tablet" {
+		t.Errorf("GET device type = %q, want tablet from its fingerprint", list[0].DeviceType)
+	}
+}
+
+func TestDeviceRules(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	set := rules.NewDeviceSet(memory.Open().DeviceRules(), zerolog.Nop())
+	s.SetDeviceRules(set)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/devices/tablet/rules", `{"domain": ".roblox.com", "action": "allow"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var rule storage.DeviceRule
+	if err := json.NewDecoder(rec.Body).Decode(&rule); err != nil || rule.ID == "" || rule.Device != "tablet" {
+		t.Fatalf("POST = %+v (%v), want a rule for the tablet", rule, err)
+	}
+
+	if rec := do(http.MethodPost, "/api/devices/phone/rules", `{"domain": ".roblox.com", "action": "allow"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("POST for an unknown device = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do(http.MethodPost, "/api/devices/tablet/rules", `{"domain": ".roblox.com", "action": "maybe"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST invalid action = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = do(http.MethodGet, "/api/devices/tablet/rules", "")
+	var list []storage.DeviceRule
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 1 || list[0].Domain != ".roblox.com" {
+		t.Errorf("GET = %+v (%v), want the added rule", list, err)
+	}
+
+	if rec := do(http.MethodDelete, "/api/devices/phone/rules/"+rule.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE through another device = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do(http.MethodDelete, "/api/devices/tablet/rules/"+rule.ID, ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rules := set.List(""); len(rules) != 0 {
+		t.Errorf("rules left after DELETE: %+v", rules)
+	}
+}
+
+func TestPauses(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPut, "/api/devices/tablet/pause", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("PUT without pauses = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	set := pause.New(memory.Open().Pauses(), zerolog.Nop())
+	s.SetPauses(set)
+
+	rec := do(http.MethodPut, "/api/devices/tablet/pause", `{"duration": "30m"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var p storage.Pause
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil || p.Kind != storage.PauseDevice || p.Target != "tablet" || p.Until == nil {
+		t.Fatalf("PUT = %+v (%v), want a 30 minute pause of the tablet", p, err)
+	}
+	if rec := do(http.MethodPut, "/api/profiles/child/pause", ""); rec.Code != http.StatusOK {
+		t.Errorf("PUT profile = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	if rec := do(http.MethodPut, "/api/devices/phone/pause", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("PUT unknown device = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do(http.MethodPut, "/api/profiles/teen/pause", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("PUT unknown profile = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do(http.MethodPut, "/api/devices/tablet/pause", `{"duration": "soon"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT invalid duration = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = do(http.MethodGet, "/api/pauses", "")
+	var list []storage.Pause
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 2 {
+		t.Errorf("GET = %+v (%v), want both pauses", list, err)
+	}
+
+	if rec := do(http.MethodDelete, "/api/devices/tablet/pause", ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodDelete, "/api/devices/tablet/pause", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE again = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if pauses := set.List(); len(pauses) != 1 || pauses[0].Kind != storage.PauseProfile {
+		t.Errorf("pauses left after DELETE: %+v, want the child profile", pauses)
+	}
+}
+
+func TestRuleSets(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	sets := rules.NewRuleSets(memory.Open().RuleSets(), zerolog.Nop())
+	s.SetRuleSets(sets)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"name": "Social Media", "profiles": ["child"], "rules": [{"id": "block-tiktok", "domains": [".tiktok.com"], "action": "block", "category": "social"}]}`
+	if rec := do(http.MethodPut, "/api/rulesets/social-media", body); rec.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if rec := do(http.MethodPut, "/api/rulesets/social-media", body); rec.Code != http.StatusOK {
+		t.Errorf("PUT again = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodPut, "/api/rulesets/gaming", `{"rules": [{"id": "x", "domains": ["a.com"], "action": "maybe"}]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT invalid action = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := do(http.MethodPut, "/api/rulesets/social-media/profiles", `{"profiles": ["teen", "child"]}`)
+	var set storage.RuleSet
+	if err := json.NewDecoder(rec.Body).Decode(&set); err != nil || len(set.Profiles) != 2 || len(set.Rules) != 1 {
+		t.Errorf("PUT profiles = %+v (%v), want two profiles and the rules kept", set, err)
+	}
+	if rec := do(http.MethodPut, "/api/rulesets/gaming/profiles", `{"profiles": ["teen"]}`); rec.Code != http.StatusNotFound {
+		t.Errorf("PUT profiles of an unknown rule set = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = do(http.MethodGet, "/api/rulesets", "")
+	var list []storage.RuleSet
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 1 || list[0].Name != "Social Media" {
+		t.Errorf("GET = %+v (%v), want the social-media rule set", list, err)
+	}
+
+	if rec := do(http.MethodDelete, "/api/rulesets/social-media", ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodGet, "/api/rulesets/social-media", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	ruleSet := rules.New(zerolog.Nop())
+	s.SetRules(ruleSet)
+	sets := rules.NewRuleSets(memory.Open().RuleSets(), zerolog.Nop())
+	s.SetRuleSets(sets)
+	pauses := pause.New(memory.Open().Pauses(), zerolog.Nop())
+	s.SetPauses(pauses)
+
+	if _, err := ruleSet.Add("child", "minecraft.net", rules.ActionAllow, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := sets.Put(context.Background(), "social-media", storage.RuleSet{Name: "Social Media", Profiles: []string{"child"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pauses.Pause(context.Background(), storage.PauseProfile, "child", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var list []ProfileInfo
+	if err := json.NewDecoder(do("/api/profiles").Body).Decode(&list); err != nil || len(list) != 2 {
+		t.Fatalf("GET = %+v (%v), want adult and child", list, err)
+	}
+	child := list[1]
+	if child.ID != "child" || len(child.Devices) != 1 || child.Devices[0] != "tablet" || len(child.RuleSets) != 1 || child.Rules != 1 || child.Paused == nil {
+		t.Errorf("child = %+v, want the tablet, social-media, one rule and paused", child)
+	}
+	if adult := list[0]; len(adult.Devices) != 0 || adult.Rules != 0 || adult.Paused != nil {
+		t.Errorf("adult = %+v, want nothing attached", adult)
+	}
+
+	var detail ProfileDetail
+	if err := json.NewDecoder(do("/api/profiles/child").Body).Decode(&detail); err != nil || len(detail.RuntimeRules) != 1 || detail.Schedule == nil {
+		t.Errorf("GET child = %+v (%v), want its rule and schedule", detail, err)
+	}
+	if rec := do("/api/profiles/teen"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET unknown profile = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	s.SetAccounts(map[string]string{"parent": "other-secret"})
+	reg := devices.New(zerolog.Nop())
+	s.SetDevices(reg, nil)
+	s.SetApprovals(approval.New(memory.Open().PendingChanges(), time.Hour, zerolog.Nop()))
+
+	do := func(token, method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if _, _, err := reg.Put("tv", "", []string{"192.168.1.40"}, "child"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do("secret", http.MethodDelete, "/api/devices/phone"); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE unknown device = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// Removal is held until a second account approves it
+	rec := do("secret", http.MethodDelete, "/api/devices/tv")
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("DELETE = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	var change storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || change.Action != ActionDeviceRemove || change.RequestedBy != DefaultAccount {
+		t.Fatalf("DELETE = %+v (%v), want a pending device removal by admin", change, err)
+	}
+	if _, ok := reg.Get("tv"); !ok {
+		t.Fatal("device removed before approval")
+	}
+
+	rec = do("other-secret", http.MethodGet, "/api/approvals")
+	var list []storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 1 || list[0].ID != change.ID {
+		t.Errorf("GET = %+v (%v), want the pending change", list, err)
+	}
+
+	if rec := do("secret", http.MethodPost, "/api/approvals/"+change.ID+"/approve"); rec.Code != http.StatusForbidden {
+		t.Errorf("self approval = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := do("other-secret", http.MethodPost, "/api/approvals/"+change.ID+"/approve"); rec.Code != http.StatusOK {
+		t.Errorf("approval = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if _, ok := reg.Get("tv"); ok {
+		t.Error("device not removed after approval")
+	}
+	if rec := do("other-secret", http.MethodPost, "/api/approvals/"+change.ID+"/approve"); rec.Code != http.StatusNotFound {
+		t.Errorf("second approval = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// A rejected change is never applied
+	reg.Assign("tablet", "adult")
+	rec = do("other-secret", http.MethodDelete, "/api/devices/tablet")
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || rec.Code != http.StatusAccepted {
+		t.Fatalf("DELETE = %d (%v), want %d", rec.Code, err, http.StatusAccepted)
+	}
+	if rec := do("secret", http.MethodDelete, "/api/approvals/"+change.ID); rec.Code != http.StatusOK {
+		t.Errorf("reject = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if _, ok := reg.Assigned("tablet"); !ok {
+		t.Error("assignment cleared after rejection")
+	}
+
+	// A change made with an API token is its creator's to get approved
+	tokens := apitoken.New(memory.Open().APITokens(), zerolog.Nop())
+	s.SetAPITokens(tokens)
+	secret, _, err := tokens.Create(context.Background(), "automation", []string{"*"}, DefaultAccount, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = do(secret, http.MethodDelete, "/api/devices/tablet")
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || rec.Code != http.StatusAccepted || change.RequestedBy != DefaultAccount {
+		t.Fatalf("DELETE with a token = %d %+v (%v), want a pending change by the token's creator", rec.Code, change, err)
+	}
+	if rec := do("secret", http.MethodPost, "/api/approvals/"+change.ID+"/approve"); rec.Code != http.StatusForbidden {
+		t.Errorf("approval by the token's creator = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := do("other-secret", http.MethodPost, "/api/approvals/"+change.ID+"/approve"); rec.Code != http.StatusOK {
+		t.Errorf("approval = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+func TestApprovalsHoldReplacements(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	s.SetAccounts(map[string]string{"parent": "other-secret"})
+	reg := devices.New(zerolog.Nop())
+	s.SetDevices(reg, nil)
+	set := rules.New(zerolog.Nop())
+	s.SetRules(set)
+	s.SetApprovals(approval.New(memory.Open().PendingChanges(), time.Hour, zerolog.Nop()))
+
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	approve := func(rec *httptest.ResponseRecorder) {
+		t.Helper()
+		var change storage.PendingChange
+		if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || rec.Code != http.StatusAccepted {
+			t.Fatalf("PUT = %d (%v), want %d", rec.Code, err, http.StatusAccepted)
+		}
+		if rec := do("other-secret", http.MethodPost, "/api/approvals/"+change.ID+"/approve", ""); rec.Code != http.StatusOK {
+			t.Fatalf("approval = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+	}
+
+	// Creating a rule is applied at once, replacing it is held
+	if rec := do("secret", http.MethodPut, "/api/rules/games", `{"profile": "child", "domain": "games.example", "action": "block"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("PUT new rule = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if rec := do("secret", http.MethodPut, "/api/rules/games", `{"profile": "child", "domain": "games.example", "action": "nope"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT invalid rule = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec := do("secret", http.MethodPut, "/api/rules/games", `{"profile": "child", "domain": "games.example", "action": "allow"}`)
+	if rule, _ := set.Get("games"); rule.Action != rules.ActionBlock {
+		t.Errorf("rule action = %q before approval, want %q", rule.Action, rules.ActionBlock)
+	}
+	approve(rec)
+	if rule, _ := set.Get("games"); rule.Action != rules.ActionAllow {
+		t.Errorf("rule action = %q after approval, want %q", rule.Action, rules.ActionAllow)
+	}
+
+	// Likewise for devices
+	if rec := do("secret", http.MethodPut, "/api/devices/tv", `{"identifiers": ["192.168.1.40"], "profile": "child"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("PUT new device = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if rec := do("secret", http.MethodPut, "/api/devices/tv", `{"identifiers": ["192.168.1.40"], "profile": "teen"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT unknown profile = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = do("secret", http.MethodPut, "/api/devices/tv", `{"identifiers": ["192.168.1.40"], "profile": "adult"}`)
+	if device, _ := reg.Get("tv"); device.Profile != "child" {
+		t.Errorf("device profile = %q before approval, want child", device.Profile)
+	}
+	approve(rec)
+	if device, _ := reg.Get("tv"); device.Profile != "adult" {
+		t.Errorf("device profile = %q after approval, want adult", device.Profile)
+	}
+
+	// Every profile assignment is held
+	rec = do("secret", http.MethodPut, "/api/devices/tablet/profile", `{"profile": "adult"}`)
+	if _, ok := reg.Assigned("tablet"); ok {
+		t.Error("profile assigned before approval")
+	}
+	approve(rec)
+	if profile, _ := reg.Assigned("tablet"); profile != "adult" {
+		t.Errorf("assigned profile = %q after approval, want adult", profile)
+	}
+
+	// A rule set change is held when it drops a rule or detaches a profile
+	sets := rules.NewRuleSets(memory.Open().RuleSets(), zerolog.Nop())
+	s.SetRuleSets(sets)
+	body := `{"profiles": ["child"], "rules": [{"id": "tiktok", "domains": [".tiktok.com"], "action": "block"}]}`
+	if rec := do("secret", http.MethodPut, "/api/rulesets/social", body); rec.Code != http.StatusCreated {
+		t.Fatalf("PUT new rule set = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	added := `{"profiles": ["child", "teen"], "rules": [{"id": "tiktok", "domains": [".TikTok.com"], "action": "block"}, {"id": "snap", "domains": [".snapchat.com"], "action": "block"}]}`
+	if rec := do("secret", http.MethodPut, "/api/rulesets/social", added); rec.Code != http.StatusOK {
+		t.Errorf("PUT adding a rule and a profile = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	rec = do("secret", http.MethodPut, "/api/rulesets/social", `{"profiles": ["child", "teen"], "rules": []}`)
+	if set, _ := sets.Get("social"); len(set.Rules) != 2 {
+		t.Errorf("rule set rules = %+v before approval, want both kept", set.Rules)
+	}
+	approve(rec)
+	if set, _ := sets.Get("social"); len(set.Rules) != 0 {
+		t.Errorf("rule set rules = %+v after approval, want none", set.Rules)
+	}
+	rec = do("secret", http.MethodPut, "/api/rulesets/social/profiles", `{"profiles": []}`)
+	if set, _ := sets.Get("social"); len(set.Profiles) != 2 {
+		t.Errorf("rule set profiles = %v before approval, want both kept", set.Profiles)
+	}
+	approve(rec)
+	if set, _ := sets.Get("social"); len(set.Profiles) != 0 {
+		t.Errorf("rule set profiles = %v after approval, want none", set.Profiles)
+	}
+	if rec := do("secret", http.MethodPut, "/api/rulesets/social/profiles", `{"profiles": ["adult"]}`); rec.Code != http.StatusOK {
+		t.Errorf("PUT attaching a profile = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestBlockPages(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	s.SetBlockPages(blockpage.New(memory.Open().BlockPages(), zerolog.Nop()))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"put", http.MethodPut, "/api/blockpages/family", `{"template": "<h1>{{.Reason}}</h1>"}`, http.StatusCreated},
+		{"put again", http.MethodPut, "/api/blockpages/family", `{"template": "<h1>Blocked: {{.Host}}</h1>"}`, http.StatusOK},
+		{"put invalid template", http.MethodPut, "/api/blockpages/broken", `{"template": "{{.Nope}}"}`, http.StatusBadRequest},
+		{"put invalid name", http.MethodPut, "/api/blockpages/Family", `{"template": "<h1>Blocked</h1>"}`, http.StatusBadRequest},
+		{"get", http.MethodGet, "/api/blockpages/family", "", http.StatusOK},
+		{"get unknown", http.MethodGet, "/api/blockpages/broken", "", http.StatusNotFound},
+		{"delete", http.MethodDelete, "/api/blockpages/family", "", http.StatusOK},
+		{"delete again", http.MethodDelete, "/api/blockpages/family", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(tt.method, tt.path, tt.body); rec.Code != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	rec := do(http.MethodGet, "/api/blockpages", "")
+	var list []storage.BlockPage
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 0 {
+		t.Errorf("GET = %+v (%v), want no block pages", list, err)
+	}
+}
+
+func TestDHCPLeases(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	store := memory.Open()
+	s.SetDHCP(store.DHCPLeases(), store.DHCPReservations())
+
+	ctx := t.Context()
+	expires := time.Now().Add(time.Hour)
+	_ = store.DHCPLeases().Create(ctx, &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:02", IP: "192.168.1.110", Hostname: "laptop", ExpiresAt: expires})
+	_ = store.DHCPLeases().Create(ctx, &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.101", Hostname: "tablet", ExpiresAt: expires})
+	_ = store.DHCPLeases().Create(ctx, &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:03", IP: "192.168.1.102", ExpiresAt: time.Now().Add(-time.Minute)})
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/api/dhcp/leases")
+	var leases []LeaseInfo
+	if err := json.NewDecoder(rec.Body).Decode(&leases); err != nil || len(leases) != 2 {
+		t.Fatalf("GET = %+v (%v), want the two unexpired leases", leases, err)
+	}
+	if leases[0].IP != "192.168.1.101" || leases[1].IP != "192.168.1.110" {
+		t.Errorf("leases = %+v, want sorted by IP", leases)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"get", http.MethodGet, "/api/dhcp/leases/AA-BB-CC-DD-EE-01", http.StatusOK},
+		{"get expired", http.MethodGet, "/api/dhcp/leases/aa:bb:cc:dd:ee:03", http.StatusNotFound},
+		{"get invalid", http.MethodGet, "/api/dhcp/leases/tablet", http.StatusBadRequest},
+		{"reserve", http.MethodPost, "/api/dhcp/leases/aa:bb:cc:dd:ee:01/reservation", http.StatusOK},
+		{"reserve expired", http.MethodPost, "/api/dhcp/leases/aa:bb:cc:dd:ee:03/reservation", http.StatusNotFound},
+		{"release", http.MethodDelete, "/api/dhcp/leases/aa:bb:cc:dd:ee:02", http.StatusOK},
+		{"release again", http.MethodDelete, "/api/dhcp/leases/aa:bb:cc:dd:ee:02", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := do(tt.method, tt.path); rec.Code != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	rec = do(http.MethodGet, "/api/dhcp/leases/aa:bb:cc:dd:ee:01")
+	var lease LeaseInfo
+	if err := json.NewDecoder(rec.Body).Decode(&lease); err != nil || !lease.Reserved {
+		t.Errorf("GET = %+v (%v), want the lease reserved", lease, err)
+	}
+
+	rec = do(http.MethodGet, "/api/dhcp/reservations")
+	var reservations []storage.DHCPReservation
+	if err := json.NewDecoder(rec.Body).Decode(&reservations); err != nil || len(reservations) != 1 ||
+		reservations[0].IP != "192.168.1.101" || reservations[0].Hostname != "tablet" {
+		t.Errorf("GET = %+v (%v), want the tablet's reservation", reservations, err)
+	}
+	if rec := do(http.MethodDelete, "/api/dhcp/reservations/aa:bb:cc:dd:ee:01"); rec.Code != http.StatusOK {
+		t.Errorf("unreserve = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodDelete, "/api/dhcp/reservations/aa:bb:cc:dd:ee:01"); rec.Code != http.StatusNotFound {
+		t.Errorf("unreserve again = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAccessRequests(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	set := rules.New(zerolog.Nop())
+	s.SetRules(set)
+	q := access.New(zerolog.Nop())
+	s.SetAccessRequests(q)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	homework, _, err := q.Submit(access.Request{Client: "192.168.1.20", Host: "games.example", Profile: "child", Reason: "homework"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := q.Submit(access.Request{Client: "192.168.1.21", Host: "video.example", Profile: "child"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := do(http.MethodGet, "/api/access-requests", "")
+	var list []access.Request
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 2 || list[0].Reason != "homework" {
+		t.Errorf("GET = %+v (%v), want both requests", list, err)
+	}
+
+	if rec := do(http.MethodPost, "/api/access-requests/"+homework.ID+"/approve", `{"duration": "soon"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST approve with invalid duration = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// Approving adds an allow rule that expires
+	rec = do(http.MethodPost, "/api/access-requests/"+homework.ID+"/approve", `{"duration": "30m"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST approve = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var rule rules.Rule
+	if err := json.NewDecoder(rec.Body).Decode(&rule); err != nil || rule.Profile != "child" || rule.Domain != "games.example" || rule.Action != rules.ActionAllow {
+		t.Fatalf("POST approve = %+v (%v), want an allow rule for games.example", rule, err)
+	}
+	if rule.Until == nil || rule.Until.After(time.Now().Add(30*time.Minute)) {
+		t.Errorf("rule expiry = %v, want within 30 minutes", rule.Until)
+	}
+	if rec := do(http.MethodPost, "/api/access-requests/"+homework.ID+"/approve", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("POST approve again = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// Denying drops the request without a rule
+	if rec := do(http.MethodDelete, "/api/access-requests/"+other.ID, ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if len(q.List()) != 0 || len(set.List()) != 1 {
+		t.Errorf("after answering: requests %+v, rules %+v", q.List(), set.List())
+	}
+}
+
+// fakeProbes records the period asked for
+type fakeProbes struct {
+	since *time.Time
+}
+
+func (f fakeProbes) History(since time.Time) probe.History {
+	*f.since = since
+	return probe.History{Since: since, Summaries: []probe.Summary{{Target: "http://connectivity.example/", Probes: 60, Failures: 3, Uptime: 95}}}
+}
+
+func TestProbes(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("/api/probes"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without probes = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	var since time.Time
+	s.SetProbes(fakeProbes{since: &since})
+
+	rec := do("/api/probes?since=6h")
+	var h probe.History
+	if err := json.NewDecoder(rec.Body).Decode(&h); err != nil || len(h.Summaries) != 1 || h.Summaries[0].Uptime != 95 {
+		t.Errorf("GET = %+v (%v), want the probe history", h, err)
+	}
+	if ago := time.Since(since); ago < 6*time.Hour || ago > 6*time.Hour+time.Minute {
+		t.Errorf("history since %v ago, want 6h", ago)
+	}
+	if rec := do("/api/probes?since=yesterday"); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET with invalid since = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// fakeCanary reports one divergence
+type fakeCanary struct{}
+
+func (fakeCanary) Report() policy.CanaryReport {
+	return policy.CanaryReport{
+		Evaluated: 10,
+		Diverged:  2,
+		Divergences: []policy.Divergence{
+			{Kind: "proxy", ActiveAction: "BLOCK", CandidateAction: "ALLOW", CandidateRule: "allow-games", Count: 2},
+		},
+	}
+}
+
+func TestCanary(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/canary", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without candidate = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	s.SetCanary(fakeCanary{})
+	var report policy.CanaryReport
+	if err := json.NewDecoder(do().Body).Decode(&report); err != nil || report.Diverged != 2 || len(report.Divergences) != 1 || report.Divergences[0].CandidateRule != "allow-games" {
+		t.Errorf("GET = %+v (%v), want the canary report", report, err)
+	}
+}
+
+func TestClientCerts(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/api/client-certs"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without cache = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	certs := clientcert.New(time.Hour, zerolog.Nop())
+	certs.Add("vpn.example.com", "192.168.1.20")
+	s.SetClientCerts(certs)
+
+	var hosts []clientcert.Host
+	if err := json.NewDecoder(do(http.MethodGet, "/api/client-certs").Body).Decode(&hosts); err != nil || len(hosts) != 1 || hosts[0].Host != "vpn.example.com" {
+		t.Errorf("GET = %+v (%v), want vpn.example.com", hosts, err)
+	}
+
+	// Removing a host has it intercepted again
+	if rec := do(http.MethodDelete, "/api/client-certs/vpn.example.com"); rec.Code != http.StatusOK {
+		t.Errorf("DELETE = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if certs.Contains("vpn.example.com") {
+		t.Error("host still bypassed after DELETE")
+	}
+	if rec := do(http.MethodDelete, "/api/client-certs/vpn.example.com"); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE again = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	s.SetAccounts(map[string]string{"parent": "other-secret"})
+	s.SetRules(rules.New(zerolog.Nop()))
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	list := func(query string) []storage.AuditEntry {
+		t.Helper()
+		var entries []storage.AuditEntry
+		if err := json.NewDecoder(do(http.MethodGet, "/api/audit"+query, "secret", "").Body).Decode(&entries); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return entries
+	}
+
+	if rec := do(http.MethodGet, "/api/audit", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without log = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	s.SetAuditLog(memory.Open().AuditLog(), time.Hour)
+
+	do(http.MethodPut, "/api/rules/kids-tiktok", "secret", `{"profile": "kids", "domain": "tiktok.com", "action": "block"}`)
+	do(http.MethodPut, "/api/rules/kids-tiktok", "other-secret", `{"profile": "kids", "domain": "tiktok.com", "action": "allow"}`)
+	do(http.MethodPut, "/api/rules/kids-tiktok", "other-secret", `{"profile": "kids", "domain": "tiktok.com", "action": "maybe"}`)
+	do(http.MethodDelete, "/api/rules/kids-tiktok", "secret", "")
+
+	// Failed changes and reads aren't recorded
+	entries := list("")
+	if len(entries) != 3 {
+		t.Fatalf("GET /api/audit = %d entries, want 3", len(entries))
+	}
+	for i, want := range []string{"admin DELETE", "parent PUT", "admin PUT"} {
+		if got := entries[i].Account + " " + entries[i].Method; got != want || entries[i].Path != "/api/rules/kids-tiktok" {
+			t.Errorf("entry %d = %s %s, want %s", i, got, entries[i].Path, want)
+		}
+	}
+
+	// Who changed the rule, and how
+	changed := entries[1]
+	if len(changed.Changes) != 1 || changed.Changes[0].Field != "action" ||
+		string(changed.Changes[0].Before) != `"block"` || string(changed.Changes[0].After) != `"allow"` {
+		t.Errorf("changes = %+v, want action from block to allow", changed.Changes)
+	}
+	if !strings.Contains(string(changed.Request), `"allow"`) {
+		t.Errorf("request = %s, want the request body", changed.Request)
+	}
+	if created := entries[2]; created.Before != nil || !strings.Contains(string(created.After), "tiktok.com") {
+		t.Errorf("create = %s -> %s, want only the created rule", created.Before, created.After)
+	}
+	if removed := entries[0]; removed.After != nil || !strings.Contains(string(removed.Before), `"allow"`) {
+		t.Errorf("delete = %s -> %s, want only the removed rule", removed.Before, removed.After)
+	}
+
+	// Changes without a GET route keep the response
+	do(http.MethodPost, "/api/rules", "secret", `{"profile": "kids", "domain": ".roblox.com", "action": "allow"}`)
+	if added := list("?limit=1")[0]; !strings.Contains(string(added.After), `"id"`) {
+		t.Errorf("POST after = %s, want the created rule", added.After)
+	}
+
+	if got := list("?account=parent"); len(got) != 1 || got[0].ID != changed.ID {
+		t.Errorf("GET ?account=parent = %+v, want the parent's change", got)
+	}
+	if got := list("?path=roblox"); len(got) != 0 {
+		t.Errorf("GET ?path=roblox = %+v, want none (POST went to /api/rules)", got)
+	}
+	var entry storage.AuditEntry
+	if rec := do(http.MethodGet, "/api/audit/"+changed.ID, "secret", ""); rec.Code != http.StatusOK ||
+		json.NewDecoder(rec.Body).Decode(&entry) != nil || entry.Account != "parent" {
+		t.Errorf("GET /api/audit/%s = %d %+v, want the entry", changed.ID, rec.Code, entry)
+	}
+	if rec := do(http.MethodGet, "/api/audit/missing", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET missing entry = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	changes := diffJSON(
+		[]byte(`{"a": 1, "b": {"c": "x", "d": [1, 2]}, "gone": true}`),
+		[]byte(`{"a": 1, "b": {"c": "y", "d": [1,2]}, "new": null}`),
+	)
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.Field+":"+string(c.Before)+">"+string(c.After))
+	}
+	want := []string{`b.c:"x">"y"`, `gone:true>`, `new:>null`}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("diffJSON = %v, want %v", got, want)
+	}
+}
+
+func TestIssuedCerts(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("/api/ca/issued"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without log = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	ctx := context.Background()
+	issued := memory.Open().IssuedCerts()
+	now := time.Now()
+	for _, cert := range []storage.IssuedCert{
+		{Serial: "a1", Host: "www.example.com", Fingerprint: "f1", Client: "192.168.1.20", Issued: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+		{Serial: "b2", Host: "mail.example.org", Fingerprint: "f2", Client: "192.168.1.21", Issued: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{Serial: "c3", Host: "api.example.com", Fingerprint: "f3", Client: "192.168.1.20", Issued: now, ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := issued.Add(ctx, cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.SetIssuedCerts(issued)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"c3", "b2", "a1"}},
+		{"?host=Example.com", []string{"c3", "a1"}},
+		{"?client=192.168.1.21", []string{"b2"}},
+		{"?serial=A1", []string{"a1"}},
+		{"?serial=f3", []string{"c3"}},
+		{"?since=1h", []string{"c3", "b2"}},
+		{"?limit=1", []string{"c3"}},
+	}
+	for _, tt := range tests {
+		var certs []storage.IssuedCert
+		if err := json.NewDecoder(do("/api/ca/issued" + tt.query).Body).Decode(&certs); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var got []string
+		for _, cert := range certs {
+			got = append(got, cert.Serial)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("GET %s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	if rec := do("/api/ca/issued?limit=0"); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET with limit=0 = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// fakeRotator records CA rotations
+type fakeRotator struct {
+	root  bool
+	grace time.Duration
+	err   error
+}
+
+func (f *fakeRotator) Rotate(newRoot bool, grace time.Duration) (*ca.Rotation, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.root, f.grace = newRoot, grace
+	return &ca.Rotation{Root: newRoot, Backup: "20261015T120000Z"}, nil
+}
+
+func TestCARotate(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	do := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/ca/rotate", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(""); rec.Code != http.StatusNotFound {
+		t.Errorf("POST without CA = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rotator := &fakeRotator{}
+	s.SetCA(rotator)
+	tests := []struct {
+		body      string
+		wantCode  int
+		wantRoot  bool
+		wantGrace time.Duration
+	}{
+		{"", http.StatusOK, false, ca.DefaultGrace},
+		{`{"root":true,"grace":"24h"}`, http.StatusOK, true, 24 * time.Hour},
+		{`{"root":true,"grace":"0s"}`, http.StatusOK, true, 0},
+		{`{"grace":"soon"}`, http.StatusBadRequest, false, 0},
+	}
+	for _, tt := range tests {
+		*rotator = fakeRotator{}
+		rec := do(tt.body)
+		if rec.Code != tt.wantCode {
+			t.Errorf("POST %s = %d, want %d", tt.body, rec.Code, tt.wantCode)
+			continue
+		}
+		if rotator.root != tt.wantRoot || rotator.grace != tt.wantGrace {
+			t.Errorf("POST %s rotated root=%v grace=%v, want root=%v grace=%v", tt.body, rotator.root, rotator.grace, tt.wantRoot, tt.wantGrace)
+		}
+	}
+
+	rotator.err = ca.ErrExternalPKI
+	if rec := do(""); rec.Code != http.StatusConflict {
+		t.Errorf("POST with external PKI = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+// fakeReloader counts policy reloads
+type fakeReloader struct {
+	reloads int
+}
+
+func (r *fakeReloader) Reload() error {
+	r.reloads++
+	return nil
+}
+
+// testPolicyDir returns a directory holding the starter policies
+func testPolicyDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, name := range []string{"config.rego", "helpers.rego", "device.rego", "dns.rego", "proxy.rego", "schedule.rego"} {
+		data, err := policies.FS.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestPolicies(t *testing.T) {
+	dir := testPolicyDir(t)
+	reloader := &fakeReloader{}
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	s.SetPolicies(policyedit.New(dir, reloader, zerolog.Nop()))
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/api/policies", nil)
+	var files []policyedit.File
+	if err := json.NewDecoder(rec.Body).Decode(&files); err != nil || len(files) != 6 {
+		t.Fatalf("GET = %+v (%v), want 6 files", files, err)
+	}
+
+	rec = do(http.MethodGet, "/api/policies/config.rego", nil)
+	var file policyedit.File
+	if err := json.NewDecoder(rec.Body).Decode(&file); err != nil || file.Content == "" {
+		t.Fatalf("GET config.rego = %+v (%v), want its content", file, err)
+	}
+
+	changed := file.Content + "\nextra_bypass := [\"example.com\"]\n"
+	rec = do(http.MethodPut, "/api/policies/config.rego", PolicyRequest{Content: changed, DryRun: true})
+	var result policyedit.Result
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || rec.Code != http.StatusOK || result.Saved || result.Diff == "" {
+		t.Errorf("PUT dry run = %d %+v (%v), want an unsaved diff", rec.Code, result, err)
+	}
+
+	if rec := do(http.MethodPut, "/api/policies/config.rego", PolicyRequest{Content: changed + "broken := {"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT broken = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(http.MethodPut, "/api/policies/config.rego", PolicyRequest{Content: changed}); rec.Code != http.StatusOK || reloader.reloads != 1 {
+		t.Errorf("PUT = %d with %d reloads, want %d with one reload: %s", rec.Code, reloader.reloads, http.StatusOK, rec.Body)
+	}
+	if rec := do(http.MethodPut, "/api/policies/extra.rego", PolicyRequest{Content: "package kproxy.extra\n"}); rec.Code != http.StatusCreated {
+		t.Errorf("PUT new file = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if rec := do(http.MethodGet, "/api/policies/missing.rego", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET missing = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestPolicyApproval(t *testing.T) {
+	dir := testPolicyDir(t)
+	reloader := &fakeReloader{}
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	s.SetAccounts(map[string]string{"parent": "other-secret"})
+	s.SetPolicies(policyedit.New(dir, reloader, zerolog.Nop()))
+	s.SetApprovals(approval.New(memory.Open().PendingChanges(), time.Hour, zerolog.Nop()))
+
+	do := func(token, method, path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(data))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	original, err := os.ReadFile(filepath.Join(dir, "config.rego"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := string(original) + "\nextra_bypass := [\"example.com\"]\n"
+
+	// Changes that don't compile are refused at once, and dry runs answered
+	if rec := do("secret", http.MethodPut, "/api/policies/config.rego", PolicyRequest{Content: changed + "broken := {"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT broken = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do("secret", http.MethodPut, "/api/policies/config.rego", PolicyRequest{Content: changed, DryRun: true}); rec.Code != http.StatusOK {
+		t.Errorf("PUT dry run = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// Saving is held until a second account approves it
+	rec := do("secret", http.MethodPut, "/api/policies/config.rego", PolicyRequest{Content: changed})
+	var change storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || rec.Code != http.StatusAccepted || change.Action != ActionPolicyWrite || change.Target != "config.rego" {
+		t.Fatalf("PUT = %d %+v (%v), want a pending policy write", rec.Code, change, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "config.rego")); string(data) != string(original) || reloader.reloads != 0 {
+		t.Fatal("policy saved before approval")
+	}
+
+	if rec := do("secret", http.MethodPost, "/api/approvals/"+change.ID+"/approve", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("self approval = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := do("other-secret", http.MethodPost, "/api/approvals/"+change.ID+"/approve", nil); rec.Code != http.StatusOK {
+		t.Errorf("approval = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "config.rego")); string(data) != changed || reloader.reloads != 1 {
+		t.Errorf("policy not saved and reloaded after approval (%d reloads)", reloader.reloads)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	dir := testPolicyDir(t)
+	reloader := &fakeReloader{}
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	s.SetPolicies(policyedit.New(dir, reloader, zerolog.Nop()))
+	ruleSet := rules.New(zerolog.Nop())
+	s.SetRules(ruleSet)
+	deviceRules := rules.NewDeviceSet(memory.Open().DeviceRules(), zerolog.Nop())
+	s.SetDeviceRules(deviceRules)
+	reg := devices.New(zerolog.Nop())
+	s.SetDevices(reg, nil)
+	s.SetRuleSets(rules.NewRuleSets(memory.Open().RuleSets(), zerolog.Nop()))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	doc := `version: 1
+policy:
+  profiles:
+    child:
+      name: Child
+      rules: []
+      time_restrictions: {}
+      usage_limits: {}
+      default_action: allow
+  bypass_domains: [bank.example.com]
+rules:
+  - {id: runtime-1, profile: child, domain: minecraft.net, action: allow}
+  - {id: runtime-2, profile: child, domain: old.example.com, action: allow, until: "2020-01-01T00:00:00Z"}
+device_rules:
+  - {id: device-1, device: tv, domain: youtube.com, action: block}
+devices:
+  - {id: tv, name: Living room TV, identifiers: [192.168.1.40], profile: child}
+rule_sets:
+  - {id: games, name: Games, profiles: [child], rules: [{id: roblox, domains: [.roblox.com], action: allow}]}
+`
+
+	var result ImportResult
+	rec := do(http.MethodPost, "/api/import?dry_run=true", doc)
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || rec.Code != http.StatusOK || len(result.Policy) == 0 || result.Rules != 2 {
+		t.Fatalf("POST dry run = %d %+v (%v), want the changes", rec.Code, result, err)
+	}
+	if len(ruleSet.List()) != 0 || reloader.reloads != 0 {
+		t.Errorf("dry run changed %d rules with %d reloads, want nothing", len(ruleSet.List()), reloader.reloads)
+	}
+	if rec := do(http.MethodPost, "/api/import", strings.Replace(doc, "profile: child, domain: minecraft.net", "profile: teen, domain: minecraft.net", 1)); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST with an unknown profile = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(http.MethodPost, "/api/import", "version: 2\n"); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST version 2 = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = do(http.MethodPost, "/api/import", doc)
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || rec.Code != http.StatusOK || reloader.reloads != 1 {
+		t.Fatalf("POST = %d %+v (%v) with %d reloads, want one reload", rec.Code, result, err, reloader.reloads)
+	}
+	if result.Rules != 1 || result.DeviceRules != 1 || result.Devices != 1 || result.RuleSets != 1 {
+		t.Errorf("result = %+v, want one of each without the expired rule", result)
+	}
+
+	rec = do(http.MethodGet, "/api/export?format=yaml", "")
+	export, err := ParseExport(rec.Body.Bytes())
+	if err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET yaml = %d (%v)", rec.Code, err)
+	}
+	profiles, _ := export.Policy["profiles"].(map[string]interface{})
+	if _, ok := profiles["child"]; !ok || len(export.Rules) != 1 || len(export.DeviceRules) != 1 || len(export.Devices) != 1 || len(export.RuleSets) != 1 {
+		t.Errorf("export = %+v, want what was imported", export)
+	}
+
+	// Importing an export again changes nothing
+	data, _ := json.Marshal(export)
+	rec = do(http.MethodPost, "/api/import", string(data))
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || len(result.Policy) != 0 || result.DeviceRules != 0 {
+		t.Errorf("POST export = %+v (%v), want no policy changes and no new device rules", result, err)
+	}
+	if len(ruleSet.List()) != 1 || len(deviceRules.List("")) != 1 || reloader.reloads != 1 {
+		t.Errorf("after import again: %d rules, %d device rules, %d reloads, want 1, 1, 1", len(ruleSet.List()), len(deviceRules.List("")), reloader.reloads)
+	}
+	if rec := do(http.MethodGet, "/api/export?format=xml", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET xml = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(http.MethodPost, "/api/import", "version: 1\n#"+strings.Repeat("x", maxImportSize)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST too large = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// With approval, imports are checked and held until a second account approves
+	s.SetAccounts(map[string]string{"parent": "other-secret"})
+	s.SetApprovals(approval.New(memory.Open().PendingChanges(), time.Hour, zerolog.Nop()))
+	changed := strings.Replace(doc, "bank.example.com", "school.example.com", 1)
+	if rec := do(http.MethodPost, "/api/import", strings.Replace(changed, "profile: child, domain: minecraft.net", "profile: teen, domain: minecraft.net", 1)); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST held with an unknown profile = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = do(http.MethodPost, "/api/import", changed)
+	var change storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || rec.Code != http.StatusAccepted || change.Action != ActionImport {
+		t.Fatalf("POST = %d %+v (%v), want a pending import", rec.Code, change, err)
+	}
+	if reloader.reloads != 1 {
+		t.Fatal("import applied before approval")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/approvals/"+change.ID+"/approve", nil)
+	req.Header.Set("Authorization", "Bearer other-secret")
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || rec.Code != http.StatusOK || len(result.Policy) == 0 || reloader.reloads != 2 {
+		t.Errorf("approval = %d %+v (%v) with %d reloads, want the import applied", rec.Code, result, err, reloader.reloads)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	store := memory.Open()
+	audit := memory.Open().AuditLog()
+	s.SetAuditLog(audit, time.Hour)
+
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/api/system/backup", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without backups = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	s.SetBackups(backup.New(backup.Config{Store: store}, zerolog.Nop()))
+
+	if err := store.RuleSets().Put(context.Background(), storage.RuleSet{ID: "games", Name: "Games"}); err != nil {
+		t.Fatal(err)
+	}
+	rec := do(http.MethodGet, "/api/system/backup", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("GET = %d %s, want a gzip archive", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	archive := rec.Body.Bytes()
+	if rec := do(http.MethodGet, "/api/system/backup?ca=true", nil); rec.Code != http.StatusInternalServerError {
+		t.Errorf("GET with the CA when there is none = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+
+	if err := store.RuleSets().Delete(context.Background(), "games"); err != nil {
+		t.Fatal(err)
+	}
+	var result backup.Result
+	rec = do(http.MethodPost, "/api/system/restore?dry_run=true", archive)
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil || !result.DryRun || result.Records != 1 {
+		t.Errorf("POST dry run = %+v (%v), want one record", result, err)
+	}
+	if sets, _ := store.RuleSets().List(context.Background()); len(sets) != 0 {
+		t.Error("dry run restored the rule set")
+	}
+	if rec := do(http.MethodPost, "/api/system/restore", archive); rec.Code != http.StatusOK {
+		t.Fatalf("POST = %d: %s", rec.Code, rec.Body)
+	}
+	if sets, _ := store.RuleSets().List(context.Background()); len(sets) != 1 {
+		t.Error("restore didn't bring the rule set back")
+	}
+	if rec := do(http.MethodPost, "/api/system/restore?ca=true", archive); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST with the CA when the backup has none = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := do(http.MethodPost, "/api/system/restore", []byte("not a backup")); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST garbage = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// The archive isn't kept in the audit log
+	entries, err := audit.List(context.Background())
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("audit entries = %+v (%v), want the restores", entries, err)
+	}
+	for _, entry := range entries {
+		if entry.Request != nil {
+			t.Errorf("audit entry for %s kept the request body", entry.Path)
+		}
+	}
+
+	// With approval, a restore is checked and held, without answering the archive
+	s.SetAccounts(map[string]string{"parent": "other-secret"})
+	s.SetApprovals(approval.New(memory.Open().PendingChanges(), time.Hour, zerolog.Nop()))
+	if err := store.RuleSets().Delete(context.Background(), "games"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := do(http.MethodPost, "/api/system/restore", []byte("not a backup")); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST garbage held = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = do(http.MethodPost, "/api/system/restore", archive)
+	var change storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || rec.Code != http.StatusAccepted || change.Action != ActionRestore || change.Params != nil {
+		t.Fatalf("POST = %d %+v (%v), want a pending restore without the archive", rec.Code, change, err)
+	}
+	if sets, _ := store.RuleSets().List(context.Background()); len(sets) != 0 {
+		t.Fatal("restored before approval")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/approvals", nil)
+	req.Header.Set("Authorization", "Bearer other-secret")
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	var pending []storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&pending); err != nil || len(pending) != 1 || pending[0].Params != nil {
+		t.Errorf("GET /api/approvals = %+v (%v), want the restore without the archive", pending, err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/api/approvals/"+change.ID+"/approve", nil)
+	req.Header.Set("Authorization", "Bearer other-secret")
+	rec = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("approval = %d: %s", rec.Code, rec.Body)
+	}
+	if sets, _ := store.RuleSets().List(context.Background()); len(sets) != 1 {
+		t.Error("approved restore didn't bring the rule set back")
+	}
+}
+
+func TestDecisions(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/decisions"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET without a decision log = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	ctx := context.Background()
+	store := memory.Open().Decisions()
+	now := time.Now()
+	_ = store.Put(ctx, storage.Decision{ID: "d1", Kind: "dns", Time: now.Add(-2 * time.Hour), ExpiresAt: now.Add(time.Hour), Input: []byte(`{"client_ip":"10.0.0.5","domain":"example.com"}`)})
+	_ = store.Put(ctx, storage.Decision{ID: "d2", Kind: "proxy", Time: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour), Input: []byte(`{"client_ip":"10.0.0.6","host":"example.com"}`)})
+	s.SetDecisions(store)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"d2", "d1"}},
+		{"?host=example.com&limit=1", []string{"d2"}},
+		{"?client=10.0.0.5", []string{"d1"}},
+		{"?since=1h", []string{"d2"}},
+		{"?kind=dns&until=" + url.QueryEscape(now.Add(-time.Hour).Format(time.RFC3339)), []string{"d1"}},
+	}
+	for _, tt := range tests {
+		rec := get("/api/decisions" + tt.query)
+		var decisions []storage.Decision
+		if err := json.NewDecoder(rec.Body).Decode(&decisions); err != nil {
+			t.Fatalf("GET %s: %v", tt.query, err)
+		}
+		var ids []string
+		for _, d := range decisions {
+			ids = append(ids, d.ID)
+		}
+		if !slices.Equal(ids, tt.want) {
+			t.Errorf("GET %s = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+	for _, query := range []string{"?kind=http", "?since=yesterday", "?limit=0"} {
+		if rec := get("/api/decisions" + query); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	if rec := get("/api/decisions/d1"); rec.Code != http.StatusOK {
+		t.Errorf("GET d1 = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := get("/api/decisions/missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET missing = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	s.SetAPITokens(apitoken.New(memory.Open().APITokens(), zerolog.Nop()))
+	audit := memory.Open().AuditLog()
+	s.SetAuditLog(audit, time.Hour)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/api/tokens", "secret", `{"name": "script", "scopes": ["rules:write"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST with an invalid scope = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec := do(http.MethodPost, "/api/tokens", "secret", `{"name": "home-assistant", "scopes": ["read", "maintenance"], "expires": "720h"}`)
+	var created APITokenResponse
+	if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&created) != nil {
+		t.Fatalf("POST /api/tokens = %d", rec.Code)
+	}
+	if !strings.HasPrefix(created.Token, apitoken.Prefix) || created.Hash != "" || created.CreatedBy != DefaultAccount || created.ExpiresAt == nil {
+		t.Errorf("created = %+v", created)
+	}
+
+	// The token works within its scopes only
+	if rec := do(http.MethodGet, "/api/maintenance", created.Token, ""); rec.Code != http.StatusOK {
+		t.Errorf("GET with read scope = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodPut, "/api/maintenance", created.Token, ""); rec.Code != http.StatusOK {
+		t.Errorf("PUT with maintenance scope = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodPut, "/api/features/dns", created.Token, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("PUT outside the scopes = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := do(http.MethodGet, "/api/tokens", created.Token, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("token listing tokens = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// Changes are recorded against the token, never with its secret
+	entries, _ := audit.List(context.Background())
+	if len(entries) != 2 || entries[0].Account != TokenAccountPrefix+"home-assistant" {
+		t.Fatalf("audit entries = %+v, want the token's change first", entries)
+	}
+	if strings.Contains(string(entries[1].After), created.Token) {
+		t.Errorf("audit entry for POST /api/tokens = %s, want the secret redacted", entries[1].After)
+	}
+
+	var tokens []storage.APIToken
+	if rec := do(http.MethodGet, "/api/tokens", "secret", ""); rec.Code != http.StatusOK ||
+		json.NewDecoder(rec.Body).Decode(&tokens) != nil || len(tokens) != 1 || tokens[0].Hash != "" {
+		t.Errorf("GET /api/tokens = %d %+v, want the token without its hash", rec.Code, tokens)
+	}
+	if rec := do(http.MethodDelete, "/api/tokens/"+created.ID, "secret", ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE /api/tokens = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := do(http.MethodDelete, "/api/tokens/"+created.ID, "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE again = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := do(http.MethodGet, "/api/maintenance", created.Token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET with a revoked token = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+	recorder := activity.NewRecorder()
+	s.SetActivity(recorder, nil)
+	server := httptest.NewServer(s.server.Handler)
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return resp
+	}
+
+	if resp := get("/api/events?types=dns,bogus"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET with an unknown type = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	resp := get("/api/events?types=block,device")
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /api/events = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// The stream is subscribed once the headers arrive
+	recorder.Publish(activity.Event{Type: activity.EventDNS, Host: "example.com"})
+	recorder.RecordBlock("dns", "192.168.1.10", "ads.example.com", "BLOCK")
+
+	lines := bufio.NewReader(resp.Body)
+	var got []string
+	for len(got) < 2 {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			got = append(got, line)
+		}
+	}
+	if got[0] != "event: block" || !strings.HasPrefix(got[1], "data: ") {
+		t.Fatalf("stream = %q, want the block only", got)
+	}
+	var event activity.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(got[1], "data: ")), &event); err != nil || event.Host != "ads.example.com" {
+		t.Errorf("event = %+v (%v), want the blocked host", event, err)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	s := NewServer("127.0.0.1:0", "secret", fakePolicy{}, maintenance.New("Back soon", time.Hour, zerolog.Nop()), zerolog.Nop())
+
+	// The description and the docs need no token
+	for _, path := range []string{"/api/openapi.json", "/api/docs"} {
+		rec := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, http.StatusOK)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rules", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/rules without a token = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("invalid openapi.json: %v", err)
+	}
+
+	// Every route is described; run go generate after adding one
+	source, err := os.ReadFile("server.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := regexp.MustCompile(`mux\.HandleFunc\("([A-Z]+) ([^"]+)"`).FindAllStringSubmatch(string(source), -1)
+	if len(routes) == 0 {
+		t.Fatal("no routes found in server.go")
+	}
+	described := 0
+	for _, route := range routes {
+		if _, ok := spec.Paths[route[2]][strings.ToLower(route[1])]; !ok {
+			t.Errorf("%s %s missing from openapi.json (run go generate ./internal/admin)", route[1], route[2])
+		}
+	}
+	for _, ops := range spec.Paths {
+		described += len(ops)
+	}
+	if described != len(routes) {
+		t.Errorf("openapi.json describes %d operations, server.go routes %d (run go generate ./internal/admin)", described, len(routes))
+	}
+}
@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/apitoken"
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// APITokenRequest is the JSON body for creating an API token
+type APITokenRequest struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`            // e.g. ["read", "maintenance"]
+	Expires string   `json:"expires,omitempty"` // e.g. "720h"; empty = until revoked
+}
+
+// APITokenResponse is a newly created API token with its secret, which is
+// shown only once
+type APITokenResponse struct {
+	storage.APIToken
+	Token string `json:"token"`
+}
+
+// SetAPITokens sets the API tokens accepted alongside admin accounts and
+// managed through /api/tokens
+func (s *Server) SetAPITokens(t *apitoken.Set) {
+	s.apiTokens = t
+}
+
+// handleAPITokens lists the API tokens, without their secrets
+func (s *Server) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	if s.apiTokens == nil {
+		writeError(w, http.StatusNotFound, "API tokens not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.apiTokens.List())
+}
+
+// handleAPITokenCreate creates an API token, returning its secret
+func (s *Server) handleAPITokenCreate(w http.ResponseWriter, r *http.Request) {
+	if s.apiTokens == nil {
+		writeError(w, http.StatusNotFound, "API tokens not configured")
+		return
+	}
+
+	var req APITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	var ttl time.Duration
+	if req.Expires != "" {
+		d, err := time.ParseDuration(req.Expires)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid expires: "+req.Expires)
+			return
+		}
+		ttl = d
+	}
+
+	secret, token, err := s.apiTokens.Create(r.Context(), req.Name, req.Scopes, account(r), ttl)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, APITokenResponse{APIToken: token, Token: secret})
+}
+
+// handleAPITokenRevoke revokes an API token
+func (s *Server) handleAPITokenRevoke(w http.ResponseWriter, r *http.Request) {
+	if s.apiTokens == nil {
+		writeError(w, http.StatusNotFound, "API tokens not configured")
+		return
+	}
+
+	id := r.PathValue("id")
+	err := s.apiTokens.Revoke(r.Context(), id)
+	if errors.Is(err, apitoken.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "token not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Str("id", id).Msg("Revoking API token failed")
+		writeError(w, http.StatusInternalServerError, "revoke failed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"revoked": id})
+}
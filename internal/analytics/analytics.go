@@ -0,0 +1,124 @@
+// Package analytics keeps hourly summaries of policy decisions and exports
+// them nightly to a warehouse target for long-term trend analysis.
+package analytics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Summary counts the decisions of one kind for a device in one hour
+type Summary struct {
+	Hour     time.Time // Start of the hour, UTC
+	Source   string    // "dns" or "proxy"
+	Device   string    // Client IP, as in metrics
+	Category string
+	Action   string
+	Count    int
+}
+
+// key groups decisions into summaries
+type key struct {
+	hour     int64 // Unix seconds of the start of the hour
+	source   string
+	device   string
+	category string
+	action   string
+}
+
+// Collector counts decisions by device, category and hour until they are
+// exported. Counts are kept in memory; a nil *Collector counts nothing.
+type Collector struct {
+	mu     sync.Mutex
+	counts map[key]int
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewCollector creates an empty collector
+func NewCollector() *Collector {
+	return &Collector{
+		counts: make(map[key]int),
+		now:    time.Now,
+	}
+}
+
+// Record counts one decision
+func (c *Collector) Record(source, device, category, action string) {
+	if c == nil {
+		return
+	}
+
+	k := key{
+		hour:     c.now().UTC().Truncate(time.Hour).Unix(),
+		source:   source,
+		device:   device,
+		category: category,
+		action:   action,
+	}
+
+	c.mu.Lock()
+	c.counts[k]++
+	c.mu.Unlock()
+}
+
+// Drain removes and returns the summaries of hours that ended by before,
+// ordered by hour, source, device, category and action
+func (c *Collector) Drain(before time.Time) []Summary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var summaries []Summary
+	for k, count := range c.counts {
+		hour := time.Unix(k.hour, 0).UTC()
+		if hour.Add(time.Hour).After(before) {
+			continue
+		}
+		summaries = append(summaries, Summary{
+			Hour:     hour,
+			Source:   k.source,
+			Device:   k.device,
+			Category: k.category,
+			Action:   k.action,
+			Count:    count,
+		})
+		delete(c.counts, k)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if !a.Hour.Equal(b.Hour) {
+			return a.Hour.Before(b.Hour)
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Device != b.Device {
+			return a.Device < b.Device
+		}
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		return a.Action < b.Action
+	})
+	return summaries
+}
+
+// Restore adds summaries back, so a failed export is retried next time
+func (c *Collector) Restore(summaries []Summary) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range summaries {
+		k := key{
+			hour:     s.Hour.Unix(),
+			source:   s.Source,
+			device:   s.Device,
+			category: s.Category,
+			action:   s.Action,
+		}
+		c.counts[k] += s.Count
+	}
+}
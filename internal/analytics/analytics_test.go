@@ -0,0 +1,49 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCollectorDrain(t *testing.T) {
+	c := NewCollector()
+	now := time.Date(2026, 3, 2, 9, 15, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	c.Record("proxy", "192.168.1.20", "news", "ALLOW")
+	c.Record("proxy", "192.168.1.20", "news", "ALLOW")
+	c.Record("dns", "192.168.1.20", "", "BLOCK")
+	now = now.Add(time.Hour)
+	c.Record("proxy", "192.168.1.21", "games", "BLOCK")
+
+	// 10:30: only the 09:00 hour is complete
+	got := c.Drain(time.Date(2026, 3, 2, 10, 30, 0, 0, time.UTC))
+	if len(got) != 2 {
+		t.Fatalf("Drain() = %v, want the two 09:00 summaries", got)
+	}
+	if got[0].Source != "dns" || got[0].Count != 1 {
+		t.Errorf("first summary = %+v, want the dns block", got[0])
+	}
+	if got[1].Source != "proxy" || got[1].Category != "news" || got[1].Count != 2 {
+		t.Errorf("second summary = %+v, want 2 proxy news allows", got[1])
+	}
+	if !got[1].Hour.Equal(time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Hour = %v, want 09:00", got[1].Hour)
+	}
+
+	if again := c.Drain(time.Date(2026, 3, 2, 10, 30, 0, 0, time.UTC)); len(again) != 0 {
+		t.Errorf("second Drain() = %v, want nothing", again)
+	}
+
+	// Restored summaries are drained again, added to new counts
+	c.Restore(got)
+	c.now = func() time.Time { return time.Date(2026, 3, 2, 9, 59, 0, 0, time.UTC) }
+	c.Record("proxy", "192.168.1.20", "news", "ALLOW")
+	got = c.Drain(time.Date(2026, 3, 2, 11, 0, 0, 0, time.UTC))
+	if len(got) != 3 || got[1].Count != 3 {
+		t.Errorf("Drain() after Restore() = %+v, want 3 summaries with 3 news allows", got)
+	}
+
+	var none *Collector
+	none.Record("proxy", "192.168.1.20", "news", "ALLOW")
+}
@@ -0,0 +1,150 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/spool"
+	"github.com/rs/zerolog"
+)
+
+// exportTimeout bounds one export
+const exportTimeout = 2 * time.Minute
+
+// Exporter sends the summaries of completed hours to a target every night,
+// and once more on Stop so a restart loses at most the hour in progress
+type Exporter struct {
+	collector  *Collector
+	target     Target
+	exportTime time.Time // Time of day to export (only hour and minute are used)
+	logger     zerolog.Logger
+	stopChan   chan struct{}
+
+	// Exports the target failed to take, kept on disk until it is back
+	// (optional; without it they are kept in memory for the next export)
+	spool *spool.Spool
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewExporter creates an exporter running daily at exportTime (HH:MM)
+func NewExporter(collector *Collector, target Target, exportTime string, logger zerolog.Logger) (*Exporter, error) {
+	parsedTime, err := time.Parse("15:04", exportTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Exporter{
+		collector:  collector,
+		target:     target,
+		exportTime: parsedTime,
+		logger:     logger.With().Str("component", "analytics-exporter").Logger(),
+		stopChan:   make(chan struct{}),
+		now:        time.Now,
+	}, nil
+}
+
+// Start begins the nightly exports
+func (e *Exporter) Start() {
+	go e.run()
+	e.logger.Info().
+		Str("target", e.target.Name()).
+		Str("export_time", e.exportTime.Format("15:04")).
+		Msg("Nightly decision analytics export scheduled")
+}
+
+// Stop stops the nightly exports and exports the completed hours
+func (e *Exporter) Stop() {
+	close(e.stopChan)
+	e.Export()
+}
+
+// run waits for each export time until stopped
+func (e *Exporter) run() {
+	for {
+		select {
+		case <-time.After(time.Until(e.nextExport())):
+			e.Export()
+		case <-e.stopChan:
+			return
+		}
+	}
+}
+
+// nextExport returns the next export time
+func (e *Exporter) nextExport() time.Time {
+	now := e.now()
+	next := time.Date(now.Year(), now.Month(), now.Day(), e.exportTime.Hour(), e.exportTime.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// SetSpool sets the spool keeping exports the target fails to take
+func (e *Exporter) SetSpool(s *spool.Spool) {
+	e.spool = s
+}
+
+// spooledExport is an export kept in the spool
+type spooledExport struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Summaries []Summary `json:"summaries"`
+}
+
+// Resend sends a spooled export to the target
+func (e *Exporter) Resend(record []byte) error {
+	var export spooledExport
+	if err := json.Unmarshal(record, &export); err != nil {
+		// Can never be sent; log and drop it rather than block the spool
+		e.logger.Error().Err(err).Msg("Dropping unreadable spooled export")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	return e.target.Write(ctx, export.From, export.To, export.Summaries)
+}
+
+// Export sends the summaries of every completed hour to the target. On
+// failure they are spooled, or kept in memory for the next export.
+func (e *Exporter) Export() {
+	summaries := e.collector.Drain(e.now())
+	if len(summaries) == 0 {
+		return
+	}
+	from := summaries[0].Hour
+	to := summaries[len(summaries)-1].Hour.Add(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	if err := e.target.Write(ctx, from, to, summaries); err != nil {
+		if e.spool != nil {
+			record, jsonErr := json.Marshal(spooledExport{From: from, To: to, Summaries: summaries})
+			if jsonErr == nil && e.spool.Add(record) == nil {
+				e.logger.Error().Err(err).
+					Str("target", e.target.Name()).
+					Int("rows", len(summaries)).
+					Msg("Failed to export decision analytics, spooled until the target is back")
+				return
+			}
+		}
+		e.collector.Restore(summaries)
+		e.logger.Error().Err(err).
+			Str("target", e.target.Name()).
+			Int("rows", len(summaries)).
+			Msg("Failed to export decision analytics, will retry at the next export")
+		return
+	}
+
+	e.logger.Info().
+		Str("target", e.target.Name()).
+		Time("from", from).
+		Time("to", to).
+		Int("rows", len(summaries)).
+		Msg("Exported decision analytics")
+}
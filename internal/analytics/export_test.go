@@ -0,0 +1,205 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/spool"
+	"github.com/rs/zerolog"
+)
+
+var (
+	hour      = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	summaries = []Summary{
+		{Hour: hour, Source: "dns", Device: "192.168.1.20", Action: "BLOCK", Count: 1},
+		{Hour: hour, Source: "proxy", Device: "192.168.1.20", Category: "news, local", Action: "ALLOW", Count: 2},
+	}
+)
+
+// fakeTarget records writes, failing while err is set
+type fakeTarget struct {
+	err    error
+	writes [][]Summary
+}
+
+func (f *fakeTarget) Name() string { return "fake" }
+
+func (f *fakeTarget) Write(ctx context.Context, from, to time.Time, summaries []Summary) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.writes = append(f.writes, summaries)
+	return nil
+}
+
+func TestExporter(t *testing.T) {
+	c := NewCollector()
+	c.now = func() time.Time { return hour.Add(10 * time.Minute) }
+	c.Record("proxy", "192.168.1.20", "news", "ALLOW")
+
+	target := &fakeTarget{err: errors.New("unreachable")}
+	e, err := NewExporter(c, target, "02:00", zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.now = func() time.Time { return hour.Add(2 * time.Hour) }
+
+	// A failed export is kept for the next one
+	e.Export()
+	target.err = nil
+	e.Export()
+	if len(target.writes) != 1 || len(target.writes[0]) != 1 || target.writes[0][0].Count != 1 {
+		t.Errorf("writes = %+v, want the summary once", target.writes)
+	}
+
+	e.Export()
+	if len(target.writes) != 1 {
+		t.Errorf("empty export wrote %+v", target.writes[1:])
+	}
+}
+
+func TestExporterSpool(t *testing.T) {
+	c := NewCollector()
+	c.now = func() time.Time { return hour.Add(10 * time.Minute) }
+	c.Record("proxy", "192.168.1.20", "news", "ALLOW")
+
+	target := &fakeTarget{err: errors.New("unreachable")}
+	e, err := NewExporter(c, target, "02:00", zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.now = func() time.Time { return hour.Add(2 * time.Hour) }
+	sp, err := spool.Open(t.TempDir(), "analytics", 1<<20, time.Minute, e.Resend, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetSpool(sp)
+
+	// A failed export goes to the spool rather than back to the collector
+	e.Export()
+	if left := c.Drain(hour.Add(2 * time.Hour)); len(left) != 0 {
+		t.Errorf("collector kept %+v, want the export spooled", left)
+	}
+
+	target.err = nil
+	if sent, err := sp.Replay(); err != nil || sent != 1 {
+		t.Fatalf("Replay() = %d (%v), want one export", sent, err)
+	}
+	if len(target.writes) != 1 || target.writes[0][0].Count != 1 || !target.writes[0][0].Hour.Equal(hour) {
+		t.Errorf("writes = %+v, want the spooled summary", target.writes)
+	}
+}
+
+func TestNextExport(t *testing.T) {
+	e, err := NewExporter(NewCollector(), &fakeTarget{}, "02:00", zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.now = func() time.Time { return time.Date(2026, 3, 2, 1, 0, 0, 0, time.UTC) }
+	if got := e.nextExport(); !got.Equal(time.Date(2026, 3, 2, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("nextExport() = %v, want today 02:00", got)
+	}
+	e.now = func() time.Time { return time.Date(2026, 3, 2, 2, 0, 0, 0, time.UTC) }
+	if got := e.nextExport(); !got.Equal(time.Date(2026, 3, 3, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("nextExport() = %v, want tomorrow 02:00", got)
+	}
+
+	if _, err := NewExporter(NewCollector(), &fakeTarget{}, "2am", zerolog.Nop()); err == nil {
+		t.Error("NewExporter() with a bad time succeeded")
+	}
+}
+
+func TestCSVTarget(t *testing.T) {
+	target := &CSVTarget{Dir: filepath.Join(t.TempDir(), "analytics")}
+	if err := target.Write(context.Background(), hour, hour.Add(time.Hour), summaries); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(target.Dir, "decisions-20260302T09-20260302T10.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "hour,source,device,category,action,count\n" +
+		"2026-03-02T09:00:00Z,dns,192.168.1.20,,BLOCK,1\n" +
+		"2026-03-02T09:00:00Z,proxy,192.168.1.20,\"news, local\",ALLOW,2\n"
+	if string(content) != want {
+		t.Errorf("CSV =\n%s\nwant\n%s", content, want)
+	}
+}
+
+func TestS3Target(t *testing.T) {
+	var path, auth, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		content, _ := io.ReadAll(r.Body)
+		body = string(content)
+	}))
+	defer srv.Close()
+
+	target := &S3Target{
+		Endpoint:        srv.URL,
+		Bucket:          "analytics",
+		Region:          "us-east-1",
+		Prefix:          "kproxy/",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+	}
+	if err := target.Write(context.Background(), hour, hour.Add(time.Hour), summaries); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if path != "/analytics/kproxy/decisions-20260302T09-20260302T10.csv" {
+		t.Errorf("path = %q", path)
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/us-east-1/s3/aws4_request") {
+		t.Errorf("Authorization = %q, want a SigV4 signature", auth)
+	}
+	if !strings.HasPrefix(body, "hour,source,device") {
+		t.Errorf("body = %q, want CSV", body)
+	}
+}
+
+func TestInfluxDBTarget(t *testing.T) {
+	var query, auth, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		auth = r.Header.Get("Authorization")
+		content, _ := io.ReadAll(r.Body)
+		body = string(content)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	target := &InfluxDBTarget{URL: srv.URL, Org: "home", Bucket: "kproxy", Token: "tok"}
+	if err := target.Write(context.Background(), hour, hour.Add(time.Hour), summaries); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if query != "bucket=kproxy&org=home&precision=s" || auth != "Token tok" {
+		t.Errorf("query = %q, Authorization = %q", query, auth)
+	}
+	want := "kproxy_decisions,action=BLOCK,device=192.168.1.20,source=dns count=1i 1772442000\n" +
+		"kproxy_decisions,action=ALLOW,category=news\\,\\ local,device=192.168.1.20,source=proxy count=2i 1772442000\n"
+	if body != want {
+		t.Errorf("body =\n%s\nwant\n%s", body, want)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bucket not found", http.StatusNotFound)
+	}))
+	defer failing.Close()
+	target.URL = failing.URL
+	if err := target.Write(context.Background(), hour, hour.Add(time.Hour), summaries); err == nil || !strings.Contains(err.Error(), "bucket not found") {
+		t.Errorf("Write() error = %v, want the server's error", err)
+	}
+}
@@ -0,0 +1,202 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Target receives exported summaries
+type Target interface {
+	// Name identifies the target in logs
+	Name() string
+
+	// Write stores the summaries of the hours from from up to to
+	Write(ctx context.Context, from, to time.Time, summaries []Summary) error
+}
+
+// csvHeader is the first row of CSV exports
+var csvHeader = []string{"hour", "source", "device", "category", "action", "count"}
+
+// encodeCSV writes summaries as CSV with a header row
+func encodeCSV(summaries []Summary) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(csvHeader); err != nil {
+		return nil, err
+	}
+	for _, s := range summaries {
+		record := []string{s.Hour.Format(time.RFC3339), s.Source, s.Device, s.Category, s.Action, strconv.Itoa(s.Count)}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// exportName names the file holding one export
+func exportName(from, to time.Time) string {
+	const layout = "20060102T15"
+	return fmt.Sprintf("decisions-%s-%s.csv", from.UTC().Format(layout), to.UTC().Format(layout))
+}
+
+// CSVTarget writes each export to a CSV file in a directory
+type CSVTarget struct {
+	Dir string
+}
+
+// Name identifies the target in logs
+func (t *CSVTarget) Name() string {
+	return "csv"
+}
+
+// Write stores the summaries as a new CSV file
+func (t *CSVTarget) Write(ctx context.Context, from, to time.Time, summaries []Summary) error {
+	content, err := encodeCSV(summaries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.Dir, 0750); err != nil {
+		return err
+	}
+
+	path := filepath.Join(t.Dir, exportName(from, to))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// S3Target uploads each export as a CSV object to an S3 bucket, or to any
+// S3-compatible store (MinIO, R2...) with Endpoint set
+type S3Target struct {
+	Endpoint        string // Defaults to https://s3.<region>.amazonaws.com
+	Bucket          string
+	Region          string
+	Prefix          string // Prepended to object names, e.g. "kproxy/"
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Client used for uploads (http.DefaultClient if nil)
+	Client *http.Client
+}
+
+// Name identifies the target in logs
+func (t *S3Target) Name() string {
+	return "s3"
+}
+
+// Write uploads the summaries as a new object
+func (t *S3Target) Write(ctx context.Context, from, to time.Time, summaries []Summary) error {
+	content, err := encodeCSV(summaries)
+	if err != nil {
+		return err
+	}
+
+	endpoint := t.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", t.Region)
+	}
+	object := t.Prefix + exportName(from, to)
+	target := strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(t.Bucket) + "/" + (&url.URL{Path: object}).EscapedPath()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/csv")
+
+	sum := sha256.Sum256(content)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	creds := aws.Credentials{AccessKeyID: t.AccessKeyID, SecretAccessKey: t.SecretAccessKey}
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, payloadHash, "s3", t.Region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	return doRequest(t.Client, req)
+}
+
+// InfluxDBTarget writes summaries as points through the InfluxDB v2 write
+// API. Each summary is a kproxy_decisions point with source, device,
+// category and action tags and a count field, at the start of its hour.
+type InfluxDBTarget struct {
+	URL    string // e.g. http://influxdb:8086
+	Org    string
+	Bucket string
+	Token  string
+
+	// Client used for writes (http.DefaultClient if nil)
+	Client *http.Client
+}
+
+// Name identifies the target in logs
+func (t *InfluxDBTarget) Name() string {
+	return "influxdb"
+}
+
+// Write sends the summaries as line protocol
+func (t *InfluxDBTarget) Write(ctx context.Context, from, to time.Time, summaries []Summary) error {
+	var buf bytes.Buffer
+	for _, s := range summaries {
+		buf.WriteString("kproxy_decisions")
+		for _, tag := range [][2]string{{"action", s.Action}, {"category", s.Category}, {"device", s.Device}, {"source", s.Source}} {
+			if tag[1] != "" {
+				fmt.Fprintf(&buf, ",%s=%s", tag[0], escapeTag(tag[1]))
+			}
+		}
+		fmt.Fprintf(&buf, " count=%di %d\n", s.Count, s.Hour.Unix())
+	}
+
+	query := url.Values{"org": {t.Org}, "bucket": {t.Bucket}, "precision": {"s"}}
+	target := strings.TrimSuffix(t.URL, "/") + "/api/v2/write?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Token "+t.Token)
+	}
+	return doRequest(t.Client, req)
+}
+
+// escapeTag escapes a line protocol tag value
+func escapeTag(value string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(value)
+}
+
+// doRequest sends a request, failing on any status other than 2xx
+func doRequest(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,264 @@
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+// Prefix starts every token secret, so leaked tokens are easy to recognise
+const Prefix = "kpt_"
+
+// ErrNotFound is returned for a token that doesn't exist
+var ErrNotFound = errors.New("token not found")
+
+// Set holds the API tokens created through the admin API (kproxy token
+// create), for scripts and integrations such as Home Assistant that
+// shouldn't use an admin account's token. A token is limited to its scopes:
+//
+//	"*"                 everything an admin account can do
+//	"read"              GET requests anywhere
+//	"<resource>"        anything under /api/<resource>, e.g. "maintenance"
+//	"<resource>:read"   GET requests under /api/<resource>
+//
+// Managing tokens, deciding approvals and taking or restoring backups
+// (which hold the CA key and token hashes) are left to admin accounts
+// whatever the scopes. Only a SHA-256 hash of each secret is stored; the set caches
+// the tokens so authenticating a request doesn't touch storage.
+type Set struct {
+	store  storage.APITokenStore
+	logger zerolog.Logger
+
+	mu     sync.Mutex
+	tokens []storage.APIToken // Oldest first
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates an empty token set backed by store. Call Load to read the
+// stored tokens.
+func New(store storage.APITokenStore, logger zerolog.Logger) *Set {
+	return &Set{
+		store:  store,
+		logger: logger.With().Str("component", "apitoken").Logger(),
+		now:    time.Now,
+	}
+}
+
+// Load reads the stored tokens, deleting those that expired
+func (s *Set) Load(ctx context.Context) error {
+	stored, err := s.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = s.tokens[:0]
+	for _, token := range stored {
+		if token.ExpiresAt != nil && !now.Before(*token.ExpiresAt) {
+			if err := s.store.Delete(ctx, token.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+			continue
+		}
+		s.tokens = append(s.tokens, token)
+	}
+	return nil
+}
+
+// Create creates a token named name with scopes, expiring after ttl (0
+// keeps it until revoked). It returns the secret, which can't be recovered
+// afterwards, and the token without its hash.
+func (s *Set) Create(ctx context.Context, name string, scopes []string, createdBy string, ttl time.Duration) (string, storage.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", storage.APIToken{}, fmt.Errorf("name is required")
+	}
+	if len(scopes) == 0 {
+		return "", storage.APIToken{}, fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if err := ValidateScope(scope); err != nil {
+			return "", storage.APIToken{}, err
+		}
+	}
+	if ttl < 0 {
+		return "", storage.APIToken{}, fmt.Errorf("expiry must be positive")
+	}
+
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", storage.APIToken{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", storage.APIToken{}, err
+	}
+	plain := Prefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	now := s.now()
+	token := storage.APIToken{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hash:      hash(plain),
+		Scopes:    append([]string{}, scopes...),
+		CreatedBy: createdBy,
+		Created:   now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		token.ExpiresAt = &expires
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.Put(ctx, token); err != nil {
+		return "", storage.APIToken{}, err
+	}
+	s.tokens = append(s.tokens, token)
+
+	s.logger.Info().
+		Str("id", token.ID).
+		Str("name", name).
+		Strs("scopes", scopes).
+		Str("created_by", createdBy).
+		Msg("API token created")
+	token.Hash = ""
+	return plain, token, nil
+}
+
+// Revoke deletes a token; requests using it fail from then on
+func (s *Set) Revoke(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexLocked(id)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err := s.store.Delete(ctx, id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	s.logger.Info().Str("id", id).Str("name", s.tokens[i].Name).Msg("API token revoked")
+	s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+	return nil
+}
+
+// List returns the unexpired tokens without their hashes, oldest first
+func (s *Set) List() []storage.APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	tokens := make([]storage.APIToken, len(s.tokens))
+	for i, token := range s.tokens {
+		token.Hash = ""
+		tokens[i] = token
+	}
+	return tokens
+}
+
+// Authenticate returns the unexpired token with the secret plain
+func (s *Set) Authenticate(plain string) (storage.APIToken, bool) {
+	if s == nil || !strings.HasPrefix(plain, Prefix) {
+		return storage.APIToken{}, false
+	}
+	want := hash(plain)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	for _, token := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(want)) == 1 {
+			return token, true
+		}
+	}
+	return storage.APIToken{}, false
+}
+
+// ValidateScope checks that scope is one a token can hold
+func ValidateScope(scope string) error {
+	if scope == "*" || scope == "read" {
+		return nil
+	}
+	resource, access, _ := strings.Cut(scope, ":")
+	if resource == "" || strings.ContainsAny(resource, "/* ") || (access != "" && access != "read") {
+		return fmt.Errorf("invalid scope: %q", scope)
+	}
+	return nil
+}
+
+// Allows reports whether a token with scopes may make a request with
+// method to the admin API path
+func Allows(scopes []string, method, path string) bool {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(path, "/api/"), "/")
+	if resource == "tokens" || resource == "approvals" || path == "/api/system/backup" || path == "/api/system/restore" {
+		return false
+	}
+	read := method == http.MethodGet || method == http.MethodHead
+	for _, scope := range scopes {
+		switch scope {
+		case "*":
+			return true
+		case "read":
+			if read {
+				return true
+			}
+			continue
+		}
+		name, access, _ := strings.Cut(scope, ":")
+		if name == resource && (access == "" || read) {
+			return true
+		}
+	}
+	return false
+}
+
+// hash returns the stored form of a secret
+func hash(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// indexLocked finds a token by ID. s.mu must be held.
+func (s *Set) indexLocked(id string) int {
+	for i, token := range s.tokens {
+		if token.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// pruneLocked drops expired tokens from the cache; Load deletes them from
+// storage. s.mu must be held.
+func (s *Set) pruneLocked() {
+	now := s.now()
+	kept := s.tokens[:0]
+	for _, token := range s.tokens {
+		if token.ExpiresAt != nil && !now.Before(*token.ExpiresAt) {
+			s.logger.Info().Str("id", token.ID).Str("name", token.Name).Msg("API token expired")
+			continue
+		}
+		kept = append(kept, token)
+	}
+	s.tokens = kept
+}
@@ -0,0 +1,103 @@
+package apitoken
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestSet(t *testing.T) {
+	ctx := context.Background()
+	store := memory.Open().APITokens()
+	s := New(store, zerolog.Nop())
+	now := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	secret, token, err := s.Create(ctx, "home-assistant", []string{"read", "maintenance"}, "parent", 0)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, Prefix) || token.Hash != "" || token.CreatedBy != "parent" || token.ExpiresAt != nil {
+		t.Errorf("Create() = %q, %+v", secret, token)
+	}
+	if stored, _ := store.List(ctx); len(stored) != 1 || stored[0].Hash == "" || strings.Contains(stored[0].Hash, secret) {
+		t.Errorf("stored tokens = %+v, want the hash only", stored)
+	}
+	if _, _, err := s.Create(ctx, "script", []string{"rules:write"}, "parent", 0); err == nil {
+		t.Error("Create() with an invalid scope succeeded")
+	}
+	if _, _, err := s.Create(ctx, " ", []string{"read"}, "parent", 0); err == nil {
+		t.Error("Create() without a name succeeded")
+	}
+	shortSecret, _, err := s.Create(ctx, "backup", []string{"*"}, "admin", time.Hour)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if got, ok := s.Authenticate(secret); !ok || got.ID != token.ID {
+		t.Errorf("Authenticate() = %+v, %v", got, ok)
+	}
+	if _, ok := s.Authenticate(secret + "x"); ok {
+		t.Error("Authenticate() accepted a wrong secret")
+	}
+
+	// Tokens survive a restart; expired ones are dropped from storage
+	now = now.Add(2 * time.Hour)
+	if _, ok := s.Authenticate(shortSecret); ok {
+		t.Error("Authenticate() accepted an expired token")
+	}
+	restarted := New(store, zerolog.Nop())
+	restarted.now = s.now
+	if err := restarted.Load(ctx); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if tokens := restarted.List(); len(tokens) != 1 || tokens[0].Name != "home-assistant" || tokens[0].Hash != "" {
+		t.Errorf("List() after Load = %+v, want home-assistant", tokens)
+	}
+	if stored, _ := store.List(ctx); len(stored) != 1 {
+		t.Errorf("stored tokens after Load = %+v, want the expired token deleted", stored)
+	}
+
+	if err := restarted.Revoke(ctx, token.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if err := restarted.Revoke(ctx, token.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke() again = %v, want ErrNotFound", err)
+	}
+	if _, ok := restarted.Authenticate(secret); ok {
+		t.Error("Authenticate() accepted a revoked token")
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		method string
+		path   string
+		want   bool
+	}{
+		{[]string{"*"}, http.MethodPut, "/api/rules/games", true},
+		{[]string{"*"}, http.MethodGet, "/api/tokens", false},
+		{[]string{"*"}, http.MethodPost, "/api/approvals/1/approve", false},
+		{[]string{"read"}, http.MethodGet, "/api/rules", true},
+		{[]string{"read"}, http.MethodGet, "/api/system/backup", false},
+		{[]string{"*"}, http.MethodPost, "/api/system/restore", false},
+		{[]string{"read"}, http.MethodDelete, "/api/rules/games", false},
+		{[]string{"maintenance"}, http.MethodPost, "/api/maintenance", true},
+		{[]string{"maintenance"}, http.MethodGet, "/api/rules", false},
+		{[]string{"devices:read"}, http.MethodGet, "/api/devices/tablet", true},
+		{[]string{"devices:read"}, http.MethodPost, "/api/devices/tablet/pause", false},
+		{[]string{"devices:read", "devices"}, http.MethodPost, "/api/devices/tablet/pause", true},
+	}
+	for _, tt := range tests {
+		if got := Allows(tt.scopes, tt.method, tt.path); got != tt.want {
+			t.Errorf("Allows(%v, %s, %s) = %v, want %v", tt.scopes, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package approval implements the two-person rule for destructive admin
+// changes: a change requested by one admin account is queued in storage and
+// only applied once a different admin approves it within the approval
+// window.
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrNotFound is returned for a change that doesn't exist or has expired
+	ErrNotFound = errors.New("pending change not found")
+
+	// ErrSelfApproval is returned when the requesting admin tries to approve
+	// their own change
+	ErrSelfApproval = errors.New("a change must be approved by a different admin")
+)
+
+// Queue holds destructive admin changes until a second admin approves them
+type Queue struct {
+	store  storage.PendingChangeStore
+	window time.Duration
+	logger zerolog.Logger
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates an approval queue. Changes expire if not approved within window.
+func New(store storage.PendingChangeStore, window time.Duration, logger zerolog.Logger) *Queue {
+	return &Queue{
+		store:  store,
+		window: window,
+		logger: logger.With().Str("component", "approval").Logger(),
+		now:    time.Now,
+	}
+}
+
+// Submit queues a change requested by an admin account. Params (optional)
+// is stored as JSON and handed back on approval.
+func (q *Queue) Submit(ctx context.Context, action, target string, params interface{}, by string) (*storage.PendingChange, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	change := storage.PendingChange{
+		ID:          id,
+		Action:      action,
+		Target:      target,
+		RequestedBy: by,
+		RequestedAt: q.now(),
+	}
+	change.ExpiresAt = change.RequestedAt.Add(q.window)
+	if params != nil {
+		if change.Params, err = json.Marshal(params); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := q.store.Create(ctx, change); err != nil {
+		return nil, err
+	}
+
+	q.logger.Info().
+		Str("id", change.ID).
+		Str("action", change.Action).
+		Str("target", change.Target).
+		Str("requested_by", by).
+		Time("expires_at", change.ExpiresAt).
+		Msg("Change waiting for approval")
+	return &change, nil
+}
+
+// List returns the changes waiting for approval, oldest first
+func (q *Queue) List(ctx context.Context) ([]storage.PendingChange, error) {
+	return q.store.List(ctx)
+}
+
+// Approve removes a change from the queue for the caller to apply. The
+// approving admin must not be the one who requested it.
+func (q *Queue) Approve(ctx context.Context, id, by string) (*storage.PendingChange, error) {
+	change, err := q.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if change.RequestedBy == by {
+		return nil, ErrSelfApproval
+	}
+
+	// Only one approval can take the change
+	if err := q.delete(ctx, id); err != nil {
+		return nil, err
+	}
+
+	q.logger.Info().
+		Str("id", change.ID).
+		Str("action", change.Action).
+		Str("target", change.Target).
+		Str("requested_by", change.RequestedBy).
+		Str("approved_by", by).
+		Msg("Change approved")
+	return change, nil
+}
+
+// Reject drops a change. Any admin can reject a change, including the one
+// who requested it (withdrawing it).
+func (q *Queue) Reject(ctx context.Context, id, by string) (*storage.PendingChange, error) {
+	change, err := q.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.delete(ctx, id); err != nil {
+		return nil, err
+	}
+
+	q.logger.Info().
+		Str("id", change.ID).
+		Str("action", change.Action).
+		Str("target", change.Target).
+		Str("requested_by", change.RequestedBy).
+		Str("rejected_by", by).
+		Msg("Change rejected")
+	return change, nil
+}
+
+// get looks up a change, mapping storage's not-found error
+func (q *Queue) get(ctx context.Context, id string) (*storage.PendingChange, error) {
+	change, err := q.store.Get(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return change, err
+}
+
+// delete removes a change, mapping storage's not-found error
+func (q *Queue) delete(ctx context.Context, id string) error {
+	err := q.store.Delete(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// newID returns a random change ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,74 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	q := New(memory.Open().PendingChanges(), time.Hour, zerolog.Nop())
+
+	change, err := q.Submit(ctx, "maintenance.enable", "", map[string]string{"duration": "30m"}, "alice")
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if !change.ExpiresAt.Equal(change.RequestedAt.Add(time.Hour)) {
+		t.Errorf("ExpiresAt = %v, want an hour after %v", change.ExpiresAt, change.RequestedAt)
+	}
+
+	if _, err := q.Approve(ctx, change.ID, "alice"); !errors.Is(err, ErrSelfApproval) {
+		t.Errorf("self approval error = %v, want ErrSelfApproval", err)
+	}
+
+	approved, err := q.Approve(ctx, change.ID, "bob")
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	var params map[string]string
+	if err := json.Unmarshal(approved.Params, &params); err != nil || params["duration"] != "30m" {
+		t.Errorf("Params = %s (%v), want the submitted params", approved.Params, err)
+	}
+
+	if _, err := q.Approve(ctx, change.ID, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second approval error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestQueueExpiryAndReject(t *testing.T) {
+	ctx := context.Background()
+	q := New(memory.Open().PendingChanges(), time.Hour, zerolog.Nop())
+
+	// Requested two hours ago, so already expired
+	q.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	expired, err := q.Submit(ctx, "device.remove", "tablet", nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Approve(ctx, expired.ID, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired approval error = %v, want ErrNotFound", err)
+	}
+
+	q.now = time.Now
+	change, err := q.Submit(ctx, "device.remove", "tablet", nil, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes, _ := q.List(ctx); len(changes) != 1 || changes[0].ID != change.ID {
+		t.Errorf("List() = %+v, want the unexpired change", changes)
+	}
+
+	// The requester can withdraw their own change
+	if _, err := q.Reject(ctx, change.ID, "alice"); err != nil {
+		t.Fatalf("Reject() error = %v", err)
+	}
+	if changes, _ := q.List(ctx); len(changes) != 0 {
+		t.Errorf("List() after Reject() = %+v, want none", changes)
+	}
+}
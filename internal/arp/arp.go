@@ -0,0 +1,137 @@
+// Package arp resolves client IP addresses to MAC addresses from the
+// kernel's neighbour table, so devices can be identified by MAC even though
+// DNS queries and HTTP requests only carry an IP address.
+package arp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultTable is the kernel's IPv4 neighbour table (Linux)
+const DefaultTable = "/proc/net/arp"
+
+// refreshInterval limits how often a lookup for an unknown client rereads
+// the table, so a burst of queries from an IPv6 or routed client doesn't
+// read it on every query
+const refreshInterval = time.Second
+
+// ReadTable reads an ARP table in the /proc/net/arp format, returning the
+// MAC address of each complete entry keyed by IP address. A missing table
+// (non-Linux systems) is empty.
+func ReadTable(path string) (map[string]net.HardwareAddr, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ARP table: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	entries := make(map[string]net.HardwareAddr)
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		mac, err := net.ParseMAC(fields[3])
+		if ip == nil || err != nil || mac.String() == "00:00:00:00:00:00" {
+			// Incomplete entry
+			continue
+		}
+		entries[ip.String()] = mac
+	}
+	return entries, scanner.Err()
+}
+
+// entry is a MAC address and when it was last in the table
+type entry struct {
+	mac  net.HardwareAddr
+	seen time.Time
+}
+
+// Cache looks up client MAC addresses in the neighbour table. A MAC address
+// is remembered for the TTL after it was last seen, so clients keep their
+// identity while the kernel's entry is briefly stale or reprobed. Clients
+// without an entry (IPv6 or routed clients) have no MAC address and are
+// identified by IP.
+type Cache struct {
+	path   string
+	ttl    time.Duration
+	logger zerolog.Logger
+
+	mu        sync.Mutex
+	entries   map[string]entry
+	refreshed time.Time
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewCache creates a cache over the kernel's neighbour table
+func NewCache(ttl time.Duration, logger zerolog.Logger) *Cache {
+	return &Cache{
+		path:    DefaultTable,
+		ttl:     ttl,
+		logger:  logger.With().Str("component", "arp").Logger(),
+		entries: make(map[string]entry),
+		now:     time.Now,
+	}
+}
+
+// Lookup returns the MAC address of the client at ip, or nil if it isn't
+// known. A nil cache knows no clients.
+func (c *Cache) Lookup(ip net.IP) net.HardwareAddr {
+	if c == nil || ip == nil {
+		return nil
+	}
+	key := ip.String()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if e, ok := c.entries[key]; ok && now.Sub(e.seen) < c.ttl {
+		return e.mac
+	}
+	if now.Sub(c.refreshed) < refreshInterval {
+		return nil
+	}
+
+	c.refresh(now)
+	if e, ok := c.entries[key]; ok && now.Sub(e.seen) < c.ttl {
+		return e.mac
+	}
+	return nil
+}
+
+// refresh rereads the table, forgetting entries not seen for the TTL.
+// The caller must hold c.mu.
+func (c *Cache) refresh(now time.Time) {
+	c.refreshed = now
+	table, err := ReadTable(c.path)
+	if err != nil {
+		c.logger.Warn().Err(err).Msg("ARP table lookup failed")
+		return
+	}
+	for ip, mac := range table {
+		c.entries[ip] = entry{mac: mac, seen: now}
+	}
+	for ip, e := range c.entries {
+		if now.Sub(e.seen) >= c.ttl {
+			delete(c.entries, ip)
+		}
+	}
+}
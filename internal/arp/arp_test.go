@@ -0,0 +1,80 @@
+package arp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const header = "IP address       HW type     Flags       HW address            Mask     Device\n"
+
+func writeTable(t *testing.T, path, rows string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(header+rows), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arp")
+	writeTable(t, path, `192.168.5.37     0x1         0x2         aa:bb:cc:dd:ee:01     *        br0
+192.168.5.40     0x1         0x0         00:00:00:00:00:00     *        br0
+`)
+
+	table, err := ReadTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table) != 1 || table["192.168.5.37"].String() != "aa:bb:cc:dd:ee:01" {
+		t.Errorf("Expected only the complete entry, got %v", table)
+	}
+
+	if table, err := ReadTable(filepath.Join(t.TempDir(), "missing")); err != nil || len(table) != 0 {
+		t.Errorf("Expected a missing table to be empty, got %v, %v", table, err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arp")
+	writeTable(t, path, "192.168.5.37     0x1         0x2         aa:bb:cc:dd:ee:01     *        br0\n")
+
+	now := time.Now()
+	c := NewCache(5*time.Minute, zerolog.Nop())
+	c.path = path
+	c.now = func() time.Time { return now }
+
+	client := net.ParseIP("192.168.5.37")
+	if mac := c.Lookup(client); mac.String() != "aa:bb:cc:dd:ee:01" {
+		t.Fatalf("Expected the client's MAC, got %v", mac)
+	}
+
+	// A new client isn't looked for again until the refresh interval passes
+	writeTable(t, path, "192.168.5.50     0x1         0x2         aa:bb:cc:dd:ee:02     *        br0\n")
+	if mac := c.Lookup(net.ParseIP("192.168.5.50")); mac != nil {
+		t.Errorf("Expected no lookup within the refresh interval, got %v", mac)
+	}
+	now = now.Add(2 * time.Second)
+	if mac := c.Lookup(net.ParseIP("192.168.5.50")); mac.String() != "aa:bb:cc:dd:ee:02" {
+		t.Errorf("Expected the new client's MAC, got %v", mac)
+	}
+
+	// The first client left the table but is remembered for the TTL
+	if mac := c.Lookup(client); mac.String() != "aa:bb:cc:dd:ee:01" {
+		t.Errorf("Expected the cached MAC within the TTL, got %v", mac)
+	}
+	now = now.Add(5 * time.Minute)
+	if mac := c.Lookup(client); mac != nil {
+		t.Errorf("Expected the MAC to expire after the TTL, got %v", mac)
+	}
+}
+
+func TestCache_Nil(t *testing.T) {
+	var c *Cache
+	if mac := c.Lookup(net.ParseIP("192.168.5.37")); mac != nil {
+		t.Errorf("Expected a nil cache to know no clients, got %v", mac)
+	}
+}
@@ -0,0 +1,599 @@
+// Package backup writes the configuration of a KProxy to a single versioned
+// archive and restores it: the records kept in storage, the policy files
+// and, optionally, the CA certificates and keys. Archives are gzipped tar
+// files; an archive written by an older KProxy is migrated to the current
+// version as it is read.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policyedit"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+// Version is the version of the archives written
+const Version = 1
+
+// Files in an archive
+const (
+	manifestFile = "manifest.json"
+	storageFile  = "storage.json"
+	policiesDir  = "policies/"
+	caDir        = "ca/"
+)
+
+// CA files, by their name in an archive
+const (
+	CACert           = "ca.crt"
+	CAKey            = "ca.key"
+	IntermediateCert = "intermediate.crt"
+	IntermediateKey  = "intermediate.key"
+)
+
+// maxFileSize bounds each file read from an archive
+const maxFileSize = 64 << 20
+
+var (
+	// ErrVersion is returned for archives written by a newer KProxy
+	ErrVersion = errors.New("backup written by a newer KProxy")
+
+	// ErrInvalid is returned for files that aren't a KProxy backup
+	ErrInvalid = errors.New("not a KProxy backup")
+
+	// ErrUnrestorable is returned for backups that don't fit this KProxy,
+	// such as policy files without a policy directory to restore them to
+	ErrUnrestorable = errors.New("backup can't be restored here")
+)
+
+// migrations upgrade the files of an archive one version at a time:
+// migrations[i] turns version i+1 into version i+2. Add one whenever
+// Version is bumped.
+var migrations []func(files map[string][]byte) error
+
+// Manifest describes an archive
+type Manifest struct {
+	Version  int       `json:"version"`
+	Created  time.Time `json:"created"`
+	KProxy   string    `json:"kproxy,omitempty"`   // Version of KProxy that wrote it
+	Policies []string  `json:"policies,omitempty"` // Policy files
+	CA       []string  `json:"ca,omitempty"`       // CA files, when included
+}
+
+// Snapshot holds the records kept in storage that make up configuration.
+// Caches and logs (DNS cache, decisions, hostnames, issued certificates,
+// the audit log, reports) and changes awaiting approval aren't included.
+type Snapshot struct {
+	DHCPLeases       []storage.DHCPLease       `json:"dhcp_leases,omitempty"`
+	DHCPReservations []storage.DHCPReservation `json:"dhcp_reservations,omitempty"`
+	BlockPages       []storage.BlockPage       `json:"block_pages,omitempty"`
+	DeviceRules      []storage.DeviceRule      `json:"device_rules,omitempty"`
+	RuleSets         []storage.RuleSet         `json:"rule_sets,omitempty"`
+	Pauses           []storage.Pause           `json:"pauses,omitempty"`
+	APITokens        []storage.APIToken        `json:"api_tokens,omitempty"`
+	DailyUsage       []storage.DailyUsage      `json:"daily_usage,omitempty"` // Today's, so limits carry over
+}
+
+// records counts the records of a snapshot
+func (s Snapshot) records() int {
+	return len(s.DHCPLeases) + len(s.DHCPReservations) + len(s.BlockPages) + len(s.DeviceRules) +
+		len(s.RuleSets) + len(s.Pauses) + len(s.APITokens) + len(s.DailyUsage)
+}
+
+// Archive is a backup as read
+type Archive struct {
+	Manifest Manifest
+	Storage  Snapshot
+	Policies map[string]string // Name -> content
+	CA       map[string][]byte // CACert, CAKey, ... -> PEM
+}
+
+// Result is the outcome of a restore
+type Result struct {
+	DryRun   bool                `json:"dry_run"`
+	Manifest Manifest            `json:"manifest"`
+	Records  int                 `json:"records"`      // Storage records restored
+	Policies []policyedit.Result `json:"policies"`     // Policy files changed
+	CA       []string            `json:"ca,omitempty"` // CA files restored; used after a restart
+}
+
+// Policies are the policy files of a filesystem policy directory
+// (policyedit.Editor satisfies it)
+type Policies interface {
+	List() ([]policyedit.File, error)
+	Get(name string) (*policyedit.File, error)
+	Replace(files map[string]string, dryRun bool) ([]policyedit.Result, error)
+}
+
+// Config is what a Manager backs up and restores
+type Config struct {
+	Store    storage.Store
+	Policies Policies          // nil without a filesystem policy source
+	CAFiles  map[string]string // CACert, CAKey, ... -> path; unset or missing files are skipped
+	Version  string            // KProxy version recorded in manifests
+	Reload   func()            // Reloads the state cached from storage after a restore (optional)
+}
+
+// Manager writes and restores backups
+type Manager struct {
+	cfg    Config
+	logger zerolog.Logger
+
+	// Serializes restores
+	mu sync.Mutex
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates a manager
+func New(cfg Config, logger zerolog.Logger) *Manager {
+	return &Manager{
+		cfg:    cfg,
+		logger: logger.With().Str("component", "backup").Logger(),
+		now:    time.Now,
+	}
+}
+
+// HasCA reports whether CA files are configured to back up
+func (m *Manager) HasCA() bool {
+	for _, path := range m.cfg.CAFiles {
+		if path != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes a backup to w, with the CA files if withCA is set
+func (m *Manager) Write(ctx context.Context, w io.Writer, withCA bool) (Manifest, error) {
+	manifest := Manifest{Version: Version, Created: m.now().UTC(), KProxy: m.cfg.Version}
+
+	snapshot, err := m.snapshot(ctx)
+	if err != nil {
+		return Manifest{}, err
+	}
+	files := map[string][]byte{}
+	if files[storageFile], err = json.MarshalIndent(snapshot, "", "  "); err != nil {
+		return Manifest{}, err
+	}
+
+	if m.cfg.Policies != nil {
+		list, err := m.cfg.Policies.List()
+		if err != nil {
+			return Manifest{}, fmt.Errorf("failed to list policies: %w", err)
+		}
+		for _, f := range list {
+			file, err := m.cfg.Policies.Get(f.Name)
+			if err != nil {
+				return Manifest{}, fmt.Errorf("failed to read policy %s: %w", f.Name, err)
+			}
+			files[policiesDir+f.Name] = []byte(file.Content)
+			manifest.Policies = append(manifest.Policies, f.Name)
+		}
+	}
+
+	if withCA {
+		for _, name := range []string{CACert, CAKey, IntermediateCert, IntermediateKey} {
+			path := m.cfg.CAFiles[name]
+			if path == "" {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return Manifest{}, fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			files[caDir+name] = data
+			manifest.CA = append(manifest.CA, name)
+		}
+		if len(manifest.CA) == 0 {
+			return Manifest{}, errors.New("no CA files to back up")
+		}
+	}
+
+	if files[manifestFile], err = json.MarshalIndent(manifest, "", "  "); err != nil {
+		return Manifest{}, err
+	}
+	if err := writeArchive(w, files, manifest.Created); err != nil {
+		return Manifest{}, err
+	}
+	return manifest, nil
+}
+
+// writeArchive writes files as a gzipped tar, the manifest first
+func writeArchive(w io.Writer, files map[string][]byte, modified time.Time) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if name != manifestFile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{manifestFile}, names...)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		mode := int64(0644)
+		if strings.HasPrefix(name, caDir) || name == storageFile {
+			mode = 0600 // Keys and API token hashes
+		}
+		header := &tar.Header{Name: name, Mode: mode, Size: int64(len(files[name])), ModTime: modified}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// snapshot reads the records to back up from storage
+func (m *Manager) snapshot(ctx context.Context) (Snapshot, error) {
+	store := m.cfg.Store
+	var s Snapshot
+	var err error
+	if s.DHCPLeases, err = store.DHCPLeases().List(ctx); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list DHCP leases: %w", err)
+	}
+	if s.DHCPReservations, err = store.DHCPReservations().List(ctx); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list DHCP reservations: %w", err)
+	}
+	if s.BlockPages, err = store.BlockPages().List(ctx); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list block pages: %w", err)
+	}
+	if s.DeviceRules, err = store.DeviceRules().List(ctx); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list device rules: %w", err)
+	}
+	if s.RuleSets, err = store.RuleSets().List(ctx); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list rule sets: %w", err)
+	}
+	if s.Pauses, err = store.Pauses().List(ctx); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list pauses: %w", err)
+	}
+	if s.APITokens, err = store.APITokens().List(ctx); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list API tokens: %w", err)
+	}
+	if s.DailyUsage, err = store.Usage().ListDailyUsage(ctx, m.now().Format("2006-01-02")); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list usage: %w", err)
+	}
+	return s, nil
+}
+
+// Read reads a backup, migrating it from the version it was written in
+func Read(r io.Reader) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	defer func() { _ = gz.Close() }()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxFileSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		if len(data) > maxFileSize {
+			return nil, fmt.Errorf("%w: %s is too large", ErrInvalid, header.Name)
+		}
+		files[header.Name] = data
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(files[manifestFile], &manifest); err != nil || manifest.Version < 1 {
+		return nil, fmt.Errorf("%w: missing or invalid %s", ErrInvalid, manifestFile)
+	}
+	if manifest.Version > Version {
+		return nil, fmt.Errorf("%w (version %d, this KProxy reads up to %d)", ErrVersion, manifest.Version, Version)
+	}
+	if err := migrate(files, manifest.Version, Version); err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{Manifest: manifest, Policies: make(map[string]string), CA: make(map[string][]byte)}
+	if data, ok := files[storageFile]; ok {
+		if err := json.Unmarshal(data, &archive.Storage); err != nil {
+			return nil, fmt.Errorf("%w: invalid %s: %v", ErrInvalid, storageFile, err)
+		}
+	}
+	for name, data := range files {
+		switch {
+		case strings.HasPrefix(name, policiesDir):
+			archive.Policies[strings.TrimPrefix(name, policiesDir)] = string(data)
+		case strings.HasPrefix(name, caDir):
+			archive.CA[strings.TrimPrefix(name, caDir)] = data
+		}
+	}
+	return archive, nil
+}
+
+// migrate upgrades the files of an archive from one version to another
+func migrate(files map[string][]byte, from, to int) error {
+	for v := from; v < to; v++ {
+		if err := migrations[v-1](files); err != nil {
+			return fmt.Errorf("failed to migrate backup from version %d: %w", v, err)
+		}
+	}
+	return nil
+}
+
+// Restore replaces the configuration with a backup: the policy files
+// become those of the backup, and the backed up kinds of storage record
+// are made to match it. CA files are only restored with withCA, and are
+// used after a restart. A dry run checks the backup and reports what would
+// change.
+func (m *Manager) Restore(ctx context.Context, archive *Archive, withCA, dryRun bool) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := Result{DryRun: dryRun, Manifest: archive.Manifest, Records: archive.Storage.records(), Policies: []policyedit.Result{}}
+
+	// Check everything before changing anything
+	if len(archive.Policies) > 0 && m.cfg.Policies == nil {
+		return Result{}, fmt.Errorf("%w: it has policy files, but policies here aren't a filesystem directory", ErrUnrestorable)
+	}
+	if withCA {
+		if err := m.checkCA(archive.CA); err != nil {
+			return Result{}, err
+		}
+	}
+	if len(archive.Policies) > 0 {
+		// Compiles them all before anything is written
+		policies, err := m.cfg.Policies.Replace(archive.Policies, true)
+		if err != nil {
+			return Result{}, err
+		}
+		result.Policies = policies
+	}
+	if dryRun {
+		if withCA {
+			result.CA = sortedKeys(archive.CA)
+		}
+		return result, nil
+	}
+
+	if err := restoreSnapshot(ctx, m.cfg.Store, archive.Storage, m.now().Format("2006-01-02")); err != nil {
+		return Result{}, err
+	}
+	if m.cfg.Reload != nil {
+		m.cfg.Reload()
+	}
+	if len(archive.Policies) > 0 {
+		policies, err := m.cfg.Policies.Replace(archive.Policies, false)
+		if err != nil {
+			return Result{}, err
+		}
+		result.Policies = policies
+	}
+	if withCA {
+		for _, name := range sortedKeys(archive.CA) {
+			if err := writeFile(m.cfg.CAFiles[name], archive.CA[name]); err != nil {
+				return Result{}, fmt.Errorf("failed to restore %s: %w", name, err)
+			}
+			result.CA = append(result.CA, name)
+		}
+	}
+
+	m.logger.Info().
+		Time("created", archive.Manifest.Created).
+		Int("records", result.Records).
+		Int("policies", len(result.Policies)).
+		Strs("ca", result.CA).
+		Msg("Backup restored")
+	return result, nil
+}
+
+// checkCA checks that the CA files of a backup can be restored: each has a
+// path configured, and the certificates and keys form pairs
+func (m *Manager) checkCA(files map[string][]byte) error {
+	if len(files) == 0 {
+		return fmt.Errorf("%w: it has no CA files", ErrUnrestorable)
+	}
+	for name := range files {
+		if m.cfg.CAFiles[name] == "" {
+			return fmt.Errorf("%w: it has %s, but no path is configured for it", ErrUnrestorable, name)
+		}
+	}
+	for _, pair := range [][2]string{{CACert, CAKey}, {IntermediateCert, IntermediateKey}} {
+		cert, key := files[pair[0]], files[pair[1]]
+		if cert == nil && key == nil {
+			continue
+		}
+		if _, err := tls.X509KeyPair(cert, key); err != nil {
+			return fmt.Errorf("%w: its %s and %s don't form a pair: %v", ErrUnrestorable, pair[0], pair[1], err)
+		}
+	}
+	return nil
+}
+
+// restoreSnapshot makes the records in store match a snapshot: records in
+// it are written, and records of the same kinds not in it are deleted.
+// Today's usage only ever grows, so limits aren't reset by a restore.
+func restoreSnapshot(ctx context.Context, store storage.Store, s Snapshot, today string) error {
+	leases, err := store.DHCPLeases().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list DHCP leases: %w", err)
+	}
+	if err := replace(ctx, s.DHCPLeases, leases,
+		func(l storage.DHCPLease) string { return l.MAC },
+		func(ctx context.Context, l storage.DHCPLease) error { return store.DHCPLeases().Create(ctx, &l) },
+		func(ctx context.Context, l storage.DHCPLease) error { return store.DHCPLeases().Delete(ctx, l.MAC) },
+	); err != nil {
+		return fmt.Errorf("failed to restore DHCP leases: %w", err)
+	}
+
+	reservations, err := store.DHCPReservations().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list DHCP reservations: %w", err)
+	}
+	if err := replace(ctx, s.DHCPReservations, reservations,
+		func(r storage.DHCPReservation) string { return r.MAC },
+		store.DHCPReservations().Put,
+		func(ctx context.Context, r storage.DHCPReservation) error {
+			return store.DHCPReservations().Delete(ctx, r.MAC)
+		},
+	); err != nil {
+		return fmt.Errorf("failed to restore DHCP reservations: %w", err)
+	}
+
+	pages, err := store.BlockPages().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list block pages: %w", err)
+	}
+	if err := replace(ctx, s.BlockPages, pages,
+		func(p storage.BlockPage) string { return p.Name },
+		store.BlockPages().Put,
+		func(ctx context.Context, p storage.BlockPage) error { return store.BlockPages().Delete(ctx, p.Name) },
+	); err != nil {
+		return fmt.Errorf("failed to restore block pages: %w", err)
+	}
+
+	deviceRules, err := store.DeviceRules().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list device rules: %w", err)
+	}
+	if err := replace(ctx, s.DeviceRules, deviceRules,
+		func(r storage.DeviceRule) string { return r.ID },
+		store.DeviceRules().Put,
+		func(ctx context.Context, r storage.DeviceRule) error { return store.DeviceRules().Delete(ctx, r.ID) },
+	); err != nil {
+		return fmt.Errorf("failed to restore device rules: %w", err)
+	}
+
+	ruleSets, err := store.RuleSets().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list rule sets: %w", err)
+	}
+	if err := replace(ctx, s.RuleSets, ruleSets,
+		func(r storage.RuleSet) string { return r.ID },
+		store.RuleSets().Put,
+		func(ctx context.Context, r storage.RuleSet) error { return store.RuleSets().Delete(ctx, r.ID) },
+	); err != nil {
+		return fmt.Errorf("failed to restore rule sets: %w", err)
+	}
+
+	pauses, err := store.Pauses().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list pauses: %w", err)
+	}
+	if err := replace(ctx, s.Pauses, pauses,
+		func(p storage.Pause) string { return p.Kind + "/" + p.Target },
+		store.Pauses().Put,
+		func(ctx context.Context, p storage.Pause) error { return store.Pauses().Delete(ctx, p.Kind, p.Target) },
+	); err != nil {
+		return fmt.Errorf("failed to restore pauses: %w", err)
+	}
+
+	tokens, err := store.APITokens().List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list API tokens: %w", err)
+	}
+	if err := replace(ctx, s.APITokens, tokens,
+		func(t storage.APIToken) string { return t.ID },
+		store.APITokens().Put,
+		func(ctx context.Context, t storage.APIToken) error { return store.APITokens().Delete(ctx, t.ID) },
+	); err != nil {
+		return fmt.Errorf("failed to restore API tokens: %w", err)
+	}
+
+	for _, usage := range s.DailyUsage {
+		if usage.Date != today {
+			continue
+		}
+		var current int64
+		if have, err := store.Usage().GetDailyUsage(ctx, today, usage.DeviceID, usage.LimitID); err == nil {
+			current = have.TotalSeconds
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			return fmt.Errorf("failed to read usage: %w", err)
+		}
+		if usage.TotalSeconds > current {
+			if err := store.Usage().IncrementDailyUsage(ctx, today, usage.DeviceID, usage.LimitID, usage.TotalSeconds-current); err != nil {
+				return fmt.Errorf("failed to restore usage: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// replace writes the records in want and deletes those in have that
+// aren't, matching them by key
+func replace[T any](ctx context.Context, want, have []T, key func(T) string,
+	put, remove func(context.Context, T) error) error {
+	keep := make(map[string]bool, len(want))
+	for _, record := range want {
+		keep[key(record)] = true
+		if err := put(ctx, record); err != nil {
+			return err
+		}
+	}
+	for _, record := range have {
+		if keep[key(record)] {
+			continue
+		}
+		if err := remove(ctx, record); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile replaces a file atomically, readable by its owner only
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := io.Copy(tmp, bytes.NewReader(data)); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
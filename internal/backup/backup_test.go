@@ -0,0 +1,239 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policyedit"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/goodtune/kproxy/policies"
+	"github.com/rs/zerolog"
+)
+
+// countingReloader counts reloads
+type countingReloader struct {
+	reloads int
+}
+
+func (r *countingReloader) Reload() error {
+	r.reloads++
+	return nil
+}
+
+// policyDir writes the shipped policies (without tests) to a temp directory
+func policyDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	entries, err := policies.FS.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), "_test.rego") {
+			continue
+		}
+		data, err := policies.FS.ReadFile(entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry.Name()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// writeCA writes a self-signed CA certificate and key, returning their paths
+func writeCA(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	today := time.Now().Format("2006-01-02")
+
+	source := memory.Open()
+	if err := source.RuleSets().Put(ctx, storage.RuleSet{ID: "games", Name: "Games", Profiles: []string{"child"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.APITokens().Put(ctx, storage.APIToken{ID: "t1", Name: "script", Hash: "abc", Scopes: []string{"read"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Pauses().Put(ctx, storage.Pause{Kind: storage.PauseProfile, Target: "child"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Usage().IncrementDailyUsage(ctx, today, "tablet", "games", 600); err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := writeCA(t, t.TempDir())
+	sourcePolicies := policyedit.New(policyDir(t), &countingReloader{}, zerolog.Nop())
+	m := New(Config{
+		Store:    source,
+		Policies: sourcePolicies,
+		CAFiles:  map[string]string{CACert: certPath, CAKey: keyPath},
+		Version:  "1.2.3",
+	}, zerolog.Nop())
+
+	var buf bytes.Buffer
+	manifest, err := m.Write(ctx, &buf, true)
+	if err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if manifest.Version != Version || manifest.KProxy != "1.2.3" || len(manifest.Policies) == 0 || len(manifest.CA) != 2 {
+		t.Errorf("manifest = %+v, want policies and both CA files", manifest)
+	}
+
+	archive, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if len(archive.Storage.RuleSets) != 1 || archive.Storage.APITokens[0].Hash != "abc" || len(archive.Storage.DailyUsage) != 1 || len(archive.CA) != 2 {
+		t.Errorf("archive = %+v, want the records and CA files written", archive)
+	}
+
+	// Restore elsewhere: a rule set not in the backup goes, the policy
+	// files become the backup's
+	target := memory.Open()
+	if err := target.RuleSets().Put(ctx, storage.RuleSet{ID: "stale", Name: "Stale"}); err != nil {
+		t.Fatal(err)
+	}
+	dir := policyDir(t)
+	if err := os.WriteFile(filepath.Join(dir, "extra.rego"), []byte("package kproxy.extra\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targetCA := t.TempDir()
+	reloader := &countingReloader{}
+	reloads := 0
+	restorer := New(Config{
+		Store:    target,
+		Policies: policyedit.New(dir, reloader, zerolog.Nop()),
+		CAFiles:  map[string]string{CACert: filepath.Join(targetCA, "ca.crt"), CAKey: filepath.Join(targetCA, "ca.key")},
+		Reload:   func() { reloads++ },
+	}, zerolog.Nop())
+
+	result, err := restorer.Restore(ctx, archive, true, true)
+	if err != nil || !result.DryRun || len(result.Policies) != 1 || len(result.CA) != 2 {
+		t.Fatalf("Restore() dry run = %+v (%v), want extra.rego removed and both CA files", result, err)
+	}
+	if sets, _ := target.RuleSets().List(ctx); len(sets) != 1 || sets[0].ID != "stale" || reloads != 0 {
+		t.Error("dry run changed storage or reloaded")
+	}
+
+	result, err = restorer.Restore(ctx, archive, true, false)
+	if err != nil || result.Records != 4 {
+		t.Fatalf("Restore() = %+v (%v), want 4 records", result, err)
+	}
+	if sets, _ := target.RuleSets().List(ctx); len(sets) != 1 || sets[0].ID != "games" {
+		t.Errorf("rule sets = %+v, want games only", sets)
+	}
+	if tokens, _ := target.APITokens().List(ctx); len(tokens) != 1 || tokens[0].Hash != "abc" {
+		t.Errorf("tokens = %+v, want t1 with its hash", tokens)
+	}
+	if usage, err := target.Usage().GetDailyUsage(ctx, today, "tablet", "games"); err != nil || usage.TotalSeconds != 600 {
+		t.Errorf("usage = %+v (%v), want 600s", usage, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "extra.rego")); !os.IsNotExist(err) || reloader.reloads != 1 || reloads != 1 {
+		t.Errorf("extra.rego: %v, %d policy reloads, %d state reloads; want it removed and one of each", err, reloader.reloads, reloads)
+	}
+	if data, err := os.ReadFile(filepath.Join(targetCA, "ca.key")); err != nil || !bytes.Equal(data, archive.CA[CAKey]) {
+		t.Errorf("ca.key not restored: %v", err)
+	}
+
+	// Restoring again doesn't add to today's usage
+	if _, err := restorer.Restore(ctx, archive, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ := target.Usage().GetDailyUsage(ctx, today, "tablet", "games"); usage.TotalSeconds != 600 {
+		t.Errorf("usage after restoring twice = %ds, want 600s", usage.TotalSeconds)
+	}
+
+	// Without a filesystem policy directory, policy files can't be restored
+	if _, err := New(Config{Store: memory.Open()}, zerolog.Nop()).Restore(ctx, archive, false, true); err == nil {
+		t.Error("Restore() without policies = nil, want an error")
+	}
+}
+
+func TestReadVersions(t *testing.T) {
+	archive := func(manifest Manifest, files map[string][]byte) []byte {
+		t.Helper()
+		if files == nil {
+			files = make(map[string][]byte)
+		}
+		files[manifestFile], _ = json.Marshal(manifest)
+		var buf bytes.Buffer
+		if err := writeArchive(&buf, files, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	if _, err := Read(bytes.NewReader(archive(Manifest{Version: Version + 1}, nil))); !errors.Is(err, ErrVersion) {
+		t.Errorf("Read() newer = %v, want ErrVersion", err)
+	}
+	if _, err := Read(strings.NewReader("not a backup")); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Read() garbage = %v, want ErrInvalid", err)
+	}
+
+	// Older archives go through each migration in turn
+	saved := migrations
+	defer func() { migrations = saved }()
+	migrations = []func(map[string][]byte) error{
+		func(files map[string][]byte) error {
+			files[storageFile] = bytes.ReplaceAll(files[storageFile], []byte(`"sets"`), []byte(`"rule_sets"`))
+			return nil
+		},
+		func(files map[string][]byte) error {
+			return errors.New("broken")
+		},
+	}
+	files := map[string][]byte{storageFile: []byte(`{"sets": [{"id": "games"}]}`)}
+	if err := migrate(files, 1, 2); err != nil || !bytes.Contains(files[storageFile], []byte(`"rule_sets"`)) {
+		t.Errorf("migrate() = %v, %s; want rule_sets", err, files[storageFile])
+	}
+	if err := migrate(files, 1, 3); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Errorf("migrate() failing = %v, want an error naming version 2", err)
+	}
+}
@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// scheduledTimeout bounds one scheduled backup
+const scheduledTimeout = 5 * time.Minute
+
+// File names of scheduled backups: kproxy-backup-20060102-150405.tar.gz
+const (
+	filePrefix = "kproxy-backup-"
+	fileSuffix = ".tar.gz"
+)
+
+// Scheduler writes a backup to a directory every day, keeping the newest
+type Scheduler struct {
+	manager    *Manager
+	dir        string
+	backupTime time.Time // Time of day to back up (only hour and minute are used)
+	keep       int       // Backups kept; older ones are deleted (0 keeps all)
+	withCA     bool
+	logger     zerolog.Logger
+	stopChan   chan struct{}
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewScheduler creates a scheduler backing up daily at backupTime (HH:MM)
+func NewScheduler(manager *Manager, dir, backupTime string, keep int, withCA bool, logger zerolog.Logger) (*Scheduler, error) {
+	parsedTime, err := time.Parse("15:04", backupTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scheduler{
+		manager:    manager,
+		dir:        dir,
+		backupTime: parsedTime,
+		keep:       keep,
+		withCA:     withCA,
+		logger:     logger.With().Str("component", "backup-scheduler").Logger(),
+		stopChan:   make(chan struct{}),
+		now:        time.Now,
+	}, nil
+}
+
+// Start begins the daily backups
+func (s *Scheduler) Start() {
+	go s.run()
+	s.logger.Info().
+		Str("dir", s.dir).
+		Str("backup_time", s.backupTime.Format("15:04")).
+		Int("keep", s.keep).
+		Msg("Daily backup scheduled")
+}
+
+// Stop stops the daily backups
+func (s *Scheduler) Stop() {
+	close(s.stopChan)
+}
+
+// run waits for each backup time until stopped
+func (s *Scheduler) run() {
+	for {
+		select {
+		case <-time.After(time.Until(s.nextBackup())):
+			if _, err := s.Backup(); err != nil {
+				s.logger.Error().Err(err).Msg("Scheduled backup failed")
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// nextBackup returns the next backup time
+func (s *Scheduler) nextBackup() time.Time {
+	now := s.now()
+	next := time.Date(now.Year(), now.Month(), now.Day(), s.backupTime.Hour(), s.backupTime.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Backup writes a backup to the directory and deletes the oldest beyond
+// the number kept. It returns the path written.
+func (s *Scheduler) Backup() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), scheduledTimeout)
+	defer cancel()
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, filePrefix+s.now().Format("20060102-150405")+fileSuffix)
+
+	// Write next to the file and rename, so a partial backup is never kept
+	tmp, err := os.CreateTemp(s.dir, ".backup-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	manifest, err := s.manager.Write(ctx, tmp, s.withCA)
+	if err != nil {
+		_ = tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+
+	s.logger.Info().Str("path", path).Int("policies", len(manifest.Policies)).Strs("ca", manifest.CA).Msg("Backup written")
+	if err := s.prune(); err != nil {
+		return path, fmt.Errorf("backup written, but removing old backups failed: %w", err)
+	}
+	return path, nil
+}
+
+// prune deletes the oldest backups beyond the number kept
+func (s *Scheduler) prune() error {
+	if s.keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			names = append(names, name)
+		}
+	}
+	// Names sort by time
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for i := s.keep; i < len(names); i++ {
+		if err := os.Remove(filepath.Join(s.dir, names[i])); err != nil {
+			return err
+		}
+		s.logger.Debug().Str("name", names[i]).Msg("Old backup removed")
+	}
+	return nil
+}
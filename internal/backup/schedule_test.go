@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestScheduler(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewScheduler(New(Config{Store: memory.Open()}, zerolog.Nop()), dir, "03:00", 2, false, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 10, 15, 2, 0, 0, 0, time.Local)
+	s.now = func() time.Time { return now }
+	if next := s.nextBackup(); !next.Equal(time.Date(2026, 10, 15, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("nextBackup() = %v, want 03:00 today", next)
+	}
+
+	for i := 0; i < 3; i++ {
+		now = now.AddDate(0, 0, 1)
+		if _, err := s.Backup(); err != nil {
+			t.Fatalf("Backup() = %v", err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !strings.Contains(entries[0].Name(), "20261017") || !strings.Contains(entries[1].Name(), "20261018") {
+		t.Errorf("backups = %v, want the newest two", entries)
+	}
+	f, err := os.Open(filepath.Join(dir, entries[1].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := Read(f); err != nil {
+		t.Errorf("Read() scheduled backup = %v", err)
+	}
+
+	if _, err := NewScheduler(nil, dir, "3am", 0, false, zerolog.Nop()); err == nil {
+		t.Error("NewScheduler() with an invalid time = nil, want an error")
+	}
+}
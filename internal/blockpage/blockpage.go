@@ -0,0 +1,206 @@
+// Package blockpage manages custom block page templates. Parents upload
+// html/template pages through the admin API; policy names the template to
+// show for a blocked request (per profile and category) and the proxy
+// renders it in place of the built-in block page.
+package blockpage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrNotFound is returned for templates that don't exist
+	ErrNotFound = errors.New("block page not found")
+
+	// ErrInvalidName is returned for template names that aren't usable as
+	// config.rego values
+	ErrInvalidName = errors.New("invalid block page name (use lowercase letters, digits, '.', '-' and '_')")
+
+	// ErrInvalidTemplate is returned for templates that don't parse or render
+	ErrInvalidTemplate = errors.New("invalid block page template")
+)
+
+// namePattern matches template names
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// Data is what a block page template can show, e.g. {{.Reason}}
+type Data struct {
+	Reason        string
+	Host          string
+	URL           string
+	Path          string // Blocked path, for the access request form's "path" field
+	Device        string
+	Profile       string
+	Category      string
+	TimeRemaining string // e.g. "25 minutes" (empty if no usage limit applies)
+	BlockedAt     time.Time
+	RequestAccess bool // Whether the page may offer the access request form
+}
+
+// sample is rendered when a template is saved, to catch templates that
+// parse but fail on execution (e.g. {{.Missing}})
+var sample = Data{
+	Reason:        "matched block rule: social",
+	Host:          "example.com",
+	URL:           "example.com/",
+	Path:          "/",
+	Device:        "192.168.1.10",
+	Profile:       "kids",
+	Category:      "social",
+	TimeRemaining: "25 minutes",
+	BlockedAt:     time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	RequestAccess: true,
+}
+
+// Pages holds the block page templates, parsing each once per version
+type Pages struct {
+	store  storage.BlockPageStore
+	logger zerolog.Logger
+
+	mu     sync.Mutex
+	parsed map[string]parsedPage
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// parsedPage is a parsed template and the version it was parsed from
+type parsedPage struct {
+	updatedAt time.Time
+	tmpl      *template.Template
+}
+
+// New creates a block page set backed by store
+func New(store storage.BlockPageStore, logger zerolog.Logger) *Pages {
+	return &Pages{
+		store:  store,
+		logger: logger.With().Str("component", "blockpage").Logger(),
+		parsed: make(map[string]parsedPage),
+		now:    time.Now,
+	}
+}
+
+// Put creates or replaces a template after checking that it renders. It
+// reports whether the template was created.
+func (p *Pages) Put(ctx context.Context, name, text string) (storage.BlockPage, bool, error) {
+	if !namePattern.MatchString(name) {
+		return storage.BlockPage{}, false, ErrInvalidName
+	}
+	tmpl, err := parse(name, text)
+	if err != nil {
+		return storage.BlockPage{}, false, err
+	}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return storage.BlockPage{}, false, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	_, err = p.store.Get(ctx, name)
+	created := errors.Is(err, storage.ErrNotFound)
+	if err != nil && !created {
+		return storage.BlockPage{}, false, err
+	}
+
+	page := storage.BlockPage{Name: name, Template: text, UpdatedAt: p.now()}
+	if err := p.store.Put(ctx, page); err != nil {
+		return storage.BlockPage{}, false, err
+	}
+
+	p.logger.Info().Str("name", name).Bool("created", created).Msg("Block page saved")
+	return page, created, nil
+}
+
+// Get returns a template
+func (p *Pages) Get(ctx context.Context, name string) (*storage.BlockPage, error) {
+	page, err := p.store.Get(ctx, name)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return page, err
+}
+
+// List returns all templates sorted by name
+func (p *Pages) List(ctx context.Context) ([]storage.BlockPage, error) {
+	return p.store.List(ctx)
+}
+
+// Delete removes a template. Policies still naming it get the built-in
+// block page.
+func (p *Pages) Delete(ctx context.Context, name string) error {
+	err := p.store.Delete(ctx, name)
+	if errors.Is(err, storage.ErrNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	delete(p.parsed, name)
+	p.mu.Unlock()
+
+	p.logger.Info().Str("name", name).Msg("Block page deleted")
+	return nil
+}
+
+// Render renders the named template. Nothing is written on error, so the
+// caller can fall back to the built-in page.
+func (p *Pages) Render(ctx context.Context, name string, data Data) ([]byte, error) {
+	page, err := p.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	cached, ok := p.parsed[name]
+	p.mu.Unlock()
+	if !ok || !cached.updatedAt.Equal(page.UpdatedAt) {
+		tmpl, err := parse(name, page.Template)
+		if err != nil {
+			return nil, err
+		}
+		cached = parsedPage{updatedAt: page.UpdatedAt, tmpl: tmpl}
+		p.mu.Lock()
+		p.parsed[name] = cached
+		p.mu.Unlock()
+	}
+
+	var buf bytes.Buffer
+	if err := cached.tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parse parses a template
+func parse(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	return tmpl, nil
+}
+
+// Remaining formats the time left on a usage limit for Data.TimeRemaining
+func Remaining(d time.Duration) string {
+	switch minutes := int(d / time.Minute); {
+	case d <= 0:
+		return ""
+	case minutes == 0:
+		return "less than a minute"
+	case minutes == 1:
+		return "1 minute"
+	default:
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+}
@@ -0,0 +1,59 @@
+package blockpage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestPages(t *testing.T) {
+	ctx := context.Background()
+	p := New(memory.Open().BlockPages(), zerolog.Nop())
+
+	for _, tt := range []struct {
+		name, text string
+		want       error
+	}{
+		{name: "Gaming", text: "<p>blocked</p>", want: ErrInvalidName},
+		{name: "gaming", text: "<p>{{.Reason</p>", want: ErrInvalidTemplate},
+		{name: "gaming", text: "<p>{{.Missing}}</p>", want: ErrInvalidTemplate},
+	} {
+		if _, _, err := p.Put(ctx, tt.name, tt.text); !errors.Is(err, tt.want) {
+			t.Errorf("Put(%q, %q) error = %v, want %v", tt.name, tt.text, err, tt.want)
+		}
+	}
+
+	_, created, err := p.Put(ctx, "gaming", "<p>{{.Reason}} on {{.Host}}, {{.TimeRemaining}} left</p>")
+	if err != nil || !created {
+		t.Fatalf("Put() = %v, %v, want created", created, err)
+	}
+
+	got, err := p.Render(ctx, "gaming", Data{Reason: "<script>", Host: "games.example", TimeRemaining: Remaining(25 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<p>&lt;script&gt; on games.example, 25 minutes left</p>"; string(got) != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	// A replaced template is parsed again
+	p.now = func() time.Time { return time.Now().Add(time.Minute) }
+	if _, created, err := p.Put(ctx, "gaming", "<p>Game over</p>"); err != nil || created {
+		t.Fatalf("Put() again = %v, %v, want replaced", created, err)
+	}
+	if got, _ := p.Render(ctx, "gaming", Data{}); !strings.Contains(string(got), "Game over") {
+		t.Errorf("Render() after replace = %q", got)
+	}
+
+	if err := p.Delete(ctx, "gaming"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Render(ctx, "gaming", Data{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Render() after Delete error = %v, want ErrNotFound", err)
+	}
+}
@@ -1,21 +1,26 @@
 package ca
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/storage"
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/rs/zerolog"
 )
@@ -26,12 +31,34 @@ type CA struct {
 	rootKey       *ecdsa.PrivateKey
 	intermCert    *x509.Certificate
 	intermKey     *ecdsa.PrivateKey
-	certCache     *lru.Cache[string, *tls.Certificate]
+	certCache     *lru.Cache[string, cachedCert]
 	cacheCapacity int
 	cacheTTL      time.Duration
 	certValidity  time.Duration
 	logger        zerolog.Logger
 	mu            sync.RWMutex
+
+	// Where the root and intermediate live, rewritten by Rotate
+	paths Config
+
+	// Leaf keys generated ahead of handshakes (optional)
+	keys *keyPool
+
+	// Certificates being minted, shared by concurrent handshakes for the
+	// same host, and the signing generation, bumped by Rotate so mints
+	// started before a rotation aren't cached
+	inflight   map[string]*mint
+	generation int
+
+	// The signing certificate cross-signed by the previous root after a
+	// root rotation, sent with leaves until it expires so clients still
+	// trusting only the old root keep validating them
+	cross *x509.Certificate
+
+	// Issuance log of minted leaf certificates (optional), and how long
+	// records are kept
+	issued          storage.IssuedCertStore
+	issuedRetention time.Duration
 }
 
 // Config holds CA configuration
@@ -43,6 +70,21 @@ type Config struct {
 	CertCacheSize  int
 	CertCacheTTL   time.Duration
 	CertValidity   time.Duration
+	KeyPoolSize    int // Leaf keys kept generated ahead; 0 generates each on demand
+}
+
+// cachedCert is a minted certificate and when it stops being served from
+// the cache
+type cachedCert struct {
+	cert  *tls.Certificate
+	until time.Time
+}
+
+// mint is a certificate being generated
+type mint struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
 }
 
 // NewCA creates a new Certificate Authority
@@ -51,6 +93,8 @@ func NewCA(config Config, logger zerolog.Logger) (*CA, error) {
 		cacheTTL:     config.CertCacheTTL,
 		certValidity: config.CertValidity,
 		logger:       logger.With().Str("component", "ca").Logger(),
+		paths:        config,
+		inflight:     make(map[string]*mint),
 	}
 
 	// Check if intermediate certificate exists first
@@ -74,7 +118,7 @@ func NewCA(config Config, logger zerolog.Logger) (*CA, error) {
 		} else {
 			// Neither root nor intermediate exists - generate both for simple setup
 			ca.logger.Warn().Err(err).Msg("Root CA certificate not found, generating new certificate")
-			rootCert, rootKey, err = generateRootCA(config.RootCertPath, config.RootKeyPath, ca.logger)
+			rootCert, rootKey, err = generateRootCA(config.RootCertPath, config.RootKeyPath, nil, ca.logger)
 			if err != nil {
 				return nil, fmt.Errorf("failed to generate root certificate: %w", err)
 			}
@@ -126,13 +170,19 @@ func NewCA(config Config, logger zerolog.Logger) (*CA, error) {
 		ca.intermKey = ca.rootKey
 	}
 
+	// Keep serving the cross-signed chain of an unfinished rotation
+	ca.cross = loadCross(ca.crossPath(), ca.intermCert)
+
 	// Create certificate cache
-	cache, err := lru.New[string, *tls.Certificate](config.CertCacheSize)
+	cache, err := lru.New[string, cachedCert](config.CertCacheSize)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create certificate cache: %w", err)
 	}
 	ca.certCache = cache
 	ca.cacheCapacity = config.CertCacheSize
+	if config.KeyPoolSize > 0 {
+		ca.keys = newKeyPool(config.KeyPoolSize, ca.logger)
+	}
 
 	// Log initialization - use struct fields which are correctly set
 	rootSubject := "none (external PKI)"
@@ -156,40 +206,72 @@ func (ca *CA) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, erro
 		return nil, fmt.Errorf("no SNI hostname provided")
 	}
 
-	ca.mu.RLock()
+	ca.mu.Lock()
 	// Check cache
-	if cert, ok := ca.certCache.Get(hostname); ok {
-		ca.mu.RUnlock()
+	if cached, ok := ca.certCache.Get(hostname); ok && time.Now().Before(cached.until) {
+		ca.mu.Unlock()
 		ca.logger.Debug().Str("hostname", hostname).Msg("Certificate cache hit")
 		metrics.CertificateCacheHits.Inc()
-		return cert, nil
+		return cached.cert, nil
 	}
-	ca.mu.RUnlock()
+	// A browser opens several connections at once; mint only once
+	if m, ok := ca.inflight[hostname]; ok {
+		ca.mu.Unlock()
+		<-m.done
+		metrics.CertificateCacheHits.Inc()
+		return m.cert, m.err
+	}
+	m := &mint{done: make(chan struct{})}
+	ca.inflight[hostname] = m
+	generation := ca.generation
+	ca.mu.Unlock()
 
 	// Cache miss - generate new certificate
 	metrics.CertificateCacheMisses.Inc()
 
 	ca.logger.Info().Str("hostname", hostname).Msg("Generating new certificate")
-	cert, err := ca.generateCertificate(hostname)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate certificate for %s: %w", hostname, err)
+	start := time.Now()
+	m.cert, m.err = ca.generateCertificate(hostname)
+	metrics.CertificateGenerationDuration.Observe(time.Since(start).Seconds())
+
+	ca.mu.Lock()
+	delete(ca.inflight, hostname)
+	if m.err == nil && generation == ca.generation {
+		// Cache certificate
+		if ca.certCache.Add(hostname, cachedCert{cert: m.cert, until: ca.cacheUntil(m.cert.Leaf)}) {
+			metrics.CertificateCacheEvictions.Inc()
+		}
+	}
+	ca.mu.Unlock()
+	close(m.done)
+
+	if m.err != nil {
+		return nil, fmt.Errorf("failed to generate certificate for %s: %w", hostname, m.err)
 	}
 
 	// Record certificate generation
 	metrics.CertificatesGenerated.Inc()
+	ca.recordIssued(hostname, m.cert.Leaf, hello)
 
-	// Cache certificate
-	ca.mu.Lock()
-	ca.certCache.Add(hostname, cert)
-	ca.mu.Unlock()
+	return m.cert, nil
+}
 
-	return cert, nil
+// cacheUntil is when a minted leaf stops being served from the cache:
+// after the cache TTL, and before the last quarter of its validity (an hour
+// at most), so clients never get a certificate about to lapse
+func (ca *CA) cacheUntil(leaf *x509.Certificate) time.Time {
+	margin := min(time.Hour, ca.certValidity/4)
+	until := leaf.NotAfter.Add(-margin)
+	if ca.cacheTTL > 0 && time.Now().Add(ca.cacheTTL).Before(until) {
+		until = time.Now().Add(ca.cacheTTL)
+	}
+	return until
 }
 
 // generateCertificate generates a new certificate for the given hostname
 func (ca *CA) generateCertificate(hostname string) (*tls.Certificate, error) {
-	// Generate key pair
-	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	// Take a key pair from the pool, or generate one
+	privKey, err := ca.keys.get()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate private key: %w", err)
 	}
@@ -213,16 +295,25 @@ func (ca *CA) generateCertificate(hostname string) (*tls.Certificate, error) {
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
-		DNSNames:              []string{hostname},
 	}
+	// Clients connecting by address without SNI get an IP certificate
+	if ip := net.ParseIP(hostname); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{hostname}
+	}
+
+	ca.mu.RLock()
+	rootCert, intermCert, intermKey, cross := ca.rootCert, ca.intermCert, ca.intermKey, ca.cross
+	ca.mu.RUnlock()
 
 	// Sign certificate with intermediate CA
 	certDER, err := x509.CreateCertificate(
 		rand.Reader,
 		template,
-		ca.intermCert,
+		intermCert,
 		&privKey.PublicKey,
-		ca.intermKey,
+		intermKey,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create certificate: %w", err)
@@ -242,23 +333,68 @@ func (ca *CA) generateCertificate(hostname string) (*tls.Certificate, error) {
 	}
 
 	// Include intermediate cert in chain if different from root
-	if ca.intermCert != ca.rootCert {
-		tlsCert.Certificate = append(tlsCert.Certificate, ca.intermCert.Raw)
+	if intermCert != rootCert {
+		tlsCert.Certificate = append(tlsCert.Certificate, intermCert.Raw)
+	}
+	// During a rotation's grace window, also the path to the previous root
+	if cross != nil && now.Before(cross.NotAfter) {
+		tlsCert.Certificate = append(tlsCert.Certificate, cross.Raw)
 	}
 
 	return tlsCert, nil
 }
 
+// SetIssuanceLog records every leaf certificate minted from now on in
+// store, keeping records for retention
+func (ca *CA) SetIssuanceLog(store storage.IssuedCertStore, retention time.Duration) {
+	ca.issued = store
+	ca.issuedRetention = retention
+}
+
+// recordIssued adds a minted certificate to the issuance log, with the
+// client whose handshake it was minted for
+func (ca *CA) recordIssued(hostname string, leaf *x509.Certificate, hello *tls.ClientHelloInfo) {
+	if ca.issued == nil {
+		return
+	}
+
+	var client string
+	if hello.Conn != nil && hello.Conn.RemoteAddr() != nil {
+		client, _, _ = net.SplitHostPort(hello.Conn.RemoteAddr().String())
+	}
+	fingerprint := sha256.Sum256(leaf.Raw)
+	now := time.Now()
+	record := storage.IssuedCert{
+		Serial:      leaf.SerialNumber.Text(16),
+		Host:        hostname,
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		Client:      client,
+		Issued:      now,
+		ExpiresAt:   now.Add(ca.issuedRetention),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := ca.issued.Add(ctx, record); err != nil {
+		ca.logger.Error().Err(err).Str("hostname", hostname).Str("serial", record.Serial).Msg("Failed to record issued certificate")
+	}
+}
+
 // GetRootCertPEM returns the root CA certificate in PEM format
 func (ca *CA) GetRootCertPEM() ([]byte, error) {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
 	return pem.EncodeToMemory(&pem.Block{
 		Type:  "CERTIFICATE",
 		Bytes: ca.rootCert.Raw,
 	}), nil
 }
 
-// generateRootCA generates a new root CA certificate and private key
-func generateRootCA(certPath, keyPath string, logger zerolog.Logger) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+// generateRootCA generates a new root CA certificate and private key. With
+// permitted, the root may only sign for names in those domains.
+func generateRootCA(certPath, keyPath string, permitted []string, logger zerolog.Logger) (*x509.Certificate, *ecdsa.PrivateKey, error) {
 	// Generate private key
 	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
 	if err != nil {
@@ -288,7 +424,11 @@ func generateRootCA(certPath, keyPath string, logger zerolog.Logger) (*x509.Cert
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 		MaxPathLenZero:        false,
-		MaxPathLen:            2,
+		MaxPathLen:            1, // Root, intermediate, leaf
+	}
+	if len(permitted) > 0 {
+		template.PermittedDNSDomains = permitted
+		template.PermittedDNSDomainsCritical = true
 	}
 
 	// Create self-signed certificate
@@ -355,8 +495,10 @@ func generateRootCA(certPath, keyPath string, logger zerolog.Logger) (*x509.Cert
 
 // generateIntermediateCA generates a new intermediate CA certificate signed by the root CA
 func generateIntermediateCA(certPath, keyPath string, rootCert *x509.Certificate, rootKey *ecdsa.PrivateKey, logger zerolog.Logger) (*x509.Certificate, *ecdsa.PrivateKey, error) {
-	// Generate private key
-	privateKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	// Generate private key. P-256, since the intermediate signs a leaf in
+	// each handshake that misses the cache, and P-256 signs several times
+	// faster than P-384.
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to generate private key: %w", err)
 	}
@@ -383,8 +525,8 @@ func generateIntermediateCA(certPath, keyPath string, rootCert *x509.Certificate
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		MaxPathLenZero:        false,
-		MaxPathLen:            1,
+		MaxPathLenZero:        true, // Signs leaves only
+		MaxPathLen:            0,
 	}
 
 	// Create certificate signed by root
@@ -501,9 +643,15 @@ func (ca *CA) ClearCache() {
 	ca.mu.Lock()
 	defer ca.mu.Unlock()
 	ca.certCache.Purge()
+	ca.generation++
 	ca.logger.Info().Msg("Certificate cache cleared")
 }
 
+// Close stops generating pooled keys
+func (ca *CA) Close() {
+	ca.keys.close()
+}
+
 // CacheStats returns certificate cache statistics
 func (ca *CA) CacheStats() (size, capacity int) {
 	ca.mu.RLock()
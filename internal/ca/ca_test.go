@@ -0,0 +1,343 @@
+package ca
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestIssuanceLog(t *testing.T) {
+	dir := t.TempDir()
+	authority, err := NewCA(Config{
+		RootCertPath:  filepath.Join(dir, "root-ca.crt"),
+		RootKeyPath:   filepath.Join(dir, "root-ca.key"),
+		CertCacheSize: 10,
+		CertCacheTTL:  time.Hour,
+		CertValidity:  24 * time.Hour,
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := memory.Open().IssuedCerts()
+	authority.SetIssuanceLog(issued, time.Hour)
+
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	defer func() { _ = server.Close() }()
+	hello := &tls.ClientHelloInfo{ServerName: "example.com", Conn: server}
+	cert, err := authority.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cached certificate isn't minted again
+	if _, err := authority.GetCertificate(hello); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := issued.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("recorded %d certificates, want 1", len(records))
+	}
+	fingerprint := sha256.Sum256(cert.Leaf.Raw)
+	got := records[0]
+	if got.Host != "example.com" || got.Serial != cert.Leaf.SerialNumber.Text(16) || got.Fingerprint != hex.EncodeToString(fingerprint[:]) || !got.NotAfter.Equal(cert.Leaf.NotAfter) {
+		t.Errorf("record = %+v, want the minted certificate", got)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		RootCertPath:   filepath.Join(dir, "root-ca.crt"),
+		RootKeyPath:    filepath.Join(dir, "root-ca.key"),
+		IntermCertPath: filepath.Join(dir, "intermediate-ca.crt"),
+		IntermKeyPath:  filepath.Join(dir, "intermediate-ca.key"),
+		CertCacheSize:  10,
+		CertCacheTTL:   time.Hour,
+		CertValidity:   24 * time.Hour,
+	}
+	authority, err := NewCA(config, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// verify checks a served chain against the given roots
+	verify := func(cert *tls.Certificate, roots ...*x509.Certificate) error {
+		pool := x509.NewCertPool()
+		for _, root := range roots {
+			pool.AddCert(root)
+		}
+		intermediates := x509.NewCertPool()
+		for _, der := range cert.Certificate[1:] {
+			c, err := x509.ParseCertificate(der)
+			if err != nil {
+				return err
+			}
+			intermediates.AddCert(c)
+		}
+		_, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: pool, Intermediates: intermediates})
+		return err
+	}
+	hello := &tls.ClientHelloInfo{ServerName: "example.com"}
+	first, err := authority.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRoot, oldInterm := authority.rootCert, authority.intermCert
+
+	// A new intermediate under the same root
+	rotation, err := authority.Rotate(false, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotation.Root || rotation.GraceUntil != nil || rotation.InvalidatedLeaves != 1 {
+		t.Errorf("rotation = %+v, want an intermediate rotation invalidating 1 leaf", rotation)
+	}
+	if _, err := os.Stat(config.IntermCertPath + "." + rotation.Backup); err != nil {
+		t.Errorf("previous intermediate not kept: %v", err)
+	}
+	second, err := authority.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first || authority.intermCert.Equal(oldInterm) || !authority.rootCert.Equal(oldRoot) {
+		t.Fatal("intermediate rotation didn't re-sign with a new intermediate under the same root")
+	}
+	if err := verify(second, oldRoot); err != nil {
+		t.Errorf("leaf after intermediate rotation: %v", err)
+	}
+
+	// A new root, with the old one trusted for a grace window
+	rotation, err = authority.Rotate(true, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotation.Root || rotation.GraceUntil == nil || authority.rootCert.Equal(oldRoot) {
+		t.Fatalf("rotation = %+v, want a root rotation with a grace window", rotation)
+	}
+	third, err := authority.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(third, authority.rootCert); err != nil {
+		t.Errorf("leaf against the new root: %v", err)
+	}
+	if err := verify(third, oldRoot); err != nil {
+		t.Errorf("leaf against the old root during the grace window: %v", err)
+	}
+
+	// A restart keeps serving the cross-signed chain
+	reloaded, err := NewCA(config, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := reloaded.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(fourth, oldRoot); err != nil {
+		t.Errorf("leaf after restart against the old root: %v", err)
+	}
+
+	// Without a grace window, the old root is dropped at once
+	if _, err := authority.Rotate(true, 0); err != nil {
+		t.Fatal(err)
+	}
+	fifth, err := authority.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(fifth, oldRoot); err == nil {
+		t.Error("leaf still validates against the old root without a grace window")
+	}
+}
+
+func TestInit(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		RootCertPath:   filepath.Join(dir, "ca", "root-ca.crt"),
+		RootKeyPath:    filepath.Join(dir, "ca", "root-ca.key"),
+		IntermCertPath: filepath.Join(dir, "ca", "intermediate-ca.crt"),
+		IntermKeyPath:  filepath.Join(dir, "ca", "intermediate-ca.key"),
+		CertCacheSize:  10,
+		CertValidity:   24 * time.Hour,
+	}
+
+	if _, _, err := Init(config, InitOptions{PermittedDomains: []string{"example.com"}}, zerolog.Nop()); err == nil {
+		t.Error("Init() accepted a name constraint under a public suffix")
+	}
+
+	root, intermediate, err := Init(config, InitOptions{PermittedDomains: []string{"LAN.", "home.arpa"}}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.MaxPathLen != 1 || !intermediate.MaxPathLenZero || intermediate.MaxPathLen != 0 {
+		t.Errorf("path lengths = %d, %d, want 1 for the root and 0 for the intermediate", root.MaxPathLen, intermediate.MaxPathLen)
+	}
+	if strings.Join(root.PermittedDNSDomains, ",") != "lan,home.arpa" {
+		t.Errorf("permitted domains = %v, want [lan home.arpa]", root.PermittedDNSDomains)
+	}
+	for _, path := range []string{config.RootKeyPath, config.IntermKeyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s mode = %v, want 0600", filepath.Base(path), info.Mode().Perm())
+		}
+	}
+
+	// The CA signs for the permitted names only
+	authority, err := NewCA(config, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+	for _, tt := range []struct {
+		host string
+		want bool
+	}{
+		{"printer.lan", true},
+		{"example.com", false},
+	} {
+		cert, err := authority.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		intermediates := x509.NewCertPool()
+		intermediates.AddCert(intermediate)
+		_, err = cert.Leaf.Verify(x509.VerifyOptions{DNSName: tt.host, Roots: pool, Intermediates: intermediates})
+		if got := err == nil; got != tt.want {
+			t.Errorf("%s: valid = %v (%v), want %v", tt.host, got, err, tt.want)
+		}
+	}
+
+	// Existing files are kept unless forced
+	if _, _, err := Init(config, InitOptions{}, zerolog.Nop()); !errors.Is(err, ErrExists) {
+		t.Errorf("Init() over existing files = %v, want ErrExists", err)
+	}
+	replaced, _, err := Init(config, InitOptions{Force: true}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.Equal(root) || len(replaced.PermittedDNSDomains) != 0 {
+		t.Error("forced Init() didn't replace the root")
+	}
+}
+
+func TestCertificateCache(t *testing.T) {
+	dir := t.TempDir()
+	authority, err := NewCA(Config{
+		RootCertPath:  filepath.Join(dir, "root-ca.crt"),
+		RootKeyPath:   filepath.Join(dir, "root-ca.key"),
+		CertCacheSize: 10,
+		CertCacheTTL:  50 * time.Millisecond,
+		CertValidity:  24 * time.Hour,
+		KeyPoolSize:   4,
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer authority.Close()
+	issued := memory.Open().IssuedCerts()
+	authority.SetIssuanceLog(issued, time.Hour)
+
+	// Concurrent handshakes for a host share one mint
+	hello := &tls.ClientHelloInfo{ServerName: "example.com"}
+	certs := make(chan *tls.Certificate, 8)
+	for range cap(certs) {
+		go func() {
+			cert, err := authority.GetCertificate(hello)
+			if err != nil {
+				t.Error(err)
+			}
+			certs <- cert
+		}()
+	}
+	first := <-certs
+	for range cap(certs) - 1 {
+		if cert := <-certs; cert != first {
+			t.Fatal("concurrent handshakes got different certificates")
+		}
+	}
+	if _, ok := first.PrivateKey.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("leaf key is %T, want ECDSA", first.PrivateKey)
+	}
+	records, err := issued.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("minted %d certificates, want 1", len(records))
+	}
+
+	// After the cache TTL the host is minted again
+	time.Sleep(60 * time.Millisecond)
+	again, err := authority.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == first {
+		t.Error("certificate served from the cache after its TTL")
+	}
+}
+
+func BenchmarkGetCertificate(b *testing.B) {
+	for _, bb := range []struct {
+		name     string
+		poolSize int
+		hit      bool
+	}{
+		{"mint", 0, false},
+		{"mint-pooled", 64, false},
+		{"hit", 0, true},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			dir := b.TempDir()
+			authority, err := NewCA(Config{
+				RootCertPath:   filepath.Join(dir, "root-ca.crt"),
+				RootKeyPath:    filepath.Join(dir, "root-ca.key"),
+				IntermCertPath: filepath.Join(dir, "intermediate-ca.crt"),
+				IntermKeyPath:  filepath.Join(dir, "intermediate-ca.key"),
+				CertCacheSize:  1000,
+				CertCacheTTL:   time.Hour,
+				CertValidity:   24 * time.Hour,
+				KeyPoolSize:    bb.poolSize,
+			}, zerolog.Nop())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer authority.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				name := "example.com"
+				if !bb.hit {
+					name = fmt.Sprintf("host%d.example.com", i)
+				}
+				if _, err := authority.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package ca
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/net/publicsuffix"
+)
+
+// ErrExists is returned by Init when CA files are already in place
+var ErrExists = errors.New("CA files already exist")
+
+// InitOptions controls how Init creates a CA
+type InitOptions struct {
+	// Replace existing files
+	Force bool
+
+	// Domains the root may sign for (name constraints). Empty means any
+	// name; otherwise only non-public suffixes such as "lan" or
+	// "home.arpa" are accepted.
+	PermittedDomains []string
+}
+
+// Init generates a root CA and, when the config has intermediate paths, an
+// intermediate signed by it, and writes them to the configured paths. Keys
+// are written readable by their owner only.
+func Init(config Config, opts InitOptions, logger zerolog.Logger) (root, intermediate *x509.Certificate, err error) {
+	permitted := make([]string, 0, len(opts.PermittedDomains))
+	for _, domain := range opts.PermittedDomains {
+		domain = strings.Trim(strings.ToLower(domain), ".")
+		if err := checkPrivateSuffix(domain); err != nil {
+			return nil, nil, err
+		}
+		permitted = append(permitted, domain)
+	}
+
+	files := []string{config.RootCertPath, config.RootKeyPath}
+	if config.IntermCertPath != "" && config.IntermKeyPath != "" {
+		files = append(files, config.IntermCertPath, config.IntermKeyPath)
+	}
+	for _, path := range files {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if !opts.Force {
+			return nil, nil, fmt.Errorf("%w: %s", ErrExists, path)
+		}
+		// Removed rather than truncated, so keys get fresh permissions
+		if err := os.Remove(path); err != nil {
+			return nil, nil, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	root, rootKey, err := generateRootCA(config.RootCertPath, config.RootKeyPath, permitted, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate root certificate: %w", err)
+	}
+	if len(files) == 2 {
+		return root, nil, nil
+	}
+	intermediate, _, err = generateIntermediateCA(config.IntermCertPath, config.IntermKeyPath, root, rootKey, logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate intermediate certificate: %w", err)
+	}
+	return root, intermediate, nil
+}
+
+// checkPrivateSuffix rejects a name constraint domain that can be
+// registered publicly: a CA limited to it could still sign for real sites.
+func checkPrivateSuffix(domain string) error {
+	if domain == "" {
+		return fmt.Errorf("empty permitted domain")
+	}
+	// Reserved for home networks (RFC 8375), under the public "arpa"
+	if domain == "home.arpa" || strings.HasSuffix(domain, ".home.arpa") {
+		return nil
+	}
+	if _, icann := publicsuffix.PublicSuffix(domain); icann {
+		return fmt.Errorf("permitted domain %q is under a public suffix", domain)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// keyPool keeps ECDSA P-256 leaf keys generated ahead of time, so minting
+// a certificate during a handshake only signs. On slow hardware key
+// generation is a noticeable part of a mint. A nil pool generates each key
+// on demand.
+type keyPool struct {
+	keys      chan *ecdsa.PrivateKey
+	stop      chan struct{}
+	closeOnce sync.Once
+	logger    zerolog.Logger
+}
+
+// newKeyPool starts filling a pool of size keys in the background
+func newKeyPool(size int, logger zerolog.Logger) *keyPool {
+	p := &keyPool{
+		keys:   make(chan *ecdsa.PrivateKey, size),
+		stop:   make(chan struct{}),
+		logger: logger,
+	}
+	go p.fill()
+	return p
+}
+
+// fill generates keys whenever the pool has room
+func (p *keyPool) fill() {
+	for {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			p.logger.Error().Err(err).Msg("Failed to generate pooled key")
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-p.stop:
+				return
+			}
+		}
+		select {
+		case p.keys <- key:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// get takes a key from the pool, generating one if the pool is empty
+func (p *keyPool) get() (*ecdsa.PrivateKey, error) {
+	if p != nil {
+		select {
+		case key := <-p.keys:
+			return key, nil
+		default:
+		}
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// close stops filling the pool
+func (p *keyPool) close() {
+	if p == nil {
+		return
+	}
+	p.closeOnce.Do(func() { close(p.stop) })
+}
@@ -0,0 +1,107 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"text/template"
+)
+
+// RootCertificate returns the root CA certificate devices install, nil
+// with an external PKI
+func (ca *CA) RootCertificate() *x509.Certificate {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+	return ca.rootCert
+}
+
+// mobileConfigTemplate is an Apple configuration profile with a single
+// trusted root payload
+var mobileConfigTemplate = template.Must(template.New("mobileconfig").Funcs(template.FuncMap{
+	"xml": func(s string) (string, error) {
+		var b bytes.Buffer
+		err := xml.EscapeText(&b, []byte(s))
+		return b.String(), err
+	},
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadCertificateFileName</key>
+			<string>kproxy-root-ca.crt</string>
+			<key>PayloadContent</key>
+			<data>{{.Certificate}}</data>
+			<key>PayloadDescription</key>
+			<string>Adds the KProxy root certificate</string>
+			<key>PayloadDisplayName</key>
+			<string>{{xml .Subject}}</string>
+			<key>PayloadIdentifier</key>
+			<string>{{xml .Identifier}}.certificate</string>
+			<key>PayloadType</key>
+			<string>com.apple.security.root</string>
+			<key>PayloadUUID</key>
+			<string>{{.CertificateUUID}}</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+		</dict>
+	</array>
+	<key>PayloadDescription</key>
+	<string>Trusts the certificates KProxy on {{xml .ServerName}} presents for the sites it filters.</string>
+	<key>PayloadDisplayName</key>
+	<string>KProxy Root Certificate</string>
+	<key>PayloadIdentifier</key>
+	<string>{{xml .Identifier}}</string>
+	<key>PayloadOrganization</key>
+	<string>KProxy</string>
+	<key>PayloadRemovalDisallowed</key>
+	<false/>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>{{.ProfileUUID}}</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>
+`))
+
+// MobileConfig returns an unsigned Apple configuration profile installing
+// cert as a trusted root on iOS, iPadOS and macOS. The profile identifier
+// depends only on serverName, so installing the profile of a rotated root
+// replaces the old one; the UUIDs are derived from the certificate.
+func MobileConfig(cert *x509.Certificate, serverName string) ([]byte, error) {
+	var b bytes.Buffer
+	err := mobileConfigTemplate.Execute(&b, struct {
+		Certificate     string
+		Subject         string
+		ServerName      string
+		Identifier      string
+		CertificateUUID string
+		ProfileUUID     string
+	}{
+		Certificate:     base64.StdEncoding.EncodeToString(cert.Raw),
+		Subject:         cert.Subject.CommonName,
+		ServerName:      serverName,
+		Identifier:      "kproxy.root-ca." + serverName,
+		CertificateUUID: payloadUUID(cert.Raw, "certificate"),
+		ProfileUUID:     payloadUUID(cert.Raw, "profile"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render configuration profile: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// payloadUUID derives a version 4 style UUID from a certificate and a label
+func payloadUUID(der []byte, label string) string {
+	sum := sha256.Sum256(append([]byte(label+":"), der...))
+	sum[6] = sum[6]&0x0f | 0x40
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
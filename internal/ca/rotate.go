@@ -0,0 +1,221 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// ErrExternalPKI is returned when rotating a CA whose root key KProxy
+// doesn't hold
+var ErrExternalPKI = errors.New("root CA key not available (external PKI)")
+
+// ErrNoIntermediate is returned when rotating only the intermediate of a CA
+// that signs with its root
+var ErrNoIntermediate = errors.New("no intermediate CA configured; rotate the root instead")
+
+// DefaultGrace is how long the old root stays trusted after a root rotation
+// when no grace window is given
+const DefaultGrace = 7 * 24 * time.Hour
+
+// Rotation describes a completed CA rotation
+type Rotation struct {
+	Root               bool       `json:"root"` // Whether a new root was generated
+	RootSubject        string     `json:"root_subject"`
+	RootFingerprint    string     `json:"root_fingerprint"`              // SHA-256, hex
+	Intermediate       string     `json:"intermediate,omitempty"`        // Subject of the new intermediate
+	IntermediateSerial string     `json:"intermediate_serial,omitempty"` // Hex
+	GraceUntil         *time.Time `json:"grace_until,omitempty"`         // Old root trusted until
+	Backup             string     `json:"backup"`                        // Suffix of the previous files
+	InvalidatedLeaves  int        `json:"invalidated_leaves"`
+}
+
+// Rotate replaces the signing CA without a restart. A new intermediate is
+// generated and signed by the root; with newRoot a new root is generated
+// first, with the old root's name constraints. The previous files are kept beside the new ones with a timestamp
+// suffix, and cached leaf certificates are dropped so every host is
+// re-signed on its next handshake.
+//
+// After a root rotation clients must install the new root. For grace, the
+// new signing certificate is also cross-signed by the old root and sent
+// with every leaf, so clients still trusting only the old root keep
+// validating. Leaves signed by a replaced intermediate stay valid until
+// they expire.
+func (ca *CA) Rotate(newRoot bool, grace time.Duration) (*Rotation, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if ca.rootKey == nil {
+		return nil, ErrExternalPKI
+	}
+	hasInterm := ca.intermCert != ca.rootCert
+	if !hasInterm && !newRoot {
+		return nil, ErrNoIntermediate
+	}
+
+	// Keep the previous files, restoring them if generation fails
+	suffix := time.Now().UTC().Format("20060102T150405Z")
+	var files []string
+	if newRoot {
+		files = append(files, ca.paths.RootCertPath, ca.paths.RootKeyPath)
+	}
+	if hasInterm {
+		files = append(files, ca.paths.IntermCertPath, ca.paths.IntermKeyPath)
+	}
+	var moved []string
+	restore := func() {
+		for _, path := range moved {
+			_ = os.Rename(path+"."+suffix, path)
+		}
+	}
+	for _, path := range files {
+		if err := os.Rename(path, path+"."+suffix); err != nil {
+			restore()
+			return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		moved = append(moved, path)
+	}
+
+	oldRoot, oldRootKey := ca.rootCert, ca.rootKey
+	rootCert, rootKey := oldRoot, oldRootKey
+	if newRoot {
+		var err error
+		rootCert, rootKey, err = generateRootCA(ca.paths.RootCertPath, ca.paths.RootKeyPath, oldRoot.PermittedDNSDomains, ca.logger)
+		if err != nil {
+			restore()
+			return nil, fmt.Errorf("failed to generate root certificate: %w", err)
+		}
+	}
+	intermCert, intermKey := rootCert, rootKey
+	if hasInterm {
+		var err error
+		intermCert, intermKey, err = generateIntermediateCA(ca.paths.IntermCertPath, ca.paths.IntermKeyPath, rootCert, rootKey, ca.logger)
+		if err != nil {
+			restore()
+			return nil, fmt.Errorf("failed to generate intermediate certificate: %w", err)
+		}
+	}
+
+	var cross *x509.Certificate
+	var graceUntil *time.Time
+	if newRoot && grace > 0 {
+		until := time.Now().Add(grace)
+		if until.After(oldRoot.NotAfter) {
+			until = oldRoot.NotAfter
+		}
+		graceUntil = &until
+		var err error
+		cross, err = crossSign(intermCert, oldRoot, oldRootKey, until, ca.crossPath())
+		if err != nil {
+			restore()
+			return nil, err
+		}
+	} else {
+		_ = os.Remove(ca.crossPath())
+	}
+
+	ca.rootCert, ca.rootKey = rootCert, rootKey
+	ca.intermCert, ca.intermKey = intermCert, intermKey
+	ca.cross = cross
+	invalidated := ca.certCache.Len()
+	ca.certCache.Purge()
+	ca.generation++
+
+	fingerprint := sha256.Sum256(rootCert.Raw)
+	rotation := &Rotation{
+		Root:              newRoot,
+		RootSubject:       rootCert.Subject.CommonName,
+		RootFingerprint:   hex.EncodeToString(fingerprint[:]),
+		GraceUntil:        graceUntil,
+		Backup:            suffix,
+		InvalidatedLeaves: invalidated,
+	}
+	if hasInterm {
+		rotation.Intermediate = intermCert.Subject.CommonName
+		rotation.IntermediateSerial = intermCert.SerialNumber.Text(16)
+	}
+
+	event := ca.logger.Warn().
+		Bool("root", newRoot).
+		Str("root_fingerprint", rotation.RootFingerprint).
+		Str("intermediate_serial", rotation.IntermediateSerial)
+	if graceUntil != nil {
+		event = event.Time("grace_until", *graceUntil)
+	}
+	event.Int("invalidated_leaves", invalidated).Msg("Certificate Authority rotated")
+
+	return rotation, nil
+}
+
+// crossPath is where the cross-signed certificate of a root rotation is kept
+func (ca *CA) crossPath() string {
+	if ca.paths.IntermCertPath != "" && ca.paths.IntermKeyPath != "" {
+		return ca.paths.IntermCertPath + ".cross"
+	}
+	return ca.paths.RootCertPath + ".cross"
+}
+
+// crossSign issues cert again, same subject and key, signed by the previous
+// root and valid until the end of the grace window, and saves it to path
+func crossSign(cert, oldRoot *x509.Certificate, oldRootKey *ecdsa.PrivateKey, until time.Time, path string) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		RawSubject:            cert.RawSubject,
+		SubjectKeyId:          cert.SubjectKeyId,
+		NotBefore:             time.Now(),
+		NotAfter:              until,
+		KeyUsage:              cert.KeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            cert.MaxPathLen,
+		MaxPathLenZero:        cert.MaxPathLenZero,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, oldRoot, cert.PublicKey, oldRootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cross-sign certificate: %w", err)
+	}
+	cross, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write cross-signed certificate: %w", err)
+	}
+	return cross, nil
+}
+
+// loadCross loads the cross-signed certificate left by a root rotation. It
+// returns nil when there is none, its grace window has ended, or it
+// doesn't belong to the current signing certificate.
+func loadCross(path string, signing *x509.Certificate) *x509.Certificate {
+	certPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil
+	}
+	cross, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || !time.Now().Before(cross.NotAfter) {
+		return nil
+	}
+	if key, ok := cross.PublicKey.(*ecdsa.PublicKey); !ok || !key.Equal(signing.PublicKey) {
+		return nil
+	}
+	return cross
+}
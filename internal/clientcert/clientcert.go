@@ -0,0 +1,128 @@
+package clientcert
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrNotFound is returned for a host that isn't bypassed
+var ErrNotFound = errors.New("host not bypassed")
+
+// Host is an origin that asked for a client certificate while KProxy
+// intercepted a connection to it
+type Host struct {
+	Host     string    `json:"host"`
+	Client   string    `json:"client"` // Address of the client whose request found it
+	Detected time.Time `json:"detected"`
+	Until    time.Time `json:"until"`
+}
+
+// Cache holds the hosts found to want a client certificate (mutual TLS:
+// corporate VPN portals, smart-card sites). KProxy can't present the
+// client's certificate when it intercepts, so the handshake fails; until
+// an entry expires, allowed connections to the host are tunnelled to the
+// origin instead, and the client presents its own certificate. Entries are
+// kept in memory only.
+type Cache struct {
+	ttl    time.Duration
+	logger zerolog.Logger
+
+	mu    sync.Mutex
+	hosts map[string]Host
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates an empty cache keeping hosts for ttl
+func New(ttl time.Duration, logger zerolog.Logger) *Cache {
+	return &Cache{
+		ttl:    ttl,
+		logger: logger.With().Str("component", "clientcert").Logger(),
+		hosts:  make(map[string]Host),
+		now:    time.Now,
+	}
+}
+
+// Add records that host asked for a client certificate on a request from
+// client, restarting its expiry if it was already known
+func (c *Cache) Add(host, client string) Host {
+	host = normalize(host)
+	now := c.now()
+	entry := Host{Host: host, Client: client, Detected: now, Until: now.Add(c.ttl)}
+
+	c.mu.Lock()
+	c.hosts[host] = entry
+	c.mu.Unlock()
+
+	c.logger.Warn().
+		Str("host", host).
+		Str("client", client).
+		Time("until", entry.Until).
+		Msg("Origin requested a client certificate; bypassing interception")
+	return entry
+}
+
+// Contains reports whether connections to host should bypass interception.
+// A nil cache contains nothing.
+func (c *Cache) Contains(host string) bool {
+	if c == nil {
+		return false
+	}
+	host = normalize(host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.hosts[host]
+	if ok && !c.now().Before(entry.Until) {
+		delete(c.hosts, host)
+		return false
+	}
+	return ok
+}
+
+// List returns the hosts bypassed now, by name
+func (c *Cache) List() []Host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	hosts := make([]Host, 0, len(c.hosts))
+	for name, entry := range c.hosts {
+		if !now.Before(entry.Until) {
+			delete(c.hosts, name)
+			continue
+		}
+		hosts = append(hosts, entry)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
+
+// Remove stops bypassing a host, so it is intercepted again
+func (c *Cache) Remove(host string) error {
+	host = normalize(host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.hosts[host]
+	if !ok || !c.now().Before(entry.Until) {
+		delete(c.hosts, host)
+		return fmt.Errorf("%w: %s", ErrNotFound, host)
+	}
+	delete(c.hosts, host)
+	return nil
+}
+
+// normalize lower-cases a host name and drops a trailing dot
+func normalize(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,46 @@
+package clientcert
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestCache(t *testing.T) {
+	c := New(time.Hour, zerolog.Nop())
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	c.Add("VPN.example.com.", "192.168.1.20")
+	c.Add("smartcard.example.org", "192.168.1.21")
+
+	if !c.Contains("vpn.example.com") {
+		t.Error("Contains() = false for a host just added")
+	}
+	if c.Contains("example.com") {
+		t.Error("Contains() = true for a host never added")
+	}
+	if hosts := c.List(); len(hosts) != 2 || hosts[0].Host != "smartcard.example.org" || hosts[1].Until != now.Add(time.Hour) {
+		t.Errorf("List() = %+v, want both hosts by name", hosts)
+	}
+
+	if err := c.Remove("smartcard.example.org"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := c.Remove("smartcard.example.org"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Remove() again = %v, want ErrNotFound", err)
+	}
+
+	// Entries expire, so a host that stops asking is intercepted again
+	now = now.Add(time.Hour)
+	if c.Contains("vpn.example.com") || len(c.List()) != 0 {
+		t.Error("host still bypassed after its entry expired")
+	}
+
+	var nilCache *Cache
+	if nilCache.Contains("vpn.example.com") {
+		t.Error("nil cache contains a host")
+	}
+}
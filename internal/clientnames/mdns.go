@@ -0,0 +1,40 @@
+package clientnames
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// mdnsGroup is the IPv4 multicast DNS group and port
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// listenMDNS joins the multicast DNS group. The socket is shared, so
+// Avahi or another responder on the server keeps working.
+func listenMDNS() (net.PacketConn, error) {
+	return net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+}
+
+// mdnsName returns the name a multicast DNS response announces for the
+// host that sent it: the owner of an address record for the sender's own
+// address. Records a host announces for other addresses are ignored, so a
+// client can't name another.
+func mdnsName(packet []byte, sender net.IP) string {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(packet); err != nil || !msg.Response {
+		return ""
+	}
+	for _, rr := range append(msg.Answer, msg.Extra...) {
+		switch rr := rr.(type) {
+		case *dns.A:
+			if rr.A.Equal(sender) {
+				return rr.Hdr.Name
+			}
+		case *dns.AAAA:
+			if rr.AAAA.Equal(sender) {
+				return rr.Hdr.Name
+			}
+		}
+	}
+	return ""
+}
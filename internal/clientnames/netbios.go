@@ -0,0 +1,89 @@
+package clientnames
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+)
+
+// netbiosAddr is where NetBIOS name registrations are broadcast
+const netbiosAddr = ":137"
+
+const (
+	// NetBIOS name service opcodes announcing a name
+	netbiosRegistration = 5
+	netbiosRefresh      = 8
+	netbiosRefreshAlt   = 9
+	netbiosMultiHomed   = 15
+
+	// netbiosWorkstation is the name suffix of a host's own name
+	netbiosWorkstation = 0x00
+
+	// netbiosGroup flags group names (workgroups and domains)
+	netbiosGroup = 0x8000
+)
+
+// netbiosName returns the workstation name a NetBIOS name registration or
+// refresh (RFC 1002) announces. Group names and the host's other names
+// (services such as file sharing) are ignored.
+func netbiosName(packet []byte, sender net.IP) string {
+	// Header: ID, flags, question, answer, authority and additional counts
+	if len(packet) < 12 {
+		return ""
+	}
+	flags := binary.BigEndian.Uint16(packet[2:4])
+	if flags&0x8000 != 0 {
+		// Responses
+		return ""
+	}
+	switch (flags >> 11) & 0xf {
+	case netbiosRegistration, netbiosRefresh, netbiosRefreshAlt, netbiosMultiHomed:
+	default:
+		return ""
+	}
+	if binary.BigEndian.Uint16(packet[4:6]) != 1 || binary.BigEndian.Uint16(packet[10:12]) != 1 {
+		return ""
+	}
+
+	// Question name: 32 bytes encoding 16, then the scope's labels
+	off := 12
+	if len(packet) < off+33 || packet[off] != 32 {
+		return ""
+	}
+	var decoded [16]byte
+	for i := range decoded {
+		hi, lo := packet[off+1+2*i]-'A', packet[off+2+2*i]-'A'
+		if hi > 15 || lo > 15 {
+			return ""
+		}
+		decoded[i] = hi<<4 | lo
+	}
+	off += 33
+	for off < len(packet) && packet[off] != 0 {
+		off += int(packet[off]) + 1
+	}
+	off += 1 + 4 // End of name, question type and class
+
+	// Additional record: name (usually a pointer to the question's), type,
+	// class, TTL, length, then the NB flags and address
+	if off < len(packet) && packet[off]&0xc0 == 0xc0 {
+		off += 2
+	} else {
+		for off < len(packet) && packet[off] != 0 {
+			off += int(packet[off]) + 1
+		}
+		off++
+	}
+	off += 2 + 2 + 4 + 2
+	if len(packet) < off+6 {
+		return ""
+	}
+	if binary.BigEndian.Uint16(packet[off:off+2])&netbiosGroup != 0 {
+		return ""
+	}
+
+	if decoded[15] != netbiosWorkstation {
+		return ""
+	}
+	return strings.TrimRight(string(decoded[:15]), " ")
+}
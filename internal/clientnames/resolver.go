@@ -0,0 +1,229 @@
+// Package clientnames learns the names clients announce for themselves, over
+// multicast DNS and NetBIOS and in DHCP requests, so logs and policy can
+// name a client ("Liams-iPad") rather than give its IP address.
+package clientnames
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+// storeTimeout bounds writing a learned hostname to storage
+const storeTimeout = 2 * time.Second
+
+// maxNameLength is the longest hostname kept (a DNS name)
+const maxNameLength = 253
+
+// Config holds hostname resolver configuration
+type Config struct {
+	TTL     time.Duration // How long a name is kept after it was last announced
+	MDNS    bool          // Listen for multicast DNS announcements
+	NetBIOS bool          // Listen for NetBIOS name registrations
+}
+
+// Resolver keeps the hostname each client last announced, by IP address.
+// Names are self-reported and unauthenticated: they identify clients in
+// logs and can inform policy, but any client can claim any name.
+type Resolver struct {
+	config Config
+	store  storage.HostnameStore
+	logger zerolog.Logger
+
+	mu    sync.RWMutex
+	names map[string]entry
+
+	// Listeners, closed on Stop
+	conns []net.PacketConn
+	wg    sync.WaitGroup
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// entry is a known name and when it was last written to storage
+type entry struct {
+	storage.Hostname
+	stored time.Time
+}
+
+// NewResolver creates a resolver that keeps names in store
+func NewResolver(config Config, store storage.HostnameStore, logger zerolog.Logger) *Resolver {
+	return &Resolver{
+		config: config,
+		store:  store,
+		logger: logger.With().Str("component", "hostnames").Logger(),
+		names:  make(map[string]entry),
+		now:    time.Now,
+	}
+}
+
+// Start loads the names kept in storage and starts listening for
+// announcements. A listener that can't be opened (the port is taken, or
+// KProxy isn't allowed to bind it) is logged and skipped.
+func (r *Resolver) Start() error {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	hostnames, err := r.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	for _, h := range hostnames {
+		r.names[h.IP] = entry{Hostname: h, stored: h.Seen}
+	}
+	r.mu.Unlock()
+
+	if r.config.MDNS {
+		if conn, err := listenMDNS(); err != nil {
+			r.logger.Warn().Err(err).Msg("Failed to listen for mDNS announcements")
+		} else {
+			r.serve(conn, "mdns", mdnsName)
+		}
+	}
+	if r.config.NetBIOS {
+		if conn, err := net.ListenPacket("udp4", netbiosAddr); err != nil {
+			r.logger.Warn().Err(err).Msg("Failed to listen for NetBIOS name registrations")
+		} else {
+			r.serve(conn, "netbios", netbiosName)
+		}
+	}
+
+	r.logger.Info().
+		Int("known", len(hostnames)).
+		Bool("mdns", r.config.MDNS).
+		Bool("netbios", r.config.NetBIOS).
+		Msg("Hostname resolver started")
+	return nil
+}
+
+// Stop stops listening for announcements
+func (r *Resolver) Stop() {
+	for _, conn := range r.conns {
+		_ = conn.Close()
+	}
+	r.wg.Wait()
+}
+
+// serve reads announcements from conn until it is closed, learning the name
+// parse finds in each for the address that sent it
+func (r *Resolver) serve(conn net.PacketConn, source string, parse func(packet []byte, sender net.IP) string) {
+	r.conns = append(r.conns, conn)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		buf := make([]byte, 9000)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udpAddr, ok := addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+			if name := parse(buf[:n], udpAddr.IP); name != "" {
+				r.Learn(udpAddr.IP, nil, name, source)
+			}
+		}
+	}()
+}
+
+// Learn records the name a client announced by source ("dhcp", "mdns" or
+// "netbios"). A name that changed, or hasn't been stored for a while, is
+// written to storage.
+func (r *Resolver) Learn(ip net.IP, mac net.HardwareAddr, name, source string) {
+	if r == nil || ip == nil {
+		return
+	}
+	name = normalizeName(name)
+	if name == "" {
+		return
+	}
+
+	now := r.now()
+	h := storage.Hostname{
+		IP:        ip.String(),
+		Name:      name,
+		Source:    source,
+		Seen:      now,
+		ExpiresAt: now.Add(r.config.TTL),
+	}
+	if mac != nil {
+		h.MAC = mac.String()
+	}
+
+	// Announcements repeat; only refresh storage a few times per TTL
+	r.mu.Lock()
+	old, known := r.names[h.IP]
+	if h.MAC == "" && old.Name == h.Name {
+		h.MAC = old.MAC
+	}
+	changed := !known || old.Name != h.Name || old.MAC != h.MAC
+	store := changed || now.Sub(old.stored) >= r.config.TTL/4
+	e := entry{Hostname: h, stored: old.stored}
+	if store {
+		e.stored = now
+	}
+	r.names[h.IP] = e
+	r.mu.Unlock()
+
+	if changed {
+		r.logger.Info().
+			Str("ip", h.IP).
+			Str("mac", h.MAC).
+			Str("hostname", h.Name).
+			Str("source", source).
+			Msg("Learned client hostname")
+	}
+	if !store {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := r.store.Put(ctx, h); err != nil {
+		r.logger.Warn().Err(err).Str("ip", h.IP).Msg("Failed to store client hostname")
+	}
+}
+
+// Lookup returns the name the client at ip announced, or "" if none is
+// known. A nil resolver knows no names.
+func (r *Resolver) Lookup(ip net.IP) string {
+	if r == nil || ip == nil {
+		return ""
+	}
+	r.mu.RLock()
+	e, ok := r.names[ip.String()]
+	r.mu.RUnlock()
+	if !ok || !r.now().Before(e.ExpiresAt) {
+		return ""
+	}
+	return e.Name
+}
+
+// normalizeName trims an announced name to the host's own label, e.g.
+// "Liams-iPad.local." becomes "Liams-iPad". Names with control characters
+// are refused.
+func normalizeName(name string) string {
+	name = strings.TrimSpace(name)
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > len(".local") && strings.EqualFold(name[len(name)-len(".local"):], ".local") {
+		name = name[:len(name)-len(".local")]
+	}
+	if len(name) > maxNameLength {
+		return ""
+	}
+	for _, c := range name {
+		if unicode.IsControl(c) {
+			return ""
+		}
+	}
+	return name
+}
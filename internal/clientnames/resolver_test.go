@@ -0,0 +1,125 @@
+package clientnames
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+func TestResolver(t *testing.T) {
+	store := memory.Open().Hostnames()
+	now := time.Now()
+	_ = store.Put(context.Background(), storage.Hostname{IP: "192.168.5.20", Name: "printer", Source: "dhcp", Seen: now, ExpiresAt: now.Add(time.Hour)})
+
+	r := NewResolver(Config{TTL: 24 * time.Hour}, store, zerolog.Nop())
+	r.now = func() time.Time { return now }
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer r.Stop()
+
+	if name := r.Lookup(net.ParseIP("192.168.5.20")); name != "printer" {
+		t.Errorf("Expected the stored name, got %q", name)
+	}
+
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	r.Learn(net.ParseIP("192.168.5.142"), mac, "Liams-iPad", "dhcp")
+	r.Learn(net.ParseIP("192.168.5.142"), nil, "Liams-iPad.local.", "mdns")
+	if name := r.Lookup(net.ParseIP("192.168.5.142")); name != "Liams-iPad" {
+		t.Errorf("Expected Liams-iPad, got %q", name)
+	}
+
+	list, _ := store.List(context.Background())
+	if len(list) != 2 || list[0].Name != "Liams-iPad" || list[0].MAC != "aa:bb:cc:dd:ee:01" {
+		t.Errorf("Expected the learned name stored with its MAC, got %+v", list)
+	}
+
+	r.Learn(net.ParseIP("192.168.5.150"), nil, "bad\x00name", "netbios")
+	if name := r.Lookup(net.ParseIP("192.168.5.150")); name != "" {
+		t.Errorf("Expected a name with control characters refused, got %q", name)
+	}
+
+	now = now.Add(25 * time.Hour)
+	if name := r.Lookup(net.ParseIP("192.168.5.142")); name != "" {
+		t.Errorf("Expected the name to expire after the TTL, got %q", name)
+	}
+
+	var none *Resolver
+	if name := none.Lookup(net.ParseIP("192.168.5.142")); name != "" {
+		t.Errorf("Expected a nil resolver to know no names, got %q", name)
+	}
+}
+
+func TestMDNSName(t *testing.T) {
+	sender := net.ParseIP("192.168.5.142")
+	msg := new(dns.Msg)
+	msg.Response = true
+	msg.Answer = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "Dads-iPhone.local.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("192.168.5.10")},
+		&dns.A{Hdr: dns.RR_Header{Name: "Liams-iPad.local.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: sender},
+	}
+	packet, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := mdnsName(packet, sender); name != "Liams-iPad.local." {
+		t.Errorf("Expected the sender's own name, got %q", name)
+	}
+
+	// Queries don't announce anything
+	query := new(dns.Msg)
+	query.SetQuestion("Liams-iPad.local.", dns.TypeA)
+	packet, _ = query.Pack()
+	if name := mdnsName(packet, sender); name != "" {
+		t.Errorf("Expected no name from a query, got %q", name)
+	}
+}
+
+// netbiosRegistrationPacket builds a NetBIOS name registration request
+func netbiosRegistrationPacket(name string, suffix byte, nbFlags uint16) []byte {
+	packet := make([]byte, 12)
+	binary.BigEndian.PutUint16(packet[2:4], netbiosRegistration<<11|0x0110) // Broadcast, recursion desired
+	binary.BigEndian.PutUint16(packet[4:6], 1)
+	binary.BigEndian.PutUint16(packet[10:12], 1)
+
+	padded := []byte(strings.ToUpper(name) + strings.Repeat(" ", 15-len(name)))
+	padded = append(padded, suffix)
+	packet = append(packet, 32)
+	for _, c := range padded {
+		packet = append(packet, 'A'+c>>4, 'A'+c&0x0f)
+	}
+	packet = append(packet, 0, 0x00, 0x20, 0x00, 0x01) // End of name, NB, IN
+
+	packet = append(packet, 0xc0, 0x0c, 0x00, 0x20, 0x00, 0x01) // Pointer to the question name, NB, IN
+	packet = append(packet, 0x00, 0x04, 0x93, 0xe0, 0x00, 0x06) // TTL, length
+	packet = binary.BigEndian.AppendUint16(packet, nbFlags)
+	return append(packet, 192, 168, 5, 30)
+}
+
+func TestNetBIOSName(t *testing.T) {
+	sender := net.ParseIP("192.168.5.30")
+	tests := []struct {
+		name   string
+		packet []byte
+		want   string
+	}{
+		{"workstation", netbiosRegistrationPacket("study-pc", 0x00, 0), "STUDY-PC"},
+		{"file server name", netbiosRegistrationPacket("study-pc", 0x20, 0), ""},
+		{"workgroup", netbiosRegistrationPacket("workgroup", 0x00, netbiosGroup), ""},
+		{"truncated", netbiosRegistrationPacket("study-pc", 0x00, 0)[:40], ""},
+		{"short", []byte{0, 1, 2}, ""},
+	}
+	for _, tt := range tests {
+		if got := netbiosName(tt.packet, sender); got != tt.want {
+			t.Errorf("%s: netbiosName() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+// Package desired implements declarative configuration for kproxy apply:
+// devices, users, profiles, rule sets, subnet default profiles and bypass
+// domains are
+// described in YAML files, compared against the data in config.rego, and
+// written back to it.
+package desired
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/format"
+	"gopkg.in/yaml.v3"
+)
+
+// Sections are the parts of config.rego managed by YAML files, in the order
+// they are written. Other values in config.rego (server_name) are kept.
+var Sections = []string{"devices", "users", "profiles", "rule_sets", "subnet_profiles", "bypass_domains"}
+
+// Operations in a plan
+const (
+	OpAdd    = "add"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// State is the data in config.rego, by top-level name. Values are plain
+// JSON values (map[string]interface{}, []interface{}, string, float64...).
+type State map[string]interface{}
+
+// Change is one step of a plan
+type Change struct {
+	Section string `json:"section"`
+	Key     string `json:"key"` // Device, user or profile ID, subnet, or bypass domain
+	Op      string `json:"op"`
+}
+
+// Load reads the desired state from a YAML file, or from every .yaml and
+// .yml file in a directory. Sections missing from all files are not managed;
+// an ID defined in two files is an error.
+func Load(path string) (State, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no YAML files in %s", path)
+		}
+	}
+
+	state := State{}
+	origin := make(map[string]string) // "section/key" -> file
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if err := mergeFile(state, origin, file, doc); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// mergeFile adds the sections of one YAML file to state
+func mergeFile(state State, origin map[string]string, file string, doc map[string]interface{}) error {
+	for section, value := range doc {
+		if !managed(section) {
+			return fmt.Errorf("%s: unknown section %q (must be one of devices, users, profiles, rule_sets, subnet_profiles, bypass_domains)", file, section)
+		}
+		value, err := normalize(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", file, section, err)
+		}
+
+		if section == "bypass_domains" {
+			domains, ok := value.([]interface{})
+			if value != nil && !ok {
+				return fmt.Errorf("%s: bypass_domains must be a list", file)
+			}
+			existing, _ := state[section].([]interface{})
+			state[section] = append(append([]interface{}{}, existing...), domains...)
+			continue
+		}
+
+		entries, ok := value.(map[string]interface{})
+		if value != nil && !ok {
+			return fmt.Errorf("%s: %s must be a map of IDs", file, section)
+		}
+		merged, _ := state[section].(map[string]interface{})
+		if merged == nil {
+			merged = make(map[string]interface{})
+		}
+		for key, entry := range entries {
+			if other, ok := origin[section+"/"+key]; ok {
+				return fmt.Errorf("%s: %s %q is also defined in %s", file, section, key, other)
+			}
+			origin[section+"/"+key] = file
+			merged[key] = entry
+		}
+		state[section] = merged
+	}
+	return nil
+}
+
+// Parse reads the data in config.rego. Every rule must be a plain value
+// (name := {...}); logic can't be managed by kproxy apply.
+func Parse(filename string, src []byte) (State, error) {
+	module, err := ast.ParseModule(filename, string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	state := State{}
+	for _, rule := range module.Rules {
+		name := rule.Head.Ref().String()
+		if len(rule.Head.Args) > 0 || rule.Head.Value == nil || rule.Else != nil || !rule.Body.Equal(ast.NewBody(ast.NewExpr(ast.BooleanTerm(true)))) {
+			return nil, fmt.Errorf("%s: %s is not a plain value; kproxy apply only manages data", filename, name)
+		}
+		value, err := ast.JSON(rule.Head.Value.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", filename, name, err)
+		}
+		if state[name], err = normalize(value); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", filename, name, err)
+		}
+	}
+	return state, nil
+}
+
+// Merge returns current with the sections defined in desired replaced
+func Merge(current, desired State) State {
+	merged := State{}
+	for name, value := range current {
+		merged[name] = value
+	}
+	for name, value := range desired {
+		merged[name] = value
+	}
+	return merged
+}
+
+// Validate checks that the devices, users, rule sets and subnets in a state
+// refer to profiles that exist
+func Validate(state State) error {
+	profiles, _ := state["profiles"].(map[string]interface{})
+
+	for _, section := range []string{"devices", "users"} {
+		entries, _ := state[section].(map[string]interface{})
+		for _, id := range sortedKeys(entries) {
+			entry, ok := entries[id].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s %q must be a map", section, id)
+			}
+			profile, _ := entry["profile"].(string)
+			if _, ok := profiles[profile]; !ok {
+				return fmt.Errorf("%s %q: profile %q not found", section, id, profile)
+			}
+			if section == "devices" {
+				if identifiers, _ := entry["identifiers"].([]interface{}); len(identifiers) == 0 {
+					return fmt.Errorf("devices %q: at least one identifier is required", id)
+				}
+			}
+		}
+	}
+	subnets, _ := state["subnet_profiles"].(map[string]interface{})
+	for _, cidr := range sortedKeys(subnets) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("subnet_profiles %q: not a CIDR range", cidr)
+		}
+		profile, _ := subnets[cidr].(string)
+		if _, ok := profiles[profile]; !ok {
+			return fmt.Errorf("subnet_profiles %q: profile %q not found", cidr, profile)
+		}
+	}
+	ruleSets, _ := state["rule_sets"].(map[string]interface{})
+	for _, id := range sortedKeys(ruleSets) {
+		set, ok := ruleSets[id].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("rule_sets %q must be a map", id)
+		}
+		if _, ok := set["rules"].([]interface{}); !ok {
+			return fmt.Errorf("rule_sets %q: rules must be a list", id)
+		}
+		attached, _ := set["profiles"].([]interface{})
+		for _, profile := range attached {
+			name, _ := profile.(string)
+			if _, ok := profiles[name]; !ok {
+				return fmt.Errorf("rule_sets %q: profile %q not found", id, name)
+			}
+		}
+	}
+	for _, id := range sortedKeys(profiles) {
+		if _, ok := profiles[id].(map[string]interface{}); !ok {
+			return fmt.Errorf("profiles %q must be a map", id)
+		}
+	}
+	return nil
+}
+
+// Diff returns the changes that turn current into desired, by section
+func Diff(current, desired State) []Change {
+	var changes []Change
+	for _, section := range Sections {
+		if section == "bypass_domains" {
+			have := stringSet(current[section])
+			want := stringSet(desired[section])
+			for _, domain := range sortedKeys(want) {
+				if _, ok := have[domain]; !ok {
+					changes = append(changes, Change{Section: section, Key: domain, Op: OpAdd})
+				}
+			}
+			for _, domain := range sortedKeys(have) {
+				if _, ok := want[domain]; !ok {
+					changes = append(changes, Change{Section: section, Key: domain, Op: OpDelete})
+				}
+			}
+			continue
+		}
+
+		have, _ := current[section].(map[string]interface{})
+		want, _ := desired[section].(map[string]interface{})
+		for _, key := range sortedKeys(want) {
+			old, ok := have[key]
+			switch {
+			case !ok:
+				changes = append(changes, Change{Section: section, Key: key, Op: OpAdd})
+			case !reflect.DeepEqual(old, want[key]):
+				changes = append(changes, Change{Section: section, Key: key, Op: OpUpdate})
+			}
+		}
+		for _, key := range sortedKeys(have) {
+			if _, ok := want[key]; !ok {
+				changes = append(changes, Change{Section: section, Key: key, Op: OpDelete})
+			}
+		}
+	}
+	return changes
+}
+
+// Render writes a state as config.rego. source names the YAML it came from.
+func Render(state State, source string) ([]byte, error) {
+	return render(state, "Generated by kproxy apply from "+source+".",
+		"Edit the YAML files and run kproxy apply again instead of editing this file.")
+}
+
+// RenderImport writes a state imported through the admin API as
+// config.rego
+func RenderImport(state State) ([]byte, error) {
+	return render(state, "Written by kproxy rules import (POST /api/import).")
+}
+
+// render writes a state as config.rego under a header comment
+func render(state State, header ...string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("package kproxy.config\n\n")
+	for _, line := range header {
+		fmt.Fprintf(&buf, "# %s\n", line)
+	}
+
+	names := append([]string{}, Sections...)
+	var others []string
+	for name := range state {
+		if !managed(name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+
+	for _, name := range append(names, others...) {
+		value, ok := state[name]
+		if !ok {
+			continue
+		}
+		content, err := json.MarshalIndent(value, "", "\t")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Fprintf(&buf, "\n%s := %s\n", name, content)
+	}
+
+	return format.Source("config.rego", buf.Bytes())
+}
+
+// normalize turns a decoded YAML or Rego value into plain JSON values so
+// that states from either source compare equal
+func normalize(value interface{}) (interface{}, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(content, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// managed reports whether a config.rego name is one of the Sections
+func managed(name string) bool {
+	for _, section := range Sections {
+		if name == section {
+			return true
+		}
+	}
+	return false
+}
+
+// stringSet returns the strings in a list value
+func stringSet(value interface{}) map[string]interface{} {
+	set := make(map[string]interface{})
+	list, _ := value.([]interface{})
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			set[s] = nil
+		}
+	}
+	return set
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
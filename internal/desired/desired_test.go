@@ -0,0 +1,226 @@
+package desired
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const configRego = `package kproxy.config
+
+devices := {
+	"kids-ipad": {"name": "Kids iPad", "identifiers": ["aa:bb:cc:dd:ee:ff"], "profile": "child"},
+	"old-laptop": {"name": "Old Laptop", "identifiers": ["192.168.1.50"], "profile": "child"},
+}
+
+users := {}
+
+profiles := {"child": {"name": "Child", "rules": [], "default_action": "block"}}
+
+bypass_domains := ["ocsp.apple.com"]
+
+server_name := "local.kproxy"
+`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"devices.yaml": `
+devices:
+  kids-ipad:
+    name: Kids iPad
+    identifiers: ["aa:bb:cc:dd:ee:ff"]
+    profile: child
+bypass_domains: [ocsp.apple.com]
+`,
+		"more.yml": `
+devices:
+  kids-switch:
+    name: Nintendo Switch
+    identifiers: ["98:b6:e9:12:34:56"]
+    profile: child
+bypass_domains: ["*.bank.example.com"]
+`,
+		"README.md": "ignored",
+	})
+
+	state, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	devices, _ := state["devices"].(map[string]interface{})
+	if len(devices) != 2 {
+		t.Errorf("devices = %v, want kids-ipad and kids-switch", devices)
+	}
+	if got := state["bypass_domains"]; !reflect.DeepEqual(got, []interface{}{"ocsp.apple.com", "*.bank.example.com"}) {
+		t.Errorf("bypass_domains = %v, want both files' domains", got)
+	}
+	if _, ok := state["profiles"]; ok {
+		t.Error("profiles loaded, want it unmanaged")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"duplicate", map[string]string{
+			"a.yaml": "devices:\n  tv:\n    profile: child\n",
+			"b.yaml": "devices:\n  tv:\n    profile: adult\n",
+		}, "also defined"},
+		{"unknown section", map[string]string{"a.yaml": "server_name: other\n"}, "unknown section"},
+		{"bad yaml", map[string]string{"a.yaml": "devices: [\n"}, "a.yaml"},
+		{"empty dir", map[string]string{}, "no YAML files"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeFiles(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	state, err := Parse("config.rego", []byte(configRego))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if state["server_name"] != "local.kproxy" {
+		t.Errorf("server_name = %v, want local.kproxy", state["server_name"])
+	}
+
+	logic := configRego + "\nis_child(id) if devices[id].profile == \"child\"\n"
+	if _, err := Parse("config.rego", []byte(logic)); err == nil {
+		t.Error("Parse() with a function succeeded, want an error")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	current, err := Parse("config.rego", []byte(configRego))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeFiles(t, map[string]string{"config.yaml": `
+devices:
+  kids-ipad:
+    name: Kids iPad
+    identifiers: ["aa:bb:cc:dd:ee:ff"]
+    profile: adult
+  kids-switch:
+    name: Nintendo Switch
+    identifiers: ["98:b6:e9:12:34:56"]
+    profile: child
+profiles:
+  child: {name: Child, rules: [], default_action: block}
+  adult: {name: Adult, rules: [], default_action: allow}
+bypass_domains: ["*.bank.example.com"]
+`})
+	want, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := Merge(current, want)
+	if err := Validate(merged); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	got := Diff(current, merged)
+	expected := []Change{
+		{"devices", "kids-ipad", OpUpdate},
+		{"devices", "kids-switch", OpAdd},
+		{"devices", "old-laptop", OpDelete},
+		{"profiles", "adult", OpAdd},
+		{"bypass_domains", "*.bank.example.com", OpAdd},
+		{"bypass_domains", "ocsp.apple.com", OpDelete},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Diff() = %v, want %v", got, expected)
+	}
+
+	if changes := Diff(merged, merged); len(changes) != 0 {
+		t.Errorf("Diff() of equal states = %v, want none", changes)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	state := State{
+		"devices":  map[string]interface{}{"tv": map[string]interface{}{"identifiers": []interface{}{"192.168.1.9"}, "profile": "guest"}},
+		"profiles": map[string]interface{}{"child": map[string]interface{}{}},
+	}
+	if err := Validate(state); err == nil || !strings.Contains(err.Error(), `profile "guest" not found`) {
+		t.Errorf("Validate() error = %v, want missing profile", err)
+	}
+
+	state["devices"] = map[string]interface{}{"tv": map[string]interface{}{"profile": "child"}}
+	if err := Validate(state); err == nil || !strings.Contains(err.Error(), "identifier") {
+		t.Errorf("Validate() error = %v, want missing identifiers", err)
+	}
+
+	delete(state, "devices")
+	for _, tt := range []struct {
+		subnets map[string]interface{}
+		want    string
+	}{
+		{subnets: map[string]interface{}{"192.168.20.0/24": "guest"}, want: `profile "guest" not found`},
+		{subnets: map[string]interface{}{"kids-vlan": "child"}, want: "not a CIDR range"},
+	} {
+		state["subnet_profiles"] = tt.subnets
+		if err := Validate(state); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Validate(%v) error = %v, want %q", tt.subnets, err, tt.want)
+		}
+	}
+	state["subnet_profiles"] = map[string]interface{}{"192.168.20.0/24": "child"}
+	if err := Validate(state); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	state["rule_sets"] = map[string]interface{}{"gaming": map[string]interface{}{"profiles": []interface{}{"guest"}, "rules": []interface{}{}}}
+	if err := Validate(state); err == nil || !strings.Contains(err.Error(), `rule_sets "gaming": profile "guest" not found`) {
+		t.Errorf("Validate() error = %v, want the unknown rule set profile", err)
+	}
+	state["rule_sets"] = map[string]interface{}{"gaming": map[string]interface{}{"profiles": []interface{}{"child"}, "rules": []interface{}{}}}
+	if err := Validate(state); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	current, err := Parse("config.rego", []byte(configRego))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Render(current, "config/")
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if !strings.Contains(string(out), "# Generated by kproxy apply from config/.") {
+		t.Errorf("Render() output missing header:\n%s", out)
+	}
+
+	parsed, err := Parse("config.rego", out)
+	if err != nil {
+		t.Fatalf("Parse(Render()) error = %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(parsed, current) {
+		t.Errorf("Parse(Render()) = %v, want %v", parsed, current)
+	}
+}
@@ -0,0 +1,317 @@
+package devices
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/activity"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrExists is returned when adding a device whose ID is already in use
+	ErrExists = errors.New("device already exists")
+
+	// ErrNotFound is returned when removing a device that wasn't added at runtime
+	ErrNotFound = errors.New("device not found")
+)
+
+// Device is a device added at runtime
+type Device struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Identifiers []string  `json:"identifiers"` // MAC addresses, IPs or CIDR ranges, as in config.rego
+	Profile     string    `json:"profile"`
+	Created     time.Time `json:"created"`
+
+	// Discovered devices wait in the quarantine profile until classified
+	Pending      bool   `json:"pending,omitempty"`
+	DiscoveredBy string `json:"discovered_by,omitempty"` // "dhcp", "dns" or "proxy"
+}
+
+// idPattern matches device IDs usable as config.rego keys
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Registry holds devices added and profiles assigned through the admin API.
+// Both are kept in memory only; permanent devices belong in config.rego.
+type Registry struct {
+	logger   zerolog.Logger
+	activity *activity.Recorder
+
+	mu       sync.RWMutex
+	devices  map[string]Device
+	profiles map[string]string // Device ID -> assigned profile
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates an empty registry
+func New(logger zerolog.Logger) *Registry {
+	return &Registry{
+		logger:   logger.With().Str("component", "devices").Logger(),
+		devices:  make(map[string]Device),
+		profiles: make(map[string]string),
+		now:      time.Now,
+	}
+}
+
+// SetActivity sets the recorder that streams discovered devices to live
+// dashboards
+func (r *Registry) SetActivity(a *activity.Recorder) {
+	r.activity = a
+}
+
+// Add adds a device. Callers check that the ID isn't a configured device
+// and that the profile exists.
+func (r *Registry) Add(id, name string, identifiers []string, profile string) (Device, error) {
+	device, err := newDevice(id, name, identifiers, profile)
+	if err != nil {
+		return Device{}, err
+	}
+	device.Created = r.now()
+
+	r.mu.Lock()
+	if _, ok := r.devices[device.ID]; ok {
+		r.mu.Unlock()
+		return Device{}, fmt.Errorf("%w: %s", ErrExists, device.ID)
+	}
+	r.devices[device.ID] = device
+	r.mu.Unlock()
+
+	r.logDevice(device, "Device added")
+	return device, nil
+}
+
+// Discover adds a pending device for a client seen for the first time,
+// unless the ID is already in use. It reports whether the device was added.
+func (r *Registry) Discover(id, name, identifier, profile, source string) (Device, bool, error) {
+	device, err := newDevice(id, name, []string{identifier}, profile)
+	if err != nil {
+		return Device{}, false, err
+	}
+	device.Created = r.now()
+	device.Pending = true
+	device.DiscoveredBy = source
+
+	r.mu.Lock()
+	if existing, ok := r.devices[device.ID]; ok {
+		r.mu.Unlock()
+		return existing, false, nil
+	}
+	r.devices[device.ID] = device
+	r.mu.Unlock()
+
+	r.logDevice(device, "New device quarantined")
+	r.activity.Publish(activity.Event{
+		Time:    device.Created,
+		Type:    activity.EventDevice,
+		Source:  source,
+		Device:  device.ID,
+		Client:  identifier,
+		Profile: device.Profile,
+	})
+	return device, true, nil
+}
+
+// Put creates or replaces a device, so repeating the same request leaves
+// the same device in place. A replaced device keeps its creation time. It
+// reports whether the device was created.
+func (r *Registry) Put(id, name string, identifiers []string, profile string) (Device, bool, error) {
+	device, err := newDevice(id, name, identifiers, profile)
+	if err != nil {
+		return Device{}, false, err
+	}
+
+	r.mu.Lock()
+	old, exists := r.devices[device.ID]
+	device.Created = r.now()
+	if exists {
+		device.Created = old.Created
+	}
+	r.devices[device.ID] = device
+	r.mu.Unlock()
+
+	if exists {
+		r.logDevice(device, "Device updated")
+	} else {
+		r.logDevice(device, "Device added")
+	}
+	return device, !exists, nil
+}
+
+// Check reports whether Put would accept a device, without changing
+// anything
+func Check(id, name string, identifiers []string, profile string) error {
+	_, err := newDevice(id, name, identifiers, profile)
+	return err
+}
+
+// Remove deletes a device added at runtime, and any profile assigned to it
+func (r *Registry) Remove(id string) error {
+	r.mu.Lock()
+	if _, ok := r.devices[id]; !ok {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	delete(r.devices, id)
+	delete(r.profiles, id)
+	r.mu.Unlock()
+
+	r.logger.Info().Str("id", id).Msg("Device removed")
+	return nil
+}
+
+// Assign sets the profile of a device, configured or added at runtime.
+// Assigning a profile to a pending device classifies it: the device takes
+// the profile and leaves quarantine.
+func (r *Registry) Assign(id, profile string) {
+	r.mu.Lock()
+	if device, ok := r.devices[id]; ok && device.Pending {
+		device.Profile, device.Pending = profile, false
+		r.devices[id] = device
+		r.mu.Unlock()
+
+		r.logDevice(device, "Device classified")
+		return
+	}
+	r.profiles[id] = profile
+	r.mu.Unlock()
+
+	r.logger.Info().Str("id", id).Str("profile", profile).Msg("Device profile assigned")
+}
+
+// Unassign clears the profile assigned to a device at runtime. It reports
+// whether one was assigned.
+func (r *Registry) Unassign(id string) bool {
+	r.mu.Lock()
+	_, ok := r.profiles[id]
+	delete(r.profiles, id)
+	r.mu.Unlock()
+
+	if ok {
+		r.logger.Info().Str("id", id).Msg("Device profile assignment cleared")
+	}
+	return ok
+}
+
+// Devices returns the devices added at runtime, sorted by ID
+func (r *Registry) Devices() []Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Device, 0, len(r.devices))
+	for _, d := range r.devices {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Get returns a device added at runtime
+func (r *Registry) Get(id string) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	device, ok := r.devices[id]
+	return device, ok
+}
+
+// Assigned returns the profile assigned to a device at runtime, if any
+func (r *Registry) Assigned(id string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	profile, ok := r.profiles[id]
+	return profile, ok
+}
+
+// PolicyDevices returns the devices added at runtime as
+// input.runtime_devices for OPA
+func (r *Registry) PolicyDevices() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	facts := make(map[string]interface{}, len(r.devices))
+	for id, d := range r.devices {
+		identifiers := make([]interface{}, len(d.Identifiers))
+		for i, identifier := range d.Identifiers {
+			identifiers[i] = identifier
+		}
+		facts[id] = map[string]interface{}{
+			"name":        d.Name,
+			"identifiers": identifiers,
+			"profile":     d.Profile,
+		}
+	}
+	return facts
+}
+
+// PolicyProfiles returns the profiles assigned at runtime as
+// input.device_profiles for OPA
+func (r *Registry) PolicyProfiles() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	facts := make(map[string]interface{}, len(r.profiles))
+	for id, profile := range r.profiles {
+		facts[id] = profile
+	}
+	return facts
+}
+
+// newDevice checks a device's fields and normalizes its ID and identifiers
+func newDevice(id, name string, identifiers []string, profile string) (Device, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if !idPattern.MatchString(id) {
+		return Device{}, fmt.Errorf("invalid device ID: %q (use lowercase letters, digits, - and _)", id)
+	}
+	if profile == "" {
+		return Device{}, fmt.Errorf("profile is required")
+	}
+	if len(identifiers) == 0 {
+		return Device{}, fmt.Errorf("at least one identifier is required")
+	}
+
+	normalized := make([]string, 0, len(identifiers))
+	for _, identifier := range identifiers {
+		n, err := normalizeIdentifier(identifier)
+		if err != nil {
+			return Device{}, err
+		}
+		normalized = append(normalized, n)
+	}
+	if name == "" {
+		name = id
+	}
+	return Device{ID: id, Name: name, Identifiers: normalized, Profile: profile}, nil
+}
+
+// logDevice logs a device that was added or changed
+func (r *Registry) logDevice(device Device, msg string) {
+	r.logger.Info().
+		Str("id", device.ID).
+		Strs("identifiers", device.Identifiers).
+		Str("profile", device.Profile).
+		Msg(msg)
+}
+
+// normalizeIdentifier checks a MAC address, IP address or CIDR range and
+// returns it in the form policies match
+func normalizeIdentifier(identifier string) (string, error) {
+	identifier = strings.TrimSpace(identifier)
+	if mac, err := net.ParseMAC(identifier); err == nil && len(mac) == 6 {
+		return mac.String(), nil
+	}
+	if ip := net.ParseIP(identifier); ip != nil {
+		return ip.String(), nil
+	}
+	if _, network, err := net.ParseCIDR(identifier); err == nil {
+		return network.String(), nil
+	}
+	return "", fmt.Errorf("invalid identifier: %q (must be a MAC address, IP address or CIDR range)", identifier)
+}
@@ -0,0 +1,145 @@
+package devices
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New(zerolog.Nop())
+
+	device, err := r.Add("sams-ipad", "Sam's iPad", []string{"AA-BB-CC-DD-EE-01", "192.168.5.37", "2001:DB8::1"}, "kids")
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	want := []string{"aa:bb:cc:dd:ee:01", "192.168.5.37", "2001:db8::1"}
+	for i, identifier := range device.Identifiers {
+		if identifier != want[i] {
+			t.Errorf("identifier %d = %s, want %s", i, identifier, want[i])
+		}
+	}
+
+	if _, err := r.Add("sams-ipad", "", []string{"192.168.5.38"}, "kids"); !errors.Is(err, ErrExists) {
+		t.Errorf("Add() of a duplicate = %v, want ErrExists", err)
+	}
+
+	for _, tt := range []struct {
+		id          string
+		identifiers []string
+		profile     string
+	}{
+		{id: "Sam's iPad", identifiers: []string{"192.168.5.38"}, profile: "kids"},
+		{id: "tv", identifiers: []string{"living room"}, profile: "kids"},
+		{id: "tv", profile: "kids"},
+		{id: "tv", identifiers: []string{"192.168.5.38"}},
+	} {
+		if _, err := r.Add(tt.id, "", tt.identifiers, tt.profile); err == nil {
+			t.Errorf("Add(%q, %v, %q): expected error", tt.id, tt.identifiers, tt.profile)
+		}
+	}
+
+	r.Assign("laptop", "teen")
+	if profile, ok := r.Assigned("laptop"); !ok || profile != "teen" {
+		t.Errorf("Assigned() = %q, %v, want teen", profile, ok)
+	}
+
+	facts := r.PolicyDevices()
+	if d, ok := facts["sams-ipad"].(map[string]interface{}); !ok || d["profile"] != "kids" {
+		t.Errorf("PolicyDevices() = %v", facts)
+	}
+	if profiles := r.PolicyProfiles(); profiles["laptop"] != "teen" {
+		t.Errorf("PolicyProfiles() = %v", profiles)
+	}
+}
+
+func TestRegistryPutRemove(t *testing.T) {
+	r := New(zerolog.Nop())
+
+	device, created, err := r.Put("tv", "TV", []string{"192.168.5.40"}, "kids")
+	if err != nil || !created {
+		t.Fatalf("Put() = %+v, %v, %v, want a created device", device, created, err)
+	}
+	again, created, err := r.Put("tv", "TV", []string{"192.168.5.40"}, "adults")
+	if err != nil || created || again.Profile != "adults" || !again.Created.Equal(device.Created) {
+		t.Errorf("Put() again = %+v, %v, %v, want the device updated", again, created, err)
+	}
+
+	r.Assign("tv", "kids")
+	if err := r.Remove("tv"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, ok := r.Assigned("tv"); ok || len(r.Devices()) != 0 {
+		t.Error("Remove() left the device or its assignment")
+	}
+	if err := r.Remove("tv"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Remove() of a removed device = %v, want ErrNotFound", err)
+	}
+
+	r.Assign("kids-ipad", "adults")
+	if !r.Unassign("kids-ipad") || r.Unassign("kids-ipad") {
+		t.Error("Unassign() should report the assignment once")
+	}
+}
+
+func TestARPLookup(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "arp")
+	content := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.5.37     0x1         0x2         aa:bb:cc:dd:ee:01     *        br0
+192.168.5.40     0x1         0x0         00:00:00:00:00:00     *        br0
+`
+	if err := os.WriteFile(table, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	arpTable = table
+	defer func() { arpTable = "/proc/net/arp" }()
+
+	tests := map[string]string{
+		"192.168.5.37": "aa:bb:cc:dd:ee:01",
+		"192.168.5.40": "", // Incomplete
+		"192.168.5.99": "",
+	}
+	for ip, want := range tests {
+		got, err := ARPLookup(net.ParseIP(ip))
+		if err != nil || got != want {
+			t.Errorf("ARPLookup(%s) = %q, %v, want %q", ip, got, err, want)
+		}
+	}
+}
+
+func TestMDNSLookup(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(r)
+		resp.Answer = append(resp.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 120},
+			Ptr: "Sams-iPad.local.",
+		})
+		_ = w.WriteMsg(resp)
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	defer func() { _ = server.Shutdown() }()
+
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	mdnsPort = port
+	defer func() { mdnsPort = "5353" }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	name, err := MDNSLookup(ctx, net.ParseIP("127.0.0.1"))
+	if err != nil || name != "Sams-iPad.local" {
+		t.Errorf("MDNSLookup() = %q, %v, want Sams-iPad.local", name, err)
+	}
+}
@@ -0,0 +1,174 @@
+package devices
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/rs/zerolog"
+)
+
+// discoveryQueueSize is how many new clients may wait to be identified
+// before further ones are left for their next query
+const discoveryQueueSize = 256
+
+// Identifier identifies clients as policy does (the policy engine)
+type Identifier interface {
+	LookupDevices(clientIP net.IP, clientMAC net.HardwareAddr) (*opa.DeviceLookup, error)
+}
+
+// Discovery quarantines unknown clients as they appear. The first time a
+// client is seen (a DHCP lease, a DNS query or a proxy request), it is
+// identified off the request path; a client that policy doesn't know as a
+// device, by its identifiers, its user or its subnet, is added as a pending
+// device in the quarantine profile, to be classified through the admin API.
+type Discovery struct {
+	registry   *Registry
+	identifier Identifier
+	profile    string
+	jobs       chan sighting
+	stop       chan struct{}
+	done       chan struct{}
+	logger     zerolog.Logger
+
+	mu   sync.Mutex
+	seen map[string]bool // Client MAC or IP addresses identified or queued
+}
+
+// sighting is a client seen for the first time
+type sighting struct {
+	key      string
+	ip       net.IP
+	mac      net.HardwareAddr
+	hostname string
+	source   string
+}
+
+// NewDiscovery creates a discovery that adds unknown clients to registry
+// with the quarantine profile
+func NewDiscovery(registry *Registry, identifier Identifier, profile string, logger zerolog.Logger) *Discovery {
+	return &Discovery{
+		registry:   registry,
+		identifier: identifier,
+		profile:    profile,
+		jobs:       make(chan sighting, discoveryQueueSize),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		seen:       make(map[string]bool),
+		logger:     logger.With().Str("component", "discovery").Logger(),
+	}
+}
+
+// Start starts identifying new clients
+func (d *Discovery) Start() {
+	d.logger.Info().Str("profile", d.profile).Msg("Quarantining new devices")
+	go d.run()
+}
+
+// Stop identifies the clients still queued and stops
+func (d *Discovery) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+// Observe notes a client seen by source ("dhcp", "dns" or "proxy"), with
+// its MAC address and hostname if known. Clients already seen return at
+// once; new ones are queued, and skipped until their next query when the
+// queue is full. A nil discovery does nothing.
+func (d *Discovery) Observe(ip net.IP, mac net.HardwareAddr, hostname, source string) {
+	if d == nil || ip == nil {
+		return
+	}
+	key := ip.String()
+	if len(mac) > 0 {
+		key = mac.String()
+	}
+
+	d.mu.Lock()
+	if d.seen[key] {
+		d.mu.Unlock()
+		return
+	}
+	d.seen[key] = true
+	d.mu.Unlock()
+
+	select {
+	case d.jobs <- sighting{key: key, ip: ip, mac: mac, hostname: hostname, source: source}:
+	default:
+		d.forget(key)
+	}
+}
+
+// forget lets a client be identified again when it is next seen
+func (d *Discovery) forget(key string) {
+	d.mu.Lock()
+	delete(d.seen, key)
+	d.mu.Unlock()
+}
+
+func (d *Discovery) run() {
+	defer close(d.done)
+	for {
+		select {
+		case s := <-d.jobs:
+			d.identify(s)
+		case <-d.stop:
+			for {
+				select {
+				case s := <-d.jobs:
+					d.identify(s)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// identify quarantines a client if policy doesn't know it
+func (d *Discovery) identify(s sighting) {
+	lookup, err := d.identifier.LookupDevices(s.ip, s.mac)
+	if err != nil {
+		d.logger.Warn().Err(err).Str("client", s.key).Msg("Failed to identify new client")
+		d.forget(s.key)
+		return
+	}
+	if lookup.Device != nil {
+		return
+	}
+	if !slices.Contains(lookup.Profiles, d.profile) {
+		d.logger.Warn().Str("profile", d.profile).Msg("Quarantine profile is not defined in policy; default policy applies to new devices")
+	}
+
+	// Prefer the MAC address, which survives a change of IP address
+	identifier := s.ip.String()
+	if len(s.mac) > 0 {
+		identifier = s.mac.String()
+	}
+	name := s.hostname
+	if name == "" {
+		name = identifier
+	}
+
+	_, created, err := d.registry.Discover(discoveredID(identifier), name, identifier, d.profile, s.source)
+	if err != nil {
+		d.logger.Warn().Err(err).Str("client", s.key).Msg("Failed to add new device")
+		return
+	}
+	if created {
+		metrics.DevicesDiscovered.WithLabelValues(s.source).Inc()
+	}
+}
+
+// discoveredID makes a device ID from a MAC or IP address:
+// aa:bb:cc:dd:ee:01 becomes new-aabbccddee01 and 192.168.1.57 becomes
+// new-192-168-1-57
+func discoveredID(identifier string) string {
+	if mac, err := net.ParseMAC(identifier); err == nil {
+		return "new-" + strings.ReplaceAll(mac.String(), ":", "")
+	}
+	return "new-" + strings.NewReplacer(".", "-", ":", "-").Replace(identifier)
+}
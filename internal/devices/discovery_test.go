@@ -0,0 +1,80 @@
+package devices
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/rs/zerolog"
+)
+
+// fakeIdentifier knows one MAC address as a device and counts lookups
+type fakeIdentifier struct {
+	known string
+
+	mu      sync.Mutex
+	lookups int
+}
+
+func (f *fakeIdentifier) LookupDevices(clientIP net.IP, clientMAC net.HardwareAddr) (*opa.DeviceLookup, error) {
+	f.mu.Lock()
+	f.lookups++
+	f.mu.Unlock()
+
+	lookup := &opa.DeviceLookup{Profiles: []string{"child", "quarantine"}}
+	if clientMAC.String() == f.known {
+		lookup.DeviceID = "tablet"
+		lookup.Device = &opa.Device{Name: "Tablet", Profile: "child"}
+	}
+	return lookup, nil
+}
+
+func TestDiscovery(t *testing.T) {
+	r := New(zerolog.Nop())
+	identifier := &fakeIdentifier{known: "aa:bb:cc:dd:ee:ff"}
+	d := NewDiscovery(r, identifier, "quarantine", zerolog.Nop())
+	d.Start()
+
+	known, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	unknown, _ := net.ParseMAC("98:b6:e9:12:34:56")
+	d.Observe(net.ParseIP("192.168.1.10"), known, "", "dns")
+	d.Observe(net.ParseIP("192.168.1.20"), unknown, "switch", "dhcp")
+	d.Observe(net.ParseIP("192.168.1.20"), unknown, "", "proxy")
+	d.Observe(net.ParseIP("192.168.1.57"), nil, "", "proxy")
+	d.Stop()
+
+	if identifier.lookups != 3 {
+		t.Errorf("Expected each client identified once, got %d lookups", identifier.lookups)
+	}
+
+	list := r.Devices()
+	if len(list) != 2 {
+		t.Fatalf("Expected two quarantined devices, got %+v", list)
+	}
+	byMAC, byIP := list[1], list[0]
+	if byMAC.ID != "new-98b6e9123456" || byMAC.Name != "switch" || byMAC.Identifiers[0] != "98:b6:e9:12:34:56" {
+		t.Errorf("Unexpected device for the new MAC: %+v", byMAC)
+	}
+	if !byMAC.Pending || byMAC.Profile != "quarantine" || byMAC.DiscoveredBy != "dhcp" {
+		t.Errorf("Expected a pending quarantined device discovered by DHCP, got %+v", byMAC)
+	}
+	if byIP.ID != "new-192-168-1-57" || byIP.Identifiers[0] != "192.168.1.57" || byIP.DiscoveredBy != "proxy" {
+		t.Errorf("Unexpected device for the client without a MAC: %+v", byIP)
+	}
+
+	// Assigning a profile classifies the device
+	r.Assign("new-98b6e9123456", "child")
+	device, _ := r.Get("new-98b6e9123456")
+	if device.Pending || device.Profile != "child" {
+		t.Errorf("Expected the device classified as child, got %+v", device)
+	}
+	if _, ok := r.Assigned("new-98b6e9123456"); ok {
+		t.Error("Classifying should set the device's profile, not a runtime assignment")
+	}
+}
+
+func TestDiscovery_Nil(t *testing.T) {
+	var d *Discovery
+	d.Observe(net.ParseIP("192.168.1.57"), nil, "", "dns")
+}
@@ -0,0 +1,63 @@
+package devices
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/arp"
+	"github.com/miekg/dns"
+)
+
+var (
+	// arpTable is the kernel's IPv4 neighbour table (Linux)
+	arpTable = arp.DefaultTable
+
+	// mdnsPort is where hosts answer multicast DNS (replaced in tests)
+	mdnsPort = "5353"
+)
+
+// ARPLookup returns the MAC address the kernel has cached for ip, or "" if
+// there is none (IPv6 clients, stale entries, or non-Linux systems)
+func ARPLookup(ip net.IP) (string, error) {
+	table, err := arp.ReadTable(arpTable)
+	if err != nil {
+		return "", err
+	}
+	if mac, ok := table[ip.String()]; ok {
+		return mac.String(), nil
+	}
+	return "", nil
+}
+
+// MDNSLookup asks the host at ip for its own name over multicast DNS, sent
+// unicast to port 5353. Apple devices, Avahi and most printers answer; it
+// returns "" if nothing does before the context ends.
+func MDNSLookup(ctx context.Context, ip net.IP) (string, error) {
+	reverse, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return "", err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(reverse, dns.TypePTR)
+	msg.RecursionDesired = false
+
+	client := &dns.Client{Net: "udp", Timeout: time.Second}
+	if deadline, ok := ctx.Deadline(); ok {
+		client.Timeout = time.Until(deadline)
+	}
+
+	resp, _, err := client.ExchangeContext(ctx, msg, net.JoinHostPort(ip.String(), mdnsPort))
+	if err != nil {
+		// Most hosts don't run a responder; that's not an error worth reporting
+		return "", nil
+	}
+	for _, ans := range resp.Answer {
+		if ptr, ok := ans.(*dns.PTR); ok {
+			return strings.TrimSuffix(ptr.Ptr, "."), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,118 @@
+package dhcp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+)
+
+// VendorOptions are extra options for clients whose vendor class (option
+// 60) starts with VendorClass, e.g. "MSFT" for Windows laptops
+type VendorOptions struct {
+	VendorClass string           // Case-insensitive prefix
+	Options     map[uint8]string // Extra options by code, as for Config.Options
+}
+
+// vendorOptions are compiled VendorOptions
+type vendorOptions struct {
+	prefix  string // Lowercase
+	options []dhcpv4.Option
+}
+
+// rawValue is an option value sent as is
+type rawValue []byte
+
+// ToBytes returns the value
+func (v rawValue) ToBytes() []byte { return v }
+
+// String returns the value in hex
+func (v rawValue) String() string { return hex.EncodeToString(v) }
+
+// parseOptions compiles extra options by code, in code order
+func parseOptions(options map[uint8]string) ([]dhcpv4.Option, error) {
+	codes := make([]int, 0, len(options))
+	for code := range options {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+
+	compiled := make([]dhcpv4.Option, 0, len(codes))
+	for _, code := range codes {
+		if code == 0 || code == 255 {
+			return nil, fmt.Errorf("invalid option code %d", code)
+		}
+		value, err := optionValue(uint8(code), options[uint8(code)])
+		if err != nil {
+			return nil, fmt.Errorf("option %d: %w", code, err)
+		}
+		compiled = append(compiled, dhcpv4.Option{Code: dhcpv4.GenericOptionCode(code), Value: value})
+	}
+	return compiled, nil
+}
+
+// optionValue encodes an option value:
+//   - "hex:" and hex digits (colons allowed) as raw bytes, e.g. vendor-specific
+//     information (43)
+//   - for domain search (119), a comma-separated list of domains
+//   - a comma-separated list of IPv4 addresses as addresses, e.g. NTP
+//     servers (42)
+//   - anything else as text, e.g. a domain name (15) or WPAD URL (252)
+func optionValue(code uint8, value string) (dhcpv4.OptionValue, error) {
+	if digits, ok := strings.CutPrefix(value, "hex:"); ok {
+		raw, err := hex.DecodeString(strings.ReplaceAll(digits, ":", ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex: %q", value)
+		}
+		return rawValue(raw), nil
+	}
+
+	parts := strings.Split(value, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	if code == dhcpv4.OptionDNSDomainSearchList.Code() {
+		labels := &rfc1035label.Labels{Labels: parts}
+		return rawValue(labels.ToBytes()), nil
+	}
+
+	var ips dhcpv4.IPs
+	for _, part := range parts {
+		ip := net.ParseIP(part).To4()
+		if ip == nil {
+			return dhcpv4.String(value), nil
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// addOptions adds the configured extra options to response: global ones,
+// then the scope's, then those for the client's vendor class, each
+// replacing the same option from before
+func (s *Server) addOptions(resp *dhcpv4.DHCPv4, req *dhcpv4.DHCPv4, sc *scope) {
+	for _, option := range s.options {
+		resp.UpdateOption(option)
+	}
+	for _, option := range sc.options {
+		resp.UpdateOption(option)
+	}
+
+	vendorClass := strings.ToLower(req.ClassIdentifier())
+	if vendorClass == "" {
+		return
+	}
+	for _, v := range s.vendors {
+		if !strings.HasPrefix(vendorClass, v.prefix) {
+			continue
+		}
+		for _, option := range v.options {
+			resp.UpdateOption(option)
+		}
+	}
+}
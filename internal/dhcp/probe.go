@@ -0,0 +1,116 @@
+package dhcp
+
+import (
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/arp"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Prober checks whether an address is already in use, e.g. by a device
+// with a static address, before it is offered
+type Prober interface {
+	InUse(ip net.IP) bool
+}
+
+// PingProber checks an address with an ICMP echo request, then the kernel's
+// neighbour table, which catches hosts that drop pings but answer the ARP
+// request the ping caused
+type PingProber struct {
+	timeout time.Duration
+	table   string // Neighbour table, in the /proc/net/arp format
+	logger  zerolog.Logger
+
+	seq      atomic.Uint32
+	warnOnce sync.Once
+}
+
+// NewPingProber creates a prober that waits up to timeout for a reply
+func NewPingProber(timeout time.Duration, logger zerolog.Logger) *PingProber {
+	return &PingProber{
+		timeout: timeout,
+		table:   arp.DefaultTable,
+		logger:  logger.With().Str("component", "dhcp").Logger(),
+	}
+}
+
+// InUse reports whether a host answers at ip
+func (p *PingProber) InUse(ip net.IP) bool {
+	if p.ping(ip) {
+		return true
+	}
+	table, err := arp.ReadTable(p.table)
+	if err != nil {
+		return false
+	}
+	_, ok := table[ip.String()]
+	return ok
+}
+
+// ping sends an echo request to ip and reports whether it was answered
+// within the timeout
+func (p *PingProber) ping(ip net.IP) bool {
+	// Unprivileged ping sockets where the kernel allows them, raw sockets
+	// otherwise (the DHCP server usually runs as root for port 67)
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	conn, err := icmp.ListenPacket("udp4", "0.0.0.0")
+	if err != nil {
+		dst = &net.IPAddr{IP: ip}
+		conn, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	}
+	if err != nil {
+		p.warnOnce.Do(func() {
+			p.logger.Warn().Err(err).Msg("Can't send pings, checking addresses in the ARP table only")
+		})
+		return false
+	}
+	defer func() { _ = conn.Close() }()
+
+	seq := int(p.seq.Add(1) & 0xffff)
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: []byte("kproxy")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return false
+	}
+	if err := conn.SetDeadline(time.Now().Add(p.timeout)); err != nil {
+		return false
+	}
+	if _, err := conn.WriteTo(b, dst); err != nil {
+		return false
+	}
+
+	reply := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(reply)
+		if err != nil {
+			return false // Timed out
+		}
+		m, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), reply[:n])
+		if err != nil || m.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		if echo, ok := m.Body.(*icmp.Echo); ok && echo.Seq == seq && addrIP(peer).Equal(ip) {
+			return true
+		}
+	}
+}
+
+// addrIP is the IP address of a ping socket's peer
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package dhcp
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"golang.org/x/net/ipv4"
+)
+
+// Scope is an additional address pool, e.g. for a VLAN. Clients are matched
+// to a scope by the relay agent that forwarded their request (its address is
+// in Subnet) or, when not relayed, by the interface the request arrived on.
+type Scope struct {
+	Name       string
+	Interface  string           // Answer clients on this interface directly ("" = relayed requests only)
+	Subnet     string           // CIDR, e.g. "192.168.20.0/24"
+	ServerIP   string           // Server identifier ("" = Interface's address, then Config.ServerIP)
+	Gateway    string           // Default gateway
+	DNSServers []string         // DNS servers to advertise ("" = server identifier)
+	LeaseTime  time.Duration    // 0 = Config.LeaseTime
+	RangeStart string           // Start of IP pool
+	RangeEnd   string           // End of IP pool
+	Options    map[uint8]string // Extra options by code, as for Config.Options
+}
+
+// scope is a compiled Scope, or the pool of the top-level Config
+type scope struct {
+	name       string
+	iface      string
+	subnet     *net.IPNet
+	serverIP   net.IP
+	gateway    net.IP
+	dnsServers []net.IP
+	leaseTime  time.Duration
+	poolStart  net.IP
+	poolEnd    net.IP
+	options    []dhcpv4.Option
+}
+
+// defaultScope compiles the pool of the top-level Config, which answers
+// clients on any interface without a scope of its own
+func defaultScope(config Config) (*scope, error) {
+	poolStart := net.ParseIP(config.RangeStart).To4()
+	if poolStart == nil {
+		return nil, fmt.Errorf("invalid range_start IP: %s", config.RangeStart)
+	}
+	poolEnd := net.ParseIP(config.RangeEnd).To4()
+	if poolEnd == nil {
+		return nil, fmt.Errorf("invalid range_end IP: %s", config.RangeEnd)
+	}
+	serverIP := net.ParseIP(config.ServerIP).To4()
+	mask := net.ParseIP(config.SubnetMask).To4()
+	if mask == nil {
+		return nil, fmt.Errorf("invalid subnet_mask: %s", config.SubnetMask)
+	}
+
+	sc := &scope{
+		name:      "default",
+		subnet:    &net.IPNet{IP: serverIP.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)},
+		serverIP:  serverIP,
+		gateway:   net.ParseIP(config.Gateway).To4(),
+		leaseTime: config.LeaseTime,
+		poolStart: poolStart,
+		poolEnd:   poolEnd,
+	}
+	sc.dnsServers = parseIPs(config.DNSServers, serverIP)
+	return sc, nil
+}
+
+// newScope compiles a Scope
+func newScope(s Scope, config Config) (*scope, error) {
+	_, subnet, err := net.ParseCIDR(s.Subnet)
+	if err != nil || subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("scope %s: invalid subnet: %s", s.Name, s.Subnet)
+	}
+	poolStart := net.ParseIP(s.RangeStart).To4()
+	if poolStart == nil || !subnet.Contains(poolStart) {
+		return nil, fmt.Errorf("scope %s: range_start %s is not in %s", s.Name, s.RangeStart, subnet)
+	}
+	poolEnd := net.ParseIP(s.RangeEnd).To4()
+	if poolEnd == nil || !subnet.Contains(poolEnd) || ipToInt(poolEnd) < ipToInt(poolStart) {
+		return nil, fmt.Errorf("scope %s: invalid range_end: %s", s.Name, s.RangeEnd)
+	}
+
+	sc := &scope{
+		name:      s.Name,
+		iface:     s.Interface,
+		subnet:    subnet,
+		gateway:   net.ParseIP(s.Gateway).To4(),
+		leaseTime: s.LeaseTime,
+		poolStart: poolStart,
+		poolEnd:   poolEnd,
+	}
+	if sc.leaseTime <= 0 {
+		sc.leaseTime = config.LeaseTime
+	}
+
+	switch {
+	case s.ServerIP != "":
+		sc.serverIP = net.ParseIP(s.ServerIP).To4()
+		if sc.serverIP == nil {
+			return nil, fmt.Errorf("scope %s: invalid server_ip: %s", s.Name, s.ServerIP)
+		}
+	case s.Interface != "":
+		sc.serverIP, err = interfaceIP(s.Interface, subnet)
+		if err != nil {
+			return nil, fmt.Errorf("scope %s: %w", s.Name, err)
+		}
+	default:
+		sc.serverIP = net.ParseIP(config.ServerIP).To4()
+	}
+	sc.dnsServers = parseIPs(s.DNSServers, sc.serverIP)
+
+	if sc.options, err = parseOptions(s.Options); err != nil {
+		return nil, fmt.Errorf("scope %s: %w", s.Name, err)
+	}
+	return sc, nil
+}
+
+// interfaceIP finds the address of iface in subnet, the server identifier
+// its clients can reach
+func interfaceIP(iface string, subnet *net.IPNet) (net.IP, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", iface, err)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", iface, err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && subnet.Contains(ipNet.IP) {
+			return ipNet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no address in %s (set server_ip)", iface, subnet)
+}
+
+// parseIPs parses addresses, or returns fallback if there are none
+func parseIPs(addrs []string, fallback net.IP) []net.IP {
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			ips = append(ips, ip.To4())
+		}
+	}
+	if len(ips) == 0 {
+		return []net.IP{fallback}
+	}
+	return ips
+}
+
+// contains reports whether ip is in the scope's pool
+func (sc *scope) contains(ip net.IP) bool {
+	if ip == nil || ip.To4() == nil {
+		return false
+	}
+	n := ipToInt(ip.To4())
+	return n >= ipToInt(sc.poolStart) && n <= ipToInt(sc.poolEnd)
+}
+
+// scopeFor finds the scope of a request received on iface ("" when the
+// listener isn't bound to an interface): the scope whose subnet holds the
+// relay agent's address, else the scope of the interface, else the default
+// scope. It returns nil if no scope serves the client.
+func (s *Server) scopeFor(req *dhcpv4.DHCPv4, iface string) *scope {
+	if relay := req.GatewayIPAddr.To4(); relay != nil && !relay.Equal(net.IPv4zero) {
+		for _, sc := range s.scopes {
+			if sc.subnet.Contains(relay) {
+				return sc
+			}
+		}
+		return nil
+	}
+	for _, sc := range s.scopes {
+		if sc.iface == iface && (iface != "" || sc.name == "default") {
+			return sc
+		}
+	}
+	return nil
+}
+
+// skipInterfaces is a listener that ignores requests arriving on interfaces
+// with listeners of their own, which would otherwise be answered twice
+type skipInterfaces struct {
+	net.PacketConn
+	pc   *ipv4.PacketConn
+	skip map[int]bool
+}
+
+// newSkipInterfaces wraps conn to ignore requests arriving on ifaces
+func newSkipInterfaces(conn net.PacketConn, ifaces []string) (*skipInterfaces, error) {
+	skip := make(map[int]bool)
+	for _, name := range ifaces {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("interface %s: %w", name, err)
+		}
+		skip[ifi.Index] = true
+	}
+	pc := ipv4.NewPacketConn(conn)
+	if err := pc.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		return nil, fmt.Errorf("failed to read receiving interfaces: %w", err)
+	}
+	return &skipInterfaces{PacketConn: conn, pc: pc, skip: skip}, nil
+}
+
+// ReadFrom reads the next request that didn't arrive on a skipped interface
+func (c *skipInterfaces) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, cm, peer, err := c.pc.ReadFrom(b)
+		if err != nil || cm == nil || !c.skip[cm.IfIndex] {
+			return n, peer, err
+		}
+	}
+}
@@ -1,13 +1,20 @@
 package dhcp
 
 import (
+	"cmp"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/goodtune/kproxy/internal/clientnames"
+	"github.com/goodtune/kproxy/internal/devices"
+	"github.com/goodtune/kproxy/internal/fingerprint"
 	"github.com/goodtune/kproxy/internal/metrics"
 	"github.com/goodtune/kproxy/internal/policy"
 	"github.com/goodtune/kproxy/internal/storage"
@@ -32,6 +39,22 @@ type Config struct {
 	BootServerName string
 	TFTPIP         string
 	BootURI        string
+	Scopes         []Scope // Additional pools, e.g. per VLAN
+
+	// Architecture-specific TFTP boot files ("" = BootFileName), and the
+	// file clients already running iPXE get instead, e.g. a boot menu
+	UEFIBootFileName  string
+	ARM64BootFileName string
+	IPXEScript        string
+
+	// Extra options by code, e.g. 42 (NTP servers), 119 (domain search) or
+	// 252 (WPAD URL); see optionValue for how values are encoded
+	Options       map[uint8]string
+	VendorOptions []VendorOptions // Extra options by client vendor class
+
+	// How long an address is not offered after a client declined it or a
+	// probe found it in use
+	ConflictHold time.Duration
 }
 
 // Server implements a DHCP server for network boot support
@@ -41,13 +64,37 @@ type Server struct {
 	leaseStore   storage.DHCPLeaseStore
 	logger       zerolog.Logger
 
-	// Server instance
-	server *server4.Server
+	// Fixed addresses of clients (optional)
+	reservations storage.DHCPReservationStore
+
+	// Quarantine of clients seen for the first time (optional)
+	discovery *devices.Discovery
+
+	// Hostnames clients announced for themselves (optional)
+	clientNames *clientnames.Resolver
+
+	// Device type guesses from what clients reveal (optional)
+	fingerprints *fingerprint.Collector
+
+	// Check for addresses in use before offering them (optional)
+	prober Prober
+
+	// Server instances: one for BindAddress and one per scope interface
+	servers []*server4.Server
 
 	// IP pool management
-	poolStart net.IP
-	poolEnd   net.IP
-	mu        sync.RWMutex
+	scopes []*scope
+	mu     sync.RWMutex
+
+	// Extra options for all clients and by vendor class
+	options []dhcpv4.Option
+	vendors []vendorOptions
+
+	// Addresses in conflict, not offered until the time, guarded by mu
+	held map[string]time.Time
+
+	// Addresses being probed before an offer, guarded by mu
+	probing map[string]bool
 
 	// Shutdown coordination
 	ctx    context.Context
@@ -61,24 +108,49 @@ func NewServer(
 	leaseStore storage.DHCPLeaseStore,
 	logger zerolog.Logger,
 ) (*Server, error) {
-	// Parse IP range
-	poolStart := net.ParseIP(config.RangeStart)
-	if poolStart == nil {
-		return nil, fmt.Errorf("invalid range_start IP: %s", config.RangeStart)
-	}
-	poolEnd := net.ParseIP(config.RangeEnd)
-	if poolEnd == nil {
-		return nil, fmt.Errorf("invalid range_end IP: %s", config.RangeEnd)
-	}
-
 	// Validate server IP
-	if net.ParseIP(config.ServerIP) == nil {
+	if net.ParseIP(config.ServerIP).To4() == nil {
 		return nil, fmt.Errorf("invalid server_ip: %s", config.ServerIP)
 	}
 
-	// Validate subnet mask
-	if net.ParseIP(config.SubnetMask) == nil {
-		return nil, fmt.Errorf("invalid subnet_mask: %s", config.SubnetMask)
+	// The top-level range is optional when there are scopes
+	var scopes []*scope
+	if config.RangeStart != "" || len(config.Scopes) == 0 {
+		sc, err := defaultScope(config)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, sc)
+	}
+	interfaces := make(map[string]bool)
+	for _, sCfg := range config.Scopes {
+		sc, err := newScope(sCfg, config)
+		if err != nil {
+			return nil, err
+		}
+		if sc.iface != "" {
+			if interfaces[sc.iface] {
+				return nil, fmt.Errorf("scope %s: interface %s already has a scope", sc.name, sc.iface)
+			}
+			interfaces[sc.iface] = true
+		}
+		scopes = append(scopes, sc)
+	}
+
+	options, err := parseOptions(config.Options)
+	if err != nil {
+		return nil, err
+	}
+	var vendors []vendorOptions
+	for _, v := range config.VendorOptions {
+		if v.VendorClass == "" {
+			return nil, fmt.Errorf("vendor options have no vendor class")
+		}
+		compiled, err := parseOptions(v.Options)
+		if err != nil {
+			return nil, fmt.Errorf("vendor class %s: %w", v.VendorClass, err)
+		}
+		vendors = append(vendors, vendorOptions{prefix: strings.ToLower(v.VendorClass), options: compiled})
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -88,8 +160,11 @@ func NewServer(
 		policyEngine: policyEngine,
 		leaseStore:   leaseStore,
 		logger:       logger.With().Str("component", "dhcp").Logger(),
-		poolStart:    poolStart.To4(),
-		poolEnd:      poolEnd.To4(),
+		scopes:       scopes,
+		options:      options,
+		vendors:      vendors,
+		held:         make(map[string]time.Time),
+		probing:      make(map[string]bool),
 		ctx:          ctx,
 		cancel:       cancel,
 	}
@@ -97,6 +172,34 @@ func NewServer(
 	return s, nil
 }
 
+// SetReservations sets the store of clients' fixed addresses
+func (s *Server) SetReservations(reservations storage.DHCPReservationStore) {
+	s.reservations = reservations
+}
+
+// SetDiscovery sets the discovery that quarantines new clients
+func (s *Server) SetDiscovery(d *devices.Discovery) {
+	s.discovery = d
+}
+
+// SetClientNames sets the resolver that names clients by their announced
+// hostnames
+func (s *Server) SetClientNames(r *clientnames.Resolver) {
+	s.clientNames = r
+}
+
+// SetFingerprints sets the collector that guesses device types from what
+// clients reveal about themselves
+func (s *Server) SetFingerprints(c *fingerprint.Collector) {
+	s.fingerprints = c
+}
+
+// SetProber sets the prober that checks addresses aren't in use before
+// they are offered
+func (s *Server) SetProber(p Prober) {
+	s.prober = p
+}
+
 // Start starts the DHCP server
 func (s *Server) Start() error {
 	laddr := &net.UDPAddr{
@@ -104,27 +207,57 @@ func (s *Server) Start() error {
 		Port: s.config.Port,
 	}
 
-	s.logger.Info().
-		Str("addr", laddr.String()).
-		Str("range", fmt.Sprintf("%s-%s", s.config.RangeStart, s.config.RangeEnd)).
-		Msg("Starting DHCP server")
+	var interfaces []string
+	for _, sc := range s.scopes {
+		s.logger.Info().
+			Str("addr", laddr.String()).
+			Str("scope", sc.name).
+			Str("interface", sc.iface).
+			Str("range", fmt.Sprintf("%s-%s", sc.poolStart, sc.poolEnd)).
+			Msg("Starting DHCP server")
+		if sc.iface != "" {
+			interfaces = append(interfaces, sc.iface)
+		}
+	}
 
-	// Create DHCP server with the interface
-	server, err := server4.NewServer("", laddr, s.handleDHCP, server4.WithDebugLogger())
+	// The main listener answers relayed requests and the default scope;
+	// requests on scope interfaces are left to their own listeners
+	conn, err := server4.NewIPv4UDPConn("", laddr)
 	if err != nil {
 		return fmt.Errorf("failed to create DHCP server: %w", err)
 	}
+	var mainConn net.PacketConn = conn
+	if len(interfaces) > 0 {
+		if mainConn, err = newSkipInterfaces(conn, interfaces); err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("failed to create DHCP server: %w", err)
+		}
+	}
+	server, err := server4.NewServer("", laddr, s.handler(""), server4.WithConn(mainConn), server4.WithDebugLogger())
+	if err != nil {
+		return fmt.Errorf("failed to create DHCP server: %w", err)
+	}
+	s.servers = append(s.servers, server)
 
-	s.server = server
-
-	// Start server in goroutine
-	errChan := make(chan error, 1)
-	go func() {
-		if err := s.server.Serve(); err != nil {
-			s.logger.Error().Err(err).Msg("DHCP server error")
-			errChan <- err
+	for _, iface := range interfaces {
+		server, err := server4.NewServer(iface, laddr, s.handler(iface), server4.WithDebugLogger())
+		if err != nil {
+			_ = s.closeServers()
+			return fmt.Errorf("failed to create DHCP server on %s: %w", iface, err)
 		}
-	}()
+		s.servers = append(s.servers, server)
+	}
+
+	// Start servers in goroutines
+	errChan := make(chan error, len(s.servers))
+	for _, server := range s.servers {
+		go func() {
+			if err := server.Serve(); err != nil {
+				s.logger.Error().Err(err).Msg("DHCP server error")
+				errChan <- err
+			}
+		}()
+	}
 
 	// Wait briefly for startup errors
 	select {
@@ -141,33 +274,63 @@ func (s *Server) Stop() error {
 	s.logger.Info().Msg("Stopping DHCP server")
 	s.cancel()
 
-	if s.server != nil {
-		if err := s.server.Close(); err != nil {
-			return fmt.Errorf("failed to stop DHCP server: %w", err)
-		}
+	if err := s.closeServers(); err != nil {
+		return fmt.Errorf("failed to stop DHCP server: %w", err)
 	}
 
 	s.logger.Info().Msg("DHCP server stopped")
 	return nil
 }
 
+// closeServers closes the listeners
+func (s *Server) closeServers() error {
+	var errs []error
+	for _, server := range s.servers {
+		if err := server.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.servers = nil
+	return errors.Join(errs...)
+}
+
+// handler handles the requests of the listener on iface ("" for the main
+// listener)
+func (s *Server) handler(iface string) server4.Handler {
+	return func(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+		s.handleDHCP(conn, peer, m, iface)
+	}
+}
+
 // handleDHCP processes incoming DHCP requests
-func (s *Server) handleDHCP(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
+func (s *Server) handleDHCP(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4, iface string) {
 	s.logger.Debug().
 		Str("type", m.MessageType().String()).
 		Str("mac", m.ClientHWAddr.String()).
 		Str("peer", peer.String()).
+		Str("interface", iface).
 		Msg("Received DHCP request")
 
 	var response *dhcpv4.DHCPv4
 	var err error
 
+	// Releases and declines need no scope; offers and leases do
+	sc := s.scopeFor(m, iface)
+	if sc == nil && (m.MessageType() == dhcpv4.MessageTypeDiscover || m.MessageType() == dhcpv4.MessageTypeRequest) {
+		s.logger.Debug().
+			Str("mac", m.ClientHWAddr.String()).
+			Str("relay", m.GatewayIPAddr.String()).
+			Str("interface", iface).
+			Msg("No DHCP scope for request, ignoring")
+		return
+	}
+
 	switch m.MessageType() {
 	case dhcpv4.MessageTypeDiscover:
-		response, err = s.handleDiscover(m)
+		response, err = s.handleDiscover(m, sc)
 		metrics.DHCPRequestsTotal.WithLabelValues("discover").Inc()
 	case dhcpv4.MessageTypeRequest:
-		response, err = s.handleRequest(m)
+		response, err = s.handleRequest(m, sc)
 		metrics.DHCPRequestsTotal.WithLabelValues("request").Inc()
 	case dhcpv4.MessageTypeRelease:
 		if err = s.handleRelease(m); err != nil {
@@ -209,18 +372,24 @@ func (s *Server) handleDHCP(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4
 	}
 }
 
-// handleDiscover processes DHCP DISCOVER messages
-func (s *Server) handleDiscover(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+// handleDiscover processes DHCP DISCOVER messages for scope sc
+func (s *Server) handleDiscover(req *dhcpv4.DHCPv4, sc *scope) (*dhcpv4.DHCPv4, error) {
 	mac := req.ClientHWAddr.String()
 
+	// A client with a reservation is always offered its address
+	if reserved := s.reservedIP(mac, sc); reserved != nil {
+		return s.createOffer(req, sc, reserved)
+	}
+
 	// Check for existing lease
 	lease, err := s.leaseStore.GetByMAC(s.ctx, mac)
 	var offerIP net.IP
 
 	if err == nil && lease != nil && !lease.IsExpired() {
-		// Check if existing lease IP is still in the current pool
+		// Check if existing lease IP is still in the current pool and not
+		// since reserved for another client
 		existingIP := net.ParseIP(lease.IP)
-		if s.isIPInPool(existingIP) {
+		if sc.contains(existingIP) && !s.reservedIPs()[lease.IP] {
 			// Reuse existing lease if IP is still in pool
 			offerIP = existingIP
 			s.logger.Debug().
@@ -228,15 +397,16 @@ func (s *Server) handleDiscover(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 				Str("ip", lease.IP).
 				Msg("Reusing existing lease")
 		} else {
-			// Old lease is outside current pool, delete and allocate new IP
+			// Old lease is outside current pool or reserved, delete and
+			// allocate new IP
 			s.logger.Info().
 				Str("mac", mac).
 				Str("old_ip", lease.IP).
-				Msg("Existing lease outside pool range, allocating new IP")
+				Msg("Existing lease outside pool range or reserved, allocating new IP")
 			if err := s.leaseStore.Delete(s.ctx, mac); err != nil {
 				s.logger.Warn().Err(err).Msg("Failed to delete old lease")
 			}
-			offerIP, err = s.allocateIP(mac)
+			offerIP, err = s.allocateIP(sc)
 			if err != nil {
 				s.logger.Error().Err(err).Str("mac", mac).Msg("Failed to allocate IP")
 				return nil, err
@@ -248,7 +418,7 @@ func (s *Server) handleDiscover(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 		}
 	} else {
 		// Allocate new IP
-		offerIP, err = s.allocateIP(mac)
+		offerIP, err = s.allocateIP(sc)
 		if err != nil {
 			s.logger.Error().Err(err).Str("mac", mac).Msg("Failed to allocate IP")
 			return nil, err
@@ -259,7 +429,11 @@ func (s *Server) handleDiscover(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 			Msg("Allocated new IP")
 	}
 
-	// Create DHCP OFFER
+	return s.createOffer(req, sc, offerIP)
+}
+
+// createOffer creates a DHCP OFFER of offerIP in scope sc
+func (s *Server) createOffer(req *dhcpv4.DHCPv4, sc *scope, offerIP net.IP) (*dhcpv4.DHCPv4, error) {
 	offer, err := dhcpv4.NewReplyFromRequest(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create reply: %w", err)
@@ -267,10 +441,11 @@ func (s *Server) handleDiscover(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 
 	offer.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
 	offer.YourIPAddr = offerIP
-	offer.ServerIPAddr = net.ParseIP(s.config.ServerIP)
+	offer.ServerIPAddr = sc.serverIP
 
-	// Add standard options
-	s.addStandardOptions(offer)
+	// Add standard and configured options
+	s.addStandardOptions(offer, sc)
+	s.addOptions(offer, req, sc)
 
 	// Add boot options for PXE/netboot
 	s.addBootOptions(offer, req)
@@ -278,8 +453,8 @@ func (s *Server) handleDiscover(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 	return offer, nil
 }
 
-// handleRequest processes DHCP REQUEST messages
-func (s *Server) handleRequest(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+// handleRequest processes DHCP REQUEST messages for scope sc
+func (s *Server) handleRequest(req *dhcpv4.DHCPv4, sc *scope) (*dhcpv4.DHCPv4, error) {
 	mac := req.ClientHWAddr.String()
 	requestedIP := req.RequestedIPAddress()
 
@@ -288,12 +463,35 @@ func (s *Server) handleRequest(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 		requestedIP = req.ClientIPAddr
 	}
 
-	if !s.isIPInPool(requestedIP) {
+	// A client with a reservation gets only its reserved address, which
+	// may be outside the pool; others can't take a reserved address
+	reserved := s.reservedIP(mac, sc)
+	switch {
+	case reserved != nil && !reserved.Equal(requestedIP):
+		s.logger.Info().
+			Str("mac", mac).
+			Str("ip", requestedIP.String()).
+			Str("reserved_ip", reserved.String()).
+			Msg("Requested IP is not the client's reservation, sending NAK")
+		return s.createNAK(req, sc), nil
+	case reserved == nil && !sc.contains(requestedIP):
 		s.logger.Warn().
 			Str("mac", mac).
 			Str("ip", requestedIP.String()).
 			Msg("Requested IP not in pool, sending NAK")
-		return s.createNAK(req), nil
+		return s.createNAK(req, sc), nil
+	case reserved == nil && s.reservedIPs()[requestedIP.String()]:
+		s.logger.Warn().
+			Str("mac", mac).
+			Str("ip", requestedIP.String()).
+			Msg("Requested IP is reserved for another client, sending NAK")
+		return s.createNAK(req, sc), nil
+	case reserved == nil && s.isHeld(requestedIP):
+		s.logger.Warn().
+			Str("mac", mac).
+			Str("ip", requestedIP.String()).
+			Msg("Requested IP is held after a conflict, sending NAK")
+		return s.createNAK(req, sc), nil
 	}
 
 	// Create or update lease
@@ -301,7 +499,7 @@ func (s *Server) handleRequest(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 		MAC:       mac,
 		IP:        requestedIP.String(),
 		Hostname:  req.HostName(),
-		ExpiresAt: time.Now().Add(s.config.LeaseTime),
+		ExpiresAt: time.Now().Add(sc.leaseTime),
 	}
 
 	if err := s.leaseStore.Create(s.ctx, lease); err != nil {
@@ -315,7 +513,11 @@ func (s *Server) handleRequest(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 		Str("mac", mac).
 		Str("ip", requestedIP.String()).
 		Str("hostname", lease.Hostname).
+		Str("scope", sc.name).
 		Msg("Assigned IP lease")
+	s.clientNames.Learn(requestedIP, req.ClientHWAddr, lease.Hostname, "dhcp")
+	s.fingerprints.ObserveDHCP(requestedIP, req.ClientHWAddr, parameterList(req), req.ClassIdentifier())
+	s.discovery.Observe(requestedIP, req.ClientHWAddr, lease.Hostname, "dhcp")
 
 	// Create DHCP ACK
 	ack, err := dhcpv4.NewReplyFromRequest(req)
@@ -325,10 +527,11 @@ func (s *Server) handleRequest(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 
 	ack.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
 	ack.YourIPAddr = requestedIP
-	ack.ServerIPAddr = net.ParseIP(s.config.ServerIP)
+	ack.ServerIPAddr = sc.serverIP
 
-	// Add standard options
-	s.addStandardOptions(ack)
+	// Add standard and configured options
+	s.addStandardOptions(ack, sc)
+	s.addOptions(ack, req, sc)
 
 	// Add boot options for PXE/netboot
 	s.addBootOptions(ack, req)
@@ -336,6 +539,16 @@ func (s *Server) handleRequest(req *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
 	return ack, nil
 }
 
+// parameterList is the option codes a client asked for (option 55), in
+// the order it asked
+func parameterList(req *dhcpv4.DHCPv4) []uint8 {
+	var codes []uint8
+	for _, code := range req.ParameterRequestList() {
+		codes = append(codes, code.Code())
+	}
+	return codes
+}
+
 // handleRelease processes DHCP RELEASE messages
 func (s *Server) handleRelease(req *dhcpv4.DHCPv4) error {
 	mac := req.ClientHWAddr.String()
@@ -366,7 +579,12 @@ func (s *Server) handleDecline(req *dhcpv4.DHCPv4) error {
 		Str("ip", requestedIP.String()).
 		Msg("Client declined IP address (possible conflict)")
 
-	// Mark IP as unavailable temporarily or remove lease
+	// Hold the address so it isn't offered again, and remove the lease
+	if requestedIP != nil {
+		s.mu.Lock()
+		s.hold(requestedIP, "decline")
+		s.mu.Unlock()
+	}
 	if err := s.leaseStore.Delete(s.ctx, mac); err != nil {
 		return err
 	}
@@ -374,38 +592,43 @@ func (s *Server) handleDecline(req *dhcpv4.DHCPv4) error {
 	return nil
 }
 
-// addStandardOptions adds standard DHCP options to response
-func (s *Server) addStandardOptions(resp *dhcpv4.DHCPv4) {
+// addStandardOptions adds the standard DHCP options of scope sc to response
+func (s *Server) addStandardOptions(resp *dhcpv4.DHCPv4, sc *scope) {
 	// Subnet mask
-	mask := net.IPMask(net.ParseIP(s.config.SubnetMask).To4())
-	resp.UpdateOption(dhcpv4.OptSubnetMask(mask))
+	resp.UpdateOption(dhcpv4.OptSubnetMask(sc.subnet.Mask))
 
 	// Router (gateway)
-	if s.config.Gateway != "" {
-		resp.UpdateOption(dhcpv4.OptRouter(net.ParseIP(s.config.Gateway)))
+	if sc.gateway != nil {
+		resp.UpdateOption(dhcpv4.OptRouter(sc.gateway))
 	}
 
-	// DNS servers
-	var dnsServers []net.IP
-	if len(s.config.DNSServers) > 0 {
-		for _, dns := range s.config.DNSServers {
-			dnsServers = append(dnsServers, net.ParseIP(dns))
-		}
-	} else {
-		// Use server IP as DNS if none specified
-		dnsServers = []net.IP{net.ParseIP(s.config.ServerIP)}
-	}
-	resp.UpdateOption(dhcpv4.OptDNS(dnsServers...))
+	// DNS servers (the server IP if none specified)
+	resp.UpdateOption(dhcpv4.OptDNS(sc.dnsServers...))
 
 	// Lease time
-	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(s.config.LeaseTime))
+	resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(sc.leaseTime))
 
 	// Server identifier
-	resp.UpdateOption(dhcpv4.OptServerIdentifier(net.ParseIP(s.config.ServerIP)))
+	resp.UpdateOption(dhcpv4.OptServerIdentifier(sc.serverIP))
 }
 
 // addBootOptions adds PXE/netboot options to response
 func (s *Server) addBootOptions(resp *dhcpv4.DHCPv4, req *dhcpv4.DHCPv4) {
+	// Clients that already loaded iPXE get its script rather than iPXE
+	// again, which would loop
+	if s.config.IPXEScript != "" && isIPXE(req) {
+		resp.UpdateOption(dhcpv4.OptBootFileName(s.config.IPXEScript))
+		if s.config.TFTPIP != "" {
+			resp.ServerIPAddr = net.ParseIP(s.config.TFTPIP)
+		}
+		s.logger.Debug().
+			Str("mac", req.ClientHWAddr.String()).
+			Str("mode", "iPXE").
+			Str("file", s.config.IPXEScript).
+			Msg("Configured iPXE script")
+		return
+	}
+
 	// Check if client is requesting PXE boot (option 93 - client architecture)
 	if req.Options.Has(dhcpv4.OptionClientSystemArchitectureType) {
 		s.logger.Debug().
@@ -419,7 +642,8 @@ func (s *Server) addBootOptions(resp *dhcpv4.DHCPv4, req *dhcpv4.DHCPv4) {
 		if len(archType) >= 2 {
 			arch := binary.BigEndian.Uint16(archType)
 
-			// 0x0000 = BIOS, 0x0007 = UEFI x64, 0x0009 = UEFI x64 HTTP
+			// 0x0000 = BIOS, 0x0007 = UEFI x64, 0x0009 = UEFI x64 HTTP,
+			// 0x000b = UEFI ARM64
 			switch arch {
 			case 0x0000:
 				// BIOS PXE boot
@@ -452,9 +676,9 @@ func (s *Server) addBootOptions(resp *dhcpv4.DHCPv4, req *dhcpv4.DHCPv4) {
 						Str("mode", "UEFI-HTTP").
 						Str("uri", s.config.BootURI).
 						Msg("Configured UEFI HTTP boot")
-				} else if s.config.BootFileName != "" {
+				} else if bootFile := cmp.Or(s.config.UEFIBootFileName, s.config.BootFileName); bootFile != "" {
 					// Fallback to TFTP boot
-					resp.UpdateOption(dhcpv4.OptBootFileName(s.config.BootFileName))
+					resp.UpdateOption(dhcpv4.OptBootFileName(bootFile))
 					if s.config.TFTPIP != "" {
 						resp.ServerIPAddr = net.ParseIP(s.config.TFTPIP)
 					}
@@ -462,39 +686,93 @@ func (s *Server) addBootOptions(resp *dhcpv4.DHCPv4, req *dhcpv4.DHCPv4) {
 					s.logger.Debug().
 						Str("mac", req.ClientHWAddr.String()).
 						Str("mode", "UEFI-TFTP").
-						Str("file", s.config.BootFileName).
+						Str("file", bootFile).
 						Msg("Configured UEFI TFTP boot")
 				}
+			case 0x000b:
+				// UEFI ARM64 TFTP boot
+				if bootFile := cmp.Or(s.config.ARM64BootFileName, s.config.BootFileName); bootFile != "" {
+					resp.UpdateOption(dhcpv4.OptBootFileName(bootFile))
+					if s.config.TFTPIP != "" {
+						resp.ServerIPAddr = net.ParseIP(s.config.TFTPIP)
+					}
+
+					s.logger.Debug().
+						Str("mac", req.ClientHWAddr.String()).
+						Str("mode", "UEFI-ARM64").
+						Str("file", bootFile).
+						Msg("Configured UEFI ARM64 TFTP boot")
+				}
 			}
 		}
 	}
 }
 
-// allocateIP finds an available IP address in the pool
-func (s *Server) allocateIP(mac string) (net.IP, error) {
+// isIPXE reports whether a request comes from iPXE, which identifies
+// itself with user class "iPXE" (option 77) and its own options (175)
+func isIPXE(req *dhcpv4.DHCPv4) bool {
+	if req.Options.Has(dhcpv4.GenericOptionCode(175)) {
+		return true
+	}
+	return slices.Contains(req.UserClass(), "iPXE")
+}
+
+// allocateIP finds an available IP address in the pool of scope sc. With a
+// prober, each candidate is probed without holding mu, so a host slow to
+// answer doesn't stall every other client.
+func (s *Server) allocateIP(sc *scope) (net.IP, error) {
+	for {
+		ip, err := s.nextFreeIP(sc)
+		if err != nil || s.prober == nil {
+			return ip, err
+		}
+		inUse := s.prober.InUse(ip)
+
+		s.mu.Lock()
+		delete(s.probing, ip.String())
+		free := false
+		if inUse {
+			s.logger.Warn().
+				Str("ip", ip.String()).
+				Str("scope", sc.name).
+				Msg("Address in use by an unknown host, skipping")
+			s.hold(ip, "probe")
+		} else if allocated, err := s.allocatedIPs(); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		} else {
+			// Leased, reserved or declined while it was probed
+			free = !allocated[ip.String()] && !time.Now().Before(s.held[ip.String()])
+		}
+		s.mu.Unlock()
+		if free {
+			return ip, nil
+		}
+	}
+}
+
+// nextFreeIP returns the first address in the pool of scope sc that isn't
+// allocated, held or being probed. With a prober, the address is marked as
+// being probed, for the caller to clear.
+func (s *Server) nextFreeIP(sc *scope) (net.IP, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Get all active leases
-	leases, err := s.leaseStore.List(s.ctx)
+	allocated, err := s.allocatedIPs()
 	if err != nil {
-		return nil, fmt.Errorf("failed to list leases: %w", err)
-	}
-
-	// Build map of allocated IPs
-	allocated := make(map[string]bool)
-	for _, lease := range leases {
-		if !lease.IsExpired() {
-			allocated[lease.IP] = true
-		}
+		return nil, err
 	}
 
-	// Find first available IP in pool
-	current := make(net.IP, len(s.poolStart))
-	copy(current, s.poolStart)
+	current := make(net.IP, len(sc.poolStart))
+	copy(current, sc.poolStart)
 
+	now := time.Now()
 	for {
-		if !allocated[current.String()] {
+		key := current.String()
+		if !allocated[key] && !now.Before(s.held[key]) && !s.probing[key] {
+			if s.prober != nil {
+				s.probing[key] = true
+			}
 			return current, nil
 		}
 
@@ -502,30 +780,90 @@ func (s *Server) allocateIP(mac string) (net.IP, error) {
 		current = nextIP(current)
 
 		// Check if we've exceeded pool range
-		if ipToInt(current) > ipToInt(s.poolEnd) {
-			return nil, fmt.Errorf("no available IPs in pool")
+		if ipToInt(current) > ipToInt(sc.poolEnd) {
+			return nil, fmt.Errorf("no available IPs in pool of scope %s", sc.name)
 		}
 	}
 }
 
-// isIPInPool checks if an IP is within the configured pool range
-func (s *Server) isIPInPool(ip net.IP) bool {
-	if ip == nil {
-		return false
+// allocatedIPs returns the set of reserved and actively leased addresses
+func (s *Server) allocatedIPs() (map[string]bool, error) {
+	leases, err := s.leaseStore.List(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list leases: %w", err)
 	}
 
-	ipInt := ipToInt(ip.To4())
-	startInt := ipToInt(s.poolStart)
-	endInt := ipToInt(s.poolEnd)
+	allocated := s.reservedIPs()
+	for _, lease := range leases {
+		if !lease.IsExpired() {
+			allocated[lease.IP] = true
+		}
+	}
+	return allocated, nil
+}
 
-	return ipInt >= startInt && ipInt <= endInt
+// hold stops ip being offered for the conflict hold-down time. The caller
+// must hold mu.
+func (s *Server) hold(ip net.IP, source string) {
+	now := time.Now()
+	for held, until := range s.held {
+		if !now.Before(until) {
+			delete(s.held, held)
+		}
+	}
+	s.held[ip.String()] = now.Add(s.config.ConflictHold)
+	metrics.DHCPConflictsTotal.WithLabelValues(source).Inc()
+}
+
+// isHeld reports whether ip is held after a conflict
+func (s *Server) isHeld(ip net.IP) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return time.Now().Before(s.held[ip.String()])
+}
+
+// reservedIP returns the address reserved for mac in the subnet of scope
+// sc, or nil if it has none there
+func (s *Server) reservedIP(mac string, sc *scope) net.IP {
+	if s.reservations == nil {
+		return nil
+	}
+	reservation, err := s.reservations.Get(s.ctx, mac)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			s.logger.Warn().Err(err).Str("mac", mac).Msg("Failed to look up reservation")
+		}
+		return nil
+	}
+	ip := net.ParseIP(reservation.IP).To4()
+	if ip == nil || !sc.subnet.Contains(ip) {
+		return nil
+	}
+	return ip
+}
+
+// reservedIPs returns the set of reserved addresses
+func (s *Server) reservedIPs() map[string]bool {
+	reserved := make(map[string]bool)
+	if s.reservations == nil {
+		return reserved
+	}
+	reservations, err := s.reservations.List(s.ctx)
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("Failed to list reservations")
+		return reserved
+	}
+	for _, reservation := range reservations {
+		reserved[reservation.IP] = true
+	}
+	return reserved
 }
 
-// createNAK creates a DHCP NAK response
-func (s *Server) createNAK(req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+// createNAK creates a DHCP NAK response from scope sc
+func (s *Server) createNAK(req *dhcpv4.DHCPv4, sc *scope) *dhcpv4.DHCPv4 {
 	nak, _ := dhcpv4.NewReplyFromRequest(req)
 	nak.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
-	nak.ServerIPAddr = net.ParseIP(s.config.ServerIP)
+	nak.ServerIPAddr = sc.serverIP
 	return nak
 }
 
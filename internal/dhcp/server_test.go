@@ -0,0 +1,406 @@
+package dhcp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/rs/zerolog"
+)
+
+func TestReservations(t *testing.T) {
+	store := memory.Open()
+	s, err := NewServer(Config{
+		ServerIP:   "192.168.1.1",
+		SubnetMask: "255.255.255.0",
+		LeaseTime:  time.Hour,
+		RangeStart: "192.168.1.100",
+		RangeEnd:   "192.168.1.101",
+	}, nil, store.DHCPLeases(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetReservations(store.DHCPReservations())
+
+	ctx := context.Background()
+	printer, _ := net.ParseMAC("aa:bb:cc:dd:ee:01")
+	laptop, _ := net.ParseMAC("aa:bb:cc:dd:ee:02")
+	_ = store.DHCPReservations().Put(ctx, storage.DHCPReservation{MAC: printer.String(), IP: "192.168.1.20"})
+	_ = store.DHCPReservations().Put(ctx, storage.DHCPReservation{MAC: "aa:bb:cc:dd:ee:03", IP: "192.168.1.100"})
+
+	discover := func(mac net.HardwareAddr) net.IP {
+		t.Helper()
+		req, _ := dhcpv4.NewDiscovery(mac)
+		offer, err := s.handleDiscover(req, s.scopeFor(req, ""))
+		if err != nil {
+			t.Fatalf("handleDiscover failed: %v", err)
+		}
+		return offer.YourIPAddr
+	}
+	request := func(mac net.HardwareAddr, ip string) dhcpv4.MessageType {
+		t.Helper()
+		req, _ := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest), dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP(ip))))
+		reply, err := s.handleRequest(req, s.scopeFor(req, ""))
+		if err != nil {
+			t.Fatalf("handleRequest failed: %v", err)
+		}
+		return reply.MessageType()
+	}
+
+	// Reserved addresses may be outside the pool
+	if ip := discover(printer); !ip.Equal(net.ParseIP("192.168.1.20")) {
+		t.Errorf("Expected the printer offered its reservation, got %v", ip)
+	}
+	if got := request(printer, "192.168.1.20"); got != dhcpv4.MessageTypeAck {
+		t.Errorf("Expected the reservation acknowledged, got %v", got)
+	}
+	if got := request(printer, "192.168.1.101"); got != dhcpv4.MessageTypeNak {
+		t.Errorf("Expected a client with a reservation refused other addresses, got %v", got)
+	}
+
+	// Other clients skip reserved addresses in the pool
+	if ip := discover(laptop); !ip.Equal(net.ParseIP("192.168.1.101")) {
+		t.Errorf("Expected the laptop offered the first unreserved address, got %v", ip)
+	}
+	if got := request(laptop, "192.168.1.100"); got != dhcpv4.MessageTypeNak {
+		t.Errorf("Expected another client's reservation refused, got %v", got)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	config := Config{
+		ServerIP:   "192.168.1.1",
+		SubnetMask: "255.255.255.0",
+		LeaseTime:  time.Hour,
+		RangeStart: "192.168.1.100",
+		RangeEnd:   "192.168.1.200",
+		Scopes: []Scope{
+			{
+				Name:       "iot",
+				Subnet:     "10.0.20.0/24",
+				Gateway:    "10.0.20.1",
+				LeaseTime:  10 * time.Minute,
+				RangeStart: "10.0.20.100",
+				RangeEnd:   "10.0.20.150",
+				Options:    map[uint8]string{42: "10.0.20.1", 15: "iot.home.arpa"},
+			},
+			{
+				Name:       "guest",
+				Interface:  "vlan30",
+				Subnet:     "10.0.30.0/24",
+				ServerIP:   "10.0.30.1",
+				RangeStart: "10.0.30.10",
+				RangeEnd:   "10.0.30.20",
+			},
+		},
+	}
+	s, err := NewServer(config, nil, memory.Open().DHCPLeases(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:10")
+	discover := func(relay string, iface string) *dhcpv4.DHCPv4 {
+		t.Helper()
+		req, _ := dhcpv4.NewDiscovery(mac)
+		if relay != "" {
+			req.GatewayIPAddr = net.ParseIP(relay)
+		}
+		sc := s.scopeFor(req, iface)
+		if sc == nil {
+			return nil
+		}
+		offer, err := s.handleDiscover(req, sc)
+		if err != nil {
+			t.Fatalf("handleDiscover failed: %v", err)
+		}
+		return offer
+	}
+
+	// Relayed requests are matched by the relay's subnet
+	offer := discover("10.0.20.1", "")
+	if offer == nil || !offer.YourIPAddr.Equal(net.ParseIP("10.0.20.100")) {
+		t.Fatalf("Expected an offer from the iot scope, got %v", offer)
+	}
+	if got := offer.IPAddressLeaseTime(0); got != 10*time.Minute {
+		t.Errorf("Expected the scope's lease time, got %v", got)
+	}
+	if got := offer.ServerIdentifier(); !got.Equal(net.ParseIP("192.168.1.1")) {
+		t.Errorf("Expected the server IP as identifier for relayed clients, got %v", got)
+	}
+	if got := dhcpv4.GetIPs(dhcpv4.OptionNTPServers, offer.Options); len(got) != 1 || !got[0].Equal(net.ParseIP("10.0.20.1")) {
+		t.Errorf("Expected the NTP server option, got %v", got)
+	}
+	if got := dhcpv4.GetString(dhcpv4.OptionDomainName, offer.Options); got != "iot.home.arpa" {
+		t.Errorf("Expected the domain name option, got %q", got)
+	}
+	if discover("172.16.0.1", "") != nil {
+		t.Error("Expected requests from unknown relays ignored")
+	}
+
+	// Direct requests are matched by the receiving interface
+	if offer := discover("", "vlan30"); offer == nil || !offer.YourIPAddr.Equal(net.ParseIP("10.0.30.10")) {
+		t.Errorf("Expected an offer from the guest scope, got %v", offer)
+	} else if got := offer.ServerIdentifier(); !got.Equal(net.ParseIP("10.0.30.1")) {
+		t.Errorf("Expected the scope's server IP as identifier, got %v", got)
+	}
+	if offer := discover("", ""); offer == nil || !offer.YourIPAddr.Equal(net.ParseIP("192.168.1.100")) {
+		t.Errorf("Expected an offer from the default range, got %v", offer)
+	}
+
+	// An address of another scope is refused
+	req, _ := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.168.1.100"))), dhcpv4.WithGatewayIP(net.ParseIP("10.0.20.1")))
+	reply, err := s.handleRequest(req, s.scopeFor(req, ""))
+	if err != nil || reply.MessageType() != dhcpv4.MessageTypeNak {
+		t.Errorf("Expected a NAK for another scope's address, got %v (%v)", reply, err)
+	}
+
+	// Scopes must not share an interface
+	config.Scopes[0].Interface = "vlan30"
+	config.Scopes[0].ServerIP = "10.0.20.1"
+	if _, err := NewServer(config, nil, memory.Open().DHCPLeases(), zerolog.Nop()); err == nil {
+		t.Error("Expected an error for two scopes on one interface")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	config := Config{
+		ServerIP:   "192.168.1.1",
+		SubnetMask: "255.255.255.0",
+		LeaseTime:  time.Hour,
+		RangeStart: "192.168.1.100",
+		RangeEnd:   "192.168.1.200",
+		Options: map[uint8]string{
+			42:  "192.168.1.1, 192.168.1.2",
+			119: "home.arpa, example.com",
+			252: "http://wpad.home.arpa/proxy.pac",
+		},
+		VendorOptions: []VendorOptions{
+			{VendorClass: "MSFT", Options: map[uint8]string{252: "http://192.168.1.1/laptops.pac", 43: "hex:01:04:c0:a8:01:01"}},
+		},
+	}
+	s, err := NewServer(config, nil, memory.Open().DHCPLeases(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offer := func(vendorClass string) *dhcpv4.DHCPv4 {
+		t.Helper()
+		mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:20")
+		req, _ := dhcpv4.NewDiscovery(mac)
+		if vendorClass != "" {
+			req.UpdateOption(dhcpv4.OptClassIdentifier(vendorClass))
+		}
+		offer, err := s.handleDiscover(req, s.scopeFor(req, ""))
+		if err != nil {
+			t.Fatalf("handleDiscover failed: %v", err)
+		}
+		return offer
+	}
+
+	phone := offer("android-dhcp-14")
+	if got := dhcpv4.GetIPs(dhcpv4.OptionNTPServers, phone.Options); len(got) != 2 {
+		t.Errorf("Expected two NTP servers, got %v", got)
+	}
+	if got := phone.DomainSearch(); got == nil || len(got.Labels) != 2 || got.Labels[1] != "example.com" {
+		t.Errorf("Expected the domain search list, got %v", got)
+	}
+	if got := string(phone.Options.Get(dhcpv4.GenericOptionCode(252))); got != "http://wpad.home.arpa/proxy.pac" {
+		t.Errorf("Expected the global WPAD URL, got %q", got)
+	}
+
+	laptop := offer("MSFT 5.0")
+	if got := string(laptop.Options.Get(dhcpv4.GenericOptionCode(252))); got != "http://192.168.1.1/laptops.pac" {
+		t.Errorf("Expected the vendor class's WPAD URL, got %q", got)
+	}
+	if got := laptop.Options.Get(dhcpv4.OptionVendorSpecificInformation); len(got) != 6 || got[0] != 1 || got[5] != 1 {
+		t.Errorf("Expected the raw vendor-specific option, got %v", got)
+	}
+	if got := dhcpv4.GetIPs(dhcpv4.OptionNTPServers, laptop.Options); len(got) != 2 {
+		t.Errorf("Expected the global options too, got %v", got)
+	}
+
+	config.Options = map[uint8]string{43: "hex:zz"}
+	if _, err := NewServer(config, nil, memory.Open().DHCPLeases(), zerolog.Nop()); err == nil {
+		t.Error("Expected an error for invalid hex")
+	}
+}
+
+// fakeProber finds the addresses in it in use, counting probes
+type fakeProber struct {
+	inUse  map[string]bool
+	probes map[string]int
+}
+
+func (p *fakeProber) InUse(ip net.IP) bool {
+	p.probes[ip.String()]++
+	return p.inUse[ip.String()]
+}
+
+func TestConflicts(t *testing.T) {
+	s, err := NewServer(Config{
+		ServerIP:     "192.168.1.1",
+		SubnetMask:   "255.255.255.0",
+		LeaseTime:    time.Hour,
+		RangeStart:   "192.168.1.100",
+		RangeEnd:     "192.168.1.110",
+		ConflictHold: time.Hour,
+	}, nil, memory.Open().DHCPLeases(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prober := &fakeProber{inUse: map[string]bool{"192.168.1.100": true}, probes: make(map[string]int)}
+	s.SetProber(prober)
+
+	discover := func(mac string) net.IP {
+		t.Helper()
+		hw, _ := net.ParseMAC(mac)
+		req, _ := dhcpv4.NewDiscovery(hw)
+		offer, err := s.handleDiscover(req, s.scopeFor(req, ""))
+		if err != nil {
+			t.Fatalf("handleDiscover failed: %v", err)
+		}
+		return offer.YourIPAddr
+	}
+
+	// A static device answers at the first address
+	if ip := discover("aa:bb:cc:dd:ee:30"); !ip.Equal(net.ParseIP("192.168.1.101")) {
+		t.Errorf("Expected the address in use skipped, got %v", ip)
+	}
+	if ip := discover("aa:bb:cc:dd:ee:31"); !ip.Equal(net.ParseIP("192.168.1.101")) {
+		t.Errorf("Expected the next free address, got %v", ip)
+	}
+	if prober.probes["192.168.1.100"] != 1 {
+		t.Errorf("Expected the address in use held rather than probed again, probed %d times", prober.probes["192.168.1.100"])
+	}
+
+	// A declined address is held too
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:31")
+	decline, _ := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeDecline),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.168.1.101"))))
+	if err := s.handleDecline(decline); err != nil {
+		t.Fatal(err)
+	}
+	if ip := discover("aa:bb:cc:dd:ee:31"); !ip.Equal(net.ParseIP("192.168.1.102")) {
+		t.Errorf("Expected the declined address skipped, got %v", ip)
+	}
+	req, _ := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.168.1.101"))))
+	if reply, err := s.handleRequest(req, s.scopeFor(req, "")); err != nil || reply.MessageType() != dhcpv4.MessageTypeNak {
+		t.Errorf("Expected a NAK for a held address, got %v (%v)", reply, err)
+	}
+}
+
+// blockingProber blocks probes of its address until release is closed
+type blockingProber struct {
+	ip      string
+	probing chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProber) InUse(ip net.IP) bool {
+	if ip.String() == p.ip {
+		close(p.probing)
+		<-p.release
+	}
+	return false
+}
+
+func TestProbeWithoutLock(t *testing.T) {
+	s, err := NewServer(Config{
+		ServerIP:     "192.168.1.1",
+		SubnetMask:   "255.255.255.0",
+		LeaseTime:    time.Hour,
+		RangeStart:   "192.168.1.100",
+		RangeEnd:     "192.168.1.110",
+		ConflictHold: time.Hour,
+	}, nil, memory.Open().DHCPLeases(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prober := &blockingProber{ip: "192.168.1.100", probing: make(chan struct{}), release: make(chan struct{})}
+	s.SetProber(prober)
+
+	sc := s.scopes[0]
+	first := make(chan net.IP)
+	go func() {
+		ip, err := s.allocateIP(sc)
+		if err != nil {
+			t.Error(err)
+		}
+		first <- ip
+	}()
+	<-prober.probing
+
+	// Another client isn't stalled by the probe, nor offered the same address
+	ip, err := s.allocateIP(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("192.168.1.101")) {
+		t.Errorf("Expected the next address while the first is probed, got %v", ip)
+	}
+
+	close(prober.release)
+	if ip := <-first; !ip.Equal(net.ParseIP("192.168.1.100")) {
+		t.Errorf("Expected the probed address, got %v", ip)
+	}
+	if len(s.probing) != 0 {
+		t.Errorf("Expected no addresses left marked as probed, got %v", s.probing)
+	}
+}
+
+func TestBootOptions(t *testing.T) {
+	s, err := NewServer(Config{
+		ServerIP:          "192.168.1.1",
+		SubnetMask:        "255.255.255.0",
+		LeaseTime:         time.Hour,
+		RangeStart:        "192.168.1.100",
+		RangeEnd:          "192.168.1.200",
+		BootFileName:      "undionly.kpxe",
+		UEFIBootFileName:  "ipxe.efi",
+		ARM64BootFileName: "ipxe-arm64.efi",
+		TFTPIP:            "192.168.1.1",
+		IPXEScript:        "kproxy.ipxe",
+	}, nil, memory.Open().DHCPLeases(), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bootFile := func(arch uint16, userClass string) string {
+		t.Helper()
+		mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:40")
+		req, _ := dhcpv4.NewDiscovery(mac)
+		req.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionClientSystemArchitectureType, Value: dhcpv4.OptionGeneric{Data: []byte{byte(arch >> 8), byte(arch)}}})
+		if userClass != "" {
+			req.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionUserClassInformation, Value: dhcpv4.String(userClass)})
+		}
+		offer, err := s.handleDiscover(req, s.scopeFor(req, ""))
+		if err != nil {
+			t.Fatalf("handleDiscover failed: %v", err)
+		}
+		return offer.BootFileNameOption()
+	}
+
+	tests := []struct {
+		name      string
+		arch      uint16
+		userClass string
+		want      string
+	}{
+		{"BIOS", 0x0000, "", "undionly.kpxe"},
+		{"UEFI x64", 0x0007, "", "ipxe.efi"},
+		{"UEFI ARM64", 0x000b, "", "ipxe-arm64.efi"},
+		{"iPXE", 0x0007, "iPXE", "kproxy.ipxe"},
+	}
+	for _, tt := range tests {
+		if got := bootFile(tt.arch, tt.userClass); got != tt.want {
+			t.Errorf("%s: boot file = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/storage"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+// sharedCacheTimeout bounds how long a query waits on the shared cache backend
+const sharedCacheTimeout = 100 * time.Millisecond
+
+// cacheEntry is an upstream response stored in wire format with the time it was stored
+type cacheEntry struct {
+	data      []byte
+	storedAt  time.Time
+	expiresAt time.Time
+}
+
+// Cache caches upstream responses keyed by question name, class and type.
+// Entries live for the smallest TTL in the response (capped by the bypass
+// TTL cap) and TTLs are counted down when served from cache. Policy is still
+// evaluated for every query; only the upstream round trip is skipped.
+type Cache struct {
+	local  *lru.Cache[string, cacheEntry]
+	shared storage.DNSCacheStore // Optional backend shared between instances (e.g. Redis)
+	ttlCap uint32
+	logger zerolog.Logger
+}
+
+// NewCache creates a response cache holding up to size entries in memory.
+// If shared is non-nil, responses are also written to and read from it.
+func NewCache(size int, shared storage.DNSCacheStore, ttlCap uint32, logger zerolog.Logger) (*Cache, error) {
+	local, err := lru.New[string, cacheEntry](size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS cache: %w", err)
+	}
+
+	return &Cache{
+		local:  local,
+		shared: shared,
+		ttlCap: ttlCap,
+		logger: logger.With().Str("component", "dns-cache").Logger(),
+	}, nil
+}
+
+// Get returns a cached response for the query's first question, with TTLs
+// reduced by the time spent in the cache
+func (c *Cache) Get(r *dns.Msg) (*dns.Msg, bool) {
+	key, ok := cacheKey(r)
+	if !ok {
+		return nil, false
+	}
+
+	now := time.Now()
+	entry, ok := c.local.Get(key)
+	if ok && !now.Before(entry.expiresAt) {
+		c.local.Remove(key)
+		ok = false
+	}
+	if !ok && c.shared != nil {
+		entry, ok = c.getShared(key)
+	}
+	if !ok {
+		metrics.DNSCacheMisses.Inc()
+		return nil, false
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(entry.data); err != nil {
+		c.local.Remove(key)
+		metrics.DNSCacheMisses.Inc()
+		return nil, false
+	}
+
+	elapsed := uint32(now.Sub(entry.storedAt).Seconds())
+	for _, rr := range allRecords(resp) {
+		if rr.Header().Ttl > elapsed {
+			rr.Header().Ttl -= elapsed
+		} else {
+			rr.Header().Ttl = 0
+		}
+	}
+	resp.Id = r.Id
+
+	metrics.DNSCacheHits.Inc()
+	return resp, true
+}
+
+// Set caches an upstream response if it is cacheable
+func (c *Cache) Set(r *dns.Msg, resp *dns.Msg) {
+	key, ok := cacheKey(r)
+	if !ok {
+		return
+	}
+
+	ttl, ok := cacheTTL(resp)
+	if !ok {
+		return
+	}
+	if c.ttlCap > 0 && ttl > c.ttlCap {
+		ttl = c.ttlCap
+	}
+
+	data, err := resp.Pack()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	entry := cacheEntry{
+		data:      data,
+		storedAt:  now,
+		expiresAt: now.Add(time.Duration(ttl) * time.Second),
+	}
+	c.local.Add(key, entry)
+
+	if c.shared != nil {
+		c.setShared(key, entry, time.Duration(ttl)*time.Second)
+	}
+}
+
+// getShared reads an entry from the shared backend and promotes it to the local cache
+func (c *Cache) getShared(key string) (cacheEntry, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedCacheTimeout)
+	defer cancel()
+
+	value, err := c.shared.Get(ctx, key)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			c.logger.Debug().Err(err).Msg("Shared DNS cache read failed")
+		}
+		return cacheEntry{}, false
+	}
+
+	// Shared values are prefixed with the store time and expiry (unix seconds)
+	if len(value) < 16 {
+		return cacheEntry{}, false
+	}
+	entry := cacheEntry{
+		storedAt:  time.Unix(int64(binary.BigEndian.Uint64(value[0:8])), 0),
+		expiresAt: time.Unix(int64(binary.BigEndian.Uint64(value[8:16])), 0),
+		data:      value[16:],
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		return cacheEntry{}, false
+	}
+
+	c.local.Add(key, entry)
+	return entry, true
+}
+
+// setShared writes an entry to the shared backend
+func (c *Cache) setShared(key string, entry cacheEntry, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedCacheTimeout)
+	defer cancel()
+
+	value := make([]byte, 16, 16+len(entry.data))
+	binary.BigEndian.PutUint64(value[0:8], uint64(entry.storedAt.Unix()))
+	binary.BigEndian.PutUint64(value[8:16], uint64(entry.expiresAt.Unix()))
+	value = append(value, entry.data...)
+
+	if err := c.shared.Set(ctx, key, value, ttl); err != nil {
+		c.logger.Debug().Err(err).Msg("Shared DNS cache write failed")
+	}
+}
+
+// cacheKey builds the cache key from the first question. Answers can vary by
+// client subnet, so a forwarded EDNS Client Subnet is part of the key, and
+// with the DNSSEC OK and Checking Disabled bits, which decide whether
+// signatures are included and bogus answers withheld.
+func cacheKey(r *dns.Msg) (string, bool) {
+	if len(r.Question) == 0 {
+		return "", false
+	}
+	q := r.Question[0]
+	key := fmt.Sprintf("%s/%d/%d", strings.ToLower(q.Name), q.Qclass, q.Qtype)
+	if wantsDNSSEC(r) {
+		key += "/do"
+	}
+	if r.CheckingDisabled {
+		key += "/cd"
+	}
+	if subnet := clientSubnet(r); subnet != "" {
+		key += "/" + subnet
+	}
+	return key, true
+}
+
+// cacheTTL returns how long a response may be cached: the smallest answer TTL
+// for positive answers, or the SOA negative TTL for NXDOMAIN/NODATA (RFC 2308).
+// Errors, truncated responses and responses without a usable TTL are not cached.
+func cacheTTL(resp *dns.Msg) (uint32, bool) {
+	if resp.Truncated {
+		return 0, false
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return 0, false
+	}
+
+	if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0 {
+		ttl := resp.Answer[0].Header().Ttl
+		for _, rr := range resp.Answer[1:] {
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+		return ttl, ttl > 0
+	}
+
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := min(soa.Hdr.Ttl, soa.Minttl)
+			return ttl, ttl > 0
+		}
+	}
+	return 0, false
+}
+
+// allRecords returns the records in all sections except the OPT pseudo-record
+func allRecords(m *dns.Msg) []dns.RR {
+	rrs := make([]dns.RR, 0, len(m.Answer)+len(m.Ns)+len(m.Extra))
+	rrs = append(rrs, m.Answer...)
+	rrs = append(rrs, m.Ns...)
+	for _, rr := range m.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+func newResponse(q *dns.Msg, ttl uint32) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(q)
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   net.ParseIP("192.0.2.1").To4(),
+	})
+	return resp
+}
+
+func TestCache_HitCountsDownTTL(t *testing.T) {
+	cache, err := NewCache(10, nil, 300, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+
+	q := newQuery()
+	cache.Set(q, newResponse(q, 60))
+
+	// Pretend the entry was stored 10 seconds ago
+	key, _ := cacheKey(q)
+	entry, _ := cache.local.Get(key)
+	entry.storedAt = entry.storedAt.Add(-10 * time.Second)
+	cache.local.Add(key, entry)
+
+	q2 := newQuery()
+	q2.Question[0].Name = "EXAMPLE.com."
+	resp, ok := cache.Get(q2)
+	if !ok {
+		t.Fatal("expected cache hit for case-insensitive name")
+	}
+	if resp.Id != q2.Id {
+		t.Errorf("response ID = %d, want %d", resp.Id, q2.Id)
+	}
+	if ttl := resp.Answer[0].Header().Ttl; ttl != 50 {
+		t.Errorf("TTL = %d, want 50", ttl)
+	}
+}
+
+func TestCache_TTLCapAndExpiry(t *testing.T) {
+	cache, err := NewCache(10, nil, 30, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+
+	q := newQuery()
+	cache.Set(q, newResponse(q, 3600))
+
+	key, _ := cacheKey(q)
+	entry, _ := cache.local.Get(key)
+	if lifetime := entry.expiresAt.Sub(entry.storedAt); lifetime != 30*time.Second {
+		t.Errorf("cache lifetime = %v, want capped to 30s", lifetime)
+	}
+
+	entry.expiresAt = time.Now().Add(-time.Second)
+	cache.local.Add(key, entry)
+	if _, ok := cache.Get(q); ok {
+		t.Error("expected expired entry to miss")
+	}
+}
+
+func TestCache_Uncacheable(t *testing.T) {
+	cache, err := NewCache(10, nil, 0, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+
+	q := newQuery()
+
+	servfail := new(dns.Msg)
+	servfail.SetRcode(q, dns.RcodeServerFailure)
+	cache.Set(q, servfail)
+
+	zeroTTL := newResponse(q, 0)
+	cache.Set(q, zeroTTL)
+
+	if _, ok := cache.Get(q); ok {
+		t.Error("expected SERVFAIL and zero-TTL responses not to be cached")
+	}
+
+	// NXDOMAIN is cached for the SOA negative TTL
+	nx := new(dns.Msg)
+	nx.SetRcode(q, dns.RcodeNameError)
+	nx.Ns = append(nx.Ns, &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 900},
+		Ns:     "a.gtld-servers.net.",
+		Mbox:   "nstld.verisign-grs.com.",
+		Minttl: 120,
+	})
+	cache.Set(q, nx)
+
+	key, _ := cacheKey(q)
+	entry, ok := cache.local.Get(key)
+	if !ok {
+		t.Fatal("expected NXDOMAIN to be cached")
+	}
+	if lifetime := entry.expiresAt.Sub(entry.storedAt); lifetime != 120*time.Second {
+		t.Errorf("negative cache lifetime = %v, want 120s", lifetime)
+	}
+}
+
+func TestCache_SharedBackend(t *testing.T) {
+	shared := memory.Open().DNSCache()
+
+	writer, _ := NewCache(10, shared, 0, zerolog.Nop())
+	reader, _ := NewCache(10, shared, 0, zerolog.Nop())
+
+	q := newQuery()
+	writer.Set(q, newResponse(q, 60))
+
+	key, _ := cacheKey(q)
+	if _, err := shared.Get(context.Background(), key); err != nil {
+		t.Fatalf("expected entry in shared backend: %v", err)
+	}
+
+	resp, ok := reader.Get(q)
+	if !ok {
+		t.Fatal("expected hit from shared backend")
+	}
+	if len(resp.Answer) != 1 {
+		t.Errorf("expected 1 answer, got %d", len(resp.Answer))
+	}
+	if _, ok := reader.local.Get(key); !ok {
+		t.Error("expected shared hit to be promoted to the local cache")
+	}
+}
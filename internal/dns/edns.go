@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// upstreamQuery returns the query to send upstream for a bypassed client
+// query. Unless passthrough is enabled, EDNS0 options such as Client Subnet
+// are stripped so the client's details don't reach the upstream resolver.
+// With dnssec enabled the DO bit is set, asking the upstream for signatures
+// and an authenticated (AD) answer.
+func upstreamQuery(r *dns.Msg, passthrough, dnssec bool) *dns.Msg {
+	opt := r.IsEdns0()
+	if !dnssec && (opt == nil || passthrough || len(opt.Option) == 0) {
+		return r
+	}
+
+	q := r.Copy()
+	opt = q.IsEdns0()
+	if opt != nil && !passthrough {
+		opt.Option = nil
+	}
+	if dnssec {
+		if opt == nil {
+			q.SetEdns0(dns.DefaultMsgSize, true)
+		} else {
+			opt.SetDo()
+		}
+	}
+	return q
+}
+
+// wantsDNSSEC reports whether the client set the DO bit itself
+func wantsDNSSEC(r *dns.Msg) bool {
+	opt := r.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// setReplyEdns0 adds an OPT record to the reply for clients that sent one,
+// carrying the upstream's EDNS0 options (such as the Client Subnet scope)
+func setReplyEdns0(msg, r, resp *dns.Msg) {
+	clientOpt := r.IsEdns0()
+	upstreamOpt := resp.IsEdns0()
+	if clientOpt == nil || upstreamOpt == nil || msg.IsEdns0() != nil {
+		return
+	}
+
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(clientOpt.UDPSize())
+	if clientOpt.Do() {
+		opt.SetDo()
+	}
+	opt.Option = upstreamOpt.Option
+	msg.Extra = append(msg.Extra, opt)
+}
+
+// clientSubnet returns the EDNS Client Subnet of a query as "address/mask",
+// or "" if it has none
+func clientSubnet(r *dns.Msg) string {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+	for _, o := range opt.Option {
+		if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return fmt.Sprintf("%s/%d", subnet.Address, subnet.SourceNetmask)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// newSubnetQuery returns a query carrying an EDNS Client Subnet option
+func newSubnetQuery(subnet string) *dns.Msg {
+	q := newQuery()
+	q.SetEdns0(1232, false)
+	_, ipNet, _ := net.ParseCIDR(subnet)
+	ones, _ := ipNet.Mask.Size()
+	q.IsEdns0().Option = append(q.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: uint8(ones),
+		Address:       ipNet.IP,
+	})
+	return q
+}
+
+func TestUpstreamQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       *dns.Msg
+		passthrough bool
+		dnssec      bool
+		wantOpt     bool
+		wantOptions int
+		wantDO      bool
+	}{
+		{"plain query unchanged", newQuery(), false, false, false, 0, false},
+		{"options stripped", newSubnetQuery("192.168.1.0/24"), false, false, true, 0, false},
+		{"options forwarded", newSubnetQuery("192.168.1.0/24"), true, false, true, 1, false},
+		{"DO added without EDNS0", newQuery(), false, true, true, 0, true},
+		{"DO set alongside options", newSubnetQuery("192.168.1.0/24"), true, true, true, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := upstreamQuery(tt.query, tt.passthrough, tt.dnssec)
+
+			opt := q.IsEdns0()
+			if (opt != nil) != tt.wantOpt {
+				t.Fatalf("OPT present = %v, want %v", opt != nil, tt.wantOpt)
+			}
+			if opt == nil {
+				return
+			}
+			if len(opt.Option) != tt.wantOptions {
+				t.Errorf("options = %d, want %d", len(opt.Option), tt.wantOptions)
+			}
+			if opt.Do() != tt.wantDO {
+				t.Errorf("DO = %v, want %v", opt.Do(), tt.wantDO)
+			}
+		})
+	}
+}
+
+func TestUpstreamQuery_LeavesClientQueryAlone(t *testing.T) {
+	r := newSubnetQuery("192.168.1.0/24")
+	upstreamQuery(r, false, true)
+
+	opt := r.IsEdns0()
+	if len(opt.Option) != 1 || opt.Do() {
+		t.Errorf("client query was modified: options = %d, DO = %v", len(opt.Option), opt.Do())
+	}
+}
+
+func TestSetReplyEdns0(t *testing.T) {
+	r := newSubnetQuery("192.168.1.0/24")
+	resp := newResponse(r, 60)
+	resp.SetEdns0(4096, true)
+	resp.IsEdns0().Option = r.IsEdns0().Option
+
+	msg := new(dns.Msg)
+	msg.SetReply(r)
+	setReplyEdns0(msg, r, resp)
+	setReplyEdns0(msg, r, resp)
+
+	opts := 0
+	for _, rr := range msg.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			opts++
+		}
+	}
+	if opts != 1 {
+		t.Fatalf("reply has %d OPT records, want 1", opts)
+	}
+
+	opt := msg.IsEdns0()
+	if opt.UDPSize() != 1232 {
+		t.Errorf("UDP size = %d, want the client's 1232", opt.UDPSize())
+	}
+	if opt.Do() {
+		t.Error("DO set in reply to a client that didn't set it")
+	}
+	if clientSubnet(msg) != "192.168.1.0/24" {
+		t.Errorf("client subnet = %q, want 192.168.1.0/24", clientSubnet(msg))
+	}
+
+	// Clients that didn't use EDNS0 don't get an OPT record back
+	plain := newQuery()
+	msg = new(dns.Msg)
+	msg.SetReply(plain)
+	setReplyEdns0(msg, plain, resp)
+	if msg.IsEdns0() != nil {
+		t.Error("OPT added to reply for a client without EDNS0")
+	}
+}
+
+func TestCacheKey_ClientSubnet(t *testing.T) {
+	plain, _ := cacheKey(newQuery())
+	subnetA, _ := cacheKey(newSubnetQuery("192.168.1.0/24"))
+	subnetB, _ := cacheKey(newSubnetQuery("10.0.0.0/8"))
+
+	if plain == subnetA || subnetA == subnetB {
+		t.Errorf("cache keys should differ by client subnet: %q, %q, %q", plain, subnetA, subnetB)
+	}
+}
+
+func TestCacheKey_DNSSECBits(t *testing.T) {
+	plain, _ := cacheKey(newQuery())
+
+	do := newQuery()
+	do.SetEdns0(dns.DefaultMsgSize, true)
+	doKey, _ := cacheKey(do)
+
+	cd := newQuery()
+	cd.CheckingDisabled = true
+	cdKey, _ := cacheKey(cd)
+
+	// EDNS0 alone, without the DO bit, doesn't change the answer
+	edns := newQuery()
+	edns.SetEdns0(dns.DefaultMsgSize, false)
+	ednsKey, _ := cacheKey(edns)
+
+	if plain == doKey || plain == cdKey || doKey == cdKey {
+		t.Errorf("cache keys should differ by DO and CD bits: %q, %q, %q", plain, doKey, cdKey)
+	}
+	if ednsKey != plain {
+		t.Errorf("cache key with EDNS0 = %q, want %q", ednsKey, plain)
+	}
+}
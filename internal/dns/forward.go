@@ -0,0 +1,62 @@
+package dns
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ForwardZone sends queries for a domain and its subdomains to dedicated
+// resolvers (conditional forwarding), e.g. corp.example.com to a VPN resolver
+type ForwardZone struct {
+	Zone    string   // Domain suffix, e.g. "corp.example.com"
+	Servers []string // Upstream specs, as for UpstreamDNS
+}
+
+// forwardZone is a compiled ForwardZone
+type forwardZone struct {
+	suffix    string
+	upstreams []Upstream
+}
+
+// newForwardZones compiles forward zones, most specific suffix first
+func newForwardZones(zones []ForwardZone, timeout time.Duration) ([]forwardZone, error) {
+	compiled := make([]forwardZone, 0, len(zones))
+	for _, z := range zones {
+		suffix := strings.ToLower(strings.Trim(z.Zone, "."))
+		if suffix == "" {
+			return nil, fmt.Errorf("forward zone has no domain")
+		}
+		if len(z.Servers) == 0 {
+			return nil, fmt.Errorf("forward zone %s has no servers", suffix)
+		}
+
+		upstreams := make([]Upstream, 0, len(z.Servers))
+		for _, spec := range z.Servers {
+			upstream, err := NewUpstream(spec, timeout)
+			if err != nil {
+				return nil, fmt.Errorf("forward zone %s: %w", suffix, err)
+			}
+			upstreams = append(upstreams, upstream)
+		}
+		compiled = append(compiled, forwardZone{suffix: suffix, upstreams: upstreams})
+	}
+
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return len(compiled[i].suffix) > len(compiled[j].suffix)
+	})
+	return compiled, nil
+}
+
+// forwardZoneFor returns the most specific forward zone containing domain, or nil
+func (s *Server) forwardZoneFor(domain string) *forwardZone {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for i := range s.forwardZones {
+		z := &s.forwardZones[i]
+		if domain == z.suffix || strings.HasSuffix(domain, "."+z.suffix) {
+			return z
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitClients is the number of client buckets kept. The least recently
+// seen clients are evicted first, so a flood of spoofed source addresses
+// can't grow memory without bound.
+const rateLimitClients = 10000
+
+// RateLimiter applies a token bucket per client IP
+type RateLimiter struct {
+	qps     rate.Limit
+	burst   int
+	clients *lru.Cache[string, *rate.Limiter]
+}
+
+// NewRateLimiter creates a rate limiter allowing each client qps queries per
+// second on average, with bursts of up to burst queries
+func NewRateLimiter(qps float64, burst int) (*RateLimiter, error) {
+	if qps <= 0 || burst < 1 {
+		return nil, fmt.Errorf("invalid DNS rate limit: %g qps, burst %d", qps, burst)
+	}
+
+	clients, err := lru.New[string, *rate.Limiter](rateLimitClients)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS rate limiter: %w", err)
+	}
+
+	return &RateLimiter{
+		qps:     rate.Limit(qps),
+		burst:   burst,
+		clients: clients,
+	}, nil
+}
+
+// Allow reports whether a query from the client may be answered now
+func (l *RateLimiter) Allow(clientIP net.IP) bool {
+	key := clientIP.String()
+
+	limiter, ok := l.clients.Get(key)
+	if !ok {
+		// Concurrent first queries may race to create the bucket; the loser's
+		// query is still counted against a fresh bucket, which is harmless
+		limiter = rate.NewLimiter(l.qps, l.burst)
+		l.clients.Add(key, limiter)
+	}
+
+	return limiter.Allow()
+}
@@ -0,0 +1,37 @@
+package dns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter, err := NewRateLimiter(1, 3)
+	if err != nil {
+		t.Fatalf("NewRateLimiter failed: %v", err)
+	}
+
+	noisy := net.ParseIP("192.168.1.50")
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow(noisy) {
+			t.Fatalf("query %d within burst was refused", i)
+		}
+	}
+	if limiter.Allow(noisy) {
+		t.Error("expected query over burst to be refused")
+	}
+
+	// Other clients have their own bucket
+	if !limiter.Allow(net.ParseIP("192.168.1.51")) {
+		t.Error("expected other client to be allowed")
+	}
+}
+
+func TestNewRateLimiterInvalid(t *testing.T) {
+	if _, err := NewRateLimiter(0, 10); err == nil {
+		t.Error("expected error for zero QPS")
+	}
+	if _, err := NewRateLimiter(10, 0); err == nil {
+		t.Error("expected error for zero burst")
+	}
+}
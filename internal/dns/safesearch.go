@@ -0,0 +1,44 @@
+package dns
+
+import (
+	"github.com/goodtune/kproxy/internal/safesearch"
+	"github.com/miekg/dns"
+)
+
+// safeSearchQuery rewrites an address query for a search engine into a
+// query for its SafeSearch host. It returns the original message and ""
+// when q isn't for a search engine.
+func safeSearchQuery(r *dns.Msg, q *dns.Question) (*dns.Msg, string) {
+	if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+		return r, ""
+	}
+	target := safesearch.Target(q.Name)
+	if target == "" {
+		return r, ""
+	}
+
+	query := r.Copy()
+	query.Question = []dns.Question{{Name: dns.Fqdn(target), Qtype: q.Qtype, Qclass: q.Qclass}}
+	return query, target
+}
+
+// createSafeSearchCNAME points the queried name at its SafeSearch host. The
+// TTL follows the upstream answer so both expire together.
+func (s *Server) createSafeSearchCNAME(q *dns.Question, target string, resp *dns.Msg) dns.RR {
+	ttl := s.bypassTTLCap
+	for _, ans := range resp.Answer {
+		if ttl == 0 || ans.Header().Ttl < ttl {
+			ttl = ans.Header().Ttl
+		}
+	}
+
+	return &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   q.Name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Target: dns.Fqdn(target),
+	}
+}
@@ -1,21 +1,41 @@
 package dns
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strings"
 	"time"
 
+	"github.com/goodtune/kproxy/internal/activity"
+	"github.com/goodtune/kproxy/internal/analytics"
+	"github.com/goodtune/kproxy/internal/arp"
+	"github.com/goodtune/kproxy/internal/clientnames"
+	"github.com/goodtune/kproxy/internal/devices"
+	"github.com/goodtune/kproxy/internal/maintenance"
 	"github.com/goodtune/kproxy/internal/metrics"
 	"github.com/goodtune/kproxy/internal/policy"
 	"github.com/miekg/dns"
 	"github.com/rs/zerolog"
 )
 
+// maxCNAMEHops bounds how far a CNAME chain is followed (guards against loops)
+const maxCNAMEHops = 16
+
+// maintenanceDNSActions maps maintenance DNS actions to the action taken for
+// every query during a maintenance window ("policy" leaves DNS unchanged)
+var maintenanceDNSActions = map[string]policy.DNSAction{
+	"intercept": policy.DNSActionIntercept,
+	"bypass":    policy.DNSActionBypass,
+	"block":     policy.DNSActionBlock,
+}
+
 // Server handles DNS queries with intercept/bypass logic
 type Server struct {
 	proxyIP      net.IP
-	upstreamDNS  []string
+	proxyIPv6    net.IP // Returned for AAAA intercepts (optional; AAAA gets no answer without it)
+	upstreams    []Upstream
+	forwardZones []forwardZone // Conditional forwarding, most specific first
 	policyEngine *policy.Engine
 	logger       zerolog.Logger
 
@@ -24,34 +44,108 @@ type Server struct {
 	bypassTTLCap uint32
 	blockTTL     uint32
 
-	// DNS client for upstream queries
-	client *dns.Client
+	// DNS-only mode: no proxy is running, so INTERCEPT decisions are resolved at the DNS level
+	dnsOnly bool
+
+	// Upstream response cache (optional)
+	cache *Cache
+
+	// Check CNAME chains of bypassed answers against block rules
+	cnameInspection bool
+
+	// EDNS0 handling for bypassed queries
+	ednsPassthrough bool // Forward client EDNS0 options (e.g. Client Subnet) upstream
+	dnssec          bool // Set the DO bit upstream and pass on the AD flag
+
+	stripHTTPSRecords bool // Answer HTTPS/SVCB queries with no records
+
+	// Name answered with the asking device's profile and notices ("" = off)
+	statusName string
+
+	// Per-client query rate limit (optional)
+	rateLimiter *RateLimiter
+
+	// Action overriding policy during network maintenance (optional)
+	maintenance       *maintenance.Mode
+	maintenanceAction policy.DNSAction
+
+	// Query rates and recent blocks for live dashboards (optional)
+	activity *activity.Recorder
+
+	// Hourly decision counts for warehouse export (optional)
+	analytics *analytics.Collector
+
+	// Client MAC addresses from the neighbour table (optional)
+	arp *arp.Cache
+
+	// Quarantine of clients seen for the first time (optional)
+	discovery *devices.Discovery
+
+	// Hostnames clients announced for themselves (optional)
+	clientNames *clientnames.Resolver
 
 	// Servers
 	udpServer *dns.Server
 	tcpServer *dns.Server
+	dotServer *dns.Server // DNS-over-TLS (optional)
 
 	// Optional pre-created listeners (for systemd socket activation)
 	udpConn net.PacketConn
 	tcpLn   net.Listener
+	dotLn   net.Listener
 }
 
 // Config holds DNS server configuration
 type Config struct {
 	ListenAddr   string
 	ProxyIP      string
+	ProxyIPv6    string
 	UpstreamDNS  []string
+	ForwardZones []ForwardZone // Domains resolved by dedicated resolvers, outside policy
 	InterceptTTL uint32
 	BypassTTLCap uint32
 	BlockTTL     uint32
 	EnableTCP    bool
 	EnableUDP    bool
 	Timeout      time.Duration
+	DNSOnly      bool   // No proxy available; INTERCEPT decisions become BYPASS or BLOCK
+	Cache        *Cache // Upstream response cache (nil disables caching)
+
+	// Block bypassed answers whose CNAME chain leads to a blocked domain
+	CNAMEInspection bool
+
+	// Forward client EDNS0 options upstream and return the upstream's
+	EDNSPassthrough bool
+
+	// Request DNSSEC records upstream; profiles may then require validated answers
+	DNSSEC bool
+
+	// Answer HTTPS/SVCB queries with no records, so clients don't learn
+	// that a site offers HTTP/3 (QUIC would bypass the proxy)
+	StripHTTPSRecords bool
+
+	// Answer TXT queries for this name with the asking device's profile and
+	// the notices due for it ("" disables the status record)
+	StatusName string
+
+	// Network maintenance switch and the DNS action while it is on
+	// ("intercept", "bypass", "block" or "policy")
+	Maintenance          *maintenance.Mode
+	MaintenanceDNSAction string
+
+	// Per-client rate limit: queries per second and burst (0 QPS disables)
+	RateLimitQPS   float64
+	RateLimitBurst int
+
+	// DNS-over-TLS (disabled when DoTAddr is empty)
+	DoTAddr        string
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 }
 
 // NewServer creates a new DNS server
 func NewServer(config Config, policy *policy.Engine, logger zerolog.Logger) (*Server, error) {
 	// ProxyIP is optional - if not set, we'll auto-detect from incoming connections
+	// (and it is never used in DNS-only mode)
 	var proxyIP net.IP
 	if config.ProxyIP != "" {
 		proxyIP = net.ParseIP(config.ProxyIP)
@@ -60,17 +154,66 @@ func NewServer(config Config, policy *policy.Engine, logger zerolog.Logger) (*Se
 		}
 	}
 
+	var proxyIPv6 net.IP
+	if config.ProxyIPv6 != "" {
+		proxyIPv6 = net.ParseIP(config.ProxyIPv6)
+		if proxyIPv6 == nil || proxyIPv6.To4() != nil {
+			return nil, fmt.Errorf("invalid proxy IPv6 address: %s", config.ProxyIPv6)
+		}
+	}
+
+	// Upstreams are tried in order, so later entries act as fallbacks
+	upstreams := make([]Upstream, 0, len(config.UpstreamDNS))
+	for _, spec := range config.UpstreamDNS {
+		upstream, err := NewUpstream(spec, config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, upstream)
+	}
+
+	forwardZones, err := newForwardZones(config.ForwardZones, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &Server{
 		proxyIP:      proxyIP,
-		upstreamDNS:  config.UpstreamDNS,
+		proxyIPv6:    proxyIPv6,
+		upstreams:    upstreams,
+		forwardZones: forwardZones,
 		policyEngine: policy,
 		logger:       logger.With().Str("component", "dns").Logger(),
 		interceptTTL: config.InterceptTTL,
 		bypassTTLCap: config.BypassTTLCap,
 		blockTTL:     config.BlockTTL,
-		client: &dns.Client{
-			Timeout: config.Timeout,
-		},
+		dnsOnly:      config.DNSOnly,
+		cache:        config.Cache,
+
+		cnameInspection: config.CNAMEInspection,
+		ednsPassthrough: config.EDNSPassthrough,
+		dnssec:          config.DNSSEC,
+
+		stripHTTPSRecords: config.StripHTTPSRecords,
+
+		statusName: strings.TrimSuffix(config.StatusName, "."),
+	}
+
+	if config.RateLimitQPS > 0 {
+		limiter, err := NewRateLimiter(config.RateLimitQPS, config.RateLimitBurst)
+		if err != nil {
+			return nil, err
+		}
+		s.rateLimiter = limiter
+	}
+
+	if config.Maintenance != nil && config.MaintenanceDNSAction != "policy" {
+		action, ok := maintenanceDNSActions[config.MaintenanceDNSAction]
+		if !ok {
+			return nil, fmt.Errorf("invalid maintenance DNS action: %s", config.MaintenanceDNSAction)
+		}
+		s.maintenance = config.Maintenance
+		s.maintenanceAction = action
 	}
 
 	// Set up DNS handler
@@ -91,18 +234,63 @@ func NewServer(config Config, policy *policy.Engine, logger zerolog.Logger) (*Se
 		}
 	}
 
+	if config.DoTAddr != "" {
+		if config.GetCertificate == nil {
+			return nil, fmt.Errorf("DNS-over-TLS requires a certificate source")
+		}
+		s.dotServer = &dns.Server{
+			Addr: config.DoTAddr,
+			Net:  "tcp-tls",
+			TLSConfig: &tls.Config{
+				GetCertificate: config.GetCertificate,
+				MinVersion:     tls.VersionTLS12,
+			},
+		}
+	}
+
 	return s, nil
 }
 
+// SetActivity sets the recorder that keeps query rates and recent blocks for live dashboards
+func (s *Server) SetActivity(r *activity.Recorder) {
+	s.activity = r
+}
+
+// SetAnalytics sets the collector that counts decisions for warehouse export
+func (s *Server) SetAnalytics(c *analytics.Collector) {
+	s.analytics = c
+}
+
+// SetARP sets the cache that resolves clients' MAC addresses for policy
+func (s *Server) SetARP(c *arp.Cache) {
+	s.arp = c
+}
+
+// SetDiscovery sets the discovery that quarantines new clients
+func (s *Server) SetDiscovery(d *devices.Discovery) {
+	s.discovery = d
+}
+
+// SetClientNames sets the resolver that names clients by their announced
+// hostnames
+func (s *Server) SetClientNames(r *clientnames.Resolver) {
+	s.clientNames = r
+}
+
 // SetListeners sets pre-created listeners for systemd socket activation
 func (s *Server) SetListeners(udpConn net.PacketConn, tcpLn net.Listener) {
 	s.udpConn = udpConn
 	s.tcpLn = tcpLn
 }
 
+// SetDoTListener sets a pre-created DNS-over-TLS listener for systemd socket activation
+func (s *Server) SetDoTListener(ln net.Listener) {
+	s.dotLn = ln
+}
+
 // Start starts the DNS server
 func (s *Server) Start() error {
-	errChan := make(chan error, 2)
+	errChan := make(chan error, 3)
 
 	if s.udpServer != nil {
 		go func() {
@@ -142,6 +330,25 @@ func (s *Server) Start() error {
 		}()
 	}
 
+	if s.dotServer != nil {
+		go func() {
+			s.logger.Info().Str("addr", s.dotServer.Addr).Msg("Starting DNS server (DoT)")
+			var err error
+			if s.dotLn != nil {
+				// Use systemd socket-activated listener (plain TCP, wrap with TLS)
+				s.logger.Debug().Msg("Using systemd socket-activated DoT listener")
+				s.dotServer.Listener = tls.NewListener(s.dotLn, s.dotServer.TLSConfig)
+				err = s.dotServer.ActivateAndServe()
+			} else {
+				// Create and bind listener ourselves
+				err = s.dotServer.ListenAndServe()
+			}
+			if err != nil {
+				errChan <- fmt.Errorf("DoT server error: %w", err)
+			}
+		}()
+	}
+
 	// Wait a bit to ensure servers started
 	select {
 	case err := <-errChan:
@@ -167,6 +374,12 @@ func (s *Server) Stop() error {
 		}
 	}
 
+	if s.dotServer != nil {
+		if err := s.dotServer.Shutdown(); err != nil {
+			errs = append(errs, fmt.Errorf("DoT shutdown error: %w", err))
+		}
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("shutdown errors: %v", errs)
 	}
@@ -185,6 +398,18 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	// Get client IP for device identification
 	clientIP := s.extractClientIP(w.RemoteAddr())
 
+	// Refuse queries from clients over their rate limit
+	if s.rateLimiter != nil && !s.rateLimiter.Allow(clientIP) {
+		s.logger.Debug().Str("client", clientIP.String()).Msg("DNS query rate limited")
+		metrics.DNSRateLimited.Inc()
+
+		msg.Rcode = dns.RcodeRefused
+		if err := w.WriteMsg(msg); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to write DNS response")
+		}
+		return
+	}
+
 	// Process each question
 	for _, question := range r.Question {
 		domain := strings.TrimSuffix(question.Name, ".")
@@ -196,16 +421,51 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 			Str("type", dns.TypeToString[qtype]).
 			Msg("DNS query received")
 
-		// Determine action based on policy
-		// Note: DNS queries don't include MAC address, but we could look it up from DHCP leases in the future
-		action := s.policyEngine.GetDNSAction(clientIP, nil, domain)
+		// Conditionally forwarded domains (VPN, work) are resolved by their
+		// own resolvers without policy; everything else is up to policy.
+		// The status record is answered locally, outside policy.
+		status := s.statusName != "" && strings.EqualFold(domain, s.statusName)
+		zone := s.forwardZoneFor(domain)
+		var decision policy.DNSDecision
+		switch {
+		case status:
+		case zone != nil:
+			decision = policy.DNSDecision{Action: policy.DNSActionBypass}
+		default:
+			clientMAC := s.arp.Lookup(clientIP)
+			s.discovery.Observe(clientIP, clientMAC, s.clientNames.Lookup(clientIP), "dns")
+			decision = s.policyEngine.GetDNSDecision(clientIP, clientMAC, domain)
+		}
+		action := decision.Action
+
+		// During network maintenance the configured action applies to everything
+		if s.maintenance != nil && s.maintenance.Active() {
+			action = s.maintenanceAction
+		}
+
+		// Without a proxy there is nothing to intercept to, so decide at the DNS level
+		if s.dnsOnly && action == policy.DNSActionIntercept {
+			action = s.resolveWithoutProxy(clientIP, domain)
+		}
 
 		var logAction string
 		var responseIP string
 		var upstream string
 
-		switch action {
-		case policy.DNSActionIntercept:
+		// HTTPS/SVCB records advertise HTTP/3, which would take clients
+		// around the proxy, so they may be answered with no records
+		stripped := s.stripHTTPSRecords && zone == nil && action != policy.DNSActionBlock &&
+			(qtype == dns.TypeHTTPS || qtype == dns.TypeSVCB)
+
+		switch {
+		case status:
+			s.answerStatus(msg, &question, clientIP)
+			logAction = "STATUS"
+
+		case stripped:
+			logAction = "NODATA"
+
+		case action == policy.DNSActionIntercept:
 			// Return proxy IP
 			if answer := s.createInterceptResponse(&question, domain); answer != nil {
 				msg.Answer = append(msg.Answer, answer)
@@ -213,10 +473,23 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 			}
 			logAction = "INTERCEPT"
 
-		case policy.DNSActionBypass:
-			// Forward to upstream and return real response
-			upstreamResp, upstreamAddr, err := s.forwardToUpstream(r)
-			if err != nil {
+		case action == policy.DNSActionBypass:
+			// Forward to upstream and return real response. Search engines
+			// are resolved through their SafeSearch hosts when the profile
+			// asks for it.
+			query, qname, safeTarget := r, question.Name, ""
+			if decision.SafeSearch && zone == nil {
+				if query, safeTarget = safeSearchQuery(r, &question); safeTarget != "" {
+					qname = dns.Fqdn(safeTarget)
+				}
+			}
+			upstreamResp, upstreamAddr, err := s.forwardToUpstream(query, zone)
+			if err != nil && (s.dnsOnly || zone != nil) {
+				// No proxy to fall back to, or a private name the proxy can't reach
+				s.logger.Warn().Err(err).Str("domain", domain).Msg("Upstream DNS query failed")
+				msg.Rcode = dns.RcodeServerFailure
+				logAction = "SERVFAIL"
+			} else if err != nil {
 				s.logger.Warn().Err(err).Str("domain", domain).Msg("Upstream DNS query failed, falling back to intercept")
 				// On error, fall back to intercept
 				if answer := s.createInterceptResponse(&question, domain); answer != nil {
@@ -224,9 +497,27 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 					responseIP = s.getResponseIP(answer)
 				}
 				logAction = "INTERCEPT_FALLBACK"
+			} else if s.dnssec && decision.DNSSECStrict && !upstreamResp.AuthenticatedData {
+				// The profile only accepts answers the upstream resolver validated
+				s.logger.Info().
+					Str("client", clientIP.String()).
+					Str("domain", domain).
+					Msg("Refusing answer without DNSSEC validation")
+				msg.Rcode = dns.RcodeServerFailure
+				upstream = upstreamAddr
+				logAction = "DNSSEC_FAIL"
+			} else if s.blockedByCNAME(clientIP, domain, qname, upstreamResp) {
+				// A tracker hiding behind a CNAME (CNAME cloaking)
+				responseIP = s.answerBlocked(msg, &question, domain, decision)
+				upstream = upstreamAddr
+				logAction = "CNAME_BLOCK"
 			} else {
-				// Copy answers from upstream, potentially cap TTL
+				// Copy answers from upstream, potentially cap TTL. Signatures
+				// requested on the client's behalf are left out.
 				for _, ans := range upstreamResp.Answer {
+					if s.dnssec && !wantsDNSSEC(r) && ans.Header().Rrtype == dns.TypeRRSIG {
+						continue
+					}
 					if s.bypassTTLCap > 0 && ans.Header().Ttl > s.bypassTTLCap {
 						ans.Header().Ttl = s.bypassTTLCap
 					}
@@ -235,29 +526,46 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 				if len(upstreamResp.Answer) > 0 {
 					responseIP = s.getResponseIP(upstreamResp.Answer[0])
 				}
+				if safeTarget != "" {
+					// Answer for the name the client asked for
+					msg.Answer = append([]dns.RR{s.createSafeSearchCNAME(&question, safeTarget, upstreamResp)}, msg.Answer...)
+				}
+				if s.dnssec {
+					msg.AuthenticatedData = upstreamResp.AuthenticatedData
+				}
+				if s.ednsPassthrough {
+					setReplyEdns0(msg, r, upstreamResp)
+				}
 				upstream = upstreamAddr
 				logAction = "BYPASS"
+				if zone != nil {
+					logAction = "FORWARD"
+				} else if safeTarget != "" {
+					logAction = "SAFESEARCH"
+				}
 			}
 
-		case policy.DNSActionBlock:
-			// Return 0.0.0.0 (sinkhole)
-			if answer := s.createBlockResponse(&question, domain); answer != nil {
-				msg.Answer = append(msg.Answer, answer)
-				responseIP = "0.0.0.0"
-			}
+		case action == policy.DNSActionBlock:
+			// Sinkhole or error, as the profile asks for
+			responseIP = s.answerBlocked(msg, &question, domain, decision)
 			logAction = "BLOCK"
 		}
 
 		// Log the DNS query to structured logger
 		latency := time.Since(startTime).Milliseconds()
-		s.logger.Info().
-			Str("client_ip", clientIP.String()).
+		logEvent := s.logger.Info().
+			Str("client_ip", clientIP.String())
+		if hostname := s.clientNames.Lookup(clientIP); hostname != "" {
+			logEvent = logEvent.Str("client_hostname", hostname)
+		}
+		logEvent.
 			Str("domain", domain).
 			Str("query_type", dns.TypeToString[qtype]).
 			Str("action", logAction).
 			Str("response_ip", responseIP).
 			Str("upstream", upstream).
 			Int64("latency_ms", latency).
+			Str("decision_id", decision.DecisionID).
 			Msg("DNS query processed")
 
 		// Record metrics
@@ -265,6 +573,19 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 		deviceName := clientIP.String()
 
 		metrics.DNSQueriesTotal.WithLabelValues(deviceName, logAction, dns.TypeToString[qtype]).Inc()
+
+		s.activity.RecordQuery(deviceName)
+		s.activity.Publish(activity.Event{
+			Type:      activity.EventDNS,
+			Client:    deviceName,
+			Host:      domain,
+			QueryType: dns.TypeToString[qtype],
+			Action:    logAction,
+		})
+		s.analytics.Record("dns", deviceName, "", logAction)
+		if logAction == "BLOCK" || logAction == "CNAME_BLOCK" {
+			s.activity.RecordBlock("dns", deviceName, domain, logAction)
+		}
 		metrics.DNSQueryDuration.WithLabelValues(logAction).Observe(time.Since(startTime).Seconds())
 	}
 
@@ -274,6 +595,133 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	}
 }
 
+// answerStatus answers the status record with the client's profile and the
+// notices due for it, one TXT record each. Other types get no records.
+func (s *Server) answerStatus(msg *dns.Msg, q *dns.Question, clientIP net.IP) {
+	if q.Qtype != dns.TypeTXT {
+		return
+	}
+
+	status, err := s.policyEngine.Status(clientIP, nil)
+	if err != nil {
+		s.logger.Error().Err(err).Str("client", clientIP.String()).Msg("Device status evaluation failed")
+		msg.Rcode = dns.RcodeServerFailure
+		return
+	}
+
+	for _, txt := range statusRecords(status) {
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    0, // Polled for changes
+			},
+			Txt: []string{txt},
+		})
+	}
+}
+
+// statusRecords formats a device status as "profile=kids", "bedtime=12"
+// and "usage_limit.gaming=4" (minutes remaining)
+func statusRecords(status *policy.DeviceStatus) []string {
+	records := []string{"profile=" + status.Profile}
+	for _, notice := range status.Notices {
+		name := notice.Kind
+		if notice.Category != "" {
+			name += "." + notice.Category
+		}
+		records = append(records, fmt.Sprintf("%s=%d", name, int(notice.Remaining/time.Minute)))
+	}
+	return records
+}
+
+// resolveWithoutProxy turns an INTERCEPT decision into BYPASS or BLOCK for DNS-only mode.
+// The DNS policy leaves allow/block decisions to the proxy policy, so evaluate that for
+// the domain's root path: ALLOW and WARN resolve normally, anything else is sinkholed.
+func (s *Server) resolveWithoutProxy(clientIP net.IP, domain string) policy.DNSAction {
+	decision := s.policyEngine.Evaluate(&policy.ProxyRequest{
+		ClientIP: clientIP,
+		Host:     domain,
+		Path:     "/",
+		Method:   "GET",
+	})
+	switch decision.Action {
+	case policy.ActionAllow:
+		return policy.DNSActionBypass
+	case policy.ActionWarn:
+		s.logger.Info().
+			Str("client", clientIP.String()).
+			Str("domain", domain).
+			Str("reason", decision.Reason).
+			Msg("Resolving domain in warn mode (would block)")
+		return policy.DNSActionBypass
+	}
+
+	s.logger.Debug().
+		Str("client", clientIP.String()).
+		Str("domain", domain).
+		Str("reason", decision.Reason).
+		Msg("Blocking domain in DNS-only mode")
+
+	return policy.DNSActionBlock
+}
+
+// blockedByCNAME reports whether an upstream answer should be blocked because
+// a target in its CNAME chain matches a block rule
+func (s *Server) blockedByCNAME(clientIP net.IP, domain, qname string, resp *dns.Msg) bool {
+	if !s.cnameInspection {
+		return false
+	}
+
+	chain := cnameChain(qname, resp.Answer)
+	if len(chain) == 0 {
+		return false
+	}
+
+	action, reason := s.policyEngine.GetCNAMEAction(clientIP, nil, domain, chain)
+	switch action {
+	case policy.ActionBlock:
+		s.logger.Info().
+			Str("client", clientIP.String()).
+			Str("domain", domain).
+			Strs("cname_chain", chain).
+			Str("reason", reason).
+			Msg("Blocking domain by CNAME target")
+		return true
+	case policy.ActionWarn:
+		s.logger.Info().
+			Str("client", clientIP.String()).
+			Str("domain", domain).
+			Strs("cname_chain", chain).
+			Str("reason", reason).
+			Msg("Resolving domain in warn mode (CNAME target would block)")
+	}
+
+	return false
+}
+
+// cnameChain follows CNAME records in an answer section from name and returns
+// the targets in resolution order, lowercased and without the trailing dot
+func cnameChain(name string, answers []dns.RR) []string {
+	var chain []string
+	for len(chain) < maxCNAMEHops {
+		target := ""
+		for _, rr := range answers {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+				target = cname.Target
+				break
+			}
+		}
+		if target == "" {
+			break
+		}
+		chain = append(chain, strings.ToLower(strings.TrimSuffix(target, ".")))
+		name = target
+	}
+	return chain
+}
+
 // createInterceptResponse creates a DNS response that returns the proxy IP
 func (s *Server) createInterceptResponse(q *dns.Question, domain string) dns.RR {
 	switch q.Qtype {
@@ -293,16 +741,63 @@ func (s *Server) createInterceptResponse(q *dns.Question, domain string) dns.RR
 			A: s.proxyIP.To4(),
 		}
 	case dns.TypeAAAA:
-		// Return empty for IPv6 to force IPv4
-		return nil
+		// Without an IPv6 proxy address, return no answer so clients use IPv4
+		if s.proxyIPv6 == nil {
+			return nil
+		}
+
+		s.logger.Debug().
+			Str("domain", domain).
+			Str("proxy_ipv6", s.proxyIPv6.String()).
+			Msg("Creating DNS intercept response")
+
+		return &dns.AAAA{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeAAAA,
+				Class:  dns.ClassINET,
+				Ttl:    s.interceptTTL,
+			},
+			AAAA: s.proxyIPv6,
+		}
 	default:
 		return nil
 	}
 }
 
+// answerBlocked answers a blocked question with the profile's block
+// response and returns the sinkhole address given (if any)
+func (s *Server) answerBlocked(msg *dns.Msg, q *dns.Question, domain string, decision policy.DNSDecision) string {
+	var answer dns.RR
+	switch decision.BlockResponse {
+	case policy.DNSBlockNXDomain:
+		msg.Rcode = dns.RcodeNameError
+	case policy.DNSBlockRefused:
+		msg.Rcode = dns.RcodeRefused
+	case policy.DNSBlockCustomIP:
+		answer = s.createSinkholeResponse(q, decision.BlockIP, decision.BlockIPv6)
+	default:
+		answer = s.createBlockResponse(q, domain)
+	}
+
+	if answer == nil {
+		return ""
+	}
+	msg.Answer = append(msg.Answer, answer)
+	return s.getResponseIP(answer)
+}
+
 // createBlockResponse creates a DNS response that blocks the domain
 func (s *Server) createBlockResponse(q *dns.Question, domain string) dns.RR {
-	if q.Qtype == dns.TypeA {
+	// Sinkhole IPv6 too, otherwise dual-stack clients reach the domain over IPv6
+	return s.createSinkholeResponse(q, net.IPv4zero, net.IPv6unspecified)
+}
+
+// createSinkholeResponse answers an address query with a sinkhole address.
+// Queries of other types, or for a family without an address, get no answer.
+func (s *Server) createSinkholeResponse(q *dns.Question, ipv4, ipv6 net.IP) dns.RR {
+	switch {
+	case q.Qtype == dns.TypeA && ipv4 != nil:
 		return &dns.A{
 			Hdr: dns.RR_Header{
 				Name:   q.Name,
@@ -310,27 +805,55 @@ func (s *Server) createBlockResponse(q *dns.Question, domain string) dns.RR {
 				Class:  dns.ClassINET,
 				Ttl:    s.blockTTL,
 			},
-			A: net.ParseIP("0.0.0.0").To4(),
+			A: ipv4.To4(),
+		}
+	case q.Qtype == dns.TypeAAAA && ipv6 != nil:
+		return &dns.AAAA{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeAAAA,
+				Class:  dns.ClassINET,
+				Ttl:    s.blockTTL,
+			},
+			AAAA: ipv6,
 		}
+	default:
+		return nil
 	}
-	return nil
 }
 
-// forwardToUpstream forwards a DNS query to upstream DNS servers
-func (s *Server) forwardToUpstream(r *dns.Msg) (*dns.Msg, string, error) {
+// forwardToUpstream forwards a DNS query to the forward zone's resolvers,
+// or to the upstream DNS servers when zone is nil
+func (s *Server) forwardToUpstream(r *dns.Msg, zone *forwardZone) (*dns.Msg, string, error) {
+	query := upstreamQuery(r, s.ednsPassthrough, s.dnssec)
+
+	upstreams := s.upstreams
+	if zone != nil {
+		upstreams = zone.upstreams
+	}
+
+	if s.cache != nil {
+		if resp, ok := s.cache.Get(query); ok {
+			return resp, "cache", nil
+		}
+	}
+
 	// Try each upstream DNS server
-	for _, upstream := range s.upstreamDNS {
-		resp, _, err := s.client.Exchange(r, upstream)
+	for _, upstream := range upstreams {
+		resp, err := upstream.Exchange(query)
 		if err == nil && resp != nil {
-			return resp, upstream, nil
+			if s.cache != nil {
+				s.cache.Set(query, resp)
+			}
+			return resp, upstream.String(), nil
 		}
 		s.logger.Warn().
 			Err(err).
-			Str("upstream", upstream).
+			Str("upstream", upstream.String()).
 			Msg("Upstream DNS query failed, trying next")
 
 		// Record upstream error
-		metrics.DNSUpstreamErrors.WithLabelValues(upstream).Inc()
+		metrics.DNSUpstreamErrors.WithLabelValues(upstream.String()).Inc()
 	}
 	return nil, "", fmt.Errorf("all upstream DNS servers failed")
 }
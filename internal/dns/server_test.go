@@ -0,0 +1,236 @@
+package dns
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+func TestInterceptAndBlockResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxyIPv6 string
+		qtype     uint16
+		intercept string // Expected intercept answer ("" = no answer)
+		block     string // Expected block answer
+	}{
+		{name: "A", qtype: dns.TypeA, intercept: "192.168.1.10", block: "0.0.0.0"},
+		{name: "AAAA without proxy IPv6", qtype: dns.TypeAAAA, intercept: "", block: "::"},
+		{name: "AAAA with proxy IPv6", proxyIPv6: "2001:db8::10", qtype: dns.TypeAAAA, intercept: "2001:db8::10", block: "::"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewServer(Config{ProxyIP: "192.168.1.10", ProxyIPv6: tt.proxyIPv6}, nil, zerolog.Nop())
+			if err != nil {
+				t.Fatalf("NewServer failed: %v", err)
+			}
+
+			q := &dns.Question{Name: "example.com.", Qtype: tt.qtype, Qclass: dns.ClassINET}
+
+			got := ""
+			if answer := s.createInterceptResponse(q, "example.com"); answer != nil {
+				got = s.getResponseIP(answer)
+			}
+			if got != tt.intercept {
+				t.Errorf("intercept = %q, want %q", got, tt.intercept)
+			}
+
+			answer := s.createBlockResponse(q, "example.com")
+			if answer == nil || s.getResponseIP(answer) != tt.block {
+				t.Errorf("block = %v, want %s", answer, tt.block)
+			}
+		})
+	}
+}
+
+func TestNewServerRejectsIPv4AsProxyIPv6(t *testing.T) {
+	if _, err := NewServer(Config{ProxyIPv6: "192.168.1.10"}, nil, zerolog.Nop()); err == nil {
+		t.Error("expected error for IPv4 proxy_ipv6")
+	}
+}
+
+func TestCNAMEChain(t *testing.T) {
+	cname := func(name, target string) dns.RR {
+		return &dns.CNAME{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET}, Target: target}
+	}
+
+	tests := []struct {
+		name    string
+		answers []dns.RR
+		want    []string
+	}{
+		{
+			name: "chain in any order",
+			answers: []dns.RR{
+				cname("edge.cdn.net.", "X.Tracker.net."),
+				cname("metrics.example.com.", "edge.cdn.net."),
+				&dns.A{Hdr: dns.RR_Header{Name: "x.tracker.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}},
+			},
+			want: []string{"edge.cdn.net", "x.tracker.net"},
+		},
+		{
+			name:    "no CNAME",
+			answers: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "metrics.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}}},
+			want:    nil,
+		},
+		{
+			name:    "unrelated CNAME",
+			answers: []dns.RR{cname("other.example.com.", "tracker.net.")},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cnameChain("metrics.example.com.", tt.answers)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("cnameChain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// CNAME loops are cut off
+	loop := []dns.RR{cname("metrics.example.com.", "a.net."), cname("a.net.", "metrics.example.com.")}
+	if got := cnameChain("metrics.example.com.", loop); len(got) != maxCNAMEHops {
+		t.Errorf("expected loop to stop after %d hops, got %d", maxCNAMEHops, len(got))
+	}
+}
+
+func TestForwardZones(t *testing.T) {
+	s, err := NewServer(Config{
+		UpstreamDNS: []string{"8.8.8.8:53"},
+		ForwardZones: []ForwardZone{
+			{Zone: "example.com", Servers: []string{"10.0.0.53:53"}},
+			{Zone: ".Corp.Example.com.", Servers: []string{"10.1.0.53:53", "tcp://10.1.0.54:53"}},
+		},
+	}, nil, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	tests := []struct {
+		domain string
+		want   string // First resolver ("" = no forward zone)
+	}{
+		{domain: "corp.example.com", want: "10.1.0.53:53"},
+		{domain: "WIKI.corp.example.com.", want: "10.1.0.53:53"},
+		{domain: "www.example.com", want: "10.0.0.53:53"},
+		{domain: "example.com", want: "10.0.0.53:53"},
+		{domain: "notexample.com", want: ""},
+		{domain: "google.com", want: ""},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if zone := s.forwardZoneFor(tt.domain); zone != nil {
+			got = zone.upstreams[0].String()
+		}
+		if got != tt.want {
+			t.Errorf("forwardZoneFor(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+
+	if _, err := NewServer(Config{ForwardZones: []ForwardZone{{Zone: "corp.example.com"}}}, nil, zerolog.Nop()); err == nil {
+		t.Error("expected error for a forward zone without servers")
+	}
+}
+
+func TestSafeSearchQuery(t *testing.T) {
+	s, err := NewServer(Config{BypassTTLCap: 300}, nil, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.google.com.", dns.TypeA)
+
+	query, target := safeSearchQuery(r, &r.Question[0])
+	if target != "forcesafesearch.google.com" {
+		t.Fatalf("target = %q, want forcesafesearch.google.com", target)
+	}
+	if query.Question[0].Name != "forcesafesearch.google.com." || r.Question[0].Name != "www.google.com." {
+		t.Errorf("query = %s, original = %s", query.Question[0].Name, r.Question[0].Name)
+	}
+
+	resp := new(dns.Msg)
+	resp.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "forcesafesearch.google.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 120}}}
+	cname, ok := s.createSafeSearchCNAME(&r.Question[0], target, resp).(*dns.CNAME)
+	if !ok || cname.Hdr.Name != "www.google.com." || cname.Target != "forcesafesearch.google.com." || cname.Hdr.Ttl != 120 {
+		t.Errorf("cname = %v", cname)
+	}
+
+	// Only address queries for search engines are rewritten
+	for _, q := range []dns.Question{
+		{Name: "www.google.com.", Qtype: dns.TypeMX, Qclass: dns.ClassINET},
+		{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+	} {
+		if query, target := safeSearchQuery(r, &q); query != r || target != "" {
+			t.Errorf("safeSearchQuery(%s %s) = %q", q.Name, dns.TypeToString[q.Qtype], target)
+		}
+	}
+}
+
+func TestAnswerBlocked(t *testing.T) {
+	s, err := NewServer(Config{ProxyIP: "192.168.1.10", BlockTTL: 60}, nil, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		decision policy.DNSDecision
+		qtype    uint16
+		rcode    int
+		answer   string // Expected sinkhole address ("" = no answer)
+	}{
+		{name: "default", qtype: dns.TypeA, rcode: dns.RcodeSuccess, answer: "0.0.0.0"},
+		{name: "zero-ip AAAA", decision: policy.DNSDecision{BlockResponse: policy.DNSBlockZeroIP}, qtype: dns.TypeAAAA, rcode: dns.RcodeSuccess, answer: "::"},
+		{name: "nxdomain", decision: policy.DNSDecision{BlockResponse: policy.DNSBlockNXDomain}, qtype: dns.TypeA, rcode: dns.RcodeNameError},
+		{name: "refused", decision: policy.DNSDecision{BlockResponse: policy.DNSBlockRefused}, qtype: dns.TypeA, rcode: dns.RcodeRefused},
+		{name: "custom-ip", decision: policy.DNSDecision{BlockResponse: policy.DNSBlockCustomIP, BlockIP: net.ParseIP("192.168.1.5")}, qtype: dns.TypeA, rcode: dns.RcodeSuccess, answer: "192.168.1.5"},
+		{name: "custom-ip without IPv6", decision: policy.DNSDecision{BlockResponse: policy.DNSBlockCustomIP, BlockIP: net.ParseIP("192.168.1.5")}, qtype: dns.TypeAAAA, rcode: dns.RcodeSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := new(dns.Msg)
+			q := &dns.Question{Name: "ads.example.com.", Qtype: tt.qtype, Qclass: dns.ClassINET}
+
+			got := s.answerBlocked(msg, q, "ads.example.com", tt.decision)
+			if got != tt.answer {
+				t.Errorf("answer = %q, want %q", got, tt.answer)
+			}
+			if msg.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[msg.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if tt.answer == "" && len(msg.Answer) != 0 {
+				t.Errorf("unexpected answers: %v", msg.Answer)
+			}
+		})
+	}
+}
+
+func TestStatusRecords(t *testing.T) {
+	got := statusRecords(&policy.DeviceStatus{
+		Profile: "kids",
+		Notices: []policy.Notice{
+			{Kind: "usage_limit", Category: "gaming", Remaining: 4 * time.Minute, Threshold: 5},
+			{Kind: "bedtime", Remaining: 12 * time.Minute, Threshold: 15},
+		},
+	})
+	want := []string{"profile=kids", "usage_limit.gaming=4", "bedtime=12"}
+	if !slices.Equal(got, want) {
+		t.Errorf("statusRecords() = %q, want %q", got, want)
+	}
+
+	// Unknown devices have no profile and nothing due
+	if got := statusRecords(&policy.DeviceStatus{}); !slices.Equal(got, []string{"profile="}) {
+		t.Errorf("statusRecords() = %q for an unknown device", got)
+	}
+}
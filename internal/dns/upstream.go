@@ -0,0 +1,250 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohContentType is the media type for DNS-over-HTTPS wire format messages (RFC 8484)
+const dohContentType = "application/dns-message"
+
+// maxIdleDoTConns is the number of idle connections kept per DNS-over-TLS upstream
+const maxIdleDoTConns = 4
+
+// Upstream forwards DNS queries to a single upstream resolver
+type Upstream interface {
+	Exchange(m *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// NewUpstream creates an upstream from a server spec:
+//
+//	8.8.8.8:53                          plain DNS over UDP
+//	udp://8.8.8.8:53, tcp://8.8.8.8:53  plain DNS over the given transport
+//	tls://1.1.1.1, tls://dns.quad9.net  DNS-over-TLS (port 853 by default)
+//	https://1.1.1.1/dns-query           DNS-over-HTTPS
+//
+// Host names in tls:// and https:// specs are resolved with the system
+// resolver, so use IP addresses if KProxy is the host's own resolver.
+func NewUpstream(spec string, timeout time.Duration) (Upstream, error) {
+	if !strings.Contains(spec, "://") {
+		return newPlainUpstream("udp", spec, timeout)
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", spec, err)
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		return newPlainUpstream(u.Scheme, u.Host, timeout)
+	case "tls":
+		return newDoTUpstream(u, timeout)
+	case "https":
+		return newDoHUpstream(u, timeout)
+	default:
+		return nil, fmt.Errorf("unsupported upstream scheme %q in %q (supported: udp, tcp, tls, https)", u.Scheme, spec)
+	}
+}
+
+// plainUpstream is a classic UDP or TCP resolver
+type plainUpstream struct {
+	addr   string
+	client *dns.Client
+}
+
+func newPlainUpstream(network, addr string, timeout time.Duration) (*plainUpstream, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid upstream address %q: %w", addr, err)
+	}
+
+	return &plainUpstream{
+		addr:   addr,
+		client: &dns.Client{Net: network, Timeout: timeout},
+	}, nil
+}
+
+func (p *plainUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	resp, _, err := p.client.Exchange(m, p.addr)
+	return resp, err
+}
+
+func (p *plainUpstream) String() string {
+	return p.addr
+}
+
+// dotUpstream is a DNS-over-TLS resolver. Connections are kept open and reused
+// so that each query doesn't pay for a TLS handshake.
+type dotUpstream struct {
+	spec   string
+	addr   string
+	client *dns.Client
+
+	mu   sync.Mutex
+	idle []*dns.Conn
+}
+
+func newDoTUpstream(u *url.URL, timeout time.Duration) (*dotUpstream, error) {
+	host := u.Hostname()
+	if host == "" {
+		return nil, fmt.Errorf("invalid DNS-over-TLS upstream %q: missing host", u.String())
+	}
+	port := u.Port()
+	if port == "" {
+		port = "853"
+	}
+
+	return &dotUpstream{
+		spec: u.String(),
+		addr: net.JoinHostPort(host, port),
+		client: &dns.Client{
+			Net:     "tcp-tls",
+			Timeout: timeout,
+			TLSConfig: &tls.Config{
+				ServerName: host,
+				MinVersion: tls.VersionTLS12,
+			},
+		},
+	}, nil
+}
+
+func (d *dotUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	// A pooled connection may have been closed by the server while idle,
+	// so retry once on a fresh connection before giving up
+	if conn := d.get(); conn != nil {
+		resp, _, err := d.client.ExchangeWithConn(m, conn)
+		if err == nil {
+			d.put(conn)
+			return resp, nil
+		}
+		_ = conn.Close()
+	}
+
+	conn, err := d.client.Dial(d.addr)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, err := d.client.ExchangeWithConn(m, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	d.put(conn)
+	return resp, nil
+}
+
+// get takes an idle connection from the pool, or returns nil if there is none
+func (d *dotUpstream) get() *dns.Conn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.idle) == 0 {
+		return nil
+	}
+	conn := d.idle[len(d.idle)-1]
+	d.idle = d.idle[:len(d.idle)-1]
+	return conn
+}
+
+// put returns a connection to the pool, closing it if the pool is full
+func (d *dotUpstream) put(conn *dns.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.idle) >= maxIdleDoTConns {
+		_ = conn.Close()
+		return
+	}
+	d.idle = append(d.idle, conn)
+}
+
+func (d *dotUpstream) String() string {
+	return d.spec
+}
+
+// dohUpstream is a DNS-over-HTTPS resolver. The HTTP transport pools
+// connections and negotiates HTTP/2 where the server supports it.
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHUpstream(u *url.URL, timeout time.Duration) (*dohUpstream, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid DNS-over-HTTPS upstream %q: missing host", u.String())
+	}
+	if u.Path == "" {
+		u.Path = "/dns-query"
+	}
+
+	return &dohUpstream{
+		url: u.String(),
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:               nil, // Never route DNS through an HTTP proxy
+				ForceAttemptHTTP2:   true,
+				MaxIdleConnsPerHost: maxIdleDoTConns,
+				IdleConnTimeout:     90 * time.Second,
+				TLSClientConfig: &tls.Config{
+					MinVersion: tls.VersionTLS12,
+				},
+			},
+		},
+	}, nil
+}
+
+func (d *dohUpstream) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 recommends a zero ID so responses are cache-friendly
+	query := m.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	httpResp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned HTTP %d", httpResp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DoH response: %w", err)
+	}
+
+	resp := new(dns.Msg)
+	if err := resp.Unpack(body); err != nil {
+		return nil, fmt.Errorf("failed to unpack DoH response: %w", err)
+	}
+	resp.Id = m.Id
+
+	return resp, nil
+}
+
+func (d *dohUpstream) String() string {
+	return d.url
+}
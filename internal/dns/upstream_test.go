@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// answerA replies to every query with a fixed A record
+func answerA(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1").To4(),
+	})
+	_ = w.WriteMsg(m)
+}
+
+func newQuery() *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	return m
+}
+
+func TestNewUpstream(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "8.8.8.8:53", want: "8.8.8.8:53"},
+		{spec: "tcp://8.8.8.8:53", want: "8.8.8.8:53"},
+		{spec: "tls://1.1.1.1", want: "tls://1.1.1.1"},
+		{spec: "https://1.1.1.1", want: "https://1.1.1.1/dns-query"},
+		{spec: "https://dns.google/resolve", want: "https://dns.google/resolve"},
+		{spec: "8.8.8.8", wantErr: true},
+		{spec: "quic://1.1.1.1", wantErr: true},
+		{spec: "tls://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			u, err := NewUpstream(tt.spec, time.Second)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error for %q", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewUpstream failed: %v", err)
+			}
+			if u.String() != tt.want {
+				t.Errorf("String() = %q, want %q", u.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDoHUpstream(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		q := new(dns.Msg)
+		if err := q.Unpack(body); err != nil || q.Id != 0 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		rec := &recorder{}
+		answerA(rec, q)
+		packed, _ := rec.msg.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	u, err := NewUpstream(srv.URL+"/dns-query", time.Second)
+	if err != nil {
+		t.Fatalf("NewUpstream failed: %v", err)
+	}
+	u.(*dohUpstream).client = srv.Client()
+
+	q := newQuery()
+	resp, err := u.Exchange(q)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if resp.Id != q.Id {
+		t.Errorf("response ID = %d, want query ID %d", resp.Id, q.Id)
+	}
+	if len(resp.Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %d", len(resp.Answer))
+	}
+}
+
+func TestDoTUpstreamReusesConnections(t *testing.T) {
+	// Borrow the httptest certificate (valid for 127.0.0.1) for the DoT listener
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certSrv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(certSrv.Certificate())
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certSrv.TLS.Certificates})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	server := &dns.Server{Listener: ln, Handler: dns.HandlerFunc(answerA)}
+	go func() { _ = server.ActivateAndServe() }()
+	defer func() { _ = server.Shutdown() }()
+
+	u, err := NewUpstream("tls://"+ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("NewUpstream failed: %v", err)
+	}
+	dot := u.(*dotUpstream)
+	dot.client.TLSConfig.RootCAs = roots
+
+	for i := 0; i < 3; i++ {
+		resp, err := dot.Exchange(newQuery())
+		if err != nil {
+			t.Fatalf("Exchange %d failed: %v", i, err)
+		}
+		if len(resp.Answer) != 1 {
+			t.Fatalf("expected 1 answer, got %d", len(resp.Answer))
+		}
+	}
+
+	if len(dot.idle) != 1 {
+		t.Errorf("expected 1 pooled connection, got %d", len(dot.idle))
+	}
+}
+
+// recorder captures the message written by a handler
+type recorder struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (r *recorder) WriteMsg(m *dns.Msg) error {
+	r.msg = m
+	return nil
+}
@@ -0,0 +1,215 @@
+// Package extauthz answers external authorization checks from other
+// gateways (Envoy's ext_authz gRPC API) with KProxy's proxy decisions, so
+// KProxy can be the policy decision point for traffic it doesn't proxy.
+package extauthz
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// Evaluator decides proxy requests (the policy engine)
+type Evaluator interface {
+	Evaluate(req *policy.ProxyRequest) *policy.PolicyDecision
+}
+
+// Server is the gRPC external authorization service. Each check is decided
+// as a proxied request would be: BLOCK is denied with 403, and ALLOW and
+// WARN are allowed (a gateway can't show the warning). Checks must carry
+// "authorization: Bearer <token>" metadata.
+type Server struct {
+	authv3.UnimplementedAuthorizationServer
+
+	addr          string
+	token         string
+	evaluator     Evaluator
+	policyHeaders []string
+	server        *grpc.Server
+	logger        zerolog.Logger
+}
+
+// NewServer creates a new external authorization server. policyHeaders
+// are the request headers passed to policy, as for proxied requests.
+func NewServer(addr, token string, evaluator Evaluator, policyHeaders []string, logger zerolog.Logger) *Server {
+	s := &Server{
+		addr:          addr,
+		token:         token,
+		evaluator:     evaluator,
+		policyHeaders: policyHeaders,
+		server:        grpc.NewServer(),
+		logger:        logger.With().Str("component", "ext-authz").Logger(),
+	}
+	authv3.RegisterAuthorizationServer(s.server, s)
+	return s
+}
+
+// Start starts the external authorization server
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info().Str("addr", s.addr).Msg("Starting external authorization server")
+	go func() {
+		if err := s.server.Serve(listener); err != nil {
+			s.logger.Error().Err(err).Msg("External authorization server error")
+		}
+	}()
+	return nil
+}
+
+// Stop stops the external authorization server, finishing checks in flight
+func (s *Server) Stop() error {
+	s.logger.Info().Msg("Stopping external authorization server")
+	s.server.GracefulStop()
+	return nil
+}
+
+// Check decides a request received by another gateway
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	if !s.authorized(ctx) {
+		return nil, grpcstatus.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	attrs := req.GetAttributes()
+	httpReq := attrs.GetRequest().GetHttp()
+	if httpReq == nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, "no HTTP request attributes")
+	}
+	headers := requestHeaders(httpReq)
+
+	clientIP := net.ParseIP(attrs.GetSource().GetAddress().GetSocketAddress().GetAddress())
+	if clientIP == nil {
+		clientIP = forwardedFor(headers["x-forwarded-for"])
+	}
+	if clientIP == nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, "no client address")
+	}
+
+	host := httpReq.GetHost()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	policyReq := &policy.ProxyRequest{
+		ClientIP:  clientIP,
+		Host:      host,
+		Method:    httpReq.GetMethod(),
+		UserAgent: headers["user-agent"],
+		Encrypted: httpReq.GetScheme() == "https",
+	}
+	// The path is the request target, with the query string
+	if target, err := url.ParseRequestURI(httpReq.GetPath()); err == nil {
+		policyReq.Path = target.Path
+		policyReq.Query = target.Query()
+	} else {
+		policyReq.Path = httpReq.GetPath()
+	}
+	for _, name := range s.policyHeaders {
+		if value := headers[strings.ToLower(name)]; value != "" {
+			if policyReq.Headers == nil {
+				policyReq.Headers = make(map[string]string, len(s.policyHeaders))
+			}
+			policyReq.Headers[strings.ToLower(name)] = value
+		}
+	}
+
+	decision := s.evaluator.Evaluate(policyReq)
+	metrics.ExtAuthzChecks.WithLabelValues(string(decision.Action)).Inc()
+
+	s.logger.Debug().
+		Str("client_ip", clientIP.String()).
+		Str("host", host).
+		Str("path", policyReq.Path).
+		Str("action", string(decision.Action)).
+		Str("reason", decision.Reason).
+		Str("decision_id", decision.DecisionID).
+		Msg("External authorization check")
+
+	switch decision.Action {
+	case policy.ActionAllow, policy.ActionWarn:
+		return &authv3.CheckResponse{
+			Status:       &status.Status{Code: int32(code.Code_OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{}},
+		}, nil
+	default:
+		return &authv3.CheckResponse{
+			Status: &status.Status{Code: int32(code.Code_PERMISSION_DENIED), Message: decision.Reason},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
+				Headers: deniedHeaders(decision),
+				Body:    fmt.Sprintf("Blocked: %s\n", decision.Reason),
+			}},
+		}, nil
+	}
+}
+
+// authorized reports whether a check carries the token
+func (s *Server) authorized(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requestHeaders returns the request's headers keyed by lowercase name,
+// whether the gateway sends them as a map or as a header map
+func requestHeaders(req *authv3.AttributeContext_HttpRequest) map[string]string {
+	if headers := req.GetHeaders(); len(headers) > 0 {
+		return headers
+	}
+	headers := map[string]string{}
+	for _, header := range req.GetHeaderMap().GetHeaders() {
+		value := header.GetValue()
+		if value == "" {
+			value = string(header.GetRawValue())
+		}
+		name := strings.ToLower(header.GetKey())
+		if _, ok := headers[name]; !ok {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// forwardedFor returns the first address of an X-Forwarded-For header
+func forwardedFor(xff string) net.IP {
+	first, _, _ := strings.Cut(xff, ",")
+	return net.ParseIP(strings.TrimSpace(first))
+}
+
+// deniedHeaders describes a block to the client and the gateway's logs
+func deniedHeaders(decision *policy.PolicyDecision) []*corev3.HeaderValueOption {
+	headers := []*corev3.HeaderValueOption{
+		{Header: &corev3.HeaderValue{Key: "content-type", Value: "text/plain; charset=utf-8"}},
+		{Header: &corev3.HeaderValue{Key: "x-kproxy-reason", Value: decision.Reason}},
+	}
+	if decision.DecisionID != "" {
+		headers = append(headers, &corev3.HeaderValueOption{
+			Header: &corev3.HeaderValue{Key: "x-kproxy-decision-id", Value: decision.DecisionID},
+		})
+	}
+	return headers
+}
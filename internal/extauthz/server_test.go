@@ -0,0 +1,131 @@
+package extauthz
+
+import (
+	"context"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// blockingEvaluator blocks one host and records the requests it decides
+type blockingEvaluator struct {
+	host     string
+	requests []*policy.ProxyRequest
+}
+
+func (e *blockingEvaluator) Evaluate(req *policy.ProxyRequest) *policy.PolicyDecision {
+	e.requests = append(e.requests, req)
+	if req.Host == e.host {
+		return &policy.PolicyDecision{Action: policy.ActionBlock, Reason: "blocked domain", DecisionID: "0123456789abcdef"}
+	}
+	return &policy.PolicyDecision{Action: policy.ActionAllow}
+}
+
+// checkRequest builds an Envoy check for a request from clientIP
+func checkRequest(clientIP, host, path string, headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Source: &authv3.AttributeContext_Peer{Address: &corev3.Address{
+			Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{Address: clientIP}},
+		}},
+		Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+			Method:  "GET",
+			Host:    host,
+			Path:    path,
+			Scheme:  "https",
+			Headers: headers,
+		}},
+	}}
+}
+
+func TestCheck(t *testing.T) {
+	evaluator := &blockingEvaluator{host: "blocked.example.com"}
+	s := NewServer("127.0.0.1:0", "secret", evaluator, []string{"Referer"}, zerolog.Nop())
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+
+	resp, err := s.Check(ctx, checkRequest("192.168.1.10", "www.example.com:8443", "/watch?v=1", map[string]string{
+		"referer":    "https://example.com/",
+		"user-agent": "test",
+		"cookie":     "session=1",
+	}))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if resp.GetStatus().GetCode() != int32(code.Code_OK) || resp.GetOkResponse() == nil {
+		t.Errorf("Expected an allowed check, got %v", resp)
+	}
+	req := evaluator.requests[0]
+	if req.ClientIP.String() != "192.168.1.10" || req.Host != "www.example.com" || req.Path != "/watch" || !req.Encrypted {
+		t.Errorf("Unexpected policy request %+v", req)
+	}
+	if len(req.Query["v"]) != 1 || req.Query["v"][0] != "1" || req.UserAgent != "test" {
+		t.Errorf("Expected the query and user agent in the policy request, got %+v", req)
+	}
+	if len(req.Headers) != 1 || req.Headers["referer"] != "https://example.com/" {
+		t.Errorf("Expected only the policy headers, got %v", req.Headers)
+	}
+
+	resp, err = s.Check(ctx, checkRequest("192.168.1.10", "blocked.example.com", "/", nil))
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	denied := resp.GetDeniedResponse()
+	if resp.GetStatus().GetCode() != int32(code.Code_PERMISSION_DENIED) || denied == nil || denied.GetStatus().GetCode() != typev3.StatusCode_Forbidden {
+		t.Fatalf("Expected a denied check, got %v", resp)
+	}
+	found := false
+	for _, header := range denied.GetHeaders() {
+		if header.GetHeader().GetKey() == "x-kproxy-decision-id" && header.GetHeader().GetValue() == "0123456789abcdef" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected the decision ID in the denied response headers, got %v", denied.GetHeaders())
+	}
+}
+
+func TestCheck_ClientAddress(t *testing.T) {
+	evaluator := &blockingEvaluator{}
+	s := NewServer("127.0.0.1:0", "secret", evaluator, nil, zerolog.Nop())
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+
+	// Without a source address the client is the first forwarded address
+	if _, err := s.Check(ctx, checkRequest("", "www.example.com", "/", map[string]string{
+		"x-forwarded-for": "192.168.1.20, 10.0.0.1",
+	})); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if ip := evaluator.requests[0].ClientIP.String(); ip != "192.168.1.20" {
+		t.Errorf("Expected client 192.168.1.20, got %s", ip)
+	}
+
+	_, err := s.Check(ctx, checkRequest("", "www.example.com", "/", nil))
+	if grpcstatus.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument without a client address, got %v", err)
+	}
+}
+
+func TestCheck_Token(t *testing.T) {
+	evaluator := &blockingEvaluator{}
+	s := NewServer("127.0.0.1:0", "secret", evaluator, nil, zerolog.Nop())
+	req := checkRequest("192.168.1.10", "www.example.com", "/", nil)
+
+	for name, ctx := range map[string]context.Context{
+		"missing": context.Background(),
+		"wrong":   metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer guessed")),
+	} {
+		if _, err := s.Check(ctx, req); grpcstatus.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s token: expected Unauthenticated, got %v", name, err)
+		}
+	}
+	if len(evaluator.requests) != 0 {
+		t.Error("Expected unauthenticated checks not to be evaluated")
+	}
+}
@@ -0,0 +1,128 @@
+package features
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// Experimental feature flags
+const (
+	H3Listener      = "h3_listener"      // HTTP/3 (QUIC) proxy listener
+	ContentScanning = "content_scanning" // Scanning of response bodies
+)
+
+// descriptions lists every known flag
+var descriptions = map[string]string{
+	H3Listener:      "HTTP/3 (QUIC) proxy listener",
+	ContentScanning: "Scanning of response bodies",
+}
+
+// ErrUnknownFlag is returned for flag names that aren't in the registry
+var ErrUnknownFlag = errors.New("unknown feature flag")
+
+// Flag is the state of one feature flag
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	Overridden  bool   `json:"overridden"` // Changed at runtime from the configured value
+}
+
+// Set holds the feature flags of a deployment. Flags start at their
+// configured values and can be switched at runtime through the admin API;
+// runtime changes are not persisted.
+type Set struct {
+	logger zerolog.Logger
+
+	mu         sync.RWMutex
+	configured map[string]bool
+	enabled    map[string]bool
+}
+
+// New creates a flag set from the configured values. Flags that aren't
+// configured are off.
+func New(configured map[string]bool, logger zerolog.Logger) (*Set, error) {
+	s := &Set{
+		logger:     logger.With().Str("component", "features").Logger(),
+		configured: make(map[string]bool, len(descriptions)),
+		enabled:    make(map[string]bool, len(descriptions)),
+	}
+
+	for name, on := range configured {
+		if _, ok := descriptions[name]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+		}
+		s.configured[name] = on
+		s.enabled[name] = on
+	}
+
+	return s, nil
+}
+
+// Enabled reports whether a feature is on. A nil set has every feature off.
+func (s *Set) Enabled(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.enabled[name]
+}
+
+// Set switches a feature on or off at runtime
+func (s *Set) Set(name string, enabled bool) (Flag, error) {
+	if _, ok := descriptions[name]; !ok {
+		return Flag{}, fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+	}
+
+	s.mu.Lock()
+	s.enabled[name] = enabled
+	s.mu.Unlock()
+
+	s.logger.Warn().Str("flag", name).Bool("enabled", enabled).Msg("Feature flag changed")
+	return s.flag(name), nil
+}
+
+// Reset returns a feature to its configured value
+func (s *Set) Reset(name string) (Flag, error) {
+	if _, ok := descriptions[name]; !ok {
+		return Flag{}, fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+	}
+
+	s.mu.Lock()
+	s.enabled[name] = s.configured[name]
+	s.mu.Unlock()
+
+	s.logger.Info().Str("flag", name).Msg("Feature flag reset to configured value")
+	return s.flag(name), nil
+}
+
+// List returns every known flag, sorted by name
+func (s *Set) List() []Flag {
+	names := make([]string, 0, len(descriptions))
+	for name := range descriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := make([]Flag, 0, len(names))
+	for _, name := range names {
+		flags = append(flags, s.flag(name))
+	}
+	return flags
+}
+
+func (s *Set) flag(name string) Flag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Flag{
+		Name:        name,
+		Description: descriptions[name],
+		Enabled:     s.enabled[name],
+		Overridden:  s.enabled[name] != s.configured[name],
+	}
+}
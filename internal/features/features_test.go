@@ -0,0 +1,57 @@
+package features
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSet(t *testing.T) {
+	s, err := New(map[string]bool{H3Listener: true, ContentScanning: false}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if !s.Enabled(H3Listener) || s.Enabled(ContentScanning) {
+		t.Fatalf("flags = %+v, want only h3_listener on", s.List())
+	}
+
+	flag, err := s.Set(ContentScanning, true)
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if !flag.Enabled || !flag.Overridden || !s.Enabled(ContentScanning) {
+		t.Errorf("Set() = %+v, want enabled and overridden", flag)
+	}
+
+	flag, err = s.Reset(ContentScanning)
+	if err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if flag.Enabled || flag.Overridden {
+		t.Errorf("Reset() = %+v, want the configured value", flag)
+	}
+
+	if _, err := s.Set("warp_drive", true); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("Set(unknown) error = %v, want ErrUnknownFlag", err)
+	}
+
+	flags := s.List()
+	if len(flags) != 2 || flags[0].Name != ContentScanning || flags[1].Name != H3Listener {
+		t.Errorf("List() = %+v, want both flags sorted by name", flags)
+	}
+}
+
+func TestNew_UnknownFlag(t *testing.T) {
+	if _, err := New(map[string]bool{"warp_drive": true}, zerolog.Nop()); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("New() error = %v, want ErrUnknownFlag", err)
+	}
+}
+
+func TestNilSet(t *testing.T) {
+	var s *Set
+	if s.Enabled(H3Listener) {
+		t.Error("nil set reports a feature enabled")
+	}
+}
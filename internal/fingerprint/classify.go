@@ -0,0 +1,98 @@
+package fingerprint
+
+import "strings"
+
+// Device types
+const (
+	Phone    = "phone"
+	Tablet   = "tablet"
+	TV       = "tv"
+	Console  = "console"
+	Computer = "computer" // Laptop or desktop
+)
+
+// userAgentTypes are User-Agent tokens and the device types they give away,
+// most specific first: a smart TV's browser also claims to run Linux
+var userAgentTypes = []struct {
+	token      string // Lowercase
+	deviceType string
+}{
+	{"smarttv", TV},
+	{"smart-tv", TV},
+	{"hbbtv", TV},
+	{"tizen", TV},
+	{"web0s", TV},
+	{"webos", TV},
+	{"bravia", TV},
+	{"appletv", TV},
+	{"apple tv", TV},
+	{"roku", TV},
+	{"crkey", TV}, // Chromecast
+	{"aftb", TV},  // Fire TV devices are AFTB, AFTM, AFTS...
+	{"aftm", TV},
+	{"afts", TV},
+	{"playstation", Console},
+	{"xbox", Console},
+	{"nintendo", Console},
+	{"ipad", Tablet},
+	{"iphone", Phone},
+	{"ipod", Phone},
+	{"windows phone", Phone},
+	{"android", ""}, // Phone or tablet, decided by "mobile"
+	{"cros", Computer},
+	{"windows nt", Computer},
+	{"macintosh", Computer},
+	{"x11;", Computer},
+}
+
+// dhcpParameterTypes are the DHCP parameter request lists of common
+// operating systems, which ask for options in a fixed order
+var dhcpParameterTypes = map[string]string{
+	"1,121,3,6,15,108,114,119,252":               Phone,    // iOS and iPadOS
+	"1,121,3,6,15,108,114,119,252,95,44,46":      Computer, // macOS
+	"1,3,6,15,31,33,43,44,46,47,119,121,249,252": Computer, // Windows 10 and 11
+}
+
+// Classify guesses the device type of a fingerprint, or "" if nothing it
+// revealed is recognised. User-Agents are the best evidence, then the DHCP
+// vendor class, then the DHCP parameter request list.
+func Classify(f *Fingerprint) string {
+	if deviceType := userAgentsType(f.UserAgents); deviceType != "" {
+		return deviceType
+	}
+	vendorClass := strings.ToLower(f.VendorClass)
+	switch {
+	case strings.HasPrefix(vendorClass, "android-dhcp"):
+		return Phone
+	case strings.HasPrefix(vendorClass, "msft"):
+		return Computer
+	}
+	return dhcpParameterTypes[f.DHCPParameters]
+}
+
+// userAgentsType is the most specific device type any of the User-Agents
+// gives away. Apps often send User-Agents without a platform, so a client
+// is recognised by any one that has it.
+func userAgentsType(userAgents []string) string {
+	best := len(userAgentTypes)
+	deviceType := ""
+	for _, userAgent := range userAgents {
+		ua := strings.ToLower(userAgent)
+		for i, t := range userAgentTypes[:best] {
+			if !strings.Contains(ua, t.token) {
+				continue
+			}
+			best = i
+			deviceType = t.deviceType
+			if t.token == "android" {
+				// Android phones' browsers say "Mobile"; tablets' don't
+				deviceType = Tablet
+				if strings.Contains(ua, "mobile") {
+					deviceType = Phone
+				}
+			}
+			break
+		}
+	}
+	return deviceType
+}
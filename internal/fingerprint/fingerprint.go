@@ -0,0 +1,200 @@
+// Package fingerprint guesses what kind of device a client is (a phone, a
+// TV, a games console...) from what it reveals about itself: the options it
+// asks for in DHCP requests, its DHCP vendor class and the User-Agent of its
+// HTTP requests.
+package fingerprint
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/rs/zerolog"
+)
+
+// cacheSize is how many clients (and IP to MAC addresses) are remembered
+const cacheSize = 4096
+
+// maxUserAgents is how many distinct User-Agents are kept per client
+const maxUserAgents = 5
+
+// maxUserAgentLength is the longest User-Agent kept
+const maxUserAgentLength = 512
+
+// Fingerprint is what a client revealed about itself and the device type
+// guessed from it
+type Fingerprint struct {
+	MAC            string    `json:"mac,omitempty"`
+	IP             string    `json:"ip,omitempty"`
+	DHCPParameters string    `json:"dhcp_parameters,omitempty"` // Option 55, e.g. "1,121,3,6,15,119,252"
+	VendorClass    string    `json:"vendor_class,omitempty"`    // Option 60, e.g. "android-dhcp-13"
+	UserAgents     []string  `json:"user_agents,omitempty"`     // Newest first
+	DeviceType     string    `json:"device_type,omitempty"`     // "phone", "tablet", "tv", "console", "computer" or "" if unknown
+	Seen           time.Time `json:"seen"`
+}
+
+// Collector keeps the fingerprint of each client, by MAC address when it is
+// known and by IP address otherwise. Clients reached through the proxy
+// without a MAC address are matched to the MAC of their DHCP lease.
+type Collector struct {
+	logger zerolog.Logger
+
+	mu      sync.Mutex
+	clients *lru.Cache[string, *Fingerprint] // By MAC or IP address
+	macs    *lru.Cache[string, string]       // IP address -> MAC address
+}
+
+// NewCollector creates an empty collector
+func NewCollector(logger zerolog.Logger) *Collector {
+	// lru.New only fails for a non-positive size
+	clients, _ := lru.New[string, *Fingerprint](cacheSize)
+	macs, _ := lru.New[string, string](cacheSize)
+	return &Collector{
+		logger:  logger.With().Str("component", "fingerprint").Logger(),
+		clients: clients,
+		macs:    macs,
+	}
+}
+
+// ObserveDHCP records the parameter request list (option 55) and vendor
+// class (option 60) of a client's DHCP request. A nil collector does
+// nothing.
+func (c *Collector) ObserveDHCP(ip net.IP, mac net.HardwareAddr, parameters []uint8, vendorClass string) {
+	if c == nil || len(mac) == 0 {
+		return
+	}
+	codes := make([]string, 0, len(parameters))
+	for _, code := range parameters {
+		codes = append(codes, strconv.Itoa(int(code)))
+	}
+
+	c.update(ip, mac, func(f *Fingerprint) {
+		f.DHCPParameters = strings.Join(codes, ",")
+		f.VendorClass = vendorClass
+	})
+}
+
+// ObserveUserAgent records the User-Agent of a client's HTTP request. A nil
+// collector does nothing.
+func (c *Collector) ObserveUserAgent(ip net.IP, mac net.HardwareAddr, userAgent string) {
+	if c == nil || userAgent == "" || len(userAgent) > maxUserAgentLength {
+		return
+	}
+	c.update(ip, mac, func(f *Fingerprint) {
+		if i := slices.Index(f.UserAgents, userAgent); i >= 0 {
+			f.UserAgents = slices.Delete(f.UserAgents, i, i+1)
+		}
+		f.UserAgents = slices.Insert(f.UserAgents, 0, userAgent)
+		if len(f.UserAgents) > maxUserAgents {
+			f.UserAgents = f.UserAgents[:maxUserAgents]
+		}
+	})
+}
+
+// update applies change to a client's fingerprint and guesses its device
+// type again
+func (c *Collector) update(ip net.IP, mac net.HardwareAddr, change func(f *Fingerprint)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.key(ip, mac)
+	if key == "" {
+		return
+	}
+	f, ok := c.clients.Get(key)
+	if !ok {
+		f = &Fingerprint{}
+		// A client first seen by IP address keeps what it revealed once
+		// its MAC address is known
+		if len(mac) > 0 && ip != nil {
+			if byIP, ok := c.clients.Peek(ip.String()); ok {
+				*f = *byIP
+				c.clients.Remove(ip.String())
+			}
+		}
+		c.clients.Add(key, f)
+	}
+	if len(mac) > 0 {
+		f.MAC = mac.String()
+	}
+	if ip != nil {
+		f.IP = ip.String()
+	}
+	f.Seen = time.Now()
+
+	change(f)
+	if deviceType := Classify(f); deviceType != f.DeviceType {
+		c.logger.Debug().
+			Str("client", key).
+			Str("device_type", deviceType).
+			Msg("Guessed device type")
+		f.DeviceType = deviceType
+	}
+}
+
+// key finds the key of a client: its MAC address, the MAC address last seen
+// with its IP address, or its IP address. The caller holds mu.
+func (c *Collector) key(ip net.IP, mac net.HardwareAddr) string {
+	if len(mac) > 0 {
+		if ip != nil {
+			c.macs.Add(ip.String(), mac.String())
+		}
+		return mac.String()
+	}
+	if ip == nil {
+		return ""
+	}
+	if known, ok := c.macs.Get(ip.String()); ok {
+		return known
+	}
+	return ip.String()
+}
+
+// Lookup returns the fingerprint of the client at ip (and mac, if known)
+func (c *Collector) Lookup(ip net.IP, mac net.HardwareAddr) (Fingerprint, bool) {
+	if c == nil {
+		return Fingerprint{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.clients.Get(c.lookupKey(ip, mac))
+	if !ok {
+		return Fingerprint{}, false
+	}
+	copied := *f
+	copied.UserAgents = slices.Clone(f.UserAgents)
+	return copied, true
+}
+
+// lookupKey is key without learning the IP address of mac. The caller
+// holds mu.
+func (c *Collector) lookupKey(ip net.IP, mac net.HardwareAddr) string {
+	if len(mac) > 0 {
+		return mac.String()
+	}
+	if ip == nil {
+		return ""
+	}
+	if known, ok := c.macs.Peek(ip.String()); ok {
+		return known
+	}
+	return ip.String()
+}
+
+// DeviceType returns the device type guessed for the client at ip (and
+// mac, if known), or "" if unknown. A nil collector knows no clients.
+func (c *Collector) DeviceType(ip net.IP, mac net.HardwareAddr) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.clients.Peek(c.lookupKey(ip, mac)); ok {
+		return f.DeviceType
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package fingerprint
+
+import (
+	"net"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		f    Fingerprint
+		want string
+	}{
+		{"smart TV browser", Fingerprint{UserAgents: []string{"Mozilla/5.0 (SMART-TV; LINUX; Tizen 6.0) AppleWebKit/537.36"}}, TV},
+		{"console", Fingerprint{UserAgents: []string{"Mozilla/5.0 (PlayStation; PlayStation 5/2.26) AppleWebKit/605.1.15"}}, Console},
+		{"iPad", Fingerprint{UserAgents: []string{"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15"}}, Tablet},
+		{"Android phone", Fingerprint{UserAgents: []string{"Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36"}}, Phone},
+		{"Android tablet", Fingerprint{UserAgents: []string{"Mozilla/5.0 (Linux; Android 14; SM-X710) Safari/537.36"}}, Tablet},
+		{"laptop", Fingerprint{UserAgents: []string{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0"}}, Computer},
+		{"app without a platform", Fingerprint{UserAgents: []string{"okhttp/4.12.0", "Mozilla/5.0 (Linux; Android 13; Pixel 7) Mobile"}}, Phone},
+		{"most specific wins", Fingerprint{UserAgents: []string{"Mozilla/5.0 (X11; Linux x86_64)", "Roku/DVP-12.5"}}, TV},
+		{"vendor class", Fingerprint{VendorClass: "android-dhcp-14"}, Phone},
+		{"parameter list", Fingerprint{DHCPParameters: "1,121,3,6,15,108,114,119,252,95,44,46"}, Computer},
+		{"User-Agent over DHCP", Fingerprint{VendorClass: "MSFT 5.0", UserAgents: []string{"Mozilla/5.0 (Xbox One; Windows NT 10.0)"}}, Console},
+		{"unknown", Fingerprint{DHCPParameters: "1,3,6", UserAgents: []string{"curl/8.4.0"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := Classify(&tt.f); got != tt.want {
+			t.Errorf("%s: Classify() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c := NewCollector(zerolog.Nop())
+	ip := net.ParseIP("192.168.5.60")
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:60")
+
+	// A proxy request before the MAC address is known
+	c.ObserveUserAgent(ip, nil, "Mozilla/5.0 (Web0S; Linux/SmartTV) AppleWebKit/537.36")
+	if got := c.DeviceType(ip, nil); got != TV {
+		t.Errorf("Expected a TV by IP address, got %q", got)
+	}
+
+	// The DHCP lease links the IP address to the MAC address
+	c.ObserveDHCP(ip, mac, []uint8{1, 3, 6, 12, 15, 28}, "udhcp 1.30.1")
+	f, ok := c.Lookup(nil, mac)
+	if !ok || f.DeviceType != TV || f.DHCPParameters != "1,3,6,12,15,28" || f.VendorClass != "udhcp 1.30.1" {
+		t.Errorf("Expected the fingerprint kept under the MAC address, got %+v", f)
+	}
+	if got := c.DeviceType(ip, nil); got != TV {
+		t.Errorf("Expected later requests by IP address to find the MAC's fingerprint, got %q", got)
+	}
+
+	// User-Agents are kept newest first, without repeats
+	for _, ua := range []string{"a", "b", "a", "c", "d", "e", "f"} {
+		c.ObserveUserAgent(ip, nil, ua)
+	}
+	f, _ = c.Lookup(ip, nil)
+	if len(f.UserAgents) != maxUserAgents || f.UserAgents[0] != "f" || f.UserAgents[4] != "a" {
+		t.Errorf("Unexpected User-Agents: %v", f.UserAgents)
+	}
+
+	var none *Collector
+	none.ObserveUserAgent(ip, nil, "Roku")
+	if got := none.DeviceType(ip, mac); got != "" {
+		t.Errorf("Expected a nil collector to know no clients, got %q", got)
+	}
+}
@@ -0,0 +1,119 @@
+package identity
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Heartbeat is the JSON body posted by a login agent running on a shared computer
+type Heartbeat struct {
+	User   string `json:"user"`
+	IP     string `json:"ip,omitempty"`  // Defaults to the request's source address
+	MAC    string `json:"mac,omitempty"` // Optional
+	Logout bool   `json:"logout,omitempty"`
+}
+
+// AgentServer accepts heartbeats from login agents. Agents post the current
+// user periodically (more often than the registry TTL) and on logout.
+type AgentServer struct {
+	server   *http.Server
+	token    string
+	registry *Registry
+	logger   zerolog.Logger
+}
+
+// NewAgentServer creates a new agent heartbeat server. Requests must carry
+// "Authorization: Bearer <token>".
+func NewAgentServer(addr, token string, registry *Registry, logger zerolog.Logger) *AgentServer {
+	s := &AgentServer{
+		token:    token,
+		registry: registry,
+		logger:   logger.With().Str("component", "identity-agent").Logger(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heartbeat", s.handleHeartbeat)
+
+	s.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	return s
+}
+
+// Start starts the agent heartbeat server
+func (s *AgentServer) Start() error {
+	s.logger.Info().Str("addr", s.server.Addr).Msg("Starting identity agent server")
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error().Err(err).Msg("Identity agent server error")
+		}
+	}()
+	return nil
+}
+
+// Stop stops the agent heartbeat server
+func (s *AgentServer) Stop() error {
+	s.logger.Info().Msg("Stopping identity agent server")
+	return s.server.Close()
+}
+
+// handleHeartbeat records or clears the user for the posting host
+func (s *AgentServer) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var hb Heartbeat
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&hb); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	if hb.IP == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err == nil {
+			hb.IP = host
+		}
+	}
+	if hb.IP != "" && net.ParseIP(hb.IP) == nil {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+	if hb.MAC != "" {
+		mac, err := net.ParseMAC(hb.MAC)
+		if err != nil {
+			http.Error(w, "invalid mac", http.StatusBadRequest)
+			return
+		}
+		hb.MAC = mac.String()
+	}
+
+	if hb.Logout {
+		s.registry.Logout(hb.IP, hb.MAC)
+		s.logger.Debug().Str("user", hb.User).Str("ip", hb.IP).Msg("User logged out")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if hb.User == "" {
+		http.Error(w, "user is required", http.StatusBadRequest)
+		return
+	}
+
+	s.registry.Login(hb.User, SourceAgent, hb.IP, hb.MAC)
+	s.logger.Debug().Str("user", hb.User).Str("ip", hb.IP).Str("mac", hb.MAC).Msg("User heartbeat")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,161 @@
+package identity
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRegistry_LookupPrefersMAC(t *testing.T) {
+	r := NewRegistry(time.Minute)
+	r.Login("alice", SourceAgent, "192.168.1.10", "")
+	r.Login("bob", SourceRADIUS, "", "AA:BB:CC:DD:EE:FF")
+
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	u, ok := r.Lookup(net.ParseIP("192.168.1.10"), mac)
+	if !ok || u.Name != "bob" {
+		t.Errorf("Expected MAC match bob, got %+v", u)
+	}
+
+	u, ok = r.Lookup(net.ParseIP("192.168.1.10"), nil)
+	if !ok || u.Name != "alice" {
+		t.Errorf("Expected IP match alice, got %+v", u)
+	}
+
+	r.Logout("192.168.1.10", "")
+	if _, ok := r.Lookup(net.ParseIP("192.168.1.10"), nil); ok {
+		t.Error("Expected no user after logout")
+	}
+}
+
+func TestRegistry_Expiry(t *testing.T) {
+	r := NewRegistry(-time.Second)
+	r.Login("alice", SourceAgent, "192.168.1.10", "")
+
+	if _, ok := r.Lookup(net.ParseIP("192.168.1.10"), nil); ok {
+		t.Error("Expected expired user to be ignored")
+	}
+	if users := r.List(); len(users) != 0 {
+		t.Errorf("Expected no listed users, got %+v", users)
+	}
+}
+
+// buildAccountingRequest builds a signed RADIUS Accounting-Request
+func buildAccountingRequest(secret string, status uint32, user, mac string, ip net.IP) []byte {
+	var attrs []byte
+	addAttr := func(typ byte, val []byte) {
+		attrs = append(attrs, typ, byte(len(val)+2))
+		attrs = append(attrs, val...)
+	}
+	statusBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(statusBytes, status)
+	addAttr(radiusAttrAcctStatusType, statusBytes)
+	addAttr(radiusAttrUserName, []byte(user))
+	addAttr(radiusAttrCallingStationID, []byte(mac))
+	addAttr(radiusAttrFramedIPAddress, ip.To4())
+
+	pkt := make([]byte, radiusHeaderLen, radiusHeaderLen+len(attrs))
+	pkt[0] = radiusCodeAccountingRequest
+	pkt[1] = 42
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(radiusHeaderLen+len(attrs)))
+	pkt = append(pkt, attrs...)
+
+	h := md5.New()
+	h.Write(pkt[:4])
+	h.Write(make([]byte, 16))
+	h.Write(attrs)
+	h.Write([]byte(secret))
+	copy(pkt[4:radiusHeaderLen], h.Sum(nil))
+	return pkt
+}
+
+func TestRadiusServer_Accounting(t *testing.T) {
+	r := NewRegistry(time.Minute)
+	s := NewRadiusServer("127.0.0.1:0", "s3cret", r, zerolog.Nop())
+	ip := net.ParseIP("192.168.1.50")
+
+	resp, err := s.handlePacket(buildAccountingRequest("s3cret", radiusAcctStart, "alice", "AA-BB-CC-DD-EE-FF", ip))
+	if err != nil {
+		t.Fatalf("handlePacket failed: %v", err)
+	}
+	if resp[0] != radiusCodeAccountingResponse || resp[1] != 42 {
+		t.Errorf("Unexpected response header: %v", resp[:4])
+	}
+
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	if u, ok := r.Lookup(nil, mac); !ok || u.Name != "alice" || u.Source != SourceRADIUS {
+		t.Errorf("Expected alice via MAC, got %+v", u)
+	}
+	if u, ok := r.Lookup(ip, nil); !ok || u.Name != "alice" {
+		t.Errorf("Expected alice via IP, got %+v", u)
+	}
+
+	if _, err := s.handlePacket(buildAccountingRequest("s3cret", radiusAcctStop, "alice", "AA-BB-CC-DD-EE-FF", ip)); err != nil {
+		t.Fatalf("handlePacket failed: %v", err)
+	}
+	if _, ok := r.Lookup(ip, mac); ok {
+		t.Error("Expected user to be removed after Stop")
+	}
+}
+
+func TestRadiusServer_RejectsBadSecret(t *testing.T) {
+	r := NewRegistry(time.Minute)
+	s := NewRadiusServer("127.0.0.1:0", "s3cret", r, zerolog.Nop())
+
+	pkt := buildAccountingRequest("wrong", radiusAcctStart, "mallory", "", net.ParseIP("192.168.1.50"))
+	if _, err := s.handlePacket(pkt); err == nil {
+		t.Fatal("Expected authenticator error")
+	}
+	if len(r.List()) != 0 {
+		t.Error("Expected registry to be unchanged")
+	}
+}
+
+func TestAgentServer_Heartbeat(t *testing.T) {
+	r := NewRegistry(time.Minute)
+	s := NewAgentServer("127.0.0.1:0", "token", r, zerolog.Nop())
+
+	tests := []struct {
+		name       string
+		auth       string
+		body       string
+		wantStatus int
+		wantUser   string
+	}{
+		{"missing token", "", `{"user":"alice"}`, http.StatusUnauthorized, ""},
+		{"wrong token", "Bearer nope", `{"user":"alice"}`, http.StatusUnauthorized, ""},
+		{"missing user", "Bearer token", `{}`, http.StatusBadRequest, ""},
+		{"login from source address", "Bearer token", `{"user":"alice"}`, http.StatusNoContent, "alice"},
+		{"logout", "Bearer token", `{"user":"alice","logout":true}`, http.StatusNoContent, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/heartbeat", strings.NewReader(tt.body))
+			req.RemoteAddr = "192.168.1.20:5555"
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			u, ok := r.Lookup(net.ParseIP("192.168.1.20"), nil)
+			if tt.wantUser == "" && ok {
+				t.Errorf("Expected no user, got %+v", u)
+			}
+			if tt.wantUser != "" && (!ok || u.Name != tt.wantUser) {
+				t.Errorf("Expected user %s, got %+v", tt.wantUser, u)
+			}
+		})
+	}
+}
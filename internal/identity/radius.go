@@ -0,0 +1,206 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// RADIUS packet codes and attributes used by the accounting listener (RFC 2866)
+const (
+	radiusCodeAccountingRequest  = 4
+	radiusCodeAccountingResponse = 5
+
+	radiusAttrUserName         = 1
+	radiusAttrFramedIPAddress  = 8
+	radiusAttrCallingStationID = 31
+	radiusAttrAcctStatusType   = 40
+
+	radiusAcctStart         = 1
+	radiusAcctStop          = 2
+	radiusAcctInterimUpdate = 3
+
+	radiusHeaderLen = 20
+)
+
+// RadiusServer listens for RADIUS accounting packets (e.g. from an 802.1X
+// capable access point or switch) and records which user is logged in on
+// which client address.
+type RadiusServer struct {
+	addr     string
+	secret   []byte
+	registry *Registry
+	logger   zerolog.Logger
+
+	conn net.PacketConn
+}
+
+// NewRadiusServer creates a new RADIUS accounting listener
+func NewRadiusServer(addr, secret string, registry *Registry, logger zerolog.Logger) *RadiusServer {
+	return &RadiusServer{
+		addr:     addr,
+		secret:   []byte(secret),
+		registry: registry,
+		logger:   logger.With().Str("component", "radius").Logger(),
+	}
+}
+
+// Start starts the RADIUS accounting listener
+func (s *RadiusServer) Start() error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	s.conn = conn
+
+	s.logger.Info().Str("addr", s.addr).Msg("Starting RADIUS accounting listener")
+	go s.serve()
+	return nil
+}
+
+// Stop stops the RADIUS accounting listener
+func (s *RadiusServer) Stop() error {
+	s.logger.Info().Msg("Stopping RADIUS accounting listener")
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// serve reads and handles packets until the connection is closed
+func (s *RadiusServer) serve() {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Error().Err(err).Msg("RADIUS read error")
+			continue
+		}
+
+		resp, err := s.handlePacket(buf[:n])
+		if err != nil {
+			s.logger.Warn().Err(err).Str("from", addr.String()).Msg("Dropping RADIUS packet")
+			continue
+		}
+		if _, err := s.conn.WriteTo(resp, addr); err != nil {
+			s.logger.Error().Err(err).Str("to", addr.String()).Msg("Failed to send RADIUS response")
+		}
+	}
+}
+
+// handlePacket verifies an Accounting-Request, updates the registry and
+// returns the Accounting-Response to send back
+func (s *RadiusServer) handlePacket(pkt []byte) ([]byte, error) {
+	if len(pkt) < radiusHeaderLen {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(pkt))
+	}
+	if pkt[0] != radiusCodeAccountingRequest {
+		return nil, fmt.Errorf("unexpected code %d", pkt[0])
+	}
+	length := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if length < radiusHeaderLen || length > len(pkt) {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+	pkt = pkt[:length]
+
+	// Request Authenticator = MD5(Code+ID+Length+16 zero octets+Attributes+Secret)
+	h := md5.New()
+	h.Write(pkt[:4])
+	h.Write(make([]byte, 16))
+	h.Write(pkt[radiusHeaderLen:])
+	h.Write(s.secret)
+	if !bytes.Equal(h.Sum(nil), pkt[4:radiusHeaderLen]) {
+		return nil, errors.New("invalid request authenticator (shared secret mismatch?)")
+	}
+
+	attrs, err := parseRadiusAttributes(pkt[radiusHeaderLen:])
+	if err != nil {
+		return nil, err
+	}
+
+	user := string(attrs[radiusAttrUserName])
+	mac := normalizeCallingStationID(string(attrs[radiusAttrCallingStationID]))
+	var ip string
+	if v := attrs[radiusAttrFramedIPAddress]; len(v) == 4 {
+		ip = net.IP(v).String()
+	}
+
+	var status uint32
+	if v := attrs[radiusAttrAcctStatusType]; len(v) == 4 {
+		status = binary.BigEndian.Uint32(v)
+	}
+
+	switch status {
+	case radiusAcctStart, radiusAcctInterimUpdate:
+		if user != "" && (ip != "" || mac != "") {
+			s.registry.Login(user, SourceRADIUS, ip, mac)
+			s.logger.Debug().Str("user", user).Str("ip", ip).Str("mac", mac).Msg("User logged in")
+		}
+	case radiusAcctStop:
+		s.registry.Logout(ip, mac)
+		s.logger.Debug().Str("user", user).Str("ip", ip).Str("mac", mac).Msg("User logged out")
+	}
+
+	return s.accountingResponse(pkt), nil
+}
+
+// accountingResponse builds an Accounting-Response with no attributes.
+// Response Authenticator = MD5(Code+ID+Length+RequestAuth+Attributes+Secret)
+func (s *RadiusServer) accountingResponse(req []byte) []byte {
+	resp := make([]byte, radiusHeaderLen)
+	resp[0] = radiusCodeAccountingResponse
+	resp[1] = req[1]
+	binary.BigEndian.PutUint16(resp[2:4], radiusHeaderLen)
+
+	h := md5.New()
+	h.Write(resp[:4])
+	h.Write(req[4:radiusHeaderLen])
+	h.Write(s.secret)
+	copy(resp[4:], h.Sum(nil))
+
+	return resp
+}
+
+// parseRadiusAttributes decodes type-length-value attributes. Only the
+// first occurrence of each attribute is kept.
+func parseRadiusAttributes(b []byte) (map[byte][]byte, error) {
+	attrs := make(map[byte][]byte)
+	for len(b) > 0 {
+		if len(b) < 2 || int(b[1]) < 2 || int(b[1]) > len(b) {
+			return nil, errors.New("malformed attribute")
+		}
+		typ, l := b[0], int(b[1])
+		if _, seen := attrs[typ]; !seen {
+			attrs[typ] = b[2:l]
+		}
+		b = b[l:]
+	}
+	return attrs, nil
+}
+
+// normalizeCallingStationID converts the MAC formats used by network equipment
+// (AA-BB-CC-DD-EE-FF, aabb.ccdd.eeff, AABBCCDDEEFF) to aa:bb:cc:dd:ee:ff
+func normalizeCallingStationID(s string) string {
+	hex := strings.NewReplacer("-", "", ":", "", ".", "").Replace(strings.ToLower(s))
+	if len(hex) != 12 {
+		return ""
+	}
+	parts := make([]string, 6)
+	for i := range parts {
+		parts[i] = hex[i*2 : i*2+2]
+	}
+	mac, err := net.ParseMAC(strings.Join(parts, ":"))
+	if err != nil {
+		return ""
+	}
+	return mac.String()
+}
@@ -0,0 +1,149 @@
+package identity
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of user identification
+const (
+	SourceRADIUS = "radius"
+	SourceAgent  = "agent"
+	SourceProxy  = "proxy" // Forward proxy authentication
+)
+
+// User is a person identified as logged in at a network address
+type User struct {
+	Name      string    // User name reported by the authenticator
+	Source    string    // "radius", "agent" or "proxy"
+	IP        string    // Client IP address (may be empty)
+	MAC       string    // Client MAC address, lower case (may be empty)
+	ExpiresAt time.Time // Mapping is ignored after this time unless refreshed
+}
+
+// Registry maps client addresses to the users currently logged in on them.
+// Authenticator sources (RADIUS accounting, agent heartbeats) feed it; the
+// policy engine queries it when building facts.
+type Registry struct {
+	ttl time.Duration
+
+	mu    sync.RWMutex
+	byIP  map[string]User
+	byMAC map[string]User
+}
+
+// NewRegistry creates a registry whose entries expire after ttl unless refreshed
+func NewRegistry(ttl time.Duration) *Registry {
+	return &Registry{
+		ttl:   ttl,
+		byIP:  make(map[string]User),
+		byMAC: make(map[string]User),
+	}
+}
+
+// Login records that a user is logged in at the given IP and/or MAC address
+func (r *Registry) Login(name, source, ip, mac string) {
+	u := User{
+		Name:      name,
+		Source:    source,
+		IP:        ip,
+		MAC:       strings.ToLower(mac),
+		ExpiresAt: time.Now().Add(r.ttl),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Expired logins are dropped here rather than by a background sweeper;
+	// the tables are only as large as the number of clients on the network
+	r.pruneLocked(time.Now())
+
+	if u.IP != "" {
+		r.byIP[u.IP] = u
+	}
+	if u.MAC != "" {
+		r.byMAC[u.MAC] = u
+	}
+}
+
+// Logout removes the user mappings for the given IP and/or MAC address
+func (r *Registry) Logout(ip, mac string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ip != "" {
+		delete(r.byIP, ip)
+	}
+	if mac != "" {
+		delete(r.byMAC, strings.ToLower(mac))
+	}
+}
+
+// Lookup returns the user logged in at the given address. MAC matches take
+// priority over IP matches, mirroring device identification.
+func (r *Registry) Lookup(ip net.IP, mac net.HardwareAddr) (*User, bool) {
+	now := time.Now()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if mac != nil {
+		if u, ok := r.byMAC[mac.String()]; ok && now.Before(u.ExpiresAt) {
+			return &u, true
+		}
+	}
+	if ip != nil {
+		if u, ok := r.byIP[ip.String()]; ok && now.Before(u.ExpiresAt) {
+			return &u, true
+		}
+	}
+
+	return nil, false
+}
+
+// LookupUser implements policy.UserResolver
+func (r *Registry) LookupUser(ip net.IP, mac net.HardwareAddr) (name, source string, ok bool) {
+	u, ok := r.Lookup(ip, mac)
+	if !ok {
+		return "", "", false
+	}
+	return u.Name, u.Source, true
+}
+
+// List returns all unexpired user mappings
+func (r *Registry) List() []User {
+	now := time.Now()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]User, 0, len(r.byIP)+len(r.byMAC))
+	for _, u := range r.byIP {
+		if now.Before(u.ExpiresAt) {
+			users = append(users, u)
+		}
+	}
+	for _, u := range r.byMAC {
+		// Skip entries already listed via their IP
+		if now.Before(u.ExpiresAt) && (u.IP == "" || r.byIP[u.IP] != u) {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// pruneLocked removes expired entries. Callers must hold mu for writing.
+func (r *Registry) pruneLocked(now time.Time) {
+	for ip, u := range r.byIP {
+		if !now.Before(u.ExpiresAt) {
+			delete(r.byIP, ip)
+		}
+	}
+	for mac, u := range r.byMAC {
+		if !now.Before(u.ExpiresAt) {
+			delete(r.byMAC, mac)
+		}
+	}
+}
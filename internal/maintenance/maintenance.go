@@ -0,0 +1,115 @@
+package maintenance
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Status describes the current maintenance window
+type Status struct {
+	Active  bool       `json:"active"`
+	Message string     `json:"message,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// Mode is the global maintenance switch. While it is on, the proxy serves a
+// maintenance page for all HTTP traffic and DNS follows the configured
+// maintenance action. Every window has an expiry, so a forgotten switch
+// can't take the network down indefinitely.
+type Mode struct {
+	defaultMessage  string
+	defaultDuration time.Duration
+	logger          zerolog.Logger
+
+	mu      sync.RWMutex
+	active  bool
+	message string
+	until   time.Time
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates a maintenance switch, initially off. Windows opened without a
+// message or duration use the defaults given here.
+func New(defaultMessage string, defaultDuration time.Duration, logger zerolog.Logger) *Mode {
+	return &Mode{
+		defaultMessage:  defaultMessage,
+		defaultDuration: defaultDuration,
+		logger:          logger.With().Str("component", "maintenance").Logger(),
+		now:             time.Now,
+	}
+}
+
+// Enable starts (or extends) a maintenance window lasting duration
+func (m *Mode) Enable(message string, duration time.Duration) Status {
+	if message == "" {
+		message = m.defaultMessage
+	}
+	if duration <= 0 {
+		duration = m.defaultDuration
+	}
+
+	m.mu.Lock()
+	m.active = true
+	m.message = message
+	m.until = m.now().Add(duration)
+	m.mu.Unlock()
+
+	m.logger.Warn().
+		Str("message", message).
+		Dur("duration", duration).
+		Msg("Maintenance mode enabled")
+
+	return m.Status()
+}
+
+// Disable ends the maintenance window
+func (m *Mode) Disable() Status {
+	m.mu.Lock()
+	wasActive := m.active
+	m.active = false
+	m.mu.Unlock()
+
+	if wasActive {
+		m.logger.Warn().Msg("Maintenance mode disabled")
+	}
+
+	return m.Status()
+}
+
+// Active reports whether a maintenance window is in effect
+func (m *Mode) Active() bool {
+	return m.Status().Active
+}
+
+// Status returns the current maintenance window. Expired windows are
+// reported (and cleared) as inactive.
+func (m *Mode) Status() Status {
+	m.mu.RLock()
+	active, message, until := m.active, m.message, m.until
+	m.mu.RUnlock()
+
+	if !active {
+		return Status{}
+	}
+
+	if !m.now().Before(until) {
+		m.mu.Lock()
+		// Only clear the window that expired, not one enabled since
+		expired := m.active && m.until.Equal(until)
+		if expired {
+			m.active = false
+		}
+		m.mu.Unlock()
+
+		if expired {
+			m.logger.Warn().Msg("Maintenance mode expired")
+		}
+		return Status{}
+	}
+
+	return Status{Active: true, Message: message, Until: &until}
+}
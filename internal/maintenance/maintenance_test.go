@@ -0,0 +1,59 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestMode_EnableDisable(t *testing.T) {
+	m := New("Back soon", time.Hour, zerolog.Nop())
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	if m.Active() {
+		t.Fatal("maintenance should start off")
+	}
+
+	status := m.Enable("", 0)
+	if !status.Active || status.Message != "Back soon" {
+		t.Fatalf("Enable() = %+v, want active with the default message", status)
+	}
+	if want := now.Add(time.Hour); !status.Until.Equal(want) {
+		t.Errorf("until = %v, want %v", status.Until, want)
+	}
+
+	status = m.Enable("Upgrading the router", 10*time.Minute)
+	if status.Message != "Upgrading the router" || !status.Until.Equal(now.Add(10*time.Minute)) {
+		t.Errorf("Enable() = %+v, want the given message and duration", status)
+	}
+
+	if status := m.Disable(); status.Active {
+		t.Errorf("Disable() = %+v, want inactive", status)
+	}
+	if m.Active() {
+		t.Error("maintenance still active after Disable")
+	}
+}
+
+func TestMode_Expiry(t *testing.T) {
+	m := New("Back soon", time.Hour, zerolog.Nop())
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	m.Enable("", 30*time.Minute)
+
+	now = now.Add(29 * time.Minute)
+	if !m.Active() {
+		t.Fatal("maintenance ended before its expiry")
+	}
+
+	now = now.Add(time.Minute)
+	if m.Active() {
+		t.Fatal("maintenance still active at its expiry")
+	}
+	if status := m.Status(); status.Until != nil {
+		t.Errorf("expired status = %+v, want empty", status)
+	}
+}
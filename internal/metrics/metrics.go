@@ -3,6 +3,7 @@ package metrics
 import (
 	"net"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -28,6 +29,61 @@ var (
 		[]string{"device", "action"},
 	)
 
+	RequestPhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kproxy_request_phase_duration_seconds",
+			Help:    "Time spent in each phase of a proxied request",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"phase"}, // "policy", "dns", "tls_mint", "connect" or "transfer"
+	)
+
+	PolicyEvalP99 = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kproxy_policy_eval_p99_seconds",
+			Help: "99th percentile proxy policy evaluation time over the last check interval",
+		},
+	)
+
+	CanaryDivergences = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_policy_canary_divergences_total",
+			Help: "Decisions of the candidate policies that differ from the active policies, by kind",
+		},
+		[]string{"kind"}, // "dns" or "proxy"
+	)
+
+	DecisionLogDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_policy_decision_log_dropped_total",
+			Help: "Sampled policy decisions not recorded by the decision log, by reason",
+		},
+		[]string{"reason"}, // "queue_full", "too_large" or "store_error"
+	)
+
+	ExtAuthzChecks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_ext_authz_checks_total",
+			Help: "External authorization checks answered for other gateways, by action",
+		},
+		[]string{"action"},
+	)
+
+	DevicesDiscovered = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_devices_discovered_total",
+			Help: "Unknown clients quarantined as new devices, by how they were first seen",
+		},
+		[]string{"source"}, // "dhcp", "dns" or "proxy"
+	)
+
+	QUICRejected = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kproxy_quic_rejected_total",
+			Help: "QUIC connection attempts answered with version negotiation so the client falls back to TCP",
+		},
+	)
+
 	// DNS metrics
 	DNSQueriesTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -54,6 +110,27 @@ var (
 		[]string{"upstream"},
 	)
 
+	DNSCacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kproxy_dns_cache_hits_total",
+			Help: "DNS response cache hits",
+		},
+	)
+
+	DNSCacheMisses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kproxy_dns_cache_misses_total",
+			Help: "DNS response cache misses",
+		},
+	)
+
+	DNSRateLimited = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kproxy_dns_rate_limited_total",
+			Help: "DNS queries refused because the client exceeded its rate limit",
+		},
+	)
+
 	// TLS/Certificate metrics
 	CertificatesGenerated = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -76,6 +153,21 @@ var (
 		},
 	)
 
+	CertificateCacheEvictions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kproxy_certificate_cache_evictions_total",
+			Help: "Certificates evicted from a full cache (raise tls.cert_cache_size if this keeps growing)",
+		},
+	)
+
+	CertificateGenerationDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "kproxy_certificate_generation_duration_seconds",
+			Help:    "Time to mint a leaf certificate during a TLS handshake",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+		},
+	)
+
 	// Policy metrics
 	BlockedRequests = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -85,6 +177,110 @@ var (
 		[]string{"device", "reason"},
 	)
 
+	WarnedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_warned_requests_total",
+			Help: "Total requests allowed in warn mode that would otherwise be blocked",
+		},
+		[]string{"device", "reason"},
+	)
+
+	ThrottledRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_throttled_requests_total",
+			Help: "Total requests slowed down as a usage limit nears exhaustion",
+		},
+		[]string{"category"},
+	)
+
+	QuotaTerminations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_quota_terminations_total",
+			Help: "Transfers cut off because policy blocked them while in flight, e.g. a usage limit ran out",
+		},
+		[]string{"category"},
+	)
+
+	TimerInjections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_timer_injections_total",
+			Help: "Pages given a banner with the time left on a usage limit",
+		},
+		[]string{"category"},
+	)
+
+	NoticesShown = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_notices_shown_total",
+			Help: "Pages given a banner warning that bedtime or a usage limit is near",
+		},
+		[]string{"kind"},
+	)
+
+	AccessRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_access_requests_total",
+			Help: "Access requests made from the block page",
+		},
+		[]string{"profile"},
+	)
+
+	// Connectivity probe metrics
+	ProbeUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kproxy_probe_up",
+			Help: "Whether the last connectivity probe of a target succeeded (1) or failed (0)",
+		},
+		[]string{"target"},
+	)
+
+	ProbeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kproxy_probe_duration_seconds",
+			Help:    "Connectivity probe time by phase (dns, http) for successful probes",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"target", "phase"},
+	)
+
+	ProbeFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_probe_failures_total",
+			Help: "Failed connectivity probes by target and the phase they failed in",
+		},
+		[]string{"target", "phase"},
+	)
+
+	// Storage health metrics
+	StorageUp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "kproxy_storage_up",
+			Help: "Whether the last storage health check reached the backend (1) or not (0)",
+		},
+	)
+
+	StorageProbeDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "kproxy_storage_probe_duration_seconds",
+			Help:    "Storage health check time",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+	)
+
+	StorageProbeFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kproxy_storage_probe_failures_total",
+			Help: "Storage health checks that didn't reach the backend",
+		},
+	)
+
+	StorageDegradedReads = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "kproxy_storage_degraded_reads_total",
+			Help: "Usage lookups answered from memory because storage was unreachable",
+		},
+	)
+
 	// Usage metrics
 	UsageMinutesConsumed = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -94,6 +290,31 @@ var (
 		[]string{"device", "category"},
 	)
 
+	// Bandwidth metrics
+	ProfileBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_profile_bytes_total",
+			Help: "Total proxied response bytes by profile",
+		},
+		[]string{"profile"},
+	)
+
+	ProfileBandwidthShare = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kproxy_profile_bandwidth_share_bytes",
+			Help: "Bandwidth currently allocated to each active profile in bytes per second",
+		},
+		[]string{"profile"},
+	)
+
+	BandwidthLimitedResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_bandwidth_limited_responses_total",
+			Help: "Responses shaped by a profile bandwidth limit, by profile and limit",
+		},
+		[]string{"profile", "limit"},
+	)
+
 	// Connection metrics
 	ActiveConnections = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -117,6 +338,49 @@ var (
 			Help: "Number of active DHCP leases",
 		},
 	)
+
+	DHCPConflictsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_dhcp_conflicts_total",
+			Help: "Total addresses held back after a conflict, by how it was found (probe, decline)",
+		},
+		[]string{"source"},
+	)
+
+	// Request mirroring metrics
+	MirroredRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_mirrored_requests_total",
+			Help: "Request records sent to the mirror sink, by result",
+		},
+		[]string{"result"}, // "sent", "dropped", "spooled" (kept on disk until the sink is back) or "failed"
+	)
+
+	// Store-and-forward metrics for sinks that were down
+	SpoolBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kproxy_spool_bytes",
+			Help: "Bytes of records spooled on disk for a sink that was down, by sink",
+		},
+		[]string{"sink"}, // "mirror", "search-alerts" or "analytics"
+	)
+
+	SpoolDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_spool_dropped_total",
+			Help: "Spooled records dropped because the spool was full, by sink",
+		},
+		[]string{"sink"},
+	)
+
+	// Search monitoring metrics
+	SearchConcerns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kproxy_search_concerns_total",
+			Help: "Search queries matching a concern list, by profile and list",
+		},
+		[]string{"profile", "list"},
+	)
 )
 
 func init() {
@@ -124,17 +388,50 @@ func init() {
 	prometheus.MustRegister(
 		RequestsTotal,
 		RequestDuration,
+		RequestPhaseDuration,
+		PolicyEvalP99,
+		CanaryDivergences,
+		DecisionLogDropped,
+		ExtAuthzChecks,
+		DevicesDiscovered,
+		QUICRejected,
 		DNSQueriesTotal,
 		DNSQueryDuration,
 		DNSUpstreamErrors,
+		DNSCacheHits,
+		DNSCacheMisses,
+		DNSRateLimited,
 		CertificatesGenerated,
 		CertificateCacheHits,
 		CertificateCacheMisses,
+		CertificateCacheEvictions,
+		CertificateGenerationDuration,
 		BlockedRequests,
+		WarnedRequests,
+		ThrottledRequests,
+		QuotaTerminations,
+		TimerInjections,
+		NoticesShown,
+		AccessRequests,
+		ProbeUp,
+		ProbeDuration,
+		ProbeFailures,
+		StorageUp,
+		StorageProbeDuration,
+		StorageProbeFailures,
+		StorageDegradedReads,
 		UsageMinutesConsumed,
+		ProfileBytesTotal,
+		ProfileBandwidthShare,
+		BandwidthLimitedResponses,
 		ActiveConnections,
 		DHCPRequestsTotal,
 		DHCPLeasesActive,
+		DHCPConflictsTotal,
+		MirroredRequests,
+		SpoolBytes,
+		SpoolDropped,
+		SearchConcerns,
 	)
 }
 
@@ -143,24 +440,31 @@ type Server struct {
 	server   *http.Server
 	logger   zerolog.Logger
 	listener net.Listener // Optional pre-created listener (for systemd socket activation)
+
+	// Optional status reported by /health in place of "OK"
+	healthStatus func() string
+
+	// Whether /readyz reports ready (set once all servers have started)
+	ready atomic.Bool
 }
 
 // NewServer creates a new metrics server
 func NewServer(addr string, logger zerolog.Logger) *Server {
+	s := &Server{
+		logger: logger.With().Str("component", "metrics").Logger(),
+	}
+
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK"))
-	})
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/livez", s.handleLive)
+	mux.HandleFunc("/readyz", s.handleReady)
 
-	return &Server{
-		server: &http.Server{
-			Addr:    addr,
-			Handler: mux,
-		},
-		logger: logger.With().Str("component", "metrics").Logger(),
+	s.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
 	}
+	return s
 }
 
 // SetListener sets a pre-created listener for systemd socket activation
@@ -168,6 +472,47 @@ func (s *Server) SetListener(ln net.Listener) {
 	s.listener = ln
 }
 
+// SetHealthStatus sets a function describing the server's status for /health.
+// The endpoint still answers 200 so that health checks keep passing.
+func (s *Server) SetHealthStatus(status func() string) {
+	s.healthStatus = status
+}
+
+// handleHealth reports that the server is up
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := "OK"
+	if s.healthStatus != nil {
+		status = s.healthStatus()
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(status))
+}
+
+// SetReady sets whether /readyz reports ready. KProxy is ready once every
+// server has started and stops being ready when it begins shutting down.
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+// handleLive reports that the process is running, for liveness probes. It
+// doesn't depend on readiness or maintenance, so those never restart KProxy.
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("OK"))
+}
+
+// handleReady reports whether KProxy should receive traffic, for readiness
+// probes: 503 while starting and while shutting down
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("NOT READY"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("READY"))
+}
+
 // Start starts the metrics server
 func (s *Server) Start() error {
 	s.logger.Info().Str("addr", s.server.Addr).Msg("Starting metrics server")
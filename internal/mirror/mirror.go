@@ -0,0 +1,389 @@
+// Package mirror sends records of allowed proxy traffic to a secondary HTTP
+// sink for offline analysis. Requests are sampled, redacted and batched;
+// mirroring never delays or fails the proxied request.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/goodtune/kproxy/internal/spool"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// batchSize is the most records sent in one POST
+	batchSize = 100
+
+	// flushInterval is how long records wait for a batch to fill
+	flushInterval = time.Second
+
+	// redacted replaces query parameter values
+	redacted = "REDACTED"
+)
+
+// alwaysRedacted are headers never mirrored, whatever the configuration
+var alwaysRedacted = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// Config configures mirroring
+type Config struct {
+	URL             string   // Sink receiving batches of records as a JSON array
+	SampleRate      float64  // Fraction of requests mirrored (0-1]
+	FullHosts       []string // Hosts (and subdomains) mirrored with headers and body
+	MaxBodyBytes    int      // Request body captured for full hosts
+	RedactClientIP  bool     // Replace client IPs with a pseudonym
+	RedactQuery     bool     // Drop query parameter values
+	RedactUserAgent bool     // Drop User-Agent
+	RedactHeaders   []string // Extra headers dropped from full records
+	QueueSize       int      // Records buffered for the sink
+	Timeout         time.Duration
+}
+
+// Entry is one mirrored request. Headers and Body are only set for full hosts.
+type Entry struct {
+	Time          time.Time           `json:"time"`
+	Client        string              `json:"client"` // IP, or a pseudonym when redacted
+	Profile       string              `json:"profile,omitempty"`
+	Method        string              `json:"method"`
+	Host          string              `json:"host"`
+	Path          string              `json:"path"`
+	Query         string              `json:"query,omitempty"`
+	Category      string              `json:"category,omitempty"`
+	Action        string              `json:"action"`
+	Status        int                 `json:"status"`
+	ResponseBytes int64               `json:"response_bytes"`
+	DurationMS    int64               `json:"duration_ms"`
+	UserAgent     string              `json:"user_agent,omitempty"`
+	Headers       map[string][]string `json:"headers,omitempty"`
+	Body          []byte              `json:"body,omitempty"` // Base64 in JSON
+	BodyTruncated bool                `json:"body_truncated,omitempty"`
+}
+
+// Mirror queues records of proxied requests and sends them to the sink in
+// the background. A nil *Mirror mirrors nothing.
+type Mirror struct {
+	cfg      Config
+	client   *http.Client
+	queue    chan Entry
+	key      []byte // Client pseudonym key, new for each process
+	logger   zerolog.Logger
+	stopChan chan struct{}
+	done     chan struct{}
+
+	// Batches the sink failed to take, kept on disk until it is back (optional)
+	spool *spool.Spool
+
+	// Replaced in tests
+	random func() float64
+	now    func() time.Time
+}
+
+// New creates a mirror sending to cfg.URL
+func New(cfg Config, logger zerolog.Logger) (*Mirror, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate pseudonym key: %w", err)
+	}
+
+	return &Mirror{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		queue:    make(chan Entry, cfg.QueueSize),
+		key:      key,
+		logger:   logger.With().Str("component", "mirror").Logger(),
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+		random:   mrand.Float64,
+		now:      time.Now,
+	}, nil
+}
+
+// Start begins sending records to the sink
+func (m *Mirror) Start() {
+	go m.run()
+
+	m.logger.Info().
+		Str("url", m.cfg.URL).
+		Float64("sample_rate", m.cfg.SampleRate).
+		Strs("full_hosts", m.cfg.FullHosts).
+		Msg("Mirroring allowed requests")
+}
+
+// Stop sends the queued records and stops
+func (m *Mirror) Stop() {
+	close(m.stopChan)
+	<-m.done
+}
+
+// SetSpool sets the spool keeping batches the sink fails to take. Call
+// before Start.
+func (m *Mirror) SetSpool(s *spool.Spool) {
+	m.spool = s
+}
+
+// Resend sends a spooled batch to the sink
+func (m *Mirror) Resend(record []byte) error {
+	return m.post(record)
+}
+
+// Request is a request being mirrored
+type Request struct {
+	m     *Mirror
+	entry Entry
+	start time.Time
+	body  *capture
+}
+
+// Begin starts mirroring an upstream request, returning nil if it isn't
+// sampled. For full hosts the request body is captured as it is sent, so
+// Begin must be called before the request is.
+func (m *Mirror) Begin(r *http.Request, clientIP net.IP, decision *policy.PolicyDecision) *Request {
+	if m == nil || m.random() >= m.cfg.SampleRate {
+		return nil
+	}
+
+	host := strings.ToLower(r.URL.Hostname())
+	req := &Request{
+		m:     m,
+		start: m.now(),
+		entry: Entry{
+			Client:   m.clientName(clientIP),
+			Profile:  decision.Profile,
+			Method:   r.Method,
+			Host:     host,
+			Path:     r.URL.Path,
+			Query:    m.query(r.URL.RawQuery),
+			Category: decision.Category,
+			Action:   string(decision.Action),
+		},
+	}
+	if !m.cfg.RedactUserAgent {
+		req.entry.UserAgent = r.UserAgent()
+	}
+
+	if m.fullHost(host) {
+		req.entry.Headers = m.headers(r.Header)
+		if m.cfg.MaxBodyBytes > 0 && r.Body != nil && r.Body != http.NoBody {
+			req.body = &capture{ReadCloser: r.Body, limit: m.cfg.MaxBodyBytes}
+			r.Body = req.body
+		}
+	}
+	return req
+}
+
+// Finish records the response and queues the record. Records are dropped
+// when the queue is full, so a slow sink never holds up traffic.
+func (r *Request) Finish(status int, responseBytes int64) {
+	if r == nil {
+		return
+	}
+
+	r.entry.Time = r.start
+	r.entry.Status = status
+	r.entry.ResponseBytes = responseBytes
+	r.entry.DurationMS = r.m.now().Sub(r.start).Milliseconds()
+	if r.body != nil {
+		r.entry.Body, r.entry.BodyTruncated = r.body.captured()
+	}
+
+	select {
+	case r.m.queue <- r.entry:
+	default:
+		metrics.MirroredRequests.WithLabelValues("dropped").Inc()
+	}
+}
+
+// run sends batches until stopped, then sends what is left
+func (m *Mirror) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, batchSize)
+	for {
+		select {
+		case entry := <-m.queue:
+			batch = append(batch, entry)
+			if len(batch) == batchSize {
+				batch = m.send(batch)
+			}
+		case <-ticker.C:
+			batch = m.send(batch)
+		case <-m.stopChan:
+			for {
+				select {
+				case entry := <-m.queue:
+					batch = append(batch, entry)
+					if len(batch) == batchSize {
+						batch = m.send(batch)
+					}
+				default:
+					m.send(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// send POSTs a batch to the sink, returning the emptied batch
+func (m *Mirror) send(batch []Entry) []Entry {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		m.logger.Error().Err(err).Int("records", len(batch)).Msg("Failed to encode mirrored requests")
+		metrics.MirroredRequests.WithLabelValues("failed").Add(float64(len(batch)))
+		return batch[:0]
+	}
+
+	result := "sent"
+	if err := m.post(body); err != nil {
+		result = "failed"
+		if m.spool != nil && m.spool.Add(body) == nil {
+			result = "spooled"
+		}
+		m.logger.Warn().Err(err).Int("records", len(batch)).Str("result", result).Msg("Failed to send mirrored requests")
+	}
+	metrics.MirroredRequests.WithLabelValues(result).Add(float64(len(batch)))
+	return batch[:0]
+}
+
+// post sends one JSON-encoded batch
+func (m *Mirror) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sink returned %s", resp.Status)
+	}
+	return nil
+}
+
+// clientName names the client, pseudonymized when client IPs are redacted. A
+// pseudonym is stable until restart, so one client's requests can still be
+// grouped, but can't be matched to an address.
+func (m *Mirror) clientName(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if !m.cfg.RedactClientIP {
+		return ip.String()
+	}
+	mac := hmac.New(sha256.New, m.key)
+	_, _ = mac.Write([]byte(ip.String()))
+	return "client-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// query returns the query string, with values dropped when redacting
+func (m *Mirror) query(raw string) string {
+	if raw == "" || !m.cfg.RedactQuery {
+		return raw
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return redacted
+	}
+	for key := range values {
+		values[key] = []string{redacted}
+	}
+	return values.Encode()
+}
+
+// headers copies request headers without credentials, redacted headers
+// and, when client IPs are redacted, headers carrying them
+func (m *Mirror) headers(h http.Header) map[string][]string {
+	out := h.Clone()
+	for _, name := range alwaysRedacted {
+		out.Del(name)
+	}
+	for _, name := range m.cfg.RedactHeaders {
+		out.Del(name)
+	}
+	if m.cfg.RedactClientIP {
+		out.Del("X-Forwarded-For")
+		out.Del("X-Real-Ip")
+		out.Del("Forwarded")
+	}
+	if m.cfg.RedactUserAgent {
+		out.Del("User-Agent")
+	}
+	return out
+}
+
+// fullHost reports whether host is one of the full hosts or a subdomain of one
+func (m *Mirror) fullHost(host string) bool {
+	for _, full := range m.cfg.FullHosts {
+		full = strings.ToLower(full)
+		if host == full || strings.HasSuffix(host, "."+full) {
+			return true
+		}
+	}
+	return false
+}
+
+// capture copies up to limit bytes of a body as it is read. The transport
+// may still be sending the body when the response arrives, so reads are
+// locked.
+type capture struct {
+	io.ReadCloser
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *capture) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		room := c.limit - c.buf.Len()
+		if n > room {
+			c.truncated = true
+		}
+		if room > 0 {
+			c.buf.Write(p[:min(n, room)])
+		}
+	}
+	return n, err
+}
+
+// captured returns a copy of the body read so far
+func (c *capture) captured() ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Clone(c.buf.Bytes()), c.truncated
+}
@@ -0,0 +1,225 @@
+package mirror
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/goodtune/kproxy/internal/spool"
+	"github.com/rs/zerolog"
+)
+
+// sink collects the records POSTed to it
+type sink struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+func (s *sink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var batch []Entry
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.entries = append(s.entries, batch...)
+	s.mu.Unlock()
+}
+
+func newMirror(t *testing.T, cfg Config) (*Mirror, *sink) {
+	t.Helper()
+	s := &sink{}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	cfg.URL = srv.URL
+	if cfg.SampleRate == 0 {
+		cfg.SampleRate = 1
+	}
+	cfg.QueueSize = 10
+	cfg.Timeout = 5 * time.Second
+	m, err := New(cfg, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, s
+}
+
+var allow = &policy.PolicyDecision{Action: policy.ActionAllow, Category: "news", Profile: "child"}
+
+func TestMirror(t *testing.T) {
+	m, s := newMirror(t, Config{RedactQuery: true, RedactClientIP: true})
+	m.Start()
+
+	req := httptest.NewRequest(http.MethodGet, "https://News.example.com/story?id=42&token=secret", nil)
+	req.Header.Set("User-Agent", "Tablet/1.0")
+	req.Header.Set("Cookie", "session=abc")
+	m.Begin(req, net.ParseIP("192.168.1.20"), allow).Finish(http.StatusOK, 1234)
+	m.Stop()
+
+	if len(s.entries) != 1 {
+		t.Fatalf("sink got %d records, want 1", len(s.entries))
+	}
+	got := s.entries[0]
+	if got.Host != "news.example.com" || got.Path != "/story" || got.Status != 200 || got.ResponseBytes != 1234 {
+		t.Errorf("record = %+v", got)
+	}
+	if got.Category != "news" || got.Profile != "child" || got.Action != string(policy.ActionAllow) {
+		t.Errorf("record decision = %q %q %q", got.Category, got.Profile, got.Action)
+	}
+	if got.Query != "id=REDACTED&token=REDACTED" {
+		t.Errorf("Query = %q, want values redacted", got.Query)
+	}
+	if got.Client == "192.168.1.20" || !strings.HasPrefix(got.Client, "client-") {
+		t.Errorf("Client = %q, want a pseudonym", got.Client)
+	}
+	if got.UserAgent != "Tablet/1.0" {
+		t.Errorf("UserAgent = %q", got.UserAgent)
+	}
+	if got.Headers != nil || got.Body != nil {
+		t.Errorf("metadata record has headers %v or body %q", got.Headers, got.Body)
+	}
+}
+
+func TestMirrorSpool(t *testing.T) {
+	s := &sink{}
+	down := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	m, err := New(Config{URL: srv.URL, SampleRate: 1, QueueSize: 10, Timeout: 5 * time.Second}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := spool.Open(t.TempDir(), "mirror", 1<<20, time.Minute, m.Resend, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetSpool(sp)
+
+	// Records sent while the sink is down are kept and sent once it is back
+	m.Start()
+	m.Begin(httptest.NewRequest(http.MethodGet, "https://news.example.com/a", nil), net.ParseIP("192.168.1.20"), allow).Finish(http.StatusOK, 1)
+	m.Stop()
+	if len(s.entries) != 0 {
+		t.Fatalf("sink got %d records while down", len(s.entries))
+	}
+
+	down = false
+	if sent, err := sp.Replay(); err != nil || sent != 1 {
+		t.Fatalf("Replay() = %d (%v), want one batch", sent, err)
+	}
+	if len(s.entries) != 1 || s.entries[0].Path != "/a" {
+		t.Errorf("sink got %+v, want the spooled record", s.entries)
+	}
+}
+
+func TestClientPseudonym(t *testing.T) {
+	m, _ := newMirror(t, Config{RedactClientIP: true})
+	a := m.clientName(net.ParseIP("192.168.1.20"))
+	if b := m.clientName(net.ParseIP("192.168.1.20")); a != b {
+		t.Errorf("pseudonyms %q and %q differ for one client", a, b)
+	}
+	if b := m.clientName(net.ParseIP("192.168.1.21")); a == b {
+		t.Errorf("pseudonym %q shared by two clients", a)
+	}
+
+	m.cfg.RedactClientIP = false
+	if got := m.clientName(net.ParseIP("192.168.1.20")); got != "192.168.1.20" {
+		t.Errorf("clientName() = %q, want the IP", got)
+	}
+}
+
+func TestFullHost(t *testing.T) {
+	m, s := newMirror(t, Config{
+		FullHosts:       []string{"api.example.com"},
+		MaxBodyBytes:    5,
+		RedactHeaders:   []string{"X-Api-Key"},
+		RedactUserAgent: true,
+		RedactClientIP:  true,
+	})
+	m.Start()
+
+	req := httptest.NewRequest(http.MethodPost, "https://v2.api.example.com/classify", strings.NewReader("hello world"))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Api-Key", "secret")
+	req.Header.Set("X-Forwarded-For", "192.168.1.20")
+	req.Header.Set("User-Agent", "Tablet/1.0")
+	req.Header.Set("Content-Type", "text/plain")
+	mr := m.Begin(req, net.ParseIP("192.168.1.20"), allow)
+
+	// The body still reaches upstream in full
+	if body, _ := io.ReadAll(req.Body); string(body) != "hello world" {
+		t.Errorf("upstream body = %q, want it unchanged", body)
+	}
+	mr.Finish(http.StatusCreated, 0)
+
+	other := httptest.NewRequest(http.MethodPost, "https://notapi.example.com/", strings.NewReader("private"))
+	m.Begin(other, net.ParseIP("192.168.1.20"), allow).Finish(http.StatusOK, 0)
+	m.Stop()
+
+	if len(s.entries) != 2 {
+		t.Fatalf("sink got %d records, want 2", len(s.entries))
+	}
+	got := s.entries[0]
+	if string(got.Body) != "hello" || !got.BodyTruncated {
+		t.Errorf("Body = %q (truncated %v), want the first 5 bytes, truncated", got.Body, got.BodyTruncated)
+	}
+	for _, name := range []string{"Authorization", "X-Api-Key", "X-Forwarded-For", "User-Agent"} {
+		if _, ok := got.Headers[name]; ok {
+			t.Errorf("header %s mirrored, want it redacted", name)
+		}
+	}
+	if got.Headers["Content-Type"] == nil {
+		t.Errorf("Headers = %v, want Content-Type", got.Headers)
+	}
+	if got.UserAgent != "" {
+		t.Errorf("UserAgent = %q, want it redacted", got.UserAgent)
+	}
+	if s.entries[1].Headers != nil || s.entries[1].Body != nil {
+		t.Errorf("record for a host outside full_hosts has headers or body: %+v", s.entries[1])
+	}
+}
+
+func TestSampling(t *testing.T) {
+	m, _ := newMirror(t, Config{SampleRate: 0.25})
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	m.random = func() float64 { return 0.5 }
+	if m.Begin(req, nil, allow) != nil {
+		t.Error("Begin() sampled a request above the sample rate")
+	}
+	m.random = func() float64 { return 0.1 }
+	if m.Begin(req, nil, allow) == nil {
+		t.Error("Begin() skipped a request within the sample rate")
+	}
+
+	var none *Mirror
+	none.Begin(req, nil, allow).Finish(http.StatusOK, 0)
+}
+
+func TestQueueFullDrops(t *testing.T) {
+	m, _ := newMirror(t, Config{})
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	// Not started, so nothing drains the queue
+	for i := 0; i < 20; i++ {
+		m.Begin(req, nil, allow).Finish(http.StatusOK, 0)
+	}
+	if len(m.queue) != 10 {
+		t.Errorf("queue holds %d records, want 10", len(m.queue))
+	}
+}
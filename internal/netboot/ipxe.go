@@ -0,0 +1,72 @@
+package netboot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MenuScriptName is the name the iPXE menu is served under
+const MenuScriptName = "kproxy.ipxe"
+
+// MenuEntry is an item of the iPXE boot menu: an image or script to chain
+// to, or a kernel to boot
+type MenuEntry struct {
+	Name   string // Menu text
+	Arch   string // iPXE ${buildarch} it is shown on, e.g. "x86_64", "i386" or "arm64" ("" = all)
+	Chain  string // URL of an image or script to chain-load
+	Kernel string // URL of a kernel to boot with Initrd and Args, instead of Chain
+	Initrd string
+	Args   string
+}
+
+// Menu generates an iPXE script showing the entries for the client's
+// architecture, then a shell and local boot. After timeout without a
+// choice the first entry shown is booted (0 = wait for a choice).
+func Menu(title string, entries []MenuEntry, timeout time.Duration) []byte {
+	var b bytes.Buffer
+	b.WriteString("#!ipxe\n\n")
+	b.WriteString(":start\n")
+	fmt.Fprintf(&b, "menu %s\n", oneLine(title))
+	for i, e := range entries {
+		item := fmt.Sprintf("item entry%d %s", i, oneLine(e.Name))
+		if e.Arch != "" {
+			// iPXE stops a script at the first failing command, hence "||"
+			item = fmt.Sprintf("iseq ${buildarch} %s && %s ||", oneLine(e.Arch), item)
+		}
+		b.WriteString(item + "\n")
+	}
+	b.WriteString("item --gap\n")
+	b.WriteString("item shell iPXE shell\n")
+	b.WriteString("item exit Boot from local disk\n")
+	if timeout > 0 {
+		fmt.Fprintf(&b, "choose --timeout %d target || goto exit\n", timeout.Milliseconds())
+	} else {
+		b.WriteString("choose target || goto exit\n")
+	}
+	b.WriteString("goto ${target}\n")
+
+	for i, e := range entries {
+		fmt.Fprintf(&b, "\n:entry%d\n", i)
+		if e.Kernel != "" {
+			fmt.Fprintf(&b, "kernel %s || goto failed\n", strings.TrimSpace(oneLine(e.Kernel+" "+e.Args)))
+			if e.Initrd != "" {
+				fmt.Fprintf(&b, "initrd %s || goto failed\n", oneLine(e.Initrd))
+			}
+			b.WriteString("boot || goto failed\n")
+		} else {
+			fmt.Fprintf(&b, "chain %s || goto failed\n", oneLine(e.Chain))
+		}
+	}
+
+	b.WriteString("\n:shell\nshell\ngoto start\n")
+	b.WriteString("\n:exit\nexit\n")
+	b.WriteString("\n:failed\necho Boot failed, returning to the menu\nsleep 3\ngoto start\n")
+	return b.Bytes()
+}
+
+// oneLine keeps configuration text from adding script lines
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
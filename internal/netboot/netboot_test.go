@@ -0,0 +1,122 @@
+package netboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// fetch reads name from the server at addr as a TFTP client, with options
+// as name/value pairs
+func fetch(t *testing.T, addr net.Addr, name string, options ...string) ([]byte, uint16) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	blockSize := defaultBlockSize
+	rrq := []byte{0, opRRQ}
+	rrq = appendOption(rrq, name, "octet")
+	for i := 0; i+1 < len(options); i += 2 {
+		rrq = appendOption(rrq, options[i], options[i+1])
+		if options[i] == "blksize" {
+			blockSize, _ = strconv.Atoi(options[i+1])
+		}
+	}
+	if _, err := conn.WriteTo(rrq, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	var content []byte
+	buf := make([]byte, 70000)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		ack := []byte{0, opACK, 0, 0}
+		switch binary.BigEndian.Uint16(buf) {
+		case opERROR:
+			return nil, binary.BigEndian.Uint16(buf[2:])
+		case opOACK:
+		case opDATA:
+			content = append(content, buf[4:n]...)
+			copy(ack[2:], buf[2:4])
+		}
+		if _, err := conn.WriteTo(ack, peer); err != nil {
+			t.Fatal(err)
+		}
+		if binary.BigEndian.Uint16(buf) == opDATA && n-4 < blockSize {
+			return content, 0
+		}
+	}
+}
+
+func TestTFTP(t *testing.T) {
+	dir := t.TempDir()
+	image := bytes.Repeat([]byte("kproxy"), 1000) // 6000 bytes
+	if err := os.WriteFile(filepath.Join(dir, "undionly.kpxe"), image, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(filepath.Dir(dir), "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewTFTPServer(TFTPConfig{BindAddress: "127.0.0.1", Port: 0, Root: dir}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetFile("/"+MenuScriptName, []byte("#!ipxe\n"))
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Stop() }()
+
+	if got, code := fetch(t, s.Addr(), "undionly.kpxe"); code != 0 || !bytes.Equal(got, image) {
+		t.Errorf("Expected the file in 512-byte blocks, got %d bytes (error %d)", len(got), code)
+	}
+	if got, code := fetch(t, s.Addr(), "/undionly.kpxe", "blksize", "1428", "tsize", "0"); code != 0 || !bytes.Equal(got, image) {
+		t.Errorf("Expected the file with options, got %d bytes (error %d)", len(got), code)
+	}
+	if got, code := fetch(t, s.Addr(), MenuScriptName); code != 0 || string(got) != "#!ipxe\n" {
+		t.Errorf("Expected the generated file, got %q (error %d)", got, code)
+	}
+	if _, code := fetch(t, s.Addr(), "../secret"); code != errFileNotFound {
+		t.Errorf("Expected files outside the root not found, got error %d", code)
+	}
+	if _, code := fetch(t, s.Addr(), "missing.efi"); code != errFileNotFound {
+		t.Errorf("Expected a missing file not found, got error %d", code)
+	}
+}
+
+func TestMenu(t *testing.T) {
+	script := string(Menu("Home lab", []MenuEntry{
+		{Name: "netboot.xyz", Arch: "x86_64", Chain: "http://boot.netboot.xyz/ipxe/netboot.xyz.efi"},
+		{Name: "Kiosk\nshell", Kernel: "http://192.168.1.1/vmlinuz", Initrd: "http://192.168.1.1/initrd", Args: "quiet"},
+	}, 10*time.Second))
+
+	for _, want := range []string{
+		"#!ipxe\n",
+		"menu Home lab\n",
+		"iseq ${buildarch} x86_64 && item entry0 netboot.xyz ||\n",
+		"item entry1 Kiosk shell\n",
+		"choose --timeout 10000 target || goto exit\n",
+		":entry0\nchain http://boot.netboot.xyz/ipxe/netboot.xyz.efi || goto failed\n",
+		":entry1\nkernel http://192.168.1.1/vmlinuz quiet || goto failed\ninitrd http://192.168.1.1/initrd || goto failed\nboot || goto failed\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected the script to contain %q:\n%s", want, script)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Package netboot serves network boot files: a small read-only TFTP server
+// (RFC 1350, with the blksize and tsize options of RFC 2348 and 2349) and
+// an iPXE boot menu generated from configuration, so KProxy can netboot
+// machines on the LAN without a separate TFTP server.
+package netboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TFTP opcodes
+const (
+	opRRQ   = 1
+	opWRQ   = 2
+	opDATA  = 3
+	opACK   = 4
+	opERROR = 5
+	opOACK  = 6
+)
+
+// TFTP error codes
+const (
+	errNotDefined     = 0
+	errFileNotFound   = 1
+	errAccessViolated = 2
+	errIllegalOp      = 4
+)
+
+const (
+	defaultBlockSize = 512
+	maxBlockSize     = 65464
+	retries          = 5
+	defaultTimeout   = time.Second
+)
+
+// TFTPConfig holds TFTP server configuration
+type TFTPConfig struct {
+	BindAddress string
+	Port        int
+	Root        string // Directory files are served from ("" = generated files only)
+}
+
+// TFTPServer serves files read-only over TFTP
+type TFTPServer struct {
+	config TFTPConfig
+	logger zerolog.Logger
+
+	root *os.Root // nil without a root directory
+
+	mu    sync.RWMutex
+	files map[string][]byte // Generated files, e.g. the iPXE menu
+
+	conn net.PacketConn
+	wg   sync.WaitGroup
+}
+
+// NewTFTPServer creates a TFTP server serving files from config.Root
+func NewTFTPServer(config TFTPConfig, logger zerolog.Logger) (*TFTPServer, error) {
+	s := &TFTPServer{
+		config: config,
+		logger: logger.With().Str("component", "tftp").Logger(),
+		files:  make(map[string][]byte),
+	}
+	if config.Root != "" {
+		root, err := os.OpenRoot(config.Root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open TFTP root: %w", err)
+		}
+		s.root = root
+	}
+	return s, nil
+}
+
+// SetFile serves content as name, in preference to a file in the root
+func (s *TFTPServer) SetFile(name string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[cleanName(name)] = content
+}
+
+// Start starts listening for read requests
+func (s *TFTPServer) Start() error {
+	addr := net.JoinHostPort(s.config.BindAddress, strconv.Itoa(s.config.Port))
+	conn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	s.conn = conn
+
+	s.wg.Add(1)
+	go s.serve()
+
+	s.logger.Info().Str("addr", conn.LocalAddr().String()).Str("root", s.config.Root).Msg("TFTP server started")
+	return nil
+}
+
+// Addr returns the address the server listens on
+func (s *TFTPServer) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Stop stops listening. Transfers in progress finish or time out.
+func (s *TFTPServer) Stop() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.wg.Wait()
+	if s.root != nil {
+		_ = s.root.Close()
+	}
+	return err
+}
+
+// serve reads requests until the listener is closed
+func (s *TFTPServer) serve() {
+	defer s.wg.Done()
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Error().Err(err).Msg("TFTP server error")
+			}
+			return
+		}
+		packet := bytes.Clone(buf[:n])
+		go s.handle(packet, peer)
+	}
+}
+
+// request is a parsed read request
+type request struct {
+	filename string
+	options  map[string]string // Lowercase names
+}
+
+// parseRequest parses a read or write request: filename, mode and options,
+// each NUL-terminated
+func parseRequest(packet []byte) (*request, error) {
+	fields := strings.Split(string(packet[2:]), "\x00")
+	if len(fields) < 3 || fields[len(fields)-1] != "" {
+		return nil, fmt.Errorf("malformed request")
+	}
+	fields = fields[:len(fields)-1]
+	if mode := strings.ToLower(fields[1]); mode != "octet" && mode != "netascii" {
+		return nil, fmt.Errorf("unsupported mode %q", fields[1])
+	}
+
+	req := &request{filename: fields[0], options: make(map[string]string)}
+	for i := 2; i+1 < len(fields); i += 2 {
+		req.options[strings.ToLower(fields[i])] = fields[i+1]
+	}
+	return req, nil
+}
+
+// handle answers a request from its own port (the transfer ID)
+func (s *TFTPServer) handle(packet []byte, peer net.Addr) {
+	conn, err := net.ListenPacket("udp4", net.JoinHostPort(s.config.BindAddress, "0"))
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to open TFTP transfer socket")
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	if len(packet) < 2 {
+		return
+	}
+	switch binary.BigEndian.Uint16(packet) {
+	case opRRQ:
+	case opWRQ:
+		sendError(conn, peer, errAccessViolated, "read-only server")
+		return
+	default:
+		sendError(conn, peer, errIllegalOp, "expected a read request")
+		return
+	}
+
+	req, err := parseRequest(packet)
+	if err != nil {
+		sendError(conn, peer, errNotDefined, err.Error())
+		return
+	}
+	content, err := s.open(req.filename)
+	if err != nil {
+		s.logger.Debug().Err(err).Str("file", req.filename).Str("client", peer.String()).Msg("TFTP file not found")
+		sendError(conn, peer, errFileNotFound, "file not found")
+		return
+	}
+
+	t := &transfer{conn: conn, peer: peer, blockSize: defaultBlockSize, timeout: defaultTimeout}
+	if err := t.negotiate(req.options, len(content)); err != nil {
+		s.logger.Debug().Err(err).Str("file", req.filename).Str("client", peer.String()).Msg("TFTP transfer failed")
+		return
+	}
+	if err := t.send(content); err != nil {
+		s.logger.Debug().Err(err).Str("file", req.filename).Str("client", peer.String()).Msg("TFTP transfer failed")
+		return
+	}
+	s.logger.Info().Str("file", req.filename).Str("client", peer.String()).Int("bytes", len(content)).Msg("TFTP transfer complete")
+}
+
+// open reads a generated file or a file in the root. Names can't escape
+// the root.
+func (s *TFTPServer) open(filename string) ([]byte, error) {
+	name := cleanName(filename)
+	s.mu.RLock()
+	content, ok := s.files[name]
+	s.mu.RUnlock()
+	if ok {
+		return content, nil
+	}
+	if s.root == nil || name == "." {
+		return nil, fs.ErrNotExist
+	}
+
+	f, err := s.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return nil, fs.ErrNotExist
+	}
+	return io.ReadAll(f)
+}
+
+// cleanName makes a requested filename relative to the root. Clients ask
+// for "/pxelinux.0", "pxelinux.0" or "\boot\pxelinux.0" alike.
+func cleanName(filename string) string {
+	name := strings.ReplaceAll(filename, "\\", "/")
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// transfer sends one file to one client
+type transfer struct {
+	conn      net.PacketConn
+	peer      net.Addr
+	blockSize int
+	timeout   time.Duration
+}
+
+// negotiate accepts the options the client asked for, if any, with an
+// OACK the client acknowledges as block 0
+func (t *transfer) negotiate(options map[string]string, size int) error {
+	var oack []byte
+	if v, ok := options["blksize"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 8 {
+			t.blockSize = min(n, maxBlockSize)
+			oack = appendOption(oack, "blksize", strconv.Itoa(t.blockSize))
+		}
+	}
+	if v, ok := options["timeout"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 255 {
+			t.timeout = time.Duration(n) * time.Second
+			oack = appendOption(oack, "timeout", v)
+		}
+	}
+	if _, ok := options["tsize"]; ok {
+		oack = appendOption(oack, "tsize", strconv.Itoa(size))
+	}
+	if oack == nil {
+		return nil
+	}
+	return t.exchange(append([]byte{0, opOACK}, oack...), 0)
+}
+
+// send sends content in blocks. The last block is shorter than the block
+// size, empty if need be. Block numbers wrap for files over 65535 blocks.
+func (t *transfer) send(content []byte) error {
+	for block := 1; ; block++ {
+		start := (block - 1) * t.blockSize
+		end := min(start+t.blockSize, len(content))
+		packet := make([]byte, 4, 4+end-start)
+		binary.BigEndian.PutUint16(packet, opDATA)
+		binary.BigEndian.PutUint16(packet[2:], uint16(block))
+		packet = append(packet, content[start:end]...)
+		if err := t.exchange(packet, uint16(block)); err != nil {
+			return err
+		}
+		if end-start < t.blockSize {
+			return nil
+		}
+	}
+}
+
+// exchange sends packet until the client acknowledges block
+func (t *transfer) exchange(packet []byte, block uint16) error {
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < retries; attempt++ {
+		if _, err := t.conn.WriteTo(packet, t.peer); err != nil {
+			return err
+		}
+		deadline := time.Now().Add(t.timeout)
+		for {
+			if err := t.conn.SetReadDeadline(deadline); err != nil {
+				return err
+			}
+			n, from, err := t.conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break // Resend
+				}
+				return err
+			}
+			if from.String() != t.peer.String() || n < 4 {
+				continue // Another transfer ID, or garbage
+			}
+			switch binary.BigEndian.Uint16(buf) {
+			case opACK:
+				if binary.BigEndian.Uint16(buf[2:]) == block {
+					return nil
+				}
+			case opERROR:
+				return fmt.Errorf("client error: %s", strings.TrimRight(string(buf[4:n]), "\x00"))
+			}
+		}
+	}
+	return fmt.Errorf("no acknowledgement of block %d", block)
+}
+
+// appendOption appends an option name and value to an OACK
+func appendOption(b []byte, name, value string) []byte {
+	b = append(b, name...)
+	b = append(b, 0)
+	b = append(b, value...)
+	return append(b, 0)
+}
+
+// sendError sends an ERROR packet
+func sendError(conn net.PacketConn, peer net.Addr, code uint16, message string) {
+	packet := make([]byte, 4, 5+len(message))
+	binary.BigEndian.PutUint16(packet, opERROR)
+	binary.BigEndian.PutUint16(packet[2:], code)
+	packet = append(packet, message...)
+	packet = append(packet, 0)
+	_, _ = conn.WriteTo(packet, peer)
+}
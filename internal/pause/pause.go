@@ -0,0 +1,189 @@
+package pause
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+// ErrNotFound is returned for a device or profile that isn't paused
+var ErrNotFound = errors.New("not paused")
+
+// Set holds the devices and profiles paused through the admin API (kproxy
+// device pause, kproxy profile pause). Policy blocks all DNS queries and
+// requests of a paused device, and of every device with a paused profile,
+// from the next query on; no policy edit or reload is involved. Pauses are
+// kept in storage and survive restarts; the set caches them so policy
+// evaluation doesn't touch storage.
+type Set struct {
+	store  storage.PauseStore
+	logger zerolog.Logger
+
+	mu     sync.Mutex
+	pauses []storage.Pause // Oldest first
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates an empty pause set backed by store. Call Load to read the
+// stored pauses.
+func New(store storage.PauseStore, logger zerolog.Logger) *Set {
+	return &Set{
+		store:  store,
+		logger: logger.With().Str("component", "pause").Logger(),
+		now:    time.Now,
+	}
+}
+
+// Load reads the stored pauses, deleting those that expired while KProxy
+// was stopped
+func (s *Set) Load(ctx context.Context) error {
+	stored, err := s.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pauses = s.pauses[:0]
+	for _, pause := range stored {
+		if pause.Until != nil && !now.Before(*pause.Until) {
+			if err := s.store.Delete(ctx, pause.Kind, pause.Target); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+			continue
+		}
+		s.pauses = append(s.pauses, pause)
+	}
+	return nil
+}
+
+// Pause pauses a device or profile (kind storage.PauseDevice or
+// storage.PauseProfile), replacing an earlier pause of it. A zero until
+// keeps it paused until resumed.
+func (s *Set) Pause(ctx context.Context, kind, target string, until time.Time) (storage.Pause, error) {
+	target = strings.TrimSpace(target)
+	if kind != storage.PauseDevice && kind != storage.PauseProfile {
+		return storage.Pause{}, fmt.Errorf("invalid pause kind: %s", kind)
+	}
+	if target == "" {
+		return storage.Pause{}, fmt.Errorf("%s is required", kind)
+	}
+	now := s.now()
+	if !until.IsZero() && !until.After(now) {
+		return storage.Pause{}, fmt.Errorf("until must be in the future")
+	}
+
+	pause := storage.Pause{Kind: kind, Target: target, Created: now}
+	if !until.IsZero() {
+		pause.Until = &until
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.Put(ctx, pause); err != nil {
+		return storage.Pause{}, err
+	}
+	if i := s.indexLocked(kind, target); i >= 0 {
+		s.pauses = append(s.pauses[:i], s.pauses[i+1:]...)
+	}
+	s.pauses = append(s.pauses, pause)
+
+	event := s.logger.Warn().Str("kind", kind).Str("target", target)
+	if pause.Until != nil {
+		event = event.Time("until", *pause.Until)
+	}
+	event.Msg("Paused")
+	return pause, nil
+}
+
+// Resume ends the pause of a device or profile
+func (s *Set) Resume(ctx context.Context, kind, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	i := s.indexLocked(kind, target)
+	if i < 0 {
+		return fmt.Errorf("%w: %s %s", ErrNotFound, kind, target)
+	}
+	if err := s.store.Delete(ctx, kind, target); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	s.pauses = append(s.pauses[:i], s.pauses[i+1:]...)
+	s.logger.Warn().Str("kind", kind).Str("target", target).Msg("Resumed")
+	return nil
+}
+
+// Get returns the pause in effect for a device or profile
+func (s *Set) Get(kind, target string) (storage.Pause, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	if i := s.indexLocked(kind, target); i >= 0 {
+		return s.pauses[i], nil
+	}
+	return storage.Pause{}, fmt.Errorf("%w: %s %s", ErrNotFound, kind, target)
+}
+
+// List returns the pauses in effect, oldest first
+func (s *Set) List() []storage.Pause {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	return append([]storage.Pause{}, s.pauses...)
+}
+
+// PolicyPauses returns the pauses in effect as input.paused for OPA:
+// {"devices": {id: {"until": RFC 3339 or ""}}, "profiles": {...}}
+func (s *Set) PolicyPauses() map[string]interface{} {
+	facts := map[string]interface{}{
+		"devices":  map[string]interface{}{},
+		"profiles": map[string]interface{}{},
+	}
+	for _, pause := range s.List() {
+		until := ""
+		if pause.Until != nil {
+			until = pause.Until.UTC().Format(time.RFC3339)
+		}
+		facts[pause.Kind+"s"].(map[string]interface{})[pause.Target] = map[string]interface{}{"until": until}
+	}
+	return facts
+}
+
+// indexLocked finds the pause of a device or profile. s.mu must be held.
+func (s *Set) indexLocked(kind, target string) int {
+	for i, pause := range s.pauses {
+		if pause.Kind == kind && pause.Target == target {
+			return i
+		}
+	}
+	return -1
+}
+
+// pruneLocked drops expired pauses from the cache; Load deletes them from
+// storage. s.mu must be held.
+func (s *Set) pruneLocked() {
+	now := s.now()
+	kept := s.pauses[:0]
+	for _, pause := range s.pauses {
+		if pause.Until != nil && !now.Before(*pause.Until) {
+			s.logger.Warn().Str("kind", pause.Kind).Str("target", pause.Target).Msg("Pause expired")
+			continue
+		}
+		kept = append(kept, pause)
+	}
+	s.pauses = kept
+}
@@ -0,0 +1,76 @@
+package pause
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestSet(t *testing.T) {
+	ctx := context.Background()
+	store := memory.Open().Pauses()
+	s := New(store, zerolog.Nop())
+	now := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	if _, err := s.Pause(ctx, storage.PauseDevice, "tablet", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	if _, err := s.Pause(ctx, storage.PauseProfile, "child", time.Time{}); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	if _, err := s.Pause(ctx, "user", "alice", time.Time{}); err == nil {
+		t.Error("Pause() of an unknown kind succeeded")
+	}
+	if _, err := s.Pause(ctx, storage.PauseDevice, "laptop", now.Add(-time.Minute)); err == nil {
+		t.Error("Pause() until a time in the past succeeded")
+	}
+
+	// Pausing again replaces the pause
+	again, err := s.Pause(ctx, storage.PauseDevice, "tablet", now.Add(2*time.Hour))
+	if err != nil || again.Until == nil || !again.Until.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("Pause() again = %+v (%v)", again, err)
+	}
+	if pauses := s.List(); len(pauses) != 2 || pauses[0].Kind != storage.PauseProfile || pauses[1].Target != "tablet" {
+		t.Errorf("List() = %+v, want the child profile then the tablet", pauses)
+	}
+
+	facts := s.PolicyPauses()
+	devices := facts["devices"].(map[string]interface{})
+	profiles := facts["profiles"].(map[string]interface{})
+	if devices["tablet"].(map[string]interface{})["until"] != "2026-03-01T20:00:00Z" || profiles["child"].(map[string]interface{})["until"] != "" {
+		t.Errorf("PolicyPauses() = %v", facts)
+	}
+
+	// Pauses survive a restart; expired ones are dropped from storage
+	now = now.Add(2 * time.Hour)
+	restarted := New(store, zerolog.Nop())
+	restarted.now = s.now
+	if err := restarted.Load(ctx); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if pauses := restarted.List(); len(pauses) != 1 || pauses[0].Target != "child" {
+		t.Errorf("List() after Load = %+v, want the child profile", pauses)
+	}
+	if stored, _ := store.List(ctx); len(stored) != 1 {
+		t.Errorf("stored pauses after Load = %+v, want the expired pause deleted", stored)
+	}
+
+	if err := restarted.Resume(ctx, storage.PauseDevice, "tablet"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resume() of an expired pause = %v, want ErrNotFound", err)
+	}
+	if err := restarted.Resume(ctx, storage.PauseProfile, "child"); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if _, err := restarted.Get(storage.PauseProfile, "child"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Resume = %v, want ErrNotFound", err)
+	}
+	if stored, _ := store.List(ctx); len(stored) != 0 {
+		t.Errorf("stored pauses after Resume = %+v, want none", stored)
+	}
+}
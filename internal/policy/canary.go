@@ -0,0 +1,253 @@
+package policy
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/rs/zerolog"
+)
+
+// canaryQueueSize is how many decisions may wait for shadow evaluation
+// before new ones are skipped
+const canaryQueueSize = 1000
+
+// maxDivergences caps the distinct divergences counted; further ones are
+// counted under an "(other)" rule
+const maxDivergences = 1000
+
+// Canary evaluates a candidate policy set in shadow: every DNS and proxy
+// decision of the active policies is evaluated again with the same facts
+// by the candidate, off the request path, and decisions that differ are
+// logged and counted. The candidate's decisions are never enforced.
+type Canary struct {
+	engine *opa.Engine
+	source string
+	jobs   chan canaryJob
+	stop   chan struct{}
+	done   chan struct{}
+	logger zerolog.Logger
+
+	mu          sync.Mutex
+	since       time.Time
+	evaluated   int64
+	skipped     int64
+	failed      int64
+	divergences map[divergenceKey]*Divergence
+}
+
+// canaryJob is an active decision waiting for shadow evaluation
+type canaryJob struct {
+	kind   string // "dns" or "proxy"
+	facts  map[string]interface{}
+	action string
+	rule   string
+}
+
+type divergenceKey struct {
+	kind, activeAction, activeRule, candidateAction, candidateRule string
+}
+
+// Divergence counts the decisions on which the candidate disagreed with
+// the active policies in the same way. For proxy decisions the rule is the
+// matched rule ID; DNS decisions have no rule ID, so it is the reason.
+type Divergence struct {
+	Kind            string    `json:"kind"` // "dns" or "proxy"
+	ActiveAction    string    `json:"active_action"`
+	ActiveRule      string    `json:"active_rule,omitempty"`
+	CandidateAction string    `json:"candidate_action"`
+	CandidateRule   string    `json:"candidate_rule,omitempty"`
+	Count           int64     `json:"count"`
+	Example         string    `json:"example"` // Domain or host of the last one
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+// CanaryReport is the shadow evaluation summary since the candidate was
+// (re)loaded
+type CanaryReport struct {
+	Candidate   string       `json:"candidate"` // Policy directory or URLs
+	Since       time.Time    `json:"since"`
+	Evaluated   int64        `json:"evaluated"`
+	Diverged    int64        `json:"diverged"`
+	Skipped     int64        `json:"skipped"` // Queue full, not evaluated
+	Failed      int64        `json:"failed"`  // Candidate evaluation errors
+	Divergences []Divergence `json:"divergences"`
+}
+
+// NewCanary creates a shadow evaluator for the candidate policies loaded
+// by engine. source names them in logs and reports.
+func NewCanary(engine *opa.Engine, source string, logger zerolog.Logger) *Canary {
+	return &Canary{
+		engine:      engine,
+		source:      source,
+		jobs:        make(chan canaryJob, canaryQueueSize),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		since:       time.Now(),
+		divergences: make(map[divergenceKey]*Divergence),
+		logger:      logger.With().Str("component", "canary").Logger(),
+	}
+}
+
+// Start starts evaluating queued decisions
+func (c *Canary) Start() {
+	c.logger.Info().Str("candidate", c.source).Msg("Shadow evaluating candidate policies")
+	go c.run()
+}
+
+// Stop evaluates the decisions still queued and stops
+func (c *Canary) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+// Reload reloads the candidate policies and starts counting afresh
+func (c *Canary) Reload() error {
+	if err := c.engine.Reload(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.since = time.Now()
+	c.evaluated, c.skipped, c.failed = 0, 0, 0
+	c.divergences = make(map[divergenceKey]*Divergence)
+	c.mu.Unlock()
+	return nil
+}
+
+// Report returns the divergences counted since the candidate was loaded,
+// most frequent first
+func (c *Canary) Report() CanaryReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := CanaryReport{
+		Candidate:   c.source,
+		Since:       c.since,
+		Evaluated:   c.evaluated,
+		Skipped:     c.skipped,
+		Failed:      c.failed,
+		Divergences: make([]Divergence, 0, len(c.divergences)),
+	}
+	for _, d := range c.divergences {
+		report.Diverged += d.Count
+		report.Divergences = append(report.Divergences, *d)
+	}
+	sort.Slice(report.Divergences, func(i, j int) bool {
+		a, b := report.Divergences[i], report.Divergences[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.LastSeen.After(b.LastSeen)
+	})
+	return report
+}
+
+// enqueue queues an active decision for shadow evaluation. facts must not
+// be changed afterwards. Decisions are skipped when the queue is full, so
+// the candidate never slows down traffic.
+func (c *Canary) enqueue(kind string, facts map[string]interface{}, action, rule string) {
+	select {
+	case c.jobs <- canaryJob{kind: kind, facts: facts, action: action, rule: rule}:
+	default:
+		c.mu.Lock()
+		c.skipped++
+		c.mu.Unlock()
+	}
+}
+
+func (c *Canary) run() {
+	defer close(c.done)
+	for {
+		select {
+		case job := <-c.jobs:
+			c.evaluate(job)
+		case <-c.stop:
+			for {
+				select {
+				case job := <-c.jobs:
+					c.evaluate(job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// evaluate evaluates a decision with the candidate and counts it if the
+// action or rule differs
+func (c *Canary) evaluate(job canaryJob) {
+	ctx := context.Background()
+
+	var action, rule, example string
+	switch job.kind {
+	case "dns":
+		d, err := c.engine.EvaluateDNS(ctx, job.facts)
+		if err != nil {
+			c.fail(job.kind, err)
+			return
+		}
+		action, rule = d.Action, d.Reason
+		example, _ = job.facts["domain"].(string)
+	default:
+		d, err := c.engine.EvaluateProxy(ctx, job.facts)
+		if err != nil {
+			c.fail(job.kind, err)
+			return
+		}
+		action, rule = d.Action, d.MatchedRuleID
+		example, _ = job.facts["host"].(string)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evaluated++
+	if action == job.action && rule == job.rule {
+		return
+	}
+
+	key := divergenceKey{job.kind, job.action, job.rule, action, rule}
+	d, ok := c.divergences[key]
+	if !ok && len(c.divergences) >= maxDivergences {
+		key = divergenceKey{kind: job.kind, activeRule: "(other)", candidateRule: "(other)"}
+		d, ok = c.divergences[key]
+	}
+	if !ok {
+		d = &Divergence{
+			Kind:            key.kind,
+			ActiveAction:    key.activeAction,
+			ActiveRule:      key.activeRule,
+			CandidateAction: key.candidateAction,
+			CandidateRule:   key.candidateRule,
+		}
+		c.divergences[key] = d
+	}
+	d.Count++
+	d.Example = example
+	d.LastSeen = time.Now()
+	metrics.CanaryDivergences.WithLabelValues(job.kind).Inc()
+
+	// Log the first of each kind of divergence; the rest are counted
+	event := c.logger.Debug()
+	if d.Count == 1 {
+		event = c.logger.Info()
+	}
+	event.
+		Str("kind", job.kind).
+		Str("example", example).
+		Str("active_action", job.action).
+		Str("active_rule", job.rule).
+		Str("candidate_action", action).
+		Str("candidate_rule", rule).
+		Msg("Candidate policy decision differs")
+}
+
+func (c *Canary) fail(kind string, err error) {
+	c.mu.Lock()
+	c.failed++
+	c.mu.Unlock()
+	c.logger.Debug().Err(err).Str("kind", kind).Msg("Candidate policy evaluation failed")
+}
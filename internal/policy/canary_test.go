@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/rs/zerolog"
+)
+
+// writePolicies copies the shipped policies to a directory, with the
+// default profile assigned to 192.168.1.0/24 and the given default action
+func writePolicies(t *testing.T, defaultAction string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files, err := filepath.Glob("../../policies/*.rego")
+	if err != nil || len(files) == 0 {
+		t.Skipf("Skipping canary test - policies not available: %v", err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.rego") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(file) == "config.rego" {
+			content := strings.Replace(string(data), "subnet_profiles := {}", `subnet_profiles := {"192.168.1.0/24": "default"}`, 1)
+			i := strings.LastIndex(content, `"default_action": "block"`)
+			content = content[:i] + `"default_action": "` + defaultAction + `"` + content[i+len(`"default_action": "block"`):]
+			data = []byte(content)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(file)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestCanary tests that proxy decisions of a candidate policy set are
+// evaluated in shadow and differences counted, without being enforced
+func TestCanary(t *testing.T) {
+	active := writePolicies(t, "block")
+	candidate := writePolicies(t, "allow")
+
+	engine, err := NewEngine(nil, "local.kproxy", opa.Config{Source: "filesystem", PolicyDir: active}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	candidateEngine, err := opa.NewEngine(opa.Config{Source: "filesystem", PolicyDir: candidate}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("opa.NewEngine failed: %v", err)
+	}
+	canary := NewCanary(candidateEngine, candidate, zerolog.Nop())
+	engine.SetCanary(canary)
+	canary.Start()
+
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		decision := engine.Evaluate(&ProxyRequest{
+			ClientIP: net.ParseIP("192.168.1.10"),
+			Host:     host,
+			Path:     "/",
+			Method:   "GET",
+		})
+		if decision.Action != ActionBlock {
+			t.Errorf("Expected the active policies to block %s, got %s", host, decision.Action)
+		}
+	}
+	engine.GetDNSDecision(net.ParseIP("192.168.1.10"), nil, "a.example.com")
+	canary.Stop()
+
+	report := canary.Report()
+	if report.Evaluated != 3 {
+		t.Errorf("Expected 3 shadow evaluations, got %d", report.Evaluated)
+	}
+	if report.Diverged != 2 || len(report.Divergences) != 1 {
+		t.Fatalf("Expected 2 proxy divergences of one kind, got %+v", report)
+	}
+	d := report.Divergences[0]
+	if d.Kind != "proxy" || d.ActiveAction != "BLOCK" || d.CandidateAction != "ALLOW" || d.Count != 2 {
+		t.Errorf("Unexpected divergence: %+v", d)
+	}
+
+	// Reloading the candidate starts counting afresh
+	if err := engine.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if report := canary.Report(); report.Evaluated != 0 || len(report.Divergences) != 0 {
+		t.Errorf("Expected counts to reset on reload, got %+v", report)
+	}
+}
@@ -0,0 +1,185 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand/v2"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+// decisionLogQueueSize is how many decisions may wait to be stored before
+// new ones are dropped
+const decisionLogQueueSize = 1000
+
+// DecisionLogConfig selects the decisions recorded and how long they are
+// kept
+type DecisionLogConfig struct {
+	SampleRate float64       // Fraction of decisions recorded (0-1)
+	MaxSize    int           // Largest input and result recorded, in bytes
+	Retention  time.Duration // How long decisions are kept
+}
+
+// DecisionLog records the OPA input and result of sampled DNS and proxy
+// decisions to storage, so that a decision can be evaluated again later
+// (kproxy check replay). Recording happens off the request path: decisions
+// are dropped rather than slowing traffic down when storage falls behind,
+// and dropped when their input and result are larger than MaxSize.
+type DecisionLog struct {
+	store  storage.DecisionLogStore
+	config DecisionLogConfig
+	jobs   chan decisionJob
+	stop   chan struct{}
+	done   chan struct{}
+	logger zerolog.Logger
+}
+
+// decisionJob is a decision waiting to be stored
+type decisionJob struct {
+	id     string
+	kind   string // "dns" or "proxy"
+	time   time.Time
+	facts  map[string]interface{}
+	result interface{}
+}
+
+// NewDecisionLog creates a decision log recording to store
+func NewDecisionLog(store storage.DecisionLogStore, config DecisionLogConfig, logger zerolog.Logger) *DecisionLog {
+	return &DecisionLog{
+		store:  store,
+		config: config,
+		jobs:   make(chan decisionJob, decisionLogQueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		logger: logger.With().Str("component", "decision-log").Logger(),
+	}
+}
+
+// Start starts storing recorded decisions
+func (l *DecisionLog) Start() {
+	l.logger.Info().
+		Float64("sample_rate", l.config.SampleRate).
+		Dur("retention", l.config.Retention).
+		Msg("Recording policy decisions")
+	go l.run()
+}
+
+// Stop stores the decisions still queued and stops
+func (l *DecisionLog) Stop() {
+	close(l.stop)
+	<-l.done
+}
+
+// record queues a decision to be stored if it is sampled, and returns its
+// ID, or "" if it isn't recorded. facts and result must not be changed
+// afterwards.
+func (l *DecisionLog) record(kind string, facts map[string]interface{}, result interface{}) string {
+	if l == nil || mathrand.Float64() >= l.config.SampleRate {
+		return ""
+	}
+
+	job := decisionJob{id: newDecisionID(), kind: kind, time: time.Now(), facts: facts, result: result}
+	select {
+	case l.jobs <- job:
+		return job.id
+	default:
+		metrics.DecisionLogDropped.WithLabelValues("queue_full").Inc()
+		return ""
+	}
+}
+
+func (l *DecisionLog) run() {
+	defer close(l.done)
+	for {
+		select {
+		case job := <-l.jobs:
+			l.put(job)
+		case <-l.stop:
+			for {
+				select {
+				case job := <-l.jobs:
+					l.put(job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// put stores a decision unless it is too large
+func (l *DecisionLog) put(job decisionJob) {
+	input, err := json.Marshal(job.facts)
+	if err != nil {
+		l.logger.Warn().Err(err).Str("id", job.id).Msg("Failed to encode decision input")
+		return
+	}
+	result, err := json.Marshal(job.result)
+	if err != nil {
+		l.logger.Warn().Err(err).Str("id", job.id).Msg("Failed to encode decision result")
+		return
+	}
+	if l.config.MaxSize > 0 && len(input)+len(result) > l.config.MaxSize {
+		metrics.DecisionLogDropped.WithLabelValues("too_large").Inc()
+		l.logger.Debug().Str("id", job.id).Int("size", len(input)+len(result)).Msg("Decision too large to record")
+		return
+	}
+
+	decision := storage.Decision{
+		ID:        job.id,
+		Kind:      job.kind,
+		Time:      job.time,
+		ExpiresAt: job.time.Add(l.config.Retention),
+		Input:     input,
+		Result:    result,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := l.store.Put(ctx, decision); err != nil {
+		metrics.DecisionLogDropped.WithLabelValues("store_error").Inc()
+		l.logger.Warn().Err(err).Str("id", job.id).Msg("Failed to record decision")
+	}
+}
+
+// Replay evaluates the input of a recorded decision again with engine, and
+// returns the result encoded as recorded ones are
+func Replay(ctx context.Context, engine *opa.Engine, decision *storage.Decision) (json.RawMessage, error) {
+	// Numbers decode as json.Number, so they reach OPA as recorded
+	var facts map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(decision.Input))
+	dec.UseNumber()
+	if err := dec.Decode(&facts); err != nil {
+		return nil, fmt.Errorf("failed to decode decision input: %w", err)
+	}
+
+	var result interface{}
+	var err error
+	switch decision.Kind {
+	case "dns":
+		result, err = engine.EvaluateDNS(ctx, facts)
+	case "proxy":
+		result, err = engine.EvaluateProxy(ctx, facts)
+	default:
+		return nil, fmt.Errorf("unknown decision kind: %s", decision.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// newDecisionID returns a random decision ID, short enough to copy from a
+// log line
+func newDecisionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,118 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+// TestDecisionLog tests that sampled decisions are recorded with their
+// input and result, and can be replayed against other policies
+func TestDecisionLog(t *testing.T) {
+	active := writePolicies(t, "block")
+	candidate := writePolicies(t, "allow")
+
+	engine, err := NewEngine(nil, "local.kproxy", opa.Config{Source: "filesystem", PolicyDir: active}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	store := memory.Open().Decisions()
+	decisionLog := NewDecisionLog(store, DecisionLogConfig{SampleRate: 1, MaxSize: 64 << 10, Retention: time.Hour}, zerolog.Nop())
+	engine.SetDecisionLog(decisionLog)
+	decisionLog.Start()
+
+	proxy := engine.Evaluate(&ProxyRequest{
+		ClientIP: net.ParseIP("192.168.1.10"),
+		Host:     "a.example.com",
+		Path:     "/",
+		Method:   "GET",
+	})
+	dns := engine.GetDNSDecision(net.ParseIP("192.168.1.10"), nil, "a.example.com")
+	decisionLog.Stop()
+
+	if proxy.DecisionID == "" || dns.DecisionID == "" || proxy.DecisionID == dns.DecisionID {
+		t.Fatalf("Expected distinct decision IDs, got %q and %q", proxy.DecisionID, dns.DecisionID)
+	}
+
+	ctx := context.Background()
+	recorded, err := store.Get(ctx, proxy.DecisionID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	var input struct {
+		Host string `json:"host"`
+	}
+	var result opa.ProxyDecision
+	if err := json.Unmarshal(recorded.Input, &input); err != nil || input.Host != "a.example.com" {
+		t.Errorf("Recorded input = %s, want the request's facts", recorded.Input)
+	}
+	if err := json.Unmarshal(recorded.Result, &result); err != nil || recorded.Kind != "proxy" || result.Action != "BLOCK" {
+		t.Errorf("Recorded %s result = %s, want BLOCK", recorded.Kind, recorded.Result)
+	}
+
+	// Replayed against the same policies the result is unchanged, against
+	// the candidate it is allowed
+	activeEngine, err := opa.NewEngine(opa.Config{Source: "filesystem", PolicyDir: active}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("opa.NewEngine failed: %v", err)
+	}
+	replayed, err := Replay(ctx, activeEngine, recorded)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if string(replayed) != string(recorded.Result) {
+		t.Errorf("Replay = %s, want %s", replayed, recorded.Result)
+	}
+
+	candidateEngine, err := opa.NewEngine(opa.Config{Source: "filesystem", PolicyDir: candidate}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("opa.NewEngine failed: %v", err)
+	}
+	replayed, err = Replay(ctx, candidateEngine, recorded)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if err := json.Unmarshal(replayed, &result); err != nil || result.Action != "ALLOW" {
+		t.Errorf("Replay against the candidate = %s, want ALLOW", replayed)
+	}
+
+	if _, err := store.Get(ctx, dns.DecisionID); err != nil {
+		t.Errorf("DNS decision not recorded: %v", err)
+	}
+}
+
+// TestDecisionLogLimits tests sampling and the size cap
+func TestDecisionLogLimits(t *testing.T) {
+	dir := writePolicies(t, "block")
+	engine, err := NewEngine(nil, "local.kproxy", opa.Config{Source: "filesystem", PolicyDir: dir}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	// Nothing is sampled at rate 0
+	store := memory.Open().Decisions()
+	none := NewDecisionLog(store, DecisionLogConfig{SampleRate: 0, Retention: time.Hour}, zerolog.Nop())
+	engine.SetDecisionLog(none)
+	none.Start()
+	if id := engine.GetDNSDecision(net.ParseIP("192.168.1.10"), nil, "a.example.com").DecisionID; id != "" {
+		t.Errorf("Decision %q recorded at sample rate 0", id)
+	}
+	none.Stop()
+
+	// Decisions over the size cap are dropped
+	tiny := NewDecisionLog(store, DecisionLogConfig{SampleRate: 1, MaxSize: 16, Retention: time.Hour}, zerolog.Nop())
+	engine.SetDecisionLog(tiny)
+	tiny.Start()
+	id := engine.GetDNSDecision(net.ParseIP("192.168.1.10"), nil, "a.example.com").DecisionID
+	tiny.Stop()
+	if _, err := store.Get(context.Background(), id); err != storage.ErrNotFound {
+		t.Errorf("Expected an oversized decision not to be stored, got %v", err)
+	}
+}
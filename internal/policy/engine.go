@@ -17,14 +17,80 @@ type UsageTracker interface {
 	GetCategoryUsage(deviceID, category string) (time.Duration, error)
 }
 
+// UserResolver identifies the person logged in at a client address, for
+// shared devices where policy should follow the user rather than the device
+type UserResolver interface {
+	LookupUser(clientIP net.IP, clientMAC net.HardwareAddr) (name, source string, ok bool)
+}
+
+// RuleSource supplies rules added at runtime (kproxy rule), which policies
+// apply ahead of the configured ones
+type RuleSource interface {
+	PolicyRules() []interface{}
+}
+
+// DeviceRuleSource supplies rules added at runtime for single devices
+// (kproxy device rule), which policies apply ahead of the device's profile
+type DeviceRuleSource interface {
+	PolicyDeviceRules() []interface{}
+}
+
+// RuleSetSource supplies rule sets saved at runtime (kproxy ruleset), which
+// policies apply to the profiles they are attached to
+type RuleSetSource interface {
+	PolicyRuleSets() map[string]interface{}
+}
+
+// StreamSource supplies the media streams in flight per profile and device,
+// which policies use to limit concurrent streams
+type StreamSource interface {
+	PolicyStreams() map[string]interface{}
+}
+
+// DeviceSource supplies devices added and profiles assigned at runtime
+// (kproxy device), which policies merge with the configured devices
+type DeviceSource interface {
+	PolicyDevices() map[string]interface{}
+	PolicyProfiles() map[string]interface{}
+}
+
+// PauseSource supplies the devices and profiles paused at runtime (kproxy
+// device pause, kproxy profile pause), which policies block
+type PauseSource interface {
+	PolicyPauses() map[string]interface{}
+}
+
+// HostnameSource names clients by the hostname they announced (mDNS,
+// NetBIOS or DHCP), which is self-reported and unauthenticated
+type HostnameSource interface {
+	Lookup(clientIP net.IP) string
+}
+
+// DeviceTypeSource guesses what kind of device a client is ("phone", "tv",
+// "console"...) from its DHCP requests and User-Agents
+type DeviceTypeSource interface {
+	DeviceType(clientIP net.IP, clientMAC net.HardwareAddr) string
+}
+
 // Engine handles policy evaluation by gathering facts and calling OPA
 type Engine struct {
-	usageStore   storage.UsageStore
-	usageTracker UsageTracker
-	opaEngine    *opa.Engine
-	clock        Clock
-	serverName   string // Server name for client setup (e.g., "local.kproxy")
-	logger       zerolog.Logger
+	usageStore       storage.UsageStore
+	usageTracker     UsageTracker
+	userResolver     UserResolver
+	ruleSource       RuleSource
+	deviceRuleSource DeviceRuleSource
+	ruleSetSource    RuleSetSource
+	streamSource     StreamSource
+	deviceSource     DeviceSource
+	pauseSource      PauseSource
+	hostnameSource   HostnameSource
+	deviceTypeSource DeviceTypeSource
+	opaEngine        *opa.Engine
+	canary           *Canary
+	decisionLog      *DecisionLog
+	clock            Clock
+	serverName       string // Server name for client setup (e.g., "local.kproxy")
+	logger           zerolog.Logger
 }
 
 // NewEngine creates a new fact-based policy engine
@@ -61,9 +127,71 @@ func (e *Engine) SetUsageTracker(tracker UsageTracker) {
 	e.usageTracker = tracker
 }
 
+// SetUserResolver sets the resolver used to add user facts to policy input
+func (e *Engine) SetUserResolver(resolver UserResolver) {
+	e.userResolver = resolver
+}
+
+// SetRuleSource sets the source of rules added at runtime
+func (e *Engine) SetRuleSource(source RuleSource) {
+	e.ruleSource = source
+}
+
+// SetDeviceRuleSource sets the source of device rules added at runtime
+func (e *Engine) SetDeviceRuleSource(source DeviceRuleSource) {
+	e.deviceRuleSource = source
+}
+
+// SetRuleSetSource sets the source of rule sets saved at runtime
+func (e *Engine) SetRuleSetSource(source RuleSetSource) {
+	e.ruleSetSource = source
+}
+
+// SetStreamSource sets the source of media streams in flight
+func (e *Engine) SetStreamSource(source StreamSource) {
+	e.streamSource = source
+}
+
+// SetDeviceSource sets the source of devices added at runtime
+func (e *Engine) SetDeviceSource(source DeviceSource) {
+	e.deviceSource = source
+}
+
+// SetPauseSource sets the source of devices and profiles paused at runtime
+func (e *Engine) SetPauseSource(source PauseSource) {
+	e.pauseSource = source
+}
+
+// SetHostnameSource sets the source of client hostnames
+func (e *Engine) SetHostnameSource(source HostnameSource) {
+	e.hostnameSource = source
+}
+
+// SetDeviceTypeSource sets the source of client device types
+func (e *Engine) SetDeviceTypeSource(source DeviceTypeSource) {
+	e.deviceTypeSource = source
+}
+
+// SetCanary sets a candidate policy set evaluated in shadow of the
+// active one
+func (e *Engine) SetCanary(canary *Canary) {
+	e.canary = canary
+}
+
+// SetDecisionLog records sampled decisions for replay (optional)
+func (e *Engine) SetDecisionLog(decisionLog *DecisionLog) {
+	e.decisionLog = decisionLog
+}
+
 // GetDNSAction determines the DNS action for a query using OPA
 // Just gathers facts and asks OPA
 func (e *Engine) GetDNSAction(clientIP net.IP, clientMAC net.HardwareAddr, domain string) DNSAction {
+	return e.GetDNSDecision(clientIP, clientMAC, domain).Action
+}
+
+// GetDNSDecision determines the DNS action for a query and whether the
+// device's profile requires DNSSEC-validated answers
+func (e *Engine) GetDNSDecision(clientIP net.IP, clientMAC net.HardwareAddr, domain string) DNSDecision {
 	// Build facts
 	facts := e.buildDNSFacts(clientIP, clientMAC, domain)
 
@@ -72,26 +200,99 @@ func (e *Engine) GetDNSAction(clientIP net.IP, clientMAC net.HardwareAddr, domai
 	dnsDecision, err := e.opaEngine.EvaluateDNS(ctx, facts)
 	if err != nil {
 		e.logger.Error().Err(err).Msg("OPA DNS evaluation failed, falling back to intercept")
-		return DNSActionIntercept
+		return DNSDecision{Action: DNSActionIntercept}
 	}
 
 	// Log the decision reason
 	e.logger.Debug().
 		Str("action", dnsDecision.Action).
 		Str("reason", dnsDecision.Reason).
+		Bool("dnssec_strict", dnsDecision.DNSSECStrict).
+		Bool("safesearch", dnsDecision.SafeSearch).
+		Str("block_response", dnsDecision.BlockResponse).
 		Msg("DNS policy decision")
 
+	if e.canary != nil {
+		e.canary.enqueue("dns", facts, dnsDecision.Action, dnsDecision.Reason)
+	}
+
+	decision := DNSDecision{
+		DNSSECStrict: dnsDecision.DNSSECStrict,
+		SafeSearch:   dnsDecision.SafeSearch,
+		DecisionID:   e.decisionLog.record("dns", facts, dnsDecision),
+	}
+	e.setDNSBlockResponse(&decision, dnsDecision)
+
 	// Convert string action to DNSAction
 	switch dnsDecision.Action {
 	case "BYPASS":
-		return DNSActionBypass
+		decision.Action = DNSActionBypass
 	case "BLOCK":
-		return DNSActionBlock
+		decision.Action = DNSActionBlock
 	case "INTERCEPT":
-		return DNSActionIntercept
+		decision.Action = DNSActionIntercept
 	default:
 		e.logger.Warn().Str("action", dnsDecision.Action).Msg("Unknown DNS action from OPA, defaulting to intercept")
-		return DNSActionIntercept
+		decision.Action = DNSActionIntercept
+	}
+
+	return decision
+}
+
+// setDNSBlockResponse validates the profile's block response. Unknown modes
+// and custom-ip without a usable address fall back to the 0.0.0.0 sinkhole.
+func (e *Engine) setDNSBlockResponse(decision *DNSDecision, d *opa.DNSDecision) {
+	decision.BlockResponse = DNSBlockZeroIP
+
+	switch mode := DNSBlockResponse(d.BlockResponse); mode {
+	case DNSBlockNXDomain, DNSBlockRefused:
+		decision.BlockResponse = mode
+	case DNSBlockCustomIP:
+		ipv4 := net.ParseIP(d.BlockIP).To4()
+		ipv6 := net.ParseIP(d.BlockIPv6)
+		if ipv6.To4() != nil {
+			ipv6 = nil
+		}
+		if ipv4 == nil && ipv6 == nil {
+			e.logger.Warn().
+				Str("block_ip", d.BlockIP).
+				Str("block_ipv6", d.BlockIPv6).
+				Msg("DNS block response custom-ip has no valid address, using zero-ip")
+			return
+		}
+		decision.BlockResponse = mode
+		decision.BlockIP = ipv4
+		decision.BlockIPv6 = ipv6
+	case DNSBlockZeroIP, "":
+	default:
+		e.logger.Warn().Str("block_response", d.BlockResponse).Msg("Unknown DNS block response, using zero-ip")
+	}
+}
+
+// GetCNAMEAction checks the CNAME targets of a bypassed query against the
+// device's block rules. It returns ActionBlock or ActionWarn with the reason
+// if a target matches, otherwise ActionBypass. Errors fail open, since the
+// query itself was already allowed to bypass the proxy.
+func (e *Engine) GetCNAMEAction(clientIP net.IP, clientMAC net.HardwareAddr, domain string, chain []string) (Action, string) {
+	// Build facts
+	facts := e.buildDNSFacts(clientIP, clientMAC, domain)
+	facts["cname_chain"] = chain
+
+	// Evaluate with OPA
+	ctx := context.Background()
+	decision, err := e.opaEngine.EvaluateCNAME(ctx, facts)
+	if err != nil {
+		e.logger.Error().Err(err).Msg("OPA CNAME evaluation failed, allowing query")
+		return ActionBypass, ""
+	}
+
+	switch decision.Action {
+	case "BLOCK":
+		return ActionBlock, decision.Reason
+	case "WARN":
+		return ActionWarn, decision.Reason
+	default:
+		return ActionBypass, decision.Reason
 	}
 }
 
@@ -112,20 +313,48 @@ func (e *Engine) Evaluate(req *ProxyRequest) *PolicyDecision {
 		}
 	}
 
+	if e.canary != nil {
+		e.canary.enqueue("proxy", facts, opaDecision.Action, opaDecision.MatchedRuleID)
+	}
+
 	// Convert OPA decision to PolicyDecision
 	decision := &PolicyDecision{
-		Action:        Action(opaDecision.Action),
-		Reason:        opaDecision.Reason,
-		BlockPage:     opaDecision.BlockPage,
-		MatchedRuleID: opaDecision.MatchedRuleID,
-		Category:      opaDecision.Category,
-		InjectTimer:   opaDecision.InjectTimer,
-		TimeRemaining: time.Duration(opaDecision.TimeRemainingMinutes) * time.Minute,
-		UsageLimitID:  opaDecision.UsageLimitID,
-	}
-
-	// If decision is ALLOW and we have a category with usage tracking, record activity
-	if decision.Action == ActionAllow && e.usageTracker != nil && decision.Category != "" {
+		Action:          Action(opaDecision.Action),
+		Reason:          opaDecision.Reason,
+		BlockPage:       opaDecision.BlockPage,
+		BlockTemplate:   opaDecision.BlockTemplate,
+		MatchedRuleID:   opaDecision.MatchedRuleID,
+		Category:        opaDecision.Category,
+		InjectTimer:     opaDecision.InjectTimer,
+		TimeRemaining:   time.Duration(opaDecision.TimeRemainingMinutes) * time.Minute,
+		UsageLimitID:    opaDecision.UsageLimitID,
+		Script:          opaDecision.Script,
+		ThrottleDelay:   time.Duration(opaDecision.ThrottleDelayMS) * time.Millisecond,
+		ThrottleKbps:    opaDecision.ThrottleKbps,
+		Profile:         opaDecision.Profile,
+		BandwidthWeight: opaDecision.BandwidthWeight,
+		StreamDevice:    opaDecision.StreamDevice,
+		SafeSearch:      opaDecision.SafeSearch,
+		YouTubeRestrict: opaDecision.YouTubeRestrict,
+		SearchLog:       opaDecision.SearchLog,
+		SearchConcerns:  opaDecision.SearchConcerns,
+		DecisionID:      e.decisionLog.record("proxy", facts, opaDecision),
+		Egress:          opaDecision.Egress,
+	}
+	for _, limit := range opaDecision.BandwidthLimits {
+		decision.BandwidthLimits = append(decision.BandwidthLimits, BandwidthLimit{
+			ID:     limit.ID,
+			Kbps:   limit.Kbps,
+			Bucket: limit.Bucket,
+		})
+	}
+	if opaDecision.Notice != nil {
+		notice := convertNotice(*opaDecision.Notice)
+		decision.Notice = &notice
+	}
+
+	// If decision is ALLOW (or WARN) and we have a category with usage tracking, record activity
+	if (decision.Action == ActionAllow || decision.Action == ActionWarn) && e.usageTracker != nil && decision.Category != "" {
 		// Get device ID from OPA (we'll need to query OPA for this)
 		// For now, use a composite key of IP+MAC
 		deviceID := e.makeDeviceKey(req.ClientIP, req.ClientMAC)
@@ -135,6 +364,78 @@ func (e *Engine) Evaluate(req *ProxyRequest) *PolicyDecision {
 	return decision
 }
 
+// LookupDevices lists the devices known to policy and identifies the
+// device at clientIP (and clientMAC, if known)
+func (e *Engine) LookupDevices(clientIP net.IP, clientMAC net.HardwareAddr) (*opa.DeviceLookup, error) {
+	clientMACStr := ""
+	if clientMAC != nil {
+		clientMACStr = clientMAC.String()
+	}
+
+	facts := map[string]interface{}{
+		"client_ip":  clientIP.String(),
+		"client_mac": clientMACStr,
+	}
+	e.addUserFacts(facts, clientIP, clientMAC)
+	e.addHostnameFacts(facts, clientIP)
+	e.addDeviceTypeFacts(facts, clientIP, clientMAC)
+	e.addRuntimeDeviceFacts(facts)
+
+	return e.opaEngine.LookupDevices(context.Background(), facts)
+}
+
+// Status reports the profile of the device at clientIP (and clientMAC, if
+// known) and the notices due for it, most urgent first
+func (e *Engine) Status(clientIP net.IP, clientMAC net.HardwareAddr) (*DeviceStatus, error) {
+	clientMACStr := ""
+	if clientMAC != nil {
+		clientMACStr = clientMAC.String()
+	}
+
+	facts := map[string]interface{}{
+		"client_ip":   clientIP.String(),
+		"client_mac":  clientMACStr,
+		"time":        e.timeFacts(),
+		"usage":       e.gatherUsageFacts(clientIP, clientMAC),
+		"server_name": e.serverName,
+	}
+	e.addUserFacts(facts, clientIP, clientMAC)
+	e.addHostnameFacts(facts, clientIP)
+	e.addDeviceTypeFacts(facts, clientIP, clientMAC)
+	e.addRuntimeDeviceFacts(facts)
+
+	opaStatus, err := e.opaEngine.EvaluateStatus(context.Background(), facts)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &DeviceStatus{Profile: opaStatus.Profile}
+	for _, notice := range opaStatus.Notices {
+		status.Notices = append(status.Notices, convertNotice(notice))
+	}
+	return status, nil
+}
+
+// convertNotice converts an OPA notice
+func convertNotice(n opa.Notice) Notice {
+	return Notice{
+		Kind:      n.Kind,
+		Category:  n.Category,
+		Remaining: time.Duration(n.MinutesRemaining) * time.Minute,
+		Threshold: n.Threshold,
+	}
+}
+
+// ProfileSchedule projects a profile's rules, time restrictions and usage
+// limits onto a weekly 7x24 grid of effective actions per category
+func (e *Engine) ProfileSchedule(profileID string) (*opa.Schedule, error) {
+	facts := map[string]interface{}{"profile": profileID}
+	if e.ruleSetSource != nil {
+		facts["rule_sets"] = e.ruleSetSource.PolicyRuleSets()
+	}
+	return e.opaEngine.EvaluateSchedule(context.Background(), facts)
+}
+
 // buildDNSFacts gathers facts for DNS evaluation
 func (e *Engine) buildDNSFacts(clientIP net.IP, clientMAC net.HardwareAddr, domain string) map[string]interface{} {
 	clientMACStr := ""
@@ -142,12 +443,20 @@ func (e *Engine) buildDNSFacts(clientIP net.IP, clientMAC net.HardwareAddr, doma
 		clientMACStr = clientMAC.String()
 	}
 
-	return map[string]interface{}{
+	facts := map[string]interface{}{
 		"client_ip":   clientIP.String(),
 		"client_mac":  clientMACStr,
 		"domain":      domain,
 		"server_name": e.serverName,
 	}
+	e.addUserFacts(facts, clientIP, clientMAC)
+	e.addHostnameFacts(facts, clientIP)
+	e.addDeviceTypeFacts(facts, clientIP, clientMAC)
+	e.addRuntimeRuleFacts(facts)
+	e.addRuntimeDeviceFacts(facts)
+	e.addPauseFacts(facts)
+
+	return facts
 }
 
 // buildProxyFacts gathers facts for proxy request evaluation
@@ -157,27 +466,148 @@ func (e *Engine) buildProxyFacts(req *ProxyRequest) map[string]interface{} {
 		clientMACStr = req.ClientMAC.String()
 	}
 
-	// Get current time info from clock
-	now := e.clock.Now()
-	currentTime := map[string]interface{}{
-		"day_of_week": int(now.Weekday()),
-		"hour":        now.Hour(),
-		"minute":      now.Minute(),
-	}
-
 	// Gather usage facts from database
 	usageFacts := e.gatherUsageFacts(req.ClientIP, req.ClientMAC)
 
-	return map[string]interface{}{
+	facts := map[string]interface{}{
 		"client_ip":   req.ClientIP.String(),
 		"client_mac":  clientMACStr,
 		"host":        req.Host,
 		"path":        req.Path,
 		"method":      req.Method,
-		"time":        currentTime,
+		"time":        e.timeFacts(),
 		"usage":       usageFacts,
 		"server_name": e.serverName,
 	}
+	if req.DirectIP {
+		serverNames := req.ServerNames
+		if serverNames == nil {
+			serverNames = []string{}
+		}
+		facts["direct_ip"] = true
+		facts["server_names"] = serverNames
+	}
+	facts["query"] = queryFacts(req.Query)
+	facts["headers"] = headerFacts(req.Headers)
+	if req.SearchQuery != "" {
+		facts["search"] = map[string]interface{}{
+			"engine": req.SearchEngine,
+			"query":  req.SearchQuery,
+		}
+	}
+	if e.streamSource != nil {
+		facts["streams"] = e.streamSource.PolicyStreams()
+	}
+	e.addUserFacts(facts, req.ClientIP, req.ClientMAC)
+	e.addHostnameFacts(facts, req.ClientIP)
+	e.addDeviceTypeFacts(facts, req.ClientIP, req.ClientMAC)
+	e.addRuntimeRuleFacts(facts)
+	e.addRuntimeDeviceFacts(facts)
+	e.addPauseFacts(facts)
+
+	return facts
+}
+
+// timeFacts gives the current time from the clock
+func (e *Engine) timeFacts() map[string]interface{} {
+	now := e.clock.Now()
+	return map[string]interface{}{
+		"day_of_week": int(now.Weekday()),
+		"hour":        now.Hour(),
+		"minute":      now.Minute(),
+	}
+}
+
+// queryFacts converts query parameters for OPA: name -> list of values
+func queryFacts(query map[string][]string) map[string]interface{} {
+	facts := make(map[string]interface{}, len(query))
+	for name, values := range query {
+		list := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			list = append(list, value)
+		}
+		facts[name] = list
+	}
+	return facts
+}
+
+// headerFacts converts the selected request headers for OPA
+func headerFacts(headers map[string]string) map[string]interface{} {
+	facts := make(map[string]interface{}, len(headers))
+	for name, value := range headers {
+		facts[name] = value
+	}
+	return facts
+}
+
+// addUserFacts adds the logged-in user, if known, as input.user
+func (e *Engine) addUserFacts(facts map[string]interface{}, clientIP net.IP, clientMAC net.HardwareAddr) {
+	if e.userResolver == nil {
+		return
+	}
+	name, source, ok := e.userResolver.LookupUser(clientIP, clientMAC)
+	if !ok {
+		return
+	}
+	facts["user"] = map[string]interface{}{
+		"name":   name,
+		"source": source,
+	}
+}
+
+// addHostnameFacts adds the client's announced hostname, if known, as
+// input.client_hostname
+func (e *Engine) addHostnameFacts(facts map[string]interface{}, clientIP net.IP) {
+	if e.hostnameSource == nil {
+		return
+	}
+	if name := e.hostnameSource.Lookup(clientIP); name != "" {
+		facts["client_hostname"] = name
+	}
+}
+
+// addDeviceTypeFacts adds the client's guessed device type, if known, as
+// input.device_type
+func (e *Engine) addDeviceTypeFacts(facts map[string]interface{}, clientIP net.IP, clientMAC net.HardwareAddr) {
+	if e.deviceTypeSource == nil {
+		return
+	}
+	if deviceType := e.deviceTypeSource.DeviceType(clientIP, clientMAC); deviceType != "" {
+		facts["device_type"] = deviceType
+	}
+}
+
+// addRuntimeRuleFacts adds rules added at runtime as input.runtime_rules,
+// input.device_rules and input.rule_sets
+func (e *Engine) addRuntimeRuleFacts(facts map[string]interface{}) {
+	if e.ruleSource != nil {
+		facts["runtime_rules"] = e.ruleSource.PolicyRules()
+	}
+	if e.deviceRuleSource != nil {
+		facts["device_rules"] = e.deviceRuleSource.PolicyDeviceRules()
+	}
+	if e.ruleSetSource != nil {
+		facts["rule_sets"] = e.ruleSetSource.PolicyRuleSets()
+	}
+}
+
+// addRuntimeDeviceFacts adds devices added and profiles assigned at runtime
+// as input.runtime_devices and input.device_profiles
+func (e *Engine) addRuntimeDeviceFacts(facts map[string]interface{}) {
+	if e.deviceSource == nil {
+		return
+	}
+	facts["runtime_devices"] = e.deviceSource.PolicyDevices()
+	facts["device_profiles"] = e.deviceSource.PolicyProfiles()
+}
+
+// addPauseFacts adds the devices and profiles paused at runtime as
+// input.paused
+func (e *Engine) addPauseFacts(facts map[string]interface{}) {
+	if e.pauseSource == nil {
+		return
+	}
+	facts["paused"] = e.pauseSource.PolicyPauses()
 }
 
 // gatherUsageFacts queries the database for current usage
@@ -213,7 +643,14 @@ func (e *Engine) gatherUsageFacts(clientIP net.IP, clientMAC net.HardwareAddr) m
 
 // makeDeviceKey creates a composite key for device identification
 // This is temporary - ideally OPA should handle device identification
+// When a user is logged in, usage is tracked against the user instead so
+// that time limits follow the person across shared devices.
 func (e *Engine) makeDeviceKey(clientIP net.IP, clientMAC net.HardwareAddr) string {
+	if e.userResolver != nil {
+		if name, _, ok := e.userResolver.LookupUser(clientIP, clientMAC); ok {
+			return "user:" + name
+		}
+	}
 	if clientMAC != nil {
 		return clientMAC.String()
 	}
@@ -231,5 +668,13 @@ func (e *Engine) Reload() error {
 	}
 
 	e.logger.Info().Msg("OPA policies reloaded successfully")
+
+	// A candidate failing to load doesn't fail the reload of the active
+	// policies; its counts start afresh otherwise
+	if e.canary != nil {
+		if err := e.canary.Reload(); err != nil {
+			e.logger.Error().Err(err).Msg("Failed to reload candidate policies")
+		}
+	}
 	return nil
 }
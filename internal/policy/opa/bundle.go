@@ -0,0 +1,105 @@
+package opa
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/keys"
+)
+
+const (
+	// defaultBundleKeyID names the key when BundleKeyID is unset
+	defaultBundleKeyID = "default"
+
+	// defaultBundleAlgorithm is OPA's default bundle signing algorithm
+	defaultBundleAlgorithm = "RS256"
+)
+
+// loadPoliciesFromBundle loads the modules of the signed OPA bundle at
+// BundleURL. The bundle must carry a .signatures.json verifying against
+// the key in BundleKeyFile, and every file in it must match its signed hash.
+func (e *Engine) loadPoliciesFromBundle() error {
+	verification, err := e.bundleVerificationConfig()
+	if err != nil {
+		return err
+	}
+
+	e.logger.Info().Str("url", e.config.BundleURL).Msg("Loading policy bundle")
+
+	content, err := e.fetchPolicyWithRetry(e.config.BundleURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch bundle from %s: %w", e.config.BundleURL, err)
+	}
+
+	b, err := bundle.NewReader(bytes.NewReader(content)).
+		WithBundleVerificationConfig(verification).
+		WithBundleName(e.config.BundleURL).
+		Read()
+	if err != nil {
+		return fmt.Errorf("failed to read bundle from %s: %w", e.config.BundleURL, err)
+	}
+
+	compiled := len(b.WasmModules) > 0
+	if compiled && e.target() != targetWasm {
+		// opa build -t wasm rewrites the modules around the entrypoints, so
+		// they can't be interpreted
+		return fmt.Errorf("bundle %s is compiled to WASM (opa build -t wasm), which needs the wasm target", e.config.BundleURL)
+	}
+	if len(b.Modules) == 0 && !compiled {
+		return fmt.Errorf("no policy files found in bundle %s", e.config.BundleURL)
+	}
+	if len(b.Data) > 0 {
+		// Configuration lives in config.rego, and the prepared queries
+		// have no store to put data in
+		e.logger.Warn().Str("url", e.config.BundleURL).Msg("Ignoring data files in policy bundle")
+	}
+
+	for _, file := range b.Modules {
+		e.modules[e.config.BundleURL+"#"+file.Path] = file.Parsed
+		e.logger.Debug().Str("file", file.Path).Str("package", file.Parsed.Package.Path.String()).Msg("Loaded policy module from bundle")
+	}
+
+	if compiled {
+		// The entrypoints need no compiling here
+		e.wasm = &b
+	}
+
+	e.logger.Info().
+		Str("url", e.config.BundleURL).
+		Str("revision", b.Manifest.Revision).
+		Int("modules", len(b.Modules)).
+		Bool("wasm", compiled).
+		Msg("Loaded policy bundle")
+
+	return nil
+}
+
+// bundleVerificationConfig builds the verification of bundle signatures
+// from the key in BundleKeyFile, read on every load so a rotated key is
+// picked up by a reload. Naming the key ID makes the signatures required.
+func (e *Engine) bundleVerificationConfig() (*bundle.VerificationConfig, error) {
+	key, err := os.ReadFile(e.config.BundleKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle key: %w", err)
+	}
+
+	alg := e.config.BundleAlgorithm
+	if alg == "" {
+		alg = defaultBundleAlgorithm
+	}
+	if !keys.IsSupportedAlgorithm(alg) {
+		return nil, fmt.Errorf("unsupported bundle signing algorithm: %s", alg)
+	}
+
+	keyID := e.config.BundleKeyID
+	if keyID == "" {
+		keyID = defaultBundleKeyID
+	}
+
+	publicKeys := map[string]*keys.Config{
+		keyID: {Key: string(key), Algorithm: alg},
+	}
+	return bundle.NewVerificationConfig(publicKeys, keyID, "", nil), nil
+}
@@ -0,0 +1,124 @@
+package opa
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/rs/zerolog"
+)
+
+// policyBundle builds a bundle of the shipped policies (without tests)
+func policyBundle(t *testing.T, revision string) bundle.Bundle {
+	t.Helper()
+
+	files, err := filepath.Glob("../../../policies/*.rego")
+	if err != nil || len(files) == 0 {
+		t.Skipf("Skipping bundle test - policies not available: %v", err)
+	}
+	b := bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: revision},
+		Data:     map[string]interface{}{},
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.rego") {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := "/policies/" + filepath.Base(file)
+		b.Modules = append(b.Modules, bundle.ModuleFile{URL: path, Path: path, Raw: content})
+	}
+	return b
+}
+
+// sign signs b with secret under keyID
+func sign(t *testing.T, b bundle.Bundle, keyID, secret string) bundle.Bundle {
+	t.Helper()
+	if err := b.GenerateSignature(bundle.NewSigningConfig(secret, "HS256", ""), keyID, false); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// tarball writes b as a bundle tarball
+func tarball(t *testing.T, b bundle.Bundle) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := bundle.NewWriter(&buf).Write(b); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBundleSource(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "bundle.key")
+	if err := os.WriteFile(keyFile, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var served []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(served)
+	}))
+	defer server.Close()
+
+	config := Config{
+		Source:          "bundle",
+		BundleURL:       server.URL + "/bundle.tar.gz",
+		BundleKeyFile:   keyFile,
+		BundleKeyID:     "kproxy",
+		BundleAlgorithm: "HS256",
+		HTTPRetries:     1,
+	}
+
+	// A bundle signed with the key loads and evaluates
+	served = tarball(t, sign(t, policyBundle(t, "v1"), "kproxy", "secret"))
+	engine, err := NewEngine(config, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	if _, err := engine.EvaluateSchedule(context.Background(), map[string]interface{}{"profile": "default"}); err != nil {
+		t.Errorf("EvaluateSchedule failed: %v", err)
+	}
+
+	tampered := sign(t, policyBundle(t, "v2"), "kproxy", "secret")
+	tampered.Modules[0].Raw = append(tampered.Modules[0].Raw, []byte("\n# changed\n")...)
+
+	// Bundles that aren't signed with the key, or changed since, don't load
+	tests := []struct {
+		name   string
+		bundle []byte
+	}{
+		{"unsigned", tarball(t, policyBundle(t, "v2"))},
+		{"other secret", tarball(t, sign(t, policyBundle(t, "v2"), "kproxy", "guessed"))},
+		{"tampered", tarball(t, tampered)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served = tt.bundle
+			if _, err := NewEngine(config, zerolog.Nop()); err == nil {
+				t.Error("NewEngine loaded the bundle")
+			}
+			// A reload keeps the verified policies
+			if err := engine.Reload(); err == nil {
+				t.Error("Reload loaded the bundle")
+			}
+		})
+	}
+
+	// Signatures are required
+	unsigned := config
+	unsigned.BundleKeyFile = ""
+	if _, err := NewEngine(unsigned, zerolog.Nop()); err == nil {
+		t.Error("NewEngine accepted a bundle source without a key")
+	}
+}
@@ -3,6 +3,7 @@ package opa
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,32 +14,57 @@ import (
 	"time"
 
 	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/bundle"
 	"github.com/open-policy-agent/opa/v1/rego"
 	"github.com/rs/zerolog"
 )
 
 // Config holds OPA engine configuration
 type Config struct {
-	Source      string        // "filesystem" or "remote"
+	Source      string        // "filesystem", "remote", "both" or "bundle"
 	PolicyDir   string        // Directory for filesystem source
 	PolicyURLs  []string      // URLs for remote source
 	HTTPTimeout time.Duration // Timeout for HTTP requests
 	HTTPRetries int           // Number of retries for failed requests
+
+	// Signed OPA bundle for bundle source
+	BundleURL       string // Bundle tarball (.tar.gz) URL
+	BundleKeyFile   string // PEM public key (or HMAC secret) verifying its signatures
+	BundleKeyID     string // Key ID the bundle is signed with (default "default")
+	BundleAlgorithm string // Signing algorithm (default RS256)
+
+	// Evaluation backend: "rego" (the interpreter, default) or "wasm"
+	// (policies compiled to WebAssembly, in builds tagged opa_wasm)
+	Target string
 }
 
+// Evaluation targets
+const (
+	targetRego = "rego"
+	targetWasm = "wasm"
+)
+
 // Engine wraps OPA rego engine for policy evaluation
 type Engine struct {
 	config Config
 	logger zerolog.Logger
 
 	// Compiled queries (protected by mu)
-	mu         sync.RWMutex
-	dnsQuery   rego.PreparedEvalQuery
-	proxyQuery rego.PreparedEvalQuery
+	mu            sync.RWMutex
+	dnsQuery      rego.PreparedEvalQuery
+	cnameQuery    rego.PreparedEvalQuery
+	proxyQuery    rego.PreparedEvalQuery
+	scheduleQuery rego.PreparedEvalQuery
+	deviceQuery   rego.PreparedEvalQuery
+	statusQuery   rego.PreparedEvalQuery
 
 	// Policy modules (protected by mu)
 	modules map[string]*ast.Module
 
+	// Bundle with compiled WASM for its entrypoints, evaluated instead of
+	// compiling the modules when the target is wasm (protected by mu)
+	wasm *bundle.Bundle
+
 	// HTTP client for remote loading
 	httpClient *http.Client
 }
@@ -69,13 +95,34 @@ func NewEngine(config Config, logger zerolog.Logger) (*Engine, error) {
 		return nil, fmt.Errorf("failed to prepare DNS query: %w", err)
 	}
 
+	// Prepare CNAME cloaking query
+	if err := e.prepareCNAMEQuery(); err != nil {
+		return nil, fmt.Errorf("failed to prepare CNAME query: %w", err)
+	}
+
 	// Prepare Proxy query
 	if err := e.prepareProxyQuery(); err != nil {
 		return nil, fmt.Errorf("failed to prepare proxy query: %w", err)
 	}
 
+	// Prepare schedule projection query
+	if err := e.prepareScheduleQuery(); err != nil {
+		return nil, fmt.Errorf("failed to prepare schedule query: %w", err)
+	}
+
+	// Prepare device lookup query
+	if err := e.prepareDeviceQuery(); err != nil {
+		return nil, fmt.Errorf("failed to prepare device query: %w", err)
+	}
+
+	// Prepare device status query
+	if err := e.prepareStatusQuery(); err != nil {
+		return nil, fmt.Errorf("failed to prepare status query: %w", err)
+	}
+
 	e.logger.Info().
 		Str("source", config.Source).
+		Str("target", e.target()).
 		Str("policy_dir", config.PolicyDir).
 		Int("policy_urls", len(config.PolicyURLs)).
 		Msg("OPA engine initialized")
@@ -103,8 +150,29 @@ func (e *Engine) validateConfig() error {
 			return fmt.Errorf("policy_dir is required for 'both' source (filesystem is required)")
 		}
 		// Remote URLs are optional in both mode
+	case "bundle":
+		if e.config.BundleURL == "" {
+			return fmt.Errorf("bundle_url is required for bundle source")
+		}
+		if !strings.HasPrefix(e.config.BundleURL, "http://") && !strings.HasPrefix(e.config.BundleURL, "https://") {
+			return fmt.Errorf("invalid bundle URL (must be http:// or https://): %s", e.config.BundleURL)
+		}
+		// Only signed bundles are loaded
+		if e.config.BundleKeyFile == "" {
+			return fmt.Errorf("bundle_key_file is required for bundle source")
+		}
+	default:
+		return fmt.Errorf("invalid policy source: %s (must be 'filesystem', 'remote', 'both', or 'bundle')", e.config.Source)
+	}
+
+	switch e.target() {
+	case targetRego:
+	case targetWasm:
+		if !wasmAvailable {
+			return fmt.Errorf("the wasm target is not available in this build (build with CGO_ENABLED=1 and -tags opa_wasm)")
+		}
 	default:
-		return fmt.Errorf("invalid policy source: %s (must be 'filesystem', 'remote', or 'both')", e.config.Source)
+		return fmt.Errorf("invalid policy target: %s (must be 'rego' or 'wasm')", e.config.Target)
 	}
 
 	// Validate URLs if provided
@@ -126,6 +194,8 @@ func (e *Engine) loadPolicies() error {
 		return e.loadPoliciesFromFilesystem()
 	case "remote":
 		return e.loadPoliciesFromRemote()
+	case "bundle":
+		return e.loadPoliciesFromBundle()
 	case "both":
 		// Load from both sources - filesystem is required, remote is optional
 		// Filesystem is local storage and should be reliable
@@ -322,6 +392,29 @@ func (e *Engine) prepareDNSQuery() error {
 	return nil
 }
 
+// prepareCNAMEQuery prepares the CNAME cloaking query
+func (e *Engine) prepareCNAMEQuery() error {
+	ctx := context.Background()
+
+	// Build rego options: query + modules
+	opts := []func(*rego.Rego){rego.Query("data.kproxy.dns.cname_decision")}
+	opts = append(opts, e.withModules()...)
+
+	// Build rego instance with all options
+	r := rego.New(opts...)
+
+	// Prepare the query
+	query, err := r.PrepareForEval(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to prepare CNAME query: %w", err)
+	}
+
+	e.cnameQuery = query
+	e.logger.Debug().Msg("CNAME query prepared")
+
+	return nil
+}
+
 // prepareProxyQuery prepares the proxy decision query
 func (e *Engine) prepareProxyQuery() error {
 	ctx := context.Background()
@@ -345,30 +438,140 @@ func (e *Engine) prepareProxyQuery() error {
 	return nil
 }
 
-// withModules returns rego options for all loaded modules
+// prepareScheduleQuery prepares the weekly schedule projection query
+func (e *Engine) prepareScheduleQuery() error {
+	ctx := context.Background()
+
+	// Build rego options: query + modules
+	opts := []func(*rego.Rego){rego.Query("data.kproxy.schedule.matrix")}
+	opts = append(opts, e.withModules()...)
+
+	// Build rego instance with all options
+	r := rego.New(opts...)
+
+	// Prepare the query
+	query, err := r.PrepareForEval(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to prepare schedule query: %w", err)
+	}
+
+	e.scheduleQuery = query
+	e.logger.Debug().Msg("Schedule query prepared")
+
+	return nil
+}
+
+// prepareDeviceQuery prepares the device lookup query
+func (e *Engine) prepareDeviceQuery() error {
+	ctx := context.Background()
+
+	// Build rego options: query + modules
+	opts := []func(*rego.Rego){rego.Query("data.kproxy.device.lookup")}
+	opts = append(opts, e.withModules()...)
+
+	// Build rego instance with all options
+	r := rego.New(opts...)
+
+	// Prepare the query
+	query, err := r.PrepareForEval(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to prepare device query: %w", err)
+	}
+
+	e.deviceQuery = query
+	e.logger.Debug().Msg("Device query prepared")
+
+	return nil
+}
+
+// prepareStatusQuery prepares the device status query
+func (e *Engine) prepareStatusQuery() error {
+	ctx := context.Background()
+
+	// Build rego options: query + modules
+	opts := []func(*rego.Rego){rego.Query("data.kproxy.proxy.status")}
+	opts = append(opts, e.withModules()...)
+
+	// Build rego instance with all options
+	r := rego.New(opts...)
+
+	// Prepare the query
+	query, err := r.PrepareForEval(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to prepare status query: %w", err)
+	}
+
+	e.statusQuery = query
+	e.logger.Debug().Msg("Status query prepared")
+
+	return nil
+}
+
+// withModules returns rego options for all loaded modules, evaluated by
+// the configured target
 func (e *Engine) withModules() []func(*rego.Rego) {
-	opts := make([]func(*rego.Rego), 0, len(e.modules))
+	if e.wasm != nil {
+		// Queries for the bundle's entrypoints are answered by its WASM
+		return []func(*rego.Rego){rego.ParsedBundle(e.config.BundleURL, e.wasm)}
+	}
+
+	opts := make([]func(*rego.Rego), 0, len(e.modules)+1)
 	for name, module := range e.modules {
 		opts = append(opts, rego.Module(name, module.String()))
 	}
+	if e.target() == targetWasm {
+		opts = append(opts, rego.Target(targetWasm))
+	}
 	return opts
 }
 
+// target returns the evaluation backend
+func (e *Engine) target() string {
+	if e.config.Target == "" {
+		return targetRego
+	}
+	return strings.ToLower(e.config.Target)
+}
+
 // DNSDecision represents a DNS policy decision
 type DNSDecision struct {
-	Action string `json:"action"`
-	Reason string `json:"reason"`
+	Action       string `json:"action"`
+	Reason       string `json:"reason"`
+	DNSSECStrict bool   `json:"dnssec_strict"`
+	SafeSearch   bool   `json:"safesearch"`
+
+	// How the query is answered if it is blocked, and the sinkhole addresses
+	// for "custom-ip"
+	BlockResponse string `json:"block_response"`
+	BlockIP       string `json:"block_ip"`
+	BlockIPv6     string `json:"block_ipv6"`
 }
 
 // EvaluateDNS evaluates DNS action for a query
 func (e *Engine) EvaluateDNS(ctx context.Context, input map[string]interface{}) (*DNSDecision, error) {
-	startTime := time.Now()
-
 	// Acquire read lock to safely access prepared query
 	e.mu.RLock()
 	dnsQuery := e.dnsQuery
 	e.mu.RUnlock()
 
+	return e.evaluateDNSDecision(ctx, dnsQuery, input)
+}
+
+// EvaluateCNAME evaluates the CNAME chain of a bypassed query. The input is
+// the DNS input plus "cname_chain", the CNAME targets in resolution order.
+func (e *Engine) EvaluateCNAME(ctx context.Context, input map[string]interface{}) (*DNSDecision, error) {
+	// Acquire read lock to safely access prepared query
+	e.mu.RLock()
+	cnameQuery := e.cnameQuery
+	e.mu.RUnlock()
+
+	return e.evaluateDNSDecision(ctx, cnameQuery, input)
+}
+
+// evaluateDNSDecision evaluates a query that returns a DNS decision object
+func (e *Engine) evaluateDNSDecision(ctx context.Context, dnsQuery rego.PreparedEvalQuery, input map[string]interface{}) (*DNSDecision, error) {
+	startTime := time.Now()
+
 	// Evaluate the query
 	results, err := dnsQuery.Eval(ctx, rego.EvalInput(input))
 	if err != nil {
@@ -405,19 +608,66 @@ func (e *Engine) EvaluateDNS(ctx context.Context, input map[string]interface{})
 		decision.Reason = reason
 	}
 
+	if strict, ok := decisionMap["dnssec_strict"].(bool); ok {
+		decision.DNSSECStrict = strict
+	}
+
+	if safe, ok := decisionMap["safesearch"].(bool); ok {
+		decision.SafeSearch = safe
+	}
+
+	if mode, ok := decisionMap["block_response"].(string); ok {
+		decision.BlockResponse = mode
+	}
+	if ip, ok := decisionMap["block_ip"].(string); ok {
+		decision.BlockIP = ip
+	}
+	if ip, ok := decisionMap["block_ipv6"].(string); ok {
+		decision.BlockIPv6 = ip
+	}
+
 	return decision, nil
 }
 
 // ProxyDecision represents a proxy policy decision
 type ProxyDecision struct {
-	Action               string `json:"action"`
-	Reason               string `json:"reason"`
-	BlockPage            string `json:"block_page"`
-	MatchedRuleID        string `json:"matched_rule_id"`
-	Category             string `json:"category"`
-	InjectTimer          bool   `json:"inject_timer"`
-	TimeRemainingMinutes int    `json:"time_remaining_minutes"`
-	UsageLimitID         string `json:"usage_limit_id"`
+	Action               string           `json:"action"`
+	Reason               string           `json:"reason"`
+	BlockPage            string           `json:"block_page"`
+	BlockTemplate        string           `json:"block_template"`
+	MatchedRuleID        string           `json:"matched_rule_id"`
+	Category             string           `json:"category"`
+	InjectTimer          bool             `json:"inject_timer"`
+	TimeRemainingMinutes int              `json:"time_remaining_minutes"`
+	UsageLimitID         string           `json:"usage_limit_id"`
+	Script               string           `json:"script"`
+	ThrottleDelayMS      int              `json:"throttle_delay_ms"`
+	ThrottleKbps         int              `json:"throttle_kbps"`
+	Profile              string           `json:"profile"`
+	BandwidthWeight      int              `json:"bandwidth_weight"`
+	StreamDevice         string           `json:"stream_device"`
+	SafeSearch           bool             `json:"safesearch"`
+	YouTubeRestrict      string           `json:"youtube_restrict"`
+	SearchLog            bool             `json:"search_log"`
+	SearchConcerns       []string         `json:"search_concerns"`
+	BandwidthLimits      []BandwidthLimit `json:"bandwidth_limits"`
+	Notice               *Notice          `json:"notice"`
+	Egress               string           `json:"egress"`
+}
+
+// Notice warns a device that its time is running out
+type Notice struct {
+	Kind             string `json:"kind"`     // "bedtime" or "usage_limit"
+	Category         string `json:"category"` // Category of a usage limit
+	MinutesRemaining int    `json:"minutes_remaining"`
+	Threshold        int    `json:"threshold"` // Warning threshold (minutes) reached
+}
+
+// BandwidthLimit is a profile bandwidth limit in force for a request
+type BandwidthLimit struct {
+	ID     string `json:"id"`
+	Kbps   int    `json:"kbps"`
+	Bucket string `json:"bucket"`
 }
 
 // EvaluateProxy evaluates a proxy request
@@ -461,31 +711,188 @@ func (e *Engine) EvaluateProxy(ctx context.Context, input map[string]interface{}
 	return &decision, nil
 }
 
-// Reload reloads all policies
+// Schedule is a profile's weekly schedule projection
+type Schedule struct {
+	Profile    string                `json:"profile"`
+	Categories []string              `json:"categories"`
+	Schedule   map[string][][]string `json:"schedule"` // category -> [day 0-6][hour 0-23] action
+}
+
+// ErrProfileNotFound is returned when a schedule is requested for an unknown profile
+var ErrProfileNotFound = errors.New("profile not found")
+
+// EvaluateSchedule projects a profile's rules and time restrictions onto a
+// weekly grid. The input names the profile (input.profile) and carries the
+// rule sets saved at runtime (input.rule_sets).
+func (e *Engine) EvaluateSchedule(ctx context.Context, input map[string]interface{}) (*Schedule, error) {
+	// Acquire read lock to safely access prepared query
+	e.mu.RLock()
+	scheduleQuery := e.scheduleQuery
+	e.mu.RUnlock()
+
+	results, err := scheduleQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, fmt.Errorf("schedule query evaluation failed: %w", err)
+	}
+
+	// The matrix is undefined for unknown profiles
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return nil, ErrProfileNotFound
+	}
+
+	resultBytes, err := json.Marshal(results[0].Expressions[0].Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+
+	var schedule Schedule
+	if err := json.Unmarshal(resultBytes, &schedule); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schedule: %w", err)
+	}
+
+	return &schedule, nil
+}
+
+// Device is a device known to policy
+type Device struct {
+	Name        string   `json:"name"`
+	Identifiers []string `json:"identifiers"`
+	Profile     string   `json:"profile"`
+	User        string   `json:"user,omitempty"`        // Logged-in user whose profile applies
+	Subnet      string   `json:"subnet,omitempty"`      // Subnet whose default profile applies to an unknown client
+	DeviceType  string   `json:"device_type,omitempty"` // Device type whose default profile applies to an unknown client
+}
+
+// DeviceLookup lists the known devices and the device identified from the
+// input facts
+type DeviceLookup struct {
+	Devices  map[string]Device `json:"devices"`
+	Profiles []string          `json:"profiles"`
+	DeviceID string            `json:"device_id"` // "" if no configured device matched
+	Device   *Device           `json:"device"`    // nil for unknown clients
+}
+
+// LookupDevices lists devices and identifies the client in the input
+// (client_ip, client_mac and the optional user and runtime device facts)
+func (e *Engine) LookupDevices(ctx context.Context, input map[string]interface{}) (*DeviceLookup, error) {
+	// Acquire read lock to safely access prepared query
+	e.mu.RLock()
+	deviceQuery := e.deviceQuery
+	e.mu.RUnlock()
+
+	results, err := deviceQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, fmt.Errorf("device query evaluation failed: %w", err)
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return nil, fmt.Errorf("no results from device query")
+	}
+
+	resultBytes, err := json.Marshal(results[0].Expressions[0].Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal device lookup: %w", err)
+	}
+
+	var lookup DeviceLookup
+	if err := json.Unmarshal(resultBytes, &lookup); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device lookup: %w", err)
+	}
+
+	return &lookup, nil
+}
+
+// DeviceStatus is the profile of a device and the notices due for it
+type DeviceStatus struct {
+	Profile string   `json:"profile"`
+	Notices []Notice `json:"notices"`
+}
+
+// EvaluateStatus evaluates the status of the device in the input (the
+// device facts, time and usage of a proxy request). Policies without a
+// status rule report no notices.
+func (e *Engine) EvaluateStatus(ctx context.Context, input map[string]interface{}) (*DeviceStatus, error) {
+	// Acquire read lock to safely access prepared query
+	e.mu.RLock()
+	statusQuery := e.statusQuery
+	e.mu.RUnlock()
+
+	results, err := statusQuery.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, fmt.Errorf("status query evaluation failed: %w", err)
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return &DeviceStatus{}, nil
+	}
+
+	resultBytes, err := json.Marshal(results[0].Expressions[0].Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal device status: %w", err)
+	}
+
+	var status DeviceStatus
+	if err := json.Unmarshal(resultBytes, &status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device status: %w", err)
+	}
+
+	return &status, nil
+}
+
+// Reload reloads all policies. The policies are loaded and the queries
+// prepared apart from the engine, which keeps evaluating with the current
+// ones meanwhile; they are swapped in together once all have compiled, so
+// a failed reload (a half-saved file, say) leaves the engine as it was.
 func (e *Engine) Reload() error {
 	e.logger.Info().Msg("Reloading OPA policies")
 
-	// Acquire write lock to safely modify engine state
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	// Clear existing modules
-	e.modules = make(map[string]*ast.Module)
+	next := &Engine{
+		config:     e.config,
+		logger:     e.logger,
+		modules:    make(map[string]*ast.Module),
+		httpClient: e.httpClient,
+	}
 
 	// Reload policies
-	if err := e.loadPolicies(); err != nil {
+	if err := next.loadPolicies(); err != nil {
 		return fmt.Errorf("failed to reload policies: %w", err)
 	}
 
 	// Re-prepare queries
-	if err := e.prepareDNSQuery(); err != nil {
+	if err := next.prepareDNSQuery(); err != nil {
 		return fmt.Errorf("failed to re-prepare DNS query: %w", err)
 	}
 
-	if err := e.prepareProxyQuery(); err != nil {
+	if err := next.prepareCNAMEQuery(); err != nil {
+		return fmt.Errorf("failed to re-prepare CNAME query: %w", err)
+	}
+
+	if err := next.prepareProxyQuery(); err != nil {
 		return fmt.Errorf("failed to re-prepare proxy query: %w", err)
 	}
 
+	if err := next.prepareScheduleQuery(); err != nil {
+		return fmt.Errorf("failed to re-prepare schedule query: %w", err)
+	}
+
+	if err := next.prepareDeviceQuery(); err != nil {
+		return fmt.Errorf("failed to re-prepare device query: %w", err)
+	}
+
+	if err := next.prepareStatusQuery(); err != nil {
+		return fmt.Errorf("failed to re-prepare status query: %w", err)
+	}
+
+	// Acquire write lock to swap in the new policies
+	e.mu.Lock()
+	e.modules = next.modules
+	e.wasm = next.wasm
+	e.dnsQuery = next.dnsQuery
+	e.cnameQuery = next.cnameQuery
+	e.proxyQuery = next.proxyQuery
+	e.scheduleQuery = next.scheduleQuery
+	e.deviceQuery = next.deviceQuery
+	e.statusQuery = next.statusQuery
+	e.mu.Unlock()
+
 	e.logger.Info().Msg("OPA policies reloaded successfully")
 
 	return nil
@@ -2,6 +2,10 @@ package opa
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -118,3 +122,192 @@ func TestReloadWithoutPolicies(t *testing.T) {
 		t.Error("Expected error when creating engine with invalid policy dir")
 	}
 }
+
+// TestReloadKeepsPoliciesOnError tests that a failed reload leaves the
+// current policies in place
+func TestReloadKeepsPoliciesOnError(t *testing.T) {
+	dir := t.TempDir()
+	files, err := filepath.Glob("../../../policies/*.rego")
+	if err != nil || len(files) == 0 {
+		t.Skipf("Skipping reload test - policies not available: %v", err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.rego") {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(file)), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	engine, err := NewEngine(Config{Source: "filesystem", PolicyDir: dir}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	// A half-saved file doesn't compile
+	if err := os.WriteFile(filepath.Join(dir, "schedule.rego"), []byte("package kproxy.schedule\n\nmatrix := {"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Reload(); err == nil {
+		t.Fatal("Reload succeeded with a broken policy")
+	}
+
+	schedule, err := engine.EvaluateSchedule(context.Background(), map[string]interface{}{"profile": "default"})
+	if err != nil {
+		t.Fatalf("EvaluateSchedule after failed reload: %v", err)
+	}
+	if len(schedule.Schedule["default"]) != 7 {
+		t.Errorf("schedule = %+v, want the previous policies' grid", schedule.Schedule)
+	}
+}
+
+// TestEvaluateSchedule tests the schedule projection against the shipped policies
+func TestEvaluateSchedule(t *testing.T) {
+	config := Config{
+		Source:    "filesystem",
+		PolicyDir: "../../../policies",
+	}
+
+	engine, err := NewEngine(config, zerolog.Nop())
+	if err != nil {
+		t.Skipf("Skipping schedule test - policies not available: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+
+	// The shipped default profile blocks everything
+	schedule, err := engine.EvaluateSchedule(ctx, map[string]interface{}{"profile": "default"})
+	if err != nil {
+		t.Fatalf("EvaluateSchedule failed: %v", err)
+	}
+	grid := schedule.Schedule["default"]
+	if len(grid) != 7 || len(grid[0]) != 24 {
+		t.Fatalf("Expected 7x24 grid, got %dx%d", len(grid), len(grid[0]))
+	}
+	if grid[3][12] != "BLOCK" {
+		t.Errorf("Expected BLOCK, got %s", grid[3][12])
+	}
+
+	if _, err := engine.EvaluateSchedule(ctx, map[string]interface{}{"profile": "missing"}); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("Expected ErrProfileNotFound, got %v", err)
+	}
+}
+
+// TestLookupDevices tests device lookup against the shipped policies
+func TestLookupDevices(t *testing.T) {
+	config := Config{
+		Source:    "filesystem",
+		PolicyDir: "../../../policies",
+	}
+
+	engine, err := NewEngine(config, zerolog.Nop())
+	if err != nil {
+		t.Skipf("Skipping device test - policies not available: %v", err)
+		return
+	}
+
+	lookup, err := engine.LookupDevices(context.Background(), map[string]interface{}{
+		"client_ip":  "192.168.5.37",
+		"client_mac": "",
+		"runtime_devices": map[string]interface{}{
+			"sams-ipad": map[string]interface{}{
+				"name":        "Sam's iPad",
+				"identifiers": []interface{}{"192.168.5.37"},
+				"profile":     "default",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("LookupDevices failed: %v", err)
+	}
+	if lookup.DeviceID != "sams-ipad" || lookup.Device == nil || lookup.Device.Name != "Sam's iPad" {
+		t.Errorf("identified %q %+v, want sams-ipad", lookup.DeviceID, lookup.Device)
+	}
+	if _, ok := lookup.Devices["sams-ipad"]; !ok {
+		t.Errorf("devices = %+v, want sams-ipad listed", lookup.Devices)
+	}
+
+	lookup, err = engine.LookupDevices(context.Background(), map[string]interface{}{"client_ip": "203.0.113.1", "client_mac": ""})
+	if err != nil {
+		t.Fatalf("LookupDevices failed: %v", err)
+	}
+	if lookup.DeviceID != "" || lookup.Device != nil {
+		t.Errorf("unknown client identified as %q %+v", lookup.DeviceID, lookup.Device)
+	}
+}
+
+// TestRunTests tests running the policy tests among the loaded policies
+func TestRunTests(t *testing.T) {
+	ctx := context.Background()
+
+	results, err := RunTests(ctx, Config{Source: "filesystem", PolicyDir: "../../../policies"}, "", zerolog.Nop())
+	if err != nil {
+		t.Skipf("Skipping policy tests - policies not available: %v", err)
+	}
+	if len(results) == 0 {
+		t.Fatal("No policy tests found")
+	}
+	for _, result := range results {
+		if !result.Pass() {
+			t.Errorf("%s", result)
+		}
+	}
+
+	// Failing tests are reported, and the filter selects tests
+	dir := t.TempDir()
+	policy := "package kproxy.example\n\nanswer := 42\n"
+	tests := "package kproxy.example_test\n\nimport data.kproxy.example\n\n" +
+		"test_answer if example.answer == 42\n\ntest_wrong if example.answer == 41\n"
+	if err := os.WriteFile(filepath.Join(dir, "example.rego"), []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "example_test.rego"), []byte(tests), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{Source: "filesystem", PolicyDir: dir}
+
+	results, err = RunTests(ctx, config, "", zerolog.Nop())
+	if err != nil {
+		t.Fatalf("RunTests failed: %v", err)
+	}
+	outcomes := map[string]bool{}
+	for _, result := range results {
+		outcomes[result.Name] = result.Pass()
+	}
+	if len(outcomes) != 2 || !outcomes["test_answer"] || outcomes["test_wrong"] {
+		t.Errorf("outcomes = %v, want test_answer to pass and test_wrong to fail", outcomes)
+	}
+
+	results, err = RunTests(ctx, config, "test_answer", zerolog.Nop())
+	if err != nil || len(results) != 1 {
+		t.Errorf("RunTests filtered = %d results (%v), want 1", len(results), err)
+	}
+
+	// Policies that don't compile are an error
+	if err := os.WriteFile(filepath.Join(dir, "example.rego"), []byte("package kproxy.example\n\nanswer := {"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunTests(ctx, config, "", zerolog.Nop()); err == nil {
+		t.Error("RunTests succeeded with a broken policy")
+	}
+}
+
+// TestTarget tests the evaluation target setting
+func TestTarget(t *testing.T) {
+	config := Config{Source: "filesystem", PolicyDir: "../../../policies", Target: "bytecode"}
+	if _, err := NewEngine(config, zerolog.Nop()); err == nil {
+		t.Error("NewEngine accepted an unknown target")
+	}
+
+	// Builds without the WASM engine refuse the wasm target
+	config.Target = "wasm"
+	if _, err := NewEngine(config, zerolog.Nop()); !wasmAvailable && err == nil {
+		t.Error("NewEngine accepted the wasm target without the WASM engine")
+	}
+}
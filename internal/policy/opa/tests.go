@@ -0,0 +1,52 @@
+package opa
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/tester"
+	"github.com/rs/zerolog"
+)
+
+// testTimeout bounds each policy test, as opa test does
+const testTimeout = 5 * time.Second
+
+// RunTests runs the policy tests (test_ rules, usually in *_test.rego
+// files) among the policies config loads, with the policies they test, as
+// opa test does. filter, if set, is a regular expression selecting tests by
+// package and name. The policies are only loaded, so tests can be run
+// before a reload picks the policies up; policies that don't compile are an
+// error.
+func RunTests(ctx context.Context, config Config, filter string, logger zerolog.Logger) ([]*tester.Result, error) {
+	e := &Engine{
+		config:     config,
+		logger:     logger.With().Str("component", "opa").Logger(),
+		modules:    make(map[string]*ast.Module),
+		httpClient: &http.Client{Timeout: config.HTTPTimeout},
+	}
+	if err := e.validateConfig(); err != nil {
+		return nil, fmt.Errorf("invalid OPA configuration: %w", err)
+	}
+	if err := e.loadPolicies(); err != nil {
+		return nil, fmt.Errorf("failed to load policies: %w", err)
+	}
+
+	ch, err := tester.NewRunner().
+		SetModules(e.modules).
+		SetTimeout(testTimeout).
+		CapturePrintOutput(true).
+		Filter(filter).
+		RunTests(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*tester.Result
+	for result := range ch {
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,10 @@
+//go:build opa_wasm
+
+package opa
+
+// The WASM engine (wasmtime, through cgo) evaluates policies compiled to
+// WebAssembly for the wasm target
+import _ "github.com/open-policy-agent/opa/v1/features/wasm"
+
+// wasmAvailable reports whether this build can evaluate the wasm target
+const wasmAvailable = true
@@ -0,0 +1,8 @@
+//go:build !opa_wasm
+
+package opa
+
+// wasmAvailable reports whether this build can evaluate the wasm target.
+// The WASM engine needs cgo and wasmtime, which release builds (pure Go,
+// with 32-bit ARM among the targets) can't include.
+const wasmAvailable = false
@@ -0,0 +1,145 @@
+//go:build opa_wasm
+
+package opa
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/compile"
+	"github.com/rs/zerolog"
+)
+
+// wasmInputs are DNS and schedule inputs evaluated by both targets
+var wasmInputs = []map[string]interface{}{
+	{"client_ip": "192.168.1.10", "client_mac": "", "domain": "www.example.com", "time": map[string]interface{}{"day_of_week": 1, "hour": 10, "minute": 0}, "profile": "default"},
+	{"client_ip": "10.0.0.5", "client_mac": "aa:bb:cc:dd:ee:ff", "domain": "local.kproxy", "time": map[string]interface{}{"day_of_week": 6, "hour": 23, "minute": 30}, "profile": "default"},
+}
+
+// assertSameDecisions checks that engine decides as the rego interpreter does
+func assertSameDecisions(t *testing.T, engine, interpreter *Engine) {
+	t.Helper()
+	ctx := context.Background()
+	for _, input := range wasmInputs {
+		want, err := interpreter.EvaluateDNS(ctx, input)
+		if err != nil {
+			t.Fatalf("EvaluateDNS (rego) failed: %v", err)
+		}
+		got, err := engine.EvaluateDNS(ctx, input)
+		if err != nil {
+			t.Fatalf("EvaluateDNS (wasm) failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("DNS decision for %v = %+v, want %+v", input["domain"], got, want)
+		}
+
+		wantSchedule, err := interpreter.EvaluateSchedule(ctx, input)
+		if err != nil {
+			t.Fatalf("EvaluateSchedule (rego) failed: %v", err)
+		}
+		gotSchedule, err := engine.EvaluateSchedule(ctx, input)
+		if err != nil {
+			t.Fatalf("EvaluateSchedule (wasm) failed: %v", err)
+		}
+		if !reflect.DeepEqual(gotSchedule, wantSchedule) {
+			t.Errorf("Schedule = %+v, want %+v", gotSchedule, wantSchedule)
+		}
+	}
+}
+
+func TestWasmTarget(t *testing.T) {
+	interpreter, err := NewEngine(Config{Source: "filesystem", PolicyDir: "../../../policies"}, zerolog.Nop())
+	if err != nil {
+		t.Skipf("Skipping WASM test - policies not available: %v", err)
+	}
+
+	// Policies from the directory are compiled to WASM when loaded
+	engine, err := NewEngine(Config{Source: "filesystem", PolicyDir: "../../../policies", Target: "wasm"}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	assertSameDecisions(t, engine, interpreter)
+	if err := engine.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	assertSameDecisions(t, engine, interpreter)
+}
+
+func TestWasmBundle(t *testing.T) {
+	interpreter, err := NewEngine(Config{Source: "filesystem", PolicyDir: "../../../policies"}, zerolog.Nop())
+	if err != nil {
+		t.Skipf("Skipping WASM test - policies not available: %v", err)
+	}
+
+	// Build the policies as opa build -t wasm does
+	dir := t.TempDir()
+	files, _ := filepath.Glob("../../../policies/*.rego")
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.rego") {
+			continue
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(file)), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var built bytes.Buffer
+	compiler := compile.New().
+		WithTarget("wasm").
+		WithPaths(dir).
+		WithEntrypoints("kproxy/dns/decision", "kproxy/dns/cname_decision", "kproxy/proxy/decision",
+			"kproxy/schedule/matrix", "kproxy/device/lookup", "kproxy/proxy/status").
+		WithOutput(&built)
+	if err := compiler.Build(context.Background()); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	b, err := bundle.NewReader(&built).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := tarball(t, sign(t, b, "kproxy", "secret"))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(served)
+	}))
+	defer server.Close()
+	keyFile := filepath.Join(t.TempDir(), "bundle.key")
+	if err := os.WriteFile(keyFile, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{
+		Source:          "bundle",
+		BundleURL:       server.URL + "/bundle.tar.gz",
+		BundleKeyFile:   keyFile,
+		BundleKeyID:     "kproxy",
+		BundleAlgorithm: "HS256",
+		HTTPRetries:     1,
+		Target:          "wasm",
+	}
+	engine, err := NewEngine(config, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	if engine.wasm == nil {
+		t.Fatal("Expected the bundle's compiled WASM to be evaluated")
+	}
+	assertSameDecisions(t, engine, interpreter)
+
+	// The rego target can't interpret a bundle built for WASM
+	config.Target = "rego"
+	if _, err := NewEngine(config, zerolog.Nop()); err == nil {
+		t.Error("NewEngine loaded a WASM bundle with the rego target")
+	}
+}
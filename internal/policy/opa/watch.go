@@ -0,0 +1,159 @@
+package opa
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+)
+
+// settleDelay is how long the watcher waits after a filesystem event before
+// comparing the policies, so the writes of one save (editors commonly write
+// a temporary file and rename it) lead to one reload
+const settleDelay = 100 * time.Millisecond
+
+// Watcher reloads filesystem policies when their content changes. It
+// watches the directory for filesystem events and compares a checksum of
+// the policy files after each burst of them, so editing a .rego file is
+// picked up within moments. The checksum is also compared every interval,
+// which covers filesystems without change notifications and Kubernetes
+// ConfigMap updates, which replace a symlinked directory under the mount
+// rather than writing the files.
+type Watcher struct {
+	dir      string
+	interval time.Duration
+	reload   func()
+	logger   zerolog.Logger
+	stopChan chan struct{}
+}
+
+// NewWatcher creates a watcher that calls reload when the .rego files in dir
+// change, checking on filesystem events and every interval
+func NewWatcher(dir string, interval time.Duration, reload func(), logger zerolog.Logger) *Watcher {
+	return &Watcher{
+		dir:      dir,
+		interval: interval,
+		reload:   reload,
+		logger:   logger.With().Str("component", "policy-watcher").Logger(),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start begins watching, from the policies currently on disk. Without
+// filesystem events (inotify watches used up, say) it only checks every
+// interval.
+func (w *Watcher) Start() error {
+	sum, err := policyChecksum(w.dir)
+	if err != nil {
+		return err
+	}
+
+	events, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = events.Add(w.dir); err != nil {
+			_ = events.Close()
+		}
+	}
+	if err != nil {
+		w.logger.Warn().Err(err).Msg("Failed to watch policy directory, checking every interval only")
+		events = nil
+	}
+	go w.run(sum, events)
+
+	w.logger.Info().
+		Str("dir", w.dir).
+		Dur("interval", w.interval).
+		Bool("events", events != nil).
+		Msg("Watching policies for changes")
+	return nil
+}
+
+// Stop stops watching
+func (w *Watcher) Stop() {
+	close(w.stopChan)
+}
+
+// run checks the policies after filesystem events and every interval until
+// stopped
+func (w *Watcher) run(sum string, events *fsnotify.Watcher) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	var changes <-chan fsnotify.Event
+	var errs <-chan error
+	if events != nil {
+		defer func() { _ = events.Close() }()
+		changes = events.Events
+		errs = events.Errors
+	}
+
+	// Fires settleDelay after the first event of a burst
+	var settled <-chan time.Time
+
+	for {
+		select {
+		case <-ticker.C:
+			sum = w.check(sum)
+		case _, ok := <-changes:
+			if !ok {
+				changes = nil
+				continue
+			}
+			if settled == nil {
+				settled = time.After(settleDelay)
+			}
+		case <-settled:
+			settled = nil
+			sum = w.check(sum)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			w.logger.Warn().Err(err).Msg("Policy directory watch error")
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+// check reloads the policies if their checksum differs from sum, returning
+// the current checksum
+func (w *Watcher) check(sum string) string {
+	next, err := policyChecksum(w.dir)
+	if err != nil {
+		w.logger.Warn().Err(err).Msg("Failed to read policies")
+		return sum
+	}
+	if next == sum {
+		return sum
+	}
+	w.logger.Info().Msg("Policies changed, reloading...")
+	w.reload()
+	return next
+}
+
+// policyChecksum hashes the names and content of the .rego files in dir
+func policyChecksum(dir string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.rego"))
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		_, _ = h.Write([]byte(filepath.Base(file)))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write(content)
+		_, _ = h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
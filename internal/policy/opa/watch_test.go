@@ -0,0 +1,91 @@
+package opa
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.rego")
+	if err := os.WriteFile(file, []byte("package kproxy.config\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan struct{}, 10)
+	w := NewWatcher(dir, 10*time.Millisecond, func() { reloaded <- struct{}{} }, zerolog.Nop())
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	// Unchanged policies don't reload
+	select {
+	case <-reloaded:
+		t.Fatal("reloaded without a change")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A ConfigMap update swaps the ..data symlink rather than writing the file
+	data := filepath.Join(dir, "..data")
+	if err := os.Mkdir(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(data, "config.rego"), []byte("package kproxy.config\n\ndevices := {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "config.rego"), file); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload after the policies changed")
+	}
+}
+
+func TestWatcherEvents(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.rego")
+	if err := os.WriteFile(file, []byte("package kproxy.config\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan struct{}, 10)
+	// Far too long an interval to notice the change by checking
+	w := NewWatcher(dir, time.Hour, func() { reloaded <- struct{}{} }, zerolog.Nop())
+	if err := w.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer w.Stop()
+
+	// An editor saving through a temporary file
+	tmp := filepath.Join(dir, ".config.rego.swp")
+	if err := os.WriteFile(tmp, []byte("package kproxy.config\n\ndevices := {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reloaded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload after editing a policy")
+	}
+
+	// The save is one reload
+	select {
+	case <-reloaded:
+		t.Fatal("reloaded twice for one save")
+	case <-time.After(3 * settleDelay):
+	}
+}
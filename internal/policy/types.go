@@ -15,6 +15,7 @@ const (
 	ActionAllow  Action = "ALLOW"
 	ActionBlock  Action = "BLOCK"
 	ActionBypass Action = "BYPASS"
+	ActionWarn   Action = "WARN" // Allowed, but would have been blocked (warn mode)
 )
 
 // UnmarshalJSON implements json.Unmarshaler to normalize action to uppercase.
@@ -29,11 +30,11 @@ func (a *Action) UnmarshalJSON(data []byte) error {
 
 	// Validate against known actions
 	switch normalized {
-	case ActionAllow, ActionBlock, ActionBypass:
+	case ActionAllow, ActionBlock, ActionBypass, ActionWarn:
 		*a = normalized
 		return nil
 	default:
-		return fmt.Errorf("invalid action: %s (must be ALLOW, BLOCK, or BYPASS)", s)
+		return fmt.Errorf("invalid action: %s (must be ALLOW, BLOCK, BYPASS, or WARN)", s)
 	}
 }
 
@@ -51,6 +52,27 @@ const (
 	DNSActionBlock                      // Return 0.0.0.0 / NXDOMAIN
 )
 
+// DNSBlockResponse is how a blocked DNS query is answered
+type DNSBlockResponse string
+
+const (
+	DNSBlockZeroIP   DNSBlockResponse = "zero-ip"   // 0.0.0.0 / :: (sinkhole)
+	DNSBlockNXDomain DNSBlockResponse = "nxdomain"  // The domain doesn't exist
+	DNSBlockRefused  DNSBlockResponse = "refused"   // The server refuses to answer
+	DNSBlockCustomIP DNSBlockResponse = "custom-ip" // The profile's own sinkhole addresses
+)
+
+// DNSDecision is the DNS action for a query along with how it is resolved
+type DNSDecision struct {
+	Action        DNSAction
+	DNSSECStrict  bool             // Bypassed answers must be DNSSEC-validated
+	SafeSearch    bool             // Search engines resolve to their SafeSearch hosts
+	BlockResponse DNSBlockResponse // How the query is answered if it is blocked
+	BlockIP       net.IP           // IPv4 sinkhole for DNSBlockCustomIP (nil = no A answer)
+	BlockIPv6     net.IP           // IPv6 sinkhole for DNSBlockCustomIP (nil = no AAAA answer)
+	DecisionID    string           // Recorded by the decision log ("" = not recorded)
+}
+
 // Device represents a monitored device
 type Device struct {
 	ID          string    `json:"id"`
@@ -115,14 +137,52 @@ type BypassRule struct {
 
 // PolicyDecision represents the result of policy evaluation
 type PolicyDecision struct {
-	Action        Action
-	Reason        string
-	BlockPage     string
-	InjectTimer   bool
-	TimeRemaining time.Duration
-	MatchedRuleID string
-	Category      string
-	UsageLimitID  string
+	Action          Action
+	Reason          string
+	BlockPage       string
+	BlockTemplate   string // Custom block page template chosen by the profile (optional)
+	InjectTimer     bool
+	TimeRemaining   time.Duration
+	MatchedRuleID   string
+	Category        string
+	UsageLimitID    string
+	Script          string           // Name of the Lua script to run on allowed requests (optional)
+	ThrottleDelay   time.Duration    // Delay before proxying, as a usage limit nears exhaustion
+	ThrottleKbps    int              // Response bandwidth cap in kbit/s (0 = no cap)
+	Profile         string           // Profile of the identified device (empty if unknown)
+	BandwidthWeight int              // Profile's share of the link when bandwidth sharing is enabled
+	StreamDevice    string           // Device to count the response against the profile's stream limit ("" = not a stream)
+	SafeSearch      bool             // Enforce SafeSearch on search engine requests
+	YouTubeRestrict string           // YouTube Restricted Mode level: "moderate", "strict" or "" for none
+	SearchLog       bool             // Log the search query terms (profile opted in)
+	SearchConcerns  []string         // Concern lists the search query matched (profile opted in to alerts)
+	BandwidthLimits []BandwidthLimit // Profile's bandwidth limits in force
+	Notice          *Notice          // Warning to show the device (nil if none is due)
+	DecisionID      string           // Recorded by the decision log ("" = not recorded)
+	Egress          string           // Egress route for upstream connections ("" = default)
+}
+
+// Notice warns a device that bedtime (the end of its profile's time window)
+// or a usage limit is near
+type Notice struct {
+	Kind      string // "bedtime" or "usage_limit"
+	Category  string // Category of a usage limit
+	Remaining time.Duration
+	Threshold int // Warning threshold in minutes that was reached
+}
+
+// DeviceStatus is the profile of a device and the notices due for it
+type DeviceStatus struct {
+	Profile string
+	Notices []Notice
+}
+
+// BandwidthLimit is a rate cap on responses. Responses under limits with
+// the same bucket share its rate.
+type BandwidthLimit struct {
+	ID     string
+	Kbps   int
+	Bucket string // e.g. "kids/evening", or "kids/evening/tablet" per device
 }
 
 // ProxyRequest represents an HTTP request to be evaluated
@@ -134,6 +194,20 @@ type ProxyRequest struct {
 	Method    string
 	UserAgent string
 	Encrypted bool
+
+	// Query parameters, and the request headers selected for policy keyed by
+	// lowercase name (the first value of each)
+	Query   map[string][]string
+	Headers map[string]string
+
+	// Requests made straight to an IP address, with the server names found
+	// for it (SNI, a name learned from earlier requests, or reverse DNS)
+	DirectIP    bool
+	ServerNames []string
+
+	// Search results requests, when search monitoring is enabled
+	SearchEngine string // "google", "bing" or "duckduckgo"
+	SearchQuery  string
 }
 
 // DNSRequest represents a DNS query to be evaluated
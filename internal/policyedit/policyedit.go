@@ -0,0 +1,302 @@
+// Package policyedit edits the .rego files of a filesystem policy directory
+// through the admin API. A change is compiled together with the rest of the
+// directory before it is written, so a typo can't leave KProxy without
+// policies, and the policies are reloaded once it is saved.
+package policyedit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrNotFound is returned for policy files that don't exist
+	ErrNotFound = errors.New("policy file not found")
+
+	// ErrInvalidName is returned for names that aren't a .rego file in the
+	// policy directory
+	ErrInvalidName = errors.New("invalid policy file name (use letters, digits, '-' and '_' with a .rego extension)")
+
+	// ErrCompile is returned for changes that don't compile
+	ErrCompile = errors.New("policy doesn't compile")
+)
+
+// namePattern matches policy file names
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*\.rego$`)
+
+// Reloader reloads the running policies (policy.Engine satisfies it)
+type Reloader interface {
+	Reload() error
+}
+
+// File is a policy file
+type File struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Content  string    `json:"content,omitempty"` // Only for a single file
+}
+
+// Result is the outcome of a change
+type Result struct {
+	Name    string `json:"name"`
+	Created bool   `json:"created"` // The file didn't exist
+	Saved   bool   `json:"saved"`   // False for a dry run or an unchanged file
+	Diff    string `json:"diff"`    // Unified diff from the current file ("" if unchanged)
+}
+
+// Editor edits the policy files in a directory
+type Editor struct {
+	dir      string
+	reloader Reloader
+	logger   zerolog.Logger
+
+	// Serializes changes, so each one is compiled against the files it replaces
+	mu sync.Mutex
+}
+
+// New creates an editor for the policy files in dir
+func New(dir string, reloader Reloader, logger zerolog.Logger) *Editor {
+	return &Editor{
+		dir:      dir,
+		reloader: reloader,
+		logger:   logger.With().Str("component", "policyedit").Logger(),
+	}
+}
+
+// List returns the policy files sorted by name
+func (e *Editor) List() ([]File, error) {
+	paths, err := filepath.Glob(filepath.Join(e.dir, "*.rego"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]File, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, File{Name: filepath.Base(path), Size: info.Size(), Modified: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// Get returns a policy file with its content
+func (e *Editor) Get(name string) (*File, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+
+	path := filepath.Join(e.dir, name)
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Name: name, Size: info.Size(), Modified: info.ModTime(), Content: string(content)}, nil
+}
+
+// Put replaces or creates a policy file after compiling it with the rest of
+// the directory, then reloads the policies. A dry run only compiles the
+// change and returns its diff.
+func (e *Editor) Put(name, content string, dryRun bool) (*Result, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	path := filepath.Join(e.dir, name)
+	current, err := os.ReadFile(path)
+	created := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !created {
+		return nil, err
+	}
+
+	result := &Result{Name: name, Created: created}
+	if !created && string(current) == content {
+		return result, nil
+	}
+	result.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(current)),
+		B:        difflib.SplitLines(content),
+		FromFile: "a/" + name,
+		ToFile:   "b/" + name,
+		Context:  3,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.compile(name, content); err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	if err := e.write(name, content); err != nil {
+		return nil, err
+	}
+	result.Saved = true
+
+	e.logger.Info().Str("name", name).Bool("created", created).Msg("Policy file saved")
+	if err := e.reloader.Reload(); err != nil {
+		return result, fmt.Errorf("saved, but reloading policies failed: %w", err)
+	}
+	return result, nil
+}
+
+// Replace makes the policy directory hold exactly files (name -> content),
+// as when restoring a backup: they are compiled together, written, the
+// other .rego files are removed, and the policies are reloaded once. A dry
+// run only compiles them. The results list the files written or removed.
+func (e *Editor) Replace(files map[string]string, dryRun bool) ([]Result, error) {
+	for name := range files {
+		if !namePattern.MatchString(name) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidName, name)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := compileFiles(files); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(e.dir, "*.rego"))
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		current, err := os.ReadFile(filepath.Join(e.dir, name))
+		created := errors.Is(err, fs.ErrNotExist)
+		if err != nil && !created {
+			return nil, err
+		}
+		if !created && string(current) == files[name] {
+			continue
+		}
+		results = append(results, Result{Name: name, Created: created, Saved: !dryRun})
+	}
+	var removed []string
+	for _, path := range paths {
+		if _, ok := files[filepath.Base(path)]; !ok {
+			removed = append(removed, path)
+			results = append(results, Result{Name: filepath.Base(path), Saved: !dryRun})
+		}
+	}
+	if dryRun || len(results) == 0 {
+		return results, nil
+	}
+
+	for _, result := range results {
+		content, ok := files[result.Name]
+		if !ok {
+			continue
+		}
+		if err := e.write(result.Name, content); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range removed {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	e.logger.Info().Int("files", len(files)).Int("changed", len(results)).Msg("Policy files replaced")
+	if err := e.reloader.Reload(); err != nil {
+		return results, fmt.Errorf("saved, but reloading policies failed: %w", err)
+	}
+	return results, nil
+}
+
+// write replaces a policy file: the content is written next to it and
+// renamed, so a reload never sees half of it
+func (e *Editor) write(name, content string) error {
+	tmp, err := os.CreateTemp(e.dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.WriteString(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(e.dir, name))
+}
+
+// compile checks that the policy directory compiles with the change
+func (e *Editor) compile(name, content string) error {
+	paths, err := filepath.Glob(filepath.Join(e.dir, "*.rego"))
+	if err != nil {
+		return err
+	}
+	files := make(map[string]string, len(paths)+1)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.Base(path)] = string(data)
+	}
+	files[name] = content
+	return compileFiles(files)
+}
+
+// compileFiles checks that a set of policy files compiles, using a copy of
+// them in a temporary directory
+func compileFiles(files map[string]string) error {
+	tmp, err := os.MkdirTemp("", "kproxy-policies-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	if _, err := opa.NewEngine(opa.Config{Source: "filesystem", PolicyDir: tmp, HTTPTimeout: 30 * time.Second}, zerolog.Nop()); err != nil {
+		// Name the files as they are in the policy directory
+		return fmt.Errorf("%w: %s", ErrCompile, strings.ReplaceAll(err.Error(), tmp+string(filepath.Separator), ""))
+	}
+	return nil
+}
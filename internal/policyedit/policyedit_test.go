@@ -0,0 +1,151 @@
+package policyedit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goodtune/kproxy/policies"
+	"github.com/rs/zerolog"
+)
+
+// countingReloader counts reloads
+type countingReloader struct {
+	reloads int
+}
+
+func (r *countingReloader) Reload() error {
+	r.reloads++
+	return nil
+}
+
+// starterDir writes the shipped policies (without tests) to a temp directory
+func starterDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	entries, err := policies.FS.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), "_test.rego") {
+			continue
+		}
+		data, err := policies.FS.ReadFile(entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry.Name()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestEditor(t *testing.T) {
+	dir := starterDir(t)
+	reloader := &countingReloader{}
+	e := New(dir, reloader, zerolog.Nop())
+
+	files, err := e.List()
+	if err != nil || len(files) == 0 || files[0].Name != "config.rego" || files[0].Content != "" {
+		t.Fatalf("List() = %+v (%v), want config.rego first without content", files, err)
+	}
+
+	file, err := e.Get("config.rego")
+	if err != nil || !strings.Contains(file.Content, "package kproxy.config") {
+		t.Fatalf("Get() = %+v (%v), want config.rego", file, err)
+	}
+	original := file.Content
+	changed := strings.Replace(original, "bypass_domains := [", "bypass_domains := [\n\t\"example.com\",", 1)
+
+	// A dry run shows the diff without saving
+	result, err := e.Put("config.rego", changed, true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if result.Saved || !strings.Contains(result.Diff, "+\t\"example.com\",") {
+		t.Errorf("dry run = %+v, want an unsaved diff adding example.com", result)
+	}
+	if file, _ := e.Get("config.rego"); file.Content != original || reloader.reloads != 0 {
+		t.Error("dry run changed the file or reloaded")
+	}
+
+	result, err = e.Put("config.rego", changed, false)
+	if err != nil || !result.Saved || result.Created {
+		t.Fatalf("Put() = %+v (%v), want a saved change", result, err)
+	}
+	if file, _ := e.Get("config.rego"); file.Content != changed || reloader.reloads != 1 {
+		t.Errorf("after Put: content changed = %v, reloads = %d, want the change and one reload", file.Content == changed, reloader.reloads)
+	}
+
+	// Saving the same content again is a no-op
+	if result, err := e.Put("config.rego", changed, false); err != nil || result.Saved || result.Diff != "" {
+		t.Errorf("Put() unchanged = %+v (%v), want nothing saved", result, err)
+	}
+
+	// Changes that don't compile are rejected and leave the file alone
+	_, err = e.Put("config.rego", changed+"\nbroken := {", false)
+	if !errors.Is(err, ErrCompile) || strings.Contains(err.Error(), os.TempDir()) {
+		t.Errorf("Put() broken = %v, want ErrCompile naming the file as in the policy directory", err)
+	}
+	if file, _ := e.Get("config.rego"); file.Content != changed || reloader.reloads != 1 {
+		t.Error("broken change was saved or reloaded")
+	}
+
+	if _, err := e.Get("../etc/passwd"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Get() outside the directory = %v, want ErrInvalidName", err)
+	}
+	if _, err := e.Get("missing.rego"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() missing = %v, want ErrNotFound", err)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	dir := starterDir(t)
+	reloader := &countingReloader{}
+	e := New(dir, reloader, zerolog.Nop())
+
+	files := make(map[string]string)
+	list, err := e.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range list {
+		file, err := e.Get(f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = file.Content
+	}
+	if err := os.WriteFile(filepath.Join(dir, "extra.rego"), []byte("package kproxy.extra\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files["config.rego"] = strings.Replace(files["config.rego"], "bypass_domains := [", "bypass_domains := [\n\t\"example.com\",", 1)
+
+	// A dry run lists the changes without making them
+	results, err := e.Replace(files, true)
+	if err != nil || len(results) != 2 || results[0].Name != "config.rego" || results[1].Name != "extra.rego" || results[0].Saved {
+		t.Fatalf("Replace() dry run = %+v (%v), want config.rego changed and extra.rego removed, unsaved", results, err)
+	}
+	if _, err := e.Get("extra.rego"); err != nil || reloader.reloads != 0 {
+		t.Error("dry run removed a file or reloaded")
+	}
+
+	broken := map[string]string{"config.rego": "package kproxy.config\n\nbroken := {"}
+	if _, err := e.Replace(broken, false); !errors.Is(err, ErrCompile) {
+		t.Errorf("Replace() broken = %v, want ErrCompile", err)
+	}
+
+	if _, err := e.Replace(files, false); err != nil || reloader.reloads != 1 {
+		t.Fatalf("Replace() = %v with %d reloads, want one reload", err, reloader.reloads)
+	}
+	if _, err := e.Get("extra.rego"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() removed file = %v, want ErrNotFound", err)
+	}
+	if file, _ := e.Get("config.rego"); file.Content != files["config.rego"] {
+		t.Error("config.rego wasn't replaced")
+	}
+}
@@ -0,0 +1,401 @@
+// Package probe checks internet reachability and latency in the
+// background, the way the proxy reaches the internet: names are resolved
+// through the configured upstream DNS servers and targets are fetched
+// directly from the KProxy host. The history tells an outage apart from a
+// policy block after the fact.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+// maxBodyBytes caps what a probe downloads to measure throughput
+const maxBodyBytes = 1 << 20
+
+// Phases a probe can fail in
+const (
+	PhaseDNS  = "dns"  // Resolving the target through the upstream servers
+	PhaseHTTP = "http" // Connecting, the request or a 4xx/5xx answer
+)
+
+// Resolver looks up a name through KProxy's upstream DNS servers
+// (dns.Upstream satisfies it)
+type Resolver interface {
+	Exchange(m *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// Config holds prober configuration
+type Config struct {
+	Targets   []string      // URLs fetched by each probe
+	Resolvers []Resolver    // Upstream DNS servers, tried in order
+	Interval  time.Duration // Time between probes
+	Timeout   time.Duration // Time allowed for each probe
+	Retention time.Duration // How long results are kept
+}
+
+// Result is the outcome of probing one target
+type Result struct {
+	Target     string    `json:"target"`
+	Time       time.Time `json:"time"`
+	OK         bool      `json:"ok"`
+	Phase      string    `json:"phase,omitempty"` // Where a failed probe failed
+	Error      string    `json:"error,omitempty"`
+	DNSMS      int64     `json:"dns_ms"`                 // Name resolution time
+	LatencyMS  int64     `json:"latency_ms"`             // Time to the response headers, excluding DNS
+	Status     int       `json:"status,omitempty"`       // HTTP status
+	Bytes      int64     `json:"bytes"`                  // Body bytes read (up to 1 MiB)
+	KbitPerSec float64   `json:"kbit_per_sec,omitempty"` // Body download rate
+}
+
+// Outage is a run of consecutive failed probes of a target
+type Outage struct {
+	Start  time.Time  `json:"start"`           // First failed probe
+	End    *time.Time `json:"end,omitempty"`   // First successful probe after it (nil = ongoing)
+	Probes int        `json:"probes"`          // Failed probes in the run
+	Phase  string     `json:"phase"`           // Phase of the first failure
+	Error  string     `json:"error,omitempty"` // Error of the first failure
+}
+
+// Summary sums up the results of one target over a period
+type Summary struct {
+	Target       string   `json:"target"`
+	Probes       int      `json:"probes"`
+	Failures     int      `json:"failures"`
+	Uptime       float64  `json:"uptime"` // Percentage of successful probes
+	AvgLatencyMS int64    `json:"avg_latency_ms"`
+	Last         *Result  `json:"last,omitempty"`
+	Outages      []Outage `json:"outages"` // Oldest first
+}
+
+// History is the probe history served to the admin API
+type History struct {
+	Since     time.Time `json:"since"`
+	Summaries []Summary `json:"summaries"`
+	Results   []Result  `json:"results"` // Oldest first
+}
+
+// target is a parsed probe target
+type target struct {
+	raw  string
+	host string
+	port string
+}
+
+// Prober runs probes in the background and keeps their results in memory
+type Prober struct {
+	targets   []target
+	resolvers []Resolver
+	interval  time.Duration
+	timeout   time.Duration
+	retention time.Duration
+	logger    zerolog.Logger
+	stopChan  chan struct{}
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	results []Result // Oldest first
+
+	// Replaced in tests
+	now  func() time.Time
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// New creates a prober
+func New(cfg Config, logger zerolog.Logger) (*Prober, error) {
+	p := &Prober{
+		resolvers: cfg.Resolvers,
+		interval:  cfg.Interval,
+		timeout:   cfg.Timeout,
+		retention: cfg.Retention,
+		logger:    logger.With().Str("component", "probe").Logger(),
+		stopChan:  make(chan struct{}),
+		now:       time.Now,
+		dial:      (&net.Dialer{}).DialContext,
+	}
+
+	for _, raw := range cfg.Targets {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid probe target: %q (must be an http:// or https:// URL)", raw)
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		p.targets = append(p.targets, target{raw: raw, host: u.Hostname(), port: port})
+	}
+	if len(p.targets) == 0 {
+		return nil, errors.New("no probe targets")
+	}
+
+	return p, nil
+}
+
+// Start begins probing every interval
+func (p *Prober) Start() {
+	p.wg.Add(1)
+	go p.run()
+	p.logger.Info().
+		Int("targets", len(p.targets)).
+		Dur("interval", p.interval).
+		Msg("Connectivity probes started")
+}
+
+// Stop stops probing and waits for a running probe to finish
+func (p *Prober) Stop() {
+	close(p.stopChan)
+	p.wg.Wait()
+}
+
+// run is the background probe loop
+func (p *Prober) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	p.ProbeAll()
+	for {
+		select {
+		case <-ticker.C:
+			p.ProbeAll()
+		case <-p.stopChan:
+			return
+		}
+	}
+}
+
+// ProbeAll probes every target concurrently and records the results
+func (p *Prober) ProbeAll() []Result {
+	results := make([]Result, len(p.targets))
+	var wg sync.WaitGroup
+	for i, t := range p.targets {
+		wg.Add(1)
+		go func(i int, t target) {
+			defer wg.Done()
+			results[i] = p.probe(t)
+		}(i, t)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		p.record(r)
+	}
+	return results
+}
+
+// probe probes one target
+func (p *Prober) probe(t target) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	result := Result{Target: t.raw, Time: p.now()}
+
+	// Resolve through the upstream servers, as the proxy's clients do
+	start := time.Now()
+	ip, err := p.resolve(t.host)
+	result.DNSMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Phase = PhaseDNS
+		result.Error = err.Error()
+		return result
+	}
+
+	transport := &http.Transport{
+		// Connect to the resolved address; TLS still verifies the target's name
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return p.dial(ctx, network, net.JoinHostPort(ip, t.port))
+		},
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.raw, nil)
+	if err != nil {
+		result.Phase = PhaseHTTP
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("User-Agent", "KProxy-Probe/1.0")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	start = time.Now()
+	resp, err := transport.RoundTrip(req)
+	result.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Phase = PhaseHTTP
+		result.Error = err.Error()
+		return result
+	}
+	defer func() { _ = resp.Body.Close() }()
+	result.Status = resp.StatusCode
+
+	start = time.Now()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxBodyBytes))
+	elapsed := time.Since(start)
+	result.Bytes = n
+	if n > 0 && elapsed > 0 {
+		result.KbitPerSec = float64(n*8) / 1000 / elapsed.Seconds()
+	}
+	if err != nil {
+		result.Phase = PhaseHTTP
+		result.Error = err.Error()
+		return result
+	}
+	if resp.StatusCode >= 400 {
+		result.Phase = PhaseHTTP
+		result.Error = resp.Status
+		return result
+	}
+
+	result.OK = true
+	return result
+}
+
+// resolve looks up an IPv4 address for host through the upstream servers,
+// trying each in turn. IP address hosts are used as they are.
+func (p *Prober) resolve(host string) (string, error) {
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	if len(p.resolvers) == 0 {
+		return "", errors.New("no upstream DNS servers")
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), dns.TypeA)
+
+	var lastErr error
+	for _, r := range p.resolvers {
+		resp, err := r.Exchange(m)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", r, err)
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			lastErr = fmt.Errorf("%s: %s", r, dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				return a.A.String(), nil
+			}
+		}
+		lastErr = fmt.Errorf("%s: no address for %s", r, host)
+	}
+	return "", lastErr
+}
+
+// record keeps a result, drops results past retention and updates metrics
+func (p *Prober) record(r Result) {
+	up := 0.0
+	if r.OK {
+		up = 1
+		metrics.ProbeDuration.WithLabelValues(r.Target, PhaseDNS).Observe(float64(r.DNSMS) / 1000)
+		metrics.ProbeDuration.WithLabelValues(r.Target, PhaseHTTP).Observe(float64(r.LatencyMS) / 1000)
+	} else {
+		metrics.ProbeFailures.WithLabelValues(r.Target, r.Phase).Inc()
+		p.logger.Warn().
+			Str("target", r.Target).
+			Str("phase", r.Phase).
+			Str("error", r.Error).
+			Msg("Connectivity probe failed")
+	}
+	metrics.ProbeUp.WithLabelValues(r.Target).Set(up)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.results = append(p.results, r)
+	cutoff := p.now().Add(-p.retention)
+	drop := 0
+	for drop < len(p.results) && p.results[drop].Time.Before(cutoff) {
+		drop++
+	}
+	if drop > 0 {
+		p.results = append(p.results[:0], p.results[drop:]...)
+	}
+}
+
+// History returns the results since a time with a summary per target
+func (p *Prober) History(since time.Time) History {
+	p.mu.Lock()
+	var results []Result
+	for _, r := range p.results {
+		if !r.Time.Before(since) {
+			results = append(results, r)
+		}
+	}
+	p.mu.Unlock()
+
+	if results == nil {
+		results = []Result{}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Time.Before(results[j].Time) })
+
+	h := History{Since: since, Results: results}
+	for _, t := range p.targets {
+		h.Summaries = append(h.Summaries, summarize(t.raw, results))
+	}
+	return h
+}
+
+// summarize sums up the results of one target
+func summarize(name string, results []Result) Summary {
+	s := Summary{Target: name, Outages: []Outage{}}
+	var latency int64
+	var outage *Outage
+	for i := range results {
+		r := results[i]
+		if r.Target != name {
+			continue
+		}
+		s.Probes++
+		s.Last = &results[i]
+
+		if r.OK {
+			latency += r.LatencyMS
+			if outage != nil {
+				end := r.Time
+				outage.End = &end
+				s.Outages = append(s.Outages, *outage)
+				outage = nil
+			}
+			continue
+		}
+
+		s.Failures++
+		if outage == nil {
+			outage = &Outage{Start: r.Time, Phase: r.Phase, Error: r.Error}
+		}
+		outage.Probes++
+	}
+	if outage != nil {
+		s.Outages = append(s.Outages, *outage)
+	}
+
+	if s.Probes > 0 {
+		s.Uptime = float64(s.Probes-s.Failures) / float64(s.Probes) * 100
+	}
+	if ok := s.Probes - s.Failures; ok > 0 {
+		s.AvgLatencyMS = latency / int64(ok)
+	}
+	return s
+}
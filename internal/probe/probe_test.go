@@ -0,0 +1,107 @@
+package probe
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+// fakeResolver answers every A query with addr, or NXDOMAIN when addr is empty
+type fakeResolver struct {
+	addr *string
+}
+
+func (f fakeResolver) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	resp := new(dns.Msg)
+	resp.SetReply(m)
+	if *f.addr == "" {
+		resp.Rcode = dns.RcodeNameError
+		return resp, nil
+	}
+	resp.Answer = append(resp.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP(*f.addr),
+	})
+	return resp, nil
+}
+
+func (f fakeResolver) String() string { return "fake" }
+
+func TestProber(t *testing.T) {
+	status := http.StatusNoContent
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	target := "http://connectivity.example:" + u.Port() + "/generate_204"
+
+	addr := "127.0.0.1"
+	p, err := New(Config{
+		Targets:   []string{target},
+		Resolvers: []Resolver{fakeResolver{addr: &addr}},
+		Timeout:   5 * time.Second,
+		Retention: time.Hour,
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	probe := func() Result {
+		t.Helper()
+		now = now.Add(time.Minute)
+		return p.ProbeAll()[0]
+	}
+
+	if r := probe(); !r.OK || r.Status != http.StatusNoContent {
+		t.Fatalf("probe = %+v, want success", r)
+	}
+
+	// The internet goes away: DNS fails, then the site answers with errors
+	addr = ""
+	if r := probe(); r.OK || r.Phase != PhaseDNS {
+		t.Errorf("probe without DNS = %+v, want a dns failure", r)
+	}
+	addr = "127.0.0.1"
+	status = http.StatusServiceUnavailable
+	if r := probe(); r.OK || r.Phase != PhaseHTTP || r.Status != http.StatusServiceUnavailable {
+		t.Errorf("probe of a failing site = %+v, want an http failure", r)
+	}
+	status = http.StatusNoContent
+	recovered := probe()
+
+	h := p.History(time.Time{})
+	if len(h.Results) != 4 || len(h.Summaries) != 1 {
+		t.Fatalf("History() = %+v, want 4 results for 1 target", h)
+	}
+	s := h.Summaries[0]
+	if s.Probes != 4 || s.Failures != 2 || s.Uptime != 50 {
+		t.Errorf("summary = %+v, want 2 of 4 probes failed", s)
+	}
+	if len(s.Outages) != 1 || s.Outages[0].Probes != 2 || s.Outages[0].Phase != PhaseDNS || s.Outages[0].End == nil || !s.Outages[0].End.Equal(recovered.Time) {
+		t.Errorf("outages = %+v, want one two-probe outage ending at %v", s.Outages, recovered.Time)
+	}
+
+	// Results older than the retention are dropped
+	now = now.Add(time.Hour)
+	probe()
+	if h := p.History(time.Time{}); len(h.Results) != 1 {
+		t.Errorf("History() after an hour = %d results, want 1", len(h.Results))
+	}
+}
+
+func TestNewInvalidTarget(t *testing.T) {
+	for _, target := range []string{"ftp://example.com/", "example.com", "http:///path"} {
+		if _, err := New(Config{Targets: []string{target}}, zerolog.Nop()); err == nil {
+			t.Errorf("New(%q) succeeded, want an error", target)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/goodtune/kproxy/internal/access"
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy"
+)
+
+// accessRequestPath receives the block page's "request access" form on the
+// blocked host itself, so the request needs no other name to reach KProxy
+const accessRequestPath = "/.kproxy/request-access"
+
+// maxAccessReason caps the reason a client gives for an access request
+const maxAccessReason = 500
+
+// handleAccessRequest takes an access request posted from the block page.
+// The form names the blocked path and the client's reason; policy is
+// evaluated again for the client, host and path, so the device, profile
+// and block reason come from policy rather than the form.
+func (s *Server) handleAccessRequest(w http.ResponseWriter, r *http.Request, encrypted bool) {
+	if s.access == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	path := r.PostForm.Get("path")
+	if !strings.HasPrefix(path, "/") {
+		path = "/"
+	}
+
+	policyReq := &policy.ProxyRequest{
+		ClientIP:  s.extractClientIP(r),
+		Host:      r.Host,
+		Path:      path,
+		Method:    http.MethodGet,
+		UserAgent: r.UserAgent(),
+		Encrypted: encrypted,
+	}
+	decision := s.evaluate(policyReq, &requestTiming{})
+
+	s.requestAccess(w, r, policyReq, decision, r.PostForm.Get("reason"))
+}
+
+// canRequestAccess reports whether access can be asked for a blocked
+// request. Unknown devices have no profile to add a rule to, and a rule
+// for the site wouldn't lift a block for being outside allowed hours or
+// for a pause.
+func canRequestAccess(decision *policy.PolicyDecision) bool {
+	return decision.Profile != "" && decision.BlockPage != "time_restriction" && decision.BlockPage != "paused"
+}
+
+// requestAccess queues an access request for a blocked request
+func (s *Server) requestAccess(w http.ResponseWriter, r *http.Request, req *policy.ProxyRequest, decision *policy.PolicyDecision, reason string) {
+	if decision.Action != policy.ActionBlock {
+		// Nothing to ask for any more
+		http.Redirect(w, r, req.Path, http.StatusSeeOther)
+		return
+	}
+	if !canRequestAccess(decision) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	reason = strings.TrimSpace(reason)
+	if len(reason) > maxAccessReason {
+		reason = reason[:maxAccessReason]
+	}
+
+	_, _, err := s.access.Submit(access.Request{
+		Client:      req.ClientIP.String(),
+		Host:        hostWithoutPort(req.Host),
+		Path:        req.Path,
+		Profile:     decision.Profile,
+		Category:    decision.Category,
+		BlockReason: decision.Reason,
+		Reason:      reason,
+	})
+	if errors.Is(err, access.ErrTooMany) {
+		http.Error(w, "Too many access requests are waiting; try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to queue access request")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	metrics.AccessRequests.WithLabelValues(decision.Profile).Inc()
+
+	requestedHTML := fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Access Requested - KProxy</title>
+	<style>
+		* { margin: 0; padding: 0; box-sizing: border-box; }
+		body {
+			font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+			background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%);
+			min-height: 100vh;
+			display: flex;
+			align-items: center;
+			justify-content: center;
+			padding: 20px;
+		}
+		.container {
+			background: white;
+			border-radius: 16px;
+			padding: 40px;
+			max-width: 500px;
+			text-align: center;
+			box-shadow: 0 20px 60px rgba(0,0,0,0.3);
+		}
+		.icon { font-size: 64px; margin-bottom: 20px; }
+		h1 { color: #333; margin-bottom: 16px; }
+		p { color: #666; line-height: 1.6; margin-bottom: 24px; }
+	</style>
+</head>
+<body>
+	<div class="container">
+		<div class="icon">📨</div>
+		<h1>Access Requested</h1>
+		<p>Your request for %s has been sent. Try again once it has been approved.</p>
+	</div>
+</body>
+</html>`, html.EscapeString(hostWithoutPort(req.Host)))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusAccepted)
+	if _, err := w.Write([]byte(requestedHTML)); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to write access request page")
+	}
+}
+
+// accessRequestForm is the block page's "request access" form for a
+// blocked path
+func accessRequestForm(path string) string {
+	return fmt.Sprintf(`<form method="post" action="%s" class="request-access">
+			<input type="hidden" name="path" value="%s">
+			<textarea name="reason" maxlength="%d" placeholder="Why do you need this site?"></textarea>
+			<button type="submit">Ask for access</button>
+		</form>`, accessRequestPath, html.EscapeString(path), maxAccessReason)
+}
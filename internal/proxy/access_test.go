@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goodtune/kproxy/internal/access"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+)
+
+func TestRequestAccess(t *testing.T) {
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+	blocked := &policy.PolicyDecision{
+		Action:   policy.ActionBlock,
+		Reason:   "matched block rule: games",
+		Profile:  "kids",
+		Category: "gaming",
+	}
+
+	// The block page only offers the form with access requests on
+	rec := httptest.NewRecorder()
+	s.handleBlock(rec, httptest.NewRequest(http.MethodGet, "http://games.example/play", nil), blocked)
+	if strings.Contains(rec.Body.String(), accessRequestPath) {
+		t.Error("block page offers access requests without a queue")
+	}
+
+	q := access.New(zerolog.Nop())
+	s.SetAccessRequests(q)
+	rec = httptest.NewRecorder()
+	s.handleBlock(rec, httptest.NewRequest(http.MethodGet, "http://games.example/play", nil), blocked)
+	if body := rec.Body.String(); !strings.Contains(body, accessRequestPath) || !strings.Contains(body, `value="/play"`) {
+		t.Errorf("block page = %q, want the access request form for /play", body)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		decision *policy.PolicyDecision
+		want     int
+	}{
+		{name: "blocked", decision: blocked, want: http.StatusAccepted},
+		{name: "allowed since", decision: &policy.PolicyDecision{Action: policy.ActionAllow, Profile: "kids"}, want: http.StatusSeeOther},
+		{name: "unknown device", decision: &policy.PolicyDecision{Action: policy.ActionBlock}, want: http.StatusForbidden},
+		{name: "outside allowed hours", decision: &policy.PolicyDecision{Action: policy.ActionBlock, Profile: "kids", BlockPage: "time_restriction"}, want: http.StatusForbidden},
+		{name: "paused", decision: &policy.PolicyDecision{Action: policy.ActionBlock, Profile: "kids", BlockPage: "paused"}, want: http.StatusForbidden},
+	} {
+		req := &policy.ProxyRequest{ClientIP: net.ParseIP("192.168.1.20"), Host: "games.example:443", Path: "/play"}
+		rec := httptest.NewRecorder()
+		s.requestAccess(rec, httptest.NewRequest(http.MethodPost, "https://games.example"+accessRequestPath, nil), req, tt.decision, "  homework  ")
+		if rec.Code != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	list := q.List()
+	if len(list) != 1 {
+		t.Fatalf("List() = %+v, want one request", list)
+	}
+	if got := list[0]; got.Host != "games.example" || got.Client != "192.168.1.20" || got.Profile != "kids" || got.Reason != "homework" || got.BlockReason != blocked.Reason {
+		t.Errorf("request = %+v", got)
+	}
+}
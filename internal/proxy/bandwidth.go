@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy"
+	"golang.org/x/time/rate"
+)
+
+// bandwidthLimits enforces the bandwidth limits of policy decisions. Each
+// limit bucket is a token bucket shared by the responses drawing from it
+// (all devices of a profile, or one device), kept while any is in flight.
+type bandwidthLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*limitBucket
+}
+
+// limitBucket is the token bucket of one bandwidth limit
+type limitBucket struct {
+	transfers int
+	limiter   *rate.Limiter
+}
+
+func newBandwidthLimits() *bandwidthLimits {
+	return &bandwidthLimits{buckets: make(map[string]*limitBucket)}
+}
+
+// Writer wraps w so that writes draw from the bucket of every limit. The
+// returned release function must be called when the transfer is done.
+func (b *bandwidthLimits) Writer(ctx context.Context, w io.Writer, profile string, limits []policy.BandwidthLimit) (io.Writer, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var held []string
+	for _, limit := range limits {
+		if limit.Kbps <= 0 {
+			continue
+		}
+		bucket, ok := b.buckets[limit.Bucket]
+		if !ok {
+			bucket = &limitBucket{limiter: rate.NewLimiter(0, shaperBurst)}
+			b.buckets[limit.Bucket] = bucket
+		}
+		// The policy may have changed the rate since the bucket was created
+		bucket.limiter.SetLimit(rate.Limit(float64(limit.Kbps) * 1000 / 8))
+		bucket.transfers++
+		held = append(held, limit.Bucket)
+
+		w = &shapedWriter{ctx: ctx, w: w, limiter: bucket.limiter}
+		metrics.BandwidthLimitedResponses.WithLabelValues(profile, limit.ID).Inc()
+	}
+
+	release := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		for _, key := range held {
+			bucket := b.buckets[key]
+			bucket.transfers--
+			if bucket.transfers == 0 {
+				delete(b.buckets, key)
+			}
+		}
+	}
+
+	return w, release
+}
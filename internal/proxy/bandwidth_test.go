@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/goodtune/kproxy/internal/policy"
+	"golang.org/x/time/rate"
+)
+
+func TestBandwidthLimitsShareBuckets(t *testing.T) {
+	limits := newBandwidthLimits()
+
+	evening := policy.BandwidthLimit{ID: "evening", Kbps: 800, Bucket: "kids/evening"}
+	tablet := policy.BandwidthLimit{ID: "each-device", Kbps: 400, Bucket: "kids/each-device/tablet"}
+
+	var buf bytes.Buffer
+	_, releaseTablet := limits.Writer(context.Background(), &buf, "kids", []policy.BandwidthLimit{evening, tablet})
+	_, releaseTV := limits.Writer(context.Background(), &buf, "kids", []policy.BandwidthLimit{evening})
+
+	bucket := func(key string) (*limitBucket, rate.Limit) {
+		limits.mu.Lock()
+		defer limits.mu.Unlock()
+		b, ok := limits.buckets[key]
+		if !ok {
+			return nil, 0
+		}
+		return b, b.limiter.Limit()
+	}
+
+	// Both devices draw from the profile's bucket, the tablet also from its own
+	if b, limit := bucket("kids/evening"); b == nil || b.transfers != 2 || limit != 100000 {
+		t.Errorf("profile bucket = %+v at %v, want 2 transfers at 100000", b, limit)
+	}
+	if b, limit := bucket("kids/each-device/tablet"); b == nil || b.transfers != 1 || limit != 50000 {
+		t.Errorf("device bucket = %+v at %v, want 1 transfer at 50000", b, limit)
+	}
+
+	// Buckets are dropped once their transfers are done
+	releaseTablet()
+	if b, _ := bucket("kids/each-device/tablet"); b != nil {
+		t.Error("expected the device bucket to be removed")
+	}
+	if b, _ := bucket("kids/evening"); b == nil || b.transfers != 1 {
+		t.Errorf("profile bucket = %+v, want 1 transfer", b)
+	}
+	releaseTV()
+	if len(limits.buckets) != 0 {
+		t.Errorf("expected no buckets, got %d", len(limits.buckets))
+	}
+}
+
+func TestBandwidthLimitedWriterWritesEverything(t *testing.T) {
+	limits := newBandwidthLimits()
+
+	var buf bytes.Buffer
+	w, release := limits.Writer(context.Background(), &buf, "kids", []policy.BandwidthLimit{
+		{ID: "fast", Kbps: 8000000, Bucket: "kids/fast"}, // Fast enough not to slow the test
+	})
+	defer release()
+
+	data := make([]byte, 3*shaperBurst+1)
+	n, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if n != len(data) || buf.Len() != len(data) {
+		t.Errorf("wrote %d bytes (buffer %d), want %d", n, buf.Len(), len(data))
+	}
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"sync/atomic"
+
+	"github.com/goodtune/kproxy/internal/clientcert"
+)
+
+// certRequestKey is the context key of the flag set when the origin of an
+// upstream request asks for a client certificate
+type certRequestKey struct{}
+
+// SetClientCerts sets the cache of hosts found to want a client
+// certificate, which are tunnelled rather than intercepted while allowed
+func (s *Server) SetClientCerts(c *clientcert.Cache) {
+	s.clientCerts = c
+}
+
+// noteCertificateRequest is the upstream GetClientCertificate. KProxy has
+// no certificate to present, so it sends none, as crypto/tls does without
+// the callback, and flags the request the handshake was made for.
+func noteCertificateRequest(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if requested, ok := info.Context().Value(certRequestKey{}).(*atomic.Bool); ok {
+		requested.Store(true)
+	}
+	return &tls.Certificate{}, nil
+}
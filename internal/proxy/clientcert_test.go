@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/clientcert"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+)
+
+func TestClientCertBypass(t *testing.T) {
+	// An origin that insists on a client certificate, like a VPN portal
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	origin.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	origin.Config.ErrorLog = log.New(io.Discard, "", 0)
+	origin.StartTLS()
+	defer origin.Close()
+
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+	certs := clientcert.New(time.Hour, zerolog.Nop())
+	s.SetClientCerts(certs)
+	roots := x509.NewCertPool()
+	roots.AddCert(origin.Certificate())
+	s.transport.TLSClientConfig.RootCAs = roots
+
+	r := httptest.NewRequest(http.MethodGet, "https://"+origin.Listener.Addr().String()+"/", nil)
+	r.RemoteAddr = "192.168.1.20:50000"
+	r.RequestURI = "/"
+	rec := httptest.NewRecorder()
+	decision := &policy.PolicyDecision{Action: policy.ActionAllow}
+	s.handleProxy(rec, r, &policy.ProxyRequest{Host: r.Host}, true, decision, &requestTiming{})
+
+	if rec.Code != http.StatusBadGateway || rec.Header().Get("Connection") != "close" {
+		t.Errorf("got %d (Connection %q), want 502 closing the connection", rec.Code, rec.Header().Get("Connection"))
+	}
+	hosts := certs.List()
+	if len(hosts) != 1 || hosts[0].Host != "127.0.0.1" || hosts[0].Client != "192.168.1.20" {
+		t.Fatalf("bypassed hosts = %+v, want 127.0.0.1 found by 192.168.1.20", hosts)
+	}
+
+	// The next connection to the host is tunnelled, not intercepted
+	tests := []struct {
+		serverName string
+		want       bool
+	}{
+		{"127.0.0.1", true},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		client, conn := net.Pipe()
+		if got := s.routeTLS(conn, tt.serverName, nil); got != tt.want {
+			t.Errorf("%s: tunnelled = %v, want %v", tt.serverName, got, tt.want)
+		}
+		_ = client.Close()
+		if !tt.want {
+			_ = conn.Close()
+		}
+	}
+}
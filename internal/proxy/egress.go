@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Egress is a named route for upstream connections, chosen by policy
+// ("egress" on a profile or rule) or as the default. Connections can leave
+// by an interface or source address, e.g. on a multi-homed router, and be
+// chained through a SOCKS5 or HTTP proxy, e.g. at the far end of a VPN.
+type Egress struct {
+	Name      string
+	Interface string // Bind to this interface (Linux)
+	SourceIP  string // Bind to this local address
+	Proxy     string // "socks5://host:port" or "http://host:port", optionally with user:password@
+}
+
+// egress is a compiled Egress: a dialer for tunnels and a transport, with
+// its own connection pool, for proxied requests
+type egress struct {
+	dialer    *net.Dialer
+	proxyURL  *url.URL
+	transport *http.Transport
+}
+
+// SetEgress sets the egress routes policy can choose, and the one used when
+// it chooses none ("" = connect directly from the host)
+func (s *Server) SetEgress(routes []Egress, defaultName string) error {
+	egresses := make(map[string]*egress, len(routes))
+	for _, route := range routes {
+		e, err := newEgress(route, s.http2)
+		if err != nil {
+			return fmt.Errorf("egress %s: %w", route.Name, err)
+		}
+		egresses[route.Name] = e
+	}
+	if _, ok := egresses[defaultName]; defaultName != "" && !ok {
+		return fmt.Errorf("default egress %q is not defined", defaultName)
+	}
+	s.egresses = egresses
+	s.defaultEgress = defaultName
+	return nil
+}
+
+// newEgress compiles an egress route
+func newEgress(route Egress, http2 bool) (*egress, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if route.SourceIP != "" {
+		ip := net.ParseIP(route.SourceIP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source_ip: %s", route.SourceIP)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if route.Interface != "" {
+		control, err := bindToInterface(route.Interface)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Control = control
+	}
+
+	e := &egress{dialer: dialer}
+	e.transport = newUpstreamTransport(http2)
+	e.transport.DialContext = dialUpstream(dialer)
+	if route.Proxy != "" {
+		u, err := url.Parse(route.Proxy)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy: %s", route.Proxy)
+		}
+		switch u.Scheme {
+		case "socks5", "socks5h", "http":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+		}
+		e.proxyURL = u
+		// The proxy, not the original destination, is what gets dialed
+		e.transport.Proxy = http.ProxyURL(u)
+		e.transport.DialContext = dialer.DialContext
+	}
+	return e, nil
+}
+
+// egressFor returns the egress named by a decision, or the default. The
+// direct route is nil; ok is false for a name that isn't defined.
+func (s *Server) egressFor(name string) (e *egress, ok bool) {
+	name = cmp.Or(name, s.defaultEgress)
+	if name == "" {
+		return nil, true
+	}
+	e, ok = s.egresses[name]
+	return e, ok
+}
+
+// transportFor returns the transport for requests leaving by an egress
+func (s *Server) transportFor(name string) (*http.Transport, error) {
+	e, ok := s.egressFor(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown egress %q", name)
+	}
+	if e == nil {
+		return s.transport, nil
+	}
+	return e.transport, nil
+}
+
+// dialFor returns the dial function for tunnels leaving by an egress
+func (s *Server) dialFor(name string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	e, ok := s.egressFor(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown egress %q", name)
+	}
+	if e == nil {
+		return s.dialOrigin, nil
+	}
+	return e.dial, nil
+}
+
+// dial connects to addr by the egress, through its proxy if it has one
+func (e *egress) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if e.proxyURL == nil {
+		return e.dialer.DialContext(ctx, network, addr)
+	}
+	if e.proxyURL.Scheme == "http" {
+		return e.dialHTTPConnect(ctx, network, addr)
+	}
+	d, err := proxy.FromURL(e.proxyURL, e.dialer)
+	if err != nil {
+		return nil, err
+	}
+	return d.(proxy.ContextDialer).DialContext(ctx, network, addr)
+}
+
+// dialHTTPConnect opens a tunnel to addr with CONNECT through an HTTP proxy
+func (e *egress) dialHTTPConnect(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := e.dialer.DialContext(ctx, network, e.proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := e.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("upstream proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	// Keep anything read past the proxy's response
+	return &replayConn{Conn: conn, r: br}, nil
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToInterface returns a dialer control binding sockets to an interface
+// (SO_BINDTODEVICE, which needs CAP_NET_RAW)
+func bindToInterface(iface string) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = unix.BindToDevice(int(fd), iface)
+		})
+		if err != nil {
+			return err
+		}
+		if sockErr != nil {
+			return fmt.Errorf("failed to bind to interface %s: %w", iface, sockErr)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+)
+
+// upstreamProxy is an HTTP proxy answering plain requests itself and
+// splicing CONNECT tunnels to their target
+func upstreamProxy(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			_, _ = io.WriteString(w, "via proxy "+r.URL.String())
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			_ = upstream.Close()
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			_ = upstream.Close()
+		}()
+		_, _ = io.Copy(conn, upstream)
+		_ = conn.Close()
+	}))
+}
+
+func TestSetEgress(t *testing.T) {
+	tests := []struct {
+		name        string
+		routes      []Egress
+		defaultName string
+		wantErr     bool
+	}{
+		{"none", nil, "", false},
+		{"source ip", []Egress{{Name: "lan", SourceIP: "127.0.0.1"}}, "lan", false},
+		{"socks", []Egress{{Name: "vpn", Proxy: "socks5://10.8.0.1:1080"}}, "", false},
+		{"bad source ip", []Egress{{Name: "lan", SourceIP: "lan"}}, "", true},
+		{"bad proxy scheme", []Egress{{Name: "vpn", Proxy: "ftp://10.8.0.1"}}, "", true},
+		{"undefined default", []Egress{{Name: "lan"}}, "vpn", true},
+	}
+	for _, tt := range tests {
+		s := NewServer(Config{}, nil, nil, zerolog.Nop())
+		if err := s.SetEgress(tt.routes, tt.defaultName); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestEgressProxy(t *testing.T) {
+	upstream := upstreamProxy(t)
+	defer upstream.Close()
+
+	origin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = origin.Close() }()
+	go func() {
+		conn, err := origin.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+	err = s.SetEgress([]Egress{{Name: "vpn", Proxy: upstream.URL}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tunnels are opened with CONNECT through the upstream proxy
+	dial, err := s.dialFor("vpn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dial(ctx, "tcp", origin.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+		t.Errorf("echo = %q (%v), want ping", got, err)
+	}
+
+	// Requests are sent to the upstream proxy
+	tests := []struct {
+		egress string
+		code   int
+		want   string
+	}{
+		{"vpn", http.StatusOK, "via proxy http://example.com/"},
+		{"missing", http.StatusBadGateway, "Bad Gateway\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.RequestURI = "/"
+		rec := httptest.NewRecorder()
+		decision := &policy.PolicyDecision{Action: policy.ActionAllow, Egress: tt.egress}
+		s.handleProxy(rec, r, &policy.ProxyRequest{Host: r.Host}, false, decision, &requestTiming{})
+		if rec.Code != tt.code || rec.Body.String() != tt.want {
+			t.Errorf("%s: got %d %q, want %d %q", tt.egress, rec.Code, rec.Body.String(), tt.code, tt.want)
+		}
+	}
+}
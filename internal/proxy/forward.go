@@ -0,0 +1,383 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/identity"
+	"github.com/goodtune/kproxy/internal/policy"
+)
+
+// ForwardProxyConfig enables an explicit proxy listener for devices set up
+// with proxy settings (manually or by the PAC file) rather than KProxy's
+// DNS. HTTPS sites reached with CONNECT on port 443 are intercepted like
+// those reached through DNS; other ports listed in ConnectPorts are
+// tunnelled once policy allows the host.
+type ForwardProxyConfig struct {
+	Addr         string
+	PACProxy     string            // host:port the PAC file sends clients to
+	Users        map[string]string // User name -> password for Proxy-Authorization (empty = no authentication)
+	ConnectPorts []int             // Ports other than 443 CONNECT may reach (empty = 443 only)
+}
+
+// PAC file paths, served on the forward proxy port and, for the server name
+// and "wpad" hosts, on the HTTP port
+const (
+	wpadPath = "/wpad.dat"
+	pacPath  = "/proxy.pac"
+)
+
+// SetUserRegistry records users who authenticate to the forward proxy as
+// logged in at their address, so policy identifies them as with RADIUS
+func (s *Server) SetUserRegistry(r *identity.Registry) {
+	s.users = r
+}
+
+// handleForward handles requests to the forward proxy: absolute-URI HTTP
+// requests and CONNECT tunnels
+func (s *Server) handleForward(w http.ResponseWriter, r *http.Request) {
+	// The PAC file is fetched before the proxy is in use
+	if r.Method != http.MethodConnect && r.URL.Host == "" {
+		if r.URL.Path == wpadPath || r.URL.Path == pacPath {
+			s.servePAC(w)
+			return
+		}
+		http.Error(w, "Not a proxy request", http.StatusBadRequest)
+		return
+	}
+
+	if !s.authenticate(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		s.handleConnect(w, r)
+		return
+	}
+	if r.URL.Scheme != "http" {
+		http.Error(w, "Unsupported scheme", http.StatusBadRequest)
+		return
+	}
+
+	// From here on it is an ordinary intercepted HTTP request
+	r.RequestURI = r.URL.RequestURI()
+	r.Header.Del("Proxy-Authorization")
+	r.Header.Del("Proxy-Connection")
+	s.handleHTTP(w, r)
+}
+
+// authenticate checks Proxy-Authorization when users are configured,
+// answering 407 if it is missing or wrong
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.forward.Users) == 0 {
+		return true
+	}
+
+	name, ok := s.proxyBasicAuth(r)
+	if !ok {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="KProxy"`)
+		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+		return false
+	}
+	if s.users != nil {
+		clientIP := s.extractClientIP(r)
+		mac := ""
+		if hw := s.arp.Lookup(clientIP); hw != nil {
+			mac = hw.String()
+		}
+		s.users.Login(name, identity.SourceProxy, clientIP.String(), mac)
+	}
+	return true
+}
+
+// proxyBasicAuth returns the user named by valid Basic credentials in the
+// Proxy-Authorization header
+func (s *Server) proxyBasicAuth(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	encoded, ok := strings.CutPrefix(auth, "Basic ")
+	if !ok {
+		return "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", false
+	}
+	name, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", false
+	}
+	want, exists := s.forward.Users[name]
+	if !exists || subtle.ConstantTimeCompare([]byte(password), []byte(want)) != 1 {
+		return "", false
+	}
+	return name, true
+}
+
+// handleConnect opens a CONNECT tunnel. Port 443 is handed to the HTTPS
+// server to be intercepted (or passed through by SNI) as if the client had
+// connected directly; allowed other ports are spliced to the origin unless
+// policy blocks the host or it resolves to this host.
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, "Bad CONNECT target", http.StatusBadRequest)
+		return
+	}
+	if port != "443" && !s.connectPortAllowed(port) {
+		http.Error(w, "CONNECT port not allowed", http.StatusForbidden)
+		return
+	}
+
+	var egress string
+	addr := r.Host
+	if port != "443" {
+		policyReq := &policy.ProxyRequest{
+			ClientIP:  s.extractClientIP(r),
+			Host:      host,
+			Path:      "/",
+			Method:    http.MethodConnect,
+			Encrypted: true,
+		}
+		if s.policyEngine != nil {
+			decision := s.evaluate(policyReq, &requestTiming{})
+			if decision.Action == policy.ActionBlock {
+				s.logRequest(policyReq, decision, http.StatusForbidden, 0, 0, &requestTiming{})
+				http.Error(w, "Blocked by KProxy", http.StatusForbidden)
+				return
+			}
+			egress = decision.Egress
+		}
+
+		// The proxy's own listeners and services bound to loopback, such
+		// as storage, aren't for clients. The address checked is the one
+		// dialed, so the name can't resolve elsewhere in between. Through
+		// an egress proxy, the proxy resolves the name.
+		if e, _ := s.egressFor(egress); e == nil || e.proxyURL == nil {
+			ip, err := s.connectIP(r.Context(), host)
+			if errors.Is(err, errProxyHost) {
+				s.logger.Warn().Str("host", r.Host).Str("client", r.RemoteAddr).Msg("Refusing CONNECT to the proxy host")
+				http.Error(w, "CONNECT to the proxy host not allowed", http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to resolve CONNECT target", http.StatusBadGateway)
+				return
+			}
+			addr = net.JoinHostPort(ip.String(), port)
+		}
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "CONNECT not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Failed to take over CONNECT connection")
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		_ = conn.Close()
+		return
+	}
+	// A client connecting by address sends no SNI; treat it like a
+	// redirected connection to that address
+	var inner net.Conn = conn
+	if ip := net.ParseIP(host); ip != nil && port == "443" {
+		key := conn.RemoteAddr().String()
+		s.intercepted.Store(key, &net.TCPAddr{IP: ip, Port: 443})
+		inner = &interceptedConn{Conn: conn, forget: func() { s.intercepted.Delete(key) }}
+	}
+	// Anything the client sent after the CONNECT is still in the buffer
+	tunnelled := &replayConn{Conn: inner, r: brw.Reader}
+
+	if port == "443" {
+		if !s.connects.push(tunnelled) {
+			_ = conn.Close()
+		}
+		return
+	}
+
+	go func() {
+		defer func() { _ = tunnelled.Close() }()
+		dial, err := s.dialFor(egress)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("host", r.Host).Msg("CONNECT tunnel not opened")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), passthroughDialTimeout)
+		upstream, err := dial(ctx, "tcp", addr)
+		cancel()
+		if err != nil {
+			s.logger.Warn().Err(err).Str("host", r.Host).Msg("Failed to connect to origin for CONNECT")
+			return
+		}
+		defer func() { _ = upstream.Close() }()
+
+		done := make(chan struct{})
+		go func() {
+			_, _ = io.Copy(upstream, tunnelled)
+			closeWrite(upstream)
+			close(done)
+		}()
+		_, _ = io.Copy(tunnelled, upstream)
+		closeWrite(conn)
+		<-done
+	}()
+}
+
+// errProxyHost refuses CONNECT tunnels to the proxy host itself
+var errProxyHost = errors.New("CONNECT target is the proxy host")
+
+// connectPortAllowed reports whether CONNECT may reach port, other than
+// 443: only ports listed in connect_ports
+func (s *Server) connectPortAllowed(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && slices.Contains(s.forward.ConnectPorts, n)
+}
+
+// connectIP resolves the host of a CONNECT tunnel to the address to dial,
+// failing with errProxyHost when any of its addresses is on this host
+func (s *Server) connectIP(ctx context.Context, host string) (net.IP, error) {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = s.lookupIP(ctx, host); err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("no addresses for %s", host)
+		}
+	}
+	for _, ip := range ips {
+		if isLocalIP(ip) {
+			return nil, errProxyHost
+		}
+	}
+	return ips[0], nil
+}
+
+// resolveIP looks up the addresses of host
+func resolveIP(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+// isLocalIP reports whether ip is on this host: a loopback address or one
+// of its interface addresses
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// startForward serves the forward proxy, passing CONNECT tunnels to port
+// 443 on to the HTTPS server
+func (s *Server) startForward(errChan chan<- error) {
+	s.logger.Info().Str("addr", s.forwardServer.Addr).Msg("Starting forward proxy server")
+
+	s.connects = newConnListener(&net.TCPAddr{})
+	go func() {
+		passthrough := newPassthroughListener(s.connects, s.routeTLS)
+		err := s.httpsServer.Serve(tls.NewListener(passthrough, s.httpsServer.TLSConfig))
+		if err != nil && err != http.ErrServerClosed {
+			errChan <- fmt.Errorf("forward proxy tunnel error: %w", err)
+		}
+	}()
+	go func() {
+		err := s.forwardServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			errChan <- fmt.Errorf("forward proxy server error: %w", err)
+		}
+	}()
+}
+
+// servesPAC reports whether a request on the HTTP port is for the PAC file:
+// its path on the server name, or on a "wpad" host found by WPAD
+func (s *Server) servesPAC(r *http.Request, host string) bool {
+	if s.forward == nil || (r.URL.Path != wpadPath && r.URL.Path != pacPath) {
+		return false
+	}
+	return s.matchesServerName(host) || host == "wpad" || strings.HasPrefix(host, "wpad.")
+}
+
+// servePAC serves a proxy auto-config file sending clients to the forward
+// proxy, except for plain host names and local domains
+func (s *Server) servePAC(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	_, _ = fmt.Fprintf(w, `function FindProxyForURL(url, host) {
+  if (isPlainHostName(host) || dnsDomainIs(host, ".local") || dnsDomainIs(host, ".lan")) {
+    return "DIRECT";
+  }
+  return "PROXY %s";
+}
+`, s.forward.PACProxy)
+}
+
+// connListener hands connections from CONNECT tunnels to the HTTPS server
+type connListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// push queues a connection for Accept, reporting false once closed
+func (l *connListener) push(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/identity"
+	"github.com/rs/zerolog"
+)
+
+func TestForwardProxyAuth(t *testing.T) {
+	s := NewServer(Config{ForwardProxy: &ForwardProxyConfig{
+		Users: map[string]string{"alice": "secret"},
+	}}, nil, nil, zerolog.Nop())
+	users := identity.NewRegistry(time.Minute)
+	s.SetUserRegistry(users)
+
+	tests := []struct {
+		user, password string
+		want           bool
+	}{
+		{"", "", false},
+		{"alice", "wrong", false},
+		{"bob", "secret", false},
+		{"alice", "secret", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.RemoteAddr = "192.168.1.20:50000"
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.password)
+			r.Header.Set("Proxy-Authorization", r.Header.Get("Authorization"))
+			r.Header.Del("Authorization")
+		}
+		rec := httptest.NewRecorder()
+		if got := s.authenticate(rec, r); got != tt.want {
+			t.Errorf("%s/%s: authenticated = %v, want %v", tt.user, tt.password, got, tt.want)
+		}
+		if !tt.want && (rec.Code != http.StatusProxyAuthRequired || rec.Header().Get("Proxy-Authenticate") == "") {
+			t.Errorf("%s/%s: got %d without a challenge", tt.user, tt.password, rec.Code)
+		}
+	}
+
+	// The user is identified at their address for policy
+	if name, source, ok := users.LookupUser(net.ParseIP("192.168.1.20"), nil); !ok || name != "alice" || source != identity.SourceProxy {
+		t.Errorf("user = %q (%q, %v), want alice from the proxy", name, source, ok)
+	}
+}
+
+func TestPAC(t *testing.T) {
+	s := NewServer(Config{
+		ServerName:   "local.kproxy",
+		ForwardProxy: &ForwardProxyConfig{PACProxy: "192.168.1.1:3128"},
+	}, nil, nil, zerolog.Nop())
+
+	rec := httptest.NewRecorder()
+	s.handleForward(rec, httptest.NewRequest(http.MethodGet, "/wpad.dat", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `return "PROXY 192.168.1.1:3128";`) {
+		t.Errorf("PAC file = %d %q", rec.Code, rec.Body.String())
+	}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://local.kproxy/proxy.pac", true},
+		{"http://wpad.home.arpa/wpad.dat", true},
+		{"http://wpad/wpad.dat", true},
+		{"http://example.com/wpad.dat", false},
+		{"http://wpad.home.arpa/index.html", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if got := s.servesPAC(r, r.Host); got != tt.want {
+			t.Errorf("%s: serves PAC = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+// connect sends a CONNECT for target to the proxy at addr, followed by
+// payload, returning the response and a reader for the tunnel
+func connect(t *testing.T, addr, target, payload string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n"+payload); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, br
+}
+
+func TestConnectTunnel(t *testing.T) {
+	// An echo server on a port other than 443 is tunnelled, not intercepted
+	s := NewServer(Config{ForwardProxy: &ForwardProxyConfig{ConnectPorts: []int{7}}}, nil, nil, zerolog.Nop())
+	s.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("192.0.2.7")}, nil
+	}
+	s.dialOrigin = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// The address checked is the one dialed
+		if addr != "192.0.2.7:7" {
+			t.Errorf("dialed %s, want 192.0.2.7:7", addr)
+		}
+		upstream, origin := net.Pipe()
+		go func() {
+			defer func() { _ = origin.Close() }()
+			_, _ = io.Copy(origin, origin)
+		}()
+		return upstream, nil
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(s.handleForward))
+	defer proxy.Close()
+
+	resp, br := connect(t, proxy.Listener.Addr().String(), "echo.example:7", "ping")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT status = %d", resp.StatusCode)
+	}
+
+	// Bytes sent along with the CONNECT reach the origin too
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(br, got); err != nil || string(got) != "ping" {
+		t.Errorf("echo = %q (%v), want ping", got, err)
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	s := NewServer(Config{ForwardProxy: &ForwardProxyConfig{ConnectPorts: []int{6379}}}, nil, nil, zerolog.Nop())
+	s.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("192.0.2.7"), net.ParseIP("127.0.0.1")}, nil
+	}
+	s.dialOrigin = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		t.Errorf("dialed %s, want the tunnel refused first", addr)
+		return nil, errors.New("refused")
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(s.handleForward))
+	defer proxy.Close()
+
+	for _, tt := range []struct {
+		target string
+		why    string
+	}{
+		// Services on this host, like storage bound to loopback
+		{"127.0.0.1:6379", "to loopback"},
+		{"redis.example:6379", "to a name resolving to loopback"},
+		// Ports other than 443 must be listed
+		{"chat.example:6380", "to a port not allowed"},
+	} {
+		resp, _ := connect(t, proxy.Listener.Addr().String(), tt.target, "")
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("CONNECT %s: status = %d, want %d", tt.why, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+}
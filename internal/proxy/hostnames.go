@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+// hostnameCacheSize is the number of addresses whose server name is kept
+const hostnameCacheSize = 10000
+
+// reverseLookupTimeout bounds how long a request waits for a PTR lookup
+const reverseLookupTimeout = 500 * time.Millisecond
+
+// reverseLookupTTL is how long PTR results, including failures, are kept
+const reverseLookupTTL = time.Hour
+
+// hostnames remembers server names seen for IP addresses, so requests made
+// directly to an IP (with no DNS query to link them to a name) can still be
+// reported under a recognisable name. Names are learned from upstream
+// connections only: the Host header of named requests and the certificates
+// of servers reached by IP. The SNI a client sends is its own claim.
+type hostnames struct {
+	names *lru.Cache[string, string]
+
+	// DNS names of the certificates servers reached by IP presented
+	certNames *lru.Cache[string, []string]
+
+	// Reverse DNS results (nil disables reverse lookups)
+	ptr        *expirable.LRU[string, []string]
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+}
+
+func newHostnames(reverseLookup bool) *hostnames {
+	// lru.New only fails for a non-positive size
+	names, _ := lru.New[string, string](hostnameCacheSize)
+	certNames, _ := lru.New[string, []string](hostnameCacheSize)
+	h := &hostnames{
+		names:      names,
+		certNames:  certNames,
+		lookupAddr: net.DefaultResolver.LookupAddr,
+	}
+	if reverseLookup {
+		h.ptr = expirable.NewLRU[string, []string](hostnameCacheSize, nil, reverseLookupTTL)
+	}
+	return h
+}
+
+// learn records name as the server name for ip. IP literals are ignored.
+func (h *hostnames) learn(ip net.IP, name string) {
+	name = normalizeName(name)
+	if ip == nil || name == "" {
+		return
+	}
+	h.names.Add(ip.String(), name)
+}
+
+// lookup returns the server name last seen for ip
+func (h *hostnames) lookup(ip net.IP) (string, bool) {
+	return h.names.Get(ip.String())
+}
+
+// learnFromConn records name against the remote address of an upstream connection
+func (h *hostnames) learnFromConn(conn net.Conn, name string) {
+	if conn == nil {
+		return
+	}
+	h.learn(hostIP(conn.RemoteAddr().String()), name)
+}
+
+// learnCert records the name a server reached by ip was issued its
+// certificate for, and the certificate's DNS names
+func (h *hostnames) learnCert(ip net.IP, cert *x509.Certificate) {
+	if ip == nil {
+		return
+	}
+	h.learn(ip, certName(cert))
+
+	names := make([]string, 0, len(cert.DNSNames))
+	for _, name := range cert.DNSNames {
+		if name = normalizeName(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	h.certNames.Add(ip.String(), names)
+}
+
+// certifies reports whether the certificate last seen for ip covers name
+func (h *hostnames) certifies(ip net.IP, name string) bool {
+	name = normalizeName(name)
+	if name == "" {
+		return false
+	}
+	patterns, _ := h.certNames.Get(ip.String())
+	for _, pattern := range patterns {
+		if pattern == name {
+			return true
+		}
+		// A wildcard covers exactly one label
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if i := strings.IndexByte(name, '.'); i > 0 && name[i+1:] == suffix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serverNames returns the names a request made directly to ip can be
+// classified by, most trustworthy first: the name learned for the address
+// from upstream, then the SNI name if the address's certificate covers it.
+// An SNI name nothing confirms is ignored, so a client can't pick the name
+// its request is judged by. Reverse DNS is only consulted when no name is
+// known.
+func (h *hostnames) serverNames(ctx context.Context, ip net.IP, sni string) []string {
+	names := []string{}
+	add := func(name string) {
+		if name = normalizeName(name); name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	if name, ok := h.lookup(ip); ok {
+		add(name)
+	}
+	if h.certifies(ip, sni) {
+		add(sni)
+	}
+	if len(names) == 0 {
+		for _, name := range h.reverse(ctx, ip) {
+			add(name)
+		}
+	}
+	return names
+}
+
+// reverse returns the PTR names for ip, from cache where possible
+func (h *hostnames) reverse(ctx context.Context, ip net.IP) []string {
+	if h.ptr == nil {
+		return nil
+	}
+
+	key := ip.String()
+	if names, ok := h.ptr.Get(key); ok {
+		return names
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reverseLookupTimeout)
+	defer cancel()
+
+	names, err := h.lookupAddr(ctx, key)
+	if err != nil {
+		names = nil
+	}
+	h.ptr.Add(key, names)
+	return names
+}
+
+// displayHost returns the learned server name for a request to an IP
+// address, or host unchanged
+func (h *hostnames) displayHost(host string) string {
+	if ip := hostIP(host); ip != nil {
+		if name, ok := h.lookup(ip); ok {
+			return name
+		}
+	}
+	return host
+}
+
+// normalizeName lowercases a server name and strips the trailing dot. IP
+// literals aren't names and normalize to "".
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if net.ParseIP(name) != nil {
+		return ""
+	}
+	return name
+}
+
+// hostIP returns the IP address of a host (with or without a port), or nil
+// if the host is a name
+func hostIP(host string) net.IP {
+	return net.ParseIP(strings.Trim(hostWithoutPort(host), "[]"))
+}
+
+// hostWithoutPort strips the port, if any, from a host
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// certName returns the server name a certificate was issued for: its first
+// DNS name (a wildcard stands for its parent domain), or the common name
+func certName(cert *x509.Certificate) string {
+	for _, name := range cert.DNSNames {
+		if name = strings.TrimPrefix(name, "*."); name != "" {
+			return name
+		}
+	}
+	if net.ParseIP(cert.Subject.CommonName) == nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
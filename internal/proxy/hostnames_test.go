@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestHostnames_DisplayHost(t *testing.T) {
+	h := newHostnames(false)
+	h.learn(net.ParseIP("203.0.113.10"), "Video.Example.com.")
+	h.learn(net.ParseIP("2001:db8::1"), "v6.example.com")
+
+	// IP literals are never learned as names
+	h.learn(net.ParseIP("203.0.113.20"), "203.0.113.20")
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"203.0.113.10", "video.example.com"},
+		{"203.0.113.10:8443", "video.example.com"},
+		{"[2001:db8::1]:443", "v6.example.com"},
+		{"203.0.113.20", "203.0.113.20"},
+		{"198.51.100.1", "198.51.100.1"},
+		{"www.example.com", "www.example.com"},
+	}
+
+	for _, tt := range tests {
+		if got := h.displayHost(tt.host); got != tt.want {
+			t.Errorf("displayHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHostnames_LearnFromConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	h := newHostnames(false)
+	h.learnFromConn(conn, "local.example.com")
+
+	if name, ok := h.lookup(net.ParseIP("127.0.0.1")); !ok || name != "local.example.com" {
+		t.Errorf("lookup = %q, %v; want local.example.com", name, ok)
+	}
+}
+
+func TestHostnames_ServerNames(t *testing.T) {
+	h := newHostnames(true)
+	lookups := 0
+	h.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		if addr == "203.0.113.30" {
+			return []string{"host-30.Example.net."}, nil
+		}
+		return nil, errors.New("no PTR record")
+	}
+	h.learn(net.ParseIP("203.0.113.10"), "video.example.com")
+	h.learnCert(net.ParseIP("203.0.113.20"), &x509.Certificate{DNSNames: []string{"cdn.example.com", "*.cdn.example.com"}})
+
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		ip   string
+		sni  string
+		want []string
+	}{
+		{"learned name, SNI not confirmed", "203.0.113.10", "api.example.com", []string{"video.example.com"}},
+		{"duplicates dropped", "203.0.113.10", "video.example.com", []string{"video.example.com"}},
+		{"SNI covered by the certificate", "203.0.113.20", "img.CDN.example.com", []string{"cdn.example.com", "img.cdn.example.com"}},
+		{"SNI outside the certificate", "203.0.113.20", "a.img.cdn.example.com", []string{"cdn.example.com"}},
+		{"reverse DNS as a last resort", "203.0.113.30", "", []string{"host-30.example.net"}},
+		{"SNI alone not trusted", "203.0.113.40", "video.example.com", []string{}},
+		{"unclassifiable", "203.0.113.40", "", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := h.serverNames(ctx, net.ParseIP(tt.ip), tt.sni)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("serverNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// PTR results, including failures, are cached
+	h.serverNames(ctx, net.ParseIP("203.0.113.30"), "")
+	h.serverNames(ctx, net.ParseIP("203.0.113.40"), "")
+	if lookups != 2 {
+		t.Errorf("reverse lookups = %d, want 2", lookups)
+	}
+
+	// Reverse lookups can be disabled
+	h = newHostnames(false)
+	h.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		t.Fatal("unexpected reverse lookup")
+		return nil, nil
+	}
+	if got := h.serverNames(ctx, net.ParseIP("203.0.113.30"), ""); len(got) != 0 {
+		t.Errorf("serverNames() = %v, want none", got)
+	}
+}
+
+func TestCertName(t *testing.T) {
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want string
+	}{
+		{"first SAN", &x509.Certificate{DNSNames: []string{"cdn.example.com", "example.com"}}, "cdn.example.com"},
+		{"wildcard SAN", &x509.Certificate{DNSNames: []string{"*.example.net"}}, "example.net"},
+		{"common name", &x509.Certificate{Subject: pkix.Name{CommonName: "legacy.example.org"}}, "legacy.example.org"},
+		{"IP common name", &x509.Certificate{Subject: pkix.Name{CommonName: "192.0.2.1"}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := certName(tt.cert); got != tt.want {
+				t.Errorf("certName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxInjectBody is the largest (decoded) body the timer banner is injected
+// into; larger responses are passed through unchanged
+const maxInjectBody = 4 << 20
+
+// TimerInjectionConfig selects the responses the remaining-time banner is
+// injected into
+type TimerInjectionConfig struct {
+	DisabledHosts []string // Host patterns never modified, e.g. "*.bank.com" or "secure.*"
+	ContentTypes  []string // Media types modified (default text/html)
+}
+
+// timerInjector adds a banner with the time left on a usage limit to HTML
+// responses, for decisions with InjectTimer set. Only identity and gzip
+// bodies can be rewritten; the upstream request is narrowed to those
+// encodings so servers don't answer with brotli or zstd.
+type timerInjector struct {
+	disabledHosts []string
+	contentTypes  []string
+}
+
+// newTimerInjector creates a timer injector
+func newTimerInjector(config TimerInjectionConfig) *timerInjector {
+	t := &timerInjector{contentTypes: []string{"text/html"}}
+	for _, host := range config.DisabledHosts {
+		t.disabledHosts = append(t.disabledHosts, strings.ToLower(host))
+	}
+	if len(config.ContentTypes) > 0 {
+		t.contentTypes = nil
+		for _, contentType := range config.ContentTypes {
+			t.contentTypes = append(t.contentTypes, strings.ToLower(contentType))
+		}
+	}
+	return t
+}
+
+// applies reports whether responses from host may be modified. A nil
+// injector modifies nothing.
+func (t *timerInjector) applies(r *http.Request) bool {
+	if t == nil || r.Method == http.MethodHead {
+		return false
+	}
+	host := strings.ToLower(hostWithoutPort(r.Host))
+	for _, pattern := range t.disabledHosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// prepare asks upstream for a body the injector can decode, and for the
+// whole resource rather than a range of it
+func (t *timerInjector) prepare(req *http.Request) {
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Del("Range")
+}
+
+// inject rewrites resp to show the banner. It reports whether the response
+// was modified; anything it can't handle is left untouched.
+func (t *timerInjector) inject(resp *http.Response, banner []byte) bool {
+	if resp.StatusCode != http.StatusOK || !t.modifies(resp.Header.Get("Content-Type")) {
+		return false
+	}
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if encoding != "" && encoding != "identity" && encoding != "gzip" {
+		return false
+	}
+
+	// Read the body, giving up (and restoring what was read) if it is too
+	// large to buffer
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxInjectBody+1))
+	if err != nil || len(raw) > maxInjectBody {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(raw), resp.Body), resp.Body}
+		return false
+	}
+
+	page := raw
+	if encoding == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			resp.Body = readCloser{bytes.NewReader(raw), resp.Body}
+			return false
+		}
+		page, err = io.ReadAll(io.LimitReader(zr, maxInjectBody+1))
+		if err != nil || len(page) > maxInjectBody {
+			resp.Body = readCloser{bytes.NewReader(raw), resp.Body}
+			return false
+		}
+	}
+
+	page = insertBanner(page, banner)
+
+	if encoding == "gzip" {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(page)
+		_ = zw.Close()
+		page = buf.Bytes()
+	}
+
+	resp.Body = readCloser{bytes.NewReader(page), resp.Body}
+	resp.ContentLength = int64(len(page))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(page)))
+	// The body no longer matches the origin's validators
+	resp.Header.Del("ETag")
+	resp.Header.Del("Content-MD5")
+	return true
+}
+
+// modifies reports whether a Content-Type is one of the configured types
+func (t *timerInjector) modifies(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range t.contentTypes {
+		if mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// insertBanner puts the banner at the end of the page's body, or at the end
+// of the document if there is no closing body tag
+func insertBanner(page, banner []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body"))
+	if i < 0 {
+		return append(page, banner...)
+	}
+	out := make([]byte, 0, len(page)+len(banner))
+	out = append(out, page[:i]...)
+	out = append(out, banner...)
+	return append(out, page[i:]...)
+}
+
+// timerBanner renders the remaining-time banner
+func timerBanner(category string, remaining time.Duration) []byte {
+	left := "Less than a minute"
+	if remaining >= time.Minute {
+		left = minutesText(remaining)
+	}
+	if category != "" {
+		left += " of " + html.EscapeString(category)
+	}
+
+	return []byte(`<div id="kproxy-timer" style="position:fixed;bottom:12px;right:12px;z-index:2147483647;` +
+		`padding:8px 14px;border-radius:8px;background:rgba(51,51,51,0.9);color:#fff;` +
+		`font:14px -apple-system,BlinkMacSystemFont,'Segoe UI',Roboto,sans-serif;pointer-events:none">` +
+		left + ` left today</div>`)
+}
+
+// minutesText describes a duration in whole minutes, e.g. "25 minutes"
+func minutesText(d time.Duration) string {
+	if minutes := int(d / time.Minute); minutes != 1 {
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	return "1 minute"
+}
+
+// readCloser reads from a replacement body and closes the original
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
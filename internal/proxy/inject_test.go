@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+)
+
+func TestTimerInjection(t *testing.T) {
+	const page = "<html><body><p>game</p></BODY></html>"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gzip":
+			if r.Header.Get("Accept-Encoding") != "gzip" {
+				t.Errorf("Accept-Encoding = %q, want gzip", r.Header.Get("Accept-Encoding"))
+			}
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = io.WriteString(zw, page)
+			_ = zw.Close()
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(buf.Bytes())
+		case "/brotli":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = io.WriteString(w, page)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"page": "</body>"}`)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = io.WriteString(w, page)
+		}
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Config{TimerInjection: &TimerInjectionConfig{DisabledHosts: []string{"secure.*"}}}, nil, nil, zerolog.Nop())
+	s.transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, upstream.Listener.Addr().String())
+	}
+	timer := &policy.PolicyDecision{InjectTimer: true, Category: "gaming", TimeRemaining: 25 * time.Minute}
+
+	for _, tt := range []struct {
+		name     string
+		url      string
+		decision *policy.PolicyDecision
+		inject   bool
+	}{
+		{name: "plain", url: "http://games.example/", decision: timer, inject: true},
+		{name: "gzip", url: "http://games.example/gzip", decision: timer, inject: true},
+		{name: "brotli", url: "http://games.example/brotli", decision: timer},
+		{name: "not html", url: "http://games.example/json", decision: timer},
+		{name: "disabled host", url: "http://secure.example/", decision: timer},
+		{name: "no timer", url: "http://games.example/", decision: &policy.PolicyDecision{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.RequestURI = req.URL.RequestURI() // As received by the proxy
+			rec := httptest.NewRecorder()
+			s.handleProxy(rec, req, nil, false, tt.decision, &requestTiming{})
+
+			resp := rec.Result()
+			var body io.Reader = resp.Body
+			if resp.Header.Get("Content-Encoding") == "gzip" {
+				zr, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("invalid gzip body: %v", err)
+				}
+				body = zr
+			}
+			b, _ := io.ReadAll(body)
+
+			injected := strings.Contains(string(b), `id="kproxy-timer"`)
+			if injected != tt.inject {
+				t.Fatalf("banner injected = %v, want %v: %s", injected, tt.inject, b)
+			}
+			if !tt.inject {
+				return
+			}
+			if !strings.Contains(string(b), "25 minutes of gaming left today</div></BODY>") {
+				t.Errorf("banner not before </body>: %s", b)
+			}
+			if resp.Header.Get("ETag") != "" {
+				t.Error("ETag kept on a modified page")
+			}
+		})
+	}
+}
+
+func TestInsertBanner(t *testing.T) {
+	if got := string(insertBanner([]byte("<p>no body tag"), []byte("<b>"))); got != "<p>no body tag<b>" {
+		t.Errorf("insertBanner() = %q, want the banner appended", got)
+	}
+	if got := string(timerBanner("", 30*time.Second)); !strings.Contains(got, "Less than a minute left today") {
+		t.Errorf("timerBanner() = %q", got)
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"fmt"
+	"html"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy"
+)
+
+// noticeRepeat is how long a client isn't shown the same notice again. A
+// notice is shown once per threshold, but a usage limit can stay within
+// one for hours while the device is idle, so it comes back after a while.
+const noticeRepeat = time.Hour
+
+// noticeLog remembers the notices shown to each client, so that a notice
+// is shown on one page when its threshold is reached rather than on every
+// page after that
+type noticeLog struct {
+	mu    sync.Mutex
+	shown map[string]time.Time // client, kind, category and threshold -> when shown
+}
+
+func newNoticeLog() *noticeLog {
+	return &noticeLog{shown: make(map[string]time.Time)}
+}
+
+// due reports whether the notice still has to be shown to the client
+func (l *noticeLog) due(client string, notice *policy.Notice) bool {
+	if notice == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	shownAt, ok := l.shown[noticeKey(client, notice)]
+	return !ok || time.Since(shownAt) >= noticeRepeat
+}
+
+// record notes that the notice was shown to the client
+func (l *noticeLog) record(client string, notice *policy.Notice) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, shownAt := range l.shown {
+		if now.Sub(shownAt) >= noticeRepeat {
+			delete(l.shown, key)
+		}
+	}
+	l.shown[noticeKey(client, notice)] = now
+}
+
+func noticeKey(client string, notice *policy.Notice) string {
+	return fmt.Sprintf("%s|%s|%s|%d", client, notice.Kind, notice.Category, notice.Threshold)
+}
+
+// noticeBanner renders the banner warning of a notice
+func noticeBanner(notice *policy.Notice) []byte {
+	text := "Bedtime in " + minutesText(notice.Remaining)
+	if notice.Kind == "usage_limit" {
+		text = minutesText(notice.Remaining) + " of " + html.EscapeString(notice.Category) + " left today"
+	}
+
+	return []byte(`<div id="kproxy-notice" style="position:fixed;bottom:12px;left:12px;z-index:2147483647;` +
+		`padding:8px 14px;border-radius:8px;background:rgba(230,126,34,0.95);color:#fff;` +
+		`font:bold 14px -apple-system,BlinkMacSystemFont,'Segoe UI',Roboto,sans-serif;pointer-events:none">` +
+		text + `</div>`)
+}
@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+)
+
+func TestNoticeShownOncePerThreshold(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html><body><p>homework</p></body></html>")
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Config{TimerInjection: &TimerInjectionConfig{}}, nil, nil, zerolog.Nop())
+
+	page := func(notice *policy.Notice) string {
+		req := httptest.NewRequest(http.MethodGet, upstream.URL+"/", nil)
+		req.RequestURI = req.URL.RequestURI() // As received by the proxy
+		req.Host = upstream.Listener.Addr().String()
+		rec := httptest.NewRecorder()
+		s.handleProxy(rec, req, nil, false, &policy.PolicyDecision{Action: policy.ActionAllow, Notice: notice}, &requestTiming{})
+		b, _ := io.ReadAll(rec.Result().Body)
+		return string(b)
+	}
+
+	fifteen := &policy.Notice{Kind: "bedtime", Remaining: 12 * time.Minute, Threshold: 15}
+	if got := page(fifteen); !strings.Contains(got, "Bedtime in 12 minutes</div></body>") {
+		t.Fatalf("expected the bedtime notice: %s", got)
+	}
+	if got := page(&policy.Notice{Kind: "bedtime", Remaining: 11 * time.Minute, Threshold: 15}); strings.Contains(got, "kproxy-notice") {
+		t.Errorf("expected the notice only once per threshold: %s", got)
+	}
+
+	// The next threshold is shown again, as are other notices
+	if got := page(&policy.Notice{Kind: "bedtime", Remaining: 5 * time.Minute, Threshold: 5}); !strings.Contains(got, "Bedtime in 5 minutes") {
+		t.Errorf("expected the 5 minute notice: %s", got)
+	}
+	limit := &policy.Notice{Kind: "usage_limit", Category: "gaming", Remaining: time.Minute, Threshold: 5}
+	if got := page(limit); !strings.Contains(got, "1 minute of gaming left today") {
+		t.Errorf("expected the usage limit notice: %s", got)
+	}
+
+	// Notices come back once noticeRepeat has passed
+	s.notices.shown[noticeKey("192.0.2.1", fifteen)] = time.Now().Add(-noticeRepeat) // httptest's client address
+	if got := page(fifteen); !strings.Contains(got, "kproxy-notice") {
+		t.Errorf("expected the notice to be shown again: %s", got)
+	}
+}
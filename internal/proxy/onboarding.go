@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/ca"
+)
+
+// handleClientSetup handles client setup routes for server.name: the
+// onboarding page and the root certificate in the format each platform
+// installs
+func (s *Server) handleClientSetup(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debug().
+		Str("path", r.URL.Path).
+		Msg("Serving client setup route")
+
+	switch r.URL.Path {
+	case "/ca.crt", "/setup/ca.crt":
+		s.serveRootCertificate(w, r, "pem")
+	case "/ca.der", "/ca.cer":
+		s.serveRootCertificate(w, r, "der")
+	case "/ca.mobileconfig":
+		s.serveRootCertificate(w, r, "mobileconfig")
+	case "/", "/setup", "/setup/":
+		s.serveSetupPage(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// servesSetupOverHTTP reports whether a plain HTTP request to server.name
+// is answered rather than redirected to HTTPS. Certificate downloads always
+// are: devices fetch them before they trust KProxy. The onboarding page is
+// too, unless a Let's Encrypt certificate makes HTTPS work without the root.
+func (s *Server) servesSetupOverHTTP(path string) bool {
+	if s.rootCertificate() == nil {
+		return false
+	}
+	switch path {
+	case "/ca.crt", "/setup/ca.crt", "/ca.der", "/ca.cer", "/ca.mobileconfig":
+		return true
+	case "/", "/setup", "/setup/":
+		return s.letsEncryptCert(s.serverName) == nil
+	}
+	return false
+}
+
+// serveRootCertificate serves the root CA certificate for installation, as
+// PEM (most platforms), DER (Windows, Android) or an Apple configuration
+// profile (iOS, iPadOS, macOS)
+func (s *Server) serveRootCertificate(w http.ResponseWriter, r *http.Request, format string) {
+	root := s.rootCertificate()
+	if root == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var body []byte
+	switch format {
+	case "der":
+		body = root.Raw
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename=kproxy-root-ca.cer")
+	case "mobileconfig":
+		profile, err := ca.MobileConfig(root, s.serverName)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Failed to render configuration profile")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		body = profile
+		w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+		w.Header().Set("Content-Disposition", "attachment; filename=kproxy-root-ca.mobileconfig")
+	default:
+		body = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename=kproxy-root-ca.crt")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(body); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to write certificate")
+	}
+
+	s.logger.Info().
+		Str("client", s.extractClientIP(r).String()).
+		Str("format", format).
+		Msg("Root certificate downloaded")
+}
+
+// rootCertificate returns the live root CA certificate, nil without one
+func (s *Server) rootCertificate() *x509.Certificate {
+	if s.ca == nil {
+		return nil
+	}
+	return s.ca.RootCertificate()
+}
+
+// onboardingPlatform guesses the platform of a browser from its User-Agent,
+// to open its instructions first. iPads asking for desktop sites claim to
+// be Macs; both install the configuration profile.
+func onboardingPlatform(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		return "ios"
+	case strings.Contains(userAgent, "Android"):
+		return "android"
+	case strings.Contains(userAgent, "CrOS"):
+		return "chromeos"
+	case strings.Contains(userAgent, "Macintosh"):
+		return "macos"
+	case strings.Contains(userAgent, "Windows"):
+		return "windows"
+	case strings.Contains(userAgent, "Linux"):
+		return "linux"
+	}
+	return ""
+}
+
+// onboardingPage explains how to install the root certificate on each
+// platform, with the details of the live root to check against
+var onboardingPage = template.Must(template.New("onboarding").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>KProxy Client Setup</title>
+	<style>
+		* { margin: 0; padding: 0; box-sizing: border-box; }
+		body {
+			font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+			background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+			min-height: 100vh;
+			display: flex;
+			align-items: center;
+			justify-content: center;
+			padding: 20px;
+		}
+		.container {
+			background: white;
+			border-radius: 16px;
+			padding: 40px;
+			max-width: 640px;
+			box-shadow: 0 20px 60px rgba(0,0,0,0.3);
+		}
+		.logo { font-size: 48px; text-align: center; margin-bottom: 20px; }
+		h1 { color: #333; margin-bottom: 16px; text-align: center; }
+		p { color: #666; line-height: 1.6; margin-bottom: 24px; }
+		details {
+			background: #f8f9fa;
+			border-radius: 8px;
+			margin-bottom: 12px;
+			padding: 16px 20px;
+		}
+		summary { cursor: pointer; font-weight: bold; color: #333; }
+		ol { color: #666; line-height: 1.6; margin: 12px 0 16px 20px; }
+		.download-btn {
+			display: block;
+			padding: 12px;
+			background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+			color: white;
+			text-align: center;
+			text-decoration: none;
+			border-radius: 8px;
+			font-weight: bold;
+		}
+		.fingerprint {
+			font-family: monospace;
+			font-size: 12px;
+			word-break: break-all;
+			color: #333;
+		}
+		.info { font-size: 14px; color: #999; margin-top: 24px; text-align: center; }
+	</style>
+</head>
+<body>
+	<div class="container">
+		<div class="logo">🔒</div>
+		<h1>KProxy Client Setup</h1>
+		<p>To browse through KProxy, install its root certificate on this device. Choose your device below and follow the steps.</p>
+
+		<details{{if eq .Platform "ios"}} open{{end}}>
+			<summary>iPhone and iPad</summary>
+			<ol>
+				<li>Open this page in Safari and download the profile; allow the download.</li>
+				<li>Open Settings, tap <em>Profile Downloaded</em> and tap Install.</li>
+				<li>Go to Settings, General, About, Certificate Trust Settings and turn on full trust for {{.Subject}}.</li>
+			</ol>
+			<a href="/ca.mobileconfig" class="download-btn">Download Profile</a>
+		</details>
+
+		<details{{if eq .Platform "macos"}} open{{end}}>
+			<summary>Mac</summary>
+			<ol>
+				<li>Download the profile and open it.</li>
+				<li>Open System Settings, Privacy &amp; Security, Profiles, and install <em>KProxy Root Certificate</em>.</li>
+			</ol>
+			<a href="/ca.mobileconfig" class="download-btn">Download Profile</a>
+		</details>
+
+		<details{{if eq .Platform "android"}} open{{end}}>
+			<summary>Android</summary>
+			<ol>
+				<li>Download the certificate.</li>
+				<li>Open Settings, Security (or Security &amp; privacy), Encryption &amp; credentials, Install a certificate, CA certificate.</li>
+				<li>Choose <em>kproxy-root-ca.cer</em> from Downloads.</li>
+			</ol>
+			<a href="/ca.der" class="download-btn">Download Certificate</a>
+		</details>
+
+		<details{{if eq .Platform "windows"}} open{{end}}>
+			<summary>Windows</summary>
+			<ol>
+				<li>Download the certificate and open it.</li>
+				<li>Click Install Certificate, choose Local Machine, then <em>Place all certificates in the following store</em>.</li>
+				<li>Browse to <em>Trusted Root Certification Authorities</em> and finish.</li>
+			</ol>
+			<a href="/ca.der" class="download-btn">Download Certificate</a>
+		</details>
+
+		<details{{if eq .Platform "chromeos"}} open{{end}}>
+			<summary>Chromebook</summary>
+			<ol>
+				<li>Download the certificate.</li>
+				<li>Open chrome://certificate-manager (or Settings, Privacy and security, Security, Manage certificates), and import it under Authorities.</li>
+				<li>Tick <em>Trust this certificate for identifying websites</em>.</li>
+			</ol>
+			<a href="/ca.crt" class="download-btn">Download Certificate</a>
+		</details>
+
+		<details{{if eq .Platform "linux"}} open{{end}}>
+			<summary>Linux</summary>
+			<ol>
+				<li>Download the certificate.</li>
+				<li>Run <code>sudo cp kproxy-root-ca.crt /usr/local/share/ca-certificates/ &amp;&amp; sudo update-ca-certificates</code>.</li>
+				<li>Firefox keeps its own list: Settings, Privacy &amp; Security, View Certificates, Authorities, Import.</li>
+			</ol>
+			<a href="/ca.crt" class="download-btn">Download Certificate</a>
+		</details>
+
+		<p class="info">
+			{{.Subject}}, valid until {{.NotAfter}}<br>
+			Check that the SHA-256 fingerprint your device shows matches:<br>
+			<span class="fingerprint">{{.Fingerprint}}</span><br>
+			Server: {{.ServerName}}
+		</p>
+	</div>
+</body>
+</html>
+`))
+
+// serveSetupPage serves the onboarding page for installing the root
+// certificate
+func (s *Server) serveSetupPage(w http.ResponseWriter, r *http.Request) {
+	root := s.rootCertificate()
+	if root == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	fingerprint := sha256.Sum256(root.Raw)
+	var b bytes.Buffer
+	if err := onboardingPage.Execute(&b, struct {
+		Subject     string
+		NotAfter    string
+		Fingerprint string
+		ServerName  string
+		Platform    string
+	}{
+		Subject:     root.Subject.CommonName,
+		NotAfter:    root.NotAfter.Local().Format(time.DateOnly),
+		Fingerprint: strings.ReplaceAll(fmt.Sprintf("% X", fingerprint[:]), " ", ":"),
+		ServerName:  s.serverName,
+		Platform:    onboardingPlatform(r.UserAgent()),
+	}); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to render setup page")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(b.Bytes()); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to write setup page")
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/ca"
+	"github.com/rs/zerolog"
+)
+
+func TestClientSetup(t *testing.T) {
+	dir := t.TempDir()
+	authority, err := ca.NewCA(ca.Config{
+		RootCertPath:  filepath.Join(dir, "root-ca.crt"),
+		RootKeyPath:   filepath.Join(dir, "root-ca.key"),
+		CertCacheSize: 10,
+		CertValidity:  time.Hour,
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := authority.RootCertificate()
+	s := NewServer(Config{ServerName: "kproxy.lan", HTTPSPort: 443}, nil, authority, zerolog.Nop())
+
+	get := func(url, userAgent string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("User-Agent", userAgent)
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, r)
+		return rec
+	}
+
+	// Every format is served over plain HTTP, before the device trusts the root
+	rec := get("http://kproxy.lan/ca.crt", "")
+	block, _ := pem.Decode(rec.Body.Bytes())
+	if rec.Code != http.StatusOK || block == nil || !root.Equal(mustParse(t, block.Bytes)) {
+		t.Errorf("GET /ca.crt = %d, want the root in PEM", rec.Code)
+	}
+	rec = get("http://kproxy.lan/ca.der", "")
+	if rec.Code != http.StatusOK || !root.Equal(mustParse(t, rec.Body.Bytes())) {
+		t.Errorf("GET /ca.der = %d, want the root in DER", rec.Code)
+	}
+	rec = get("http://kproxy.lan/ca.mobileconfig", "")
+	profile := rec.Body.String()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-apple-aspen-config" ||
+		!strings.Contains(profile, base64.StdEncoding.EncodeToString(root.Raw)) ||
+		!strings.Contains(profile, "<string>com.apple.security.root</string>") {
+		t.Errorf("GET /ca.mobileconfig = %d (%s), want a profile with the root", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	// The onboarding page opens the visitor's platform and shows the fingerprint
+	rec = get("http://kproxy.lan/", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)")
+	page := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(page, "<details open>\n\t\t\t<summary>iPhone and iPad") {
+		t.Errorf("GET / = %d, want the page with iPhone instructions open", rec.Code)
+	}
+	fingerprint := sha256.Sum256(root.Raw)
+	if !strings.Contains(page, "KProxy Root CA") || !strings.Contains(page, strings.ReplaceAll(fmt.Sprintf("% X", fingerprint[:]), " ", ":")) {
+		t.Error("onboarding page doesn't describe the live root")
+	}
+
+	// Other paths on the server name still go to HTTPS
+	if rec := get("http://kproxy.lan/other", ""); rec.Code != http.StatusMovedPermanently {
+		t.Errorf("GET /other = %d, want %d", rec.Code, http.StatusMovedPermanently)
+	}
+}
+
+func mustParse(t *testing.T, der []byte) *x509.Certificate {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
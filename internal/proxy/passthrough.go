@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy"
+)
+
+const (
+	// helloTimeout bounds how long a client may take to send its ClientHello
+	helloTimeout = 10 * time.Second
+
+	// passthroughDialTimeout bounds connecting to the origin of a tunnel
+	passthroughDialTimeout = 10 * time.Second
+)
+
+// errHelloRead stops the TLS handshake once the ClientHello has been read
+var errHelloRead = errors.New("client hello read")
+
+// passthroughListener reads the SNI from each connection's ClientHello
+// before TLS is terminated. Connections route takes (returns true for) are
+// handled there; the rest are returned from Accept, replaying the bytes
+// read, for the TLS server to intercept as usual.
+type passthroughListener struct {
+	net.Listener
+	route func(conn net.Conn, serverName string, hello []byte) bool
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error // Accept error of the wrapped listener, set before done closes
+}
+
+// newPassthroughListener wraps a listener and starts accepting from it
+func newPassthroughListener(inner net.Listener, route func(conn net.Conn, serverName string, hello []byte) bool) *passthroughListener {
+	l := &passthroughListener{
+		Listener: inner,
+		route:    route,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// Accept returns the next connection to intercept
+func (l *passthroughListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections
+func (l *passthroughListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// acceptLoop accepts from the wrapped listener, reading each ClientHello in
+// its own goroutine so a slow client doesn't hold up the others
+func (l *passthroughListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			l.closeOnce.Do(func() {
+				l.err = err
+				close(l.done)
+			})
+			return
+		}
+		go l.handle(conn)
+	}
+}
+
+// handle reads the ClientHello and routes the connection
+func (l *passthroughListener) handle(conn net.Conn) {
+	var hello bytes.Buffer
+	_ = conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	serverName := readServerName(io.TeeReader(conn, &hello))
+	_ = conn.SetReadDeadline(time.Time{})
+
+	if serverName != "" && l.route(conn, serverName, hello.Bytes()) {
+		return
+	}
+
+	replayed := &replayConn{Conn: conn, r: io.MultiReader(&hello, conn)}
+	select {
+	case l.conns <- replayed:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+// readServerName reads a ClientHello and returns its SNI (empty if the
+// client sent none, or didn't send a ClientHello)
+func readServerName(r io.Reader) string {
+	var serverName string
+	_ = tls.Server(readOnlyConn{r: r}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	return serverName
+}
+
+// readOnlyConn lets crypto/tls parse a ClientHello from a reader without
+// answering it
+type readOnlyConn struct {
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// replayConn is a connection whose first bytes were already read; reads
+// return those bytes again before the rest of the connection
+type replayConn struct {
+	net.Conn
+	r io.Reader
+
+	// Nanoseconds spent minting the interception certificate, until the
+	// first request on the connection reports it
+	certMint atomic.Int64
+}
+
+func (c *replayConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+// routeTLS tunnels connections to hosts policy bypasses straight to the
+// origin, so certificate-pinned apps work when their traffic reaches the
+// proxy, and those to allowed hosts that want a client certificate, so the
+// client can present its own. Everything else is intercepted.
+func (s *Server) routeTLS(conn net.Conn, serverName string, hello []byte) bool {
+	if s.matchesServerName(serverName) {
+		return false
+	}
+	if s.policyEngine == nil && !s.clientCerts.Contains(serverName) {
+		return false
+	}
+	if s.maintenance != nil && s.maintenance.Active() {
+		return false
+	}
+
+	clientIP := net.ParseIP(hostOnly(conn.RemoteAddr().String()))
+	policyReq := &policy.ProxyRequest{
+		ClientIP:  clientIP,
+		Host:      serverName,
+		Path:      "/",
+		Method:    "CONNECT",
+		Encrypted: true,
+	}
+	timing := &requestTiming{}
+	decision := &policy.PolicyDecision{Action: policy.ActionAllow}
+	if s.policyEngine != nil {
+		decision = s.evaluate(policyReq, timing)
+	}
+	// Blocked hosts are intercepted anyway, to show the block page
+	switch {
+	case decision.Action == policy.ActionBypass:
+	case decision.Action != policy.ActionBlock && s.clientCerts.Contains(serverName):
+	default:
+		return false
+	}
+
+	go func() {
+		startTime := time.Now()
+		status, size := s.tunnelTo(conn, serverName, "443", decision.Egress, hello, timing)
+		s.logRequest(policyReq, decision, status, size, time.Since(startTime).Milliseconds(), timing)
+		timing.observe()
+
+		deviceName := clientIP.String()
+		metrics.RequestsTotal.WithLabelValues(deviceName, serverName, string(decision.Action), policyReq.Method).Inc()
+		metrics.RequestDuration.WithLabelValues(deviceName, string(decision.Action)).Observe(time.Since(startTime).Seconds())
+		s.analytics.Record("proxy", deviceName, decision.Category, string(decision.Action))
+		s.publishDecision(deviceName, serverName, decision)
+	}()
+	return true
+}
+
+// tunnel splices a client connection to serverName:443, sending the
+// ClientHello already read first. Redirected clients go there too rather
+// than to their original destination: policy only saw the name, which the
+// client is free to send with any address. It returns the status to log
+// (200 once connected, 502 if the origin couldn't be reached) and the bytes
+// sent back to the client.
+func (s *Server) tunnel(conn net.Conn, serverName string, hello []byte, timing *requestTiming) (int, int64) {
+	return s.tunnelTo(conn, serverName, "443", "", hello, timing)
+}
+
+// tunnelTo is tunnel to any port, leaving by an egress route
+func (s *Server) tunnelTo(conn net.Conn, serverName, port, egress string, hello []byte, timing *requestTiming) (int, int64) {
+	defer func() { _ = conn.Close() }()
+
+	dial, err := s.dialFor(egress)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("host", serverName).Msg("TLS tunnel not opened")
+		return http.StatusBadGateway, 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), passthroughDialTimeout)
+	defer cancel()
+
+	dialStart := time.Now()
+	upstream, err := dial(ctx, "tcp", net.JoinHostPort(serverName, port))
+	timing.set(&timing.connect, time.Since(dialStart))
+	if err != nil {
+		s.logger.Warn().Err(err).Str("host", serverName).Msg("Failed to connect to origin for TLS passthrough")
+		return http.StatusBadGateway, 0
+	}
+	defer func() { _ = upstream.Close() }()
+
+	// A name resolving back to the proxy would tunnel to ourselves forever.
+	// (A redirected connection's local address is where it was headed.)
+	redirected := s.originalDst(conn.RemoteAddr().String()) != nil
+	if !redirected && sameAddr(upstream.RemoteAddr(), conn.LocalAddr()) {
+		s.logger.Warn().Str("host", serverName).Msg("Refusing TLS passthrough to the proxy itself")
+		return http.StatusLoopDetected, 0
+	}
+
+	if _, err := upstream.Write(hello); err != nil {
+		return http.StatusBadGateway, 0
+	}
+
+	transferStart := time.Now()
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		closeWrite(upstream)
+		close(done)
+	}()
+	n, _ := io.Copy(conn, upstream)
+	closeWrite(conn)
+	<-done
+	timing.set(&timing.transfer, time.Since(transferStart))
+
+	return http.StatusOK, n
+}
+
+// closeWrite half-closes a connection so the peer sees EOF
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = tcp.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
+
+// sameAddr reports whether two addresses have the same IP and port
+func sameAddr(a, b net.Addr) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	hostA, portA, errA := net.SplitHostPort(a.String())
+	hostB, portB, errB := net.SplitHostPort(b.String())
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	ipA := net.ParseIP(hostA)
+	return ipA != nil && ipA.Equal(net.ParseIP(hostB))
+}
+
+// hostOnly strips the port from an address
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
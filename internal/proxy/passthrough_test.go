@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestPassthroughListener(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+	s.dialOrigin = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr != "bank.example:443" {
+			t.Errorf("dialed %s, want bank.example:443", addr)
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, origin.Listener.Addr().String())
+	}
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	intercepting := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "intercepted")
+	}))
+	intercepting.Listener = newPassthroughListener(inner, func(conn net.Conn, serverName string, hello []byte) bool {
+		if serverName != "bank.example" {
+			return false
+		}
+		go s.tunnel(conn, serverName, hello, &requestTiming{})
+		return true
+	})
+	intercepting.StartTLS()
+	defer intercepting.Close()
+
+	for _, tt := range []struct {
+		host string
+		want string
+	}{
+		{host: "bank.example", want: "origin"},
+		{host: "games.example", want: "intercepted"},
+	} {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, inner.Addr().String())
+			},
+		}}
+
+		resp, err := client.Get("https://" + tt.host + "/")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.host, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != tt.want {
+			t.Errorf("%s: body = %q, want %q", tt.host, body, tt.want)
+		}
+	}
+}
+
+func TestTunnelRefusesLoop(t *testing.T) {
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	s.dialOrigin = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, ln.Addr().String())
+	}
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The origin resolves to the address the client connected to
+	if status, _ := s.tunnel(conn, "loop.example", nil, &requestTiming{}); status != http.StatusLoopDetected {
+		t.Errorf("status = %d, want %d", status, http.StatusLoopDetected)
+	}
+}
+
+func TestTunnelIgnoresOriginalDst(t *testing.T) {
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	var dialed string
+	s.dialOrigin = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = addr
+		upstream, origin := net.Pipe()
+		_ = origin.Close()
+		return upstream, nil
+	}
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A redirected client headed for an internal address, sending the SNI
+	// of a host policy bypasses
+	key := conn.RemoteAddr().String()
+	s.intercepted.Store(key, &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 443})
+	defer s.intercepted.Delete(key)
+	go func() { _ = client.Close() }()
+	s.tunnel(conn, "bank.example", nil, &requestTiming{})
+	if dialed != "bank.example:443" {
+		t.Errorf("dialed %s, want bank.example:443", dialed)
+	}
+}
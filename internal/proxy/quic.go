@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+// QUIC Initial packets are padded to at least this size; only datagrams this
+// large are answered so the rejecter can't be used to amplify traffic
+const minQUICInitialSize = 1200
+
+// quicGreaseVersion is a reserved QUIC version no client implements
+// (RFC 9000 section 15)
+const quicGreaseVersion = 0x1a2a3a4a
+
+// quicRejecter listens on UDP at the HTTPS port and answers each QUIC
+// connection attempt with a Version Negotiation packet offering only a
+// version the client can't speak. The client gives up on HTTP/3 and retries
+// over TCP, where the proxy applies policy.
+type quicRejecter struct {
+	conn   net.PacketConn
+	logger zerolog.Logger
+}
+
+// listenQUICRejecter starts rejecting QUIC on addr
+func listenQUICRejecter(addr string, logger zerolog.Logger) (*quicRejecter, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	q := &quicRejecter{conn: conn, logger: logger}
+	go q.serve()
+	return q, nil
+}
+
+// serve answers datagrams until the connection is closed
+func (q *quicRejecter) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := q.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				q.logger.Error().Err(err).Msg("QUIC rejecter read failed")
+			}
+			return
+		}
+
+		reply := versionNegotiation(buf[:n])
+		if reply == nil {
+			continue
+		}
+		if _, err := q.conn.WriteTo(reply, addr); err != nil {
+			q.logger.Debug().Err(err).Str("client", addr.String()).Msg("Failed to send QUIC version negotiation")
+			continue
+		}
+		metrics.QUICRejected.Inc()
+	}
+}
+
+// close stops rejecting QUIC
+func (q *quicRejecter) close() error {
+	return q.conn.Close()
+}
+
+// versionNegotiation returns the Version Negotiation packet answering a QUIC
+// long header packet (RFC 9000 section 17.2.1), or nil if the datagram isn't
+// one that should be answered
+func versionNegotiation(packet []byte) []byte {
+	if len(packet) < minQUICInitialSize || packet[0]&0x80 == 0 {
+		return nil
+	}
+
+	// Long header: flags, version, then length-prefixed connection IDs
+	version := binary.BigEndian.Uint32(packet[1:5])
+	if version == 0 {
+		// Never answer a Version Negotiation packet
+		return nil
+	}
+	rest := packet[5:]
+	dcid, rest, ok := connectionID(rest)
+	if !ok {
+		return nil
+	}
+	scid, _, ok := connectionID(rest)
+	if !ok {
+		return nil
+	}
+
+	// The reply swaps the client's connection IDs
+	reply := make([]byte, 0, 7+len(dcid)+len(scid)+4)
+	reply = append(reply, 0x80|packet[0]&0x7f, 0, 0, 0, 0)
+	reply = append(reply, byte(len(scid)))
+	reply = append(reply, scid...)
+	reply = append(reply, byte(len(dcid)))
+	reply = append(reply, dcid...)
+	return binary.BigEndian.AppendUint32(reply, quicGreaseVersion)
+}
+
+// connectionID reads a length-prefixed connection ID
+func connectionID(b []byte) (id, rest []byte, ok bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, nil, false
+	}
+	return b[1 : 1+int(b[0])], b[1+int(b[0]):], true
+}
+
+// stripHTTP3AltSvc removes HTTP/3 (h3, h3-29, ...) alternatives from an
+// Alt-Svc header, so clients don't switch to QUIC after the first response
+func stripHTTP3AltSvc(header http.Header) {
+	values := header.Values("Alt-Svc")
+	if len(values) == 0 {
+		return
+	}
+
+	var kept []string
+	for _, value := range values {
+		for _, alternative := range strings.Split(value, ",") {
+			alternative = strings.TrimSpace(alternative)
+			protocol, _, _ := strings.Cut(alternative, "=")
+			if alternative == "" || strings.HasPrefix(protocol, "h3") || strings.HasPrefix(protocol, "quic") {
+				continue
+			}
+			kept = append(kept, alternative)
+		}
+	}
+
+	if len(kept) == 0 {
+		header.Del("Alt-Svc")
+		return
+	}
+	header.Set("Alt-Svc", strings.Join(kept, ", "))
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// quicInitial builds a padded QUIC v1 long header packet
+func quicInitial(dcid, scid []byte) []byte {
+	packet := []byte{0xc3, 0, 0, 0, 1}
+	packet = append(packet, byte(len(dcid)))
+	packet = append(packet, dcid...)
+	packet = append(packet, byte(len(scid)))
+	packet = append(packet, scid...)
+	return append(packet, make([]byte, minQUICInitialSize-len(packet))...)
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	scid := []byte{9, 10, 11, 12}
+
+	reply := versionNegotiation(quicInitial(dcid, scid))
+	want := []byte{0xc3, 0, 0, 0, 0, 4, 9, 10, 11, 12, 8, 1, 2, 3, 4, 5, 6, 7, 8, 0x1a, 0x2a, 0x3a, 0x4a}
+	if !bytes.Equal(reply, want) {
+		t.Errorf("reply = %x, want %x", reply, want)
+	}
+
+	for name, packet := range map[string][]byte{
+		"short datagram":      quicInitial(dcid, scid)[:100],
+		"short header":        append([]byte{0x43}, quicInitial(dcid, scid)[1:]...),
+		"version negotiation": append([]byte{0x80, 0, 0, 0, 0}, quicInitial(dcid, scid)[5:]...),
+	} {
+		if reply := versionNegotiation(packet); reply != nil {
+			t.Errorf("%s: reply = %x, want none", name, reply)
+		}
+	}
+
+	if _, _, ok := connectionID([]byte{8, 1, 2}); ok {
+		t.Error("connectionID() accepted a truncated ID")
+	}
+}
+
+func TestQUICRejecter(t *testing.T) {
+	q, err := listenQUICRejecter("127.0.0.1:0", zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = q.close() }()
+
+	client, err := net.Dial("udp", q.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if _, err := client.Write(quicInitial([]byte{1, 2, 3, 4}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 100)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("no version negotiation: %v", err)
+	}
+	if n < 5 || !bytes.Equal(buf[1:5], []byte{0, 0, 0, 0}) {
+		t.Errorf("reply = %x, want a version negotiation packet", buf[:n])
+	}
+}
+
+func TestStripHTTP3AltSvc(t *testing.T) {
+	for _, tt := range []struct {
+		altSvc []string
+		want   string
+	}{
+		{altSvc: []string{`h3=":443"; ma=86400, h3-29=":443"; ma=86400`}, want: ""},
+		{altSvc: []string{`h3=":443"; ma=86400, h2="alt.example:443"`}, want: `h2="alt.example:443"`},
+		{altSvc: []string{`quic=":443"`, `h2=":8443"`}, want: `h2=":8443"`},
+		{altSvc: []string{"clear"}, want: "clear"},
+	} {
+		header := http.Header{"Alt-Svc": tt.altSvc}
+		stripHTTP3AltSvc(header)
+		if got := header.Get("Alt-Svc"); got != tt.want {
+			t.Errorf("Alt-Svc %q = %q, want %q", tt.altSvc, got, tt.want)
+		}
+	}
+}
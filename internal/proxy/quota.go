@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+)
+
+// quotaEnforcer ends transfers under a usage limit once policy no longer
+// allows them, e.g. when the limit runs out in the middle of a video. At
+// every check the request of each transfer is evaluated again; evaluating
+// also records the activity, so a long download keeps counting as use.
+type quotaEnforcer struct {
+	interval time.Duration
+	evaluate func(*policy.ProxyRequest) *policy.PolicyDecision
+	logger   zerolog.Logger
+
+	mu        sync.Mutex
+	transfers map[*quotaTransfer]struct{}
+
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// quotaTransfer is a response in flight under a usage limit
+type quotaTransfer struct {
+	req      *policy.ProxyRequest
+	category string
+	cancel   context.CancelFunc
+	ended    atomic.Bool
+}
+
+// newQuotaEnforcer returns nil (enforcement off) if interval isn't positive
+func newQuotaEnforcer(interval time.Duration, evaluate func(*policy.ProxyRequest) *policy.PolicyDecision, logger zerolog.Logger) *quotaEnforcer {
+	if interval <= 0 {
+		return nil
+	}
+	return &quotaEnforcer{
+		interval:  interval,
+		evaluate:  evaluate,
+		logger:    logger,
+		transfers: make(map[*quotaTransfer]struct{}),
+		stopChan:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (q *quotaEnforcer) start() {
+	if q == nil {
+		return
+	}
+	go q.run()
+}
+
+func (q *quotaEnforcer) stop() {
+	if q == nil {
+		return
+	}
+	close(q.stopChan)
+	<-q.done
+}
+
+// begin tracks a transfer allowed under a usage limit; cancel aborts it.
+// It returns nil for transfers that aren't under one.
+func (q *quotaEnforcer) begin(req *policy.ProxyRequest, decision *policy.PolicyDecision, cancel context.CancelFunc) *quotaTransfer {
+	if q == nil || req == nil || decision.UsageLimitID == "" {
+		return nil
+	}
+	t := &quotaTransfer{req: req, category: decision.Category, cancel: cancel}
+	q.mu.Lock()
+	q.transfers[t] = struct{}{}
+	q.mu.Unlock()
+	return t
+}
+
+// end stops tracking a transfer
+func (q *quotaEnforcer) end(t *quotaTransfer) {
+	if q == nil || t == nil {
+		return
+	}
+	q.mu.Lock()
+	delete(q.transfers, t)
+	q.mu.Unlock()
+}
+
+// wasEnded reports whether the enforcer ended the transfer
+func (t *quotaTransfer) wasEnded() bool {
+	return t != nil && t.ended.Load()
+}
+
+func (q *quotaEnforcer) run() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			q.check()
+		case <-q.stopChan:
+			return
+		}
+	}
+}
+
+// check evaluates every tracked transfer again and ends those now blocked
+func (q *quotaEnforcer) check() {
+	q.mu.Lock()
+	transfers := make([]*quotaTransfer, 0, len(q.transfers))
+	for t := range q.transfers {
+		transfers = append(transfers, t)
+	}
+	q.mu.Unlock()
+
+	for _, t := range transfers {
+		decision := q.evaluate(t.req)
+		if decision.Action != policy.ActionBlock {
+			continue
+		}
+
+		t.ended.Store(true)
+		t.cancel()
+		q.end(t)
+
+		metrics.QuotaTerminations.WithLabelValues(t.category).Inc()
+		q.logger.Info().
+			Str("client_ip", t.req.ClientIP.String()).
+			Str("host", t.req.Host).
+			Str("category", t.category).
+			Str("reason", decision.Reason).
+			Msg("Ending transfer no longer allowed by policy")
+	}
+}
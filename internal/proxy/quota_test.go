@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+)
+
+func TestQuotaEnforcerEndsBlockedTransfers(t *testing.T) {
+	action := policy.ActionAllow
+	q := newQuotaEnforcer(time.Hour, func(*policy.ProxyRequest) *policy.PolicyDecision {
+		return &policy.PolicyDecision{Action: action, Reason: "usage limit exceeded"}
+	}, zerolog.Nop())
+
+	cancelled := 0
+	req := &policy.ProxyRequest{ClientIP: net.ParseIP("192.168.1.10"), Host: "video.example"}
+	limited := q.begin(req, &policy.PolicyDecision{UsageLimitID: "entertainment", Category: "entertainment"}, func() { cancelled++ })
+	if unlimited := q.begin(req, &policy.PolicyDecision{Category: "educational"}, func() {}); unlimited != nil {
+		t.Error("expected transfers without a usage limit not to be tracked")
+	}
+
+	// Still allowed: the transfer goes on
+	q.check()
+	if cancelled != 0 || limited.wasEnded() {
+		t.Fatal("expected an allowed transfer to go on")
+	}
+
+	// The limit ran out: the transfer is ended and no longer tracked
+	action = policy.ActionBlock
+	q.check()
+	if cancelled != 1 || !limited.wasEnded() {
+		t.Errorf("cancelled %d times (ended %v), want once", cancelled, limited.wasEnded())
+	}
+	if len(q.transfers) != 0 {
+		t.Errorf("expected no tracked transfers, got %d", len(q.transfers))
+	}
+
+	if newQuotaEnforcer(0, nil, zerolog.Nop()) != nil {
+		t.Error("expected no enforcer with a zero interval")
+	}
+}
+
+func TestQuotaEnforcerAbortsResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Larger than the proxy's response buffer, so it reaches the client
+		_, _ = io.WriteString(w, strings.Repeat("x", 64*1024)+"\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+	s.quotas = newQuotaEnforcer(time.Hour, func(*policy.ProxyRequest) *policy.PolicyDecision {
+		return &policy.PolicyDecision{Action: policy.ActionBlock}
+	}, zerolog.Nop())
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Host = upstream.Listener.Addr().String()
+		s.handleProxy(w, r, &policy.ProxyRequest{Host: r.Host}, false, &policy.PolicyDecision{
+			Action:       policy.ActionAllow,
+			Category:     "entertainment",
+			UsageLimitID: "entertainment",
+		}, &requestTiming{})
+	}))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/video")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body := bufio.NewReader(resp.Body)
+	if line, err := body.ReadString('\n'); err != nil || len(line) != 64*1024+1 {
+		t.Fatalf("first segment is %d bytes (%v)", len(line), err)
+	}
+
+	// The limit runs out mid-stream: the response is cut off, not ended cleanly
+	s.quotas.check()
+	if _, err := io.ReadAll(body); err == nil {
+		t.Error("expected the response to be aborted")
+	}
+}
@@ -7,16 +7,36 @@ import (
 	_ "embed"
 	"encoding/hex"
 	"fmt"
+	"html"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/goodtune/kproxy/internal/access"
+	"github.com/goodtune/kproxy/internal/activity"
+	"github.com/goodtune/kproxy/internal/analytics"
+	"github.com/goodtune/kproxy/internal/arp"
+	"github.com/goodtune/kproxy/internal/blockpage"
 	"github.com/goodtune/kproxy/internal/ca"
+	"github.com/goodtune/kproxy/internal/clientcert"
+	"github.com/goodtune/kproxy/internal/clientnames"
+	"github.com/goodtune/kproxy/internal/devices"
+	"github.com/goodtune/kproxy/internal/fingerprint"
+	"github.com/goodtune/kproxy/internal/identity"
+	"github.com/goodtune/kproxy/internal/maintenance"
 	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/mirror"
 	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/goodtune/kproxy/internal/report"
+	"github.com/goodtune/kproxy/internal/safesearch"
+	"github.com/goodtune/kproxy/internal/script"
+	"github.com/goodtune/kproxy/internal/searchwatch"
 	"github.com/rs/zerolog"
 )
 
@@ -41,12 +61,124 @@ type Server struct {
 	serverName   string // Server name for client setup (e.g., "local.kproxy")
 	httpsPort    int    // HTTPS port for redirect
 
-	// Let's Encrypt certificate for server.name (optional)
-	letsEncryptCert *tls.Certificate
+	// Time for in-flight requests to finish on Stop
+	shutdownTimeout time.Duration
+
+	// Upstream connections, shared so they are reused (and multiplexed over HTTP/2)
+	transport *http.Transport
+
+	// Let's Encrypt certificate for the names it covers (optional), read on
+	// every handshake so renewals apply without a restart
+	letsEncrypt func(serverName string) *tls.Certificate
+
+	// Lua runtime for request/response mutation scripts (optional)
+	scripts *script.Runtime
+
+	// Fair bandwidth sharing between profiles (optional)
+	shaper *Shaper
+
+	// Token buckets of the profile bandwidth limits in force
+	bandwidth *bandwidthLimits
+
+	// Media streams in flight, for concurrent stream limits (optional)
+	streams *StreamTracker
+
+	// Hosts that asked for a client certificate, tunnelled rather than
+	// intercepted (optional)
+	clientCerts *clientcert.Cache
+
+	// Server names learned for IP addresses, for reporting direct-IP requests
+	hostnames *hostnames
+
+	// Network maintenance switch (optional)
+	maintenance *maintenance.Mode
+
+	// Recent blocks for live dashboards (optional)
+	activity *activity.Recorder
+
+	// Hourly decision counts for warehouse export (optional)
+	analytics *analytics.Collector
+
+	// Client MAC addresses from the neighbour table (optional)
+	arp *arp.Cache
+
+	// Quarantine of clients seen for the first time (optional)
+	discovery *devices.Discovery
+
+	// Hostnames clients announced for themselves (optional)
+	clientNames *clientnames.Resolver
+
+	// Device type guesses from what clients reveal (optional)
+	fingerprints *fingerprint.Collector
+
+	// Daily counts per profile for weekly reports (optional)
+	reports *report.Recorder
+
+	// Search query logging and concern alerts (optional; queries are only
+	// passed to policy when set)
+	search *searchwatch.Monitor
+
+	// Mirroring of allowed requests to an analysis sink (optional)
+	mirror *mirror.Mirror
 
 	// Optional pre-created listeners (for systemd socket activation)
 	httpListener  net.Listener
 	httpsListener net.Listener
+
+	// Connects to origins of bypassed (passthrough) TLS connections
+	dialOrigin func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Resolves the hosts of CONNECT tunnels before they are dialed
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+
+	// Warns when policy evaluation gets slow (optional)
+	slowPolicy *slowPolicyWatch
+
+	// Ends transfers whose usage limit runs out in flight (optional)
+	quotas *quotaEnforcer
+
+	// Keeping clients off HTTP/3, which would bypass the proxy
+	rejectQUIC bool
+	stripHTTP3 bool
+	quic       *quicRejecter
+
+	// Listeners for connections the firewall redirects to the proxy
+	// (optional), and the original destinations of those connections by
+	// client address
+	transparent *TransparentConfig
+	intercepted sync.Map
+
+	// Explicit proxy listener (optional), the tunnels it hands to the HTTPS
+	// server, and where users who authenticate to it are recorded
+	forward       *ForwardProxyConfig
+	forwardServer *http.Server
+	connects      *connListener
+	users         *identity.Registry
+
+	// Extra TLS ports filtered by SNI and tunnelled (optional)
+	tlsAddrs []string
+	tlsPorts []net.Listener
+
+	// Egress routes policy can send upstream connections by (optional),
+	// and the one used when it names none
+	http2         bool
+	egresses      map[string]*egress
+	defaultEgress string
+
+	// Remaining-time banner for pages under a usage limit (optional), also
+	// used for the notices shown as bedtime or a usage limit nears
+	injector *timerInjector
+	notices  *noticeLog
+
+	// Request headers passed to policy (canonical names)
+	policyHeaders []string
+
+	// Custom block page templates (optional)
+	blockPages *blockpage.Pages
+
+	// Access requests from the block page (optional; the block page offers
+	// the request form only when set)
+	access *access.Queue
 }
 
 // Config holds proxy server configuration
@@ -56,6 +188,46 @@ type Config struct {
 	AdminDomain string
 	ServerName  string // Server name for client setup
 	HTTPSPort   int    // HTTPS port for redirect
+
+	// Look up PTR names to classify requests made directly to an IP address
+	ReverseLookup bool
+
+	// Time for in-flight requests to finish on Stop (default 5s)
+	ShutdownTimeout time.Duration
+
+	// Negotiate HTTP/2 with clients (ALPN) and use it upstream when offered
+	HTTP2 bool
+
+	// Warn when the p99 policy evaluation time exceeds this (0 disables)
+	SlowPolicyThreshold time.Duration
+
+	// Re-evaluate transfers under a usage limit this often, ending those
+	// policy now blocks (0 disables)
+	QuotaCheckInterval time.Duration
+
+	// Answer QUIC on the HTTPS port with version negotiation so clients
+	// fall back to TCP
+	RejectQUIC bool
+
+	// Remove HTTP/3 alternatives from Alt-Svc response headers
+	StripHTTP3 bool
+
+	// Inject the remaining-time banner into pages when policy asks for it
+	// (nil disables)
+	TimerInjection *TimerInjectionConfig
+
+	// Request headers passed to policy as input.headers
+	PolicyHeaders []string
+
+	// Intercept connections redirected by the firewall (nil disables)
+	Transparent *TransparentConfig
+
+	// Explicit forward proxy listener (nil disables)
+	ForwardProxy *ForwardProxyConfig
+
+	// Listen addresses of other TLS ports (e.g. ":993"), where connections
+	// are filtered by SNI and tunnelled to the same port at the origin
+	TLSAddrs []string
 }
 
 // NewServer creates a new proxy server
@@ -72,6 +244,30 @@ func NewServer(
 		adminDomain:  config.AdminDomain,
 		serverName:   config.ServerName,
 		httpsPort:    config.HTTPSPort,
+		hostnames:    newHostnames(config.ReverseLookup),
+		bandwidth:    newBandwidthLimits(),
+		notices:      newNoticeLog(),
+		transport:    newUpstreamTransport(config.HTTP2),
+		dialOrigin:   (&net.Dialer{}).DialContext,
+		lookupIP:     resolveIP,
+		rejectQUIC:   config.RejectQUIC,
+		stripHTTP3:   config.StripHTTP3,
+		transparent:  config.Transparent,
+		forward:      config.ForwardProxy,
+		tlsAddrs:     config.TLSAddrs,
+		http2:        config.HTTP2,
+	}
+	for _, name := range config.PolicyHeaders {
+		s.policyHeaders = append(s.policyHeaders, http.CanonicalHeaderKey(name))
+	}
+	s.slowPolicy = newSlowPolicyWatch(config.SlowPolicyThreshold, s.logger)
+	s.quotas = newQuotaEnforcer(config.QuotaCheckInterval, policyEngine.Evaluate, s.logger)
+	if config.TimerInjection != nil {
+		s.injector = newTimerInjector(*config.TimerInjection)
+	}
+	s.shutdownTimeout = config.ShutdownTimeout
+	if s.shutdownTimeout <= 0 {
+		s.shutdownTimeout = 5 * time.Second
 	}
 
 	// HTTP server
@@ -90,36 +286,174 @@ func NewServer(
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ConnContext:  withConn,
 		TLSConfig: &tls.Config{
 			GetCertificate: s.getCertificate,
 			MinVersion:     tls.VersionTLS12,
+			NextProtos:     []string{"http/1.1"},
 		},
 	}
 
+	// Explicit forward proxy server
+	if config.ForwardProxy != nil {
+		s.forwardServer = &http.Server{
+			Addr:         config.ForwardProxy.Addr,
+			Handler:      http.HandlerFunc(s.handleForward),
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+	}
+
+	// Offer h2 explicitly rather than relying on ListenAndServeTLS, so that
+	// socket-activated listeners (wrapped with this config) negotiate it too
+	if config.HTTP2 {
+		s.httpsServer.TLSConfig.NextProtos = []string{"h2", "http/1.1"}
+	} else {
+		s.httpsServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
 	return s
 }
 
-// SetLetsEncryptCert sets the Let's Encrypt certificate for server.name
-func (s *Server) SetLetsEncryptCert(cert *tls.Certificate) {
-	s.letsEncryptCert = cert
+// newUpstreamTransport returns the transport for upstream requests. With
+// http2 it offers h2 over ALPN and uses it when the server accepts.
+func newUpstreamTransport(http2 bool) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = dialUpstream(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+	t.ForceAttemptHTTP2 = http2
+	t.TLSClientConfig = &tls.Config{GetClientCertificate: noteCertificateRequest}
+	if !http2 {
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return t
+}
+
+// SetLetsEncryptCert sets where the Let's Encrypt certificate comes from.
+// cert is called on every handshake with the SNI and returns nil when no
+// certificate covering that name is available, so the CA signs instead.
+func (s *Server) SetLetsEncryptCert(cert func(serverName string) *tls.Certificate) {
+	s.letsEncrypt = cert
 	s.logger.Info().
 		Str("server_name", s.serverName).
-		Msg("Let's Encrypt certificate configured for server name")
+		Msg("Let's Encrypt certificate configured")
+}
+
+// letsEncryptCert returns the current Let's Encrypt certificate if it covers
+// serverName, nil otherwise
+func (s *Server) letsEncryptCert(serverName string) *tls.Certificate {
+	if s.letsEncrypt == nil || serverName == "" {
+		return nil
+	}
+	return s.letsEncrypt(serverName)
+}
+
+// SetScriptRuntime sets the runtime used to run mutation scripts named by policy decisions
+func (s *Server) SetScriptRuntime(rt *script.Runtime) {
+	s.scripts = rt
+}
+
+// SetShaper sets the shaper that divides bandwidth between profiles
+func (s *Server) SetShaper(shaper *Shaper) {
+	s.shaper = shaper
+}
+
+// SetStreams sets the tracker of media streams that policy limits per profile
+func (s *Server) SetStreams(t *StreamTracker) {
+	s.streams = t
+}
+
+// SetMaintenance sets the switch that puts all HTTP traffic behind a maintenance page
+func (s *Server) SetMaintenance(m *maintenance.Mode) {
+	s.maintenance = m
+}
+
+// SetBlockPages sets the custom block page templates policy can choose
+func (s *Server) SetBlockPages(p *blockpage.Pages) {
+	s.blockPages = p
+}
+
+// SetAccessRequests sets the queue of access requests made from the block
+// page, and turns on the block page's request form
+func (s *Server) SetAccessRequests(q *access.Queue) {
+	s.access = q
+}
+
+// SetActivity sets the recorder that keeps recent blocks for live dashboards
+func (s *Server) SetActivity(r *activity.Recorder) {
+	s.activity = r
+}
+
+// SetAnalytics sets the collector that counts decisions for warehouse export
+func (s *Server) SetAnalytics(c *analytics.Collector) {
+	s.analytics = c
+}
+
+// SetARP sets the cache that resolves clients' MAC addresses for policy
+func (s *Server) SetARP(c *arp.Cache) {
+	s.arp = c
+}
+
+// SetDiscovery sets the discovery that quarantines new clients
+func (s *Server) SetDiscovery(d *devices.Discovery) {
+	s.discovery = d
+}
+
+// SetClientNames sets the resolver that names clients by their announced
+// hostnames
+func (s *Server) SetClientNames(r *clientnames.Resolver) {
+	s.clientNames = r
+}
+
+// SetFingerprints sets the collector that guesses device types from what
+// clients reveal about themselves
+func (s *Server) SetFingerprints(c *fingerprint.Collector) {
+	s.fingerprints = c
+}
+
+// SetReports sets the recorder that counts traffic per profile for weekly reports
+func (s *Server) SetReports(r *report.Recorder) {
+	s.reports = r
+}
+
+// SetSearchMonitor enables search monitoring: search queries are passed to
+// policy, which decides whether they are logged or raise alerts
+func (s *Server) SetSearchMonitor(m *searchwatch.Monitor) {
+	s.search = m
+}
+
+// SetMirror sets the mirror that sends records of allowed requests to an analysis sink
+func (s *Server) SetMirror(m *mirror.Mirror) {
+	s.mirror = m
 }
 
 // getCertificate returns the appropriate certificate based on SNI hostname
 func (s *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	// If we have a Let's Encrypt cert and the SNI matches server.name, use it
-	if s.letsEncryptCert != nil && s.matchesServerName(hello.ServerName) {
+	// Redirected clients that sent no SNI get a certificate for the
+	// address they were connecting to
+	if hello.ServerName == "" && hello.Conn != nil {
+		if dst := s.originalDst(hello.Conn.RemoteAddr().String()); dst != nil {
+			hello.ServerName = dst.IP.String()
+		}
+	}
+
+	// If we have a Let's Encrypt cert covering the SNI (server.name or one
+	// of tls.lego_domains), use it
+	if letsEncryptCert := s.letsEncryptCert(hello.ServerName); letsEncryptCert != nil {
 		s.logger.Debug().
 			Str("sni", hello.ServerName).
-			Str("server_name", s.serverName).
-			Msg("Serving Let's Encrypt certificate for server name")
-		return s.letsEncryptCert, nil
+			Msg("Serving Let's Encrypt certificate")
+		return letsEncryptCert, nil
 	}
 
-	// Otherwise, generate/retrieve certificate from CA
-	return s.ca.GetCertificate(hello)
+	// Otherwise, generate/retrieve certificate from CA, timing it for the
+	// connection's first request
+	start := time.Now()
+	cert, err := s.ca.GetCertificate(hello)
+	if c, ok := hello.Conn.(*replayConn); ok {
+		c.certMint.Store(int64(time.Since(start)))
+	}
+	return cert, err
 }
 
 // SetListeners sets pre-created listeners for systemd socket activation
@@ -130,7 +464,19 @@ func (s *Server) SetListeners(httpLn, httpsLn net.Listener) {
 
 // Start starts the proxy servers
 func (s *Server) Start() error {
-	errChan := make(chan error, 2)
+	errChan := make(chan error, 6)
+	s.slowPolicy.start()
+	s.quotas.start()
+
+	// Reject QUIC on the HTTPS port so HTTP/3 clients fall back to TCP
+	if s.rejectQUIC {
+		quic, err := listenQUICRejecter(httpsAddr(s.httpsServer.Addr), s.logger)
+		if err != nil {
+			return fmt.Errorf("QUIC rejecter error: %w", err)
+		}
+		s.quic = quic
+		s.logger.Info().Str("addr", s.httpsServer.Addr).Msg("Rejecting QUIC on the HTTPS port")
+	}
 
 	// Start HTTP server
 	go func() {
@@ -152,22 +498,45 @@ func (s *Server) Start() error {
 	// Start HTTPS server
 	go func() {
 		s.logger.Info().Str("addr", s.httpsServer.Addr).Msg("Starting HTTPS proxy server")
-		var err error
-		if s.httpsListener != nil {
+		listener := s.httpsListener
+		if listener != nil {
 			// Use systemd socket-activated listener
 			s.logger.Debug().Msg("Using systemd socket-activated HTTPS listener")
-			// For TLS with pre-created listener, we need to wrap it with TLS
-			tlsListener := tls.NewListener(s.httpsListener, s.httpsServer.TLSConfig)
-			err = s.httpsServer.Serve(tlsListener)
 		} else {
 			// Create and bind listener ourselves
-			err = s.httpsServer.ListenAndServeTLS("", "")
+			var err error
+			listener, err = net.Listen("tcp", httpsAddr(s.httpsServer.Addr))
+			if err != nil {
+				errChan <- fmt.Errorf("HTTPS server error: %w", err)
+				return
+			}
 		}
+
+		// Bypassed hosts are tunnelled by SNI before TLS is terminated
+		passthrough := newPassthroughListener(listener, s.routeTLS)
+		err := s.httpsServer.Serve(tls.NewListener(passthrough, s.httpsServer.TLSConfig))
 		if err != nil && err != http.ErrServerClosed {
 			errChan <- fmt.Errorf("HTTPS server error: %w", err)
 		}
 	}()
 
+	// Start listeners for connections redirected by the firewall
+	if s.transparent != nil {
+		if err := s.startTransparent(errChan); err != nil {
+			return err
+		}
+	}
+
+	// Filter TLS on other ports by SNI
+	if err := s.listenTLSPorts(); err != nil {
+		return err
+	}
+
+	// Start the explicit forward proxy
+	if s.forwardServer != nil {
+		s.startForward(errChan)
+	}
+
 	// Wait a bit to ensure servers started
 	select {
 	case err := <-errChan:
@@ -177,12 +546,21 @@ func (s *Server) Start() error {
 	}
 }
 
+// httpsAddr returns the HTTPS listen address, defaulting as
+// ListenAndServeTLS does
+func httpsAddr(addr string) string {
+	if addr == "" {
+		return ":https"
+	}
+	return addr
+}
+
 // Stop stops the proxy servers
 func (s *Server) Stop() error {
 	s.logger.Info().Msg("Stopping proxy servers")
 
-	// Give servers 5 seconds to shutdown gracefully
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Give in-flight requests time to finish
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
 	defer cancel()
 
 	var errs []error
@@ -195,6 +573,25 @@ func (s *Server) Stop() error {
 		errs = append(errs, fmt.Errorf("HTTPS server shutdown error: %w", err))
 	}
 
+	if s.forwardServer != nil {
+		if err := s.forwardServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("forward proxy shutdown error: %w", err))
+		}
+	}
+
+	s.closeTLSPorts()
+	s.transport.CloseIdleConnections()
+	for _, e := range s.egresses {
+		e.transport.CloseIdleConnections()
+	}
+	s.slowPolicy.stop()
+	s.quotas.stop()
+	if s.quic != nil {
+		if err := s.quic.close(); err != nil {
+			errs = append(errs, fmt.Errorf("QUIC rejecter close error: %w", err))
+		}
+	}
+
 	if len(errs) > 0 {
 		return fmt.Errorf("shutdown errors: %v", errs)
 	}
@@ -232,13 +629,31 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Redirected HTTP/1.0 clients may name no host; use the address they
+	// were connecting to
+	if dst := s.originalDst(r.RemoteAddr); dst != nil && r.Host == "" {
+		r.Host = dst.String()
+	}
+
 	// Check if this is a request to server.name - redirect to HTTPS
 	host := r.Host
 	if strings.HasSuffix(host, fmt.Sprintf(":%d", 80)) {
 		host = strings.TrimSuffix(host, fmt.Sprintf(":%d", 80))
 	}
 
+	// The PAC file for the forward proxy, also found by WPAD
+	if s.servesPAC(r, host) {
+		s.servePAC(w)
+		return
+	}
+
 	if s.matchesServerName(host) {
+		// Devices that don't trust the root yet fetch it over HTTP
+		if s.servesSetupOverHTTP(r.URL.Path) {
+			s.handleClientSetup(w, r)
+			return
+		}
+
 		// Redirect to HTTPS
 		httpsURL := fmt.Sprintf("https://%s", s.serverName)
 		if s.httpsPort != 443 {
@@ -258,36 +673,68 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// During network maintenance everything gets the maintenance page
+	if s.maintenance != nil && s.maintenance.Active() {
+		s.handleMaintenance(w)
+		return
+	}
+
+	// Access requests posted from the block page
+	if r.Method == http.MethodPost && r.URL.Path == accessRequestPath {
+		s.handleAccessRequest(w, r, false)
+		return
+	}
+
 	// Extract client info
 	clientIP := s.extractClientIP(r)
 
 	// Build policy request
 	policyReq := &policy.ProxyRequest{
 		ClientIP:  clientIP,
+		ClientMAC: s.arp.Lookup(clientIP),
 		Host:      r.Host,
 		Path:      r.URL.Path,
 		Method:    r.Method,
 		UserAgent: r.UserAgent(),
 		Encrypted: false,
 	}
+	if s.search != nil {
+		policyReq.SearchEngine, policyReq.SearchQuery = safesearch.SearchQuery(r)
+	}
+	s.discovery.Observe(clientIP, policyReq.ClientMAC, s.clientNames.Lookup(clientIP), "proxy")
+	s.fingerprints.ObserveUserAgent(clientIP, policyReq.ClientMAC, policyReq.UserAgent)
+	s.addRequestFacts(r, policyReq)
+	s.classifyDirectIP(r, policyReq)
 
 	// Evaluate policy
-	decision := s.policyEngine.Evaluate(policyReq)
+	timing := &requestTiming{}
+	decision := s.evaluate(policyReq, timing)
 
 	// Log request and record metrics
 	defer func() {
 		duration := time.Since(startTime).Milliseconds()
-		s.logRequest(policyReq, decision, http.StatusOK, 0, duration)
+		s.logRequest(policyReq, decision, http.StatusOK, 0, duration, timing)
+		timing.observe()
 
 		// Record metrics
 		// Device identification now happens in OPA; use client IP for metrics
 		deviceName := clientIP.String()
 
-		metrics.RequestsTotal.WithLabelValues(deviceName, policyReq.Host, string(decision.Action), policyReq.Method).Inc()
+		metrics.RequestsTotal.WithLabelValues(deviceName, s.reportHost(policyReq), string(decision.Action), policyReq.Method).Inc()
 		metrics.RequestDuration.WithLabelValues(deviceName, string(decision.Action)).Observe(time.Since(startTime).Seconds())
+		s.analytics.Record("proxy", deviceName, decision.Category, string(decision.Action))
+		s.publishDecision(deviceName, s.reportHost(policyReq), decision)
+		s.reports.Record(decision.Profile, s.reportHost(policyReq), decision.Category, string(decision.Action), decision.BlockPage)
+		if policyReq.SearchQuery != "" {
+			s.search.Observe(deviceName, decision.Profile, policyReq.SearchEngine, policyReq.SearchQuery, decision.SearchLog, decision.SearchConcerns)
+		}
 
-		if decision.Action == policy.ActionBlock {
+		switch decision.Action {
+		case policy.ActionBlock:
 			metrics.BlockedRequests.WithLabelValues(deviceName, decision.Reason).Inc()
+			s.activity.RecordBlock("proxy", deviceName, s.reportHost(policyReq), decision.Reason)
+		case policy.ActionWarn:
+			metrics.WarnedRequests.WithLabelValues(deviceName, decision.Reason).Inc()
 		}
 	}()
 
@@ -297,8 +744,8 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		s.handleBlock(w, r, decision)
 		return
 
-	case policy.ActionAllow:
-		s.handleProxy(w, r, false)
+	case policy.ActionAllow, policy.ActionWarn:
+		s.handleProxy(w, r, policyReq, false, decision, timing)
 		return
 
 	default:
@@ -329,36 +776,68 @@ func (s *Server) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// During network maintenance everything gets the maintenance page
+	if s.maintenance != nil && s.maintenance.Active() {
+		s.handleMaintenance(w)
+		return
+	}
+
+	// Access requests posted from the block page
+	if r.Method == http.MethodPost && r.URL.Path == accessRequestPath {
+		s.handleAccessRequest(w, r, true)
+		return
+	}
+
 	// Extract client info
 	clientIP := s.extractClientIP(r)
 
 	// Build policy request
 	policyReq := &policy.ProxyRequest{
 		ClientIP:  clientIP,
+		ClientMAC: s.arp.Lookup(clientIP),
 		Host:      r.Host,
 		Path:      r.URL.Path,
 		Method:    r.Method,
 		UserAgent: r.UserAgent(),
 		Encrypted: true,
 	}
+	if s.search != nil {
+		policyReq.SearchEngine, policyReq.SearchQuery = safesearch.SearchQuery(r)
+	}
+	s.discovery.Observe(clientIP, policyReq.ClientMAC, s.clientNames.Lookup(clientIP), "proxy")
+	s.fingerprints.ObserveUserAgent(clientIP, policyReq.ClientMAC, policyReq.UserAgent)
+	s.addRequestFacts(r, policyReq)
+	s.classifyDirectIP(r, policyReq)
 
 	// Evaluate policy
-	decision := s.policyEngine.Evaluate(policyReq)
+	timing := &requestTiming{tlsMint: certMintTime(r)}
+	decision := s.evaluate(policyReq, timing)
 
 	// Log request and record metrics
 	defer func() {
 		duration := time.Since(startTime).Milliseconds()
-		s.logRequest(policyReq, decision, http.StatusOK, 0, duration)
+		s.logRequest(policyReq, decision, http.StatusOK, 0, duration, timing)
+		timing.observe()
 
 		// Record metrics
 		// Device identification now happens in OPA; use client IP for metrics
 		deviceName := clientIP.String()
 
-		metrics.RequestsTotal.WithLabelValues(deviceName, policyReq.Host, string(decision.Action), policyReq.Method).Inc()
+		metrics.RequestsTotal.WithLabelValues(deviceName, s.reportHost(policyReq), string(decision.Action), policyReq.Method).Inc()
 		metrics.RequestDuration.WithLabelValues(deviceName, string(decision.Action)).Observe(time.Since(startTime).Seconds())
+		s.analytics.Record("proxy", deviceName, decision.Category, string(decision.Action))
+		s.publishDecision(deviceName, s.reportHost(policyReq), decision)
+		s.reports.Record(decision.Profile, s.reportHost(policyReq), decision.Category, string(decision.Action), decision.BlockPage)
+		if policyReq.SearchQuery != "" {
+			s.search.Observe(deviceName, decision.Profile, policyReq.SearchEngine, policyReq.SearchQuery, decision.SearchLog, decision.SearchConcerns)
+		}
 
-		if decision.Action == policy.ActionBlock {
+		switch decision.Action {
+		case policy.ActionBlock:
 			metrics.BlockedRequests.WithLabelValues(deviceName, decision.Reason).Inc()
+			s.activity.RecordBlock("proxy", deviceName, s.reportHost(policyReq), decision.Reason)
+		case policy.ActionWarn:
+			metrics.WarnedRequests.WithLabelValues(deviceName, decision.Reason).Inc()
 		}
 	}()
 
@@ -368,8 +847,8 @@ func (s *Server) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 		s.handleBlock(w, r, decision)
 		return
 
-	case policy.ActionAllow:
-		s.handleProxy(w, r, true)
+	case policy.ActionAllow, policy.ActionWarn:
+		s.handleProxy(w, r, policyReq, true, decision, timing)
 		return
 
 	default:
@@ -378,8 +857,10 @@ func (s *Server) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleProxy proxies the request to the upstream server
-func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request, isHTTPS bool) {
+// handleProxy proxies the request to the upstream server. req is the
+// request as evaluated, evaluated again while the response is in flight if
+// it is under a usage limit (nil skips that).
+func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request, req *policy.ProxyRequest, isHTTPS bool, decision *policy.PolicyDecision, timing *requestTiming) {
 	// Build upstream URL
 	scheme := "http"
 	if isHTTPS {
@@ -405,23 +886,149 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request, isHTTPS boo
 	// Remove hop-by-hop headers
 	removeHopByHopHeaders(upstreamReq.Header)
 
+	// Time the upstream lookup and connection, and learn which address a
+	// named host resolves to, so later requests made directly to that
+	// address can be reported by name
+	trace := timing.clientTrace()
+	targetIP := hostIP(r.Host)
+	if targetIP == nil {
+		timed := trace.GotConn
+		trace.GotConn = func(info httptrace.GotConnInfo) {
+			timed(info)
+			s.hostnames.learnFromConn(info.Conn, hostWithoutPort(r.Host))
+		}
+	}
+	upstreamReq = upstreamReq.WithContext(httptrace.WithClientTrace(upstreamReq.Context(), trace))
+
+	// Requests of redirected clients go to the address the client was
+	// connecting to rather than wherever the host name resolves. Plain HTTP
+	// is addressed there so pooled connections are keyed by it; HTTPS keeps
+	// the name, which the origin's certificate is verified against.
+	if dst := s.originalDst(r.RemoteAddr); dst != nil {
+		if isHTTPS {
+			upstreamReq = upstreamReq.WithContext(context.WithValue(upstreamReq.Context(), originalDstKey{}, dst))
+		} else {
+			upstreamReq.URL.Host = dst.String()
+			upstreamReq.Host = r.Host
+		}
+	}
+
+	// Run request mutation script (failures leave the request untouched)
+	runScript := decision.Script != "" && s.scripts != nil
+	if runScript {
+		if err := s.scripts.MutateRequest(decision.Script, upstreamReq); err != nil {
+			s.logger.Warn().Err(err).Str("script", decision.Script).Str("url", upstreamURL).Msg("Request script failed")
+		}
+	}
+
+	// Lock search engines to strict filtering and YouTube to the profile's
+	// Restricted Mode level; this runs after the script so a script can't
+	// switch it off
+	if decision.SafeSearch && safesearch.Enforce(upstreamReq) {
+		s.logger.Debug().Str("url", upstreamURL).Msg("SafeSearch enforced")
+	}
+	if decision.YouTubeRestrict != "" && safesearch.RestrictYouTube(upstreamReq, decision.YouTubeRestrict) {
+		s.logger.Debug().Str("url", upstreamURL).Str("level", decision.YouTubeRestrict).Msg("YouTube Restricted Mode enforced")
+	}
+
+	// Pages under a usage limit get a banner with the time left, and a page
+	// shows each notice due as bedtime or a usage limit nears
+	clientKey := s.extractClientIP(r).String()
+	injectTimer := decision.InjectTimer && s.injector.applies(r)
+	showNotice := s.notices.due(clientKey, decision.Notice) && s.injector.applies(r)
+	if injectTimer || showNotice {
+		s.injector.prepare(upstreamReq)
+	}
+
+	// Graduated enforcement: slow down requests as a usage limit nears exhaustion
+	if decision.ThrottleDelay > 0 || decision.ThrottleKbps > 0 {
+		metrics.ThrottledRequests.WithLabelValues(decision.Category).Inc()
+	}
+	if !throttleDelay(r.Context(), decision.ThrottleDelay) {
+		return
+	}
+
+	// Mirror the request as sent upstream (nil if mirroring is off or the
+	// request isn't sampled)
+	mirrored := s.mirror.Begin(upstreamReq, s.extractClientIP(r), decision)
+
+	// Leave by the egress route policy chose
+	transport, err := s.transportFor(decision.Egress)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("url", upstreamURL).Msg("Upstream request not sent")
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
 	// Create HTTP client
 	client := &http.Client{
-		Timeout: 30 * time.Second,
+		Transport: transport,
+		Timeout:   30 * time.Second,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 
+	// The quota enforcer cancels the upstream request to end the transfer
+	ctx, cancel := context.WithCancel(upstreamReq.Context())
+	defer cancel()
+	upstreamReq = upstreamReq.WithContext(ctx)
+
+	// Note whether the origin asks for a client certificate, which
+	// interception can't provide
+	var certRequested atomic.Bool
+	if isHTTPS && s.clientCerts != nil {
+		upstreamReq = upstreamReq.WithContext(context.WithValue(ctx, certRequestKey{}, &certRequested))
+	}
+
+	// Count the response against the profile's stream limit until it is done
+	if decision.StreamDevice != "" && s.streams != nil {
+		defer s.streams.Begin(decision.Profile, decision.StreamDevice)()
+	}
+
 	// Send request
 	resp, err := client.Do(upstreamReq)
 	if err != nil {
 		s.logger.Error().Err(err).Str("url", upstreamURL).Msg("Upstream request failed")
+		if certRequested.Load() {
+			// Tunnel the host from now on, and have the client reconnect
+			s.clientCerts.Add(hostOnly(r.Host), clientKey)
+			w.Header().Set("Connection", "close")
+		}
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 		return
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	// A server reached by IP is named by its certificate
+	if targetIP != nil && resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		s.hostnames.learnCert(targetIP, resp.TLS.PeerCertificates[0])
+	}
+
+	// Run response mutation script (failures leave the response untouched)
+	if runScript {
+		if err := s.scripts.MutateResponse(decision.Script, resp); err != nil {
+			s.logger.Warn().Err(err).Str("script", decision.Script).Str("url", upstreamURL).Msg("Response script failed")
+		}
+	}
+
+	var banner []byte
+	if injectTimer {
+		banner = append(banner, timerBanner(decision.Category, decision.TimeRemaining)...)
+	}
+	if showNotice {
+		banner = append(banner, noticeBanner(decision.Notice)...)
+	}
+	if len(banner) > 0 && s.injector.inject(resp, banner) {
+		if injectTimer {
+			metrics.TimerInjections.WithLabelValues(decision.Category).Inc()
+		}
+		if showNotice {
+			s.notices.record(clientKey, decision.Notice)
+			metrics.NoticesShown.WithLabelValues(decision.Notice.Kind).Inc()
+		}
+	}
+
 	// Copy response headers
 	for key, values := range resp.Header {
 		for _, value := range values {
@@ -432,11 +1039,45 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request, isHTTPS boo
 	// Remove hop-by-hop headers
 	removeHopByHopHeaders(w.Header())
 
+	// Keep clients from switching to HTTP/3, which would bypass the proxy
+	if s.stripHTTP3 {
+		stripHTTP3AltSvc(w.Header())
+	}
+
 	// Write status code
 	w.WriteHeader(resp.StatusCode)
 
-	// Copy response body
-	if _, err := io.Copy(w, resp.Body); err != nil {
+	// Copy response body, sharing the link fairly between profiles, under
+	// the profile's bandwidth limits and capping bandwidth if throttled
+	var body io.Writer = w
+	if decision.Profile != "" {
+		body = &profileCounter{w: body, profile: decision.Profile}
+		if s.shaper != nil {
+			var release func()
+			body, release = s.shaper.Writer(r.Context(), body, decision.Profile, decision.BandwidthWeight)
+			defer release()
+		}
+		if len(decision.BandwidthLimits) > 0 {
+			var release func()
+			body, release = s.bandwidth.Writer(r.Context(), body, decision.Profile, decision.BandwidthLimits)
+			defer release()
+		}
+	}
+	if decision.ThrottleKbps > 0 {
+		body = newThrottledWriter(body, decision.ThrottleKbps)
+	}
+	transfer := s.quotas.begin(req, decision, cancel)
+	defer s.quotas.end(transfer)
+	transferStart := time.Now()
+	n, err := io.Copy(body, resp.Body)
+	timing.set(&timing.transfer, time.Since(transferStart))
+	mirrored.Finish(resp.StatusCode, n)
+	if transfer.wasEnded() {
+		// Abort the response, so the client sees it cut off rather than
+		// complete; its next request gets the block page
+		panic(http.ErrAbortHandler)
+	}
+	if err != nil {
 		s.logger.Error().Err(err).Msg("Failed to copy response body")
 	}
 }
@@ -448,13 +1089,55 @@ func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request, decision *p
 	// Device identification now happens in OPA; use client IP for display
 	deviceName := clientIP.String()
 
+	// Devices with a profile can ask for access when access requests are on
+	requestAccess := s.access != nil && canRequestAccess(decision)
+
+	// Show the profile's custom block page if it has one
+	if decision.BlockTemplate != "" && s.blockPages != nil {
+		page, err := s.blockPages.Render(r.Context(), decision.BlockTemplate, blockpage.Data{
+			Reason:        decision.Reason,
+			Host:          r.Host,
+			URL:           r.Host + r.URL.Path,
+			Device:        deviceName,
+			Profile:       decision.Profile,
+			Category:      decision.Category,
+			Path:          r.URL.Path,
+			TimeRemaining: blockpage.Remaining(decision.TimeRemaining),
+			BlockedAt:     time.Now(),
+			RequestAccess: requestAccess,
+		})
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			if _, err := w.Write(page); err != nil {
+				s.logger.Error().Err(err).Msg("Failed to write block page")
+			}
+			return
+		}
+		s.logger.Warn().Err(err).Str("template", decision.BlockTemplate).Msg("Custom block page failed, showing the built-in page")
+	}
+
+	accessForm := ""
+	if requestAccess {
+		accessForm = accessRequestForm(r.URL.Path)
+	}
+
+	// A usage limit that ran out gets a time's up page, a pause its own
+	icon, heading, message := "🚫", "Access Blocked", "This website has been blocked by your network filter."
+	switch decision.BlockPage {
+	case "usage_limit":
+		icon, heading, message = "⏰", "Time's Up", "You've used all of today's time for this. It will be back after the daily reset."
+	case "paused":
+		icon, heading, message = "⏸️", "Internet Paused", "The internet has been paused for this device. It will be back when the pause ends."
+	}
+
 	// Render block page with branding
 	blockHTML := fmt.Sprintf(`<!DOCTYPE html>
 <html lang="en">
 <head>
 	<meta charset="UTF-8">
 	<meta name="viewport" content="width=device-width, initial-scale=1.0">
-	<title>Access Blocked - KProxy</title>
+	<title>%s - KProxy</title>
 	<style>
 		* { margin: 0; padding: 0; box-sizing: border-box; }
 		body {
@@ -491,6 +1174,25 @@ func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request, decision *p
 			word-break: break-all;
 		}
 		.info { font-size: 14px; color: #999; margin-top: 24px; }
+		.request-access { margin-top: 24px; }
+		.request-access textarea {
+			width: 100%%;
+			min-height: 60px;
+			padding: 8px;
+			border: 1px solid #ddd;
+			border-radius: 8px;
+			font-family: inherit;
+			margin-bottom: 12px;
+		}
+		.request-access button {
+			background: #667eea;
+			color: white;
+			border: none;
+			border-radius: 8px;
+			padding: 10px 20px;
+			font-size: 16px;
+			cursor: pointer;
+		}
 		.powered-by {
 			font-size: 12px;
 			color: #999;
@@ -502,9 +1204,9 @@ func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request, decision *p
 <body>
 	<div class="container">
 		<img src="/.kproxy/logo.png" alt="KProxy" class="logo">
-		<div class="icon">🚫</div>
-		<h1>Access Blocked</h1>
-		<p>This website has been blocked by your network filter.</p>
+		<div class="icon">%s</div>
+		<h1>%s</h1>
+		<p>%s</p>
 		<div class="reason">%s</div>
 		<p class="info">
 			If you believe this is a mistake, please talk to your administrator.<br>
@@ -512,10 +1214,11 @@ func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request, decision *p
 			Device: %s<br>
 			URL: %s
 		</p>
+		%s
 		<div class="powered-by">Powered by KProxy</div>
 	</div>
 </body>
-</html>`, decision.Reason, time.Now().Format("2006-01-02 15:04:05"), deviceName, r.Host+r.URL.Path)
+</html>`, heading, icon, heading, message, decision.Reason, time.Now().Format("2006-01-02 15:04:05"), deviceName, r.Host+r.URL.Path, accessForm)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusForbidden)
@@ -524,6 +1227,73 @@ func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request, decision *p
 	}
 }
 
+// handleMaintenance serves the network maintenance page
+func (s *Server) handleMaintenance(w http.ResponseWriter) {
+	status := s.maintenance.Status()
+	message := status.Message
+	until := "shortly"
+	if status.Until != nil {
+		until = status.Until.Local().Format("15:04")
+		if retryAfter := int(time.Until(*status.Until).Seconds()); retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		}
+	}
+
+	maintenanceHTML := fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Network Maintenance - KProxy</title>
+	<style>
+		* { margin: 0; padding: 0; box-sizing: border-box; }
+		body {
+			font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+			background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%);
+			min-height: 100vh;
+			display: flex;
+			align-items: center;
+			justify-content: center;
+			padding: 20px;
+		}
+		.container {
+			background: white;
+			border-radius: 16px;
+			padding: 40px;
+			max-width: 500px;
+			text-align: center;
+			box-shadow: 0 20px 60px rgba(0,0,0,0.3);
+		}
+		.logo {
+			max-width: 200px;
+			height: auto;
+			margin-bottom: 20px;
+		}
+		.icon { font-size: 64px; margin-bottom: 20px; }
+		h1 { color: #333; margin-bottom: 16px; }
+		p { color: #666; line-height: 1.6; margin-bottom: 24px; }
+		.info { font-size: 14px; color: #999; margin-top: 24px; }
+	</style>
+</head>
+<body>
+	<div class="container">
+		<img src="/.kproxy/logo.png" alt="KProxy" class="logo">
+		<div class="icon">🔧</div>
+		<h1>Network Maintenance</h1>
+		<p>%s</p>
+		<p class="info">Expected back by %s</p>
+	</div>
+</body>
+</html>`, html.EscapeString(message), until)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if _, err := w.Write([]byte(maintenanceHTML)); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to write maintenance page")
+	}
+}
+
 // extractClientIP extracts the client IP from the request
 func (s *Server) extractClientIP(r *http.Request) net.IP {
 	// Check X-Forwarded-For header
@@ -555,8 +1325,18 @@ func (s *Server) extractClientIP(r *http.Request) net.IP {
 	return net.ParseIP(host)
 }
 
+// evaluate evaluates policy for a request, timing the evaluation
+func (s *Server) evaluate(req *policy.ProxyRequest, timing *requestTiming) *policy.PolicyDecision {
+	start := time.Now()
+	decision := s.policyEngine.Evaluate(req)
+	elapsed := time.Since(start)
+	timing.set(&timing.policy, elapsed)
+	s.slowPolicy.add(elapsed)
+	return decision
+}
+
 // logRequest logs a proxied request to structured logger
-func (s *Server) logRequest(req *policy.ProxyRequest, decision *policy.PolicyDecision, statusCode int, responseSize int64, durationMS int64) {
+func (s *Server) logRequest(req *policy.ProxyRequest, decision *policy.PolicyDecision, statusCode int, responseSize int64, durationMS int64, timing *requestTiming) {
 	// Log to structured logger
 	logEvent := s.logger.Info().
 		Str("client_ip", req.ClientIP.String())
@@ -564,23 +1344,86 @@ func (s *Server) logRequest(req *policy.ProxyRequest, decision *policy.PolicyDec
 	if req.ClientMAC != nil {
 		logEvent = logEvent.Str("client_mac", req.ClientMAC.String())
 	}
+	if hostname := s.clientNames.Lookup(req.ClientIP); hostname != "" {
+		logEvent = logEvent.Str("client_hostname", hostname)
+	}
 
 	logEvent.
 		Str("method", req.Method).
 		Str("host", req.Host).
+		Str("server_name", s.reportHost(req)).
+		Bool("direct_ip", req.DirectIP).
 		Str("path", req.Path).
 		Str("user_agent", req.UserAgent).
 		Int("status_code", statusCode).
 		Int64("response_size", responseSize).
 		Int64("duration_ms", durationMS).
+		Dict("phases", timing.dict()).
 		Str("action", string(decision.Action)).
 		Str("matched_rule", decision.MatchedRuleID).
 		Str("reason", decision.Reason).
 		Str("category", decision.Category).
+		Int64("throttle_delay_ms", decision.ThrottleDelay.Milliseconds()).
 		Bool("encrypted", req.Encrypted).
+		Str("decision_id", decision.DecisionID).
 		Msg("Proxy request processed")
 }
 
+// addRequestFacts adds the query parameters and the request headers
+// selected for policy
+func (s *Server) addRequestFacts(r *http.Request, req *policy.ProxyRequest) {
+	req.Query = r.URL.Query()
+	for _, name := range s.policyHeaders {
+		if value := r.Header.Get(name); value != "" {
+			if req.Headers == nil {
+				req.Headers = make(map[string]string, len(s.policyHeaders))
+			}
+			req.Headers[strings.ToLower(name)] = value
+		}
+	}
+}
+
+// classifyDirectIP marks a request made straight to an IP address (such as
+// an app's hard-coded endpoint) and finds server names for the address, so
+// domain rules can still apply to it
+func (s *Server) classifyDirectIP(r *http.Request, req *policy.ProxyRequest) {
+	ip := hostIP(r.Host)
+	if ip == nil {
+		return
+	}
+
+	sni := ""
+	if r.TLS != nil {
+		sni = r.TLS.ServerName
+	}
+	req.DirectIP = true
+	req.ServerNames = s.hostnames.serverNames(r.Context(), ip, sni)
+}
+
+// reportHost is the host a request is reported under in logs and metrics:
+// direct-IP requests use the server name they were classified by, or one
+// learned since
+func (s *Server) reportHost(req *policy.ProxyRequest) string {
+	if req.DirectIP && len(req.ServerNames) > 0 {
+		return req.ServerNames[0]
+	}
+	return s.hostnames.displayHost(req.Host)
+}
+
+// publishDecision streams a policy decision to live dashboards
+func (s *Server) publishDecision(client, host string, decision *policy.PolicyDecision) {
+	s.activity.Publish(activity.Event{
+		Type:     activity.EventDecision,
+		Source:   "proxy",
+		Client:   client,
+		Host:     host,
+		Action:   string(decision.Action),
+		Reason:   decision.Reason,
+		Category: decision.Category,
+		Profile:  decision.Profile,
+	})
+}
+
 // removeHopByHopHeaders removes hop-by-hop headers
 func removeHopByHopHeaders(h http.Header) {
 	hopByHopHeaders := []string{
@@ -607,141 +1450,3 @@ func (s *Server) matchesServerName(host string) bool {
 	}
 	return strings.EqualFold(host, s.serverName)
 }
-
-// handleClientSetup handles client setup routes for server.name
-func (s *Server) handleClientSetup(w http.ResponseWriter, r *http.Request) {
-	s.logger.Debug().
-		Str("path", r.URL.Path).
-		Msg("Serving client setup route")
-
-	switch r.URL.Path {
-	case "/ca.crt", "/setup/ca.crt":
-		s.serveRootCertificate(w, r)
-	case "/", "/setup", "/setup/":
-		s.serveSetupPage(w, r)
-	default:
-		http.NotFound(w, r)
-	}
-}
-
-// serveRootCertificate serves the root CA certificate for installation
-func (s *Server) serveRootCertificate(w http.ResponseWriter, r *http.Request) {
-	// Get root certificate from CA
-	certPEM, err := s.ca.GetRootCertPEM()
-	if err != nil {
-		s.logger.Error().Err(err).Msg("Failed to get root certificate")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
-
-	// Set headers for certificate download
-	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
-	w.Header().Set("Content-Disposition", "attachment; filename=kproxy-root-ca.crt")
-	w.WriteHeader(http.StatusOK)
-
-	if _, err := w.Write(certPEM); err != nil {
-		s.logger.Error().Err(err).Msg("Failed to write certificate")
-	}
-
-	s.logger.Info().
-		Str("client", s.extractClientIP(r).String()).
-		Msg("Root certificate downloaded")
-}
-
-// serveSetupPage serves the client setup page
-func (s *Server) serveSetupPage(w http.ResponseWriter, r *http.Request) {
-	setupHTML := fmt.Sprintf(`<!DOCTYPE html>
-<html lang="en">
-<head>
-	<meta charset="UTF-8">
-	<meta name="viewport" content="width=device-width, initial-scale=1.0">
-	<title>KProxy Client Setup</title>
-	<style>
-		* { margin: 0; padding: 0; box-sizing: border-box; }
-		body {
-			font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
-			background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%);
-			min-height: 100vh;
-			display: flex;
-			align-items: center;
-			justify-content: center;
-			padding: 20px;
-		}
-		.container {
-			background: white;
-			border-radius: 16px;
-			padding: 40px;
-			max-width: 600px;
-			box-shadow: 0 20px 60px rgba(0,0,0,0.3);
-		}
-		.logo { font-size: 48px; text-align: center; margin-bottom: 20px; }
-		h1 { color: #333; margin-bottom: 16px; text-align: center; }
-		p { color: #666; line-height: 1.6; margin-bottom: 24px; }
-		.steps {
-			background: #f8f9fa;
-			padding: 20px;
-			border-radius: 8px;
-			margin-bottom: 24px;
-		}
-		.step {
-			margin-bottom: 16px;
-			padding-left: 24px;
-			position: relative;
-		}
-		.step:before {
-			content: "→";
-			position: absolute;
-			left: 0;
-			color: #667eea;
-			font-weight: bold;
-		}
-		.download-btn {
-			display: block;
-			width: 100%%;
-			padding: 16px;
-			background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%);
-			color: white;
-			text-align: center;
-			text-decoration: none;
-			border-radius: 8px;
-			font-weight: bold;
-			font-size: 16px;
-			transition: transform 0.2s;
-		}
-		.download-btn:hover {
-			transform: translateY(-2px);
-			box-shadow: 0 4px 12px rgba(0,0,0,0.2);
-		}
-		.info { font-size: 14px; color: #999; margin-top: 24px; text-align: center; }
-	</style>
-</head>
-<body>
-	<div class="container">
-		<div class="logo">🔒</div>
-		<h1>KProxy Client Setup</h1>
-		<p>Welcome to KProxy! To use this proxy with HTTPS interception, you need to install the root certificate on your device.</p>
-
-		<div class="steps">
-			<div class="step">Download the root certificate below</div>
-			<div class="step">Install it as a trusted root certificate on your device</div>
-			<div class="step">Configure your device to use this proxy for DNS/HTTP/HTTPS</div>
-		</div>
-
-		<a href="/ca.crt" class="download-btn" download="kproxy-root-ca.crt">
-			Download Root Certificate
-		</a>
-
-		<p class="info">
-			Server: %s<br>
-			Need help? Check the KProxy documentation for installation instructions.
-		</p>
-	</div>
-</body>
-</html>`, s.serverName)
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	if _, err := w.Write([]byte(setupHTML)); err != nil {
-		s.logger.Error().Err(err).Msg("Failed to write setup page")
-	}
-}
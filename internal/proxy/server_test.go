@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/blockpage"
+	"github.com/goodtune/kproxy/internal/maintenance"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestMaintenancePage(t *testing.T) {
+	s := NewServer(Config{ServerName: "local.kproxy", HTTPSPort: 443}, nil, nil, zerolog.Nop())
+	maint := maintenance.New("Back <soon>", time.Hour, zerolog.Nop())
+	s.SetMaintenance(maint)
+	maint.Enable("", 0)
+
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+	if !strings.Contains(rec.Body.String(), "Back &lt;soon&gt;") {
+		t.Error("maintenance page doesn't show the escaped message")
+	}
+
+	// Client setup on the server name still works
+	rec = httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://local.kproxy/setup", nil))
+	if rec.Code != http.StatusMovedPermanently {
+		t.Errorf("server name status = %d, want %d", rec.Code, http.StatusMovedPermanently)
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(upstream.Certificate())
+
+	for _, tt := range []struct {
+		http2     bool
+		wantALPN  []string
+		wantProto string
+	}{
+		{http2: true, wantALPN: []string{"h2", "http/1.1"}, wantProto: "HTTP/2.0"},
+		{http2: false, wantALPN: []string{"http/1.1"}, wantProto: "HTTP/1.1"},
+	} {
+		s := NewServer(Config{HTTP2: tt.http2}, nil, nil, zerolog.Nop())
+		s.transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+
+		if got := s.httpsServer.TLSConfig.NextProtos; strings.Join(got, ",") != strings.Join(tt.wantALPN, ",") {
+			t.Errorf("http2=%v: NextProtos = %v, want %v", tt.http2, got, tt.wantALPN)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "https://"+upstream.Listener.Addr().String()+"/", nil)
+		req.RequestURI = "/" // As received by the HTTPS server
+		rec := httptest.NewRecorder()
+		s.handleProxy(rec, req, nil, true, &policy.PolicyDecision{}, &requestTiming{})
+
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.wantProto {
+			t.Errorf("http2=%v: upstream saw %d %q, want %q", tt.http2, rec.Code, rec.Body.String(), tt.wantProto)
+		}
+	}
+}
+
+func TestCustomBlockPage(t *testing.T) {
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+	pages := blockpage.New(memory.Open().BlockPages(), zerolog.Nop())
+	s.SetBlockPages(pages)
+	if _, _, err := pages.Put(context.Background(), "family", "<h1>No {{.Category}} for {{.Profile}}</h1>"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		template string
+		want     string
+	}{
+		{template: "family", want: "<h1>No gaming for kids</h1>"},
+		{template: "missing", want: "Access Blocked"}, // Built-in page
+		{template: "", want: "Access Blocked"},
+	} {
+		rec := httptest.NewRecorder()
+		s.handleBlock(rec, httptest.NewRequest(http.MethodGet, "http://games.example/", nil), &policy.PolicyDecision{
+			Action:        policy.ActionBlock,
+			Reason:        "matched block rule: games",
+			BlockTemplate: tt.template,
+			Profile:       "kids",
+			Category:      "gaming",
+		})
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("template %q: %d %q, want 403 with %q", tt.template, rec.Code, rec.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestAddRequestFacts(t *testing.T) {
+	s := NewServer(Config{PolicyHeaders: []string{"referer", "X-Missing"}}, nil, nil, zerolog.Nop())
+
+	r := httptest.NewRequest(http.MethodGet, "http://youtube.com/results?search_query=minecraft&sp=a&sp=b", nil)
+	r.Header.Set("Referer", "https://www.youtube.com/")
+	r.Header.Set("Cookie", "secret")
+	req := &policy.ProxyRequest{}
+	s.addRequestFacts(r, req)
+
+	if got := req.Query["search_query"]; len(got) != 1 || got[0] != "minecraft" || len(req.Query["sp"]) != 2 {
+		t.Errorf("Query = %v, want search_query and both sp values", req.Query)
+	}
+	if len(req.Headers) != 1 || req.Headers["referer"] != "https://www.youtube.com/" {
+		t.Errorf("Headers = %v, want only the referer", req.Headers)
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"golang.org/x/time/rate"
+)
+
+// shaperBurst is the most a transfer may send at once, which bounds how far
+// a profile can run ahead of its share
+const shaperBurst = 64 * 1024
+
+// Shaper divides a shared link between profiles in proportion to their
+// bandwidth weights. Only profiles with a response in flight count towards
+// the split, so an idle profile's share goes to the others.
+type Shaper struct {
+	bytesPerSec float64
+
+	mu       sync.Mutex
+	profiles map[string]*profileShare
+}
+
+// profileShare is one profile's slice of the link
+type profileShare struct {
+	weight    int
+	transfers int
+	limiter   *rate.Limiter
+}
+
+// NewShaper creates a shaper for a link of kbps kilobits per second
+func NewShaper(kbps int) *Shaper {
+	return &Shaper{
+		bytesPerSec: float64(kbps) * 1000 / 8,
+		profiles:    make(map[string]*profileShare),
+	}
+}
+
+// Writer wraps w so that writes draw from the profile's share of the link.
+// The returned release function must be called when the transfer is done.
+func (s *Shaper) Writer(ctx context.Context, w io.Writer, profile string, weight int) (io.Writer, func()) {
+	if weight < 1 {
+		weight = 1
+	}
+
+	s.mu.Lock()
+	share, ok := s.profiles[profile]
+	if !ok {
+		share = &profileShare{limiter: rate.NewLimiter(0, shaperBurst)}
+		s.profiles[profile] = share
+	}
+	share.weight = weight
+	share.transfers++
+	s.rebalanceLocked()
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		share.transfers--
+		if share.transfers == 0 {
+			delete(s.profiles, profile)
+			metrics.ProfileBandwidthShare.DeleteLabelValues(profile)
+		}
+		s.rebalanceLocked()
+	}
+
+	return &shapedWriter{ctx: ctx, w: w, limiter: share.limiter}, release
+}
+
+// rebalanceLocked splits the link between active profiles by weight
+func (s *Shaper) rebalanceLocked() {
+	total := 0
+	for _, share := range s.profiles {
+		total += share.weight
+	}
+
+	for profile, share := range s.profiles {
+		bytesPerSec := s.bytesPerSec * float64(share.weight) / float64(total)
+		share.limiter.SetLimit(rate.Limit(bytesPerSec))
+		metrics.ProfileBandwidthShare.WithLabelValues(profile).Set(bytesPerSec)
+	}
+}
+
+// shapedWriter writes at the rate of a profile's share
+type shapedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *rate.Limiter
+}
+
+func (sw *shapedWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		n := min(len(p), shaperBurst)
+		if err := sw.limiter.WaitN(sw.ctx, n); err != nil {
+			return total, err
+		}
+
+		written, err := sw.w.Write(p[:n])
+		total += written
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+
+	return total, nil
+}
+
+// profileCounter counts response bytes per profile as they are written
+type profileCounter struct {
+	w       io.Writer
+	profile string
+}
+
+func (pc *profileCounter) Write(p []byte) (int, error) {
+	n, err := pc.w.Write(p)
+	metrics.ProfileBytesTotal.WithLabelValues(pc.profile).Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestShaperSplitsByWeight(t *testing.T) {
+	shaper := NewShaper(800) // 100,000 bytes per second
+
+	var buf bytes.Buffer
+	_, releaseChild := shaper.Writer(context.Background(), &buf, "child", 1)
+	_, releaseTeen := shaper.Writer(context.Background(), &buf, "teen", 3)
+
+	limit := func(profile string) rate.Limit {
+		shaper.mu.Lock()
+		defer shaper.mu.Unlock()
+		share, ok := shaper.profiles[profile]
+		if !ok {
+			return 0
+		}
+		return share.limiter.Limit()
+	}
+
+	if got := limit("child"); got != 25000 {
+		t.Errorf("child share = %v, want 25000", got)
+	}
+	if got := limit("teen"); got != 75000 {
+		t.Errorf("teen share = %v, want 75000", got)
+	}
+
+	// An idle profile's share goes to the others
+	releaseTeen()
+	if got := limit("child"); got != 100000 {
+		t.Errorf("child share after teen finished = %v, want 100000", got)
+	}
+	if _, ok := shaper.profiles["teen"]; ok {
+		t.Error("expected finished profile to be removed")
+	}
+	releaseChild()
+}
+
+func TestShapedWriterWritesEverything(t *testing.T) {
+	shaper := NewShaper(8000000) // Fast enough not to slow the test
+
+	var buf bytes.Buffer
+	w, release := shaper.Writer(context.Background(), &buf, "child", 0)
+	defer release()
+
+	data := make([]byte, 3*shaperBurst+1)
+	n, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if n != len(data) || buf.Len() != len(data) {
+		t.Errorf("wrote %d bytes (buffer %d), want %d", n, buf.Len(), len(data))
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/policy"
+)
+
+// listenTLSPorts listens on the extra TLS ports, for protocols other than
+// HTTPS (IMAPS on 993, apps on 8443...) that reach the proxy because DNS
+// sent them there. Their traffic can't be intercepted, so connections are
+// filtered by SNI and tunnelled to the origin on the same port, or dropped.
+func (s *Server) listenTLSPorts() error {
+	for _, addr := range s.tlsAddrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			s.closeTLSPorts()
+			return fmt.Errorf("TLS port listener error: %w", err)
+		}
+		s.tlsPorts = append(s.tlsPorts, ln)
+		go s.serveTLSPort(ln)
+		s.logger.Info().Str("addr", addr).Msg("Filtering TLS by SNI")
+	}
+	return nil
+}
+
+// closeTLSPorts stops listening on the extra TLS ports. Tunnels already
+// open carry on until either side closes.
+func (s *Server) closeTLSPorts() {
+	for _, ln := range s.tlsPorts {
+		_ = ln.Close()
+	}
+	s.tlsPorts = nil
+}
+
+// serveTLSPort accepts connections until the listener is closed
+func (s *Server) serveTLSPort(ln net.Listener) {
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Error().Err(err).Str("addr", ln.Addr().String()).Msg("TLS port accept error")
+			}
+			return
+		}
+		go s.handleTLSPort(conn, port)
+	}
+}
+
+// handleTLSPort reads the ClientHello, applies domain policy to its SNI and
+// tunnels the connection to the origin or drops it
+func (s *Server) handleTLSPort(conn net.Conn, port string) {
+	startTime := time.Now()
+
+	var hello bytes.Buffer
+	_ = conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	serverName := readServerName(io.TeeReader(conn, &hello))
+	_ = conn.SetReadDeadline(time.Time{})
+
+	// Without SNI there is no telling where the client meant to go
+	if serverName == "" {
+		s.logger.Debug().Str("client", conn.RemoteAddr().String()).Str("port", port).Msg("Dropping TLS connection without SNI")
+		_ = conn.Close()
+		return
+	}
+
+	clientIP := net.ParseIP(hostOnly(conn.RemoteAddr().String()))
+	policyReq := &policy.ProxyRequest{
+		ClientIP:  clientIP,
+		ClientMAC: s.arp.Lookup(clientIP),
+		Host:      serverName,
+		Path:      "/",
+		Method:    "CONNECT",
+		Encrypted: true,
+	}
+	timing := &requestTiming{}
+	decision := &policy.PolicyDecision{Action: policy.ActionAllow}
+	if s.policyEngine != nil {
+		decision = s.evaluate(policyReq, timing)
+	}
+
+	status, size := http.StatusForbidden, int64(0)
+	if decision.Action == policy.ActionBlock {
+		_ = conn.Close()
+	} else {
+		status, size = s.tunnelTo(conn, serverName, port, decision.Egress, hello.Bytes(), timing)
+	}
+	s.logRequest(policyReq, decision, status, size, time.Since(startTime).Milliseconds(), timing)
+	timing.observe()
+
+	deviceName := clientIP.String()
+	metrics.RequestsTotal.WithLabelValues(deviceName, serverName, string(decision.Action), policyReq.Method).Inc()
+	metrics.RequestDuration.WithLabelValues(deviceName, string(decision.Action)).Observe(time.Since(startTime).Seconds())
+	s.analytics.Record("proxy", deviceName, decision.Category, string(decision.Action))
+	s.publishDecision(deviceName, serverName, decision)
+	if decision.Action == policy.ActionBlock {
+		metrics.BlockedRequests.WithLabelValues(deviceName, decision.Reason).Inc()
+		s.activity.RecordBlock("proxy", deviceName, serverName, decision.Reason)
+	}
+}
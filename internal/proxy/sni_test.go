@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestTLSPort(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+
+	s := NewServer(Config{TLSAddrs: []string{"127.0.0.1:0"}}, nil, nil, zerolog.Nop())
+	dialed := make(chan string, 1)
+	s.dialOrigin = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed <- addr
+		return (&net.Dialer{}).DialContext(ctx, network, origin.Listener.Addr().String())
+	}
+	if err := s.listenTLSPorts(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.closeTLSPorts()
+	addr := s.tlsPorts[0].Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+
+	// The connection is tunnelled, unintercepted, to the SNI's host on the
+	// same port
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+			TLSClientConfig: &tls.Config{ServerName: "example.com", InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Get("https://example.com:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "origin" {
+		t.Errorf("body = %q, want origin", body)
+	}
+	if got := <-dialed; got != net.JoinHostPort("example.com", port) {
+		t.Errorf("dialed %s, want example.com:%s", got, port)
+	}
+
+	// Without SNI the connection is dropped
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		_ = conn.Close()
+		t.Error("connection without SNI was tunnelled")
+	}
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// streamForget is how long a device's last stream is remembered. Policy
+// decides how long a pause still counts as streaming; this only bounds the
+// facts it is given.
+const streamForget = 15 * time.Minute
+
+// StreamTracker counts the media responses in flight per profile and
+// device, so policy can limit how many devices of a profile stream at once.
+// Players fetch video in segments with pauses in between, so a device's
+// idle time since its last stream is tracked too.
+type StreamTracker struct {
+	mu      sync.Mutex
+	streams map[string]map[string]*streamState // Profile -> device
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// streamState is one device's streams within a profile
+type streamState struct {
+	active int
+	last   time.Time // When the last stream ended
+}
+
+// NewStreamTracker creates an empty stream tracker
+func NewStreamTracker() *StreamTracker {
+	return &StreamTracker{
+		streams: make(map[string]map[string]*streamState),
+		now:     time.Now,
+	}
+}
+
+// Begin records a stream of device in profile. The returned function must
+// be called when the response is done.
+func (t *StreamTracker) Begin(profile, device string) func() {
+	t.mu.Lock()
+	devices, ok := t.streams[profile]
+	if !ok {
+		devices = make(map[string]*streamState)
+		t.streams[profile] = devices
+	}
+	state, ok := devices[device]
+	if !ok {
+		state = &streamState{}
+		devices[device] = state
+	}
+	state.active++
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		state.active--
+		state.last = t.now()
+	}
+}
+
+// PolicyStreams returns the streams as input.streams for OPA: per profile
+// and device, the responses in flight and the whole seconds since the last
+// one ended (0 while one is in flight)
+func (t *StreamTracker) PolicyStreams() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	facts := make(map[string]interface{}, len(t.streams))
+	for profile, devices := range t.streams {
+		profileFacts := make(map[string]interface{}, len(devices))
+		for device, state := range devices {
+			idle := 0
+			if state.active == 0 {
+				if now.Sub(state.last) > streamForget {
+					delete(devices, device)
+					continue
+				}
+				idle = int(math.Floor(now.Sub(state.last).Seconds()))
+			}
+			profileFacts[device] = map[string]interface{}{
+				"active":       state.active,
+				"idle_seconds": idle,
+			}
+		}
+		if len(devices) == 0 {
+			delete(t.streams, profile)
+			continue
+		}
+		facts[profile] = profileFacts
+	}
+	return facts
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamTracker(t *testing.T) {
+	tracker := NewStreamTracker()
+	now := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	stream := func(profile, device string) map[string]interface{} {
+		facts, _ := tracker.PolicyStreams()[profile].(map[string]interface{})
+		state, _ := facts[device].(map[string]interface{})
+		return state
+	}
+
+	endTV := tracker.Begin("kids", "tv")
+	endTablet := tracker.Begin("kids", "tablet")
+	endTablet2 := tracker.Begin("kids", "tablet")
+	if got := stream("kids", "tablet"); got["active"] != 2 || got["idle_seconds"] != 0 {
+		t.Errorf("tablet = %v, want two active streams", got)
+	}
+
+	// A device stays in the facts between segments, with its idle time
+	endTablet()
+	endTablet2()
+	now = now.Add(42 * time.Second)
+	if got := stream("kids", "tablet"); got["active"] != 0 || got["idle_seconds"] != 42 {
+		t.Errorf("tablet after its streams ended = %v, want idle for 42 seconds", got)
+	}
+	if got := stream("kids", "tv"); got["active"] != 1 {
+		t.Errorf("tv = %v, want one active stream", got)
+	}
+
+	// Long-idle devices are forgotten
+	endTV()
+	now = now.Add(streamForget + time.Second)
+	if facts := tracker.PolicyStreams(); len(facts) != 0 {
+		t.Errorf("PolicyStreams() = %v, want nothing after %s idle", facts, streamForget)
+	}
+}
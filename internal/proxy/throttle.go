@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// throttleChunkInterval is how much transfer time each write is split into,
+// so a capped response trickles out smoothly rather than in bursts
+const throttleChunkInterval = 100 * time.Millisecond
+
+// throttleDelay waits for d before a request is proxied, or until the client
+// goes away. It reports false if the context was cancelled.
+func throttleDelay(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// throttledWriter caps the rate at which a response body is written
+type throttledWriter struct {
+	w           io.Writer
+	bytesPerSec int64
+	start       time.Time
+	written     int64
+
+	// Replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// newThrottledWriter wraps w to write at most kbps kilobits per second
+func newThrottledWriter(w io.Writer, kbps int) *throttledWriter {
+	return &throttledWriter{
+		w:           w,
+		bytesPerSec: int64(kbps) * 1000 / 8,
+		start:       time.Now(),
+		now:         time.Now,
+		sleep:       time.Sleep,
+	}
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	chunk := max(t.bytesPerSec*int64(throttleChunkInterval)/int64(time.Second), 1)
+
+	total := 0
+	for len(p) > 0 {
+		n := int(min(int64(len(p)), chunk))
+
+		// Wait until the bytes already written are due at the capped rate
+		due := time.Duration(t.written * int64(time.Second) / t.bytesPerSec)
+		if wait := due - t.now().Sub(t.start); wait > 0 {
+			t.sleep(wait)
+		}
+
+		written, err := t.w.Write(p[:n])
+		total += written
+		t.written += int64(written)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+
+	return total, nil
+}
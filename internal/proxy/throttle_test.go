@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThrottledWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newThrottledWriter(&buf, 80) // 10,000 bytes per second
+
+	// Fake clock that advances only while sleeping
+	now := w.start
+	var slept time.Duration
+	w.now = func() time.Time { return now }
+	w.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+
+	data := make([]byte, 10000)
+	n, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if n != len(data) || buf.Len() != len(data) {
+		t.Fatalf("wrote %d bytes (buffer %d), want %d", n, buf.Len(), len(data))
+	}
+
+	// The last 1,000-byte chunk is due after 9,000 bytes at 10,000 bytes/s
+	if slept != 900*time.Millisecond {
+		t.Errorf("slept %v, want 900ms", slept)
+	}
+}
+
+func TestThrottleDelayCancelled(t *testing.T) {
+	if !throttleDelay(context.Background(), 0) {
+		t.Error("expected zero delay to proceed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if throttleDelay(ctx, time.Hour) {
+		t.Error("expected cancelled request not to proceed")
+	}
+}
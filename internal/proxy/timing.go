@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// slowPolicyCheckInterval is how often the policy evaluation p99 is checked
+	slowPolicyCheckInterval = time.Minute
+
+	// maxPolicySamples caps the evaluation times kept between checks
+	maxPolicySamples = 10000
+)
+
+// requestTiming splits the time spent on a request into phases: policy
+// evaluation, upstream DNS lookup, minting the interception certificate,
+// connecting upstream (including its TLS handshake) and transferring the
+// response. Phases a request skipped stay zero.
+type requestTiming struct {
+	mu       sync.Mutex
+	policy   time.Duration
+	dns      time.Duration
+	tlsMint  time.Duration
+	connect  time.Duration
+	transfer time.Duration
+
+	getConn  time.Time
+	dnsStart time.Time
+}
+
+// set records the duration of a phase
+func (t *requestTiming) set(phase *time.Duration, d time.Duration) {
+	t.mu.Lock()
+	*phase = d
+	t.mu.Unlock()
+}
+
+// clientTrace times the upstream DNS lookup and connection. Connect is the
+// wait for a connection less the lookup, so it is near zero when an idle
+// connection is reused.
+func (t *requestTiming) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			t.mu.Lock()
+			t.getConn = time.Now()
+			t.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dns = time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			t.mu.Lock()
+			if connect := time.Since(t.getConn) - t.dns; connect > 0 {
+				t.connect = connect
+			}
+			t.mu.Unlock()
+		},
+	}
+}
+
+// phaseTime is the time spent in one named phase
+type phaseTime struct {
+	name string
+	d    time.Duration
+}
+
+// phases returns the phases in order
+func (t *requestTiming) phases() []phaseTime {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return []phaseTime{
+		{"policy", t.policy},
+		{"dns", t.dns},
+		{"tls_mint", t.tlsMint},
+		{"connect", t.connect},
+		{"transfer", t.transfer},
+	}
+}
+
+// dict returns the breakdown for the request log
+func (t *requestTiming) dict() *zerolog.Event {
+	d := zerolog.Dict()
+	for _, phase := range t.phases() {
+		d = d.Dur(phase.name, phase.d)
+	}
+	return d
+}
+
+// observe records the phases the request went through
+func (t *requestTiming) observe() {
+	for _, phase := range t.phases() {
+		if phase.d > 0 {
+			metrics.RequestPhaseDuration.WithLabelValues(phase.name).Observe(phase.d.Seconds())
+		}
+	}
+}
+
+// connContextKey holds the connection a request arrived on
+type connContextKey struct{}
+
+// withConn is the HTTPS server's ConnContext, giving handlers the
+// connection so they can report the certificate minted for it
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// certMintTime returns the time taken to mint the certificate for the
+// request's connection. Only the first request on a connection reports it.
+func certMintTime(r *http.Request) time.Duration {
+	tlsConn, ok := r.Context().Value(connContextKey{}).(*tls.Conn)
+	if !ok {
+		return 0
+	}
+	if c, ok := tlsConn.NetConn().(*replayConn); ok {
+		return time.Duration(c.certMint.Swap(0))
+	}
+	return 0
+}
+
+// slowPolicyWatch warns when the 99th percentile policy evaluation time
+// of recent requests exceeds a threshold, and again once it recovers
+type slowPolicyWatch struct {
+	threshold time.Duration
+	logger    zerolog.Logger
+	stopChan  chan struct{}
+
+	mu      sync.Mutex
+	samples []time.Duration
+	slow    bool
+}
+
+// newSlowPolicyWatch creates a watch (nil if threshold is not positive)
+func newSlowPolicyWatch(threshold time.Duration, logger zerolog.Logger) *slowPolicyWatch {
+	if threshold <= 0 {
+		return nil
+	}
+	return &slowPolicyWatch{
+		threshold: threshold,
+		logger:    logger,
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// add records one evaluation time
+func (w *slowPolicyWatch) add(d time.Duration) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	if len(w.samples) < maxPolicySamples {
+		w.samples = append(w.samples, d)
+	}
+	w.mu.Unlock()
+}
+
+// start checks the p99 every interval until stopped
+func (w *slowPolicyWatch) start() {
+	if w == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(slowPolicyCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.check()
+			case <-w.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the checks
+func (w *slowPolicyWatch) stop() {
+	if w == nil {
+		return
+	}
+	close(w.stopChan)
+}
+
+// check computes the p99 of the evaluations since the last check and
+// alerts when it crosses the threshold
+func (w *slowPolicyWatch) check() {
+	w.mu.Lock()
+	samples := w.samples
+	w.samples = nil
+	w.mu.Unlock()
+	if len(samples) == 0 {
+		return
+	}
+
+	p99 := percentile(samples, 0.99)
+	metrics.PolicyEvalP99.Set(p99.Seconds())
+
+	switch {
+	case p99 > w.threshold && !w.slow:
+		w.slow = true
+		w.logger.Warn().
+			Dur("p99", p99).
+			Dur("threshold", w.threshold).
+			Int("requests", len(samples)).
+			Msg("Policy evaluation p99 exceeds threshold")
+	case p99 <= w.threshold && w.slow:
+		w.slow = false
+		w.logger.Info().
+			Dur("p99", p99).
+			Dur("threshold", w.threshold).
+			Msg("Policy evaluation p99 back under threshold")
+	}
+}
+
+// percentile returns the p-th percentile (0 < p <= 1) of durations,
+// sorting them in place
+func percentile(durations []time.Duration, p float64) time.Duration {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	i := int(math.Ceil(float64(len(durations))*p)) - 1
+	return durations[max(i, 0)]
+}
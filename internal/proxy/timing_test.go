@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+)
+
+func TestRequestTiming(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+	timing := &requestTiming{}
+	// Named by host so the lookup is timed too
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+	req := httptest.NewRequest(http.MethodGet, "http://"+net.JoinHostPort("localhost", port)+"/", nil)
+	req.RequestURI = "/"
+	s.handleProxy(httptest.NewRecorder(), req, nil, false, &policy.PolicyDecision{}, timing)
+
+	if timing.dns <= 0 || timing.connect <= 0 || timing.transfer <= 0 {
+		t.Errorf("dns = %v, connect = %v, transfer = %v, want all measured", timing.dns, timing.connect, timing.transfer)
+	}
+
+	// A reused connection needs no lookup or connect
+	timing = &requestTiming{}
+	s.handleProxy(httptest.NewRecorder(), req, nil, false, &policy.PolicyDecision{}, timing)
+	if timing.dns != 0 {
+		t.Errorf("dns = %v on a reused connection, want 0", timing.dns)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	if got := percentile(durations, 0.99); got != 99*time.Millisecond {
+		t.Errorf("p99 = %v, want 99ms", got)
+	}
+	if got := percentile([]time.Duration{time.Second}, 0.99); got != time.Second {
+		t.Errorf("p99 of one = %v, want 1s", got)
+	}
+}
+
+func TestSlowPolicyWatch(t *testing.T) {
+	if newSlowPolicyWatch(0, zerolog.Nop()) != nil {
+		t.Error("watch created with no threshold")
+	}
+
+	w := newSlowPolicyWatch(50*time.Millisecond, zerolog.Nop())
+	for i := 0; i < 98; i++ {
+		w.add(time.Millisecond)
+	}
+	w.add(time.Second)
+	w.add(time.Second)
+	w.check()
+	if !w.slow {
+		t.Error("p99 of 1s not reported slow")
+	}
+
+	w.add(time.Millisecond)
+	w.check()
+	if w.slow {
+		t.Error("still slow after a fast interval")
+	}
+
+	var none *slowPolicyWatch
+	none.add(time.Second)
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// TransparentConfig enables listeners for connections the firewall
+// redirects to the proxy (iptables/nftables TPROXY or REDIRECT), keeping
+// their original destination. This intercepts clients that never ask
+// KProxy's DNS, such as devices with hard-coded resolvers.
+type TransparentConfig struct {
+	Mode      string // "tproxy" (IP_TRANSPARENT) or "redirect" (SO_ORIGINAL_DST)
+	HTTPAddr  string
+	HTTPSAddr string
+}
+
+// originalDstKey is the context key of the original destination upstream
+// connections are made to
+type originalDstKey struct{}
+
+// startTransparent serves redirected connections on the transparent
+// listeners, alongside the regular ones
+func (s *Server) startTransparent(errChan chan<- error) error {
+	httpLn, err := listenTransparent(s.transparent.Mode, s.transparent.HTTPAddr)
+	if err != nil {
+		return fmt.Errorf("transparent HTTP listener error: %w", err)
+	}
+	httpsLn, err := listenTransparent(s.transparent.Mode, s.transparent.HTTPSAddr)
+	if err != nil {
+		_ = httpLn.Close()
+		return fmt.Errorf("transparent HTTPS listener error: %w", err)
+	}
+	s.logger.Info().
+		Str("mode", s.transparent.Mode).
+		Str("http_addr", s.transparent.HTTPAddr).
+		Str("https_addr", s.transparent.HTTPSAddr).
+		Msg("Starting transparent interception")
+
+	go func() {
+		err := s.httpServer.Serve(newTransparentListener(httpLn, s.transparent.Mode, &s.intercepted))
+		if err != nil && err != http.ErrServerClosed {
+			errChan <- fmt.Errorf("transparent HTTP server error: %w", err)
+		}
+	}()
+	go func() {
+		passthrough := newPassthroughListener(newTransparentListener(httpsLn, s.transparent.Mode, &s.intercepted), s.routeTLS)
+		err := s.httpsServer.Serve(tls.NewListener(passthrough, s.httpsServer.TLSConfig))
+		if err != nil && err != http.ErrServerClosed {
+			errChan <- fmt.Errorf("transparent HTTPS server error: %w", err)
+		}
+	}()
+	return nil
+}
+
+// originalDst returns the destination a redirected client was connecting
+// to, or nil if the client connected to the proxy itself
+func (s *Server) originalDst(clientAddr string) *net.TCPAddr {
+	if dst, ok := s.intercepted.Load(clientAddr); ok {
+		return dst.(*net.TCPAddr)
+	}
+	return nil
+}
+
+// transparentListener records the original destination of each redirected
+// connection it accepts, by client address, until the connection closes
+type transparentListener struct {
+	net.Listener
+	mode  string
+	dsts  *sync.Map
+	local map[string]bool // Addresses of this host on the listening port
+}
+
+// newTransparentListener wraps a listener bound for redirected connections
+func newTransparentListener(inner net.Listener, mode string, dsts *sync.Map) *transparentListener {
+	l := &transparentListener{
+		Listener: inner,
+		mode:     mode,
+		dsts:     dsts,
+		local:    make(map[string]bool),
+	}
+	if addr, ok := inner.Addr().(*net.TCPAddr); ok {
+		port := strconv.Itoa(addr.Port)
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, a := range addrs {
+				if ipNet, ok := a.(*net.IPNet); ok {
+					l.local[net.JoinHostPort(ipNet.IP.String(), port)] = true
+				}
+			}
+		}
+	}
+	return l
+}
+
+// Accept returns the next connection, noting where it was headed
+func (l *transparentListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	dst, err := originalDst(conn, l.mode)
+	if err != nil || l.local[dst.String()] {
+		// Connected to the proxy directly rather than redirected
+		return conn, nil
+	}
+
+	key := conn.RemoteAddr().String()
+	l.dsts.Store(key, dst)
+	return &interceptedConn{Conn: conn, forget: func() { l.dsts.Delete(key) }}, nil
+}
+
+// originalDst finds where a redirected connection was headed: TPROXY
+// leaves it as the local address, REDIRECT rewrites it away
+func originalDst(conn net.Conn, mode string) (*net.TCPAddr, error) {
+	if mode == "redirect" {
+		return redirectedDst(conn)
+	}
+	addr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("not a TCP connection")
+	}
+	return addr, nil
+}
+
+// interceptedConn forgets its original destination when closed
+type interceptedConn struct {
+	net.Conn
+	forget    func()
+	closeOnce sync.Once
+}
+
+func (c *interceptedConn) Close() error {
+	c.closeOnce.Do(c.forget)
+	return c.Conn.Close()
+}
+
+// dialUpstream dials addr, or the original destination of a redirected
+// client's request when the context carries one
+func dialUpstream(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if dst, ok := ctx.Value(originalDstKey{}).(*net.TCPAddr); ok {
+			addr = dst.String()
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
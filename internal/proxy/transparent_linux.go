@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenTransparent listens for redirected connections. TPROXY needs
+// IP_TRANSPARENT (CAP_NET_ADMIN) to accept connections addressed to other
+// hosts.
+func listenTransparent(mode, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if mode == "tproxy" {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+				if sockErr == nil && network == "tcp6" {
+					sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if sockErr != nil {
+				return fmt.Errorf("failed to set IP_TRANSPARENT (needs CAP_NET_ADMIN): %w", sockErr)
+			}
+			return nil
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// redirectedDst reads the destination a connection had before REDIRECT
+// rewrote it from conntrack (IPv4 only)
+func redirectedDst(conn net.Conn) (*net.TCPAddr, error) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("not a TCP connection")
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var dst *net.TCPAddr
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		// SO_ORIGINAL_DST fills in a sockaddr_in, which fits an IPv6Mreq
+		var mreq *unix.IPv6Mreq
+		mreq, sockErr = unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+		if sockErr != nil {
+			return
+		}
+		sa := mreq.Multiaddr
+		dst = &net.TCPAddr{
+			IP:   net.IPv4(sa[4], sa[5], sa[6], sa[7]),
+			Port: int(sa[2])<<8 | int(sa[3]),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, fmt.Errorf("failed to read original destination: %w", sockErr)
+	}
+	return dst, nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+// errTransparentUnsupported is returned where the platform can't intercept
+// redirected connections
+var errTransparentUnsupported = errors.New("transparent interception is only supported on Linux")
+
+// listenTransparent is unsupported off Linux
+func listenTransparent(mode, addr string) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+// redirectedDst is unsupported off Linux
+func redirectedDst(conn net.Conn) (*net.TCPAddr, error) {
+	return nil, errTransparentUnsupported
+}
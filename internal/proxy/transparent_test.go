@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/rs/zerolog"
+)
+
+func TestTransparentListener(t *testing.T) {
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := newTransparentListener(inner, "tproxy", &s.intercepted)
+	defer func() { _ = ln.Close() }()
+
+	// Connecting to the proxy itself isn't a redirected connection
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst := s.originalDst(client.LocalAddr().String()); dst != nil {
+		t.Errorf("direct connection recorded as headed for %s", dst)
+	}
+	_ = conn.Close()
+	_ = client.Close()
+
+	// With TPROXY a redirected connection's local address is the original
+	// destination, which isn't one of ours
+	ln.local = map[string]bool{}
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	conn, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := s.originalDst(client.LocalAddr().String())
+	if dst == nil || dst.String() != ln.Addr().String() {
+		t.Fatalf("original destination = %v, want %s", dst, ln.Addr())
+	}
+	_ = conn.Close()
+	if dst := s.originalDst(client.LocalAddr().String()); dst != nil {
+		t.Errorf("original destination %s kept after close", dst)
+	}
+}
+
+func TestTransparentUpstream(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "origin "+r.Host)
+	}))
+	defer origin.Close()
+	tlsOrigin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "tls origin "+r.Host)
+	}))
+	defer tlsOrigin.Close()
+
+	s := NewServer(Config{}, nil, nil, zerolog.Nop())
+	roots := x509.NewCertPool()
+	roots.AddCert(tlsOrigin.Certificate())
+	s.transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+
+	tests := []struct {
+		origin  *httptest.Server
+		isHTTPS bool
+		url     string
+		want    string
+	}{
+		{origin, false, "http://example.com/", "origin example.com"},
+		// The host name is kept for certificate verification
+		{tlsOrigin, true, "https://example.com/", "tls origin example.com"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		r.RemoteAddr = "192.168.1.50:40000"
+		r.RequestURI = "/"
+		dst := tt.origin.Listener.Addr().(*net.TCPAddr)
+		s.intercepted.Store(r.RemoteAddr, dst)
+
+		rec := httptest.NewRecorder()
+		decision := &policy.PolicyDecision{Action: policy.ActionAllow}
+		req := &policy.ProxyRequest{Host: r.Host}
+		s.handleProxy(rec, r, req, tt.isHTTPS, decision, &requestTiming{})
+		s.intercepted.Delete(r.RemoteAddr)
+
+		// example.com doesn't resolve to the test origin, so the response
+		// shows the original destination was used
+		if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+			t.Errorf("%s: got %d %q, want %q", tt.url, rec.Code, rec.Body.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package reload
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+// publishTimeout bounds announcing a change, so a slow bus can't hold up
+// the change itself
+const publishTimeout = 2 * time.Second
+
+// Broadcaster keeps KProxy instances sharing storage in step. Changes made
+// through one instance's admin API are announced on the storage's reload
+// bus, and the other instances reload their policies and the runtime state
+// they cache from storage (device rules, rule sets, pauses) when they hear
+// of one. Notices arriving while a reload runs are folded into one more
+// reload.
+type Broadcaster struct {
+	bus    storage.ReloadBus
+	origin string
+	reload func()
+	logger zerolog.Logger
+
+	pending chan struct{}
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	// Replaced in tests
+	retryDelay time.Duration
+}
+
+// New creates a broadcaster calling reload for changes announced by other
+// instances
+func New(bus storage.ReloadBus, reload func(), logger zerolog.Logger) *Broadcaster {
+	return &Broadcaster{
+		bus:        bus,
+		origin:     newOrigin(),
+		reload:     reload,
+		logger:     logger.With().Str("component", "reload").Logger(),
+		pending:    make(chan struct{}, 1),
+		retryDelay: 5 * time.Second,
+	}
+}
+
+// newOrigin names this instance in its notices: the host name and a random
+// suffix, as instances may share a host
+func newOrigin() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "kproxy"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// Origin is the name of this instance in its notices
+func (b *Broadcaster) Origin() string {
+	return b.origin
+}
+
+// Start listens for notices from other instances
+func (b *Broadcaster) Start() {
+	if b == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
+	b.wg.Add(2)
+	go b.subscribe(ctx)
+	go b.run(ctx)
+	b.logger.Info().Str("origin", b.origin).Msg("Listening for reloads from other instances")
+}
+
+// Stop stops listening
+func (b *Broadcaster) Stop() {
+	if b == nil || b.cancel == nil {
+		return
+	}
+	b.cancel()
+	b.wg.Wait()
+}
+
+// Notify announces a change made on this instance to the others
+func (b *Broadcaster) Notify() {
+	if b == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := b.bus.Publish(ctx, b.origin); err != nil {
+		b.logger.Warn().Err(err).Msg("Failed to announce change to other instances")
+	}
+}
+
+// subscribe receives notices until ctx is done, subscribing again after
+// failures
+func (b *Broadcaster) subscribe(ctx context.Context) {
+	defer b.wg.Done()
+
+	for {
+		err := b.bus.Subscribe(ctx, b.receive)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			b.logger.Warn().Err(err).Dur("retry", b.retryDelay).Msg("Reload subscription failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(b.retryDelay):
+		}
+	}
+}
+
+// receive queues a reload for a notice from another instance
+func (b *Broadcaster) receive(origin string) {
+	if origin == b.origin {
+		return
+	}
+	b.logger.Debug().Str("from", origin).Msg("Reload notice received")
+	select {
+	case b.pending <- struct{}{}:
+	default:
+		// A reload is already queued
+	}
+}
+
+// run reloads for queued notices until ctx is done
+func (b *Broadcaster) run(ctx context.Context) {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.pending:
+			b.logger.Info().Msg("Reloading for a change made on another instance")
+			b.reload()
+		}
+	}
+}
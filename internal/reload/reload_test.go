@@ -0,0 +1,87 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestBroadcaster(t *testing.T) {
+	bus := memory.Open().Reloads()
+
+	var reloadsA, reloadsB atomic.Int32
+	a := New(bus, func() { reloadsA.Add(1) }, zerolog.Nop())
+	b := New(bus, func() { reloadsB.Add(1) }, zerolog.Nop())
+	if a.Origin() == b.Origin() {
+		t.Fatalf("instances share origin %q", a.Origin())
+	}
+	a.Start()
+	defer a.Stop()
+	b.Start()
+	defer b.Stop()
+
+	// A change on a reaches b, not a itself
+	deadline := time.Now().Add(5 * time.Second)
+	for reloadsB.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("b never reloaded")
+		}
+		a.Notify()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := reloadsA.Load(); n != 0 {
+		t.Errorf("a reloaded %d times for its own change", n)
+	}
+
+	// A nil broadcaster (single instance) does nothing
+	var none *Broadcaster
+	none.Start()
+	none.Notify()
+	none.Stop()
+}
+
+// flakyBus fails the first subscription
+type flakyBus struct {
+	subscriptions atomic.Int32
+}
+
+func (f *flakyBus) Publish(ctx context.Context, origin string) error {
+	return nil
+}
+
+func (f *flakyBus) Subscribe(ctx context.Context, fn func(origin string)) error {
+	if f.subscriptions.Add(1) == 1 {
+		return errors.New("connection refused")
+	}
+	fn("other")
+	<-ctx.Done()
+	return nil
+}
+
+func TestBroadcasterResubscribes(t *testing.T) {
+	bus := &flakyBus{}
+	reloaded := make(chan struct{}, 1)
+	b := New(bus, func() {
+		select {
+		case reloaded <- struct{}{}:
+		default:
+		}
+	}, zerolog.Nop())
+	b.retryDelay = time.Millisecond
+	b.Start()
+	defer b.Stop()
+
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the subscription failed once")
+	}
+	if n := bus.subscriptions.Load(); n != 2 {
+		t.Errorf("subscribed %d times, want 2", n)
+	}
+}
@@ -0,0 +1,233 @@
+// Package report counts each profile's proxy traffic by day and renders
+// weekly reports of usage by category, top sites, blocks and time-rule
+// compliance.
+package report
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// flushInterval is how often counts are added to storage
+	flushInterval = time.Minute
+
+	// retention is how long daily counts are kept, enough for a weekly
+	// report of any day in the last four weeks
+	retention = 35 * 24 * time.Hour
+
+	// maxHosts bounds the hosts counted per profile and day; requests to
+	// further hosts are counted under otherHosts
+	maxHosts = 500
+
+	// otherHosts counts the requests to hosts past maxHosts
+	otherHosts = "(other)"
+
+	// flushTimeout bounds one flush
+	flushTimeout = 30 * time.Second
+)
+
+// dateFormat is the layout of report dates
+const dateFormat = "2006-01-02"
+
+// Recorder counts proxy decisions per profile and day, and adds the counts
+// to storage every minute. A nil *Recorder counts nothing.
+type Recorder struct {
+	store    storage.ReportStore
+	logger   zerolog.Logger
+	stopChan chan struct{}
+	doneChan chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]*storage.ReportDay // Not yet stored, by date/profile
+	minutes map[string]int64              // Last minute counted, by profile/category
+	hosts   map[string]map[string]bool    // Hosts counted today, by date/profile
+	pruned  string                        // Date of the last pruning
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates a recorder adding its counts to store
+func New(store storage.ReportStore, logger zerolog.Logger) *Recorder {
+	return &Recorder{
+		store:    store,
+		logger:   logger.With().Str("component", "report").Logger(),
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+		pending:  make(map[string]*storage.ReportDay),
+		minutes:  make(map[string]int64),
+		hosts:    make(map[string]map[string]bool),
+		now:      time.Now,
+	}
+}
+
+// Record counts one proxy decision for a profile. Requests that weren't
+// blocked count as allowed, and each minute with allowed requests in a
+// category counts as a minute of use. Decisions without a profile (unknown
+// devices) are not counted.
+func (r *Recorder) Record(profile, host, category, action, blockPage string) {
+	if r == nil || profile == "" {
+		return
+	}
+
+	now := r.now()
+	date := now.Format(dateFormat)
+	key := date + "/" + profile
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	day, ok := r.pending[key]
+	if !ok {
+		day = &storage.ReportDay{
+			Date:       date,
+			Profile:    profile,
+			Categories: make(map[string]storage.ReportCategory),
+			Sites:      make(map[string]int),
+			Blocked:    make(map[string]int),
+			BlockKinds: make(map[string]int),
+		}
+		r.pending[key] = day
+	}
+	host = r.countedHost(key, host)
+
+	c := day.Categories[category]
+	if action == "BLOCK" {
+		c.Blocked++
+		day.Blocked[host]++
+		if blockPage == "" {
+			blockPage = "rule"
+		}
+		day.BlockKinds[blockPage]++
+	} else {
+		c.Requests++
+		day.Sites[host]++
+
+		minute := now.Unix() / 60
+		minuteKey := profile + "/" + category
+		if r.minutes[minuteKey] != minute {
+			r.minutes[minuteKey] = minute
+			c.Minutes++
+		}
+	}
+	day.Categories[category] = c
+}
+
+// countedHost returns host, or otherHosts once maxHosts hosts have been
+// counted for the day and profile. Called with r.mu held.
+func (r *Recorder) countedHost(key, host string) string {
+	seen, ok := r.hosts[key]
+	if !ok {
+		seen = make(map[string]bool)
+		r.hosts[key] = seen
+	}
+	if seen[host] {
+		return host
+	}
+	if len(seen) >= maxHosts {
+		return otherHosts
+	}
+	seen[host] = true
+	return host
+}
+
+// Start begins adding the counts to storage every minute
+func (r *Recorder) Start() {
+	go r.run()
+	r.logger.Info().Msg("Weekly report counts enabled")
+}
+
+// Stop stops the recorder and stores the remaining counts
+func (r *Recorder) Stop() {
+	close(r.stopChan)
+	<-r.doneChan
+	r.flush()
+}
+
+// run flushes the counts until stopped
+func (r *Recorder) run() {
+	defer close(r.doneChan)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.stopChan:
+			return
+		}
+	}
+}
+
+// flush stores the counts, logging failures
+func (r *Recorder) flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	if err := r.Flush(ctx); err != nil {
+		r.logger.Error().Err(err).Msg("Failed to store report counts")
+	}
+}
+
+// Flush adds the counts recorded so far to storage, and removes the counts
+// of days past retention once a day. Counts that fail to store are kept
+// for the next flush.
+func (r *Recorder) Flush(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	today := r.now().Format(dateFormat)
+
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]*storage.ReportDay)
+	for key := range r.hosts {
+		if key[:len(dateFormat)] != today {
+			delete(r.hosts, key)
+		}
+	}
+	prune := r.pruned != today
+	r.pruned = today
+	r.mu.Unlock()
+
+	var firstErr error
+	for key, day := range pending {
+		if err := r.store.Add(ctx, *day); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			r.requeue(key, day)
+		}
+	}
+
+	if prune {
+		cutoff := r.now().Add(-retention).Format(dateFormat)
+		deleted, err := r.store.DeleteBefore(ctx, cutoff)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if deleted > 0 {
+			r.logger.Info().Int("count", deleted).Str("before", cutoff).Msg("Removed old report counts")
+		}
+	}
+	return firstErr
+}
+
+// requeue puts counts that failed to store back for the next flush
+func (r *Recorder) requeue(key string, day *storage.ReportDay) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if newer, ok := r.pending[key]; ok {
+		day.Merge(*newer)
+	}
+	r.pending[key] = day
+}
@@ -0,0 +1,86 @@
+package report
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestWeekly(t *testing.T) {
+	ctx := context.Background()
+	store := memory.Open().Reports()
+	r := New(store, zerolog.Nop())
+	now := time.Date(2026, 3, 2, 18, 0, 0, 0, time.UTC) // Monday
+	r.now = func() time.Time { return now }
+
+	// Two requests in one minute are one minute of use
+	r.Record("kids", "youtube.com", "video", "ALLOW", "")
+	r.Record("kids", "youtube.com", "video", "ALLOW", "")
+	now = now.Add(time.Minute)
+	r.Record("kids", "youtube.com", "video", "WARN", "")
+	r.Record("kids", "tiktok.com", "social", "BLOCK", "")
+	r.Record("", "example.com", "", "BLOCK", "") // Unknown device
+	if err := r.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// The next evening is outside allowed hours
+	now = now.Add(24 * time.Hour)
+	r.Record("kids", "youtube.com", "", "BLOCK", "time_restriction")
+	r.Record("kids", "minecraft.net", "gaming", "BLOCK", "usage_limit")
+
+	report, err := r.Weekly(ctx, "kids", now)
+	if err != nil {
+		t.Fatalf("Weekly failed: %v", err)
+	}
+	if report.Start != "2026-02-25" || report.End != "2026-03-03" || len(report.Days) != 7 {
+		t.Fatalf("Weekly() covers %s to %s in %d days, want the week to 2026-03-03", report.Start, report.End, len(report.Days))
+	}
+	if report.Requests != 3 || report.Blocked != 3 || report.Minutes != 2 {
+		t.Errorf("totals = %d allowed, %d blocked, %d minutes, want 3, 3 and 2", report.Requests, report.Blocked, report.Minutes)
+	}
+	if c := report.Categories[0]; c.Category != "video" || c.Minutes != 2 || c.Requests != 3 {
+		t.Errorf("Categories[0] = %+v, want video first with 2 minutes", c)
+	}
+	if len(report.TopSites) != 1 || report.TopSites[0] != (Count{Name: "youtube.com", Count: 3}) {
+		t.Errorf("TopSites = %+v, want youtube.com", report.TopSites)
+	}
+	if len(report.TopBlocked) != 3 || report.Days[6].OutsideHours != 1 || report.Days[6].Weekday != "Tuesday" {
+		t.Errorf("TopBlocked = %+v, days = %+v", report.TopBlocked, report.Days)
+	}
+	want := Compliance{DaysWithinHours: 6, OutsideHoursAttempts: 1, UsageLimitBlocks: 1}
+	if report.Compliance != want {
+		t.Errorf("Compliance = %+v, want %+v", report.Compliance, want)
+	}
+
+	page, err := report.HTML()
+	if err != nil {
+		t.Fatalf("HTML failed: %v", err)
+	}
+	for _, s := range []string{"Weekly report: kids", "youtube.com", "6 of 7"} {
+		if !strings.Contains(string(page), s) {
+			t.Errorf("HTML() is missing %q", s)
+		}
+	}
+
+	// A profile without traffic gets an empty report
+	report, err = r.Weekly(ctx, "teen", now)
+	if err != nil || !report.Empty {
+		t.Errorf("Weekly(teen) = %+v (%v), want an empty report", report, err)
+	}
+}
+
+func TestRecorderHostLimit(t *testing.T) {
+	r := New(memory.Open().Reports(), zerolog.Nop())
+	for i := 0; i < maxHosts+5; i++ {
+		r.Record("kids", strings.Repeat("a", i+1)+".com", "", "ALLOW", "")
+	}
+	day := r.pending[r.now().Format(dateFormat)+"/kids"]
+	if len(day.Sites) != maxHosts+1 || day.Sites[otherHosts] != 5 {
+		t.Errorf("%d hosts with %d other, want %d hosts and 5 other", len(day.Sites), day.Sites[otherHosts], maxHosts)
+	}
+}
@@ -0,0 +1,242 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"sort"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// topSites is how many hosts the report lists as top sites and top blocks
+const topSites = 10
+
+// Weekly is a profile's report for the seven days ending on End
+type Weekly struct {
+	Profile    string     `json:"profile"`
+	Start      string     `json:"start"` // YYYY-MM-DD
+	End        string     `json:"end"`
+	Generated  time.Time  `json:"generated"`
+	Days       []Day      `json:"days"`
+	Categories []Category `json:"categories"`  // Most minutes first
+	TopSites   []Count    `json:"top_sites"`   // Most allowed requests first
+	TopBlocked []Count    `json:"top_blocked"` // Most blocked requests first
+	BlockKinds []Count    `json:"block_kinds"` // Blocked requests per block page
+	Compliance Compliance `json:"compliance"`
+	Requests   int        `json:"requests"` // Allowed requests over the week
+	Blocked    int        `json:"blocked"`
+	Minutes    int        `json:"minutes"` // Minutes of use, summed over categories
+	Empty      bool       `json:"empty"`   // No traffic was counted
+}
+
+// Day is one day's totals
+type Day struct {
+	Date         string `json:"date"`
+	Weekday      string `json:"weekday"`
+	Requests     int    `json:"requests"`
+	Blocked      int    `json:"blocked"`
+	Minutes      int    `json:"minutes"`
+	OutsideHours int    `json:"outside_hours"` // Requests blocked for being outside allowed hours
+}
+
+// Category is the week's use of one category
+type Category struct {
+	Category string `json:"category"` // "uncategorized" for requests without one
+	Requests int    `json:"requests"`
+	Blocked  int    `json:"blocked"`
+	Minutes  int    `json:"minutes"`
+}
+
+// Count is the number of requests for a host or block page
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Compliance summarises how the profile's time rules were kept
+type Compliance struct {
+	DaysWithinHours      int `json:"days_within_hours"`      // Days without requests outside allowed hours
+	OutsideHoursAttempts int `json:"outside_hours_attempts"` // Requests blocked for being outside allowed hours
+	UsageLimitBlocks     int `json:"usage_limit_blocks"`     // Requests blocked for an exhausted usage limit
+	StreamLimitBlocks    int `json:"stream_limit_blocks"`    // Streams blocked for too many streaming devices
+}
+
+// Weekly builds the report of a profile for the seven days ending on the
+// day of end. Counts not yet stored are stored first, so the report is up
+// to date.
+func (r *Recorder) Weekly(ctx context.Context, profile string, end time.Time) (*Weekly, error) {
+	if err := r.Flush(ctx); err != nil {
+		r.logger.Warn().Err(err).Msg("Report may miss the last minute of counts")
+	}
+
+	end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, end.Location())
+	start := end.AddDate(0, 0, -6)
+
+	var days []storage.ReportDay
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format(dateFormat)
+		day, err := r.store.Get(ctx, date, profile)
+		if errors.Is(err, storage.ErrNotFound) {
+			day = &storage.ReportDay{Date: date, Profile: profile}
+		} else if err != nil {
+			return nil, err
+		}
+		days = append(days, *day)
+	}
+
+	report := summarize(profile, days)
+	report.Generated = r.now()
+	return report, nil
+}
+
+// summarize builds a report from a week of daily counts
+func summarize(profile string, days []storage.ReportDay) *Weekly {
+	report := &Weekly{
+		Profile: profile,
+		Start:   days[0].Date,
+		End:     days[len(days)-1].Date,
+	}
+
+	var week storage.ReportDay
+	for _, day := range days {
+		total := Day{Date: day.Date, OutsideHours: day.BlockKinds["time_restriction"]}
+		if date, err := time.Parse(dateFormat, day.Date); err == nil {
+			total.Weekday = date.Weekday().String()
+		}
+		for _, c := range day.Categories {
+			total.Requests += c.Requests
+			total.Blocked += c.Blocked
+			total.Minutes += c.Minutes
+		}
+		report.Days = append(report.Days, total)
+		report.Requests += total.Requests
+		report.Blocked += total.Blocked
+		report.Minutes += total.Minutes
+		if total.OutsideHours == 0 {
+			report.Compliance.DaysWithinHours++
+		}
+		week.Merge(day)
+	}
+	report.Empty = report.Requests == 0 && report.Blocked == 0
+
+	report.Categories = []Category{}
+	for name, c := range week.Categories {
+		if name == "" {
+			name = "uncategorized"
+		}
+		report.Categories = append(report.Categories, Category{Category: name, Requests: c.Requests, Blocked: c.Blocked, Minutes: c.Minutes})
+	}
+	sort.Slice(report.Categories, func(i, j int) bool {
+		a, b := report.Categories[i], report.Categories[j]
+		if a.Minutes != b.Minutes {
+			return a.Minutes > b.Minutes
+		}
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.Category < b.Category
+	})
+
+	report.TopSites = top(week.Sites, topSites)
+	report.TopBlocked = top(week.Blocked, topSites)
+	report.BlockKinds = top(week.BlockKinds, 0)
+	report.Compliance.OutsideHoursAttempts = week.BlockKinds["time_restriction"]
+	report.Compliance.UsageLimitBlocks = week.BlockKinds["usage_limit"]
+	report.Compliance.StreamLimitBlocks = week.BlockKinds["stream_limit"]
+	return report
+}
+
+// top returns the n largest counts, largest first (all of them for n 0)
+func top(counts map[string]int, n int) []Count {
+	list := make([]Count, 0, len(counts))
+	for name, count := range counts {
+		list = append(list, Count{Name: name, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// HTML renders the report as a standalone HTML page, suitable for an email
+// body or for printing to PDF from a browser
+func (w *Weekly) HTML() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := weeklyTemplate.Execute(&buf, w); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// weeklyTemplate renders Weekly. Styles are inline so mail clients keep them.
+var weeklyTemplate = template.Must(template.New("weekly").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>KProxy weekly report: {{.Profile}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; color: #222; max-width: 760px; margin: 24px auto; padding: 0 16px; }
+h1 { font-size: 22px; margin-bottom: 4px; }
+h2 { font-size: 17px; margin-top: 28px; border-bottom: 1px solid #ddd; padding-bottom: 4px; }
+table { border-collapse: collapse; width: 100%; font-size: 14px; }
+th, td { text-align: left; padding: 5px 8px; border-bottom: 1px solid #eee; }
+td.n, th.n { text-align: right; }
+.muted { color: #777; font-size: 13px; }
+.summary td { font-size: 16px; }
+</style>
+</head>
+<body>
+<h1>Weekly report: {{.Profile}}</h1>
+<p class="muted">{{.Start}} to {{.End}} &middot; generated {{.Generated.Format "2006-01-02 15:04"}}</p>
+{{if .Empty}}<p>No traffic was recorded for this profile during the week.</p>{{else}}
+<table class="summary">
+<tr><td>Allowed requests</td><td class="n">{{.Requests}}</td></tr>
+<tr><td>Blocked requests</td><td class="n">{{.Blocked}}</td></tr>
+<tr><td>Minutes of use</td><td class="n">{{.Minutes}}</td></tr>
+</table>
+
+<h2>Time rules</h2>
+<table>
+<tr><td>Days kept within allowed hours</td><td class="n">{{.Compliance.DaysWithinHours}} of {{len .Days}}</td></tr>
+<tr><td>Attempts outside allowed hours</td><td class="n">{{.Compliance.OutsideHoursAttempts}}</td></tr>
+<tr><td>Requests blocked by usage limits</td><td class="n">{{.Compliance.UsageLimitBlocks}}</td></tr>
+<tr><td>Streams blocked by stream limits</td><td class="n">{{.Compliance.StreamLimitBlocks}}</td></tr>
+</table>
+
+<h2>Daily activity</h2>
+<table>
+<tr><th>Day</th><th class="n">Allowed</th><th class="n">Blocked</th><th class="n">Minutes</th><th class="n">Outside hours</th></tr>
+{{range .Days}}<tr><td>{{.Weekday}} {{.Date}}</td><td class="n">{{.Requests}}</td><td class="n">{{.Blocked}}</td><td class="n">{{.Minutes}}</td><td class="n">{{.OutsideHours}}</td></tr>
+{{end}}</table>
+
+<h2>Usage by category</h2>
+<table>
+<tr><th>Category</th><th class="n">Minutes</th><th class="n">Allowed</th><th class="n">Blocked</th></tr>
+{{range .Categories}}<tr><td>{{.Category}}</td><td class="n">{{.Minutes}}</td><td class="n">{{.Requests}}</td><td class="n">{{.Blocked}}</td></tr>
+{{end}}</table>
+
+<h2>Top sites</h2>
+{{if .TopSites}}<table>
+<tr><th>Site</th><th class="n">Requests</th></tr>
+{{range .TopSites}}<tr><td>{{.Name}}</td><td class="n">{{.Count}}</td></tr>
+{{end}}</table>{{else}}<p class="muted">No allowed requests.</p>{{end}}
+
+<h2>Blocks</h2>
+{{if .TopBlocked}}<table>
+<tr><th>Site</th><th class="n">Blocked</th></tr>
+{{range .TopBlocked}}<tr><td>{{.Name}}</td><td class="n">{{.Count}}</td></tr>
+{{end}}</table>
+<p class="muted">By reason: {{range $i, $k := .BlockKinds}}{{if $i}}, {{end}}{{$k.Name}} {{$k.Count}}{{end}}</p>{{else}}<p class="muted">Nothing was blocked.</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,203 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+// deviceRulePrefix starts the generated IDs of device rules, keeping them
+// apart from the IDs of profile rules
+const deviceRulePrefix = "device-rule-"
+
+// DeviceSet holds the rules added for single devices through the admin API
+// (kproxy device rule). They take precedence over the rules of the device's
+// profile, so one device can get an exception without a profile of its own.
+// Unlike profile rules they are kept in storage and survive restarts; the
+// set caches them so policy evaluation doesn't touch storage.
+type DeviceSet struct {
+	store  storage.DeviceRuleStore
+	logger zerolog.Logger
+
+	mu    sync.Mutex
+	rules []storage.DeviceRule // Oldest first
+	next  int
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewDeviceSet creates an empty device rule set backed by store. Call Load
+// to read the stored rules.
+func NewDeviceSet(store storage.DeviceRuleStore, logger zerolog.Logger) *DeviceSet {
+	return &DeviceSet{
+		store:  store,
+		logger: logger.With().Str("component", "rules").Logger(),
+		next:   1,
+		now:    time.Now,
+	}
+}
+
+// Load reads the stored rules, deleting those that expired while KProxy
+// was stopped
+func (s *DeviceSet) Load(ctx context.Context) error {
+	stored, err := s.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules = s.rules[:0]
+	for _, rule := range stored {
+		if rule.Until != nil && !now.Before(*rule.Until) {
+			if err := s.store.Delete(ctx, rule.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+			continue
+		}
+		s.rules = append(s.rules, rule)
+		if n, err := strconv.Atoi(strings.TrimPrefix(rule.ID, deviceRulePrefix)); err == nil && n >= s.next {
+			s.next = n + 1
+		}
+	}
+	return nil
+}
+
+// Add adds a rule for device with a generated ID. A zero until keeps the
+// rule until it is removed.
+func (s *DeviceSet) Add(ctx context.Context, device, domain, action string, until time.Time) (storage.DeviceRule, error) {
+	device = strings.TrimSpace(device)
+	if device == "" {
+		return storage.DeviceRule{}, fmt.Errorf("device is required")
+	}
+	now := s.now()
+	domain, action, err := checkRule(domain, action, until, now)
+	if err != nil {
+		return storage.DeviceRule{}, err
+	}
+
+	rule := storage.DeviceRule{
+		Device:  device,
+		Domain:  domain,
+		Action:  action,
+		Created: now,
+	}
+	if !until.IsZero() {
+		rule.Until = &until
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule.ID = deviceRulePrefix + strconv.Itoa(s.next)
+	if err := s.store.Put(ctx, rule); err != nil {
+		return storage.DeviceRule{}, err
+	}
+	s.next++
+	s.rules = append(s.rules, rule)
+
+	event := s.logger.Info().
+		Str("id", rule.ID).
+		Str("device", rule.Device).
+		Str("domain", rule.Domain).
+		Str("action", rule.Action)
+	if rule.Until != nil {
+		event = event.Time("until", *rule.Until)
+	}
+	event.Msg("Device rule added")
+	return rule, nil
+}
+
+// Remove deletes a rule of device
+func (s *DeviceSet) Remove(ctx context.Context, device, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	for i, rule := range s.rules {
+		if rule.ID != id || rule.Device != device {
+			continue
+		}
+		if err := s.store.Delete(ctx, id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		s.rules = append(s.rules[:i], s.rules[i+1:]...)
+		s.logger.Info().Str("id", id).Str("device", device).Msg("Device rule removed")
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// Get returns a rule in effect
+func (s *DeviceSet) Get(id string) (storage.DeviceRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	for _, rule := range s.rules {
+		if rule.ID == id {
+			return rule, nil
+		}
+	}
+	return storage.DeviceRule{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// List returns the rules in effect for device, or for all devices when
+// device is empty, oldest first
+func (s *DeviceSet) List(device string) []storage.DeviceRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	rules := []storage.DeviceRule{}
+	for _, rule := range s.rules {
+		if device == "" || rule.Device == device {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// PolicyDeviceRules returns the rules in effect as input.device_rules for
+// OPA, newest first so that the latest rule for a domain wins
+func (s *DeviceSet) PolicyDeviceRules() []interface{} {
+	rules := s.List("")
+
+	facts := make([]interface{}, 0, len(rules))
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		facts = append(facts, map[string]interface{}{
+			"id":       rule.ID,
+			"device":   rule.Device,
+			"domains":  []interface{}{rule.Domain},
+			"action":   rule.Action,
+			"category": "",
+		})
+	}
+	return facts
+}
+
+// pruneLocked drops expired rules from the cache; Load deletes them from
+// storage. s.mu must be held.
+func (s *DeviceSet) pruneLocked() {
+	now := s.now()
+	kept := s.rules[:0]
+	for _, rule := range s.rules {
+		if rule.Until != nil && !now.Before(*rule.Until) {
+			s.logger.Info().Str("id", rule.ID).Str("device", rule.Device).Msg("Device rule expired")
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	s.rules = kept
+}
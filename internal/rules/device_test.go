@@ -0,0 +1,69 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestDeviceSet(t *testing.T) {
+	ctx := context.Background()
+	store := memory.Open().DeviceRules()
+	s := NewDeviceSet(store, zerolog.Nop())
+	now := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	first, err := s.Add(ctx, "tablet", ".Roblox.com", "Allow", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if first.ID != "device-rule-1" || first.Domain != ".roblox.com" || first.Action != ActionAllow || first.Until == nil {
+		t.Errorf("Add() = %+v", first)
+	}
+	if _, err := s.Add(ctx, "laptop", "tiktok.com", ActionBlock, time.Time{}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if _, err := s.Add(ctx, "", "tiktok.com", ActionBlock, time.Time{}); err == nil {
+		t.Error("Add() without a device succeeded")
+	}
+
+	if rules := s.List("tablet"); len(rules) != 1 || rules[0].ID != "device-rule-1" {
+		t.Errorf("List(tablet) = %+v, want device-rule-1", rules)
+	}
+	facts := s.PolicyDeviceRules()
+	if len(facts) != 2 || facts[0].(map[string]interface{})["device"] != "laptop" {
+		t.Errorf("PolicyDeviceRules() = %v, want the laptop rule first", facts)
+	}
+
+	// Rules survive a restart; expired ones are dropped from storage
+	now = now.Add(time.Hour)
+	restarted := NewDeviceSet(store, zerolog.Nop())
+	restarted.now = s.now
+	if err := restarted.Load(ctx); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if rules := restarted.List(""); len(rules) != 1 || rules[0].ID != "device-rule-2" {
+		t.Errorf("List() after Load = %+v, want device-rule-2", rules)
+	}
+	if stored, _ := store.List(ctx); len(stored) != 1 {
+		t.Errorf("stored rules after Load = %+v, want the expired rule deleted", stored)
+	}
+	next, err := restarted.Add(ctx, "tablet", "minecraft.net", ActionAllow, time.Time{})
+	if err != nil || next.ID != "device-rule-3" {
+		t.Errorf("Add() after Load = %+v (%v), want device-rule-3", next, err)
+	}
+
+	if err := restarted.Remove(ctx, "tablet", "device-rule-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Remove() of another device's rule = %v, want ErrNotFound", err)
+	}
+	if err := restarted.Remove(ctx, "laptop", "device-rule-2"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if stored, _ := store.List(ctx); len(stored) != 1 || stored[0].ID != "device-rule-3" {
+		t.Errorf("stored rules after Remove = %+v, want device-rule-3", stored)
+	}
+}
@@ -0,0 +1,266 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Rule actions, as in config.rego
+const (
+	ActionAllow  = "allow"
+	ActionBlock  = "block"
+	ActionBypass = "bypass"
+)
+
+// ErrNotFound is returned for a rule that doesn't exist (or has expired)
+var ErrNotFound = errors.New("rule not found")
+
+// idPattern matches rule IDs chosen by callers of Put
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// Rule is a rule added at runtime for one profile. It takes precedence over
+// the profile's rules in config.rego until it expires or is removed.
+type Rule struct {
+	ID      string     `json:"id"`
+	Profile string     `json:"profile"`
+	Domain  string     `json:"domain"` // Domain pattern, as in config.rego
+	Action  string     `json:"action"`
+	Until   *time.Time `json:"until,omitempty"` // nil = until removed or restart
+	Created time.Time  `json:"created"`
+}
+
+// Set holds the rules added at runtime through the admin API. Rules are
+// kept in memory only; permanent rules belong in config.rego.
+type Set struct {
+	logger zerolog.Logger
+
+	mu    sync.Mutex
+	rules []Rule
+	next  int
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates an empty rule set
+func New(logger zerolog.Logger) *Set {
+	return &Set{
+		logger: logger.With().Str("component", "rules").Logger(),
+		next:   1,
+		now:    time.Now,
+	}
+}
+
+// Add adds a rule for profile with a generated ID. A zero until keeps the
+// rule until it is removed or KProxy restarts.
+func (s *Set) Add(profile, domain, action string, until time.Time) (Rule, error) {
+	rule, err := s.newRule(profile, domain, action, until)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	s.mu.Lock()
+	for s.indexLocked("runtime-"+strconv.Itoa(s.next)) >= 0 {
+		// Taken by Put
+		s.next++
+	}
+	rule.ID = "runtime-" + strconv.Itoa(s.next)
+	s.next++
+	s.rules = append(s.rules, rule)
+	s.mu.Unlock()
+
+	s.logRule(rule, "Runtime rule added")
+	return rule, nil
+}
+
+// Put creates or replaces the rule with the given ID, so repeating the same
+// request leaves the same rule in place. A replaced rule keeps its creation
+// time and precedence. It reports whether the rule was created.
+func (s *Set) Put(id, profile, domain, action string, until time.Time) (Rule, bool, error) {
+	if err := checkID(id); err != nil {
+		return Rule{}, false, err
+	}
+	rule, err := s.newRule(profile, domain, action, until)
+	if err != nil {
+		return Rule{}, false, err
+	}
+	rule.ID = id
+
+	s.mu.Lock()
+	s.pruneLocked()
+	i := s.indexLocked(id)
+	if i >= 0 {
+		rule.Created = s.rules[i].Created
+		s.rules[i] = rule
+	} else {
+		s.rules = append(s.rules, rule)
+	}
+	s.mu.Unlock()
+
+	if i >= 0 {
+		s.logRule(rule, "Runtime rule updated")
+	} else {
+		s.logRule(rule, "Runtime rule added")
+	}
+	return rule, i < 0, nil
+}
+
+// Check reports whether Put would accept a rule, without changing anything
+func (s *Set) Check(id, profile, domain, action string, until time.Time) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	_, err := s.newRule(profile, domain, action, until)
+	return err
+}
+
+// Get returns a rule in effect
+func (s *Set) Get(id string) (Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	if i := s.indexLocked(id); i >= 0 {
+		return s.rules[i], nil
+	}
+	return Rule{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// Remove deletes a rule
+func (s *Set) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	if i := s.indexLocked(id); i >= 0 {
+		s.rules = append(s.rules[:i], s.rules[i+1:]...)
+		s.logger.Info().Str("id", id).Msg("Runtime rule removed")
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNotFound, id)
+}
+
+// List returns the rules in effect, oldest first
+func (s *Set) List() []Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	return append([]Rule{}, s.rules...)
+}
+
+// PolicyRules returns the rules in effect as input.runtime_rules for OPA,
+// newest first so that the latest rule for a domain wins
+func (s *Set) PolicyRules() []interface{} {
+	rules := s.List()
+
+	facts := make([]interface{}, 0, len(rules))
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		facts = append(facts, map[string]interface{}{
+			"id":       rule.ID,
+			"profile":  rule.Profile,
+			"domains":  []interface{}{rule.Domain},
+			"action":   rule.Action,
+			"category": "",
+		})
+	}
+	return facts
+}
+
+// newRule checks a rule's fields and returns it without an ID
+func (s *Set) newRule(profile, domain, action string, until time.Time) (Rule, error) {
+	profile = strings.TrimSpace(profile)
+	if profile == "" {
+		return Rule{}, fmt.Errorf("profile is required")
+	}
+
+	now := s.now()
+	domain, action, err := checkRule(domain, action, until, now)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	rule := Rule{
+		Profile: profile,
+		Domain:  domain,
+		Action:  action,
+		Created: now,
+	}
+	if !until.IsZero() {
+		rule.Until = &until
+	}
+	return rule, nil
+}
+
+// checkID checks a rule ID chosen by a caller of Put
+func checkID(id string) error {
+	if !idPattern.MatchString(id) {
+		return fmt.Errorf("invalid rule ID: %q (use lowercase letters, digits, ., - and _)", id)
+	}
+	return nil
+}
+
+// checkRule normalizes and checks the domain, action and expiry shared by
+// profile and device rules
+func checkRule(domain, action string, until, now time.Time) (string, string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	action = strings.ToLower(action)
+
+	if domain == "" || domain == "." || strings.ContainsAny(domain, " /") {
+		return "", "", fmt.Errorf("invalid domain: %q", domain)
+	}
+	switch action {
+	case ActionAllow, ActionBlock, ActionBypass:
+	default:
+		return "", "", fmt.Errorf("invalid action: %q (must be allow, block or bypass)", action)
+	}
+	if !until.IsZero() && !until.After(now) {
+		return "", "", fmt.Errorf("expiry %s is in the past", until.Format(time.RFC3339))
+	}
+	return domain, action, nil
+}
+
+// logRule logs a rule that was added or changed
+func (s *Set) logRule(rule Rule, msg string) {
+	event := s.logger.Info().
+		Str("id", rule.ID).
+		Str("profile", rule.Profile).
+		Str("domain", rule.Domain).
+		Str("action", rule.Action)
+	if rule.Until != nil {
+		event = event.Time("until", *rule.Until)
+	}
+	event.Msg(msg)
+}
+
+// indexLocked returns the position of a rule, or -1. s.mu must be held.
+func (s *Set) indexLocked(id string) int {
+	for i, rule := range s.rules {
+		if rule.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// pruneLocked drops expired rules. s.mu must be held.
+func (s *Set) pruneLocked() {
+	now := s.now()
+	kept := s.rules[:0]
+	for _, rule := range s.rules {
+		if rule.Until != nil && !now.Before(*rule.Until) {
+			s.logger.Info().Str("id", rule.ID).Str("domain", rule.Domain).Msg("Runtime rule expired")
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	s.rules = kept
+}
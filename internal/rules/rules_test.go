@@ -0,0 +1,147 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSet_AddListRemove(t *testing.T) {
+	s := New(zerolog.Nop())
+	now := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	first, err := s.Add("kids", ".Roblox.com", "Allow", now.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if first.ID != "runtime-1" || first.Domain != ".roblox.com" || first.Action != ActionAllow || first.Until == nil {
+		t.Errorf("Add() = %+v", first)
+	}
+
+	second, err := s.Add("kids", "tiktok.com", ActionBlock, time.Time{})
+	if err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if second.ID != "runtime-2" || second.Until != nil {
+		t.Errorf("Add() = %+v, want runtime-2 without expiry", second)
+	}
+
+	if rules := s.List(); len(rules) != 2 || rules[0].ID != "runtime-1" {
+		t.Errorf("List() = %+v, want both rules oldest first", rules)
+	}
+
+	// Newest first for policy evaluation
+	facts := s.PolicyRules()
+	if len(facts) != 2 || facts[0].(map[string]interface{})["id"] != "runtime-2" {
+		t.Errorf("PolicyRules() = %v, want runtime-2 first", facts)
+	}
+
+	if err := s.Remove("runtime-2"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := s.Remove("runtime-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Remove() of a removed rule = %v, want ErrNotFound", err)
+	}
+	if rules := s.List(); len(rules) != 1 {
+		t.Errorf("List() = %+v, want one rule", rules)
+	}
+}
+
+func TestSet_Expiry(t *testing.T) {
+	s := New(zerolog.Nop())
+	now := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	if _, err := s.Add("kids", ".roblox.com", ActionAllow, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	now = now.Add(59 * time.Minute)
+	if len(s.List()) != 1 {
+		t.Fatal("rule expired early")
+	}
+
+	now = now.Add(time.Minute)
+	if rules := s.List(); len(rules) != 0 {
+		t.Errorf("List() = %+v after expiry, want none", rules)
+	}
+	if facts := s.PolicyRules(); len(facts) != 0 {
+		t.Errorf("PolicyRules() = %v after expiry, want none", facts)
+	}
+}
+
+func TestSet_AddInvalid(t *testing.T) {
+	s := New(zerolog.Nop())
+	now := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	tests := []struct {
+		name    string
+		profile string
+		domain  string
+		action  string
+		until   time.Time
+	}{
+		{name: "no profile", domain: "example.com", action: ActionAllow},
+		{name: "no domain", profile: "kids", action: ActionAllow},
+		{name: "URL", profile: "kids", domain: "example.com/games", action: ActionAllow},
+		{name: "unknown action", profile: "kids", domain: "example.com", action: "intercept"},
+		{name: "expired", profile: "kids", domain: "example.com", action: ActionAllow, until: now.Add(-time.Minute)},
+	}
+
+	for _, tt := range tests {
+		if _, err := s.Add(tt.profile, tt.domain, tt.action, tt.until); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+	if rules := s.List(); len(rules) != 0 {
+		t.Errorf("List() = %+v, want none", rules)
+	}
+}
+
+func TestSet_Put(t *testing.T) {
+	s := New(zerolog.Nop())
+	now := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	rule, created, err := s.Put("kids-roblox", "kids", ".roblox.com", ActionAllow, time.Time{})
+	if err != nil || !created || rule.ID != "kids-roblox" {
+		t.Fatalf("Put() = %+v, %v, %v, want a created rule", rule, created, err)
+	}
+
+	// Repeating the request changes nothing
+	now = now.Add(time.Minute)
+	again, created, err := s.Put("kids-roblox", "kids", ".roblox.com", ActionAllow, time.Time{})
+	if err != nil || created || again != rule {
+		t.Errorf("Put() again = %+v, %v, %v, want the same rule", again, created, err)
+	}
+
+	updated, created, err := s.Put("kids-roblox", "kids", ".roblox.com", ActionBlock, time.Time{})
+	if err != nil || created || updated.Action != ActionBlock || !updated.Created.Equal(rule.Created) {
+		t.Errorf("Put() update = %+v, %v, %v, want the rule blocked", updated, created, err)
+	}
+	if got, err := s.Get("kids-roblox"); err != nil || got.Action != ActionBlock {
+		t.Errorf("Get() = %+v, %v, want the updated rule", got, err)
+	}
+	if len(s.List()) != 1 {
+		t.Errorf("List() = %+v, want one rule", s.List())
+	}
+
+	// Generated IDs skip IDs chosen by Put
+	if _, _, err := s.Put("runtime-1", "kids", "tiktok.com", ActionBlock, time.Time{}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if added, err := s.Add("kids", "youtube.com", ActionBlock, time.Time{}); err != nil || added.ID != "runtime-2" {
+		t.Errorf("Add() = %+v, %v, want runtime-2", added, err)
+	}
+
+	if _, _, err := s.Put("Bad ID", "kids", "tiktok.com", ActionBlock, time.Time{}); err == nil {
+		t.Error("Put() with an invalid ID succeeded")
+	}
+	if _, err := s.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing rule = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,257 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/rs/zerolog"
+)
+
+// ErrRuleSetNotFound is returned for a rule set that doesn't exist
+var ErrRuleSetNotFound = errors.New("rule set not found")
+
+// RuleSets holds the rule sets saved through the admin API (kproxy
+// ruleset): named collections of rules, such as "Social Media", that apply
+// to every profile they are attached to. A profile's own rules come first.
+// Rule sets in config.rego work the same way; a saved rule set replaces the
+// configured one with the same ID. The rule sets are kept in storage and
+// cached so policy evaluation doesn't touch it.
+type RuleSets struct {
+	store  storage.RuleSetStore
+	logger zerolog.Logger
+
+	mu   sync.Mutex
+	sets map[string]storage.RuleSet
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewRuleSets creates an empty rule set library backed by store. Call Load
+// to read the stored rule sets.
+func NewRuleSets(store storage.RuleSetStore, logger zerolog.Logger) *RuleSets {
+	return &RuleSets{
+		store:  store,
+		logger: logger.With().Str("component", "rules").Logger(),
+		sets:   make(map[string]storage.RuleSet),
+		now:    time.Now,
+	}
+}
+
+// Load reads the stored rule sets
+func (r *RuleSets) Load(ctx context.Context) error {
+	stored, err := r.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sets = make(map[string]storage.RuleSet, len(stored))
+	for _, set := range stored {
+		r.sets[set.ID] = set
+	}
+	return nil
+}
+
+// Put creates or replaces a rule set after checking its rules. The ID and
+// updated time are set from id and the clock. It reports whether the rule
+// set was created.
+func (r *RuleSets) Put(ctx context.Context, id string, set storage.RuleSet) (storage.RuleSet, bool, error) {
+	set, err := r.Check(id, set)
+	if err != nil {
+		return storage.RuleSet{}, false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, exists := r.sets[id]
+	set.UpdatedAt = r.now()
+	if err := r.store.Put(ctx, set); err != nil {
+		return storage.RuleSet{}, false, err
+	}
+	r.sets[id] = set
+
+	r.logger.Info().
+		Str("id", id).
+		Int("rules", len(set.Rules)).
+		Strs("profiles", set.Profiles).
+		Bool("created", !exists).
+		Msg("Rule set saved")
+	return set, !exists, nil
+}
+
+// Check checks a rule set as Put does and returns it as Put would save it,
+// without saving it
+func (r *RuleSets) Check(id string, set storage.RuleSet) (storage.RuleSet, error) {
+	if !idPattern.MatchString(id) {
+		return storage.RuleSet{}, fmt.Errorf("invalid rule set ID: %q (use lowercase letters, digits, ., - and _)", id)
+	}
+	set.ID = id
+	set.Name = strings.TrimSpace(set.Name)
+	if set.Name == "" {
+		set.Name = id
+	}
+	set.Profiles = cleanProfiles(set.Profiles)
+
+	seen := make(map[string]bool, len(set.Rules))
+	for i, rule := range set.Rules {
+		if !idPattern.MatchString(rule.ID) {
+			return storage.RuleSet{}, fmt.Errorf("invalid rule ID: %q (use lowercase letters, digits, ., - and _)", rule.ID)
+		}
+		if seen[rule.ID] {
+			return storage.RuleSet{}, fmt.Errorf("duplicate rule ID: %q", rule.ID)
+		}
+		seen[rule.ID] = true
+
+		if len(rule.Domains) == 0 {
+			return storage.RuleSet{}, fmt.Errorf("rule %s: at least one domain is required", rule.ID)
+		}
+		for j, domain := range rule.Domains {
+			domain, action, err := checkRule(domain, rule.Action, time.Time{}, time.Time{})
+			if err != nil {
+				return storage.RuleSet{}, fmt.Errorf("rule %s: %w", rule.ID, err)
+			}
+			set.Rules[i].Domains[j] = domain
+			set.Rules[i].Action = action
+		}
+		for _, path := range rule.Paths {
+			if path != "*" && !strings.HasPrefix(path, "/") {
+				return storage.RuleSet{}, fmt.Errorf("rule %s: invalid path: %q (must start with / or be *)", rule.ID, path)
+			}
+		}
+	}
+	if set.Rules == nil {
+		set.Rules = []storage.RuleSetRule{}
+	}
+	return set, nil
+}
+
+// SetProfiles replaces the profiles a rule set is attached to
+func (r *RuleSets) SetProfiles(ctx context.Context, id string, profiles []string) (storage.RuleSet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	set, ok := r.sets[id]
+	if !ok {
+		return storage.RuleSet{}, fmt.Errorf("%w: %s", ErrRuleSetNotFound, id)
+	}
+	set.Profiles = cleanProfiles(profiles)
+	set.UpdatedAt = r.now()
+	if err := r.store.Put(ctx, set); err != nil {
+		return storage.RuleSet{}, err
+	}
+	r.sets[id] = set
+
+	r.logger.Info().Str("id", id).Strs("profiles", set.Profiles).Msg("Rule set profiles changed")
+	return set, nil
+}
+
+// Delete removes a rule set. Configured rule sets with the same ID apply
+// again.
+func (r *RuleSets) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sets[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrRuleSetNotFound, id)
+	}
+	if err := r.store.Delete(ctx, id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	delete(r.sets, id)
+
+	r.logger.Info().Str("id", id).Msg("Rule set deleted")
+	return nil
+}
+
+// Get returns a rule set
+func (r *RuleSets) Get(id string) (storage.RuleSet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	set, ok := r.sets[id]
+	if !ok {
+		return storage.RuleSet{}, fmt.Errorf("%w: %s", ErrRuleSetNotFound, id)
+	}
+	return set, nil
+}
+
+// List returns the rule sets sorted by ID
+func (r *RuleSets) List() []storage.RuleSet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sets := make([]storage.RuleSet, 0, len(r.sets))
+	for _, set := range r.sets {
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].ID < sets[j].ID })
+	return sets
+}
+
+// PolicyRuleSets returns the rule sets as input.rule_sets for OPA, keyed
+// by ID in the shape of config.rego's rule_sets
+func (r *RuleSets) PolicyRuleSets() map[string]interface{} {
+	sets := r.List()
+
+	facts := make(map[string]interface{}, len(sets))
+	for _, set := range sets {
+		profiles := make([]interface{}, 0, len(set.Profiles))
+		for _, profile := range set.Profiles {
+			profiles = append(profiles, profile)
+		}
+		rules := make([]interface{}, 0, len(set.Rules))
+		for _, rule := range set.Rules {
+			fact := map[string]interface{}{
+				"id":       rule.ID,
+				"domains":  stringFacts(rule.Domains),
+				"action":   rule.Action,
+				"category": rule.Category,
+			}
+			if len(rule.Paths) > 0 {
+				fact["paths"] = stringFacts(rule.Paths)
+			}
+			rules = append(rules, fact)
+		}
+		facts[set.ID] = map[string]interface{}{
+			"name":     set.Name,
+			"profiles": profiles,
+			"rules":    rules,
+		}
+	}
+	return facts
+}
+
+// cleanProfiles trims, deduplicates and sorts profile IDs
+func cleanProfiles(profiles []string) []string {
+	seen := make(map[string]bool, len(profiles))
+	cleaned := []string{}
+	for _, profile := range profiles {
+		profile = strings.TrimSpace(profile)
+		if profile == "" || seen[profile] {
+			continue
+		}
+		seen[profile] = true
+		cleaned = append(cleaned, profile)
+	}
+	sort.Strings(cleaned)
+	return cleaned
+}
+
+// stringFacts converts strings for OPA input
+func stringFacts(values []string) []interface{} {
+	facts := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		facts = append(facts, value)
+	}
+	return facts
+}
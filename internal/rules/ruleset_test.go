@@ -0,0 +1,83 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+func TestRuleSets(t *testing.T) {
+	ctx := context.Background()
+	store := memory.Open().RuleSets()
+	r := NewRuleSets(store, zerolog.Nop())
+
+	set, created, err := r.Put(ctx, "social-media", storage.RuleSet{
+		Name:     "Social Media",
+		Profiles: []string{"teen", "child", "child"},
+		Rules: []storage.RuleSetRule{
+			{ID: "block-tiktok", Domains: []string{".TikTok.com"}, Action: "Block", Category: "social"},
+			{ID: "block-reels", Domains: []string{"instagram.com"}, Paths: []string{"/reels/*"}, Action: ActionBlock},
+		},
+	})
+	if err != nil || !created {
+		t.Fatalf("Put() = %v (%v), want a created rule set", created, err)
+	}
+	if set.Rules[0].Domains[0] != ".tiktok.com" || set.Rules[0].Action != ActionBlock || len(set.Profiles) != 2 || set.Profiles[0] != "child" {
+		t.Errorf("Put() = %+v, want normalized rules and profiles", set)
+	}
+
+	for _, bad := range []storage.RuleSet{
+		{Rules: []storage.RuleSetRule{{ID: "Bad ID", Domains: []string{"a.com"}, Action: ActionBlock}}},
+		{Rules: []storage.RuleSetRule{{ID: "a", Domains: []string{"a.com"}, Action: ActionBlock}, {ID: "a", Domains: []string{"b.com"}, Action: ActionBlock}}},
+		{Rules: []storage.RuleSetRule{{ID: "a", Action: ActionBlock}}},
+		{Rules: []storage.RuleSetRule{{ID: "a", Domains: []string{"a.com"}, Action: "deny"}}},
+		{Rules: []storage.RuleSetRule{{ID: "a", Domains: []string{"a.com"}, Paths: []string{"reels"}, Action: ActionBlock}}},
+	} {
+		if _, _, err := r.Put(ctx, "bad", bad); err == nil {
+			t.Errorf("Put(%+v) succeeded", bad)
+		}
+	}
+	if _, _, err := r.Put(ctx, "Bad Set", storage.RuleSet{}); err == nil {
+		t.Error("Put() with an invalid ID succeeded")
+	}
+
+	// Bulk assignment replaces the profiles
+	if set, err := r.SetProfiles(ctx, "social-media", []string{"adult", "child"}); err != nil || len(set.Profiles) != 2 || set.Profiles[0] != "adult" {
+		t.Errorf("SetProfiles() = %+v (%v), want adult and child", set, err)
+	}
+	if _, err := r.SetProfiles(ctx, "gaming", nil); !errors.Is(err, ErrRuleSetNotFound) {
+		t.Errorf("SetProfiles() of a missing rule set = %v, want ErrRuleSetNotFound", err)
+	}
+
+	facts := r.PolicyRuleSets()
+	social, ok := facts["social-media"].(map[string]interface{})
+	if !ok || social["name"] != "Social Media" || len(social["rules"].([]interface{})) != 2 {
+		t.Fatalf("PolicyRuleSets() = %v, want social-media with two rules", facts)
+	}
+	if _, ok := social["rules"].([]interface{})[0].(map[string]interface{})["paths"]; ok {
+		t.Error("PolicyRuleSets() has paths for a rule without them")
+	}
+
+	// Rule sets survive a restart
+	restarted := NewRuleSets(store, zerolog.Nop())
+	if err := restarted.Load(ctx); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if set, err := restarted.Get("social-media"); err != nil || set.Profiles[0] != "adult" {
+		t.Errorf("Get() after Load = %+v (%v), want the stored rule set", set, err)
+	}
+
+	if err := restarted.Delete(ctx, "social-media"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := restarted.Delete(ctx, "social-media"); !errors.Is(err, ErrRuleSetNotFound) {
+		t.Errorf("Delete() again = %v, want ErrRuleSetNotFound", err)
+	}
+	if stored, _ := store.List(ctx); len(stored) != 0 {
+		t.Errorf("stored rule sets after Delete = %+v, want none", stored)
+	}
+}
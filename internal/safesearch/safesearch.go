@@ -0,0 +1,185 @@
+package safesearch
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Hosts that serve each engine with SafeSearch locked on
+const (
+	googleTarget     = "forcesafesearch.google.com"
+	bingTarget       = "strict.bing.com"
+	duckDuckGoTarget = "safe.duckduckgo.com"
+	youTubeTarget    = "restrict.youtube.com"
+)
+
+// YouTube Restricted Mode levels
+const (
+	YouTubeModerate = "moderate"
+	YouTubeStrict   = "strict"
+)
+
+// targets maps search engine hosts to their SafeSearch hosts. Google's
+// country domains are matched separately.
+var targets = map[string]string{
+	"bing.com":                 bingTarget,
+	"www.bing.com":             bingTarget,
+	"duckduckgo.com":           duckDuckGoTarget,
+	"www.duckduckgo.com":       duckDuckGoTarget,
+	"start.duckduckgo.com":     duckDuckGoTarget,
+	"html.duckduckgo.com":      duckDuckGoTarget,
+	"youtube.com":              youTubeTarget,
+	"www.youtube.com":          youTubeTarget,
+	"m.youtube.com":            youTubeTarget,
+	"youtubei.googleapis.com":  youTubeTarget,
+	"youtube.googleapis.com":   youTubeTarget,
+	"www.youtube-nocookie.com": youTubeTarget,
+}
+
+// Target returns the SafeSearch host that DNS answers for host should point
+// to, or "" if host isn't a search engine host
+func Target(host string) string {
+	host = normalize(host)
+	if target, ok := targets[host]; ok {
+		return target
+	}
+	if isGoogle(host) {
+		return googleTarget
+	}
+	return ""
+}
+
+// Enforce rewrites a proxied request so the search engine applies strict
+// filtering: Google, Bing and DuckDuckGo searches (requests with a "q"
+// parameter) get the engine's SafeSearch parameter, overriding the client's,
+// DuckDuckGo also gets its strict cookie, and YouTube gets the
+// YouTube-Restrict header. It reports whether the request was changed.
+func Enforce(r *http.Request) bool {
+	search := r.URL.Query().Has("q")
+
+	switch Target(requestHost(r)) {
+	case googleTarget:
+		return search && setQuery(r, "safe", "active")
+	case bingTarget:
+		return search && setQuery(r, "adlt", "strict")
+	case duckDuckGoTarget:
+		setCookie(r, "p", "1")
+		if search {
+			setQuery(r, "kp", "1")
+		}
+		return true
+	case youTubeTarget:
+		return RestrictYouTube(r, YouTubeStrict)
+	}
+	return false
+}
+
+// RestrictYouTube sends a YouTube request with the YouTube-Restrict header
+// for level (YouTubeModerate or YouTubeStrict), replacing any the client
+// sent. It reports whether the request was changed; requests to other hosts
+// and unknown levels are left alone.
+func RestrictYouTube(r *http.Request, level string) bool {
+	var value string
+	switch strings.ToLower(level) {
+	case YouTubeModerate:
+		value = "Moderate"
+	case YouTubeStrict:
+		value = "Strict"
+	default:
+		return false
+	}
+
+	if Target(requestHost(r)) != youTubeTarget {
+		return false
+	}
+	r.Header.Set("YouTube-Restrict", value)
+	return true
+}
+
+// SearchQuery returns the engine ("google", "bing" or "duckduckgo") and
+// the terms of a search results request, or empty strings if r isn't one.
+// Autocomplete and other requests that happen to carry a "q" parameter are
+// not searches.
+func SearchQuery(r *http.Request) (engine, query string) {
+	query = strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		return "", ""
+	}
+
+	path := r.URL.Path
+	switch Target(requestHost(r)) {
+	case googleTarget:
+		if path == "/search" {
+			return "google", query
+		}
+	case bingTarget:
+		if path == "/search" {
+			return "bing", query
+		}
+	case duckDuckGoTarget:
+		if path == "" || path == "/" || path == "/html" || path == "/html/" || path == "/lite" || path == "/lite/" {
+			return "duckduckgo", query
+		}
+	}
+	return "", ""
+}
+
+// isGoogle matches google.com and Google's country domains (google.de,
+// www.google.co.uk, google.com.au)
+func isGoogle(host string) bool {
+	host = strings.TrimPrefix(host, "www.")
+	rest, ok := strings.CutPrefix(host, "google.")
+	if !ok || rest == "" {
+		return false
+	}
+	labels := strings.Split(rest, ".")
+	if len(labels) > 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 3 {
+			return false
+		}
+	}
+	return true
+}
+
+// setQuery sets a query parameter on the request URL, overriding the client's
+func setQuery(r *http.Request, key, value string) bool {
+	q := r.URL.Query()
+	if q.Get(key) == value && len(q[key]) == 1 {
+		return false
+	}
+	q.Set(key, value)
+	r.URL.RawQuery = q.Encode()
+	return true
+}
+
+// setCookie replaces a request cookie
+func setCookie(r *http.Request, name, value string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != name {
+			r.AddCookie(c)
+		}
+	}
+	r.AddCookie(&http.Cookie{Name: name, Value: value})
+}
+
+// requestHost returns the normalized host a request is for
+func requestHost(r *http.Request) string {
+	if host := normalize(r.URL.Host); host != "" {
+		return host
+	}
+	return normalize(r.Host)
+}
+
+// normalize lowercases a host and strips the port and trailing dot
+func normalize(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,139 @@
+package safesearch
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"www.google.com", "forcesafesearch.google.com"},
+		{"google.com", "forcesafesearch.google.com"},
+		{"www.google.co.uk", "forcesafesearch.google.com"},
+		{"Google.DE.", "forcesafesearch.google.com"},
+		{"www.google.com:443", "forcesafesearch.google.com"},
+		{"mail.google.com", ""},
+		{"google.example.attacker.net", ""},
+		{"www.bing.com", "strict.bing.com"},
+		{"duckduckgo.com", "safe.duckduckgo.com"},
+		{"m.youtube.com", "restrict.youtube.com"},
+		{"youtubei.googleapis.com", "restrict.youtube.com"},
+		{"example.com", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Target(tt.host); got != tt.want {
+			t.Errorf("Target(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		cookie      string
+		wantChanged bool
+		wantQuery   string
+		wantHeader  string
+		wantCookie  string
+	}{
+		{name: "google search", url: "https://www.google.com/search?q=cats&safe=off", wantChanged: true, wantQuery: "q=cats&safe=active"},
+		{name: "google static", url: "https://www.google.com/images/logo.png", wantQuery: ""},
+		{name: "bing search", url: "https://www.bing.com/search?q=cats", wantChanged: true, wantQuery: "adlt=strict&q=cats"},
+		{name: "duckduckgo search", url: "https://duckduckgo.com/?q=cats&kp=-2", cookie: "p=-2; ax=v1", wantChanged: true, wantQuery: "kp=1&q=cats", wantCookie: "ax=v1; p=1"},
+		{name: "youtube", url: "https://www.youtube.com/watch?v=abc", wantChanged: true, wantQuery: "v=abc", wantHeader: "Strict"},
+		{name: "other site", url: "https://example.com/?q=cats", wantQuery: "q=cats"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.cookie != "" {
+				r.Header.Set("Cookie", tt.cookie)
+			}
+
+			if got := Enforce(r); got != tt.wantChanged {
+				t.Errorf("Enforce() = %v, want %v", got, tt.wantChanged)
+			}
+			if r.URL.RawQuery != tt.wantQuery {
+				t.Errorf("query = %q, want %q", r.URL.RawQuery, tt.wantQuery)
+			}
+			if got := r.Header.Get("YouTube-Restrict"); got != tt.wantHeader {
+				t.Errorf("YouTube-Restrict = %q, want %q", got, tt.wantHeader)
+			}
+			if tt.wantCookie != "" && r.Header.Get("Cookie") != tt.wantCookie {
+				t.Errorf("Cookie = %q, want %q", r.Header.Get("Cookie"), tt.wantCookie)
+			}
+		})
+	}
+}
+
+func TestRestrictYouTube(t *testing.T) {
+	tests := []struct {
+		url         string
+		level       string
+		wantChanged bool
+		wantHeader  string
+	}{
+		{url: "https://www.youtube.com/watch?v=abc", level: YouTubeModerate, wantChanged: true, wantHeader: "Moderate"},
+		{url: "https://m.youtube.com/", level: "Strict", wantChanged: true, wantHeader: "Strict"},
+		{url: "https://youtubei.googleapis.com/youtubei/v1/search", level: YouTubeStrict, wantChanged: true, wantHeader: "Strict"},
+		{url: "https://www.youtube.com/", level: "loose", wantHeader: "Off"},
+		{url: "https://www.google.com/", level: YouTubeStrict},
+	}
+
+	for _, tt := range tests {
+		r, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Target(r.URL.Host) == youTubeTarget {
+			// The client's own setting is replaced
+			r.Header.Set("YouTube-Restrict", "Off")
+		}
+
+		if got := RestrictYouTube(r, tt.level); got != tt.wantChanged {
+			t.Errorf("RestrictYouTube(%s, %q) = %v, want %v", tt.url, tt.level, got, tt.wantChanged)
+		}
+		if got := r.Header.Get("YouTube-Restrict"); got != tt.wantHeader {
+			t.Errorf("RestrictYouTube(%s, %q) header = %q, want %q", tt.url, tt.level, got, tt.wantHeader)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	tests := []struct {
+		url        string
+		wantEngine string
+		wantQuery  string
+	}{
+		{"https://www.google.com/search?q=how+to+draw+cats", "google", "how to draw cats"},
+		{"https://www.google.co.uk/search?q=%20cats%20&hl=en", "google", "cats"},
+		{"https://www.google.com/complete/search?q=ca", "", ""},
+		{"https://www.google.com/search?tbm=isch", "", ""},
+		{"https://www.bing.com/search?q=cats&form=QBLH", "bing", "cats"},
+		{"https://duckduckgo.com/?q=cats", "duckduckgo", "cats"},
+		{"https://html.duckduckgo.com/html/?q=cats", "duckduckgo", "cats"},
+		{"https://duckduckgo.com/ac/?q=ca", "", ""},
+		{"https://www.youtube.com/results?q=cats", "", ""},
+		{"https://example.com/search?q=cats", "", ""},
+	}
+
+	for _, tt := range tests {
+		r, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engine, query := SearchQuery(r)
+		if engine != tt.wantEngine || query != tt.wantQuery {
+			t.Errorf("SearchQuery(%s) = %q, %q, want %q, %q", tt.url, engine, query, tt.wantEngine, tt.wantQuery)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package script
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/pm"
+)
+
+// Locals that compiled scripts call to charge what they allocate. They
+// aren't valid identifiers, so scripts can't refer to them by name, and
+// they are bound when the chunk starts, so scripts can't replace them.
+const (
+	concatFunc = "kproxy concat" // ".." with the result charged
+	allocFunc  = "kproxy alloc"  // Charges a number of bytes
+	spreadFunc = "kproxy spread" // Returns its arguments, charging a slot for each
+)
+
+// Rough sizes of what the VM allocates: a table slot, an empty table and a closure
+const (
+	slotCost    = 32
+	tableCost   = 128
+	closureCost = 128
+)
+
+// errMemoryLimit stops a hook that allocates more than Config.MaxMemory
+var errMemoryLimit = errors.New("memory limit exceeded")
+
+// memoryBudget limits what one Lua state allocates. gopher-lua can't count
+// its allocations, so the budget charges what scripts build instead:
+// builtins that return strings or grow tables charge the bytes they
+// build, checking those that can build far more than their arguments
+// before they do, and compileFile rewrites scripts so that "..", table
+// stores, table constructors and closures are charged too. Everything
+// built is charged, even if it is garbage by the time the limit is hit.
+// A state runs on one goroutine, so the budget needs no lock.
+type memoryBudget struct {
+	limit uint64
+	used  uint64
+}
+
+// newMemoryBudget starts a budget of limit bytes
+func newMemoryBudget(limit int) *memoryBudget {
+	return &memoryBudget{limit: uint64(limit)}
+}
+
+// reserve charges n bytes, raising a Lua error if they don't fit in the budget
+func (b *memoryBudget) reserve(L *lua.LState, n uint64) {
+	if n > b.limit-b.used {
+		L.RaiseError("%s", errMemoryLimit)
+	}
+	b.used += n
+}
+
+// chunkArgs returns the functions compiled scripts expect as chunk
+// arguments, bound to concatFunc, allocFunc and spreadFunc in that order
+func (b *memoryBudget) chunkArgs(L *lua.LState) []lua.LValue {
+	concat := L.NewFunction(func(L *lua.LState) int {
+		lhs, rhs := L.Get(1), L.Get(2)
+		if lua.LVCanConvToString(lhs) && lua.LVCanConvToString(rhs) {
+			l, r := lua.LVAsString(lhs), lua.LVAsString(rhs)
+			b.reserve(L, uint64(len(l)+len(r)))
+			L.Push(lua.LString(l + r))
+			return 1
+		}
+
+		// Values with a __concat metamethod
+		op := L.GetMetaField(lhs, "__concat")
+		if op == lua.LNil {
+			op = L.GetMetaField(rhs, "__concat")
+		}
+		if op == lua.LNil {
+			bad := lhs
+			if lua.LVCanConvToString(lhs) {
+				bad = rhs
+			}
+			L.RaiseError("attempt to concatenate a %s value", bad.Type())
+		}
+		L.Push(op)
+		L.Push(lhs)
+		L.Push(rhs)
+		L.Call(2, 1)
+		return 1
+	})
+
+	alloc := L.NewFunction(func(L *lua.LState) int {
+		b.reserve(L, uint64(L.CheckInt64(1)))
+		return 0
+	})
+
+	spread := L.NewFunction(func(L *lua.LState) int {
+		b.reserve(L, mulSize(slotCost, uint64(L.GetTop())))
+		return L.GetTop()
+	})
+
+	return []lua.LValue{concat, alloc, spread}
+}
+
+// limitBuiltins replaces the string and table functions, and rawset, with
+// versions that charge what they build. Those that can build a string far
+// larger than their arguments reserve its size before building it; the
+// rest are charged for their results.
+func (b *memoryBudget) limitBuiltins(L *lua.LState) {
+	strlib := L.GetGlobal(lua.StringLibName).(*lua.LTable)
+	tablib := L.GetGlobal(lua.TabLibName).(*lua.LTable)
+
+	// Charged after the call: none of the rest returns much more than its
+	// arguments
+	strlib.ForEach(func(name, fn lua.LValue) {
+		switch name.String() {
+		case "rep", "format", "gsub", "gmatch":
+			return
+		}
+		if f, ok := fn.(*lua.LFunction); ok && f.IsG {
+			strlib.RawSet(name, L.NewFunction(b.charged(f.GFunction)))
+		}
+	})
+	gmatch := strlib.RawGetString("gmatch").(*lua.LFunction).GFunction
+	strlib.RawSetString("gmatch", L.NewFunction(func(L *lua.LState) int {
+		n := gmatch(L)
+		if iter, ok := L.Get(-1).(*lua.LFunction); ok && iter.IsG {
+			L.Replace(-1, L.NewFunction(b.charged(iter.GFunction)))
+		}
+		return n
+	}))
+
+	rep := strlib.RawGetString("rep").(*lua.LFunction).GFunction
+	strlib.RawSetString("rep", L.NewFunction(func(L *lua.LState) int {
+		str := L.CheckString(1)
+		if n := L.CheckInt(2); n > 0 {
+			b.reserve(L, mulSize(uint64(len(str)), uint64(n)))
+		}
+		return rep(L)
+	}))
+
+	format := strlib.RawGetString("format").(*lua.LFunction).GFunction
+	strlib.RawSetString("format", L.NewFunction(func(L *lua.LState) int {
+		f := L.CheckString(1)
+		if !formatWidthsValid(f) {
+			L.RaiseError("invalid format (width or precision too long)")
+		}
+		size := uint64(len(f))
+		for i := 2; i <= L.GetTop(); i++ {
+			size += uint64(len(L.Get(i).String())) + 99
+		}
+		b.reserve(L, size)
+		return format(L)
+	}))
+
+	gsub := strlib.RawGetString("gsub").(*lua.LFunction).GFunction
+	strlib.RawSetString("gsub", L.NewFunction(func(L *lua.LState) int {
+		str := L.CheckString(1)
+		pat := L.CheckString(2)
+		mds, err := pm.Find(pat, []byte(str), 0, L.OptInt(4, -1))
+		if err != nil {
+			L.RaiseError("%s", err.Error())
+		}
+		// The result is rebuilt once per match, each time with the
+		// replacements made so far
+		matches := uint64(len(mds))
+		cost := func(replaced uint64) uint64 {
+			return mulSize(2*matches, uint64(len(str))+replaced)
+		}
+
+		switch repl := L.Get(3).(type) {
+		case lua.LString:
+			// Captures (%0 to %9) repeat up to the whole string
+			per := uint64(len(repl))
+			for i := 0; i+1 < len(repl); i++ {
+				if repl[i] == '%' {
+					if repl[i+1] >= '0' && repl[i+1] <= '9' {
+						per += uint64(len(str))
+					}
+					i++
+				}
+			}
+			b.reserve(L, cost(mulSize(matches, per)))
+		case *lua.LTable, *lua.LFunction:
+			// Replacements are only known as they are made, so the
+			// cost is charged as it grows
+			charged := cost(0)
+			b.reserve(L, charged)
+			var replaced uint64
+			L.Replace(3, L.NewFunction(func(L *lua.LState) int {
+				var v lua.LValue
+				if t, ok := repl.(*lua.LTable); ok {
+					v = L.GetTable(t, L.Get(1))
+				} else {
+					nargs := L.GetTop()
+					L.Push(repl)
+					for i := 1; i <= nargs; i++ {
+						L.Push(L.Get(i))
+					}
+					L.Call(nargs, 1)
+					v = L.Get(-1)
+				}
+				if s, ok := v.(lua.LString); ok {
+					replaced += uint64(len(s))
+					next := cost(replaced)
+					b.reserve(L, next-charged)
+					charged = next
+				}
+				L.Push(v)
+				return 1
+			}))
+		}
+		return gsub(L)
+	}))
+
+	concat := tablib.RawGetString("concat").(*lua.LFunction).GFunction
+	tablib.RawSetString("concat", L.NewFunction(func(L *lua.LState) int {
+		t := L.CheckTable(1)
+		sep := uint64(len(L.OptString(2, "")))
+		var size uint64
+		t.ForEach(func(_, v lua.LValue) {
+			if s, ok := v.(lua.LString); ok {
+				size += uint64(len(s)) + sep
+			}
+		})
+		b.reserve(L, size)
+		return concat(L)
+	}))
+
+	// Builtins that store into a table
+	for _, store := range []struct {
+		lib  *lua.LTable
+		name string
+	}{
+		{tablib, "insert"},
+		{L.G.Global, "rawset"},
+	} {
+		fn := store.lib.RawGetString(store.name).(*lua.LFunction).GFunction
+		store.lib.RawSetString(store.name, L.NewFunction(func(L *lua.LState) int {
+			b.reserve(L, slotCost)
+			return fn(L)
+		}))
+	}
+}
+
+// charged wraps fn to charge the strings it returns
+func (b *memoryBudget) charged(fn lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		n := fn(L)
+		var size uint64
+		for i := L.GetTop() - n + 1; i <= L.GetTop(); i++ {
+			if s, ok := L.Get(i).(lua.LString); ok {
+				size += uint64(len(s))
+			}
+		}
+		b.reserve(L, size)
+		return n
+	}
+}
+
+// limitChunk rewrites a compiled script's chunk so that what it allocates
+// is charged to the budget, since the VM's own allocations can't be
+// limited: each ".." becomes a call of concatFunc, a call of allocFunc
+// before each statement charges the table slots, tables and closures it
+// creates, and calls that fill the end of a table constructor pass
+// through spreadFunc. The chunk binds the three from its arguments first.
+func limitChunk(chunk []ast.Stmt) []ast.Stmt {
+	bind := &ast.LocalAssignStmt{
+		Names: []string{concatFunc, allocFunc, spreadFunc},
+		Exprs: []ast.Expr{&ast.Comma3Expr{}},
+	}
+	return append([]ast.Stmt{bind}, limitStmts(chunk, scope{})...)
+}
+
+// scope holds the names of the locals visible in a block, whose
+// assignments don't store into a table
+type scope map[string]bool
+
+// with returns a copy of s with names added
+func (s scope) with(names ...string) scope {
+	c := make(scope, len(s)+len(names))
+	for name := range s {
+		c[name] = true
+	}
+	for _, name := range names {
+		c[name] = true
+	}
+	return c
+}
+
+// limitStmts rewrites a block for limitChunk
+func limitStmts(stmts []ast.Stmt, sc scope) []ast.Stmt {
+	sc = sc.with()
+	out := make([]ast.Stmt, 0, len(stmts))
+	for _, stmt := range stmts {
+		var cost uint64
+		switch st := stmt.(type) {
+		case *ast.AssignStmt:
+			for i, lhs := range st.Lhs {
+				if ident, ok := lhs.(*ast.IdentExpr); !ok || !sc[ident.Value] {
+					cost += slotCost
+				}
+				st.Lhs[i] = limitExpr(lhs, sc, &cost)
+			}
+			limitExprs(st.Rhs, sc, &cost)
+		case *ast.LocalAssignStmt:
+			limitExprs(st.Exprs, sc, &cost)
+			for _, name := range st.Names {
+				sc[name] = true
+			}
+		case *ast.FuncCallStmt:
+			st.Expr = limitExpr(st.Expr, sc, &cost)
+		case *ast.DoBlockStmt:
+			st.Stmts = limitStmts(st.Stmts, sc)
+		case *ast.WhileStmt:
+			st.Condition = limitExpr(st.Condition, sc, &cost)
+			st.Stmts = prependAlloc(limitStmts(st.Stmts, sc), cost, st)
+		case *ast.RepeatStmt:
+			var each uint64
+			st.Condition = limitExpr(st.Condition, sc, &each)
+			st.Stmts = prependAlloc(limitStmts(st.Stmts, sc), each, st)
+		case *ast.IfStmt:
+			st.Condition = limitExpr(st.Condition, sc, &cost)
+			st.Then = limitStmts(st.Then, sc)
+			st.Else = limitStmts(st.Else, sc)
+		case *ast.NumberForStmt:
+			st.Init = limitExpr(st.Init, sc, &cost)
+			st.Limit = limitExpr(st.Limit, sc, &cost)
+			st.Step = limitExpr(st.Step, sc, &cost)
+			st.Stmts = limitStmts(st.Stmts, sc.with(st.Name))
+		case *ast.GenericForStmt:
+			limitExprs(st.Exprs, sc, &cost)
+			st.Stmts = limitStmts(st.Stmts, sc.with(st.Names...))
+		case *ast.FuncDefStmt:
+			if ident, ok := st.Name.Func.(*ast.IdentExpr); !ok || !sc[ident.Value] {
+				cost += slotCost
+			}
+			st.Name.Func = limitExpr(st.Name.Func, sc, &cost)
+			st.Name.Receiver = limitExpr(st.Name.Receiver, sc, &cost)
+			params := st.Func.ParList.Names
+			if st.Name.Method != "" {
+				params = append([]string{"self"}, params...)
+			}
+			cost += closureCost
+			st.Func.Stmts = limitStmts(st.Func.Stmts, sc.with(params...))
+		case *ast.ReturnStmt:
+			limitExprs(st.Exprs, sc, &cost)
+		}
+		out = append(out, allocStmts(cost, stmt)...)
+		out = append(out, stmt)
+	}
+	return out
+}
+
+// prependAlloc returns stmts with a call of allocFunc charging cost first,
+// for a loop condition evaluated on each pass
+func prependAlloc(stmts []ast.Stmt, cost uint64, at ast.PositionHolder) []ast.Stmt {
+	if cost == 0 {
+		return stmts
+	}
+	return append(allocStmts(cost, at), stmts...)
+}
+
+// allocStmts returns a call of allocFunc charging cost, or nothing if cost is 0
+func allocStmts(cost uint64, at ast.PositionHolder) []ast.Stmt {
+	if cost == 0 {
+		return nil
+	}
+	n := &ast.NumberExpr{Value: strconv.FormatUint(cost, 10)}
+	n.SetLine(at.Line())
+	n.SetLastLine(at.LastLine())
+	stmt := &ast.FuncCallStmt{Expr: limitCall(allocFunc, at, n)}
+	stmt.SetLine(at.Line())
+	stmt.SetLastLine(at.LastLine())
+	return []ast.Stmt{stmt}
+}
+
+// limitCall returns a call of one of the chunk's charging locals
+func limitCall(name string, at ast.PositionHolder, args ...ast.Expr) *ast.FuncCallExpr {
+	fn := &ast.IdentExpr{Value: name}
+	fn.SetLine(at.Line())
+	fn.SetLastLine(at.LastLine())
+	call := &ast.FuncCallExpr{Func: fn, Args: args}
+	call.SetLine(at.Line())
+	call.SetLastLine(at.LastLine())
+	return call
+}
+
+// limitExprs applies limitExpr to each of exprs
+func limitExprs(exprs []ast.Expr, sc scope, cost *uint64) {
+	for i, expr := range exprs {
+		exprs[i] = limitExpr(expr, sc, cost)
+	}
+}
+
+// limitExpr rewrites an expression for limitChunk, adding what it
+// allocates each time it is evaluated to cost
+func limitExpr(expr ast.Expr, sc scope, cost *uint64) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.StringConcatOpExpr:
+		return limitCall(concatFunc, e, limitExpr(e.Lhs, sc, cost), limitExpr(e.Rhs, sc, cost))
+	case *ast.AttrGetExpr:
+		e.Object = limitExpr(e.Object, sc, cost)
+		e.Key = limitExpr(e.Key, sc, cost)
+	case *ast.TableExpr:
+		*cost += tableCost + slotCost*uint64(len(e.Fields))
+		for i, field := range e.Fields {
+			field.Key = limitExpr(field.Key, sc, cost)
+			field.Value = limitExpr(field.Value, sc, cost)
+			if field.Key == nil && i == len(e.Fields)-1 && multipleValues(field.Value) {
+				field.Value = limitCall(spreadFunc, e, field.Value)
+			}
+		}
+	case *ast.FuncCallExpr:
+		e.Func = limitExpr(e.Func, sc, cost)
+		e.Receiver = limitExpr(e.Receiver, sc, cost)
+		limitExprs(e.Args, sc, cost)
+	case *ast.LogicalOpExpr:
+		e.Lhs = limitExpr(e.Lhs, sc, cost)
+		e.Rhs = limitExpr(e.Rhs, sc, cost)
+	case *ast.RelationalOpExpr:
+		e.Lhs = limitExpr(e.Lhs, sc, cost)
+		e.Rhs = limitExpr(e.Rhs, sc, cost)
+	case *ast.ArithmeticOpExpr:
+		e.Lhs = limitExpr(e.Lhs, sc, cost)
+		e.Rhs = limitExpr(e.Rhs, sc, cost)
+	case *ast.UnaryMinusOpExpr:
+		e.Expr = limitExpr(e.Expr, sc, cost)
+	case *ast.UnaryNotOpExpr:
+		e.Expr = limitExpr(e.Expr, sc, cost)
+	case *ast.UnaryLenOpExpr:
+		e.Expr = limitExpr(e.Expr, sc, cost)
+	case *ast.FunctionExpr:
+		*cost += closureCost
+		e.Stmts = limitStmts(e.Stmts, sc.with(e.ParList.Names...))
+	}
+	return expr
+}
+
+// multipleValues reports whether expr can produce more than one value
+func multipleValues(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.FuncCallExpr:
+		return !e.AdjustRet
+	case *ast.Comma3Expr:
+		return !e.AdjustRet
+	}
+	return false
+}
+
+// formatWidthsValid reports whether every conversion in a string.format
+// pattern has at most two digits of width and precision, as in Lua itself
+func formatWidthsValid(f string) bool {
+	digits := func(i int) (int, bool) {
+		start := i
+		for i < len(f) && f[i] >= '0' && f[i] <= '9' {
+			i++
+		}
+		return i, i-start <= 2
+	}
+
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(f) && strings.IndexByte("-+ #0", f[i]) >= 0 {
+			i++
+		}
+		var ok bool
+		if i, ok = digits(i); !ok {
+			return false
+		}
+		if i < len(f) && f[i] == '.' {
+			if i, ok = digits(i + 1); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mulSize multiplies sizes, saturating instead of overflowing
+func mulSize(a, b uint64) uint64 {
+	if a != 0 && b > math.MaxUint64/a {
+		return math.MaxUint64
+	}
+	return a * b
+}
@@ -0,0 +1,309 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Hook names that scripts may define as global functions
+const (
+	HookRequest  = "on_request"
+	HookResponse = "on_response"
+)
+
+// Config holds scripting runtime configuration
+type Config struct {
+	Dir             string        // Directory containing *.lua scripts
+	Timeout         time.Duration // Maximum execution time of a single hook
+	MaxCallStack    int           // Maximum Lua call stack depth
+	MaxRegistrySize int           // Maximum Lua value stack size
+	MaxMemory       int           // Maximum bytes allocated by a single hook
+}
+
+// Runtime runs sandboxed Lua scripts that mutate proxied requests and responses.
+// Scripts are selected by name from the policy decision, so which script runs
+// for which profile or rule is decided in Rego.
+type Runtime struct {
+	config Config
+	logger zerolog.Logger
+
+	// Compiled scripts keyed by name (file name without extension), protected by mu
+	mu      sync.RWMutex
+	scripts map[string]*lua.FunctionProto
+}
+
+// NewRuntime creates a new scripting runtime and compiles all scripts in the configured directory
+func NewRuntime(config Config, logger zerolog.Logger) (*Runtime, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = 50 * time.Millisecond
+	}
+	if config.MaxCallStack <= 0 {
+		config.MaxCallStack = 64
+	}
+	if config.MaxRegistrySize <= 0 {
+		config.MaxRegistrySize = 16 * 1024
+	}
+	if config.MaxMemory <= 0 {
+		config.MaxMemory = 32 << 20
+	}
+
+	r := &Runtime{
+		config: config,
+		logger: logger.With().Str("component", "script").Logger(),
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload recompiles all scripts from disk. Existing scripts stay active if compilation fails.
+func (r *Runtime) Reload() error {
+	files, err := filepath.Glob(filepath.Join(r.config.Dir, "*.lua"))
+	if err != nil {
+		return fmt.Errorf("failed to list scripts: %w", err)
+	}
+
+	scripts := make(map[string]*lua.FunctionProto, len(files))
+	for _, file := range files {
+		proto, err := compileFile(file)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		scripts[name] = proto
+	}
+
+	r.mu.Lock()
+	r.scripts = scripts
+	r.mu.Unlock()
+
+	r.logger.Info().
+		Str("dir", r.config.Dir).
+		Int("scripts", len(scripts)).
+		Msg("Scripts loaded")
+
+	return nil
+}
+
+// MutateRequest runs the script's on_request hook against an upstream request.
+// The hook may change the path, query string and headers. Changes are only
+// applied if the hook completes successfully.
+func (r *Runtime) MutateRequest(name string, req *http.Request) error {
+	return r.run(name, HookRequest, func(L *lua.LState) *lua.LTable {
+		t := L.NewTable()
+		t.RawSetString("method", lua.LString(req.Method))
+		t.RawSetString("host", lua.LString(req.Host))
+		t.RawSetString("path", lua.LString(req.URL.Path))
+		t.RawSetString("query", lua.LString(req.URL.RawQuery))
+		t.RawSetString("headers", headersToTable(L, req.Header))
+		return t
+	}, func(t *lua.LTable) error {
+		headers, err := tableToHeaders(t.RawGetString("headers"))
+		if err != nil {
+			return err
+		}
+		if path, ok := t.RawGetString("path").(lua.LString); ok && string(path) != req.URL.Path {
+			req.URL.Path = string(path)
+			req.URL.RawPath = ""
+		}
+		if query, ok := t.RawGetString("query").(lua.LString); ok {
+			req.URL.RawQuery = string(query)
+		}
+		req.Header = headers
+		return nil
+	})
+}
+
+// MutateResponse runs the script's on_response hook against an upstream response.
+// The hook may change response headers; the status code is read-only.
+func (r *Runtime) MutateResponse(name string, resp *http.Response) error {
+	return r.run(name, HookResponse, func(L *lua.LState) *lua.LTable {
+		t := L.NewTable()
+		t.RawSetString("status", lua.LNumber(resp.StatusCode))
+		t.RawSetString("headers", headersToTable(L, resp.Header))
+		return t
+	}, func(t *lua.LTable) error {
+		headers, err := tableToHeaders(t.RawGetString("headers"))
+		if err != nil {
+			return err
+		}
+		resp.Header = headers
+		return nil
+	})
+}
+
+// run executes a hook in a fresh sandboxed Lua state
+func (r *Runtime) run(name, hook string, build func(*lua.LState) *lua.LTable, apply func(*lua.LTable) error) error {
+	r.mu.RLock()
+	proto, ok := r.scripts[name]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("script not found: %s", name)
+	}
+
+	budget := newMemoryBudget(r.config.MaxMemory)
+	L := r.newState(name, budget)
+	defer L.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Timeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	// Execute the chunk to define hook functions
+	L.Push(L.NewFunctionFromProto(proto))
+	args := budget.chunkArgs(L)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	if err := L.PCall(len(args), 0, nil); err != nil {
+		return fmt.Errorf("script %s failed to load: %w", name, err)
+	}
+
+	// Scripts only need to define the hooks they care about
+	fn, ok := L.GetGlobal(hook).(*lua.LFunction)
+	if !ok {
+		return nil
+	}
+
+	t := build(L)
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, t); err != nil {
+		return fmt.Errorf("script %s %s failed: %w", name, hook, err)
+	}
+
+	return apply(t)
+}
+
+// newState creates a Lua state with only safe libraries available, whose
+// builtins charge what they build to budget
+func (r *Runtime) newState(name string, budget *memoryBudget) *lua.LState {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   r.config.MaxCallStack,
+		RegistrySize:    1024,
+		RegistryMaxSize: r.config.MaxRegistrySize,
+	})
+
+	// No io, os, package or debug libraries: scripts cannot touch the filesystem or load code
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	// Remove base functions that can load code from disk or strings
+	for _, fn := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		L.SetGlobal(fn, lua.LNil)
+	}
+	budget.limitBuiltins(L)
+
+	// Route print to the structured logger
+	logger := r.logger.With().Str("script", name).Logger()
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		parts := make([]string, 0, L.GetTop())
+		for i := 1; i <= L.GetTop(); i++ {
+			parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+		}
+		logger.Debug().Msg(strings.Join(parts, "\t"))
+		return 0
+	}))
+
+	return L
+}
+
+// compileFile parses and compiles a Lua script
+func compileFile(path string) (*lua.FunctionProto, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open script %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	chunk, err := parse.Parse(f, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse script %s: %w", path, err)
+	}
+
+	proto, err := lua.Compile(limitChunk(chunk), path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile script %s: %w", path, err)
+	}
+
+	return proto, nil
+}
+
+// headersToTable converts HTTP headers to a Lua table. Single-valued headers
+// become strings; multi-valued headers become arrays of strings.
+func headersToTable(L *lua.LState, h http.Header) *lua.LTable {
+	t := L.NewTable()
+	for key, values := range h {
+		if len(values) == 1 {
+			t.RawSetString(key, lua.LString(values[0]))
+			continue
+		}
+		arr := L.NewTable()
+		for _, v := range values {
+			arr.Append(lua.LString(v))
+		}
+		t.RawSetString(key, arr)
+	}
+	return t
+}
+
+// tableToHeaders converts a Lua headers table back to HTTP headers
+func tableToHeaders(v lua.LValue) (http.Header, error) {
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("headers must be a table, got %s", v.Type())
+	}
+
+	h := make(http.Header)
+	var err error
+	t.ForEach(func(key, value lua.LValue) {
+		if err != nil {
+			return
+		}
+		name, ok := key.(lua.LString)
+		if !ok {
+			err = fmt.Errorf("header names must be strings, got %s", key.Type())
+			return
+		}
+		switch val := value.(type) {
+		case lua.LString:
+			h.Add(string(name), string(val))
+		case lua.LNumber:
+			h.Add(string(name), val.String())
+		case *lua.LTable:
+			val.ForEach(func(_, item lua.LValue) {
+				h.Add(string(name), item.String())
+			})
+		default:
+			err = fmt.Errorf("header %s has unsupported value type %s", name, value.Type())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
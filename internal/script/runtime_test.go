@@ -0,0 +1,294 @@
+package script
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// newTestRuntime writes scripts to a temp directory and loads them
+func newTestRuntime(t *testing.T, scripts map[string]string) *Runtime {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, src := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name+".lua"), []byte(src), 0644); err != nil {
+			t.Fatalf("failed to write script: %v", err)
+		}
+	}
+
+	rt, err := NewRuntime(Config{Dir: dir, Timeout: 100 * time.Millisecond}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewRuntime failed: %v", err)
+	}
+	return rt
+}
+
+func newTestRequest(t *testing.T) *http.Request {
+	t.Helper()
+
+	u, _ := url.Parse("https://example.com/watch?v=123&utm_source=feed")
+	req := &http.Request{Method: "GET", Host: "example.com", URL: u, Header: http.Header{}}
+	req.Header.Set("User-Agent", "test")
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "application/json")
+	return req
+}
+
+func TestMutateRequest(t *testing.T) {
+	rt := newTestRuntime(t, map[string]string{
+		"rewrite": `
+function on_request(req)
+  req.headers["X-Filtered"] = "yes"
+  req.headers["User-Agent"] = nil
+  req.path = "/embed" .. req.path
+  req.query = string.gsub(req.query, "&?utm_[^&]*", "")
+end
+`,
+	})
+
+	req := newTestRequest(t)
+	if err := rt.MutateRequest("rewrite", req); err != nil {
+		t.Fatalf("MutateRequest failed: %v", err)
+	}
+
+	if got := req.Header.Get("X-Filtered"); got != "yes" {
+		t.Errorf("X-Filtered = %q, want %q", got, "yes")
+	}
+	if got := req.Header.Get("User-Agent"); got != "" {
+		t.Errorf("User-Agent = %q, want removed", got)
+	}
+	if got := req.Header.Values("Accept"); len(got) != 2 {
+		t.Errorf("Accept = %v, want both values preserved", got)
+	}
+	if req.URL.Path != "/embed/watch" {
+		t.Errorf("path = %q, want %q", req.URL.Path, "/embed/watch")
+	}
+	if req.URL.RawQuery != "v=123" {
+		t.Errorf("query = %q, want %q", req.URL.RawQuery, "v=123")
+	}
+}
+
+func TestMutateResponse(t *testing.T) {
+	rt := newTestRuntime(t, map[string]string{
+		"headers": `
+function on_response(resp)
+  if resp.status == 200 then
+    resp.headers["Cache-Control"] = "no-store"
+  end
+end
+`,
+	})
+
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}}
+	resp.Header.Set("Cache-Control", "max-age=3600")
+	if err := rt.MutateResponse("headers", resp); err != nil {
+		t.Fatalf("MutateResponse failed: %v", err)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want %q", got, "no-store")
+	}
+}
+
+func TestScriptFailuresLeaveRequestUntouched(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		errSub string
+	}{
+		{
+			name:   "timeout",
+			src:    `function on_request(req) req.path = "/changed" while true do end end`,
+			errSub: "context deadline exceeded",
+		},
+		{
+			name:   "no os library",
+			src:    `function on_request(req) req.path = "/changed" os.exit(1) end`,
+			errSub: "os",
+		},
+		{
+			name:   "no dynamic loading",
+			src:    `function on_request(req) req.path = "/changed" loadstring("x = 1")() end`,
+			errSub: "non-function",
+		},
+		{
+			name:   "runaway recursion",
+			src:    `local function f() return 1 + f() end function on_request(req) req.path = "/changed" f() end`,
+			errSub: "stack overflow",
+		},
+		{
+			name:   "string.rep too large",
+			src:    `function on_request(req) req.path = "/changed" local s = string.rep("x", 1e9) end`,
+			errSub: "memory limit exceeded",
+		},
+		{
+			name:   "concatenation too large",
+			src:    `function on_request(req) req.path = "/changed" local s = "x" while true do s = s .. s end end`,
+			errSub: "memory limit exceeded",
+		},
+		{
+			name:   "table growth too large",
+			src:    `function on_request(req) req.path = "/changed" local s, t = ("x"):rep(1024 * 1024), {} for i = 1, 1e6 do t[i] = s .. i end end`,
+			errSub: "memory limit exceeded",
+		},
+		{
+			name:   "builtin results too large",
+			src:    `function on_request(req) req.path = "/changed" local s, t = ("x"):rep(10000), {} for i = 1, 1e6 do t[i] = s:sub(2) end end`,
+			errSub: "memory limit exceeded",
+		},
+		{
+			name:   "table constructors too large",
+			src:    `function on_request(req) req.path = "/changed" local s, t = ("x"):rep(10000), {} for i = 1, 1e6 do t[i] = {s:byte(1, -1)} end end`,
+			errSub: "memory limit exceeded",
+		},
+		{
+			name:   "gsub too large",
+			src:    `function on_request(req) req.path = "/changed" local s = string.gsub(("x"):rep(100000), "x", "y") end`,
+			errSub: "memory limit exceeded",
+		},
+		{
+			name:   "format width too large",
+			src:    `function on_request(req) req.path = "/changed" local s = string.format("%999999999d", 1) end`,
+			errSub: "invalid format",
+		},
+		{
+			name:   "bad header value",
+			src:    `function on_request(req) req.path = "/changed" req.headers["X-Bad"] = true end`,
+			errSub: "unsupported value type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newTestRuntime(t, map[string]string{"s": tt.src})
+			// Cases that fill a small budget get longer than the default
+			// timeout, which a loaded machine under -race can use up first
+			rt.config.MaxMemory = 1 << 20
+			if tt.errSub == "memory limit exceeded" {
+				rt.config.Timeout = 5 * time.Second
+			}
+			req := newTestRequest(t)
+
+			err := rt.MutateRequest("s", req)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.errSub) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.errSub)
+			}
+			if req.URL.Path != "/watch" {
+				t.Errorf("path = %q, want unchanged", req.URL.Path)
+			}
+			if req.Header.Get("User-Agent") != "test" {
+				t.Errorf("headers were modified on failure")
+			}
+		})
+	}
+}
+
+func TestLimitedBuiltins(t *testing.T) {
+	rt := newTestRuntime(t, map[string]string{
+		"builtins": `
+function on_request(req)
+  req.headers["X-Rep"] = ("ab"):rep(3)
+  req.headers["X-Format"] = string.format("%05.1f|%-4s|%%", 3.14159, "x")
+  req.headers["X-Gsub-String"] = string.gsub("a-b-c", "(%w)", "%1%1")
+  req.headers["X-Gsub-Table"] = string.gsub("a-b-c", "%w", {a = "1", b = "2"})
+  req.headers["X-Gsub-Function"] = string.gsub("a-b-c", "%w", function(c) return c:upper() end)
+  req.headers["X-Concat"] = table.concat({"a", "b", "c"}, ",")
+  local m = setmetatable({}, {__concat = function(a, b) return a .. "m" end})
+  req.headers["X-Dots"] = "n" .. 1 .. m
+end
+`,
+	})
+
+	req := newTestRequest(t)
+	if err := rt.MutateRequest("builtins", req); err != nil {
+		t.Fatalf("MutateRequest failed: %v", err)
+	}
+	for header, want := range map[string]string{
+		"X-Rep":           "ababab",
+		"X-Format":        "003.1|x   |%",
+		"X-Gsub-String":   "aa-bb-cc",
+		"X-Gsub-Table":    "1-2-c",
+		"X-Gsub-Function": "A-B-C",
+		"X-Concat":        "a,b,c",
+		"X-Dots":          "n1m",
+	} {
+		if got := req.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestConcurrentHooks(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"modest": `function on_request(req) local n, t = 0, {} for i = 1, 100000 do n = n + i end for i = 1, 4 do t[i] = ("x"):rep(100000) .. i end req.headers["X-Size"] = #table.concat(t) end`,
+		"greedy": `function on_request(req) local s = ("x"):rep(2 * 1024 * 1024) end`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name+".lua"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rt, err := NewRuntime(Config{Dir: dir, Timeout: 10 * time.Second, MaxMemory: 2 << 20}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewRuntime failed: %v", err)
+	}
+
+	// Allocations elsewhere in the process don't count against a hook
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		var sink [][]byte
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				sink = append(sink[:0], make([]byte, 1<<20))
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				req := newTestRequest(t)
+				if err := rt.MutateRequest("modest", req); err != nil {
+					t.Errorf("modest hook failed: %v", err)
+				} else if got := req.Header.Get("X-Size"); got != "400004" {
+					t.Errorf("X-Size = %q, want 400004", got)
+				}
+				if err := rt.MutateRequest("greedy", newTestRequest(t)); err == nil || !strings.Contains(err.Error(), "memory limit exceeded") {
+					t.Errorf("greedy hook error = %v, want memory limit exceeded", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMissingHookIsNoop(t *testing.T) {
+	rt := newTestRuntime(t, map[string]string{
+		"response-only": `function on_response(resp) end`,
+	})
+
+	req := newTestRequest(t)
+	if err := rt.MutateRequest("response-only", req); err != nil {
+		t.Fatalf("MutateRequest failed: %v", err)
+	}
+	if err := rt.MutateRequest("missing", req); err == nil {
+		t.Error("expected error for unknown script")
+	}
+}
@@ -0,0 +1,152 @@
+// Package searchwatch logs search queries and raises alerts when they match
+// concern lists. Which profiles are monitored, and which lists a query
+// matches, is decided by policy; this package acts on those decisions.
+package searchwatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/goodtune/kproxy/internal/spool"
+	"github.com/rs/zerolog"
+)
+
+// Config configures search monitoring
+type Config struct {
+	AlertWebhook      string        // URL alerts are POSTed to as JSON (optional)
+	AlertIncludeQuery bool          // Include the query terms in alerts
+	AlertTimeout      time.Duration // Timeout for each webhook POST
+}
+
+// Alert is sent to the webhook when a search query matches concern lists
+type Alert struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Profile  string    `json:"profile"`
+	Engine   string    `json:"engine"`
+	Concerns []string  `json:"concerns"`
+	Query    string    `json:"query,omitempty"` // Only with alert_include_query
+}
+
+// Monitor acts on search monitoring decisions. A nil *Monitor does nothing.
+type Monitor struct {
+	cfg    Config
+	client *http.Client
+	logger zerolog.Logger
+
+	// Alerts the webhook failed to take, kept on disk until it is back (optional)
+	spool *spool.Spool
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// New creates a search monitor
+func New(cfg Config, logger zerolog.Logger) *Monitor {
+	return &Monitor{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.AlertTimeout},
+		logger: logger.With().Str("component", "search-monitor").Logger(),
+		now:    time.Now,
+	}
+}
+
+// Observe logs a search query if its profile asks for it (logQuery) and
+// alerts if it matched concern lists
+func (m *Monitor) Observe(client, profile, engine, query string, logQuery bool, concerns []string) {
+	if m == nil {
+		return
+	}
+
+	if logQuery {
+		m.logger.Info().
+			Str("client_ip", client).
+			Str("profile", profile).
+			Str("engine", engine).
+			Str("query", query).
+			Msg("Search query")
+	}
+	if len(concerns) == 0 {
+		return
+	}
+
+	for _, concern := range concerns {
+		metrics.SearchConcerns.WithLabelValues(profile, concern).Inc()
+	}
+
+	alert := Alert{
+		Time:     m.now(),
+		Client:   client,
+		Profile:  profile,
+		Engine:   engine,
+		Concerns: concerns,
+	}
+	if m.cfg.AlertIncludeQuery {
+		alert.Query = query
+	}
+
+	event := m.logger.Warn().
+		Str("client_ip", client).
+		Str("profile", profile).
+		Str("engine", engine).
+		Strs("concerns", concerns)
+	if alert.Query != "" {
+		event = event.Str("query", alert.Query)
+	}
+	event.Msg("Search query matched concern list")
+
+	if m.cfg.AlertWebhook != "" {
+		go m.send(alert)
+	}
+}
+
+// SetSpool sets the spool keeping alerts the webhook fails to take
+func (m *Monitor) SetSpool(s *spool.Spool) {
+	m.spool = s
+}
+
+// Resend sends a spooled alert to the webhook
+func (m *Monitor) Resend(record []byte) error {
+	return m.post(record)
+}
+
+// send POSTs an alert to the webhook, spooling it if that fails
+func (m *Monitor) send(alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		m.logger.Error().Err(err).Msg("Failed to encode search alert")
+		return
+	}
+	if err := m.post(body); err != nil {
+		spooled := m.spool != nil && m.spool.Add(body) == nil
+		m.logger.Error().Err(err).Str("profile", alert.Profile).Bool("spooled", spooled).Msg("Failed to send search alert")
+	}
+}
+
+// post sends one JSON-encoded alert
+func (m *Monitor) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.cfg.AlertTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.AlertWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package searchwatch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func newWebhook(t *testing.T) (string, chan Alert) {
+	t.Helper()
+	alerts := make(chan Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		alerts <- alert
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, alerts
+}
+
+func receive(t *testing.T, alerts chan Alert) Alert {
+	t.Helper()
+	select {
+	case alert := <-alerts:
+		return alert
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert sent")
+		return Alert{}
+	}
+}
+
+func TestObserveAlerts(t *testing.T) {
+	url, alerts := newWebhook(t)
+	m := New(Config{AlertWebhook: url, AlertTimeout: 5 * time.Second}, zerolog.Nop())
+
+	m.Observe("192.168.1.20", "child", "google", "self harm help", false, []string{"self_harm"})
+	alert := receive(t, alerts)
+	if alert.Profile != "child" || alert.Engine != "google" || !reflect.DeepEqual(alert.Concerns, []string{"self_harm"}) {
+		t.Errorf("alert = %+v", alert)
+	}
+	if alert.Query != "" {
+		t.Errorf("Query = %q, want it left out", alert.Query)
+	}
+
+	m.cfg.AlertIncludeQuery = true
+	m.Observe("192.168.1.20", "child", "google", "self harm help", false, []string{"self_harm"})
+	if alert := receive(t, alerts); alert.Query != "self harm help" {
+		t.Errorf("Query = %q, want the query with alert_include_query", alert.Query)
+	}
+
+	// No concerns, no alert
+	m.Observe("192.168.1.20", "child", "google", "cat pictures", true, nil)
+	select {
+	case alert := <-alerts:
+		t.Errorf("unexpected alert %+v", alert)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	var none *Monitor
+	none.Observe("192.168.1.20", "child", "google", "self harm help", true, []string{"self_harm"})
+}
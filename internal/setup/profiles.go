@@ -0,0 +1,90 @@
+package setup
+
+import "slices"
+
+// exampleProfiles are the profiles of exampleProfilesRego
+var exampleProfiles = []string{"default", "child", "teen", "adult"}
+
+func isExampleProfile(id string) bool {
+	return slices.Contains(exampleProfiles, id)
+}
+
+// exampleProfilesRego replaces the default profile of the starter
+// config.rego: a starting point to adjust once devices are added
+const exampleProfilesRego = `
+profiles := {
+	# Blocks everything (devices not yet given a profile)
+	"default": {
+		"name": "Default Profile",
+		"description": "Secure baseline - blocks all traffic",
+		"rules": [],
+		"time_restrictions": {},
+		"usage_limits": {},
+		"default_action": "block",
+	},
+	# School sites, and an hour of videos a day, after school and at weekends
+	"child": {
+		"name": "Child",
+		"description": "Learning sites and an hour of entertainment a day",
+		"rules": [
+			{
+				"id": "allow-learning",
+				"domains": [".khanacademy.org", ".wikipedia.org", ".scratch.mit.edu"],
+				"action": "allow",
+				"category": "educational",
+			},
+			{
+				"id": "allow-video",
+				"domains": [".youtube.com", ".ytimg.com", ".googlevideo.com"],
+				"action": "allow",
+				"category": "entertainment",
+			},
+		],
+		"time_restrictions": {
+			"after-school": {
+				"days": [1, 2, 3, 4, 5],
+				"start_hour": 15, "start_minute": 0,
+				"end_hour": 19, "end_minute": 30,
+			},
+			"weekend": {
+				"days": [0, 6],
+				"start_hour": 8, "start_minute": 0,
+				"end_hour": 20, "end_minute": 0,
+			},
+		},
+		"usage_limits": {"entertainment": {"daily_minutes": 60, "inject_timer": true}},
+		"warn_minutes": [15, 5],
+		"safesearch": true,
+		"default_action": "block",
+	},
+	# Most of the web, with social media limited and nothing late at night
+	"teen": {
+		"name": "Teen",
+		"description": "Open web with 90 minutes of social media a day",
+		"rules": [{
+			"id": "social-media",
+			"domains": [".tiktok.com", ".instagram.com", ".snapchat.com"],
+			"action": "allow",
+			"category": "social-media",
+		}],
+		"time_restrictions": {"daytime": {
+			"days": [0, 1, 2, 3, 4, 5, 6],
+			"start_hour": 7, "start_minute": 0,
+			"end_hour": 22, "end_minute": 0,
+		}},
+		"usage_limits": {"social-media": {"daily_minutes": 90, "inject_timer": true}},
+		"warn_minutes": [15, 5],
+		"safesearch": true,
+		"default_action": "allow",
+	},
+	# No restrictions
+	"adult": {
+		"name": "Adult",
+		"description": "Unrestricted",
+		"rules": [],
+		"time_restrictions": {},
+		"usage_limits": {},
+		"default_action": "allow",
+	},
+}
+`
@@ -0,0 +1,412 @@
+package setup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/ca"
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/policy/opa"
+	"github.com/goodtune/kproxy/policies"
+	"github.com/rs/zerolog"
+)
+
+// Network is an IPv4 network KProxy can serve
+type Network struct {
+	Interface string
+	Address   net.IP     // KProxy's address on the network
+	Subnet    *net.IPNet // e.g. 192.168.1.0/24
+	Gateway   net.IP     // Default route through the interface (nil if none)
+}
+
+func (n Network) String() string {
+	s := fmt.Sprintf("%s: %s on %s", n.Interface, n.Address, n.Subnet)
+	if n.Gateway != nil {
+		s += fmt.Sprintf(" via %s", n.Gateway)
+	}
+	return s
+}
+
+// DetectNetworks lists the IPv4 networks of the interfaces that are up,
+// leaving out loopback
+func DetectNetworks() ([]Network, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	gateways := defaultGateways()
+
+	var networks []Network
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+			networks = append(networks, Network{
+				Interface: iface.Name,
+				Address:   ipNet.IP.To4(),
+				Subnet:    &net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask).To4(), Mask: ipNet.Mask},
+				Gateway:   gateways[iface.Name],
+			})
+		}
+	}
+	return networks, nil
+}
+
+// defaultGateways reads the default route of each interface from the
+// kernel routing table (Linux only; elsewhere there are none)
+func defaultGateways() map[string]net.IP {
+	gateways := make(map[string]net.IP)
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return gateways
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway ... with addresses in little-endian hex
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		gateways[fields[0]] = net.IPv4(b[3], b[2], b[1], b[0]).To4()
+	}
+	return gateways
+}
+
+// RedisListening reports whether something listens on Redis's port on
+// this machine, to suggest Redis for storage
+func RedisListening() bool {
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:6379", 500*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// Options are the answers to the setup questions
+type Options struct {
+	ConfigPath string // The CA and policies go in the same directory
+	ServerName string // server.name, e.g. "local.kproxy"
+	Network    Network
+
+	// DHCP server for the network (optional)
+	DHCP       bool
+	RangeStart net.IP
+	RangeEnd   net.IP
+	Gateway    net.IP
+
+	Storage string // "redis", "bolt" or "memory"
+
+	// Example profiles (child, teen, adult) in config.rego, and the profile
+	// for clients on the network that aren't added as devices ("" for none:
+	// they are blocked as unknown devices)
+	ExampleProfiles bool
+	NetworkProfile  string
+
+	AdminToken string // The admin account's token, generated if empty
+}
+
+// DefaultOptions suggests answers for a network: a DHCP pool of .100-.200
+// (where the subnet has room), the default route as gateway and the
+// example profiles, with the adult one for devices not yet added
+func DefaultOptions(configPath string, network Network) Options {
+	o := Options{
+		ConfigPath:      configPath,
+		ServerName:      "local.kproxy",
+		Network:         network,
+		Storage:         "bolt",
+		ExampleProfiles: true,
+		NetworkProfile:  "adult",
+	}
+	if network.Subnet != nil {
+		o.RangeStart = hostInSubnet(network.Subnet, 100)
+		o.RangeEnd = hostInSubnet(network.Subnet, 200)
+		o.Gateway = network.Gateway
+		if o.Gateway == nil {
+			o.Gateway = hostInSubnet(network.Subnet, 1)
+		}
+	}
+	return o
+}
+
+// hostInSubnet returns the n-th address of a subnet, or nil if the subnet
+// isn't that large
+func hostInSubnet(subnet *net.IPNet, n uint32) net.IP {
+	base := subnet.IP.To4()
+	if base == nil {
+		return nil
+	}
+	ones, bits := subnet.Mask.Size()
+	if bits-ones >= 32 || n >= 1<<(bits-ones)-1 {
+		return nil
+	}
+	v := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3]) + n
+	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To4()
+}
+
+// Validate checks the options
+func (o *Options) Validate() error {
+	if o.ConfigPath == "" {
+		return fmt.Errorf("config path is required")
+	}
+	if o.ServerName == "" || strings.ContainsAny(o.ServerName, " /\"") {
+		return fmt.Errorf("invalid server name %q", o.ServerName)
+	}
+	if o.Network.Address == nil || o.Network.Subnet == nil {
+		return fmt.Errorf("a network is required")
+	}
+	if o.Storage != "redis" && o.Storage != "bolt" && o.Storage != "memory" {
+		return fmt.Errorf("storage must be redis, bolt or memory, not %q", o.Storage)
+	}
+	if o.DHCP {
+		for name, ip := range map[string]net.IP{"range start": o.RangeStart, "range end": o.RangeEnd, "gateway": o.Gateway} {
+			if ip == nil || !o.Network.Subnet.Contains(ip) {
+				return fmt.Errorf("DHCP %s must be an address in %s", name, o.Network.Subnet)
+			}
+		}
+		if bytes.Compare(o.RangeStart.To4(), o.RangeEnd.To4()) > 0 {
+			return fmt.Errorf("DHCP range start %s is after its end %s", o.RangeStart, o.RangeEnd)
+		}
+	}
+	if o.NetworkProfile != "" && o.ExampleProfiles && !isExampleProfile(o.NetworkProfile) {
+		return fmt.Errorf("unknown profile %q (use one of %s)", o.NetworkProfile, strings.Join(exampleProfiles, ", "))
+	}
+	if o.NetworkProfile != "" && !o.ExampleProfiles && o.NetworkProfile != "default" {
+		return fmt.Errorf("without the example profiles only the default profile exists")
+	}
+	return nil
+}
+
+// Result describes what setup wrote
+type Result struct {
+	ConfigPath string
+	PolicyDir  string
+	RootCert   string // Certificate to install on devices
+	AdminToken string
+}
+
+// ErrConfigExists is returned by Run when the config file is already there
+var ErrConfigExists = errors.New("config file already exists")
+
+// Run writes the starter policies, generates the certificate authority
+// (keeping one already there) and writes the config file last, so KProxy
+// doesn't start from a half-finished setup. An existing config file or
+// policy files are only replaced with force.
+func Run(o Options, force bool, logger zerolog.Logger) (*Result, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(o.ConfigPath); err == nil && !force {
+		return nil, fmt.Errorf("%w: %s", ErrConfigExists, o.ConfigPath)
+	}
+
+	if o.AdminToken == "" {
+		token, err := GenerateToken()
+		if err != nil {
+			return nil, err
+		}
+		o.AdminToken = token
+	}
+
+	dir := filepath.Dir(o.ConfigPath)
+	result := &Result{
+		ConfigPath: o.ConfigPath,
+		PolicyDir:  filepath.Join(dir, "policies"),
+		RootCert:   filepath.Join(dir, "ca", "root-ca.crt"),
+		AdminToken: o.AdminToken,
+	}
+
+	if err := writePolicies(result.PolicyDir, o, force); err != nil {
+		return nil, err
+	}
+
+	// The CA generates a root and intermediate certificate when they are missing
+	if _, err := ca.NewCA(ca.Config{
+		RootCertPath:   result.RootCert,
+		RootKeyPath:    filepath.Join(dir, "ca", "root-ca.key"),
+		IntermCertPath: filepath.Join(dir, "ca", "intermediate-ca.crt"),
+		IntermKeyPath:  filepath.Join(dir, "ca", "intermediate-ca.key"),
+		CertCacheSize:  1,
+	}, logger); err != nil {
+		return nil, fmt.Errorf("failed to create the certificate authority: %w", err)
+	}
+
+	content, err := renderConfig(o, result)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// The file holds the admin token
+	if err := os.WriteFile(o.ConfigPath, content, 0600); err != nil {
+		return nil, err
+	}
+	if _, err := config.Load(o.ConfigPath); err != nil {
+		return nil, fmt.Errorf("written config file is invalid: %w", err)
+	}
+
+	return result, nil
+}
+
+// GenerateToken returns a random admin token
+func GenerateToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writePolicies writes the starter policies, with config.rego set up for
+// the network, and checks that they compile
+func writePolicies(dir string, o Options, force bool) error {
+	files, err := policies.Files(false)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		var existing []string
+		for _, name := range files {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				existing = append(existing, name)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("%s already has %s", dir, strings.Join(existing, ", "))
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range files {
+		content, err := policies.FS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if name == "config.rego" {
+			if content, err = policyConfig(content, o); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return err
+		}
+	}
+
+	if _, err := opa.NewEngine(opa.Config{Source: "filesystem", PolicyDir: dir, HTTPTimeout: 30 * time.Second}, zerolog.Nop()); err != nil {
+		return fmt.Errorf("written policies don't compile: %w", err)
+	}
+	return nil
+}
+
+// policyConfig fills in the starter config.rego: the server name, the
+// example profiles and the profile for the network
+func policyConfig(content []byte, o Options) ([]byte, error) {
+	s := string(content)
+	replace := func(old, new string) error {
+		if !strings.Contains(s, old) {
+			return fmt.Errorf("config.rego has no %q to replace", old)
+		}
+		s = strings.Replace(s, old, new, 1)
+		return nil
+	}
+
+	if err := replace(`server_name := "local.kproxy"`, fmt.Sprintf("server_name := %q", o.ServerName)); err != nil {
+		return nil, err
+	}
+	if o.NetworkProfile != "" {
+		if err := replace("subnet_profiles := {}", fmt.Sprintf("subnet_profiles := {%q: %q}", o.Network.Subnet.String(), o.NetworkProfile)); err != nil {
+			return nil, err
+		}
+	}
+	if o.ExampleProfiles {
+		start := strings.Index(s, `profiles := {"default": {`)
+		end := strings.Index(s[max(start, 0):], "\n}}\n")
+		if start < 0 || end < 0 {
+			return nil, fmt.Errorf("config.rego has no default profile to replace")
+		}
+		s = s[:start] + strings.TrimSpace(exampleProfilesRego) + s[start+end+len("\n}}"):]
+	}
+	return []byte(s), nil
+}
+
+var configTemplate = template.Must(template.New("config").Parse(`# KProxy configuration written by "kproxy setup". Settings not listed here
+# keep their defaults; see config.example.yaml for all of them.
+
+server:
+  name: {{printf "%q" .Options.ServerName}}
+  proxy_ip: {{printf "%q" .Options.Network.Address.String}}
+
+dhcp:
+  enabled: {{.Options.DHCP}}
+{{- if .Options.DHCP}}
+  server_ip: {{printf "%q" .Options.Network.Address.String}}
+  subnet_mask: {{printf "%q" .SubnetMask}}
+  gateway: {{printf "%q" .Options.Gateway.String}}
+  range_start: {{printf "%q" .Options.RangeStart.String}}
+  range_end: {{printf "%q" .Options.RangeEnd.String}}
+{{- end}}
+
+tls:
+  ca_cert: {{printf "%q" .Result.RootCert}}
+  ca_key: {{printf "%q" .CAKey}}
+  intermediate_cert: {{printf "%q" .IntermCert}}
+  intermediate_key: {{printf "%q" .IntermKey}}
+
+storage:
+  type: {{printf "%q" .Options.Storage}}
+
+policy:
+  opa_policy_source: "filesystem"
+  opa_policy_dir: {{printf "%q" .Result.PolicyDir}}
+
+admin:
+  enabled: true
+  token: {{printf "%q" .Options.AdminToken}}
+`))
+
+// renderConfig renders the config file
+func renderConfig(o Options, result *Result) ([]byte, error) {
+	caDir := filepath.Dir(result.RootCert)
+	var buf bytes.Buffer
+	err := configTemplate.Execute(&buf, map[string]interface{}{
+		"Options":    o,
+		"Result":     result,
+		"SubnetMask": net.IP(o.Network.Subnet.Mask).String(),
+		"CAKey":      filepath.Join(caDir, "root-ca.key"),
+		"IntermCert": filepath.Join(caDir, "intermediate-ca.crt"),
+		"IntermKey":  filepath.Join(caDir, "intermediate-ca.key"),
+	})
+	return buf.Bytes(), err
+}
@@ -0,0 +1,135 @@
+package setup
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/rs/zerolog"
+)
+
+func testNetwork() Network {
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/24")
+	return Network{Interface: "eth0", Address: net.ParseIP("192.168.1.10").To4(), Subnet: subnet}
+}
+
+func TestRun(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	o := DefaultOptions(configPath, testNetwork())
+	o.DHCP = true
+	o.NetworkProfile = "teen"
+
+	result, err := Run(o, false, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(result.AdminToken) != 48 {
+		t.Errorf("admin token = %q, want a generated one", result.AdminToken)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatalf("config.Load failed: %v", err)
+	}
+	if cfg.Server.ProxyIP != "192.168.1.10" || !cfg.DHCP.Enabled || cfg.DHCP.Gateway != "192.168.1.1" ||
+		cfg.DHCP.RangeStart != "192.168.1.100" || cfg.DHCP.RangeEnd != "192.168.1.200" || cfg.DHCP.SubnetMask != "255.255.255.0" {
+		t.Errorf("unexpected network settings: %+v %+v", cfg.Server, cfg.DHCP)
+	}
+	if !cfg.Admin.Enabled || cfg.Admin.Token != result.AdminToken || cfg.Policy.OPAPolicyDir != result.PolicyDir {
+		t.Errorf("unexpected admin or policy settings: %+v %+v", cfg.Admin, cfg.Policy)
+	}
+	if info, err := os.Stat(configPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("config file mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+	if _, err := os.Stat(result.RootCert); err != nil {
+		t.Errorf("root certificate not written: %v", err)
+	}
+
+	policy, err := os.ReadFile(filepath.Join(result.PolicyDir, "config.rego"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`subnet_profiles := {"192.168.1.0/24": "teen"}`, `"child": {`, `server_name := "local.kproxy"`} {
+		if !strings.Contains(string(policy), want) {
+			t.Errorf("config.rego lacks %s", want)
+		}
+	}
+
+	// An existing setup is only replaced with force
+	if _, err := Run(o, false, zerolog.Nop()); !errors.Is(err, ErrConfigExists) {
+		t.Errorf("second Run = %v, want ErrConfigExists", err)
+	}
+	if _, err := Run(o, true, zerolog.Nop()); err != nil {
+		t.Errorf("Run with force failed: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, change := range map[string]func(*Options){
+		"range outside subnet": func(o *Options) { o.DHCP = true; o.RangeEnd = net.ParseIP("10.0.0.1") },
+		"range reversed":       func(o *Options) { o.DHCP = true; o.RangeStart, o.RangeEnd = o.RangeEnd, o.RangeStart },
+		"unknown profile":      func(o *Options) { o.NetworkProfile = "toddler" },
+		"no example profiles":  func(o *Options) { o.ExampleProfiles = false },
+		"storage":              func(o *Options) { o.Storage = "sqlite" },
+	} {
+		o := DefaultOptions("/etc/kproxy/config.yaml", testNetwork())
+		change(&o)
+		if err := o.Validate(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Small subnets have no room for the suggested DHCP pool
+	_, small, _ := net.ParseCIDR("192.168.1.0/28")
+	if ip := hostInSubnet(small, 100); ip != nil {
+		t.Errorf("hostInSubnet(/28, 100) = %s, want nil", ip)
+	}
+}
+
+func TestWizard(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	w, err := NewWizard(configPath, []Network{testNetwork()}, false, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submit := func(code string) *httptest.ResponseRecorder {
+		form := url.Values{"network": {"0"}, "server_name": {"kproxy.home"}, "storage": {"memory"},
+			"example_profiles": {"on"}, "network_profile": {"child"}, "code": {code}}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		w.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := submit("wrong"); rec.Code != http.StatusBadRequest || w.Result() != nil {
+		t.Fatalf("wrong code: status %d, result %v", rec.Code, w.Result())
+	}
+	if _, err := os.Stat(configPath); err == nil {
+		t.Fatal("config written with the wrong code")
+	}
+
+	rec := submit(w.Code())
+	if rec.Code != http.StatusOK || w.Result() == nil {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), w.Result().AdminToken) {
+		t.Error("admin token not shown")
+	}
+	select {
+	case <-w.Done():
+	default:
+		t.Error("expected the wizard to be done")
+	}
+	if cfg, err := config.Load(configPath); err != nil || cfg.Server.Name != "kproxy.home" {
+		t.Errorf("config = %+v (%v)", cfg, err)
+	}
+}
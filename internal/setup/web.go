@@ -0,0 +1,239 @@
+package setup
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// Wizard asks the setup questions as a web form, for a first boot without
+// a terminal at hand. Anyone who can reach the form could configure the
+// proxy, so submitting it takes a one-time code shown on the console.
+type Wizard struct {
+	configPath string
+	networks   []Network
+	force      bool
+	storage    string // Suggested storage
+	code       string
+	logger     zerolog.Logger
+
+	mu     sync.Mutex
+	result *Result
+	done   chan struct{}
+}
+
+// NewWizard creates a wizard offering the networks, with answers
+// suggested by DefaultOptions
+func NewWizard(configPath string, networks []Network, force bool, logger zerolog.Logger) (*Wizard, error) {
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("no IPv4 networks found")
+	}
+	code, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+	w := &Wizard{
+		configPath: configPath,
+		networks:   networks,
+		force:      force,
+		storage:    "bolt",
+		code:       code[:8],
+		logger:     logger,
+		done:       make(chan struct{}),
+	}
+	if RedisListening() {
+		w.storage = "redis"
+	}
+	return w, nil
+}
+
+// defaults suggests answers for a network
+func (w *Wizard) defaults(network Network) Options {
+	o := DefaultOptions(w.configPath, network)
+	o.Storage = w.storage
+	return o
+}
+
+// Code is the one-time code the form asks for
+func (w *Wizard) Code() string {
+	return w.code
+}
+
+// Done is closed once setup has run successfully
+func (w *Wizard) Done() <-chan struct{} {
+	return w.done
+}
+
+// Result is what setup wrote (nil until it has run)
+func (w *Wizard) Result() *Result {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.result
+}
+
+// wizardPage is the data of the wizard page
+type wizardPage struct {
+	Networks []Network
+	Network  int
+	Options  Options
+	Profiles []string
+	Error    string
+	Result   *Result
+}
+
+func (w *Wizard) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	page := wizardPage{
+		Networks: w.networks,
+		Options:  w.defaults(w.networks[0]),
+		Profiles: exampleProfiles,
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch {
+	case w.result != nil:
+		page.Result = w.result
+
+	case r.Method == http.MethodPost:
+		o, network, err := w.optionsFromForm(r)
+		if err == nil && subtle.ConstantTimeCompare([]byte(r.PostFormValue("code")), []byte(w.code)) != 1 {
+			err = fmt.Errorf("the setup code is wrong; it is shown where kproxy setup is running")
+		}
+		if err == nil {
+			page.Result, err = Run(o, w.force, w.logger)
+		}
+		if err != nil {
+			page.Options, page.Network, page.Error = o, network, err.Error()
+			rw.WriteHeader(http.StatusBadRequest)
+			break
+		}
+		w.result = page.Result
+		close(w.done)
+
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "no-store")
+	if err := wizardTemplate.Execute(rw, page); err != nil {
+		w.logger.Error().Err(err).Msg("Failed to render setup page")
+	}
+}
+
+// optionsFromForm reads the answers from a submitted form, and the index
+// of the chosen network
+func (w *Wizard) optionsFromForm(r *http.Request) (Options, int, error) {
+	network, err := strconv.Atoi(r.PostFormValue("network"))
+	if err != nil || network < 0 || network >= len(w.networks) {
+		return w.defaults(w.networks[0]), 0, fmt.Errorf("choose a network")
+	}
+
+	o := w.defaults(w.networks[network])
+	o.ServerName = strings.TrimSpace(r.PostFormValue("server_name"))
+	o.Storage = r.PostFormValue("storage")
+	o.ExampleProfiles = r.PostFormValue("example_profiles") == "on"
+	o.NetworkProfile = r.PostFormValue("network_profile")
+	o.DHCP = r.PostFormValue("dhcp") == "on"
+	if o.DHCP {
+		o.RangeStart = net.ParseIP(strings.TrimSpace(r.PostFormValue("range_start")))
+		o.RangeEnd = net.ParseIP(strings.TrimSpace(r.PostFormValue("range_end")))
+		o.Gateway = net.ParseIP(strings.TrimSpace(r.PostFormValue("gateway")))
+	}
+	return o, network, nil
+}
+
+var wizardTemplate = template.Must(template.New("wizard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>KProxy Setup</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: #f5f5f5; margin: 0; padding: 40px 20px; }
+.container { max-width: 560px; margin: 0 auto; background: #fff; border-radius: 8px; padding: 32px; box-shadow: 0 2px 8px rgba(0,0,0,0.1); }
+h1 { margin-top: 0; color: #333; }
+fieldset { border: 1px solid #ddd; border-radius: 6px; margin: 16px 0; }
+label { display: block; margin: 8px 0 4px; color: #555; }
+input[type=text], select { width: 100%; padding: 8px; box-sizing: border-box; }
+button { margin-top: 16px; padding: 10px 20px; background: #2c7be5; color: #fff; border: 0; border-radius: 6px; font-size: 16px; }
+.error { background: #fdecea; color: #b71c1c; padding: 12px; border-radius: 6px; }
+code { background: #f0f0f0; padding: 2px 6px; border-radius: 4px; word-break: break-all; }
+</style>
+</head>
+<body>
+<div class="container">
+{{- if .Result}}
+<h1>KProxy is set up</h1>
+<p>Configuration: <code>{{.Result.ConfigPath}}</code><br>
+Policies: <code>{{.Result.PolicyDir}}</code><br>
+Root certificate: <code>{{.Result.RootCert}}</code></p>
+<p>Admin token (keep it safe, it is only shown once):<br><code>{{.Result.AdminToken}}</code></p>
+<p>Next, start KProxy, point your network's DNS at it and install the root
+certificate on your devices from the setup page. Add devices to
+<code>config.rego</code> in the policy directory.</p>
+{{- else}}
+<h1>KProxy Setup</h1>
+{{- if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post">
+<fieldset>
+<legend>Network</legend>
+<label for="network">Network to serve</label>
+<select id="network" name="network">
+{{- range $i, $n := .Networks}}
+<option value="{{$i}}"{{if eq $i $.Network}} selected{{end}}>{{$n}}</option>
+{{- end}}
+</select>
+<label for="server_name">Server name</label>
+<input type="text" id="server_name" name="server_name" value="{{.Options.ServerName}}">
+</fieldset>
+<fieldset>
+<legend>DHCP</legend>
+<label><input type="checkbox" name="dhcp"{{if .Options.DHCP}} checked{{end}}> Hand out addresses (turn off DHCP on your router first)</label>
+<label for="range_start">Range start</label>
+<input type="text" id="range_start" name="range_start" value="{{if .Options.RangeStart}}{{.Options.RangeStart}}{{end}}">
+<label for="range_end">Range end</label>
+<input type="text" id="range_end" name="range_end" value="{{if .Options.RangeEnd}}{{.Options.RangeEnd}}{{end}}">
+<label for="gateway">Gateway</label>
+<input type="text" id="gateway" name="gateway" value="{{if .Options.Gateway}}{{.Options.Gateway}}{{end}}">
+</fieldset>
+<fieldset>
+<legend>Profiles and storage</legend>
+<label><input type="checkbox" name="example_profiles"{{if .Options.ExampleProfiles}} checked{{end}}> Add example profiles (child, teen, adult)</label>
+<label for="network_profile">Profile for devices not yet added</label>
+<select id="network_profile" name="network_profile">
+<option value=""{{if eq .Options.NetworkProfile ""}} selected{{end}}>none (block unknown devices)</option>
+{{- range .Profiles}}
+<option value="{{.}}"{{if eq . $.Options.NetworkProfile}} selected{{end}}>{{.}}</option>
+{{- end}}
+</select>
+<label for="storage">Storage</label>
+<select id="storage" name="storage">
+<option value="bolt"{{if eq .Options.Storage "bolt"}} selected{{end}}>a file in /var/lib/kproxy (bolt)</option>
+<option value="memory"{{if eq .Options.Storage "memory"}} selected{{end}}>memory (usage is lost on restart)</option>
+<option value="redis"{{if eq .Options.Storage "redis"}} selected{{end}}>Redis on localhost:6379</option>
+</select>
+</fieldset>
+<label for="code">Setup code (shown where kproxy setup is running)</label>
+<input type="text" id="code" name="code" autocomplete="off">
+<button type="submit">Set up KProxy</button>
+</form>
+{{- end}}
+</div>
+</body>
+</html>
+`))
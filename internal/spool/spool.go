@@ -0,0 +1,324 @@
+// Package spool buffers records for a remote sink on disk while the sink is
+// down, and sends them again once it is back (store-and-forward), so events
+// aren't lost during a network incident or restart.
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+// segmentSuffix names the files holding spooled records, one per line
+const segmentSuffix = ".jsonl"
+
+// maxSegments is the fewest segments the size cap is split into, so the
+// oldest records can be dropped without dropping everything
+const maxSegments = 8
+
+// Send delivers one spooled record to the sink
+type Send func(record []byte) error
+
+// Spool keeps the records a sink failed to take in segment files under a
+// directory, appending to the newest segment and sending from the oldest.
+// When the spool would exceed its size cap, the oldest segments are
+// dropped. A nil *Spool keeps nothing.
+type Spool struct {
+	name          string // Sink name, for logs and metrics
+	dir           string
+	maxBytes      int64
+	segmentBytes  int64
+	retryInterval time.Duration
+	send          Send
+	logger        zerolog.Logger
+
+	mu      sync.Mutex // Guards the segment files
+	current string     // Segment records are appended to ("" to start a new one)
+	next    int64      // Sequence number of the next segment
+
+	replayMu sync.Mutex // One replay at a time
+
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// Open opens (creating if needed) the spool of sink name under dir. Records
+// left from an earlier run are kept and sent by the next replay.
+func Open(dir, name string, maxBytes int64, retryInterval time.Duration, send Send, logger zerolog.Logger) (*Spool, error) {
+	dir = filepath.Join(dir, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{
+		name:          name,
+		dir:           dir,
+		maxBytes:      maxBytes,
+		segmentBytes:  max(maxBytes/maxSegments, 1),
+		retryInterval: retryInterval,
+		send:          send,
+		logger:        logger.With().Str("component", "spool").Str("sink", name).Logger(),
+		stopChan:      make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, segment := range segments {
+		var seq int64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(segment, segmentSuffix), "%d", &seq); err == nil && seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	s.updateMetrics(segments)
+	return s, nil
+}
+
+// Add spools a record the sink failed to take. Records are single lines,
+// such as JSON from json.Marshal.
+func (s *Spool) Add(record []byte) error {
+	if s == nil {
+		return errors.New("no spool")
+	}
+	if bytes.IndexByte(record, '\n') >= 0 {
+		return errors.New("spooled records must be a single line")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != "" {
+		if info, err := os.Stat(filepath.Join(s.dir, s.current)); err != nil || info.Size() >= s.segmentBytes {
+			s.current = ""
+		}
+	}
+	if s.current == "" {
+		s.current = fmt.Sprintf("%020d%s", s.next, segmentSuffix)
+		s.next++
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, s.current), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(record, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.enforceCap()
+}
+
+// enforceCap drops the oldest segments while the spool is over its size
+// cap. Called with s.mu held.
+func (s *Spool) enforceCap() error {
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	sizes := make([]int64, len(segments))
+	for i, segment := range segments {
+		if info, err := os.Stat(filepath.Join(s.dir, segment)); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+
+	for i := 0; total > s.maxBytes && i < len(segments)-1; i++ {
+		path := filepath.Join(s.dir, segments[i])
+		records, _ := readRecords(path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= sizes[i]
+		metrics.SpoolDropped.WithLabelValues(s.name).Add(float64(len(records)))
+		s.logger.Warn().Int("records", len(records)).Int64("max_bytes", s.maxBytes).Msg("Spool full, dropped the oldest records")
+	}
+	s.updateMetrics(segments)
+	return nil
+}
+
+// Replay sends the spooled records, oldest first, until the sink fails.
+// Records sent are removed; the rest stay for the next replay. It returns
+// the number of records sent.
+func (s *Spool) Replay() (int, error) {
+	if s == nil {
+		return 0, nil
+	}
+
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	// Start a new segment, so records spooled while replaying don't land
+	// in the segments being sent
+	s.mu.Lock()
+	s.current = ""
+	segments, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, segment := range segments {
+		path := filepath.Join(s.dir, segment)
+		records, err := readRecords(path)
+		if os.IsNotExist(err) {
+			continue // Dropped by the size cap meanwhile
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		for i, record := range records {
+			if err := s.send(record); err != nil {
+				s.keep(path, records[i:])
+				return sent, err
+			}
+			sent++
+		}
+
+		s.mu.Lock()
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.logger.Error().Err(err).Msg("Failed to remove sent spool segment")
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	if remaining, err := s.segments(); err == nil {
+		s.updateMetrics(remaining)
+	}
+	s.mu.Unlock()
+
+	if sent > 0 {
+		s.logger.Info().Int("records", sent).Msg("Sent spooled records")
+	}
+	return sent, nil
+}
+
+// keep rewrites a partly sent segment with the records left to send,
+// unless the size cap dropped it meanwhile
+func (s *Spool) keep(path string, records [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	for _, record := range records {
+		buf.Write(record)
+		buf.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to rewrite spool segment")
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to rewrite spool segment")
+	}
+	if segments, err := s.segments(); err == nil {
+		s.updateMetrics(segments)
+	}
+}
+
+// Start begins replaying the spool every retry interval
+func (s *Spool) Start() {
+	if s == nil {
+		return
+	}
+	go s.run()
+}
+
+// Stop stops the replays. Records left stay on disk for the next run.
+func (s *Spool) Stop() {
+	if s == nil {
+		return
+	}
+	close(s.stopChan)
+	<-s.done
+}
+
+// run replays the spool until stopped
+func (s *Spool) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Replay(); err != nil {
+				s.logger.Debug().Err(err).Msg("Sink still unavailable, keeping spooled records")
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// segments lists the segment files, oldest first
+func (s *Spool) segments() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), segmentSuffix) {
+			segments = append(segments, entry.Name())
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// updateMetrics reports the spooled bytes
+func (s *Spool) updateMetrics(segments []string) {
+	var total int64
+	for _, segment := range segments {
+		if info, err := os.Stat(filepath.Join(s.dir, segment)); err == nil {
+			total += info.Size()
+		}
+	}
+	metrics.SpoolBytes.WithLabelValues(s.name).Set(float64(total))
+}
+
+// readRecords reads the records of a segment
+func readRecords(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var records [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); len(line) > 0 {
+			records = append(records, bytes.Clone(line))
+		}
+	}
+	return records, scanner.Err()
+}
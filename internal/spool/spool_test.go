@@ -0,0 +1,114 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	down := true
+	var got []string
+	send := func(record []byte) error {
+		if down {
+			return errors.New("sink down")
+		}
+		got = append(got, string(record))
+		return nil
+	}
+
+	s, err := Open(dir, "mirror", 1<<20, time.Minute, send, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.Add([]byte(fmt.Sprintf(`{"n":%d}`, i))); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if err := s.Add([]byte("two\nlines")); err == nil {
+		t.Error("Add() of a multi-line record succeeded")
+	}
+
+	// Nothing is lost while the sink is down
+	if sent, err := s.Replay(); err == nil || sent != 0 {
+		t.Errorf("Replay() with the sink down = %d (%v), want an error", sent, err)
+	}
+
+	// Records survive a restart and are sent in order once the sink is back
+	s, err = Open(dir, "mirror", 1<<20, time.Minute, send, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	down = false
+	if sent, err := s.Replay(); err != nil || sent != 3 {
+		t.Fatalf("Replay() = %d (%v), want 3 records", sent, err)
+	}
+	if len(got) != 3 || got[0] != `{"n":0}` || got[2] != `{"n":2}` {
+		t.Errorf("sent %v, want the records in order", got)
+	}
+	if sent, _ := s.Replay(); sent != 0 {
+		t.Errorf("Replay() again sent %d records, want none", sent)
+	}
+}
+
+func TestSpoolPartialReplay(t *testing.T) {
+	failAt := 2
+	var got []string
+	send := func(record []byte) error {
+		if len(got) == failAt {
+			return errors.New("sink down")
+		}
+		got = append(got, string(record))
+		return nil
+	}
+
+	s, err := Open(t.TempDir(), "alerts", 1<<20, time.Minute, send, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, record := range []string{"a", "b", "c", "d"} {
+		_ = s.Add([]byte(record))
+	}
+
+	// The sink fails after two records; the other two are kept
+	if sent, err := s.Replay(); err == nil || sent != 2 {
+		t.Errorf("Replay() = %d (%v), want 2 records and an error", sent, err)
+	}
+	failAt = -1
+	if sent, err := s.Replay(); err != nil || sent != 2 {
+		t.Errorf("Replay() = %d (%v), want the other 2 records", sent, err)
+	}
+	if len(got) != 4 || got[2] != "c" || got[3] != "d" {
+		t.Errorf("sent %v, want each record once, in order", got)
+	}
+}
+
+func TestSpoolCap(t *testing.T) {
+	var got []string
+	send := func(record []byte) error {
+		got = append(got, string(record))
+		return nil
+	}
+
+	// 80 bytes in segments of 10: each 9-byte record (with its newline)
+	// fills one segment
+	s, err := Open(t.TempDir(), "analytics", 80, time.Minute, send, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := 0; i < 12; i++ {
+		_ = s.Add([]byte(fmt.Sprintf("record%02d", i)))
+	}
+
+	if _, err := s.Replay(); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(got) != 8 || got[0] != "record04" || got[7] != "record11" {
+		t.Errorf("sent %v, want the newest 8 records", got)
+	}
+}
@@ -0,0 +1,47 @@
+package bolt
+
+import (
+	"context"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type apiTokenStore struct {
+	db *bolt.DB
+}
+
+// List returns all tokens, oldest first
+func (s *apiTokenStore) List(ctx context.Context) ([]storage.APIToken, error) {
+	var tokens []storage.APIToken
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		tokens, err = listJSON[storage.APIToken](tx.Bucket(apiTokensBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].Created.Equal(tokens[j].Created) {
+			return tokens[i].Created.Before(tokens[j].Created)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// Put creates or replaces a token
+func (s *apiTokenStore) Put(ctx context.Context, token storage.APIToken) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(apiTokensBucket), token.ID, token)
+	})
+}
+
+// Delete removes a token
+func (s *apiTokenStore) Delete(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteRecord(tx.Bucket(apiTokensBucket), id)
+	})
+}
@@ -0,0 +1,37 @@
+package bolt
+
+import (
+	"context"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// auditLogStore keeps entries under sequence numbers, oldest first
+type auditLogStore struct {
+	db *bolt.DB
+}
+
+// Add records a change
+func (s *auditLogStore) Add(ctx context.Context, entry storage.AuditEntry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return appendJSON(tx.Bucket(auditLogBucket), entry)
+	})
+}
+
+// List returns the unexpired entries newest first, removing the expired ones
+func (s *auditLogStore) List(ctx context.Context) ([]storage.AuditEntry, error) {
+	var entries []storage.AuditEntry
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		entries, err = listAppended(tx.Bucket(auditLogBucket), func(entry storage.AuditEntry) time.Time {
+			return entry.ExpiresAt
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
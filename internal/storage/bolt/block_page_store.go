@@ -0,0 +1,53 @@
+package bolt
+
+import (
+	"context"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type blockPageStore struct {
+	db *bolt.DB
+}
+
+// Get retrieves a block page template
+func (s *blockPageStore) Get(ctx context.Context, name string) (*storage.BlockPage, error) {
+	var page storage.BlockPage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(blockPagesBucket), name, &page)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// List returns all block page templates sorted by name
+func (s *blockPageStore) List(ctx context.Context) ([]storage.BlockPage, error) {
+	var pages []storage.BlockPage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Keyed by name, so already in order
+		var err error
+		pages, err = listJSON[storage.BlockPage](tx.Bucket(blockPagesBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// Put creates or replaces a block page template
+func (s *blockPageStore) Put(ctx context.Context, page storage.BlockPage) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(blockPagesBucket), page.Name, page)
+	})
+}
+
+// Delete removes a block page template
+func (s *blockPageStore) Delete(ctx context.Context, name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteRecord(tx.Bucket(blockPagesBucket), name)
+	})
+}
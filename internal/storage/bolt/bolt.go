@@ -0,0 +1,224 @@
+// Package bolt implements storage.Store in a single BoltDB file, so a small
+// install keeps usage, DHCP leases and admin changes across restarts without
+// running Redis. The file is locked while open, so only one KProxy instance
+// can use it; reload notices only reach subscribers in the same process.
+package bolt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// lockTimeout is how long Open waits for another process to release the
+// file
+const lockTimeout = time.Second
+
+// Buckets holding each store's records
+var (
+	schemaBucket           = []byte("schema")
+	usageSessionsBucket    = []byte("usage_sessions")
+	dailyUsageBucket       = []byte("daily_usage")
+	dhcpLeasesBucket       = []byte("dhcp_leases")
+	dhcpLeaseIPsBucket     = []byte("dhcp_lease_ips")
+	dhcpReservationsBucket = []byte("dhcp_reservations")
+	dnsCacheBucket         = []byte("dns_cache")
+	pendingChangesBucket   = []byte("pending_changes")
+	blockPagesBucket       = []byte("block_pages")
+	deviceRulesBucket      = []byte("device_rules")
+	ruleSetsBucket         = []byte("rule_sets")
+	reportsBucket          = []byte("reports")
+	pausesBucket           = []byte("pauses")
+	decisionsBucket        = []byte("decisions")
+	hostnamesBucket        = []byte("hostnames")
+	issuedCertsBucket      = []byte("issued_certs")
+	auditLogBucket         = []byte("audit_log")
+	apiTokensBucket        = []byte("api_tokens")
+)
+
+// buckets lists every bucket Open creates
+var buckets = [][]byte{
+	schemaBucket, usageSessionsBucket, dailyUsageBucket, dhcpLeasesBucket,
+	dhcpLeaseIPsBucket, dhcpReservationsBucket, dnsCacheBucket,
+	pendingChangesBucket, blockPagesBucket, deviceRulesBucket, ruleSetsBucket,
+	reportsBucket, pausesBucket, decisionsBucket, hostnamesBucket,
+	issuedCertsBucket, auditLogBucket, apiTokensBucket,
+}
+
+// Store implements the storage.Store interface using BoltDB
+type Store struct {
+	db          *bolt.DB
+	schema      *schemaStore
+	usageStore  *usageStore
+	dhcpStore   *dhcpLeaseStore
+	reserved    *dhcpReservationStore
+	dnsCache    *dnsCacheStore
+	pending     *pendingChangeStore
+	blockPages  *blockPageStore
+	deviceRules *deviceRuleStore
+	ruleSets    *ruleSetStore
+	reports     *reportStore
+	pauses      *pauseStore
+	reloads     *reloadBus
+	decisions   *decisionStore
+	hostnames   *hostnameStore
+	issued      *issuedCertStore
+	audit       *auditLogStore
+	apiTokens   *apiTokenStore
+}
+
+// Open opens (or creates) the BoltDB file at cfg.Path
+func Open(cfg config.BoltConfig) (*Store, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("bolt path is required")
+	}
+	if err := storage.EnsureDir(filepath.Dir(cfg.Path)); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: lockTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("storage file %s is in use (is another kproxy running?)", cfg.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage file %s: %w", cfg.Path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("failed to create bucket %s: %w", name, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	// Upgrade data written by older versions
+	if _, err := storage.Migrate(context.Background(), &schemaStore{db: db}, db, migrations); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	store := &Store{
+		db:          db,
+		schema:      &schemaStore{db: db},
+		usageStore:  &usageStore{db: db},
+		dhcpStore:   &dhcpLeaseStore{db: db},
+		reserved:    &dhcpReservationStore{db: db},
+		dnsCache:    &dnsCacheStore{db: db},
+		pending:     &pendingChangeStore{db: db},
+		blockPages:  &blockPageStore{db: db},
+		deviceRules: &deviceRuleStore{db: db},
+		ruleSets:    &ruleSetStore{db: db},
+		reports:     &reportStore{db: db},
+		pauses:      &pauseStore{db: db},
+		reloads:     newReloadBus(),
+		decisions:   &decisionStore{db: db},
+		hostnames:   &hostnameStore{db: db},
+		issued:      &issuedCertStore{db: db},
+		audit:       &auditLogStore{db: db},
+		apiTokens:   &apiTokenStore{db: db},
+	}
+
+	return store, nil
+}
+
+// Close closes the BoltDB file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SchemaVersion returns the schema version of the data in the file
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	return s.schema.SchemaVersion(ctx)
+}
+
+// Usage returns the UsageStore implementation
+func (s *Store) Usage() storage.UsageStore {
+	return s.usageStore
+}
+
+// DHCPLeases returns the DHCPLeaseStore implementation
+func (s *Store) DHCPLeases() storage.DHCPLeaseStore {
+	return s.dhcpStore
+}
+
+// DHCPReservations returns the DHCPReservationStore implementation
+func (s *Store) DHCPReservations() storage.DHCPReservationStore {
+	return s.reserved
+}
+
+// DNSCache returns the DNSCacheStore implementation
+func (s *Store) DNSCache() storage.DNSCacheStore {
+	return s.dnsCache
+}
+
+// PendingChanges returns the PendingChangeStore implementation
+func (s *Store) PendingChanges() storage.PendingChangeStore {
+	return s.pending
+}
+
+// BlockPages returns the BlockPageStore implementation
+func (s *Store) BlockPages() storage.BlockPageStore {
+	return s.blockPages
+}
+
+// DeviceRules returns the DeviceRuleStore implementation
+func (s *Store) DeviceRules() storage.DeviceRuleStore {
+	return s.deviceRules
+}
+
+// RuleSets returns the RuleSetStore implementation
+func (s *Store) RuleSets() storage.RuleSetStore {
+	return s.ruleSets
+}
+
+// Reports returns the ReportStore implementation
+func (s *Store) Reports() storage.ReportStore {
+	return s.reports
+}
+
+// Pauses returns the PauseStore implementation
+func (s *Store) Pauses() storage.PauseStore {
+	return s.pauses
+}
+
+// Reloads returns the ReloadBus implementation
+func (s *Store) Reloads() storage.ReloadBus {
+	return s.reloads
+}
+
+// Decisions returns the DecisionLogStore implementation, which also
+// implements storage.DecisionHistory
+func (s *Store) Decisions() storage.DecisionLogStore {
+	return s.decisions
+}
+
+// Hostnames returns the HostnameStore implementation
+func (s *Store) Hostnames() storage.HostnameStore {
+	return s.hostnames
+}
+
+// IssuedCerts returns the IssuedCertStore implementation
+func (s *Store) IssuedCerts() storage.IssuedCertStore {
+	return s.issued
+}
+
+// AuditLog returns the AuditLogStore implementation
+func (s *Store) AuditLog() storage.AuditLogStore {
+	return s.audit
+}
+
+// APITokens returns the APITokenStore implementation
+func (s *Store) APITokens() storage.APITokenStore {
+	return s.apiTokens
+}
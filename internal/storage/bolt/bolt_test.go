@@ -0,0 +1,415 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// setupTestStore opens a new file in a temporary directory
+func setupTestStore(t *testing.T) (*Store, config.BoltConfig) {
+	t.Helper()
+
+	cfg := config.BoltConfig{Path: filepath.Join(t.TempDir(), "kproxy.db")}
+	store, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open bolt store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store, cfg
+}
+
+func TestOpen(t *testing.T) {
+	store, cfg := setupTestStore(t)
+	ctx := context.Background()
+	if version, err := store.SchemaVersion(ctx); err != nil || version != storage.LatestVersion(migrations) {
+		t.Errorf("SchemaVersion() = %d, %v; want %d", version, err, storage.LatestVersion(migrations))
+	}
+	if err := store.BlockPages().Put(ctx, storage.BlockPage{Name: "gaming", Template: "<p>No</p>"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	// Only one process can have the file open
+	if _, err := Open(cfg); err == nil {
+		t.Fatal("Open() of a file in use succeeded")
+	}
+
+	// Records survive reopening
+	_ = store.Close()
+	reopened, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open() again = %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	if page, err := reopened.BlockPages().Get(ctx, "gaming"); err != nil || page.Template != "<p>No</p>" {
+		t.Errorf("Get after reopening = %+v (%v), want the stored page", page, err)
+	}
+}
+
+func TestOpenNewerSchema(t *testing.T) {
+	store, cfg := setupTestStore(t)
+	if err := store.schema.SetSchemaVersion(context.Background(), 1000); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	// Data upgraded by a newer KProxy isn't touched
+	if _, err := Open(cfg); !errors.Is(err, storage.ErrSchemaTooNew) {
+		t.Errorf("Open() = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestUsageStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	usage := store.Usage()
+
+	now := time.Now()
+	active := storage.UsageSession{ID: "s1", DeviceID: "dev", LimitID: "gaming", StartedAt: now, LastActivity: now, Active: true}
+	inactive := storage.UsageSession{ID: "s2", DeviceID: "dev", LimitID: "video", StartedAt: now.Add(-48 * time.Hour)}
+	for _, s := range []storage.UsageSession{active, inactive} {
+		if err := usage.UpsertSession(ctx, s); err != nil {
+			t.Fatalf("UpsertSession failed: %v", err)
+		}
+	}
+	if sessions, err := usage.ListActiveSessions(ctx); err != nil || len(sessions) != 1 || sessions[0].ID != "s1" {
+		t.Errorf("ListActiveSessions = %+v (%v), want s1", sessions, err)
+	}
+	if n, err := usage.DeleteInactiveSessionsBefore(ctx, now.Add(-24*time.Hour)); err != nil || n != 1 {
+		t.Errorf("DeleteInactiveSessionsBefore = %d (%v), want 1", n, err)
+	}
+	if _, err := usage.GetSession(ctx, "s2"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for deleted session, got %v", err)
+	}
+
+	_ = usage.IncrementDailyUsage(ctx, "2024-01-01", "dev", "gaming", 60)
+	_ = usage.IncrementDailyUsage(ctx, "2024-01-01", "dev", "gaming", 30)
+	_ = usage.IncrementDailyUsage(ctx, "2024-01-02", "dev", "gaming", 10)
+	if daily, err := usage.GetDailyUsage(ctx, "2024-01-01", "dev", "gaming"); err != nil || daily.TotalSeconds != 90 {
+		t.Errorf("GetDailyUsage = %+v (%v), want 90s", daily, err)
+	}
+	if list, err := usage.ListDailyUsage(ctx, "2024-01-02"); err != nil || len(list) != 1 || list[0].TotalSeconds != 10 {
+		t.Errorf("ListDailyUsage = %+v (%v), want one entry of 10s", list, err)
+	}
+	if n, err := usage.DeleteDailyUsageBefore(ctx, "2024-01-02"); err != nil || n != 1 {
+		t.Errorf("DeleteDailyUsageBefore = %d (%v), want 1", n, err)
+	}
+	if list, _ := usage.ListDailyUsage(ctx, "2024-01-01"); len(list) != 0 {
+		t.Errorf("ListDailyUsage after DeleteDailyUsageBefore = %+v, want none", list)
+	}
+}
+
+func TestDHCPLeaseStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	leases := store.DHCPLeases()
+
+	created := time.Now().Add(-time.Hour)
+	lease := &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.100", ExpiresAt: time.Now().Add(time.Hour), CreatedAt: created}
+	if err := leases.Create(ctx, lease); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Renewal with a new address keeps the creation time and moves the index
+	renewed := &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.101", ExpiresAt: time.Now().Add(2 * time.Hour)}
+	if err := leases.Create(ctx, renewed); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if got, err := leases.GetByIP(ctx, "192.168.1.101"); err != nil || !got.CreatedAt.Equal(created) {
+		t.Errorf("GetByIP = %+v (%v), want the renewed lease created at %v", got, err, created)
+	}
+	if _, err := leases.GetByIP(ctx, "192.168.1.100"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for the old address, got %v", err)
+	}
+
+	expired := &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:02", IP: "192.168.1.102", ExpiresAt: time.Now().Add(-time.Minute)}
+	_ = leases.Create(ctx, expired)
+	if list, err := leases.List(ctx); err != nil || len(list) != 2 {
+		t.Errorf("List = %+v (%v), want two leases", list, err)
+	}
+	if n, err := leases.DeleteExpired(ctx); err != nil || n != 1 {
+		t.Errorf("DeleteExpired = %d (%v), want 1", n, err)
+	}
+	if _, err := leases.GetByIP(ctx, "192.168.1.102"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for the expired lease, got %v", err)
+	}
+
+	if err := leases.Delete(ctx, "aa:bb:cc:dd:ee:01"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := leases.Get(ctx, "aa:bb:cc:dd:ee:01"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func TestDHCPReservationStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	reservations := store.DHCPReservations()
+
+	_ = reservations.Put(ctx, storage.DHCPReservation{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.60"})
+	_ = reservations.Put(ctx, storage.DHCPReservation{MAC: "aa:bb:cc:dd:ee:02", IP: "192.168.1.50"})
+	if list, err := reservations.List(ctx); err != nil || len(list) != 2 || list[0].IP != "192.168.1.50" {
+		t.Errorf("List = %+v (%v), want both sorted by IP address", list, err)
+	}
+	if err := reservations.Delete(ctx, "aa:bb:cc:dd:ee:01"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := reservations.Delete(ctx, "aa:bb:cc:dd:ee:01"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestDNSCacheStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	cache := store.DNSCache()
+
+	_ = cache.Set(ctx, "fresh", []byte("answer"), time.Hour)
+	_ = cache.Set(ctx, "stale", []byte("old"), -time.Second)
+	if value, err := cache.Get(ctx, "fresh"); err != nil || string(value) != "answer" {
+		t.Errorf("Get = %q (%v), want answer", value, err)
+	}
+	if _, err := cache.Get(ctx, "stale"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired entry, got %v", err)
+	}
+}
+
+func TestPendingChangeStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	pending := store.PendingChanges()
+
+	now := time.Now()
+	_ = pending.Create(ctx, storage.PendingChange{ID: "expired", RequestedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = pending.Create(ctx, storage.PendingChange{ID: "second", RequestedAt: now, ExpiresAt: now.Add(time.Hour)})
+	_ = pending.Create(ctx, storage.PendingChange{ID: "first", RequestedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)})
+
+	changes, err := pending.List(ctx)
+	if err != nil || len(changes) != 2 || changes[0].ID != "first" || changes[1].ID != "second" {
+		t.Errorf("List = %+v (%v), want the unexpired changes oldest first", changes, err)
+	}
+	if _, err := pending.Get(ctx, "expired"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired change, got %v", err)
+	}
+	if err := pending.Delete(ctx, "first"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := pending.Delete(ctx, "first"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestDecisionStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	decisions := store.Decisions()
+	history := decisions.(storage.DecisionHistory)
+
+	now := time.Now()
+	_ = decisions.Put(ctx, storage.Decision{ID: "expired", Kind: "dns", Time: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = decisions.Put(ctx, storage.Decision{ID: "dns", Kind: "dns", Time: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour), Input: []byte(`{"client_ip":"10.0.0.5","domain":"example.com"}`)})
+	if err := decisions.Put(ctx, storage.Decision{ID: "proxy", Kind: "proxy", Time: now, ExpiresAt: now.Add(time.Hour), Input: []byte(`{"client_ip":"10.0.0.6","host":"example.com"}`)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if d, err := decisions.Get(ctx, "proxy"); err != nil || string(d.Input) != `{"client_ip":"10.0.0.6","host":"example.com"}` {
+		t.Errorf("Get = %+v (%v), want the recorded decision", d, err)
+	}
+	if _, err := decisions.Get(ctx, "expired"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired decision, got %v", err)
+	}
+
+	if list, err := history.List(ctx, storage.DecisionFilter{}); err != nil || len(list) != 2 || list[0].ID != "proxy" {
+		t.Errorf("List() = %+v (%v), want proxy then dns", list, err)
+	}
+	if list, _ := history.List(ctx, storage.DecisionFilter{Host: "example.com", Limit: 1}); len(list) != 1 || list[0].ID != "proxy" {
+		t.Errorf("List() by host = %+v, want proxy only", list)
+	}
+	if list, _ := history.List(ctx, storage.DecisionFilter{Client: "10.0.0.5"}); len(list) != 1 || list[0].ID != "dns" {
+		t.Errorf("List() by client = %+v, want dns", list)
+	}
+}
+
+func TestHostnameStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	hostnames := store.Hostnames()
+
+	now := time.Now()
+	_ = hostnames.Put(ctx, storage.Hostname{IP: "192.168.5.142", Name: "Liams-iPad", Source: "mdns", Seen: now, ExpiresAt: now.Add(time.Hour)})
+	_ = hostnames.Put(ctx, storage.Hostname{IP: "192.168.5.20", Name: "old-laptop", Source: "netbios", Seen: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = hostnames.Put(ctx, storage.Hostname{IP: "192.168.5.142", Name: "Liams-iPad-2", Source: "dhcp", Seen: now, ExpiresAt: now.Add(time.Hour)})
+
+	if list, err := hostnames.List(ctx); err != nil || len(list) != 1 || list[0].Name != "Liams-iPad-2" {
+		t.Errorf("List = %+v (%v), want only the replaced unexpired hostname", list, err)
+	}
+}
+
+func TestIssuedCertStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	issued := store.IssuedCerts()
+
+	now := time.Now()
+	_ = issued.Add(ctx, storage.IssuedCert{Serial: "01", Host: "old.example", Issued: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = issued.Add(ctx, storage.IssuedCert{Serial: "02", Host: "example.com", Client: "192.168.1.20", Issued: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)})
+	_ = issued.Add(ctx, storage.IssuedCert{Serial: "03", Host: "example.org", Issued: now, ExpiresAt: now.Add(time.Hour)})
+
+	if list, err := issued.List(ctx); err != nil || len(list) != 2 || list[0].Serial != "03" || list[1].Client != "192.168.1.20" {
+		t.Errorf("List = %+v (%v), want the unexpired records newest first", list, err)
+	}
+}
+
+func TestAuditLogStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	audit := store.AuditLog()
+
+	now := time.Now()
+	_ = audit.Add(ctx, storage.AuditEntry{ID: "01", Account: "admin", Time: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = audit.Add(ctx, storage.AuditEntry{ID: "02", Account: "parent", Time: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)})
+	_ = audit.Add(ctx, storage.AuditEntry{ID: "03", Account: "admin", Time: now, ExpiresAt: now.Add(time.Hour)})
+
+	if list, err := audit.List(ctx); err != nil || len(list) != 2 || list[0].ID != "03" || list[1].Account != "parent" {
+		t.Errorf("List = %+v (%v), want the unexpired entries newest first", list, err)
+	}
+}
+
+func TestBlockPageStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	pages := store.BlockPages()
+
+	for _, name := range []string{"gaming", "family"} {
+		if err := pages.Put(ctx, storage.BlockPage{Name: name, Template: "<p>{{.Reason}}</p>", UpdatedAt: time.Now()}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if list, err := pages.List(ctx); err != nil || len(list) != 2 || list[0].Name != "family" || list[1].Name != "gaming" {
+		t.Errorf("List = %+v (%v), want family and gaming", list, err)
+	}
+	if err := pages.Delete(ctx, "gaming"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := pages.Get(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+	if err := pages.Delete(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestDeviceRuleStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	rules := store.DeviceRules()
+
+	created := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	for i, id := range []string{"device-rule-2", "device-rule-1"} {
+		rule := storage.DeviceRule{ID: id, Device: "tablet", Domain: "roblox.com", Action: "allow", Created: created.Add(time.Duration(-i) * time.Minute)}
+		if err := rules.Put(ctx, rule); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if list, err := rules.List(ctx); err != nil || len(list) != 2 || list[0].ID != "device-rule-1" {
+		t.Errorf("List = %+v (%v), want device-rule-1 then device-rule-2", list, err)
+	}
+	if err := rules.Delete(ctx, "device-rule-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := rules.Delete(ctx, "device-rule-1"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestPauseStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	pauses := store.Pauses()
+
+	created := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	_ = pauses.Put(ctx, storage.Pause{Kind: storage.PauseProfile, Target: "child", Created: created})
+	_ = pauses.Put(ctx, storage.Pause{Kind: storage.PauseDevice, Target: "child", Created: created.Add(-time.Minute)})
+	if list, err := pauses.List(ctx); err != nil || len(list) != 2 || list[0].Kind != storage.PauseDevice {
+		t.Errorf("List = %+v (%v), want the device then the profile pause", list, err)
+	}
+	if err := pauses.Delete(ctx, storage.PauseDevice, "child"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := pauses.Delete(ctx, storage.PauseDevice, "child"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestRuleSetStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	sets := store.RuleSets()
+
+	for _, id := range []string{"social-media", "gaming"} {
+		if err := sets.Put(ctx, storage.RuleSet{ID: id, Name: id, UpdatedAt: time.Now()}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if list, err := sets.List(ctx); err != nil || len(list) != 2 || list[0].ID != "gaming" {
+		t.Errorf("List = %+v (%v), want gaming and social-media", list, err)
+	}
+	if err := sets.Delete(ctx, "gaming"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := sets.Get(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func TestReportStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	reports := store.Reports()
+
+	for _, date := range []string{"2026-03-01", "2026-03-02", "2026-03-02"} {
+		day := storage.ReportDay{
+			Date:       date,
+			Profile:    "child",
+			Categories: map[string]storage.ReportCategory{"video": {Requests: 3, Minutes: 1}},
+			Sites:      map[string]int{"youtube.com": 3},
+		}
+		if err := reports.Add(ctx, day); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if day, err := reports.Get(ctx, "2026-03-02", "child"); err != nil || day.Categories["video"].Requests != 6 || day.Sites["youtube.com"] != 6 {
+		t.Errorf("Get = %+v (%v), want the counts added twice", day, err)
+	}
+	if n, err := reports.DeleteBefore(ctx, "2026-03-02"); err != nil || n != 1 {
+		t.Errorf("DeleteBefore = %d (%v), want 1", n, err)
+	}
+	if _, err := reports.Get(ctx, "2026-03-01", "child"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after DeleteBefore, got %v", err)
+	}
+}
+
+func TestAPITokenStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	ctx := context.Background()
+	tokens := store.APITokens()
+
+	created := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	_ = tokens.Put(ctx, storage.APIToken{ID: "b", Name: "home-assistant", Scopes: []string{"read"}, Created: created})
+	_ = tokens.Put(ctx, storage.APIToken{ID: "a", Name: "backup", Scopes: []string{"*"}, Created: created.Add(-time.Hour)})
+	if list, err := tokens.List(ctx); err != nil || len(list) != 2 || list[0].Name != "backup" {
+		t.Errorf("List = %+v (%v), want backup then home-assistant", list, err)
+	}
+	if err := tokens.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := tokens.Delete(ctx, "a"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type decisionStore struct {
+	db *bolt.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// Put stores a decision until ExpiresAt. Expired decisions are swept on
+// write (at most once a minute), and concurrent writes are batched into one
+// transaction.
+func (s *decisionStore) Put(ctx context.Context, decision storage.Decision) error {
+	now := time.Now()
+	if !now.Before(decision.ExpiresAt) {
+		return nil
+	}
+	sweep := s.sweepDue(now)
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(decisionsBucket)
+		if sweep {
+			if err := deleteExpired(b, now, decisionExpiry); err != nil {
+				return err
+			}
+		}
+		return putJSON(b, decision.ID, decision)
+	})
+}
+
+// sweepDue reports whether expired decisions should be swept, at most once
+// every sweepInterval
+func (s *decisionStore) sweepDue(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) <= sweepInterval {
+		return false
+	}
+	s.lastSweep = now
+	return true
+}
+
+// Get retrieves an unexpired decision
+func (s *decisionStore) Get(ctx context.Context, id string) (*storage.Decision, error) {
+	var decision storage.Decision
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(decisionsBucket), id, &decision)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(decision.ExpiresAt) {
+		return nil, storage.ErrNotFound
+	}
+	return &decision, nil
+}
+
+// List returns the unexpired decisions matching filter, newest first
+func (s *decisionStore) List(ctx context.Context, filter storage.DecisionFilter) ([]storage.Decision, error) {
+	now := time.Now()
+	decisions := make([]storage.Decision, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(decisionsBucket).ForEach(func(k, v []byte) error {
+			var decision storage.Decision
+			if err := json.Unmarshal(v, &decision); err != nil {
+				return err
+			}
+			if now.Before(decision.ExpiresAt) && filter.Match(decision) {
+				decisions = append(decisions, decision)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(decisions, func(i, j int) bool {
+		return decisions[i].Time.After(decisions[j].Time)
+	})
+	if filter.Limit > 0 && len(decisions) > filter.Limit {
+		decisions = decisions[:filter.Limit]
+	}
+	return decisions, nil
+}
+
+// decisionExpiry reads the expiry of a stored decision
+func decisionExpiry(v []byte) (time.Time, error) {
+	var decision struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	err := json.Unmarshal(v, &decision)
+	return decision.ExpiresAt, err
+}
@@ -0,0 +1,47 @@
+package bolt
+
+import (
+	"context"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type deviceRuleStore struct {
+	db *bolt.DB
+}
+
+// List returns all device rules, oldest first
+func (s *deviceRuleStore) List(ctx context.Context) ([]storage.DeviceRule, error) {
+	var rules []storage.DeviceRule
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rules, err = listJSON[storage.DeviceRule](tx.Bucket(deviceRulesBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if !rules[i].Created.Equal(rules[j].Created) {
+			return rules[i].Created.Before(rules[j].Created)
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+// Put creates or replaces a device rule
+func (s *deviceRuleStore) Put(ctx context.Context, rule storage.DeviceRule) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(deviceRulesBucket), rule.ID, rule)
+	})
+}
+
+// Delete removes a device rule
+func (s *deviceRuleStore) Delete(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteRecord(tx.Bucket(deviceRulesBucket), id)
+	})
+}
@@ -0,0 +1,164 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// dhcpLeaseStore keeps leases by MAC address in dhcpLeasesBucket, and the
+// MAC address holding each IP address in dhcpLeaseIPsBucket
+type dhcpLeaseStore struct {
+	db *bolt.DB
+}
+
+// Get retrieves a DHCP lease by MAC address
+func (s *dhcpLeaseStore) Get(ctx context.Context, mac string) (*storage.DHCPLease, error) {
+	return s.GetByMAC(ctx, mac)
+}
+
+// GetByMAC retrieves a DHCP lease by MAC address
+func (s *dhcpLeaseStore) GetByMAC(ctx context.Context, mac string) (*storage.DHCPLease, error) {
+	var lease storage.DHCPLease
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(dhcpLeasesBucket), mac, &lease)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+// GetByIP retrieves a DHCP lease by IP address
+func (s *dhcpLeaseStore) GetByIP(ctx context.Context, ip string) (*storage.DHCPLease, error) {
+	var lease storage.DHCPLease
+	err := s.db.View(func(tx *bolt.Tx) error {
+		mac := tx.Bucket(dhcpLeaseIPsBucket).Get([]byte(ip))
+		if mac == nil {
+			return storage.ErrNotFound
+		}
+		return getJSON(tx.Bucket(dhcpLeasesBucket), string(mac), &lease)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+// List retrieves all DHCP leases
+func (s *dhcpLeaseStore) List(ctx context.Context) ([]storage.DHCPLease, error) {
+	var leases []storage.DHCPLease
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		leases, err = listJSON[storage.DHCPLease](tx.Bucket(dhcpLeasesBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
+
+// Create creates or updates a DHCP lease
+func (s *dhcpLeaseStore) Create(ctx context.Context, lease *storage.DHCPLease) error {
+	now := time.Now()
+	if lease.UpdatedAt.IsZero() {
+		lease.UpdatedAt = now
+	}
+	if lease.CreatedAt.IsZero() {
+		lease.CreatedAt = now
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		leases := tx.Bucket(dhcpLeasesBucket)
+		ips := tx.Bucket(dhcpLeaseIPsBucket)
+
+		// Preserve the original creation time and drop a stale IP index on renewal
+		var existing storage.DHCPLease
+		switch err := getJSON(leases, lease.MAC, &existing); err {
+		case nil:
+			lease.CreatedAt = existing.CreatedAt
+			if existing.IP != lease.IP {
+				if err := dropIPIndex(ips, existing); err != nil {
+					return err
+				}
+			}
+		case storage.ErrNotFound:
+		default:
+			return err
+		}
+
+		if err := putJSON(leases, lease.MAC, lease); err != nil {
+			return err
+		}
+		return ips.Put([]byte(lease.IP), []byte(lease.MAC))
+	})
+}
+
+// Delete deletes a DHCP lease by MAC address
+func (s *dhcpLeaseStore) Delete(ctx context.Context, mac string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		leases := tx.Bucket(dhcpLeasesBucket)
+
+		var lease storage.DHCPLease
+		switch err := getJSON(leases, mac, &lease); err {
+		case nil:
+		case storage.ErrNotFound:
+			return nil
+		default:
+			return err
+		}
+		if err := dropIPIndex(tx.Bucket(dhcpLeaseIPsBucket), lease); err != nil {
+			return err
+		}
+		return leases.Delete([]byte(mac))
+	})
+}
+
+// DeleteExpired deletes expired DHCP leases
+func (s *dhcpLeaseStore) DeleteExpired(ctx context.Context) (int, error) {
+	var deleted int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		leases := tx.Bucket(dhcpLeasesBucket)
+		ips := tx.Bucket(dhcpLeaseIPsBucket)
+
+		var expired []storage.DHCPLease
+		err := leases.ForEach(func(k, v []byte) error {
+			var lease storage.DHCPLease
+			if err := json.Unmarshal(v, &lease); err != nil {
+				return err
+			}
+			if lease.IsExpired() {
+				expired = append(expired, lease)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, lease := range expired {
+			if err := dropIPIndex(ips, lease); err != nil {
+				return err
+			}
+			if err := leases.Delete([]byte(lease.MAC)); err != nil {
+				return err
+			}
+		}
+		deleted = len(expired)
+		return nil
+	})
+	return deleted, err
+}
+
+// dropIPIndex removes the IP index entry for a lease unless another MAC now owns the IP
+func dropIPIndex(ips *bolt.Bucket, lease storage.DHCPLease) error {
+	if !bytes.Equal(ips.Get([]byte(lease.IP)), []byte(lease.MAC)) {
+		return nil
+	}
+	return ips.Delete([]byte(lease.IP))
+}
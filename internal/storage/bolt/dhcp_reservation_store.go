@@ -0,0 +1,54 @@
+package bolt
+
+import (
+	"context"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type dhcpReservationStore struct {
+	db *bolt.DB
+}
+
+// Get retrieves the reservation of a client
+func (s *dhcpReservationStore) Get(ctx context.Context, mac string) (*storage.DHCPReservation, error) {
+	var reservation storage.DHCPReservation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(dhcpReservationsBucket), mac, &reservation)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// List returns all reservations sorted by IP address
+func (s *dhcpReservationStore) List(ctx context.Context) ([]storage.DHCPReservation, error) {
+	var reservations []storage.DHCPReservation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		reservations, err = listJSON[storage.DHCPReservation](tx.Bucket(dhcpReservationsBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].IP < reservations[j].IP })
+	return reservations, nil
+}
+
+// Put creates or replaces the reservation of a client
+func (s *dhcpReservationStore) Put(ctx context.Context, reservation storage.DHCPReservation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(dhcpReservationsBucket), reservation.MAC, reservation)
+	})
+}
+
+// Delete removes the reservation of a client
+func (s *dhcpReservationStore) Delete(ctx context.Context, mac string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteRecord(tx.Bucket(dhcpReservationsBucket), mac)
+	})
+}
@@ -0,0 +1,97 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// sweepInterval is how often expired DNS responses and decisions are
+// deleted, on write
+const sweepInterval = time.Minute
+
+type dnsCacheEntry struct {
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type dnsCacheStore struct {
+	db *bolt.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// Get retrieves a cached DNS response
+func (s *dnsCacheStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var entry dnsCacheEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(dnsCacheBucket), key, &entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(entry.ExpiresAt) {
+		return nil, storage.ErrNotFound
+	}
+	return entry.Value, nil
+}
+
+// Set stores a DNS response. Expired entries are swept on write (at most
+// once a minute) so the file cannot grow without bound. Concurrent writes
+// are batched into one transaction.
+func (s *dnsCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	sweep := s.sweepDue(now)
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dnsCacheBucket)
+		if sweep {
+			if err := deleteExpired(b, now, func(v []byte) (time.Time, error) {
+				var entry dnsCacheEntry
+				err := json.Unmarshal(v, &entry)
+				return entry.ExpiresAt, err
+			}); err != nil {
+				return err
+			}
+		}
+		return putJSON(b, key, dnsCacheEntry{Value: value, ExpiresAt: now.Add(ttl)})
+	})
+}
+
+// sweepDue reports whether expired entries should be swept, at most once
+// every sweepInterval
+func (s *dnsCacheStore) sweepDue(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) <= sweepInterval {
+		return false
+	}
+	s.lastSweep = now
+	return true
+}
+
+// deleteExpired removes the records of a bucket that expire by now,
+// reading each record's expiry with expiresAt
+func deleteExpired(b *bolt.Bucket, now time.Time, expiresAt func(v []byte) (time.Time, error)) error {
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		t, err := expiresAt(v)
+		if err != nil {
+			return err
+		}
+		if !now.Before(t) {
+			keys = append(keys, bytes.Clone(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteKeys(b, keys)
+}
@@ -0,0 +1,101 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// getJSON decodes the record at key into v, returning storage.ErrNotFound
+// when there is none
+func getJSON(b *bolt.Bucket, key string, v any) error {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return storage.ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+// putJSON stores v as JSON at key
+func putJSON(b *bolt.Bucket, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
+
+// listJSON decodes every record of a bucket, in key order
+func listJSON[T any](b *bolt.Bucket) ([]T, error) {
+	records := make([]T, 0)
+	err := b.ForEach(func(k, v []byte) error {
+		var record T
+		if err := json.Unmarshal(v, &record); err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// deleteRecord removes the record at key, returning storage.ErrNotFound
+// when there is none
+func deleteRecord(b *bolt.Bucket, key string) error {
+	if b.Get([]byte(key)) == nil {
+		return storage.ErrNotFound
+	}
+	return b.Delete([]byte(key))
+}
+
+// deleteKeys removes keys from a bucket; bolt doesn't allow deleting while
+// iterating with ForEach, so callers collect the keys first
+func deleteKeys(b *bolt.Bucket, keys [][]byte) error {
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendJSON stores v under the bucket's next sequence number, so
+// iterating the bucket visits records oldest first
+func appendJSON(b *bolt.Bucket, v any) error {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return b.Put(key, data)
+}
+
+// listAppended decodes the records appendJSON stored in a bucket, newest
+// first, deleting the ones that have expired by expiresAt
+func listAppended[T any](b *bolt.Bucket, expiresAt func(T) time.Time) ([]T, error) {
+	now := time.Now()
+	records := make([]T, 0)
+	var expired [][]byte
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		var record T
+		if err := json.Unmarshal(v, &record); err != nil {
+			return nil, err
+		}
+		if !now.Before(expiresAt(record)) {
+			expired = append(expired, bytes.Clone(k))
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, deleteKeys(b, expired)
+}
@@ -0,0 +1,45 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type hostnameStore struct {
+	db *bolt.DB
+}
+
+// Put creates or replaces the hostname of a client
+func (s *hostnameStore) Put(ctx context.Context, hostname storage.Hostname) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(hostnamesBucket), hostname.IP, hostname)
+	})
+}
+
+// List returns the unexpired hostnames sorted by IP address, removing the
+// expired ones
+func (s *hostnameStore) List(ctx context.Context) ([]storage.Hostname, error) {
+	var hostnames []storage.Hostname
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(hostnamesBucket)
+		if err := deleteExpired(b, time.Now(), func(v []byte) (time.Time, error) {
+			var hostname storage.Hostname
+			err := json.Unmarshal(v, &hostname)
+			return hostname.ExpiresAt, err
+		}); err != nil {
+			return err
+		}
+		// Keyed by IP address, so already in order
+		var err error
+		hostnames, err = listJSON[storage.Hostname](b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hostnames, nil
+}
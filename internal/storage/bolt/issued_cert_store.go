@@ -0,0 +1,37 @@
+package bolt
+
+import (
+	"context"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// issuedCertStore keeps records under sequence numbers, oldest first
+type issuedCertStore struct {
+	db *bolt.DB
+}
+
+// Add records a minted certificate
+func (s *issuedCertStore) Add(ctx context.Context, cert storage.IssuedCert) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return appendJSON(tx.Bucket(issuedCertsBucket), cert)
+	})
+}
+
+// List returns the unexpired records newest first, removing the expired ones
+func (s *issuedCertStore) List(ctx context.Context) ([]storage.IssuedCert, error) {
+	var certs []storage.IssuedCert
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		certs, err = listAppended(tx.Bucket(issuedCertsBucket), func(cert storage.IssuedCert) time.Time {
+			return cert.ExpiresAt
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
@@ -0,0 +1,44 @@
+package bolt
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// schemaVersionKey holds the schema version of the data in schemaBucket
+var schemaVersionKey = []byte("version")
+
+// migrations upgrade data written by older versions of KProxy. Add a step
+// at the end whenever a bucket layout or stored field changes; never change
+// or remove a released one.
+var migrations []storage.Migration[*bolt.DB]
+
+// schemaStore keeps the schema version in schemaBucket
+type schemaStore struct {
+	db *bolt.DB
+}
+
+// SchemaVersion returns the stored schema version, 0 when none is recorded
+func (s *schemaStore) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(schemaBucket).Get(schemaVersionKey)
+		if v == nil {
+			return nil
+		}
+		var err error
+		version, err = strconv.Atoi(string(v))
+		return err
+	})
+	return version, err
+}
+
+// SetSchemaVersion records the schema version
+func (s *schemaStore) SetSchemaVersion(ctx context.Context, version int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(schemaBucket).Put(schemaVersionKey, []byte(strconv.Itoa(version)))
+	})
+}
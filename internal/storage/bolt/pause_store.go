@@ -0,0 +1,55 @@
+package bolt
+
+import (
+	"context"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type pauseStore struct {
+	db *bolt.DB
+}
+
+// pauseKey is the key of the pause of a device or profile
+func pauseKey(kind, target string) string {
+	return kind + "/" + target
+}
+
+// List returns all pauses, oldest first
+func (s *pauseStore) List(ctx context.Context) ([]storage.Pause, error) {
+	var pauses []storage.Pause
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		pauses, err = listJSON[storage.Pause](tx.Bucket(pausesBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pauses, func(i, j int) bool {
+		if !pauses[i].Created.Equal(pauses[j].Created) {
+			return pauses[i].Created.Before(pauses[j].Created)
+		}
+		if pauses[i].Kind != pauses[j].Kind {
+			return pauses[i].Kind < pauses[j].Kind
+		}
+		return pauses[i].Target < pauses[j].Target
+	})
+	return pauses, nil
+}
+
+// Put creates or replaces the pause of a device or profile
+func (s *pauseStore) Put(ctx context.Context, pause storage.Pause) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(pausesBucket), pauseKey(pause.Kind, pause.Target), pause)
+	})
+}
+
+// Delete removes the pause of a device or profile
+func (s *pauseStore) Delete(ctx context.Context, kind, target string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteRecord(tx.Bucket(pausesBucket), pauseKey(kind, target))
+	})
+}
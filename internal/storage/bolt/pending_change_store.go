@@ -0,0 +1,81 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type pendingChangeStore struct {
+	db *bolt.DB
+}
+
+// Create stores a pending change
+func (s *pendingChangeStore) Create(ctx context.Context, change storage.PendingChange) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(pendingChangesBucket), change.ID, change)
+	})
+}
+
+// Get retrieves an unexpired pending change
+func (s *pendingChangeStore) Get(ctx context.Context, id string) (*storage.PendingChange, error) {
+	var change storage.PendingChange
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(pendingChangesBucket), id, &change)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(change.ExpiresAt) {
+		return nil, storage.ErrNotFound
+	}
+	return &change, nil
+}
+
+// List returns the unexpired pending changes, oldest first, dropping
+// expired ones
+func (s *pendingChangeStore) List(ctx context.Context) ([]storage.PendingChange, error) {
+	var changes []storage.PendingChange
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pendingChangesBucket)
+		if err := deleteExpired(b, time.Now(), pendingChangeExpiry); err != nil {
+			return err
+		}
+		var err error
+		changes, err = listJSON[storage.PendingChange](b)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].RequestedAt.Before(changes[j].RequestedAt) })
+	return changes, nil
+}
+
+// Delete removes a pending change
+func (s *pendingChangeStore) Delete(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pendingChangesBucket)
+
+		var change storage.PendingChange
+		if err := getJSON(b, id, &change); err != nil {
+			return err
+		}
+		// Expired changes are left for List to drop
+		if !time.Now().Before(change.ExpiresAt) {
+			return storage.ErrNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// pendingChangeExpiry reads the expiry of a stored change
+func pendingChangeExpiry(v []byte) (time.Time, error) {
+	var change storage.PendingChange
+	err := json.Unmarshal(v, &change)
+	return change.ExpiresAt, err
+}
@@ -0,0 +1,59 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// reportStore keeps the counts of each day and profile keyed by
+// date/profile, so keys sort by date first
+type reportStore struct {
+	db *bolt.DB
+}
+
+// Add adds to the counts of a day and profile
+func (s *reportStore) Add(ctx context.Context, day storage.ReportDay) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(reportsBucket)
+		key := day.Date + "/" + day.Profile
+
+		total := storage.ReportDay{Date: day.Date, Profile: day.Profile}
+		if err := getJSON(b, key, &total); err != nil && err != storage.ErrNotFound {
+			return err
+		}
+		total.Merge(day)
+		return putJSON(b, key, total)
+	})
+}
+
+// Get retrieves the counts of a day and profile
+func (s *reportStore) Get(ctx context.Context, date, profile string) (*storage.ReportDay, error) {
+	var day storage.ReportDay
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(reportsBucket), date+"/"+profile, &day)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &day, nil
+}
+
+// DeleteBefore deletes the counts of days before cutoffDate
+func (s *reportStore) DeleteBefore(ctx context.Context, cutoffDate string) (int, error) {
+	var deleted int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(reportsBucket)
+
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < cutoffDate; k, _ = c.Next() {
+			keys = append(keys, bytes.Clone(k))
+		}
+		deleted = len(keys)
+		return deleteKeys(b, keys)
+	})
+	return deleted, err
+}
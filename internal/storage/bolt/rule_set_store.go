@@ -0,0 +1,53 @@
+package bolt
+
+import (
+	"context"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type ruleSetStore struct {
+	db *bolt.DB
+}
+
+// Get retrieves a rule set
+func (s *ruleSetStore) Get(ctx context.Context, id string) (*storage.RuleSet, error) {
+	var set storage.RuleSet
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(ruleSetsBucket), id, &set)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// List returns all rule sets sorted by ID
+func (s *ruleSetStore) List(ctx context.Context) ([]storage.RuleSet, error) {
+	var sets []storage.RuleSet
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Keyed by ID, so already in order
+		var err error
+		sets, err = listJSON[storage.RuleSet](tx.Bucket(ruleSetsBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+// Put creates or replaces a rule set
+func (s *ruleSetStore) Put(ctx context.Context, set storage.RuleSet) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(ruleSetsBucket), set.ID, set)
+	})
+}
+
+// Delete removes a rule set
+func (s *ruleSetStore) Delete(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteRecord(tx.Bucket(ruleSetsBucket), id)
+	})
+}
@@ -0,0 +1,160 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+type usageStore struct {
+	db *bolt.DB
+}
+
+// dailyUsageKey is the key of daily usage: date/device/limit. Dates are
+// YYYY-MM-DD, so keys sort by date first.
+func dailyUsageKey(date, deviceID, limitID string) string {
+	return date + "/" + deviceID + "/" + limitID
+}
+
+// UpsertSession creates or updates a usage session
+func (s *usageStore) UpsertSession(ctx context.Context, session storage.UsageSession) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(usageSessionsBucket), session.ID, session)
+	})
+}
+
+// DeleteSession removes a session by ID
+func (s *usageStore) DeleteSession(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(usageSessionsBucket).Delete([]byte(id))
+	})
+}
+
+// GetSession retrieves a session by ID
+func (s *usageStore) GetSession(ctx context.Context, id string) (*storage.UsageSession, error) {
+	var session storage.UsageSession
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(usageSessionsBucket), id, &session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListActiveSessions returns all active sessions
+func (s *usageStore) ListActiveSessions(ctx context.Context) ([]storage.UsageSession, error) {
+	var sessions []storage.UsageSession
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		sessions, err = listJSON[storage.UsageSession](tx.Bucket(usageSessionsBucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	active := sessions[:0]
+	for _, session := range sessions {
+		if session.Active {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+// GetDailyUsage retrieves daily usage for a specific date, device, and limit
+func (s *usageStore) GetDailyUsage(ctx context.Context, date string, deviceID, limitID string) (*storage.DailyUsage, error) {
+	var usage storage.DailyUsage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(dailyUsageBucket), dailyUsageKey(date, deviceID, limitID), &usage)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// ListDailyUsage returns all daily usage entries for a specific date
+func (s *usageStore) ListDailyUsage(ctx context.Context, date string) ([]storage.DailyUsage, error) {
+	usages := make([]storage.DailyUsage, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(date + "/")
+		c := tx.Bucket(dailyUsageBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var usage storage.DailyUsage
+			if err := json.Unmarshal(v, &usage); err != nil {
+				return err
+			}
+			usages = append(usages, usage)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
+
+// IncrementDailyUsage increments (or creates) daily usage
+func (s *usageStore) IncrementDailyUsage(ctx context.Context, date string, deviceID, limitID string, seconds int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dailyUsageBucket)
+		key := dailyUsageKey(date, deviceID, limitID)
+
+		usage := storage.DailyUsage{Date: date, DeviceID: deviceID, LimitID: limitID}
+		if err := getJSON(b, key, &usage); err != nil && err != storage.ErrNotFound {
+			return err
+		}
+		usage.TotalSeconds += seconds
+		return putJSON(b, key, usage)
+	})
+}
+
+// DeleteDailyUsageBefore deletes daily usage entries before the specified date
+func (s *usageStore) DeleteDailyUsageBefore(ctx context.Context, cutoffDate string) (int, error) {
+	var deleted int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dailyUsageBucket)
+
+		// Keys start with the date, so the old entries come first
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < cutoffDate; k, _ = c.Next() {
+			keys = append(keys, bytes.Clone(k))
+		}
+		deleted = len(keys)
+		return deleteKeys(b, keys)
+	})
+	return deleted, err
+}
+
+// DeleteInactiveSessionsBefore deletes inactive sessions started before the specified time
+func (s *usageStore) DeleteInactiveSessionsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	var deleted int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usageSessionsBucket)
+
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var session storage.UsageSession
+			if err := json.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			if !session.Active && session.StartedAt.Before(cutoff) {
+				keys = append(keys, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		deleted = len(keys)
+		return deleteKeys(b, keys)
+	})
+	return deleted, err
+}
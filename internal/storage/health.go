@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+// Pinger is implemented by stores whose backend can become unreachable.
+// Ping returns an error when the backend doesn't answer.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Health is the state of the storage backend
+type Health struct {
+	Healthy   bool      `json:"healthy"`
+	Since     time.Time `json:"since"`                // When it last became healthy or unhealthy
+	LastProbe time.Time `json:"last_probe,omitempty"` // Zero for backends that aren't probed
+	Error     string    `json:"error,omitempty"`      // Why the last probe failed
+}
+
+// MonitorConfig holds storage health monitor configuration
+type MonitorConfig struct {
+	Interval time.Duration // Time between probes
+	Timeout  time.Duration // Time allowed for each probe
+}
+
+// Monitor probes the storage backend in the background, so callers can
+// skip storage while it is unreachable instead of waiting on every request
+// for it to time out. Stores that aren't Pingers are always healthy.
+type Monitor struct {
+	pinger   Pinger
+	interval time.Duration
+	timeout  time.Duration
+	logger   zerolog.Logger
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+
+	mu     sync.RWMutex
+	health Health
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewMonitor creates a monitor of store
+func NewMonitor(store Store, cfg MonitorConfig, logger zerolog.Logger) *Monitor {
+	m := &Monitor{
+		interval: cfg.Interval,
+		timeout:  cfg.Timeout,
+		logger:   logger.With().Str("component", "storage-health").Logger(),
+		stopChan: make(chan struct{}),
+		now:      time.Now,
+	}
+	m.pinger, _ = store.(Pinger)
+	m.health = Health{Healthy: true, Since: m.now()}
+	metrics.StorageUp.Set(1)
+	return m
+}
+
+// Start begins probing every interval
+func (m *Monitor) Start() {
+	if m.pinger == nil {
+		return
+	}
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info().
+		Dur("interval", m.interval).
+		Msg("Storage health checks started")
+}
+
+// Stop stops probing and waits for a running probe to finish
+func (m *Monitor) Stop() {
+	close(m.stopChan)
+	m.wg.Wait()
+}
+
+// run is the background probe loop
+func (m *Monitor) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Probe()
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// Probe pings the backend once, updating the health and metrics
+func (m *Monitor) Probe() Health {
+	if m.pinger == nil {
+		return m.Health()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := m.pinger.Ping(ctx)
+	metrics.StorageProbeDuration.Observe(time.Since(start).Seconds())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.health.LastProbe = now
+	if err != nil {
+		metrics.StorageProbeFailures.Inc()
+		metrics.StorageUp.Set(0)
+		m.health.Error = err.Error()
+		if m.health.Healthy {
+			m.health.Healthy = false
+			m.health.Since = now
+			m.logger.Warn().Err(err).Msg("Storage unreachable, serving from memory until it is back")
+		}
+		return m.health
+	}
+
+	metrics.StorageUp.Set(1)
+	m.health.Error = ""
+	if !m.health.Healthy {
+		m.logger.Info().
+			Dur("outage", now.Sub(m.health.Since)).
+			Msg("Storage reachable again")
+		m.health.Healthy = true
+		m.health.Since = now
+	}
+	return m.health
+}
+
+// Health returns the state found by the last probe
+func (m *Monitor) Health() Health {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.health
+}
+
+// Healthy reports whether the last probe reached the backend
+func (m *Monitor) Healthy() bool {
+	return m.Health().Healthy
+}
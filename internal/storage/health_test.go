@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// pingStore is a Store whose Ping fails with err
+type pingStore struct {
+	Store
+	err error
+}
+
+func (s *pingStore) Ping(ctx context.Context) error {
+	return s.err
+}
+
+func TestMonitor(t *testing.T) {
+	store := &pingStore{}
+	m := NewMonitor(store, MonitorConfig{Interval: time.Hour, Timeout: time.Second}, zerolog.Nop())
+	now := time.Date(2026, 3, 2, 18, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	if h := m.Probe(); !h.Healthy || h.Error != "" || !h.LastProbe.Equal(now) {
+		t.Errorf("Probe() = %+v, want healthy", h)
+	}
+
+	// Failing probes mark storage unhealthy from the first failure
+	store.err = errors.New("connection refused")
+	now = now.Add(10 * time.Second)
+	down := now
+	m.Probe()
+	now = now.Add(10 * time.Second)
+	if h := m.Probe(); h.Healthy || !h.Since.Equal(down) || h.Error != "connection refused" || m.Healthy() {
+		t.Errorf("Probe() while failing = %+v, want unhealthy since %v", h, down)
+	}
+
+	store.err = nil
+	now = now.Add(10 * time.Second)
+	if h := m.Probe(); !h.Healthy || !h.Since.Equal(now) || h.Error != "" {
+		t.Errorf("Probe() after recovering = %+v, want healthy since %v", h, now)
+	}
+}
+
+func TestMonitorWithoutPinger(t *testing.T) {
+	// Stores that can't be probed are always healthy
+	m := NewMonitor(struct{ Store }{}, MonitorConfig{Interval: time.Millisecond, Timeout: time.Second}, zerolog.Nop())
+	m.Start()
+	defer m.Stop()
+
+	if h := m.Probe(); !h.Healthy || !h.LastProbe.IsZero() {
+		t.Errorf("Probe() = %+v, want healthy and never probed", h)
+	}
+}
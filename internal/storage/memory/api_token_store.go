@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type apiTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]storage.APIToken // By ID
+}
+
+func newAPITokenStore() *apiTokenStore {
+	return &apiTokenStore{tokens: make(map[string]storage.APIToken)}
+}
+
+// List returns all tokens, oldest first
+func (s *apiTokenStore) List(ctx context.Context) ([]storage.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := make([]storage.APIToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sortAPITokens(tokens)
+	return tokens, nil
+}
+
+// Put creates or replaces a token
+func (s *apiTokenStore) Put(ctx context.Context, token storage.APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[token.ID] = token
+	return nil
+}
+
+// Delete removes a token
+func (s *apiTokenStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[id]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.tokens, id)
+	return nil
+}
+
+// sortAPITokens sorts tokens oldest first, by ID when created together
+func sortAPITokens(tokens []storage.APIToken) {
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].Created.Equal(tokens[j].Created) {
+			return tokens[i].Created.Before(tokens[j].Created)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+}
@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type auditLogStore struct {
+	mu      sync.Mutex
+	entries []storage.AuditEntry // Oldest first
+}
+
+func newAuditLogStore() *auditLogStore {
+	return &auditLogStore{}
+}
+
+// Add records a change
+func (s *auditLogStore) Add(ctx context.Context, entry storage.AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// List returns the unexpired entries newest first, removing the expired ones
+func (s *auditLogStore) List(ctx context.Context) ([]storage.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	kept := s.entries[:0]
+	for _, entry := range s.entries {
+		if now.Before(entry.ExpiresAt) {
+			kept = append(kept, entry)
+		}
+	}
+	s.entries = kept
+
+	entries := make([]storage.AuditEntry, 0, len(s.entries))
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		entries = append(entries, s.entries[i])
+	}
+	return entries, nil
+}
@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type blockPageStore struct {
+	mu    sync.RWMutex
+	pages map[string]storage.BlockPage
+}
+
+func newBlockPageStore() *blockPageStore {
+	return &blockPageStore{
+		pages: make(map[string]storage.BlockPage),
+	}
+}
+
+// Get retrieves a block page template
+func (s *blockPageStore) Get(ctx context.Context, name string) (*storage.BlockPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, ok := s.pages[name]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &page, nil
+}
+
+// List returns all block page templates sorted by name
+func (s *blockPageStore) List(ctx context.Context) ([]storage.BlockPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := make([]storage.BlockPage, 0, len(s.pages))
+	for _, page := range s.pages {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Name < pages[j].Name })
+	return pages, nil
+}
+
+// Put creates or replaces a block page template
+func (s *blockPageStore) Put(ctx context.Context, page storage.BlockPage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pages[page.Name] = page
+	return nil
+}
+
+// Delete removes a block page template
+func (s *blockPageStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pages[name]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.pages, name)
+	return nil
+}
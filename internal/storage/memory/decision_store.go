@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type decisionStore struct {
+	mu        sync.RWMutex
+	decisions map[string]storage.Decision
+	lastSweep time.Time
+}
+
+func newDecisionStore() *decisionStore {
+	return &decisionStore{
+		decisions: make(map[string]storage.Decision),
+	}
+}
+
+// Put stores a decision until ExpiresAt. Expired decisions are swept on
+// write (at most once a minute) so the map cannot grow without bound.
+func (s *decisionStore) Put(ctx context.Context, decision storage.Decision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for id, d := range s.decisions {
+			if !now.Before(d.ExpiresAt) {
+				delete(s.decisions, id)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	if now.Before(decision.ExpiresAt) {
+		s.decisions[decision.ID] = decision
+	}
+	return nil
+}
+
+// Get retrieves an unexpired decision
+func (s *decisionStore) Get(ctx context.Context, id string) (*storage.Decision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	decision, ok := s.decisions[id]
+	if !ok || !time.Now().Before(decision.ExpiresAt) {
+		return nil, storage.ErrNotFound
+	}
+	return &decision, nil
+}
+
+// List returns the unexpired decisions matching filter, newest first
+func (s *decisionStore) List(ctx context.Context, filter storage.DecisionFilter) ([]storage.Decision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	decisions := make([]storage.Decision, 0)
+	for _, decision := range s.decisions {
+		if now.Before(decision.ExpiresAt) && filter.Match(decision) {
+			decisions = append(decisions, decision)
+		}
+	}
+	sort.Slice(decisions, func(i, j int) bool {
+		return decisions[i].Time.After(decisions[j].Time)
+	})
+	if filter.Limit > 0 && len(decisions) > filter.Limit {
+		decisions = decisions[:filter.Limit]
+	}
+	return decisions, nil
+}
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type deviceRuleStore struct {
+	mu    sync.RWMutex
+	rules map[string]storage.DeviceRule
+}
+
+func newDeviceRuleStore() *deviceRuleStore {
+	return &deviceRuleStore{
+		rules: make(map[string]storage.DeviceRule),
+	}
+}
+
+// List returns all device rules, oldest first
+func (s *deviceRuleStore) List(ctx context.Context) ([]storage.DeviceRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]storage.DeviceRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	sortDeviceRules(rules)
+	return rules, nil
+}
+
+// Put creates or replaces a device rule
+func (s *deviceRuleStore) Put(ctx context.Context, rule storage.DeviceRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rules[rule.ID] = rule
+	return nil
+}
+
+// Delete removes a device rule
+func (s *deviceRuleStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rules[id]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.rules, id)
+	return nil
+}
+
+// sortDeviceRules orders rules oldest first, by ID for equal times
+func sortDeviceRules(rules []storage.DeviceRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		if !rules[i].Created.Equal(rules[j].Created) {
+			return rules[i].Created.Before(rules[j].Created)
+		}
+		return rules[i].ID < rules[j].ID
+	})
+}
@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type dhcpLeaseStore struct {
+	mu     sync.RWMutex
+	leases map[string]storage.DHCPLease // MAC -> lease
+	byIP   map[string]string            // IP -> MAC
+}
+
+func newDHCPLeaseStore() *dhcpLeaseStore {
+	return &dhcpLeaseStore{
+		leases: make(map[string]storage.DHCPLease),
+		byIP:   make(map[string]string),
+	}
+}
+
+// Get retrieves a DHCP lease by MAC address
+func (s *dhcpLeaseStore) Get(ctx context.Context, mac string) (*storage.DHCPLease, error) {
+	return s.GetByMAC(ctx, mac)
+}
+
+// GetByMAC retrieves a DHCP lease by MAC address
+func (s *dhcpLeaseStore) GetByMAC(ctx context.Context, mac string) (*storage.DHCPLease, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lease, ok := s.leases[mac]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &lease, nil
+}
+
+// GetByIP retrieves a DHCP lease by IP address
+func (s *dhcpLeaseStore) GetByIP(ctx context.Context, ip string) (*storage.DHCPLease, error) {
+	s.mu.RLock()
+	mac, ok := s.byIP[ip]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return s.GetByMAC(ctx, mac)
+}
+
+// List retrieves all DHCP leases
+func (s *dhcpLeaseStore) List(ctx context.Context) ([]storage.DHCPLease, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	leases := make([]storage.DHCPLease, 0, len(s.leases))
+	for _, lease := range s.leases {
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// Create creates or updates a DHCP lease
+func (s *dhcpLeaseStore) Create(ctx context.Context, lease *storage.DHCPLease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if lease.UpdatedAt.IsZero() {
+		lease.UpdatedAt = now
+	}
+	if lease.CreatedAt.IsZero() {
+		lease.CreatedAt = now
+	}
+
+	// Preserve the original creation time and drop a stale IP index on renewal
+	if existing, ok := s.leases[lease.MAC]; ok {
+		lease.CreatedAt = existing.CreatedAt
+		if existing.IP != lease.IP {
+			s.dropIPIndex(existing)
+		}
+	}
+
+	s.leases[lease.MAC] = *lease
+	s.byIP[lease.IP] = lease.MAC
+	return nil
+}
+
+// Delete deletes a DHCP lease by MAC address
+func (s *dhcpLeaseStore) Delete(ctx context.Context, mac string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lease, ok := s.leases[mac]; ok {
+		s.dropIPIndex(lease)
+		delete(s.leases, mac)
+	}
+	return nil
+}
+
+// DeleteExpired deletes expired DHCP leases
+func (s *dhcpLeaseStore) DeleteExpired(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int
+	for mac, lease := range s.leases {
+		if lease.IsExpired() {
+			s.dropIPIndex(lease)
+			delete(s.leases, mac)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// dropIPIndex removes the IP index entry for a lease unless another MAC now owns the IP.
+// Caller must hold the write lock.
+func (s *dhcpLeaseStore) dropIPIndex(lease storage.DHCPLease) {
+	if s.byIP[lease.IP] == lease.MAC {
+		delete(s.byIP, lease.IP)
+	}
+}
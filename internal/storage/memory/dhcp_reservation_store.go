@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type dhcpReservationStore struct {
+	mu           sync.RWMutex
+	reservations map[string]storage.DHCPReservation // MAC -> reservation
+}
+
+func newDHCPReservationStore() *dhcpReservationStore {
+	return &dhcpReservationStore{
+		reservations: make(map[string]storage.DHCPReservation),
+	}
+}
+
+// Get retrieves the reservation of a client
+func (s *dhcpReservationStore) Get(ctx context.Context, mac string) (*storage.DHCPReservation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reservation, ok := s.reservations[mac]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &reservation, nil
+}
+
+// List returns all reservations sorted by IP address
+func (s *dhcpReservationStore) List(ctx context.Context) ([]storage.DHCPReservation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reservations := make([]storage.DHCPReservation, 0, len(s.reservations))
+	for _, reservation := range s.reservations {
+		reservations = append(reservations, reservation)
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].IP < reservations[j].IP })
+	return reservations, nil
+}
+
+// Put creates or replaces the reservation of a client
+func (s *dhcpReservationStore) Put(ctx context.Context, reservation storage.DHCPReservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reservations[reservation.MAC] = reservation
+	return nil
+}
+
+// Delete removes the reservation of a client
+func (s *dhcpReservationStore) Delete(ctx context.Context, mac string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.reservations[mac]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.reservations, mac)
+	return nil
+}
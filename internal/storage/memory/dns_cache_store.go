@@ -0,0 +1,58 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type dnsCacheEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type dnsCacheStore struct {
+	mu        sync.RWMutex
+	entries   map[string]dnsCacheEntry
+	lastSweep time.Time
+}
+
+func newDNSCacheStore() *dnsCacheStore {
+	return &dnsCacheStore{
+		entries: make(map[string]dnsCacheEntry),
+	}
+}
+
+// Get retrieves a cached DNS response
+func (s *dnsCacheStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return nil, storage.ErrNotFound
+	}
+	return entry.value, nil
+}
+
+// Set stores a DNS response. Expired entries are swept on write (at most once
+// a minute) so the map cannot grow without bound.
+func (s *dnsCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, entry := range s.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	s.entries[key] = dnsCacheEntry{value: value, expiresAt: now.Add(ttl)}
+	return nil
+}
@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type hostnameStore struct {
+	mu        sync.RWMutex
+	hostnames map[string]storage.Hostname
+}
+
+func newHostnameStore() *hostnameStore {
+	return &hostnameStore{
+		hostnames: make(map[string]storage.Hostname),
+	}
+}
+
+// Put creates or replaces the hostname of a client
+func (s *hostnameStore) Put(ctx context.Context, hostname storage.Hostname) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hostnames[hostname.IP] = hostname
+	return nil
+}
+
+// List returns the unexpired hostnames sorted by IP address, removing the
+// expired ones
+func (s *hostnameStore) List(ctx context.Context) ([]storage.Hostname, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	hostnames := make([]storage.Hostname, 0, len(s.hostnames))
+	for ip, hostname := range s.hostnames {
+		if !now.Before(hostname.ExpiresAt) {
+			delete(s.hostnames, ip)
+			continue
+		}
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Slice(hostnames, func(i, j int) bool { return hostnames[i].IP < hostnames[j].IP })
+	return hostnames, nil
+}
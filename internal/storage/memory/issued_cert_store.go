@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type issuedCertStore struct {
+	mu    sync.Mutex
+	certs []storage.IssuedCert // Oldest first
+}
+
+func newIssuedCertStore() *issuedCertStore {
+	return &issuedCertStore{}
+}
+
+// Add records a minted certificate
+func (s *issuedCertStore) Add(ctx context.Context, cert storage.IssuedCert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.certs = append(s.certs, cert)
+	return nil
+}
+
+// List returns the unexpired records newest first, removing the expired ones
+func (s *issuedCertStore) List(ctx context.Context) ([]storage.IssuedCert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	kept := s.certs[:0]
+	for _, cert := range s.certs {
+		if now.Before(cert.ExpiresAt) {
+			kept = append(kept, cert)
+		}
+	}
+	s.certs = kept
+
+	certs := make([]storage.IssuedCert, 0, len(s.certs))
+	for i := len(s.certs) - 1; i >= 0; i-- {
+		certs = append(certs, s.certs[i])
+	}
+	return certs, nil
+}
@@ -0,0 +1,134 @@
+package memory
+
+import (
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// Store implements the storage.Store interface in process memory.
+// Data is lost on restart, which suits stateless deployments (e.g. DNS-only
+// filtering) where usage history and DHCP leases do not need to survive.
+type Store struct {
+	usageStore  *usageStore
+	dhcpStore   *dhcpLeaseStore
+	reserved    *dhcpReservationStore
+	dnsCache    *dnsCacheStore
+	pending     *pendingChangeStore
+	blockPages  *blockPageStore
+	deviceRules *deviceRuleStore
+	ruleSets    *ruleSetStore
+	reports     *reportStore
+	pauses      *pauseStore
+	reloads     *reloadBus
+	decisions   *decisionStore
+	hostnames   *hostnameStore
+	issued      *issuedCertStore
+	audit       *auditLogStore
+	apiTokens   *apiTokenStore
+}
+
+// Open creates a new in-memory storage instance
+func Open() *Store {
+	return &Store{
+		usageStore:  newUsageStore(),
+		dhcpStore:   newDHCPLeaseStore(),
+		reserved:    newDHCPReservationStore(),
+		dnsCache:    newDNSCacheStore(),
+		pending:     newPendingChangeStore(),
+		blockPages:  newBlockPageStore(),
+		deviceRules: newDeviceRuleStore(),
+		ruleSets:    newRuleSetStore(),
+		reports:     newReportStore(),
+		pauses:      newPauseStore(),
+		reloads:     newReloadBus(),
+		decisions:   newDecisionStore(),
+		hostnames:   newHostnameStore(),
+		issued:      newIssuedCertStore(),
+		audit:       newAuditLogStore(),
+		apiTokens:   newAPITokenStore(),
+	}
+}
+
+// Close is a no-op for in-memory storage
+func (s *Store) Close() error {
+	return nil
+}
+
+// Usage returns the UsageStore implementation
+func (s *Store) Usage() storage.UsageStore {
+	return s.usageStore
+}
+
+// DHCPLeases returns the DHCPLeaseStore implementation
+func (s *Store) DHCPLeases() storage.DHCPLeaseStore {
+	return s.dhcpStore
+}
+
+// DHCPReservations returns the DHCPReservationStore implementation
+func (s *Store) DHCPReservations() storage.DHCPReservationStore {
+	return s.reserved
+}
+
+// DNSCache returns the DNSCacheStore implementation
+func (s *Store) DNSCache() storage.DNSCacheStore {
+	return s.dnsCache
+}
+
+// PendingChanges returns the PendingChangeStore implementation
+func (s *Store) PendingChanges() storage.PendingChangeStore {
+	return s.pending
+}
+
+// BlockPages returns the BlockPageStore implementation
+func (s *Store) BlockPages() storage.BlockPageStore {
+	return s.blockPages
+}
+
+// DeviceRules returns the DeviceRuleStore implementation
+func (s *Store) DeviceRules() storage.DeviceRuleStore {
+	return s.deviceRules
+}
+
+// RuleSets returns the RuleSetStore implementation
+func (s *Store) RuleSets() storage.RuleSetStore {
+	return s.ruleSets
+}
+
+// Reports returns the ReportStore implementation
+func (s *Store) Reports() storage.ReportStore {
+	return s.reports
+}
+
+// Pauses returns the PauseStore implementation
+func (s *Store) Pauses() storage.PauseStore {
+	return s.pauses
+}
+
+// Reloads returns the ReloadBus implementation
+func (s *Store) Reloads() storage.ReloadBus {
+	return s.reloads
+}
+
+// Decisions returns the DecisionLogStore implementation
+func (s *Store) Decisions() storage.DecisionLogStore {
+	return s.decisions
+}
+
+// Hostnames returns the HostnameStore implementation
+func (s *Store) Hostnames() storage.HostnameStore {
+	return s.hostnames
+}
+
+// IssuedCerts returns the IssuedCertStore implementation
+func (s *Store) IssuedCerts() storage.IssuedCertStore {
+	return s.issued
+}
+
+// AuditLog returns the AuditLogStore implementation
+func (s *Store) AuditLog() storage.AuditLogStore {
+	return s.audit
+}
+
+// APITokens returns the APITokenStore implementation
+func (s *Store) APITokens() storage.APITokenStore {
+	return s.apiTokens
+}
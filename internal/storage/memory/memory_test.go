@@ -0,0 +1,497 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+func TestUsageStore_Sessions(t *testing.T) {
+	store := Open()
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	usageStore := store.Usage()
+
+	now := time.Now()
+	active := storage.UsageSession{ID: "s1", DeviceID: "dev", LimitID: "gaming", StartedAt: now, LastActivity: now, Active: true}
+	inactive := storage.UsageSession{ID: "s2", DeviceID: "dev", LimitID: "video", StartedAt: now.Add(-48 * time.Hour), Active: false}
+
+	for _, s := range []storage.UsageSession{active, inactive} {
+		if err := usageStore.UpsertSession(ctx, s); err != nil {
+			t.Fatalf("UpsertSession failed: %v", err)
+		}
+	}
+
+	sessions, err := usageStore.ListActiveSessions(ctx)
+	if err != nil {
+		t.Fatalf("ListActiveSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "s1" {
+		t.Errorf("Expected only session s1 to be active, got %+v", sessions)
+	}
+
+	deleted, err := usageStore.DeleteInactiveSessionsBefore(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteInactiveSessionsBefore failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted session, got %d", deleted)
+	}
+
+	if _, err := usageStore.GetSession(ctx, "s2"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for deleted session, got %v", err)
+	}
+}
+
+func TestUsageStore_DailyUsage(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	usageStore := store.Usage()
+
+	_ = usageStore.IncrementDailyUsage(ctx, "2024-01-01", "dev", "gaming", 60)
+	_ = usageStore.IncrementDailyUsage(ctx, "2024-01-01", "dev", "gaming", 30)
+	_ = usageStore.IncrementDailyUsage(ctx, "2024-01-02", "dev", "gaming", 10)
+
+	usage, err := usageStore.GetDailyUsage(ctx, "2024-01-01", "dev", "gaming")
+	if err != nil {
+		t.Fatalf("GetDailyUsage failed: %v", err)
+	}
+	if usage.TotalSeconds != 90 {
+		t.Errorf("Expected 90 seconds, got %d", usage.TotalSeconds)
+	}
+
+	deleted, _ := usageStore.DeleteDailyUsageBefore(ctx, "2024-01-02")
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted entry, got %d", deleted)
+	}
+
+	usages, _ := usageStore.ListDailyUsage(ctx, "2024-01-02")
+	if len(usages) != 1 {
+		t.Errorf("Expected 1 entry for 2024-01-02, got %d", len(usages))
+	}
+}
+
+func TestDHCPLeaseStore_Renewal(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	dhcpStore := store.DHCPLeases()
+
+	created := time.Now().Add(-time.Hour)
+	lease := &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:ff", IP: "192.168.1.100", ExpiresAt: time.Now().Add(time.Hour), CreatedAt: created}
+	if err := dhcpStore.Create(ctx, lease); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Renew with a new IP; the old IP index must go and CreatedAt must be kept
+	renewed := &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:ff", IP: "192.168.1.101", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := dhcpStore.Create(ctx, renewed); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if _, err := dhcpStore.GetByIP(ctx, "192.168.1.100"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected old IP to be released, got %v", err)
+	}
+
+	got, err := dhcpStore.GetByIP(ctx, "192.168.1.101")
+	if err != nil {
+		t.Fatalf("GetByIP failed: %v", err)
+	}
+	if !got.CreatedAt.Equal(created) {
+		t.Errorf("Expected CreatedAt %v to be preserved, got %v", created, got.CreatedAt)
+	}
+}
+
+func TestDHCPLeaseStore_DeleteExpired(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	dhcpStore := store.DHCPLeases()
+
+	_ = dhcpStore.Create(ctx, &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.101", ExpiresAt: time.Now().Add(-time.Minute)})
+	_ = dhcpStore.Create(ctx, &storage.DHCPLease{MAC: "aa:bb:cc:dd:ee:02", IP: "192.168.1.102", ExpiresAt: time.Now().Add(time.Hour)})
+
+	deleted, err := dhcpStore.DeleteExpired(ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpired failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 expired lease, got %d", deleted)
+	}
+
+	leases, _ := dhcpStore.List(ctx)
+	if len(leases) != 1 || leases[0].MAC != "aa:bb:cc:dd:ee:02" {
+		t.Errorf("Expected only the unexpired lease to remain, got %+v", leases)
+	}
+}
+
+func TestDHCPReservationStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	reservations := store.DHCPReservations()
+
+	created := time.Now()
+	for _, r := range []storage.DHCPReservation{
+		{MAC: "aa:bb:cc:dd:ee:02", IP: "192.168.1.51", CreatedAt: created},
+		{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.50", Hostname: "printer", CreatedAt: created},
+	} {
+		if err := reservations.Put(ctx, r); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	reservation, err := reservations.Get(ctx, "aa:bb:cc:dd:ee:01")
+	if err != nil || reservation.IP != "192.168.1.50" || reservation.Hostname != "printer" {
+		t.Errorf("Get = %+v (%v), want printer at 192.168.1.50", reservation, err)
+	}
+	list, err := reservations.List(ctx)
+	if err != nil || len(list) != 2 || list[0].IP != "192.168.1.50" || list[1].IP != "192.168.1.51" {
+		t.Errorf("List = %+v (%v), want both sorted by IP", list, err)
+	}
+
+	if err := reservations.Delete(ctx, "aa:bb:cc:dd:ee:01"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := reservations.Get(ctx, "aa:bb:cc:dd:ee:01"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+	if err := reservations.Delete(ctx, "aa:bb:cc:dd:ee:01"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestPendingChangeStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	pending := store.PendingChanges()
+
+	now := time.Now()
+	_ = pending.Create(ctx, storage.PendingChange{ID: "expired", RequestedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = pending.Create(ctx, storage.PendingChange{ID: "second", RequestedAt: now, ExpiresAt: now.Add(time.Hour)})
+	_ = pending.Create(ctx, storage.PendingChange{ID: "first", RequestedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)})
+
+	changes, err := pending.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(changes) != 2 || changes[0].ID != "first" || changes[1].ID != "second" {
+		t.Errorf("Expected the unexpired changes oldest first, got %+v", changes)
+	}
+
+	if _, err := pending.Get(ctx, "expired"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired change, got %v", err)
+	}
+	if err := pending.Delete(ctx, "first"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := pending.Delete(ctx, "first"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestDecisionStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	decisions := store.Decisions()
+
+	now := time.Now()
+	_ = decisions.Put(ctx, storage.Decision{ID: "expired", Kind: "dns", Time: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = decisions.Put(ctx, storage.Decision{ID: "recent", Kind: "proxy", Time: now, ExpiresAt: now.Add(time.Hour), Input: []byte(`{"host":"example.com"}`)})
+
+	d, err := decisions.Get(ctx, "recent")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if d.Kind != "proxy" || string(d.Input) != `{"host":"example.com"}` {
+		t.Errorf("Get = %+v, want the recorded decision", d)
+	}
+	if _, err := decisions.Get(ctx, "expired"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired decision, got %v", err)
+	}
+
+	history := decisions.(storage.DecisionHistory)
+	_ = decisions.Put(ctx, storage.Decision{ID: "dns", Kind: "dns", Time: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour), Input: []byte(`{"client_ip":"10.0.0.5","domain":"example.com"}`)})
+	if list, err := history.List(ctx, storage.DecisionFilter{}); err != nil || len(list) != 2 || list[0].ID != "recent" {
+		t.Errorf("List() = %+v (%v), want recent then dns", list, err)
+	}
+	if list, _ := history.List(ctx, storage.DecisionFilter{Host: "example.com", Limit: 1}); len(list) != 1 || list[0].ID != "recent" {
+		t.Errorf("List() by host = %+v, want recent only", list)
+	}
+	if list, _ := history.List(ctx, storage.DecisionFilter{Client: "10.0.0.5", Until: now}); len(list) != 1 || list[0].ID != "dns" {
+		t.Errorf("List() by client = %+v, want dns", list)
+	}
+	if list, _ := history.List(ctx, storage.DecisionFilter{Kind: "dns", Since: now}); len(list) != 0 {
+		t.Errorf("List() since now = %+v, want none", list)
+	}
+}
+
+func TestHostnameStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	hostnames := store.Hostnames()
+
+	now := time.Now()
+	_ = hostnames.Put(ctx, storage.Hostname{IP: "192.168.5.142", Name: "Liams-iPad", Source: "mdns", Seen: now, ExpiresAt: now.Add(time.Hour)})
+	_ = hostnames.Put(ctx, storage.Hostname{IP: "192.168.5.20", Name: "old-laptop", Source: "netbios", Seen: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = hostnames.Put(ctx, storage.Hostname{IP: "192.168.5.142", Name: "Liams-iPad-2", Source: "dhcp", Seen: now, ExpiresAt: now.Add(time.Hour)})
+
+	list, err := hostnames.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 1 || list[0].Name != "Liams-iPad-2" || list[0].Source != "dhcp" {
+		t.Errorf("List = %+v, want only the replaced unexpired hostname", list)
+	}
+}
+
+func TestIssuedCertStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	issued := store.IssuedCerts()
+
+	now := time.Now()
+	_ = issued.Add(ctx, storage.IssuedCert{Serial: "01", Host: "old.example", Issued: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = issued.Add(ctx, storage.IssuedCert{Serial: "02", Host: "example.com", Client: "192.168.1.20", Issued: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)})
+	_ = issued.Add(ctx, storage.IssuedCert{Serial: "03", Host: "example.org", Issued: now, ExpiresAt: now.Add(time.Hour)})
+
+	list, err := issued.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 || list[0].Serial != "03" || list[1].Client != "192.168.1.20" {
+		t.Errorf("List = %+v, want the unexpired records newest first", list)
+	}
+}
+
+func TestAuditLogStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	audit := store.AuditLog()
+
+	now := time.Now()
+	_ = audit.Add(ctx, storage.AuditEntry{ID: "01", Account: "admin", Time: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)})
+	_ = audit.Add(ctx, storage.AuditEntry{ID: "02", Account: "parent", Time: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)})
+	_ = audit.Add(ctx, storage.AuditEntry{ID: "03", Account: "admin", Time: now, ExpiresAt: now.Add(time.Hour)})
+
+	list, err := audit.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != "03" || list[1].Account != "parent" {
+		t.Errorf("List = %+v, want the unexpired entries newest first", list)
+	}
+}
+
+func TestBlockPageStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	pages := store.BlockPages()
+
+	for _, name := range []string{"gaming", "family"} {
+		if err := pages.Put(ctx, storage.BlockPage{Name: name, Template: "<p>{{.Reason}}</p>", UpdatedAt: time.Now()}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	list, err := pages.List(ctx)
+	if err != nil || len(list) != 2 || list[0].Name != "family" || list[1].Name != "gaming" {
+		t.Errorf("List = %+v (%v), want family and gaming", list, err)
+	}
+
+	if err := pages.Delete(ctx, "gaming"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := pages.Get(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+	if err := pages.Delete(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestDeviceRuleStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	rules := store.DeviceRules()
+
+	created := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	for i, id := range []string{"device-rule-2", "device-rule-1"} {
+		rule := storage.DeviceRule{ID: id, Device: "tablet", Domain: "roblox.com", Action: "allow", Created: created.Add(time.Duration(-i) * time.Minute)}
+		if err := rules.Put(ctx, rule); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	list, err := rules.List(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != "device-rule-1" || list[1].ID != "device-rule-2" {
+		t.Errorf("List = %+v (%v), want device-rule-1 then device-rule-2", list, err)
+	}
+
+	if err := rules.Delete(ctx, "device-rule-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := rules.Delete(ctx, "device-rule-1"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+	if list, _ := rules.List(ctx); len(list) != 1 {
+		t.Errorf("List after Delete = %+v, want one rule", list)
+	}
+}
+
+func TestPauseStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	pauses := store.Pauses()
+
+	created := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	if err := pauses.Put(ctx, storage.Pause{Kind: storage.PauseProfile, Target: "child", Created: created}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := pauses.Put(ctx, storage.Pause{Kind: storage.PauseDevice, Target: "child", Created: created.Add(-time.Minute)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	list, err := pauses.List(ctx)
+	if err != nil || len(list) != 2 || list[0].Kind != storage.PauseDevice || list[1].Kind != storage.PauseProfile {
+		t.Errorf("List = %+v (%v), want the device then the profile pause", list, err)
+	}
+
+	if err := pauses.Delete(ctx, storage.PauseDevice, "child"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := pauses.Delete(ctx, storage.PauseDevice, "child"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+	if list, _ := pauses.List(ctx); len(list) != 1 || list[0].Kind != storage.PauseProfile {
+		t.Errorf("List after Delete = %+v, want the profile pause", list)
+	}
+}
+
+func TestReloadBus(t *testing.T) {
+	bus := Open().Reloads()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	received := make(chan string, 1)
+	done := make(chan struct{})
+	go func() {
+		_ = bus.Subscribe(ctx, func(origin string) {
+			select {
+			case received <- origin:
+			default:
+			}
+		})
+		close(done)
+	}()
+
+	// Publish until the subscriber has registered
+	deadline := time.After(5 * time.Second)
+	for got := ""; got == ""; {
+		if err := bus.Publish(ctx, "node-a"); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		select {
+		case got = <-received:
+			if got != "node-a" {
+				t.Errorf("received %q, want node-a", got)
+			}
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("notice never received")
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRuleSetStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	sets := store.RuleSets()
+
+	for _, id := range []string{"social-media", "gaming"} {
+		if err := sets.Put(ctx, storage.RuleSet{ID: id, Name: id, UpdatedAt: time.Now()}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	list, err := sets.List(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != "gaming" || list[1].ID != "social-media" {
+		t.Errorf("List = %+v (%v), want gaming and social-media", list, err)
+	}
+
+	if err := sets.Delete(ctx, "gaming"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := sets.Get(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+	if err := sets.Delete(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestReportStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	reports := store.Reports()
+
+	for _, date := range []string{"2026-03-01", "2026-03-02", "2026-03-02"} {
+		day := storage.ReportDay{
+			Date:       date,
+			Profile:    "child",
+			Categories: map[string]storage.ReportCategory{"video": {Requests: 3, Minutes: 1}},
+			Sites:      map[string]int{"youtube.com": 3},
+			BlockKinds: map[string]int{"time_restriction": 1},
+		}
+		if err := reports.Add(ctx, day); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	day, err := reports.Get(ctx, "2026-03-02", "child")
+	if err != nil || day.Categories["video"].Requests != 6 || day.Sites["youtube.com"] != 6 || day.BlockKinds["time_restriction"] != 2 {
+		t.Errorf("Get = %+v (%v), want the counts added twice", day, err)
+	}
+	if _, err := reports.Get(ctx, "2026-03-02", "teen"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for another profile, got %v", err)
+	}
+
+	if n, err := reports.DeleteBefore(ctx, "2026-03-02"); err != nil || n != 1 {
+		t.Errorf("DeleteBefore = %d (%v), want 1", n, err)
+	}
+	if _, err := reports.Get(ctx, "2026-03-01", "child"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after DeleteBefore, got %v", err)
+	}
+}
+
+func TestAPITokenStore(t *testing.T) {
+	store := Open()
+	ctx := context.Background()
+	tokens := store.APITokens()
+
+	created := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	if err := tokens.Put(ctx, storage.APIToken{ID: "b", Name: "home-assistant", Hash: "00", Scopes: []string{"read"}, Created: created}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := tokens.Put(ctx, storage.APIToken{ID: "a", Name: "backup", Hash: "11", Scopes: []string{"*"}, Created: created.Add(-time.Hour)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	list, err := tokens.List(ctx)
+	if err != nil || len(list) != 2 || list[0].Name != "backup" || list[1].Scopes[0] != "read" {
+		t.Errorf("List = %+v (%v), want backup then home-assistant", list, err)
+	}
+
+	if err := tokens.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := tokens.Delete(ctx, "a"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+	if list, _ := tokens.List(ctx); len(list) != 1 || list[0].ID != "b" {
+		t.Errorf("List after Delete = %+v, want home-assistant", list)
+	}
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// pauseKey identifies the pause of a device or profile
+type pauseKey struct {
+	kind, target string
+}
+
+type pauseStore struct {
+	mu     sync.RWMutex
+	pauses map[pauseKey]storage.Pause
+}
+
+func newPauseStore() *pauseStore {
+	return &pauseStore{
+		pauses: make(map[pauseKey]storage.Pause),
+	}
+}
+
+// List returns all pauses, oldest first
+func (s *pauseStore) List(ctx context.Context) ([]storage.Pause, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pauses := make([]storage.Pause, 0, len(s.pauses))
+	for _, pause := range s.pauses {
+		pauses = append(pauses, pause)
+	}
+	sort.Slice(pauses, func(i, j int) bool {
+		if !pauses[i].Created.Equal(pauses[j].Created) {
+			return pauses[i].Created.Before(pauses[j].Created)
+		}
+		if pauses[i].Kind != pauses[j].Kind {
+			return pauses[i].Kind < pauses[j].Kind
+		}
+		return pauses[i].Target < pauses[j].Target
+	})
+	return pauses, nil
+}
+
+// Put creates or replaces the pause of a device or profile
+func (s *pauseStore) Put(ctx context.Context, pause storage.Pause) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pauses[pauseKey{pause.Kind, pause.Target}] = pause
+	return nil
+}
+
+// Delete removes the pause of a device or profile
+func (s *pauseStore) Delete(ctx context.Context, kind, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := pauseKey{kind, target}
+	if _, ok := s.pauses[key]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.pauses, key)
+	return nil
+}
@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type pendingChangeStore struct {
+	mu      sync.Mutex
+	changes map[string]storage.PendingChange
+}
+
+func newPendingChangeStore() *pendingChangeStore {
+	return &pendingChangeStore{
+		changes: make(map[string]storage.PendingChange),
+	}
+}
+
+// Create stores a pending change
+func (s *pendingChangeStore) Create(ctx context.Context, change storage.PendingChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.changes[change.ID] = change
+	return nil
+}
+
+// Get retrieves an unexpired pending change
+func (s *pendingChangeStore) Get(ctx context.Context, id string) (*storage.PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change, ok := s.getLocked(id)
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &change, nil
+}
+
+// List returns the unexpired pending changes, oldest first, dropping
+// expired ones
+func (s *pendingChangeStore) List(ctx context.Context) ([]storage.PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := make([]storage.PendingChange, 0, len(s.changes))
+	for id := range s.changes {
+		if change, ok := s.getLocked(id); ok {
+			changes = append(changes, change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].RequestedAt.Before(changes[j].RequestedAt) })
+	return changes, nil
+}
+
+// Delete removes a pending change
+func (s *pendingChangeStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.getLocked(id); !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.changes, id)
+	return nil
+}
+
+// getLocked returns an unexpired change, dropping it if it has expired
+func (s *pendingChangeStore) getLocked(id string) (storage.PendingChange, bool) {
+	change, ok := s.changes[id]
+	if !ok {
+		return storage.PendingChange{}, false
+	}
+	if !time.Now().Before(change.ExpiresAt) {
+		delete(s.changes, id)
+		return storage.PendingChange{}, false
+	}
+	return change, true
+}
@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"context"
+	"sync"
+)
+
+// reloadBus delivers reload notices to subscribers in the same process
+type reloadBus struct {
+	mu          sync.Mutex
+	subscribers map[int]func(origin string)
+	next        int
+}
+
+func newReloadBus() *reloadBus {
+	return &reloadBus{
+		subscribers: make(map[int]func(origin string)),
+	}
+}
+
+// Publish calls every subscriber with origin
+func (b *reloadBus) Publish(ctx context.Context, origin string) error {
+	b.mu.Lock()
+	subscribers := make([]func(string), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(origin)
+	}
+	return nil
+}
+
+// Subscribe calls fn for every notice until ctx is done
+func (b *reloadBus) Subscribe(ctx context.Context, fn func(origin string)) error {
+	b.mu.Lock()
+	id := b.next
+	b.next++
+	b.subscribers[id] = fn
+	b.mu.Unlock()
+
+	<-ctx.Done()
+
+	b.mu.Lock()
+	delete(b.subscribers, id)
+	b.mu.Unlock()
+	return nil
+}
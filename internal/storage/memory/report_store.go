@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type reportStore struct {
+	mu   sync.RWMutex
+	days map[string]storage.ReportDay // Keyed by date/profile
+}
+
+func newReportStore() *reportStore {
+	return &reportStore{
+		days: make(map[string]storage.ReportDay),
+	}
+}
+
+// Add adds to the counts of a day and profile
+func (s *reportStore) Add(ctx context.Context, day storage.ReportDay) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := day.Date + "/" + day.Profile
+	total, ok := s.days[key]
+	if !ok {
+		total = storage.ReportDay{Date: day.Date, Profile: day.Profile}
+	}
+	total.Merge(day)
+	s.days[key] = total
+	return nil
+}
+
+// Get retrieves the counts of a day and profile
+func (s *reportStore) Get(ctx context.Context, date, profile string) (*storage.ReportDay, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	day, ok := s.days[date+"/"+profile]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	// Copy so the caller can't change the stored maps
+	copied := storage.ReportDay{Date: day.Date, Profile: day.Profile}
+	copied.Merge(day)
+	return &copied, nil
+}
+
+// DeleteBefore deletes the counts of days before cutoffDate
+func (s *reportStore) DeleteBefore(ctx context.Context, cutoffDate string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for key, day := range s.days {
+		if day.Date < cutoffDate {
+			delete(s.days, key)
+			count++
+		}
+	}
+	return count, nil
+}
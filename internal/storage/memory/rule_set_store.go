@@ -0,0 +1,66 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type ruleSetStore struct {
+	mu   sync.RWMutex
+	sets map[string]storage.RuleSet
+}
+
+func newRuleSetStore() *ruleSetStore {
+	return &ruleSetStore{
+		sets: make(map[string]storage.RuleSet),
+	}
+}
+
+// Get retrieves a rule set
+func (s *ruleSetStore) Get(ctx context.Context, id string) (*storage.RuleSet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set, ok := s.sets[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &set, nil
+}
+
+// List returns all rule sets sorted by ID
+func (s *ruleSetStore) List(ctx context.Context) ([]storage.RuleSet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sets := make([]storage.RuleSet, 0, len(s.sets))
+	for _, set := range s.sets {
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].ID < sets[j].ID })
+	return sets, nil
+}
+
+// Put creates or replaces a rule set
+func (s *ruleSetStore) Put(ctx context.Context, set storage.RuleSet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sets[set.ID] = set
+	return nil
+}
+
+// Delete removes a rule set
+func (s *ruleSetStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sets[id]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.sets, id)
+	return nil
+}
@@ -0,0 +1,143 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+type usageStore struct {
+	mu       sync.RWMutex
+	sessions map[string]storage.UsageSession
+	daily    map[string]map[string]storage.DailyUsage // date -> "device:limit" -> usage
+}
+
+func newUsageStore() *usageStore {
+	return &usageStore{
+		sessions: make(map[string]storage.UsageSession),
+		daily:    make(map[string]map[string]storage.DailyUsage),
+	}
+}
+
+// UpsertSession creates or updates a usage session
+func (s *usageStore) UpsertSession(ctx context.Context, session storage.UsageSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ID] = session
+	return nil
+}
+
+// DeleteSession removes a session by ID
+func (s *usageStore) DeleteSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// GetSession retrieves a session by ID
+func (s *usageStore) GetSession(ctx context.Context, id string) (*storage.UsageSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &session, nil
+}
+
+// ListActiveSessions returns all active sessions
+func (s *usageStore) ListActiveSessions(ctx context.Context) ([]storage.UsageSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]storage.UsageSession, 0)
+	for _, session := range s.sessions {
+		if session.Active {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+// GetDailyUsage retrieves daily usage for a specific date, device, and limit
+func (s *usageStore) GetDailyUsage(ctx context.Context, date string, deviceID, limitID string) (*storage.DailyUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usage, ok := s.daily[date][deviceID+":"+limitID]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &usage, nil
+}
+
+// ListDailyUsage returns all daily usage entries for a specific date
+func (s *usageStore) ListDailyUsage(ctx context.Context, date string) ([]storage.DailyUsage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usages := make([]storage.DailyUsage, 0, len(s.daily[date]))
+	for _, usage := range s.daily[date] {
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// IncrementDailyUsage increments (or creates) daily usage
+func (s *usageStore) IncrementDailyUsage(ctx context.Context, date string, deviceID, limitID string, seconds int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byPair, ok := s.daily[date]
+	if !ok {
+		byPair = make(map[string]storage.DailyUsage)
+		s.daily[date] = byPair
+	}
+
+	key := deviceID + ":" + limitID
+	usage, ok := byPair[key]
+	if !ok {
+		usage = storage.DailyUsage{Date: date, DeviceID: deviceID, LimitID: limitID}
+	}
+	usage.TotalSeconds += seconds
+	byPair[key] = usage
+
+	return nil
+}
+
+// DeleteDailyUsageBefore deletes daily usage entries before the specified date
+func (s *usageStore) DeleteDailyUsageBefore(ctx context.Context, cutoffDate string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Dates are YYYY-MM-DD, so string comparison orders them chronologically
+	var deleted int
+	for date, byPair := range s.daily {
+		if date < cutoffDate {
+			deleted += len(byPair)
+			delete(s.daily, date)
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteInactiveSessionsBefore deletes inactive sessions started before the specified time
+func (s *usageStore) DeleteInactiveSessionsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int
+	for id, session := range s.sessions {
+		if !session.Active && session.StartedAt.Before(cutoff) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
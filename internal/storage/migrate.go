@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrSchemaTooNew is returned when stored data was upgraded by a newer
+// KProxy than the one opening it.
+var ErrSchemaTooNew = errors.New("storage: data was written by a newer version of KProxy")
+
+// Migration is one step upgrading a backend's data from Version-1 to
+// Version, so a change to key layouts or record fields doesn't mean wiping
+// the data. B is what the backend's steps work on, such as its client.
+type Migration[B any] struct {
+	Version     int
+	Description string
+	Apply       func(ctx context.Context, backend B) error
+}
+
+// SchemaStore reads and records the schema version of a backend's data.
+// Data written before versions were recorded, and empty stores, are at
+// version 0.
+type SchemaStore interface {
+	SchemaVersion(ctx context.Context) (int, error)
+	SetSchemaVersion(ctx context.Context, version int) error
+}
+
+// LatestVersion returns the schema version migrations upgrade to
+func LatestVersion[B any](migrations []Migration[B]) int {
+	return len(migrations)
+}
+
+// Migrate applies the migrations above the stored schema version in order,
+// recording the version after each step so an interrupted upgrade resumes
+// where it stopped. Migrations are numbered from 1 without gaps; steps must
+// be safe to run again on data they have partly upgraded. It returns the
+// versions applied, and ErrSchemaTooNew when the data is newer than the
+// last migration.
+func Migrate[B any](ctx context.Context, schema SchemaStore, backend B, migrations []Migration[B]) ([]int, error) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("storage: migration %q is numbered %d, want %d", m.Description, m.Version, i+1)
+		}
+	}
+
+	current, err := schema.SchemaVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	latest := LatestVersion(migrations)
+	if current > latest {
+		return nil, fmt.Errorf("%w (schema version %d, this version supports up to %d)", ErrSchemaTooNew, current, latest)
+	}
+
+	var applied []int
+	for _, m := range migrations[current:] {
+		if err := m.Apply(ctx, backend); err != nil {
+			return applied, fmt.Errorf("schema migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		if err := schema.SetSchemaVersion(ctx, m.Version); err != nil {
+			return applied, fmt.Errorf("failed to record schema version %d: %w", m.Version, err)
+		}
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// versionStore keeps a schema version in memory
+type versionStore struct {
+	version int
+}
+
+func (s *versionStore) SchemaVersion(ctx context.Context) (int, error) {
+	return s.version, nil
+}
+
+func (s *versionStore) SetSchemaVersion(ctx context.Context, version int) error {
+	s.version = version
+	return nil
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	fail := true
+	migrations := []Migration[*[]string]{
+		{Version: 1, Description: "first", Apply: func(ctx context.Context, log *[]string) error {
+			*log = append(*log, "first")
+			return nil
+		}},
+		{Version: 2, Description: "second", Apply: func(ctx context.Context, log *[]string) error {
+			if fail {
+				return errors.New("broken")
+			}
+			*log = append(*log, "second")
+			return nil
+		}},
+	}
+
+	// A failed step stops the upgrade, keeping the steps before it
+	schema := &versionStore{}
+	var log []string
+	applied, err := Migrate(ctx, schema, &log, migrations)
+	if err == nil || len(applied) != 1 || schema.version != 1 {
+		t.Fatalf("Migrate() = %v, %v at version %d; want an error after version 1", applied, err, schema.version)
+	}
+
+	// Running again resumes where it stopped
+	fail = false
+	applied, err = Migrate(ctx, schema, &log, migrations)
+	if err != nil || len(applied) != 1 || applied[0] != 2 || schema.version != 2 {
+		t.Fatalf("Migrate() resumed = %v, %v at version %d; want version 2 applied", applied, err, schema.version)
+	}
+	if len(log) != 2 || log[0] != "first" || log[1] != "second" {
+		t.Errorf("steps run = %v, want each once in order", log)
+	}
+
+	// Up to date: nothing to do
+	if applied, err := Migrate(ctx, schema, &log, migrations); err != nil || len(applied) != 0 {
+		t.Errorf("Migrate() up to date = %v, %v; want nothing applied", applied, err)
+	}
+
+	// Data from a newer KProxy is refused
+	if _, err := Migrate(ctx, &versionStore{version: 3}, &log, migrations); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Migrate() newer = %v, want ErrSchemaTooNew", err)
+	}
+
+	// Steps must be numbered in order
+	gap := []Migration[*[]string]{{Version: 2, Description: "gap"}}
+	if _, err := Migrate(ctx, &versionStore{}, &log, gap); err == nil {
+		t.Error("Migrate() with a gap = nil, want an error")
+	}
+}
@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+// sweepInterval is how often expired decisions are deleted, on write
+const sweepInterval = time.Minute
+
+type decisionStore struct {
+	db querier
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+const decisionColumns = `id, kind, recorded_at, expires_at, input, result`
+
+// scanDecision reads a row of decisionColumns
+func scanDecision(row pgx.Row) (storage.Decision, error) {
+	var decision storage.Decision
+	var input, result []byte
+	err := row.Scan(&decision.ID, &decision.Kind, &decision.Time, &decision.ExpiresAt, &input, &result)
+	decision.Input, decision.Result = input, result
+	return decision, err
+}
+
+// Put stores a decision until ExpiresAt. Expired decisions are deleted on
+// write (at most once a minute).
+func (s *decisionStore) Put(ctx context.Context, decision storage.Decision) error {
+	if err := s.sweep(ctx); err != nil {
+		return err
+	}
+	if !time.Now().Before(decision.ExpiresAt) {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, `INSERT INTO decisions (`+decisionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE SET
+			kind = excluded.kind,
+			recorded_at = excluded.recorded_at,
+			expires_at = excluded.expires_at,
+			input = excluded.input,
+			result = excluded.result`,
+		decision.ID, decision.Kind, decision.Time, decision.ExpiresAt, jsonOrNull(decision.Input), jsonOrNull(decision.Result))
+	return err
+}
+
+// sweep deletes expired decisions unless it did so within sweepInterval
+func (s *decisionStore) sweep(ctx context.Context) error {
+	s.mu.Lock()
+	now := time.Now()
+	due := now.Sub(s.lastSweep) > sweepInterval
+	if due {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+	_, err := s.db.Exec(ctx, `DELETE FROM decisions WHERE expires_at <= now()`)
+	return err
+}
+
+// Get retrieves an unexpired decision
+func (s *decisionStore) Get(ctx context.Context, id string) (*storage.Decision, error) {
+	decision, err := scanDecision(s.db.QueryRow(ctx, `SELECT `+decisionColumns+`
+		FROM decisions WHERE id = $1 AND expires_at > now()`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+// List returns the unexpired decisions matching filter, newest first
+func (s *decisionStore) List(ctx context.Context, filter storage.DecisionFilter) ([]storage.Decision, error) {
+	conditions := []string{"expires_at > now()"}
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if filter.Kind != "" {
+		where("kind = ?", filter.Kind)
+	}
+	if filter.Client != "" {
+		where("input->>'client_ip' = ?", filter.Client)
+	}
+	if filter.Host != "" {
+		// Matches the decisions_host index
+		where("coalesce(input->>'host', input->>'domain') = ?", filter.Host)
+	}
+	if !filter.Since.IsZero() {
+		where("recorded_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where("recorded_at < ?", filter.Until)
+	}
+
+	query := `SELECT ` + decisionColumns + ` FROM decisions WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY recorded_at DESC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (storage.Decision, error) {
+		return scanDecision(row)
+	})
+}
+
+// jsonOrNull returns data, or the JSON null for none, for jsonb columns
+func jsonOrNull(data []byte) string {
+	if len(data) == 0 {
+		return "null"
+	}
+	return string(data)
+}
@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// schemaLockID is the advisory lock held by the instance upgrading the
+	// schema ("kproxy" in ASCII)
+	schemaLockID = 0x6b70726f7879
+
+	// migrationTimeout bounds an upgrade, including waiting for another
+	// instance's
+	migrationTimeout = 10 * time.Minute
+)
+
+// migrations create and upgrade KProxy's tables. Add a step at the end
+// whenever a table changes; never change or remove a released one.
+var migrations = []storage.Migration[querier]{
+	{Version: 1, Description: "create usage and decision tables", Apply: execAll(
+		`CREATE TABLE IF NOT EXISTS usage_sessions (
+			id text PRIMARY KEY,
+			device_id text NOT NULL,
+			limit_id text NOT NULL,
+			started_at timestamptz NOT NULL,
+			last_activity timestamptz NOT NULL,
+			accumulated_seconds bigint NOT NULL,
+			active boolean NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS usage_sessions_active ON usage_sessions (active)`,
+		`CREATE TABLE IF NOT EXISTS daily_usage (
+			day text NOT NULL, -- YYYY-MM-DD
+			device_id text NOT NULL,
+			limit_id text NOT NULL,
+			total_seconds bigint NOT NULL,
+			PRIMARY KEY (day, device_id, limit_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS decisions (
+			id text PRIMARY KEY,
+			kind text NOT NULL,
+			recorded_at timestamptz NOT NULL,
+			expires_at timestamptz NOT NULL,
+			input jsonb NOT NULL,
+			result jsonb NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS decisions_recorded_at ON decisions (recorded_at)`,
+		`CREATE INDEX IF NOT EXISTS decisions_expires_at ON decisions (expires_at)`,
+		`CREATE INDEX IF NOT EXISTS decisions_client ON decisions ((input->>'client_ip'), recorded_at)`,
+		`CREATE INDEX IF NOT EXISTS decisions_host ON decisions ((coalesce(input->>'host', input->>'domain')), recorded_at)`,
+	)},
+}
+
+// execAll returns a migration step running statements in order
+func execAll(statements ...string) func(ctx context.Context, db querier) error {
+	return func(ctx context.Context, db querier) error {
+		for _, statement := range statements {
+			if _, err := db.Exec(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// schemaStore keeps the schema versions applied in kproxy_schema
+type schemaStore struct {
+	db querier
+}
+
+// SchemaVersion returns the latest version applied, 0 before any
+func (s *schemaStore) SchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := s.db.QueryRow(ctx, `SELECT coalesce(max(version), 0) FROM kproxy_schema`).Scan(&version)
+	return version, err
+}
+
+// SetSchemaVersion records a version as applied
+func (s *schemaStore) SetSchemaVersion(ctx context.Context, version int) error {
+	_, err := s.db.Exec(ctx, `INSERT INTO kproxy_schema (version) VALUES ($1) ON CONFLICT DO NOTHING`, version)
+	return err
+}
+
+// migrate upgrades the tables to the latest schema, returning the versions
+// applied. Instances sharing the database take turns through an advisory
+// lock, so each step runs once.
+func migrate(ctx context.Context, pool *pgxpool.Pool, migrations []storage.Migration[querier]) ([]int, error) {
+	// Advisory locks belong to a session, so everything runs on one
+	// connection
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, schemaLockID); err != nil {
+		return nil, fmt.Errorf("failed to lock schema: %w", err)
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, schemaLockID)
+	}()
+
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS kproxy_schema (
+		version integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return nil, fmt.Errorf("failed to create kproxy_schema: %w", err)
+	}
+
+	var db querier = conn
+	return storage.Migrate(ctx, &schemaStore{db: db}, db, migrations)
+}
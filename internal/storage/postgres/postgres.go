@@ -0,0 +1,136 @@
+// Package postgres keeps usage tracking and the decision log in PostgreSQL,
+// so a household can keep months of history and search it quickly. The
+// rest of KProxy's state stays in the primary store; Combine puts the two
+// together.
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// querier runs statements on the pool or on one of its connections
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Store holds the usage and decision stores kept in PostgreSQL
+type Store struct {
+	pool      *pgxpool.Pool
+	usage     *usageStore
+	decisions *decisionStore
+}
+
+// Open connects to PostgreSQL and upgrades its tables to the latest schema
+func Open(cfg config.PostgresConfig) (*Store, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres dsn: %w", err)
+	}
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.MaxConns)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+
+	// Create or upgrade the tables
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancelMigrate()
+	if _, err := migrate(migrateCtx, pool, migrations); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &Store{
+		pool:      pool,
+		usage:     &usageStore{db: pool},
+		decisions: &decisionStore{db: pool},
+	}, nil
+}
+
+// Close closes the connection pool
+func (s *Store) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+// Ping checks that PostgreSQL answers, for storage health checks
+func (s *Store) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+// SchemaVersion returns the schema version of the tables
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	return (&schemaStore{db: s.pool}).SchemaVersion(ctx)
+}
+
+// Usage returns the UsageStore implementation
+func (s *Store) Usage() storage.UsageStore {
+	return s.usage
+}
+
+// Decisions returns the DecisionLogStore implementation, which also
+// implements storage.DecisionHistory
+func (s *Store) Decisions() storage.DecisionLogStore {
+	return s.decisions
+}
+
+// Combined serves usage and decisions from PostgreSQL and everything else
+// from the primary store it embeds
+type Combined struct {
+	storage.Store
+	Postgres *Store
+}
+
+// Combine returns a store keeping usage and decisions in s and the rest in
+// primary. Closing it closes both.
+func Combine(primary storage.Store, s *Store) *Combined {
+	return &Combined{Store: primary, Postgres: s}
+}
+
+// Close closes both stores
+func (c *Combined) Close() error {
+	return errors.Join(c.Postgres.Close(), c.Store.Close())
+}
+
+// Ping checks that PostgreSQL and, when it can be probed, the primary
+// store answer
+func (c *Combined) Ping(ctx context.Context) error {
+	if err := c.Postgres.Ping(ctx); err != nil {
+		return fmt.Errorf("postgres: %w", err)
+	}
+	if pinger, ok := c.Store.(storage.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// Usage returns the PostgreSQL UsageStore
+func (c *Combined) Usage() storage.UsageStore {
+	return c.Postgres.Usage()
+}
+
+// Decisions returns the PostgreSQL DecisionLogStore
+func (c *Combined) Decisions() storage.DecisionLogStore {
+	return c.Postgres.Decisions()
+}
@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/config"
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// setupTestStore opens the database named by KPROXY_TEST_POSTGRES_DSN with
+// empty tables, skipping the test without one
+func setupTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	dsn := os.Getenv("KPROXY_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("Skipping PostgreSQL test - KPROXY_TEST_POSTGRES_DSN not set")
+	}
+	store, err := Open(config.PostgresConfig{DSN: dsn, MaxConns: 4})
+	if err != nil {
+		t.Fatalf("Failed to open PostgreSQL store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	if _, err := store.pool.Exec(context.Background(), `TRUNCATE usage_sessions, daily_usage, decisions`); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestOpen(t *testing.T) {
+	store := setupTestStore(t)
+	if version, err := store.SchemaVersion(context.Background()); err != nil || version != storage.LatestVersion(migrations) {
+		t.Errorf("SchemaVersion() = %d, %v; want %d", version, err, storage.LatestVersion(migrations))
+	}
+
+	// Opening again finds the tables up to date
+	again, err := Open(config.PostgresConfig{DSN: os.Getenv("KPROXY_TEST_POSTGRES_DSN")})
+	if err != nil {
+		t.Fatalf("Open() again = %v", err)
+	}
+	_ = again.Close()
+}
+
+func TestUsageStore(t *testing.T) {
+	store := setupTestStore(t)
+	ctx := context.Background()
+	usage := store.Usage()
+
+	started := time.Date(2026, 10, 1, 16, 0, 0, 0, time.UTC)
+	session := storage.UsageSession{ID: "s1", DeviceID: "tablet", LimitID: "games", StartedAt: started, LastActivity: started.Add(time.Minute), AccumulatedSeconds: 60, Active: true}
+	if err := usage.UpsertSession(ctx, session); err != nil {
+		t.Fatalf("UpsertSession failed: %v", err)
+	}
+	session.AccumulatedSeconds = 120
+	if err := usage.UpsertSession(ctx, session); err != nil {
+		t.Fatalf("UpsertSession failed: %v", err)
+	}
+	got, err := usage.GetSession(ctx, "s1")
+	if err != nil || got.AccumulatedSeconds != 120 || !got.StartedAt.Equal(started) {
+		t.Errorf("GetSession = %+v (%v), want the updated session", got, err)
+	}
+	if _, err := usage.GetSession(ctx, "missing"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if active, err := usage.ListActiveSessions(ctx); err != nil || len(active) != 1 {
+		t.Errorf("ListActiveSessions = %+v (%v), want s1", active, err)
+	}
+
+	session.Active = false
+	_ = usage.UpsertSession(ctx, session)
+	if n, err := usage.DeleteInactiveSessionsBefore(ctx, started.Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("DeleteInactiveSessionsBefore = %d, %v; want 1", n, err)
+	}
+
+	for _, seconds := range []int64{300, 120} {
+		if err := usage.IncrementDailyUsage(ctx, "2026-10-01", "tablet", "games", seconds); err != nil {
+			t.Fatalf("IncrementDailyUsage failed: %v", err)
+		}
+	}
+	_ = usage.IncrementDailyUsage(ctx, "2026-10-02", "tablet", "games", 60)
+	daily, err := usage.GetDailyUsage(ctx, "2026-10-01", "tablet", "games")
+	if err != nil || daily.TotalSeconds != 420 || daily.Date != "2026-10-01" {
+		t.Errorf("GetDailyUsage = %+v (%v), want 420s on 2026-10-01", daily, err)
+	}
+	if list, err := usage.ListDailyUsage(ctx, "2026-10-02"); err != nil || len(list) != 1 {
+		t.Errorf("ListDailyUsage = %+v (%v), want one entry", list, err)
+	}
+	if n, err := usage.DeleteDailyUsageBefore(ctx, "2026-10-02"); err != nil || n != 1 {
+		t.Errorf("DeleteDailyUsageBefore = %d, %v; want 1", n, err)
+	}
+}
+
+func TestDecisionStore(t *testing.T) {
+	store := setupTestStore(t)
+	ctx := context.Background()
+	decisions := store.Decisions()
+	history := decisions.(storage.DecisionHistory)
+
+	now := time.Now()
+	_ = decisions.Put(ctx, storage.Decision{ID: "expired", Kind: "dns", Time: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour), Input: []byte(`{}`), Result: []byte(`{}`)})
+	_ = decisions.Put(ctx, storage.Decision{ID: "dns", Kind: "dns", Time: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour), Input: []byte(`{"client_ip":"10.0.0.5","domain":"example.com"}`), Result: []byte(`{"action":"BLOCK"}`)})
+	if err := decisions.Put(ctx, storage.Decision{ID: "proxy", Kind: "proxy", Time: now, ExpiresAt: now.Add(time.Hour), Input: []byte(`{"client_ip":"10.0.0.6","host":"example.com"}`), Result: []byte(`{"action":"ALLOW"}`)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	d, err := decisions.Get(ctx, "dns")
+	if err != nil || d.Kind != "dns" || string(d.Result) != `{"action": "BLOCK"}` {
+		t.Errorf("Get = %+v (%v), want the recorded decision", d, err)
+	}
+	if _, err := decisions.Get(ctx, "expired"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired decision, got %v", err)
+	}
+
+	tests := []struct {
+		filter storage.DecisionFilter
+		want   []string
+	}{
+		{storage.DecisionFilter{}, []string{"proxy", "dns"}},
+		{storage.DecisionFilter{Host: "example.com", Limit: 1}, []string{"proxy"}},
+		{storage.DecisionFilter{Client: "10.0.0.5"}, []string{"dns"}},
+		{storage.DecisionFilter{Kind: "dns", Since: now}, nil},
+		{storage.DecisionFilter{Until: now}, []string{"dns"}},
+	}
+	for _, tt := range tests {
+		list, err := history.List(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("List(%+v) failed: %v", tt.filter, err)
+		}
+		var ids []string
+		for _, d := range list {
+			ids = append(ids, d.ID)
+		}
+		if len(ids) != len(tt.want) || (len(ids) > 0 && ids[0] != tt.want[0]) {
+			t.Errorf("List(%+v) = %v, want %v", tt.filter, ids, tt.want)
+		}
+	}
+}
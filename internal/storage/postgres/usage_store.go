@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/jackc/pgx/v5"
+)
+
+type usageStore struct {
+	db querier
+}
+
+const sessionColumns = `id, device_id, limit_id, started_at, last_activity, accumulated_seconds, active`
+
+// scanSession reads a row of sessionColumns
+func scanSession(row pgx.Row) (storage.UsageSession, error) {
+	var session storage.UsageSession
+	err := row.Scan(&session.ID, &session.DeviceID, &session.LimitID, &session.StartedAt,
+		&session.LastActivity, &session.AccumulatedSeconds, &session.Active)
+	return session, err
+}
+
+// UpsertSession creates or updates a usage session
+func (s *usageStore) UpsertSession(ctx context.Context, session storage.UsageSession) error {
+	_, err := s.db.Exec(ctx, `INSERT INTO usage_sessions (`+sessionColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET
+			device_id = excluded.device_id,
+			limit_id = excluded.limit_id,
+			started_at = excluded.started_at,
+			last_activity = excluded.last_activity,
+			accumulated_seconds = excluded.accumulated_seconds,
+			active = excluded.active`,
+		session.ID, session.DeviceID, session.LimitID, session.StartedAt,
+		session.LastActivity, session.AccumulatedSeconds, session.Active)
+	return err
+}
+
+// DeleteSession removes a session by ID
+func (s *usageStore) DeleteSession(ctx context.Context, id string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM usage_sessions WHERE id = $1`, id)
+	return err
+}
+
+// GetSession retrieves a session by ID
+func (s *usageStore) GetSession(ctx context.Context, id string) (*storage.UsageSession, error) {
+	session, err := scanSession(s.db.QueryRow(ctx, `SELECT `+sessionColumns+` FROM usage_sessions WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListActiveSessions returns all active sessions
+func (s *usageStore) ListActiveSessions(ctx context.Context) ([]storage.UsageSession, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+sessionColumns+` FROM usage_sessions WHERE active`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (storage.UsageSession, error) {
+		return scanSession(row)
+	})
+}
+
+// scanDailyUsage reads a row of day, device_id, limit_id and total_seconds
+func scanDailyUsage(row pgx.Row) (storage.DailyUsage, error) {
+	var usage storage.DailyUsage
+	err := row.Scan(&usage.Date, &usage.DeviceID, &usage.LimitID, &usage.TotalSeconds)
+	return usage, err
+}
+
+// GetDailyUsage retrieves daily usage for a specific date, device, and limit
+func (s *usageStore) GetDailyUsage(ctx context.Context, date string, deviceID, limitID string) (*storage.DailyUsage, error) {
+	usage, err := scanDailyUsage(s.db.QueryRow(ctx, `SELECT day, device_id, limit_id, total_seconds
+		FROM daily_usage WHERE day = $1 AND device_id = $2 AND limit_id = $3`, date, deviceID, limitID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// ListDailyUsage returns all daily usage entries for a specific date
+func (s *usageStore) ListDailyUsage(ctx context.Context, date string) ([]storage.DailyUsage, error) {
+	rows, err := s.db.Query(ctx, `SELECT day, device_id, limit_id, total_seconds
+		FROM daily_usage WHERE day = $1`, date)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (storage.DailyUsage, error) {
+		return scanDailyUsage(row)
+	})
+}
+
+// IncrementDailyUsage increments (or creates) daily usage
+func (s *usageStore) IncrementDailyUsage(ctx context.Context, date string, deviceID, limitID string, seconds int64) error {
+	_, err := s.db.Exec(ctx, `INSERT INTO daily_usage (day, device_id, limit_id, total_seconds)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (day, device_id, limit_id) DO UPDATE SET
+			total_seconds = daily_usage.total_seconds + excluded.total_seconds`,
+		date, deviceID, limitID, seconds)
+	return err
+}
+
+// DeleteDailyUsageBefore deletes daily usage entries before the specified date
+func (s *usageStore) DeleteDailyUsageBefore(ctx context.Context, cutoffDate string) (int, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM daily_usage WHERE day < $1`, cutoffDate)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// DeleteInactiveSessionsBefore deletes inactive sessions started before the specified time
+func (s *usageStore) DeleteInactiveSessionsBefore(ctx context.Context, cutoff time.Time) (int, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM usage_sessions WHERE NOT active AND started_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// apiTokensHash maps token IDs to JSON-encoded API tokens
+const apiTokensHash = "kproxy:apitokens"
+
+type apiTokenStore struct {
+	conn
+}
+
+// List returns all tokens, oldest first
+func (s *apiTokenStore) List(ctx context.Context) ([]storage.APIToken, error) {
+	values, err := s.client.HGetAll(ctx, s.key(apiTokensHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]storage.APIToken, 0, len(values))
+	for _, data := range values {
+		var token storage.APIToken
+		if err := json.Unmarshal([]byte(data), &token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].Created.Equal(tokens[j].Created) {
+			return tokens[i].Created.Before(tokens[j].Created)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// Put creates or replaces a token
+func (s *apiTokenStore) Put(ctx context.Context, token storage.APIToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.key(apiTokensHash), token.ID, data).Err()
+}
+
+// Delete removes a token
+func (s *apiTokenStore) Delete(ctx context.Context, id string) error {
+	removed, err := s.client.HDel(ctx, s.key(apiTokensHash), id).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// auditLogZSet holds JSON-encoded audit entries scored by when the change
+// was made (Unix milliseconds)
+const auditLogZSet = "kproxy:audit"
+
+type auditLogStore struct {
+	conn
+}
+
+// Add records a change
+func (s *auditLogStore) Add(ctx context.Context, entry storage.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.client.ZAdd(ctx, s.key(auditLogZSet), redis.Z{
+		Score:  float64(entry.Time.UnixMilli()),
+		Member: data,
+	}).Err()
+}
+
+// List returns the unexpired entries newest first, removing the expired ones
+func (s *auditLogStore) List(ctx context.Context) ([]storage.AuditEntry, error) {
+	values, err := s.client.ZRevRange(ctx, s.key(auditLogZSet), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entries := make([]storage.AuditEntry, 0, len(values))
+	var expired []interface{}
+	for _, data := range values {
+		var entry storage.AuditEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, err
+		}
+		if !now.Before(entry.ExpiresAt) {
+			expired = append(expired, data)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if len(expired) > 0 {
+		if err := s.client.ZRem(ctx, s.key(auditLogZSet), expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// blockPagesHash maps template names to JSON-encoded block pages
+const blockPagesHash = "kproxy:blockpages"
+
+type blockPageStore struct {
+	conn
+}
+
+// Get retrieves a block page template
+func (s *blockPageStore) Get(ctx context.Context, name string) (*storage.BlockPage, error) {
+	data, err := s.client.HGet(ctx, s.key(blockPagesHash), name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var page storage.BlockPage
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// List returns all block page templates sorted by name
+func (s *blockPageStore) List(ctx context.Context) ([]storage.BlockPage, error) {
+	values, err := s.client.HGetAll(ctx, s.key(blockPagesHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]storage.BlockPage, 0, len(values))
+	for _, data := range values {
+		var page storage.BlockPage
+		if err := json.Unmarshal([]byte(data), &page); err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Name < pages[j].Name })
+	return pages, nil
+}
+
+// Put creates or replaces a block page template
+func (s *blockPageStore) Put(ctx context.Context, page storage.BlockPage) error {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.key(blockPagesHash), page.Name, data).Err()
+}
+
+// Delete removes a block page template
+func (s *blockPageStore) Delete(ctx context.Context, name string) error {
+	removed, err := s.client.HDel(ctx, s.key(blockPagesHash), name).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
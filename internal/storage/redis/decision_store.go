@@ -0,0 +1,50 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+type decisionStore struct {
+	conn
+}
+
+func decisionKey(id string) string {
+	return "kproxy:decision:" + id
+}
+
+// Put stores a decision, letting Redis expire it at ExpiresAt
+func (s *decisionStore) Put(ctx context.Context, decision storage.Decision) error {
+	data, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Until(decision.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, s.key(decisionKey(decision.ID)), data, ttl).Err()
+}
+
+// Get retrieves an unexpired decision
+func (s *decisionStore) Get(ctx context.Context, id string) (*storage.Decision, error) {
+	data, err := s.client.Get(ctx, s.key(decisionKey(id))).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var decision storage.Decision
+	if err := json.Unmarshal(data, &decision); err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// deviceRulesHash maps rule IDs to JSON-encoded device rules
+const deviceRulesHash = "kproxy:devicerules"
+
+type deviceRuleStore struct {
+	conn
+}
+
+// List returns all device rules, oldest first
+func (s *deviceRuleStore) List(ctx context.Context) ([]storage.DeviceRule, error) {
+	values, err := s.client.HGetAll(ctx, s.key(deviceRulesHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]storage.DeviceRule, 0, len(values))
+	for _, data := range values {
+		var rule storage.DeviceRule
+		if err := json.Unmarshal([]byte(data), &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if !rules[i].Created.Equal(rules[j].Created) {
+			return rules[i].Created.Before(rules[j].Created)
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+// Put creates or replaces a device rule
+func (s *deviceRuleStore) Put(ctx context.Context, rule storage.DeviceRule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.key(deviceRulesHash), rule.ID, data).Err()
+}
+
+// Delete removes a device rule
+func (s *deviceRuleStore) Delete(ctx context.Context, id string) error {
+	removed, err := s.client.HDel(ctx, s.key(deviceRulesHash), id).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -10,7 +10,7 @@ import (
 )
 
 type dhcpLeaseStore struct {
-	client *redis.Client
+	conn
 }
 
 // Get retrieves a DHCP lease by MAC address
@@ -20,7 +20,7 @@ func (s *dhcpLeaseStore) Get(ctx context.Context, mac string) (*storage.DHCPLeas
 
 // GetByMAC retrieves a DHCP lease by MAC address
 func (s *dhcpLeaseStore) GetByMAC(ctx context.Context, mac string) (*storage.DHCPLease, error) {
-	macKey := fmt.Sprintf("kproxy:dhcp:mac:%s", mac)
+	macKey := s.key(fmt.Sprintf("kproxy:dhcp:mac:%s", mac))
 
 	data, err := s.client.HGetAll(ctx, macKey).Result()
 	if err != nil {
@@ -36,7 +36,7 @@ func (s *dhcpLeaseStore) GetByMAC(ctx context.Context, mac string) (*storage.DHC
 
 // GetByIP retrieves a DHCP lease by IP address using secondary index
 func (s *dhcpLeaseStore) GetByIP(ctx context.Context, ip string) (*storage.DHCPLease, error) {
-	ipKey := fmt.Sprintf("kproxy:dhcp:ip:%s", ip)
+	ipKey := s.key(fmt.Sprintf("kproxy:dhcp:ip:%s", ip))
 
 	// Get MAC from IP index
 	mac, err := s.client.Get(ctx, ipKey).Result()
@@ -53,7 +53,7 @@ func (s *dhcpLeaseStore) GetByIP(ctx context.Context, ip string) (*storage.DHCPL
 
 // List retrieves all DHCP leases
 func (s *dhcpLeaseStore) List(ctx context.Context) ([]storage.DHCPLease, error) {
-	leasesSet := "kproxy:dhcp:leases"
+	leasesSet := s.key("kproxy:dhcp:leases")
 
 	// Get all MAC addresses
 	macs, err := s.client.SMembers(ctx, leasesSet).Result()
@@ -70,7 +70,7 @@ func (s *dhcpLeaseStore) List(ctx context.Context) ([]storage.DHCPLease, error)
 	cmds := make([]*redis.MapStringStringCmd, len(macs))
 
 	for i, mac := range macs {
-		macKey := fmt.Sprintf("kproxy:dhcp:mac:%s", mac)
+		macKey := s.key(fmt.Sprintf("kproxy:dhcp:mac:%s", mac))
 		cmds[i] = pipe.HGetAll(ctx, macKey)
 	}
 
@@ -99,9 +99,9 @@ func (s *dhcpLeaseStore) List(ctx context.Context) ([]storage.DHCPLease, error)
 func (s *dhcpLeaseStore) Create(ctx context.Context, lease *storage.DHCPLease) error {
 	script := redis.NewScript(createDHCPLeaseScript)
 
-	macKey := fmt.Sprintf("kproxy:dhcp:mac:%s", lease.MAC)
-	ipKey := fmt.Sprintf("kproxy:dhcp:ip:%s", lease.IP)
-	leasesSet := "kproxy:dhcp:leases"
+	macKey := s.key(fmt.Sprintf("kproxy:dhcp:mac:%s", lease.MAC))
+	ipKey := s.key(fmt.Sprintf("kproxy:dhcp:ip:%s", lease.IP))
+	leasesSet := s.key("kproxy:dhcp:leases")
 
 	// Calculate TTL from ExpiresAt
 	ttlSeconds := int64(0)
@@ -138,7 +138,7 @@ func (s *dhcpLeaseStore) Create(ctx context.Context, lease *storage.DHCPLease) e
 
 // Delete deletes a DHCP lease by MAC address
 func (s *dhcpLeaseStore) Delete(ctx context.Context, mac string) error {
-	macKey := fmt.Sprintf("kproxy:dhcp:mac:%s", mac)
+	macKey := s.key(fmt.Sprintf("kproxy:dhcp:mac:%s", mac))
 
 	// Get the lease to find the IP for index cleanup
 	data, err := s.client.HGetAll(ctx, macKey).Result()
@@ -152,13 +152,13 @@ func (s *dhcpLeaseStore) Delete(ctx context.Context, mac string) error {
 	}
 
 	// Remove from leases set
-	if err := s.client.SRem(ctx, "kproxy:dhcp:leases", mac).Err(); err != nil {
+	if err := s.client.SRem(ctx, s.key("kproxy:dhcp:leases"), mac).Err(); err != nil {
 		return err
 	}
 
 	// Delete IP index if we have the IP
 	if ip, ok := data["ip"]; ok {
-		ipKey := fmt.Sprintf("kproxy:dhcp:ip:%s", ip)
+		ipKey := s.key(fmt.Sprintf("kproxy:dhcp:ip:%s", ip))
 		s.client.Del(ctx, ipKey)
 	}
 
@@ -173,7 +173,7 @@ func (s *dhcpLeaseStore) DeleteExpired(ctx context.Context) (int, error) {
 	// This operation is kept for interface compatibility but is effectively a no-op
 
 	// Optional: Scan and manually delete for immediate cleanup
-	leasesSet := "kproxy:dhcp:leases"
+	leasesSet := s.key("kproxy:dhcp:leases")
 	now := time.Now()
 
 	// Get all MAC addresses
@@ -191,7 +191,7 @@ func (s *dhcpLeaseStore) DeleteExpired(ctx context.Context) (int, error) {
 	cmds := make([]*redis.MapStringStringCmd, len(macs))
 
 	for i, mac := range macs {
-		macKey := fmt.Sprintf("kproxy:dhcp:mac:%s", mac)
+		macKey := s.key(fmt.Sprintf("kproxy:dhcp:mac:%s", mac))
 		cmds[i] = pipe.HGetAll(ctx, macKey)
 	}
 
@@ -218,12 +218,12 @@ func (s *dhcpLeaseStore) DeleteExpired(ctx context.Context) (int, error) {
 			mac := macs[i]
 
 			// Delete the lease
-			macKey := fmt.Sprintf("kproxy:dhcp:mac:%s", mac)
+			macKey := s.key(fmt.Sprintf("kproxy:dhcp:mac:%s", mac))
 			s.client.Del(ctx, macKey)
 
 			// Delete IP index
 			if ip, ok := data["ip"]; ok {
-				ipKey := fmt.Sprintf("kproxy:dhcp:ip:%s", ip)
+				ipKey := s.key(fmt.Sprintf("kproxy:dhcp:ip:%s", ip))
 				s.client.Del(ctx, ipKey)
 			}
 
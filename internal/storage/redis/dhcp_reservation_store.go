@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// dhcpReservationsHash maps client MAC addresses to JSON-encoded reservations
+const dhcpReservationsHash = "kproxy:dhcp:reservations"
+
+type dhcpReservationStore struct {
+	conn
+}
+
+// Get retrieves the reservation of a client
+func (s *dhcpReservationStore) Get(ctx context.Context, mac string) (*storage.DHCPReservation, error) {
+	data, err := s.client.HGet(ctx, s.key(dhcpReservationsHash), mac).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var reservation storage.DHCPReservation
+	if err := json.Unmarshal(data, &reservation); err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// List returns all reservations sorted by IP address
+func (s *dhcpReservationStore) List(ctx context.Context) ([]storage.DHCPReservation, error) {
+	values, err := s.client.HGetAll(ctx, s.key(dhcpReservationsHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := make([]storage.DHCPReservation, 0, len(values))
+	for _, data := range values {
+		var reservation storage.DHCPReservation
+		if err := json.Unmarshal([]byte(data), &reservation); err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, reservation)
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].IP < reservations[j].IP })
+	return reservations, nil
+}
+
+// Put creates or replaces the reservation of a client
+func (s *dhcpReservationStore) Put(ctx context.Context, reservation storage.DHCPReservation) error {
+	data, err := json.Marshal(reservation)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.key(dhcpReservationsHash), reservation.MAC, data).Err()
+}
+
+// Delete removes the reservation of a client
+func (s *dhcpReservationStore) Delete(ctx context.Context, mac string) error {
+	removed, err := s.client.HDel(ctx, s.key(dhcpReservationsHash), mac).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+type dnsCacheStore struct {
+	conn
+}
+
+// Get retrieves a cached DNS response
+func (s *dnsCacheStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := s.client.Get(ctx, s.key("kproxy:dns:cache:"+key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Set stores a DNS response, letting Redis expire it after ttl
+func (s *dnsCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.key("kproxy:dns:cache:"+key), value, ttl).Err()
+}
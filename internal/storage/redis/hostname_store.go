@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// hostnamesHash maps client IP addresses to JSON-encoded hostnames
+const hostnamesHash = "kproxy:hostnames"
+
+type hostnameStore struct {
+	conn
+}
+
+// Put creates or replaces the hostname of a client
+func (s *hostnameStore) Put(ctx context.Context, hostname storage.Hostname) error {
+	data, err := json.Marshal(hostname)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.key(hostnamesHash), hostname.IP, data).Err()
+}
+
+// List returns the unexpired hostnames sorted by IP address, removing the
+// expired ones
+func (s *hostnameStore) List(ctx context.Context) ([]storage.Hostname, error) {
+	values, err := s.client.HGetAll(ctx, s.key(hostnamesHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	hostnames := make([]storage.Hostname, 0, len(values))
+	var expired []string
+	for ip, data := range values {
+		var hostname storage.Hostname
+		if err := json.Unmarshal([]byte(data), &hostname); err != nil {
+			return nil, err
+		}
+		if !now.Before(hostname.ExpiresAt) {
+			expired = append(expired, ip)
+			continue
+		}
+		hostnames = append(hostnames, hostname)
+	}
+	if len(expired) > 0 {
+		if err := s.client.HDel(ctx, s.key(hostnamesHash), expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(hostnames, func(i, j int) bool { return hostnames[i].IP < hostnames[j].IP })
+	return hostnames, nil
+}
@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// issuedCertsZSet holds JSON-encoded issued certificate records scored by
+// when they were issued (Unix milliseconds)
+const issuedCertsZSet = "kproxy:ca:issued"
+
+type issuedCertStore struct {
+	conn
+}
+
+// Add records a minted certificate
+func (s *issuedCertStore) Add(ctx context.Context, cert storage.IssuedCert) error {
+	data, err := json.Marshal(cert)
+	if err != nil {
+		return err
+	}
+	return s.client.ZAdd(ctx, s.key(issuedCertsZSet), redis.Z{
+		Score:  float64(cert.Issued.UnixMilli()),
+		Member: data,
+	}).Err()
+}
+
+// List returns the unexpired records newest first, removing the expired ones
+func (s *issuedCertStore) List(ctx context.Context) ([]storage.IssuedCert, error) {
+	values, err := s.client.ZRevRange(ctx, s.key(issuedCertsZSet), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	certs := make([]storage.IssuedCert, 0, len(values))
+	var expired []interface{}
+	for _, data := range values {
+		var cert storage.IssuedCert
+		if err := json.Unmarshal([]byte(data), &cert); err != nil {
+			return nil, err
+		}
+		if !now.Before(cert.ExpiresAt) {
+			expired = append(expired, data)
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	if len(expired) > 0 {
+		if err := s.client.ZRem(ctx, s.key(issuedCertsZSet), expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return certs, nil
+}
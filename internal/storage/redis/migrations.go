@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// schemaVersionKey holds the schema version of the data in Redis
+	schemaVersionKey = "kproxy:schema:version"
+
+	// schemaLockKey is held by the instance upgrading the schema
+	schemaLockKey = "kproxy:schema:lock"
+
+	// migrationTimeout bounds an upgrade, including waiting for another
+	// instance's; the lock expires after it in case its holder dies
+	migrationTimeout = 10 * time.Minute
+
+	// schemaLockPoll is how often a held lock is tried again
+	schemaLockPoll = 100 * time.Millisecond
+)
+
+// migrations upgrade data written by older versions of KProxy. Add a step
+// at the end whenever a key layout or stored field changes; never change or
+// remove a released one.
+var migrations []storage.Migration[conn]
+
+// schemaStore keeps the schema version in schemaVersionKey
+type schemaStore struct {
+	conn
+}
+
+// SchemaVersion returns the stored schema version, 0 when none is recorded
+func (s *schemaStore) SchemaVersion(ctx context.Context) (int, error) {
+	version, err := s.client.Get(ctx, s.key(schemaVersionKey)).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// SetSchemaVersion records the schema version
+func (s *schemaStore) SetSchemaVersion(ctx context.Context, version int) error {
+	return s.client.Set(ctx, s.key(schemaVersionKey), version, 0).Err()
+}
+
+// migrate upgrades the data in Redis to the latest schema, returning the
+// versions applied. Instances sharing Redis take turns through a lock, so
+// each step runs once.
+func migrate(ctx context.Context, c conn, migrations []storage.Migration[conn]) ([]int, error) {
+	schema := &schemaStore{c}
+	current, err := schema.SchemaVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current == storage.LatestVersion(migrations) {
+		return nil, nil
+	}
+
+	token, err := lockToken()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		locked, err := c.client.SetNX(ctx, c.key(schemaLockKey), token, migrationTimeout).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock schema: %w", err)
+		}
+		if locked {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for another instance to upgrade the schema: %w", ctx.Err())
+		case <-time.After(schemaLockPoll):
+		}
+	}
+	defer func() {
+		_ = redis.NewScript(releaseLockScript).Run(context.Background(), c.client, []string{c.key(schemaLockKey)}, token).Err()
+	}()
+
+	// The version is read again under the lock, so steps another instance
+	// applied meanwhile are skipped
+	return storage.Migrate(ctx, schema, c, migrations)
+}
+
+// lockToken returns a random value identifying this instance's lock
+func lockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
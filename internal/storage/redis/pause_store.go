@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// pausesHash maps "kind:target" to JSON-encoded pauses
+const pausesHash = "kproxy:pauses"
+
+type pauseStore struct {
+	conn
+}
+
+// List returns all pauses, oldest first
+func (s *pauseStore) List(ctx context.Context) ([]storage.Pause, error) {
+	values, err := s.client.HGetAll(ctx, s.key(pausesHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pauses := make([]storage.Pause, 0, len(values))
+	for _, data := range values {
+		var pause storage.Pause
+		if err := json.Unmarshal([]byte(data), &pause); err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, pause)
+	}
+	sort.Slice(pauses, func(i, j int) bool {
+		if !pauses[i].Created.Equal(pauses[j].Created) {
+			return pauses[i].Created.Before(pauses[j].Created)
+		}
+		return pauseField(pauses[i].Kind, pauses[i].Target) < pauseField(pauses[j].Kind, pauses[j].Target)
+	})
+	return pauses, nil
+}
+
+// Put creates or replaces the pause of a device or profile
+func (s *pauseStore) Put(ctx context.Context, pause storage.Pause) error {
+	data, err := json.Marshal(pause)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.key(pausesHash), pauseField(pause.Kind, pause.Target), data).Err()
+}
+
+// Delete removes the pause of a device or profile
+func (s *pauseStore) Delete(ctx context.Context, kind, target string) error {
+	removed, err := s.client.HDel(ctx, s.key(pausesHash), pauseField(kind, target)).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// pauseField is the hash field of a pause
+func pauseField(kind, target string) string {
+	return kind + ":" + target
+}
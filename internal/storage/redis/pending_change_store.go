@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const pendingChangesSet = "kproxy:approvals"
+
+type pendingChangeStore struct {
+	conn
+}
+
+func pendingChangeKey(id string) string {
+	return "kproxy:approval:" + id
+}
+
+// Create stores a pending change, letting Redis expire it at ExpiresAt
+func (s *pendingChangeStore) Create(ctx context.Context, change storage.PendingChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Until(change.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, s.key(pendingChangeKey(change.ID)), data, ttl)
+	pipe.SAdd(ctx, s.key(pendingChangesSet), change.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Get retrieves an unexpired pending change
+func (s *pendingChangeStore) Get(ctx context.Context, id string) (*storage.PendingChange, error) {
+	data, err := s.client.Get(ctx, s.key(pendingChangeKey(id))).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var change storage.PendingChange
+	if err := json.Unmarshal(data, &change); err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// List returns the unexpired pending changes, oldest first. IDs of expired
+// changes are removed from the index.
+func (s *pendingChangeStore) List(ctx context.Context) ([]storage.PendingChange, error) {
+	ids, err := s.client.SMembers(ctx, s.key(pendingChangesSet)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]storage.PendingChange, 0, len(ids))
+	for _, id := range ids {
+		change, err := s.Get(ctx, id)
+		if errors.Is(err, storage.ErrNotFound) {
+			s.client.SRem(ctx, s.key(pendingChangesSet), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].RequestedAt.Before(changes[j].RequestedAt) })
+	return changes, nil
+}
+
+// Delete removes a pending change. Only one caller succeeds for a change,
+// so it can't be applied twice.
+func (s *pendingChangeStore) Delete(ctx context.Context, id string) error {
+	removed, err := s.client.Del(ctx, s.key(pendingChangeKey(id))).Result()
+	if err != nil {
+		return err
+	}
+	s.client.SRem(ctx, s.key(pendingChangesSet), id)
+	if removed == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -2,7 +2,11 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/goodtune/kproxy/internal/config"
@@ -12,13 +16,125 @@ import (
 
 // Store implements the storage.Store interface using Redis
 type Store struct {
-	client     *redis.Client
-	usageStore *usageStore
-	dhcpStore  *dhcpLeaseStore
+	client      redis.UniversalClient
+	schema      *schemaStore
+	usageStore  *usageStore
+	dhcpStore   *dhcpLeaseStore
+	reserved    *dhcpReservationStore
+	dnsCache    *dnsCacheStore
+	pending     *pendingChangeStore
+	blockPages  *blockPageStore
+	deviceRules *deviceRuleStore
+	ruleSets    *ruleSetStore
+	reports     *reportStore
+	pauses      *pauseStore
+	reloads     *reloadBus
+	decisions   *decisionStore
+	hostnames   *hostnameStore
+	issued      *issuedCertStore
+	audit       *auditLogStore
+	apiTokens   *apiTokenStore
+}
+
+// conn is the client the stores share, and how they name keys
+type conn struct {
+	client  redis.UniversalClient
+	cluster bool
+}
+
+// key returns the name in Redis of a "kproxy:" key. A cluster gets
+// "{kproxy}:" instead, a hash tag putting every key in one slot, so the
+// scripts and transactions spanning several keys keep working.
+func (c conn) key(name string) string {
+	if c.cluster {
+		return "{kproxy}" + strings.TrimPrefix(name, "kproxy")
+	}
+	return name
+}
+
+// scan calls fn with each batch of keys matching pattern; every master of
+// a cluster is scanned
+func (c conn) scan(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node, pattern, fn)
+		})
+	}
+	return scanNode(ctx, c.client, pattern, fn)
+}
+
+func scanNode(ctx context.Context, client redis.Cmdable, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
 }
 
 // Open creates a new Redis-backed storage instance
 func Open(cfg config.RedisConfig) (*Store, error) {
+	client, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := conn{client: client, cluster: cfg.Mode == config.RedisCluster}
+
+	// Ping to verify connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	// Upgrade data written by older versions
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancelMigrate()
+	if _, err := migrate(migrateCtx, c, migrations); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	// Initialize stores
+	store := &Store{
+		client:      client,
+		schema:      &schemaStore{c},
+		usageStore:  &usageStore{c},
+		dhcpStore:   &dhcpLeaseStore{c},
+		reserved:    &dhcpReservationStore{c},
+		dnsCache:    &dnsCacheStore{c},
+		pending:     &pendingChangeStore{c},
+		blockPages:  &blockPageStore{c},
+		deviceRules: &deviceRuleStore{c},
+		ruleSets:    &ruleSetStore{c},
+		reports:     &reportStore{c},
+		pauses:      &pauseStore{c},
+		reloads:     &reloadBus{c},
+		decisions:   &decisionStore{c},
+		hostnames:   &hostnameStore{c},
+		issued:      &issuedCertStore{c},
+		audit:       &auditLogStore{c},
+		apiTokens:   &apiTokenStore{c},
+	}
+
+	return store, nil
+}
+
+// newClient creates the client for the connection mode. Sentinel and
+// cluster clients follow a failover to the new master.
+func newClient(cfg config.RedisConfig) (redis.UniversalClient, error) {
 	// Parse timeouts
 	dialTimeout, err := time.ParseDuration(cfg.DialTimeout)
 	if err != nil {
@@ -35,40 +151,95 @@ func Open(cfg config.RedisConfig) (*Store, error) {
 		return nil, fmt.Errorf("invalid write_timeout: %w", err)
 	}
 
-	// Determine address
-	addr := cfg.Host
-	if cfg.Port > 0 {
-		addr = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create Redis client
-	client := redis.NewClient(&redis.Options{
-		Addr:         addr,
-		Password:     cfg.Password,
-		DB:           cfg.DB,
-		PoolSize:     cfg.PoolSize,
-		MinIdleConns: cfg.MinIdleConns,
-		DialTimeout:  dialTimeout,
-		ReadTimeout:  readTimeout,
-		WriteTimeout: writeTimeout,
-	})
+	switch cfg.Mode {
+	case "", config.RedisStandalone:
+		// Determine address
+		addr := cfg.Host
+		if cfg.Port > 0 {
+			addr = fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+		}
 
-	// Ping to verify connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		return redis.NewClient(&redis.Options{
+			Addr:         addr,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  dialTimeout,
+			ReadTimeout:  readTimeout,
+			WriteTimeout: writeTimeout,
+			TLSConfig:    tlsConfig,
+		}), nil
+	case config.RedisSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelUsername: cfg.SentinelUsername,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			DialTimeout:      dialTimeout,
+			ReadTimeout:      readTimeout,
+			WriteTimeout:     writeTimeout,
+			TLSConfig:        tlsConfig,
+		}), nil
+	case config.RedisCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Addrs,
+			Username:     cfg.Username,
+			Password:     cfg.Password,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  dialTimeout,
+			ReadTimeout:  readTimeout,
+			WriteTimeout: writeTimeout,
+			TLSConfig:    tlsConfig,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported redis mode: %s", cfg.Mode)
 	}
+}
 
-	// Initialize stores
-	store := &Store{
-		client:     client,
-		usageStore: &usageStore{client: client},
-		dhcpStore:  &dhcpLeaseStore{client: client},
+// newTLSConfig returns the TLS settings for connections, nil when TLS is
+// disabled
+func newTLSConfig(cfg config.RedisTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
 	}
 
-	return store, nil
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
 }
 
 // Close closes the Redis connection
@@ -76,6 +247,16 @@ func (s *Store) Close() error {
 	return s.client.Close()
 }
 
+// Ping checks that Redis answers, for storage health checks
+func (s *Store) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// SchemaVersion returns the schema version of the data in Redis
+func (s *Store) SchemaVersion(ctx context.Context) (int, error) {
+	return s.schema.SchemaVersion(ctx)
+}
+
 // Usage returns the UsageStore implementation
 func (s *Store) Usage() storage.UsageStore {
 	return s.usageStore
@@ -85,3 +266,73 @@ func (s *Store) Usage() storage.UsageStore {
 func (s *Store) DHCPLeases() storage.DHCPLeaseStore {
 	return s.dhcpStore
 }
+
+// DHCPReservations returns the DHCPReservationStore implementation
+func (s *Store) DHCPReservations() storage.DHCPReservationStore {
+	return s.reserved
+}
+
+// DNSCache returns the DNSCacheStore implementation
+func (s *Store) DNSCache() storage.DNSCacheStore {
+	return s.dnsCache
+}
+
+// PendingChanges returns the PendingChangeStore implementation
+func (s *Store) PendingChanges() storage.PendingChangeStore {
+	return s.pending
+}
+
+// BlockPages returns the BlockPageStore implementation
+func (s *Store) BlockPages() storage.BlockPageStore {
+	return s.blockPages
+}
+
+// DeviceRules returns the DeviceRuleStore implementation
+func (s *Store) DeviceRules() storage.DeviceRuleStore {
+	return s.deviceRules
+}
+
+// RuleSets returns the RuleSetStore implementation
+func (s *Store) RuleSets() storage.RuleSetStore {
+	return s.ruleSets
+}
+
+// Reports returns the ReportStore implementation
+func (s *Store) Reports() storage.ReportStore {
+	return s.reports
+}
+
+// Pauses returns the PauseStore implementation
+func (s *Store) Pauses() storage.PauseStore {
+	return s.pauses
+}
+
+// Reloads returns the ReloadBus implementation
+func (s *Store) Reloads() storage.ReloadBus {
+	return s.reloads
+}
+
+// Decisions returns the DecisionLogStore implementation
+func (s *Store) Decisions() storage.DecisionLogStore {
+	return s.decisions
+}
+
+// Hostnames returns the HostnameStore implementation
+func (s *Store) Hostnames() storage.HostnameStore {
+	return s.hostnames
+}
+
+// IssuedCerts returns the IssuedCertStore implementation
+func (s *Store) IssuedCerts() storage.IssuedCertStore {
+	return s.issued
+}
+
+// AuditLog returns the AuditLogStore implementation
+func (s *Store) AuditLog() storage.AuditLogStore {
+	return s.audit
+}
+
+// APITokens returns the APITokenStore implementation
+func (s *Store) APITokens() storage.APITokenStore {
+	return s.apiTokens
+}
@@ -2,6 +2,9 @@ package redis
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -383,3 +386,635 @@ func TestDHCPLeaseStore_CreatePreservesCreatedAt(t *testing.T) {
 		t.Errorf("Hostname was not updated. Expected 'updated-device', got '%s'", retrieved.Hostname)
 	}
 }
+
+func TestDHCPReservationStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	reservations := store.DHCPReservations()
+
+	created := time.Now()
+	for _, r := range []storage.DHCPReservation{
+		{MAC: "aa:bb:cc:dd:ee:02", IP: "192.168.1.51", CreatedAt: created},
+		{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.50", Hostname: "printer", CreatedAt: created},
+	} {
+		if err := reservations.Put(ctx, r); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	reservation, err := reservations.Get(ctx, "aa:bb:cc:dd:ee:01")
+	if err != nil || reservation.IP != "192.168.1.50" || reservation.Hostname != "printer" {
+		t.Errorf("Get = %+v (%v), want printer at 192.168.1.50", reservation, err)
+	}
+	list, err := reservations.List(ctx)
+	if err != nil || len(list) != 2 || list[0].IP != "192.168.1.50" || list[1].IP != "192.168.1.51" {
+		t.Errorf("List = %+v (%v), want both sorted by IP", list, err)
+	}
+
+	if err := reservations.Delete(ctx, "aa:bb:cc:dd:ee:01"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := reservations.Get(ctx, "aa:bb:cc:dd:ee:01"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+	if err := reservations.Delete(ctx, "aa:bb:cc:dd:ee:01"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestDNSCacheStore_Expiry(t *testing.T) {
+	store, mr := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	cache := store.DNSCache()
+
+	if _, err := cache.Get(ctx, "example.com./1/1"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for missing entry, got %v", err)
+	}
+
+	if err := cache.Set(ctx, "example.com./1/1", []byte("response"), 30*time.Second); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	data, err := cache.Get(ctx, "example.com./1/1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if string(data) != "response" {
+		t.Errorf("Expected cached value, got %q", data)
+	}
+
+	mr.FastForward(31 * time.Second)
+
+	if _, err := cache.Get(ctx, "example.com./1/1"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after expiry, got %v", err)
+	}
+}
+
+func TestPendingChangeStore(t *testing.T) {
+	store, mr := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	pending := store.PendingChanges()
+
+	now := time.Now()
+	for i, id := range []string{"second", "first"} {
+		change := storage.PendingChange{
+			ID:          id,
+			Action:      "device.remove",
+			Target:      "tablet",
+			RequestedBy: "alice",
+			RequestedAt: now.Add(-time.Duration(i) * time.Minute),
+			ExpiresAt:   now.Add(time.Duration(30-i*10) * time.Minute),
+		}
+		if err := pending.Create(ctx, change); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	changes, err := pending.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(changes) != 2 || changes[0].ID != "first" || changes[1].ID != "second" {
+		t.Errorf("Expected both changes oldest first, got %+v", changes)
+	}
+
+	if err := pending.Delete(ctx, "second"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := pending.Delete(ctx, "second"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+
+	// "first" expires after 20 minutes
+	mr.FastForward(21 * time.Minute)
+	if _, err := pending.Get(ctx, "first"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after expiry, got %v", err)
+	}
+	if changes, _ := pending.List(ctx); len(changes) != 0 {
+		t.Errorf("Expected no changes after expiry, got %+v", changes)
+	}
+}
+
+func TestDecisionStore(t *testing.T) {
+	store, mr := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	decisions := store.Decisions()
+
+	now := time.Now()
+	if err := decisions.Put(ctx, storage.Decision{ID: "d1", Kind: "proxy", Time: now, ExpiresAt: now.Add(time.Hour), Input: []byte(`{"host":"example.com"}`), Result: []byte(`{"action":"ALLOW"}`)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	d, err := decisions.Get(ctx, "d1")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if d.Kind != "proxy" || string(d.Input) != `{"host":"example.com"}` || string(d.Result) != `{"action":"ALLOW"}` {
+		t.Errorf("Get = %+v, want the recorded decision", d)
+	}
+
+	mr.FastForward(61 * time.Minute)
+	if _, err := decisions.Get(ctx, "d1"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after expiry, got %v", err)
+	}
+	if _, err := decisions.Get(ctx, "missing"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a missing decision, got %v", err)
+	}
+}
+
+func TestHostnameStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	hostnames := store.Hostnames()
+
+	now := time.Now()
+	for _, h := range []storage.Hostname{
+		{IP: "192.168.5.142", MAC: "aa:bb:cc:dd:ee:01", Name: "Liams-iPad", Source: "mdns", Seen: now, ExpiresAt: now.Add(time.Hour)},
+		{IP: "192.168.5.20", Name: "old-laptop", Source: "netbios", Seen: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+		{IP: "192.168.5.10", Name: "printer", Source: "dhcp", Seen: now, ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := hostnames.Put(ctx, h); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	list, err := hostnames.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "printer" || list[1].Name != "Liams-iPad" || list[1].MAC != "aa:bb:cc:dd:ee:01" {
+		t.Errorf("List = %+v, want the unexpired hostnames by IP", list)
+	}
+	if n, _ := store.client.HLen(ctx, hostnamesHash).Result(); n != 2 {
+		t.Errorf("Expected the expired hostname removed, %d left", n)
+	}
+}
+
+func TestIssuedCertStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	issued := store.IssuedCerts()
+
+	now := time.Now()
+	for _, cert := range []storage.IssuedCert{
+		{Serial: "01", Host: "old.example", Issued: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+		{Serial: "02", Host: "example.com", Client: "192.168.1.20", Issued: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{Serial: "03", Host: "example.org", Issued: now, ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := issued.Add(ctx, cert); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	list, err := issued.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 || list[0].Serial != "03" || list[1].Client != "192.168.1.20" {
+		t.Errorf("List = %+v, want the unexpired records newest first", list)
+	}
+	if n, _ := store.client.ZCard(ctx, issuedCertsZSet).Result(); n != 2 {
+		t.Errorf("Expected the expired record removed, %d left", n)
+	}
+}
+
+func TestAuditLogStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	audit := store.AuditLog()
+
+	now := time.Now()
+	for _, entry := range []storage.AuditEntry{
+		{ID: "01", Account: "admin", Path: "/api/rules/old", Time: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+		{ID: "02", Account: "parent", Path: "/api/rules/games", Time: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour),
+			Changes: []storage.AuditChange{{Field: "action", Before: []byte(`"allow"`), After: []byte(`"block"`)}}},
+		{ID: "03", Account: "admin", Path: "/api/maintenance", Time: now, ExpiresAt: now.Add(time.Hour)},
+	} {
+		if err := audit.Add(ctx, entry); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	list, err := audit.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != "03" || list[1].Account != "parent" || string(list[1].Changes[0].After) != `"block"` {
+		t.Errorf("List = %+v, want the unexpired entries newest first", list)
+	}
+	if n, _ := store.client.ZCard(ctx, auditLogZSet).Result(); n != 2 {
+		t.Errorf("Expected the expired entry removed, %d left", n)
+	}
+}
+
+func TestBlockPageStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	pages := store.BlockPages()
+
+	for _, name := range []string{"gaming", "family"} {
+		if err := pages.Put(ctx, storage.BlockPage{Name: name, Template: "<p>{{.Reason}}</p>", UpdatedAt: time.Now()}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := pages.Put(ctx, storage.BlockPage{Name: "gaming", Template: "<p>Game over</p>"}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	page, err := pages.Get(ctx, "gaming")
+	if err != nil || page.Template != "<p>Game over</p>" {
+		t.Errorf("Get = %+v (%v), want the replaced template", page, err)
+	}
+	list, err := pages.List(ctx)
+	if err != nil || len(list) != 2 || list[0].Name != "family" || list[1].Name != "gaming" {
+		t.Errorf("List = %+v (%v), want family and gaming", list, err)
+	}
+
+	if err := pages.Delete(ctx, "gaming"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := pages.Delete(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+	if _, err := pages.Get(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func TestDeviceRuleStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	rules := store.DeviceRules()
+
+	created := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	until := created.Add(time.Hour)
+	for i, id := range []string{"device-rule-2", "device-rule-1"} {
+		rule := storage.DeviceRule{ID: id, Device: "tablet", Domain: "roblox.com", Action: "allow", Until: &until, Created: created.Add(time.Duration(-i) * time.Minute)}
+		if err := rules.Put(ctx, rule); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	list, err := rules.List(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != "device-rule-1" || list[1].ID != "device-rule-2" {
+		t.Fatalf("List = %+v (%v), want device-rule-1 then device-rule-2", list, err)
+	}
+	if list[0].Device != "tablet" || list[0].Until == nil || !list[0].Until.Equal(until) {
+		t.Errorf("List()[0] = %+v, want the stored rule", list[0])
+	}
+
+	if err := rules.Delete(ctx, "device-rule-1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := rules.Delete(ctx, "device-rule-1"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestPauseStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	pauses := store.Pauses()
+
+	created := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	until := created.Add(time.Hour)
+	if err := pauses.Put(ctx, storage.Pause{Kind: storage.PauseProfile, Target: "child", Created: created}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := pauses.Put(ctx, storage.Pause{Kind: storage.PauseDevice, Target: "child", Until: &until, Created: created.Add(-time.Minute)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	list, err := pauses.List(ctx)
+	if err != nil || len(list) != 2 || list[0].Kind != storage.PauseDevice || list[1].Kind != storage.PauseProfile {
+		t.Fatalf("List = %+v (%v), want the device then the profile pause", list, err)
+	}
+	if list[0].Target != "child" || list[0].Until == nil || !list[0].Until.Equal(until) {
+		t.Errorf("List()[0] = %+v, want the stored pause", list[0])
+	}
+
+	if err := pauses.Delete(ctx, storage.PauseDevice, "child"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := pauses.Delete(ctx, storage.PauseDevice, "child"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestReloadBus(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	bus := store.Reloads()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	received := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- bus.Subscribe(ctx, func(origin string) {
+			select {
+			case received <- origin:
+			default:
+			}
+		})
+	}()
+
+	// Publish until the subscription is in place
+	deadline := time.After(5 * time.Second)
+	for got := ""; got == ""; {
+		if err := bus.Publish(ctx, "node-a"); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+		select {
+		case got = <-received:
+			if got != "node-a" {
+				t.Errorf("received %q, want node-a", got)
+			}
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("notice never received")
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Subscribe() = %v after cancel, want nil", err)
+	}
+}
+
+func TestRuleSetStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	sets := store.RuleSets()
+
+	rules := []storage.RuleSetRule{{ID: "block-tiktok", Domains: []string{".tiktok.com"}, Action: "block", Category: "social"}}
+	for _, id := range []string{"social-media", "gaming"} {
+		if err := sets.Put(ctx, storage.RuleSet{ID: id, Name: id, Profiles: []string{"child"}, Rules: rules, UpdatedAt: time.Now()}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := sets.Put(ctx, storage.RuleSet{ID: "gaming", Name: "Gaming", Profiles: []string{"child", "teen"}}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	set, err := sets.Get(ctx, "gaming")
+	if err != nil || set.Name != "Gaming" || len(set.Profiles) != 2 {
+		t.Errorf("Get = %+v (%v), want the replaced rule set", set, err)
+	}
+	list, err := sets.List(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != "gaming" || list[1].ID != "social-media" {
+		t.Fatalf("List = %+v (%v), want gaming and social-media", list, err)
+	}
+	if len(list[1].Rules) != 1 || list[1].Rules[0].Domains[0] != ".tiktok.com" {
+		t.Errorf("List()[1] = %+v, want the stored rules", list[1])
+	}
+
+	if err := sets.Delete(ctx, "gaming"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := sets.Delete(ctx, "gaming"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+}
+
+func TestReportStore(t *testing.T) {
+	store, mr := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	reports := store.Reports()
+
+	day := storage.ReportDay{
+		Date:       "2026-03-02",
+		Profile:    "child",
+		Categories: map[string]storage.ReportCategory{"video": {Requests: 3, Minutes: 1}, "a:b": {Blocked: 2}},
+		Sites:      map[string]int{"youtube.com": 3},
+		Blocked:    map[string]int{"tiktok.com": 2},
+		BlockKinds: map[string]int{"rule": 2},
+	}
+	for i := 0; i < 2; i++ {
+		if err := reports.Add(ctx, day); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	got, err := reports.Get(ctx, "2026-03-02", "child")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Categories["video"].Requests != 6 || got.Categories["video"].Minutes != 2 || got.Categories["a:b"].Blocked != 4 {
+		t.Errorf("Categories = %+v, want the counts added twice", got.Categories)
+	}
+	if got.Sites["youtube.com"] != 6 || got.Blocked["tiktok.com"] != 4 || got.BlockKinds["rule"] != 4 {
+		t.Errorf("Get = %+v, want the counts added twice", got)
+	}
+	if ttl := mr.TTL(reportKey("2026-03-02", "child")); ttl <= 0 {
+		t.Errorf("report TTL = %s, want an expiry", ttl)
+	}
+	if _, err := reports.Get(ctx, "2026-03-01", "child"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a day without traffic, got %v", err)
+	}
+}
+
+func TestAPITokenStore(t *testing.T) {
+	store, _ := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	tokens := store.APITokens()
+
+	created := time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC)
+	expires := created.Add(24 * time.Hour)
+	if err := tokens.Put(ctx, storage.APIToken{ID: "b", Name: "home-assistant", Hash: "00", Scopes: []string{"read"}, Created: created, ExpiresAt: &expires}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := tokens.Put(ctx, storage.APIToken{ID: "a", Name: "backup", Hash: "11", Scopes: []string{"*"}, Created: created.Add(-time.Hour)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	list, err := tokens.List(ctx)
+	if err != nil || len(list) != 2 || list[0].Name != "backup" || list[1].ExpiresAt == nil || !list[1].ExpiresAt.Equal(expires) {
+		t.Errorf("List = %+v (%v), want backup then home-assistant", list, err)
+	}
+
+	if err := tokens.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := tokens.Delete(ctx, "a"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound deleting again, got %v", err)
+	}
+	if list, _ := tokens.List(ctx); len(list) != 1 || list[0].ID != "b" {
+		t.Errorf("List after Delete = %+v, want home-assistant", list)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer client.Close()
+
+	ctx := context.Background()
+	c := conn{client: client}
+	steps := []storage.Migration[conn]{
+		{Version: 1, Description: "rename rule sets", Apply: func(ctx context.Context, c conn) error {
+			return c.client.Rename(ctx, c.key("kproxy:sets"), c.key(ruleSetsHash)).Err()
+		}},
+	}
+	if err := client.HSet(ctx, "kproxy:sets", "games", `{"id":"games","name":"Games"}`).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance holds the lock: wait for it
+	mr.Set(schemaLockKey, "other")
+	short, cancel := context.WithTimeout(ctx, 3*schemaLockPoll)
+	defer cancel()
+	if _, err := migrate(short, c, steps); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("migrate() while locked = %v, want a timeout", err)
+	}
+	mr.Del(schemaLockKey)
+
+	applied, err := migrate(ctx, c, steps)
+	if err != nil || len(applied) != 1 {
+		t.Fatalf("migrate() = %v, %v; want version 1 applied", applied, err)
+	}
+	if version, _ := mr.Get(schemaVersionKey); version != "1" || mr.Exists(schemaLockKey) {
+		t.Errorf("version %q, lock held %v; want version 1 and the lock released", version, mr.Exists(schemaLockKey))
+	}
+	if sets, err := (&ruleSetStore{c}).List(ctx); err != nil || len(sets) != 1 {
+		t.Errorf("rule sets after migrating = %+v (%v), want games", sets, err)
+	}
+
+	// Up to date: nothing to do
+	if applied, err := migrate(ctx, c, steps); err != nil || len(applied) != 0 {
+		t.Errorf("migrate() again = %v, %v; want nothing applied", applied, err)
+	}
+}
+
+func TestOpenNewerSchema(t *testing.T) {
+	store, mr := setupTestStore(t)
+	if version, err := store.SchemaVersion(context.Background()); err != nil || version != storage.LatestVersion(migrations) {
+		t.Errorf("SchemaVersion() = %d, %v; want %d", version, err, storage.LatestVersion(migrations))
+	}
+	_ = store.Close()
+
+	// Data upgraded by a newer KProxy isn't touched
+	mr.Set(schemaVersionKey, "1000")
+	_, err := Open(config.RedisConfig{Host: mr.Addr(), DialTimeout: "5s", ReadTimeout: "3s", WriteTimeout: "3s"})
+	if !errors.Is(err, storage.ErrSchemaTooNew) {
+		t.Errorf("Open() = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestOpenCluster(t *testing.T) {
+	// miniredis answers CLUSTER SLOTS as a single node holding every slot
+	mr := miniredis.RunT(t)
+	store, err := Open(config.RedisConfig{
+		Mode:         config.RedisCluster,
+		Addrs:        []string{mr.Addr()},
+		DialTimeout:  "5s",
+		ReadTimeout:  "3s",
+		WriteTimeout: "3s",
+	})
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	started := time.Now().Add(-48 * time.Hour)
+	session := storage.UsageSession{ID: "s1", DeviceID: "d1", LimitID: "games", StartedAt: started, LastActivity: started}
+	if err := store.Usage().UpsertSession(ctx, session); err != nil {
+		t.Fatalf("UpsertSession failed: %v", err)
+	}
+
+	// Keys share the {kproxy} hash tag, so they land in one slot
+	if !mr.Exists("{kproxy}:session:s1") || mr.Exists("kproxy:session:s1") {
+		t.Errorf("keys = %v, want the session under {kproxy}:", mr.Keys())
+	}
+
+	// Scanning visits the cluster's masters
+	deleted, err := store.Usage().DeleteInactiveSessionsBefore(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil || deleted != 1 {
+		t.Errorf("DeleteInactiveSessionsBefore() = %d, %v; want 1 deleted", deleted, err)
+	}
+}
+
+func TestOpenModes(t *testing.T) {
+	if _, err := Open(config.RedisConfig{Mode: "replicated", DialTimeout: "5s", ReadTimeout: "3s", WriteTimeout: "3s"}); err == nil {
+		t.Error("Open() with an unknown mode succeeded")
+	}
+
+	// Sentinels that aren't there fail at the first ping
+	_, err := Open(config.RedisConfig{
+		Mode:         config.RedisSentinel,
+		Addrs:        []string{"127.0.0.1:1"},
+		MasterName:   "kproxy",
+		DialTimeout:  "100ms",
+		ReadTimeout:  "100ms",
+		WriteTimeout: "100ms",
+	})
+	if err == nil {
+		t.Error("Open() without reachable sentinels succeeded")
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	if cfg, err := newTLSConfig(config.RedisTLSConfig{CAFile: "/nonexistent"}); cfg != nil || err != nil {
+		t.Errorf("newTLSConfig(disabled) = %v, %v; want nil", cfg, err)
+	}
+
+	cfg, err := newTLSConfig(config.RedisTLSConfig{Enabled: true, ServerName: "redis.lan"})
+	if err != nil || cfg.ServerName != "redis.lan" || cfg.RootCAs != nil {
+		t.Errorf("newTLSConfig() = %+v, %v; want the system roots and server name", cfg, err)
+	}
+
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tls := range []config.RedisTLSConfig{
+		{Enabled: true, CAFile: filepath.Join(dir, "missing.crt")},
+		{Enabled: true, CAFile: notPEM},
+		{Enabled: true, CertFile: notPEM, KeyFile: notPEM},
+	} {
+		if _, err := newTLSConfig(tls); err == nil {
+			t.Errorf("newTLSConfig(%+v) succeeded", tls)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	store, mr := setupTestStore(t)
+	defer func() { _ = store.Close() }()
+
+	ctx := context.Background()
+	if err := store.Ping(ctx); err != nil {
+		t.Errorf("Ping() = %v", err)
+	}
+	mr.Close()
+	if err := store.Ping(ctx); err == nil {
+		t.Error("Ping() with Redis down succeeded")
+	}
+}
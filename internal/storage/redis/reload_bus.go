@@ -0,0 +1,47 @@
+package redis
+
+import (
+	"context"
+)
+
+// reloadChannel is the pub/sub channel of reload notices; messages are the
+// origin of the change
+const reloadChannel = "kproxy:reload"
+
+type reloadBus struct {
+	conn
+}
+
+// Publish announces a change made by origin to every subscribed instance
+func (b *reloadBus) Publish(ctx context.Context, origin string) error {
+	return b.client.Publish(ctx, reloadChannel, origin).Err()
+}
+
+// Subscribe calls fn for every notice until ctx is done. The subscription
+// is reestablished after connection failures; notices published meanwhile
+// are lost.
+func (b *reloadBus) Subscribe(ctx context.Context, fn func(origin string)) error {
+	pubsub := b.client.Subscribe(ctx, reloadChannel)
+	defer func() { _ = pubsub.Close() }()
+
+	// Wait for the subscription, so a failing connection is reported
+	if _, err := pubsub.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			fn(msg.Payload)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// reportTTL is how long a day's report counts are kept
+const reportTTL = 60 * 24 * time.Hour
+
+// Report hashes are kproxy:report:<date>:<profile>, with one counter field
+// per category count (c:<category>:<count>), allowed host (s:<host>),
+// blocked host (b:<host>) and block page (k:<block page>)
+const (
+	reportCategoryPrefix  = "c:"
+	reportSitePrefix      = "s:"
+	reportBlockedPrefix   = "b:"
+	reportBlockKindPrefix = "k:"
+)
+
+type reportStore struct {
+	conn
+}
+
+func reportKey(date, profile string) string {
+	return fmt.Sprintf("kproxy:report:%s:%s", date, profile)
+}
+
+// Add adds to the counts of a day and profile
+func (s *reportStore) Add(ctx context.Context, day storage.ReportDay) error {
+	key := s.key(reportKey(day.Date, day.Profile))
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr := func(field string, n int) {
+			if n != 0 {
+				pipe.HIncrBy(ctx, key, field, int64(n))
+			}
+		}
+		for name, c := range day.Categories {
+			incr(reportCategoryPrefix+name+":requests", c.Requests)
+			incr(reportCategoryPrefix+name+":blocked", c.Blocked)
+			incr(reportCategoryPrefix+name+":minutes", c.Minutes)
+		}
+		for host, n := range day.Sites {
+			incr(reportSitePrefix+host, n)
+		}
+		for host, n := range day.Blocked {
+			incr(reportBlockedPrefix+host, n)
+		}
+		for kind, n := range day.BlockKinds {
+			incr(reportBlockKindPrefix+kind, n)
+		}
+		pipe.Expire(ctx, key, reportTTL)
+		return nil
+	})
+	return err
+}
+
+// Get retrieves the counts of a day and profile
+func (s *reportStore) Get(ctx context.Context, date, profile string) (*storage.ReportDay, error) {
+	values, err := s.client.HGetAll(ctx, s.key(reportKey(date, profile))).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	day := &storage.ReportDay{Date: date, Profile: profile}
+	counts := func(m *map[string]int, key string, n int) {
+		if *m == nil {
+			*m = make(map[string]int)
+		}
+		(*m)[key] = n
+	}
+	for field, value := range values {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("report field %s: %w", field, err)
+		}
+		switch {
+		case strings.HasPrefix(field, reportCategoryPrefix):
+			// Category names may contain colons, the count never does
+			rest := strings.TrimPrefix(field, reportCategoryPrefix)
+			i := strings.LastIndex(rest, ":")
+			if i < 0 {
+				continue
+			}
+			if day.Categories == nil {
+				day.Categories = make(map[string]storage.ReportCategory)
+			}
+			c := day.Categories[rest[:i]]
+			switch rest[i+1:] {
+			case "requests":
+				c.Requests = n
+			case "blocked":
+				c.Blocked = n
+			case "minutes":
+				c.Minutes = n
+			}
+			day.Categories[rest[:i]] = c
+		case strings.HasPrefix(field, reportSitePrefix):
+			counts(&day.Sites, strings.TrimPrefix(field, reportSitePrefix), n)
+		case strings.HasPrefix(field, reportBlockedPrefix):
+			counts(&day.Blocked, strings.TrimPrefix(field, reportBlockedPrefix), n)
+		case strings.HasPrefix(field, reportBlockKindPrefix):
+			counts(&day.BlockKinds, strings.TrimPrefix(field, reportBlockKindPrefix), n)
+		}
+	}
+	return day, nil
+}
+
+// DeleteBefore deletes the counts of days before cutoffDate
+// NOTE: Report hashes expire after reportTTL, so this is a no-op like
+// usageStore.DeleteDailyUsageBefore
+func (s *reportStore) DeleteBefore(ctx context.Context, cutoffDate string) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,75 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// ruleSetsHash maps rule set IDs to JSON-encoded rule sets
+const ruleSetsHash = "kproxy:rulesets"
+
+type ruleSetStore struct {
+	conn
+}
+
+// Get retrieves a rule set
+func (s *ruleSetStore) Get(ctx context.Context, id string) (*storage.RuleSet, error) {
+	data, err := s.client.HGet(ctx, s.key(ruleSetsHash), id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var set storage.RuleSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// List returns all rule sets sorted by ID
+func (s *ruleSetStore) List(ctx context.Context) ([]storage.RuleSet, error) {
+	values, err := s.client.HGetAll(ctx, s.key(ruleSetsHash)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make([]storage.RuleSet, 0, len(values))
+	for _, data := range values {
+		var set storage.RuleSet
+		if err := json.Unmarshal([]byte(data), &set); err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].ID < sets[j].ID })
+	return sets, nil
+}
+
+// Put creates or replaces a rule set
+func (s *ruleSetStore) Put(ctx context.Context, set storage.RuleSet) error {
+	data, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, s.key(ruleSetsHash), set.ID, data).Err()
+}
+
+// Delete removes a rule set
+func (s *ruleSetStore) Delete(ctx context.Context, id string) error {
+	removed, err := s.client.HDel(ctx, s.key(ruleSetsHash), id).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
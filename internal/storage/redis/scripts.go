@@ -11,4 +11,7 @@ var (
 
 	//go:embed scripts/create_dhcp_lease.lua
 	createDHCPLeaseScript string
+
+	//go:embed scripts/release_lock.lua
+	releaseLockScript string
 )
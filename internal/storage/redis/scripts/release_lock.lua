@@ -0,0 +1,18 @@
+-- release_lock.lua
+-- Releases a lock only when it is still held by the caller
+--
+-- KEYS:
+--   KEYS[1]: lock_key       (kproxy:schema:lock)
+--
+-- ARGV:
+--   ARGV[1]: token
+
+local lock_key = KEYS[1]       -- kproxy:schema:lock
+local token = ARGV[1]
+
+-- The lock may have expired and been taken by another instance
+if redis.call('GET', lock_key) == token then
+  return redis.call('DEL', lock_key)
+end
+
+return 0
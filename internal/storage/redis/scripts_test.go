@@ -474,3 +474,20 @@ func TestCreateDHCPLeaseScript_PreserveCreatedAt(t *testing.T) {
 		t.Errorf("Expected created_at to be preserved as %s, got %s", originalCreated, createdAt.Val())
 	}
 }
+
+func TestReleaseLockScript(t *testing.T) {
+	client, mr := setupTestRedis(t)
+	defer client.Close()
+	defer mr.Close()
+
+	ctx := context.Background()
+	script := redis.NewScript(releaseLockScript)
+
+	mr.Set(schemaLockKey, "theirs")
+	if released, err := script.Run(ctx, client, []string{schemaLockKey}, "mine").Int(); err != nil || released != 0 || !mr.Exists(schemaLockKey) {
+		t.Errorf("releasing another's lock = %d, %v; want it kept", released, err)
+	}
+	if released, err := script.Run(ctx, client, []string{schemaLockKey}, "theirs").Int(); err != nil || released != 1 || mr.Exists(schemaLockKey) {
+		t.Errorf("releasing own lock = %d, %v; want it deleted", released, err)
+	}
+}
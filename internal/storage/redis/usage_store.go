@@ -10,16 +10,16 @@ import (
 )
 
 type usageStore struct {
-	client *redis.Client
+	conn
 }
 
 // UpsertSession creates or updates a usage session
 func (s *usageStore) UpsertSession(ctx context.Context, session storage.UsageSession) error {
 	script := redis.NewScript(upsertSessionScript)
 
-	sessionKey := fmt.Sprintf("kproxy:session:%s", session.ID)
-	activeSet := "kproxy:sessions:active"
-	deviceKey := fmt.Sprintf("kproxy:sessions:device:%s:%s", session.DeviceID, session.LimitID)
+	sessionKey := s.key(fmt.Sprintf("kproxy:session:%s", session.ID))
+	activeSet := s.key("kproxy:sessions:active")
+	deviceKey := s.key(fmt.Sprintf("kproxy:sessions:device:%s:%s", session.DeviceID, session.LimitID))
 
 	active := "0"
 	if session.Active {
@@ -42,7 +42,7 @@ func (s *usageStore) UpsertSession(ctx context.Context, session storage.UsageSes
 
 // DeleteSession removes a session by ID
 func (s *usageStore) DeleteSession(ctx context.Context, id string) error {
-	sessionKey := fmt.Sprintf("kproxy:session:%s", id)
+	sessionKey := s.key(fmt.Sprintf("kproxy:session:%s", id))
 
 	// Get session to find device/limit for cleanup
 	data, err := s.client.HGetAll(ctx, sessionKey).Result()
@@ -56,14 +56,14 @@ func (s *usageStore) DeleteSession(ctx context.Context, id string) error {
 	}
 
 	// Remove from active set
-	if err := s.client.SRem(ctx, "kproxy:sessions:active", id).Err(); err != nil {
+	if err := s.client.SRem(ctx, s.key("kproxy:sessions:active"), id).Err(); err != nil {
 		return err
 	}
 
 	// Remove device mapping if we have the data
 	if deviceID, ok := data["device_id"]; ok {
 		if limitID, ok := data["limit_id"]; ok {
-			deviceKey := fmt.Sprintf("kproxy:sessions:device:%s:%s", deviceID, limitID)
+			deviceKey := s.key(fmt.Sprintf("kproxy:sessions:device:%s:%s", deviceID, limitID))
 			s.client.Del(ctx, deviceKey)
 		}
 	}
@@ -73,7 +73,7 @@ func (s *usageStore) DeleteSession(ctx context.Context, id string) error {
 
 // GetSession retrieves a session by ID
 func (s *usageStore) GetSession(ctx context.Context, id string) (*storage.UsageSession, error) {
-	sessionKey := fmt.Sprintf("kproxy:session:%s", id)
+	sessionKey := s.key(fmt.Sprintf("kproxy:session:%s", id))
 
 	data, err := s.client.HGetAll(ctx, sessionKey).Result()
 	if err != nil {
@@ -90,7 +90,7 @@ func (s *usageStore) GetSession(ctx context.Context, id string) (*storage.UsageS
 // ListActiveSessions returns all active sessions
 func (s *usageStore) ListActiveSessions(ctx context.Context) ([]storage.UsageSession, error) {
 	// Get all active session IDs
-	sessionIDs, err := s.client.SMembers(ctx, "kproxy:sessions:active").Result()
+	sessionIDs, err := s.client.SMembers(ctx, s.key("kproxy:sessions:active")).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -104,7 +104,7 @@ func (s *usageStore) ListActiveSessions(ctx context.Context) ([]storage.UsageSes
 	cmds := make([]*redis.MapStringStringCmd, len(sessionIDs))
 
 	for i, id := range sessionIDs {
-		sessionKey := fmt.Sprintf("kproxy:session:%s", id)
+		sessionKey := s.key(fmt.Sprintf("kproxy:session:%s", id))
 		cmds[i] = pipe.HGetAll(ctx, sessionKey)
 	}
 
@@ -131,7 +131,7 @@ func (s *usageStore) ListActiveSessions(ctx context.Context) ([]storage.UsageSes
 
 // GetDailyUsage retrieves daily usage for a specific date, device, and limit
 func (s *usageStore) GetDailyUsage(ctx context.Context, date string, deviceID, limitID string) (*storage.DailyUsage, error) {
-	usageKey := fmt.Sprintf("kproxy:usage:daily:%s:%s:%s", date, deviceID, limitID)
+	usageKey := s.key(fmt.Sprintf("kproxy:usage:daily:%s:%s:%s", date, deviceID, limitID))
 
 	data, err := s.client.HGetAll(ctx, usageKey).Result()
 	if err != nil {
@@ -147,7 +147,7 @@ func (s *usageStore) GetDailyUsage(ctx context.Context, date string, deviceID, l
 
 // ListDailyUsage returns all daily usage entries for a specific date
 func (s *usageStore) ListDailyUsage(ctx context.Context, date string) ([]storage.DailyUsage, error) {
-	indexKey := fmt.Sprintf("kproxy:usage:daily:index:%s", date)
+	indexKey := s.key(fmt.Sprintf("kproxy:usage:daily:index:%s", date))
 
 	// Get all device:limit pairs for this date
 	pairs, err := s.client.SMembers(ctx, indexKey).Result()
@@ -164,7 +164,7 @@ func (s *usageStore) ListDailyUsage(ctx context.Context, date string) ([]storage
 	cmds := make([]*redis.MapStringStringCmd, len(pairs))
 
 	for i, pair := range pairs {
-		usageKey := fmt.Sprintf("kproxy:usage:daily:%s:%s", date, pair)
+		usageKey := s.key(fmt.Sprintf("kproxy:usage:daily:%s:%s", date, pair))
 		cmds[i] = pipe.HGetAll(ctx, usageKey)
 	}
 
@@ -193,8 +193,8 @@ func (s *usageStore) ListDailyUsage(ctx context.Context, date string) ([]storage
 func (s *usageStore) IncrementDailyUsage(ctx context.Context, date string, deviceID, limitID string, seconds int64) error {
 	script := redis.NewScript(incrementDailyUsageScript)
 
-	usageKey := fmt.Sprintf("kproxy:usage:daily:%s:%s:%s", date, deviceID, limitID)
-	indexKey := fmt.Sprintf("kproxy:usage:daily:index:%s", date)
+	usageKey := s.key(fmt.Sprintf("kproxy:usage:daily:%s:%s:%s", date, deviceID, limitID))
+	indexKey := s.key(fmt.Sprintf("kproxy:usage:daily:index:%s", date))
 
 	keys := []string{usageKey, indexKey}
 	args := []interface{}{date, deviceID, limitID, seconds}
@@ -223,80 +223,68 @@ func (s *usageStore) DeleteInactiveSessionsBefore(ctx context.Context, cutoff ti
 	// For now, we rely on TTL for cleanup
 
 	// Optional: Scan and delete manually for immediate cleanup
-	var cursor uint64
 	var deletedCount int
 
-	for {
-		var keys []string
-		var err error
-		keys, cursor, err = s.client.Scan(ctx, cursor, "kproxy:session:*", 100).Result()
-		if err != nil {
-			return deletedCount, err
+	err := s.scan(ctx, s.key("kproxy:session:*"), func(keys []string) error {
+		// Use pipeline to check each session
+		pipe := s.client.Pipeline()
+		cmds := make([]*redis.MapStringStringCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipe.HGetAll(ctx, key)
 		}
 
-		if len(keys) > 0 {
-			// Use pipeline to check each session
-			pipe := s.client.Pipeline()
-			cmds := make([]*redis.MapStringStringCmd, len(keys))
-			for i, key := range keys {
-				cmds[i] = pipe.HGetAll(ctx, key)
-			}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return err
+		}
 
-			if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
-				return deletedCount, err
+		// Check which sessions should be deleted
+		toDelete := make([]string, 0)
+		for i, cmd := range cmds {
+			data, err := cmd.Result()
+			if err != nil || len(data) == 0 {
+				continue
 			}
 
-			// Check which sessions should be deleted
-			toDelete := make([]string, 0)
-			for i, cmd := range cmds {
-				data, err := cmd.Result()
-				if err != nil || len(data) == 0 {
-					continue
-				}
-
-				// Parse active and started_at
-				active := data["active"] == "1"
-				if active {
-					continue // Skip active sessions
-				}
+			// Parse active and started_at
+			active := data["active"] == "1"
+			if active {
+				continue // Skip active sessions
+			}
 
-				startedAt, err := time.Parse(time.RFC3339Nano, data["started_at"])
-				if err != nil {
-					continue
-				}
+			startedAt, err := time.Parse(time.RFC3339Nano, data["started_at"])
+			if err != nil {
+				continue
+			}
 
-				if startedAt.Before(cutoff) {
-					toDelete = append(toDelete, keys[i])
-					// Also extract session ID for cleanup
-					if sessionID, ok := data["id"]; ok {
-						// Remove from active set (should already be removed, but just in case)
-						s.client.SRem(ctx, "kproxy:sessions:active", sessionID)
-
-						// Remove device mapping
-						if deviceID, ok := data["device_id"]; ok {
-							if limitID, ok := data["limit_id"]; ok {
-								deviceKey := fmt.Sprintf("kproxy:sessions:device:%s:%s", deviceID, limitID)
-								s.client.Del(ctx, deviceKey)
-							}
+			if startedAt.Before(cutoff) {
+				toDelete = append(toDelete, keys[i])
+				// Also extract session ID for cleanup
+				if sessionID, ok := data["id"]; ok {
+					// Remove from active set (should already be removed, but just in case)
+					s.client.SRem(ctx, s.key("kproxy:sessions:active"), sessionID)
+
+					// Remove device mapping
+					if deviceID, ok := data["device_id"]; ok {
+						if limitID, ok := data["limit_id"]; ok {
+							deviceKey := s.key(fmt.Sprintf("kproxy:sessions:device:%s:%s", deviceID, limitID))
+							s.client.Del(ctx, deviceKey)
 						}
 					}
 				}
 			}
+		}
 
-			// Delete the sessions
-			if len(toDelete) > 0 {
-				deleted, err := s.client.Del(ctx, toDelete...).Result()
-				if err != nil {
-					return deletedCount, err
-				}
-				deletedCount += int(deleted)
+		// Delete the sessions
+		if len(toDelete) > 0 {
+			deleted, err := s.client.Del(ctx, toDelete...).Result()
+			if err != nil {
+				return err
 			}
+			deletedCount += int(deleted)
 		}
 
-		if cursor == 0 {
-			break
-		}
-	}
+		return nil
+	})
 
-	return deletedCount, nil
+	return deletedCount, err
 }
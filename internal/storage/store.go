@@ -17,6 +17,20 @@ type Store interface {
 	Close() error
 	Usage() UsageStore
 	DHCPLeases() DHCPLeaseStore
+	DHCPReservations() DHCPReservationStore
+	DNSCache() DNSCacheStore
+	PendingChanges() PendingChangeStore
+	BlockPages() BlockPageStore
+	DeviceRules() DeviceRuleStore
+	RuleSets() RuleSetStore
+	Reports() ReportStore
+	Pauses() PauseStore
+	Reloads() ReloadBus
+	Decisions() DecisionLogStore
+	Hostnames() HostnameStore
+	IssuedCerts() IssuedCertStore
+	AuditLog() AuditLogStore
+	APITokens() APITokenStore
 }
 
 // REMOVED: DeviceStore, ProfileStore, RuleStore, TimeRuleStore, UsageLimitStore, BypassRuleStore
@@ -47,3 +61,128 @@ type DHCPLeaseStore interface {
 	Delete(ctx context.Context, mac string) error
 	DeleteExpired(ctx context.Context) (int, error)
 }
+
+// DHCPReservationStore holds the fixed addresses of DHCP clients by MAC
+// address. Get and Delete return ErrNotFound for missing reservations; List
+// returns them sorted by IP address.
+type DHCPReservationStore interface {
+	Get(ctx context.Context, mac string) (*DHCPReservation, error)
+	List(ctx context.Context) ([]DHCPReservation, error)
+	Put(ctx context.Context, reservation DHCPReservation) error
+	Delete(ctx context.Context, mac string) error
+}
+
+// DNSCacheStore holds serialized DNS responses until they expire.
+// Get returns ErrNotFound for missing or expired entries.
+type DNSCacheStore interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// PendingChangeStore holds admin changes waiting for a second admin's
+// approval until they expire. Get and Delete return ErrNotFound for missing
+// or expired changes; List returns unexpired changes, oldest first.
+type PendingChangeStore interface {
+	Create(ctx context.Context, change PendingChange) error
+	Get(ctx context.Context, id string) (*PendingChange, error)
+	List(ctx context.Context) ([]PendingChange, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// BlockPageStore holds custom block page templates by name. Get and Delete
+// return ErrNotFound for missing templates; List returns them sorted by name.
+type BlockPageStore interface {
+	Get(ctx context.Context, name string) (*BlockPage, error)
+	List(ctx context.Context) ([]BlockPage, error)
+	Put(ctx context.Context, page BlockPage) error
+	Delete(ctx context.Context, name string) error
+}
+
+// DeviceRuleStore holds rules added for single devices. Delete returns
+// ErrNotFound for missing rules; List returns all rules, oldest first.
+type DeviceRuleStore interface {
+	List(ctx context.Context) ([]DeviceRule, error)
+	Put(ctx context.Context, rule DeviceRule) error
+	Delete(ctx context.Context, id string) error
+}
+
+// PauseStore holds the devices and profiles paused through the admin API,
+// one pause per kind and target. Delete returns ErrNotFound for targets that
+// aren't paused; List returns all pauses, oldest first.
+type PauseStore interface {
+	List(ctx context.Context) ([]Pause, error)
+	Put(ctx context.Context, pause Pause) error
+	Delete(ctx context.Context, kind, target string) error
+}
+
+// ReloadBus carries reload notices between KProxy instances sharing
+// storage, so that a change made through one instance reaches all of them.
+// Publish announces a change made by origin; Subscribe calls fn with the
+// origin of every notice until ctx is done. The memory store isn't shared,
+// so its bus only reaches subscribers in the same process.
+type ReloadBus interface {
+	Publish(ctx context.Context, origin string) error
+	Subscribe(ctx context.Context, fn func(origin string)) error
+}
+
+// DecisionLogStore holds policy decisions recorded for replay until they
+// expire. Get returns ErrNotFound for missing or expired decisions.
+type DecisionLogStore interface {
+	Put(ctx context.Context, decision Decision) error
+	Get(ctx context.Context, id string) (*Decision, error)
+}
+
+// DecisionHistory is implemented by decision logs that can search what they
+// hold. List returns the unexpired decisions matching filter, newest first.
+type DecisionHistory interface {
+	List(ctx context.Context, filter DecisionFilter) ([]Decision, error)
+}
+
+// HostnameStore holds the hostnames clients announced, by IP address,
+// until they expire. List returns the unexpired ones sorted by IP address.
+type HostnameStore interface {
+	Put(ctx context.Context, hostname Hostname) error
+	List(ctx context.Context) ([]Hostname, error)
+}
+
+// IssuedCertStore logs the leaf certificates the CA minted, until the
+// records expire. List returns the unexpired ones, newest first.
+type IssuedCertStore interface {
+	Add(ctx context.Context, cert IssuedCert) error
+	List(ctx context.Context) ([]IssuedCert, error)
+}
+
+// AuditLogStore logs the changes made through the admin API, until the
+// entries expire. List returns the unexpired ones, newest first.
+type AuditLogStore interface {
+	Add(ctx context.Context, entry AuditEntry) error
+	List(ctx context.Context) ([]AuditEntry, error)
+}
+
+// APITokenStore holds the API tokens created through the admin API by ID.
+// Delete returns ErrNotFound for missing tokens; List returns them oldest
+// first.
+type APITokenStore interface {
+	List(ctx context.Context) ([]APIToken, error)
+	Put(ctx context.Context, token APIToken) error
+	Delete(ctx context.Context, id string) error
+}
+
+// RuleSetStore holds rule sets saved through the admin API by ID. Get and
+// Delete return ErrNotFound for missing rule sets; List returns them sorted
+// by ID.
+type RuleSetStore interface {
+	Get(ctx context.Context, id string) (*RuleSet, error)
+	List(ctx context.Context) ([]RuleSet, error)
+	Put(ctx context.Context, set RuleSet) error
+	Delete(ctx context.Context, id string) error
+}
+
+// ReportStore holds daily counts of each profile's proxy traffic for weekly
+// reports. Add adds to the counts of the day and profile; Get returns
+// ErrNotFound for days without traffic.
+type ReportStore interface {
+	Add(ctx context.Context, day ReportDay) error
+	Get(ctx context.Context, date, profile string) (*ReportDay, error)
+	DeleteBefore(ctx context.Context, cutoffDate string) (int, error)
+}
@@ -80,3 +80,239 @@ type DHCPLease struct {
 func (l *DHCPLease) IsExpired() bool {
 	return time.Now().After(l.ExpiresAt)
 }
+
+// DHCPReservation fixes the address the DHCP server gives a client. The
+// address is kept for the client even while it has no lease.
+type DHCPReservation struct {
+	MAC       string    `json:"mac"`                // Client MAC address (key)
+	IP        string    `json:"ip"`                 // Reserved IP address
+	Hostname  string    `json:"hostname,omitempty"` // Client hostname when reserved
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PendingChange is a destructive admin change that another admin must
+// approve before it is applied.
+type PendingChange struct {
+	ID          string          `json:"id"`
+	Action      string          `json:"action"`           // e.g. "device.remove"
+	Target      string          `json:"target,omitempty"` // What the change applies to
+	Params      json.RawMessage `json:"params,omitempty"` // Request body applied on approval
+	RequestedBy string          `json:"requested_by"`     // Admin account
+	RequestedAt time.Time       `json:"requested_at"`
+	ExpiresAt   time.Time       `json:"expires_at"`
+}
+
+// BlockPage is a custom block page: an html/template rendered in place of
+// the built-in block page for decisions that name it.
+type BlockPage struct {
+	Name      string    `json:"name"`
+	Template  string    `json:"template"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DeviceRule is a rule added for one device through the admin API. It takes
+// precedence over the rules of the device's profile until it expires or is
+// removed.
+type DeviceRule struct {
+	ID      string     `json:"id"`
+	Device  string     `json:"device"` // Device ID, as in config.rego
+	Domain  string     `json:"domain"` // Domain pattern, as in config.rego
+	Action  string     `json:"action"`
+	Until   *time.Time `json:"until,omitempty"` // nil = until removed
+	Created time.Time  `json:"created"`
+}
+
+// Pause kinds
+const (
+	PauseDevice  = "device"
+	PauseProfile = "profile"
+)
+
+// Pause blocks all traffic of a device, or of every device with a profile,
+// until it expires or is resumed through the admin API.
+type Pause struct {
+	Kind    string     `json:"kind"`            // PauseDevice or PauseProfile
+	Target  string     `json:"target"`          // Device or profile ID, as in config.rego
+	Until   *time.Time `json:"until,omitempty"` // nil = until resumed
+	Created time.Time  `json:"created"`
+}
+
+// Decision is a policy evaluation recorded by the decision log: the OPA
+// input and result as JSON, so it can be evaluated again against other
+// policies.
+type Decision struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"` // "dns" or "proxy"
+	Time      time.Time       `json:"time"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	Input     json.RawMessage `json:"input"`
+	Result    json.RawMessage `json:"result"`
+}
+
+// DecisionFilter selects recorded decisions. Empty fields match every
+// decision.
+type DecisionFilter struct {
+	Kind   string    // "dns" or "proxy"
+	Client string    // Client IP address
+	Host   string    // Host of proxy decisions, domain of DNS decisions
+	Since  time.Time // Recorded at or after
+	Until  time.Time // Recorded before
+	Limit  int       // Most decisions returned, 0 for all
+}
+
+// Match reports whether a decision passes the filter, ignoring Limit
+func (f DecisionFilter) Match(d Decision) bool {
+	if f.Kind != "" && d.Kind != f.Kind {
+		return false
+	}
+	if !f.Since.IsZero() && d.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !d.Time.Before(f.Until) {
+		return false
+	}
+	if f.Client == "" && f.Host == "" {
+		return true
+	}
+
+	var input struct {
+		ClientIP string `json:"client_ip"`
+		Host     string `json:"host"`
+		Domain   string `json:"domain"`
+	}
+	if err := json.Unmarshal(d.Input, &input); err != nil {
+		return false
+	}
+	if f.Client != "" && input.ClientIP != f.Client {
+		return false
+	}
+	return f.Host == "" || input.Host == f.Host || input.Domain == f.Host
+}
+
+// Hostname is the name a client announced for itself over mDNS or NetBIOS,
+// or in its DHCP request
+type Hostname struct {
+	IP        string    `json:"ip"`
+	MAC       string    `json:"mac,omitempty"`
+	Name      string    `json:"name"`
+	Source    string    `json:"source"` // "dhcp", "mdns" or "netbios"
+	Seen      time.Time `json:"seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IssuedCert records a leaf certificate the CA minted to impersonate a host
+type IssuedCert struct {
+	Serial      string    `json:"serial"` // Hex
+	Host        string    `json:"host"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"fingerprint"`      // SHA-256 of the DER certificate, hex
+	Client      string    `json:"client,omitempty"` // Address of the client whose handshake it was minted for
+	Issued      time.Time `json:"issued"`
+	ExpiresAt   time.Time `json:"expires_at"` // When the record is removed
+}
+
+// AuditEntry records a change made through the admin API: who made it,
+// the request, and the changed resource before and after
+type AuditEntry struct {
+	ID        string          `json:"id"`
+	Time      time.Time       `json:"time"`
+	Account   string          `json:"account"`
+	Client    string          `json:"client,omitempty"` // Address the request came from
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Status    int             `json:"status"`
+	Request   json.RawMessage `json:"request,omitempty"` // Request body
+	Before    json.RawMessage `json:"before,omitempty"`  // Resource before the change, if it could be read
+	After     json.RawMessage `json:"after,omitempty"`   // Resource after the change, or the response
+	Changes   []AuditChange   `json:"changes,omitempty"` // Fields that differ between Before and After
+	ExpiresAt time.Time       `json:"expires_at"`        // When the entry is removed
+}
+
+// AuditChange is one field changed by an audited request, named by its
+// JSON path (e.g. "schedule.end"). Before or After is omitted when the
+// field was added or removed.
+type AuditChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// APIToken is a long-lived admin API token for scripts and integrations,
+// limited to its scopes. Only a hash of the secret is kept.
+type APIToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash,omitempty"` // SHA-256 of the secret, hex
+	Scopes    []string   `json:"scopes"`         // e.g. "read", "maintenance", "devices:read"
+	CreatedBy string     `json:"created_by"`     // Admin account that created it
+	Created   time.Time  `json:"created"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil = until revoked
+}
+
+// RuleSet is a named collection of domain rules, such as "Social Media",
+// that can be attached to several profiles at once.
+type RuleSet struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Profiles    []string      `json:"profiles"` // Profiles the rule set is attached to
+	Rules       []RuleSetRule `json:"rules"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// RuleSetRule is a rule of a rule set, with the fields of a profile rule in
+// config.rego. It is also read from YAML by kproxy ruleset set.
+type RuleSetRule struct {
+	ID       string   `json:"id" yaml:"id"`
+	Domains  []string `json:"domains" yaml:"domains"`
+	Paths    []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Action   string   `json:"action" yaml:"action"`
+	Category string   `json:"category,omitempty" yaml:"category,omitempty"`
+}
+
+// ReportDay counts one profile's proxy traffic on one day, for weekly
+// reports. Maps may be nil when empty.
+type ReportDay struct {
+	Date       string                    `json:"date"` // YYYY-MM-DD, local time
+	Profile    string                    `json:"profile"`
+	Categories map[string]ReportCategory `json:"categories"`
+	Sites      map[string]int            `json:"sites"`       // Allowed requests per host
+	Blocked    map[string]int            `json:"blocked"`     // Blocked requests per host
+	BlockKinds map[string]int            `json:"block_kinds"` // Blocked requests per block page, e.g. "time_restriction"
+}
+
+// ReportCategory counts a profile's requests in one category on one day
+type ReportCategory struct {
+	Requests int `json:"requests"` // Allowed requests
+	Blocked  int `json:"blocked"`
+	Minutes  int `json:"minutes"` // Minutes with allowed requests
+}
+
+// Merge adds the counts of other to d
+func (d *ReportDay) Merge(other ReportDay) {
+	if len(other.Categories) > 0 && d.Categories == nil {
+		d.Categories = make(map[string]ReportCategory)
+	}
+	for name, c := range other.Categories {
+		total := d.Categories[name]
+		total.Requests += c.Requests
+		total.Blocked += c.Blocked
+		total.Minutes += c.Minutes
+		d.Categories[name] = total
+	}
+	d.Sites = mergeCounts(d.Sites, other.Sites)
+	d.Blocked = mergeCounts(d.Blocked, other.Blocked)
+	d.BlockKinds = mergeCounts(d.BlockKinds, other.BlockKinds)
+}
+
+// mergeCounts adds the counts of other to counts
+func mergeCounts(counts, other map[string]int) map[string]int {
+	if len(other) > 0 && counts == nil {
+		counts = make(map[string]int, len(other))
+	}
+	for key, n := range other {
+		counts[key] += n
+	}
+	return counts
+}
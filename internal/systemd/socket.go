@@ -15,6 +15,7 @@ type Listeners struct {
 	HTTPS     net.Listener
 	DNSUdp    net.PacketConn
 	DNSTcp    net.Listener
+	DNSTLS    net.Listener
 	DHCP      net.PacketConn
 	Metrics   net.Listener
 	Activated bool
@@ -46,7 +47,7 @@ func GetListeners() (*Listeners, error) {
 	}
 
 	// Map named file descriptors to our listener structure
-	// Expected names: http, https, dns-udp, dns-tcp, dhcp, metrics
+	// Expected names: http, https, dns-udp, dns-tcp, dns-tls, dhcp, metrics
 
 	if lns, ok := listenersMap["http"]; ok && len(lns) > 0 {
 		listeners.HTTP = lns[0]
@@ -60,6 +61,10 @@ func GetListeners() (*Listeners, error) {
 		listeners.DNSTcp = lns[0]
 	}
 
+	if lns, ok := listenersMap["dns-tls"]; ok && len(lns) > 0 {
+		listeners.DNSTLS = lns[0]
+	}
+
 	if lns, ok := listenersMap["metrics"]; ok && len(lns) > 0 {
 		listeners.Metrics = lns[0]
 	}
@@ -0,0 +1,420 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// maxManifestSize bounds the manifest and signature downloads
+const maxManifestSize = 1 << 20
+
+// ErrNotConfigured is returned by Check when no release URL is configured
+var ErrNotConfigured = errors.New("update.url is not configured")
+
+// Manifest describes the current release on a channel. It is published as
+// <url>/<channel>.json alongside a detached ed25519 signature of the exact
+// manifest bytes in <url>/<channel>.json.sig (base64). Binaries are trusted
+// through the SHA-256 digests in the signed manifest. The channel is signed
+// too, so a manifest for one channel can't be served as another's.
+type Manifest struct {
+	Version  string            `json:"version"`
+	Channel  string            `json:"channel"`
+	Binaries map[string]Binary `json:"binaries"` // Keyed by "<goos>/<goarch>"
+}
+
+// Binary is a release binary for one platform
+type Binary struct {
+	URL    string `json:"url"`    // Absolute, or relative to the manifest
+	SHA256 string `json:"sha256"` // Hex digest
+}
+
+// Release is a verified release available for this platform
+type Release struct {
+	Version string
+	URL     string
+	SHA256  string
+}
+
+// Status reports the running version and the result of the last check
+type Status struct {
+	Current         string     `json:"current"`
+	Available       string     `json:"available,omitempty"`
+	UpdateAvailable bool       `json:"update_available"`
+	Channel         string     `json:"channel,omitempty"`
+	CheckedAt       *time.Time `json:"checked_at,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// Config holds updater configuration
+type Config struct {
+	Current       string        // Version of the running binary
+	URL           string        // Release base URL; empty disables checks
+	Channel       string        // Release channel, e.g. "stable"
+	PublicKey     string        // Base64 ed25519 public key manifests are signed with
+	CheckInterval time.Duration // Background check interval; 0 disables
+}
+
+// Updater checks a release channel for signed releases and installs them
+type Updater struct {
+	current       string
+	channel       string
+	manifestURL   string
+	publicKey     ed25519.PublicKey
+	checkInterval time.Duration
+	client        *http.Client
+	logger        zerolog.Logger
+	stopChan      chan struct{}
+
+	mu     sync.RWMutex
+	status Status
+
+	// Replaced in tests
+	platform string
+}
+
+// New creates an updater. With no URL configured it only reports the
+// running version.
+func New(cfg Config, logger zerolog.Logger) (*Updater, error) {
+	u := &Updater{
+		current:       cfg.Current,
+		channel:       cfg.Channel,
+		checkInterval: cfg.CheckInterval,
+		client:        &http.Client{Timeout: 5 * time.Minute},
+		logger:        logger.With().Str("component", "update").Logger(),
+		stopChan:      make(chan struct{}),
+		status:        Status{Current: cfg.Current, Channel: cfg.Channel},
+		platform:      runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if cfg.URL == "" {
+		return u, nil
+	}
+
+	key, err := ParsePublicKey(cfg.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	u.publicKey = key
+	u.manifestURL = strings.TrimSuffix(cfg.URL, "/") + "/" + url.PathEscape(cfg.Channel) + ".json"
+
+	return u, nil
+}
+
+// ParsePublicKey decodes a base64 ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid update public key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid update public key: want %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// Start begins periodic background checks, if enabled
+func (u *Updater) Start() {
+	if u.manifestURL == "" || u.checkInterval <= 0 {
+		return
+	}
+	go u.run()
+	u.logger.Info().
+		Str("channel", u.channel).
+		Dur("interval", u.checkInterval).
+		Msg("Release update checks started")
+}
+
+// Stop stops background checks
+func (u *Updater) Stop() {
+	close(u.stopChan)
+}
+
+// run is the background check loop
+func (u *Updater) run() {
+	ticker := time.NewTicker(u.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		rel, err := u.Check(ctx)
+		cancel()
+
+		if err != nil {
+			u.logger.Warn().Err(err).Msg("Release update check failed")
+		} else if Newer(rel.Version, u.current) {
+			u.logger.Info().
+				Str("current", u.current).
+				Str("available", rel.Version).
+				Msg("A new release is available (run 'kproxy self-update' to install)")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-u.stopChan:
+			return
+		}
+	}
+}
+
+// Status returns the running version and the result of the last check
+func (u *Updater) Status() Status {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.status
+}
+
+// Check fetches and verifies the channel manifest and returns the release
+// for this platform
+func (u *Updater) Check(ctx context.Context) (*Release, error) {
+	if u.manifestURL == "" {
+		return nil, ErrNotConfigured
+	}
+
+	rel, err := u.check(ctx)
+
+	now := time.Now()
+	u.mu.Lock()
+	u.status.CheckedAt = &now
+	u.status.Error = ""
+	if err != nil {
+		u.status.Error = err.Error()
+	} else {
+		u.status.Available = rel.Version
+		u.status.UpdateAvailable = Newer(rel.Version, u.current)
+	}
+	u.mu.Unlock()
+
+	return rel, err
+}
+
+func (u *Updater) check(ctx context.Context) (*Release, error) {
+	body, err := u.fetch(ctx, u.manifestURL)
+	if err != nil {
+		return nil, err
+	}
+	sigData, err := u.fetch(ctx, u.manifestURL+".sig")
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigData)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest signature encoding: %w", err)
+	}
+	if !ed25519.Verify(u.publicKey, body, sig) {
+		return nil, fmt.Errorf("manifest signature verification failed")
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version == "" {
+		return nil, fmt.Errorf("invalid manifest: no version")
+	}
+	if manifest.Channel != u.channel {
+		return nil, fmt.Errorf("manifest is for channel %q, not %q", manifest.Channel, u.channel)
+	}
+
+	bin, ok := manifest.Binaries[u.platform]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no binary for %s", manifest.Version, u.platform)
+	}
+	if digest, err := hex.DecodeString(bin.SHA256); err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("invalid manifest: bad sha256 for %s", u.platform)
+	}
+
+	binURL, err := resolveURL(u.manifestURL, bin.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	return &Release{
+		Version: manifest.Version,
+		URL:     binURL,
+		SHA256:  strings.ToLower(bin.SHA256),
+	}, nil
+}
+
+// fetch downloads a small document
+func (u *Updater) fetch(ctx context.Context, target string) ([]byte, error) {
+	resp, err := u.get(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", target, err)
+	}
+	return data, nil
+}
+
+func (u *Updater) get(ctx context.Context, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "kproxy/"+u.current)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", target, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s: %s", target, resp.Status)
+	}
+	return resp, nil
+}
+
+// Apply downloads the release binary, verifies its digest and atomically
+// replaces the file at path. The file is only replaced once the complete
+// binary has been verified.
+func (u *Updater) Apply(ctx context.Context, rel *Release, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	resp, err := u.get(ctx, rel.URL)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// The temporary file must be on the same filesystem for the rename to be atomic
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".update-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to download %s: %w", rel.URL, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write update: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write update: %w", err)
+	}
+
+	if got := hex.EncodeToString(hash.Sum(nil)); got != rel.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", rel.URL, got, rel.SHA256)
+	}
+
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	u.logger.Info().
+		Str("version", rel.Version).
+		Str("path", path).
+		Msg("Installed release")
+
+	return nil
+}
+
+// Executable returns the resolved path of the running binary
+func Executable() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// Restart restarts the systemd unit if it is running
+func Restart(unit string) error {
+	out, err := exec.Command("systemctl", "try-restart", unit).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl try-restart %s: %w: %s", unit, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Newer reports whether version available is newer than current. Versions
+// that don't parse as semantic versions (e.g. "dev" builds) never compare
+// as newer.
+func Newer(available, current string) bool {
+	a, aPre, ok := parseVersion(available)
+	if !ok {
+		return false
+	}
+	c, cPre, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != c[i] {
+			return a[i] > c[i]
+		}
+	}
+
+	// A release is newer than its own pre-releases
+	return aPre == "" && cPre != ""
+}
+
+// parseVersion parses "v1.2.3" or "1.2.3-rc1" into its numeric parts and
+// pre-release suffix
+func parseVersion(v string) ([3]int, string, bool) {
+	var parts [3]int
+
+	v = strings.TrimPrefix(v, "v")
+	v, _, _ = strings.Cut(v, "+")
+	core, pre, _ := strings.Cut(v, "-")
+
+	fields := strings.Split(core, ".")
+	if len(fields) != 3 {
+		return parts, "", false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, "", false
+		}
+		parts[i] = n
+	}
+	return parts, pre, true
+}
+
+// resolveURL resolves ref relative to base
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
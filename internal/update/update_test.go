@@ -0,0 +1,197 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// releaseServer serves a signed stable channel with one linux/amd64 binary
+func releaseServer(t *testing.T, priv ed25519.PrivateKey, binary []byte, digest string) *httptest.Server {
+	t.Helper()
+	return channelServer(t, priv, "stable", binary, digest)
+}
+
+// channelServer serves a signed manifest for channel as the stable channel
+func channelServer(t *testing.T, priv ed25519.PrivateKey, channel string, binary []byte, digest string) *httptest.Server {
+	t.Helper()
+
+	if digest == "" {
+		sum := sha256.Sum256(binary)
+		digest = hex.EncodeToString(sum[:])
+	}
+	manifest, err := json.Marshal(Manifest{
+		Version: "v1.2.0",
+		Channel: channel,
+		Binaries: map[string]Binary{
+			"linux/amd64": {URL: "kproxy-linux-amd64", SHA256: digest},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, manifest))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/releases/stable.json", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(manifest) })
+	mux.HandleFunc("/releases/stable.json.sig", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(sig + "\n")) })
+	mux.HandleFunc("/releases/kproxy-linux-amd64", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(binary) })
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestUpdater(t *testing.T, baseURL string, pub ed25519.PublicKey) *Updater {
+	t.Helper()
+
+	u, err := New(Config{
+		Current:   "v1.1.0",
+		URL:       baseURL + "/releases/",
+		Channel:   "stable",
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	u.platform = "linux/amd64"
+	return u
+}
+
+func TestCheckAndApply(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	binary := []byte("#!/bin/sh\necho new kproxy\n")
+	srv := releaseServer(t, priv, binary, "")
+	u := newTestUpdater(t, srv.URL, pub)
+
+	rel, err := u.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if rel.Version != "v1.2.0" || rel.URL != srv.URL+"/releases/kproxy-linux-amd64" {
+		t.Errorf("Check() = %+v", rel)
+	}
+
+	status := u.Status()
+	if !status.UpdateAvailable || status.Available != "v1.2.0" || status.CheckedAt == nil {
+		t.Errorf("Status() = %+v, want v1.2.0 available", status)
+	}
+
+	path := filepath.Join(t.TempDir(), "kproxy")
+	if err := os.WriteFile(path, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.Apply(context.Background(), rel, path); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(binary) {
+		t.Errorf("installed binary = %q, want %q", got, binary)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0755 {
+		t.Errorf("mode = %v, want 0755", info.Mode().Perm())
+	}
+
+	// Only the installed binary remains
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+}
+
+func TestCheck_BadSignature(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	srv := releaseServer(t, priv, []byte("binary"), "")
+	u := newTestUpdater(t, srv.URL, otherPub)
+
+	if _, err := u.Check(context.Background()); err == nil {
+		t.Fatal("Check() accepted a manifest signed with another key")
+	}
+	if status := u.Status(); status.Error == "" || status.UpdateAvailable {
+		t.Errorf("Status() = %+v, want the error recorded", status)
+	}
+}
+
+func TestCheck_ChannelMismatch(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+
+	// A signed beta manifest replayed as the stable one, and an old manifest
+	// with no channel, are both refused
+	for _, channel := range []string{"beta", ""} {
+		srv := channelServer(t, priv, channel, []byte("binary"), "")
+		u := newTestUpdater(t, srv.URL, pub)
+
+		if _, err := u.Check(context.Background()); err == nil || !strings.Contains(err.Error(), "channel") {
+			t.Errorf("Check() of a %q manifest error = %v, want a channel mismatch", channel, err)
+		}
+	}
+}
+
+func TestApply_ChecksumMismatch(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	wrong := sha256.Sum256([]byte("something else"))
+	srv := releaseServer(t, priv, []byte("tampered"), hex.EncodeToString(wrong[:]))
+	u := newTestUpdater(t, srv.URL, pub)
+
+	rel, err := u.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "kproxy")
+	if err := os.WriteFile(path, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.Apply(context.Background(), rel, path); err == nil {
+		t.Fatal("Apply() installed a binary with the wrong checksum")
+	}
+
+	got, _ := os.ReadFile(path)
+	if string(got) != "old" {
+		t.Errorf("binary replaced despite checksum mismatch: %q", got)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temporary file left behind: %d entries", len(entries))
+	}
+}
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		available, current string
+		want               bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"1.10.0", "1.9.0", true},
+		{"v1.2.0", "1.2.0", false},
+		{"v1.1.0", "v1.2.0", false},
+		{"v1.2.0", "v1.2.0-rc1", true},
+		{"v1.2.0-rc1", "v1.2.0", false},
+		{"v2.0.0", "dev", false},
+		{"garbage", "v1.0.0", false},
+	}
+
+	for _, tt := range tests {
+		if got := Newer(tt.available, tt.current); got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", tt.available, tt.current, got, tt.want)
+		}
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"maps"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/goodtune/kproxy/internal/activity"
 	"github.com/goodtune/kproxy/internal/metrics"
 	"github.com/goodtune/kproxy/internal/storage"
 	"github.com/rs/zerolog"
@@ -29,8 +32,25 @@ type Tracker struct {
 	deviceLimitSessions map[string]string   // key: deviceID:limitID -> sessionID
 	inactivityTimeout   time.Duration
 	minSessionDuration  time.Duration
+	activity            *activity.Recorder
 	logger              zerolog.Logger
 	mu                  sync.RWMutex
+
+	// While storage is unreachable, usage is served from the daily totals
+	// last read from it, plus the time of sessions it couldn't take; that
+	// time is saved once storage is back
+	storageHealthy func() bool
+	snapshotMu     sync.Mutex
+	snapshotDate   string
+	snapshot       map[dailyKey]int64 // Seconds, for snapshotDate
+	unsaved        map[dailyKey]int64 // Seconds not yet added to storage
+}
+
+// dailyKey identifies a daily usage total
+type dailyKey struct {
+	date     string
+	deviceID string
+	limitID  string
 }
 
 // Config holds tracker configuration
@@ -55,6 +75,8 @@ func NewTracker(usageStore storage.UsageStore, config Config, logger zerolog.Log
 		inactivityTimeout:   config.InactivityTimeout,
 		minSessionDuration:  config.MinSessionDuration,
 		logger:              logger.With().Str("component", "usage-tracker").Logger(),
+		snapshot:            make(map[dailyKey]int64),
+		unsaved:             make(map[dailyKey]int64),
 	}
 
 	// Start cleanup goroutine
@@ -69,6 +91,24 @@ func (t *Tracker) RecordActivity(deviceID, limitID string) error {
 	return err
 }
 
+// SetActivity sets the recorder that streams session starts and stops to
+// live dashboards
+func (t *Tracker) SetActivity(r *activity.Recorder) {
+	t.activity = r
+}
+
+// SetStorageHealth sets the function reporting whether storage is
+// reachable (storage.Monitor.Healthy). While it isn't, the tracker doesn't
+// wait on storage: usage comes from memory and writes are deferred.
+func (t *Tracker) SetStorageHealth(healthy func() bool) {
+	t.storageHealthy = healthy
+}
+
+// storageDown reports whether the last health check failed to reach storage
+func (t *Tracker) storageDown() bool {
+	return t.storageHealthy != nil && !t.storageHealthy()
+}
+
 // recordActivityInternal records activity and returns the session
 func (t *Tracker) recordActivityInternal(deviceID, limitID string) (*Session, error) {
 	t.mu.Lock()
@@ -126,6 +166,7 @@ func (t *Tracker) recordActivityInternal(deviceID, limitID string) (*Session, er
 
 	t.sessions[session.ID] = session
 	t.deviceLimitSessions[key] = session.ID
+	t.activity.Publish(activity.Event{Time: now, Type: activity.EventSessionStart, Device: deviceID, Limit: limitID})
 
 	// Save to storage
 	if err := t.saveSession(session); err != nil {
@@ -152,15 +193,11 @@ func (t *Tracker) GetTodayUsage(deviceID, limitID string, resetTime time.Time) (
 	today := getResetDate(now, resetTime)
 
 	// Query storage for today's usage
-	dailyUsage, err := t.usageStore.GetDailyUsage(context.Background(), today.Format("2006-01-02"), deviceID, limitID)
-	if err != nil && !errorsIsNotFound(err) {
-		return 0, fmt.Errorf("failed to query daily usage: %w", err)
-	}
-
-	var usage time.Duration
-	if err == nil && dailyUsage != nil {
-		usage = time.Duration(dailyUsage.TotalSeconds) * time.Second
+	seconds, err := t.dailySeconds(dailyKey{date: today.Format("2006-01-02"), deviceID: deviceID, limitID: limitID})
+	if err != nil {
+		return 0, err
 	}
+	usage := time.Duration(seconds) * time.Second
 
 	// Add current active session time if exists
 	key := deviceID + ":" + limitID
@@ -178,6 +215,50 @@ func (t *Tracker) GetTodayUsage(deviceID, limitID string, resetTime time.Time) (
 	return usage, nil
 }
 
+// dailySeconds returns the daily usage total of key, with the time storage
+// hasn't taken yet. When storage is down or fails, the total last read is
+// used instead, so policy evaluation carries on from memory.
+func (t *Tracker) dailySeconds(key dailyKey) (int64, error) {
+	if !t.storageDown() {
+		daily, err := t.usageStore.GetDailyUsage(context.Background(), key.date, key.deviceID, key.limitID)
+		switch {
+		case err == nil:
+			return t.remember(key, daily.TotalSeconds), nil
+		case errorsIsNotFound(err):
+			return t.remember(key, 0), nil
+		case t.storageHealthy == nil:
+			return 0, fmt.Errorf("failed to query daily usage: %w", err)
+		}
+		t.logger.Debug().Err(err).Msg("Daily usage query failed, using the last total read")
+	}
+
+	metrics.StorageDegradedReads.Inc()
+	t.snapshotMu.Lock()
+	defer t.snapshotMu.Unlock()
+	seconds := t.unsaved[key]
+	if key.date == t.snapshotDate {
+		seconds += t.snapshot[key]
+	}
+	return seconds, nil
+}
+
+// remember keeps a daily total read from storage, returning it with the
+// time storage hasn't taken yet. Totals of earlier days are forgotten.
+func (t *Tracker) remember(key dailyKey, seconds int64) int64 {
+	t.snapshotMu.Lock()
+	defer t.snapshotMu.Unlock()
+
+	if key.date != t.snapshotDate {
+		if key.date < t.snapshotDate {
+			return seconds + t.unsaved[key]
+		}
+		clear(t.snapshot)
+		t.snapshotDate = key.date
+	}
+	t.snapshot[key] = seconds
+	return seconds + t.unsaved[key]
+}
+
 // GetUsageStats returns current usage statistics for a device and limit
 func (t *Tracker) GetUsageStats(deviceID, limitID string, dailyLimit time.Duration, resetTime time.Time) (*UsageStats, error) {
 	todayUsage, err := t.GetTodayUsage(deviceID, limitID, resetTime)
@@ -206,6 +287,57 @@ func (t *Tracker) GetCategoryUsage(deviceID, category string) (time.Duration, er
 	return t.GetTodayUsage(deviceID, category, resetTime)
 }
 
+// ListTodayUsage returns today's usage for every device and limit, including
+// running sessions. Like GetCategoryUsage it assumes a daily reset at midnight.
+func (t *Tracker) ListTodayUsage() ([]Meter, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := time.Now()
+	today := getResetDate(now, time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	daily, err := t.usageStore.ListDailyUsage(context.Background(), today.Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list daily usage: %w", err)
+	}
+
+	meters := make([]Meter, 0, len(daily))
+	index := make(map[string]int, len(daily))
+	for _, d := range daily {
+		index[d.DeviceID+":"+d.LimitID] = len(meters)
+		meters = append(meters, Meter{DeviceID: d.DeviceID, LimitID: d.LimitID, Seconds: d.TotalSeconds})
+	}
+
+	for key, sessionID := range t.deviceLimitSessions {
+		session := t.sessions[sessionID]
+		if session == nil || !session.Active {
+			continue
+		}
+		elapsed := now.Sub(session.LastActivity)
+		if elapsed > t.inactivityTimeout {
+			continue
+		}
+
+		i, ok := index[key]
+		if !ok {
+			i = len(meters)
+			index[key] = i
+			meters = append(meters, Meter{DeviceID: session.DeviceID, LimitID: session.LimitID})
+		}
+		meters[i].Seconds += session.AccumulatedSeconds + int64(elapsed.Seconds())
+		meters[i].Active = true
+	}
+
+	sort.Slice(meters, func(i, j int) bool {
+		if meters[i].DeviceID != meters[j].DeviceID {
+			return meters[i].DeviceID < meters[j].DeviceID
+		}
+		return meters[i].LimitID < meters[j].LimitID
+	})
+
+	return meters, nil
+}
+
 // StopSession manually stops a session
 func (t *Tracker) StopSession(sessionID string) error {
 	t.mu.Lock()
@@ -224,6 +356,12 @@ func (t *Tracker) finalizeSession(session *Session) error {
 	if !session.Active {
 		return nil // Already finalized
 	}
+	t.activity.Publish(activity.Event{
+		Type:    activity.EventSessionStop,
+		Device:  session.DeviceID,
+		Limit:   session.LimitID,
+		Seconds: session.AccumulatedSeconds,
+	})
 
 	// Check minimum duration
 	totalDuration := time.Duration(session.AccumulatedSeconds) * time.Second
@@ -247,28 +385,34 @@ func (t *Tracker) finalizeSession(session *Session) error {
 	// Mark as inactive
 	session.Active = false
 
-	// Update storage
-	if err := t.usageStore.UpsertSession(context.Background(), storage.UsageSession{
-		ID:                 session.ID,
-		DeviceID:           session.DeviceID,
-		LimitID:            session.LimitID,
-		StartedAt:          session.StartedAt,
-		LastActivity:       session.LastActivity,
-		AccumulatedSeconds: session.AccumulatedSeconds,
-		Active:             session.Active,
-	}); err != nil {
-		return fmt.Errorf("failed to update session: %w", err)
+	// Update storage; while it is down only the daily total is kept
+	var sessionErr error
+	if !t.storageDown() {
+		sessionErr = t.usageStore.UpsertSession(context.Background(), storage.UsageSession{
+			ID:                 session.ID,
+			DeviceID:           session.DeviceID,
+			LimitID:            session.LimitID,
+			StartedAt:          session.StartedAt,
+			LastActivity:       session.LastActivity,
+			AccumulatedSeconds: session.AccumulatedSeconds,
+			Active:             session.Active,
+		})
 	}
 
-	// Aggregate to daily usage
-	if err := t.aggregateToDailyUsage(session); err != nil {
-		return fmt.Errorf("failed to aggregate to daily usage: %w", err)
-	}
+	// Aggregate to daily usage, kept in memory when storage doesn't take it
+	aggregateErr := t.aggregateToDailyUsage(session)
 
 	// Remove from active tracking
 	delete(t.sessions, session.ID)
 	delete(t.deviceLimitSessions, session.DeviceID+":"+session.LimitID)
 
+	if sessionErr != nil {
+		return fmt.Errorf("failed to update session: %w", sessionErr)
+	}
+	if aggregateErr != nil {
+		return fmt.Errorf("failed to aggregate to daily usage: %w", aggregateErr)
+	}
+
 	t.logger.Info().
 		Str("session_id", session.ID).
 		Str("device_id", session.DeviceID).
@@ -279,8 +423,12 @@ func (t *Tracker) finalizeSession(session *Session) error {
 	return nil
 }
 
-// saveSession saves a new session to storage
+// saveSession saves a new session to storage. While storage is down it is
+// skipped; the session is saved when it ends.
 func (t *Tracker) saveSession(session *Session) error {
+	if t.storageDown() {
+		return nil
+	}
 	return t.usageStore.UpsertSession(context.Background(), storage.UsageSession{
 		ID:                 session.ID,
 		DeviceID:           session.DeviceID,
@@ -296,9 +444,19 @@ func (t *Tracker) saveSession(session *Session) error {
 func (t *Tracker) aggregateToDailyUsage(session *Session) error {
 	// Get the date for this session (based on when it started)
 	date := session.StartedAt.Format("2006-01-02")
-
-	if err := t.usageStore.IncrementDailyUsage(context.Background(), date, session.DeviceID, session.LimitID, session.AccumulatedSeconds); err != nil {
-		return fmt.Errorf("failed to aggregate daily usage: %w", err)
+	key := dailyKey{date: date, deviceID: session.DeviceID, limitID: session.LimitID}
+
+	if t.storageDown() {
+		// Saved by saveUnsaved once storage is back
+		t.keepUnsaved(key, session.AccumulatedSeconds)
+	} else {
+		if err := t.usageStore.IncrementDailyUsage(context.Background(), date, session.DeviceID, session.LimitID, session.AccumulatedSeconds); err != nil {
+			t.keepUnsaved(key, session.AccumulatedSeconds)
+			return fmt.Errorf("failed to aggregate daily usage: %w", err)
+		}
+		t.snapshotMu.Lock()
+		t.addToSnapshotLocked(key, session.AccumulatedSeconds)
+		t.snapshotMu.Unlock()
 	}
 
 	// Record usage minutes metric (get category from limit ID if possible)
@@ -316,6 +474,56 @@ func (t *Tracker) aggregateToDailyUsage(session *Session) error {
 	return nil
 }
 
+// addToSnapshotLocked adds time storage took to the total remembered for
+// key, keeping it current until storage is read again. t.snapshotMu must
+// be held.
+func (t *Tracker) addToSnapshotLocked(key dailyKey, seconds int64) {
+	if key.date == t.snapshotDate {
+		t.snapshot[key] += seconds
+	}
+}
+
+// keepUnsaved holds time storage didn't take until saveUnsaved adds it
+func (t *Tracker) keepUnsaved(key dailyKey, seconds int64) {
+	t.snapshotMu.Lock()
+	defer t.snapshotMu.Unlock()
+	t.unsaved[key] += seconds
+}
+
+// saveUnsaved adds the time storage didn't take to the daily totals, once
+// storage is reachable
+func (t *Tracker) saveUnsaved() {
+	if t.storageDown() {
+		return
+	}
+
+	t.snapshotMu.Lock()
+	pending := maps.Clone(t.unsaved)
+	t.snapshotMu.Unlock()
+
+	for key, seconds := range pending {
+		if err := t.usageStore.IncrementDailyUsage(context.Background(), key.date, key.deviceID, key.limitID, seconds); err != nil {
+			t.logger.Warn().Err(err).Msg("Failed to save usage kept while storage was unreachable")
+			return
+		}
+
+		t.snapshotMu.Lock()
+		t.unsaved[key] -= seconds
+		if t.unsaved[key] == 0 {
+			delete(t.unsaved, key)
+		}
+		t.addToSnapshotLocked(key, seconds)
+		t.snapshotMu.Unlock()
+
+		t.logger.Info().
+			Str("date", key.date).
+			Str("device_id", key.deviceID).
+			Str("limit_id", key.limitID).
+			Int64("seconds", seconds).
+			Msg("Saved usage kept while storage was unreachable")
+	}
+}
+
 func errorsIsNotFound(err error) bool {
 	return errors.Is(err, storage.ErrNotFound)
 }
@@ -349,6 +557,8 @@ func (t *Tracker) cleanupInactiveSessions() {
 		}
 
 		t.mu.Unlock()
+
+		t.saveUnsaved()
 	}
 }
 
@@ -0,0 +1,103 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+	"github.com/goodtune/kproxy/internal/storage/memory"
+	"github.com/rs/zerolog"
+)
+
+// flakyUsageStore fails every call while down
+type flakyUsageStore struct {
+	storage.UsageStore
+	down bool
+}
+
+var errDown = errors.New("connection refused")
+
+func (s *flakyUsageStore) GetDailyUsage(ctx context.Context, date, deviceID, limitID string) (*storage.DailyUsage, error) {
+	if s.down {
+		return nil, errDown
+	}
+	return s.UsageStore.GetDailyUsage(ctx, date, deviceID, limitID)
+}
+
+func (s *flakyUsageStore) IncrementDailyUsage(ctx context.Context, date, deviceID, limitID string, seconds int64) error {
+	if s.down {
+		return errDown
+	}
+	return s.UsageStore.IncrementDailyUsage(ctx, date, deviceID, limitID, seconds)
+}
+
+func (s *flakyUsageStore) UpsertSession(ctx context.Context, session storage.UsageSession) error {
+	if s.down {
+		return errDown
+	}
+	return s.UsageStore.UpsertSession(ctx, session)
+}
+
+func TestTrackerDegraded(t *testing.T) {
+	store := &flakyUsageStore{UsageStore: memory.Open().Usage()}
+	tracker := NewTracker(store, Config{}, zerolog.Nop())
+	healthy := true
+	tracker.SetStorageHealth(func() bool { return healthy })
+
+	ctx := context.Background()
+	today := time.Now().Format("2006-01-02")
+	if err := store.IncrementDailyUsage(ctx, today, "aa:bb:cc:dd:ee:01", "gaming", 600); err != nil {
+		t.Fatal(err)
+	}
+	if usage, err := tracker.GetCategoryUsage("aa:bb:cc:dd:ee:01", "gaming"); err != nil || usage != 10*time.Minute {
+		t.Fatalf("GetCategoryUsage() = %v, %v; want 10m", usage, err)
+	}
+
+	// Storage fails between health checks: the last total read is used
+	store.down = true
+	if usage, err := tracker.GetCategoryUsage("aa:bb:cc:dd:ee:01", "gaming"); err != nil || usage != 10*time.Minute {
+		t.Errorf("GetCategoryUsage() with storage failing = %v, %v; want 10m from memory", usage, err)
+	}
+
+	// Sessions ending while storage is down are counted from memory, then
+	// saved once it is back
+	healthy = false
+	session := &Session{
+		ID:                 "s1",
+		DeviceID:           "aa:bb:cc:dd:ee:01",
+		LimitID:            "gaming",
+		StartedAt:          time.Now(),
+		LastActivity:       time.Now(),
+		AccumulatedSeconds: 300,
+		Active:             true,
+	}
+	tracker.mu.Lock()
+	if err := tracker.finalizeSession(session); err != nil {
+		t.Errorf("finalizeSession() while storage is down = %v", err)
+	}
+	tracker.mu.Unlock()
+	if usage, err := tracker.GetCategoryUsage("aa:bb:cc:dd:ee:01", "gaming"); err != nil || usage != 15*time.Minute {
+		t.Errorf("GetCategoryUsage() after a session ended = %v, %v; want 15m", usage, err)
+	}
+
+	store.down = false
+	healthy = true
+	tracker.saveUnsaved()
+	if daily, err := store.GetDailyUsage(ctx, today, "aa:bb:cc:dd:ee:01", "gaming"); err != nil || daily.TotalSeconds != 900 {
+		t.Errorf("daily usage saved after recovering = %+v, %v; want 900 seconds", daily, err)
+	}
+	if usage, err := tracker.GetCategoryUsage("aa:bb:cc:dd:ee:01", "gaming"); err != nil || usage != 15*time.Minute {
+		t.Errorf("GetCategoryUsage() after recovering = %v, %v; want 15m counted once", usage, err)
+	}
+}
+
+func TestTrackerWithoutHealthChecks(t *testing.T) {
+	// Without health checks a failing store is reported, as before
+	store := &flakyUsageStore{UsageStore: memory.Open().Usage(), down: true}
+	tracker := NewTracker(store, Config{}, zerolog.Nop())
+	if _, err := tracker.GetCategoryUsage("aa:bb:cc:dd:ee:01", "gaming"); !errors.Is(err, errDown) {
+		t.Errorf("GetCategoryUsage() = %v, want the storage error", err)
+	}
+}
@@ -30,3 +30,11 @@ type UsageStats struct {
 	LimitExceeded  bool
 	ActiveSession  *Session
 }
+
+// Meter is one device's usage of one usage limit today
+type Meter struct {
+	DeviceID string `json:"device_id"`
+	LimitID  string `json:"limit_id"`
+	Seconds  int64  `json:"seconds"`
+	Active   bool   `json:"active"` // A session is running
+}
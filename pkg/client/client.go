@@ -0,0 +1,146 @@
+// Package client calls the KProxy admin API. The kproxy commands use it;
+// the API itself is described by GET /api/openapi.json.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// DefaultTimeout bounds each request of a client created without an HTTP
+// client
+const DefaultTimeout = 10 * time.Second
+
+// Client calls the admin API at a base URL with a bearer token: the admin
+// token, an admin account's token or an API token
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// New creates a client for the admin API at baseURL, e.g.
+// "http://127.0.0.1:8080". A nil httpClient uses one with DefaultTimeout.
+func New(baseURL, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &Client{baseURL: baseURL, token: token, httpClient: httpClient}
+}
+
+// WithToken returns a copy of the client that authenticates with token,
+// e.g. to act as a second admin account
+func (c *Client) WithToken(token string) *Client {
+	clone := *c
+	clone.token = token
+	return &clone
+}
+
+// HeldError is returned for a change the admin API holds until a second
+// admin account approves it
+type HeldError struct {
+	Change storage.PendingChange
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("change %s is waiting for approval by another admin", e.Change.ID)
+}
+
+// APIError is an error answer of the admin API
+type APIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("admin API returned %s: %s", e.Status, e.Message)
+}
+
+// Do sends body (if not nil) as JSON and decodes the response into out (if
+// not nil)
+func (c *Client) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid admin API response: %w", err)
+	}
+	return nil
+}
+
+// Fetch gets path and returns the response body as is, for responses that
+// aren't JSON
+func (c *Client) Fetch(ctx context.Context, path string) ([]byte, error) {
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin API response: %w", err)
+	}
+	return data, nil
+}
+
+// send sends body (if not nil) as JSON and returns a successful response.
+// The caller closes its body.
+func (c *Client) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+	return c.sendRaw(ctx, method, path, "application/json", &reqBody)
+}
+
+// sendRaw sends body as is and returns a successful response. The caller
+// closes its body.
+func (c *Client) sendRaw(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin API URL: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("admin API request failed: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusAccepted {
+		defer func() { _ = resp.Body.Close() }()
+		held := &HeldError{}
+		if err := json.NewDecoder(resp.Body).Decode(&held.Change); err != nil {
+			return nil, fmt.Errorf("invalid admin API response: %w", err)
+		}
+		return nil, held
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		defer func() { _ = resp.Body.Close() }()
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return nil, &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Message: apiErr.Error}
+	}
+	return resp, nil
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goodtune/kproxy/internal/rules"
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/rules":
+			var req RuleRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("decode request: %v", err)
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(rules.Rule{ID: "r1", Profile: req.Profile, Domain: req.Domain, Action: req.Action})
+		case "DELETE /api/rules/r1":
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(storage.PendingChange{ID: "c1"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	api := New(srv.URL, "secret", nil)
+
+	rule, err := api.AddRule(ctx, RuleRequest{Profile: "child", Domain: "example.com", Action: rules.ActionAllow})
+	if err != nil {
+		t.Fatalf("AddRule: %v", err)
+	}
+	if rule.ID != "r1" || rule.Domain != "example.com" {
+		t.Errorf("rule = %+v, want r1 for example.com", rule)
+	}
+
+	var held *HeldError
+	if err := api.RemoveRule(ctx, "r1"); !errors.As(err, &held) || held.Change.ID != "c1" {
+		t.Errorf("RemoveRule = %v, want change c1 held", err)
+	}
+
+	var apiErr *APIError
+	if _, err := api.RuleSet(ctx, "missing"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "not found" {
+		t.Errorf("RuleSet = %v, want a 404 API error", err)
+	}
+	if _, err := api.WithToken("wrong").Rules(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Rules with a wrong token = %v, want a 401 API error", err)
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/devices"
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// Devices lists configured and runtime devices, only those waiting in
+// quarantine if pendingOnly is set
+func (c *Client) Devices(ctx context.Context, pendingOnly bool) ([]admin.DeviceInfo, error) {
+	path := "/api/devices"
+	if pendingOnly {
+		path += "?pending=true"
+	}
+	var list []admin.DeviceInfo
+	err := c.Do(ctx, http.MethodGet, path, nil, &list)
+	return list, err
+}
+
+// AddDevice adds a device at runtime
+func (c *Client) AddDevice(ctx context.Context, req admin.DeviceRequest) (devices.Device, error) {
+	var device devices.Device
+	err := c.Do(ctx, http.MethodPost, "/api/devices", req, &device)
+	return device, err
+}
+
+// AssignProfile assigns a profile to a configured or runtime device. It
+// returns a *HeldError when the assignment waits for approval.
+func (c *Client) AssignProfile(ctx context.Context, id, profile string) (admin.DeviceInfo, error) {
+	var device admin.DeviceInfo
+	err := c.Do(ctx, http.MethodPut, "/api/devices/"+url.PathEscape(id)+"/profile", admin.AssignRequest{Profile: profile}, &device)
+	return device, err
+}
+
+// IdentifyDevice describes what KProxy knows about a client address
+func (c *Client) IdentifyDevice(ctx context.Context, ip string) (admin.Identification, error) {
+	var id admin.Identification
+	err := c.Do(ctx, http.MethodGet, "/api/devices/identify?ip="+url.QueryEscape(ip), nil, &id)
+	return id, err
+}
+
+// DeviceRules returns the rules in effect for a device
+func (c *Client) DeviceRules(ctx context.Context, id string) ([]storage.DeviceRule, error) {
+	var list []storage.DeviceRule
+	err := c.Do(ctx, http.MethodGet, "/api/devices/"+url.PathEscape(id)+"/rules", nil, &list)
+	return list, err
+}
+
+// AddDeviceRule adds a rule for a configured or runtime device
+func (c *Client) AddDeviceRule(ctx context.Context, id string, req admin.DeviceRuleRequest) (storage.DeviceRule, error) {
+	var rule storage.DeviceRule
+	err := c.Do(ctx, http.MethodPost, "/api/devices/"+url.PathEscape(id)+"/rules", req, &rule)
+	return rule, err
+}
+
+// RemoveDeviceRule removes a rule of a device. It returns a *HeldError
+// when the removal waits for approval.
+func (c *Client) RemoveDeviceRule(ctx context.Context, id, ruleID string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/devices/"+url.PathEscape(id)+"/rules/"+url.PathEscape(ruleID), nil, nil)
+}
+
+// Profiles lists the profiles defined by policy
+func (c *Client) Profiles(ctx context.Context) ([]admin.ProfileInfo, error) {
+	var list []admin.ProfileInfo
+	err := c.Do(ctx, http.MethodGet, "/api/profiles", nil, &list)
+	return list, err
+}
+
+// Profile returns a profile with its runtime rules and weekly schedule
+func (c *Client) Profile(ctx context.Context, id string) (admin.ProfileDetail, error) {
+	var profile admin.ProfileDetail
+	err := c.Do(ctx, http.MethodGet, "/api/profiles/"+url.PathEscape(id), nil, &profile)
+	return profile, err
+}
+
+// Pauses lists the devices and profiles paused now
+func (c *Client) Pauses(ctx context.Context) ([]storage.Pause, error) {
+	var list []storage.Pause
+	err := c.Do(ctx, http.MethodGet, "/api/pauses", nil, &list)
+	return list, err
+}
+
+// Pause pauses a device or profile (kind PauseDevice or PauseProfile)
+func (c *Client) Pause(ctx context.Context, kind, target string, req admin.PauseRequest) (storage.Pause, error) {
+	var p storage.Pause
+	err := c.Do(ctx, http.MethodPut, pausePath(kind, target), req, &p)
+	return p, err
+}
+
+// Resume ends the pause of a device or profile, returning the device ID
+// or profile name
+func (c *Client) Resume(ctx context.Context, kind, target string) (string, error) {
+	var resp map[string]string
+	err := c.Do(ctx, http.MethodDelete, pausePath(kind, target), nil, &resp)
+	return resp["resumed"], err
+}
+
+// pausePath is the path of the pause of a device or profile
+func pausePath(kind, target string) string {
+	return "/api/" + kind + "s/" + url.PathEscape(target) + "/pause"
+}
+
+// Leases lists the unexpired DHCP leases
+func (c *Client) Leases(ctx context.Context) ([]admin.LeaseInfo, error) {
+	var leases []admin.LeaseInfo
+	err := c.Do(ctx, http.MethodGet, "/api/dhcp/leases", nil, &leases)
+	return leases, err
+}
+
+// ReleaseLease expires the DHCP lease of a MAC address now, returning the
+// address in canonical form
+func (c *Client) ReleaseLease(ctx context.Context, mac string) (string, error) {
+	var resp map[string]string
+	err := c.Do(ctx, http.MethodDelete, "/api/dhcp/leases/"+url.PathEscape(mac), nil, &resp)
+	return resp["released"], err
+}
+
+// ReserveLease reserves the address of a DHCP lease for its client
+func (c *Client) ReserveLease(ctx context.Context, mac string) (storage.DHCPReservation, error) {
+	var reservation storage.DHCPReservation
+	err := c.Do(ctx, http.MethodPost, "/api/dhcp/leases/"+url.PathEscape(mac)+"/reservation", nil, &reservation)
+	return reservation, err
+}
+
+// Reservations lists the DHCP reservations
+func (c *Client) Reservations(ctx context.Context) ([]storage.DHCPReservation, error) {
+	var reservations []storage.DHCPReservation
+	err := c.Do(ctx, http.MethodGet, "/api/dhcp/reservations", nil, &reservations)
+	return reservations, err
+}
+
+// RemoveReservation removes the DHCP reservation of a MAC address,
+// returning the address in canonical form
+func (c *Client) RemoveReservation(ctx context.Context, mac string) (string, error) {
+	var resp map[string]string
+	err := c.Do(ctx, http.MethodDelete, "/api/dhcp/reservations/"+url.PathEscape(mac), nil, &resp)
+	return resp["removed"], err
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/goodtune/kproxy/internal/access"
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/policy"
+	"github.com/goodtune/kproxy/internal/policyedit"
+	"github.com/goodtune/kproxy/internal/rules"
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// Rules returns the runtime rules in effect
+func (c *Client) Rules(ctx context.Context) ([]rules.Rule, error) {
+	var list []rules.Rule
+	err := c.Do(ctx, http.MethodGet, "/api/rules", nil, &list)
+	return list, err
+}
+
+// AddRule adds a runtime rule
+func (c *Client) AddRule(ctx context.Context, req admin.RuleRequest) (rules.Rule, error) {
+	var rule rules.Rule
+	err := c.Do(ctx, http.MethodPost, "/api/rules", req, &rule)
+	return rule, err
+}
+
+// RemoveRule removes a runtime rule. It returns a *HeldError when the
+// removal waits for approval.
+func (c *Client) RemoveRule(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/rules/"+url.PathEscape(id), nil, nil)
+}
+
+// RuleSets lists the rule sets saved at runtime
+func (c *Client) RuleSets(ctx context.Context) ([]storage.RuleSet, error) {
+	var list []storage.RuleSet
+	err := c.Do(ctx, http.MethodGet, "/api/rulesets", nil, &list)
+	return list, err
+}
+
+// RuleSet returns a rule set
+func (c *Client) RuleSet(ctx context.Context, id string) (storage.RuleSet, error) {
+	var set storage.RuleSet
+	err := c.Do(ctx, http.MethodGet, "/api/rulesets/"+url.PathEscape(id), nil, &set)
+	return set, err
+}
+
+// PutRuleSet creates or replaces a rule set. It returns a *HeldError when
+// dropping or changing rules or detaching profiles waits for approval.
+func (c *Client) PutRuleSet(ctx context.Context, id string, set storage.RuleSet) (storage.RuleSet, error) {
+	var saved storage.RuleSet
+	err := c.Do(ctx, http.MethodPut, "/api/rulesets/"+url.PathEscape(id), set, &saved)
+	return saved, err
+}
+
+// AttachRuleSet attaches a rule set to exactly the given profiles. It
+// returns a *HeldError when detaching profiles waits for approval.
+func (c *Client) AttachRuleSet(ctx context.Context, id string, profiles []string) (storage.RuleSet, error) {
+	var set storage.RuleSet
+	err := c.Do(ctx, http.MethodPut, "/api/rulesets/"+url.PathEscape(id)+"/profiles", admin.RuleSetProfilesRequest{Profiles: profiles}, &set)
+	return set, err
+}
+
+// RemoveRuleSet deletes a rule set. It returns a *HeldError when the
+// removal waits for approval.
+func (c *Client) RemoveRuleSet(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/rulesets/"+url.PathEscape(id), nil, nil)
+}
+
+// AccessRequests lists the access requests waiting for an answer
+func (c *Client) AccessRequests(ctx context.Context) ([]access.Request, error) {
+	var list []access.Request
+	err := c.Do(ctx, http.MethodGet, "/api/access-requests", nil, &list)
+	return list, err
+}
+
+// ApproveAccessRequest approves an access request, returning the
+// temporary rule allowing the site
+func (c *Client) ApproveAccessRequest(ctx context.Context, id string, req admin.AccessApproveRequest) (rules.Rule, error) {
+	var rule rules.Rule
+	err := c.Do(ctx, http.MethodPost, "/api/access-requests/"+url.PathEscape(id)+"/approve", req, &rule)
+	return rule, err
+}
+
+// DenyAccessRequest drops an access request without allowing anything
+func (c *Client) DenyAccessRequest(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/access-requests/"+url.PathEscape(id), nil, nil)
+}
+
+// Approvals lists the changes waiting for approval
+func (c *Client) Approvals(ctx context.Context) ([]storage.PendingChange, error) {
+	var list []storage.PendingChange
+	err := c.Do(ctx, http.MethodGet, "/api/approvals", nil, &list)
+	return list, err
+}
+
+// Approve approves a pending change and applies it
+func (c *Client) Approve(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodPost, "/api/approvals/"+url.PathEscape(id)+"/approve", nil, nil)
+}
+
+// Reject drops a pending change without applying it
+func (c *Client) Reject(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/approvals/"+url.PathEscape(id), nil, nil)
+}
+
+// BlockPages lists the custom block page templates
+func (c *Client) BlockPages(ctx context.Context) ([]storage.BlockPage, error) {
+	var list []storage.BlockPage
+	err := c.Do(ctx, http.MethodGet, "/api/blockpages", nil, &list)
+	return list, err
+}
+
+// BlockPage returns a custom block page template
+func (c *Client) BlockPage(ctx context.Context, name string) (storage.BlockPage, error) {
+	var page storage.BlockPage
+	err := c.Do(ctx, http.MethodGet, "/api/blockpages/"+url.PathEscape(name), nil, &page)
+	return page, err
+}
+
+// PutBlockPage creates or replaces a custom block page template
+func (c *Client) PutBlockPage(ctx context.Context, name, template string) (storage.BlockPage, error) {
+	var page storage.BlockPage
+	err := c.Do(ctx, http.MethodPut, "/api/blockpages/"+url.PathEscape(name), admin.BlockPageRequest{Template: template}, &page)
+	return page, err
+}
+
+// RemoveBlockPage deletes a custom block page template
+func (c *Client) RemoveBlockPage(ctx context.Context, name string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/blockpages/"+url.PathEscape(name), nil, nil)
+}
+
+// Policies lists the policy files
+func (c *Client) Policies(ctx context.Context) ([]policyedit.File, error) {
+	var files []policyedit.File
+	err := c.Do(ctx, http.MethodGet, "/api/policies", nil, &files)
+	return files, err
+}
+
+// Policy returns one policy file with its content
+func (c *Client) Policy(ctx context.Context, name string) (policyedit.File, error) {
+	var file policyedit.File
+	err := c.Do(ctx, http.MethodGet, "/api/policies/"+url.PathEscape(name), nil, &file)
+	return file, err
+}
+
+// PutPolicy compiles a policy file with the rest of the policies and,
+// unless req.DryRun is set, saves it and reloads the policies
+func (c *Client) PutPolicy(ctx context.Context, name string, req admin.PolicyRequest) (policyedit.Result, error) {
+	var result policyedit.Result
+	err := c.Do(ctx, http.MethodPut, "/api/policies/"+url.PathEscape(name), req, &result)
+	return result, err
+}
+
+// Export returns config.rego's data and the runtime state
+func (c *Client) Export(ctx context.Context) (admin.Export, error) {
+	var export admin.Export
+	err := c.Do(ctx, http.MethodGet, "/api/export", nil, &export)
+	return export, err
+}
+
+// ExportFile returns the export document as served, in format json or
+// yaml
+func (c *Client) ExportFile(ctx context.Context, format string) ([]byte, error) {
+	return c.Fetch(ctx, "/api/export?format="+url.QueryEscape(format))
+}
+
+// Import replaces the config.rego sections in export and adds or replaces
+// its runtime state; with dryRun it only reports the changes. It returns a
+// *HeldError when the import waits for approval.
+func (c *Client) Import(ctx context.Context, export admin.Export, dryRun bool) (admin.ImportResult, error) {
+	path := "/api/import"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+	var result admin.ImportResult
+	err := c.Do(ctx, http.MethodPost, path, export, &result)
+	return result, err
+}
+
+// Canary reports the decisions on which the candidate policies differ
+// from the active ones
+func (c *Client) Canary(ctx context.Context) (policy.CanaryReport, error) {
+	var report policy.CanaryReport
+	err := c.Do(ctx, http.MethodGet, "/api/canary", nil, &report)
+	return report, err
+}
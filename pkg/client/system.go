@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/backup"
+	"github.com/goodtune/kproxy/internal/ca"
+	"github.com/goodtune/kproxy/internal/maintenance"
+	"github.com/goodtune/kproxy/internal/probe"
+	"github.com/goodtune/kproxy/internal/report"
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// AuditQuery filters the audit log. Zero fields don't filter.
+type AuditQuery struct {
+	Account string
+	Path    string        // Substring of the API path
+	Since   time.Duration // How far back to look
+	Limit   int           // Server default when 0
+}
+
+// DecisionQuery filters recorded decisions. Zero fields don't filter.
+type DecisionQuery struct {
+	Kind   string // "dns" or "proxy"
+	Client string // Client IP address
+	Host   string // Host or DNS domain
+	Since  time.Time
+	Until  time.Time
+	Limit  int // Server default when 0
+}
+
+// Maintenance returns the current maintenance window
+func (c *Client) Maintenance(ctx context.Context) (maintenance.Status, error) {
+	var status maintenance.Status
+	err := c.Do(ctx, http.MethodGet, "/api/maintenance", nil, &status)
+	return status, err
+}
+
+// StartMaintenance starts or extends a maintenance window
+func (c *Client) StartMaintenance(ctx context.Context, req admin.MaintenanceRequest) (maintenance.Status, error) {
+	var status maintenance.Status
+	err := c.Do(ctx, http.MethodPut, "/api/maintenance", req, &status)
+	return status, err
+}
+
+// EndMaintenance ends the maintenance window
+func (c *Client) EndMaintenance(ctx context.Context) (maintenance.Status, error) {
+	var status maintenance.Status
+	err := c.Do(ctx, http.MethodDelete, "/api/maintenance", nil, &status)
+	return status, err
+}
+
+// SystemInfo returns the deployment details and enabled features
+func (c *Client) SystemInfo(ctx context.Context) (admin.SystemInfo, error) {
+	var info admin.SystemInfo
+	err := c.Do(ctx, http.MethodGet, "/api/system/info", nil, &info)
+	return info, err
+}
+
+// Activity returns query rates, the top busiest clients, recent blocks and
+// today's usage
+func (c *Client) Activity(ctx context.Context, top int) (admin.Activity, error) {
+	var act admin.Activity
+	err := c.Do(ctx, http.MethodGet, "/api/activity?top="+strconv.Itoa(top), nil, &act)
+	return act, err
+}
+
+// Probes returns the connectivity probe history, the whole of it when
+// since is 0
+func (c *Client) Probes(ctx context.Context, since time.Duration) (probe.History, error) {
+	path := "/api/probes"
+	if since > 0 {
+		path += "?since=" + url.QueryEscape(since.String())
+	}
+	var h probe.History
+	err := c.Do(ctx, http.MethodGet, path, nil, &h)
+	return h, err
+}
+
+// WeeklyReport returns a profile's report for the week ending on end
+// (YYYY-MM-DD, today when empty)
+func (c *Client) WeeklyReport(ctx context.Context, profile, end string) (report.Weekly, error) {
+	var weekly report.Weekly
+	err := c.Do(ctx, http.MethodGet, "/api/reports/weekly?"+weeklyQuery(profile, end, "json"), nil, &weekly)
+	return weekly, err
+}
+
+// WeeklyReportFile returns a profile's weekly report rendered as format,
+// "html" or "json"
+func (c *Client) WeeklyReportFile(ctx context.Context, profile, end, format string) ([]byte, error) {
+	return c.Fetch(ctx, "/api/reports/weekly?"+weeklyQuery(profile, end, format))
+}
+
+// weeklyQuery is the query of a weekly report request
+func weeklyQuery(profile, end, format string) string {
+	query := url.Values{"profile": {profile}, "format": {format}}
+	if end != "" {
+		query.Set("end", end)
+	}
+	return query.Encode()
+}
+
+// AuditLog searches the audit log, newest first
+func (c *Client) AuditLog(ctx context.Context, q AuditQuery) ([]storage.AuditEntry, error) {
+	query := url.Values{}
+	if q.Account != "" {
+		query.Set("account", q.Account)
+	}
+	if q.Path != "" {
+		query.Set("path", q.Path)
+	}
+	if q.Since > 0 {
+		query.Set("since", q.Since.String())
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var entries []storage.AuditEntry
+	err := c.Do(ctx, http.MethodGet, "/api/audit?"+query.Encode(), nil, &entries)
+	return entries, err
+}
+
+// AuditEntry returns one audit log entry
+func (c *Client) AuditEntry(ctx context.Context, id string) (storage.AuditEntry, error) {
+	var entry storage.AuditEntry
+	err := c.Do(ctx, http.MethodGet, "/api/audit/"+url.PathEscape(id), nil, &entry)
+	return entry, err
+}
+
+// Decisions searches the recorded policy decisions, newest first
+func (c *Client) Decisions(ctx context.Context, q DecisionQuery) ([]storage.Decision, error) {
+	query := url.Values{}
+	for name, v := range map[string]string{"kind": q.Kind, "client": q.Client, "host": q.Host} {
+		if v != "" {
+			query.Set(name, v)
+		}
+	}
+	if !q.Since.IsZero() {
+		query.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		query.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var decisions []storage.Decision
+	err := c.Do(ctx, http.MethodGet, "/api/decisions?"+query.Encode(), nil, &decisions)
+	return decisions, err
+}
+
+// Decision returns one recorded policy decision
+func (c *Client) Decision(ctx context.Context, id string) (storage.Decision, error) {
+	var decision storage.Decision
+	err := c.Do(ctx, http.MethodGet, "/api/decisions/"+url.PathEscape(id), nil, &decision)
+	return decision, err
+}
+
+// APITokens lists the API tokens, without their secrets
+func (c *Client) APITokens(ctx context.Context) ([]storage.APIToken, error) {
+	var tokens []storage.APIToken
+	err := c.Do(ctx, http.MethodGet, "/api/tokens", nil, &tokens)
+	return tokens, err
+}
+
+// CreateAPIToken creates an API token. The response holds its secret,
+// which can't be read again.
+func (c *Client) CreateAPIToken(ctx context.Context, req admin.APITokenRequest) (admin.APITokenResponse, error) {
+	var created admin.APITokenResponse
+	err := c.Do(ctx, http.MethodPost, "/api/tokens", req, &created)
+	return created, err
+}
+
+// RevokeAPIToken revokes an API token
+func (c *Client) RevokeAPIToken(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, "/api/tokens/"+url.PathEscape(id), nil, nil)
+}
+
+// RotateCA generates a new intermediate, and with req.Root a new root
+func (c *Client) RotateCA(ctx context.Context, req admin.CARotateRequest) (ca.Rotation, error) {
+	var rotation ca.Rotation
+	err := c.Do(ctx, http.MethodPost, "/api/ca/rotate", req, &rotation)
+	return rotation, err
+}
+
+// Backup returns a backup archive, with the CA certificates and keys if
+// withCA is set
+func (c *Client) Backup(ctx context.Context, withCA bool) ([]byte, error) {
+	return c.Fetch(ctx, "/api/system/backup?ca="+strconv.FormatBool(withCA))
+}
+
+// Restore restores a backup archive, with its CA files if withCA is set;
+// with dryRun it only reports what would change. It returns a *HeldError
+// when the restore waits for approval.
+func (c *Client) Restore(ctx context.Context, archive io.Reader, withCA, dryRun bool) (backup.Result, error) {
+	path := "/api/system/restore?ca=" + strconv.FormatBool(withCA) + "&dry_run=" + strconv.FormatBool(dryRun)
+	resp, err := c.sendRaw(ctx, http.MethodPost, path, "application/gzip", archive)
+	if err != nil {
+		return backup.Result{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result backup.Result
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return backup.Result{}, fmt.Errorf("invalid admin API response: %w", err)
+	}
+	return result, nil
+}
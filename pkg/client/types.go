@@ -0,0 +1,29 @@
+package client
+
+import (
+	"github.com/goodtune/kproxy/internal/admin"
+	"github.com/goodtune/kproxy/internal/storage"
+)
+
+// Request bodies, named here so programs outside this module can build
+// them; the packages they come from are internal
+type (
+	DeviceRequest        = admin.DeviceRequest
+	DeviceRuleRequest    = admin.DeviceRuleRequest
+	PauseRequest         = admin.PauseRequest
+	RuleRequest          = admin.RuleRequest
+	RuleSet              = storage.RuleSet
+	RuleSetRule          = storage.RuleSetRule
+	AccessApproveRequest = admin.AccessApproveRequest
+	PolicyRequest        = admin.PolicyRequest
+	MaintenanceRequest   = admin.MaintenanceRequest
+	APITokenRequest      = admin.APITokenRequest
+	CARotateRequest      = admin.CARotateRequest
+	Export               = admin.Export
+)
+
+// Kinds of pause taken by Pause and Resume
+const (
+	PauseDevice  = storage.PauseDevice
+	PauseProfile = storage.PauseProfile
+)
@@ -16,11 +16,103 @@ package kproxy.config
 # See docs/policy-tutorial.md for detailed examples.
 devices := {}
 
+# Subnet Default Profiles
+# Clients that match no device get the profile of the most specific subnet
+# containing their address, instead of being blocked as unknown devices.
+# Devices (including ones identified by a CIDR range) always take precedence.
+#
+# Example:
+#   subnet_profiles := {
+#       "192.168.20.0/24": "child",  # Kids VLAN
+#       "192.168.30.0/24": "guest"   # Guest Wi-Fi
+#   }
+subnet_profiles := {}
+
+# Device Type Default Profiles
+# Clients that match no device get the profile of their device type, as
+# guessed from their DHCP requests and User-Agents: "phone", "tablet", "tv",
+# "console" or "computer". The guess comes before subnet_profiles; devices
+# always take precedence.
+#
+# Example:
+#   device_type_profiles := {
+#       "tv": "tv-bedtime",  # Smart TVs and streaming sticks
+#       "console": "child"
+#   }
+device_type_profiles := {}
+
+# User Configuration
+# Users identified by an external authenticator (RADIUS accounting or a login
+# agent) can be assigned their own profile. Keys are the user names reported
+# by the authenticator. On a shared device the user's profile replaces the
+# device's profile while they are logged in.
+#
+# Example:
+#   users := {
+#       "alice": {
+#           "name": "Alice",
+#           "profile": "child"
+#       }
+#   }
+users := {}
+
 # Profile Configuration
 # Define access profiles with rules, time restrictions, and usage limits.
 #
 # The default profile below blocks all traffic as a secure baseline.
 # Customize this configuration for your network - see docs/policy-tutorial.md
+#
+# Example profile with rules, a time window and a usage limit:
+#   "child": {
+#       "name": "Child",
+#       "rules": [
+#           {
+#               "id": "allow-school",
+#               "domains": [".khanacademy.org", ".wikipedia.org"],
+#               "action": "allow",
+#               "category": "educational"
+#           },
+#           {
+#               "id": "allow-video",
+#               "domains": [".youtube.com", ".ytimg.com"],
+#               "action": "allow",
+#               "category": "entertainment"  # Counted by the usage limit below
+#           },
+#           {
+#               # Allowed, and sent through the "vpn" egress route
+#               "id": "route-streaming",
+#               "domains": [".bbc.co.uk"],
+#               "action": "route",
+#               "upstream": "vpn"
+#           }
+#       ],
+#       # Rule sets to apply after the profile's own rules (see rule_sets)
+#       "rule_sets": ["social-media"],
+#       # Internet only on weekday afternoons (days: 0 = Sunday ... 6 = Saturday)
+#       "time_restrictions": {
+#           "after-school": {
+#               "days": [1, 2, 3, 4, 5],
+#               "start_hour": 15, "start_minute": 0,
+#               "end_hour": 19, "end_minute": 0
+#           }
+#       },
+#       # One hour of entertainment a day
+#       "usage_limits": {
+#           "entertainment": {"daily_minutes": 60, "inject_timer": true}
+#       },
+#       # Warn 15 and 5 minutes before bedtime (19:00) or the hour runs out
+#       "warn_minutes": [15, 5],
+#       # Only one device streaming entertainment at a time
+#       "stream_limit": {"max_devices": 1, "categories": ["entertainment"]},
+#       # Downloads capped to 10 Mbps for the whole profile after 20:00
+#       "bandwidth_limits": {
+#           "evening": {
+#               "kbps": 10000, "days": [0, 1, 2, 3, 4, 5, 6],
+#               "start_hour": 20, "start_minute": 0, "end_hour": 24, "end_minute": 0
+#           }
+#       },
+#       "default_action": "block"
+#   }
 profiles := {"default": {
 	"name": "Default Profile",
 	"description": "Secure baseline - blocks all traffic",
@@ -30,6 +122,29 @@ profiles := {"default": {
 	"default_action": "block",
 }}
 
+# Rule Sets
+# Named collections of rules shared by several profiles. A rule set applies
+# to the profiles listed in its "profiles" and to profiles naming it in their
+# own "rule_sets", after the profile's own rules. Rule sets can also be saved
+# at runtime (kproxy ruleset); those replace one here with the same ID.
+#
+# Example rule set:
+#   rule_sets := {
+#       "social-media": {
+#           "name": "Social Media",
+#           "profiles": ["child", "teen"],
+#           "rules": [
+#               {
+#                   "id": "block-social",
+#                   "domains": [".tiktok.com", ".instagram.com", ".snapchat.com"],
+#                   "action": "block",
+#                   "category": "social"
+#               }
+#           ]
+#       }
+#   }
+rule_sets := {}
+
 # Global Bypass Domains
 # These domains always bypass the proxy (never intercepted).
 # Use for certificate validation and sensitive sites to avoid MITM.
@@ -42,6 +157,18 @@ profiles := {"default": {
 # See docs/policy-tutorial.md Step 6 for guidance on bypass domains.
 bypass_domains := []
 
+# Search Concern Lists
+# Named lists of case-insensitive regular expressions matched against search
+# queries, for profiles with "search_monitoring": {"alert": true}. Search
+# monitoring must also be enabled in the YAML config (search_monitoring).
+#
+# Example:
+#   search_concern_lists := {
+#       "self_harm": ["\\bsuicide\\b", "self[- ]?harm"],
+#       "profanity": ["\\bdamn\\b"]
+#   }
+search_concern_lists := {}
+
 # Server Name Configuration
 # The server name is used for client setup (certificate download, etc.)
 # This domain is always intercepted and allowed.
@@ -11,59 +11,186 @@ import data.kproxy.helpers
 # Input structure (facts only):
 # {
 #   "client_ip": "192.168.1.100",
-#   "client_mac": "aa:bb:cc:dd:ee:ff"  // optional, may be empty
+#   "client_mac": "aa:bb:cc:dd:ee:ff",  // optional, may be empty
+#   "user": {"name": "alice", "source": "radius"},  // optional, logged-in user
+#   "device_type": "tv",  // optional, guessed from DHCP requests and User-Agents
+#   "runtime_devices": {"sams-ipad": {...}},  // optional, devices added at runtime
+#   "device_profiles": {"sams-ipad": "teen"}  // optional, profiles assigned at runtime
 # }
 #
-# Device configuration comes from data.kproxy.config.devices
+# Device configuration comes from data.kproxy.config.devices, plus devices
+# added and profiles assigned through the admin API (kproxy device)
+# User configuration comes from data.kproxy.config.users
+# Device type default profiles come from data.kproxy.config.device_type_profiles
+# Subnet default profiles come from data.kproxy.config.subnet_profiles
+
+# Identify the device, applying the logged-in user's profile when the user
+# is configured. This lets a shared computer follow whoever is using it.
+identified_device := object.union(physical_device, {"profile": user.profile, "user": input.user.name}) if {
+	user := identified_user
+}
+
+# A configured user on an unknown device still gets their own profile
+identified_device := {"name": user.name, "profile": user.profile, "user": input.user.name} if {
+	user := identified_user
+	not physical_device
+}
+
+# No configured user: the physical device decides
+identified_device := physical_device if {
+	not identified_user
+}
+
+# No configured user or device: the client's device type decides
+identified_device := device_type_device if {
+	not identified_user
+	not physical_device
+}
+
+# No configured user, device or device type profile: the client's subnet
+# decides
+identified_device := subnet_device if {
+	not identified_user
+	not physical_device
+	not device_type_device
+}
+
+# Look up the logged-in user (from RADIUS accounting or an agent heartbeat)
+identified_user := user if {
+	user := config.users[input.user.name]
+}
 
 # Identify device by MAC address (highest priority, most reliable)
-identified_device := device if {
+physical_device := device if {
 	# MAC address provided
 	input.client_mac != ""
 
 	# Find matching device in config
-	some device_id, device in config.devices
+	some device_id, device in all_devices
 	some identifier in device.identifiers
 	is_mac_address(identifier)
 	lower(identifier) == lower(input.client_mac)
 }
 
 # Identify device by exact IP match (second priority)
-identified_device := device if {
+physical_device := device if {
 	# MAC not available or didn't match
 	not device_by_mac
 
 	# Find matching device by exact IP
-	some device_id, device in config.devices
+	some device_id, device in all_devices
 	some identifier in device.identifiers
 	is_ip_address(identifier)
-	input.client_ip == identifier
+	ip_equal(identifier, input.client_ip)
 }
 
 # Identify device by CIDR range (third priority)
-identified_device := device if {
+physical_device := device if {
 	# MAC and exact IP didn't match
 	not device_by_mac
 	not device_by_exact_ip
 
 	# Find matching device by CIDR
-	some device_id, device in config.devices
+	some device_id, device in all_devices
 	some identifier in device.identifiers
 	is_cidr(identifier)
 	helpers.ip_in_cidr(input.client_ip, identifier)
 }
 
+# Unknown client whose guessed device type has a default profile. The type
+# is reported so logs and "kproxy device identify" show why the profile
+# applies.
+device_type_device := {
+	"name": sprintf("Unknown %s", [input.device_type]),
+	"identifiers": [],
+	"profile": config.device_type_profiles[input.device_type],
+	"device_type": input.device_type,
+}
+
+# Unknown client on a subnet with a default profile. The subnet is reported
+# so logs and "kproxy device identify" show why the profile applies.
+subnet_device := {
+	"name": sprintf("Unknown device on %s", [cidr]),
+	"identifiers": [],
+	"profile": config.subnet_profiles[cidr],
+	"subnet": cidr,
+} if {
+	cidr := subnet_match
+}
+
+# The most specific subnet_profiles entry containing the client address
+subnet_match := max(matches)[1] if {
+	matches := [[prefix_length(cidr), cidr] |
+		some cidr, _ in config.subnet_profiles
+		helpers.ip_in_cidr(input.client_ip, cidr)
+	]
+	count(matches) > 0
+}
+
+# Helper: prefix length of a CIDR range ("192.168.20.0/24" -> 24)
+prefix_length(cidr) := to_number(split(cidr, "/")[1])
+
+# All devices: configured ones and those added at runtime, with profiles
+# assigned at runtime replacing the device's own
+all_devices := {id: object.union(d, assigned_profile(id)) |
+	some id, d in object.union(config.devices, object.get(input, "runtime_devices", {}))
+}
+
+# Helper: Profile assigned to a device at runtime, as an object to merge
+assigned_profile(id) := {"profile": input.device_profiles[id]} if {
+	input.device_profiles[id]
+} else := {}
+
+# Device lookup for the admin API (kproxy device): all devices, configured
+# profile names, and the device identified from the input facts
+lookup := {
+	"devices": all_devices,
+	"profiles": object.keys(config.profiles),
+	"device_id": lookup_device_id,
+	"device": lookup_device,
+}
+
+default lookup_device_id := ""
+
+lookup_device_id := device_id
+
+default lookup_device := null
+
+lookup_device := identified_device
+
+# Rules added at runtime for the identified device. Devices identified by
+# user or subnet alone have none.
+default rules := []
+
+rules := helpers.device_rules(device_id)
+
+# Pause of the identified device, or else of its profile (kproxy device
+# pause, kproxy profile pause). Go passes the pauses in effect as
+#   "paused": {"devices": {"kids-ipad": {"until": "2026-03-01T20:00:00Z"}},
+#              "profiles": {"child": {"until": ""}}}
+# ("until" is "" for pauses lasting until resumed), and adds the kind and
+# target: {"kind": "device", "target": "kids-ipad", "until": "..."}.
+pause := object.union({"kind": "device", "target": device_id}, p) if {
+	p := object.get(input, "paused", {}).devices[device_id]
+} else := object.union({"kind": "profile", "target": identified_device.profile}, p) if {
+	p := object.get(input, "paused", {}).profiles[identified_device.profile]
+}
+
+# The rules deciding a request from the identified device with profile:
+# device rules come first, so they override the profile's rules
+profile_rules(profile_id, profile) := array.concat(rules, helpers.profile_rules(profile_id, profile))
+
 # Get the device ID (for logging/tracking)
 device_id := did if {
-	device := identified_device
-	some did, d in config.devices
+	device := physical_device
+	some did, d in all_devices
 	d == device
 }
 
 # Helper: check if device was identified by MAC
 device_by_mac if {
 	input.client_mac != ""
-	some device_id, device in config.devices
+	some device_id, device in all_devices
 	some identifier in device.identifiers
 	is_mac_address(identifier)
 	lower(identifier) == lower(input.client_mac)
@@ -71,24 +198,36 @@ device_by_mac if {
 
 # Helper: check if device was identified by exact IP
 device_by_exact_ip if {
-	some device_id, device in config.devices
+	some device_id, device in all_devices
 	some identifier in device.identifiers
 	is_ip_address(identifier)
-	input.client_ip == identifier
+	ip_equal(identifier, input.client_ip)
 }
 
 # Helper: check if identifier is MAC address
+# (six hex pairs, so IPv6 addresses such as "fe80::1:2:3:4" are not mistaken for one)
 is_mac_address(id) if {
-	contains(id, ":")
-	count(split(id, ":")) == 6
+	regex.match(`^[0-9A-Fa-f]{2}(:[0-9A-Fa-f]{2}){5}$`, id)
 }
 
-# Helper: check if identifier is IP address (not MAC, not CIDR)
+# Helper: check if identifier is IP address, IPv4 or IPv6 (not MAC, not CIDR)
 is_ip_address(id) if {
-	not contains(id, ":")
+	not is_mac_address(id)
 	not contains(id, "/")
 }
 
+# Helper: compare an IP identifier with the client IP. IPv6 addresses have
+# many textual forms ("2001:DB8::0:1" == "2001:db8::1"), so compare as a /128.
+ip_equal(id, ip) if {
+	not contains(id, ":")
+	ip == id
+}
+
+ip_equal(id, ip) if {
+	contains(id, ":")
+	net.cidr_contains(sprintf("%s/128", [id]), ip)
+}
+
 # Helper: check if identifier is CIDR range
 is_cidr(id) if {
 	contains(id, "/")
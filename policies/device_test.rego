@@ -21,6 +21,16 @@ mock_config := {"devices": {
 		"identifiers": ["10.0.0.0/24"],
 		"profile": "cidr-profile",
 	},
+	"ipv6-device": {
+		"name": "IPv6 Device",
+		"identifiers": ["2001:db8::10"],
+		"profile": "ipv6-profile",
+	},
+	"ipv6-subnet-device": {
+		"name": "IPv6 Subnet Device",
+		"identifiers": ["2001:db8:1::/64"],
+		"profile": "ipv6-subnet-profile",
+	},
 	"multi-identifier-device": {
 		"name": "Multi Identifier Device",
 		"identifiers": [
@@ -205,3 +215,242 @@ test_cidr_outside_range if {
 			"client_mac": "",
 		}
 }
+
+# Configuration with a shared device and a user with their own profile
+mock_user_config := object.union(mock_config, {"users": {"alice": {
+	"name": "Alice",
+	"profile": "alice-profile",
+}}})
+
+# Test 15: Logged-in user's profile replaces the device profile
+test_user_overrides_device_profile if {
+	dev := device.identified_device with data.kproxy.config as mock_user_config
+		with input as {
+			"client_ip": "192.168.1.100",
+			"client_mac": "",
+			"user": {"name": "alice", "source": "radius"},
+		}
+
+	dev.name == "IP Device"
+	dev.profile == "alice-profile"
+	dev.user == "alice"
+}
+
+# Test 16: Logged-in user on an unknown device still gets their profile
+test_user_on_unknown_device if {
+	dev := device.identified_device with data.kproxy.config as mock_user_config
+		with input as {
+			"client_ip": "192.168.99.99",
+			"client_mac": "",
+			"user": {"name": "alice", "source": "agent"},
+		}
+
+	dev.name == "Alice"
+	dev.profile == "alice-profile"
+}
+
+# Test 17: Unconfigured user falls back to the device profile
+test_unknown_user_uses_device_profile if {
+	dev := device.identified_device with data.kproxy.config as mock_user_config
+		with input as {
+			"client_ip": "192.168.1.100",
+			"client_mac": "",
+			"user": {"name": "bob", "source": "radius"},
+		}
+
+	dev.profile == "ip-profile"
+}
+
+# Test 18: Device ID is still the physical device when a user is logged in
+test_device_id_with_user if {
+	did := device.device_id with data.kproxy.config as mock_user_config
+		with input as {
+			"client_ip": "192.168.1.100",
+			"client_mac": "",
+			"user": {"name": "alice", "source": "radius"},
+		}
+
+	did == "ip-device"
+}
+
+# Test 19: Identify device by exact IPv6 address (any textual form)
+test_identify_by_exact_ipv6 if {
+	dev := device.identified_device with data.kproxy.config as mock_config
+		with input as {
+			"client_ip": "2001:db8:0::10",
+			"client_mac": "",
+		}
+
+	dev.name == "IPv6 Device"
+}
+
+# Test 20: Identify device by IPv6 prefix (privacy addresses rotate within the /64)
+test_identify_by_ipv6_cidr if {
+	dev := device.identified_device with data.kproxy.config as mock_config
+		with input as {
+			"client_ip": "2001:db8:1::a1b2:c3d4:e5f6:1",
+			"client_mac": "",
+		}
+
+	dev.name == "IPv6 Subnet Device"
+}
+
+# Test 21: IPv6 address with six groups is not treated as a MAC
+test_ipv6_not_mac if {
+	not device.is_mac_address("2001:db8:0:0:1:10")
+	device.is_ip_address("2001:db8:0:0:1:10")
+}
+
+# Test 22: Devices added at runtime are identified like configured ones
+test_identify_runtime_device if {
+	dev := device.identified_device with data.kproxy.config as mock_config
+		with input as {
+			"client_ip": "192.168.5.37",
+			"client_mac": "",
+			"runtime_devices": {"sams-ipad": {
+				"name": "Sam's iPad",
+				"identifiers": ["192.168.5.37"],
+				"profile": "kids",
+			}},
+		}
+
+	dev.name == "Sam's iPad"
+	dev.profile == "kids"
+}
+
+# Test 23: Profiles assigned at runtime replace the configured profile
+test_runtime_profile_assignment if {
+	dev := device.identified_device with data.kproxy.config as mock_config
+		with input as {
+			"client_ip": "192.168.1.100",
+			"client_mac": "",
+			"device_profiles": {"ip-device": "teen"},
+		}
+
+	dev.name == "IP Device"
+	dev.profile == "teen"
+
+	id := device.device_id with data.kproxy.config as mock_config
+		with input as {
+			"client_ip": "192.168.1.100",
+			"client_mac": "",
+			"device_profiles": {"ip-device": "teen"},
+		}
+	id == "ip-device"
+}
+
+# Test 24: Device lookup for the admin API
+test_lookup if {
+	result := device.lookup with data.kproxy.config as object.union(mock_config, {"profiles": {"kids": {}, "teen": {}}})
+		with input as {
+			"client_ip": "10.0.0.9",
+			"client_mac": "",
+		}
+
+	result.device_id == "cidr-device"
+	result.device.name == "CIDR Device"
+	result.devices["ip-device"].profile == "ip-profile"
+	{p | some p in result.profiles} == {"kids", "teen"}
+
+	# Unknown clients
+	unknown := device.lookup with data.kproxy.config as object.union(mock_config, {"profiles": {}})
+		with input as {
+			"client_ip": "203.0.113.1",
+			"client_mac": "",
+		}
+	unknown.device_id == ""
+	unknown.device == null
+}
+
+# Subnet default profiles for clients that match no device
+mock_subnet_config := object.union(mock_config, {"subnet_profiles": {
+	"10.20.0.0/16": "guest",
+	"10.20.30.0/24": "child",
+	"10.0.0.0/24": "ignored", # Covered by cidr-device
+}})
+
+# Test: Unknown client on a subnet gets the subnet's profile
+test_subnet_default_profile if {
+	dev := device.identified_device with data.kproxy.config as mock_subnet_config
+		with input as {
+			"client_ip": "10.20.1.5",
+			"client_mac": "de:ad:be:ef:00:01",
+		}
+
+	dev.profile == "guest"
+	dev.subnet == "10.20.0.0/16"
+}
+
+# Test: The most specific subnet wins
+test_subnet_most_specific if {
+	dev := device.identified_device with data.kproxy.config as mock_subnet_config
+		with input as {
+			"client_ip": "10.20.30.40",
+			"client_mac": "",
+		}
+
+	dev.profile == "child"
+	dev.subnet == "10.20.30.0/24"
+}
+
+# Test: Configured devices take precedence over subnet defaults
+test_subnet_device_precedence if {
+	dev := device.identified_device with data.kproxy.config as mock_subnet_config
+		with input as {
+			"client_ip": "10.0.0.9",
+			"client_mac": "",
+		}
+
+	dev.name == "CIDR Device"
+	dev.profile == "cidr-profile"
+}
+
+# Test: Clients outside every subnet stay unknown
+test_subnet_no_match if {
+	not device.identified_device with data.kproxy.config as mock_subnet_config
+		with input as {
+			"client_ip": "192.168.50.1",
+			"client_mac": "",
+		}
+}
+
+# Device type default profiles for clients that match no device
+mock_device_type_config := object.union(mock_subnet_config, {"device_type_profiles": {"tv": "tv-bedtime"}})
+
+# Test: Unknown client gets its device type's profile, ahead of its subnet
+test_device_type_default_profile if {
+	dev := device.identified_device with data.kproxy.config as mock_device_type_config
+		with input as {
+			"client_ip": "10.20.1.5",
+			"client_mac": "de:ad:be:ef:00:01",
+			"device_type": "tv",
+		}
+
+	dev.profile == "tv-bedtime"
+	dev.device_type == "tv"
+	dev.name == "Unknown tv"
+}
+
+# Test: Device types without a profile fall through to the subnet
+test_device_type_no_profile if {
+	dev := device.identified_device with data.kproxy.config as mock_device_type_config
+		with input as {
+			"client_ip": "10.20.1.5",
+			"client_mac": "",
+			"device_type": "phone",
+		}
+
+	dev.profile == "guest"
+}
+
+# Test: Configured devices take precedence over device type defaults
+test_device_type_device_precedence if {
+	dev := device.identified_device with data.kproxy.config as mock_device_type_config
+		with input as {
+			"client_ip": "10.0.0.9",
+			"client_mac": "",
+			"device_type": "tv",
+		}
+
+	dev.name == "CIDR Device"
+}
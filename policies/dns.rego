@@ -13,13 +13,21 @@ import data.kproxy.helpers
 # {
 #   "client_ip": "192.168.1.100",
 #   "client_mac": "aa:bb:cc:dd:ee:ff",  // optional
-#   "domain": "youtube.com"
+#   "domain": "youtube.com",
+#   "runtime_rules": [...]  // optional, rules added at runtime (see helpers.rego)
+#   "device_rules": [...]   // optional, rules added at runtime for one device
+#   "paused": {...}         // optional, paused devices and profiles (see device.pause)
 # }
 #
 # Output structure:
 # {
 #   "action": "BYPASS" | "INTERCEPT" | "BLOCK",
-#   "reason": "description of why this decision was made"
+#   "reason": "description of why this decision was made",
+#   "dnssec_strict": true | false, // bypassed answers must be DNSSEC-validated
+#   "safesearch": true | false,    // search engines resolve to their SafeSearch addresses
+#   "block_response": "zero-ip" | "nxdomain" | "refused" | "custom-ip",
+#   "block_ip": "192.168.1.5",     // custom-ip sinkholes ("" if unset)
+#   "block_ipv6": ""
 # }
 #
 # Configuration comes from data.kproxy.config
@@ -30,11 +38,27 @@ global_bypass if {
 	helpers.match_domain(input.domain, pattern)
 }
 
+# Helper: Rules that decide this query. Rules added at runtime that match the
+# domain (for the device, then for its profile) override the profile's
+# configured rules.
+domain_rules := matching if {
+	matching := rules_for_domain(device.rules)
+	count(matching) > 0
+} else := matching if {
+	matching := rules_for_domain(helpers.runtime_rules(device.identified_device.profile))
+	count(matching) > 0
+} else := helpers.configured_rules(device.identified_device.profile, device_profile)
+
+# Helper: The rules matching the queried domain
+rules_for_domain(rules) := [rule |
+	some rule in rules
+	some domain_pattern in rule.domains
+	helpers.match_domain(input.domain, domain_pattern)
+]
+
 # Helper: Check if profile has a rule with specific action
 profile_has_rule_with_action(action_to_check) if {
-	dev := device.identified_device
-	profile := config.profiles[dev.profile]
-	some rule in profile.rules
+	some rule in domain_rules
 	rule.action == action_to_check
 	some domain_pattern in rule.domains
 	helpers.match_domain(input.domain, domain_pattern)
@@ -42,9 +66,7 @@ profile_has_rule_with_action(action_to_check) if {
 
 # Helper: Check if profile has ANY rule that matches (regardless of action)
 profile_has_matching_rule if {
-	dev := device.identified_device
-	profile := config.profiles[dev.profile]
-	some rule in profile.rules
+	some rule in domain_rules
 	some domain_pattern in rule.domains
 	helpers.match_domain(input.domain, domain_pattern)
 }
@@ -57,7 +79,7 @@ profile_default_bypass if {
 }
 
 # Priority 0: Always intercept server name for client setup
-decision := {
+base_decision := {
 	"action": "INTERCEPT",
 	"reason": "kproxy server name (client setup)",
 } if {
@@ -65,7 +87,7 @@ decision := {
 }
 
 # Priority 1: Global bypass domains (system-critical services)
-decision := {
+base_decision := {
 	"action": "BYPASS",
 	"reason": "global bypass domain",
 } if {
@@ -74,7 +96,7 @@ decision := {
 }
 
 # Priority 2: Profile rule with "bypass" action
-decision := {
+base_decision := {
 	"action": "BYPASS",
 	"reason": "profile rule action is bypass",
 } if {
@@ -84,7 +106,7 @@ decision := {
 }
 
 # Priority 3: Profile has a matching rule (block/allow) → INTERCEPT for proxy evaluation
-decision := {
+base_decision := {
 	"action": "INTERCEPT",
 	"reason": "profile has matching rule requiring proxy evaluation",
 } if {
@@ -95,7 +117,7 @@ decision := {
 }
 
 # Priority 4: Profile default bypass (only if no rules matched)
-decision := {
+base_decision := {
 	"action": "BYPASS",
 	"reason": "profile default action is bypass",
 } if {
@@ -107,11 +129,112 @@ decision := {
 }
 
 # Default action: Intercept through proxy for policy evaluation
-default decision := {
+default base_decision := {
 	"action": "INTERCEPT",
 	"reason": "default intercept for policy evaluation",
 }
 
+# Paused devices, and devices with a paused profile, are blocked ahead of
+# everything but the server name, which serves the block page
+action_decision := {
+	"action": "BLOCK",
+	"reason": sprintf("%s %s paused", [device.pause.kind, device.pause.target]),
+} if {
+	device.pause
+	not helpers.match_domain(input.domain, input.server_name)
+} else := base_decision
+
+# Final decision: the action above plus the profile's DNSSEC, SafeSearch and
+# block response settings
+decision := object.union(action_decision, {
+	"dnssec_strict": dnssec_strict,
+	"safesearch": safesearch,
+	"block_response": block_response,
+	"block_ip": block_ip,
+	"block_ipv6": block_ipv6,
+})
+
+# Helper: Profile requires validated answers. When dns.dnssec is enabled,
+# bypassed answers the upstream resolver didn't authenticate are refused.
+default dnssec_strict := false
+
+dnssec_strict if object.get(device_profile, "dnssec_strict", false) == true
+
+# Helper: Profile enforces SafeSearch. Bypassed queries for Google, Bing,
+# DuckDuckGo and YouTube are answered with a CNAME to the engine's
+# SafeSearch/restricted host; intercepted requests get it enforced by the proxy.
+default safesearch := false
+
+safesearch if object.get(device_profile, "safesearch", false) == true
+
+# Helper: How queries the device's profile blocks are answered: "zero-ip"
+# (0.0.0.0 and ::), "nxdomain", "refused" or "custom-ip" with the profile's
+# dns_block_ip/dns_block_ipv6 addresses. Go falls back to zero-ip for
+# anything it can't use.
+default block_response := "zero-ip"
+
+block_response := lower(object.get(device_profile, "dns_block_response", "zero-ip"))
+
+default block_ip := ""
+
+block_ip := object.get(device_profile, "dns_block_ip", "")
+
+default block_ipv6 := ""
+
+block_ipv6 := object.get(device_profile, "dns_block_ipv6", "")
+
+# CNAME Cloaking Decision
+# Trackers can hide behind a first-party name that is a CNAME for a
+# third-party domain. For bypassed queries, Go follows the CNAME chain in the
+# upstream answer and asks whether any target in it matches a block rule.
+#
+# Input structure: the DNS input above, plus
+# {
+#   "cname_chain": ["metrics.example.com.cdn.net", "tracker.example.net"]
+# }
+#
+# Output: BLOCK (or WARN for rules and profiles in warn mode) for the first
+# target whose first matching rule is a block rule, otherwise BYPASS.
+cname_decision := {
+	"action": cname_action(match.rule),
+	"reason": sprintf("CNAME target %s matches block rule %s", [match.target, match.rule.id]),
+} if {
+	match := cname_block_matches[0]
+}
+
+default cname_decision := {
+	"action": "BYPASS",
+	"reason": "no CNAME target matches a block rule",
+}
+
+# Helper: CNAME targets matching a block rule, in chain order
+cname_block_matches := [{"target": target, "rule": rule} |
+	some target in input.cname_chain
+	rule := first_domain_rule(device.profile_rules(device.identified_device.profile, device_profile), target)
+	rule.action == "block"
+
+	# Path-specific rules can't be applied to a whole domain
+	object.get(rule, "paths", null) == null
+]
+
+# Helper: Profile of the identified device
+device_profile := config.profiles[device.identified_device.profile]
+
+# Helper: First rule whose domains match (rules are evaluated in order)
+first_domain_rule(rules, domain) := matching[0] if {
+	matching := [rule |
+		some rule in rules
+		some pattern in rule.domains
+		helpers.match_domain(domain, pattern)
+	]
+	count(matching) > 0
+}
+
+# Helper: A rule's mode overrides its profile's (see proxy.rego)
+cname_action(rule) := "WARN" if {
+	object.get(rule, "mode", object.get(device_profile, "mode", "enforce")) == "warn"
+} else := "BLOCK"
+
 # Future: Could add explicit BLOCK rules here for DNS-level blocking
 # action := "BLOCK" if {
 #   some pattern in config.dns_block_domains
@@ -377,3 +377,386 @@ test_action_intercept_server_name if {
 	result3.action == "INTERCEPT"
 	result3.reason == "default intercept for policy evaluation"
 }
+
+# Test 19: CNAME chain through a blocked domain is blocked
+test_cname_decision if {
+	cname_config := {
+		"devices": {"laptop": {
+			"name": "Laptop",
+			"identifiers": ["192.168.1.100"],
+			"profile": "child",
+		}},
+		"profiles": {"child": {
+			"name": "Child",
+			"time_restrictions": {},
+			"rules": [
+				{
+					"id": "allow-cdn",
+					"domains": ["*.cdn.net"],
+					"action": "allow",
+					"category": "",
+				},
+				{
+					"id": "block-trackers",
+					"domains": ["*.tracker.net"],
+					"action": "block",
+					"category": "tracking",
+				},
+				{
+					"id": "block-shorts",
+					"domains": ["*.video.net"],
+					"paths": ["/shorts/*"],
+					"action": "block",
+					"category": "entertainment",
+				},
+				{
+					"id": "trial-ads",
+					"domains": ["*.ads.net"],
+					"action": "block",
+					"category": "ads",
+					"mode": "warn",
+				},
+			],
+			"usage_limits": {},
+			"default_action": "block",
+		}},
+	}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"client_mac": "",
+		"domain": "metrics.example.com",
+	}
+
+	# A later hop matching a block rule blocks the query
+	result1 := dns.cname_decision with data.kproxy.config as cname_config
+		with input as object.union(base_input, {"cname_chain": ["edge.cdn.net", "x.tracker.net"]})
+	result1.action == "BLOCK"
+	result1.reason == "CNAME target x.tracker.net matches block rule block-trackers"
+
+	# Allowed hops, path-specific block rules and default actions don't block
+	result2 := dns.cname_decision with data.kproxy.config as cname_config
+		with input as object.union(base_input, {"cname_chain": ["edge.cdn.net", "m.video.net", "other.org"]})
+	result2.action == "BYPASS"
+
+	# Block rules in warn mode only warn
+	result3 := dns.cname_decision with data.kproxy.config as cname_config
+		with input as object.union(base_input, {"cname_chain": ["pixel.ads.net"]})
+	result3.action == "WARN"
+
+	# Unknown devices have no rules to match
+	result4 := dns.cname_decision with data.kproxy.config as cname_config
+		with input as object.union(base_input, {"client_ip": "192.168.1.200", "cname_chain": ["x.tracker.net"]})
+	result4.action == "BYPASS"
+}
+
+# Test 20: Profiles can require DNSSEC-validated answers
+test_dnssec_strict if {
+	strict_config := {
+		"bypass_domains": [],
+		"devices": {
+			"laptop": {
+				"name": "Laptop",
+				"identifiers": ["192.168.1.100"],
+				"profile": "strict",
+			},
+			"tablet": {
+				"name": "Tablet",
+				"identifiers": ["192.168.1.101"],
+				"profile": "relaxed",
+			},
+		},
+		"profiles": {
+			"strict": {
+				"name": "Strict",
+				"time_restrictions": {},
+				"rules": [],
+				"usage_limits": {},
+				"default_action": "bypass",
+				"dnssec_strict": true,
+			},
+			"relaxed": {
+				"name": "Relaxed",
+				"time_restrictions": {},
+				"rules": [],
+				"usage_limits": {},
+				"default_action": "bypass",
+			},
+		},
+	}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"client_mac": "",
+		"domain": "example.com",
+	}
+
+	result1 := dns.decision with data.kproxy.config as strict_config
+		with input as base_input
+	result1.action == "BYPASS"
+	result1.dnssec_strict == true
+
+	# Profiles without the toggle, and unknown devices, don't require it
+	result2 := dns.decision with data.kproxy.config as strict_config
+		with input as object.union(base_input, {"client_ip": "192.168.1.101"})
+	result2.dnssec_strict == false
+
+	result3 := dns.decision with data.kproxy.config as strict_config
+		with input as object.union(base_input, {"client_ip": "192.168.1.200"})
+	result3.dnssec_strict == false
+}
+
+# Test 21: Profiles can enforce SafeSearch
+test_safesearch if {
+	safe_config := {
+		"bypass_domains": [],
+		"devices": {"tablet": {
+			"name": "Tablet",
+			"identifiers": ["192.168.1.101"],
+			"profile": "kids",
+		}},
+		"profiles": {"kids": {
+			"name": "Kids",
+			"time_restrictions": {},
+			"rules": [],
+			"usage_limits": {},
+			"default_action": "bypass",
+			"safesearch": true,
+		}},
+	}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.101",
+		"client_mac": "",
+		"domain": "www.google.com",
+	}
+
+	result1 := dns.decision with data.kproxy.config as safe_config
+		with input as base_input
+	result1.action == "BYPASS"
+	result1.safesearch == true
+
+	# Unknown devices have no profile to enforce it
+	result2 := dns.decision with data.kproxy.config as safe_config
+		with input as object.union(base_input, {"client_ip": "192.168.1.200"})
+	result2.safesearch == false
+}
+
+# Test 22: Runtime rules override the profile's rules for their domains
+test_runtime_rules if {
+	rules_config := {
+		"bypass_domains": [],
+		"devices": {"tablet": {
+			"name": "Tablet",
+			"identifiers": ["192.168.1.101"],
+			"profile": "kids",
+		}},
+		"profiles": {"kids": {
+			"name": "Kids",
+			"time_restrictions": {},
+			"rules": [{"id": "bypass-roblox", "domains": [".roblox.com"], "action": "bypass", "category": "gaming"}],
+			"usage_limits": {},
+			"default_action": "bypass",
+		}},
+	}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.101",
+		"client_mac": "",
+		"domain": "www.roblox.com",
+	}
+	block_roblox := {"id": "runtime-1", "profile": "kids", "domains": [".roblox.com"], "action": "block", "category": ""}
+
+	# Configured bypass rule
+	result1 := dns.decision with data.kproxy.config as rules_config
+		with input as base_input
+	result1.action == "BYPASS"
+
+	# A runtime block rule sends it to the proxy to be blocked
+	result2 := dns.decision with data.kproxy.config as rules_config
+		with input as object.union(base_input, {"runtime_rules": [block_roblox]})
+	result2.action == "INTERCEPT"
+
+	# Rules for other profiles don't apply
+	result3 := dns.decision with data.kproxy.config as rules_config
+		with input as object.union(base_input, {"runtime_rules": [object.union(block_roblox, {"profile": "adults"})]})
+	result3.action == "BYPASS"
+
+	# Runtime block rules also catch CNAME targets
+	result4 := dns.cname_decision with data.kproxy.config as rules_config
+		with input as object.union(base_input, {
+			"domain": "games.example.com",
+			"cname_chain": ["cdn.roblox.com"],
+			"runtime_rules": [block_roblox],
+		})
+	result4.action == "BLOCK"
+}
+
+# Test 23: Profiles choose how blocked queries are answered
+test_block_response if {
+	block_config := {
+		"bypass_domains": [],
+		"devices": {
+			"tablet": {
+				"name": "Tablet",
+				"identifiers": ["192.168.1.101"],
+				"profile": "kids",
+			},
+			"laptop": {
+				"name": "Laptop",
+				"identifiers": ["192.168.1.102"],
+				"profile": "teen",
+			},
+		},
+		"profiles": {
+			"kids": {
+				"name": "Kids",
+				"time_restrictions": {},
+				"rules": [],
+				"usage_limits": {},
+				"default_action": "block",
+				"dns_block_response": "NXDOMAIN",
+			},
+			"teen": {
+				"name": "Teen",
+				"time_restrictions": {},
+				"rules": [],
+				"usage_limits": {},
+				"default_action": "block",
+				"dns_block_response": "custom-ip",
+				"dns_block_ip": "192.168.1.5",
+			},
+		},
+	}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.101",
+		"client_mac": "",
+		"domain": "ads.example.com",
+	}
+
+	result1 := dns.decision with data.kproxy.config as block_config
+		with input as base_input
+	result1.block_response == "nxdomain"
+	result1.block_ip == ""
+
+	result2 := dns.decision with data.kproxy.config as block_config
+		with input as object.union(base_input, {"client_ip": "192.168.1.102"})
+	result2.block_response == "custom-ip"
+	result2.block_ip == "192.168.1.5"
+	result2.block_ipv6 == ""
+
+	# Unknown devices get the sinkhole
+	result3 := dns.decision with data.kproxy.config as block_config
+		with input as object.union(base_input, {"client_ip": "192.168.1.200"})
+	result3.block_response == "zero-ip"
+}
+
+# Test 24: Device rules override the device's profile and its runtime rules
+test_device_rules if {
+	rules_config := {
+		"bypass_domains": [],
+		"devices": {
+			"tablet": {
+				"name": "Tablet",
+				"identifiers": ["192.168.1.101"],
+				"profile": "kids",
+			},
+			"laptop": {
+				"name": "Laptop",
+				"identifiers": ["192.168.1.102"],
+				"profile": "kids",
+			},
+		},
+		"profiles": {"kids": {
+			"name": "Kids",
+			"time_restrictions": {},
+			"rules": [{"id": "bypass-roblox", "domains": [".roblox.com"], "action": "bypass", "category": "gaming"}],
+			"usage_limits": {},
+			"default_action": "bypass",
+		}},
+	}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.101",
+		"client_mac": "",
+		"domain": "www.roblox.com",
+		"device_rules": [{"id": "device-rule-1", "device": "tablet", "domains": [".roblox.com"], "action": "block", "category": ""}],
+		"runtime_rules": [{"id": "runtime-1", "profile": "kids", "domains": [".roblox.com"], "action": "bypass", "category": ""}],
+	}
+
+	# The tablet's block rule sends it to the proxy despite the bypass rules
+	result1 := dns.decision with data.kproxy.config as rules_config
+		with input as base_input
+	result1.action == "INTERCEPT"
+
+	# Other devices with the same profile still bypass
+	result2 := dns.decision with data.kproxy.config as rules_config
+		with input as object.union(base_input, {"client_ip": "192.168.1.102"})
+	result2.action == "BYPASS"
+}
+
+# Test 25: Paused devices and profiles are blocked, except for the server name
+test_paused if {
+	pause_config := {
+		"bypass_domains": ["apple.com"],
+		"devices": {
+			"tablet": {
+				"name": "Tablet",
+				"identifiers": ["192.168.1.101"],
+				"profile": "kids",
+			},
+			"tv": {
+				"name": "TV",
+				"identifiers": ["192.168.1.103"],
+				"profile": "family",
+			},
+		},
+		"profiles": {
+			"kids": {
+				"name": "Kids",
+				"time_restrictions": {},
+				"rules": [],
+				"usage_limits": {},
+				"default_action": "bypass",
+			},
+			"family": {
+				"name": "Family",
+				"time_restrictions": {},
+				"rules": [],
+				"usage_limits": {},
+				"default_action": "bypass",
+			},
+		},
+	}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.101",
+		"client_mac": "",
+		"domain": "www.apple.com",
+	}
+	device_paused := {"paused": {"devices": {"tablet": {"until": ""}}, "profiles": {}}}
+	profile_paused := {"paused": {"devices": {}, "profiles": {"family": {"until": ""}}}}
+
+	# Even global bypass domains are blocked for the paused device
+	result1 := dns.decision with data.kproxy.config as pause_config
+		with input as object.union(base_input, device_paused)
+	result1.action == "BLOCK"
+	result1.reason == "device tablet paused"
+
+	# The server name still resolves to the proxy
+	result2 := dns.decision with data.kproxy.config as pause_config
+		with input as object.union(base_input, object.union(device_paused, {"domain": "local.kproxy"}))
+	result2.action == "INTERCEPT"
+
+	# A paused profile blocks its devices...
+	result3 := dns.decision with data.kproxy.config as pause_config
+		with input as object.union(base_input, object.union(profile_paused, {"client_ip": "192.168.1.103"}))
+	result3.action == "BLOCK"
+	result3.reason == "profile family paused"
+
+	# ...and no others
+	result4 := dns.decision with data.kproxy.config as pause_config
+		with input as object.union(base_input, profile_paused)
+	result4.action == "BYPASS"
+}
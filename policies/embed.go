@@ -0,0 +1,31 @@
+// Package policies embeds the Rego policies shipped with KProxy, so
+// "kproxy policy init" and "kproxy setup" can write a starter policy
+// directory.
+package policies
+
+import (
+	"embed"
+	"strings"
+)
+
+// FS holds the policy files and their tests
+//
+//go:embed *.rego
+var FS embed.FS
+
+// Files lists the embedded policy files, with or without their tests
+func Files(tests bool) ([]string, error) {
+	entries, err := FS.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !tests && strings.HasSuffix(entry.Name(), "_test.rego") {
+			continue
+		}
+		files = append(files, entry.Name())
+	}
+	return files, nil
+}
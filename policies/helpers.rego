@@ -2,6 +2,8 @@ package kproxy.helpers
 
 import rego.v1
 
+import data.kproxy.config
+
 # Domain matching with exact, wildcard, and suffix support
 
 # Exact match
@@ -108,9 +110,8 @@ parse_time_to_minutes(time_str) := 0 if {
 
 # MAC address validation
 is_mac_address(identifier) if {
-	# Simple MAC validation: contains colons and is alphanumeric with colons
-	contains(identifier, ":")
-	count(split(identifier, ":")) == 6
+	# Six colon-separated hex pairs (IPv6 addresses can also have six parts)
+	regex.match(`^[0-9A-Fa-f]{2}(:[0-9A-Fa-f]{2}){5}$`, identifier)
 }
 
 # CIDR range checking
@@ -155,3 +156,82 @@ match_path(path, rule_paths) if {
 	pattern := concat("", ["^", replace_path_wildcard(rule_path), "$"])
 	regex.match(pattern, path)
 }
+
+# Query parameter matching: every parameter named in conditions must have a
+# value matching one of its glob patterns (a string or a list)
+match_query(query, conditions) if {
+	every name, patterns in conditions {
+		some value in object.get(query, name, [])
+		some pattern in glob_patterns(patterns)
+		glob.match(pattern, [], value)
+	}
+}
+
+# Header matching: like match_query, with case-insensitive header names and
+# one value per header
+match_headers(headers, conditions) if {
+	every name, patterns in conditions {
+		value := headers[lower(name)]
+		some pattern in glob_patterns(patterns)
+		glob.match(pattern, [], value)
+	}
+}
+
+# Helper: Glob patterns given as a string or a list
+glob_patterns(patterns) := patterns if {
+	is_array(patterns)
+} else := [patterns]
+
+# Rules added at runtime for a profile (kproxy rule add), newest first.
+# Go passes them as input.runtime_rules with the same shape as config rules
+# plus the profile they belong to.
+runtime_rules(profile_id) := [rule |
+	some rule in object.get(input, "runtime_rules", [])
+	rule.profile == profile_id
+]
+
+# A profile's rules: runtime rules come first, so they override configured ones
+profile_rules(profile_id, profile) := array.concat(runtime_rules(profile_id), configured_rules(profile_id, profile))
+
+# A profile's configured rules: its own rules, then the rules of its rule sets
+configured_rules(profile_id, profile) := array.concat(profile.rules, rule_set_rules(profile_id, profile))
+
+# Rule sets are named collections of rules shared by several profiles. They
+# come from config.rego (rule_sets) and from rule sets saved through the
+# admin API (kproxy ruleset), which Go passes as input.rule_sets and which
+# replace configured ones with the same ID.
+all_rule_sets := object.union(configured_rule_sets, saved_rule_sets)
+
+default configured_rule_sets := {}
+
+configured_rule_sets := config.rule_sets
+
+default saved_rule_sets := {}
+
+saved_rule_sets := input.rule_sets
+
+# The rule sets of a profile: those it lists in "rule_sets", in order, then
+# those listing the profile in their "profiles", by ID
+profile_rule_sets(profile_id, profile) := array.concat(listed, attached) if {
+	listed := object.get(profile, "rule_sets", [])
+	attached := sort([set_id |
+		some set_id, rule_set in all_rule_sets
+		profile_id in object.get(rule_set, "profiles", [])
+		not set_id in listed
+	])
+}
+
+# The rules of a profile's rule sets. Their IDs are prefixed with the rule
+# set's ID ("social-media/block-tiktok") and they carry it as "rule_set".
+rule_set_rules(profile_id, profile) := [object.union(rule, {"id": sprintf("%s/%s", [set_id, rule.id]), "rule_set": set_id}) |
+	some set_id in profile_rule_sets(profile_id, profile)
+	some rule in all_rule_sets[set_id].rules
+]
+
+# Rules added at runtime for one device (kproxy device rule add), newest
+# first. Go passes them as input.device_rules with the same shape as config
+# rules plus the ID of the device they belong to.
+device_rules(device_id) := [rule |
+	some rule in object.get(input, "device_rules", [])
+	rule.device == device_id
+]
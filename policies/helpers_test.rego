@@ -103,6 +103,10 @@ test_is_mac_address_invalid if {
 	not helpers.is_mac_address("192.168.1.1")
 }
 
+test_is_mac_address_ipv6 if {
+	not helpers.is_mac_address("fe80::1:2:3:4")
+}
+
 test_is_cidr_valid if {
 	helpers.is_cidr("192.168.1.0/24")
 }
@@ -424,3 +428,52 @@ test_match_path_ant_single_at_root_no_match_deep if {
 	# /path/*.xml does NOT match /path/subdir/file.xml
 	not helpers.match_path("/path/subdir/file.xml", ["/path/*.xml"])
 }
+
+# Test rule sets - a profile's own rules come first, then its rule sets
+rule_set_config := {"rule_sets": {
+	"social-media": {
+		"name": "Social Media",
+		"rules": [{"id": "block-tiktok", "domains": [".tiktok.com"], "action": "block", "category": "social"}],
+	},
+	"gaming": {
+		"name": "Gaming",
+		"profiles": ["kids"],
+		"rules": [{"id": "allow-roblox", "domains": [".roblox.com"], "action": "allow", "category": "gaming"}],
+	},
+}}
+
+rule_set_profile := {
+	"rules": [{"id": "allow-school", "domains": [".khanacademy.org"], "action": "allow", "category": "educational"}],
+	"rule_sets": ["social-media"],
+}
+
+test_configured_rules_with_rule_sets if {
+	rules := helpers.configured_rules("kids", rule_set_profile) with data.kproxy.config as rule_set_config
+	[rule.id | some rule in rules] == ["allow-school", "social-media/block-tiktok", "gaming/allow-roblox"]
+	rules[1].rule_set == "social-media"
+	rules[1].category == "social"
+}
+
+test_configured_rules_other_profile if {
+	# Rule sets attached by "profiles" apply only to the profiles they list
+	rules := helpers.configured_rules("teens", rule_set_profile) with data.kproxy.config as rule_set_config
+	[rule.id | some rule in rules] == ["allow-school", "social-media/block-tiktok"]
+}
+
+test_configured_rules_saved_rule_sets if {
+	# Rule sets saved through the admin API replace configured ones
+	saved := {"social-media": {
+		"name": "Social Media",
+		"profiles": [],
+		"rules": [{"id": "block-instagram", "domains": [".instagram.com"], "action": "block", "category": "social"}],
+	}}
+	rules := helpers.configured_rules("teens", rule_set_profile) with data.kproxy.config as rule_set_config
+		with input as {"rule_sets": saved}
+	[rule.id | some rule in rules] == ["allow-school", "social-media/block-instagram"]
+}
+
+test_configured_rules_without_rule_sets if {
+	# Profiles naming rule sets that don't exist keep their own rules
+	rules := helpers.configured_rules("kids", rule_set_profile) with data.kproxy.config as {}
+	[rule.id | some rule in rules] == ["allow-school"]
+}
@@ -22,13 +22,310 @@ import data.kproxy.helpers
 #   },
 #   "usage": {  // Current usage from database
 #     "entertainment": {"today_minutes": 45}
+#   },
+#   "direct_ip": true,                 // only for requests made to an IP address
+#   "server_names": ["api.example.com"],  // names found for that address
+#   "query": {"search_query": ["minecraft"]},  // query parameters
+#   "headers": {"referer": "https://www.youtube.com/"},  // policy.request_headers
+#   "streams": {  // Media streams per profile and device (see stream limits)
+#     "kids": {"living-room-tv": {"active": 1, "idle_seconds": 0}}
 #   }
 # }
 #
+# Profiles with "safesearch": true get "safesearch": true on their decisions,
+# and Go enforces SafeSearch on search engine requests it proxies. Profiles
+# with "restricted_youtube" ("moderate" or "strict") get "youtube_restrict"
+# set to that level, and Go sends it to YouTube in the YouTube-Restrict header.
+#
+# Profiles and rules may name an "egress" route (defined in KProxy's
+# configuration under egress.routes); allowed requests carry it as "egress"
+# and Go sends them upstream by that route, e.g. through a VPN. A rule with
+# "action": "route" allows matching requests like "allow" and sends them by
+# the route named in its "upstream", while everything else goes by default.
+#
 # Configuration comes from data.kproxy.config
+#
+# Rules and profiles may set "mode": "warn" to trial a restriction: requests
+# that would be blocked are allowed with action WARN, so they show up in logs
+# and metrics without being enforced. A rule's mode overrides its profile's.
+#
+# Search results requests carry "search": {"engine": "google", "query": "..."}
+# when search monitoring is enabled in KProxy's configuration.
+#
+# Rules added at runtime (input.runtime_rules, see helpers.profile_rules) are
+# matched before the profile's configured rules, and rules added at runtime
+# for the device (input.device_rules, see device.profile_rules) before those.
+#
+# Rules may also match query parameters and request headers: "query" and
+# "headers" map names to glob patterns (a string or a list), and every named
+# parameter or header must have a value matching one of them. Header names
+# are case-insensitive; only the headers listed in KProxy's
+# policy.request_headers (Referer and Origin by default) are passed.
+#   {"id": "block-yt-search", "domains": ["youtube.com"], "paths": ["/results"],
+#    "query": {"search_query": "*"}, "action": "block"}
+#
+# Paused devices, and devices with a paused profile (input.paused, see
+# device.pause), are blocked with block_page "paused" ahead of everything but
+# the server name. Warn mode doesn't soften a pause.
+#
+# Requests made straight to an IP address are matched against rules by the
+# first server name Go found for the address (a name learned from earlier
+# requests or the server's certificate, the SNI if that certificate covers
+# it, or reverse DNS). Those with no name use the profile's
+# "direct_ip_action", which defaults to its default_action.
+
+# Final decision: the moded decision under the profile's stream limit (or
+# the pause of a paused device or profile), with search monitoring for
+# searches, the profile's custom block page, its bandwidth limits and the
+# notice to show
+decision := object.union_n([limited_decision, search_monitoring, block_template, bandwidth_limits, request_notice])
+
+# Notices: profiles may warn devices before their time runs out
+#   "warn_minutes": [15, 5]
+# A notice is due once bedtime (the end of the current time_restrictions
+# window) or a usage limit is within the largest of these many minutes;
+# "threshold" is the smallest one it is within, so Go can show each notice
+# once per threshold. Allowed requests get the most urgent notice for
+# bedtime or their category's limit as "notice", and Go shows it as a
+# banner on pages. "status" lists every notice due for the device, for the
+# DNS status record.
+request_notice := {"notice": relevant[0]} if {
+	limited_decision.action in {"ALLOW", "WARN"}
+	relevant := [notice | some notice in notices; notice_applies(notice, limited_decision.category)]
+	count(relevant) > 0
+} else := {}
+
+# Helper: Whether a notice concerns a request in a category
+notice_applies(notice, _) if notice.kind == "bedtime"
+
+notice_applies(notice, category) if {
+	notice.kind == "usage_limit"
+	notice.category == category
+}
+
+# Notices due for the device, most urgent first
+notices := [notice |
+	some entry in sort([[n.minutes_remaining, n.kind, n.category, n.threshold] | some n in due_notices])
+	notice := {"kind": entry[1], "category": entry[2], "minutes_remaining": entry[0], "threshold": entry[3]}
+]
+
+# The device's profile and the notices due for it
+status := {"profile": device.identified_device.profile, "notices": notices} if {
+	device.identified_device
+} else := {"profile": "", "notices": []}
+
+due_notices contains {"kind": "bedtime", "category": "", "minutes_remaining": minutes, "threshold": threshold} if {
+	profile := warning_profile
+	count(profile.time_restrictions) > 0
+	minutes := bedtime_minutes(profile.time_restrictions, input.time)
+	threshold := warning_threshold(profile, minutes)
+}
+
+due_notices contains {"kind": "usage_limit", "category": category, "minutes_remaining": minutes, "threshold": threshold} if {
+	profile := warning_profile
+	some category, limit in profile.usage_limits
+	minutes := limit.daily_minutes - object.get(input.usage, [category, "today_minutes"], 0)
+	minutes > 0
+	threshold := warning_threshold(profile, minutes)
+}
+
+# Helper: The device's profile, if it asks for notices
+warning_profile := profile if {
+	profile := config.profiles[device.identified_device.profile]
+	count(object.get(profile, "warn_minutes", [])) > 0
+}
+
+# Helper: Smallest warning threshold the remaining minutes are within
+warning_threshold(profile, minutes) := min(thresholds) if {
+	thresholds := {threshold | some threshold in profile.warn_minutes; minutes <= threshold}
+	count(thresholds) > 0
+}
+
+# Helper: Minutes until the end of the time window the current time is in.
+# Where windows overlap the one ending last counts.
+bedtime_minutes(restrictions, current_time) := max(ends) - now if {
+	now := (current_time.hour * 60) + current_time.minute
+	ends := {end |
+		some window in restrictions
+		within_time_window(window, current_time)
+		end := (window.end_hour * 60) + window.end_minute
+	}
+	count(ends) > 0
+}
+
+# Bandwidth limits: profiles may cap the rate of the responses they get
+#   "bandwidth_limits": {
+#       "evening": {"kbps": 10000, "days": [0, 1, 2, 3, 4, 5, 6],
+#                   "start_hour": 20, "start_minute": 0, "end_hour": 24, "end_minute": 0},
+#       "each-device": {"kbps": 4000, "per": "device"}
+#   }
+# A limit without "days" always applies; one with them applies within its
+# window, like time_restrictions. Allowed requests get "bandwidth_limits",
+# the limits in force, and Go shapes their responses with a token bucket per
+# limit ("bucket"): shared by all devices of the profile ("per": "profile",
+# the default) or one per device ("per": "device", devices counted as for
+# stream limits).
+bandwidth_limits := {"bandwidth_limits": limits} if {
+	limited_decision.action in {"ALLOW", "WARN"}
+	profile_id := device.identified_device.profile
+	profile := config.profiles[profile_id]
+	limits := [{"id": id, "kbps": limit.kbps, "bucket": bandwidth_bucket(profile_id, id, limit)} |
+		some id, limit in object.get(profile, "bandwidth_limits", {})
+		object.get(limit, "kbps", 0) > 0
+		bandwidth_limit_active(limit, input.time)
+	]
+	count(limits) > 0
+} else := {}
+
+# Helper: Whether a bandwidth limit applies now
+bandwidth_limit_active(limit, _) if object.get(limit, "days", null) == null
+
+bandwidth_limit_active(limit, current_time) if {
+	object.get(limit, "days", null) != null
+	within_time_window(limit, current_time)
+}
+
+# Helper: The token bucket of a bandwidth limit
+bandwidth_bucket(profile_id, id, limit) := sprintf("%s/%s/%s", [profile_id, id, stream_device]) if {
+	object.get(limit, "per", "profile") == "device"
+} else := sprintf("%s/%s", [profile_id, id])
+
+# Stream limits: profiles may cap how many devices stream media at once
+#   "stream_limit": {"max_devices": 1, "categories": ["streaming"], "idle_seconds": 60}
+# Allowed requests in the listed categories are streams. Go counts them per
+# device while their responses are in flight (input.streams), and a device
+# keeps its place for idle_seconds (default 60) after its last one ends, as
+# players fetch video in segments. A stream from a new device is blocked
+# while max_devices other devices are streaming; the devices already
+# streaming are not affected. Devices are counted by device ID, or by client
+# address for clients identified by user or subnet.
+limited_decision := paused_decision if {
+	paused_decision
+} else := object.union(moded_decision, {
+	"action": "BLOCK",
+	"reason": sprintf("stream limit reached: %d of %d devices already streaming (%s)", [count(others), limit.max_devices, concat(", ", sort(others))]),
+	"block_page": "stream_limit",
+	"inject_timer": false,
+	"time_remaining_minutes": 0,
+}) if {
+	limit := stream_limit
+	others := streaming_devices(limit) - {stream_device}
+	count(others) >= limit.max_devices
+} else := object.union(moded_decision, {"stream_device": stream_device}) if {
+	stream_limit
+} else := moded_decision
+
+# Helper: The decision for a paused device or profile
+paused_decision := {
+	"action": "BLOCK",
+	"reason": sprintf("%s %s paused", [device.pause.kind, device.pause.target]),
+	"block_page": "paused",
+	"matched_rule_id": "",
+	"category": "",
+	"inject_timer": false,
+	"time_remaining_minutes": 0,
+	"usage_limit_id": "",
+	"profile": device.identified_device.profile,
+} if {
+	device.pause
+	not helpers.match_domain(input.host, input.server_name)
+}
+
+# Helper: The profile's stream limit, if the moded decision allows a stream
+stream_limit := limit if {
+	moded_decision.action in {"ALLOW", "WARN"}
+	profile := config.profiles[device.identified_device.profile]
+	limit := object.get(profile, "stream_limit", null)
+	is_object(limit)
+	moded_decision.category != ""
+	moded_decision.category in object.get(limit, "categories", [])
+}
+
+# Helper: What streams are counted against (device ID, else client address)
+stream_device := device.device_id if {
+	device.device_id
+} else := input.client_ip
+
+# Helper: Devices of the profile streaming now or within the idle window
+streaming_devices(limit) := {id |
+	some id, stream in object.get(object.get(input, "streams", {}), device.identified_device.profile, {})
+	streaming(stream, object.get(limit, "idle_seconds", 60))
+}
+
+streaming(stream, _) if stream.active > 0
+
+streaming(stream, idle_seconds) if stream.idle_seconds < idle_seconds
+
+# Helper: The enforced decision, softened to WARN in warn mode
+moded_decision := enforced_decision if {
+	not warn_only
+}
+
+moded_decision := object.union(enforced_decision, {
+	"action": "WARN",
+	"reason": sprintf("warn only (would block): %s", [enforced_decision.reason]),
+	"block_page": "",
+}) if {
+	warn_only
+}
+
+# Search monitoring: when Go sends a search query (input.search, with
+# "engine" and "query"), profiles that opt in with
+#   "search_monitoring": {"log": true, "alert": true}
+# get "search_log" (Go logs the query terms) and "search_concerns", the
+# sorted names of the config.search_concern_lists with a pattern matching the
+# query (case-insensitive regular expressions). Other profiles get neither.
+search_monitoring := {
+	"search_log": object.get(settings, "log", false) == true,
+	"search_concerns": search_concerns(settings, query),
+} if {
+	query := input.search.query
+	profile := config.profiles[device.identified_device.profile]
+	settings := object.get(profile, "search_monitoring", null)
+	is_object(settings)
+} else := {}
+
+# Helper: Concern lists matching a query, if the profile asks for alerts
+search_concerns(settings, query) := sort({name |
+	some name, patterns in config.search_concern_lists
+	some pattern in patterns
+	regex.match(concat("", ["(?i)", pattern]), query)
+}) if {
+	object.get(settings, "alert", false) == true
+} else := []
+
+# Custom block pages: profiles may map categories to block page templates
+# uploaded through KProxy's admin API, with "default" for other blocks
+#   "block_templates": {"gaming": "game-over", "default": "family"}
+# BLOCK decisions for such a profile carry "block_template" naming the
+# template; Go shows its built-in block page if the template doesn't exist.
+block_template := {"block_template": name} if {
+	limited_decision.action == "BLOCK"
+	profile := config.profiles[device.identified_device.profile]
+	templates := object.get(profile, "block_templates", {})
+	name := object.get(templates, limited_decision.category, object.get(templates, "default", ""))
+	name != ""
+} else := {}
+
+# Helper: A BLOCK decision from a profile or rule in warn mode
+warn_only if {
+	enforced_decision.action == "BLOCK"
+	decision_mode == "warn"
+}
+
+# Helper: Mode of the rule (or profile) that produced the decision.
+# Unknown devices and misconfigured profiles are always enforced.
+decision_mode := object.get(rule, "mode", object.get(profile, "mode", "enforce")) if {
+	profile := config.profiles[device.identified_device.profile]
+	enforced_decision.matched_rule_id != ""
+	some rule in device.profile_rules(device.identified_device.profile, profile)
+	rule.id == enforced_decision.matched_rule_id
+} else := object.get(profile, "mode", "enforce") if {
+	profile := config.profiles[device.identified_device.profile]
+}
 
 # Decision 0: Always allow server name for client setup (regardless of device)
-decision := {
+enforced_decision := {
 	"action": "ALLOW",
 	"reason": "kproxy server name (client setup)",
 	"block_page": "",
@@ -42,7 +339,7 @@ decision := {
 }
 
 # Decision 1: Block unknown devices
-decision := {
+enforced_decision := {
 	"action": "BLOCK",
 	"reason": "unknown device",
 	"block_page": "unknown_device",
@@ -57,7 +354,7 @@ decision := {
 }
 
 # Decision 2: Block if profile not found (should not happen with proper config)
-decision := {
+enforced_decision := {
 	"action": "BLOCK",
 	"reason": "profile not configured",
 	"block_page": "config_error",
@@ -73,7 +370,7 @@ decision := {
 }
 
 # Decision 3: Block if outside allowed time window
-decision := {
+enforced_decision := {
 	"action": "BLOCK",
 	"reason": "outside allowed hours",
 	"block_page": "time_restriction",
@@ -82,6 +379,7 @@ decision := {
 	"inject_timer": false,
 	"time_remaining_minutes": 0,
 	"usage_limit_id": "",
+	"profile": dev.profile,
 } if {
 	not helpers.match_domain(input.host, input.server_name)
 	dev := device.identified_device
@@ -93,7 +391,7 @@ decision := {
 }
 
 # Decision 4: Evaluate rules (if time allowed and rule matches)
-decision := result if {
+enforced_decision := result if {
 	not helpers.match_domain(input.host, input.server_name)
 	dev := device.identified_device
 	profile := config.profiles[dev.profile]
@@ -102,14 +400,21 @@ decision := result if {
 	time_is_allowed(profile.time_restrictions, input.time)
 
 	# Find first matching rule
-	rule := first_matching_rule(profile.rules, input.host, input.path)
-
-	# Evaluate rule
-	result := evaluate_rule(rule, profile)
+	rule := first_matching_rule(device.profile_rules(dev.profile, profile), request_host, input.path)
+
+	# Evaluate rule and attach the request mutation script (if any) and bandwidth share
+	result := object.union(evaluate_rule(rule, profile), {
+		"script": rule_script(rule, profile),
+		"profile": dev.profile,
+		"bandwidth_weight": object.get(profile, "bandwidth_weight", 1),
+		"safesearch": object.get(profile, "safesearch", false) == true,
+		"youtube_restrict": youtube_restrict(profile),
+		"egress": rule_egress(rule, profile),
+	})
 }
 
 # Decision 5: Default action (no matching rules)
-decision := {
+enforced_decision := {
 	"action": action,
 	"reason": sprintf("default %s (no matching rules)", [lower(action)]),
 	"block_page": block_page,
@@ -118,6 +423,12 @@ decision := {
 	"inject_timer": false,
 	"time_remaining_minutes": 0,
 	"usage_limit_id": "",
+	"script": object.get(profile, "script", ""),
+	"profile": dev.profile,
+	"bandwidth_weight": object.get(profile, "bandwidth_weight", 1),
+	"safesearch": object.get(profile, "safesearch", false) == true,
+	"youtube_restrict": youtube_restrict(profile),
+	"egress": object.get(profile, "egress", ""),
 } if {
 	not helpers.match_domain(input.host, input.server_name)
 	dev := device.identified_device
@@ -127,13 +438,57 @@ decision := {
 	time_is_allowed(profile.time_restrictions, input.time)
 
 	# No matching rules
-	not first_matching_rule(profile.rules, input.host, input.path)
+	not first_matching_rule(device.profile_rules(dev.profile, profile), request_host, input.path)
+	not unclassified_direct_ip
 
 	# Use profile default action
 	action := upper(profile.default_action)
 	block_page := default_block_page(action)
 }
 
+# Decision 6: Direct-IP request with no server name to classify it by
+enforced_decision := {
+	"action": action,
+	"reason": sprintf("default %s for direct IP access (no server name)", [lower(action)]),
+	"block_page": block_page,
+	"matched_rule_id": "",
+	"category": "",
+	"inject_timer": false,
+	"time_remaining_minutes": 0,
+	"usage_limit_id": "",
+	"script": object.get(profile, "script", ""),
+	"profile": dev.profile,
+	"bandwidth_weight": object.get(profile, "bandwidth_weight", 1),
+	"safesearch": object.get(profile, "safesearch", false) == true,
+	"youtube_restrict": youtube_restrict(profile),
+	"egress": object.get(profile, "egress", ""),
+} if {
+	not helpers.match_domain(input.host, input.server_name)
+	dev := device.identified_device
+	profile := config.profiles[dev.profile]
+
+	# Time must be allowed (same check as Decision 4)
+	time_is_allowed(profile.time_restrictions, input.time)
+
+	# Rules can still name the address itself
+	not first_matching_rule(device.profile_rules(dev.profile, profile), request_host, input.path)
+	unclassified_direct_ip
+
+	action := upper(object.get(profile, "direct_ip_action", profile.default_action))
+	block_page := default_block_page(action)
+}
+
+# Helper: Host that rules are matched against (see above for direct-IP requests)
+request_host := input.server_names[0] if {
+	input.direct_ip == true
+} else := input.host
+
+# Helper: Direct-IP request with no server name found for the address
+unclassified_direct_ip if {
+	input.direct_ip == true
+	count(object.get(input, "server_names", [])) == 0
+}
+
 # Helper: Check if current time is within any allowed window
 within_allowed_time(restrictions, current_time) if {
 	some window_name, window in restrictions
@@ -180,8 +535,27 @@ matches_rule(rule, host, path) if {
 	# Check if path matches (if paths specified in rule)
 	# If rule.paths is null/missing, match_path returns true for any path
 	helpers.match_path(path, object.get(rule, "paths", null))
+
+	# Check query parameters and request headers (if specified in rule)
+	helpers.match_query(object.get(input, "query", {}), object.get(rule, "query", {}))
+	helpers.match_headers(object.get(input, "headers", {}), object.get(rule, "headers", {}))
 }
 
+# Helper: Script to run on allowed requests (a rule-level script overrides the profile's)
+rule_script(rule, profile) := object.get(rule, "script", object.get(profile, "script", ""))
+
+# Helper: Egress route for upstream connections. A "route" rule sends matching
+# requests by its "upstream"; otherwise a rule-level egress overrides the profile's.
+rule_egress(rule, profile) := rule.upstream if {
+	rule.action == "route"
+} else := object.get(rule, "egress", object.get(profile, "egress", ""))
+
+# Helper: YouTube Restricted Mode level ("moderate", "strict" or "" for none)
+youtube_restrict(profile) := level if {
+	level := lower(object.get(profile, "restricted_youtube", ""))
+	level in {"moderate", "strict"}
+} else := ""
+
 # Helper: Evaluate a matched rule
 evaluate_rule(rule, profile) := {
 	"action": "BLOCK",
@@ -193,7 +567,7 @@ evaluate_rule(rule, profile) := {
 	"time_remaining_minutes": 0,
 	"usage_limit_id": usage_category_id(rule.category),
 } if {
-	rule.action == "allow"
+	rule.action in {"allow", "route"}
 	rule.category != ""
 
 	# Check if usage limit exists and is exceeded
@@ -209,8 +583,10 @@ evaluate_rule(rule, profile) := {
 	"inject_timer": inject,
 	"time_remaining_minutes": remaining,
 	"usage_limit_id": limit_id,
+	"throttle_delay_ms": slow_down.delay_ms,
+	"throttle_kbps": slow_down.kbps,
 } if {
-	rule.action == "allow"
+	rule.action in {"allow", "route"}
 
 	# Not exceeded or no usage limit
 	not usage_limit_exceeded(profile, rule.category)
@@ -219,6 +595,7 @@ evaluate_rule(rule, profile) := {
 	inject := should_inject_timer(profile, rule.category)
 	remaining := remaining_time(profile, rule.category)
 	limit_id := usage_category_id(rule.category)
+	slow_down := throttle(profile, rule.category)
 }
 
 evaluate_rule(rule, profile) := {
@@ -246,7 +623,7 @@ usage_limit_exceeded(profile, category) if {
 should_inject_timer(profile, category) := inject if {
 	category != ""
 	limit := profile.usage_limits[category]
-	inject := limit.inject_timer
+	inject := object.get(limit, "inject_timer", false)
 }
 
 should_inject_timer(profile, category) := false if {
@@ -273,6 +650,21 @@ remaining_time(profile, category) := 0 if {
 	not profile.usage_limits[category]
 }
 
+# Helper: Graduated enforcement before a usage limit is reached. Once usage
+# passes the limit's throttle.start_percent (default 90), requests are delayed
+# by up to throttle.max_delay_ms, growing linearly until the limit, and
+# responses are capped at throttle.bandwidth_kbps (if set).
+throttle(profile, category) := {"delay_ms": delay, "kbps": object.get(slow_down, "bandwidth_kbps", 0)} if {
+	category != ""
+	limit := profile.usage_limits[category]
+	slow_down := limit.throttle
+	used := input.usage[category].today_minutes
+	start := (limit.daily_minutes * object.get(slow_down, "start_percent", 90)) / 100
+	used >= start
+	limit.daily_minutes > start
+	delay := round((object.get(slow_down, "max_delay_ms", 0) * (used - start)) / (limit.daily_minutes - start))
+} else := {"delay_ms": 0, "kbps": 0}
+
 # Helper: Get usage category ID for tracking
 usage_category_id(category) := category if {
 	category != ""
@@ -128,6 +128,7 @@ test_decision_outside_time_window if {
 	decision.action == "BLOCK"
 	decision.reason == "outside allowed hours"
 	decision.block_page == "time_restriction"
+	decision.profile == mock_device.profile
 }
 
 # Test 5: Inside time window, no matching rules, should use default action
@@ -573,3 +574,881 @@ test_decision_allow_server_name if {
 	decision3.action == "ALLOW"
 	decision3.reason == "kproxy server name (client setup)"
 }
+
+# Test: Request mutation scripts (rule-level overrides profile-level)
+test_decision_script if {
+	script_config := {
+		"devices": mock_config.devices,
+		"profiles": {"test-profile": {
+			"name": "Script Profile",
+			"rules": [
+				{
+					"id": "allow-github",
+					"domains": ["github.com"],
+					"action": "allow",
+					"category": "",
+				},
+				{
+					"id": "allow-example",
+					"domains": ["example.com"],
+					"action": "allow",
+					"category": "",
+					"script": "strip-tracking",
+				},
+			],
+			"time_restrictions": {},
+			"usage_limits": {},
+			"default_action": "allow",
+			"script": "add-headers",
+		}},
+	}
+
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	# Rule without a script inherits the profile script
+	decision1 := proxy.decision with data.kproxy.config as script_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "github.com"})
+	decision1.action == "ALLOW"
+	decision1.script == "add-headers"
+
+	# Rule-level script wins
+	decision2 := proxy.decision with data.kproxy.config as script_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "example.com"})
+	decision2.script == "strip-tracking"
+
+	# Default action uses the profile script
+	decision3 := proxy.decision with data.kproxy.config as script_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "other.com"})
+	decision3.action == "ALLOW"
+	decision3.script == "add-headers"
+
+	# Profiles without scripts produce an empty script
+	decision4 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "github.com"})
+	decision4.script == ""
+}
+
+# Test 16: Warn mode allows would-be blocks with action WARN
+test_decision_warn_mode if {
+	warn_config := {"profiles": {
+		"warn-profile": {
+			"name": "Warn Profile",
+			"mode": "warn",
+			"rules": [
+				{
+					"id": "block-youtube",
+					"domains": ["youtube.com"],
+					"action": "block",
+					"category": "entertainment",
+				},
+				{
+					"id": "block-games",
+					"domains": ["games.com"],
+					"action": "block",
+					"category": "games",
+					"mode": "enforce",
+				},
+			],
+			"time_restrictions": {},
+			"usage_limits": {},
+			"default_action": "block",
+		},
+		"test-profile": {
+			"name": "Test Profile",
+			"rules": [{
+				"id": "block-tiktok",
+				"domains": ["tiktok.com"],
+				"action": "block",
+				"category": "entertainment",
+				"mode": "warn",
+			}],
+			"time_restrictions": {},
+			"usage_limits": {},
+			"default_action": "block",
+		},
+	}}
+
+	warn_device := {"name": "Test Device", "profile": "warn-profile"}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	# Profile-level warn softens rule blocks
+	decision1 := proxy.decision with data.kproxy.config as warn_config
+		with data.kproxy.device.identified_device as warn_device
+		with input as object.union(base_input, {"host": "youtube.com"})
+	decision1.action == "WARN"
+	decision1.reason == "warn only (would block): matched block rule: block-youtube"
+	decision1.matched_rule_id == "block-youtube"
+	decision1.block_page == ""
+
+	# Profile-level warn softens the default action
+	decision2 := proxy.decision with data.kproxy.config as warn_config
+		with data.kproxy.device.identified_device as warn_device
+		with input as object.union(base_input, {"host": "example.com"})
+	decision2.action == "WARN"
+
+	# A rule in enforce mode overrides the profile
+	decision3 := proxy.decision with data.kproxy.config as warn_config
+		with data.kproxy.device.identified_device as warn_device
+		with input as object.union(base_input, {"host": "games.com"})
+	decision3.action == "BLOCK"
+
+	# Rule-level warn in an enforcing profile
+	decision4 := proxy.decision with data.kproxy.config as warn_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "tiktok.com"})
+	decision4.action == "WARN"
+
+	# Enforcing profile still blocks everything else
+	decision5 := proxy.decision with data.kproxy.config as warn_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "example.com"})
+	decision5.action == "BLOCK"
+}
+
+# Test 17: Unknown devices are blocked even if every profile is in warn mode
+test_decision_warn_mode_unknown_device if {
+	decision := proxy.decision with data.kproxy.config as {"profiles": {"p": {"mode": "warn"}}}
+		with input as {
+			"server_name": "local.kproxy",
+			"client_ip": "192.168.1.200",
+			"host": "github.com",
+			"path": "/",
+			"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+			"usage": {},
+		}
+
+	decision.action == "BLOCK"
+	decision.reason == "unknown device"
+}
+
+# Test 18: Requests are throttled progressively as a usage limit nears exhaustion
+test_decision_throttle_near_limit if {
+	throttle_config := {"profiles": {"limit-profile": {
+		"name": "Limit Test Profile",
+		"rules": [{
+			"id": "allow-youtube",
+			"domains": ["youtube.com"],
+			"action": "allow",
+			"category": "entertainment",
+		}],
+		"time_restrictions": {},
+		"usage_limits": {"entertainment": {
+			"daily_minutes": 100,
+			"inject_timer": true,
+			"throttle": {"start_percent": 80, "max_delay_ms": 2000, "bandwidth_kbps": 512},
+		}},
+		"default_action": "block",
+	}}}
+	limit_device := {"name": "Test Device", "profile": "limit-profile"}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "youtube.com",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+	}
+
+	# Below the threshold: no throttling
+	decision1 := proxy.decision with data.kproxy.config as throttle_config
+		with data.kproxy.device.identified_device as limit_device
+		with input as object.union(base_input, {"usage": {"entertainment": {"today_minutes": 50}}})
+	decision1.action == "ALLOW"
+	decision1.throttle_delay_ms == 0
+	decision1.throttle_kbps == 0
+
+	# Halfway between threshold and limit: half the maximum delay
+	decision2 := proxy.decision with data.kproxy.config as throttle_config
+		with data.kproxy.device.identified_device as limit_device
+		with input as object.union(base_input, {"usage": {"entertainment": {"today_minutes": 90}}})
+	decision2.action == "ALLOW"
+	decision2.throttle_delay_ms == 1000
+	decision2.throttle_kbps == 512
+
+	# Limits without throttle settings are unaffected
+	no_throttle := json.remove(throttle_config, ["profiles/limit-profile/usage_limits/entertainment/throttle"])
+	decision3 := proxy.decision with data.kproxy.config as no_throttle
+		with data.kproxy.device.identified_device as limit_device
+		with input as object.union(base_input, {"usage": {"entertainment": {"today_minutes": 90}}})
+	decision3.throttle_delay_ms == 0
+}
+
+# Test 19: Allowed decisions carry the profile and its bandwidth weight
+test_decision_bandwidth_weight if {
+	weighted_config := object.union(mock_config, {"profiles": {"test-profile": {"bandwidth_weight": 3}}})
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	# Matched rule
+	decision1 := proxy.decision with data.kproxy.config as weighted_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "github.com"})
+	decision1.profile == "test-profile"
+	decision1.bandwidth_weight == 3
+
+	# Default action, with the default weight
+	decision2 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as object.union(base_input, {"host": "example.com"})
+	decision2.profile == "unrestricted-profile"
+	decision2.bandwidth_weight == 1
+}
+
+# Test 20: Direct-IP requests are classified by server name, with a per-profile default
+test_decision_direct_ip if {
+	direct_config := object.union(mock_config, {"profiles": {"unrestricted-profile": {"direct_ip_action": "block"}}})
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "140.82.112.3",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+		"direct_ip": true,
+	}
+
+	# A server name for the address lets domain rules apply
+	decision1 := proxy.decision with data.kproxy.config as direct_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"server_names": ["api.github.com"]})
+	decision1.action == "ALLOW"
+	decision1.matched_rule_id == "allow-github"
+
+	# Unclassified direct-IP requests use the profile's direct_ip_action
+	decision2 := proxy.decision with data.kproxy.config as direct_config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as object.union(base_input, {"server_names": []})
+	decision2.action == "BLOCK"
+	decision2.reason == "default block for direct IP access (no server name)"
+
+	# Classified requests that match no rule still get the default action
+	decision3 := proxy.decision with data.kproxy.config as direct_config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as object.union(base_input, {"server_names": ["cdn.example.net"]})
+	decision3.action == "ALLOW"
+	decision3.reason == "default allow (no matching rules)"
+
+	# Without direct_ip_action, the profile's default action applies
+	decision4 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as object.union(base_input, {"server_names": []})
+	decision4.action == "ALLOW"
+	decision4.reason == "default allow for direct IP access (no server name)"
+}
+
+# Test 21: SafeSearch profiles flag their decisions
+test_decision_safesearch if {
+	safe_config := object.union(mock_config, {"profiles": {"unrestricted-profile": {"safesearch": true}}})
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "www.google.com",
+		"path": "/search",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	decision1 := proxy.decision with data.kproxy.config as safe_config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as base_input
+	decision1.action == "ALLOW"
+	decision1.safesearch == true
+
+	# Profiles without the toggle
+	decision2 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as base_input
+	decision2.safesearch == false
+
+	# Rule matches carry it too
+	decision3 := proxy.decision with data.kproxy.config as object.union(mock_config, {"profiles": {"test-profile": {"safesearch": true}}})
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "github.com", "path": "/"})
+	decision3.matched_rule_id == "allow-github"
+	decision3.safesearch == true
+}
+
+# Test 22: Restricted YouTube profiles carry their level
+test_decision_youtube_restrict if {
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "www.youtube.com",
+		"path": "/watch",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+	device := {"name": "Test Device", "profile": "unrestricted-profile"}
+
+	decision1 := proxy.decision with data.kproxy.config as object.union(mock_config, {"profiles": {"unrestricted-profile": {"restricted_youtube": "Moderate"}}})
+		with data.kproxy.device.identified_device as device
+		with input as base_input
+	decision1.action == "ALLOW"
+	decision1.youtube_restrict == "moderate"
+
+	decision2 := proxy.decision with data.kproxy.config as object.union(mock_config, {"profiles": {"unrestricted-profile": {"restricted_youtube": "strict"}}})
+		with data.kproxy.device.identified_device as device
+		with input as base_input
+	decision2.youtube_restrict == "strict"
+
+	# Unknown levels and profiles without the setting leave YouTube alone
+	decision3 := proxy.decision with data.kproxy.config as object.union(mock_config, {"profiles": {"unrestricted-profile": {"restricted_youtube": "loose"}}})
+		with data.kproxy.device.identified_device as device
+		with input as base_input
+	decision3.youtube_restrict == ""
+
+	decision4 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as device
+		with input as base_input
+	decision4.youtube_restrict == ""
+}
+
+# Test 23: Runtime rules are matched before the profile's rules
+test_decision_runtime_rules if {
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "github.com",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	# Configured allow rule
+	decision1 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as base_input
+	decision1.action == "ALLOW"
+
+	# Overridden by a runtime block rule for the profile
+	block_github := {"id": "runtime-1", "profile": "test-profile", "domains": ["github.com"], "action": "block", "category": ""}
+	decision2 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"runtime_rules": [block_github]})
+	decision2.action == "BLOCK"
+	decision2.matched_rule_id == "runtime-1"
+
+	# Runtime rules for other profiles are ignored
+	decision3 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"runtime_rules": [object.union(block_github, {"profile": "other"})]})
+	decision3.action == "ALLOW"
+	decision3.matched_rule_id == "allow-github"
+
+	# A runtime allow rule opens a domain the profile doesn't allow
+	decision4 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {
+			"host": "www.roblox.com",
+			"runtime_rules": [{"id": "runtime-2", "profile": "test-profile", "domains": [".roblox.com"], "action": "allow", "category": ""}],
+		})
+	decision4.action == "ALLOW"
+	decision4.matched_rule_id == "runtime-2"
+}
+
+# Test 24: Search monitoring is per-profile opt-in
+test_decision_search_monitoring if {
+	search_config := object.union(mock_config, {
+		"profiles": {"unrestricted-profile": {"search_monitoring": {"log": true, "alert": true}}},
+		"search_concern_lists": {
+			"self_harm": ["\\bsuicide\\b", "self[- ]?harm"],
+			"bullying": ["\\bloser\\b"],
+		},
+	})
+	device := {"name": "Test Device", "profile": "unrestricted-profile"}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "www.google.com",
+		"path": "/search",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+		"search": {"engine": "google", "query": "Self Harm help"},
+	}
+
+	decision1 := proxy.decision with data.kproxy.config as search_config
+		with data.kproxy.device.identified_device as device
+		with input as base_input
+	decision1.action == "ALLOW"
+	decision1.search_log == true
+	decision1.search_concerns == ["self_harm"]
+
+	# No match
+	decision2 := proxy.decision with data.kproxy.config as search_config
+		with data.kproxy.device.identified_device as device
+		with input as object.union(base_input, {"search": {"engine": "google", "query": "cat pictures"}})
+	decision2.search_log == true
+	decision2.search_concerns == []
+
+	# Logging only: no concern matching
+	log_only := object.union(search_config, {"profiles": {"unrestricted-profile": {"search_monitoring": {"log": true, "alert": false}}}})
+	decision3 := proxy.decision with data.kproxy.config as log_only
+		with data.kproxy.device.identified_device as device
+		with input as base_input
+	decision3.search_log == true
+	decision3.search_concerns == []
+
+	# Profiles that haven't opted in get neither
+	decision4 := proxy.decision with data.kproxy.config as object.union(mock_config, {"search_concern_lists": search_config.search_concern_lists})
+		with data.kproxy.device.identified_device as device
+		with input as base_input
+	not decision4.search_log
+	not decision4.search_concerns
+
+	# Nor do requests that aren't searches
+	decision5 := proxy.decision with data.kproxy.config as search_config
+		with data.kproxy.device.identified_device as device
+		with input as object.remove(base_input, ["search"])
+	not decision5.search_log
+}
+
+# Test 25: Profiles choose custom block page templates per category
+test_decision_block_template if {
+	templates_config := object.union(mock_config, {"profiles": {"test-profile": {"block_templates": {
+		"entertainment": "screen-time",
+		"default": "family",
+	}}}})
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "www.youtube.com",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	decision1 := proxy.decision with data.kproxy.config as templates_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as base_input
+	decision1.action == "BLOCK"
+	decision1.block_template == "screen-time"
+
+	# Blocks without a mapped category use the default
+	decision2 := proxy.decision with data.kproxy.config as templates_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "example.com"})
+	decision2.action == "BLOCK"
+	decision2.block_template == "family"
+
+	# Allowed requests and profiles without templates carry none
+	decision3 := proxy.decision with data.kproxy.config as templates_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "github.com"})
+	decision3.action == "ALLOW"
+	not decision3.block_template
+
+	decision4 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as base_input
+	decision4.action == "BLOCK"
+	not decision4.block_template
+}
+
+# Test 26: Device rules are matched before the device's profile rules
+test_decision_device_rules if {
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "github.com",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+	block_github := {"id": "device-rule-1", "device": "test-device", "domains": ["github.com"], "action": "block", "category": ""}
+
+	# Overrides the profile's allow rule for the device
+	decision1 := proxy.decision with data.kproxy.config as mock_config
+		with input as object.union(base_input, {"device_rules": [block_github]})
+	decision1.action == "BLOCK"
+	decision1.matched_rule_id == "device-rule-1"
+
+	# ...and the profile's runtime rules
+	decision2 := proxy.decision with data.kproxy.config as mock_config
+		with input as object.union(base_input, {
+			"device_rules": [block_github],
+			"runtime_rules": [{"id": "runtime-1", "profile": "test-profile", "domains": ["github.com"], "action": "allow", "category": ""}],
+		})
+	decision2.matched_rule_id == "device-rule-1"
+
+	# Rules for other devices are ignored
+	decision3 := proxy.decision with data.kproxy.config as mock_config
+		with input as object.union(base_input, {"device_rules": [object.union(block_github, {"device": "other-device"})]})
+	decision3.action == "ALLOW"
+	decision3.matched_rule_id == "allow-github"
+}
+
+# Test 27: Rules from a profile's rule sets are matched after its own rules
+test_decision_rule_sets if {
+	social := {"social-media": {
+		"name": "Social Media",
+		"profiles": ["test-profile"],
+		"rules": [
+			{"id": "block-github", "domains": ["github.com"], "action": "block", "category": "social"},
+			{"id": "block-tiktok", "domains": [".tiktok.com"], "action": "block", "category": "social"},
+		],
+	}}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "www.tiktok.com",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	decision1 := proxy.decision with data.kproxy.config as object.union(mock_config, {"rule_sets": social})
+		with data.kproxy.device.identified_device as mock_device
+		with input as base_input
+	decision1.action == "BLOCK"
+	decision1.matched_rule_id == "social-media/block-tiktok"
+	decision1.category == "social"
+
+	# The profile's own allow rule wins over the rule set
+	decision2 := proxy.decision with data.kproxy.config as object.union(mock_config, {"rule_sets": social})
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"host": "github.com"})
+	decision2.action == "ALLOW"
+	decision2.matched_rule_id == "allow-github"
+
+	# Rule sets saved through the admin API work the same way
+	decision3 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as mock_device
+		with input as object.union(base_input, {"rule_sets": social})
+	decision3.matched_rule_id == "social-media/block-tiktok"
+}
+
+# Test 28: Stream limits block streams from new devices while others stream
+test_decision_stream_limit if {
+	limited := {"stream_limit": {"max_devices": 1, "categories": ["work"], "idle_seconds": 60}}
+	config := object.union(mock_config, {"profiles": {"test-profile": object.union(mock_config.profiles["test-profile"], limited)}})
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"client_mac": "",
+		"host": "github.com",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	# Nothing streaming: allowed and counted as the device's stream
+	decision1 := proxy.decision with data.kproxy.config as config with input as base_input
+	decision1.action == "ALLOW"
+	decision1.stream_device == "test-device"
+
+	# Another device streaming: blocked with the devices in the reason
+	decision2 := proxy.decision with data.kproxy.config as config
+		with input as object.union(base_input, {"streams": {"test-profile": {"tv": {"active": 1, "idle_seconds": 0}}}})
+	decision2.action == "BLOCK"
+	decision2.block_page == "stream_limit"
+	decision2.reason == "stream limit reached: 1 of 1 devices already streaming (tv)"
+
+	# Between segments the other device keeps its place
+	decision3 := proxy.decision with data.kproxy.config as config
+		with input as object.union(base_input, {"streams": {"test-profile": {"tv": {"active": 0, "idle_seconds": 30}}}})
+	decision3.action == "BLOCK"
+
+	# Once it has been idle long enough, or only this device streams, it's allowed
+	decision4 := proxy.decision with data.kproxy.config as config
+		with input as object.union(base_input, {"streams": {"test-profile": {"tv": {"active": 0, "idle_seconds": 90}}}})
+	decision4.action == "ALLOW"
+	decision5 := proxy.decision with data.kproxy.config as config
+		with input as object.union(base_input, {"streams": {"test-profile": {"test-device": {"active": 2, "idle_seconds": 0}}}})
+	decision5.action == "ALLOW"
+
+	# Profiles without a stream limit have no streams
+	decision6 := proxy.decision with data.kproxy.config as mock_config with input as base_input
+	not decision6.stream_device
+}
+
+# Test 29: Rules can match query parameters and request headers
+test_decision_query_and_headers if {
+	rules := [
+		{
+			"id": "block-yt-search",
+			"domains": ["youtube.com"],
+			"paths": ["/results"],
+			"query": {"search_query": "*"},
+			"action": "block",
+			"category": "video",
+		},
+		{
+			"id": "allow-khan-videos",
+			"domains": ["youtube.com"],
+			"paths": ["/watch"],
+			"headers": {"Referer": ["https://www.youtube.com/@khanacademy*"]},
+			"action": "allow",
+			"category": "education",
+		},
+	]
+	config := object.union(mock_config, {"profiles": {"unrestricted-profile": object.union(
+		mock_config.profiles["unrestricted-profile"],
+		{"rules": rules, "default_action": "block"},
+	)}})
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "youtube.com",
+		"path": "/results",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	# A search is blocked by its query parameter
+	decision1 := proxy.decision with data.kproxy.config as config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as object.union(base_input, {"query": {"search_query": ["minecraft"]}})
+	decision1.matched_rule_id == "block-yt-search"
+
+	# Without the parameter the rule doesn't match
+	decision2 := proxy.decision with data.kproxy.config as config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as object.union(base_input, {"query": {}})
+	decision2.matched_rule_id == ""
+
+	# Videos opened from the channel are allowed, others fall to the default
+	watch := object.union(base_input, {"path": "/watch", "query": {"v": ["abc"]}})
+	decision3 := proxy.decision with data.kproxy.config as config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as object.union(watch, {"headers": {"referer": "https://www.youtube.com/@khanacademy/videos"}})
+	decision3.action == "ALLOW"
+	decision3.matched_rule_id == "allow-khan-videos"
+
+	decision4 := proxy.decision with data.kproxy.config as config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as object.union(watch, {"headers": {"referer": "https://www.youtube.com/@gamer"}})
+	decision4.action == "BLOCK"
+	decision4.matched_rule_id == ""
+}
+
+# Test 30: Bandwidth limits apply within their window, per profile or device
+test_decision_bandwidth_limits if {
+	limits := {"bandwidth_limits": {
+		"evening": {"kbps": 10000, "days": [2], "start_hour": 16, "start_minute": 0, "end_hour": 24, "end_minute": 0},
+		"each-device": {"kbps": 4000, "per": "device"},
+	}}
+	config := object.union(mock_config, {"profiles": {"test-profile": object.union(mock_config.profiles["test-profile"], limits)}})
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"client_mac": "",
+		"host": "github.com",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 16, "minute": 30},
+		"usage": {},
+	}
+
+	# In the evening both limits apply, the device's with its own bucket
+	decision1 := proxy.decision with data.kproxy.config as config with input as base_input
+	decision1.action == "ALLOW"
+	{limit.id: limit | some limit in decision1.bandwidth_limits} == {
+		"evening": {"id": "evening", "kbps": 10000, "bucket": "test-profile/evening"},
+		"each-device": {"id": "each-device", "kbps": 4000, "bucket": "test-profile/each-device/test-device"},
+	}
+
+	# Earlier in the day only the limit without a window applies
+	decision2 := proxy.decision with data.kproxy.config as config
+		with input as object.union(base_input, {"time": {"day_of_week": 2, "hour": 10, "minute": 0}})
+	[limit.id | some limit in decision2.bandwidth_limits] == ["each-device"]
+
+	# Blocked requests and profiles without limits have none
+	decision3 := proxy.decision with data.kproxy.config as config
+		with input as object.union(base_input, {"host": "youtube.com"})
+	decision3.action == "BLOCK"
+	not decision3.bandwidth_limits
+	decision4 := proxy.decision with data.kproxy.config as mock_config with input as base_input
+	not decision4.bandwidth_limits
+}
+
+# Test 31: Notices warn of bedtime and usage limits running out
+test_decision_notices if {
+	warnings := {
+		"warn_minutes": [15, 5],
+		"usage_limits": {"work": {"daily_minutes": 120}},
+		"default_action": "allow",
+	}
+	config := object.union(mock_config, {"profiles": {"test-profile": object.union(mock_config.profiles["test-profile"], warnings)}})
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"client_mac": "",
+		"host": "github.com",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 16, "minute": 50},
+		"usage": {"work": {"today_minutes": 116}},
+	}
+	bedtime := {"kind": "bedtime", "category": "", "minutes_remaining": 10, "threshold": 15}
+	work := {"kind": "usage_limit", "category": "work", "minutes_remaining": 4, "threshold": 5}
+
+	# The most urgent notice for the request's category comes with it
+	decision1 := proxy.decision with data.kproxy.config as config with input as base_input
+	decision1.action == "ALLOW"
+	decision1.notice == work
+
+	# The status lists every notice due
+	status := proxy.status with data.kproxy.config as config with input as base_input
+	status == {"profile": "test-profile", "notices": [work, bedtime]}
+
+	# Requests in other categories only hear about bedtime
+	decision2 := proxy.decision with data.kproxy.config as config
+		with input as object.union(base_input, {"host": "example.com"})
+	decision2.notice == bedtime
+
+	# Blocked requests, early in the day and profiles without warn_minutes get none
+	decision3 := proxy.decision with data.kproxy.config as config
+		with input as object.union(base_input, {"host": "youtube.com"})
+	not decision3.notice
+	early := proxy.status with data.kproxy.config as config
+		with input as object.union(base_input, {"time": {"day_of_week": 2, "hour": 10, "minute": 0}, "usage": {"work": {"today_minutes": 0}}})
+	early.notices == []
+	decision4 := proxy.decision with data.kproxy.config as mock_config with input as base_input
+	not decision4.notice
+}
+
+# Test 32: Paused devices and profiles are blocked ahead of their rules
+test_decision_paused if {
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"host": "github.com",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+	device_paused := {"paused": {"devices": {"test-device": {"until": "2026-03-01T20:00:00Z"}}, "profiles": {}}}
+
+	# The allow rule doesn't apply while the device is paused
+	decision1 := proxy.decision with data.kproxy.config as mock_config
+		with input as object.union(base_input, device_paused)
+	decision1.action == "BLOCK"
+	decision1.block_page == "paused"
+	decision1.reason == "device test-device paused"
+	decision1.profile == "test-profile"
+
+	# Pausing the profile blocks it too, even in warn mode
+	warn_config := json.patch(mock_config, [{"op": "add", "path": "/profiles/test-profile/mode", "value": "warn"}])
+	decision2 := proxy.decision with data.kproxy.config as warn_config
+		with input as object.union(base_input, {"paused": {"devices": {}, "profiles": {"test-profile": {"until": ""}}}})
+	decision2.action == "BLOCK"
+	decision2.reason == "profile test-profile paused"
+
+	# The server name stays reachable
+	decision3 := proxy.decision with data.kproxy.config as mock_config
+		with input as object.union(base_input, object.union(device_paused, {"host": "local.kproxy"}))
+	decision3.action == "ALLOW"
+
+	# Pauses of other devices are ignored
+	decision4 := proxy.decision with data.kproxy.config as mock_config
+		with input as object.union(base_input, {"paused": {"devices": {"other-device": {"until": ""}}, "profiles": {}}})
+	decision4.action == "ALLOW"
+	decision4.matched_rule_id == "allow-github"
+}
+
+# Test: Profiles and rules choose the egress route
+test_decision_egress if {
+	egress_config := {
+		"devices": {},
+		"profiles": {"egress-profile": {
+			"rules": [{
+				"id": "allow-streaming",
+				"domains": ["stream.example"],
+				"action": "allow",
+				"category": "",
+				"egress": "direct",
+			}],
+			"time_restrictions": {},
+			"usage_limits": {},
+			"default_action": "allow",
+			"egress": "vpn",
+		}},
+	}
+	device := {"name": "Test Device", "profile": "egress-profile"}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	# The profile's route applies by default
+	decision1 := proxy.decision with data.kproxy.config as egress_config
+		with data.kproxy.device.identified_device as device
+		with input as object.union(base_input, {"host": "news.example"})
+	decision1.action == "ALLOW"
+	decision1.egress == "vpn"
+
+	# A rule's route wins
+	decision2 := proxy.decision with data.kproxy.config as egress_config
+		with data.kproxy.device.identified_device as device
+		with input as object.union(base_input, {"host": "stream.example"})
+	decision2.egress == "direct"
+
+	# Profiles without one use the default route
+	decision3 := proxy.decision with data.kproxy.config as mock_config
+		with data.kproxy.device.identified_device as {"name": "Test Device", "profile": "unrestricted-profile"}
+		with input as object.union(base_input, {"host": "news.example"})
+	decision3.egress == ""
+}
+
+# Test: Route rules allow matching requests and send them by their upstream
+test_decision_route if {
+	route_config := {
+		"devices": {},
+		"profiles": {"route-profile": {
+			"rules": [{
+				"id": "route-streaming",
+				"domains": ["stream.example"],
+				"action": "route",
+				"upstream": "vpn",
+				"category": "",
+			}],
+			"time_restrictions": {},
+			"usage_limits": {},
+			"default_action": "allow",
+		}},
+	}
+	device := {"name": "Test Device", "profile": "route-profile"}
+	base_input := {
+		"server_name": "local.kproxy",
+		"client_ip": "192.168.1.100",
+		"path": "/",
+		"time": {"day_of_week": 2, "hour": 10, "minute": 0},
+		"usage": {},
+	}
+
+	# Matching requests are allowed and routed
+	decision1 := proxy.decision with data.kproxy.config as route_config
+		with data.kproxy.device.identified_device as device
+		with input as object.union(base_input, {"host": "stream.example"})
+	decision1.action == "ALLOW"
+	decision1.matched_rule_id == "route-streaming"
+	decision1.egress == "vpn"
+
+	# Everything else goes direct
+	decision2 := proxy.decision with data.kproxy.config as route_config
+		with data.kproxy.device.identified_device as device
+		with input as object.union(base_input, {"host": "news.example"})
+	decision2.action == "ALLOW"
+	decision2.egress == ""
+}
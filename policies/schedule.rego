@@ -0,0 +1,86 @@
+package kproxy.schedule
+
+import rego.v1
+
+import data.kproxy.config
+import data.kproxy.helpers
+
+# Weekly Schedule Projection
+# Projects a profile's time restrictions, rules and usage limits onto a
+# 7x24 grid of effective actions per category, for rendering a heatmap of
+# "what's allowed when". Usage is not considered: LIMITED means allowed until
+# the category's daily limit is reached.
+#
+# Input structure:
+# {
+#   "profile": "child"
+# }
+#
+# Cell values:
+#   ALLOW   - allowed for the whole hour
+#   LIMITED - allowed for the whole hour, subject to a daily usage limit
+#   PARTIAL - allowed for part of the hour only (time window starts or ends mid-hour)
+#   BLOCK   - blocked for the whole hour
+#
+# Uncategorized traffic (no matching rule) is reported as category "default".
+
+matrix := {
+	"profile": input.profile,
+	"categories": categories,
+	"schedule": {category: grid(profile, category) | some category in categories},
+} if {
+	profile := config.profiles[input.profile]
+}
+
+# Categories used by the profile's rules, plus "default"
+categories := sort(array.concat([c | some c in rule_categories], ["default"])) if {
+	profile := config.profiles[input.profile]
+	rule_categories := {rule.category |
+		some rule in helpers.configured_rules(input.profile, profile)
+		object.get(rule, "category", "") != ""
+		rule.category != "default"
+	}
+}
+
+# Helper: 7 days (0=Sunday) x 24 hours of cell values for one category
+grid(profile, category) := [[cell(profile, category, day, hour) |
+	some hour in numbers.range(0, 23)
+] |
+	some day in numbers.range(0, 6)
+]
+
+# Helper: Effective action for one hour
+cell(profile, category, day, hour) := "BLOCK" if {
+	category_action(profile, category) == "BLOCK"
+} else := "BLOCK" if {
+	allowed_minutes(profile.time_restrictions, day, hour) == 0
+} else := "PARTIAL" if {
+	allowed_minutes(profile.time_restrictions, day, hour) < 60
+} else := category_action(profile, category)
+
+# Helper: Action for a category when time allows, taken from the first rule
+# with that category (rules are evaluated in order)
+category_action(profile, category) := upper(profile.default_action) if {
+	category == "default"
+} else := "BLOCK" if {
+	first_rule(profile, category).action == "block"
+} else := "LIMITED" if {
+	profile.usage_limits[category]
+} else := "ALLOW"
+
+# Helper: First rule for a category
+first_rule(profile, category) := rules[0] if {
+	rules := [rule | some rule in helpers.configured_rules(input.profile, profile); object.get(rule, "category", "") == category]
+	count(rules) > 0
+}
+
+# Helper: Minutes within an hour covered by any allowed time window
+allowed_minutes(restrictions, day, hour) := 60 if {
+	count(restrictions) == 0
+} else := count({minute |
+	some minute in numbers.range(hour * 60, (hour * 60) + 59)
+	some window in restrictions
+	day in window.days
+	minute >= (window.start_hour * 60) + window.start_minute
+	minute < (window.end_hour * 60) + window.end_minute
+})
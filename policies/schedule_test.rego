@@ -0,0 +1,106 @@
+package kproxy.schedule_test
+
+import rego.v1
+
+import data.kproxy.schedule
+
+# Test profile: weekday afternoons only, gaming limited, social media blocked
+mock_config := {"profiles": {
+	"child": {
+		"rules": [
+			{"id": "games", "domains": ["*.roblox.com"], "action": "allow", "category": "gaming"},
+			{"id": "social", "domains": ["*.tiktok.com"], "action": "block", "category": "social-media"},
+			{"id": "school", "domains": ["*.khanacademy.org"], "action": "allow", "category": "educational"},
+		],
+		"time_restrictions": {"afternoon": {
+			"days": [1, 2, 3, 4, 5],
+			"start_hour": 15,
+			"start_minute": 30,
+			"end_hour": 19,
+			"end_minute": 0,
+		}},
+		"usage_limits": {"gaming": {"daily_minutes": 60, "inject_timer": true}},
+		"default_action": "block",
+	},
+	"adult": {
+		"rules": [],
+		"time_restrictions": {},
+		"usage_limits": {},
+		"default_action": "allow",
+	},
+}}
+
+# Test: Categories are the rule categories plus "default", sorted
+test_schedule_categories if {
+	m := schedule.matrix with data.kproxy.config as mock_config
+		with input as {"profile": "child"}
+
+	m.categories == ["default", "educational", "gaming", "social-media"]
+}
+
+# Test: Grid is 7 days by 24 hours
+test_schedule_dimensions if {
+	m := schedule.matrix with data.kproxy.config as mock_config
+		with input as {"profile": "child"}
+
+	count(m.schedule.educational) == 7
+	every day in m.schedule.educational {
+		count(day) == 24
+	}
+}
+
+# Test: Inside the window, category actions apply
+test_schedule_inside_window if {
+	m := schedule.matrix with data.kproxy.config as mock_config
+		with input as {"profile": "child"}
+
+	# Monday 16:00
+	m.schedule.educational[1][16] == "ALLOW"
+	m.schedule.gaming[1][16] == "LIMITED"
+	m.schedule["social-media"][1][16] == "BLOCK"
+	m.schedule["default"][1][16] == "BLOCK"
+}
+
+# Test: Window starting mid-hour is PARTIAL, outside the window is BLOCK
+test_schedule_window_edges if {
+	m := schedule.matrix with data.kproxy.config as mock_config
+		with input as {"profile": "child"}
+
+	m.schedule.educational[1][15] == "PARTIAL"
+	m.schedule.educational[1][18] == "ALLOW"
+	m.schedule.educational[1][19] == "BLOCK"
+	m.schedule.educational[0][16] == "BLOCK"
+}
+
+# Test: No time restrictions means the category action applies all week
+test_schedule_unrestricted if {
+	m := schedule.matrix with data.kproxy.config as mock_config
+		with input as {"profile": "adult"}
+
+	m.categories == ["default"]
+	every day in m.schedule["default"] {
+		every cell in day {
+			cell == "ALLOW"
+		}
+	}
+}
+
+# Test: Unknown profile has no schedule
+test_schedule_unknown_profile if {
+	not schedule.matrix with data.kproxy.config as mock_config
+		with input as {"profile": "missing"}
+}
+
+# Test: Categories and actions include the profile's rule sets
+test_schedule_rule_sets if {
+	config := object.union(mock_config, {
+		"profiles": {"adult": {"rule_sets": ["gambling"]}},
+		"rule_sets": {"gambling": {"rules": [{"id": "block-bets", "domains": [".bet365.com"], "action": "block", "category": "gambling"}]}},
+	})
+	m := schedule.matrix with data.kproxy.config as config
+		with input as {"profile": "adult"}
+
+	m.categories == ["default", "gambling"]
+	m.schedule.gambling[3][12] == "BLOCK"
+	m.schedule["default"][3][12] == "ALLOW"
+}
@@ -13,19 +13,11 @@ if [ ! -f /etc/kproxy/ca/root-ca.crt ] || [ ! -f /etc/kproxy/ca/root-ca.key ]; t
     echo "IMPORTANT: CA certificates not found!"
     echo "You must generate CA certificates before starting kproxy:"
     echo ""
-    echo "  1. Copy the generate-ca.sh script from the repository or"
-    echo "     create your own CA certificates"
+    echo "  1. Generate them at the paths in /etc/kproxy/config.yaml:"
+    echo "     sudo kproxy ca init"
     echo ""
-    echo "  2. Place the following files in /etc/kproxy/ca/:"
-    echo "     - root-ca.crt"
-    echo "     - root-ca.key"
-    echo "     - intermediate-ca.crt"
-    echo "     - intermediate-ca.key"
-    echo ""
-    echo "  3. Ensure proper permissions:"
+    echo "  2. Give them to the kproxy user:"
     echo "     sudo chown -R kproxy:kproxy /etc/kproxy/ca"
-    echo "     sudo chmod 600 /etc/kproxy/ca/*.key"
-    echo "     sudo chmod 644 /etc/kproxy/ca/*.crt"
     echo ""
 fi
 